@@ -0,0 +1,155 @@
+// Command benchgate compares `go test -bench` output against a stored baseline
+// and exits non-zero when any benchmark got slower by more than a threshold.
+//
+// Usage:
+//
+//	benchgate -baseline .bench/baseline.txt -threshold 20 bench_output.txt
+//
+// Benchmarks run with -count > 1 are reduced to their median ns/op, which
+// keeps a single noisy run from failing the gate.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// procSuffix matches the -GOMAXPROCS suffix go test appends to benchmark names
+var procSuffix = regexp.MustCompile(`-\d+$`)
+
+func main() {
+	baselinePath := flag.String("baseline", ".bench/baseline.txt", "baseline benchmark output")
+	threshold := flag.Float64("threshold", 20, "maximum allowed slowdown in percent")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: benchgate [-baseline file] [-threshold percent] <bench output>")
+		os.Exit(2)
+	}
+
+	baseline, err := parseFile(*baselinePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "benchgate: %v\n", err)
+		os.Exit(2)
+	}
+	current, err := parseFile(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "benchgate: %v\n", err)
+		os.Exit(2)
+	}
+	if len(current) == 0 {
+		fmt.Fprintf(os.Stderr, "benchgate: no benchmark results in %s\n", flag.Arg(0))
+		os.Exit(2)
+	}
+
+	names := make([]string, 0, len(current))
+	for name := range current {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "BENCHMARK\tBASELINE\tCURRENT\tDELTA\t")
+
+	var regressions int
+	for _, name := range names {
+		now := median(current[name])
+		old, ok := baseline[name]
+		if !ok {
+			fmt.Fprintf(w, "%s\t-\t%s\tnew\t\n", name, formatNs(now))
+			continue
+		}
+
+		before := median(old)
+		delta := (now - before) / before * 100
+		verdict := ""
+		if delta > *threshold {
+			verdict = "REGRESSION"
+			regressions++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%+.1f%%\t%s\n", name, formatNs(before), formatNs(now), delta, verdict)
+	}
+	w.Flush()
+
+	if regressions > 0 {
+		fmt.Fprintf(os.Stderr, "\n%d benchmark(s) regressed by more than %.0f%%\n", regressions, *threshold)
+		os.Exit(1)
+	}
+	fmt.Printf("\nNo regressions above %.0f%%\n", *threshold)
+}
+
+// parseFile reads ns/op results from go test -bench output, keyed by
+// package-qualified benchmark name
+func parseFile(path string) (map[string][]float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	results := make(map[string][]float64)
+	var pkg string
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "pkg:" {
+			pkg = fields[1]
+			continue
+		}
+		if len(fields) < 4 || !strings.HasPrefix(fields[0], "Benchmark") {
+			continue
+		}
+
+		// Name, iterations, then value/unit pairs
+		for i := 2; i+1 < len(fields); i += 2 {
+			if fields[i+1] != "ns/op" {
+				continue
+			}
+			ns, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				break
+			}
+			name := procSuffix.ReplaceAllString(fields[0], "")
+			if pkg != "" {
+				name = pkg[strings.LastIndex(pkg, "/")+1:] + "." + name
+			}
+			results[name] = append(results[name], ns)
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	return results, nil
+}
+
+// median returns the middle value of samples
+func median(samples []float64) float64 {
+	sorted := append([]float64(nil), samples...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// formatNs renders a duration in nanoseconds with a readable unit
+func formatNs(ns float64) string {
+	switch {
+	case ns >= 1e6:
+		return fmt.Sprintf("%.2fms", ns/1e6)
+	case ns >= 1e3:
+		return fmt.Sprintf("%.2fµs", ns/1e3)
+	default:
+		return fmt.Sprintf("%.1fns", ns)
+	}
+}
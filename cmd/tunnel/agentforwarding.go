@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/system"
+	"github.com/jedarden/tunnel/pkg/config"
+)
+
+// agentForwardingPolicy builds the agent forwarding policy from the SSH
+// settings
+func agentForwardingPolicy(cfg *config.Config) *core.AgentForwardingPolicy {
+	return &core.AgentForwardingPolicy{
+		Allow: cfg.SSH.AllowAgentForwarding,
+		Users: cfg.SSH.AgentForwardingUsers,
+	}
+}
+
+// watchAgentForwarding records an audit event for every SSH session that
+// forwards an agent, until ctx is done. sshd enforces the policy through
+// authorized_keys; a session the policy denies means a key bypassed it, so
+// it is also reported as a warning.
+func watchAgentForwarding(ctx context.Context, policy *core.AgentForwardingPolicy, interval time.Duration) {
+	if auditTrail == nil {
+		return
+	}
+
+	seen := make(map[string]bool)
+	check := func() {
+		sessions, err := system.AgentForwardedSessions()
+		if err != nil {
+			if verbose {
+				fmt.Fprintf(os.Stderr, "Warning: failed to list SSH sessions: %v\n", err)
+			}
+			return
+		}
+
+		current := make(map[string]bool, len(sessions))
+		for _, s := range sessions {
+			current[s.Socket] = true
+			if seen[s.Socket] {
+				continue
+			}
+			allowed := policy.Allows(s.User)
+			auditTrail.LogAgentForwarding(s.User, s.SourceIP, allowed, map[string]interface{}{
+				"pid":    s.PID,
+				"socket": s.Socket,
+			})
+			if !allowed {
+				fmt.Fprintf(os.Stderr, "Warning: %s forwarded an SSH agent from %s although agent forwarding is not allowed for them\n", s.User, s.SourceIP)
+			}
+		}
+		seen = current
+	}
+
+	check()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			check()
+		}
+	}
+}
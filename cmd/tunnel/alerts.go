@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/output"
+	"github.com/jedarden/tunnel/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+// alertInterval is how often the running instance evaluates alert rules
+const alertInterval = 30 * time.Second
+
+// alertEngine evaluates the configured alert rules while tunnel is running
+var alertEngine *core.AlertEngine
+
+var alertsCmd = &cobra.Command{
+	Use:   "alerts",
+	Short: "Manage alert rules",
+	Long: `Alert rules are defined under "alerts" in the config file and evaluated by the
+running instance. When a rule fires, a notification is sent through the same
+channel as connection notifications, honoring its quiet hours.
+
+  alerts:
+    - name: bastion-down
+      condition: unhealthy
+      target: bastion
+      for: 5m
+    - name: slow-link
+      condition: latency
+      threshold: 300ms
+      percentile: 95
+      for: 10m
+    - name: stale-keys
+      condition: key_age
+      threshold: 1y`,
+}
+
+var alertsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List configured alert rules",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return listAlertRules()
+	},
+}
+
+var alertsTestCmd = &cobra.Command{
+	Use:               "test <rule>",
+	Short:             "Send a test notification for an alert rule",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeAlertRules,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return testAlertRule(args[0])
+	},
+}
+
+func init() {
+	alertsCmd.AddCommand(alertsListCmd)
+	alertsCmd.AddCommand(alertsTestCmd)
+}
+
+// loadAlertRules parses the alert rules from the loaded config
+func loadAlertRules() ([]core.AlertRule, error) {
+	if appConfig == nil {
+		return nil, nil
+	}
+	rules, err := core.NewAlertRules(appConfig.Alerts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load alert rules: %w", err)
+	}
+	return rules, nil
+}
+
+// startAlerts evaluates the configured alert rules against the tunnel
+// manager, sending notifications through notifier
+func startAlerts(ctx context.Context, cfg *config.Config, source core.AlertSource, notifier *core.NotificationDispatcher) error {
+	if cfg == nil || len(cfg.Alerts) == 0 {
+		return nil
+	}
+
+	rules, err := core.NewAlertRules(cfg.Alerts)
+	if err != nil {
+		return err
+	}
+
+	// Avoid handing the engine typed nil interfaces
+	var keys core.KeyManager
+	if keyManager != nil {
+		keys = keyManager
+	}
+	var n core.Notifier
+	if notifier != nil {
+		n = notifier
+	}
+
+	alertEngine = core.NewAlertEngine(rules, source, keys, n)
+	go alertEngine.Run(ctx, alertInterval)
+	return nil
+}
+
+func listAlertRules() error {
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+
+	rules, err := loadAlertRules()
+	if err != nil {
+		return err
+	}
+
+	if format.Structured() {
+		list := make([]map[string]interface{}, 0, len(rules))
+		for _, r := range rules {
+			list = append(list, map[string]interface{}{
+				"name":        r.Name,
+				"condition":   r.Condition,
+				"target":      r.Target,
+				"description": r.Describe(),
+			})
+		}
+		return writeOutput(format, map[string]interface{}{"rules": list})
+	}
+
+	if len(rules) == 0 && format == output.FormatTable {
+		color.Yellow("No alert rules configured")
+		return nil
+	}
+
+	table := newTable("NAME", "CONDITION", "RULE")
+	for _, r := range rules {
+		table.AddRow(r.Name, string(r.Condition), r.Describe())
+	}
+	return renderTable(format, table)
+}
+
+func testAlertRule(name string) error {
+	rules, err := loadAlertRules()
+	if err != nil {
+		return err
+	}
+
+	// Test alerts go straight to the desktop so they are seen even during
+	// quiet hours
+	engine := core.NewAlertEngine(rules, nil, nil, core.NewDesktopNotifier())
+	alert, err := engine.Test(name)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		return printJSON(alert)
+	}
+	color.Green("✓ Sent test alert for rule %s", name)
+	fmt.Printf("  %s\n", alert.Message)
+	return nil
+}
+
+// completeAlertRules completes alert rule names from the config
+func completeAlertRules(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 || appConfig == nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	names := make([]string, 0, len(appConfig.Alerts))
+	for _, r := range appConfig.Alerts {
+		names = append(names, r.Name)
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
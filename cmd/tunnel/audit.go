@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/output"
+	"github.com/jedarden/tunnel/internal/units"
+	"github.com/jedarden/tunnel/internal/web/middleware"
+	"github.com/spf13/cobra"
+)
+
+// apiCallEvent is the audit event type of a mutating REST API call
+const apiCallEvent = "api_call"
+
+var (
+	auditSince  string
+	auditCaller string
+	auditFailed bool
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Inspect the audit log",
+}
+
+var auditAPICmd = &cobra.Command{
+	Use:   "api",
+	Short: "List REST API calls that changed something",
+	Long: `List the calls to the REST API that may have changed something - connects,
+disconnects, key changes, installs - as the running instance recorded them in
+the audit log: who made them, the endpoint and its parameters, and how they
+ended. Callers are API tokens by fingerprint, users a reverse proxy vouched
+for, or client addresses.
+
+Each caller is also rate limited, by api.rate_limit in the config: 600
+calls and 60 changes a minute by default. Calls over the limit get 429 and
+are recorded here too.`,
+	Example: `  tunnel audit api
+  tunnel audit api --since 1h --failed
+  tunnel audit api --caller token:3f2a9c1b04de --json`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return listAPICalls(auditSince, auditCaller, auditFailed)
+	},
+}
+
+func init() {
+	auditAPICmd.Flags().StringVar(&auditSince, "since", "24h", "how far back to list calls (e.g. 1h, 7d)")
+	auditAPICmd.Flags().StringVar(&auditCaller, "caller", "", "only list calls of this caller")
+	auditAPICmd.Flags().BoolVar(&auditFailed, "failed", false, "only list calls that failed or were refused")
+
+	auditCmd.AddCommand(auditAPICmd)
+}
+
+// recordAPICall writes a mutating API call to the audit log
+func recordAPICall(r middleware.AuditRecord) {
+	details := map[string]interface{}{
+		"http_method": r.Method,
+		"endpoint":    r.Endpoint,
+		"path":        r.Path,
+		"status":      r.Status,
+		"duration_ms": r.Duration.Milliseconds(),
+	}
+	if len(r.Params) > 0 {
+		details["params"] = r.Params
+	}
+	if r.Error != "" {
+		details["error"] = r.Error
+	}
+	if err := auditTrail.Log(core.AuditEvent{
+		EventType: apiCallEvent,
+		Method:    "api",
+		User:      r.Caller,
+		SourceIP:  r.SourceIP,
+		Success:   r.Status < 400,
+		Details:   details,
+	}); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to audit %s %s: %v\n", r.Method, r.Path, err)
+	}
+}
+
+// apiCall is a recorded API call as listed by `audit api`
+type apiCall struct {
+	Time     time.Time         `json:"time"`
+	Caller   string            `json:"caller"`
+	SourceIP string            `json:"source_ip"`
+	Method   string            `json:"method"`
+	Endpoint string            `json:"endpoint"`
+	Path     string            `json:"path"`
+	Params   map[string]string `json:"params,omitempty"`
+	Status   int               `json:"status"`
+	Error    string            `json:"error,omitempty"`
+}
+
+func listAPICalls(since, caller string, failed bool) error {
+	period, err := core.ParseAlertDuration(since)
+	if err != nil || period == 0 {
+		return fmt.Errorf("invalid --since %q: use a duration such as 24h or 7d", since)
+	}
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("failed to get home directory: %w", err)
+	}
+	events, err := core.ReadAuditLog(auditLogPath(homeDir), time.Now().Add(-period))
+	if err != nil {
+		return fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	calls := []apiCall{}
+	for _, event := range events {
+		if event.EventType != apiCallEvent || (caller != "" && event.User != caller) || (failed && event.Success) {
+			continue
+		}
+		calls = append(calls, apiCallFromEvent(event))
+	}
+
+	if format.Structured() {
+		return writeOutput(format, map[string]interface{}{"calls": calls})
+	}
+	if len(calls) == 0 && format == output.FormatTable {
+		color.Yellow("No API calls recorded since %s", units.Time(time.Now().Add(-period)))
+		return nil
+	}
+
+	table := newTable("TIME", "CALLER", "SOURCE", "CALL", "PARAMS", "RESULT")
+	for _, call := range calls {
+		result := strconv.Itoa(call.Status)
+		if call.Error != "" {
+			result += " " + call.Error
+		}
+		table.AddRow(
+			units.TimeExact(call.Time),
+			call.Caller,
+			call.SourceIP,
+			call.Method+" "+call.Path,
+			formatParams(call.Params),
+			result,
+		)
+	}
+	return renderTable(format, table)
+}
+
+// apiCallFromEvent reads an api_call audit event. Numbers come back from
+// the JSON log as float64.
+func apiCallFromEvent(event core.AuditEvent) apiCall {
+	call := apiCall{Time: event.Timestamp, Caller: event.User, SourceIP: event.SourceIP}
+	call.Method, _ = event.Details["http_method"].(string)
+	call.Endpoint, _ = event.Details["endpoint"].(string)
+	call.Path, _ = event.Details["path"].(string)
+	call.Error, _ = event.Details["error"].(string)
+	if status, ok := event.Details["status"].(float64); ok {
+		call.Status = int(status)
+	}
+	if params, ok := event.Details["params"].(map[string]interface{}); ok {
+		call.Params = make(map[string]string, len(params))
+		for k, v := range params {
+			call.Params[k] = fmt.Sprint(v)
+		}
+	}
+	return call
+}
+
+// formatParams lists parameters as k=v, sorted by name
+func formatParams(params map[string]string) string {
+	if len(params) == 0 {
+		return "-"
+	}
+	pairs := make([]string, 0, len(params))
+	for k, v := range params {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/output"
+	"github.com/jedarden/tunnel/internal/units"
+	"github.com/spf13/cobra"
+)
+
+var (
+	auditLogUser     string
+	auditLogType     string
+	auditLogSince    string
+	auditLogUntil    string
+	auditExportSince string
+	auditLogFailed   bool
+	auditLogFormat   string
+	auditLogFile     string
+	auditVerifyFile  string
+)
+
+var auditListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List audit log events",
+	Long: `List the events in the audit log: connections, key changes, rotations, API
+calls and the rest, narrowed down by user, event type and time range.
+
+--since and --until take a duration back from now (30m, 24h, 7d), a date
+(2006-01-02) or a time (2006-01-02T15:04:05Z). --type takes an event type
+or a pattern such as 'identity_key_*'.`,
+	Example: `  tunnel audit list
+  tunnel audit list --user alice --since 7d
+  tunnel audit list --type 'key_*' --since 2026-03-01 --until 2026-04-01 --json`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return listAuditEvents("", auditLogSince)
+	},
+}
+
+var auditSearchCmd = &cobra.Command{
+	Use:   "search <text>",
+	Short: "Find audit log events mentioning some text",
+	Long: `List the audit log events with the text in any field - type, user, source
+address - or in their details, such as a key fingerprint, a connection ID or
+an error. Case is ignored. Takes the same filters as audit list.`,
+	Example: `  tunnel audit search SHA256:3f2a9c1b
+  tunnel audit search "permission denied" --since 30d --failed`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return listAuditEvents(args[0], auditLogSince)
+	},
+}
+
+var auditExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export audit log events for a compliance review",
+	Long: `Write the audit log events, all of them or those the filters select, as CSV
+(one event a row, its details as a JSON object) or as JSON, to a file or to
+stdout.`,
+	Example: `  tunnel audit export --file audit-q1.csv --since 2026-01-01 --until 2026-04-01
+  tunnel audit export --format json --type 'identity_key_*' > rotations.json`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return exportAuditEvents(auditLogFormat, auditLogFile, auditExportSince)
+	},
+}
+
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check the audit log for tampering",
+	Long: `Check the hash chain of the audit log. Each entry records the hash of the
+one before it, so an entry changed, removed, added or moved after it was
+written breaks the chain there. With monitoring.audit_key_file set the
+hashes are HMACs under that key, and the log must be verified with it.
+
+Entries written before the log was chained are counted but can't be checked.
+Entries cut off the end of the log leave the chain intact: note down the
+head hash printed here and compare it with later runs.
+
+Exits non-zero when the chain is broken.`,
+	Example: `  tunnel audit verify
+  tunnel audit verify --file /var/log/tunnel/audit.log.20260301-120000`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return verifyAuditLog(auditVerifyFile)
+	},
+	SilenceUsage: true,
+}
+
+func init() {
+	auditListCmd.Flags().StringVar(&auditLogSince, "since", "24h", "start of the time range: a duration back, a date or a time")
+	auditSearchCmd.Flags().StringVar(&auditLogSince, "since", "24h", "start of the time range: a duration back, a date or a time")
+	auditExportCmd.Flags().StringVar(&auditExportSince, "since", "", "start of the time range: a duration back, a date or a time (default all)")
+	for _, cmd := range []*cobra.Command{auditListCmd, auditSearchCmd, auditExportCmd} {
+		cmd.Flags().StringVar(&auditLogUser, "user", "", "only events of this user")
+		cmd.Flags().StringVar(&auditLogType, "type", "", "only events of this type or type pattern")
+		cmd.Flags().StringVar(&auditLogUntil, "until", "", "end of the time range, as for --since (default now)")
+		cmd.Flags().BoolVar(&auditLogFailed, "failed", false, "only events that failed")
+	}
+	auditExportCmd.Flags().StringVar(&auditLogFormat, "format", "csv", "export format: csv or json")
+	auditExportCmd.Flags().StringVarP(&auditLogFile, "file", "f", "", "write to this file instead of stdout")
+
+	auditCmd.AddCommand(auditListCmd)
+	auditCmd.AddCommand(auditSearchCmd)
+	auditCmd.AddCommand(auditExportCmd)
+
+	auditVerifyCmd.Flags().StringVarP(&auditVerifyFile, "file", "f", "", "audit log to verify, e.g. a rotated one (default the configured log)")
+	auditCmd.AddCommand(auditVerifyCmd)
+}
+
+// parseAuditTime reads a --since or --until value: a duration back from
+// now, a date or a time. Empty is the zero time.
+func parseAuditTime(flag, value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	if d, err := core.ParseAlertDuration(value); err == nil && d > 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --%s %q: use a duration such as 24h or 7d, a date or an RFC 3339 time", flag, value)
+}
+
+// queryAuditEvents reads the audit log events the filter flags select
+// from sinceFlag on
+func queryAuditEvents(text, sinceFlag string) ([]core.AuditEvent, error) {
+	now := time.Now()
+	since, err := parseAuditTime("since", sinceFlag, now)
+	if err != nil {
+		return nil, err
+	}
+	until, err := parseAuditTime("until", auditLogUntil, now)
+	if err != nil {
+		return nil, err
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get home directory: %w", err)
+	}
+	events, err := core.QueryAuditLog(auditLogPath(homeDir), core.AuditQuery{
+		User:      auditLogUser,
+		EventType: auditLogType,
+		Since:     since,
+		Until:     until,
+		Text:      text,
+		Failed:    auditLogFailed,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	return events, nil
+}
+
+func listAuditEvents(text, since string) error {
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+	events, err := queryAuditEvents(text, since)
+	if err != nil {
+		return err
+	}
+
+	if format.Structured() {
+		return writeOutput(format, map[string]interface{}{"events": events})
+	}
+	if len(events) == 0 && format == output.FormatTable {
+		color.Yellow("No audit events match")
+		return nil
+	}
+
+	table := newTable("TIME", "TYPE", "USER", "METHOD", "SOURCE", "RESULT", "DETAILS")
+	for _, event := range events {
+		result := "ok"
+		if !event.Success {
+			result = "failed"
+		}
+		table.AddRow(
+			units.TimeExact(event.Timestamp),
+			event.EventType,
+			orDash(event.User),
+			orDash(event.Method),
+			orDash(event.SourceIP),
+			result,
+			orDash(core.FormatAuditDetails(event.Details)),
+		)
+	}
+	return renderTable(format, table)
+}
+
+func exportAuditEvents(format, file, since string) error {
+	if format != "csv" && format != "json" {
+		return fmt.Errorf("invalid --format %q: use csv or json", format)
+	}
+	events, err := queryAuditEvents("", since)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if file != "" {
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", file, err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if format == "csv" {
+		err = core.WriteAuditCSV(w, events)
+	} else {
+		err = output.Write(w, output.FormatJSON, map[string]interface{}{"events": events})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to export audit log: %w", err)
+	}
+	if file != "" {
+		color.Green("✓ Exported %d audit event(s) to %s", len(events), file)
+	}
+	return nil
+}
+
+func verifyAuditLog(path string) error {
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return fmt.Errorf("failed to get home directory: %w", err)
+		}
+		path = auditLogPath(homeDir)
+	}
+
+	v, err := core.VerifyAuditLog(path)
+	if err != nil {
+		return fmt.Errorf("failed to verify audit log: %w", err)
+	}
+
+	if format.Structured() {
+		if err := writeOutput(format, map[string]interface{}{"file": path, "intact": v.Intact(), "verification": v}); err != nil {
+			return err
+		}
+	} else {
+		hashes := "SHA-256"
+		if v.Keyed {
+			hashes = "HMAC-SHA256"
+		}
+		fmt.Printf("File:      %s\n", path)
+		fmt.Printf("Entries:   %d (%d chained, %d from before chaining)\n", v.Entries, v.Chained, v.Unchained)
+		fmt.Printf("Hashes:    %s\n", hashes)
+		fmt.Printf("Head:      %s\n\n", orDash(v.Head))
+
+		if v.Intact() {
+			color.Green("✓ Audit log chain is intact")
+			return nil
+		}
+		color.Red("✗ Audit log chain is broken: %d problem(s)", len(v.Problems))
+		table := newTable("LINE", "TIME", "TYPE", "PROBLEM")
+		for _, p := range v.Problems {
+			when := "-"
+			if !p.Timestamp.IsZero() {
+				when = units.TimeExact(p.Timestamp)
+			}
+			table.AddRow(strconv.Itoa(p.Line), when, orDash(p.EventType), p.Problem)
+		}
+		if err := renderTable(format, table); err != nil {
+			return err
+		}
+	}
+
+	if !v.Intact() {
+		return fmt.Errorf("audit log %s has been tampered with", path)
+	}
+	return nil
+}
+
+// orDash shows an empty table cell as "-"
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var keysAuthorizedKeysCommandCmd = &cobra.Command{
+	Use:   "authorized-keys-command <user>",
+	Short: "Print a user's active keys for sshd's AuthorizedKeysCommand",
+	Long: `Print the active, unexpired keys for a user in authorized_keys format, so a
+system sshd can authenticate against TUNNEL-managed keys directly.
+
+Only the keys recorded as the user's own are printed. Owners are recorded
+by the sqlite and ldap key stores, and by the metadata sidecar next to
+authorized_keys for keys added with tunnel; where none are recorded nothing
+is printed, rather than letting any key log in as any account.
+
+Add to /etc/ssh/sshd_config:
+
+  AuthorizedKeysCommand /usr/local/bin/tunnel --config /etc/tunnel/config.yaml keys authorized-keys-command %u
+  AuthorizedKeysCommandUser tunnel
+
+The command user must be able to read the config file and the key store.`,
+	Example: `  tunnel keys authorized-keys-command alice`,
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return printAuthorizedKeys(args[0])
+	},
+}
+
+func init() {
+	keysCmd.AddCommand(keysAuthorizedKeysCommandCmd)
+}
+
+// printAuthorizedKeys writes the user's usable keys to stdout, one
+// authorized_keys line each, with options such as expiry-time intact
+func printAuthorizedKeys(user string) error {
+	if keyManager == nil {
+		return fmt.Errorf("key manager not initialized")
+	}
+
+	if !keyManager.RecordsOwners() {
+		fmt.Fprintln(os.Stderr, "Warning: key owners are not recorded; no keys printed")
+		return nil
+	}
+	keys, err := keyManager.AuthorizedKeysFor(user, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to list keys: %w", err)
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	for _, key := range keys {
+		fmt.Fprintln(w, key.PublicKey)
+	}
+	return w.Flush()
+}
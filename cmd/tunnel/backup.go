@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/backup"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/state"
+	"github.com/jedarden/tunnel/pkg/config"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	backupComponents     []string
+	backupPassphraseFile string
+	restoreOnly          []string
+	restoreDryRun        bool
+)
+
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Back up and restore TUNNEL state",
+	Long: `Back up and restore TUNNEL's state as a single encrypted archive.
+
+Archives hold the config file, instance state (state.json, with provider
+instance definitions), keys (authorized_keys with its metadata, the key
+database and the certificate authority key), shares and pending access
+requests, the file credential store and TOTP enrollments, and the audit log. They are encrypted with AES-256-GCM using a key derived from a
+passphrase, read from --passphrase-file, TUNNEL_BACKUP_PASSPHRASE or a prompt.
+
+Metrics are kept in memory and are not part of a backup.`,
+}
+
+var backupCreateCmd = &cobra.Command{
+	Use:   "create [file]",
+	Short: "Write an encrypted backup",
+	Example: `  tunnel backup create
+  tunnel backup create /mnt/backups/tunnel.bak --components config,keys`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file := fmt.Sprintf("tunnel-%s.bak", time.Now().Format("20060102-150405"))
+		if len(args) > 0 {
+			file = args[0]
+		}
+		return createBackup(file, backupComponents)
+	},
+}
+
+var backupRestoreCmd = &cobra.Command{
+	Use:   "restore <file>",
+	Short: "Restore state from a backup",
+	Long: `Restore state from an encrypted backup.
+
+Every file is checked against its recorded checksum, the config is parsed and
+validated and authorized_keys entries are parsed before anything is written.
+Nothing is restored if any check fails. Files are written where this host's
+config keeps them, never to paths named in the archive. Replaced files are kept next to the
+original with a .pre-restore suffix.
+
+Stop any running tunnel instance before restoring.`,
+	Example: `  tunnel backup restore tunnel.bak --dry-run
+  tunnel backup restore tunnel.bak --only keys,shares`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return restoreBackup(args[0], restoreOnly, restoreDryRun)
+	},
+}
+
+func init() {
+	backupCmd.PersistentFlags().StringVar(&backupPassphraseFile, "passphrase-file", "", "file containing the backup passphrase")
+	backupCreateCmd.Flags().StringSliceVar(&backupComponents, "components", nil,
+		"components to back up: "+strings.Join(backup.Components, ", ")+" (default all)")
+	backupRestoreCmd.Flags().StringSliceVar(&restoreOnly, "only", nil, "restore only these components")
+	backupRestoreCmd.Flags().BoolVar(&restoreDryRun, "dry-run", false, "validate the backup and show what would be restored")
+
+	backupCmd.AddCommand(backupCreateCmd)
+	backupCmd.AddCommand(backupRestoreCmd)
+}
+
+// backupLocations returns every state file or directory tunnel backs up,
+// each under a root named after what it is, and where those roots are on
+// this host. A restore writes only within these roots.
+func backupLocations() ([]backup.Source, backup.Roots, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get home directory: %w", err)
+	}
+	configDir := filepath.Join(homeDir, ".config", "tunnel")
+
+	authorizedKeys := filepath.Join(homeDir, ".ssh", "authorized_keys")
+	if configured := appConfig.SSH.AuthorizedKeys; configured != "" {
+		authorizedKeys = expandHome(configured, homeDir)
+	}
+	credentialsDir := filepath.Join(configDir, "credentials")
+	if appConfig.Credentials.BaseDir != "" {
+		credentialsDir = expandHome(appConfig.Credentials.BaseDir, homeDir)
+	}
+	statePath, err := state.DefaultPath()
+	if err != nil {
+		return nil, nil, err
+	}
+	ca, err := certAuthority()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	all := []backup.Source{
+		{Component: backup.ComponentConfig, Root: "config", Path: appConfig.Path()},
+		{Component: backup.ComponentState, Root: "state", Path: statePath},
+		{Component: backup.ComponentKeys, Root: "authorized_keys", Path: authorizedKeys},
+		{Component: backup.ComponentKeys, Root: "key_metadata", Path: core.KeyMetadataPath(authorizedKeys)},
+		{Component: backup.ComponentKeys, Root: "ca_key", Path: ca.KeyPath()},
+		{Component: backup.ComponentKeys, Root: "ca_public_key", Path: ca.KeyPath() + ".pub"},
+		{Component: backup.ComponentShares, Root: "shares", Path: filepath.Join(configDir, "shares.json")},
+		{Component: backup.ComponentShares, Root: "requests", Path: filepath.Join(configDir, "requests")},
+		{Component: backup.ComponentCredentials, Root: "credentials", Path: credentialsDir},
+		{Component: backup.ComponentCredentials, Root: "totp", Path: totpStorePath(homeDir)},
+		{Component: backup.ComponentAudit, Root: "audit", Path: auditLogPath(homeDir)},
+	}
+	if appConfig.SSH.KeyStore == "sqlite" && appConfig.SSH.KeyDatabase != "" {
+		all = append(all, backup.Source{Component: backup.ComponentKeys, Root: "key_database", Path: expandHome(appConfig.SSH.KeyDatabase, homeDir)})
+	}
+
+	roots := make(backup.Roots, len(all))
+	for _, src := range all {
+		roots[src.Root] = src.Path
+	}
+	return all, roots, nil
+}
+
+// backupSources returns the state files for each requested component
+func backupSources(components []string) ([]backup.Source, error) {
+	all, _, err := backupLocations()
+	if err != nil {
+		return nil, err
+	}
+	if len(components) == 0 {
+		return all, nil
+	}
+
+	var sources []backup.Source
+	for _, c := range components {
+		known := false
+		for _, src := range all {
+			if src.Component == c {
+				sources = append(sources, src)
+				known = true
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown component %q (valid: %s)", c, strings.Join(backup.Components, ", "))
+		}
+	}
+	return sources, nil
+}
+
+// backupPassphrase reads the passphrase from --passphrase-file,
+// TUNNEL_BACKUP_PASSPHRASE or stdin, in that order
+func backupPassphrase() (string, error) {
+	var passphrase string
+	switch {
+	case backupPassphraseFile != "":
+		data, err := os.ReadFile(backupPassphraseFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read passphrase file: %w", err)
+		}
+		passphrase = string(data)
+	case os.Getenv("TUNNEL_BACKUP_PASSPHRASE") != "":
+		passphrase = os.Getenv("TUNNEL_BACKUP_PASSPHRASE")
+	default:
+		reader := bufio.NewReader(os.Stdin)
+		fmt.Print("Backup passphrase: ")
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("failed to read passphrase: %w", err)
+		}
+		passphrase = line
+	}
+
+	passphrase = strings.TrimRight(passphrase, "\r\n")
+	if passphrase == "" {
+		return "", fmt.Errorf("passphrase cannot be empty")
+	}
+	return passphrase, nil
+}
+
+func createBackup(file string, components []string) error {
+	sources, err := backupSources(components)
+	if err != nil {
+		return err
+	}
+	passphrase, err := backupPassphrase()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create backup file: %w", err)
+	}
+
+	manifest, err := backup.Create(f, sources, passphrase)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file)
+		return fmt.Errorf("failed to create backup: %w", err)
+	}
+
+	if jsonOutput {
+		return printJSON(manifest)
+	}
+	color.Green("✓ Backed up %d files (%s) to %s", len(manifest.Files), strings.Join(manifest.Components(), ", "), file)
+	return nil
+}
+
+func restoreBackup(file string, components []string, dryRun bool) error {
+	passphrase, err := backupPassphrase()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	archive, err := backup.Open(f, passphrase)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read backup: %w", err)
+	}
+
+	files, err := archive.Select(components)
+	if err != nil {
+		return err
+	}
+	if err := archive.Validate(files, backupValidators); err != nil {
+		return err
+	}
+
+	// Files go where this host keeps them now, never to paths the
+	// archive names
+	_, roots, err := backupLocations()
+	if err != nil {
+		return err
+	}
+	dests := make([]string, len(files))
+	for i, file := range files {
+		if dests[i], err = archive.Destination(file, roots); err != nil {
+			return err
+		}
+	}
+
+	if jsonOutput && dryRun {
+		return printJSON(files)
+	}
+
+	fmt.Printf("Backup from %s, created %s\n", archive.Manifest.Hostname, archive.Manifest.CreatedAt.Local().Format(time.RFC1123))
+	for i, file := range files {
+		fmt.Printf("  %-12s %s (%d bytes)\n", file.Component, dests[i], file.Size)
+	}
+
+	if dryRun {
+		color.Green("✓ Backup is valid; %d files would be restored", len(files))
+		return nil
+	}
+
+	if err := archive.Restore(files, roots); err != nil {
+		return fmt.Errorf("failed to restore backup: %w", err)
+	}
+	color.Green("✓ Restored %d files", len(files))
+	color.Yellow("Previous versions were saved with a .pre-restore suffix")
+	return nil
+}
+
+// backupValidators check restored state parses before it replaces live files
+var backupValidators = map[string]backup.Validator{
+	backup.ComponentConfig: func(f backup.File, data []byte) error {
+		_, err := config.Parse(data)
+		return err
+	},
+	backup.ComponentState: func(f backup.File, data []byte) error {
+		if !json.Valid(data) {
+			return errors.New("invalid JSON")
+		}
+		return nil
+	},
+	backup.ComponentKeys: func(f backup.File, data []byte) error {
+		if f.Root == "ca_key" {
+			_, err := ssh.ParseRawPrivateKey(data)
+			return err
+		}
+		switch path.Ext(f.Name) {
+		case ".db":
+			return nil
+		case ".json":
+			if !json.Valid(data) {
+				return errors.New("invalid JSON")
+			}
+			return nil
+		}
+		for i, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if _, err := core.ParseSSHPublicKey(line); err != nil {
+				return fmt.Errorf("line %d: %w", i+1, err)
+			}
+		}
+		return nil
+	},
+	backup.ComponentShares: func(f backup.File, data []byte) error {
+		if path.Ext(f.Name) == ".json" && !json.Valid(data) {
+			return errors.New("invalid JSON")
+		}
+		return nil
+	},
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/units"
+	"github.com/jedarden/tunnel/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	benchmarkSamples  int
+	benchmarkInterval time.Duration
+	benchmarkApply    bool
+)
+
+var benchmarkCmd = &cobra.Command{
+	Use:   "benchmark",
+	Short: "Measure and compare tunnel performance",
+}
+
+var benchmarkProvidersCmd = &cobra.Command{
+	Use:   "providers [method...]",
+	Short: "Rank providers by connect time and latency",
+	Long: `Bring up each provider in turn, time how long it takes to connect, measure
+its latency for a few seconds once up, take it down again and rank the
+providers by latency.
+
+Without methods, the enabled methods of the config are compared. A provider
+that is already connected is measured without being taken down, and shows no
+connect time. With --apply, the failover priorities of the methods are set
+from the ranking, fastest first, and the ones that failed go last.`,
+	Example: `  tunnel benchmark providers
+  tunnel benchmark providers bore ngrok tailscale --samples 10
+  tunnel benchmark providers --apply`,
+	ValidArgsFunction: completeProviderNames,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runBenchmark(cmd.Context(), args, benchmarkSamples, benchmarkInterval, benchmarkApply)
+	},
+}
+
+func init() {
+	benchmarkProvidersCmd.Flags().IntVar(&benchmarkSamples, "samples", 5, "latency measurements per provider")
+	benchmarkProvidersCmd.Flags().DurationVar(&benchmarkInterval, "interval", time.Second, "wait before each measurement")
+	benchmarkProvidersCmd.Flags().BoolVar(&benchmarkApply, "apply", false, "set the failover priorities of the methods from the ranking")
+
+	benchmarkCmd.AddCommand(benchmarkProvidersCmd)
+}
+
+func runBenchmark(ctx context.Context, methods []string, samples int, interval time.Duration, apply bool) error {
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+	if samples <= 0 {
+		return fmt.Errorf("invalid --samples %d: it must be positive", samples)
+	}
+	if interval <= 0 {
+		return fmt.Errorf("invalid --interval %s: it must be positive", interval)
+	}
+
+	if len(methods) == 0 {
+		methods = enabledMethods()
+		if len(methods) == 0 {
+			return fmt.Errorf("no methods are enabled; name the providers to compare")
+		}
+	}
+
+	opts := core.BenchmarkOptions{Samples: samples, Interval: interval}
+	var results []core.BenchmarkResult
+	for _, method := range methods {
+		provider, err := reg.GetProvider(method)
+		if err != nil {
+			return err
+		}
+		if !provider.IsInstalled() {
+			results = append(results, core.BenchmarkResult{Provider: provider.Name(), Error: "not installed"})
+			continue
+		}
+		if !format.Structured() {
+			fmt.Fprintf(os.Stderr, "Benchmarking %s...\n", provider.Name())
+		}
+		useStoredCredential(provider)
+		results = append(results, core.BenchmarkProvider(ctx, provider, opts))
+		if ctx.Err() != nil {
+			return fmt.Errorf("benchmark interrupted")
+		}
+	}
+	core.RankBenchmarks(results)
+
+	if apply && (len(results) == 0 || !results[0].OK()) {
+		fmt.Fprintln(os.Stderr, "Warning: no provider could be measured; leaving the failover priorities as they are")
+		apply = false
+	}
+	if apply {
+		if err := applyBenchmarkPriorities(results); err != nil {
+			return err
+		}
+	}
+
+	if format.Structured() {
+		return writeOutput(format, map[string]interface{}{
+			"results": results,
+			"applied": apply,
+		})
+	}
+	if !noHeaders {
+		fmt.Println()
+	}
+	table := newTable("RANK", "PROVIDER", "CONNECT", "LATENCY", "MIN", "MAX", "NOTE")
+	for i, r := range results {
+		rank := strconv.Itoa(i + 1)
+		if !r.OK() {
+			table.AddRow("-", r.Provider, "-", "-", "-", "-", colorizeState("failed")+": "+r.Error)
+			continue
+		}
+		connect, note := units.DurationExact(r.ConnectTime), ""
+		if r.AlreadyConnected {
+			connect, note = "-", "already connected"
+		}
+		table.AddRow(rank, r.Provider, connect, units.Duration(r.Latency), units.Duration(r.MinLatency), units.Duration(r.MaxLatency), note)
+	}
+	if err := renderTable(format, table); err != nil {
+		return err
+	}
+
+	if apply {
+		fmt.Println()
+		color.Green("✓ Set failover priorities from the ranking")
+	}
+	return nil
+}
+
+// enabledMethods names the providers the config enables, in failover
+// order
+func enabledMethods() []string {
+	var methods []string
+	for name, method := range appConfig.Methods {
+		if _, err := reg.GetProvider(name); err == nil && method.Enabled {
+			methods = append(methods, name)
+		}
+	}
+	sort.Slice(methods, func(i, j int) bool {
+		a, b := appConfig.Methods[methods[i]], appConfig.Methods[methods[j]]
+		if a.Priority != b.Priority {
+			return a.Priority < b.Priority
+		}
+		return methods[i] < methods[j]
+	})
+	return methods
+}
+
+// applyBenchmarkPriorities numbers the failover priorities of the ranked
+// providers from 1, keeping the others after them in their current order
+func applyBenchmarkPriorities(ranked []core.BenchmarkResult) error {
+	if appConfig.Methods == nil {
+		appConfig.Methods = make(map[string]config.MethodConfig)
+	}
+	seen := make(map[string]bool)
+	priority := 1
+	for _, r := range ranked {
+		method := appConfig.Methods[r.Provider]
+		method.Priority = priority
+		appConfig.Methods[r.Provider] = method
+		seen[r.Provider] = true
+		priority++
+	}
+
+	var rest []string
+	for name := range appConfig.Methods {
+		if !seen[name] {
+			rest = append(rest, name)
+		}
+	}
+	sort.Slice(rest, func(i, j int) bool {
+		return appConfig.Methods[rest[i]].Priority < appConfig.Methods[rest[j]].Priority
+	})
+	for _, name := range rest {
+		method := appConfig.Methods[name]
+		method.Priority = priority
+		appConfig.Methods[name] = method
+		priority++
+	}
+
+	if err := appConfig.Save(); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/changelog"
+	"github.com/spf13/cobra"
+)
+
+var (
+	changelogAll   bool
+	changelogSince string
+)
+
+var changelogCmd = &cobra.Command{
+	Use:   "changelog",
+	Short: "Show what changed in recent releases",
+	Long: `Show the release notes built into this binary, breaking changes and the
+config migrations they need first.
+
+The TUI shows releases you haven't seen once after an upgrade; this command
+shows them again. By default only the newest release is shown.`,
+	Example: `  tunnel changelog
+  tunnel changelog --since 0.1.0
+  tunnel changelog --all --json`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return showChangelog(changelogAll, changelogSince)
+	},
+}
+
+func init() {
+	changelogCmd.Flags().BoolVar(&changelogAll, "all", false, "show every release")
+	changelogCmd.Flags().StringVar(&changelogSince, "since", "", "show the releases after this version")
+}
+
+func showChangelog(all bool, since string) error {
+	releases := changelog.Releases()
+	switch {
+	case all:
+	case since != "":
+		found := false
+		for _, r := range releases {
+			found = found || r.Version == since
+		}
+		if !found {
+			return fmt.Errorf("no release %s in the changelog", since)
+		}
+		releases = changelog.Since(releases, since)
+	default:
+		releases = changelog.Since(releases, "")
+	}
+
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+	if format.Structured() {
+		return writeOutput(format, map[string]interface{}{"releases": releases})
+	}
+
+	if len(releases) == 0 {
+		color.Yellow("No releases after %s", since)
+		return nil
+	}
+	for i, r := range releases {
+		if i > 0 {
+			fmt.Println()
+		}
+		heading := color.New(color.Bold, color.FgCyan).Sprint(r.Version)
+		if r.Date != "" {
+			heading += "  " + r.Date
+		}
+		fmt.Println(heading)
+
+		for _, s := range r.Sections {
+			title := color.CyanString(s.Title)
+			switch s.Title {
+			case changelog.Breaking:
+				title = color.RedString(s.Title)
+			case changelog.Migrations:
+				title = color.YellowString(s.Title)
+			}
+			fmt.Printf("\n  %s\n", title)
+			for _, item := range s.Items {
+				fmt.Printf("  • %s\n", item)
+			}
+		}
+	}
+	return nil
+}
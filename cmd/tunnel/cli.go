@@ -2,8 +2,10 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/fs"
 	"log"
@@ -12,6 +14,10 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -21,10 +27,22 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/compress"
 	"github.com/gofiber/fiber/v2/middleware/filesystem"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/jedarden/tunnel/internal/cmdtemplate"
 	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/fleet"
+	"github.com/jedarden/tunnel/internal/instance"
+	"github.com/jedarden/tunnel/internal/logging"
+	"github.com/jedarden/tunnel/internal/offline"
+	"github.com/jedarden/tunnel/internal/output"
 	"github.com/jedarden/tunnel/internal/providers"
+	"github.com/jedarden/tunnel/internal/providers/script"
 	"github.com/jedarden/tunnel/internal/registry"
+	"github.com/jedarden/tunnel/internal/sandbox"
+	"github.com/jedarden/tunnel/internal/state"
+	"github.com/jedarden/tunnel/internal/suggest"
+	"github.com/jedarden/tunnel/internal/system"
 	"github.com/jedarden/tunnel/internal/tui"
+	"github.com/jedarden/tunnel/internal/units"
 	"github.com/jedarden/tunnel/internal/upgrade"
 	"github.com/jedarden/tunnel/internal/web/api"
 	embeddedfs "github.com/jedarden/tunnel/internal/web/embed"
@@ -36,14 +54,16 @@ import (
 )
 
 var (
-	cfgFile    string
-	verbose    bool
-	jsonOutput bool
-	webPort    int
+	cfgFile     string
+	verbose     bool
+	jsonOutput  bool
+	webPort     int
+	offlineMode bool
 
 	manager       *core.DefaultConnectionManager
 	reg           *registry.Registry
 	keyManager    *core.FileKeyManager
+	auditTrail    *core.AuditLogger
 	tunnelManager *tunnel.Manager
 	tunnelReg     *tunnel.Registry
 )
@@ -51,6 +71,10 @@ var (
 // appConfig holds the loaded application configuration (used during initialization)
 var appConfig *config.Config //nolint:unused
 
+// configErr is why the config file couldn't be loaded, when appConfig holds
+// the defaults instead
+var configErr error
+
 // Execute runs the root command
 func Execute(ctx context.Context) error {
 	return rootCmd.ExecuteContext(ctx)
@@ -69,7 +93,7 @@ By default, running 'tunnel' without arguments launches the interactive TUI.`,
   tunnel
 
   # Start a specific tunnel method
-  tunnel start cloudflared
+  tunnel start cloudflare
 
   # Show status of all connections
   tunnel status
@@ -88,14 +112,19 @@ func init() {
 	// Global flags
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.config/tunnel/config.yaml)")
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
-	rootCmd.PersistentFlags().BoolVar(&jsonOutput, "json", false, "output in JSON format")
+	rootCmd.PersistentFlags().BoolVar(&jsonOutput, "json", false, "output in JSON format (same as --output json)")
+	rootCmd.PersistentFlags().StringVarP(&outputFlag, "output", "o", "", "output format for list commands: table, json, yaml, csv")
+	rootCmd.PersistentFlags().BoolVar(&noHeaders, "no-headers", false, "omit column headers from table output")
 	rootCmd.PersistentFlags().IntVarP(&webPort, "port", "p", 8080, "web server port")
+	rootCmd.PersistentFlags().BoolVar(&offlineMode, "offline", false, "offline mode: suppress all outbound internet access")
+	rootCmd.Flags().BoolVar(&takeover, "takeover", false, "replace the lock left by an instance that did not shut down cleanly")
 
 	// Add all subcommands
 	rootCmd.AddCommand(startCmd)
 	rootCmd.AddCommand(stopCmd)
 	rootCmd.AddCommand(restartCmd)
 	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(providerInstanceCmd)
 	rootCmd.AddCommand(listCmd)
 	rootCmd.AddCommand(configCmd)
 	rootCmd.AddCommand(authCmd)
@@ -104,6 +133,32 @@ func init() {
 	rootCmd.AddCommand(versionCmd)
 	rootCmd.AddCommand(completionsCmd)
 	rootCmd.AddCommand(emergencyRevokeCmd)
+	rootCmd.AddCommand(shareCmd)
+	rootCmd.AddCommand(watchCmd)
+	rootCmd.AddCommand(alertsCmd)
+	rootCmd.AddCommand(dnsCmd)
+	rootCmd.AddCommand(backupCmd)
+	rootCmd.AddCommand(migrateCmd)
+	rootCmd.AddCommand(reportCmd)
+	rootCmd.AddCommand(sessionsCmd)
+	rootCmd.AddCommand(sftpCmd)
+	rootCmd.AddCommand(usersCmd)
+	rootCmd.AddCommand(logsCmd)
+	rootCmd.AddCommand(upgradeCmd)
+	rootCmd.AddCommand(serveCmd)
+	rootCmd.AddCommand(daemonCmd)
+	rootCmd.AddCommand(promptCmd)
+	rootCmd.AddCommand(importCmd)
+	rootCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(forwardsCmd)
+	rootCmd.AddCommand(profileCmd)
+	rootCmd.AddCommand(auditCmd)
+	rootCmd.AddCommand(debugCmd)
+	rootCmd.AddCommand(verifyCmd)
+	rootCmd.AddCommand(benchmarkCmd)
+	rootCmd.AddCommand(fleetCmd)
+	rootCmd.AddCommand(stagedCmd)
+	rootCmd.AddCommand(changelogCmd)
 }
 
 func initCLI() {
@@ -113,21 +168,84 @@ func initCLI() {
 	if verbose {
 		viper.Set("verbose", true)
 	}
+	if offlineMode {
+		viper.Set("offline", true)
+	}
+	// Commands without table output still honor --output json
+	if format, err := output.ParseFormat(outputFlag); err == nil && format == output.FormatJSON {
+		jsonOutput = true
+	}
+
+	// The prompt segment is printed with every shell prompt and only talks
+	// to the daemon, so it skips loading providers and keys
+	if cmd, _, err := rootCmd.Find(os.Args[1:]); err == nil && cmd == promptCmd {
+		return
+	}
+
+	// verify-totp decides logins, so it must know it was pointed at a real
+	// config before loading creates a default one
+	if cmd, _, err := rootCmd.Find(os.Args[1:]); err == nil && cmd == usersVerifyTOTPCmd {
+		checkTOTPConfigFile()
+	}
 
-	// Load application config
+	// Load application config, migrating it unless `tunnel migrate` is
+	// about to preview or apply the migration itself
 	var err error
-	appConfig, err = config.Load("")
+	if cmd, _, findErr := rootCmd.Find(os.Args[1:]); findErr == nil && cmd == migrateCmd {
+		appConfig, err = config.LoadUnmigrated(cfgFile)
+	} else {
+		appConfig, err = config.Load(cfgFile)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Warning: Failed to load config: %v\n", err)
 		// Use default config if loading fails
+		configErr = err
 		appConfig = config.GetDefaultConfig()
 	}
 
+	// Offline mode can come from the flag, TUNNEL_OFFLINE, or the config
+	// file; record the first two as layers so `config explain` shows them
+	if offlineMode {
+		appConfig.Override("settings.offline", "true", config.SourceFlag, "--offline")
+	} else if on, _ := strconv.ParseBool(os.Getenv("TUNNEL_OFFLINE")); on {
+		appConfig.Override("settings.offline", "true", config.SourceEnv, "TUNNEL_OFFLINE")
+	}
+	offline.SetEnabled(viper.GetBool("offline") || appConfig.Settings.Offline)
+	applySandboxes(appConfig)
+	applyCommandTemplates(appConfig)
+	if err := units.Configure(appConfig.Settings.UnitOptions()); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	if err := setupLogging(appConfig.Settings); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	if err := setupAuditKey(appConfig.Monitoring.AuditKeyFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	onShutdown("logging", func(context.Context) error { return logging.Close() })
+
 	// Create registry with all providers
 	reg = registry.NewRegistry()
+	for _, err := range reg.LoadPlugins() {
+		if verbose {
+			fmt.Fprintf(os.Stderr, "Warning: ignoring %v\n", err)
+		}
+	}
+	registerScripts(reg, appConfig)
+	applyMethodSettings(reg, appConfig)
 
-	// Create connection manager
-	manager = core.NewConnectionManager(nil)
+	// Create connection manager; it is the last thing torn down on exit
+	managerConfig := core.DefaultManagerConfig()
+	managerConfig.MaxConnections = appConfig.Settings.MaxConnections
+	managerConfig.MaxPerProvider = appConfig.Settings.MaxInstancesPerProvider
+	managerConfig.Mode = core.ConnectionMode(appConfig.Settings.ConnectionMode)
+	managerConfig.Balance = core.BalanceStrategy(appConfig.Settings.Balance)
+	applyFailoverSettings(managerConfig.FailoverConfig, appConfig.Failover)
+	if path, err := state.DefaultPath(); err == nil {
+		managerConfig.StateFile = path
+	}
+	manager = core.NewConnectionManager(managerConfig)
+	onShutdown("connection manager", manager.ShutdownContext)
 
 	// Register all providers from registry with the connection manager
 	for _, provider := range reg.ListProviders() {
@@ -135,51 +253,412 @@ func initCLI() {
 		adapter := &providerAdapter{provider: provider}
 		manager.RegisterProvider(adapter)
 	}
+	watchProviderRegistry()
+
+	// Forwards of local services stop before the connections they may use
+	forwarder = newForwarder(appConfig, manager.GetEventPublisher())
+	onShutdown("forwards", func(context.Context) error { return forwarder.StopAll() })
 
 	// Initialize key manager
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Warning: Failed to get home directory: %v\n", err)
 	} else {
+		auditTrail = openAuditTrail(homeDir)
 		authorizedKeysPath := filepath.Join(homeDir, ".ssh", "authorized_keys")
-		keyManager, err = core.NewFileKeyManager(authorizedKeysPath, nil)
+		if configured := appConfig.SSH.AuthorizedKeys; configured != "" {
+			authorizedKeysPath = expandHome(configured, homeDir)
+		}
+		keyManager, err = newKeyManager(authorizedKeysPath)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Warning: Failed to initialize key manager: %v\n", err)
+		} else {
+			keyManager.SetAgentForwardingPolicy(agentForwardingPolicy(appConfig))
+			onShutdown("key manager", func(context.Context) error { return keyManager.Close() })
+		}
+	}
+}
+
+// auditLogPath returns the configured audit log, defaulting to audit.log in
+// the config directory
+func auditLogPath(homeDir string) string {
+	if configured := appConfig.Monitoring.AuditLog; configured != "" {
+		return expandHome(configured, homeDir)
+	}
+	return filepath.Join(homeDir, ".config", "tunnel", "audit.log")
+}
+
+// setupAuditKey reads the key audit log entries are signed with, if the
+// config names one
+func setupAuditKey(path string) error {
+	if path == "" {
+		return nil
+	}
+	home, _ := os.UserHomeDir()
+	data, err := os.ReadFile(expandHome(path, home))
+	if err != nil {
+		return fmt.Errorf("failed to read audit key: %w", err)
+	}
+	key := bytes.TrimSpace(data)
+	if len(key) == 0 {
+		return fmt.Errorf("audit key file %s is empty", path)
+	}
+	core.SetAuditKey(key)
+	return nil
+}
+
+// openAuditTrail opens the audit log that key changes, connection events and
+// alerts are recorded in. Failing to open it only costs the audit trail.
+func openAuditTrail(homeDir string) *core.AuditLogger {
+	logger, err := core.NewAuditLogger(auditLogPath(homeDir), appConfig.Monitoring.Syslog, appConfig.Monitoring.SyslogServer)
+	if err != nil {
+		if verbose {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to open audit log: %v\n", err)
+		}
+		return nil
+	}
+	onShutdown("audit log", func(context.Context) error { return logger.Close() })
+	return logger
+}
+
+// applySandboxes confines the processes of each provider with a sandbox
+// section or enforced resource limits in cfg
+func applySandboxes(cfg *config.Config) {
+	home, _ := os.UserHomeDir()
+	expand := func(paths []string) []string {
+		out := make([]string, len(paths))
+		for i, p := range paths {
+			out[i] = expandHome(p, home)
+		}
+		return out
+	}
+	canonical := func(name string) string {
+		c, deprecated := providers.CanonicalName(name)
+		if deprecated {
+			providers.WarnDeprecated("provider name", name, c)
+		}
+		return c
+	}
+
+	policies := make(map[string]sandbox.Policy)
+	for name, sb := range cfg.Sandbox {
+		policies[canonical(name)] = sandbox.Policy{
+			User:     sb.User,
+			AppArmor: sb.AppArmor,
+			Seccomp:  expandHome(sb.Seccomp, home),
+			ReadOnly: sb.ReadOnly,
+			Writable: expand(sb.Writable),
+			Hide:     expand(sb.Hide),
+			Required: sb.Required,
+		}
+	}
+	for name, limit := range cfg.Monitoring.ProviderLimits {
+		if !limit.Enforce {
+			continue
+		}
+		name = canonical(name)
+		policy := policies[name]
+		policy.Limits = sandbox.Limits{
+			MemoryMB:   limit.MaxMemoryMB,
+			CPUPercent: limit.MaxCPUPercent,
+			OpenFiles:  limit.MaxOpenFiles,
+		}
+		policies[name] = policy
+	}
+
+	for name, policy := range policies {
+		providers.SetSandbox(name, policy)
+	}
+}
+
+// applyCommandTemplates replaces the command lines of the providers listed
+// in cfg's commands section. The templates were validated on load.
+func applyCommandTemplates(cfg *config.Config) {
+	for name, command := range cfg.Commands {
+		canonical, deprecated := providers.CanonicalName(name)
+		if deprecated {
+			providers.WarnDeprecated("provider name", name, canonical)
+		}
+		tmpl, err := cmdtemplate.Parse(command)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: ignoring command for %s: %v\n", name, err)
+			continue
+		}
+		providers.SetCommandTemplate(canonical, tmpl)
+	}
+}
+
+// applyMethodSettings hands each method's settings to its provider as the
+// extra config, so a provider such as reverse-ssh can be set up entirely in
+// the config file
+func applyMethodSettings(r *registry.Registry, cfg *config.Config) {
+	for name, method := range cfg.Methods {
+		if len(method.Settings) == 0 {
+			continue
+		}
+		provider, err := r.GetProvider(name)
+		if err != nil {
+			continue
+		}
+		providerConfig, err := provider.GetConfig()
+		if err != nil {
+			providerConfig = &providers.ProviderConfig{Name: provider.Name()}
+		}
+		extra := make(map[string]string, len(providerConfig.Extra)+len(method.Settings))
+		for k, v := range providerConfig.Extra {
+			extra[k] = v
+		}
+		for k, v := range method.Settings {
+			extra[k] = v
+		}
+		updated := *providerConfig
+		updated.Extra = extra
+		if err := provider.Configure(&updated); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: ignoring settings for %s: %v\n", name, err)
+		}
+	}
+}
+
+// registerScripts adds a provider to r for each script in cfg's scripts
+// section. The scripts were validated on load.
+func registerScripts(r *registry.Registry, cfg *config.Config) {
+	for name, sc := range cfg.Scripts {
+		spec, err := scriptSpec(sc)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: ignoring script %s: %v\n", name, err)
+			continue
+		}
+		r.Register(script.New(name, spec))
+	}
+}
+
+// scriptSpec parses the commands and endpoint pattern of a script
+func scriptSpec(sc config.ScriptConfig) (script.Spec, error) {
+	spec := script.Spec{
+		EndpointJSON: sc.EndpointJSON,
+		Category:     providers.Category(sc.Category),
+		Env:          sc.Env,
+		Restart:      sc.Restart,
+		StartTimeout: time.Duration(sc.StartTimeout) * time.Second,
+	}
+	var err error
+	if spec.Connect, err = cmdtemplate.Parse(sc.Connect); err != nil {
+		return spec, fmt.Errorf("connect command: %w", err)
+	}
+	if sc.Disconnect != "" {
+		if spec.Disconnect, err = cmdtemplate.Parse(sc.Disconnect); err != nil {
+			return spec, fmt.Errorf("disconnect command: %w", err)
+		}
+	}
+	if sc.Health != "" {
+		if spec.Health, err = cmdtemplate.Parse(sc.Health); err != nil {
+			return spec, fmt.Errorf("health command: %w", err)
+		}
+	}
+	if sc.Endpoint != "" {
+		if spec.Endpoint, err = regexp.Compile(sc.Endpoint); err != nil {
+			return spec, fmt.Errorf("endpoint pattern: %w", err)
+		}
+	}
+	return spec, nil
+}
+
+// expandHome replaces a leading ~ in path with home
+func expandHome(path, home string) string {
+	if path == "~" {
+		return home
+	}
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		return filepath.Join(home, rest)
+	}
+	return path
+}
+
+// applyFailoverSettings sets the latency failover thresholds of the failover
+// section on fc, leaving the defaults where the section leaves them unset
+func applyFailoverSettings(fc *core.FailoverConfig, settings config.FailoverConfig) {
+	if settings.MaxLatency > 0 {
+		fc.MaxLatency = time.Duration(settings.MaxLatency) * time.Millisecond
+	}
+	switch {
+	case settings.LatencyChecks < 0:
+		fc.LatencyChecks = 0
+	case settings.LatencyChecks > 0:
+		fc.LatencyChecks = settings.LatencyChecks
+	}
+}
+
+// setupLogging installs the logger the settings describe. TUNNEL_LOG_FILE
+// names a log file when the settings don't, and --verbose logs debug
+// entries.
+func setupLogging(settings config.Settings) error {
+	opts := logging.Options{
+		Level:    settings.LogLevel,
+		Format:   settings.LogFormat,
+		File:     settings.LogFile,
+		MaxSize:  settings.LogMaxSize,
+		MaxFiles: settings.LogMaxFiles,
+	}
+	if opts.File == "" {
+		opts.File = viper.GetString("log_file")
+	}
+	if opts.File != "" {
+		home, _ := os.UserHomeDir()
+		opts.File = expandHome(opts.File, home)
+	}
+	if verbose {
+		opts.Level = "debug"
+	}
+	if err := logging.Setup(opts); err != nil {
+		return fmt.Errorf("failed to set up logging: %w", err)
+	}
+	return nil
+}
+
+// connectionModes are the modes the TUI's m key steps through, in order
+var connectionModes = []struct {
+	mode     core.ConnectionMode
+	strategy core.BalanceStrategy
+}{
+	{core.ModeFailover, core.BalanceRoundRobin},
+	{core.ModeLoadBalance, core.BalanceRoundRobin},
+	{core.ModeLoadBalance, core.BalanceLatency},
+}
+
+// tuiConnectionMode describes the configured connection mode for the TUI
+func tuiConnectionMode() string {
+	mode, strategy, err := core.ParseConnectionMode(appConfig.Settings.ConnectionMode, appConfig.Settings.Balance)
+	if err != nil {
+		return appConfig.Settings.ConnectionMode
+	}
+	if mode == core.ModeLoadBalance {
+		return fmt.Sprintf("%s (%s)", mode, strategy)
+	}
+	return string(mode)
+}
+
+// cycleTUIConnectionMode switches to the next connection mode, saving it
+// and applying it to the running managers
+func cycleTUIConnectionMode() (string, error) {
+	mode, strategy, err := core.ParseConnectionMode(appConfig.Settings.ConnectionMode, appConfig.Settings.Balance)
+	if err != nil {
+		return "", err
+	}
+	next := connectionModes[0]
+	for i, m := range connectionModes {
+		if m.mode == mode && (mode == core.ModeFailover || m.strategy == strategy) {
+			next = connectionModes[(i+1)%len(connectionModes)]
+			break
+		}
+	}
+
+	appConfig.Settings.ConnectionMode = string(next.mode)
+	appConfig.Settings.Balance = string(next.strategy)
+	if err := appConfig.Validate(); err != nil {
+		return "", err
+	}
+	if err := appConfig.Save(); err != nil {
+		return "", fmt.Errorf("failed to save config: %w", err)
+	}
+
+	if manager != nil {
+		if err := manager.SetMode(next.mode, next.strategy); err != nil {
+			return "", err
+		}
+	}
+	if tunnelManager != nil {
+		if err := tunnelManager.SetMode(next.mode, next.strategy); err != nil {
+			return "", err
 		}
 	}
+	return tuiConnectionMode(), nil
+}
+
+// newKeyManager creates the key manager for the configured key store backend
+func newKeyManager(authorizedKeysPath string) (*core.FileKeyManager, error) {
+	var store core.KeyStore
+	switch appConfig.SSH.KeyStore {
+	case "sqlite":
+		sqlStore, err := core.OpenSQLKeyStore(core.SQLiteDriver, appConfig.SSH.KeyDatabase)
+		if err != nil {
+			return nil, err
+		}
+		store = sqlStore
+	case "ldap":
+		store = core.NewLDAPKeyStore(appConfig.SSH.LDAP)
+	default:
+		return core.NewFileKeyManager(authorizedKeysPath, auditTrail)
+	}
+
+	km, err := core.NewFileKeyManagerWithStore(authorizedKeysPath, store, auditTrail)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	return km, nil
 }
 
 // Connection commands
 
 var startCmd = &cobra.Command{
-	Use:   "start [method]",
-	Short: "Start a tunnel connection",
-	Long:  `Start a tunnel connection using the specified method or the default method.`,
-	Example: `  tunnel start cloudflared
+	Use:     "start [method]",
+	Aliases: []string{"up"},
+	Short:   "Start a tunnel connection",
+	Long: `Start a tunnel connection using the specified method or the default method.
+
+With --dry-run nothing is started; the binaries that would be executed,
+the ports that would be opened and the config that would be applied are
+printed instead, with secrets redacted.
+
+Tunnels that are up are recorded in ~/.config/tunnel/state.json until
+they are stopped, and survive shutting tunnel down. --restore starts them
+again, with any saved provider instances, e.g. after a reboot.`,
+	Example: `  tunnel start cloudflare
   tunnel start ngrok
+  tunnel start bore --dry-run
+  tunnel start --restore
   tunnel start`,
-	Args: cobra.MaximumNArgs(1),
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: completeProviderNames,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if restoreState {
+			if len(args) > 0 {
+				return fmt.Errorf("--restore starts the saved tunnels and takes no method")
+			}
+			return restoreConnections()
+		}
 		method := "default"
 		if len(args) > 0 {
 			method = args[0]
 		}
+		if startDryRun {
+			return planConnections(method, providers.ActionConnect)
+		}
 		return startConnection(method)
 	},
 }
 
 var stopCmd = &cobra.Command{
-	Use:   "stop [method|all]",
-	Short: "Stop tunnel connection(s)",
-	Long:  `Stop a specific tunnel connection or all connections.`,
-	Example: `  tunnel stop cloudflared
-  tunnel stop all`,
-	Args: cobra.MaximumNArgs(1),
+	Use:     "stop [method|all]",
+	Aliases: []string{"down"},
+	Short:   "Stop tunnel connection(s)",
+	Long: `Stop a specific tunnel connection or all connections.
+
+With --dry-run nothing is stopped; the commands that would be run are
+printed instead.`,
+	Example: `  tunnel stop cloudflare
+  tunnel stop all
+  tunnel down all --dry-run`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: completeProviderNames,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		method := "all"
 		if len(args) > 0 {
 			method = args[0]
 		}
+		if stopDryRun {
+			return planConnections(method, providers.ActionDisconnect)
+		}
 		return stopConnection(method)
 	},
 }
@@ -188,9 +667,10 @@ var restartCmd = &cobra.Command{
 	Use:   "restart [method]",
 	Short: "Restart a tunnel connection",
 	Long:  `Restart a specific tunnel connection.`,
-	Example: `  tunnel restart cloudflared
+	Example: `  tunnel restart cloudflare
   tunnel restart ngrok`,
-	Args: cobra.ExactArgs(1),
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeProviderNames,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		method := args[0]
 		return restartConnection(method)
@@ -200,12 +680,21 @@ var restartCmd = &cobra.Command{
 var statusCmd = &cobra.Command{
 	Use:   "status",
 	Short: "Show connection status",
-	Long:  `Display the status of all tunnel connections.`,
+	Long: `Display the status of all tunnel connections.
+
+With --json, durations - uptime, total_uptime and latency - are given in
+seconds.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return showStatus()
 	},
 }
 
+var statusDetail bool
+
+func init() {
+	statusCmd.Flags().BoolVar(&statusDetail, "detail", false, "include CPU and memory usage of provider processes and connection history")
+}
+
 // Method management commands
 
 var listCmd = &cobra.Command{
@@ -231,7 +720,7 @@ var configGetCmd = &cobra.Command{
 	Long:  `Get a specific configuration value or show all configuration.`,
 	Example: `  tunnel config get
   tunnel config get ssh.port
-  tunnel config get providers.cloudflared.enabled`,
+  tunnel config get providers.cloudflare.enabled`,
 	Args: cobra.MaximumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		key := ""
@@ -242,12 +731,17 @@ var configGetCmd = &cobra.Command{
 	},
 }
 
+var configSetForce bool
+
 var configSetCmd = &cobra.Command{
 	Use:   "set <key> <value>",
 	Short: "Set configuration value",
-	Long:  `Set a specific configuration value.`,
+	Long: `Set a specific configuration value.
+
+Unknown keys are rejected with suggestions, since a misspelled key would be
+silently ignored. Use --force to set a key anyway.`,
 	Example: `  tunnel config set ssh.port 2222
-  tunnel config set providers.cloudflared.enabled true`,
+  tunnel config set providers.cloudflare.enabled true`,
 	Args: cobra.ExactArgs(2),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		key := args[0]
@@ -256,6 +750,25 @@ var configSetCmd = &cobra.Command{
 	},
 }
 
+var configExplainCmd = &cobra.Command{
+	Use:   "explain <key>",
+	Short: "Show where a configuration value comes from",
+	Long: `Show the effective value of a setting and the layer it came from: the
+built-in default, the config file, a TUNNEL_* environment variable, a
+command-line flag, or a change made at runtime. Values from lower layers
+that it overrides are listed too.
+
+Any setting can be overridden from the environment; the variable name is the
+key in upper case with dots replaced by underscores, prefixed with TUNNEL_.`,
+	Example: `  tunnel config explain settings.log_level
+  TUNNEL_SSH_PORT=2200 tunnel config explain ssh.port
+  tunnel config explain settings.offline --offline`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return explainConfig(args[0])
+	},
+}
+
 var configEditCmd = &cobra.Command{
 	Use:   "edit",
 	Short: "Edit configuration file",
@@ -266,8 +779,11 @@ var configEditCmd = &cobra.Command{
 }
 
 func init() {
+	configSetCmd.Flags().BoolVar(&configSetForce, "force", false, "set the key even if tunnel does not recognize it")
+
 	configCmd.AddCommand(configGetCmd)
 	configCmd.AddCommand(configSetCmd)
+	configCmd.AddCommand(configExplainCmd)
 	configCmd.AddCommand(configEditCmd)
 }
 
@@ -283,9 +799,10 @@ var authLoginCmd = &cobra.Command{
 	Use:   "login <method>",
 	Short: "Authenticate with a tunnel provider",
 	Long:  `Interactively authenticate with a tunnel provider.`,
-	Example: `  tunnel auth login cloudflared
+	Example: `  tunnel auth login cloudflare
   tunnel auth login ngrok`,
-	Args: cobra.ExactArgs(1),
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeProviderNames,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		method := args[0]
 		return authLogin(method)
@@ -295,10 +812,15 @@ var authLoginCmd = &cobra.Command{
 var authSetKeyCmd = &cobra.Command{
 	Use:   "set-key <method>",
 	Short: "Set API key for a provider",
-	Long:  `Set the API key for a tunnel provider.`,
+	Long: `Set the API key for a tunnel provider. The key is kept in the system
+keyring, or where there is none in a file encrypted with the passphrase from
+TUNNEL_CREDENTIALS_PASSPHRASE or credentials.passphrase_file, and handed to
+the provider whenever it connects.`,
 	Example: `  tunnel auth set-key ngrok
-  tunnel auth set-key cloudflared`,
-	Args: cobra.ExactArgs(1),
+  tunnel auth set-key cloudflare`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeProviderNames,
+	SilenceUsage:      true,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		method := args[0]
 		return setAPIKey(method)
@@ -328,12 +850,31 @@ var keysCmd = &cobra.Command{
 	Long:  `Manage SSH public keys for authentication.`,
 }
 
+var (
+	keysListSource    string
+	keysListOlderThan string
+	keysRevokeFleet   bool
+
+	keysAddFrom             []string
+	keysAddCommand          string
+	keysAddNoPortForwarding bool
+	keysAddNoPTY            bool
+	keysAddNoX11Forwarding  bool
+	keysAddExpires          string
+)
+
 var keysListCmd = &cobra.Command{
 	Use:   "list [user]",
 	Short: "List SSH keys",
-	Long:  `List all SSH public keys, optionally filtered by user.`,
+	Long: `List all SSH public keys, optionally filtered by user, source or age.
+
+Filters need the sqlite or ldap key store (ssh.key_store in the config file),
+since authorized_keys does not record a key's owner, source or when it was
+added. With the file store the user argument is ignored.`,
 	Example: `  tunnel keys list
-  tunnel keys list alice`,
+  tunnel keys list alice
+  tunnel keys list --source github
+  tunnel keys list --older-than 90d`,
 	Args: cobra.MaximumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		user := ""
@@ -347,13 +888,21 @@ var keysListCmd = &cobra.Command{
 var keysAddCmd = &cobra.Command{
 	Use:   "add <user>",
 	Short: "Add a new SSH key",
-	Long:  `Add a new SSH public key for a user. Prompts for the key interactively.`,
+	Long: `Add a new SSH public key for a user. Prompts for the key interactively.
+
+The restriction flags are written as options of the key's authorized_keys
+line, so sshd enforces them: --from limits the addresses the key connects
+from (addresses, host names, * and ? wildcards or CIDR blocks, ! to exclude),
+--command forces the command it runs, and --expires stops sshd accepting it
+after a duration (30d) or a date (2026-12-31). Options in the pasted line are
+kept unless a flag replaces them.`,
 	Example: `  tunnel keys add alice
-  tunnel keys add bob`,
+  tunnel keys add bob --from 10.0.0.0/8 --no-pty
+  tunnel keys add backup --command "rrsync -ro /srv" --no-port-forwarding --expires 90d`,
 	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		user := args[0]
-		return addKey(user)
+		return addKey(cmd, user)
 	},
 }
 
@@ -377,14 +926,18 @@ var keysRotateCmd = &cobra.Command{
 var keysRevokeCmd = &cobra.Command{
 	Use:   "revoke <user> <key-id>",
 	Short: "Revoke a specific SSH key",
-	Long:  `Revoke (remove) a specific SSH public key.`,
+	Long: `Revoke (remove) a specific SSH public key.
+
+With --fleet, or fleet.propagate_revocations in the config, the key is
+revoked on every node of the fleet section too.`,
 	Example: `  tunnel keys revoke alice SHA256:abc123...
-  tunnel keys revoke bob 1`,
+  tunnel keys revoke bob 1
+  tunnel keys revoke bob 1 --fleet`,
 	Args: cobra.ExactArgs(2),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		user := args[0]
 		keyID := args[1]
-		return revokeKey(user, keyID)
+		return revokeKey(user, keyID, propagateToFleet(cmd, keysRevokeFleet))
 	},
 }
 
@@ -415,6 +968,16 @@ var keysImportGitLabCmd = &cobra.Command{
 }
 
 func init() {
+	keysListCmd.Flags().StringVar(&keysListSource, "source", "", "only keys from this source: manual, github, gitlab, gitea, url, file, ldap, rotation")
+	keysListCmd.Flags().StringVar(&keysListOlderThan, "older-than", "", "only keys added longer ago than this (e.g. 90d, 1y)")
+	keysRevokeCmd.Flags().BoolVar(&keysRevokeFleet, "fleet", false, "revoke the key on the fleet nodes too")
+	keysAddCmd.Flags().StringSliceVar(&keysAddFrom, "from", nil, "only accept the key from these address patterns (comma-separated)")
+	keysAddCmd.Flags().StringVar(&keysAddCommand, "command", "", "force this command whatever the client runs")
+	keysAddCmd.Flags().BoolVar(&keysAddNoPortForwarding, "no-port-forwarding", false, "refuse port forwarding with the key")
+	keysAddCmd.Flags().BoolVar(&keysAddNoPTY, "no-pty", false, "refuse a terminal with the key")
+	keysAddCmd.Flags().BoolVar(&keysAddNoX11Forwarding, "no-x11-forwarding", false, "refuse X11 forwarding with the key")
+	keysAddCmd.Flags().StringVar(&keysAddExpires, "expires", "", "stop accepting the key after a duration (e.g. 30d) or on a date")
+
 	keysCmd.AddCommand(keysListCmd)
 	keysCmd.AddCommand(keysAddCmd)
 	keysCmd.AddCommand(keysRotateCmd)
@@ -471,6 +1034,7 @@ var (
 	emergencyRevokeKillSessions bool
 	emergencyRevokeNotify       bool
 	emergencyRevokeForce        bool
+	emergencyRevokeFleet        bool
 )
 
 var emergencyRevokeCmd = &cobra.Command{
@@ -484,6 +1048,11 @@ This command will:
 - Log an audit event with the reason
 - Optionally kill active sessions
 - Optionally send notifications
+- Optionally revoke the user's keys on every fleet node (--fleet, or
+  fleet.propagate_revocations in the config) and report each node's result
+
+With two_person.enabled in the config the revocation is only staged, and
+runs once a second operator approves it with tunnel staged approve.
 
 Use this command in emergency situations such as:
 - Security breaches or compromised credentials
@@ -497,11 +1066,27 @@ Use this command in emergency situations such as:
   tunnel emergency-revoke alice --reason "device stolen" --kill-sessions
 
   # Skip confirmation prompt
-  tunnel emergency-revoke charlie --reason "terminated" --force`,
+  tunnel emergency-revoke charlie --reason "terminated" --force
+
+  # Revoke on every fleet node as well
+  tunnel emergency-revoke dave --reason "key leaked" --fleet`,
 	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		username := args[0]
-		return emergencyRevoke(username, emergencyRevokeReason, emergencyRevokeKillSessions, emergencyRevokeNotify, emergencyRevokeForce)
+		propagate := propagateToFleet(cmd, emergencyRevokeFleet)
+		if appConfig.TwoPerson.Enabled {
+			return stageAction(core.StagedAction{
+				Action: actionEmergencyRevoke,
+				User:   username,
+				Reason: emergencyRevokeReason,
+				Params: map[string]string{
+					"kill_sessions": strconv.FormatBool(emergencyRevokeKillSessions),
+					"notify":        strconv.FormatBool(emergencyRevokeNotify),
+					"fleet":         strconv.FormatBool(propagate),
+				},
+			})
+		}
+		return emergencyRevoke(username, emergencyRevokeReason, emergencyRevokeKillSessions, emergencyRevokeNotify, emergencyRevokeForce, propagate)
 	},
 }
 
@@ -511,15 +1096,32 @@ func init() {
 	emergencyRevokeCmd.Flags().BoolVar(&emergencyRevokeKillSessions, "kill-sessions", false, "kill active SSH sessions for the user")
 	emergencyRevokeCmd.Flags().BoolVar(&emergencyRevokeNotify, "notify", false, "send notification about the revocation")
 	emergencyRevokeCmd.Flags().BoolVar(&emergencyRevokeForce, "force", false, "skip confirmation prompt")
+	emergencyRevokeCmd.Flags().BoolVar(&emergencyRevokeFleet, "fleet", false, "revoke the user's keys on the fleet nodes too")
 }
 
 // Implementation functions
 
 func launchTUI(ctx context.Context) error {
+	// Only one instance manages providers; later invocations attach to it
+	holder, err := acquireInstanceLock("tui")
+	if errors.Is(err, instance.ErrRunning) {
+		return attachToInstance(ctx, holder)
+	}
+	if err != nil {
+		return err
+	}
+
 	if verbose {
 		fmt.Println("Launching tunnel with web server...")
 	}
 
+	startThrottles(ctx)
+	if restoreState {
+		if err := restoreConnections(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+
 	// Start the hot-swap binary watcher
 	upgradeWatcher, err := upgrade.NewWatcher(log.Default())
 	if err != nil {
@@ -545,8 +1147,44 @@ func launchTUI(ctx context.Context) error {
 		defer upgradeWatcher.Stop()
 	}
 
+	startBackgroundJobs(ctx)
+
 	// Create the minimal TUI application
 	tuiApp := tui.NewApp(webPort)
+	restoreTUIState(tuiApp)
+	defer saveTUIState(tuiApp)
+	setupTUIChangelog(tuiApp)
+	setupTUIMacros(tuiApp)
+	setupTUIDashboard(tuiApp)
+
+	// Forwards show on the dashboard, failed ones with their error
+	tuiApp.SetForwards(forwarder.List)
+	tuiApp.SetProfiles(tuiProfiles, applyTUIProfile)
+	tuiApp.SetThrottles(throttleStatuses)
+	tuiApp.SetCapture(toggleTUICapture)
+	tuiApp.SetConnectionMode(tuiConnectionMode, cycleTUIConnectionMode)
+	go autostartForwards(appConfig)
+
+	// Access requests from the API or watch folder wait for approval in the TUI
+	approvals, closeApprovals, err := newApprovalQueue()
+	if err != nil {
+		if verbose {
+			fmt.Printf("Warning: Could not enable access approvals: %v\n", err)
+		}
+	} else {
+		// Close after the web server has drained so in-flight requests can still audit
+		onShutdown("access approvals", func(context.Context) error {
+			closeApprovals()
+			return nil
+		})
+		tuiApp.SetApprovalQueue(approvals)
+	}
+
+	// Self-enrollment feeds the same approval queue once email is verified
+	var enrollment *core.EnrollmentManager
+	if approvals != nil && appConfig.Enrollment.Enabled {
+		enrollment = newEnrollmentManager(approvals, appConfig.Enrollment)
+	}
 
 	// Create and run the Bubble Tea program
 	p := tea.NewProgram(tuiApp, tea.WithAltScreen())
@@ -556,7 +1194,7 @@ func launchTUI(ctx context.Context) error {
 
 	// Start web server in background
 	go func() {
-		if err := startWebServer(ctx, p); err != nil {
+		if err := startWebServer(ctx, p, approvals, enrollment, webOptions{}); err != nil {
 			serverReady <- err
 		}
 		close(serverReady)
@@ -582,6 +1220,26 @@ func launchTUI(ctx context.Context) error {
 		}
 	}()
 
+	// Quit the TUI on SIGTERM as well as on its own key bindings
+	go func() {
+		<-ctx.Done()
+		p.Quit()
+	}()
+
+	// Log entries go to the live log rather than over the alternate screen
+	logging.SetQuiet(true)
+	defer logging.SetQuiet(false)
+	entries, unsubscribe := logging.Subscribe(100)
+	defer unsubscribe()
+	go p.Send(tui.LogFeedMsg{Entries: entries})
+
+	// Audit events join connection events on the events screen
+	if auditTrail != nil {
+		audits, unsubscribeAudit := auditTrail.Subscribe(100)
+		defer unsubscribeAudit()
+		go p.Send(tui.AuditFeedMsg{Events: audits})
+	}
+
 	// Run the TUI program
 	if _, err := p.Run(); err != nil {
 		return fmt.Errorf("failed to run TUI: %w", err)
@@ -590,19 +1248,110 @@ func launchTUI(ctx context.Context) error {
 	return nil
 }
 
-// startWebServer starts the Fiber web server with the API and embedded frontend
-func startWebServer(ctx context.Context, p *tea.Program) error {
+// webOptions changes how startWebServer serves; the zero value is the TUI's
+// web server
+type webOptions struct {
+	addr    string // exact address to listen on; empty tries webPort and the ports above it
+	apiOnly bool   // leave out the embedded frontend
+	token   string // API token required of every client; empty leaves all but the key-changing routes open
+	shared  bool   // several local users reach the server, so refuse every request without a token
+}
+
+// startWebServer starts the Fiber web server with the API and embedded
+// frontend. p is nil when there is no TUI to keep informed.
+func startWebServer(ctx context.Context, p *tea.Program, approvals *core.ApprovalQueue, enrollment *core.EnrollmentManager, opts webOptions) error {
 	// Create tunnel manager and registry for the API
 	tunnelReg = tunnel.NewRegistry()
-	tunnelManager = tunnel.NewManager(nil) // Use default config
+	registerScripts(tunnelReg, appConfig)
+	throttleRegistry(tunnelReg)
+	managerConfig := tunnel.DefaultManagerConfig()
+	managerConfig.MaxConnections = appConfig.Settings.MaxConnections
+	managerConfig.MaxPerProvider = appConfig.Settings.MaxInstancesPerProvider
+	managerConfig.Mode = tunnel.ConnectionMode(appConfig.Settings.ConnectionMode)
+	managerConfig.Balance = tunnel.BalanceStrategy(appConfig.Settings.Balance)
+	applyFailoverSettings(managerConfig.FailoverConfig, appConfig.Failover)
+	tunnelManager = tunnel.NewManager(managerConfig)
+	if p != nil {
+		p.Send(tui.ConnectionBudgetMsg{Budget: tunnelManager.Budget})
+		p.Send(tui.ThroughputMsg{Throughput: tunnelManager.ThroughputSince})
+
+		// Connection events feed the live log of the split layout
+		sub := tunnelManager.GetEventPublisher().Subscribe("tui", nil)
+		p.Send(tui.EventFeedMsg{Events: sub.Channel})
+	}
+
+	notifier, err := startNotifications(ctx, appConfig, tunnelManager.GetEventPublisher())
+	if err != nil {
+		return fmt.Errorf("failed to start notifications: %w", err)
+	}
+	if err := startAlerts(ctx, appConfig, tunnelManager, notifier); err != nil {
+		return fmt.Errorf("failed to start alerts: %w", err)
+	}
 
-	// Create API server
+	// Keep connection events and alerts for `tunnel report`
+	if auditTrail != nil {
+		sub := tunnelManager.GetEventPublisher().Subscribe("audit-log", nil)
+		go auditTrail.RecordConnectionEvents(ctx, sub)
+		if alertEngine != nil {
+			alertEngine.SetAuditLogger(auditTrail)
+		}
+	}
+	if alertEngine != nil && p != nil {
+		p.Send(tui.AlertEngineMsg{Engine: alertEngine})
+	}
+
+	// Tunnels die quietly when the laptop changes networks; bring them back
+	go watchNetwork(ctx, tunnelManager)
+
+	// Send DNS through the resolvers of a connected VPN when enabled
+	startDNS(ctx, appConfig, tunnelReg)
+
+	// Hold the ports of on-demand tunnels, starting them when first used
+	startWake(ctx, appConfig, tunnelReg)
+
+	// Keep authorized_keys in step with the LDAP directory
+	if keyManager != nil && appConfig.SSH.KeyStore == "ldap" {
+		interval := time.Duration(appConfig.SSH.LDAP.SyncInterval) * time.Second
+		go syncDirectoryKeys(ctx, keyManager, interval)
+	}
+
+	// Bring existing keys in line with the agent forwarding policy and
+	// record the sessions that forward an agent
+	if keyManager != nil {
+		if err := keyManager.ApplyAgentForwardingPolicy(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to apply agent forwarding policy: %v\n", err)
+		}
+		go watchAgentForwarding(ctx, agentForwardingPolicy(appConfig), 30*time.Second)
+	}
+
+	// Record SSH sessions in the audit log as they end
+	go watchSSHSessions(ctx, appConfig.SSH.SessionLog, 10*time.Second)
+
+	// Tell the user when the binaries tunnel installed have new releases
+	go checkProviderUpdates(ctx, appConfig, notifier)
+
+	// Create API server; provider installs are recorded for `tunnel upgrade`
+	installs, err := openInstallStore()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	var keys core.KeyManager
+	if keyManager != nil {
+		keys = keyManager
+	}
 	apiServer := api.NewServer(&api.ServerConfig{
-		Manager:  tunnelManager,
-		Registry: tunnelReg,
-		Logger:   log.Default(),
-		DevMode:  false,
+		Manager:       tunnelManager,
+		Registry:      tunnelReg,
+		Approvals:     approvals,
+		Enrollment:    enrollment,
+		Installs:      installs,
+		Keys:          keys,
+		BeforeConnect: useStoredCredential,
+		AnswerProbes:  appConfig.Verify.AnswerProbes,
+		Logger:        log.Default(),
+		DevMode:       false,
 	})
+	onShutdown("api connections", apiServer.Shutdown)
 
 	// Create Fiber app
 	app := fiber.New(fiber.Config{
@@ -624,18 +1373,48 @@ func startWebServer(ctx context.Context, p *tea.Program) error {
 		Level: compress.LevelBestSpeed,
 	}))
 	app.Use(middleware.RequestLogger())
+	proxied := appConfig.API.ProxyAuth.Enabled()
+	if proxied {
+		handler, err := proxyAuth(appConfig)
+		if err != nil {
+			return err
+		}
+		app.Use("/api", handler)
+	}
+	// A reverse proxy on this host connects from loopback, so loopback is
+	// no reason to skip the token. Without a token or a proxy the routes
+	// changing authorized_keys refuse every request.
+	if opts.shared || proxied || opts.token != "" {
+		app.Use("/api", middleware.RequireToken(opts.token))
+	}
+
+	// Changes made through the API are audited, including those refused
+	// for going over a caller's rate limit
+	if auditTrail != nil {
+		app.Use("/api", middleware.Audit(recordAPICall))
+	}
+	if limits := appConfig.API.RateLimit; !limits.Disabled {
+		requests, mutations := limits.Limits()
+		app.Use("/api", middleware.RateLimit(requests, nil))
+		app.Use("/api", middleware.RateLimit(mutations, middleware.IsMutation))
+		// Each enrollment sends an email and each verification guesses a
+		// token, so both get a far smaller budget
+		app.Use("/api/enroll", middleware.RateLimit(limits.EnrollmentLimit(), middleware.IsMutation))
+	}
 
 	// Setup API routes
 	api.SetupRoutes(app, apiServer)
 
-	// Serve embedded frontend
+	// Serve embedded frontend, unless only the API was asked for
 	staticFS, err := embeddedfs.GetFS()
-	if err != nil {
+	switch {
+	case opts.apiOnly:
+	case err != nil:
 		// Frontend not embedded (development mode)
 		if verbose {
 			fmt.Println("Frontend not embedded, API-only mode")
 		}
-	} else {
+	default:
 		// Serve static files from embedded filesystem
 		app.Use("/", filesystem.New(filesystem.Config{
 			Root:         http.FS(staticFS),
@@ -645,18 +1424,17 @@ func startWebServer(ctx context.Context, p *tea.Program) error {
 		}))
 	}
 
-	// Run server (blocks until shutdown)
-	go func() {
-		<-ctx.Done()
-		_ = app.Shutdown()
-	}()
+	if opts.addr != "" {
+		return listenWebServer(app, opts.addr)
+	}
 
 	// Try to start server, auto-incrementing port if in use
 	actualPort := webPort
 	maxAttempts := 10
 
 	for attempt := 0; attempt < maxAttempts; attempt++ {
-		addr := fmt.Sprintf(":%d", actualPort)
+		// Only this host; tunnel serve is for reaching the API from elsewhere
+		addr := fmt.Sprintf("127.0.0.1:%d", actualPort)
 
 		// Check if port is available
 		listener, err := net.Listen("tcp", addr)
@@ -670,7 +1448,7 @@ func startWebServer(ctx context.Context, p *tea.Program) error {
 		listener.Close()
 
 		// Port is available - notify TUI of the actual port
-		if actualPort != webPort {
+		if actualPort != webPort && p != nil {
 			p.Send(tui.ServerStatusMsg{
 				Status: tui.ServerRunning,
 				Port:   actualPort,
@@ -682,6 +1460,17 @@ func startWebServer(ctx context.Context, p *tea.Program) error {
 			fmt.Printf("Starting web server on http://localhost:%d\n", actualPort)
 		}
 
+		// Let later invocations find and attach to this server
+		if instanceLock != nil {
+			if err := instanceLock.SetPort(actualPort); err != nil && verbose {
+				fmt.Printf("Warning: Could not record web server port: %v\n", err)
+			}
+		}
+
+		// Drain in-flight requests before the connections they may be using go away
+		onShutdown("web server", app.ShutdownWithContext)
+
+		// Run server (blocks until shutdown)
 		return app.Listen(addr)
 	}
 
@@ -703,14 +1492,22 @@ func startConnection(method string) error {
 		fmt.Printf("Starting connection with method: %s\n", method)
 	}
 
-	// Get provider from registry
-	provider, err := reg.GetProvider(method)
+	// The daemon keeps connections up after we exit; let it make them
+	var res connectResult
+	var err error
+	if client := daemonClient(); client != nil {
+		err = client.Call("start", method, &res)
+	} else {
+		warnUnthrottled(method)
+		res, err = connectProvider(method)
+	}
 	if err != nil {
-		return fmt.Errorf("provider not found: %s", method)
+		return err
 	}
+	method = res.Method
 
 	// Check if already connected
-	if provider.IsConnected() {
+	if res.Unchanged {
 		if jsonOutput {
 			output := map[string]interface{}{
 				"status":  "error",
@@ -723,22 +1520,19 @@ func startConnection(method string) error {
 		return nil
 	}
 
-	// Connect using the provider
-	if err := provider.Connect(); err != nil {
+	if res.Error != "" {
 		if jsonOutput {
 			output := map[string]interface{}{
 				"status": "error",
-				"error":  err.Error(),
+				"error":  res.Error,
 				"method": method,
 			}
 			return printJSON(output)
 		}
-		return fmt.Errorf("failed to connect: %w", err)
+		return fmt.Errorf("failed to connect: %s", res.Error)
 	}
 
-	// Get connection info
-	connInfo, err := provider.GetConnectionInfo()
-	if err == nil && connInfo != nil {
+	if connInfo := res.Info; connInfo != nil {
 		if jsonOutput {
 			output := map[string]interface{}{
 				"status":          "started",
@@ -777,10 +1571,20 @@ func stopConnection(method string) error {
 		fmt.Printf("Stopping connection: %s\n", method)
 	}
 
+	client := daemonClient()
+
 	// Handle "all" to stop all connections
 	if method == "all" {
-		providers := reg.GetConnectedProviders()
-		if len(providers) == 0 {
+		var res stopAllResult
+		if client != nil {
+			if err := client.Call("stop-all", nil, &res); err != nil {
+				return err
+			}
+		} else {
+			res = disconnectAll()
+			forgetConnections(res.Stopped...)
+		}
+		if res.Count == 0 {
 			if jsonOutput {
 				output := map[string]interface{}{
 					"status":  "info",
@@ -792,44 +1596,52 @@ func stopConnection(method string) error {
 			return nil
 		}
 
-		errors := []string{}
-		for _, provider := range providers {
-			if err := provider.Disconnect(); err != nil {
-				errors = append(errors, fmt.Sprintf("%s: %v", provider.Name(), err))
-			} else if verbose {
-				fmt.Printf("Stopped %s\n", provider.Name())
+		if verbose {
+			for _, name := range res.Stopped {
+				fmt.Printf("Stopped %s\n", name)
 			}
 		}
 
+		errors := res.Errors
+		if errors == nil {
+			errors = []string{}
+		}
 		if jsonOutput {
 			output := map[string]interface{}{
 				"status":  "stopped",
-				"count":   len(providers),
+				"count":   res.Count,
 				"errors":  errors,
-				"success": len(providers) - len(errors),
+				"success": res.Count - len(errors),
 			}
 			return printJSON(output)
 		}
 
 		if len(errors) > 0 {
-			color.Yellow("Stopped %d connection(s) with %d error(s):", len(providers)-len(errors), len(errors))
+			color.Yellow("Stopped %d connection(s) with %d error(s):", res.Count-len(errors), len(errors))
 			for _, errMsg := range errors {
 				fmt.Printf("  - %s\n", errMsg)
 			}
 		} else {
-			color.Green("✓ Stopped all %d connection(s)", len(providers))
+			color.Green("✓ Stopped all %d connection(s)", res.Count)
 		}
 		return nil
 	}
 
 	// Stop specific provider
-	provider, err := reg.GetProvider(method)
+	var res connectResult
+	var err error
+	if client != nil {
+		err = client.Call("stop", method, &res)
+	} else {
+		res, err = disconnectProvider(method)
+	}
 	if err != nil {
-		return fmt.Errorf("provider not found: %s", method)
+		return err
 	}
+	method = res.Method
 
 	// Check if connected
-	if !provider.IsConnected() {
+	if res.Unchanged {
 		if jsonOutput {
 			output := map[string]interface{}{
 				"status":  "info",
@@ -842,17 +1654,16 @@ func stopConnection(method string) error {
 		return nil
 	}
 
-	// Disconnect
-	if err := provider.Disconnect(); err != nil {
+	if res.Error != "" {
 		if jsonOutput {
 			output := map[string]interface{}{
 				"status": "error",
-				"error":  err.Error(),
+				"error":  res.Error,
 				"method": method,
 			}
 			return printJSON(output)
 		}
-		return fmt.Errorf("failed to disconnect: %w", err)
+		return fmt.Errorf("failed to disconnect: %s", res.Error)
 	}
 
 	if jsonOutput {
@@ -875,8 +1686,9 @@ func restartConnection(method string) error {
 	// Get provider from registry
 	provider, err := reg.GetProvider(method)
 	if err != nil {
-		return fmt.Errorf("provider not found: %s", method)
+		return err
 	}
+	method = provider.Name() // resolve deprecated aliases
 
 	// Check if provider is installed
 	if !provider.IsInstalled() {
@@ -916,6 +1728,7 @@ func restartConnection(method string) error {
 	}
 
 	// Start the connection
+	useStoredCredential(provider)
 	if err := provider.Connect(); err != nil {
 		if jsonOutput {
 			output := map[string]interface{}{
@@ -974,150 +1787,170 @@ func restartConnection(method string) error {
 	if wasConnected {
 		fmt.Println()
 		fmt.Printf("Connection restarted at: %s\n",
-			color.CyanString(time.Now().Format("2006-01-02 15:04:05")))
+			color.CyanString(units.TimeExact(time.Now())))
 	}
 
 	return nil
 }
 
 func showStatus() error {
-	providers := reg.ListProviders()
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
 
-	if jsonOutput {
-		connections := []map[string]interface{}{}
-		for _, provider := range providers {
-			info := map[string]interface{}{
-				"name":      provider.Name(),
-				"category":  provider.Category(),
-				"installed": provider.IsInstalled(),
-				"connected": provider.IsConnected(),
-			}
+	// Only the daemon knows the state of the connections it holds
+	req := statusRequest{Structured: format.Structured(), Detail: statusDetail}
+	var report statusReport
+	if client := daemonClient(); client != nil {
+		if err := client.Call("status", req, &report); err != nil {
+			return err
+		}
+	} else {
+		report = collectStatus(req)
+	}
 
-			// Add connection info if connected
-			if provider.IsConnected() {
-				if connInfo, err := provider.GetConnectionInfo(); err == nil && connInfo != nil {
-					info["connection_info"] = connInfo
-				}
-			}
+	if format.Structured() {
+		return showStatusStructured(format, report)
+	}
 
-			connections = append(connections, info)
-		}
-		return printJSON(map[string]interface{}{"connections": connections})
+	if offline.Enabled() && format == output.FormatTable {
+		color.Yellow("Offline mode enabled: internet-dependent providers are unavailable")
 	}
 
-	color.Cyan("=== Tunnel Status ===")
-	fmt.Println()
+	headers := []string{"NAME", "CATEGORY", "STATUS", "DETAILS"}
+	if statusDetail {
+		headers = append(headers, "PROCESSES", "CPU", "MEMORY")
+	}
+	table := newTable(headers...)
+	table.SetColor(2, colorizeState)
+	table.SetMaxWidth(3, 48)
 
-	// Group by category
-	vpnProviders := reg.ListByCategory("vpn")
-	tunnelProviders := reg.ListByCategory("tunnel")
+	for _, row := range report.Rows {
+		table.AddRow(row...)
+	}
 
-	if len(vpnProviders) > 0 {
-		color.Cyan("VPN Providers:")
-		for _, provider := range vpnProviders {
-			displayProviderStatus(provider)
-		}
-		fmt.Println()
+	if err := renderTable(format, table); err != nil {
+		return err
+	}
+	if format != output.FormatTable {
+		return nil
+	}
+	for _, warning := range report.Warnings {
+		color.Yellow("⚠ %s", warning)
 	}
 
-	if len(tunnelProviders) > 0 {
-		color.Cyan("Tunnel Providers:")
-		for _, provider := range tunnelProviders {
-			displayProviderStatus(provider)
+	if len(report.ProviderInstances) > 0 {
+		fmt.Println()
+		fmt.Println("Provider instances:")
+		if err := renderTable(format, instancesTable(report.ProviderInstances)); err != nil {
+			return err
 		}
 	}
 
+	if statusDetail {
+		printConnectionTimelines(report.History)
+	}
+
 	return nil
 }
 
-func displayProviderStatus(provider providers.Provider) {
-	name := provider.Name()
-	installed := provider.IsInstalled()
-	connected := provider.IsConnected()
+// providerStatusRow returns the name, category, status and details columns
+// shown for a provider by `tunnel status`
+func providerStatusRow(provider providers.Provider) []string {
+	row := []string{provider.Name(), string(provider.Category())}
 
-	fmt.Printf("  %-15s: ", name)
-
-	if !installed {
-		color.Red("not installed")
-		return
+	switch {
+	case !provider.IsInstalled():
+		return append(row, "not installed", "")
+	case offline.Enabled() && providers.RequiresInternet(provider):
+		return append(row, "offline", "")
+	case !provider.IsConnected():
+		return append(row, "disconnected", "")
 	}
 
-	if connected {
-		color.Green("connected")
-		// Show connection details
-		if connInfo, err := provider.GetConnectionInfo(); err == nil && connInfo != nil {
-			if connInfo.TunnelURL != "" {
-				fmt.Printf("\n    URL: %s", color.CyanString(connInfo.TunnelURL))
-			}
-			if connInfo.LocalIP != "" {
-				fmt.Printf("\n    Local IP: %s", color.CyanString(connInfo.LocalIP))
-			}
-			if connInfo.RemoteIP != "" {
-				fmt.Printf("\n    Remote IP: %s", color.CyanString(connInfo.RemoteIP))
-			}
+	details := ""
+	if connInfo, err := provider.GetConnectionInfo(); err == nil && connInfo != nil {
+		switch {
+		case connInfo.TunnelURL != "":
+			details = connInfo.TunnelURL
+		case connInfo.LocalIP != "":
+			details = connInfo.LocalIP
+		case connInfo.RemoteIP != "":
+			details = connInfo.RemoteIP
 		}
-		fmt.Println()
-	} else {
-		color.Yellow("disconnected")
 	}
+	return append(row, "connected", details)
 }
 
-func listMethods() error {
-	providerInfo := reg.GetProviderInfo()
-
-	if jsonOutput {
-		return printJSON(map[string]interface{}{"providers": providerInfo})
+// providerResourceUsage samples the CPU and memory of a provider's processes
+// and checks them against any configured limits. It returns nil usage for
+// providers without processes of their own.
+func providerResourceUsage(provider providers.Provider) (*system.ResourceUsage, []string) {
+	patterns := providers.ProcessPatterns(provider)
+	if len(patterns) == 0 || !provider.IsInstalled() {
+		return nil, nil
 	}
 
-	color.Cyan("=== Available Tunnel Providers ===")
-	fmt.Println()
-
-	// Group by category
-	vpnProviders := []registry.ProviderInfo{}
-	tunnelProviders := []registry.ProviderInfo{}
-
-	for _, info := range providerInfo {
-		if info.Category == "vpn" {
-			vpnProviders = append(vpnProviders, info)
-		} else if info.Category == "tunnel" {
-			tunnelProviders = append(tunnelProviders, info)
+	usage, err := system.GetResourceUsage(patterns)
+	if err != nil {
+		if verbose {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to read resource usage for %s: %v\n", provider.Name(), err)
 		}
+		return nil, nil
 	}
+	usage.Violations = providers.LimitViolations(provider.Name())
 
-	if len(vpnProviders) > 0 {
-		color.Cyan("VPN Providers:")
-		for _, info := range vpnProviders {
-			displayProviderInfo(info)
+	warnings := slices.Clone(usage.Violations)
+	if appConfig != nil {
+		if limit, ok := appConfig.Monitoring.ProviderLimits[provider.Name()]; ok {
+			warnings = append(warnings, usage.CheckLimits(system.ResourceLimits{
+				MaxCPUPercent: limit.MaxCPUPercent,
+				MaxMemoryMB:   limit.MaxMemoryMB,
+				MaxOpenFiles:  limit.MaxOpenFiles,
+			})...)
 		}
-		fmt.Println()
 	}
 
-	if len(tunnelProviders) > 0 {
-		color.Cyan("Tunnel Providers:")
-		for _, info := range tunnelProviders {
-			displayProviderInfo(info)
-		}
+	return usage, warnings
+}
+
+func listMethods() error {
+	format, err := outputFormat()
+	if err != nil {
+		return err
 	}
 
-	return nil
-}
+	providerInfo := reg.GetProviderInfo()
+	sort.Slice(providerInfo, func(i, j int) bool {
+		if providerInfo[i].Category != providerInfo[j].Category {
+			return providerInfo[i].Category < providerInfo[j].Category
+		}
+		return providerInfo[i].Name < providerInfo[j].Name
+	})
 
-func displayProviderInfo(info registry.ProviderInfo) {
-	installedStatus := color.GreenString("installed")
-	if !info.Installed {
-		installedStatus = color.RedString("not installed")
+	if format.Structured() {
+		return writeOutput(format, map[string]interface{}{"providers": providerInfo})
 	}
 
-	connectedStatus := ""
-	if info.Installed {
-		if info.Connected {
-			connectedStatus = color.GreenString(" [connected]")
-		} else {
-			connectedStatus = color.YellowString(" [disconnected]")
+	table := newTable("NAME", "DISPLAY NAME", "CATEGORY", "INSTALLED", "STATE", "ALIASES")
+	table.SetColor(3, colorizeState)
+	table.SetColor(4, colorizeState)
+
+	for _, info := range providerInfo {
+		installed := "not installed"
+		state := ""
+		if info.Installed {
+			installed = "installed"
+			state = "disconnected"
+			if info.Connected {
+				state = "connected"
+			}
 		}
+		table.AddRow(info.Name, info.DisplayName, string(info.Category), installed, state, strings.Join(info.Aliases, ", "))
 	}
 
-	fmt.Printf("  %-15s - %-20s%s\n", info.Name, installedStatus, connectedStatus)
+	return renderTable(format, table)
 }
 
 // NewCredentialStore creates a credential store (helper function)
@@ -1138,6 +1971,10 @@ func getConfig(key string) error {
 		return nil
 	}
 
+	key = canonicalConfigKey(key)
+	if err := validateConfigKey(key); err != nil {
+		return err
+	}
 	value := viper.Get(key)
 	if jsonOutput {
 		return printJSON(map[string]interface{}{key: value})
@@ -1147,21 +1984,22 @@ func getConfig(key string) error {
 }
 
 func setConfig(key, value string) error {
-	viper.Set(key, value)
-
-	// Write config file
-	configFile := viper.ConfigFileUsed()
-	if configFile == "" {
-		configFile = os.ExpandEnv("$HOME/.config/tunnel/config.yaml")
-		// Create directory if needed
-		if err := os.MkdirAll(os.ExpandEnv("$HOME/.config/tunnel"), 0755); err != nil {
-			return fmt.Errorf("failed to create config directory: %w", err)
+	key = canonicalConfigKey(key)
+	if !configSetForce {
+		if err := validateConfigKey(key); err != nil {
+			return fmt.Errorf("%w; use --force to set it anyway", err)
 		}
 	}
-
-	if err := viper.WriteConfigAs(configFile); err != nil {
+	// Write through pkg/config so other keys, comments and concurrent
+	// writers are left intact
+	configFile := cfgFile
+	if configFile == "" {
+		configFile = config.DefaultPath()
+	}
+	if err := config.SetValue(configFile, key, value); err != nil {
 		return fmt.Errorf("failed to write config: %w", err)
 	}
+	viper.Set(key, value)
 
 	if jsonOutput {
 		return printJSON(map[string]interface{}{
@@ -1175,6 +2013,74 @@ func setConfig(key, value string) error {
 	return nil
 }
 
+func explainConfig(key string) error {
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+
+	key = canonicalConfigKey(key)
+	if !config.IsKey(key) {
+		return fmt.Errorf("unknown config key: %s%s", key, suggest.Hint(suggest.Closest(key, config.Keys())))
+	}
+	origin, err := appConfig.Explain(key)
+	if err != nil {
+		return err
+	}
+
+	if format.Structured() {
+		return writeOutput(format, origin)
+	}
+
+	fmt.Printf("%s = %v\n", origin.Key, origin.Value)
+	fmt.Printf("  from %s\n", describeOrigin(origin))
+	if len(origin.Shadowed) > 0 {
+		fmt.Println("  overrides:")
+		for _, o := range origin.Shadowed {
+			fmt.Printf("    %s: %v\n", describeOrigin(o), o.Value)
+		}
+	}
+	return nil
+}
+
+// describeOrigin names a config layer, with the file, variable or flag
+func describeOrigin(o config.Origin) string {
+	if o.Detail == "" {
+		return string(o.Source)
+	}
+	return fmt.Sprintf("%s (%s)", o.Source, o.Detail)
+}
+
+// canonicalConfigKey translates keys using deprecated provider names,
+// warning that the old spelling will go away
+func canonicalConfigKey(key string) string {
+	canonical, deprecated := providers.CanonicalConfigKey(key)
+	if deprecated {
+		providers.WarnDeprecated("config key", key, canonical)
+	}
+	return canonical
+}
+
+// validateConfigKey rejects keys that nothing reads, suggesting the closest
+// known keys. Known keys are those of the config file schema plus every key
+// viper has a default or value for.
+func validateConfigKey(key string) error {
+	if config.IsKey(key) {
+		return nil
+	}
+
+	lower := strings.ToLower(key)
+	known := config.Keys()
+	for _, k := range viper.AllKeys() {
+		if k == lower || strings.HasPrefix(k, lower+".") {
+			return nil
+		}
+		known = append(known, k)
+	}
+
+	return fmt.Errorf("unknown config key %q%s", key, suggest.Hint(suggest.Closest(key, known)))
+}
+
 func editConfig() error {
 	editor := os.Getenv("EDITOR")
 	if editor == "" {
@@ -1210,8 +2116,9 @@ func authLogin(method string) error {
 	// Get provider from registry
 	provider, err := reg.GetProvider(method)
 	if err != nil {
-		return fmt.Errorf("provider not found: %s", method)
+		return err
 	}
+	method = provider.Name() // resolve deprecated aliases
 
 	// Check if installed
 	if !provider.IsInstalled() {
@@ -1288,8 +2195,9 @@ func setAPIKey(method string) error {
 	// Check if provider exists
 	provider, err := reg.GetProvider(method)
 	if err != nil {
-		return fmt.Errorf("provider not found: %s", method)
+		return err
 	}
+	method = provider.Name() // resolve deprecated aliases
 
 	// Check if installed
 	if !provider.IsInstalled() {
@@ -1305,21 +2213,9 @@ func setAPIKey(method string) error {
 	color.Cyan("=== Set API Key for %s ===", method)
 	fmt.Println()
 
-	// Get home directory for credential storage
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return fmt.Errorf("failed to get home directory: %w", err)
-	}
-
-	// Create credential store
-	credStore, err := NewCredentialStore(
-		"file",
-		"tunnel",
-		filepath.Join(homeDir, ".config", "tunnel", "credentials"),
-		"tunnel-credentials",
-	)
+	store, err := credentialStore()
 	if err != nil {
-		return fmt.Errorf("failed to create credential store: %w", err)
+		return fmt.Errorf("failed to open credential store: %w", err)
 	}
 
 	// Read API key from stdin
@@ -1347,7 +2243,7 @@ func setAPIKey(method string) error {
 	}
 
 	// Store the API key securely
-	if err := credStore.Set(method, "api_key", []byte(apiKey)); err != nil {
+	if err := store.Set(method, core.ProviderCredential, []byte(apiKey)); err != nil {
 		if jsonOutput {
 			output := map[string]interface{}{
 				"status": "error",
@@ -1370,8 +2266,7 @@ func setAPIKey(method string) error {
 
 	color.Green("✓ API key stored securely")
 	fmt.Printf("  Provider: %s\n", method)
-	fmt.Printf("  Location: %s\n",
-		color.CyanString(filepath.Join(homeDir, ".config", "tunnel", "credentials")))
+	fmt.Printf("  Location: %s\n", color.CyanString(describeCredentialStore(store)))
 
 	// Show next steps
 	fmt.Println()
@@ -1391,7 +2286,7 @@ func validateAPIKey(method, apiKey string) error {
 		if len(apiKey) < 20 {
 			return fmt.Errorf("ngrok auth token seems too short (expected 20+ characters)")
 		}
-	case "cloudflare", "cloudflared":
+	case "cloudflare":
 		// Cloudflare tunnel tokens are base64-encoded and quite long
 		if len(apiKey) < 32 {
 			return fmt.Errorf("cloudflare tunnel token seems too short")
@@ -1527,6 +2422,7 @@ type providerAdapter struct {
 		Connect() error
 		Disconnect() error
 		IsConnected() bool
+		GetConnectionInfo() (*providers.ConnectionInfo, error)
 	}
 }
 
@@ -1536,18 +2432,20 @@ func (p *providerAdapter) Name() string {
 
 func (p *providerAdapter) Connect(ctx context.Context, config *core.Config) (*core.Connection, error) {
 	// Use the provider's Connect method
+	if full, ok := p.provider.(providers.Provider); ok {
+		useStoredCredential(full)
+	}
 	if err := p.provider.Connect(); err != nil {
 		return nil, err
 	}
 
-	// Create a connection object
+	// Create a connection object; the endpoint comes from the provider
 	conn := core.NewConnection(
 		fmt.Sprintf("%s-%d", p.provider.Name(), os.Getpid()),
 		p.provider.Name(),
-		0, // localPort - not used for most providers
-		"", // remoteHost
-		0,  // remotePort
+		0, "", 0,
 	)
+	p.refresh(conn)
 	conn.SetState(core.StateConnected)
 
 	return conn, nil
@@ -1558,68 +2456,215 @@ func (p *providerAdapter) Disconnect(conn *core.Connection) error {
 }
 
 func (p *providerAdapter) IsHealthy(conn *core.Connection) bool {
-	return p.provider.IsConnected()
+	if !p.provider.IsConnected() {
+		conn.SetState(core.StateFailed)
+		return false
+	}
+	conn.SetState(core.StateConnected)
+	// Health checks run periodically, so they also keep the endpoint
+	// current when a provider is handed a new address
+	p.refresh(conn)
+	return true
+}
+
+// MeasureLatency reports the round trip the provider's health check
+// measures, for the providers whose check measures one
+func (p *providerAdapter) MeasureLatency(ctx context.Context, conn *core.Connection) (time.Duration, error) {
+	provider, ok := p.provider.(providers.Provider)
+	if !ok {
+		return 0, fmt.Errorf("%s cannot measure latency", p.provider.Name())
+	}
+	health, err := provider.HealthCheck()
+	if err != nil {
+		return 0, err
+	}
+	if health == nil || health.Latency <= 0 {
+		return 0, fmt.Errorf("%s health check measured no latency", provider.Name())
+	}
+	return health.Latency, nil
+}
+
+// refresh copies the provider's current ports, remote host and URL onto conn
+func (p *providerAdapter) refresh(conn *core.Connection) {
+	info, err := p.provider.GetConnectionInfo()
+	if err != nil || info == nil {
+		return
+	}
+	conn.SetEndpoint(info.LocalPort, info.RemoteIP, info.RemotePort, info.TunnelURL)
+}
+
+// watchProviderRegistry keeps the connection manager in step with providers
+// registered or removed at runtime, e.g. after a plugin is installed
+func watchProviderRegistry() {
+	changes, stop := reg.Watch()
+	onShutdown("provider registry watcher", func(context.Context) error {
+		stop()
+		return nil
+	})
+
+	go func() {
+		for change := range changes {
+			switch change.Type {
+			case registry.ChangeRegistered:
+				provider, err := reg.GetProvider(change.Name)
+				if err != nil {
+					continue
+				}
+				manager.RegisterProvider(&providerAdapter{provider: provider})
+
+			case registry.ChangeUnregistered:
+				if err := manager.UnregisterProvider(change.Name); err != nil && verbose {
+					fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+				}
+			}
+		}
+	}()
+}
+
+// completeProviderNames offers the currently registered provider names for
+// shell completion of the first argument
+func completeProviderNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	r := reg
+	if r == nil {
+		r = registry.NewRegistry()
+	}
+
+	var names []string
+	for _, provider := range r.ListProviders() {
+		if strings.HasPrefix(provider.Name(), toComplete) {
+			names = append(names, provider.Name())
+		}
+	}
+	sort.Strings(names)
+
+	if cmd.Name() == "stop" && strings.HasPrefix("all", toComplete) {
+		names = append(names, "all")
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
 }
 
 // Keys management functions
 
+// keySources lists the values accepted by keys list --source
+var keySources = []string{core.KeySourceManual, core.KeySourceGitHub, core.KeySourceGitLab, core.KeySourceURL, core.KeySourceFile, core.KeySourceLDAP, core.KeySourceRotation}
+
+// requireKnownKeyUser fails for a user the key store holds no keys for,
+// suggesting similar names. Stores that do not record owners accept any
+// user.
+func requireKnownKeyUser(user string) error {
+	if !keyManager.RecordsOwners() {
+		return nil
+	}
+
+	keys, err := keyManager.QueryKeys(core.KeyQuery{})
+	if err != nil {
+		return fmt.Errorf("failed to list keys: %w", err)
+	}
+
+	var users []string
+	for _, key := range keys {
+		if key.User == user {
+			return nil
+		}
+		users = append(users, key.User)
+	}
+	return fmt.Errorf("no keys for user %q%s", user, suggest.Hint(suggest.Closest(user, users)))
+}
+
 func listKeys(user string) error {
 	if keyManager == nil {
 		return fmt.Errorf("key manager not initialized")
 	}
 
-	keys, err := keyManager.ListKeys(user)
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+
+	if (keysListSource != "" || keysListOlderThan != "") && !keyManager.RecordsOwners() {
+		return fmt.Errorf("--source and --older-than need recorded key owners: the sqlite or ldap key store (ssh.key_store), or keys added with tunnel")
+	}
+	if keysListSource != "" && !slices.Contains(keySources, keysListSource) {
+		return fmt.Errorf("unknown key source %q (valid: %s)%s", keysListSource,
+			strings.Join(keySources, ", "), suggest.Hint(suggest.Closest(keysListSource, keySources)))
+	}
+	if user != "" {
+		if err := requireKnownKeyUser(user); err != nil {
+			return err
+		}
+	}
+
+	query := core.KeyQuery{Source: keysListSource}
+	if keyManager.RecordsOwners() {
+		query.User = user
+	}
+	if keysListOlderThan != "" {
+		age, err := core.ParseAlertDuration(keysListOlderThan)
+		if err != nil {
+			return fmt.Errorf("invalid --older-than: %w", err)
+		}
+		query.AddedBefore = time.Now().Add(-age)
+	}
+
+	keys, err := keyManager.QueryKeys(query)
 	if err != nil {
 		return fmt.Errorf("failed to list keys: %w", err)
 	}
 
-	if jsonOutput {
-		output := map[string]interface{}{
+	if format.Structured() {
+		result := map[string]interface{}{
 			"count": len(keys),
 			"keys":  keys,
 		}
 		if user != "" {
-			output["user"] = user
+			result["user"] = user
 		}
-		return printJSON(output)
+		return writeOutput(format, result)
 	}
 
 	// Terminal output
-	if len(keys) == 0 {
+	if len(keys) == 0 && format == output.FormatTable {
 		color.Yellow("No SSH keys found")
 		return nil
 	}
 
-	color.Cyan("=== SSH Public Keys ===")
-	if user != "" {
-		fmt.Printf("User: %s\n", color.GreenString(user))
-	}
-	fmt.Printf("Total: %s\n\n", color.GreenString("%d", len(keys)))
+	table := newTable("#", "TYPE", "FINGERPRINT", "COMMENT", "STATUS", "ADDED", "LAST USED", "EXPIRES", "RESTRICTIONS", "NOTE")
+	table.SetMaxWidth(3, 32)
+	table.SetMaxWidth(8, 40)
+	table.SetMaxWidth(9, 40)
+	table.SetColor(4, colorizeStatus)
 
 	for i, key := range keys {
-		fmt.Printf("%s. %s\n", color.CyanString("%d", i+1), color.GreenString(key.Type))
-		fmt.Printf("   Fingerprint: %s\n", key.Fingerprint)
-		if key.Comment != "" {
-			fmt.Printf("   Comment:     %s\n", key.Comment)
-		}
-		fmt.Printf("   Status:      %s\n", colorizeStatus(key.Status))
-		fmt.Printf("   Added:       %s\n", key.AddedAt.Format("2006-01-02 15:04:05"))
+		lastUsed := ""
 		if !key.LastUsed.IsZero() {
-			fmt.Printf("   Last Used:   %s\n", key.LastUsed.Format("2006-01-02 15:04:05"))
+			lastUsed = units.Time(key.LastUsed)
 		}
+		expires := ""
 		if key.ExpiresAt != nil {
-			fmt.Printf("   Expires:     %s\n", key.ExpiresAt.Format("2006-01-02 15:04:05"))
+			expires = units.Time(*key.ExpiresAt)
 		}
-		fmt.Println()
+		table.AddRow(strconv.Itoa(i+1), key.Type, key.Fingerprint, key.Comment, key.Status,
+			units.Time(key.AddedAt), lastUsed, expires, key.Restrictions.String(), key.Note)
 	}
 
-	return nil
+	return renderTable(format, table)
 }
 
-func addKey(user string) error {
+func addKey(cmd *cobra.Command, user string) error {
 	if keyManager == nil {
 		return fmt.Errorf("key manager not initialized")
 	}
+	var expiresAt time.Time
+	if keysAddExpires != "" {
+		var err error
+		if expiresAt, err = parseKeyExpiry(keysAddExpires, time.Now()); err != nil {
+			return err
+		}
+	}
 
 	color.Cyan("Add SSH Public Key for %s", user)
 	fmt.Println("Paste your SSH public key (press Enter when done):")
@@ -1642,6 +2687,28 @@ func addKey(user string) error {
 		return fmt.Errorf("invalid SSH key: %w", err)
 	}
 
+	// The flags override what options the pasted line had
+	restrictions := key.Restrictions
+	flags := cmd.Flags()
+	if flags.Changed("from") {
+		restrictions.From = keysAddFrom
+	}
+	if flags.Changed("command") {
+		restrictions.Command = keysAddCommand
+	}
+	restrictions.NoPortForwarding = restrictions.NoPortForwarding || keysAddNoPortForwarding
+	restrictions.NoPTY = restrictions.NoPTY || keysAddNoPTY
+	restrictions.NoX11Forwarding = restrictions.NoX11Forwarding || keysAddNoX11Forwarding
+	restricted, err := core.WithRestrictions(*key, restrictions)
+	if err != nil {
+		return fmt.Errorf("invalid key restrictions: %w", err)
+	}
+	key = &restricted
+	if !expiresAt.IsZero() {
+		expiring := core.WithExpiry(*key, expiresAt)
+		key = &expiring
+	}
+
 	// Add the key
 	if err := keyManager.AddKey(user, *key); err != nil {
 		if jsonOutput {
@@ -1654,6 +2721,8 @@ func addKey(user string) error {
 		}
 		return fmt.Errorf("failed to add key: %w", err)
 	}
+	notifyKeyChange(fmt.Sprintf("SSH key added for %s", user),
+		fmt.Sprintf("%s key %s was authorized", key.Type, key.Fingerprint))
 
 	if jsonOutput {
 		output := map[string]interface{}{
@@ -1662,6 +2731,12 @@ func addKey(user string) error {
 			"fingerprint": key.Fingerprint,
 			"type":        key.Type,
 		}
+		if !key.Restrictions.IsZero() {
+			output["restrictions"] = key.Restrictions.String()
+		}
+		if key.ExpiresAt != nil {
+			output["expires_at"] = key.ExpiresAt
+		}
 		return printJSON(output)
 	}
 
@@ -1671,15 +2746,36 @@ func addKey(user string) error {
 	if key.Comment != "" {
 		fmt.Printf("  Comment:     %s\n", key.Comment)
 	}
+	if !key.Restrictions.IsZero() {
+		fmt.Printf("  Restricted:  %s\n", key.Restrictions)
+	}
+	if key.ExpiresAt != nil {
+		fmt.Printf("  Expires:     %s\n", units.Time(*key.ExpiresAt))
+	}
 
 	return nil
 }
 
+// parseKeyExpiry reads an --expires value: a duration from now or a date
+func parseKeyExpiry(value string, now time.Time) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	if d, err := core.ParseAlertDuration(value); err == nil && d > 0 {
+		return now.Add(d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --expires %q: use a duration such as 30d or a date", value)
+}
+
 func rotateKey(user, keyID string) error {
 	if keyManager == nil {
 		return fmt.Errorf("key manager not initialized")
 	}
 
+	if err := requireKnownKeyUser(user); err != nil {
+		return err
+	}
+
 	if keyID == "" {
 		// Rotate all keys for user
 		color.Yellow("Key rotation for all keys is not yet implemented")
@@ -1719,6 +2815,8 @@ func rotateKey(user, keyID string) error {
 	if err := keyManager.AddKey(user, *newKey); err != nil {
 		return fmt.Errorf("failed to add new key: %w", err)
 	}
+	notifyKeyChange(fmt.Sprintf("SSH key rotated for %s", user),
+		fmt.Sprintf("Key %s was replaced by %s key %s", keyID, newKey.Type, newKey.Fingerprint))
 
 	if jsonOutput {
 		output := map[string]interface{}{
@@ -1739,15 +2837,29 @@ func rotateKey(user, keyID string) error {
 	return nil
 }
 
-func revokeKey(user, keyID string) error {
+func revokeKey(user, keyID string, propagate bool) error {
 	if keyManager == nil {
 		return fmt.Errorf("key manager not initialized")
 	}
 
+	if err := requireKnownKeyUser(user); err != nil {
+		return err
+	}
+
 	if verbose {
 		fmt.Printf("Revoking key %s for user %s\n", keyID, user)
 	}
 
+	// Other nodes know the key by its fingerprint, not by its local ID
+	fingerprint := keyID
+	if keys, err := keyManager.ListKeys(user); err == nil {
+		for _, key := range keys {
+			if key.ID == keyID {
+				fingerprint = key.Fingerprint
+			}
+		}
+	}
+
 	// Remove the key
 	if err := keyManager.RemoveKey(user, keyID); err != nil {
 		if jsonOutput {
@@ -1761,6 +2873,13 @@ func revokeKey(user, keyID string) error {
 		}
 		return fmt.Errorf("failed to revoke key: %w", err)
 	}
+	notifyKeyChange(fmt.Sprintf("SSH key revoked for %s", user), fmt.Sprintf("Key %s was revoked", keyID))
+
+	var fleetResults []fleet.RevokeResult
+	var fleetErr error
+	if propagate {
+		fleetResults, fleetErr = revokeOnFleet(fleet.RevokeRequest{User: user, Fingerprints: []string{fingerprint}}, "")
+	}
 
 	if jsonOutput {
 		output := map[string]interface{}{
@@ -1768,6 +2887,12 @@ func revokeKey(user, keyID string) error {
 			"user":   user,
 			"key_id": keyID,
 		}
+		if propagate {
+			output["fleet"] = fleetResults
+			if fleetErr != nil {
+				output["fleet_error"] = fleetErr.Error()
+			}
+		}
 		return printJSON(output)
 	}
 
@@ -1775,6 +2900,14 @@ func revokeKey(user, keyID string) error {
 	fmt.Printf("  User:   %s\n", user)
 	fmt.Printf("  Key ID: %s\n", keyID)
 
+	if fleetErr != nil {
+		return fmt.Errorf("key revoked here but not on the fleet: %w", fleetErr)
+	}
+	if propagate {
+		if failed := printFleetResults(fleetResults); failed > 0 {
+			return fmt.Errorf("revocation failed on %d of %d fleet node(s)", failed, len(fleetResults))
+		}
+	}
 	return nil
 }
 
@@ -1833,6 +2966,10 @@ func importGitLabKeys(gitlabUser string) error {
 		return fmt.Errorf("key manager not initialized")
 	}
 
+	if err := offline.Check("GitLab key import"); err != nil {
+		return err
+	}
+
 	color.Cyan("Importing SSH keys from GitLab user: %s", gitlabUser)
 
 	// GitLab API endpoint for user's SSH keys
@@ -1945,7 +3082,7 @@ func colorizeStatus(status string) string {
 }
 
 // emergencyRevoke revokes all SSH keys for a user in an emergency situation
-func emergencyRevoke(username, reason string, killSessions, notify, force bool) error {
+func emergencyRevoke(username, reason string, killSessions, notify, force, propagate bool) error {
 	// Validate inputs
 	if username == "" {
 		return fmt.Errorf("username cannot be empty")
@@ -1959,13 +3096,13 @@ func emergencyRevoke(username, reason string, killSessions, notify, force bool)
 		return fmt.Errorf("key manager not initialized")
 	}
 
-	// Get all keys for the user
-	keys, err := keyManager.ListKeys(username)
+	// Get all keys for the user; ListKeys would return everyone's
+	keys, err := keyManager.QueryKeys(core.KeyQuery{User: username})
 	if err != nil {
 		return fmt.Errorf("failed to list keys: %w", err)
 	}
 
-	if len(keys) == 0 {
+	if len(keys) == 0 && !propagate {
 		if jsonOutput {
 			return printJSON(map[string]interface{}{
 				"status":  "info",
@@ -1989,6 +3126,9 @@ func emergencyRevoke(username, reason string, killSessions, notify, force bool)
 		if notify {
 			fmt.Println("Notifications will be sent.")
 		}
+		if propagate {
+			color.Red("The user's keys will be revoked on all %d fleet node(s) too!", len(appConfig.Fleet.Nodes))
+		}
 
 		fmt.Print("\nType 'yes' to confirm: ")
 		var confirmation string
@@ -2019,29 +3159,70 @@ func emergencyRevoke(username, reason string, killSessions, notify, force bool)
 		}
 	}
 
-	// Kill active sessions if requested
+	// Kill active sessions if requested. The keys are gone by now, so a
+	// killed session can't log straight back in.
 	sessionsKilled := 0
+	var sessionResults []core.SessionKill
+	sessionFailures := []string{}
 	if killSessions {
-		// Note: This is a placeholder for session killing logic
-		// In a real implementation, this would use 'pkill' or similar
-		if verbose && !jsonOutput {
-			color.Yellow("Session killing not yet implemented (placeholder)")
+		fingerprints := make([]string, len(keys))
+		for i, key := range keys {
+			fingerprints[i] = key.Fingerprint
+		}
+		sessionResults, err = core.NewSessionTracker().KillUserSessions(username, fingerprints)
+		if err != nil {
+			sessionFailures = append(sessionFailures, err.Error())
+		}
+		for _, r := range sessionResults {
+			if r.Killed {
+				sessionsKilled++
+				if verbose && !jsonOutput {
+					fmt.Printf("Killed session from %s (pid %d)\n", r.Session.SourceIP, r.PID)
+				}
+			} else {
+				sessionFailures = append(sessionFailures, fmt.Sprintf("%s (pid %d): %s", r.Session.SourceIP, r.PID, r.Error))
+			}
+		}
+	}
+
+	// Revoke every key of the user on the other nodes, whichever keys
+	// they hold, so a compromised key is gone everywhere
+	fleetResults := []fleet.RevokeResult{}
+	fleetFailures := []string{}
+	if propagate {
+		results, err := revokeOnFleet(fleet.RevokeRequest{User: username, Reason: reason}, "")
+		if err != nil {
+			fleetFailures = append(fleetFailures, err.Error())
+		} else {
+			fleetResults = results
+		}
+		for _, res := range fleetResults {
+			if !res.OK() {
+				fleetFailures = append(fleetFailures, fmt.Sprintf("%s: %s", res.Node, res.Error))
+			}
 		}
 	}
+	success := len(failedKeys) == 0 && len(sessionFailures) == 0 && len(fleetFailures) == 0
 
 	// Send notification if requested
+	notified := false
 	if notify {
-		// Note: This is a placeholder for notification logic
-		// In a real implementation, this would send email, Slack, etc.
-		if verbose && !jsonOutput {
-			fmt.Printf("Notification logged: Emergency key revocation for %s\n", username)
+		message := fmt.Sprintf("%d of %d key(s) revoked. Reason: %s", revokedCount, len(keys), reason)
+		if killSessions {
+			message += fmt.Sprintf("\n%d session(s) killed", sessionsKilled)
+		}
+		if propagate {
+			message += fmt.Sprintf("\nRevoked on %d of %d fleet node(s)", len(fleetResults)-countFailedNodes(fleetResults), len(appConfig.Fleet.Nodes))
+		}
+		notified = notifyKeyChange(fmt.Sprintf("Emergency revocation for %s", username), message)
+		if !notified && !jsonOutput {
+			color.Yellow("No notification channels configured; set notifications.email, slack or webhooks in the config")
 		}
 	}
 
 	// Log audit event
 	homeDir, _ := os.UserHomeDir()
-	auditLogPath := filepath.Join(homeDir, ".config", "tunnel", "audit.log")
-	auditLogger, err := core.NewAuditLogger(auditLogPath, false, "")
+	auditLogger, err := core.NewAuditLogger(auditLogPath(homeDir), false, "")
 	if err != nil {
 		if verbose {
 			fmt.Fprintf(os.Stderr, "Warning: Failed to initialize audit logger: %v\n", err)
@@ -2060,11 +3241,17 @@ func emergencyRevoke(username, reason string, killSessions, notify, force bool)
 				"keys_failed":     len(failedKeys),
 				"total_keys":      len(keys),
 				"kill_sessions":   killSessions,
+				"sessions_found":  len(sessionResults),
 				"sessions_killed": sessionsKilled,
+				"sessions":        sessionResults,
+				"session_errors":  sessionFailures,
 				"notify":          notify,
+				"notified":        notified,
 				"forced":          force,
+				"fleet":           fleetResults,
+				"fleet_errors":    fleetFailures,
 			},
-			Success: len(failedKeys) == 0,
+			Success: success,
 		})
 	}
 
@@ -2079,15 +3266,21 @@ func emergencyRevoke(username, reason string, killSessions, notify, force bool)
 			"total_keys":      len(keys),
 			"failed_keys":     failedKeys,
 			"kill_sessions":   killSessions,
+			"sessions_found":  len(sessionResults),
 			"sessions_killed": sessionsKilled,
+			"sessions":        sessionResults,
+			"session_errors":  sessionFailures,
 			"notify":          notify,
-			"success":         len(failedKeys) == 0,
+			"notified":        notified,
+			"fleet":           fleetResults,
+			"fleet_errors":    fleetFailures,
+			"success":         success,
 		})
 	}
 
 	// Display summary
 	fmt.Println()
-	if len(failedKeys) == 0 {
+	if success {
 		color.Green("✓ Emergency revocation completed successfully")
 	} else {
 		color.Yellow("⚠ Emergency revocation completed with errors")
@@ -2105,10 +3298,24 @@ func emergencyRevoke(username, reason string, killSessions, notify, force bool)
 	}
 
 	if killSessions {
-		fmt.Printf("Sessions killed: %d\n", sessionsKilled)
+		fmt.Printf("Sessions killed: %s\n", color.GreenString("%d/%d", sessionsKilled, len(sessionResults)))
+		if len(sessionFailures) > 0 {
+			color.Red("\nFailed to kill %d session(s):", len(sessionFailures))
+			for _, failure := range sessionFailures {
+				fmt.Printf("  - %s\n", failure)
+			}
+		}
 	}
 
-	if notify {
+	if propagate {
+		if len(fleetResults) > 0 {
+			printFleetResults(fleetResults)
+		} else {
+			color.Red("\nNot revoked on the fleet: %s", strings.Join(fleetFailures, "; "))
+		}
+	}
+
+	if notified {
 		fmt.Println("\nNotifications sent: Yes")
 	}
 
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/daemon"
+	"github.com/jedarden/tunnel/internal/instance"
+	"github.com/jedarden/tunnel/internal/logging"
+	"github.com/jedarden/tunnel/internal/providers"
+	"github.com/spf13/cobra"
+)
+
+var (
+	daemonDetach bool
+	daemonSystem bool
+	daemonHealth string
+)
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Keep connections up in a background process",
+	Long: `Run the connection manager as a long-lived process so connections outlive
+the command that started them.
+
+While the daemon is running, tunnel start, tunnel stop and tunnel status
+hand their work to it over a control socket (~/.config/tunnel/daemon.sock)
+that only your user can open. The daemon also serves the web UI and API on
+127.0.0.1 at --port, and the TUI attaches to it.
+
+The daemon runs in the foreground, which suits systemd and other
+supervisors; --detach starts it in the background instead, logging to
+~/.config/tunnel/daemon.log. Stopping the daemon stops its connections.
+
+--system runs one daemon for every user of the host, listening on
+/run/tunnel/daemon.sock, normally as root under systemd. Each request is
+scoped by the OS user that made it, per the rbac section of the config:
+users see and control only the instances they started (scope own), all of
+them (scope all, which may also stop the daemon), or nothing (none). root
+always has all. The web API can't tell local users apart, so in this mode
+it answers only requests carrying the API token (TUNNEL_API_TOKEN).
+
+For load balancers and uptime monitors, the daemon can answer HTTP probes
+on an address of its own (health.listen in the config, or --health):
+/healthz is 200 while the process is up, /readyz while at least
+health.min_tunnels tunnels are established. Both are 503 otherwise, and
+from the moment the daemon starts shutting down.
+
+A watchdog checks the daemon's failover health loop, event queue and the
+supervisors of its tunnels, and restarts whichever is stuck. If that
+doesn't help (watchdog.max_restarts within ten minutes), the daemon shuts
+down, keeping the record of its tunnels, and starts again with --restore.`,
+	Example: `  # Start the daemon in the background
+  tunnel daemon --detach
+
+  # Connections now survive the CLI
+  tunnel start bore
+  tunnel status
+
+  # Stop the daemon and its connections
+  tunnel daemon stop
+
+  # One daemon for the whole host
+  sudo tunnel daemon --system
+
+  # Answer health probes on port 8081
+  tunnel daemon --health 0.0.0.0:8081
+
+  # After a reboot, bring back the tunnels that were up
+  tunnel daemon --detach --restore`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if daemonDetach {
+			return detachDaemon()
+		}
+		return runDaemon(cmd.Context())
+	},
+}
+
+var daemonStopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stop the daemon and its connections",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return stopDaemon()
+	},
+}
+
+func init() {
+	daemonCmd.Flags().BoolVar(&daemonDetach, "detach", false, "start the daemon in the background and return once it is listening")
+	daemonCmd.Flags().BoolVar(&daemonSystem, "system", false, "serve every user of this host, scoped by the rbac config")
+	daemonCmd.Flags().StringVar(&daemonHealth, "health", "", "address to answer /healthz and /readyz on (default health.listen from the config)")
+	daemonCmd.AddCommand(daemonStopCmd)
+}
+
+// daemonDir holds the daemon's socket and log
+func daemonDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".config", "tunnel"), nil
+}
+
+// daemonSocket returns where this daemon listens: the user's socket, or
+// the system-wide one with --system
+func daemonSocket() (string, error) {
+	if daemonSystem {
+		return systemSocketPath, nil
+	}
+	dir, err := daemonDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "daemon.sock"), nil
+}
+
+// daemonClient returns a client for the running daemon, or nil when there
+// is none and commands should act on this process's providers. The user's
+// own daemon is preferred over a system-wide one.
+func daemonClient() *daemon.Client {
+	paths := []string{systemSocketPath}
+	if dir, err := daemonDir(); err == nil {
+		paths = append([]string{filepath.Join(dir, "daemon.sock")}, paths...)
+	}
+	for _, path := range paths {
+		if client := daemon.NewClient(path); client.Running() {
+			return client
+		}
+	}
+	return nil
+}
+
+// runDaemon serves the control socket and web server until ctx is done or
+// a client asks the daemon to stop
+func runDaemon(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	holder, err := acquireInstanceLock("daemon")
+	if errors.Is(err, instance.ErrRunning) {
+		return fmt.Errorf("tunnel is already running (pid %d, %s); stop it before starting the daemon", holder.PID, holder.Command)
+	}
+	if err != nil {
+		return err
+	}
+
+	server := daemon.NewServer()
+	server.Logger = log.Default()
+
+	path, err := daemonSocket()
+	if err != nil {
+		return err
+	}
+	var ln net.Listener
+	if daemonSystem {
+		if !daemon.SupportsPeerCredentials() {
+			return fmt.Errorf("--system needs peer credentials on the control socket, which this platform lacks")
+		}
+		server.Policy = rbacPolicy(appConfig)
+		ln, err = daemon.ListenShared(path)
+	} else {
+		ln, err = daemon.Listen(path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open control socket: %w", err)
+	}
+
+	healthAddr := appConfig.Health.Listen
+	if daemonHealth != "" {
+		healthAddr = daemonHealth
+	}
+	var healthLn net.Listener
+	if healthAddr != "" {
+		if healthLn, err = net.Listen("tcp", healthAddr); err != nil {
+			ln.Close()
+			return fmt.Errorf("failed to listen for health probes: %w", err)
+		}
+	}
+
+	// Relays of bandwidth limited tunnels close after the connections
+	startThrottles(ctx)
+
+	// Connections end with the daemon; steps run in reverse, so this
+	// happens after the web server has drained
+	onShutdown("connections", func(context.Context) error {
+		res := disconnectAll()
+		if len(res.Errors) > 0 {
+			return fmt.Errorf("%v", res.Errors)
+		}
+		return nil
+	})
+
+	handleDaemonOps(server, cancel)
+	handleForwardOps(server)
+	handleInstanceOps(server)
+	handleCaptureOp(server)
+	handlePromptOp(ctx, server)
+
+	startBackgroundJobs(ctx)
+
+	startWatchdog(ctx, cancel)
+
+	go func() {
+		for _, err := range autostartForwards(appConfig) {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}()
+
+	served := make(chan error, 3)
+	go func() {
+		served <- server.Serve(ctx, ln)
+	}()
+	if healthLn != nil {
+		health := daemon.NewHealth(appConfig.Health.MinTunnels, connectedTunnels)
+		go func() {
+			served <- health.Serve(ctx, healthLn)
+		}()
+	}
+	go func() {
+		addr := fmt.Sprintf("127.0.0.1:%d", webPort)
+		opts := webOptions{addr: addr}
+		if daemonSystem {
+			token, err := apiToken()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			}
+			opts.shared, opts.token = true, token
+		}
+		served <- startWebServer(ctx, nil, nil, nil, opts)
+	}()
+
+	color.Green("✓ Daemon listening on %s (pid %d)", ln.Addr(), os.Getpid())
+	if healthLn != nil {
+		fmt.Printf("  Health probes: http://%s/healthz, /readyz\n", healthLn.Addr())
+	}
+	if restoreState {
+		// Through our own socket, so restored tunnels are owned like any other
+		go func() {
+			if err := restoreConnections(); err != nil {
+				logging.For("daemon").Error("failed to restore connections", "error", err)
+			}
+		}()
+	}
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// handleDaemonOps registers the operations the CLI hands to the daemon.
+// Each is limited to the instances the caller may see; stop ends the
+// daemon.
+func handleDaemonOps(server *daemon.Server, stop context.CancelFunc) {
+	server.Handle("start", func(ctx context.Context, args json.RawMessage) (any, error) {
+		var method string
+		if err := json.Unmarshal(args, &method); err != nil {
+			return nil, err
+		}
+		caller := daemon.CallerFrom(ctx)
+		if err := checkControl(caller, method); err != nil {
+			return nil, err
+		}
+		res, err := connectProvider(method)
+		if err == nil && res.Error == "" && !res.Unchanged {
+			owners.record(res.Method, caller)
+		}
+		return res, err
+	})
+	server.Handle("stop", func(ctx context.Context, args json.RawMessage) (any, error) {
+		var method string
+		if err := json.Unmarshal(args, &method); err != nil {
+			return nil, err
+		}
+		if err := checkControl(daemon.CallerFrom(ctx), method); err != nil {
+			return nil, err
+		}
+		res, err := disconnectProvider(method)
+		if err == nil && res.Error == "" {
+			owners.release(res.Method)
+		}
+		return res, err
+	})
+	server.Handle("stop-all", func(ctx context.Context, args json.RawMessage) (any, error) {
+		caller := daemon.CallerFrom(ctx)
+		res := disconnectWhere(func(name string) bool { return canControl(caller, name, true) })
+		for _, name := range res.Stopped {
+			owners.release(name)
+		}
+		forgetConnections(res.Stopped...)
+		return res, nil
+	})
+	server.Handle("status", func(ctx context.Context, args json.RawMessage) (any, error) {
+		var req statusRequest
+		if err := json.Unmarshal(args, &req); err != nil {
+			return nil, err
+		}
+		report := collectStatus(req)
+		scopeStatus(&report, daemon.CallerFrom(ctx))
+		return report, nil
+	})
+	server.HandleAdmin("shutdown", func(ctx context.Context, args json.RawMessage) (any, error) {
+		// Answer before the socket closes under us
+		time.AfterFunc(100*time.Millisecond, stop)
+		return os.Getpid(), nil
+	})
+}
+
+// detachDaemon starts the daemon as a background process and waits for
+// its control socket to come up
+func detachDaemon() error {
+	path, err := daemonSocket()
+	if err != nil {
+		return err
+	}
+	client := daemon.NewClient(path)
+	if client.Running() {
+		return fmt.Errorf("the daemon is already running")
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the tunnel executable: %w", err)
+	}
+	dir, err := daemonDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	logPath := filepath.Join(dir, "daemon.log")
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open daemon log: %w", err)
+	}
+	defer logFile.Close()
+
+	args := []string{"daemon", "--port", strconv.Itoa(webPort)}
+	if cfgFile != "" {
+		args = append(args, "--config", cfgFile)
+	}
+	if verbose {
+		args = append(args, "--verbose")
+	}
+	if offlineMode {
+		args = append(args, "--offline")
+	}
+	if daemonSystem {
+		args = append(args, "--system")
+	}
+	if daemonHealth != "" {
+		args = append(args, "--health", daemonHealth)
+	}
+	if restoreState {
+		args = append(args, "--restore")
+	}
+
+	cmd := exec.Command(exe, args...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	detachProcess(cmd)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start daemon: %w", err)
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	deadline := time.After(15 * time.Second)
+	for {
+		select {
+		case err := <-exited:
+			return fmt.Errorf("daemon exited during startup (%v); see %s", err, logPath)
+		case <-deadline:
+			return fmt.Errorf("daemon did not start listening within 15s; see %s", logPath)
+		case <-time.After(100 * time.Millisecond):
+		}
+		if client.Running() {
+			color.Green("✓ Daemon started (pid %d)", cmd.Process.Pid)
+			fmt.Printf("  Log: %s\n", logPath)
+			return nil
+		}
+	}
+}
+
+// stopDaemon asks the running daemon to shut down and waits for it to go
+func stopDaemon() error {
+	client := daemonClient()
+	if client == nil {
+		color.Yellow("The daemon is not running")
+		return nil
+	}
+
+	var pid int
+	if err := client.Call("shutdown", nil, &pid); err != nil {
+		return fmt.Errorf("failed to stop daemon: %w", err)
+	}
+
+	deadline := time.Now().Add(shutdownTimeout() + 5*time.Second)
+	for runningInstance() != nil {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("daemon (pid %d) is still shutting down", pid)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	color.Green("✓ Stopped daemon (pid %d)", pid)
+	return nil
+}
+
+// connectResult is the outcome of starting or stopping one provider
+type connectResult struct {
+	Method    string                    `json:"method"`              // canonical provider name
+	Unchanged bool                      `json:"unchanged,omitempty"` // already connected, or already stopped
+	Error     string                    `json:"error,omitempty"`
+	Info      *providers.ConnectionInfo `json:"info,omitempty"`
+}
+
+// stopAllResult is the outcome of stopping every connected provider
+type stopAllResult struct {
+	Count   int      `json:"count"`
+	Stopped []string `json:"stopped,omitempty"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+// connectProvider starts method's provider in this process
+func connectProvider(method string) (connectResult, error) {
+	provider, err := reg.GetProvider(method)
+	if err != nil {
+		return connectResult{}, err
+	}
+	res := connectResult{Method: provider.Name()} // resolve deprecated aliases
+
+	if provider.IsConnected() {
+		res.Unchanged = true
+		return res, nil
+	}
+	useStoredCredential(provider)
+	if err := provider.Connect(); err != nil {
+		res.Error = err.Error()
+		return res, nil
+	}
+	recordConnection(res.Method)
+	if info, err := provider.GetConnectionInfo(); err == nil {
+		res.Info = info
+	}
+	return res, nil
+}
+
+// disconnectProvider stops method's provider in this process
+func disconnectProvider(method string) (connectResult, error) {
+	provider, err := reg.GetProvider(method)
+	if err != nil {
+		return connectResult{}, err
+	}
+	res := connectResult{Method: provider.Name()}
+
+	if !provider.IsConnected() {
+		res.Unchanged = true
+		forgetConnections(res.Method)
+		return res, nil
+	}
+	if err := provider.Disconnect(); err != nil {
+		res.Error = err.Error()
+		return res, nil
+	}
+	forgetConnections(res.Method)
+	return res, nil
+}
+
+// connectedTunnels names the providers connected in this process
+func connectedTunnels() []string {
+	var names []string
+	for _, provider := range reg.GetConnectedProviders() {
+		names = append(names, provider.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// disconnectAll stops every connected provider in this process
+func disconnectAll() stopAllResult {
+	return disconnectWhere(nil)
+}
+
+// disconnectWhere stops the connected providers in this process that
+// include accepts, or all of them when include is nil
+func disconnectWhere(include func(name string) bool) stopAllResult {
+	var connected []providers.Provider
+	for _, provider := range reg.GetConnectedProviders() {
+		if include == nil || include(provider.Name()) {
+			connected = append(connected, provider)
+		}
+	}
+	res := stopAllResult{Count: len(connected)}
+	for _, provider := range connected {
+		if err := provider.Disconnect(); err != nil {
+			res.Errors = append(res.Errors, fmt.Sprintf("%s: %v", provider.Name(), err))
+		} else {
+			res.Stopped = append(res.Stopped, provider.Name())
+		}
+	}
+	return res
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/daemon"
+	"github.com/jedarden/tunnel/pkg/config"
+)
+
+// systemSocketPath is where a system-wide daemon listens for every user
+const systemSocketPath = "/run/tunnel/daemon.sock"
+
+// instanceOwner is the user who started a provider through the daemon
+type instanceOwner struct {
+	UID   int       `json:"uid"`
+	User  string    `json:"user"`
+	Since time.Time `json:"since"`
+}
+
+// ownerTable records who started each provider the daemon holds
+type ownerTable struct {
+	mu     sync.Mutex
+	owners map[string]instanceOwner
+}
+
+// owners is the daemon's record of instance ownership, by provider name
+var owners = &ownerTable{owners: make(map[string]instanceOwner)}
+
+func (t *ownerTable) record(name string, caller daemon.Caller) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.owners[name] = instanceOwner{UID: caller.Peer.UID, User: caller.Peer.User, Since: time.Now()}
+}
+
+func (t *ownerTable) release(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.owners, name)
+}
+
+// get returns who started name. Instances not started through the daemon,
+// such as those the web API started, belong to the daemon's own user.
+func (t *ownerTable) get(name string) (instanceOwner, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	owner, ok := t.owners[name]
+	if !ok {
+		owner.UID = os.Getuid()
+	}
+	return owner, ok
+}
+
+// canControl reports whether caller may see and control the provider
+// called name. A provider that isn't connected is no one's instance.
+func canControl(caller daemon.Caller, name string, connected bool) bool {
+	if !connected {
+		return true
+	}
+	owner, _ := owners.get(name)
+	return caller.CanSee(owner.UID)
+}
+
+// checkControl refuses a start or stop of method's provider when another
+// user's instance of it is running and the caller can't see it
+func checkControl(caller daemon.Caller, method string) error {
+	provider, err := reg.GetProvider(method)
+	if err != nil {
+		return nil // reported by the operation itself
+	}
+	if canControl(caller, provider.Name(), provider.IsConnected()) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s is running for another user", daemon.ErrForbidden, provider.Name())
+}
+
+// canSeeDaemonOwned reports whether caller may see what the daemon holds
+// for itself rather than for a caller, such as its forwards and provider
+// instances. Those belong to the daemon's own user.
+func canSeeDaemonOwned(caller daemon.Caller) bool {
+	return caller.CanSee(os.Getuid())
+}
+
+// hiddenProviders returns the connected providers the caller can't see
+func hiddenProviders(caller daemon.Caller) map[string]bool {
+	hidden := make(map[string]bool)
+	if caller.Scope == daemon.ScopeAll {
+		return hidden
+	}
+	for _, provider := range reg.GetConnectedProviders() {
+		if !canControl(caller, provider.Name(), true) {
+			hidden[provider.Name()] = true
+		}
+	}
+	return hidden
+}
+
+// scopeStatus removes from report the instances the caller can't see and
+// notes the owner of those it can
+func scopeStatus(report *statusReport, caller daemon.Caller) {
+	hidden := hiddenProviders(caller)
+
+	rows := report.Rows[:0]
+	for _, row := range report.Rows {
+		if !hidden[row[0]] {
+			rows = append(rows, row)
+		}
+	}
+	report.Rows = rows
+
+	history := report.History[:0]
+	for _, status := range report.History {
+		if !hidden[status.Method] {
+			history = append(history, status)
+		}
+	}
+	report.History = history
+
+	if !canSeeDaemonOwned(caller) {
+		report.ProviderInstances = nil
+	}
+
+	connections := report.Connections[:0]
+	for _, info := range report.Connections {
+		name, _ := info["name"].(string)
+		if hidden[name] {
+			continue
+		}
+		if owner, ok := owners.get(name); ok {
+			if connected, _ := info["connected"].(bool); connected {
+				info["owner"] = owner
+			}
+		}
+		connections = append(connections, info)
+	}
+	if report.Connections != nil {
+		report.Connections = connections
+	}
+}
+
+// scopePrompt removes from state the connections the caller can't see.
+// Everything in the prompt cache is connected, so no provider is asked.
+func scopePrompt(state promptState, caller daemon.Caller) promptState {
+	if caller.Scope == daemon.ScopeAll {
+		return state
+	}
+	visible := []promptConnection{}
+	for _, conn := range state.Connections {
+		if canControl(caller, conn.Name, true) {
+			visible = append(visible, conn)
+		}
+	}
+	state.Connections = visible
+	return state
+}
+
+// rbacPolicy converts the rbac section of the config into a daemon policy.
+// The config has been validated, so every scope parses.
+func rbacPolicy(cfg *config.Config) *daemon.Policy {
+	policy := &daemon.Policy{
+		Users:  make(map[string]daemon.Scope),
+		Groups: make(map[string]daemon.Scope),
+	}
+	if cfg == nil {
+		return policy
+	}
+	policy.Default = daemon.Scope(cfg.RBAC.Default)
+	for name, scope := range cfg.RBAC.Users {
+		policy.Users[name] = daemon.Scope(scope)
+	}
+	for name, scope := range cfg.RBAC.Groups {
+		policy.Groups[name] = daemon.Scope(scope)
+	}
+	return policy
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/daemon"
+	"github.com/jedarden/tunnel/internal/units"
+	"github.com/spf13/cobra"
+)
+
+// defaultCaptureDuration is how long a capture runs unless told otherwise
+const defaultCaptureDuration = time.Minute
+
+var (
+	captureDuration time.Duration
+	captureMaxMB    int
+	captureStop     bool
+)
+
+var debugCmd = &cobra.Command{
+	Use:   "debug",
+	Short: "Tools for debugging tunnels",
+}
+
+var debugCaptureCmd = &cobra.Command{
+	Use:   "capture <connection>",
+	Short: "Record a tunnel's traffic to a pcap file",
+	Long: `Record the traffic a tunnel carries to and from its local service to a
+pcap file for Wireshark or tcpdump, for a while and up to a size limit.
+The connection is a provider name or connection ID.
+
+The daemon records what passes through the relay it puts in front of the
+tunnel's local port, so the provider needs one: set capture: true (or a
+bandwidth limit) for it in the providers section of the config, and start
+the tunnel through the daemon. bore, ngrok and reverse-ssh can be relayed.
+Captures are written to ~/.config/tunnel/debug. They hold whatever the
+tunnel carries, so handle them like the credentials that may be in them.
+
+The TUI toggles a capture of every relayed tunnel with c.`,
+	Example: `  tunnel debug capture bore --duration 60s
+  tunnel debug capture ngrok --max-size 4
+  tunnel debug capture bore --stop`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCapture(args[0], captureDuration, captureMaxMB, captureStop)
+	},
+}
+
+func init() {
+	debugCaptureCmd.Flags().DurationVar(&captureDuration, "duration", defaultCaptureDuration, "how long to capture")
+	debugCaptureCmd.Flags().IntVar(&captureMaxMB, "max-size", core.DefaultCaptureLimit>>20, "stop recording once the file reaches this many MiB")
+	debugCaptureCmd.Flags().BoolVar(&captureStop, "stop", false, "end the running capture now")
+
+	debugCmd.AddCommand(debugCaptureCmd)
+}
+
+// captureRequest asks the daemon to start or stop a capture
+type captureRequest struct {
+	Target   string        `json:"target"`
+	Duration time.Duration `json:"duration"`
+	MaxBytes int64         `json:"max_bytes"`
+	Stop     bool          `json:"stop"`
+}
+
+// captureResult is a capture as started or stopped
+type captureResult struct {
+	Provider string             `json:"provider"`
+	Capture  core.CaptureStatus `json:"capture"`
+	Until    time.Time          `json:"until,omitempty"`
+	Stopped  bool               `json:"stopped"`
+}
+
+// captureTimers end running captures after their duration, by provider
+var (
+	captureMu     sync.Mutex
+	captureTimers = make(map[string]*time.Timer)
+)
+
+func runCapture(target string, duration time.Duration, maxMB int, stop bool) error {
+	if duration <= 0 {
+		return fmt.Errorf("invalid --duration %s: it must be positive", duration)
+	}
+	if maxMB <= 0 {
+		return fmt.Errorf("invalid --max-size %d: it must be positive", maxMB)
+	}
+	client := daemonClient()
+	if client == nil {
+		return fmt.Errorf("no daemon is running; captures are recorded by the daemon's relay, start it with tunnel daemon --detach")
+	}
+
+	var res captureResult
+	req := captureRequest{Target: target, Duration: duration, MaxBytes: int64(maxMB) << 20, Stop: stop}
+	if err := client.Call("capture", req, &res); err != nil {
+		return err
+	}
+	if jsonOutput {
+		return printJSON(res)
+	}
+	if res.Stopped {
+		color.Green("✓ Stopped capturing %s: %s", res.Provider, describeCapture(res.Capture))
+		return nil
+	}
+	color.Green("✓ Capturing %s until %s", res.Provider, res.Until.Format("15:04:05"))
+	fmt.Printf("  File: %s\n", res.Capture.Path)
+	return nil
+}
+
+// handleCaptureOp registers the daemon's capture operation. Captures may
+// hold anything a tunnel carries, so only admins may start them.
+func handleCaptureOp(server *daemon.Server) {
+	server.HandleAdmin("capture", func(ctx context.Context, args json.RawMessage) (any, error) {
+		var req captureRequest
+		if err := json.Unmarshal(args, &req); err != nil {
+			return nil, err
+		}
+		if req.Stop {
+			return stopCapture(req.Target)
+		}
+		return startCapture(req.Target, req.Duration, req.MaxBytes)
+	})
+}
+
+// captureThrottle finds the relay of target, a provider name or
+// connection ID
+func captureThrottle(target string) (*core.Throttle, error) {
+	if th, ok := throttles[target]; ok {
+		return th, nil
+	}
+	if manager != nil {
+		if conn, err := manager.Status(target); err == nil {
+			if th, ok := throttles[conn.Method]; ok {
+				return th, nil
+			}
+			target = conn.Method
+		}
+	}
+	return nil, fmt.Errorf("%s is not relayed; set capture: true for it in the providers section of the config and restart the daemon", target)
+}
+
+// startCapture records the traffic of target's relay to a new file in the
+// debug directory for duration
+func startCapture(target string, duration time.Duration, maxBytes int64) (captureResult, error) {
+	th, err := captureThrottle(target)
+	if err != nil {
+		return captureResult{}, err
+	}
+	if th.Status().Capture != nil {
+		return captureResult{}, fmt.Errorf("%w for %s; end it with tunnel debug capture %s --stop", core.ErrCaptureRunning, th.Name, th.Name)
+	}
+	if duration <= 0 {
+		duration = defaultCaptureDuration
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return captureResult{}, fmt.Errorf("failed to get home directory: %w", err)
+	}
+	dir := debugDir(homeDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return captureResult{}, fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s.pcap", th.Name, time.Now().Format("20060102-150405")))
+	c, err := core.NewCapture(path, maxBytes)
+	if err != nil {
+		return captureResult{}, err
+	}
+	if err := th.StartCapture(c); err != nil {
+		c.Close()
+		os.Remove(path)
+		return captureResult{}, err
+	}
+
+	captureMu.Lock()
+	captureTimers[th.Name] = time.AfterFunc(duration, func() {
+		stopCapture(th.Name)
+	})
+	captureMu.Unlock()
+
+	return captureResult{Provider: th.Name, Capture: c.Status(), Until: time.Now().Add(duration)}, nil
+}
+
+// stopCapture ends the capture of target's relay and closes its file
+func stopCapture(target string) (captureResult, error) {
+	th, err := captureThrottle(target)
+	if err != nil {
+		return captureResult{}, err
+	}
+
+	captureMu.Lock()
+	if timer := captureTimers[th.Name]; timer != nil {
+		timer.Stop()
+		delete(captureTimers, th.Name)
+	}
+	captureMu.Unlock()
+
+	c := th.StopCapture()
+	if c == nil {
+		return captureResult{}, fmt.Errorf("no capture of %s is running", th.Name)
+	}
+	if err := c.Close(); err != nil {
+		return captureResult{}, err
+	}
+	return captureResult{Provider: th.Name, Capture: c.Status(), Stopped: true}, nil
+}
+
+// toggleTUICapture stops the running captures, or starts one of every
+// relayed tunnel when none is running, and describes what it did
+func toggleTUICapture() (string, error) {
+	var running, names []string
+	for name, th := range throttles {
+		names = append(names, name)
+		if th.Status().Capture != nil {
+			running = append(running, name)
+		}
+	}
+	if len(names) == 0 {
+		return "", fmt.Errorf("no tunnel is relayed; set capture: true for a provider in the config")
+	}
+	sort.Strings(names)
+
+	if len(running) > 0 {
+		sort.Strings(running)
+		var stopped []string
+		for _, name := range running {
+			res, err := stopCapture(name)
+			if err != nil {
+				return "", err
+			}
+			stopped = append(stopped, res.Capture.Path)
+		}
+		return "Capture saved to " + strings.Join(stopped, ", "), nil
+	}
+
+	for _, name := range names {
+		if _, err := startCapture(name, defaultCaptureDuration, 0); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("Capturing %s for %s", strings.Join(names, ", "), units.Duration(defaultCaptureDuration)), nil
+}
+
+// debugDir is where captures are kept
+func debugDir(homeDir string) string {
+	return filepath.Join(homeDir, ".config", "tunnel", "debug")
+}
+
+// describeCapture summarizes a finished capture in one line
+func describeCapture(c core.CaptureStatus) string {
+	s := fmt.Sprintf("%d packets, %s in %s", c.Packets, units.Bytes(c.Bytes), c.Path)
+	if c.Truncated {
+		s += " (stopped at the size limit)"
+	}
+	return s
+}
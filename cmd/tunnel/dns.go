@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/dnsproxy"
+	"github.com/jedarden/tunnel/pkg/config"
+	"github.com/jedarden/tunnel/pkg/tunnel"
+	"github.com/spf13/cobra"
+)
+
+// dnsSyncInterval is how often the running instance looks for a connected VPN
+const dnsSyncInterval = 10 * time.Second
+
+var dnsCmd = &cobra.Command{
+	Use:   "dns",
+	Short: "Manage DNS over tunnel",
+	Long: `When "dns.enabled" is set in the config, the running instance forwards DNS
+queries through the resolvers of a connected VPN (Tailscale MagicDNS or the DNS
+servers of a WireGuard interface) and points resolv.conf at the forwarder. The
+original resolv.conf is restored when the VPN disconnects or tunnel exits.`,
+}
+
+var dnsRestoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Restore the original resolv.conf",
+	Long: `Put back the resolv.conf saved by the DNS forwarder. Only needed if tunnel was
+killed before it could restore it itself.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return restoreResolvConf()
+	},
+}
+
+func init() {
+	dnsCmd.AddCommand(dnsRestoreCmd)
+}
+
+// startDNS runs the DNS forwarder for as long as a VPN with its own resolvers
+// is connected
+func startDNS(ctx context.Context, cfg *config.Config, r *tunnel.Registry) {
+	if cfg == nil || !cfg.DNS.Enabled {
+		return
+	}
+
+	var resolv *dnsproxy.ResolvConf
+	if cfg.DNS.ManageResolvConf {
+		resolv = dnsproxy.NewResolvConf(cfg.DNS.ResolvConf)
+	}
+
+	m := dnsproxy.NewManager(cfg.DNS.Listen, resolv, r.ListProviders, log.Default())
+	onShutdown("dns forwarder", m.Close)
+	go m.Run(ctx, dnsSyncInterval)
+}
+
+func restoreResolvConf() error {
+	path := ""
+	if appConfig != nil {
+		path = appConfig.DNS.ResolvConf
+	}
+
+	resolv := dnsproxy.NewResolvConf(path)
+	if !resolv.Managed() {
+		color.Yellow("%s is not managed by tunnel", resolv.Path)
+		return nil
+	}
+	if err := resolv.Restore(); err != nil {
+		return err
+	}
+	color.Green("✓ Restored %s", resolv.Path)
+	return nil
+}
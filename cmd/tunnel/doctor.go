@@ -1,367 +1,236 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"net"
-	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"runtime"
-	"time"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/doctor"
+	"github.com/jedarden/tunnel/internal/providers"
+	"github.com/jedarden/tunnel/pkg/config"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 var doctorCmd = &cobra.Command{
 	Use:   "doctor",
 	Short: "Diagnose and fix common issues",
-	Long:  `Run diagnostics to check for common issues and suggest fixes.`,
+	Long: `Run diagnostics to check for common issues and suggest fixes.
+
+  config      the config file parses, is valid and only you can change it
+  providers   the client of every provider is installed, and its version
+  auth        installed providers are logged in to their service
+  network     the internet and the endpoints of providers in use resolve
+  ports       nothing else holds the addresses tunnel listens on, and the
+              ports providers expose are served
+  ssh         sshd answers, the SFTP policy is enforced, and authorized_keys
+              has permissions sshd accepts
+
+Problems with enabled methods are failures; with providers that are only
+installed, warnings. Use --json for a structured report.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return runDoctor()
 	},
 }
 
-type checkResult struct {
-	name    string
-	status  string // "pass", "warn", "fail"
-	message string
-	fix     string // suggested fix
-}
-
 func runDoctor() error {
-	results := []checkResult{}
-
-	color.Cyan("=== TUNNEL Doctor ===")
-	fmt.Println()
-	color.White("Running diagnostics...\n")
-
-	// Check 1: Configuration file
-	results = append(results, checkConfigFile())
-
-	// Check 2: Provider binaries
-	results = append(results, checkProviderBinaries()...)
-
-	// Check 3: Network connectivity
-	results = append(results, checkNetworkConnectivity())
-
-	// Check 4: SSH server
-	results = append(results, checkSSHServer())
-
-	// Check 5: Port availability
-	results = append(results, checkPortAvailability())
-
-	// Check 6: Permissions
-	results = append(results, checkPermissions())
-
-	// Check 7: System requirements
-	results = append(results, checkSystemRequirements())
-
-	// Print results
-	fmt.Println()
-	color.Cyan("=== Diagnostic Results ===")
-	fmt.Println()
-
-	passCount := 0
-	warnCount := 0
-	failCount := 0
-
-	for _, result := range results {
-		var statusColor func(format string, a ...interface{}) string
-		var icon string
-
-		switch result.status {
-		case "pass":
-			statusColor = color.GreenString
-			icon = "✓"
-			passCount++
-		case "warn":
-			statusColor = color.YellowString
-			icon = "⚠"
-			warnCount++
-		case "fail":
-			statusColor = color.RedString
-			icon = "✗"
-			failCount++
-		}
-
-		fmt.Printf("%s %s: %s\n", statusColor(icon), result.name, result.message)
-		if result.fix != "" && result.status != "pass" {
-			color.White("  Fix: %s\n", result.fix)
-		}
+	format, err := outputFormat()
+	if err != nil {
+		return err
 	}
-
-	// Summary
-	fmt.Println()
-	color.Cyan("=== Summary ===")
-	fmt.Printf("Passed: %s  Warnings: %s  Failed: %s\n",
-		color.GreenString("%d", passCount),
-		color.YellowString("%d", warnCount),
-		color.RedString("%d", failCount))
-
-	if failCount > 0 {
+	if !format.Structured() {
+		color.Cyan("=== TUNNEL Doctor ===")
 		fmt.Println()
-		color.Red("Some checks failed. Please address the issues above.")
-		return nil // Don't exit with error, just inform
+		color.White("Running diagnostics...\n")
 	}
 
-	if warnCount > 0 {
-		fmt.Println()
-		color.Yellow("Some checks have warnings. TUNNEL should work but may have limited functionality.")
-		return nil
+	report := doctor.Run(context.Background(), doctorChecks(), doctor.DefaultTimeout)
+	if format.Structured() {
+		return writeOutput(format, report)
 	}
-
-	fmt.Println()
-	color.Green("All checks passed! TUNNEL is ready to use.")
-	return nil
+	printDoctorReport(report)
+	return nil // Don't exit with error, just inform
 }
 
-func checkConfigFile() checkResult {
-	configFile := viper.ConfigFileUsed()
+// doctorChecks lists the diagnostics for this installation
+func doctorChecks() []doctor.Check {
+	targets := doctorProviders()
+	homeDir, _ := os.UserHomeDir()
 
-	if configFile == "" {
-		return checkResult{
-			name:    "Configuration File",
-			status:  "warn",
-			message: "No config file found, using defaults",
-			fix:     "Run 'tunnel config edit' to create a configuration file",
-		}
+	sshPort := appConfig.SSH.Port
+	if sshPort == 0 {
+		sshPort = 22
 	}
-
-	if _, err := os.Stat(configFile); os.IsNotExist(err) {
-		return checkResult{
-			name:    "Configuration File",
-			status:  "warn",
-			message: "Config file does not exist",
-			fix:     "Run 'tunnel config edit' to create a configuration file",
-		}
+	authorizedKeys := filepath.Join(homeDir, ".ssh", "authorized_keys")
+	if configured := appConfig.SSH.AuthorizedKeys; configured != "" {
+		authorizedKeys = expandHome(configured, homeDir)
 	}
 
-	return checkResult{
-		name:    "Configuration File",
-		status:  "pass",
-		message: fmt.Sprintf("Found at %s", configFile),
+	return []doctor.Check{
+		doctor.Config(doctorConfigPath()),
+		doctor.Binaries(targets),
+		doctor.Auth(targets, checkAuthStatus),
+		doctor.Connectivity(),
+		doctor.DNS(targets),
+		doctor.Ports(doctorListeners(), doctorExposedPorts(targets), daemonClient() != nil),
+		doctor.SSHServer(sshPort),
+		{Category: "ssh", Run: func(context.Context) []doctor.Result { return []doctor.Result{checkSFTPPolicy()} }},
+		doctor.AuthorizedKeys(authorizedKeys),
+		doctor.System(),
 	}
 }
 
-func checkProviderBinaries() []checkResult {
-	providers := []struct {
-		name       string
-		binary     string
-		configKey  string
-		required   bool
-		installCmd string
-	}{
-		{
-			name:       "Cloudflare Tunnel",
-			binary:     "cloudflared",
-			configKey:  "providers.cloudflared.binary_path",
-			required:   false,
-			installCmd: "Visit https://developers.cloudflare.com/cloudflare-one/connections/connect-apps/install-and-setup/installation/",
-		},
-		{
-			name:       "ngrok",
-			binary:     "ngrok",
-			configKey:  "providers.ngrok.binary_path",
-			required:   false,
-			installCmd: "Visit https://ngrok.com/download or run: snap install ngrok (Linux)",
-		},
-		{
-			name:       "Tailscale",
-			binary:     "tailscale",
-			configKey:  "providers.tailscale.binary_path",
-			required:   false,
-			installCmd: "Visit https://tailscale.com/download or run: curl -fsSL https://tailscale.com/install.sh | sh",
-		},
-		{
-			name:       "bore",
-			binary:     "bore",
-			configKey:  "providers.bore.binary_path",
-			required:   false,
-			installCmd: "Run: cargo install bore-cli (requires Rust)",
-		},
+// doctorConfigPath returns the config file this run uses, even if it
+// failed to load
+func doctorConfigPath() string {
+	switch {
+	case appConfig.Path() != "":
+		return appConfig.Path()
+	case cfgFile != "":
+		return cfgFile
+	default:
+		return config.DefaultPath()
 	}
+}
 
-	results := []checkResult{}
-
-	for _, provider := range providers {
-		binaryPath := viper.GetString(provider.configKey)
-		if binaryPath == "" {
-			binaryPath = provider.binary
-		}
-
-		path, err := exec.LookPath(binaryPath)
-		if err != nil {
-			status := "warn"
-			if provider.required {
-				status = "fail"
-			}
-			results = append(results, checkResult{
-				name:    provider.name,
-				status:  status,
-				message: fmt.Sprintf("Binary '%s' not found in PATH", binaryPath),
-				fix:     provider.installCmd,
-			})
-			continue
-		}
-
-		results = append(results, checkResult{
-			name:    provider.name,
-			status:  "pass",
-			message: fmt.Sprintf("Found at %s", path),
+// doctorProviders returns every registered provider, marking those the
+// config enables as a method or defines as a script
+func doctorProviders() []doctor.Provider {
+	list := reg.ListProviders()
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+
+	targets := make([]doctor.Provider, 0, len(list))
+	for _, p := range list {
+		_, script := appConfig.Scripts[p.Name()]
+		targets = append(targets, doctor.Provider{
+			Provider: p,
+			Enabled:  appConfig.Methods[p.Name()].Enabled || script,
 		})
 	}
-
-	return results
+	return targets
 }
 
-func checkNetworkConnectivity() checkResult {
-	client := &http.Client{
-		Timeout: 5 * time.Second,
+// doctorListeners lists the addresses tunnel listens on with this config
+func doctorListeners() []doctor.Listener {
+	listeners := []doctor.Listener{
+		{Owner: "Web server", Address: ":" + strconv.Itoa(webPort), Fallback: true},
 	}
-
-	// Try to connect to a reliable endpoint
-	resp, err := client.Get("https://www.cloudflare.com")
-	if err != nil {
-		return checkResult{
-			name:    "Internet Connectivity",
-			status:  "fail",
-			message: fmt.Sprintf("Cannot reach internet: %v", err),
-			fix:     "Check your internet connection and firewall settings",
-		}
+	for i, w := range appConfig.Wake {
+		owner := fmt.Sprintf("Wake listener %d (%s)", i+1, w.Provider)
+		listeners = append(listeners, doctor.Listener{Owner: owner, Address: w.Listen})
 	}
-	defer resp.Body.Close()
-
-	return checkResult{
-		name:    "Internet Connectivity",
-		status:  "pass",
-		message: "Internet is reachable",
+	if appConfig.DNS.Enabled && appConfig.DNS.Listen != "" {
+		listeners = append(listeners, doctor.Listener{Owner: "DNS forwarder", Network: "udp", Address: appConfig.DNS.Listen})
 	}
+	return listeners
 }
 
-func checkSSHServer() checkResult {
-	port := viper.GetInt("ssh.port")
-	if port == 0 {
-		port = 22
-	}
-
-	// Try to connect to local SSH server
-	conn, err := net.DialTimeout("tcp", fmt.Sprintf("localhost:%d", port), 2*time.Second)
-	if err != nil {
-		return checkResult{
-			name:    "SSH Server",
-			status:  "warn",
-			message: fmt.Sprintf("SSH server not running on port %d", port),
-			fix:     "Install and start SSH server: sudo apt-get install openssh-server && sudo systemctl start ssh",
+// doctorExposedPorts returns the local ports enabled providers expose,
+// according to their connect plans
+func doctorExposedPorts(targets []doctor.Provider) []doctor.Exposed {
+	byPort := make(map[int][]string)
+	for _, t := range targets {
+		if !t.Enabled {
+			continue
 		}
-	}
-	defer conn.Close()
-
-	return checkResult{
-		name:    "SSH Server",
-		status:  "pass",
-		message: fmt.Sprintf("SSH server is running on port %d", port),
-	}
-}
-
-func checkPortAvailability() checkResult {
-	port := viper.GetInt("ssh.port")
-	if port == 0 {
-		port = 22
-	}
-
-	// Check if we can bind to the port (if SSH is not running)
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
-	if err != nil {
-		// Port is in use, which is good if SSH is running
-		return checkResult{
-			name:    "Port Availability",
-			status:  "pass",
-			message: fmt.Sprintf("Port %d is in use (likely by SSH server)", port),
+		plan, err := providers.PlanFor(t.Provider, providers.ActionConnect)
+		if err != nil {
+			continue
+		}
+		for _, p := range plan.Ports {
+			if p.Side == "local" && p.Protocol == "tcp" {
+				byPort[p.Port] = append(byPort[p.Port], t.Name())
+			}
 		}
 	}
-	listener.Close()
 
-	return checkResult{
-		name:    "Port Availability",
-		status:  "warn",
-		message: fmt.Sprintf("Port %d is available but nothing is listening", port),
-		fix:     "Make sure SSH server is configured to listen on this port",
+	exposed := make([]doctor.Exposed, 0, len(byPort))
+	for port, names := range byPort {
+		exposed = append(exposed, doctor.Exposed{Provider: strings.Join(names, ", "), Port: port})
 	}
+	sort.Slice(exposed, func(i, j int) bool { return exposed[i].Port < exposed[j].Port })
+	return exposed
 }
 
-func checkPermissions() checkResult {
-	// Check if we can write to config directory
-	configDir := filepath.Dir(viper.ConfigFileUsed())
-	if configDir == "" || configDir == "." {
-		configDir = os.ExpandEnv("$HOME/.config/tunnel")
-	}
+// checkSFTPPolicy compares the configured SFTP policy with what the
+// running sshd enforces
+func checkSFTPPolicy() doctor.Result {
+	result := doctor.Result{Name: "SFTP policy"}
+	if _, err := exec.LookPath("sshd"); err != nil {
+		result.Status = doctor.StatusSkip
+		result.Message = "sshd not found, SFTP policy not checked"
+		return result
+	}
+
+	problems, err := sftpPolicy(appConfig).Verify()
+	switch {
+	case err != nil:
+		result.Status = doctor.StatusWarn
+		result.Message = "Could not read the effective sshd configuration"
+		result.Fix = "Run tunnel doctor as root to check the SFTP policy"
+	case len(problems) > 0:
+		result.Status = doctor.StatusFail
+		result.Message = strings.Join(problems, "; ")
+		result.Fix = "Run: sudo tunnel sftp apply --reload"
+	default:
+		result.Status = doctor.StatusPass
+		result.Message = "sshd enforces the configured SFTP policy"
+	}
+	return result
+}
 
-	// Try to create directory
-	if err := os.MkdirAll(configDir, 0755); err != nil {
-		return checkResult{
-			name:    "File Permissions",
-			status:  "fail",
-			message: fmt.Sprintf("Cannot create config directory: %v", err),
-			fix:     fmt.Sprintf("Check permissions for %s", configDir),
+func printDoctorReport(report *doctor.Report) {
+	color.Cyan("=== Diagnostic Results ===")
+	for _, category := range report.Categories() {
+		fmt.Println()
+		title := strings.ToUpper(category[:1]) + category[1:]
+		if category == "ssh" {
+			title = "SSH"
 		}
-	}
-
-	// Try to create a test file
-	testFile := filepath.Join(configDir, ".test")
-	if err := os.WriteFile(testFile, []byte("test"), 0644); err != nil {
-		return checkResult{
-			name:    "File Permissions",
-			status:  "fail",
-			message: fmt.Sprintf("Cannot write to config directory: %v", err),
-			fix:     fmt.Sprintf("Check write permissions for %s", configDir),
+		color.White("%s", title)
+		for _, result := range report.Results {
+			if result.Category != category {
+				continue
+			}
+			var icon string
+			switch result.Status {
+			case doctor.StatusPass:
+				icon = color.GreenString("✓")
+			case doctor.StatusWarn:
+				icon = color.YellowString("⚠")
+			case doctor.StatusFail:
+				icon = color.RedString("✗")
+			default:
+				icon = color.HiBlackString("-")
+			}
+			fmt.Printf("  %s %s: %s\n", icon, result.Name, result.Message)
+			if result.Fix != "" && result.Status != doctor.StatusPass {
+				color.White("    Fix: %s", result.Fix)
+			}
 		}
 	}
-	os.Remove(testFile)
-
-	return checkResult{
-		name:    "File Permissions",
-		status:  "pass",
-		message: "Can read and write to config directory",
-	}
-}
-
-func checkSystemRequirements() checkResult {
-	// Check OS
-	osInfo := fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH)
-
-	// Check Go version (embedded in binary)
-	goVersion := runtime.Version()
 
-	// Check if running in container
-	inContainer := false
-	if _, err := os.Stat("/.dockerenv"); err == nil {
-		inContainer = true
-	}
-
-	containerInfo := ""
-	if inContainer {
-		containerInfo = " (running in container)"
-	}
-
-	message := fmt.Sprintf("%s, Go %s%s", osInfo, goVersion, containerInfo)
-
-	// Warn if not on supported OS
-	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
-		return checkResult{
-			name:    "System Requirements",
-			status:  "warn",
-			message: message,
-			fix:     "TUNNEL is primarily tested on Linux and macOS",
-		}
-	}
+	// Summary
+	fmt.Println()
+	color.Cyan("=== Summary ===")
+	fmt.Printf("Passed: %s  Warnings: %s  Failed: %s  Skipped: %d\n",
+		color.GreenString("%d", report.Summary.Pass),
+		color.YellowString("%d", report.Summary.Warn),
+		color.RedString("%d", report.Summary.Fail),
+		report.Summary.Skip)
 
-	return checkResult{
-		name:    "System Requirements",
-		status:  "pass",
-		message: message,
+	fmt.Println()
+	switch {
+	case report.Summary.Fail > 0:
+		color.Red("Some checks failed. Please address the issues above.")
+	case report.Summary.Warn > 0:
+		color.Yellow("Some checks have warnings. TUNNEL should work but may have limited functionality.")
+	default:
+		color.Green("All checks passed! TUNNEL is ready to use.")
 	}
 }
-
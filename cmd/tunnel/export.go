@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/exporter"
+	"github.com/jedarden/tunnel/internal/providers"
+	"github.com/spf13/cobra"
+)
+
+var (
+	exportImage string
+	exportFile  string
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export <systemd|compose> <method>",
+	Short: "Export a configured tunnel as a systemd unit or docker-compose service",
+	Long: `Generate a standalone definition of a configured provider or script for a
+machine where tunnel itself won't run.
+
+  systemd   a service unit running the provider's client, restarted if it
+            fails; providers that only run commands to connect, such as
+            tailscale, become a oneshot unit that stays active
+  compose   a docker-compose service running the client in its published
+            image on the host's network
+
+The commands are the ones tunnel start would run, including command
+templates from the config. Secrets are never written into the definition:
+it reads them from an environment variable, named in the notes printed
+with it. Commands that can't be exported, such as those tunnel only runs
+under a condition, are listed in the notes too.
+
+The definition is printed unless --file is given.`,
+	Example: `  tunnel export systemd cloudflare > /etc/systemd/system/tunnel-cloudflare.service
+  tunnel export compose ngrok --file docker-compose.yml
+  tunnel export compose reverse-ssh --image ghcr.io/example/ssh-client`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		kind, err := exporter.ParseKind(args[0])
+		if err != nil {
+			return err
+		}
+		return runExport(kind, args[1])
+	},
+}
+
+func init() {
+	exportCmd.Flags().StringVar(&exportImage, "image", "", "compose: image to run the client in, for clients without a published one")
+	exportCmd.Flags().StringVar(&exportFile, "file", "", "write the definition to this file instead of printing it")
+}
+
+func runExport(kind exporter.Kind, method string) error {
+	provider, err := reg.GetProvider(method)
+	if err != nil {
+		return err
+	}
+	method = provider.Name() // resolve deprecated aliases
+	if _, ok := provider.(providers.Planner); !ok {
+		return fmt.Errorf("%s does not describe the commands it runs, so it can't be exported", method)
+	}
+
+	connect, err := providers.PlanFor(provider, providers.ActionConnect)
+	if err != nil {
+		return fmt.Errorf("failed to plan connect %s: %w", method, err)
+	}
+	disconnect, err := providers.PlanFor(provider, providers.ActionDisconnect)
+	if err != nil {
+		return fmt.Errorf("failed to plan disconnect %s: %w", method, err)
+	}
+
+	def := exporter.Definition{
+		Name:        method,
+		Description: "tunnel " + providers.DisplayName(method),
+		Connect:     connect,
+		Disconnect:  disconnect,
+		Image:       exportImage,
+	}
+	if script, ok := appConfig.Scripts[method]; ok {
+		def.Env = script.Env
+		def.Restart = script.Restart
+	}
+
+	exp, err := exporter.Generate(kind, def)
+	if err != nil {
+		return fmt.Errorf("failed to export %s: %w", method, err)
+	}
+
+	if exportFile != "" {
+		if err := os.WriteFile(exportFile, []byte(exp.Content), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", exportFile, err)
+		}
+	}
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+	if format.Structured() {
+		return writeOutput(format, exp)
+	}
+	if exportFile == "" {
+		fmt.Print(exp.Content)
+	} else {
+		color.Green("✓ Exported %s as %s to %s", method, kind, exportFile)
+	}
+
+	// Notes go to stderr so the printed definition can be redirected
+	for _, note := range exp.Notes {
+		fmt.Fprintf(os.Stderr, "Note: %s\n", note)
+	}
+	if exportFile != "" {
+		switch kind {
+		case exporter.KindSystemd:
+			fmt.Printf("  Install it as /etc/systemd/system/%s, then run 'systemctl enable --now %s'\n", exp.File, exp.Name)
+		case exporter.KindCompose:
+			fmt.Println("  Start it with 'docker compose up -d'")
+		}
+	}
+	return nil
+}
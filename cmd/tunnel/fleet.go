@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/fleet"
+	"github.com/spf13/cobra"
+)
+
+var (
+	fleetRevokeReason string
+	fleetRevokeNode   string
+)
+
+var fleetCmd = &cobra.Command{
+	Use:   "fleet",
+	Short: "Act on the other TUNNEL nodes listed in the config",
+	Long: `The fleet section of the config lists other TUNNEL nodes by the base URL
+of their REST API and a file holding its token, which needs the admin
+scope. keys revoke and emergency-revoke pass revocations on to them with
+--fleet, or always with fleet.propagate_revocations.`,
+}
+
+var fleetRevokeCmd = &cobra.Command{
+	Use:   "revoke <user> [fingerprint...]",
+	Short: "Revoke keys of a user on every fleet node",
+	Long: `Revoke keys of a user by fingerprint on every fleet node, or all of the
+user's keys when no fingerprint is given, and report how each node did.
+Keys revoked here are not touched; use it to retry the nodes a propagated
+revocation failed on.
+
+Under the two-person rule (two_person.enabled) the revocation is staged for
+a second operator to approve with tunnel staged approve.`,
+	Example: `  tunnel fleet revoke bob SHA256:3f2a9c1b... --reason "key leaked"
+  tunnel fleet revoke alice --node edge-2 --reason "device stolen"`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if appConfig.TwoPerson.Enabled {
+			if _, err := fleetNodes(fleetRevokeNode); err != nil {
+				return err
+			}
+			return stageAction(core.StagedAction{
+				Action: actionFleetRevoke,
+				User:   args[0],
+				Reason: fleetRevokeReason,
+				Params: map[string]string{
+					"fingerprints": strings.Join(args[1:], " "),
+					"node":         fleetRevokeNode,
+				},
+			})
+		}
+		return fleetRevoke(args[0], args[1:], fleetRevokeReason, fleetRevokeNode)
+	},
+}
+
+func init() {
+	fleetRevokeCmd.Flags().StringVar(&fleetRevokeReason, "reason", "", "why the keys are revoked, recorded by each node")
+	fleetRevokeCmd.Flags().StringVar(&fleetRevokeNode, "node", "", "only revoke on this node")
+
+	fleetCmd.AddCommand(fleetRevokeCmd)
+}
+
+// fleetNodes returns the configured fleet nodes, or only the one named
+func fleetNodes(only string) ([]*fleet.Node, error) {
+	configured := appConfig.Fleet.Nodes
+	if len(configured) == 0 {
+		return nil, fmt.Errorf("no fleet nodes configured; add them to fleet.nodes")
+	}
+
+	home, _ := os.UserHomeDir()
+	var nodes []*fleet.Node
+	for _, n := range configured {
+		if only != "" && n.Name != only {
+			continue
+		}
+		node := &fleet.Node{Name: n.Name, URL: n.URL}
+		if n.TokenFile != "" {
+			data, err := os.ReadFile(expandHome(n.TokenFile, home))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read token of fleet node %s: %w", n.Name, err)
+			}
+			node.Token = strings.TrimSpace(string(data))
+		}
+		nodes = append(nodes, node)
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no fleet node named %s in fleet.nodes", only)
+	}
+	return nodes, nil
+}
+
+// propagateToFleet reports whether a revocation is passed on to the fleet:
+// as --fleet says when given, otherwise as the config says
+func propagateToFleet(cmd *cobra.Command, flag bool) bool {
+	if cmd.Flags().Changed("fleet") {
+		return flag
+	}
+	return appConfig.Fleet.PropagateRevocations
+}
+
+// revokeOnFleet revokes keys of a user on the fleet nodes, or on the one
+// named, and audits how each node did
+func revokeOnFleet(req fleet.RevokeRequest, only string) ([]fleet.RevokeResult, error) {
+	nodes, err := fleetNodes(only)
+	if err != nil {
+		return nil, err
+	}
+	results := fleet.Revoke(context.Background(), nodes, req)
+
+	failed := countFailedNodes(results)
+	details := map[string]interface{}{
+		"reason":       req.Reason,
+		"nodes":        results,
+		"nodes_failed": failed,
+	}
+	if len(req.Fingerprints) > 0 {
+		details["fingerprints"] = req.Fingerprints
+	} else {
+		details["all_keys"] = true
+	}
+	homeDir, _ := os.UserHomeDir()
+	if auditLogger, err := core.NewAuditLogger(auditLogPath(homeDir), false, ""); err == nil {
+		defer auditLogger.Close()
+		_ = auditLogger.Log(core.AuditEvent{
+			Timestamp: time.Now(),
+			EventType: "fleet_revoke",
+			Method:    "ssh-key",
+			User:      req.User,
+			Details:   details,
+			Success:   failed == 0,
+		})
+	} else if verbose {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to initialize audit logger: %v\n", err)
+	}
+	return results, nil
+}
+
+// printFleetResults shows how each node did and returns how many failed
+func printFleetResults(results []fleet.RevokeResult) int {
+	failed := 0
+	fmt.Println("\nFleet:")
+	for _, res := range results {
+		if !res.OK() {
+			failed++
+			color.Red("  ✗ %s", res.Error)
+			continue
+		}
+		line := fmt.Sprintf("  ✓ %s: %d key(s) revoked", res.Node, len(res.Revoked))
+		if len(res.Missing) > 0 {
+			line += fmt.Sprintf(", %d not present", len(res.Missing))
+		}
+		color.Green("%s", line)
+	}
+	if failed > 0 {
+		color.Yellow("\nRetry the failed nodes with: tunnel fleet revoke <user> --node <name>")
+	}
+	return failed
+}
+
+func fleetRevoke(user string, fingerprints []string, reason, only string) error {
+	results, err := revokeOnFleet(fleet.RevokeRequest{User: user, Fingerprints: fingerprints, Reason: reason}, only)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		return printJSON(map[string]interface{}{
+			"user":  user,
+			"nodes": results,
+		})
+	}
+	if failed := printFleetResults(results); failed > 0 {
+		return fmt.Errorf("revocation failed on %d of %d node(s)", failed, len(results))
+	}
+	return nil
+}
+
+// countFailedNodes counts the nodes a revocation failed on
+func countFailedNodes(results []fleet.RevokeResult) int {
+	failed := 0
+	for _, res := range results {
+		if !res.OK() {
+			failed++
+		}
+	}
+	return failed
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/daemon"
+	"github.com/jedarden/tunnel/internal/output"
+	"github.com/jedarden/tunnel/internal/providers"
+	"github.com/jedarden/tunnel/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+// forwarder runs the forwards defined in the config
+var forwarder *core.ForwardingManager
+
+var (
+	forwardProvider   string
+	forwardLocalPort  int
+	forwardRemoteHost string
+	forwardRemotePort int
+	forwardAutostart  bool
+)
+
+var forwardsCmd = &cobra.Command{
+	Use:   "forwards",
+	Short: "Expose local services through providers",
+	Long: `Define named forwards of local ports through a provider, beyond the SSH
+port its own connection carries. Each forward runs a client of its own, so
+one provider can expose several services at once. bore and reverse-ssh can
+carry forwards.
+
+Forwards are kept in the forwards section of the config. They run in the
+daemon when one is up, and are shown on the TUI dashboard; without a
+daemon, tunnel forwards start runs them in the foreground.`,
+}
+
+var forwardsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List forwards and whether they are running",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return listForwards()
+	},
+}
+
+var forwardsAddCmd = &cobra.Command{
+	Use:   "add <name>",
+	Short: "Define a forward",
+	Example: `  tunnel forwards add web --provider bore --local-port 3000
+  tunnel forwards add grafana --provider reverse-ssh --local-port 3000 --remote-port 13000 --autostart`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return addForward(config.ForwardConfig{
+			Name:       args[0],
+			Provider:   forwardProvider,
+			LocalPort:  forwardLocalPort,
+			RemoteHost: forwardRemoteHost,
+			RemotePort: forwardRemotePort,
+			Autostart:  forwardAutostart,
+		})
+	},
+}
+
+var forwardsRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Stop a forward and remove its definition",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return removeForward(args[0])
+	},
+}
+
+var forwardsStartCmd = &cobra.Command{
+	Use:   "start [name...]",
+	Short: "Start forwards, all of them without names",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return startForwards(cmd.Context(), args)
+	},
+}
+
+var forwardsStopCmd = &cobra.Command{
+	Use:   "stop [name...]",
+	Short: "Stop forwards in the daemon, all of them without names",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return stopForwards(args)
+	},
+}
+
+func init() {
+	forwardsAddCmd.Flags().StringVar(&forwardProvider, "provider", "", "provider to forward through (required)")
+	forwardsAddCmd.Flags().IntVar(&forwardLocalPort, "local-port", 0, "local port of the service (required)")
+	forwardsAddCmd.Flags().StringVar(&forwardRemoteHost, "remote-host", "", "server to forward to (default: the provider's)")
+	forwardsAddCmd.Flags().IntVar(&forwardRemotePort, "remote-port", 0, "port to expose it on (default: chosen by the provider)")
+	forwardsAddCmd.Flags().BoolVar(&forwardAutostart, "autostart", false, "start it with the daemon and the TUI")
+	_ = forwardsAddCmd.MarkFlagRequired("provider")
+	_ = forwardsAddCmd.MarkFlagRequired("local-port")
+
+	forwardsCmd.AddCommand(forwardsListCmd)
+	forwardsCmd.AddCommand(forwardsAddCmd)
+	forwardsCmd.AddCommand(forwardsRemoveCmd)
+	forwardsCmd.AddCommand(forwardsStartCmd)
+	forwardsCmd.AddCommand(forwardsStopCmd)
+}
+
+// newForwarder creates the forwarding manager with the forwards of cfg
+func newForwarder(cfg *config.Config, events *core.EventPublisher) *core.ForwardingManager {
+	m := core.NewForwardingManager(openForward, events)
+	syncForwards(m, cfg)
+	return m
+}
+
+// openForward starts a client of f's provider carrying f
+func openForward(f core.Forward) (core.ForwardTunnel, error) {
+	provider, err := reg.GetProvider(f.Provider)
+	if err != nil {
+		return nil, err
+	}
+	return providers.StartForward(provider, providers.Forward{
+		LocalPort:  f.LocalPort,
+		RemoteHost: f.RemoteHost,
+		RemotePort: f.RemotePort,
+	})
+}
+
+// syncForwards brings the definitions in m in line with cfg. Running
+// forwards keep their definition until stopped.
+func syncForwards(m *core.ForwardingManager, cfg *config.Config) {
+	defined := make(map[string]bool)
+	for _, fc := range cfg.Forwards {
+		defined[fc.Name] = true
+		f := toForward(fc)
+		if current, err := m.Get(fc.Name); err == nil && current.Forward == f {
+			continue
+		}
+		if err := m.Define(f); err != nil && verbose {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+	for _, status := range m.List() {
+		if !defined[status.Name] && status.State != core.ForwardRunning {
+			_ = m.Remove(status.Name)
+		}
+	}
+}
+
+func toForward(fc config.ForwardConfig) core.Forward {
+	return core.Forward{
+		Name:       fc.Name,
+		Provider:   fc.Provider,
+		LocalPort:  fc.LocalPort,
+		RemoteHost: fc.RemoteHost,
+		RemotePort: fc.RemotePort,
+	}
+}
+
+// autostartForwards starts the forwards marked autostart and returns
+// those that failed
+func autostartForwards(cfg *config.Config) []error {
+	var errs []error
+	for _, fc := range cfg.Forwards {
+		if !fc.Autostart {
+			continue
+		}
+		if err := forwarder.Start(fc.Name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// forwardsResult is the outcome of starting or stopping forwards
+type forwardsResult struct {
+	Forwards []core.ForwardStatus `json:"forwards"`
+	Failed   map[string]string    `json:"failed,omitempty"`
+}
+
+// handleForwardOps registers the daemon's forward operations. Forwards
+// are the daemon's own, so starting and stopping them is for callers who
+// may control the daemon, and listing them for callers who may see the
+// daemon's own user.
+func handleForwardOps(server *daemon.Server) {
+	server.Handle("forwards", func(ctx context.Context, args json.RawMessage) (any, error) {
+		if !canSeeDaemonOwned(daemon.CallerFrom(ctx)) {
+			return forwardsResult{Forwards: []core.ForwardStatus{}}, nil
+		}
+		reloadForwards()
+		return forwardsResult{Forwards: forwarder.List()}, nil
+	})
+	server.HandleAdmin("forward-start", func(ctx context.Context, args json.RawMessage) (any, error) {
+		var names []string
+		if err := json.Unmarshal(args, &names); err != nil {
+			return nil, err
+		}
+		reloadForwards()
+		return runForwards(names, forwarder.Start), nil
+	})
+	server.HandleAdmin("forward-stop", func(ctx context.Context, args json.RawMessage) (any, error) {
+		var names []string
+		if err := json.Unmarshal(args, &names); err != nil {
+			return nil, err
+		}
+		res := runForwards(names, forwarder.Stop)
+		reloadForwards()
+		return res, nil
+	})
+}
+
+// reloadForwards picks up forwards added or removed in the config file
+// since the daemon started
+func reloadForwards() {
+	cfg, err := config.Load(appConfig.Path())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to reload forwards: %v\n", err)
+		return
+	}
+	syncForwards(forwarder, cfg)
+}
+
+// runForwards applies fn to the named forwards, or to all of them, and
+// returns their state afterwards
+func runForwards(names []string, fn func(name string) error) forwardsResult {
+	if len(names) == 0 {
+		for _, status := range forwarder.List() {
+			names = append(names, status.Name)
+		}
+	}
+	res := forwardsResult{Forwards: []core.ForwardStatus{}, Failed: make(map[string]string)}
+	for _, name := range names {
+		if err := fn(name); err != nil {
+			res.Failed[name] = err.Error()
+		}
+		if status, err := forwarder.Get(name); err == nil {
+			res.Forwards = append(res.Forwards, status)
+		}
+	}
+	return res
+}
+
+func listForwards() error {
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+
+	var res forwardsResult
+	if client := daemonClient(); client != nil {
+		if err := client.Call("forwards", nil, &res); err != nil {
+			return err
+		}
+	} else {
+		res.Forwards = forwarder.List()
+	}
+
+	if format.Structured() {
+		return writeOutput(format, res)
+	}
+	if len(res.Forwards) == 0 && format == output.FormatTable {
+		color.Yellow("No forwards defined; add one with tunnel forwards add")
+		return nil
+	}
+	return renderTable(format, forwardsTable(res.Forwards))
+}
+
+func forwardsTable(forwards []core.ForwardStatus) *output.Table {
+	table := newTable("NAME", "PROVIDER", "LOCAL", "REMOTE", "STATE", "ADDRESS")
+	table.SetColor(4, colorizeState)
+	for _, f := range forwards {
+		remote := f.RemoteHost
+		if f.RemotePort != 0 {
+			remote += ":" + strconv.Itoa(f.RemotePort)
+		}
+		if remote == "" {
+			remote = "-"
+		}
+		address := f.Address
+		if f.Error != "" {
+			address = f.Error
+		}
+		table.AddRow(f.Name, f.Provider, strconv.Itoa(f.LocalPort), remote, f.State, address)
+	}
+	return table
+}
+
+func addForward(fc config.ForwardConfig) error {
+	provider, err := reg.GetProvider(fc.Provider)
+	if err != nil {
+		return err
+	}
+	if !providers.CanForward(provider) {
+		return fmt.Errorf("%s can't forward other local ports; use one of: %s", provider.Name(), strings.Join(forwardingProviders(), ", "))
+	}
+	fc.Provider = provider.Name()
+
+	for _, existing := range appConfig.Forwards {
+		if existing.Name == fc.Name {
+			return fmt.Errorf("forward %s already exists", fc.Name)
+		}
+	}
+	appConfig.Forwards = append(appConfig.Forwards, fc)
+	if err := appConfig.Validate(); err != nil {
+		appConfig.Forwards = appConfig.Forwards[:len(appConfig.Forwards)-1]
+		return err
+	}
+	if err := appConfig.Save(); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	color.Green("✓ Added forward %s: local port %d through %s", fc.Name, fc.LocalPort, fc.Provider)
+	return nil
+}
+
+func removeForward(name string) error {
+	kept := appConfig.Forwards[:0:0]
+	for _, fc := range appConfig.Forwards {
+		if fc.Name != name {
+			kept = append(kept, fc)
+		}
+	}
+	if len(kept) == len(appConfig.Forwards) {
+		return fmt.Errorf("forward not found: %s", name)
+	}
+
+	if client := daemonClient(); client != nil {
+		var res forwardsResult
+		if err := client.Call("forward-stop", []string{name}, &res); err != nil {
+			return err
+		}
+	}
+	appConfig.Forwards = kept
+	if err := appConfig.Save(); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	color.Green("✓ Removed forward %s", name)
+	return nil
+}
+
+// startForwards starts forwards in the daemon, or runs them here until
+// interrupted
+func startForwards(ctx context.Context, names []string) error {
+	if client := daemonClient(); client != nil {
+		var res forwardsResult
+		if err := client.Call("forward-start", names, &res); err != nil {
+			return err
+		}
+		return printForwardsResult(res, "Started")
+	}
+
+	if len(forwarder.List()) == 0 {
+		return fmt.Errorf("no forwards defined; add one with tunnel forwards add")
+	}
+	res := runForwards(names, forwarder.Start)
+	if err := printForwardsResult(res, "Started"); err != nil && len(res.Failed) == len(res.Forwards) {
+		return err
+	}
+	if !jsonOutput {
+		fmt.Println("Forwarding until interrupted (Ctrl+C); run tunnel daemon to keep forwards up in the background")
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func stopForwards(names []string) error {
+	client := daemonClient()
+	if client == nil {
+		return fmt.Errorf("no daemon is running; forwards started in the foreground stop with it")
+	}
+	var res forwardsResult
+	if err := client.Call("forward-stop", names, &res); err != nil {
+		return err
+	}
+	return printForwardsResult(res, "Stopped")
+}
+
+func printForwardsResult(res forwardsResult, verb string) error {
+	if jsonOutput {
+		if err := printJSON(res); err != nil {
+			return err
+		}
+	} else {
+		for _, f := range res.Forwards {
+			if _, failed := res.Failed[f.Name]; failed {
+				continue
+			}
+			switch {
+			case verb == "Started" && f.State == core.ForwardRunning:
+				line := fmt.Sprintf("✓ %s forward %s: local port %d through %s", verb, f.Name, f.LocalPort, f.Provider)
+				if f.Address != "" {
+					line += " at " + f.Address
+				}
+				color.Green(line)
+			case verb == "Stopped" && f.State == core.ForwardStopped:
+				color.Green("✓ %s forward %s", verb, f.Name)
+			}
+		}
+		failed := make([]string, 0, len(res.Failed))
+		for name := range res.Failed {
+			failed = append(failed, name)
+		}
+		sort.Strings(failed)
+		for _, name := range failed {
+			color.Red("✗ %s", res.Failed[name])
+		}
+	}
+	if len(res.Failed) > 0 {
+		return fmt.Errorf("%d forward(s) failed", len(res.Failed))
+	}
+	return nil
+}
+
+// forwardingProviders returns the providers that can carry forwards
+func forwardingProviders() []string {
+	var names []string
+	for _, p := range reg.ListProviders() {
+		if providers.CanForward(p) {
+			names = append(names, p.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/importer"
+	"github.com/jedarden/tunnel/internal/output"
+	"github.com/jedarden/tunnel/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	importDryRun bool
+	importForce  bool
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import <autossh|systemd|compose> <path>",
+	Short: "Import tunnels defined for autossh, systemd or docker-compose",
+	Long: `Convert tunnels you already run with other tools into tunnel config.
+
+  autossh   command lines in a script, crontab or ps output ("-" reads stdin)
+  systemd   a service unit, or a directory of them, running ssh, autossh,
+            cloudflared, ngrok or bore
+  compose   the cloudflared, ngrok, bore and autossh services of a
+            docker-compose file
+
+An ssh -R tunnel the reverse-ssh provider can run becomes its settings under
+"methods". Any other tunnel becomes a script provider under "scripts" that
+runs the same command, restarted if the original was. Definitions that
+can't be mapped are listed with the reason, and each import notes what
+changed on the way, such as autossh's monitor port being dropped.
+
+Imported entries never replace what is in the config already unless
+--force is given. Use --dry-run to see the result without saving it.`,
+	Example: `  tunnel import autossh ~/bin/tunnels.sh
+  ps -eo args | tunnel import autossh -
+  tunnel import systemd /etc/systemd/system/cloudflared.service
+  tunnel import compose docker-compose.yml --dry-run`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		kind, err := importer.ParseKind(args[0])
+		if err != nil {
+			return err
+		}
+		return runImport(kind, args[1], importDryRun, importForce)
+	},
+}
+
+func init() {
+	importCmd.Flags().BoolVar(&importDryRun, "dry-run", false, "show what would be imported without saving it")
+	importCmd.Flags().BoolVar(&importForce, "force", false, "replace methods and scripts already in the config")
+}
+
+// importReport is the JSON form of an import
+type importReport struct {
+	Imported []importer.Entry               `json:"imported"`
+	Skipped  []importer.Skip                `json:"skipped"`
+	Methods  map[string]config.MethodConfig `json:"methods,omitempty"`
+	Scripts  map[string]config.ScriptConfig `json:"scripts,omitempty"`
+	Applied  bool                           `json:"applied"`
+}
+
+func runImport(kind importer.Kind, path string, dryRun, force bool) error {
+	result, err := importer.File(kind, path)
+	if err != nil {
+		return fmt.Errorf("failed to import %s: %w", path, err)
+	}
+
+	report := importReport{Imported: []importer.Entry{}, Skipped: result.Skipped, Methods: result.Methods, Scripts: result.Scripts}
+	if appConfig.Methods == nil {
+		appConfig.Methods = make(map[string]config.MethodConfig)
+	}
+	if appConfig.Scripts == nil {
+		appConfig.Scripts = make(map[string]config.ScriptConfig)
+	}
+
+	for _, entry := range result.Imported {
+		if reason := importConflict(entry, result, force); reason != "" {
+			report.Skipped = append(report.Skipped, importer.Skip{Source: entry.Source, Reason: reason})
+			delete(report.Methods, entry.Name)
+			delete(report.Scripts, entry.Name)
+			continue
+		}
+		report.Imported = append(report.Imported, entry)
+		if entry.Type == importer.TypeMethod {
+			method := result.Methods[entry.Name]
+			method.Priority = appConfig.Methods[entry.Name].Priority
+			if method.Priority == 0 {
+				method.Priority = nextPriority()
+			}
+			appConfig.Methods[entry.Name] = method
+		} else {
+			appConfig.Scripts[entry.Name] = result.Scripts[entry.Name]
+		}
+	}
+
+	if len(report.Imported) > 0 {
+		if err := appConfig.Validate(); err != nil {
+			return fmt.Errorf("imported config is invalid: %w", err)
+		}
+		if !dryRun {
+			if err := appConfig.Save(); err != nil {
+				return fmt.Errorf("failed to save config: %w", err)
+			}
+			report.Applied = true
+		}
+	}
+
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+	if format.Structured() {
+		return writeOutput(format, report)
+	}
+	return printImportReport(format, report, dryRun)
+}
+
+// importConflict explains why entry can't be added to the config, or
+// returns "" if it can
+func importConflict(entry importer.Entry, result *importer.Result, force bool) string {
+	if force {
+		return ""
+	}
+	switch entry.Type {
+	case importer.TypeMethod:
+		existing, ok := appConfig.Methods[entry.Name]
+		if !ok || len(existing.Settings) == 0 {
+			return ""
+		}
+		if reflect.DeepEqual(existing.Settings, result.Methods[entry.Name].Settings) {
+			return "already imported"
+		}
+		return fmt.Sprintf("the %s method is already configured; use --force to replace it", entry.Name)
+	default:
+		existing, ok := appConfig.Scripts[entry.Name]
+		if !ok {
+			return ""
+		}
+		if sameScript(existing, result.Scripts[entry.Name]) {
+			return "already imported"
+		}
+		return fmt.Sprintf("a script called %s already exists; use --force to replace it", entry.Name)
+	}
+}
+
+// sameScript reports whether two scripts are the same, counting a missing
+// env and an empty one, as saved, as equal
+func sameScript(a, b config.ScriptConfig) bool {
+	if len(a.Env) == 0 {
+		a.Env = nil
+	}
+	if len(b.Env) == 0 {
+		b.Env = nil
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// nextPriority returns a failover priority after every configured method
+func nextPriority() int {
+	highest := 0
+	for _, method := range appConfig.Methods {
+		if method.Priority > highest {
+			highest = method.Priority
+		}
+	}
+	return highest + 1
+}
+
+func printImportReport(format output.Format, report importReport, dryRun bool) error {
+	if len(report.Imported) > 0 {
+		t := newTable("SOURCE", "IMPORTED AS", "NAME", "NOTES")
+		for _, entry := range report.Imported {
+			t.AddRow(entry.Source, entry.Type, entry.Name, strings.Join(entry.Notes, "; "))
+		}
+		if err := renderTable(format, t); err != nil {
+			return err
+		}
+	}
+	if format == output.FormatCSV {
+		return nil
+	}
+
+	if len(report.Skipped) > 0 {
+		fmt.Println()
+		color.Yellow("Not imported:")
+		for _, skip := range report.Skipped {
+			fmt.Printf("  %s: %s\n", skip.Source, skip.Reason)
+		}
+	}
+
+	fmt.Println()
+	switch {
+	case len(report.Imported) == 0:
+		color.Yellow("Nothing imported")
+	case dryRun:
+		fmt.Printf("Dry run: %d definition(s) would be imported into %s\n", len(report.Imported), appConfig.Path())
+	default:
+		color.Green("✓ Imported %d definition(s) into %s", len(report.Imported), appConfig.Path())
+		fmt.Println("  Start one with 'tunnel start <name>'")
+	}
+	return nil
+}
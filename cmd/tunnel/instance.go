@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/jedarden/tunnel/internal/changelog"
+	"github.com/jedarden/tunnel/internal/instance"
+	"github.com/jedarden/tunnel/internal/tui"
+)
+
+var (
+	takeover bool
+
+	// instanceLock is held while this process is the primary TUI instance
+	instanceLock *instance.Lock
+)
+
+// instanceLockPath returns the per-user instance lock file
+func instanceLockPath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".config", "tunnel", "instance.lock"), nil
+}
+
+// instanceHeader carries the API token from TUNNEL_API_TOKEN, if any, which
+// a running tunnel serve requires of local commands too
+func instanceHeader() http.Header {
+	header := http.Header{}
+	if token := strings.TrimSpace(os.Getenv("TUNNEL_API_TOKEN")); token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	return header
+}
+
+// getFromInstance requests path from a running instance's API
+func getFromInstance(client *http.Client, holder *instance.Info, path string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost:%d%s", holder.Port, path), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = instanceHeader()
+	return client.Do(req)
+}
+
+// acquireInstanceLock makes this process the primary instance. When another
+// instance is already running, its details are returned with ErrRunning.
+func acquireInstanceLock(command string) (*instance.Info, error) {
+	path, err := instanceLockPath()
+	if err != nil {
+		return nil, err
+	}
+
+	lock, err := instance.Acquire(path, command, takeover)
+	switch {
+	case err == nil:
+		instanceLock = lock
+		onShutdown("instance lock", func(context.Context) error {
+			return lock.Release()
+		})
+		return nil, nil
+
+	case errors.Is(err, instance.ErrRunning):
+		holder, readErr := instance.Read(path)
+		if readErr != nil {
+			return nil, err
+		}
+		return holder, err
+
+	case errors.Is(err, instance.ErrStaleLock):
+		return nil, fmt.Errorf("%v\nA previous tunnel instance did not shut down cleanly; run 'tunnel --takeover' to replace its lock", err)
+
+	default:
+		return nil, fmt.Errorf("failed to acquire instance lock: %w", err)
+	}
+}
+
+// attachToInstance runs the TUI as a client of an instance that is already
+// running, pointing at its web server rather than starting another one
+func attachToInstance(ctx context.Context, holder *instance.Info) error {
+	if holder.Port == 0 {
+		return fmt.Errorf("tunnel is already running (pid %d) and its web server is still starting; try again shortly", holder.PID)
+	}
+
+	if verbose {
+		fmt.Printf("Attaching to running instance (pid %d) on port %d\n", holder.PID, holder.Port)
+	}
+
+	app := tui.NewApp(holder.Port)
+	restoreTUIState(app)
+	setupTUIChangelog(app)
+	setupTUIMacros(app)
+	setupTUIDashboard(app)
+	defer saveTUIState(app)
+
+	p := tea.NewProgram(app, tea.WithAltScreen())
+
+	go func() {
+		p.Send(tui.ServerStatusMsg{
+			Status: tui.ServerAttached,
+			Port:   holder.Port,
+		})
+	}()
+
+	go func() {
+		<-ctx.Done()
+		p.Quit()
+	}()
+
+	if _, err := p.Run(); err != nil {
+		return fmt.Errorf("failed to run TUI: %w", err)
+	}
+	return nil
+}
+
+// restoreTUIState returns app to where the last TUI session left off
+func restoreTUIState(app *tui.App) {
+	path, err := tui.StatePath()
+	if err != nil {
+		return
+	}
+	state, err := tui.LoadState(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		return
+	}
+	app.RestoreState(state)
+}
+
+// setupTUIChangelog puts the release notes the user hasn't seen in front
+// of the dashboard; it needs the restored state to know which those are
+func setupTUIChangelog(app *tui.App) {
+	app.SetChangelog(changelog.Releases())
+}
+
+// setupTUIMacros loads the user's keyboard macros into app and saves new
+// recordings
+func setupTUIMacros(app *tui.App) {
+	path, err := tui.MacrosPath()
+	if err != nil {
+		return
+	}
+	macros, err := tui.LoadMacros(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	app.SetMacros(macros, func(m tui.Macros) error { return tui.SaveMacros(path, m) })
+}
+
+// setupTUIDashboard picks app's dashboard widgets from the config and
+// gives them their sources
+func setupTUIDashboard(app *tui.App) {
+	if err := app.SetWidgets(appConfig.Dashboard.Widgets); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	if err := app.SetGraphStyle(appConfig.Dashboard.GraphStyle); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	if keyManager != nil {
+		app.SetKeyExpirations(keyManager.CheckKeyExpiration)
+	}
+}
+
+// saveTUIState remembers app's state for the next TUI session
+func saveTUIState(app *tui.App) {
+	path, err := tui.StatePath()
+	if err == nil {
+		err = tui.SaveState(path, app.State())
+	}
+	if err != nil && verbose {
+		fmt.Fprintf(os.Stderr, "Warning: failed to save TUI state: %v\n", err)
+	}
+}
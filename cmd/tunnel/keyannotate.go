@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/spf13/cobra"
+)
+
+var (
+	keysAnnotateComment string
+	keysAnnotateNote    string
+)
+
+var keysAnnotateCmd = &cobra.Command{
+	Use:   "annotate <user> <key-id>",
+	Short: "Relabel a key or attach a note to it",
+	Long: `Change the comment a key is listed under, or attach a free-form note such
+as which machine it is on. Both are kept with the key's metadata; the
+authorized_keys line, and the key in it, are left as they are.
+
+An empty --comment restores the comment of the authorized_keys line, and an
+empty --note removes the note.`,
+	Example: `  tunnel keys annotate alice SHA256:abc123... --note "Alice's work laptop, replaced 2024-05"
+  tunnel keys annotate bob 1 --comment bob-ci
+  tunnel keys annotate bob 1 --note ""`,
+	Args:         cobra.ExactArgs(2),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var annotation core.KeyAnnotation
+		if cmd.Flags().Changed("comment") {
+			annotation.Comment = &keysAnnotateComment
+		}
+		if cmd.Flags().Changed("note") {
+			annotation.Note = &keysAnnotateNote
+		}
+		if annotation.Comment == nil && annotation.Note == nil {
+			return fmt.Errorf("nothing to change: give --comment or --note")
+		}
+		return annotateKey(args[0], args[1], annotation)
+	},
+}
+
+func init() {
+	keysAnnotateCmd.Flags().StringVar(&keysAnnotateComment, "comment", "", "label to list the key under")
+	keysAnnotateCmd.Flags().StringVar(&keysAnnotateNote, "note", "", "free-form note about the key")
+
+	keysCmd.AddCommand(keysAnnotateCmd)
+}
+
+func annotateKey(user, keyID string, annotation core.KeyAnnotation) error {
+	if keyManager == nil {
+		return fmt.Errorf("key manager not initialized")
+	}
+	if err := requireKnownKeyUser(user); err != nil {
+		return err
+	}
+
+	key, err := keyManager.AnnotateKey(user, keyID, annotation)
+	if err != nil {
+		return fmt.Errorf("failed to annotate key: %w", err)
+	}
+
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+	if format.Structured() {
+		return writeOutput(format, map[string]interface{}{
+			"status":      "success",
+			"user":        user,
+			"fingerprint": key.Fingerprint,
+			"comment":     key.Comment,
+			"note":        key.Note,
+		})
+	}
+	color.Green("✓ SSH key annotated")
+	fmt.Printf("  Fingerprint: %s\n", key.Fingerprint)
+	fmt.Printf("  Comment:     %s\n", orDash(key.Comment))
+	fmt.Printf("  Note:        %s\n", orDash(key.Note))
+	return nil
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/units"
+	"github.com/spf13/cobra"
+)
+
+// Where `tunnel keys ca trust` writes by default
+const (
+	defaultTrustedCAKeys = "/etc/ssh/tunnel_user_ca.pub"
+	defaultCADropIn      = "/etc/ssh/sshd_config.d/tunnel-ca.conf"
+)
+
+var (
+	caInitComment string
+	caTrustDropIn string
+	caTrustReload bool
+
+	keysSignPrincipals       []string
+	keysSignValidFor         string
+	keysSignKeyID            string
+	keysSignOut              string
+	keysSignFrom             []string
+	keysSignCommand          string
+	keysSignNoPortForwarding bool
+	keysSignNoPTY            bool
+	keysSignNoX11Forwarding  bool
+)
+
+var keysCACmd = &cobra.Command{
+	Use:   "ca",
+	Short: "Manage the user certificate authority",
+	Long: `Instead of listing every key in authorized_keys, sshd can accept any key
+carrying a certificate from a CA it trusts. Create the CA with init, have
+sshd trust it with trust, then sign users' keys with tunnel keys sign.
+
+The CA key is kept at ssh.ca.key_path (default ~/.config/tunnel/ca/user_ca);
+anyone who can read it can log in as anyone, so keep it that way.`,
+}
+
+var keysCAInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Create the certificate authority key",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return initCA(caInitComment)
+	},
+}
+
+var keysCAShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the certificate authority's public key",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return showCA()
+	},
+}
+
+var keysCATrustCmd = &cobra.Command{
+	Use:   "trust",
+	Short: "Make sshd trust certificates from the CA",
+	Long: `Write the CA's public key to ssh.ca.trusted_keys (default
+/etc/ssh/tunnel_user_ca.pub) and an sshd_config drop-in naming it as
+TrustedUserCAKeys, then check the result with sshd -t. A configuration sshd
+rejects is rolled back.`,
+	Example:      `  sudo tunnel keys ca trust --reload`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return trustCA(caTrustDropIn, caTrustReload)
+	},
+}
+
+var keysSignCmd = &cobra.Command{
+	Use:   "sign <public-key-file>",
+	Short: "Sign a public key with the certificate authority",
+	Long: `Issue a certificate for a public key, letting it log in as the given
+principals until it expires, without an authorized_keys entry. The
+certificate is written next to the key as <name>-cert.pub, where ssh looks
+for it; --out - prints it instead.
+
+Certificates are valid for ssh.ca.validity hours (default 24) unless
+--valid-for says otherwise. The restriction flags work as for keys add, but
+--from only takes addresses and CIDR blocks. Agent forwarding is permitted
+when the agent forwarding policy allows it for every principal.`,
+	Example: `  tunnel keys sign ~/.ssh/id_ed25519.pub --principal alice
+  tunnel keys sign deploy.pub -n deploy -n backup --valid-for 30d --no-pty
+  tunnel keys sign bob.pub -n bob --from 10.0.0.0/8 --out -`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return signKey(args[0])
+	},
+}
+
+func init() {
+	keysCAInitCmd.Flags().StringVar(&caInitComment, "comment", "tunnel-user-ca", "comment of the CA key")
+	keysCATrustCmd.Flags().StringVar(&caTrustDropIn, "path", defaultCADropIn, "sshd_config drop-in to write")
+	keysCATrustCmd.Flags().BoolVar(&caTrustReload, "reload", false, "reload sshd afterwards")
+
+	keysSignCmd.Flags().StringSliceVarP(&keysSignPrincipals, "principal", "n", nil, "user the certificate may log in as (repeatable)")
+	keysSignCmd.Flags().StringVar(&keysSignValidFor, "valid-for", "", "how long the certificate is valid (e.g. 8h, 30d)")
+	keysSignCmd.Flags().StringVarP(&keysSignKeyID, "id", "I", "", "key ID sshd logs; default the first principal")
+	keysSignCmd.Flags().StringVar(&keysSignOut, "out", "", "where to write the certificate; - prints it")
+	keysSignCmd.Flags().StringSliceVar(&keysSignFrom, "from", nil, "only accept the certificate from these addresses or CIDR blocks")
+	keysSignCmd.Flags().StringVar(&keysSignCommand, "command", "", "force this command whatever the client runs")
+	keysSignCmd.Flags().BoolVar(&keysSignNoPortForwarding, "no-port-forwarding", false, "refuse port forwarding with the certificate")
+	keysSignCmd.Flags().BoolVar(&keysSignNoPTY, "no-pty", false, "refuse a terminal with the certificate")
+	keysSignCmd.Flags().BoolVar(&keysSignNoX11Forwarding, "no-x11-forwarding", false, "refuse X11 forwarding with the certificate")
+	_ = keysSignCmd.MarkFlagRequired("principal")
+
+	keysCACmd.AddCommand(keysCAInitCmd)
+	keysCACmd.AddCommand(keysCAShowCmd)
+	keysCACmd.AddCommand(keysCATrustCmd)
+	keysCmd.AddCommand(keysCACmd)
+	keysCmd.AddCommand(keysSignCmd)
+}
+
+// certAuthority returns the CA of the ssh.ca section
+func certAuthority() (*core.CAManager, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get home directory: %w", err)
+	}
+	path := filepath.Join(home, ".config", "tunnel", "ca", "user_ca")
+	if configured := appConfig.SSH.CA.KeyPath; configured != "" {
+		path = expandHome(configured, home)
+	}
+	return core.NewCAManager(path, auditTrail), nil
+}
+
+func initCA(comment string) error {
+	ca, err := certAuthority()
+	if err != nil {
+		return err
+	}
+	key, err := ca.Init(comment)
+	if err != nil {
+		return fmt.Errorf("failed to create certificate authority: %w", err)
+	}
+
+	if jsonOutput {
+		return printJSON(map[string]interface{}{
+			"status":      "success",
+			"path":        ca.KeyPath(),
+			"fingerprint": key.Fingerprint,
+		})
+	}
+	color.Green("✓ Certificate authority created")
+	fmt.Printf("  Key:         %s\n", ca.KeyPath())
+	fmt.Printf("  Fingerprint: %s\n", key.Fingerprint)
+	fmt.Println("\nRun `sudo tunnel keys ca trust` so sshd accepts its certificates")
+	return nil
+}
+
+func showCA() error {
+	ca, err := certAuthority()
+	if err != nil {
+		return err
+	}
+	key, err := ca.PublicKey()
+	if err != nil {
+		return err
+	}
+
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+	if format.Structured() {
+		return writeOutput(format, map[string]interface{}{
+			"path":        ca.KeyPath(),
+			"type":        key.Type,
+			"public_key":  key.PublicKey,
+			"fingerprint": key.Fingerprint,
+		})
+	}
+	fmt.Println(key.PublicKey)
+	return nil
+}
+
+func trustCA(dropIn string, reload bool) error {
+	ca, err := certAuthority()
+	if err != nil {
+		return err
+	}
+	trusted := appConfig.SSH.CA.TrustedKeys
+	if trusted == "" {
+		trusted = defaultTrustedCAKeys
+	}
+
+	previous, err := os.ReadFile(dropIn)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read %s: %w", dropIn, err)
+	}
+	restore := func() error {
+		if previous == nil {
+			return os.Remove(dropIn)
+		}
+		return os.WriteFile(dropIn, previous, 0644)
+	}
+
+	if err := ca.WriteTrustedKeys(trusted); err != nil {
+		return fmt.Errorf("failed to trust certificate authority: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(dropIn), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(dropIn), err)
+	}
+	if err := os.WriteFile(dropIn, []byte(core.TrustedUserCAConfig(trusted)), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", dropIn, err)
+	}
+
+	if out, err := exec.Command("sshd", "-t").CombinedOutput(); err != nil {
+		if rerr := restore(); rerr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to roll back %s: %v\n", dropIn, rerr)
+		}
+		return fmt.Errorf("sshd rejected the CA configuration, rolled back: %s", strings.TrimSpace(string(out)))
+	}
+	color.Green("✓ sshd trusts certificates from %s", trusted)
+
+	if auditTrail != nil {
+		auditTrail.LogConfigChange(os.Getenv("USER"), map[string]interface{}{
+			"trusted_user_ca_keys": trusted,
+			"ca_drop_in":           dropIn,
+		})
+	}
+
+	if !reload {
+		fmt.Println("Reload sshd for it to take effect, or rerun with --reload")
+		return nil
+	}
+	if err := reloadSSHD(); err != nil {
+		return err
+	}
+	color.Green("✓ sshd reloaded")
+	return nil
+}
+
+func signKey(path string) error {
+	ca, err := certAuthority()
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read public key: %w", err)
+	}
+
+	validFor := time.Duration(appConfig.SSH.CA.Hours()) * time.Hour
+	if keysSignValidFor != "" {
+		if validFor, err = core.ParseAlertDuration(keysSignValidFor); err != nil || validFor == 0 {
+			return fmt.Errorf("invalid --valid-for %q: use a duration such as 8h or 30d", keysSignValidFor)
+		}
+	}
+
+	policy := agentForwardingPolicy(appConfig)
+	agentForwarding := true
+	for _, principal := range keysSignPrincipals {
+		agentForwarding = agentForwarding && policy.Allows(principal)
+	}
+
+	signed, err := ca.Sign(core.CertRequest{
+		PublicKey:  strings.TrimSpace(string(data)),
+		KeyID:      keysSignKeyID,
+		Principals: keysSignPrincipals,
+		ValidFor:   validFor,
+		Restrictions: core.KeyRestrictions{
+			Command:          keysSignCommand,
+			From:             keysSignFrom,
+			NoPortForwarding: keysSignNoPortForwarding,
+			NoPTY:            keysSignNoPTY,
+			NoX11Forwarding:  keysSignNoX11Forwarding,
+		},
+		AgentForwarding: agentForwarding,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to sign key: %w", err)
+	}
+
+	out := keysSignOut
+	if out == "" {
+		out = strings.TrimSuffix(path, ".pub") + "-cert.pub"
+	}
+	if out == "-" {
+		fmt.Println(signed.Certificate)
+		return nil
+	}
+	if err := os.WriteFile(out, []byte(signed.Certificate+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write certificate: %w", err)
+	}
+
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+	if format.Structured() {
+		return writeOutput(format, map[string]interface{}{"path": out, "certificate": signed})
+	}
+	color.Green("✓ Certificate written to %s", out)
+	fmt.Printf("  Key ID:      %s\n", signed.KeyID)
+	fmt.Printf("  Serial:      %d\n", signed.Serial)
+	fmt.Printf("  Principals:  %s\n", strings.Join(signed.Principals, ", "))
+	fmt.Printf("  Valid:       %s to %s\n", units.Time(signed.ValidAfter), units.Time(signed.ValidBefore))
+	return nil
+}
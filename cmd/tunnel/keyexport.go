@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/spf13/cobra"
+)
+
+// keyStatuses lists the values accepted by keys export --status
+var keyStatuses = []string{"active", "expired", "revoked"}
+
+var (
+	keysExportFormat    string
+	keysExportFile      string
+	keysExportStatus    string
+	keysExportOlderThan string
+	keysExportNewerThan string
+	keysExportMetadata  bool
+)
+
+var keysExportCmd = &cobra.Command{
+	Use:   "export [user]",
+	Short: "Export keys as an authorized_keys file or JSON",
+	Long: `Write the keys, all of them or a user's, for other systems to use: as an
+authorized_keys file, each line with its options, or as JSON, to a file or
+to stdout.
+
+--metadata adds what TUNNEL knows about each key: its user, source, status,
+when it was added, last used and expires, and its note. In authorized_keys
+format these go in a comment above each line.
+
+--status, --older-than and --newer-than narrow the export. Without a key
+store, users and ages come from the metadata kept beside authorized_keys.`,
+	Example: `  tunnel keys export alice > alice.keys
+  tunnel keys export --format json --metadata --file keys.json
+  tunnel keys export --status active --newer-than 90d`,
+	Args:         cobra.MaximumNArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		user := ""
+		if len(args) > 0 {
+			user = args[0]
+		}
+		return exportKeys(user)
+	},
+}
+
+func init() {
+	keysExportCmd.Flags().StringVar(&keysExportFormat, "format", "authorized_keys", "export format: authorized_keys or json")
+	keysExportCmd.Flags().StringVarP(&keysExportFile, "file", "f", "", "write to this file instead of stdout")
+	keysExportCmd.Flags().StringVar(&keysExportStatus, "status", "", "only keys with this status: active, expired or revoked")
+	keysExportCmd.Flags().StringVar(&keysExportOlderThan, "older-than", "", "only keys added longer ago than this (e.g. 90d, 1y)")
+	keysExportCmd.Flags().StringVar(&keysExportNewerThan, "newer-than", "", "only keys added within this (e.g. 30d)")
+	keysExportCmd.Flags().BoolVar(&keysExportMetadata, "metadata", false, "include each key's user, source, dates and note")
+
+	keysCmd.AddCommand(keysExportCmd)
+}
+
+func exportKeys(user string) error {
+	if keyManager == nil {
+		return fmt.Errorf("key manager not initialized")
+	}
+	if keysExportFormat != "authorized_keys" && keysExportFormat != "json" {
+		return fmt.Errorf("invalid --format %q: use authorized_keys or json", keysExportFormat)
+	}
+	if keysExportStatus != "" && !slices.Contains(keyStatuses, keysExportStatus) {
+		return fmt.Errorf("invalid --status %q (valid: %s)", keysExportStatus, strings.Join(keyStatuses, ", "))
+	}
+	if user != "" {
+		if err := requireKnownKeyUser(user); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	query := core.KeyQuery{User: user, Status: keysExportStatus}
+	if keysExportOlderThan != "" {
+		age, err := core.ParseAlertDuration(keysExportOlderThan)
+		if err != nil {
+			return fmt.Errorf("invalid --older-than: %w", err)
+		}
+		query.AddedBefore = now.Add(-age)
+	}
+	keys, err := keyManager.QueryKeys(query)
+	if err != nil {
+		return fmt.Errorf("failed to list keys: %w", err)
+	}
+	if keysExportNewerThan != "" {
+		age, err := core.ParseAlertDuration(keysExportNewerThan)
+		if err != nil {
+			return fmt.Errorf("invalid --newer-than: %w", err)
+		}
+		keys = slices.DeleteFunc(keys, func(key core.SSHPublicKey) bool {
+			return key.AddedAt.Before(now.Add(-age))
+		})
+	}
+
+	var w io.Writer = os.Stdout
+	if keysExportFile != "" {
+		f, err := os.OpenFile(keysExportFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", keysExportFile, err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if keysExportFormat == "json" {
+		err = core.WriteKeysJSON(w, keys, keysExportMetadata)
+	} else {
+		err = core.WriteAuthorizedKeysFile(w, keys, keysExportMetadata)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to export keys: %w", err)
+	}
+	if keysExportFile != "" {
+		color.Green("✓ Exported %d SSH key(s) to %s", len(keys), keysExportFile)
+	}
+	return nil
+}
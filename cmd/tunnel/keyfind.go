@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/output"
+	"github.com/spf13/cobra"
+)
+
+var keysFindCmd = &cobra.Command{
+	Use:   "find <query>",
+	Short: "Find keys by fingerprint, comment, user or source",
+	Long: `Search the authorized keys for query: fingerprints that start with it,
+with or without the SHA256: prefix, and comments, users, sources and notes
+that contain it, ignoring case. Each match says which field matched.`,
+	Example: `  tunnel keys find SHA256:FJ+gEq
+  tunnel keys find laptop
+  tunnel keys find alice --json`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return findKeys(args[0])
+	},
+}
+
+func init() {
+	keysCmd.AddCommand(keysFindCmd)
+}
+
+func findKeys(query string) error {
+	if keyManager == nil {
+		return fmt.Errorf("key manager not initialized")
+	}
+
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+
+	keys, err := keyManager.QueryKeys(core.KeyQuery{})
+	if err != nil {
+		return fmt.Errorf("failed to list keys: %w", err)
+	}
+	matches := core.SearchKeys(keys, query)
+
+	if format.Structured() {
+		return writeOutput(format, map[string]interface{}{
+			"query":   query,
+			"count":   len(matches),
+			"matches": matches,
+		})
+	}
+
+	if len(matches) == 0 && format == output.FormatTable {
+		color.Yellow("No keys match %q", query)
+		return nil
+	}
+
+	table := newTable("USER", "TYPE", "FINGERPRINT", "COMMENT", "SOURCE", "STATUS", "MATCHED")
+	table.SetMaxWidth(3, 32)
+	table.SetColor(5, colorizeStatus)
+	for _, m := range matches {
+		key := m.Key
+		table.AddRow(orDash(key.User), key.Type, key.Fingerprint, key.Comment, orDash(key.Source), key.Status, m.Field)
+	}
+	return renderTable(format, table)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/output"
+	"github.com/spf13/cobra"
+)
+
+var (
+	keysImportSource     string
+	keysImportFileDryRun bool
+)
+
+var keysImportCmd = &cobra.Command{
+	Use:   "import <user>",
+	Short: "Import a user's SSH keys from a key server",
+	Long: `Import the SSH public keys a user publishes on a key server.
+
+--source picks the server: github, gitlab or codeberg, one of the key_sources
+in the config, or a URL serving authorized_keys lines, with {user} standing
+for the user. Configured sources reach self-hosted GitLab, Gitea and GitHub
+Enterprise, sending the API token in their token_file:
+
+  key_sources:
+    - name: work
+      type: gitlab            # github, gitlab, gitea or url
+      url: https://gitlab.example.com
+      token_file: ~/.config/tunnel/gitlab-token`,
+	Example: `  tunnel keys import alice --source codeberg
+  tunnel keys import alice --source work
+  tunnel keys import alice --source 'https://keys.example.com/{user}.pub'`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return importKeysFromSource(keysImportSource, args[0])
+	},
+}
+
+var keysImportFileCmd = &cobra.Command{
+	Use:   "import-file <user> <path|->",
+	Short: "Import SSH keys from an authorized_keys file",
+	Long: `Import every key of an authorized_keys file, or of stdin with -, for a
+user, such as the file of a server being migrated. Options on the lines are
+kept.
+
+Each key line is reported: added, a duplicate of a key already authorized or
+of an earlier line, or invalid, including weak RSA and DSA keys. The keys
+are added together, and with --dry-run not at all.`,
+	Example: `  tunnel keys import-file alice /home/alice/.ssh/authorized_keys --dry-run
+  ssh old-server cat .ssh/authorized_keys | tunnel keys import-file deploy -`,
+	Args:         cobra.ExactArgs(2),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return importKeysFromFile(args[0], args[1], keysImportFileDryRun)
+	},
+}
+
+func init() {
+	keysImportCmd.Flags().StringVarP(&keysImportSource, "source", "s", "github", "key server: github, gitlab, codeberg, a key_sources name, or a URL")
+	keysImportFileCmd.Flags().BoolVar(&keysImportFileDryRun, "dry-run", false, "show what would be imported without changing anything")
+	keysCmd.AddCommand(keysImportCmd)
+	keysCmd.AddCommand(keysImportFileCmd)
+}
+
+// keyServer resolves --source: a configured key source, which can replace
+// a built-in one, a built-in one, or a URL used as is
+func keyServer(source string) (core.KeyServer, error) {
+	for _, s := range appConfig.KeySources {
+		if s.Name != source {
+			continue
+		}
+		server := core.KeyServer{Name: s.Name, Type: s.Type, BaseURL: s.URL}
+		if s.TokenFile != "" {
+			home, _ := os.UserHomeDir()
+			data, err := os.ReadFile(expandHome(s.TokenFile, home))
+			if err != nil {
+				return server, fmt.Errorf("failed to read token of key source %s: %w", s.Name, err)
+			}
+			server.Token = strings.TrimSpace(string(data))
+		}
+		return server, nil
+	}
+
+	names := make([]string, 0, len(core.BuiltinKeyServers)+len(appConfig.KeySources))
+	for _, s := range core.BuiltinKeyServers {
+		if s.Name == source {
+			return s, nil
+		}
+		names = append(names, s.Name)
+	}
+	if strings.HasPrefix(source, "https://") || strings.HasPrefix(source, "http://") {
+		return core.KeyServer{Name: source, Type: core.KeyServerURL, BaseURL: source}, nil
+	}
+
+	for _, s := range appConfig.KeySources {
+		names = append(names, s.Name)
+	}
+	return core.KeyServer{}, fmt.Errorf("unknown key source %q (available: %s, or a URL)", source, strings.Join(names, ", "))
+}
+
+func importKeysFromSource(source, user string) error {
+	if keyManager == nil {
+		return fmt.Errorf("key manager not initialized")
+	}
+	server, err := keyServer(source)
+	if err != nil {
+		return err
+	}
+
+	if !jsonOutput {
+		color.Cyan("Importing SSH keys of %s from %s", user, server.Name)
+	}
+
+	keys, err := keyManager.ImportFromKeyServer(server, user)
+	if err != nil {
+		if jsonOutput {
+			return printJSON(map[string]interface{}{
+				"status": "error",
+				"error":  err.Error(),
+				"source": server.Name,
+				"user":   user,
+			})
+		}
+		return fmt.Errorf("failed to import keys from %s: %w", server.Name, err)
+	}
+
+	if jsonOutput {
+		return printJSON(map[string]interface{}{
+			"status": "success",
+			"source": server.Name,
+			"user":   user,
+			"count":  len(keys),
+			"keys":   keys,
+		})
+	}
+
+	if len(keys) == 0 {
+		color.Yellow("No SSH keys found for %s on %s", user, server.Name)
+		return nil
+	}
+
+	color.Green("✓ Imported %d SSH key(s) from %s", len(keys), server.Name)
+	fmt.Println()
+
+	for i, key := range keys {
+		fmt.Printf("%d. %s\n", i+1, color.GreenString(key.Type))
+		fmt.Printf("   Fingerprint: %s\n", key.Fingerprint)
+		if key.Comment != "" {
+			fmt.Printf("   Comment:     %s\n", key.Comment)
+		}
+		fmt.Println()
+	}
+
+	return nil
+}
+
+func importKeysFromFile(user, path string, dryRun bool) error {
+	if keyManager == nil {
+		return fmt.Errorf("key manager not initialized")
+	}
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	name := "stdin"
+	if path != "-" {
+		name = path
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open key file: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	results, err := keyManager.ImportAuthorizedKeys(user, r, dryRun)
+	if err != nil {
+		return fmt.Errorf("failed to import keys: %w", err)
+	}
+	added := 0
+	for _, result := range results {
+		if result.Status == core.KeyImportAdded {
+			added++
+		}
+	}
+	if added > 0 && !dryRun {
+		notifyKeyChange(fmt.Sprintf("SSH keys imported for %s", user),
+			fmt.Sprintf("%d key(s) were authorized from %s", added, name))
+	}
+
+	if format.Structured() {
+		return writeOutput(format, map[string]interface{}{
+			"user":    user,
+			"dry_run": dryRun,
+			"added":   added,
+			"skipped": len(results) - added,
+			"results": results,
+		})
+	}
+
+	if len(results) == 0 {
+		color.Yellow("No SSH keys found in %s", name)
+		return nil
+	}
+	table := newTable("LINE", "STATUS", "TYPE", "FINGERPRINT", "COMMENT", "REASON")
+	table.SetMaxWidth(4, 32)
+	table.SetMaxWidth(5, 60)
+	table.SetColor(1, colorizeImportStatus)
+	for _, result := range results {
+		table.AddRow(strconv.Itoa(result.Line), result.Status, orDash(result.Type), orDash(result.Fingerprint),
+			result.Comment, orDash(result.Reason))
+	}
+	if err := renderTable(format, table); err != nil {
+		return err
+	}
+	if format != output.FormatTable {
+		return nil
+	}
+
+	fmt.Println()
+	if dryRun {
+		fmt.Printf("Dry run: %d key(s) would be imported for %s, %d skipped\n", added, user, len(results)-added)
+		return nil
+	}
+	color.Green("✓ Imported %d SSH key(s) for %s, %d skipped", added, user, len(results)-added)
+	return nil
+}
+
+// colorizeImportStatus colors the outcome of an imported key line
+func colorizeImportStatus(status string) string {
+	switch status {
+	case core.KeyImportAdded:
+		return color.GreenString(status)
+	case core.KeyImportDuplicate:
+		return color.YellowString(status)
+	case core.KeyImportInvalid:
+		return color.RedString(status)
+	}
+	return status
+}
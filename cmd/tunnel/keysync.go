@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/output"
+	"github.com/jedarden/tunnel/internal/units"
+	"github.com/spf13/cobra"
+)
+
+// syncDirectoryKeys periodically exports keys managed outside TUNNEL, such
+// as those in LDAP, to authorized_keys so sshd sees directory changes
+func syncDirectoryKeys(ctx context.Context, km *core.FileKeyManager, interval time.Duration) {
+	export := func() {
+		n, err := km.SyncAuthorizedKeys()
+		if err != nil {
+			fmt.Printf("Warning: failed to sync keys from directory: %v\n", err)
+			return
+		}
+		if verbose {
+			fmt.Printf("Synced %d keys from directory to authorized_keys\n", n)
+		}
+	}
+
+	export()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			export()
+		}
+	}
+}
+
+var (
+	keysSyncDryRun bool
+	keysSyncUser   string
+	keysSyncWatch  bool
+)
+
+var keysSyncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Keep users' keys in line with GitHub, GitLab and other key sources",
+	Long: `Fetch the keys of the users in the key_sync section from their accounts on
+key sources. Keys published upstream are added; keys from that kind of
+source that were removed upstream are revoked. Keys added by hand are never
+touched, and a user whose keys can't be fetched keeps them all. Every change
+is written to the audit log.
+
+Syncing runs every key_sync.interval minutes (default 60) while tunnel is
+running; --watch does the same in the foreground.
+
+  key_sync:
+    interval: 30
+    users:
+      - user: alice
+        source: github        # or gitlab, codeberg, a key_sources name
+        account: alice-gh     # default the user`,
+	Example: `  tunnel keys sync --dry-run
+  tunnel keys sync --user alice
+  tunnel keys sync --watch`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if keysSyncWatch {
+			return watchKeySync(cmd.Context())
+		}
+		return runKeySync(keysSyncUser, keysSyncDryRun)
+	},
+}
+
+func init() {
+	keysSyncCmd.Flags().BoolVar(&keysSyncDryRun, "dry-run", false, "show what would be added and revoked without changing anything")
+	keysSyncCmd.Flags().StringVar(&keysSyncUser, "user", "", "only sync this user")
+	keysSyncCmd.Flags().BoolVar(&keysSyncWatch, "watch", false, "keep syncing every key_sync.interval minutes")
+	keysCmd.AddCommand(keysSyncCmd)
+}
+
+// keySyncMappings resolves the key_sync section, or only one user of it
+func keySyncMappings(only string) ([]core.KeySyncMapping, error) {
+	if len(appConfig.KeySync.Users) == 0 {
+		return nil, fmt.Errorf("no users to sync; add them to key_sync.users")
+	}
+
+	var mappings []core.KeySyncMapping
+	for _, u := range appConfig.KeySync.Users {
+		if only != "" && u.User != only {
+			continue
+		}
+		source, account := u.Source, u.Account
+		if source == "" {
+			source = core.GitHubKeyServer.Name
+		}
+		if account == "" {
+			account = u.User
+		}
+		server, err := keyServer(source)
+		if err != nil {
+			return nil, fmt.Errorf("key_sync user %s: %w", u.User, err)
+		}
+		mappings = append(mappings, core.KeySyncMapping{User: u.User, Account: account, Server: server})
+	}
+	if len(mappings) == 0 {
+		return nil, fmt.Errorf("no user named %s in key_sync.users", only)
+	}
+	return mappings, nil
+}
+
+// syncKeys runs a sync of the key_sync users and announces what changed
+func syncKeys(only string, dryRun bool) ([]core.KeySyncResult, error) {
+	if keyManager == nil {
+		return nil, fmt.Errorf("key manager not initialized")
+	}
+	mappings, err := keySyncMappings(only)
+	if err != nil {
+		return nil, err
+	}
+
+	results := keyManager.SyncKeys(mappings, dryRun)
+	if !dryRun {
+		for _, r := range results {
+			if len(r.Added) > 0 || len(r.Removed) > 0 {
+				notifyKeyChange(fmt.Sprintf("SSH keys synced for %s", r.User),
+					fmt.Sprintf("%d key(s) added and %d revoked to match %s on %s", len(r.Added), len(r.Removed), r.Account, r.Source))
+			}
+		}
+	}
+	return results, nil
+}
+
+// keySyncRow is a sync result as printed
+type keySyncRow struct {
+	core.KeySyncResult
+	Error string `json:"error,omitempty"`
+}
+
+func runKeySync(only string, dryRun bool) error {
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+	results, err := syncKeys(only, dryRun)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	rows := make([]keySyncRow, 0, len(results))
+	for _, r := range results {
+		row := keySyncRow{KeySyncResult: r}
+		if r.Err != nil {
+			failed++
+			row.Error = r.Err.Error()
+		}
+		rows = append(rows, row)
+	}
+
+	if format.Structured() {
+		if err := writeOutput(format, map[string]interface{}{"dry_run": dryRun, "users": rows}); err != nil {
+			return err
+		}
+	} else {
+		table := newTable("USER", "SOURCE", "ACCOUNT", "ADDED", "REVOKED", "ERROR")
+		for _, row := range rows {
+			table.AddRow(row.User, row.Source, row.Account,
+				fingerprintList(row.Added), fingerprintList(row.Removed), orDash(row.Error))
+		}
+		if err := renderTable(format, table); err != nil {
+			return err
+		}
+		if dryRun && format == output.FormatTable {
+			fmt.Println("\nDry run: nothing was changed")
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d user(s) could not be synced; their keys were left as they were", failed, len(results))
+	}
+	return nil
+}
+
+// fingerprintList joins fingerprints for a table cell
+func fingerprintList(fingerprints []string) string {
+	if len(fingerprints) == 0 {
+		return "-"
+	}
+	return strings.Join(fingerprints, ", ")
+}
+
+// watchKeySync syncs every key_sync interval until ctx is done, printing
+// each change as it happens
+func watchKeySync(ctx context.Context) error {
+	if _, err := keySyncMappings(""); err != nil {
+		return err
+	}
+	interval := time.Duration(appConfig.KeySync.Minutes()) * time.Minute
+	color.Cyan("Syncing keys every %s; press Ctrl+C to stop", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		results, err := syncKeys("", false)
+		if err != nil {
+			return err
+		}
+		now := units.Clock(time.Now())
+		for _, r := range results {
+			switch {
+			case r.Err != nil:
+				color.Red("%s ✗ %s: %v", now, r.User, r.Err)
+			case len(r.Added) > 0 || len(r.Removed) > 0:
+				color.Green("%s ✓ %s: %d added, %d revoked from %s", now, r.User, len(r.Added), len(r.Removed), r.Source)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// startBackgroundJobs revokes expiring shares, rotates managed keys and
+// syncs keys from key sources until ctx is done, for whichever long-running
+// command manages keys
+func startBackgroundJobs(ctx context.Context) {
+	go runShareSweeper(ctx, time.Minute)
+	go runRotationScheduler(ctx, time.Hour)
+	go runKeySyncScheduler(ctx)
+}
+
+// runKeySyncScheduler syncs the key_sync users every interval until ctx
+// is done
+func runKeySyncScheduler(ctx context.Context) {
+	if keyManager == nil || len(appConfig.KeySync.Users) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(appConfig.KeySync.Minutes()) * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		results, err := syncKeys("", false)
+		if verbose {
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: key sync failed: %v\n", err)
+			}
+			for _, r := range results {
+				if r.Err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: key sync of %s failed: %v\n", r.User, r.Err)
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
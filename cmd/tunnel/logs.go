@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/instance"
+	"github.com/jedarden/tunnel/internal/providers"
+	"github.com/jedarden/tunnel/internal/units"
+	"github.com/spf13/cobra"
+)
+
+var (
+	logsSince  string
+	logsOp     string
+	logsWindow time.Duration
+)
+
+var logsCmd = &cobra.Command{
+	Use:   "logs [provider]",
+	Short: "Show a provider's logs with operations marked",
+	Long: `Show a provider's log lines with a marker where each connect,
+disconnect or failover started, so lines can be read against the
+operation that caused them.
+
+Failed operations name their ID, e.g. "connect attempt #3 (1f2e3d4c)
+failed"; pass it to --op to trace that one operation: its audit entries
+and the log lines around it. The provider can be left out with --op.
+Operations are tracked by the running instance; without one the logs are
+shown unmarked.`,
+	Example: `  tunnel logs cloudflare
+  tunnel logs ngrok --since 24h
+  tunnel logs --op 1f2e3d4c --window 1m`,
+	Args:              cobra.RangeArgs(0, 1),
+	ValidArgsFunction: completeProviderNames,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := ""
+		if len(args) == 1 {
+			name = args[0]
+		} else if logsOp == "" {
+			return fmt.Errorf("name a provider or pass --op")
+		}
+		return showLogs(name, logsSince, logsOp, logsWindow)
+	},
+}
+
+func init() {
+	logsCmd.Flags().StringVar(&logsSince, "since", "1h", "how far back to show logs (e.g. 30m, 24h, 7d)")
+	logsCmd.Flags().StringVar(&logsOp, "op", "", "trace one operation: its audit entries and the lines around it")
+	logsCmd.Flags().DurationVar(&logsWindow, "window", 30*time.Second, "lines this long before and after --op are shown too")
+}
+
+// providerLogs is a provider's log lines and the operations they are
+// tagged with. Tracing one operation adds it and its audit entries.
+type providerLogs struct {
+	Entries    []providers.LogEntry `json:"entries"`
+	Operations []core.Operation     `json:"operations"`
+	Operation  *core.Operation      `json:"operation,omitempty"`
+	Audit      []core.AuditEvent    `json:"audit,omitempty"`
+}
+
+func showLogs(name, since, op string, window time.Duration) error {
+	period, err := core.ParseAlertDuration(since)
+	if err != nil || period == 0 {
+		return fmt.Errorf("invalid --since %q: use a duration such as 30m or 24h", since)
+	}
+
+	holder := runningInstance()
+	var traced *core.Operation
+	if op != "" {
+		if holder == nil || holder.Port == 0 {
+			return fmt.Errorf("operations are tracked by the running instance, and none is running")
+		}
+		if traced, err = fetchOperation(holder, op); err != nil {
+			return err
+		}
+		if name == "" {
+			name = traced.Method
+		}
+	}
+
+	canonical, deprecated := providers.CanonicalName(name)
+	if deprecated {
+		providers.WarnDeprecated("provider name", name, canonical)
+	}
+	if traced != nil && traced.Method != canonical {
+		return fmt.Errorf("operation %s is on %s, not %s", op, traced.Method, canonical)
+	}
+
+	logs, err := fetchInstanceLogs(holder, canonical, period, op, window)
+	if err != nil {
+		return err
+	}
+	if logs == nil {
+		if logs, err = localLogs(canonical, period); err != nil {
+			return err
+		}
+	}
+	if traced != nil {
+		logs.Operation = traced
+		if logs.Audit, err = operationAudit(op, traced.StartedAt.Add(-window)); err != nil {
+			return err
+		}
+	}
+
+	if jsonOutput {
+		return printJSON(logs)
+	}
+	renderLogs(logs)
+	return nil
+}
+
+// fetchOperation looks up an operation by ID on the running instance
+func fetchOperation(holder *instance.Info, id string) (*core.Operation, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := getFromInstance(client, holder, "/api/operations/"+url.PathEscape(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query running instance: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("operation %s not found", id)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("running instance returned %s", resp.Status)
+	}
+
+	var op core.Operation
+	if err := json.NewDecoder(resp.Body).Decode(&op); err != nil {
+		return nil, fmt.Errorf("failed to decode operation: %w", err)
+	}
+	return &op, nil
+}
+
+// fetchInstanceLogs asks a running instance for a provider's logs tagged
+// with its operations. It returns nil without a running instance.
+func fetchInstanceLogs(holder *instance.Info, name string, since time.Duration, op string, window time.Duration) (*providerLogs, error) {
+	if holder == nil || holder.Port == 0 {
+		return nil, nil
+	}
+
+	q := url.Values{}
+	q.Set("since", since.String())
+	q.Set("window", window.String())
+	if op != "" {
+		q.Set("op", op)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := getFromInstance(client, holder, fmt.Sprintf("/api/providers/%s/logs?%s", url.PathEscape(name), q.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query running instance: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound && op != "" {
+		return nil, fmt.Errorf("operation %s not found for %s", op, name)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("running instance returned %s", resp.Status)
+	}
+
+	var logs providerLogs
+	if err := json.NewDecoder(resp.Body).Decode(&logs); err != nil {
+		return nil, fmt.Errorf("failed to decode provider logs: %w", err)
+	}
+	return &logs, nil
+}
+
+// localLogs reads a provider's logs directly, without operation markers
+func localLogs(name string, since time.Duration) (*providerLogs, error) {
+	provider, err := reg.GetProvider(name)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := provider.GetLogs(time.Now().Add(-since))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s logs: %w", name, err)
+	}
+	fmt.Fprintln(os.Stderr, "No running instance; operations are not marked")
+	return &providerLogs{Entries: entries}, nil
+}
+
+// operationAudit returns the audit entries tagged with an operation ID
+func operationAudit(id string, since time.Time) ([]core.AuditEvent, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get home directory: %w", err)
+	}
+	events, err := core.ReadAuditLog(auditLogPath(homeDir), since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	var tagged []core.AuditEvent
+	for _, e := range events {
+		if e.Details["operation_id"] == id {
+			tagged = append(tagged, e)
+		}
+	}
+	return tagged, nil
+}
+
+// renderLogs prints log lines oldest first with a marker line before the
+// first line of each operation, after the traced operation's audit entries
+func renderLogs(logs *providerLogs) {
+	if logs.Operation != nil {
+		printOperationMarker(*logs.Operation)
+		if len(logs.Audit) == 0 {
+			fmt.Println("No audit entries")
+		}
+		for _, e := range logs.Audit {
+			status := color.GreenString("ok")
+			if !e.Success {
+				status = color.RedString("failed")
+			}
+			fmt.Printf("%s audit %s %s %v\n", units.TimeExact(e.Timestamp), e.EventType, status, e.Details["message"])
+		}
+		fmt.Println()
+	}
+
+	entries := logs.Entries
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Timestamp.Before(entries[j].Timestamp) })
+
+	ops := make(map[string]core.Operation, len(logs.Operations))
+	for _, op := range logs.Operations {
+		ops[op.ID] = op
+	}
+
+	if len(entries) == 0 {
+		color.Yellow("No log lines")
+		return
+	}
+
+	current := ""
+	for _, e := range entries {
+		if e.OperationID != current {
+			current = e.OperationID
+			if op, ok := ops[current]; ok {
+				printOperationMarker(op)
+			}
+		}
+		level := e.Level
+		switch level {
+		case "error":
+			level = color.RedString(level)
+		case "warn", "warning":
+			level = color.YellowString(level)
+		}
+		fmt.Printf("%s %-5s %s\n", units.TimeExact(e.Timestamp), level, e.Message)
+	}
+}
+
+// printOperationMarker prints the line that opens an operation's logs
+func printOperationMarker(op core.Operation) {
+	marker := fmt.Sprintf("── %s at %s", op.Label(), units.Clock(op.StartedAt))
+	switch {
+	case op.Error != "":
+		color.Red("%s failed: %s ──", marker, op.Error)
+	case !op.EndedAt.IsZero():
+		color.Cyan("%s done ──", marker)
+	default:
+		color.Cyan("%s ──", marker)
+	}
+}
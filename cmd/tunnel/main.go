@@ -6,7 +6,9 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/jedarden/tunnel/internal/providers"
 	"github.com/jedarden/tunnel/pkg/version"
 	"github.com/spf13/viper"
 )
@@ -41,7 +43,16 @@ func main() {
 		<-sigChan
 		fmt.Fprintln(os.Stderr, "\nReceived interrupt signal, shutting down gracefully...")
 		cancel()
-		os.Exit(0)
+
+		// Give the shutdown sequence below its full budget; a second signal
+		// or a hung teardown forces the process out
+		select {
+		case <-sigChan:
+			fmt.Fprintln(os.Stderr, "Received second interrupt signal, exiting immediately")
+		case <-time.After(shutdownTimeout() + time.Second):
+			fmt.Fprintln(os.Stderr, "Graceful shutdown timed out, exiting")
+		}
+		os.Exit(1)
 	}()
 
 	// Initialize configuration
@@ -50,8 +61,18 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Execute root command
-	if err := Execute(ctx); err != nil {
+	// Execute root command, then tear down whatever it started
+	err := Execute(ctx)
+	cancel()
+
+	if shutdownErr := shutdown(shutdownTimeout()); shutdownErr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", shutdownErr)
+	}
+	if daemonRestart.Load() {
+		restartDaemon()
+	}
+
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
@@ -83,6 +104,8 @@ func initConfig() error {
 		// Config file not found; that's okay, we'll use defaults
 	}
 
+	translateDeprecatedConfigKeys()
+
 	return nil
 }
 
@@ -92,6 +115,7 @@ func setDefaults() {
 	viper.SetDefault("verbose", false)
 	viper.SetDefault("log_level", "info")
 	viper.SetDefault("log_file", "")
+	viper.SetDefault("offline", false)
 
 	// Web server defaults
 	viper.SetDefault("web.port", 8080)
@@ -103,8 +127,8 @@ func setDefaults() {
 	viper.SetDefault("ssh.authorized_keys_file", "$HOME/.ssh/authorized_keys")
 
 	// Provider defaults
-	viper.SetDefault("providers.cloudflared.enabled", true)
-	viper.SetDefault("providers.cloudflared.binary_path", "cloudflared")
+	viper.SetDefault("providers.cloudflare.enabled", true)
+	viper.SetDefault("providers.cloudflare.binary_path", "cloudflared")
 
 	viper.SetDefault("providers.ngrok.enabled", true)
 	viper.SetDefault("providers.ngrok.binary_path", "ngrok")
@@ -123,3 +147,20 @@ func setDefaults() {
 	viper.SetDefault("monitoring.check_interval", 30)
 	viper.SetDefault("monitoring.auto_reconnect", true)
 }
+
+// translateDeprecatedConfigKeys copies settings stored under deprecated
+// provider names, such as providers.cloudflared, to their current key so
+// older config files keep working. A value already set under the current
+// key wins.
+func translateDeprecatedConfigKeys() {
+	for _, key := range viper.AllKeys() {
+		canonical, deprecated := providers.CanonicalConfigKey(key)
+		if !deprecated || !viper.InConfig(key) {
+			continue
+		}
+		providers.WarnDeprecated("config key", key, canonical)
+		if !viper.InConfig(canonical) {
+			viper.Set(canonical, viper.Get(key))
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/migrate"
+	"github.com/jedarden/tunnel/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+var migrateDryRun bool
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Upgrade config and state files to the current format",
+	Long: `Upgrade the config file and state files written by an older release.
+
+Files are migrated automatically when they are loaded; this command applies
+every pending migration at once and reports what changed. Each migrated file
+is first copied to <file>.v<old version>.bak.
+
+Use --dry-run to preview the steps and the resulting changes without writing
+anything.`,
+	Example: `  tunnel migrate --dry-run
+  tunnel migrate`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runMigrations(migrateDryRun)
+	},
+}
+
+func init() {
+	migrateCmd.Flags().BoolVar(&migrateDryRun, "dry-run", false, "show pending migrations and their changes without applying them")
+}
+
+// migrationResult is the JSON form of a migrate.Result
+type migrationResult struct {
+	Name    string   `json:"name"`
+	Path    string   `json:"path"`
+	From    int      `json:"from"`
+	To      int      `json:"to"`
+	Steps   []string `json:"steps,omitempty"`
+	Backup  string   `json:"backup,omitempty"`
+	Applied bool     `json:"applied"`
+}
+
+func runMigrations(dryRun bool) error {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("failed to get home directory: %w", err)
+	}
+
+	configPath := cfgFile
+	if configPath == "" {
+		configPath = config.DefaultPath()
+	}
+
+	targets := []struct {
+		migrator *migrate.Migrator
+		path     string
+	}{
+		{config.Migrations, configPath},
+		{core.ShareMigrations, filepath.Join(homeDir, ".config", "tunnel", "shares.json")},
+	}
+
+	var results []migrationResult
+	for _, target := range targets {
+		result, err := target.migrator.MigrateFile(target.path, dryRun)
+		if err != nil {
+			return fmt.Errorf("failed to migrate %s: %w", target.path, err)
+		}
+
+		entry := migrationResult{
+			Name:    result.Name,
+			Path:    result.Path,
+			From:    result.From,
+			To:      result.To,
+			Backup:  result.Backup,
+			Applied: result.Changed() && !dryRun,
+		}
+		for _, step := range result.Applied {
+			entry.Steps = append(entry.Steps, fmt.Sprintf("v%d: %s", step.Version, step.Description))
+		}
+		results = append(results, entry)
+
+		if jsonOutput {
+			continue
+		}
+		if !result.Changed() {
+			color.Green("✓ %s is current (version %d)", result.Name, result.From)
+			continue
+		}
+
+		fmt.Printf("%s: version %d → %d (%s)\n", result.Name, result.From, result.To, result.Path)
+		for _, step := range entry.Steps {
+			fmt.Printf("  %s\n", step)
+		}
+		if dryRun {
+			printMigrationDiff(migrate.Diff(result.Before, result.After))
+			continue
+		}
+		color.Green("✓ Migrated %s; original saved to %s", result.Name, result.Backup)
+	}
+
+	if jsonOutput {
+		return printJSON(results)
+	}
+	return nil
+}
+
+// printMigrationDiff prints a line diff with added lines in green and
+// removed lines in red
+func printMigrationDiff(diff string) {
+	for _, line := range strings.Split(strings.TrimRight(diff, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "+"):
+			color.Green("    %s", line)
+		case strings.HasPrefix(line, "-"):
+			color.Red("    %s", line)
+		}
+	}
+}
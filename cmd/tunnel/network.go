@@ -0,0 +1,20 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jedarden/tunnel/internal/netwatch"
+	"github.com/jedarden/tunnel/pkg/tunnel"
+)
+
+// watchNetwork re-establishes tunnels when the host changes networks or
+// resumes from sleep. The manager publishes a network change event, so the
+// change shows up in 'tunnel watch' and the web UI.
+func watchNetwork(ctx context.Context, m *tunnel.Manager) {
+	for change := range netwatch.Watch(ctx, netwatch.DefaultInterval) {
+		if err := m.HandleNetworkChange(change.Reason); err != nil && verbose {
+			fmt.Printf("Warning: %v\n", err)
+		}
+	}
+}
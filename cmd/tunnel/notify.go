@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/pkg/config"
+)
+
+// startNotifications sends notifications for connection drops, failures,
+// failovers and recoveries reported by the tunnel manager, to the desktop
+// and to any email, Slack or webhook channels configured. The returned
+// dispatcher is nil when no channel is on.
+func startNotifications(ctx context.Context, cfg *config.Config, publisher *core.EventPublisher) (*core.NotificationDispatcher, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	notifiers := remoteNotifiers(cfg)
+	if cfg.Notifications.Desktop {
+		notifiers = append(notifiers, core.NewDesktopNotifier())
+	}
+	if len(notifiers) == 0 {
+		return nil, nil
+	}
+
+	dispatcher, err := newDispatcher(cfg, notifiers)
+	if err != nil {
+		return nil, err
+	}
+	sub := publisher.Subscribe("notifications", nil)
+	go dispatcher.Run(ctx, sub)
+	return dispatcher, nil
+}
+
+// remoteNotifiers returns the email, Slack and webhook channels configured
+func remoteNotifiers(cfg *config.Config) core.MultiNotifier {
+	var notifiers core.MultiNotifier
+	n := cfg.Notifications
+	if len(n.Email.To) > 0 {
+		notifiers = append(notifiers, &core.EmailNotifier{
+			Mailer: &core.SMTPMailer{
+				Host:     n.Email.Host,
+				Port:     n.Email.Port,
+				Username: n.Email.Username,
+				Password: n.Email.Password,
+				From:     n.Email.From,
+			},
+			To: n.Email.To,
+		})
+	}
+	if n.Slack.WebhookURL != "" {
+		notifiers = append(notifiers, &core.SlackNotifier{WebhookURL: n.Slack.WebhookURL, Channel: n.Slack.Channel})
+	}
+	for _, hook := range n.Webhooks {
+		notifiers = append(notifiers, &core.WebhookNotifier{URL: hook.URL, Headers: hook.Headers})
+	}
+	return notifiers
+}
+
+// newDispatcher wraps notifiers in the event filter and quiet hours of cfg
+func newDispatcher(cfg *config.Config, notifiers core.MultiNotifier) (*core.NotificationDispatcher, error) {
+	quiet, err := core.ParseQuietHours(cfg.Notifications.QuietHours.Start, cfg.Notifications.QuietHours.End)
+	if err != nil {
+		return nil, err
+	}
+
+	enabled := make(map[core.NotificationEvent]bool)
+	for _, event := range core.NotificationEvents {
+		enabled[event] = cfg.Notifications.EventEnabled(string(event))
+	}
+	return core.NewNotificationDispatcher(notifiers, enabled, quiet), nil
+}
+
+// notifyKeyChange reports a change to the SSH keys on the email, Slack and
+// webhook channels. The desktop is left out: whoever is at it made the
+// change. It reports whether any channel is configured; delivery failures
+// are warned about rather than failing the command that changed the keys.
+func notifyKeyChange(title, message string) bool {
+	if appConfig == nil {
+		return false
+	}
+	notifiers := remoteNotifiers(appConfig)
+	if len(notifiers) == 0 {
+		return false
+	}
+
+	dispatcher, err := newDispatcher(appConfig, notifiers)
+	if err == nil {
+		err = dispatcher.Notify(core.Notification{
+			Event:   core.NotifyKey,
+			Title:   title,
+			Message: message,
+			Time:    time.Now(),
+		})
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to send key notification: %v\n", err)
+	}
+	return true
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/output"
+)
+
+var (
+	outputFlag string
+	noHeaders  bool
+)
+
+// outputFormat resolves --output, treating --json as --output json
+func outputFormat() (output.Format, error) {
+	if outputFlag == "" && jsonOutput {
+		return output.FormatJSON, nil
+	}
+	return output.ParseFormat(outputFlag)
+}
+
+// writeOutput prints data in a structured format on stdout
+func writeOutput(format output.Format, data interface{}) error {
+	return output.Write(os.Stdout, format, data)
+}
+
+// newTable creates a table that honors --no-headers
+func newTable(headers ...string) *output.Table {
+	t := output.NewTable(headers...)
+	t.NoHeaders = noHeaders
+	return t
+}
+
+// renderTable prints a table on stdout, as CSV when requested
+func renderTable(format output.Format, t *output.Table) error {
+	if format == output.FormatCSV {
+		return t.WriteCSV(os.Stdout)
+	}
+	return t.Render(os.Stdout)
+}
+
+// colorizeState colors the connection and install states shown in tables
+func colorizeState(state string) string {
+	switch strings.TrimSpace(state) {
+	case "connected", "installed", "yes":
+		return color.GreenString(state)
+	case "not installed", "failed", "expired":
+		return color.RedString(state)
+	case "disconnected", "offline":
+		return color.YellowString(state)
+	default:
+		return state
+	}
+}
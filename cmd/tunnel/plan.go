@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/offline"
+	"github.com/jedarden/tunnel/internal/providers"
+)
+
+var (
+	startDryRun bool
+	stopDryRun  bool
+)
+
+func init() {
+	startCmd.Flags().BoolVar(&startDryRun, "dry-run", false, "show the commands, ports and config the start would use without running anything")
+	stopCmd.Flags().BoolVar(&stopDryRun, "dry-run", false, "show the commands the stop would run without running anything")
+}
+
+// planConnections prints what connecting or disconnecting method would do.
+// For a disconnect, method may be "all".
+func planConnections(method, action string) error {
+	var targets []providers.Provider
+	if action == providers.ActionDisconnect && method == "all" {
+		targets = reg.GetConnectedProviders()
+	} else {
+		provider, err := reg.GetProvider(method)
+		if err != nil {
+			return err
+		}
+		targets = []providers.Provider{provider}
+	}
+
+	plans := make([]*providers.Plan, 0, len(targets))
+	for _, provider := range targets {
+		if action == providers.ActionConnect {
+			useStoredCredential(provider)
+		}
+		plan, err := providers.PlanFor(provider, action)
+		if err != nil {
+			return fmt.Errorf("failed to plan %s %s: %w", action, provider.Name(), err)
+		}
+		switch {
+		case action == providers.ActionConnect && provider.IsConnected():
+			plan.Note("%s is already connected; nothing would be run", provider.Name())
+		case action == providers.ActionDisconnect && !provider.IsConnected():
+			plan.Note("%s is not connected; nothing would be run", provider.Name())
+		case action == providers.ActionConnect && !provider.IsInstalled():
+			plan.Note("%s is not installed; the start would fail", provider.Name())
+		case action == providers.ActionConnect && offline.Enabled() && providers.RequiresInternet(provider):
+			plan.Note("offline mode is on; the start would be refused")
+		}
+		plans = append(plans, plan)
+	}
+
+	if jsonOutput {
+		return printJSON(map[string]interface{}{"dry_run": true, "plans": plans})
+	}
+	if len(plans) == 0 {
+		color.Yellow("No active connections; nothing would be stopped")
+		return nil
+	}
+	for i, plan := range plans {
+		if i > 0 {
+			fmt.Println()
+		}
+		printPlan(plan)
+	}
+	return nil
+}
+
+// printPlan prints a plan for review
+func printPlan(plan *providers.Plan) {
+	color.Cyan("Dry run: %s %s", plan.Action, plan.Provider)
+
+	fmt.Println("  Commands:")
+	if len(plan.Commands) == 0 {
+		fmt.Println("    (none)")
+	}
+	for _, cmd := range plan.Commands {
+		line := "    " + cmd.String()
+		if cmd.Background {
+			line += color.HiBlackString("  [background]")
+		}
+		if cmd.Sandboxed {
+			line += color.HiBlackString("  [sandboxed]")
+		}
+		if cmd.Condition != "" {
+			line += color.HiBlackString("  [if %s]", cmd.Condition)
+		}
+		fmt.Println(line)
+	}
+
+	if len(plan.Ports) > 0 {
+		fmt.Println("  Ports:")
+		for _, p := range plan.Ports {
+			fmt.Printf("    %d/%s %-6s %s\n", p.Port, p.Protocol, p.Side, p.Description)
+		}
+	}
+
+	if len(plan.Config) > 0 {
+		fmt.Println("  Config:")
+		keys := make([]string, 0, len(plan.Config))
+		for k := range plan.Config {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("    %s: %s\n", k, plan.Config[k])
+		}
+	}
+
+	for _, note := range plan.Notes {
+		color.Yellow("  Note: %s", note)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/output"
+	"github.com/jedarden/tunnel/internal/tui"
+	"github.com/jedarden/tunnel/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	profileDescription   string
+	profileDefaultMethod string
+	profileFailover      []string
+	profileForce         bool
+)
+
+var profileCmd = &cobra.Command{
+	Use:     "profile",
+	Aliases: []string{"profiles"},
+	Short:   "Switch between named setups",
+	Long: `Save and switch between named setups such as home, work or demo. A
+profile holds the default method, the failover order of the methods it
+enables (the others are disabled), method settings such as ports, and the
+SSH key policy: allowed users and TCP and agent forwarding.
+
+Profiles are kept in the profiles section of the config; settings.profile
+names the one last applied. The TUI switches profiles with p.`,
+}
+
+var profileListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List profiles",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return listProfiles()
+	},
+}
+
+var profileCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Save the current setup as a profile",
+	Long: `Save the current setup as a profile: the default method, the enabled
+methods in failover order with their settings, and the SSH key policy.
+Flags change what is saved without touching the current setup.`,
+	Example: `  tunnel profile create home
+  tunnel profile create demo --default-method tailscale --failover tailscale,wireguard --description "conference wifi"`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return createProfile(args[0])
+	},
+}
+
+var profileApplyCmd = &cobra.Command{
+	Use:   "apply <name>",
+	Short: "Switch to a profile",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return applyProfile(args[0])
+	},
+}
+
+var profileDeleteCmd = &cobra.Command{
+	Use:   "delete <name>",
+	Short: "Delete a profile, leaving the current setup as it is",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return deleteProfile(args[0])
+	},
+}
+
+func init() {
+	profileCreateCmd.Flags().StringVar(&profileDescription, "description", "", "what the profile is for")
+	profileCreateCmd.Flags().StringVar(&profileDefaultMethod, "default-method", "", "default method (default: the current one)")
+	profileCreateCmd.Flags().StringSliceVar(&profileFailover, "failover", nil, "methods to enable, first tried first (default: the enabled ones)")
+	profileCreateCmd.Flags().BoolVar(&profileForce, "force", false, "replace an existing profile")
+
+	profileCmd.AddCommand(profileListCmd)
+	profileCmd.AddCommand(profileCreateCmd)
+	profileCmd.AddCommand(profileApplyCmd)
+	profileCmd.AddCommand(profileDeleteCmd)
+}
+
+// profileInfo is a profile as listed
+type profileInfo struct {
+	Name          string   `json:"name" yaml:"name"`
+	Active        bool     `json:"active" yaml:"active"`
+	Description   string   `json:"description,omitempty" yaml:"description,omitempty"`
+	DefaultMethod string   `json:"default_method,omitempty" yaml:"default_method,omitempty"`
+	Failover      []string `json:"failover,omitempty" yaml:"failover,omitempty"`
+}
+
+func profileInfos() []profileInfo {
+	infos := []profileInfo{}
+	for _, name := range appConfig.ProfileNames() {
+		p := appConfig.Profiles[name]
+		infos = append(infos, profileInfo{
+			Name:          name,
+			Active:        name == appConfig.Settings.Profile,
+			Description:   p.Description,
+			DefaultMethod: p.DefaultMethod,
+			Failover:      p.Failover,
+		})
+	}
+	return infos
+}
+
+func listProfiles() error {
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+
+	infos := profileInfos()
+	if format.Structured() {
+		return writeOutput(format, infos)
+	}
+	if len(infos) == 0 && format == output.FormatTable {
+		color.Yellow("No profiles defined; save the current setup with tunnel profile create")
+		return nil
+	}
+
+	table := newTable("NAME", "ACTIVE", "DEFAULT", "FAILOVER", "DESCRIPTION")
+	for _, p := range infos {
+		active := ""
+		if p.Active {
+			active = "*"
+		}
+		failover := strings.Join(p.Failover, " → ")
+		if failover == "" {
+			failover = "-"
+		}
+		table.AddRow(p.Name, active, p.DefaultMethod, failover, p.Description)
+	}
+	return renderTable(format, table)
+}
+
+func createProfile(name string) error {
+	if _, ok := appConfig.Profiles[name]; ok && !profileForce {
+		return fmt.Errorf("profile %s already exists; use --force to replace it", name)
+	}
+
+	p := appConfig.CurrentProfile()
+	p.Description = profileDescription
+	if profileDefaultMethod != "" {
+		p.DefaultMethod = profileDefaultMethod
+	}
+	if len(profileFailover) > 0 {
+		p.Failover = profileFailover
+		// Keep the settings of the methods the profile enables
+		for method := range p.Settings {
+			if !slices.Contains(p.Failover, method) {
+				delete(p.Settings, method)
+			}
+		}
+	}
+
+	previous, existed := appConfig.Profiles[name]
+	if appConfig.Profiles == nil {
+		appConfig.Profiles = make(map[string]config.ProfileConfig)
+	}
+	appConfig.Profiles[name] = p
+	if err := appConfig.Validate(); err != nil {
+		if existed {
+			appConfig.Profiles[name] = previous
+		} else {
+			delete(appConfig.Profiles, name)
+		}
+		return err
+	}
+	if err := appConfig.Save(); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	color.Green("✓ Saved profile %s: %s", name, describeProfile(p))
+	return nil
+}
+
+func applyProfile(name string) error {
+	if err := appConfig.ApplyProfile(name); err != nil {
+		return err
+	}
+	if err := appConfig.Validate(); err != nil {
+		return fmt.Errorf("profile %s leaves the config invalid: %w", name, err)
+	}
+	if err := appConfig.Save(); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	color.Green("✓ Switched to profile %s: %s", name, describeProfile(appConfig.Profiles[name]))
+	if daemonClient() != nil {
+		fmt.Println("Connections already up keep their settings until reconnected")
+	}
+	return nil
+}
+
+func deleteProfile(name string) error {
+	if _, ok := appConfig.Profiles[name]; !ok {
+		return fmt.Errorf("profile not found: %s", name)
+	}
+	delete(appConfig.Profiles, name)
+	if appConfig.Settings.Profile == name {
+		appConfig.Settings.Profile = ""
+	}
+	if err := appConfig.Save(); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	color.Green("✓ Deleted profile %s", name)
+	return nil
+}
+
+// describeProfile summarizes a profile in one line
+func describeProfile(p config.ProfileConfig) string {
+	var parts []string
+	if p.DefaultMethod != "" {
+		parts = append(parts, "default "+p.DefaultMethod)
+	}
+	if len(p.Failover) > 0 {
+		parts = append(parts, "failover "+strings.Join(p.Failover, " → "))
+	}
+	if p.Keys != nil {
+		users := "any user"
+		if len(p.Keys.AllowedUsers) > 0 {
+			users = strings.Join(p.Keys.AllowedUsers, ", ")
+		}
+		parts = append(parts, "keys for "+users)
+	}
+	if len(parts) == 0 {
+		return "no changes"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// tuiProfiles lists the profiles for the TUI's profile picker
+func tuiProfiles() []tui.Profile {
+	var profiles []tui.Profile
+	for _, p := range profileInfos() {
+		profiles = append(profiles, tui.Profile{Name: p.Name, Description: p.Description, Active: p.Active})
+	}
+	return profiles
+}
+
+// applyTUIProfile switches profiles from the TUI, without printing
+func applyTUIProfile(name string) error {
+	if err := appConfig.ApplyProfile(name); err != nil {
+		return err
+	}
+	if err := appConfig.Validate(); err != nil {
+		return err
+	}
+	return appConfig.Save()
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/daemon"
+	"github.com/jedarden/tunnel/internal/providers"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// promptTimeout bounds the whole daemon round trip; a prompt that
+	// waits longer than this is noticeably slow
+	promptTimeout = 100 * time.Millisecond
+
+	// promptRefresh is how often the daemon re-checks the health of its
+	// connections for the prompt cache
+	promptRefresh = 5 * time.Second
+)
+
+var promptFormat string
+
+var promptCmd = &cobra.Command{
+	Use:   "prompt",
+	Short: "Print a short connection segment for shell prompts",
+	Long: `Print the active connection, its health and its latency in a form shell
+prompts can embed, such as "bore ✓ 42ms". With more than one connection
+the rest are counted, as in "bore ✓ 42ms +2".
+
+The segment comes from state the daemon keeps cached, so it answers within
+100ms. When the daemon isn't running or nothing is connected, nothing is
+printed and the command still succeeds, leaving the prompt unchanged.
+
+Formats:
+  starship    plain text, for a starship custom module
+  powerlevel  text with zsh color escapes, for a powerlevel10k segment
+  json        the cached state of every connection`,
+	Example: `  # ~/.config/starship.toml
+  [custom.tunnel]
+  command = "tunnel prompt --format starship"
+  when = true
+  format = "[$output]($style) "
+
+  # ~/.p10k.zsh
+  function prompt_tunnel() {
+    p10k segment -t "$(tunnel prompt --format powerlevel)"
+  }`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return showPrompt(promptFormat)
+	},
+}
+
+func init() {
+	promptCmd.Flags().StringVar(&promptFormat, "format", "starship", "segment format: starship, powerlevel or json")
+}
+
+// promptConnection is the prompt's view of one connected provider
+type promptConnection struct {
+	Name    string        `json:"name"`
+	Health  string        `json:"health"` // healthy, degraded or unhealthy
+	Latency time.Duration `json:"latency,omitempty"`
+}
+
+// promptState is what the daemon caches for `tunnel prompt`
+type promptState struct {
+	Connections []promptConnection `json:"connections"`
+	UpdatedAt   time.Time          `json:"updated_at"`
+}
+
+// promptCache holds the daemon's latest prompt state. Health checks can
+// take seconds, so they run in the background and the prompt op only ever
+// reads.
+type promptCache struct {
+	mu    sync.RWMutex
+	state promptState
+}
+
+// run refreshes the cache every interval until ctx is done
+func (c *promptCache) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		state := collectPromptState()
+		c.mu.Lock()
+		c.state = state
+		c.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// get returns the cached state
+func (c *promptCache) get() promptState {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.state
+}
+
+// handlePromptOp keeps the prompt cache fresh for as long as ctx lasts and
+// answers the prompt op from it
+func handlePromptOp(ctx context.Context, server *daemon.Server) {
+	cache := &promptCache{}
+	go cache.run(ctx, promptRefresh)
+	server.Handle("prompt", func(ctx context.Context, args json.RawMessage) (any, error) {
+		return scopePrompt(cache.get(), daemon.CallerFrom(ctx)), nil
+	})
+}
+
+// collectPromptState checks the health of this process's connected
+// providers, healthiest and fastest first
+func collectPromptState() promptState {
+	state := promptState{Connections: []promptConnection{}, UpdatedAt: time.Now()}
+	for _, provider := range reg.GetConnectedProviders() {
+		conn := promptConnection{Name: provider.Name(), Health: "unhealthy"}
+		if health, err := providers.CheckHealth(provider); err == nil && health != nil {
+			conn.Latency = health.Latency
+			switch {
+			case !health.Healthy:
+			case health.Status == "degraded":
+				conn.Health = "degraded"
+			default:
+				conn.Health = "healthy"
+			}
+		}
+		state.Connections = append(state.Connections, conn)
+	}
+
+	rank := map[string]int{"healthy": 0, "degraded": 1, "unhealthy": 2}
+	sort.SliceStable(state.Connections, func(i, j int) bool {
+		a, b := state.Connections[i], state.Connections[j]
+		if rank[a.Health] != rank[b.Health] {
+			return rank[a.Health] < rank[b.Health]
+		}
+		if a.Latency != b.Latency {
+			return a.Latency < b.Latency
+		}
+		return a.Name < b.Name
+	})
+	return state
+}
+
+// showPrompt prints the prompt segment from the daemon's cached state.
+// Prompts are drawn constantly, so an absent or slow daemon prints nothing
+// rather than an error.
+func showPrompt(format string) error {
+	switch format {
+	case "starship", "powerlevel", "json":
+	default:
+		return fmt.Errorf("unknown prompt format %q: expected starship, powerlevel or json", format)
+	}
+
+	var state promptState
+	if client := daemonClient(); client != nil {
+		client.Timeout = promptTimeout
+		if err := client.Call("prompt", nil, &state); err != nil && verbose {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+
+	if format == "json" {
+		if state.Connections == nil {
+			state.Connections = []promptConnection{}
+		}
+		return printJSON(state)
+	}
+	if segment := promptSegment(state, format == "powerlevel"); segment != "" {
+		fmt.Println(segment)
+	}
+	return nil
+}
+
+// promptSegment renders the first connection and a count of the rest. zsh
+// wraps it in %F color escapes, which powerlevel10k passes through.
+func promptSegment(state promptState, zsh bool) string {
+	if len(state.Connections) == 0 {
+		return ""
+	}
+	conn := state.Connections[0]
+
+	glyph, zshColor := "✓", "green"
+	switch conn.Health {
+	case "degraded":
+		glyph, zshColor = "~", "yellow"
+	case "unhealthy":
+		glyph, zshColor = "✗", "red"
+	}
+	if zsh {
+		glyph = "%F{" + zshColor + "}" + glyph + "%f"
+	}
+
+	parts := []string{conn.Name, glyph}
+	if conn.Latency > 0 {
+		parts = append(parts, fmt.Sprintf("%dms", conn.Latency.Milliseconds()))
+	}
+	if extra := len(state.Connections) - 1; extra > 0 {
+		parts = append(parts, fmt.Sprintf("+%d", extra))
+	}
+	return strings.Join(parts, " ")
+}
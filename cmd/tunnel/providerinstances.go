@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/daemon"
+	"github.com/jedarden/tunnel/internal/output"
+	"github.com/jedarden/tunnel/internal/providers"
+	"github.com/jedarden/tunnel/internal/registry"
+	"github.com/jedarden/tunnel/internal/units"
+	"github.com/spf13/cobra"
+)
+
+var (
+	instanceName       string
+	instanceLocalPort  int
+	instanceRemoteHost string
+	instanceRemotePort int
+	instanceTunnelName string
+)
+
+var providerInstanceCmd = &cobra.Command{
+	Use:   "instance",
+	Short: "Manage several instances of a provider",
+	Long: `Run more than one tunnel of the same provider, each with a config of its
+own, e.g. a bore tunnel for staging and another for production. Instances
+get an ID when they are created and are started and stopped by it; the
+display name is for people.
+
+Instances are kept in ~/.config/tunnel/state.json, config included, until
+deleted, and tunnel start --restore brings back those that were up. They
+run in the daemon when one is up, and tunnel status lists them.`,
+}
+
+var providerInstanceCreateCmd = &cobra.Command{
+	Use:   "create <provider>",
+	Short: "Create an instance of a provider",
+	Long: `Create an instance of a provider, starting from the provider's current
+config with the flags given replacing its values. The instance is not
+started.`,
+	Example: `  tunnel instance create bore --name staging --local-port 3000
+  tunnel instance create reverse-ssh --name backup --remote-host relay.example.com --remote-port 2222`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeProviderNames,
+	SilenceUsage:      true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return createProviderInstance(args[0])
+	},
+}
+
+var providerInstanceStartCmd = &cobra.Command{
+	Use:               "start <id>",
+	Short:             "Start an instance",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeInstanceIDs,
+	SilenceUsage:      true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runProviderInstanceOp("instance-start", args[0])
+	},
+}
+
+var providerInstanceStopCmd = &cobra.Command{
+	Use:               "stop <id>",
+	Short:             "Stop an instance",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeInstanceIDs,
+	SilenceUsage:      true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runProviderInstanceOp("instance-stop", args[0])
+	},
+}
+
+var providerInstanceListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List instances and whether they are connected",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return listProviderInstances()
+	},
+}
+
+var providerInstanceDeleteCmd = &cobra.Command{
+	Use:               "delete <id>",
+	Short:             "Stop an instance and forget it",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeInstanceIDs,
+	SilenceUsage:      true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runProviderInstanceOp("instance-delete", args[0])
+	},
+}
+
+func init() {
+	providerInstanceCreateCmd.Flags().StringVar(&instanceName, "name", "", "display name (default: the instance ID)")
+	providerInstanceCreateCmd.Flags().IntVar(&instanceLocalPort, "local-port", 0, "local port to expose")
+	providerInstanceCreateCmd.Flags().StringVar(&instanceRemoteHost, "remote-host", "", "server to connect to")
+	providerInstanceCreateCmd.Flags().IntVar(&instanceRemotePort, "remote-port", 0, "port to expose it on")
+	providerInstanceCreateCmd.Flags().StringVar(&instanceTunnelName, "tunnel-name", "", "tunnel name, for providers that name their tunnels")
+
+	providerInstanceCmd.AddCommand(providerInstanceCreateCmd)
+	providerInstanceCmd.AddCommand(providerInstanceStartCmd)
+	providerInstanceCmd.AddCommand(providerInstanceStopCmd)
+	providerInstanceCmd.AddCommand(providerInstanceListCmd)
+	providerInstanceCmd.AddCommand(providerInstanceDeleteCmd)
+}
+
+var (
+	instancesMu sync.Mutex
+	instances   *registry.InstanceManager
+)
+
+// newInstanceManager returns an instance manager for reg, limited as the
+// settings say
+func newInstanceManager() *registry.InstanceManager {
+	im := registry.NewInstanceManager(reg)
+	if appConfig != nil {
+		im.SetLimits(appConfig.Settings.MaxConnections, appConfig.Settings.MaxInstancesPerProvider)
+	}
+	return im
+}
+
+// providerInstances returns this process's provider instances, loaded from
+// the state file the first time without connecting any. Changes to them
+// are saved back.
+func providerInstances() (*registry.InstanceManager, error) {
+	instancesMu.Lock()
+	defer instancesMu.Unlock()
+	if instances != nil {
+		return instances, nil
+	}
+
+	store, err := stateStore()
+	if err != nil {
+		return nil, err
+	}
+	saved, err := store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read connection state: %w", err)
+	}
+	im := newInstanceManager()
+	for id, err := range im.Load(saved.Instances) {
+		if verbose {
+			fmt.Fprintf(os.Stderr, "Warning: skipping instance %s: %v\n", id, err)
+		}
+	}
+	im.SetStateStore(store)
+	instances = im
+	return im, nil
+}
+
+// adoptInstances makes im this process's provider instances, unless they
+// have been loaded already
+func adoptInstances(im *registry.InstanceManager) {
+	instancesMu.Lock()
+	defer instancesMu.Unlock()
+	if instances == nil {
+		instances = im
+	}
+}
+
+// instanceInfos returns the summary of every instance, sorted by ID
+func instanceInfos(im *registry.InstanceManager) []registry.InstanceInfo {
+	infos := im.GetInstanceInfo()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+// instanceInfo returns the summary of the instance with id
+func instanceInfo(im *registry.InstanceManager, id string) (registry.InstanceInfo, error) {
+	if _, err := im.GetInstance(id); err != nil {
+		return registry.InstanceInfo{}, err
+	}
+	for _, info := range im.GetInstanceInfo() {
+		if info.ID == id {
+			return info, nil
+		}
+	}
+	return registry.InstanceInfo{}, fmt.Errorf("instance not found: %s", id)
+}
+
+// instanceCreateRequest asks for an instance of Provider with Config
+type instanceCreateRequest struct {
+	Provider string                    `json:"provider"`
+	Name     string                    `json:"name,omitempty"`
+	Config   *providers.ProviderConfig `json:"config"`
+}
+
+// createInstance creates an instance in this process
+func createInstance(req instanceCreateRequest) (registry.InstanceInfo, error) {
+	im, err := providerInstances()
+	if err != nil {
+		return registry.InstanceInfo{}, err
+	}
+	instance, err := im.CreateInstance(req.Provider, req.Name, req.Config)
+	if err != nil {
+		return registry.InstanceInfo{}, err
+	}
+	return instanceInfo(im, instance.ID)
+}
+
+// applyInstanceOp starts, stops or deletes the instance with id in this
+// process and returns what it looks like afterwards
+func applyInstanceOp(op, id string) (registry.InstanceInfo, error) {
+	im, err := providerInstances()
+	if err != nil {
+		return registry.InstanceInfo{}, err
+	}
+	info, err := instanceInfo(im, id)
+	if err != nil {
+		return info, err
+	}
+
+	switch op {
+	case "instance-start":
+		err = im.ConnectInstance(id)
+	case "instance-stop":
+		err = im.DisconnectInstance(id)
+	case "instance-delete":
+		if err := im.DeleteInstance(id); err != nil {
+			return info, err
+		}
+		info.Status = "deleted"
+		return info, nil
+	default:
+		return info, fmt.Errorf("unknown instance operation %q", op)
+	}
+	if err != nil {
+		return info, err
+	}
+	return instanceInfo(im, id)
+}
+
+// handleInstanceOps registers the daemon's provider instance operations.
+// Instances are the daemon's own, like forwards, so changing them is for
+// callers who may control the daemon, and seeing them for callers who may
+// see the daemon's own user.
+func handleInstanceOps(server *daemon.Server) {
+	server.Handle("instances", func(ctx context.Context, args json.RawMessage) (any, error) {
+		if !canSeeDaemonOwned(daemon.CallerFrom(ctx)) {
+			return []registry.InstanceInfo{}, nil
+		}
+		im, err := providerInstances()
+		if err != nil {
+			return nil, err
+		}
+		return instanceInfos(im), nil
+	})
+	server.HandleAdmin("instance-create", func(ctx context.Context, args json.RawMessage) (any, error) {
+		var req instanceCreateRequest
+		if err := json.Unmarshal(args, &req); err != nil {
+			return nil, err
+		}
+		return createInstance(req)
+	})
+	for _, op := range []string{"instance-start", "instance-stop", "instance-delete"} {
+		server.HandleAdmin(op, func(ctx context.Context, args json.RawMessage) (any, error) {
+			var id string
+			if err := json.Unmarshal(args, &id); err != nil {
+				return nil, err
+			}
+			return applyInstanceOp(op, id)
+		})
+	}
+}
+
+func createProviderInstance(providerName string) error {
+	provider, err := reg.GetProvider(providerName)
+	if err != nil {
+		return err
+	}
+
+	// The provider's config carries its credentials and defaults; the
+	// instance gets a copy of its own
+	cfg := *providerConfig(provider)
+	cfg.Name = provider.Name()
+	cfg.Extra = maps.Clone(cfg.Extra)
+	if instanceLocalPort != 0 {
+		cfg.LocalPort = instanceLocalPort
+	}
+	if instanceRemoteHost != "" {
+		cfg.RemoteHost = instanceRemoteHost
+	}
+	if instanceRemotePort != 0 {
+		cfg.RemotePort = instanceRemotePort
+	}
+	if instanceTunnelName != "" {
+		cfg.TunnelName = instanceTunnelName
+	}
+	if err := provider.ValidateConfig(&cfg); err != nil {
+		return fmt.Errorf("invalid config for %s: %w", provider.Name(), err)
+	}
+
+	req := instanceCreateRequest{Provider: provider.Name(), Name: instanceName, Config: &cfg}
+	var info registry.InstanceInfo
+	if client := daemonClient(); client != nil {
+		err = client.Call("instance-create", req, &info)
+	} else {
+		info, err = createInstance(req)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create instance: %w", err)
+	}
+
+	if jsonOutput {
+		return printJSON(info)
+	}
+	color.Green("✓ Created %s instance %s", info.ProviderName, info.ID)
+	if info.DisplayName != info.ID {
+		fmt.Printf("  Name: %s\n", info.DisplayName)
+	}
+	fmt.Printf("\nStart it with `tunnel instance start %s`\n", info.ID)
+	return nil
+}
+
+// runProviderInstanceOp starts, stops or deletes an instance, in the daemon
+// when one is running
+func runProviderInstanceOp(op, id string) error {
+	var info registry.InstanceInfo
+	var err error
+	if client := daemonClient(); client != nil {
+		err = client.Call(op, id, &info)
+	} else {
+		info, err = applyInstanceOp(op, id)
+	}
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		return printJSON(info)
+	}
+	switch op {
+	case "instance-start":
+		color.Green("✓ Started instance %s (%s)", info.ID, info.DisplayName)
+	case "instance-stop":
+		color.Green("✓ Stopped instance %s (%s)", info.ID, info.DisplayName)
+	case "instance-delete":
+		color.Green("✓ Deleted instance %s (%s)", info.ID, info.DisplayName)
+	}
+	return nil
+}
+
+// fetchProviderInstances returns the instances, from the daemon when one
+// is running
+func fetchProviderInstances() ([]registry.InstanceInfo, error) {
+	if client := daemonClient(); client != nil {
+		var infos []registry.InstanceInfo
+		if err := client.Call("instances", nil, &infos); err != nil {
+			return nil, err
+		}
+		return infos, nil
+	}
+	im, err := providerInstances()
+	if err != nil {
+		return nil, err
+	}
+	return instanceInfos(im), nil
+}
+
+func listProviderInstances() error {
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+	infos, err := fetchProviderInstances()
+	if err != nil {
+		return err
+	}
+
+	if format.Structured() {
+		if infos == nil {
+			infos = []registry.InstanceInfo{}
+		}
+		return writeOutput(format, map[string]interface{}{"instances": infos})
+	}
+	if len(infos) == 0 && format == output.FormatTable {
+		color.Yellow("No provider instances; create one with tunnel instance create")
+		return nil
+	}
+	return renderTable(format, instancesTable(infos))
+}
+
+func instancesTable(infos []registry.InstanceInfo) *output.Table {
+	table := newTable("ID", "NAME", "PROVIDER", "STATUS", "SINCE", "ERROR")
+	table.SetColor(3, colorizeState)
+	table.SetMaxWidth(5, 48)
+	for _, info := range infos {
+		since := "-"
+		if info.ConnectedAt != nil {
+			since = units.Time(*info.ConnectedAt)
+		}
+		table.AddRow(info.ID, info.DisplayName, info.ProviderName, info.Status, since, orDash(info.LastError))
+	}
+	return table
+}
+
+// completeInstanceIDs completes the IDs of saved provider instances
+func completeInstanceIDs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	infos, err := fetchProviderInstances()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	ids := make([]string, 0, len(infos))
+	for _, info := range infos {
+		ids = append(ids, info.ID)
+	}
+	return ids, cobra.ShellCompDirectiveNoFileComp
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jedarden/tunnel/internal/daemon"
+	"github.com/jedarden/tunnel/internal/web/middleware"
+	"github.com/jedarden/tunnel/pkg/config"
+)
+
+// proxyAuth returns the middleware accepting identities from the reverse
+// proxy configured in api.proxy_auth, scoped by the rbac section
+func proxyAuth(cfg *config.Config) (fiber.Handler, error) {
+	pa := cfg.API.ProxyAuth
+	home, _ := os.UserHomeDir()
+
+	mc := middleware.ProxyAuthConfig{
+		UserHeader:   pa.UserHeader,
+		GroupsHeader: pa.GroupsHeader,
+		SecretHeader: pa.SecretHeader,
+		ClientCert:   pa.ClientCA != "",
+		ProxyNames:   pa.ProxyNames,
+	}
+	if pa.SecretFile != "" {
+		data, err := os.ReadFile(expandHome(pa.SecretFile, home))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read proxy secret: %w", err)
+		}
+		if mc.Secret = strings.TrimSpace(string(data)); mc.Secret == "" {
+			return nil, fmt.Errorf("proxy secret file %s is empty", pa.SecretFile)
+		}
+	}
+
+	// A proxy user is matched like an OS user of the daemon, but is never
+	// root
+	policy := rbacPolicy(cfg)
+	mc.Scope = func(user string, groups []string) string {
+		return string(policy.ScopeFor(daemon.Peer{UID: -1, User: user, Groups: groups}))
+	}
+	return middleware.ProxyAuth(mc), nil
+}
+
+// apiTLSConfig returns the TLS settings for serving the API, or nil to
+// serve plain HTTP. With a proxy client CA, a certificate is verified when
+// presented, so token clients without one still connect.
+func apiTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	if cfg.API.TLSCert == "" {
+		return nil, nil
+	}
+	home, _ := os.UserHomeDir()
+	cert, err := tls.LoadX509KeyPair(expandHome(cfg.API.TLSCert, home), expandHome(cfg.API.TLSKey, home))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load API certificate: %w", err)
+	}
+	tc := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+
+	if ca := cfg.API.ProxyAuth.ClientCA; ca != "" {
+		data, err := os.ReadFile(expandHome(ca, home))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read proxy client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates in proxy client CA %s", ca)
+		}
+		tc.ClientCAs = pool
+		tc.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tc, nil
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// reexec replaces this process with exe run with args, keeping its PID so
+// a supervisor or the instance lock sees the same process
+func reexec(exe string, args []string) error {
+	return syscall.Exec(exe, append([]string{os.Args[0]}, args...), os.Environ())
+}
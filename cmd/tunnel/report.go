@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/report"
+	"github.com/spf13/cobra"
+)
+
+var (
+	reportSince  string
+	reportFormat string
+	reportFile   string
+)
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Summarize tunnel activity",
+}
+
+var reportGenerateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "Generate an activity report for a period",
+	Long: `Generate a shareable report of connection uptime, failovers, bandwidth, key
+changes and alerts over a period, for weekly ops reviews and the like.
+
+The report is built from the audit log, which the running instance fills with
+connection events and alerts. Sessions that are still up are completed from
+the running instance, if there is one.`,
+	Example: `  tunnel report generate
+  tunnel report generate --since 7d --format html --file weekly.html
+  tunnel report generate --since 24h --json`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return generateReport(reportSince, reportFormat, reportFile)
+	},
+}
+
+func init() {
+	reportGenerateCmd.Flags().StringVar(&reportSince, "since", "7d", "length of the period, ending now (e.g. 24h, 7d, 4w)")
+	reportGenerateCmd.Flags().StringVar(&reportFormat, "format", "markdown", "document format: markdown or html")
+	reportGenerateCmd.Flags().StringVarP(&reportFile, "file", "f", "", "write the report to a file instead of stdout")
+
+	reportCmd.AddCommand(reportGenerateCmd)
+}
+
+func generateReport(since, format, file string) error {
+	period, err := core.ParseAlertDuration(since)
+	if err != nil || period == 0 {
+		return fmt.Errorf("invalid --since %q: use a duration such as 24h or 7d", since)
+	}
+	docFormat, err := report.ParseFormat(format)
+	if err != nil {
+		return err
+	}
+	outFormat, err := outputFormat()
+	if err != nil {
+		return err
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("failed to get home directory: %w", err)
+	}
+	// Read the whole log so sessions that began before the period count
+	events, err := core.ReadAuditLog(auditLogPath(homeDir), time.Time{})
+	if err != nil {
+		return fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	live, err := fetchInstanceStatuses(runningInstance())
+	if err != nil && verbose {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+
+	until := time.Now()
+	r := report.Build(events, live, until.Add(-period), until)
+
+	if outFormat.Structured() {
+		return writeOutput(outFormat, r)
+	}
+
+	var w io.Writer = os.Stdout
+	if file != "" {
+		f, err := os.Create(file)
+		if err != nil {
+			return fmt.Errorf("failed to create report: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if err := r.Render(w, docFormat); err != nil {
+		return err
+	}
+	if file != "" {
+		color.Green("✓ Report written to %s", file)
+	}
+	return nil
+}
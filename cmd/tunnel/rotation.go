@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/output"
+	"github.com/jedarden/tunnel/internal/units"
+	"github.com/spf13/cobra"
+)
+
+var keysRotationForce string
+
+var keysRotationCmd = &cobra.Command{
+	Use:   "rotation",
+	Short: "Rotate the keypairs of managed identities on a schedule",
+	Long: `Managed identities are keypairs TUNNEL holds for a user, listed in the
+rotation section of the config. Every so many days a new keypair is written
+to the identity's key_path and its public key installed for the user. The
+old private key moves to key_path.previous and stays valid for the overlap,
+so whatever uses it can switch over, and is then revoked and deleted.
+
+Rotation runs hourly while tunnel is running. Each step is audited and sent
+to the configured notifiers.`,
+}
+
+var keysRotationStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show when each identity was and will be rotated",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return rotationStatus()
+	},
+}
+
+var keysRotationRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Rotate the identities that are due now",
+	Long: `Rotate the identities that are due and revoke keys whose overlap has ended.
+This also runs automatically while tunnel is running; the command is useful
+from cron on machines where it is not, or with --identity to rotate one
+identity ahead of schedule.`,
+	Example: `  tunnel keys rotation run
+  tunnel keys rotation run --identity deploy`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runRotation(keysRotationForce)
+	},
+}
+
+func init() {
+	keysRotationRunCmd.Flags().StringVar(&keysRotationForce, "identity", "", "rotate this identity now, whatever its age")
+
+	keysRotationCmd.AddCommand(keysRotationStatusCmd)
+	keysRotationCmd.AddCommand(keysRotationRunCmd)
+	keysCmd.AddCommand(keysRotationCmd)
+}
+
+// rotationStore returns the store recording where each identity's
+// rotation stands
+func rotationStore() (*core.RotationStore, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return core.NewRotationStore(filepath.Join(homeDir, ".config", "tunnel", "rotation.json")), nil
+}
+
+// rotationPolicies returns the identities of the rotation section
+func rotationPolicies() []core.RotationPolicy {
+	home, _ := os.UserHomeDir()
+	var policies []core.RotationPolicy
+	for _, id := range appConfig.Rotation.Identities {
+		policies = append(policies, core.RotationPolicy{
+			Identity: id.Name,
+			User:     id.User,
+			KeyPath:  expandHome(id.KeyPath, home),
+			Every:    time.Duration(id.Days()) * 24 * time.Hour,
+			Overlap:  time.Duration(id.Overlap) * 24 * time.Hour,
+		})
+	}
+	return policies
+}
+
+// rotationRow is an identity as shown by keys rotation status
+type rotationRow struct {
+	core.RotationState
+	NextRotation time.Time `json:"next_rotation"`
+}
+
+func rotationStatus() error {
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+	store, err := rotationStore()
+	if err != nil {
+		return err
+	}
+	states, err := store.List()
+	if err != nil {
+		return fmt.Errorf("failed to read rotation state: %w", err)
+	}
+	byIdentity := make(map[string]core.RotationState, len(states))
+	for _, st := range states {
+		byIdentity[st.Identity] = st
+	}
+
+	var rows []rotationRow
+	for _, p := range rotationPolicies() {
+		st, ok := byIdentity[p.Identity]
+		if !ok {
+			st = core.RotationState{Identity: p.Identity, User: p.User}
+		}
+		row := rotationRow{RotationState: st}
+		if st.Current != "" {
+			row.NextRotation = st.NextRotation(p)
+		}
+		rows = append(rows, row)
+	}
+
+	if format.Structured() {
+		return writeOutput(format, map[string]interface{}{"identities": rows})
+	}
+	if len(rows) == 0 && format == output.FormatTable {
+		color.Yellow("No managed identities; add them to the rotation section of the config")
+		return nil
+	}
+
+	table := newTable("IDENTITY", "USER", "KEY", "SINCE", "NEXT ROTATION", "RETIRING")
+	for _, row := range rows {
+		key, since, next, retiring := "-", "-", "pending", "-"
+		if row.Current != "" {
+			key = row.Current
+			since = units.Time(row.CurrentSince)
+			next = units.Time(row.NextRotation)
+		}
+		if row.Previous != "" {
+			retiring = fmt.Sprintf("%s until %s", row.Previous, units.Time(row.RevokeAt))
+		}
+		table.AddRow(row.Identity, row.User, key, since, next, retiring)
+	}
+	return renderTable(format, table)
+}
+
+func runRotation(force string) error {
+	if keyManager == nil {
+		return fmt.Errorf("key manager not initialized")
+	}
+	if force != "" {
+		found := false
+		for _, id := range appConfig.Rotation.Identities {
+			found = found || id.Name == force
+		}
+		if !found {
+			return fmt.Errorf("no identity named %s in the rotation section", force)
+		}
+	}
+
+	steps, err := rotateIdentities(force)
+
+	if jsonOutput {
+		var results []map[string]interface{}
+		for _, step := range steps {
+			result := map[string]interface{}{
+				"identity":    step.Identity,
+				"user":        step.User,
+				"action":      step.Action,
+				"fingerprint": step.Fingerprint,
+			}
+			if step.Replaced != "" {
+				result["replaced"] = step.Replaced
+				result["revoke_at"] = step.RevokeAt
+			}
+			if step.Err != nil {
+				result["error"] = step.Err.Error()
+			}
+			results = append(results, result)
+		}
+		out := map[string]interface{}{"steps": results}
+		if err != nil {
+			out["error"] = err.Error()
+		}
+		return printJSON(out)
+	}
+
+	failed := 0
+	for _, step := range steps {
+		if step.Err != nil {
+			failed++
+			color.Red("✗ %s: %s failed: %v", step.Identity, step.Action, step.Err)
+			continue
+		}
+		color.Green("✓ %s", describeRotationStep(step))
+	}
+	if len(steps) == 0 && err == nil {
+		fmt.Println("No identity is due for rotation")
+	}
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d rotation step(s) failed; they are retried on the next run", failed)
+	}
+	return nil
+}
+
+// rotateIdentities runs the rotation of the configured identities,
+// auditing and announcing each step
+func rotateIdentities(force string) ([]core.RotationStep, error) {
+	store, err := rotationStore()
+	if err != nil {
+		return nil, err
+	}
+
+	steps, err := store.Run(keyManager, rotationPolicies(), time.Now(), force)
+	for _, step := range steps {
+		logRotationEvent(step)
+		title := fmt.Sprintf("Identity %s: key %s", step.Identity, step.Action)
+		message := describeRotationStep(step)
+		if step.Err != nil {
+			title = fmt.Sprintf("Identity %s: key rotation failed", step.Identity)
+			message = fmt.Sprintf("%s step failed for %s: %v", step.Action, step.User, step.Err)
+		}
+		notifyKeyChange(title, message)
+	}
+	return steps, err
+}
+
+// describeRotationStep says what a successful step did
+func describeRotationStep(step core.RotationStep) string {
+	switch step.Action {
+	case core.RotationRotated:
+		return fmt.Sprintf("%s: new key %s installed for %s; %s stays valid until %s",
+			step.Identity, step.Fingerprint, step.User, step.Replaced, units.Time(step.RevokeAt))
+	case core.RotationRevoked:
+		return fmt.Sprintf("%s: retired key %s revoked for %s", step.Identity, step.Fingerprint, step.User)
+	case core.RotationAdopted:
+		return fmt.Sprintf("%s: existing key %s taken over for %s", step.Identity, step.Fingerprint, step.User)
+	default:
+		return fmt.Sprintf("%s: key %s generated and installed for %s", step.Identity, step.Fingerprint, step.User)
+	}
+}
+
+// logRotationEvent records a rotation step in the audit log
+func logRotationEvent(step core.RotationStep) {
+	homeDir, _ := os.UserHomeDir()
+	auditLogger, err := core.NewAuditLogger(filepath.Join(homeDir, ".config", "tunnel", "audit.log"), false, "")
+	if err != nil {
+		if verbose {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to initialize audit logger: %v\n", err)
+		}
+		return
+	}
+	defer auditLogger.Close()
+
+	details := map[string]interface{}{
+		"identity":    step.Identity,
+		"fingerprint": step.Fingerprint,
+	}
+	if step.Replaced != "" {
+		details["replaced"] = step.Replaced
+		details["revoke_at"] = step.RevokeAt
+	}
+	if step.Err != nil {
+		details["error"] = step.Err.Error()
+	}
+
+	_ = auditLogger.Log(core.AuditEvent{
+		Timestamp: time.Now(),
+		EventType: "identity_key_" + step.Action,
+		Method:    "ssh-key",
+		User:      step.User,
+		Details:   details,
+		Success:   step.Err == nil,
+	})
+}
+
+// runRotationScheduler rotates identities as they fall due until ctx is
+// done
+func runRotationScheduler(ctx context.Context, interval time.Duration) {
+	if keyManager == nil || len(appConfig.Rotation.Identities) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := rotateIdentities(""); err != nil && verbose {
+			fmt.Fprintf(os.Stderr, "Warning: key rotation failed: %v\n", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
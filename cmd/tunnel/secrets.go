@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/providers"
+)
+
+// passphraseEnv holds the credential store passphrase, ahead of the config
+const passphraseEnv = "TUNNEL_CREDENTIALS_PASSPHRASE"
+
+// legacyPassphrase is the fixed passphrase earlier releases encrypted
+// provider keys with; keys found under it are moved to the real store
+const legacyPassphrase = "tunnel-credentials"
+
+var (
+	credStoreOnce sync.Once
+	credStore     core.CredentialStore
+	credStoreErr  error
+)
+
+// credentialStore opens the store of the credentials section once:
+// the system keyring, or files encrypted with the passphrase when the
+// keyring is unavailable or file is configured
+func credentialStore() (core.CredentialStore, error) {
+	credStoreOnce.Do(func() {
+		// The keyring needs no passphrase, so an unreadable passphrase file
+		// only matters once the store turns out to be a file
+		passphrase, passphraseErr := credentialPassphrase()
+		storeType := appConfig.Credentials.Store
+		if storeType == "" {
+			storeType = "keyring"
+		}
+		credStore, credStoreErr = core.NewCredentialStore(storeType, "tunnel", credentialsDir(), passphrase)
+		if errors.Is(credStoreErr, core.ErrNoPassphrase) {
+			if passphraseErr != nil {
+				credStoreErr = passphraseErr
+			} else {
+				credStoreErr = fmt.Errorf("%w: set %s or credentials.passphrase_file", credStoreErr, passphraseEnv)
+			}
+		}
+		if credStoreErr == nil {
+			migrateLegacyCredentials(credStore)
+		}
+	})
+	return credStore, credStoreErr
+}
+
+// migrateLegacyCredentials moves keys stored under legacyPassphrase into
+// store, which encrypts them under the configured passphrase or keeps
+// them in the keyring
+func migrateLegacyCredentials(store core.CredentialStore) {
+	switch store.(type) {
+	case *core.FileStore, *core.KeyringStore:
+	default:
+		return // the environment can't take them
+	}
+	legacy, err := core.NewFileStore(credentialsDir(), legacyPassphrase)
+	if err != nil {
+		return
+	}
+	moved, err := legacy.MoveTo(store)
+	if len(moved) > 0 {
+		fmt.Fprintf(os.Stderr, "Moved the stored keys of %s to %s\n", strings.Join(moved, ", "), describeCredentialStore(store))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to move keys stored by an earlier release: %v\n", err)
+	}
+}
+
+// credentialPassphrase reads the passphrase from the environment, the
+// passphrase file or the config, in that order. When the file can't be
+// read, the config's passphrase is returned with the error.
+func credentialPassphrase() (string, error) {
+	if p := os.Getenv(passphraseEnv); p != "" {
+		return p, nil
+	}
+	if path := appConfig.Credentials.PassphraseFile; path != "" {
+		home, _ := os.UserHomeDir()
+		data, err := os.ReadFile(expandHome(path, home))
+		if err != nil {
+			return appConfig.Credentials.Passphrase, fmt.Errorf("failed to read credentials passphrase: %w", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return appConfig.Credentials.Passphrase, nil
+}
+
+// credentialsDir returns where the file store keeps credentials
+func credentialsDir() string {
+	home, _ := os.UserHomeDir()
+	if dir := appConfig.Credentials.BaseDir; dir != "" {
+		return expandHome(dir, home)
+	}
+	return filepath.Join(home, ".config", "tunnel", "credentials")
+}
+
+// describeCredentialStore says where store keeps credentials
+func describeCredentialStore(store core.CredentialStore) string {
+	switch store.(type) {
+	case *core.KeyringStore:
+		return "system keyring"
+	case *core.FileStore:
+		return credentialsDir() + " (encrypted)"
+	case *core.EnvStore:
+		return "environment"
+	}
+	return "credential store"
+}
+
+// useStoredCredential hands provider the API key stored for it with
+// `tunnel auth set-key`, unless it has a token of its own. Call it just
+// before connecting: the store is only opened when a tunnel needs it, and
+// the token is never written to the config.
+func useStoredCredential(provider providers.Provider) {
+	cfg := providerConfig(provider)
+	if cfg.AuthToken != "" {
+		return
+	}
+	store, err := credentialStore()
+	if err != nil {
+		if verbose {
+			fmt.Fprintf(os.Stderr, "Warning: stored provider credentials unavailable: %v\n", err)
+		}
+		return
+	}
+	token, err := core.ProviderToken(store, provider.Name())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to read the stored key for %s: %v\n", provider.Name(), err)
+		return
+	}
+	if token == "" {
+		return
+	}
+	updated := *cfg
+	updated.AuthToken = token
+	if err := provider.Configure(&updated); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring the stored key for %s: %v\n", provider.Name(), err)
+	}
+}
+
+// storedCredentialTunnel is a provider that picks up its stored key when
+// something other than a command connects it, such as a wake listener
+type storedCredentialTunnel struct {
+	providers.Provider
+}
+
+func (t storedCredentialTunnel) Connect() error {
+	useStoredCredential(t.Provider)
+	return t.Provider.Connect()
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jedarden/tunnel/internal/instance"
+	"github.com/spf13/cobra"
+)
+
+var (
+	serveAPIOnly   bool
+	serveListen    string
+	serveTokenFile string
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run the web server and REST API without the TUI",
+	Long: `Run the connection manager, provider registry and key manager behind the
+REST+JSON API the TUI's web server uses, without the TUI, so scripts and
+other hosts can drive tunnel:
+
+  GET    /api/providers                  list providers
+  POST   /api/providers/<name>/connect   start a provider
+  POST   /api/providers/<name>/disconnect
+  GET    /api/connections/status         status of managed connections
+  GET    /api/connections/pick           connection for the next session, by mode
+  GET    /api/metrics                    global metrics
+  GET    /api/keys                       list SSH keys (?user=)
+  POST   /api/keys                       add a key: {"user", "public_key"}
+  DELETE /api/keys/<user>/<key-id>
+  POST   /api/keys/revoke                revoke {"user", "fingerprints"}, all keys
+                                         without fingerprints, for a fleet node
+  POST   /api/probe                      read the SSH banner at {"host", "port"}
+                                         for another node's tunnel verify
+
+The server listens on 127.0.0.1 at --port unless --listen says otherwise.
+The token is read from --token-file or TUNNEL_API_TOKEN, and is required to
+listen beyond loopback. Once set, clients must send it as "Authorization:
+Bearer <token>" (or ?token= for WebSockets), whether or not they're on this
+host, since a reverse proxy here would otherwise pass every request through.
+tunnel status, tunnel logs and tunnel watch send TUNNEL_API_TOKEN. Without a
+token the API is open to every user of this host, except the routes adding,
+removing and revoking SSH keys, which are refused.
+
+Behind an authenticating reverse proxy (oauth2-proxy, Authelia), set
+api.proxy_auth instead: the identity in X-Forwarded-User and
+X-Forwarded-Groups is trusted when the proxy sends the shared secret from
+secret_file in X-Proxy-Secret, or connects with a client certificate signed
+by client_ca (which needs api.tls_cert and api.tls_key). The rbac section
+gives the identity its scope: none is refused, own may view and start and
+stop the providers it started, all may do anything. Without the header a
+request still needs the token, even from this host, since the proxy's own
+requests come from loopback.`,
+	Example: `  # API and web UI on localhost:8080
+  tunnel serve
+
+  # API only, reachable from other hosts
+  TUNNEL_API_TOKEN=$(cat ~/.config/tunnel/api-token) tunnel serve --api --listen 0.0.0.0:8080
+
+  # Drive it from elsewhere
+  curl -H "Authorization: Bearer $TOKEN" -X POST http://host:8080/api/providers/bore/connect`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return serve(cmd.Context())
+	},
+}
+
+func init() {
+	serveCmd.Flags().BoolVar(&serveAPIOnly, "api", false, "serve only the REST API, not the web UI")
+	serveCmd.Flags().StringVar(&serveListen, "listen", "", "address to listen on (default 127.0.0.1:<port>)")
+	serveCmd.Flags().StringVar(&serveTokenFile, "token-file", "", "file holding the API token (default $TUNNEL_API_TOKEN)")
+}
+
+// serve runs the web server until ctx is done
+func serve(ctx context.Context) error {
+	token, err := apiToken()
+	if err != nil {
+		return err
+	}
+
+	addr := serveListen
+	if addr == "" {
+		addr = fmt.Sprintf("127.0.0.1:%d", webPort)
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid --listen address %q: %w", addr, err)
+	}
+	if token == "" && !isLoopbackHost(host) && !appConfig.API.ProxyAuth.Enabled() {
+		return fmt.Errorf("refusing to serve the API on %s without a token; set --token-file or TUNNEL_API_TOKEN, or api.proxy_auth", addr)
+	}
+
+	// Only one instance manages providers
+	holder, err := acquireInstanceLock("serve")
+	if errors.Is(err, instance.ErrRunning) {
+		return fmt.Errorf("tunnel is already running (pid %d, %s); stop it before serving", holder.PID, holder.Command)
+	}
+	if err != nil {
+		return err
+	}
+
+	if token == "" && !appConfig.API.ProxyAuth.Enabled() {
+		color.Yellow("No API token set: the API is open to every user of this host, and the SSH key routes are refused")
+	}
+
+	startBackgroundJobs(ctx)
+	startThrottles(ctx)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- startWebServer(ctx, nil, nil, nil, webOptions{addr: addr, apiOnly: serveAPIOnly, token: token})
+	}()
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// listenWebServer serves app on addr, recording the port for later
+// invocations to find
+func listenWebServer(app *fiber.App, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	scheme := "http"
+	tlsConfig, err := apiTLSConfig(appConfig)
+	if err != nil {
+		ln.Close()
+		return err
+	}
+	if tlsConfig != nil {
+		ln, scheme = tls.NewListener(ln, tlsConfig), "https"
+	}
+
+	if instanceLock != nil {
+		if err := instanceLock.SetPort(port); err != nil && verbose {
+			fmt.Printf("Warning: Could not record web server port: %v\n", err)
+		}
+	}
+	onShutdown("web server", app.ShutdownWithContext)
+
+	color.Green("✓ Serving on %s://%s", scheme, ln.Addr())
+	return app.Listener(ln)
+}
+
+// apiToken returns the token API clients on other hosts must present
+func apiToken() (string, error) {
+	if serveTokenFile == "" {
+		return strings.TrimSpace(os.Getenv("TUNNEL_API_TOKEN")), nil
+	}
+	home, _ := os.UserHomeDir()
+	data, err := os.ReadFile(expandHome(serveTokenFile, home))
+	if err != nil {
+		return "", fmt.Errorf("failed to read token file: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", serveTokenFile)
+	}
+	return token, nil
+}
+
+// isLoopbackHost reports whether host only accepts connections from this host
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/output"
+	"github.com/jedarden/tunnel/internal/system"
+	"github.com/jedarden/tunnel/internal/units"
+	"github.com/jedarden/tunnel/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+// sshSessionEvent is the audit event type of an ended SSH session
+const sshSessionEvent = "ssh_session"
+
+var (
+	sessionsSince string
+	sessionsUser  string
+)
+
+var sessionsCmd = &cobra.Command{
+	Use:   "sessions",
+	Short: "Inspect SSH sessions",
+}
+
+var sessionsHistoryCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List ended SSH sessions",
+	Long: `List the SSH sessions the running instance recorded in the audit log: who
+logged in, with which key, from where, for how long and how much traffic
+they caused. Commands of non-interactive sessions are shown when
+ssh.session_log.commands is on.`,
+	Example: `  tunnel sessions history
+  tunnel sessions history --since 24h --user deploy
+  tunnel sessions history --json`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return sessionHistory(sessionsSince, sessionsUser)
+	},
+}
+
+func init() {
+	sessionsHistoryCmd.Flags().StringVar(&sessionsSince, "since", "7d", "how far back to list sessions (e.g. 24h, 7d)")
+	sessionsHistoryCmd.Flags().StringVar(&sessionsUser, "user", "", "only list sessions of this user")
+
+	sessionsCmd.AddCommand(sessionsHistoryCmd)
+}
+
+// sessionRecord is an ended SSH session as listed by `sessions history`
+type sessionRecord struct {
+	User          string        `json:"user"`
+	SourceIP      string        `json:"source_ip"`
+	Fingerprint   string        `json:"fingerprint,omitempty"`
+	StartedAt     time.Time     `json:"started_at"`
+	EndedAt       time.Time     `json:"ended_at"`
+	Duration      time.Duration `json:"duration"`
+	BytesSent     int64         `json:"bytes_sent"`
+	BytesReceived int64         `json:"bytes_received"`
+	Command       string        `json:"command,omitempty"`
+}
+
+func sessionHistory(since, user string) error {
+	period, err := core.ParseAlertDuration(since)
+	if err != nil || period == 0 {
+		return fmt.Errorf("invalid --since %q: use a duration such as 24h or 7d", since)
+	}
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("failed to get home directory: %w", err)
+	}
+	events, err := core.ReadAuditLog(auditLogPath(homeDir), time.Now().Add(-period))
+	if err != nil {
+		return fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	records := []sessionRecord{}
+	for _, event := range events {
+		if event.EventType != sshSessionEvent || (user != "" && event.User != user) {
+			continue
+		}
+		records = append(records, sessionRecordFromEvent(event))
+	}
+
+	if format.Structured() {
+		return writeOutput(format, map[string]interface{}{"sessions": records})
+	}
+
+	if len(records) == 0 && format == output.FormatTable {
+		color.Yellow("No SSH sessions recorded since %s", units.Time(time.Now().Add(-period)))
+		return nil
+	}
+
+	table := newTable("STARTED", "USER", "SOURCE", "KEY", "DURATION", "SENT", "RECEIVED", "COMMAND")
+	for _, r := range records {
+		key := r.Fingerprint
+		if key == "" {
+			key = "-"
+		}
+		table.AddRow(
+			units.Time(r.StartedAt),
+			r.User,
+			r.SourceIP,
+			key,
+			units.Duration(r.Duration),
+			units.Bytes(r.BytesSent),
+			units.Bytes(r.BytesReceived),
+			r.Command,
+		)
+	}
+	return renderTable(format, table)
+}
+
+// sessionRecordFromEvent reads an ssh_session audit event. Numbers come
+// back from the JSON log as float64.
+func sessionRecordFromEvent(event core.AuditEvent) sessionRecord {
+	r := sessionRecord{User: event.User, SourceIP: event.SourceIP, EndedAt: event.Timestamp}
+	r.Fingerprint, _ = event.Details["fingerprint"].(string)
+	r.Command, _ = event.Details["command"].(string)
+	if secs, ok := event.Details["duration_seconds"].(float64); ok {
+		r.Duration = time.Duration(secs * float64(time.Second))
+	}
+	if n, ok := event.Details["bytes_sent"].(float64); ok {
+		r.BytesSent = int64(n)
+	}
+	if n, ok := event.Details["bytes_received"].(float64); ok {
+		r.BytesReceived = int64(n)
+	}
+	r.StartedAt = r.EndedAt.Add(-r.Duration)
+	return r
+}
+
+// watchSSHSessions records every SSH session in the audit log once it ends,
+// until ctx is done, and drops records older than the retention period.
+// Sessions still open at shutdown are not recorded.
+func watchSSHSessions(ctx context.Context, cfg config.SessionLogConfig, interval time.Duration) {
+	if auditTrail == nil || !cfg.Enabled {
+		return
+	}
+
+	open := make(map[string]system.SSHSession)
+	poll := func() {
+		sessions, err := system.SSHSessions()
+		if err != nil {
+			if verbose {
+				fmt.Fprintf(os.Stderr, "Warning: failed to list SSH sessions: %v\n", err)
+			}
+			return
+		}
+
+		current := make(map[string]system.SSHSession, len(sessions))
+		for _, s := range sessions {
+			seen, ok := open[s.ID]
+			if s.StartedAt.IsZero() {
+				if ok {
+					s.StartedAt = seen.StartedAt
+				} else {
+					s.StartedAt = time.Now()
+				}
+			}
+			if !ok && s.Fingerprint != "" && keyManager != nil {
+				// Remember when each key last logged in
+				if err := keyManager.RecordKeyUse(s.Fingerprint, s.StartedAt); err != nil && verbose {
+					fmt.Fprintf(os.Stderr, "Warning: failed to record key use: %v\n", err)
+				}
+			}
+			current[s.ID] = s
+		}
+		for id, s := range open {
+			if _, ok := current[id]; !ok {
+				recordSSHSession(s, cfg.Commands)
+			}
+		}
+		open = current
+	}
+
+	prune := func() {
+		if cfg.RetentionDays == 0 {
+			return
+		}
+		cutoff := time.Now().AddDate(0, 0, -cfg.RetentionDays)
+		removed, err := auditTrail.Prune(func(e core.AuditEvent) bool {
+			return e.EventType != sshSessionEvent || e.Timestamp.After(cutoff)
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to prune session history: %v\n", err)
+		} else if removed > 0 && verbose {
+			fmt.Printf("Pruned %d SSH sessions older than %d days from the audit log\n", removed, cfg.RetentionDays)
+		}
+	}
+
+	poll()
+	prune()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	daily := time.NewTicker(24 * time.Hour)
+	defer daily.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			poll()
+		case <-daily.C:
+			prune()
+		}
+	}
+}
+
+// recordSSHSession writes an ended session to the audit log
+func recordSSHSession(s system.SSHSession, commands bool) {
+	details := map[string]interface{}{
+		"pid":              s.PID,
+		"source_port":      s.SourcePort,
+		"duration_seconds": time.Since(s.StartedAt).Seconds(),
+		"bytes_sent":       s.BytesSent,
+		"bytes_received":   s.BytesReceived,
+	}
+	if s.Fingerprint != "" {
+		details["fingerprint"] = s.Fingerprint
+	}
+	if s.TTY != "" {
+		details["tty"] = s.TTY
+	}
+	if commands && s.Command != "" {
+		details["command"] = s.Command
+	}
+
+	auditTrail.Log(core.AuditEvent{
+		Timestamp: time.Now(),
+		EventType: sshSessionEvent,
+		Method:    "ssh",
+		User:      s.User,
+		SourceIP:  s.SourceIP,
+		Details:   details,
+		Success:   true,
+	})
+}
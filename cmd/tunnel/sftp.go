@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/system"
+	"github.com/jedarden/tunnel/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	sftpDropIn string
+	sftpReload bool
+)
+
+var sftpCmd = &cobra.Command{
+	Use:   "sftp",
+	Short: "Manage the SFTP policy sshd enforces",
+	Long: `SFTP access is set under ssh.sftp in the config: whether SFTP is served at
+all, whether it is read-only, and which users are jailed to a chroot
+directory or limited to read-only SFTP. sshd enforces the policy through an
+sshd_config drop-in, and tunnel doctor checks that the running sshd matches.`,
+}
+
+var sftpShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the sshd configuration for the SFTP policy",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fmt.Print(sftpPolicy(appConfig).SSHDConfig())
+		return nil
+	},
+}
+
+var sftpApplyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Write the SFTP policy to sshd's configuration",
+	Long: `Write the SFTP policy as an sshd_config drop-in and check the result with
+sshd -t. A configuration sshd rejects is rolled back. sshd_config must
+include the drop-in directory, as it does by default on current
+distributions; a Subsystem sftp line in sshd_config itself takes precedence
+over the drop-in and has to be removed.`,
+	Example: `  sudo tunnel sftp apply --reload`,
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return applySFTPPolicy(sftpDropIn, sftpReload)
+	},
+}
+
+func init() {
+	sftpApplyCmd.Flags().StringVar(&sftpDropIn, "path", system.SFTPDropInPath, "sshd_config drop-in to write")
+	sftpApplyCmd.Flags().BoolVar(&sftpReload, "reload", false, "reload sshd afterwards")
+
+	sftpCmd.AddCommand(sftpShowCmd)
+	sftpCmd.AddCommand(sftpApplyCmd)
+}
+
+// sftpPolicy builds the SFTP policy from the SSH settings
+func sftpPolicy(cfg *config.Config) system.SFTPPolicy {
+	if cfg == nil {
+		return system.SFTPPolicy{Enabled: true}
+	}
+	return system.SFTPPolicy{
+		Enabled:       cfg.SSH.SFTP.Enabled,
+		ReadOnly:      cfg.SSH.SFTP.ReadOnly,
+		Chroot:        cfg.SSH.SFTP.Chroot,
+		ReadOnlyUsers: cfg.SSH.SFTP.ReadOnlyUsers,
+	}
+}
+
+func applySFTPPolicy(path string, reload bool) error {
+	policy := sftpPolicy(appConfig)
+
+	previous, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	restore := func() error {
+		if previous == nil {
+			return os.Remove(path)
+		}
+		return os.WriteFile(path, previous, 0644)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, []byte(policy.SSHDConfig()), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	if out, err := exec.Command("sshd", "-t").CombinedOutput(); err != nil {
+		if rerr := restore(); rerr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to roll back %s: %v\n", path, rerr)
+		}
+		return fmt.Errorf("sshd rejected the SFTP policy, rolled back: %s", strings.TrimSpace(string(out)))
+	}
+	color.Green("✓ SFTP policy written to %s", path)
+
+	for user, dir := range policy.Chroot {
+		if err := system.CheckChrootDir(strings.ReplaceAll(dir, "%u", user)); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %s: %v; sshd will refuse their logins\n", user, err)
+		}
+	}
+
+	if auditTrail != nil {
+		auditTrail.LogConfigChange(os.Getenv("USER"), map[string]interface{}{
+			"sftp_enabled":   policy.Enabled,
+			"sftp_read_only": policy.ReadOnly,
+			"sftp_drop_in":   path,
+		})
+	}
+
+	if !reload {
+		fmt.Println("Reload sshd for the policy to take effect, or rerun with --reload")
+		return nil
+	}
+	if err := reloadSSHD(); err != nil {
+		return err
+	}
+	color.Green("✓ sshd reloaded")
+	return nil
+}
+
+// reloadSSHD asks systemd to reload sshd, whose unit is ssh on Debian and
+// sshd elsewhere
+func reloadSSHD() error {
+	var lastErr error
+	for _, unit := range []string{"ssh", "sshd"} {
+		out, err := exec.Command("systemctl", "reload", unit).CombinedOutput()
+		if err == nil {
+			return nil
+		}
+		lastErr = fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return fmt.Errorf("failed to reload sshd: %w", lastErr)
+}
@@ -0,0 +1,527 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/output"
+	"github.com/jedarden/tunnel/internal/providers"
+	"github.com/jedarden/tunnel/internal/units"
+	"github.com/jedarden/tunnel/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	shareTTL          time.Duration
+	shareUser         string
+	shareProvider     string
+	shareRevokeTunnel bool
+	shareWait         bool
+)
+
+var shareCmd = &cobra.Command{
+	Use:   "share",
+	Short: "Share temporary SSH access",
+	Long: `Grant a user temporary SSH access through a tunnel.
+
+Shares import the user's GitHub keys with an expiry, make sure a tunnel is up
+and print a one-line connection instruction to send them. Keys are written with
+an sshd expiry-time option and are removed from authorized_keys by the next
+sweep once the TTL elapses.`,
+}
+
+var shareCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create an expiring share for a user",
+	Long: `Import a user's GitHub SSH keys with an expiry, ensure a tunnel is connected
+and print the command they should use to connect.`,
+	Example: `  tunnel share create --ttl 2h --user alice
+  tunnel share create --ttl 30m --user bob --provider bore --revoke-tunnel
+  tunnel share create --ttl 1h --user carol --wait`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return createShare(cmd.Context(), shareUser, shareTTL, shareProvider, shareRevokeTunnel, shareWait)
+	},
+}
+
+var shareListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List active shares",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return listShares()
+	},
+}
+
+var shareRevokeCmd = &cobra.Command{
+	Use:   "revoke <share-id>",
+	Short: "Revoke a share before it expires",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return revokeShare(args[0])
+	},
+}
+
+var shareSweepCmd = &cobra.Command{
+	Use:   "sweep",
+	Short: "Revoke all expired shares",
+	Long: `Revoke the keys (and optionally tunnels) of every share whose TTL has elapsed.
+The sweep also runs automatically while tunnel is running; this command is
+useful from cron on machines where it is not.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return sweepShares()
+	},
+}
+
+func init() {
+	shareCreateCmd.Flags().DurationVar(&shareTTL, "ttl", 2*time.Hour, "how long the access remains valid")
+	shareCreateCmd.Flags().StringVar(&shareUser, "user", "", "GitHub user to share access with (required)")
+	_ = shareCreateCmd.MarkFlagRequired("user")
+	shareCreateCmd.Flags().StringVar(&shareProvider, "provider", "", "provider to connect through (default: first connected provider)")
+	shareCreateCmd.Flags().BoolVar(&shareRevokeTunnel, "revoke-tunnel", false, "disconnect the tunnel when the share expires")
+	shareCreateCmd.Flags().BoolVar(&shareWait, "wait", false, "stay in the foreground and revoke access when the TTL elapses")
+
+	shareCmd.AddCommand(shareCreateCmd)
+	shareCmd.AddCommand(shareListCmd)
+	shareCmd.AddCommand(shareRevokeCmd)
+	shareCmd.AddCommand(shareSweepCmd)
+}
+
+// shareStore returns the store holding active shares
+func shareStore() (*core.ShareStore, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return core.NewShareStore(filepath.Join(homeDir, ".config", "tunnel", "shares.json")), nil
+}
+
+// newApprovalQueue opens the queue of access requests awaiting approval.
+// The returned func closes the queue's audit log.
+func newApprovalQueue() (*core.ApprovalQueue, func(), error) {
+	if keyManager == nil {
+		return nil, nil, fmt.Errorf("key manager not initialized")
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get home directory: %w", err)
+	}
+	configDir := filepath.Join(homeDir, ".config", "tunnel")
+
+	auditLogger, err := core.NewAuditLogger(filepath.Join(configDir, "audit.log"), false, "")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to initialize audit logger: %w", err)
+	}
+
+	shares, err := shareStore()
+	if err != nil {
+		auditLogger.Close()
+		return nil, nil, err
+	}
+
+	q, err := core.NewApprovalQueue(filepath.Join(configDir, "requests"), keyManager, shares, auditLogger)
+	if err != nil {
+		auditLogger.Close()
+		return nil, nil, err
+	}
+
+	return q, func() { auditLogger.Close() }, nil
+}
+
+// newEnrollmentManager builds the self-enrollment manager from config
+func newEnrollmentManager(approvals *core.ApprovalQueue, cfg config.EnrollmentConfig) *core.EnrollmentManager {
+	mailer := &core.SMTPMailer{
+		Host:     cfg.SMTP.Host,
+		Port:     cfg.SMTP.Port,
+		Username: cfg.SMTP.Username,
+		Password: cfg.SMTP.Password,
+		From:     cfg.SMTP.From,
+	}
+	return core.NewEnrollmentManager(approvals, mailer, cfg.BaseURL, cfg.AllowedDomains,
+		time.Duration(cfg.TokenTTL)*time.Second)
+}
+
+func createShare(ctx context.Context, githubUser string, ttl time.Duration, providerName string, revokeTunnel, wait bool) error {
+	if keyManager == nil {
+		return fmt.Errorf("key manager not initialized")
+	}
+	if ttl <= 0 {
+		return fmt.Errorf("ttl must be positive")
+	}
+
+	store, err := shareStore()
+	if err != nil {
+		return err
+	}
+
+	provider, err := shareTunnelProvider(providerName)
+	if err != nil {
+		return err
+	}
+
+	// Bring the tunnel up before handing out keys
+	if !provider.IsConnected() {
+		if !jsonOutput {
+			fmt.Printf("Connecting %s...\n", provider.Name())
+		}
+		useStoredCredential(provider)
+		if err := provider.Connect(); err != nil {
+			return fmt.Errorf("failed to connect %s: %w", provider.Name(), err)
+		}
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+
+	// Only the keys this share adds are its to revoke; keys the user
+	// already had stay as they were
+	keys, err := keyManager.ImportFromGitHubWithExpiry(githubUser, expiresAt)
+	share := core.Share{
+		ID:           core.NewShareID(),
+		User:         githubUser,
+		Provider:     provider.Name(),
+		RevokeTunnel: revokeTunnel,
+		CreatedAt:    now,
+		ExpiresAt:    expiresAt,
+	}
+	for _, key := range keys {
+		share.Fingerprints = append(share.Fingerprints, key.Fingerprint)
+	}
+	if err != nil {
+		// Don't leave the keys added before the failure behind
+		_ = core.RevokeShareKeys(keyManager, share)
+		return fmt.Errorf("failed to import GitHub keys: %w", err)
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no new keys to share for GitHub user %s; their keys are already authorized or they publish none", githubUser)
+	}
+
+	connInfo, _ := provider.GetConnectionInfo()
+	share.Instruction = shareInstruction(provider.Name(), connInfo)
+
+	if err := store.Add(share); err != nil {
+		// Don't leave keys behind that nothing will clean up
+		_ = core.RevokeShareKeys(keyManager, share)
+		return fmt.Errorf("failed to record share: %w", err)
+	}
+
+	logShareEvent("share_created", share, nil)
+
+	if jsonOutput {
+		if err := printJSON(map[string]interface{}{
+			"status": "created",
+			"share":  share,
+		}); err != nil {
+			return err
+		}
+	} else {
+		color.Green("✓ Shared access with %s via %s", githubUser, provider.Name())
+		fmt.Printf("  %-15s: %s\n", "Share ID", share.ID)
+		fmt.Printf("  %-15s: %d\n", "Keys", len(keys))
+		fmt.Printf("  %-15s: %s\n", "Expires", units.TimeExact(expiresAt))
+		fmt.Println()
+		fmt.Println("Send this to the user:")
+		fmt.Printf("  %s\n", color.CyanString(share.Instruction))
+		fmt.Println()
+	}
+
+	if !wait {
+		return nil
+	}
+
+	if !jsonOutput {
+		fmt.Printf("Waiting %s for share to expire (Ctrl+C to leave it active)...\n", ttl.Round(time.Second))
+	}
+	timer := time.NewTimer(time.Until(expiresAt))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-timer.C:
+	}
+
+	return sweepShares()
+}
+
+// shareTunnelProvider picks the provider a share connects through
+func shareTunnelProvider(name string) (providers.Provider, error) {
+	if name != "" {
+		provider, err := reg.GetProvider(name)
+		if err != nil {
+			return nil, err
+		}
+		return provider, nil
+	}
+
+	connected := reg.GetConnectedProviders()
+	if len(connected) == 0 {
+		return nil, fmt.Errorf("no tunnel is connected; pass --provider to choose one")
+	}
+	return connected[0], nil
+}
+
+// shareInstruction builds the one-line command a guest runs to connect
+func shareInstruction(providerName string, info *providers.ConnectionInfo) string {
+	sshUser := "user"
+	if u, err := user.Current(); err == nil {
+		sshUser = u.Username
+	}
+
+	host, port := "", ""
+	if info != nil {
+		switch {
+		case info.TunnelURL != "":
+			if parsed, err := url.Parse(info.TunnelURL); err == nil && parsed.Host != "" {
+				host, port = parsed.Hostname(), parsed.Port()
+			} else if h, p, err := net.SplitHostPort(info.TunnelURL); err == nil {
+				host, port = h, p
+			} else {
+				host = info.TunnelURL
+			}
+		case info.RemoteIP != "":
+			host = info.RemoteIP
+		case info.LocalIP != "":
+			host = info.LocalIP
+		}
+	}
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+
+	parts := []string{"ssh"}
+	if providerName == "cloudflare" {
+		parts = append(parts, `-o ProxyCommand="cloudflared access ssh --hostname %h"`)
+	}
+	if port != "" && port != "22" {
+		parts = append(parts, "-p", port)
+	}
+	parts = append(parts, fmt.Sprintf("%s@%s", sshUser, host))
+
+	return strings.Join(parts, " ")
+}
+
+func listShares() error {
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+
+	store, err := shareStore()
+	if err != nil {
+		return err
+	}
+
+	shares, err := store.List()
+	if err != nil {
+		return fmt.Errorf("failed to list shares: %w", err)
+	}
+
+	if format.Structured() {
+		return writeOutput(format, map[string]interface{}{"shares": shares})
+	}
+
+	if len(shares) == 0 && format == output.FormatTable {
+		color.Yellow("No active shares")
+		return nil
+	}
+
+	table := newTable("ID", "USER", "PROVIDER", "EXPIRES", "STATUS")
+	table.SetColor(4, colorizeState)
+
+	now := time.Now()
+	for _, share := range shares {
+		status := fmt.Sprintf("expires in %s", time.Until(share.ExpiresAt).Round(time.Minute))
+		if share.Expired(now) {
+			status = "expired"
+		}
+		table.AddRow(share.ID, share.User, share.Provider, units.Time(share.ExpiresAt), status)
+	}
+
+	return renderTable(format, table)
+}
+
+func revokeShare(id string) error {
+	if keyManager == nil {
+		return fmt.Errorf("key manager not initialized")
+	}
+
+	store, err := shareStore()
+	if err != nil {
+		return err
+	}
+
+	shares, err := store.List()
+	if err != nil {
+		return fmt.Errorf("failed to list shares: %w", err)
+	}
+
+	var share *core.Share
+	for i := range shares {
+		if shares[i].ID == id {
+			share = &shares[i]
+			break
+		}
+	}
+	if share == nil {
+		return fmt.Errorf("share not found: %s", id)
+	}
+
+	if err := core.RevokeShareKeys(keyManager, *share); err != nil {
+		logShareEvent("share_revoked", *share, err)
+		return fmt.Errorf("failed to revoke share keys: %w", err)
+	}
+	if _, err := store.Remove(id); err != nil {
+		return fmt.Errorf("failed to remove share: %w", err)
+	}
+
+	tunnelErr := revokeShareTunnel(*share)
+	logShareEvent("share_revoked", *share, tunnelErr)
+
+	if jsonOutput {
+		return printJSON(map[string]interface{}{
+			"status": "revoked",
+			"share":  share,
+		})
+	}
+
+	color.Green("✓ Revoked share %s for %s", share.ID, share.User)
+	if tunnelErr != nil {
+		color.Yellow("Failed to disconnect %s: %v", share.Provider, tunnelErr)
+	}
+	return nil
+}
+
+func sweepShares() error {
+	if keyManager == nil {
+		return fmt.Errorf("key manager not initialized")
+	}
+
+	revoked, err := expireShares()
+
+	if jsonOutput {
+		output := map[string]interface{}{
+			"status":  "swept",
+			"revoked": revoked,
+		}
+		if err != nil {
+			output["error"] = err.Error()
+		}
+		return printJSON(output)
+	}
+
+	for _, share := range revoked {
+		color.Green("✓ Share %s for %s expired and was revoked", share.ID, share.User)
+	}
+	if len(revoked) == 0 && err == nil {
+		fmt.Println("No expired shares")
+	}
+
+	return err
+}
+
+// expireShares revokes every expired share, including its tunnel when requested
+func expireShares() ([]core.Share, error) {
+	store, err := shareStore()
+	if err != nil {
+		return nil, err
+	}
+
+	revoked, err := store.RevokeExpired(keyManager, time.Now())
+	for _, share := range revoked {
+		logShareEvent("share_expired", share, revokeShareTunnel(share))
+	}
+
+	return revoked, err
+}
+
+// runShareSweeper periodically revokes expired shares until ctx is done
+func runShareSweeper(ctx context.Context, interval time.Duration) {
+	if keyManager == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := expireShares(); err != nil && verbose {
+			fmt.Fprintf(os.Stderr, "Warning: share sweep failed: %v\n", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// revokeShareTunnel disconnects a share's tunnel if it asked for that and
+// no other share still depends on it
+func revokeShareTunnel(share core.Share) error {
+	if !share.RevokeTunnel || share.Provider == "" {
+		return nil
+	}
+
+	if store, err := shareStore(); err == nil {
+		if shares, err := store.List(); err == nil {
+			now := time.Now()
+			for _, other := range shares {
+				if other.ID != share.ID && other.Provider == share.Provider && !other.Expired(now) {
+					return nil
+				}
+			}
+		}
+	}
+
+	provider, err := reg.GetProvider(share.Provider)
+	if err != nil {
+		return err
+	}
+	if !provider.IsConnected() {
+		return nil
+	}
+	return provider.Disconnect()
+}
+
+// logShareEvent writes a share lifecycle event to the audit log
+func logShareEvent(eventType string, share core.Share, err error) {
+	homeDir, _ := os.UserHomeDir()
+	auditLogPath := filepath.Join(homeDir, ".config", "tunnel", "audit.log")
+	auditLogger, logErr := core.NewAuditLogger(auditLogPath, false, "")
+	if logErr != nil {
+		if verbose {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to initialize audit logger: %v\n", logErr)
+		}
+		return
+	}
+	defer auditLogger.Close()
+
+	details := map[string]interface{}{
+		"share_id":      share.ID,
+		"provider":      share.Provider,
+		"fingerprints":  share.Fingerprints,
+		"expires_at":    share.ExpiresAt,
+		"revoke_tunnel": share.RevokeTunnel,
+	}
+	if err != nil {
+		details["error"] = err.Error()
+	}
+
+	_ = auditLogger.Log(core.AuditEvent{
+		Timestamp: time.Now(),
+		EventType: eventType,
+		Method:    "github",
+		User:      share.User,
+		Details:   details,
+		Success:   err == nil,
+	})
+}
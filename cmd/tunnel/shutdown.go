@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// defaultShutdownTimeout bounds a graceful shutdown when nothing is configured
+const defaultShutdownTimeout = 10 * time.Second
+
+// shutdownStep is one piece of teardown registered with onShutdown
+type shutdownStep struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+var (
+	shutdownMu    sync.Mutex
+	shutdownSteps []shutdownStep
+)
+
+// onShutdown registers fn to run when the process shuts down. Steps run in
+// reverse registration order, so a component is torn down before anything it
+// was built on top of.
+func onShutdown(name string, fn func(ctx context.Context) error) {
+	shutdownMu.Lock()
+	defer shutdownMu.Unlock()
+	shutdownSteps = append(shutdownSteps, shutdownStep{name: name, fn: fn})
+}
+
+// shutdownTimeout returns how long a graceful shutdown may take before the
+// process is forced to exit. TUNNEL_SHUTDOWN_TIMEOUT overrides the config file.
+func shutdownTimeout() time.Duration {
+	seconds := viper.GetInt("shutdown_timeout")
+	if seconds <= 0 && appConfig != nil {
+		seconds = appConfig.Settings.ShutdownTimeout
+	}
+	if seconds <= 0 {
+		return defaultShutdownTimeout
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// shutdown runs the registered shutdown steps, giving up after timeout.
+// Each step receives a context that expires with the overall deadline.
+func shutdown(timeout time.Duration) error {
+	shutdownMu.Lock()
+	steps := shutdownSteps
+	shutdownSteps = nil
+	shutdownMu.Unlock()
+
+	if len(steps) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		var errors []error
+		for i := len(steps) - 1; i >= 0; i-- {
+			step := steps[i]
+			if verbose {
+				fmt.Printf("Shutting down %s...\n", step.name)
+			}
+			if err := step.fn(ctx); err != nil {
+				errors = append(errors, fmt.Errorf("%s: %w", step.name, err))
+			}
+		}
+
+		if len(errors) > 0 {
+			done <- fmt.Errorf("errors during shutdown: %v", errors)
+			return
+		}
+		done <- nil
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("shutdown did not finish within %s", timeout)
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/output"
+	"github.com/spf13/cobra"
+)
+
+// Actions under the two-person rule
+const (
+	actionEmergencyRevoke = "emergency-revoke"
+	actionFleetRevoke     = "fleet-revoke"
+)
+
+var stagedApproveForce bool
+
+var stagedCmd = &cobra.Command{
+	Use:   "staged",
+	Short: "Approve or reject actions staged under the two-person rule",
+	Long: `With two_person.enabled in the config, emergency-revoke and fleet revoke
+don't run when asked: they are staged, and run only when a different
+operator approves them within two_person.window minutes (30 by default).
+Operators are told apart by their login, or the login they ran sudo from.
+Staging, approving, rejecting and running are all recorded in the audit log.
+
+Staged actions are kept in two_person.store (default
+/var/lib/tunnel/staged.json), so every operator sees the same ones; give
+the operators a group owning its directory, or run tunnel with sudo.`,
+}
+
+var stagedListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List actions waiting for approval",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return listStagedActions()
+	},
+}
+
+var stagedApproveCmd = &cobra.Command{
+	Use:   "approve <id>",
+	Short: "Approve a staged action and run it",
+	Example: `  tunnel staged approve 3f2a9c1b
+  tunnel staged approve 3f2a9c1b --force`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return approveStagedAction(args[0], stagedApproveForce)
+	},
+}
+
+var stagedRejectCmd = &cobra.Command{
+	Use:   "reject <id>",
+	Short: "Drop a staged action without running it",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return rejectStagedAction(args[0])
+	},
+}
+
+func init() {
+	stagedApproveCmd.Flags().BoolVar(&stagedApproveForce, "force", false, "skip confirmation prompt")
+
+	stagedCmd.AddCommand(stagedListCmd)
+	stagedCmd.AddCommand(stagedApproveCmd)
+	stagedCmd.AddCommand(stagedRejectCmd)
+}
+
+// openStagedStore returns the store of staged actions and a func that
+// closes its audit log
+func openStagedStore() (*core.StagedActionStore, func(), error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get home directory: %w", err)
+	}
+	auditLogger, err := core.NewAuditLogger(auditLogPath(homeDir), false, "")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to initialize audit logger: %w", err)
+	}
+	store := core.NewStagedActionStore(appConfig.TwoPerson.StorePath(), auditLogger)
+	return store, func() { auditLogger.Close() }, nil
+}
+
+// currentOperator names who runs this command: the login sudo was run
+// from, or else the login of the real user ID. SUDO_USER is only believed
+// from root, and only when SUDO_UID agrees with it, since anyone can set
+// it in their environment.
+func currentOperator() (string, error) {
+	if name := os.Getenv("SUDO_USER"); name != "" && os.Geteuid() == 0 && os.Getuid() == 0 {
+		if u, err := user.Lookup(name); err == nil && u.Uid == os.Getenv("SUDO_UID") {
+			return u.Username, nil
+		}
+	}
+	u, err := user.LookupId(strconv.Itoa(os.Getuid()))
+	if err != nil {
+		return "", fmt.Errorf("failed to tell who is running tunnel: %w", err)
+	}
+	return u.Username, nil
+}
+
+// stageAction records action for a second operator to approve, in place of
+// running it
+func stageAction(action core.StagedAction) error {
+	operator, err := currentOperator()
+	if err != nil {
+		return err
+	}
+	store, closeStore, err := openStagedStore()
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	window := time.Duration(appConfig.TwoPerson.Minutes()) * time.Minute
+	staged, err := store.Stage(action, operator, window, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to stage %s: %w", action.Action, err)
+	}
+
+	if jsonOutput {
+		return printJSON(map[string]interface{}{
+			"status": "staged",
+			"action": staged,
+		})
+	}
+	color.Yellow("⏳ %s for %s staged as %s", staged.Action, staged.User, staged.ID)
+	fmt.Printf("\nThe two-person rule is on: another operator must approve it by %s with\n",
+		staged.ExpiresAt.Local().Format("15:04"))
+	fmt.Printf("  tunnel staged approve %s\n", staged.ID)
+	return nil
+}
+
+func listStagedActions() error {
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+	store, closeStore, err := openStagedStore()
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	pending, err := store.Pending(time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to read staged actions: %w", err)
+	}
+
+	if format.Structured() {
+		return writeOutput(format, map[string]interface{}{"actions": pending})
+	}
+	if len(pending) == 0 && format == output.FormatTable {
+		color.Yellow("No actions waiting for approval")
+		return nil
+	}
+
+	table := newTable("ID", "ACTION", "USER", "REASON", "STAGED BY", "EXPIRES")
+	for _, action := range pending {
+		table.AddRow(action.ID, describeStagedAction(action), action.User, orDash(action.Reason),
+			action.StagedBy, action.ExpiresAt.Local().Format("15:04:05"))
+	}
+	return renderTable(format, table)
+}
+
+func approveStagedAction(id string, force bool) error {
+	operator, err := currentOperator()
+	if err != nil {
+		return err
+	}
+	store, closeStore, err := openStagedStore()
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	if !force && !jsonOutput {
+		pending, err := store.Pending(time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to read staged actions: %w", err)
+		}
+		var found *core.StagedAction
+		for i := range pending {
+			if pending[i].ID == id {
+				found = &pending[i]
+			}
+		}
+		if found == nil {
+			return fmt.Errorf("failed to approve %s: %w", id, core.ErrStagedNotFound)
+		}
+
+		color.Red("Approving: %s for %s", describeStagedAction(*found), found.User)
+		fmt.Printf("Staged by %s at %s\n", found.StagedBy, found.StagedAt.Local().Format("15:04:05"))
+		if found.Reason != "" {
+			fmt.Printf("Reason: %s\n", found.Reason)
+		}
+		fmt.Print("\nType 'yes' to approve and run it: ")
+		var confirmation string
+		_, _ = fmt.Scanln(&confirmation)
+		if confirmation != "yes" {
+			color.Yellow("Not approved; the action stays staged")
+			return nil
+		}
+	}
+
+	action, err := store.Approve(id, operator, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to approve %s: %w", id, err)
+	}
+	if !jsonOutput {
+		color.Green("✓ %s approved by %s (staged by %s)", action.ID, operator, action.StagedBy)
+	}
+
+	err = runStagedAction(*action)
+	store.RecordOutcome(*action, operator, err)
+	return err
+}
+
+// runStagedAction runs an approved action as if its command had been run
+// without the two-person rule
+func runStagedAction(action core.StagedAction) error {
+	flag := func(name string) bool {
+		on, _ := strconv.ParseBool(action.Params[name])
+		return on
+	}
+	switch action.Action {
+	case actionEmergencyRevoke:
+		return emergencyRevoke(action.User, action.Reason, flag("kill_sessions"), flag("notify"), true, flag("fleet"))
+	case actionFleetRevoke:
+		return fleetRevoke(action.User, strings.Fields(action.Params["fingerprints"]), action.Reason, action.Params["node"])
+	default:
+		return fmt.Errorf("unknown staged action %q", action.Action)
+	}
+}
+
+func rejectStagedAction(id string) error {
+	operator, err := currentOperator()
+	if err != nil {
+		return err
+	}
+	store, closeStore, err := openStagedStore()
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	action, err := store.Reject(id, operator)
+	if err != nil {
+		return fmt.Errorf("failed to reject %s: %w", id, err)
+	}
+	if jsonOutput {
+		return printJSON(map[string]interface{}{
+			"status": "rejected",
+			"action": action,
+		})
+	}
+	color.Green("✓ %s for %s rejected", action.Action, action.User)
+	return nil
+}
+
+// describeStagedAction names the action with its options
+func describeStagedAction(action core.StagedAction) string {
+	var opts []string
+	switch action.Action {
+	case actionEmergencyRevoke:
+		for _, name := range []string{"kill_sessions", "notify", "fleet"} {
+			if on, _ := strconv.ParseBool(action.Params[name]); on {
+				opts = append(opts, strings.ReplaceAll(name, "_", "-"))
+			}
+		}
+	case actionFleetRevoke:
+		if fps := strings.Fields(action.Params["fingerprints"]); len(fps) > 0 {
+			opts = append(opts, fmt.Sprintf("%d key(s)", len(fps)))
+		} else {
+			opts = append(opts, "all keys")
+		}
+		if node := action.Params["node"]; node != "" {
+			opts = append(opts, "node "+node)
+		}
+	}
+	if len(opts) == 0 {
+		return action.Action
+	}
+	return fmt.Sprintf("%s (%s)", action.Action, strings.Join(opts, ", "))
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/state"
+)
+
+// restoreState brings back the tunnels that were up when tunnel last ran,
+// for start, the daemon and the TUI
+var restoreState bool
+
+func init() {
+	startCmd.Flags().BoolVar(&restoreState, "restore", false, "start the tunnels and provider instances that were up before the last shutdown")
+	daemonCmd.Flags().BoolVar(&restoreState, "restore", false, "once listening, start the tunnels that were up before the last shutdown")
+	rootCmd.Flags().BoolVar(&restoreState, "restore", false, "start the tunnels that were up before the last shutdown, then open the TUI")
+}
+
+// stateStore returns the store recording which tunnels are up
+func stateStore() (*state.Store, error) {
+	path, err := state.DefaultPath()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate connection state: %w", err)
+	}
+	return state.NewStore(path), nil
+}
+
+// updateState applies fn to the connection state. A state that can't be
+// saved doesn't fail the start or stop that changed it.
+func updateState(fn func(*state.State)) {
+	store, err := stateStore()
+	if err == nil {
+		err = store.Update(fn)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to save connection state: %v\n", err)
+	}
+}
+
+// recordConnection notes that method's tunnel is up
+func recordConnection(method string) {
+	updateState(func(s *state.State) {
+		s.PutConnection(state.Connection{ID: method, Method: method, StartedAt: time.Now().UTC()})
+	})
+}
+
+// forgetConnections notes that the tunnels of methods were stopped on
+// purpose, so they aren't restored
+func forgetConnections(methods ...string) {
+	if len(methods) == 0 {
+		return
+	}
+	updateState(func(s *state.State) {
+		for _, method := range methods {
+			s.RemoveMethod(method)
+		}
+	})
+}
+
+// restoreResult is the outcome of tunnel start --restore
+type restoreResult struct {
+	Restored  []string          `json:"restored"`
+	Instances []string          `json:"instances,omitempty"`
+	Failed    map[string]string `json:"failed,omitempty"`
+}
+
+// restoreConnections starts the tunnels recorded in the state file, through
+// the daemon when one is running, and recreates saved provider instances
+// in this process
+func restoreConnections() error {
+	store, err := stateStore()
+	if err != nil {
+		return err
+	}
+	saved, err := store.Load()
+	if err != nil {
+		return fmt.Errorf("failed to read connection state: %w", err)
+	}
+
+	res := restoreResult{Restored: []string{}, Failed: make(map[string]string)}
+	client := daemonClient()
+	for _, method := range saved.Methods() {
+		var started connectResult
+		if client != nil {
+			err = client.Call("start", method, &started)
+		} else {
+			started, err = connectProvider(method)
+		}
+		if err == nil && started.Error != "" {
+			err = fmt.Errorf("%s", started.Error)
+		}
+		if err != nil {
+			res.Failed[method] = err.Error()
+			continue
+		}
+		// Entries the connection manager made for method are replaced by
+		// the one the CLI keeps
+		updateState(func(s *state.State) {
+			s.RemoveMethod(method)
+			s.PutConnection(state.Connection{ID: started.Method, Method: started.Method, StartedAt: time.Now().UTC()})
+		})
+		res.Restored = append(res.Restored, started.Method)
+	}
+
+	if len(saved.Instances) > 0 {
+		restored := newInstanceManager()
+		restored.SetStateStore(store)
+		failed := restored.Restore(saved.Instances)
+		adoptInstances(restored)
+		for _, inst := range saved.Instances {
+			if err, ok := failed[inst.ID]; ok {
+				res.Failed[inst.ID] = err.Error()
+			} else if inst.Connected {
+				res.Instances = append(res.Instances, inst.ID)
+			}
+		}
+	}
+
+	if jsonOutput {
+		if err := printJSON(res); err != nil {
+			return err
+		}
+	} else {
+		printRestoreResult(res)
+	}
+	if len(res.Failed) > 0 {
+		return fmt.Errorf("failed to restore %d of %d connection(s)", len(res.Failed), len(saved.Methods())+len(saved.Instances))
+	}
+	return nil
+}
+
+func printRestoreResult(res restoreResult) {
+	if len(res.Restored) == 0 && len(res.Instances) == 0 && len(res.Failed) == 0 {
+		color.Yellow("No saved connections to restore")
+		return
+	}
+	for _, method := range res.Restored {
+		color.Green("✓ Restored %s connection", method)
+	}
+	for _, id := range res.Instances {
+		color.Green("✓ Restored instance %s", id)
+	}
+
+	failed := make([]string, 0, len(res.Failed))
+	for name := range res.Failed {
+		failed = append(failed, name)
+	}
+	sort.Strings(failed)
+	for _, name := range failed {
+		color.Red("✗ %s: %s", name, strings.TrimSpace(res.Failed[name]))
+	}
+}
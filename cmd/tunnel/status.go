@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/instance"
+	"github.com/jedarden/tunnel/internal/offline"
+	"github.com/jedarden/tunnel/internal/output"
+	"github.com/jedarden/tunnel/internal/providers"
+	"github.com/jedarden/tunnel/internal/registry"
+	"github.com/jedarden/tunnel/internal/units"
+)
+
+// statusSchemaVersion is bumped whenever fields in structured status output change meaning
+const statusSchemaVersion = 2
+
+// runningInstance returns the live TUI or daemon instance, if any
+func runningInstance() *instance.Info {
+	path, err := instanceLockPath()
+	if err != nil {
+		return nil
+	}
+	return instance.Find(path)
+}
+
+// fetchInstanceStatuses asks a running instance for the connections it manages,
+// which is where failover and metrics state lives
+func fetchInstanceStatuses(holder *instance.Info) ([]core.ConnectionStatus, error) {
+	if holder == nil || holder.Port == 0 {
+		return nil, nil
+	}
+	// The daemon answering a status request is the instance itself
+	if holder.PID == os.Getpid() && tunnelManager != nil {
+		return tunnelManager.ConnectionStatuses(), nil
+	}
+
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := getFromInstance(client, holder, "/api/connections/status")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query running instance: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("running instance returned %s", resp.Status)
+	}
+
+	var body struct {
+		Connections []core.ConnectionStatus `json:"connections"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode instance status: %w", err)
+	}
+	return body.Connections, nil
+}
+
+// providerStatusJSON builds the `status --json` entry for one provider.
+// conns are the running instance's connections for this provider. Uptime,
+// total_uptime and latency are in seconds.
+func providerStatusJSON(provider providers.Provider, conns []core.ConnectionStatus, detail bool) map[string]interface{} {
+	installed := provider.IsInstalled()
+	connected := provider.IsConnected()
+
+	info := map[string]interface{}{
+		"name":        provider.Name(),
+		"category":    provider.Category(),
+		"installed":   installed,
+		"connected":   connected,
+		"connections": conns,
+	}
+	if offline.Enabled() && providers.RequiresInternet(provider) {
+		info["status"] = "offline"
+	}
+
+	if installed {
+		if health, err := providers.CheckHealth(provider); err == nil && health != nil {
+			info["health"] = health
+			if info["status"] == nil {
+				info["status"] = health.Status
+			}
+		} else if err != nil {
+			info["last_error"] = err.Error()
+		}
+	}
+
+	// Add connection info if connected
+	if connected {
+		if connInfo, err := provider.GetConnectionInfo(); err == nil && connInfo != nil {
+			info["connection_info"] = connInfo
+			if !connInfo.ConnectedAt.IsZero() {
+				info["uptime"] = time.Since(connInfo.ConnectedAt).Seconds()
+			}
+		}
+	}
+
+	// The managed connection carries failover state the provider itself can't know
+	for _, conn := range conns {
+		if conn.Primary || len(conns) == 1 {
+			info["instance_id"] = conn.ID
+			info["primary"] = conn.Primary
+			info["priority"] = conn.Priority
+			info["consecutive_failures"] = conn.ConsecutiveFailures
+			info["latency"] = conn.Latency.Seconds()
+			info["bytes_sent"] = conn.BytesSent
+			info["bytes_received"] = conn.BytesReceived
+			info["uptime"] = conn.Uptime.Seconds()
+			info["total_uptime"] = conn.TotalUptime.Seconds()
+			if conn.LastError != "" {
+				info["last_error"] = conn.LastError
+			}
+			break
+		}
+	}
+
+	if detail {
+		if usage, warnings := providerResourceUsage(provider); usage != nil {
+			info["resources"] = usage
+			if len(warnings) > 0 {
+				info["resource_warnings"] = warnings
+			}
+		}
+	}
+
+	return info
+}
+
+// sortProviders orders providers by category, then name
+func sortProviders(list []providers.Provider) {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Category() != list[j].Category() {
+			return list[i].Category() < list[j].Category()
+		}
+		return list[i].Name() < list[j].Name()
+	})
+}
+
+// statusRequest says what `tunnel status` needs gathered
+type statusRequest struct {
+	Structured bool `json:"structured"`
+	Detail     bool `json:"detail"`
+}
+
+// statusReport is what `tunnel status` shows. It is gathered by the daemon
+// when one is running, since only the daemon's providers know about the
+// connections it holds.
+type statusReport struct {
+	Rows     [][]string              `json:"rows,omitempty"`     // table rows
+	Warnings []string                `json:"warnings,omitempty"` // resource limit warnings
+	History  []core.ConnectionStatus `json:"history,omitempty"`  // connection timelines for --detail
+
+	Connections []map[string]interface{} `json:"connections,omitempty"` // structured entries
+	Offline     bool                     `json:"offline,omitempty"`
+	Instance    *instance.Info           `json:"instance,omitempty"`
+
+	ProviderInstances []registry.InstanceInfo `json:"provider_instances,omitempty"` // from tunnel instance create
+}
+
+// collectStatus gathers the status of this process's providers
+func collectStatus(req statusRequest) statusReport {
+	providerList := reg.ListProviders()
+	sortProviders(providerList)
+
+	holder := runningInstance()
+	var statuses []core.ConnectionStatus
+	if req.Structured || req.Detail {
+		var err error
+		statuses, err = fetchInstanceStatuses(holder)
+		if err != nil && verbose {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+
+	report := statusReport{Offline: offline.Enabled(), Instance: holder}
+	if im, err := providerInstances(); err == nil {
+		report.ProviderInstances = instanceInfos(im)
+	} else if verbose {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	if req.Structured {
+		byMethod := make(map[string][]core.ConnectionStatus)
+		for _, status := range statuses {
+			byMethod[status.Method] = append(byMethod[status.Method], status)
+		}
+
+		report.Connections = []map[string]interface{}{}
+		for _, provider := range providerList {
+			conns := byMethod[provider.Name()]
+			if conns == nil {
+				conns = []core.ConnectionStatus{}
+			}
+			report.Connections = append(report.Connections, providerStatusJSON(provider, conns, req.Detail))
+		}
+		return report
+	}
+
+	for _, provider := range providerList {
+		row := providerStatusRow(provider)
+		if req.Detail {
+			usage, limitWarnings := providerResourceUsage(provider)
+			if usage != nil && len(usage.Processes) > 0 {
+				row = append(row,
+					strconv.Itoa(len(usage.Processes)),
+					units.Percent(usage.CPUPercent),
+					units.Bytes(int64(usage.MemoryBytes)))
+			}
+			for _, warning := range limitWarnings {
+				report.Warnings = append(report.Warnings, fmt.Sprintf("%s: %s", provider.Name(), warning))
+			}
+		}
+		report.Rows = append(report.Rows, row)
+	}
+	report.History = statuses
+	return report
+}
+
+// showStatusStructured prints the machine-readable status of every provider
+func showStatusStructured(format output.Format, report statusReport) error {
+	connections := report.Connections
+	if connections == nil {
+		connections = []map[string]interface{}{}
+	}
+
+	result := map[string]interface{}{
+		"schema_version": statusSchemaVersion,
+		"offline_mode":   report.Offline,
+		"connections":    connections,
+	}
+	if report.Instance != nil {
+		result["instance"] = report.Instance
+	}
+	if len(report.ProviderInstances) > 0 {
+		result["provider_instances"] = report.ProviderInstances
+	}
+	return writeOutput(format, result)
+}
+
+// timelineWidth is the number of cells in a connection's state timeline
+const timelineWidth = 40
+
+// printConnectionTimelines shows, for each connection of the running
+// instance, a bar of the states it has been in and its cumulative uptime
+func printConnectionTimelines(statuses []core.ConnectionStatus) {
+	if len(statuses) == 0 {
+		return
+	}
+
+	now := time.Now()
+	fmt.Println()
+	fmt.Println("Connection history:")
+	for _, status := range statuses {
+		since := "no transitions recorded"
+		if len(status.History) > 0 {
+			since = "since " + units.Time(status.History[0].At)
+		}
+		fmt.Printf("  %-24s %s  up %s, %s total (%s)\n",
+			status.ID,
+			timelineBar(status.History, now, timelineWidth),
+			units.Duration(status.Uptime),
+			units.Duration(status.TotalUptime),
+			since)
+	}
+}
+
+// timelineBar renders history as width cells spanning from the first
+// transition to now, each showing the state the connection was in at the
+// end of that slice of time
+func timelineBar(history []core.StateChange, now time.Time, width int) string {
+	if len(history) == 0 {
+		return strings.Repeat(" ", width)
+	}
+
+	start := history[0].At
+	span := now.Sub(start)
+	if span <= 0 {
+		span = time.Second
+	}
+
+	var b strings.Builder
+	next := 0
+	state := ""
+	for i := 0; i < width; i++ {
+		cellEnd := start.Add(span * time.Duration(i+1) / time.Duration(width))
+		for next < len(history) && !history[next].At.After(cellEnd) {
+			state = history[next].State
+			next++
+		}
+		b.WriteString(timelineCell(state))
+	}
+	return b.String()
+}
+
+// timelineCell returns the colored cell for a state name
+func timelineCell(state string) string {
+	switch strings.ToLower(state) {
+	case "connected":
+		return color.GreenString("█")
+	case "connecting", "reconnecting":
+		return color.YellowString("▒")
+	case "failed":
+		return color.RedString("░")
+	default:
+		return "·"
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/logging"
+	"github.com/jedarden/tunnel/internal/providers"
+	"github.com/jedarden/tunnel/internal/registry"
+)
+
+// throttleable are the providers whose client connects to a local port,
+// where a relay can hold the tunnel to its bandwidth limits
+var throttleable = map[string]bool{"bore": true, "ngrok": true, "reverse-ssh": true}
+
+// throttles are the bandwidth relays of this process, by provider. They are
+// started once, before anything connects, and only read afterwards.
+var throttles map[string]*core.Throttle
+
+// bandwidthLimits returns the limits the providers section sets for name
+func bandwidthLimits(name string) core.ThrottleLimits {
+	upload, download := appConfig.Providers[name].Limits()
+	return core.ThrottleLimits{UploadKbps: upload, DownloadKbps: download}
+}
+
+// startThrottles puts a relay in front of the local service of each
+// provider with bandwidth limits or capture enabled, and points the
+// provider at it. Only long-running commands call it: tunnels made by a
+// one-shot command outlive it, and would outlive the relay too.
+func startThrottles(ctx context.Context) {
+	throttles = make(map[string]*core.Throttle)
+	for name, traffic := range appConfig.Providers {
+		limits := bandwidthLimits(name)
+		if limits.Unlimited() && !traffic.Capture {
+			continue
+		}
+		if !throttleable[name] {
+			fmt.Fprintf(os.Stderr, "Warning: not relaying %s for bandwidth limits or capture: it does not forward a local port\n", name)
+			continue
+		}
+		provider, err := reg.GetProvider(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: not relaying %s: %v\n", name, err)
+			continue
+		}
+
+		th := core.NewThrottle(name, throttleTarget(providerConfig(provider)), limits)
+		th.OnTransfer = func(sent, received int64) {
+			countTraffic(name, sent, received)
+		}
+		if err := th.Listen("127.0.0.1:0"); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: not relaying %s: %v\n", name, err)
+			continue
+		}
+		onShutdown("throttle "+name, func(context.Context) error {
+			if c := th.StopCapture(); c != nil {
+				c.Close()
+			}
+			return th.Close()
+		})
+		go func() {
+			if err := th.Serve(ctx); err != nil {
+				logging.For("throttle").Error("relay stopped", "provider", name, "error", err)
+			}
+		}()
+		throttles[name] = th
+	}
+	throttleRegistry(reg)
+}
+
+// throttleRegistry points the providers of r that have a relay at it
+func throttleRegistry(r *registry.Registry) {
+	for name, th := range throttles {
+		provider, err := r.GetProvider(name)
+		if err != nil {
+			continue
+		}
+		cfg := providerConfig(provider)
+		updated := *cfg
+		updated.LocalPort = th.Port()
+		updated.Extra = make(map[string]string, len(cfg.Extra))
+		for k, v := range cfg.Extra {
+			updated.Extra[k] = v
+		}
+		// Settings such as reverse-ssh's win over the generic field
+		if updated.Extra["localPort"] != "" {
+			updated.Extra["localPort"] = strconv.Itoa(th.Port())
+		}
+		if updated.Extra["localHost"] != "" {
+			updated.Extra["localHost"] = "127.0.0.1"
+		}
+		if err := provider.Configure(&updated); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: not relaying %s: %v\n", name, err)
+		}
+	}
+}
+
+// providerConfig returns provider's configuration, or an empty one when it
+// has none yet
+func providerConfig(provider providers.Provider) *providers.ProviderConfig {
+	cfg, err := provider.GetConfig()
+	if err != nil || cfg == nil {
+		return &providers.ProviderConfig{Name: provider.Name()}
+	}
+	return cfg
+}
+
+// throttleTarget is the local service cfg forwards, SSH unless configured
+// otherwise
+func throttleTarget(cfg *providers.ProviderConfig) string {
+	host, port := "127.0.0.1", "22"
+	if cfg.LocalPort != 0 {
+		port = strconv.Itoa(cfg.LocalPort)
+	}
+	if p := cfg.Extra["localPort"]; p != "" {
+		port = p
+	}
+	if h := cfg.Extra["localHost"]; h != "" {
+		host = h
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// countTraffic adds relayed bytes to the metrics of name's connections
+func countTraffic(name string, sent, received int64) {
+	if manager == nil {
+		return
+	}
+	conns, err := manager.List()
+	if err != nil {
+		return
+	}
+	for _, conn := range conns {
+		if conn.Method == name && conn.Metrics != nil {
+			conn.Metrics.AddTraffic(sent, received)
+		}
+	}
+}
+
+// throttleStatuses returns the limits and live rates of the relays, by
+// provider name
+func throttleStatuses() []core.ThrottleStatus {
+	statuses := make([]core.ThrottleStatus, 0, len(throttles))
+	for _, th := range throttles {
+		statuses = append(statuses, th.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Provider < statuses[j].Provider
+	})
+	return statuses
+}
+
+// warnUnthrottled tells a one-shot command that method's bandwidth limits
+// need a process that stays around to hold them
+func warnUnthrottled(method string) {
+	if throttles == nil && !bandwidthLimits(method).Unlimited() {
+		fmt.Fprintf(os.Stderr, "Warning: bandwidth limits of %s apply only to tunnels started by the daemon, the TUI or tunnel serve\n", method)
+	}
+}
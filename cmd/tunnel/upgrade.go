@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/installer"
+	"github.com/jedarden/tunnel/internal/providers"
+	"github.com/jedarden/tunnel/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+// changelogLines caps the release notes shown per release before upgrading
+const changelogLines = 15
+
+var (
+	upgradeAll      bool
+	upgradeCheck    bool
+	upgradeYes      bool
+	upgradePin      string
+	upgradeUnpin    bool
+	upgradeNoCanary bool
+)
+
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade [provider...]",
+	Short: "Upgrade provider binaries installed by tunnel",
+	Long: `Upgrade the provider binaries tunnel installed to their latest release.
+Binaries installed some other way, such as through the system package
+manager, are left alone.
+
+The release notes of every newer version are shown before upgrading.
+Afterwards a canary checks the new binary: it must run, and a provider
+that is not carrying a connection is connected, health checked and
+disconnected again. If the canary fails the previous binary is restored.
+Providers pinned under "updates.pin" in the config are held at their
+version and skipped; pin or unpin one with --pin and --unpin.
+
+While "updates.check" is on, the running instance checks for new releases
+every "updates.interval" seconds and sends a notification when one is out.`,
+	Example: `  tunnel upgrade --check
+  tunnel upgrade --all
+  tunnel upgrade bore --yes
+  tunnel upgrade tailscale --pin 1.66.4
+  tunnel upgrade tailscale --unpin`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch {
+		case upgradePin != "" || upgradeUnpin:
+			if len(args) != 1 {
+				return fmt.Errorf("--pin and --unpin take exactly one provider")
+			}
+			return pinProvider(args[0], upgradePin)
+		case len(args) == 0 && !upgradeAll && !upgradeCheck:
+			return fmt.Errorf("name the providers to upgrade, or use --all")
+		}
+		return upgradeProviders(args, upgradeCheck, upgradeYes, !upgradeNoCanary)
+	},
+}
+
+func init() {
+	upgradeCmd.Flags().BoolVar(&upgradeAll, "all", false, "upgrade every provider installed by tunnel")
+	upgradeCmd.Flags().BoolVar(&upgradeCheck, "check", false, "only list available updates")
+	upgradeCmd.Flags().BoolVarP(&upgradeYes, "yes", "y", false, "upgrade without asking for confirmation")
+	upgradeCmd.Flags().BoolVar(&upgradeNoCanary, "no-canary", false, "skip the canary check after upgrading")
+	upgradeCmd.Flags().StringVar(&upgradePin, "pin", "", "hold the provider at this version")
+	upgradeCmd.Flags().BoolVar(&upgradeUnpin, "unpin", false, "release a pinned provider")
+}
+
+// openInstallStore opens the record of the binaries tunnel installed
+func openInstallStore() (*installer.Store, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return installer.NewStore(filepath.Join(homeDir, ".config", "tunnel", "installed.json")), nil
+}
+
+// newUpdateChecker returns a checker for the installed binaries
+func newUpdateChecker() (*installer.Checker, error) {
+	store, err := openInstallStore()
+	if err != nil {
+		return nil, err
+	}
+	return &installer.Checker{
+		Store:    store,
+		Releases: installer.NewGitHub(),
+		Pins:     appConfig.Updates.Pin,
+	}, nil
+}
+
+func upgradeProviders(names []string, checkOnly, yes, canary bool) error {
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+
+	checker, err := newUpdateChecker()
+	if err != nil {
+		return err
+	}
+	for i, name := range names {
+		provider, err := reg.GetProvider(name)
+		if err != nil {
+			return err
+		}
+		names[i] = provider.Name()
+	}
+
+	updates, err := checker.Check(context.Background(), names...)
+	if err != nil {
+		return fmt.Errorf("failed to check for updates: %w", err)
+	}
+
+	if checkOnly {
+		if format.Structured() {
+			return writeOutput(format, map[string]interface{}{"updates": updates})
+		}
+		if len(updates) == 0 {
+			color.Yellow("No provider binaries were installed by tunnel")
+			return nil
+		}
+		table := newTable("PROVIDER", "INSTALLED", "LATEST", "STATUS")
+		for _, u := range updates {
+			table.AddRow(u.Provider, orUnknown(u.Installed), u.Latest, updateStatus(u))
+		}
+		return renderTable(format, table)
+	}
+
+	upgraded, rolledBack := 0, 0
+	for _, u := range updates {
+		switch {
+		case u.Pinned != "":
+			color.Yellow("Skipping %s: pinned at %s", u.Provider, u.Pinned)
+			continue
+		case !u.Available():
+			fmt.Printf("%s is up to date (%s)\n", u.Provider, orUnknown(u.Installed))
+			continue
+		}
+
+		printChangelog(u)
+		if !yes && !confirmUpgrade(u) {
+			continue
+		}
+
+		provider, err := reg.GetProvider(u.Provider)
+		if err != nil {
+			return err
+		}
+		var check installer.Canary
+		if canary {
+			check = canaryConnect(provider)
+		}
+		fmt.Printf("Upgrading %s...\n", u.Provider)
+		record, err := installer.Upgrade(checker.Store, provider, check)
+		if errors.Is(err, installer.ErrCanaryFailed) {
+			color.Red("✗ %s: %v", u.Provider, err)
+			rolledBack++
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to upgrade %s: %w", u.Provider, err)
+		}
+		color.Green("✓ Upgraded %s to %s", u.Provider, orUnknown(record.Version))
+		upgraded++
+	}
+
+	if upgraded == 0 && len(updates) == 0 {
+		color.Yellow("No provider binaries were installed by tunnel")
+	}
+	if rolledBack > 0 {
+		return fmt.Errorf("%d upgrades failed their canary and were rolled back", rolledBack)
+	}
+	return nil
+}
+
+// canaryConnect returns the check run on p after its binary is upgraded.
+// The new binary must report its version. A configured provider that is not
+// connected is then brought up as a test instance, health checked and taken
+// down; one that is connected carries the primary and is left alone.
+func canaryConnect(p providers.Provider) installer.Canary {
+	return func() error {
+		if installer.DetectVersion(p.Name()) == "" {
+			return fmt.Errorf("new %s binary does not report a version", p.Name())
+		}
+		if p.IsConnected() {
+			return nil
+		}
+		if cfg, err := p.GetConfig(); err != nil || p.ValidateConfig(cfg) != nil {
+			return nil
+		}
+
+		if err := p.Connect(); err != nil {
+			return fmt.Errorf("canary connection: %w", err)
+		}
+		defer p.Disconnect()
+
+		health, err := p.HealthCheck()
+		if err != nil {
+			return fmt.Errorf("canary health check: %w", err)
+		}
+		if !health.Healthy {
+			return fmt.Errorf("canary connection unhealthy: %s", health.Message)
+		}
+		return nil
+	}
+}
+
+// printChangelog shows the releases between the installed and latest version
+func printChangelog(u installer.Update) {
+	color.Cyan("%s %s → %s", u.Provider, orUnknown(u.Installed), u.Latest)
+	for _, release := range u.Changelog {
+		fmt.Printf("\n  %s (%s)\n", release.Version, release.PublishedAt.Local().Format("2006-01-02"))
+		lines := strings.Split(release.Notes, "\n")
+		if len(lines) > changelogLines {
+			lines = append(lines[:changelogLines], "…")
+		}
+		for _, line := range lines {
+			fmt.Printf("    %s\n", strings.TrimRight(line, "\r"))
+		}
+		if release.URL != "" {
+			fmt.Printf("    %s\n", release.URL)
+		}
+	}
+	fmt.Println()
+}
+
+func confirmUpgrade(u installer.Update) bool {
+	fmt.Printf("Upgrade %s to %s? (y/N): ", u.Provider, u.Latest)
+	var confirm string
+	fmt.Scanln(&confirm)
+	return strings.ToLower(confirm) == "y"
+}
+
+func updateStatus(u installer.Update) string {
+	switch {
+	case u.Pinned != "":
+		return "pinned at " + u.Pinned
+	case u.Available():
+		return fmt.Sprintf("%d new releases", len(u.Changelog))
+	default:
+		return "up to date"
+	}
+}
+
+func orUnknown(version string) string {
+	if version == "" {
+		return "unknown"
+	}
+	return version
+}
+
+// pinProvider holds name at version in the config, or releases it when
+// version is empty
+func pinProvider(name, version string) error {
+	provider, err := reg.GetProvider(name)
+	if err != nil {
+		return err
+	}
+	name = provider.Name()
+
+	if appConfig.Updates.Pin == nil {
+		appConfig.Updates.Pin = make(map[string]string)
+	}
+	if version == "" {
+		delete(appConfig.Updates.Pin, name)
+	} else {
+		appConfig.Updates.Pin[name] = version
+	}
+	if err := appConfig.Save(); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+
+	if version == "" {
+		color.Green("✓ Unpinned %s", name)
+	} else {
+		color.Green("✓ Pinned %s at %s", name, version)
+	}
+	return nil
+}
+
+// checkProviderUpdates periodically looks for new releases of the installed
+// provider binaries and notifies once per release
+func checkProviderUpdates(ctx context.Context, cfg *config.Config, notifier *core.NotificationDispatcher) {
+	if cfg == nil || !cfg.Updates.Check {
+		return
+	}
+	checker, err := newUpdateChecker()
+	if err != nil {
+		return
+	}
+
+	notified := make(map[string]string) // provider to the last release announced
+	check := func() {
+		updates, err := checker.Check(ctx)
+		if err != nil {
+			if verbose {
+				fmt.Printf("Warning: failed to check for provider updates: %v\n", err)
+			}
+			return
+		}
+		for _, u := range updates {
+			if !u.Available() || notified[u.Provider] == u.Latest {
+				continue
+			}
+			notified[u.Provider] = u.Latest
+			if notifier == nil {
+				continue
+			}
+			notifier.Notify(core.Notification{
+				Event:   core.NotifyUpdate,
+				Title:   fmt.Sprintf("%s %s available", u.Provider, u.Latest),
+				Message: fmt.Sprintf("Run `tunnel upgrade %s` to upgrade from %s", u.Provider, orUnknown(u.Installed)),
+				Time:    time.Now(),
+			})
+		}
+	}
+
+	interval := time.Duration(cfg.Updates.Interval) * time.Second
+	if interval <= 0 {
+		interval = 24 * time.Hour
+	}
+
+	check()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			check()
+		}
+	}
+}
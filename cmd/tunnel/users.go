@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/output"
+	"github.com/jedarden/tunnel/internal/units"
+	"github.com/spf13/cobra"
+)
+
+var usersCmd = &cobra.Command{
+	Use:   "users",
+	Short: "Manage SSH users' second factors",
+}
+
+var usersEnrollTOTPCmd = &cobra.Command{
+	Use:   "enroll-totp <user>",
+	Short: "Enroll a user for TOTP logins",
+	Long: `Give a user a TOTP secret for their authenticator app and a set of
+single-use backup codes. Enrolling again replaces the secret and codes.
+
+The secret and codes are only shown once; hand them to the user over a
+trusted channel.`,
+	Example: `  tunnel users enroll-totp alice`,
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return enrollTOTP(args[0])
+	},
+}
+
+var usersRemoveTOTPCmd = &cobra.Command{
+	Use:   "remove-totp <user>",
+	Short: "Remove a user's TOTP enrollment",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return removeTOTP(args[0])
+	},
+}
+
+var usersListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List TOTP enrollments and bypassed users",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return listTOTPUsers()
+	},
+}
+
+var usersTOTPConfigCmd = &cobra.Command{
+	Use:   "totp-config",
+	Short: "Print the sshd and PAM configuration for TOTP logins",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		printTOTPConfig()
+		return nil
+	},
+}
+
+var usersVerifyTOTPCmd = &cobra.Command{
+	Use:    "verify-totp",
+	Short:  "Check a TOTP code for pam_exec",
+	Hidden: true,
+	Long: `Read a verification code on stdin and check it for the user in PAM_USER,
+exiting non-zero to refuse the login. Meant to be run by pam_exec with
+expose_authtok; see tunnel users totp-config.
+
+It fails closed: without --config naming an existing config, with a config
+it can't load, or with ssh.totp.store not an absolute path, every login is
+refused.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return verifyTOTP(os.Getenv("PAM_USER"))
+	},
+	SilenceUsage: true,
+}
+
+func init() {
+	usersCmd.AddCommand(usersEnrollTOTPCmd)
+	usersCmd.AddCommand(usersRemoveTOTPCmd)
+	usersCmd.AddCommand(usersListCmd)
+	usersCmd.AddCommand(usersTOTPConfigCmd)
+	usersCmd.AddCommand(usersVerifyTOTPCmd)
+}
+
+// totpStore opens the configured TOTP enrollments
+func totpStore() (*core.TOTPStore, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return core.NewTOTPStore(totpStorePath(homeDir)), nil
+}
+
+// totpStorePath returns where TOTP enrollments are kept
+func totpStorePath(homeDir string) string {
+	if appConfig != nil && appConfig.SSH.TOTP.Store != "" {
+		return expandHome(appConfig.SSH.TOTP.Store, homeDir)
+	}
+	return filepath.Join(homeDir, ".config", "tunnel", "totp.json")
+}
+
+// totpConfigErr is why verify-totp can't trust the config it runs with
+var totpConfigErr error
+
+// checkTOTPConfigFile records, before the config is loaded, whether
+// verify-totp was pointed at an existing config file. Loading creates a
+// missing one with TOTP disabled, which would let every login through.
+func checkTOTPConfigFile() {
+	if cfgFile == "" {
+		totpConfigErr = errors.New("no --config given")
+		return
+	}
+	if _, err := os.Stat(cfgFile); err != nil {
+		totpConfigErr = err
+	}
+}
+
+// totpIssuer is the name authenticator apps list the secret under
+func totpIssuer() string {
+	if appConfig != nil && appConfig.SSH.TOTP.Issuer != "" {
+		return appConfig.SSH.TOTP.Issuer
+	}
+	return "TUNNEL"
+}
+
+// totpBypassed reports whether user logs in without a second factor
+func totpBypassed(user string) bool {
+	return appConfig != nil && slices.Contains(appConfig.SSH.TOTP.Bypass, user)
+}
+
+func enrollTOTP(user string) error {
+	store, err := totpStore()
+	if err != nil {
+		return err
+	}
+	secret, codes, err := store.Enroll(user)
+	if err != nil {
+		return fmt.Errorf("failed to enroll %s: %w", user, err)
+	}
+	if auditTrail != nil {
+		auditTrail.LogKeyOperation("totp_enrolled", user, true, nil)
+	}
+
+	uri := core.TOTPURI(totpIssuer(), user, secret)
+	if jsonOutput {
+		return printJSON(map[string]interface{}{
+			"user":         user,
+			"secret":       secret,
+			"uri":          uri,
+			"backup_codes": codes,
+		})
+	}
+
+	color.Green("✓ Enrolled %s for TOTP", user)
+	fmt.Println()
+	fmt.Printf("Secret:  %s\n", secret)
+	fmt.Printf("URI:     %s\n", uri)
+	fmt.Println()
+	fmt.Println("Backup codes, each usable once:")
+	for _, code := range codes {
+		fmt.Printf("  %s\n", code)
+	}
+	if totpBypassed(user) {
+		fmt.Println()
+		color.Yellow("%s is in ssh.totp.bypass and is not asked for a code", user)
+	}
+	return nil
+}
+
+func removeTOTP(user string) error {
+	store, err := totpStore()
+	if err != nil {
+		return err
+	}
+	if err := store.Remove(user); err != nil {
+		return fmt.Errorf("failed to remove TOTP for %s: %w", user, err)
+	}
+	if auditTrail != nil {
+		auditTrail.LogKeyOperation("totp_removed", user, true, nil)
+	}
+	color.Green("✓ Removed TOTP for %s", user)
+	return nil
+}
+
+func listTOTPUsers() error {
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+	store, err := totpStore()
+	if err != nil {
+		return err
+	}
+	enrollments, err := store.List()
+	if err != nil {
+		return fmt.Errorf("failed to list TOTP users: %w", err)
+	}
+
+	type userInfo struct {
+		User        string    `json:"user"`
+		EnrolledAt  time.Time `json:"enrolled_at,omitempty"`
+		BackupCodes int       `json:"backup_codes"`
+		Bypass      bool      `json:"bypass"`
+	}
+	var users []userInfo
+	for _, e := range enrollments {
+		users = append(users, userInfo{User: e.User, EnrolledAt: e.EnrolledAt, BackupCodes: len(e.BackupCodes), Bypass: totpBypassed(e.User)})
+	}
+	if appConfig != nil {
+		for _, user := range appConfig.SSH.TOTP.Bypass {
+			if !slices.ContainsFunc(users, func(u userInfo) bool { return u.User == user }) {
+				users = append(users, userInfo{User: user, Bypass: true})
+			}
+		}
+	}
+
+	if format.Structured() {
+		return writeOutput(format, map[string]interface{}{"users": users})
+	}
+	if len(users) == 0 && format == output.FormatTable {
+		color.Yellow("No users enrolled for TOTP")
+		return nil
+	}
+
+	table := newTable("USER", "ENROLLED", "BACKUP CODES", "BYPASS")
+	for _, u := range users {
+		enrolled := "-"
+		if !u.EnrolledAt.IsZero() {
+			enrolled = units.Time(u.EnrolledAt)
+		}
+		bypass := "no"
+		if u.Bypass {
+			bypass = "yes"
+		}
+		table.AddRow(u.User, enrolled, fmt.Sprint(u.BackupCodes), bypass)
+	}
+	return renderTable(format, table)
+}
+
+// printTOTPConfig prints what sshd and PAM need to ask for a TOTP code
+// after a successful publickey authentication
+func printTOTPConfig() {
+	binary, err := os.Executable()
+	if err != nil {
+		binary = "/usr/local/bin/tunnel"
+	}
+	// verify-totp refuses logins without a config of its own to read
+	configPath := appConfig.Path()
+	if cfgFile != "" {
+		configPath = cfgFile
+	}
+	configFlag := ""
+	if abs, err := filepath.Abs(configPath); err == nil && configPath != "" {
+		configFlag = " --config " + abs
+	}
+	if store := appConfig.SSH.TOTP.Store; !filepath.IsAbs(store) {
+		color.Yellow("⚠ ssh.totp.store is %q; set it to an absolute path, or verify-totp refuses every login\n", store)
+	}
+
+	fmt.Println("# /etc/ssh/sshd_config.d/tunnel-totp.conf")
+	fmt.Println("UsePAM yes")
+	fmt.Println("KbdInteractiveAuthentication yes")
+	fmt.Println("AuthenticationMethods publickey,keyboard-interactive")
+	if appConfig != nil && len(appConfig.SSH.TOTP.Bypass) > 0 {
+		fmt.Println()
+		fmt.Printf("Match User %s\n", strings.Join(appConfig.SSH.TOTP.Bypass, ","))
+		fmt.Println("    AuthenticationMethods publickey")
+	}
+	fmt.Println()
+	fmt.Println("# /etc/pam.d/sshd: replace the common-auth include with")
+	fmt.Printf("auth required pam_exec.so expose_authtok quiet %s%s users verify-totp\n", binary, configFlag)
+}
+
+// verifyTOTP checks the code on stdin for user and fails unless it is
+// valid, the user is bypassed, or TOTP is disabled. It fails closed: a
+// config or store it can't read refuses the login.
+func verifyTOTP(user string) error {
+	if user == "" {
+		return fmt.Errorf("PAM_USER is not set")
+	}
+	if totpConfigErr != nil {
+		return fmt.Errorf("refusing login: verify-totp needs --config naming an existing config: %w", totpConfigErr)
+	}
+	if configErr != nil {
+		return fmt.Errorf("refusing login: failed to load config: %w", configErr)
+	}
+	if !appConfig.SSH.TOTP.Enabled || totpBypassed(user) {
+		return nil
+	}
+	// PAM runs us with its own HOME, so ~ would name the wrong store
+	storePath := appConfig.SSH.TOTP.Store
+	if !filepath.IsAbs(storePath) {
+		return fmt.Errorf("refusing login: ssh.totp.store must be an absolute path, not %q", storePath)
+	}
+
+	code, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && code == "" {
+		return fmt.Errorf("failed to read verification code: %w", err)
+	}
+	// pam_exec passes the token NUL-terminated
+	code = strings.TrimRight(code, "\x00\r\n")
+
+	store := core.NewTOTPStore(storePath)
+	method, err := store.Verify(user, code, time.Now())
+	if auditTrail != nil {
+		details := map[string]interface{}{"factor": method}
+		if err != nil {
+			details = map[string]interface{}{"error": err.Error()}
+		}
+		auditTrail.Log(core.AuditEvent{
+			Timestamp: time.Now(),
+			EventType: "totp_verify",
+			Method:    "ssh",
+			User:      user,
+			SourceIP:  os.Getenv("PAM_RHOST"),
+			Details:   details,
+			Success:   err == nil,
+		})
+	}
+	if errors.Is(err, core.ErrTOTPNotEnrolled) {
+		return fmt.Errorf("%s is not enrolled for TOTP", user)
+	}
+	return err
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/probe"
+	"github.com/jedarden/tunnel/internal/providers"
+	"github.com/spf13/cobra"
+)
+
+var (
+	verifyEndpoint string
+	verifyProbe    string
+	verifyTimeout  int
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <method>",
+	Short: "Check from outside that a tunnel is reachable",
+	Long: `Ask the probes in the verify section of the config to connect to the
+public endpoint of a tunnel and read its SSH banner. Unlike tunnel status,
+which checks the tunnel from this host, this confirms the endpoint answers
+from the internet.
+
+A probe is another TUNNEL node with verify.answer_probes enabled, reached at
+its /api/probe route with an admin API token, or any service taking the
+same requests.`,
+	Example: `  tunnel verify bore
+  tunnel verify ngrok --probe node-b
+  tunnel verify reverse-ssh --endpoint vps.example.com:2222`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runVerify(args[0], verifyEndpoint, verifyProbe, verifyTimeout)
+	},
+}
+
+func init() {
+	verifyCmd.Flags().StringVar(&verifyEndpoint, "endpoint", "", "host:port to check instead of the tunnel's public endpoint")
+	verifyCmd.Flags().StringVar(&verifyProbe, "probe", "", "ask only the named probe")
+	verifyCmd.Flags().IntVar(&verifyTimeout, "timeout", 0, "seconds each probe may take to connect (default from config, or 10)")
+}
+
+// verifyRow is one probe's answer, or why it could not give one
+type verifyRow struct {
+	probe.Result
+	Failed bool `json:"probe_failed,omitempty"` // the probe itself could not be asked
+}
+
+func runVerify(method, endpoint, only string, timeout int) error {
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+	if timeout < 0 {
+		return fmt.Errorf("invalid --timeout %d: it must not be negative", timeout)
+	}
+	if timeout == 0 {
+		timeout = appConfig.Verify.Timeout
+	}
+
+	if endpoint == "" {
+		if endpoint, err = publicEndpoint(method); err != nil {
+			return err
+		}
+	}
+	host, port := providers.SplitHostPort(endpoint)
+	if port == 0 {
+		return fmt.Errorf("invalid endpoint %q: expected host:port", endpoint)
+	}
+	req := probe.Request{Host: host, Port: port, Timeout: timeout}
+	if err := req.Validate(); err != nil {
+		return fmt.Errorf("invalid endpoint %q: %w", endpoint, err)
+	}
+	if isLoopbackHost(host) {
+		return fmt.Errorf("%s only answers on this host; a probe elsewhere cannot reach it", endpoint)
+	}
+
+	clients, err := probeClients(only)
+	if err != nil {
+		return err
+	}
+
+	rows := make([]verifyRow, len(clients))
+	var wg sync.WaitGroup
+	for i, client := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := client.Verify(context.Background(), req)
+			if err != nil {
+				rows[i] = verifyRow{Result: probe.Result{Probe: client.Name, Address: req.Address(), Error: err.Error()}, Failed: true}
+				return
+			}
+			rows[i] = verifyRow{Result: res}
+		}()
+	}
+	wg.Wait()
+
+	reached := 0
+	for _, row := range rows {
+		if row.Reachable {
+			reached++
+		}
+	}
+
+	if format.Structured() {
+		if err := writeOutput(format, map[string]interface{}{
+			"method":    method,
+			"endpoint":  req.Address(),
+			"reachable": reached > 0,
+			"probes":    rows,
+		}); err != nil {
+			return err
+		}
+	} else {
+		if !noHeaders {
+			fmt.Printf("Checking %s (%s) from %d probe(s)\n\n", req.Address(), method, len(rows))
+		}
+		table := newTable("PROBE", "RESULT", "LATENCY", "DETAIL")
+		for _, row := range rows {
+			result, latency, detail := "unreachable", "-", row.Error
+			switch {
+			case row.Reachable:
+				result, latency, detail = "reachable", strconv.FormatInt(row.LatencyMS, 10)+"ms", row.Banner
+			case row.Failed:
+				result = "probe failed"
+			}
+			table.AddRow(row.Probe, result, latency, detail)
+		}
+		if err := renderTable(format, table); err != nil {
+			return err
+		}
+	}
+
+	if reached == 0 {
+		return fmt.Errorf("%s is not reachable from any probe", req.Address())
+	}
+	if !format.Structured() {
+		fmt.Println()
+		color.Green("✓ %s is reachable from %d of %d probe(s)", req.Address(), reached, len(rows))
+	}
+	return nil
+}
+
+// publicEndpoint returns the host:port the tunnel of method answers on
+// publicly
+func publicEndpoint(method string) (string, error) {
+	provider, err := reg.GetProvider(method)
+	if err != nil {
+		return "", err
+	}
+	if !provider.IsConnected() {
+		return "", fmt.Errorf("%s is not connected; start it first or pass --endpoint", method)
+	}
+	info, err := provider.GetConnectionInfo()
+	if err != nil {
+		return "", fmt.Errorf("failed to get connection info: %w", err)
+	}
+
+	switch {
+	case info != nil && info.TunnelURL != "":
+		if strings.HasPrefix(info.TunnelURL, "http://") || strings.HasPrefix(info.TunnelURL, "https://") {
+			return "", fmt.Errorf("%s serves its tunnel over HTTP at %s, which has no SSH banner to check; pass --endpoint for a TCP endpoint", method, info.TunnelURL)
+		}
+		if host, port := providers.SplitHostPort(info.TunnelURL); port != 0 {
+			return net.JoinHostPort(host, strconv.Itoa(port)), nil
+		}
+	case info != nil && info.RemoteIP != "":
+		port := info.RemotePort
+		if port == 0 {
+			port = 22
+		}
+		return net.JoinHostPort(info.RemoteIP, strconv.Itoa(port)), nil
+	}
+	return "", fmt.Errorf("%s does not report a public endpoint; pass --endpoint", method)
+}
+
+// probeClients returns the configured probes, or only the one named
+func probeClients(only string) ([]*probe.Client, error) {
+	configured := appConfig.Verify.Probes
+	if len(configured) == 0 {
+		return nil, fmt.Errorf("no probes configured; add one to verify.probes, such as another node's /api/probe")
+	}
+
+	home, _ := os.UserHomeDir()
+	var clients []*probe.Client
+	for _, p := range configured {
+		if only != "" && p.Name != only {
+			continue
+		}
+		client := &probe.Client{Name: p.Name, URL: p.URL}
+		if p.TokenFile != "" {
+			data, err := os.ReadFile(expandHome(p.TokenFile, home))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read token of probe %s: %w", p.Name, err)
+			}
+			client.Token = strings.TrimSpace(string(data))
+		}
+		clients = append(clients, client)
+	}
+	if len(clients) == 0 {
+		return nil, fmt.Errorf("no probe named %s in verify.probes", only)
+	}
+	return clients, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/logging"
+	"github.com/jedarden/tunnel/internal/wake"
+	"github.com/jedarden/tunnel/pkg/config"
+	"github.com/jedarden/tunnel/pkg/tunnel"
+)
+
+// startWake binds the configured wake-on-demand listeners, so their
+// tunnels start when first used instead of running all the time
+func startWake(ctx context.Context, cfg *config.Config, r *tunnel.Registry) {
+	if cfg == nil {
+		return
+	}
+
+	for _, w := range cfg.Wake {
+		provider, err := r.GetProvider(w.Provider)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: wake listener %s: %v\n", w.Listen, err)
+			continue
+		}
+
+		l := &wake.Listener{
+			Addr:         w.Listen,
+			Target:       w.Target,
+			Tunnel:       storedCredentialTunnel{provider},
+			IdleTimeout:  time.Duration(w.IdleTimeout) * time.Second,
+			StartTimeout: time.Duration(w.StartTimeout) * time.Second,
+			Logger:       log.Default(),
+		}
+		if err := l.Listen(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: wake listener: %v\n", err)
+			continue
+		}
+		onShutdown("wake listener "+w.Listen, l.Close)
+		go func() {
+			if err := l.Serve(ctx); err != nil {
+				logging.For("wake").Error("listener stopped", "addr", l.Addr, "error", err)
+			}
+		}()
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/fatih/color"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/registry"
+	"github.com/jedarden/tunnel/internal/units"
+	"github.com/spf13/cobra"
+)
+
+var watchTypes []string
+
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Stream events from the running instance",
+	Long: `Connect to the running tunnel instance and print connection events as they
+happen. With --json each event is printed as a single line of JSON, which makes
+the stream easy to consume from shell scripts.
+
+Event types: connected, disconnected, reconnecting, failover, metrics_update,
+error, state_change, primary_change, network_change, provider_registered,
+provider_unregistered.`,
+	Example: `  tunnel watch
+  tunnel watch --type failover,error
+  tunnel watch --type failover --json | while read -r event; do notify "$event"; done`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return watchEvents(cmd.Context(), watchTypes)
+	},
+}
+
+func init() {
+	watchCmd.Flags().StringSliceVar(&watchTypes, "type", nil, "only show these event types (comma-separated)")
+}
+
+// watchEvent is an event as delivered over the instance's WebSocket
+type watchEvent struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+	Time    string          `json:"time"`
+}
+
+// watchEventTypes normalizes and validates the --type filter
+func watchEventTypes(types []string) (map[string]bool, error) {
+	if len(types) == 0 {
+		return nil, nil
+	}
+
+	filter := make(map[string]bool, len(types))
+	for _, name := range types {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		switch strings.ToLower(name) {
+		case "provider_" + string(registry.ChangeRegistered), "provider_" + string(registry.ChangeUnregistered):
+			filter[strings.ToLower(name)] = true
+			continue
+		}
+
+		t, err := core.ParseEventType(name)
+		if err != nil {
+			return nil, err
+		}
+		filter[strings.ToLower(t.String())] = true
+	}
+	return filter, nil
+}
+
+func watchEvents(ctx context.Context, types []string) error {
+	filter, err := watchEventTypes(types)
+	if err != nil {
+		return err
+	}
+
+	holder := runningInstance()
+	if holder == nil || holder.Port == 0 {
+		return fmt.Errorf("no running tunnel instance found; start one with 'tunnel'")
+	}
+
+	url := fmt.Sprintf("ws://localhost:%d/api/ws", holder.Port)
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, url, instanceHeader())
+	if err != nil {
+		return fmt.Errorf("failed to connect to event stream: %w", err)
+	}
+	defer conn.Close()
+
+	// Unblock the read loop on Ctrl+C
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	if !jsonOutput {
+		color.Cyan("Watching events from pid %d on port %d (Ctrl+C to stop)", holder.PID, holder.Port)
+	}
+
+	for {
+		var event watchEvent
+		if err := conn.ReadJSON(&event); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("event stream closed: %w", err)
+		}
+
+		if filter != nil && !filter[strings.ToLower(event.Type)] {
+			continue
+		}
+
+		if err := printWatchEvent(event); err != nil {
+			return err
+		}
+	}
+}
+
+// printWatchEvent prints one event, as a JSON line in --json mode
+func printWatchEvent(event watchEvent) error {
+	if jsonOutput {
+		line, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("failed to encode event: %w", err)
+		}
+		_, err = fmt.Fprintln(os.Stdout, string(line))
+		return err
+	}
+
+	var payload struct {
+		ConnID  string `json:"conn_id"`
+		Message string `json:"message"`
+		Name    string `json:"name"`
+	}
+	_ = json.Unmarshal(event.Payload, &payload)
+
+	timestamp := event.Time
+	if t, err := time.Parse(time.RFC3339, event.Time); err == nil {
+		timestamp = units.TimeExact(t)
+	}
+
+	subject := payload.ConnID
+	if subject == "" {
+		subject = payload.Name
+	}
+
+	fmt.Printf("%s  %s  %-20s %s\n", timestamp, colorizeEventType(event.Type), subject, payload.Message)
+	return nil
+}
+
+// colorizeEventType pads and colors an event type for terminal output
+func colorizeEventType(eventType string) string {
+	padded := fmt.Sprintf("%-14s", eventType)
+	switch strings.ToLower(eventType) {
+	case "connected":
+		return color.GreenString(padded)
+	case "error", "disconnected":
+		return color.RedString(padded)
+	case "failover", "reconnecting", "primarychange", "networkchange":
+		return color.YellowString(padded)
+	default:
+		return padded
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/logging"
+	"github.com/jedarden/tunnel/internal/providers"
+	"github.com/jedarden/tunnel/internal/watchdog"
+)
+
+// providerProbeTimeout is how long a provider may take to say whether it
+// is connected before its supervisor counts as stuck
+const providerProbeTimeout = 10 * time.Second
+
+// daemonRestart is set when the watchdog asks for a controlled restart; the
+// daemon starts again once shutdown has finished
+var daemonRestart atomic.Bool
+
+// startWatchdog watches the daemon's subsystems until ctx is done: the
+// failover health loop, the event queue and the supervisors of the tunnels
+// that should be up. When restarting one doesn't help, the daemon shuts
+// down through stop and restarts itself with --restore.
+func startWatchdog(ctx context.Context, stop context.CancelFunc) {
+	cfg := appConfig.Watchdog
+	if cfg.Disabled || manager == nil {
+		return
+	}
+
+	w := watchdog.New(func(reason string) {
+		logging.For("watchdog").Warn("restarting the daemon", "reason", reason)
+		daemonRestart.Store(true)
+		stop()
+	})
+	w.Logger = log.Default()
+	if cfg.Interval > 0 {
+		w.Interval = time.Duration(cfg.Interval) * time.Second
+	}
+	if cfg.MaxRestarts > 0 {
+		w.MaxRestarts = cfg.MaxRestarts
+	}
+
+	w.Watch(watchdog.Component{
+		Name:  "health loop",
+		Check: manager.HealthLoopStalled,
+		Restart: func() error {
+			manager.RestartHealthLoop()
+			return nil
+		},
+	})
+
+	events := manager.GetEventPublisher()
+	var stalled []string
+	w.Watch(watchdog.Component{
+		Name: "event queue",
+		Check: func() error {
+			if stalled = events.Stalled(); len(stalled) > 0 {
+				return fmt.Errorf("%s stopped draining events", strings.Join(stalled, ", "))
+			}
+			return nil
+		},
+		Restart: func() error {
+			for _, id := range stalled {
+				events.Drain(id)
+			}
+			return nil
+		},
+	})
+
+	w.Watch(watchdog.Providers(expectedProviders, providerProbeTimeout))
+
+	go w.Run(ctx)
+}
+
+// expectedProviders returns the providers of the tunnels that should be
+// up, per the connection state
+func expectedProviders() []providers.Provider {
+	store, err := stateStore()
+	if err != nil {
+		return nil
+	}
+	saved, err := store.Load()
+	if err != nil {
+		return nil
+	}
+	var list []providers.Provider
+	for _, method := range saved.Methods() {
+		if provider, err := reg.GetProvider(method); err == nil {
+			list = append(list, provider)
+		}
+	}
+	return list
+}
+
+// restartDaemon runs the daemon again in place of this process, restoring
+// the tunnels the shutdown left in the connection state
+func restartDaemon() {
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to restart the daemon: %v\n", err)
+		os.Exit(1)
+	}
+	args := os.Args[1:]
+	if !hasArg(args, "--restore") {
+		args = append(args, "--restore")
+	}
+	fmt.Fprintf(os.Stderr, "Restarting the daemon: %s %s\n", exe, strings.Join(args, " "))
+	if err := reexec(exe, args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to restart the daemon: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func hasArg(args []string, arg string) bool {
+	for _, a := range args {
+		if a == arg {
+			return true
+		}
+	}
+	return false
+}
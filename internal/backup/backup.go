@@ -0,0 +1,482 @@
+// Package backup writes and restores encrypted archives of TUNNEL's state:
+// configuration, instance state, keys, shares, credentials and the audit log.
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// FormatVersion is written to every manifest and checked on restore.
+// Version 1 archives recorded absolute paths; they are still read, but
+// restored only into the roots given to Restore.
+const FormatVersion = 2
+
+// Components group the files in an archive for selective restore
+const (
+	ComponentConfig      = "config"
+	ComponentState       = "state"
+	ComponentKeys        = "keys"
+	ComponentShares      = "shares"
+	ComponentCredentials = "credentials"
+	ComponentAudit       = "audit"
+)
+
+// Components lists every component in restore order
+var Components = []string{ComponentConfig, ComponentState, ComponentKeys, ComponentShares, ComponentCredentials, ComponentAudit}
+
+// magic identifies an encrypted TUNNEL backup
+var magic = []byte("TUNNELBK")
+
+const (
+	saltSize         = 32
+	pbkdf2Iterations = 100000
+	manifestName     = "manifest.json"
+)
+
+// ErrBadPassphrase is returned when an archive cannot be decrypted
+var ErrBadPassphrase = errors.New("wrong passphrase or corrupted backup")
+
+// Source is a file or directory to include in a backup. Root names it, so
+// that a restore puts it wherever Roots says that is now rather than where
+// it was when backed up.
+type Source struct {
+	Component string
+	Root      string
+	Path      string
+}
+
+// Roots maps the root names of sources to the files or directories they
+// stand for on this host
+type Roots map[string]string
+
+// File describes one file in an archive
+type File struct {
+	Component string      `json:"component"`
+	Name      string      `json:"name"`           // path inside the archive
+	Root      string      `json:"root,omitempty"` // the source's root; empty in version 1 archives
+	Path      string      `json:"path"`           // slash-separated path within the root, or absolute in version 1
+	Mode      fs.FileMode `json:"mode"`
+	Size      int64       `json:"size"`
+	SHA256    string      `json:"sha256"`
+}
+
+// Location names f for messages: its root and its path within it
+func (f File) Location() string {
+	if f.Root == "" {
+		return f.Path
+	}
+	return path.Join(f.Root, f.Path)
+}
+
+// Manifest describes an archive's contents
+type Manifest struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	Hostname  string    `json:"hostname"`
+	Files     []File    `json:"files"`
+}
+
+// Components returns the components present in the manifest
+func (m *Manifest) Components() []string {
+	seen := make(map[string]bool)
+	for _, f := range m.Files {
+		seen[f.Component] = true
+	}
+	var components []string
+	for _, c := range Components {
+		if seen[c] {
+			components = append(components, c)
+		}
+	}
+	return components
+}
+
+// Create archives sources, encrypts the archive with passphrase and writes it
+// to w. Missing sources are skipped so that optional state such as a key
+// database need not exist.
+func Create(w io.Writer, sources []Source, passphrase string) (*Manifest, error) {
+	if passphrase == "" {
+		return nil, errors.New("passphrase is required")
+	}
+
+	hostname, _ := os.Hostname()
+	manifest := &Manifest{Version: FormatVersion, CreatedAt: time.Now().UTC(), Hostname: hostname}
+	contents := make(map[string][]byte)
+
+	for _, src := range sources {
+		err := filepath.WalkDir(src.Path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if d.IsDir() || !d.Type().IsRegular() {
+				return nil
+			}
+
+			data, err := os.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+
+			rel, err := filepath.Rel(src.Path, p)
+			if err != nil {
+				return err
+			}
+			name := path.Join(src.Component, fmt.Sprintf("%03d", len(manifest.Files)), filepath.Base(p))
+			sum := sha256.Sum256(data)
+			manifest.Files = append(manifest.Files, File{
+				Component: src.Component,
+				Name:      name,
+				Root:      src.Root,
+				Path:      filepath.ToSlash(rel),
+				Mode:      info.Mode().Perm(),
+				Size:      int64(len(data)),
+				SHA256:    hex.EncodeToString(sum[:]),
+			})
+			contents[name] = data
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", src.Path, err)
+		}
+	}
+
+	var archive bytes.Buffer
+	if err := writeTarball(&archive, manifest, contents); err != nil {
+		return nil, err
+	}
+
+	sealed, err := encrypt(archive.Bytes(), passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(sealed); err != nil {
+		return nil, fmt.Errorf("write backup: %w", err)
+	}
+	return manifest, nil
+}
+
+// writeTarball writes the manifest followed by every file as a gzipped tar
+func writeTarball(w io.Writer, manifest *Manifest, contents map[string][]byte) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode manifest: %w", err)
+	}
+
+	add := func(name string, data []byte, mode fs.FileMode) error {
+		hdr := &tar.Header{Name: name, Mode: int64(mode), Size: int64(len(data)), ModTime: manifest.CreatedAt}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("write archive: %w", err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			return fmt.Errorf("write archive: %w", err)
+		}
+		return nil
+	}
+
+	if err := add(manifestName, manifestData, 0600); err != nil {
+		return err
+	}
+	for _, f := range manifest.Files {
+		if err := add(f.Name, contents[f.Name], f.Mode); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("write archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("write archive: %w", err)
+	}
+	return nil
+}
+
+// Archive is a decrypted backup held in memory
+type Archive struct {
+	Manifest *Manifest
+	contents map[string][]byte
+}
+
+// Open decrypts and reads a backup
+func Open(r io.Reader, passphrase string) (*Archive, error) {
+	sealed, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("read backup: %w", err)
+	}
+
+	plain, err := decrypt(sealed, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(plain))
+	if err != nil {
+		return nil, fmt.Errorf("read archive: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	archive := &Archive{contents: make(map[string][]byte)}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read archive: %w", err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("read archive: %w", err)
+		}
+		archive.contents[hdr.Name] = data
+	}
+
+	manifestData, ok := archive.contents[manifestName]
+	if !ok {
+		return nil, errors.New("backup has no manifest")
+	}
+	delete(archive.contents, manifestName)
+
+	var manifest Manifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return nil, fmt.Errorf("decode manifest: %w", err)
+	}
+	if manifest.Version < 1 || manifest.Version > FormatVersion {
+		return nil, fmt.Errorf("unsupported backup version %d", manifest.Version)
+	}
+	archive.Manifest = &manifest
+
+	return archive, nil
+}
+
+// Data returns the contents of the archived file f
+func (a *Archive) Data(f File) []byte {
+	return a.contents[f.Name]
+}
+
+// Select returns the files belonging to components, or every file when
+// components is empty
+func (a *Archive) Select(components []string) ([]File, error) {
+	if len(components) == 0 {
+		return a.Manifest.Files, nil
+	}
+
+	wanted := make(map[string]bool)
+	present := a.Manifest.Components()
+	for _, c := range components {
+		if !contains(present, c) {
+			return nil, fmt.Errorf("backup has no %s component (has: %s)", c, strings.Join(present, ", "))
+		}
+		wanted[c] = true
+	}
+
+	var files []File
+	for _, f := range a.Manifest.Files {
+		if wanted[f.Component] {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+// Validator checks the contents of one archived file before it is restored
+type Validator func(f File, data []byte) error
+
+// Validate checks every file in files against its manifest checksum and the
+// validator registered for its component, returning all problems found
+func (a *Archive) Validate(files []File, validators map[string]Validator) error {
+	var problems []string
+	for _, f := range files {
+		data, ok := a.contents[f.Name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s: missing from archive", f.Location()))
+			continue
+		}
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != f.SHA256 {
+			problems = append(problems, fmt.Sprintf("%s: checksum mismatch", f.Location()))
+			continue
+		}
+		if validate := validators[f.Component]; validate != nil {
+			if err := validate(f, data); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", f.Location(), err))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("backup failed validation:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// Destination returns where f is restored to: its path within the
+// directory roots gives for its root. A version 1 file, recorded with an
+// absolute path, must lie within one of roots. Nothing outside roots is
+// ever returned.
+func (a *Archive) Destination(f File, roots Roots) (string, error) {
+	if f.Root == "" {
+		for _, root := range roots {
+			if rel, err := filepath.Rel(root, f.Path); err == nil && filepath.IsLocal(rel) {
+				return filepath.Join(root, rel), nil
+			}
+		}
+		return "", fmt.Errorf("%s is outside the locations tunnel restores to", f.Path)
+	}
+
+	root, ok := roots[f.Root]
+	if !ok || root == "" {
+		return "", fmt.Errorf("%s: nowhere to restore %s to on this host", f.Location(), f.Root)
+	}
+	rel := filepath.FromSlash(f.Path)
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("%s escapes %s", f.Location(), f.Root)
+	}
+	return filepath.Join(root, rel), nil
+}
+
+// Restore writes files back under roots. Every destination is checked
+// before anything is written. Existing files are kept alongside with a
+// .pre-restore suffix, and each file is replaced atomically.
+func (a *Archive) Restore(files []File, roots Roots) error {
+	dests := make([]string, len(files))
+	for i, f := range files {
+		dest, err := a.Destination(f, roots)
+		if err != nil {
+			return err
+		}
+		dests[i] = dest
+	}
+	for i, f := range files {
+		if err := restoreFile(dests[i], a.contents[f.Name], f.Mode); err != nil {
+			return fmt.Errorf("restore %s: %w", dests[i], err)
+		}
+	}
+	return nil
+}
+
+// restoreFile atomically replaces path with data, keeping the old copy
+func restoreFile(p string, data []byte, mode fs.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+
+	if existing, err := os.ReadFile(p); err == nil {
+		if err := os.WriteFile(p+".pre-restore", existing, 0600); err != nil {
+			return err
+		}
+	}
+
+	tmp := p + ".tunnel-tmp"
+	if err := os.WriteFile(tmp, data, mode); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// encrypt seals data with a key derived from passphrase
+func encrypt(data []byte, passphrase string) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("generate salt: %w", err)
+	}
+
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+
+	out := append([]byte{}, magic...)
+	out = append(out, salt...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, data, magic), nil
+}
+
+// decrypt opens data sealed by encrypt
+func decrypt(data []byte, passphrase string) ([]byte, error) {
+	if !bytes.HasPrefix(data, magic) {
+		return nil, errors.New("not a TUNNEL backup")
+	}
+	data = data[len(magic):]
+	if len(data) < saltSize {
+		return nil, ErrBadPassphrase
+	}
+
+	gcm, err := newGCM(passphrase, data[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+	data = data[saltSize:]
+	if len(data) < gcm.NonceSize() {
+		return nil, ErrBadPassphrase
+	}
+
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], magic)
+	if err != nil {
+		return nil, ErrBadPassphrase
+	}
+	return plain, nil
+}
+
+// newGCM derives an AES-256-GCM cipher from passphrase and salt
+func newGCM(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key := pbkdf2.Key([]byte(passphrase), salt, pbkdf2Iterations, 32, sha256.New)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("create gcm: %w", err)
+	}
+	return gcm, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+package backup
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+	requestsDir := filepath.Join(dir, "requests")
+	writeFile(t, configPath, "version: \"1.0\"\n")
+	writeFile(t, filepath.Join(requestsDir, "alice.json"), `{"id":"alice"}`)
+	writeFile(t, filepath.Join(requestsDir, "alice.pub"), "ssh-ed25519 AAAA alice\n")
+
+	sources := []Source{
+		{Component: ComponentConfig, Root: "config", Path: configPath},
+		{Component: ComponentShares, Root: "requests", Path: requestsDir},
+		{Component: ComponentAudit, Root: "audit", Path: filepath.Join(dir, "missing.log")},
+	}
+	roots := Roots{"config": configPath, "requests": requestsDir, "audit": filepath.Join(dir, "missing.log")}
+
+	var buf bytes.Buffer
+	manifest, err := Create(&buf, sources, "secret")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if len(manifest.Files) != 3 {
+		t.Fatalf("Create() archived %d files, want 3", len(manifest.Files))
+	}
+	if got := manifest.Components(); strings.Join(got, ",") != "config,shares" {
+		t.Errorf("Components() = %v", got)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("alice")) {
+		t.Error("backup contents are not encrypted")
+	}
+
+	if _, err := Open(bytes.NewReader(buf.Bytes()), "wrong"); !errors.Is(err, ErrBadPassphrase) {
+		t.Fatalf("Open() with wrong passphrase error = %v", err)
+	}
+
+	archive, err := Open(bytes.NewReader(buf.Bytes()), "secret")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	files, err := archive.Select([]string{ComponentConfig})
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Select(config) = %v, %v", files, err)
+	}
+	if _, err := archive.Select([]string{ComponentKeys}); err == nil {
+		t.Error("Select() of an absent component should fail")
+	}
+
+	// Only the selected component is restored; the old file is kept
+	writeFile(t, configPath, "changed\n")
+	writeFile(t, filepath.Join(requestsDir, "alice.json"), "changed")
+	if err := archive.Restore(files, roots); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if data, _ := os.ReadFile(configPath); string(data) != "version: \"1.0\"\n" {
+		t.Errorf("config not restored, got %q", data)
+	}
+	if data, _ := os.ReadFile(configPath + ".pre-restore"); string(data) != "changed\n" {
+		t.Errorf("pre-restore copy = %q", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(requestsDir, "alice.json")); string(data) != "changed" {
+		t.Error("unselected component was restored")
+	}
+
+	// Restored where the roots are now, not where they were
+	moved := filepath.Join(t.TempDir(), "requests")
+	files, _ = archive.Select([]string{ComponentShares})
+	if err := archive.Restore(files, Roots{"requests": moved}); err != nil {
+		t.Fatalf("Restore() to a moved root error = %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(moved, "alice.pub")); string(data) != "ssh-ed25519 AAAA alice\n" {
+		t.Errorf("moved root not restored, got %q", data)
+	}
+}
+
+func TestRestoreStaysInRoots(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+	writeFile(t, configPath, "version: \"1.0\"\n")
+
+	var buf bytes.Buffer
+	if _, err := Create(&buf, []Source{{Component: ComponentConfig, Root: "config", Path: configPath}}, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	archive, err := Open(&buf, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := Roots{"config": configPath}
+	outside := filepath.Join(dir, "outside")
+
+	tests := []struct {
+		name string
+		file File
+	}{
+		{"escaping path", File{Root: "config", Path: "../outside"}},
+		{"absolute path", File{Root: "config", Path: outside}},
+		{"unknown root", File{Root: "elsewhere", Path: "."}},
+		{"version 1 path outside the roots", File{Path: outside}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := archive.Manifest.Files[0]
+			f.Root, f.Path = tt.file.Root, tt.file.Path
+			if err := archive.Restore([]File{archive.Manifest.Files[0], f}, roots); err == nil {
+				t.Fatal("Restore() wrote outside its roots")
+			}
+			if _, err := os.Stat(outside); !os.IsNotExist(err) {
+				t.Errorf("%s was written", outside)
+			}
+			if _, err := os.Stat(configPath + ".pre-restore"); !os.IsNotExist(err) {
+				t.Error("a file was restored before the bad destination was refused")
+			}
+		})
+	}
+
+	// A version 1 file within a root still restores there
+	f := archive.Manifest.Files[0]
+	f.Root, f.Path = "", configPath
+	if dest, err := archive.Destination(f, roots); err != nil || dest != configPath {
+		t.Errorf("Destination() of a version 1 file = %q, %v", dest, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+	writeFile(t, configPath, "bad")
+
+	var buf bytes.Buffer
+	if _, err := Create(&buf, []Source{{Component: ComponentConfig, Path: configPath}}, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	archive, err := Open(&buf, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failing := map[string]Validator{
+		ComponentConfig: func(f File, data []byte) error { return errors.New("not a config") },
+	}
+	if err := archive.Validate(archive.Manifest.Files, failing); err == nil || !strings.Contains(err.Error(), "not a config") {
+		t.Errorf("Validate() error = %v, want validator failure", err)
+	}
+
+	archive.Manifest.Files[0].SHA256 = strings.Repeat("0", 64)
+	if err := archive.Validate(archive.Manifest.Files, nil); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("Validate() error = %v, want checksum mismatch", err)
+	}
+}
+
+func TestOpenRejectsForeignData(t *testing.T) {
+	if _, err := Open(strings.NewReader("not a backup"), "secret"); err == nil {
+		t.Error("Open() accepted data without the backup header")
+	}
+	if _, err := Create(&bytes.Buffer{}, nil, ""); err == nil {
+		t.Error("Create() accepted an empty passphrase")
+	}
+}
@@ -0,0 +1,105 @@
+// Package changelog holds the release notes built into the binary, so the
+// TUI can tell a user what changed after an upgrade
+package changelog
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+)
+
+//go:embed CHANGELOG.md
+var embedded string
+
+// Section titles called out ahead of the rest of a release
+const (
+	Breaking   = "Breaking"
+	Migrations = "Migrations"
+)
+
+// Release is one release's notes
+type Release struct {
+	Version  string    `json:"version"`
+	Date     string    `json:"date,omitempty"`
+	Sections []Section `json:"sections"`
+}
+
+// Section is a titled list of changes within a release
+type Section struct {
+	Title string   `json:"title"`
+	Items []string `json:"items"`
+}
+
+// Notable reports whether r has breaking changes or migrations
+func (r Release) Notable() bool {
+	for _, s := range r.Sections {
+		if s.Title == Breaking || s.Title == Migrations {
+			return true
+		}
+	}
+	return false
+}
+
+// Releases returns the built-in release notes, newest first
+func Releases() []Release {
+	releases, err := Parse(embedded)
+	if err != nil {
+		panic(fmt.Sprintf("embedded changelog: %v", err))
+	}
+	return releases
+}
+
+// Parse reads release notes in the Keep a Changelog layout: a "## [version]
+// - date" heading per release, "### Title" per section and "- " per item,
+// an item's continuation lines indented. Text before the first release is
+// ignored.
+func Parse(text string) ([]Release, error) {
+	var releases []Release
+	var release *Release
+	var section *Section
+	for i, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "## "):
+			releases = append(releases, parseHeading(strings.TrimPrefix(line, "## ")))
+			release, section = &releases[len(releases)-1], nil
+		case release == nil || trimmed == "":
+		case strings.HasPrefix(line, "### "):
+			release.Sections = append(release.Sections, Section{Title: strings.TrimSpace(line[4:])})
+			section = &release.Sections[len(release.Sections)-1]
+		case section == nil:
+			return nil, fmt.Errorf("line %d: text outside a section of %s", i+1, release.Version)
+		case strings.HasPrefix(line, "- "):
+			section.Items = append(section.Items, strings.TrimSpace(line[2:]))
+		case line != trimmed && len(section.Items) > 0:
+			section.Items[len(section.Items)-1] += " " + trimmed
+		default:
+			return nil, fmt.Errorf("line %d: expected an item in %s of %s", i+1, section.Title, release.Version)
+		}
+	}
+	return releases, nil
+}
+
+// parseHeading splits "[1.2.0] - 2026-10-18" into version and date; the
+// brackets and date are optional
+func parseHeading(heading string) Release {
+	version, date, _ := strings.Cut(strings.TrimSpace(heading), " - ")
+	return Release{
+		Version: strings.Trim(strings.TrimSpace(version), "[]"),
+		Date:    strings.TrimSpace(date),
+	}
+}
+
+// Since returns the releases newer than seen, newest first. When seen isn't
+// among them, as on a first run, that is only the newest release.
+func Since(releases []Release, seen string) []Release {
+	for i, r := range releases {
+		if r.Version == seen {
+			return releases[:i]
+		}
+	}
+	if len(releases) == 0 {
+		return nil
+	}
+	return releases[:1]
+}
@@ -0,0 +1,68 @@
+package changelog
+
+import "testing"
+
+const notes = `# Changelog
+
+Intro text.
+
+## [1.1.0] - 2026-11-02
+
+### Breaking
+- The audit log moved.
+  Run the migration first.
+
+### Fixed
+- A crash.
+
+## 1.0.0
+### Added
+- Everything.
+`
+
+func TestParse(t *testing.T) {
+	releases, err := Parse(notes)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(releases) != 2 {
+		t.Fatalf("Parse() returned %d releases, want 2", len(releases))
+	}
+
+	r := releases[0]
+	if r.Version != "1.1.0" || r.Date != "2026-11-02" || len(r.Sections) != 2 {
+		t.Errorf("release = %+v", r)
+	}
+	if got := r.Sections[0].Items; len(got) != 1 || got[0] != "The audit log moved. Run the migration first." {
+		t.Errorf("continued item = %q", got)
+	}
+	if !r.Notable() || releases[1].Notable() {
+		t.Error("only 1.1.0 has breaking changes")
+	}
+	if releases[1].Version != "1.0.0" || releases[1].Date != "" {
+		t.Errorf("release without brackets or date = %+v", releases[1])
+	}
+
+	if _, err := Parse("## 1.0.0\nstray text\n"); err == nil {
+		t.Error("Parse() accepted text outside a section")
+	}
+}
+
+func TestSince(t *testing.T) {
+	releases, _ := Parse(notes)
+	if got := Since(releases, "1.0.0"); len(got) != 1 || got[0].Version != "1.1.0" {
+		t.Errorf("Since(1.0.0) = %+v, want 1.1.0", got)
+	}
+	if got := Since(releases, "1.1.0"); len(got) != 0 {
+		t.Errorf("Since(newest) = %+v, want none", got)
+	}
+	if got := Since(releases, ""); len(got) != 1 || got[0].Version != "1.1.0" {
+		t.Errorf("Since(\"\") = %+v, want the newest only", got)
+	}
+}
+
+func TestEmbeddedChangelog(t *testing.T) {
+	if len(Releases()) == 0 {
+		t.Error("embedded changelog has no releases")
+	}
+}
@@ -0,0 +1,173 @@
+// Package cmdtemplate renders the command lines users can configure in
+// place of the ones providers build, such as
+//
+//	{{.Binary}} {{quote .Args}} --log-level debug
+//
+// Templates use text/template. The result is split into words the way a
+// shell would, but never run through one, so nothing is expanded.
+package cmdtemplate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// Data is what a command template can refer to
+type Data struct {
+	Binary     string   // binary the provider runs
+	Args       []string // arguments the provider would pass it
+	Port       int      // local port being exposed
+	RemoteHost string
+	RemotePort int
+	Token      string // auth token or key
+	TokenPath  string // file holding Token, written only if the template uses it
+	ConfigFile string
+	TunnelName string
+	NetworkID  string
+}
+
+// Template is a parsed command line template
+type Template struct {
+	text string
+	tmpl *template.Template
+}
+
+// Parse parses a command line template
+func Parse(text string) (*Template, error) {
+	tmpl, err := template.New("command").
+		Option("missingkey=error").
+		Funcs(template.FuncMap{"quote": quote}).
+		Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{text: text, tmpl: tmpl}, nil
+}
+
+// Validate parses text and renders it with sample data, so mistakes such
+// as misspelled placeholders are found when config is loaded rather than
+// when a tunnel is started
+func Validate(text string) error {
+	t, err := Parse(text)
+	if err != nil {
+		return err
+	}
+	_, err = t.Render(Data{
+		Binary:     "tunnel-provider",
+		Args:       []string{"--sample"},
+		Port:       22,
+		RemoteHost: "example.com",
+		RemotePort: 2222,
+		Token:      "token",
+		TokenPath:  "/run/tunnel/token",
+		ConfigFile: "/etc/tunnel/provider.conf",
+		TunnelName: "tunnel",
+		NetworkID:  "network",
+	})
+	return err
+}
+
+// Render renders the template and splits the result into a binary and
+// its arguments
+func (t *Template) Render(data Data) ([]string, error) {
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	argv, err := Split(buf.String())
+	if err != nil {
+		return nil, err
+	}
+	if len(argv) == 0 {
+		return nil, errors.New("command is empty")
+	}
+	return argv, nil
+}
+
+// UsesTokenPath reports whether the template refers to TokenPath, so the
+// token only has to be written out when it does
+func (t *Template) UsesTokenPath() bool {
+	return strings.Contains(t.text, ".TokenPath")
+}
+
+// Split splits a command line into words the way a POSIX shell would,
+// honoring single and double quotes and backslash escapes, but without
+// any expansion
+func Split(line string) ([]string, error) {
+	var (
+		words   []string
+		word    strings.Builder
+		inWord  bool
+		quoted  rune
+		escaped bool
+	)
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case quoted == '\'':
+			if r == '\'' {
+				quoted = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case quoted == '"':
+			switch r {
+			case '"':
+				quoted = 0
+			case '\\':
+				escaped = true
+			default:
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			escaped, inWord = true, true
+		case r == '\'' || r == '"':
+			quoted, inWord = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quoted != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quoted)
+	}
+	if escaped {
+		return nil, errors.New("trailing backslash")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// quote single-quotes a value, or each element of a list, so it renders
+// as one word per value whatever it contains
+func quote(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return quoteWord(v)
+	case []string:
+		words := make([]string, len(v))
+		for i, s := range v {
+			words[i] = quoteWord(s)
+		}
+		return strings.Join(words, " ")
+	default:
+		return quoteWord(fmt.Sprint(v))
+	}
+}
+
+func quoteWord(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
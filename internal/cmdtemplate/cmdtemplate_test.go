@@ -0,0 +1,69 @@
+package cmdtemplate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		want []string
+	}{
+		{"bore local 22", []string{"bore", "local", "22"}},
+		{`ngrok  tcp "22"  --label 'a b'`, []string{"ngrok", "tcp", "22", "--label", "a b"}},
+		{`echo "say \"hi\"" it\'s ''`, []string{"echo", `say "hi"`, "it's", ""}},
+	} {
+		got, err := Split(tc.line)
+		if err != nil {
+			t.Fatalf("Split(%q) error = %v", tc.line, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Split(%q) = %q, want %q", tc.line, got, tc.want)
+		}
+	}
+
+	for _, line := range []string{`a "b`, `a 'b`, `a\`} {
+		if _, err := Split(line); err == nil {
+			t.Errorf("Split(%q) succeeded, want error", line)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	tmpl, err := Parse("{{.Binary}} {{quote .Args}} --port {{.Port}} --secret-file {{.TokenPath}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := tmpl.Render(Data{
+		Binary:    "bore",
+		Args:      []string{"local", "22", "--to", "it's.example.com"},
+		Port:      22,
+		TokenPath: "/tmp/bore token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"bore", "local", "22", "--to", "it's.example.com", "--port", "22", "--secret-file", "/tmp/bore", "token"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+	if !tmpl.UsesTokenPath() {
+		t.Error("UsesTokenPath() = false")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for text, ok := range map[string]bool{
+		"{{.Binary}} {{quote .Args}} --region eu": true,
+		"ngrok tcp {{.Port}}":                     true,
+		"bore local {{.LocalPort}}":               false, // no such placeholder
+		"bore local {{.Port":                      false,
+		"{{if false}}bore{{end}}":                 false, // renders nothing
+		`bore "{{.Port}}`:                         false,
+	} {
+		if err := Validate(text); (err == nil) != ok {
+			t.Errorf("Validate(%q) error = %v, want ok %v", text, err, ok)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package core
+
+import (
+	"time"
+)
+
+// AggregationWindow is the span covered by a metrics rollup
+type AggregationWindow time.Duration
+
+// Supported rollup windows
+const (
+	Window1m AggregationWindow = AggregationWindow(time.Minute)
+	Window5m AggregationWindow = AggregationWindow(5 * time.Minute)
+	Window1h AggregationWindow = AggregationWindow(time.Hour)
+)
+
+// AggregationWindows lists the windows maintained for every connection
+var AggregationWindows = []AggregationWindow{Window1m, Window5m, Window1h}
+
+// String returns the window in Go duration notation, e.g. "5m0s"
+func (w AggregationWindow) String() string {
+	return time.Duration(w).String()
+}
+
+// bucketsPerWindow is the rollup resolution; memory per window is constant
+const bucketsPerWindow = 12
+
+// MetricSample is a single metrics observation for a connection
+type MetricSample struct {
+	Timestamp     time.Time     `json:"timestamp"`
+	Latency       time.Duration `json:"latency"` // zero when the measurement failed
+	BytesSent     int64         `json:"bytes_sent"`
+	BytesReceived int64         `json:"bytes_received"`
+}
+
+// ThroughputPoint is a connection's transfer rates between two samples
+type ThroughputPoint struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Sent     float64   `json:"sent"`     // bytes a second
+	Received float64   `json:"received"` // bytes a second
+}
+
+// throughput turns the byte counters of consecutive samples into rates. A
+// counter that went backwards, as after a reconnect, counts from zero.
+func throughput(samples []MetricSample) []ThroughputPoint {
+	var points []ThroughputPoint
+	for i := 1; i < len(samples); i++ {
+		prev, cur := samples[i-1], samples[i]
+		secs := cur.Timestamp.Sub(prev.Timestamp).Seconds()
+		if secs <= 0 {
+			continue
+		}
+		sent, received := cur.BytesSent-prev.BytesSent, cur.BytesReceived-prev.BytesReceived
+		if sent < 0 {
+			sent = cur.BytesSent
+		}
+		if received < 0 {
+			received = cur.BytesReceived
+		}
+		points = append(points, ThroughputPoint{
+			Start:    prev.Timestamp,
+			End:      cur.Timestamp,
+			Sent:     float64(sent) / secs,
+			Received: float64(received) / secs,
+		})
+	}
+	return points
+}
+
+// AggregatedMetrics summarizes the samples that fall inside a window
+type AggregatedMetrics struct {
+	Window        AggregationWindow `json:"window"`
+	Samples       int               `json:"samples"`
+	Failures      int               `json:"failures"`
+	MinLatency    time.Duration     `json:"min_latency"`
+	MaxLatency    time.Duration     `json:"max_latency"`
+	AvgLatency    time.Duration     `json:"avg_latency"`
+	BytesSent     int64             `json:"bytes_sent"`     // transferred during the window
+	BytesReceived int64             `json:"bytes_received"` // transferred during the window
+}
+
+// sampleRing is a fixed-capacity ring buffer of samples
+type sampleRing struct {
+	buf   []MetricSample
+	next  int
+	count int
+}
+
+func newSampleRing(size int) *sampleRing {
+	if size < 1 {
+		size = 1
+	}
+	return &sampleRing{buf: make([]MetricSample, size)}
+}
+
+// add stores a sample, overwriting the oldest once full
+func (r *sampleRing) add(s MetricSample) {
+	r.buf[r.next] = s
+	r.next = (r.next + 1) % len(r.buf)
+	if r.count < len(r.buf) {
+		r.count++
+	}
+}
+
+// last returns up to n of the most recent samples, oldest first
+func (r *sampleRing) last(n int) []MetricSample {
+	if n > r.count {
+		n = r.count
+	}
+	out := make([]MetricSample, n)
+	start := r.next - n
+	if start < 0 {
+		start += len(r.buf)
+	}
+	for i := 0; i < n; i++ {
+		out[i] = r.buf[(start+i)%len(r.buf)]
+	}
+	return out
+}
+
+// rollupBucket accumulates samples for one slice of a window
+type rollupBucket struct {
+	epoch      int64 // bucket number since the Unix epoch; identifies staleness
+	samples    int
+	failures   int
+	latencySum time.Duration
+	minLatency time.Duration
+	maxLatency time.Duration
+	sent       int64
+	received   int64
+}
+
+// rollup incrementally aggregates samples over a sliding window using a
+// fixed ring of time buckets
+type rollup struct {
+	window  AggregationWindow
+	width   time.Duration
+	buckets [bucketsPerWindow]rollupBucket
+}
+
+func newRollup(window AggregationWindow) *rollup {
+	return &rollup{
+		window: window,
+		width:  time.Duration(window) / bucketsPerWindow,
+	}
+}
+
+// add folds a sample into its bucket. sentDelta and receivedDelta are the
+// bytes transferred since the previous sample.
+func (r *rollup) add(s MetricSample, sentDelta, receivedDelta int64) {
+	epoch := s.Timestamp.UnixNano() / int64(r.width)
+	b := &r.buckets[epoch%bucketsPerWindow]
+	if b.epoch != epoch {
+		*b = rollupBucket{epoch: epoch}
+	}
+
+	b.sent += sentDelta
+	b.received += receivedDelta
+
+	if s.Latency <= 0 {
+		b.failures++
+		return
+	}
+
+	b.samples++
+	b.latencySum += s.Latency
+	if b.minLatency == 0 || s.Latency < b.minLatency {
+		b.minLatency = s.Latency
+	}
+	if s.Latency > b.maxLatency {
+		b.maxLatency = s.Latency
+	}
+}
+
+// aggregate merges the buckets that are still inside the window at now
+func (r *rollup) aggregate(now time.Time) AggregatedMetrics {
+	result := AggregatedMetrics{Window: r.window}
+	current := now.UnixNano() / int64(r.width)
+
+	var latencySum time.Duration
+	for _, b := range r.buckets {
+		if b.epoch <= current-bucketsPerWindow || b.epoch > current {
+			continue
+		}
+
+		result.Samples += b.samples
+		result.Failures += b.failures
+		result.BytesSent += b.sent
+		result.BytesReceived += b.received
+		latencySum += b.latencySum
+
+		if b.samples == 0 {
+			continue
+		}
+		if result.MinLatency == 0 || b.minLatency < result.MinLatency {
+			result.MinLatency = b.minLatency
+		}
+		if b.maxLatency > result.MaxLatency {
+			result.MaxLatency = b.maxLatency
+		}
+	}
+
+	if result.Samples > 0 {
+		result.AvgLatency = latencySum / time.Duration(result.Samples)
+	}
+	return result
+}
+
+// connectionSeries holds the bounded metrics history for one connection
+type connectionSeries struct {
+	ring    *sampleRing
+	rollups map[AggregationWindow]*rollup
+	last    *MetricSample
+}
+
+func newConnectionSeries(size int) *connectionSeries {
+	s := &connectionSeries{
+		ring:    newSampleRing(size),
+		rollups: make(map[AggregationWindow]*rollup, len(AggregationWindows)),
+	}
+	for _, w := range AggregationWindows {
+		s.rollups[w] = newRollup(w)
+	}
+	return s
+}
+
+// add records a sample in the ring and every rollup
+func (s *connectionSeries) add(sample MetricSample) {
+	var sentDelta, receivedDelta int64
+	if s.last != nil {
+		// Counters reset when a connection restarts; treat that as a fresh baseline
+		if sample.BytesSent >= s.last.BytesSent {
+			sentDelta = sample.BytesSent - s.last.BytesSent
+		}
+		if sample.BytesReceived >= s.last.BytesReceived {
+			receivedDelta = sample.BytesReceived - s.last.BytesReceived
+		}
+	}
+
+	s.ring.add(sample)
+	for _, r := range s.rollups {
+		r.add(sample, sentDelta, receivedDelta)
+	}
+	s.last = &sample
+}
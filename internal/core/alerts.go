@@ -0,0 +1,530 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jedarden/tunnel/pkg/config"
+)
+
+// AlertCondition is the kind of check an alert rule performs
+type AlertCondition string
+
+const (
+	AlertUnhealthy AlertCondition = "unhealthy" // connection failing its health checks
+	AlertLatency   AlertCondition = "latency"   // latency percentile above a threshold
+	AlertKeyAge    AlertCondition = "key_age"   // authorized key older than a threshold
+)
+
+// maxRecentAlerts bounds the resolved alert history kept by an engine
+const maxRecentAlerts = 50
+
+// AlertRule is a parsed alert rule
+type AlertRule struct {
+	Name       string
+	Condition  AlertCondition
+	Target     string        // Connection ID or key fingerprint; empty matches all
+	Threshold  time.Duration // Latency or key age
+	Percentile float64       // Latency percentile, 0-100
+	For        time.Duration // How long the condition must hold before firing
+}
+
+// NewAlertRule parses a rule from its config form
+func NewAlertRule(cfg config.AlertRuleConfig) (AlertRule, error) {
+	rule := AlertRule{
+		Name:       cfg.Name,
+		Condition:  AlertCondition(cfg.Condition),
+		Target:     cfg.Target,
+		Percentile: cfg.Percentile,
+	}
+
+	if cfg.For != "" {
+		d, err := ParseAlertDuration(cfg.For)
+		if err != nil {
+			return rule, fmt.Errorf("alert rule %s: parse for: %w", cfg.Name, err)
+		}
+		rule.For = d
+	}
+
+	switch rule.Condition {
+	case AlertUnhealthy:
+	case AlertLatency, AlertKeyAge:
+		if cfg.Threshold == "" {
+			return rule, fmt.Errorf("alert rule %s: threshold is required for %s", cfg.Name, cfg.Condition)
+		}
+		d, err := ParseAlertDuration(cfg.Threshold)
+		if err != nil {
+			return rule, fmt.Errorf("alert rule %s: parse threshold: %w", cfg.Name, err)
+		}
+		rule.Threshold = d
+	default:
+		return rule, fmt.Errorf("alert rule %s: unknown condition %q", cfg.Name, cfg.Condition)
+	}
+
+	if rule.Condition == AlertLatency {
+		if rule.Percentile == 0 {
+			rule.Percentile = 95
+		}
+		if rule.Percentile < 0 || rule.Percentile > 100 {
+			return rule, fmt.Errorf("alert rule %s: percentile must be between 0 and 100", cfg.Name)
+		}
+		if rule.For == 0 {
+			rule.For = 5 * time.Minute
+		}
+	}
+
+	return rule, nil
+}
+
+// NewAlertRules parses every rule in cfg
+func NewAlertRules(cfg []config.AlertRuleConfig) ([]AlertRule, error) {
+	rules := make([]AlertRule, 0, len(cfg))
+	for _, c := range cfg {
+		rule, err := NewAlertRule(c)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// ParseAlertDuration parses a Go duration, additionally accepting whole days
+// ("90d"), weeks ("2w") and years ("1y")
+func ParseAlertDuration(value string) (time.Duration, error) {
+	units := map[string]time.Duration{
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+		"y": 365 * 24 * time.Hour,
+	}
+	for suffix, unit := range units {
+		if n, ok := strings.CutSuffix(value, suffix); ok {
+			count, err := strconv.Atoi(n)
+			if err != nil || count < 0 {
+				return 0, fmt.Errorf("invalid duration %q", value)
+			}
+			return time.Duration(count) * unit, nil
+		}
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return d, nil
+}
+
+// Describe returns a one-line, human readable summary of the rule
+func (r AlertRule) Describe() string {
+	target := r.Target
+	if target == "" {
+		target = "any"
+	}
+
+	switch r.Condition {
+	case AlertUnhealthy:
+		return fmt.Sprintf("connection %s unhealthy for > %s", target, r.For)
+	case AlertLatency:
+		return fmt.Sprintf("connection %s latency p%g > %s for %s", target, r.Percentile, r.Threshold, r.For)
+	case AlertKeyAge:
+		return fmt.Sprintf("key %s older than %s", target, r.Threshold)
+	default:
+		return string(r.Condition)
+	}
+}
+
+// Alert is a rule that has fired for a particular subject
+type Alert struct {
+	Rule       string    `json:"rule"`
+	Subject    string    `json:"subject"` // Connection ID or key fingerprint
+	Message    string    `json:"message"`
+	Since      time.Time `json:"since"` // When the condition was first seen
+	FiredAt    time.Time `json:"fired_at"`
+	ResolvedAt time.Time `json:"resolved_at,omitempty"`
+
+	AcknowledgedAt time.Time `json:"acknowledged_at,omitempty"`
+	SilencedUntil  time.Time `json:"silenced_until,omitempty"` // Notifications suppressed until then
+}
+
+// Key identifies an alert by rule and subject
+func (a Alert) Key() string {
+	return a.Rule + "/" + a.Subject
+}
+
+// Active reports whether the alert has not yet resolved
+func (a Alert) Active() bool {
+	return a.ResolvedAt.IsZero()
+}
+
+// Acknowledged reports whether someone has acknowledged the alert
+func (a Alert) Acknowledged() bool {
+	return !a.AcknowledgedAt.IsZero()
+}
+
+// Silenced reports whether notifications for the alert are suppressed at t
+func (a Alert) Silenced(t time.Time) bool {
+	return t.Before(a.SilencedUntil)
+}
+
+// AlertSource supplies the connection state alert rules are evaluated against
+type AlertSource interface {
+	ConnectionStatuses() []ConnectionStatus
+	LatencySince(connID string, since time.Time) []time.Duration
+}
+
+// AlertEngine evaluates alert rules and sends a notification whenever one
+// fires. Conditions must hold for the rule's For duration before firing;
+// latency rules instead look at the samples from that window.
+type AlertEngine struct {
+	rules    []AlertRule
+	source   AlertSource
+	keys     KeyManager
+	notifier Notifier
+	audit    *AuditLogger
+	now      func() time.Time
+
+	mu       sync.Mutex
+	pending  map[string]time.Time // condition first seen, by alert key
+	active   map[string]*Alert
+	recent   []Alert
+	silenced map[string]time.Time // silence expiry, by alert key
+}
+
+// NewAlertEngine creates an engine. source, keys and notifier may be nil, in
+// which case the rules depending on them never fire or nothing is sent.
+func NewAlertEngine(rules []AlertRule, source AlertSource, keys KeyManager, notifier Notifier) *AlertEngine {
+	return &AlertEngine{
+		rules:    rules,
+		source:   source,
+		keys:     keys,
+		notifier: notifier,
+		now:      time.Now,
+		pending:  make(map[string]time.Time),
+		active:   make(map[string]*Alert),
+		silenced: make(map[string]time.Time),
+	}
+}
+
+// SetAuditLogger records fired and resolved alerts in al, so they show up in
+// reports after the engine is gone
+func (e *AlertEngine) SetAuditLogger(al *AuditLogger) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.audit = al
+}
+
+// Rules returns the engine's rules
+func (e *AlertEngine) Rules() []AlertRule {
+	return e.rules
+}
+
+// Rule returns the rule with the given name
+func (e *AlertEngine) Rule(name string) (AlertRule, bool) {
+	for _, r := range e.rules {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return AlertRule{}, false
+}
+
+// Run evaluates the rules every interval until ctx is done
+func (e *AlertEngine) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.Evaluate()
+		}
+	}
+}
+
+// Evaluate checks every rule once and returns the alerts that fired
+func (e *AlertEngine) Evaluate() []Alert {
+	now := e.now()
+
+	e.mu.Lock()
+	var fired, resolved []Alert
+	seen := make(map[string]bool)
+	for _, rule := range e.rules {
+		for subject, message := range e.violations(rule, now) {
+			key := rule.Name + "/" + subject
+			seen[key] = true
+
+			if _, ok := e.active[key]; ok {
+				continue
+			}
+			since, ok := e.pending[key]
+			if !ok {
+				since = now
+				e.pending[key] = since
+			}
+			if rule.Condition != AlertLatency && now.Sub(since) < rule.For {
+				continue
+			}
+
+			alert := &Alert{Rule: rule.Name, Subject: subject, Message: message, Since: since, FiredAt: now}
+			alert.SilencedUntil = e.silenced[key]
+			e.active[key] = alert
+			delete(e.pending, key)
+			fired = append(fired, *alert)
+		}
+	}
+
+	// Anything no longer violating resolves
+	for key := range e.pending {
+		if !seen[key] {
+			delete(e.pending, key)
+		}
+	}
+	for key, alert := range e.active {
+		if seen[key] {
+			continue
+		}
+		alert.ResolvedAt = now
+		e.recent = append(e.recent, *alert)
+		resolved = append(resolved, *alert)
+		delete(e.active, key)
+	}
+	if len(e.recent) > maxRecentAlerts {
+		e.recent = e.recent[len(e.recent)-maxRecentAlerts:]
+	}
+	for key, until := range e.silenced {
+		if !now.Before(until) {
+			delete(e.silenced, key)
+		}
+	}
+	audit := e.audit
+	e.mu.Unlock()
+
+	if audit != nil {
+		for _, alert := range fired {
+			_ = audit.Log(alertAuditEvent("alert_fired", alert, alert.FiredAt))
+		}
+		for _, alert := range resolved {
+			_ = audit.Log(alertAuditEvent("alert_resolved", alert, alert.ResolvedAt))
+		}
+	}
+
+	for _, alert := range fired {
+		if alert.Silenced(now) {
+			continue
+		}
+		e.notify(alert)
+	}
+	return fired
+}
+
+// violations returns the subjects currently breaking rule, with a message for each
+func (e *AlertEngine) violations(rule AlertRule, now time.Time) map[string]string {
+	result := make(map[string]string)
+
+	switch rule.Condition {
+	case AlertUnhealthy:
+		if e.source == nil {
+			return result
+		}
+		for _, s := range e.source.ConnectionStatuses() {
+			if rule.Target != "" && s.ID != rule.Target && s.Method != rule.Target {
+				continue
+			}
+			if !s.Healthy {
+				result[s.ID] = fmt.Sprintf("%s has been unhealthy for more than %s", s.ID, rule.For)
+			}
+		}
+
+	case AlertLatency:
+		if e.source == nil {
+			return result
+		}
+		for _, s := range e.source.ConnectionStatuses() {
+			if rule.Target != "" && s.ID != rule.Target && s.Method != rule.Target {
+				continue
+			}
+			samples := e.source.LatencySince(s.ID, now.Add(-rule.For))
+			if len(samples) == 0 {
+				continue
+			}
+			if p := Percentile(samples, rule.Percentile); p > rule.Threshold {
+				result[s.ID] = fmt.Sprintf("%s latency p%g is %s over the last %s (threshold %s)",
+					s.ID, rule.Percentile, p.Round(time.Millisecond), rule.For, rule.Threshold)
+			}
+		}
+
+	case AlertKeyAge:
+		if e.keys == nil {
+			return result
+		}
+		keys, err := e.keys.ListKeys("")
+		if err != nil {
+			return result
+		}
+		for _, key := range keys {
+			if rule.Target != "" && key.Fingerprint != rule.Target && key.Comment != rule.Target {
+				continue
+			}
+			if key.AddedAt.IsZero() {
+				continue
+			}
+			if age := now.Sub(key.AddedAt); age > rule.Threshold {
+				result[key.Fingerprint] = fmt.Sprintf("key %s (%s) is %d days old",
+					key.Fingerprint, key.Comment, int(age.Hours()/24))
+			}
+		}
+	}
+
+	return result
+}
+
+// alertAuditEvent describes an alert change for the audit log
+func alertAuditEvent(eventType string, alert Alert, at time.Time) AuditEvent {
+	return AuditEvent{
+		Timestamp: at,
+		EventType: eventType,
+		Method:    "alert",
+		Success:   eventType == "alert_resolved",
+		Details: map[string]interface{}{
+			"rule":    alert.Rule,
+			"subject": alert.Subject,
+			"message": alert.Message,
+			"since":   alert.Since,
+		},
+	}
+}
+
+// notify sends a notification for a fired alert
+func (e *AlertEngine) notify(alert Alert) error {
+	if e.notifier == nil {
+		return nil
+	}
+	return e.notifier.Notify(Notification{
+		Event:   NotifyAlert,
+		Title:   fmt.Sprintf("Alert: %s", alert.Rule),
+		Message: alert.Message,
+		ConnID:  alert.Subject,
+		Time:    alert.FiredAt,
+	})
+}
+
+// Test sends a notification for the named rule without evaluating it, so the
+// notification path can be checked end to end
+func (e *AlertEngine) Test(name string) (Alert, error) {
+	rule, ok := e.Rule(name)
+	if !ok {
+		return Alert{}, fmt.Errorf("unknown alert rule: %s", name)
+	}
+
+	now := e.now()
+	alert := Alert{
+		Rule:    rule.Name,
+		Subject: "test",
+		Message: fmt.Sprintf("Test alert: %s", rule.Describe()),
+		Since:   now,
+		FiredAt: now,
+	}
+	if e.notifier == nil {
+		return alert, fmt.Errorf("no notifier configured")
+	}
+	return alert, e.notify(alert)
+}
+
+// Acknowledge marks an active alert, identified by its Key, as acknowledged
+func (e *AlertEngine) Acknowledge(key string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	alert, ok := e.active[key]
+	if !ok {
+		return fmt.Errorf("no active alert: %s", key)
+	}
+	alert.AcknowledgedAt = e.now()
+	return nil
+}
+
+// Silence suppresses notifications for an alert, identified by its Key, for
+// d. The silence outlives the alert, so a flapping condition stays quiet.
+func (e *AlertEngine) Silence(key string, d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("silence duration must be positive")
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	until := e.now().Add(d)
+	e.silenced[key] = until
+	if alert, ok := e.active[key]; ok {
+		alert.SilencedUntil = until
+	}
+	return nil
+}
+
+// Active returns the currently firing alerts, oldest first
+func (e *AlertEngine) Active() []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	alerts := make([]Alert, 0, len(e.active))
+	for _, a := range e.active {
+		alerts = append(alerts, *a)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].FiredAt.Before(alerts[j].FiredAt)
+	})
+	return alerts
+}
+
+// Recent returns resolved alerts, oldest first
+func (e *AlertEngine) Recent() []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	recent := make([]Alert, len(e.recent))
+	copy(recent, e.recent)
+	return recent
+}
+
+// Percentile returns the p-th percentile (0-100) of samples using the
+// nearest-rank method
+func Percentile(samples []time.Duration, p float64) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+
+	sorted := make([]time.Duration, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// LatencySince returns the successful latency measurements for a connection
+// taken at or after since
+func (m *DefaultConnectionManager) LatencySince(connID string, since time.Time) []time.Duration {
+	if m.metricsCollector == nil {
+		return nil
+	}
+
+	var latencies []time.Duration
+	for _, s := range m.metricsCollector.GetSamples(connID, m.metricsCollector.bufferSize) {
+		if s.Timestamp.Before(since) || s.Latency <= 0 {
+			continue
+		}
+		latencies = append(latencies, s.Latency)
+	}
+	return latencies
+}
@@ -0,0 +1,187 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jedarden/tunnel/pkg/config"
+)
+
+type fakeAlertSource struct {
+	statuses  []ConnectionStatus
+	latencies map[string][]time.Duration
+}
+
+func (f *fakeAlertSource) ConnectionStatuses() []ConnectionStatus {
+	return f.statuses
+}
+
+func (f *fakeAlertSource) LatencySince(connID string, since time.Time) []time.Duration {
+	return f.latencies[connID]
+}
+
+func TestNewAlertRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.AlertRuleConfig
+		wantErr bool
+	}{
+		{"unhealthy", config.AlertRuleConfig{Name: "a", Condition: "unhealthy", For: "5m"}, false},
+		{"latency defaults", config.AlertRuleConfig{Name: "b", Condition: "latency", Threshold: "300ms"}, false},
+		{"key age in years", config.AlertRuleConfig{Name: "c", Condition: "key_age", Threshold: "1y"}, false},
+		{"missing threshold", config.AlertRuleConfig{Name: "d", Condition: "latency"}, true},
+		{"bad duration", config.AlertRuleConfig{Name: "e", Condition: "unhealthy", For: "soon"}, true},
+		{"bad percentile", config.AlertRuleConfig{Name: "f", Condition: "latency", Threshold: "1s", Percentile: 120}, true},
+		{"unknown condition", config.AlertRuleConfig{Name: "g", Condition: "cpu"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewAlertRule(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewAlertRule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	rule, _ := NewAlertRule(config.AlertRuleConfig{Name: "b", Condition: "latency", Threshold: "300ms"})
+	if rule.Percentile != 95 || rule.For != 5*time.Minute {
+		t.Errorf("expected latency defaults p95 over 5m, got p%g over %s", rule.Percentile, rule.For)
+	}
+
+	if d, _ := ParseAlertDuration("90d"); d != 90*24*time.Hour {
+		t.Errorf("ParseAlertDuration(90d) = %s", d)
+	}
+}
+
+func TestAlertEngineUnhealthyFor(t *testing.T) {
+	source := &fakeAlertSource{statuses: []ConnectionStatus{{ID: "conn-1", Method: "bastion", Healthy: false}}}
+	notifier := &recordingNotifier{}
+	rules := []AlertRule{{Name: "down", Condition: AlertUnhealthy, Target: "bastion", For: 5 * time.Minute}}
+
+	engine := NewAlertEngine(rules, source, nil, notifier)
+	now := time.Now()
+	engine.now = func() time.Time { return now }
+
+	if fired := engine.Evaluate(); len(fired) != 0 {
+		t.Fatalf("expected rule to wait for its duration, fired %+v", fired)
+	}
+
+	now = now.Add(6 * time.Minute)
+	fired := engine.Evaluate()
+	if len(fired) != 1 || fired[0].Subject != "conn-1" {
+		t.Fatalf("expected one alert for conn-1, got %+v", fired)
+	}
+	if len(notifier.sent) != 1 || notifier.sent[0].Event != NotifyAlert {
+		t.Fatalf("expected one alert notification, got %+v", notifier.sent)
+	}
+
+	// Still failing: no repeat notification
+	now = now.Add(time.Minute)
+	engine.Evaluate()
+	if len(notifier.sent) != 1 {
+		t.Errorf("expected alert to fire once, got %d notifications", len(notifier.sent))
+	}
+
+	source.statuses[0].Healthy = true
+	engine.Evaluate()
+	if len(engine.Active()) != 0 {
+		t.Errorf("expected alert to resolve, still active: %+v", engine.Active())
+	}
+	if recent := engine.Recent(); len(recent) != 1 || recent[0].Active() {
+		t.Errorf("expected one resolved alert in history, got %+v", recent)
+	}
+}
+
+func TestAlertEngineLatencyPercentile(t *testing.T) {
+	ms := time.Millisecond
+	source := &fakeAlertSource{
+		statuses: []ConnectionStatus{{ID: "fast", Healthy: true}, {ID: "slow", Healthy: true}},
+		latencies: map[string][]time.Duration{
+			"fast": {10 * ms, 20 * ms, 30 * ms, 400 * ms},
+			"slow": {350 * ms, 400 * ms, 20 * ms, 500 * ms},
+		},
+	}
+	rules := []AlertRule{{Name: "slow-link", Condition: AlertLatency, Threshold: 300 * ms, Percentile: 50, For: 10 * time.Minute}}
+
+	engine := NewAlertEngine(rules, source, nil, nil)
+	fired := engine.Evaluate()
+	if len(fired) != 1 || fired[0].Subject != "slow" {
+		t.Fatalf("expected only the slow connection to alert, got %+v", fired)
+	}
+}
+
+func TestAlertEngineTest(t *testing.T) {
+	notifier := &recordingNotifier{}
+	rules := []AlertRule{{Name: "stale-keys", Condition: AlertKeyAge, Threshold: 365 * 24 * time.Hour}}
+	engine := NewAlertEngine(rules, nil, nil, notifier)
+
+	if _, err := engine.Test("stale-keys"); err != nil {
+		t.Fatalf("Test() error = %v", err)
+	}
+	if len(notifier.sent) != 1 {
+		t.Errorf("expected a test notification, got %d", len(notifier.sent))
+	}
+	if _, err := engine.Test("missing"); err == nil {
+		t.Error("expected error for unknown rule")
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	samples := []time.Duration{5, 1, 4, 2, 3, 6, 7, 8, 9, 10}
+	if got := Percentile(samples, 95); got != 10 {
+		t.Errorf("p95 = %d, want 10", got)
+	}
+	if got := Percentile(samples, 50); got != 5 {
+		t.Errorf("p50 = %d, want 5", got)
+	}
+	if got := Percentile(nil, 95); got != 0 {
+		t.Errorf("p95 of no samples = %d, want 0", got)
+	}
+}
+
+func TestAlertEngineAcknowledgeAndSilence(t *testing.T) {
+	source := &fakeAlertSource{statuses: []ConnectionStatus{{ID: "conn-1", Healthy: false}}}
+	notifier := &recordingNotifier{}
+	rules := []AlertRule{{Name: "down", Condition: AlertUnhealthy}}
+
+	engine := NewAlertEngine(rules, source, nil, notifier)
+	now := time.Now()
+	engine.now = func() time.Time { return now }
+
+	fired := engine.Evaluate()
+	if len(fired) != 1 {
+		t.Fatalf("expected alert to fire, got %+v", fired)
+	}
+	key := fired[0].Key()
+
+	if err := engine.Acknowledge(key); err != nil {
+		t.Fatalf("Acknowledge() error = %v", err)
+	}
+	if err := engine.Silence(key, time.Hour); err != nil {
+		t.Fatalf("Silence() error = %v", err)
+	}
+	active := engine.Active()
+	if len(active) != 1 || !active[0].Acknowledged() || !active[0].Silenced(now) {
+		t.Fatalf("expected acknowledged, silenced alert, got %+v", active)
+	}
+
+	// Resolve and re-fire inside the silence window: no new notification
+	source.statuses[0].Healthy = true
+	engine.Evaluate()
+	source.statuses[0].Healthy = false
+	now = now.Add(10 * time.Minute)
+	if fired := engine.Evaluate(); len(fired) != 1 {
+		t.Fatalf("expected alert to fire again, got %+v", fired)
+	}
+	if len(notifier.sent) != 1 {
+		t.Errorf("expected silenced alert not to notify, got %d notifications", len(notifier.sent))
+	}
+	if engine.Active()[0].Acknowledged() {
+		t.Error("expected a re-fired alert to need acknowledging again")
+	}
+
+	if err := engine.Acknowledge("down/missing"); err == nil {
+		t.Error("expected error acknowledging an unknown alert")
+	}
+}
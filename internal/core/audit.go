@@ -1,7 +1,11 @@
 package core
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -18,6 +22,10 @@ type AuditEvent struct {
 	SourceIP  string                 `json:"source_ip"`
 	Details   map[string]interface{} `json:"details"`
 	Success   bool                   `json:"success"`
+
+	// The hash chain; see auditchain.go. Hash must stay the last field.
+	PrevHash string `json:"prev_hash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
 }
 
 // AuditLogger handles audit logging
@@ -28,6 +36,9 @@ type AuditLogger struct {
 	mu           sync.Mutex
 	enabled      bool
 	useSyslog    bool
+
+	subMu       sync.Mutex
+	subscribers map[chan AuditEvent]struct{}
 }
 
 // NewAuditLogger creates a new audit logger
@@ -82,16 +93,10 @@ func (al *AuditLogger) Log(event AuditEvent) error {
 		event.Timestamp = time.Now()
 	}
 
-	// Marshal to JSON
-	data, err := json.Marshal(event)
-	if err != nil {
-		return fmt.Errorf("marshal audit event: %w", err)
-	}
-
-	// Write to file
+	// Write to file, chained to the entry before
 	if al.file != nil {
-		if _, err := al.file.Write(append(data, '\n')); err != nil {
-			return fmt.Errorf("write to audit log: %w", err)
+		if err := al.append(event); err != nil {
+			return err
 		}
 	}
 
@@ -107,6 +112,65 @@ func (al *AuditLogger) Log(event AuditEvent) error {
 		}
 	}
 
+	al.publish(event)
+	return nil
+}
+
+// Subscribe returns a feed of the events logged from now on and a func
+// that ends it. Events a slow subscriber has no room for are dropped
+// rather than holding up the logger.
+func (al *AuditLogger) Subscribe(buffer int) (<-chan AuditEvent, func()) {
+	ch := make(chan AuditEvent, buffer)
+	al.subMu.Lock()
+	if al.subscribers == nil {
+		al.subscribers = make(map[chan AuditEvent]struct{})
+	}
+	al.subscribers[ch] = struct{}{}
+	al.subMu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			al.subMu.Lock()
+			delete(al.subscribers, ch)
+			close(ch)
+			al.subMu.Unlock()
+		})
+	}
+}
+
+func (al *AuditLogger) publish(event AuditEvent) {
+	al.subMu.Lock()
+	defer al.subMu.Unlock()
+	for ch := range al.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// append writes event to the file chained to its last entry. Other
+// processes append to the same file, so the chain's end is read under a
+// lock shared with them.
+func (al *AuditLogger) append(event AuditEvent) error {
+	lock, err := lockFile(al.filePath+".lock", true)
+	if err != nil {
+		return fmt.Errorf("lock audit log: %w", err)
+	}
+	defer unlockFile(lock)
+
+	prev, err := lastAuditHash(al.filePath)
+	if err != nil {
+		return err
+	}
+	line, _, err := chainAuditEvent(event, prev)
+	if err != nil {
+		return err
+	}
+	if _, err := al.file.Write(line); err != nil {
+		return fmt.Errorf("write to audit log: %w", err)
+	}
 	return nil
 }
 
@@ -195,7 +259,117 @@ func (al *AuditLogger) LogError(eventType, method, user string, err error, detai
 	})
 }
 
-// Rotate rotates the audit log file
+// connectionAuditTypes maps the connection events kept in the audit log, so
+// reports can be built after the fact, to their audit event type
+var connectionAuditTypes = map[EventType]string{
+	EventConnected:    "connection_connected",
+	EventDisconnected: "connection_disconnected",
+	EventReconnecting: "connection_reconnected",
+	EventFailover:     "connection_failover",
+	EventError:        "connection_error",
+}
+
+// RecordConnectionEvents writes connection lifecycle events received on sub
+// to the audit log until ctx is done or the subscription is closed
+func (al *AuditLogger) RecordConnectionEvents(ctx context.Context, sub *EventSubscriber) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-sub.Channel:
+			if !ok {
+				return
+			}
+			if audit, ok := connectionAuditEvent(event); ok {
+				_ = al.Log(audit)
+			}
+		}
+	}
+}
+
+// FromConnectionEvent reports whether e records a connection event, as
+// written by RecordConnectionEvents
+func (e AuditEvent) FromConnectionEvent() bool {
+	for _, eventType := range connectionAuditTypes {
+		if e.EventType == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// connectionAuditEvent converts a connection event to its audit form
+func connectionAuditEvent(event *ConnectionEvent) (AuditEvent, bool) {
+	eventType, ok := connectionAuditTypes[event.Type]
+	if !ok {
+		return AuditEvent{}, false
+	}
+
+	audit := AuditEvent{
+		Timestamp: event.Timestamp,
+		EventType: eventType,
+		Success:   event.Type != EventError,
+		Details: map[string]interface{}{
+			"connection_id": event.ConnID,
+			"message":       event.Message,
+		},
+	}
+	if event.OperationID != "" {
+		audit.Details["operation_id"] = event.OperationID
+	}
+	switch data := event.Data.(type) {
+	case *Connection:
+		audit.Method = data.Method
+		if data.Metrics != nil {
+			sent, received, _ := data.Metrics.GetStats()
+			audit.Details["bytes_sent"] = sent
+			audit.Details["bytes_received"] = received
+		}
+	case map[string]string:
+		for k, v := range data {
+			audit.Details[k] = v
+		}
+	case error:
+		audit.Details["error"] = data.Error()
+	}
+	return audit, true
+}
+
+// ReadAuditLog returns the events in the audit log at path recorded at or
+// after since, oldest first. Lines that don't parse are skipped so one
+// truncated entry doesn't hide the rest of the log. A missing log has no
+// events.
+func ReadAuditLog(path string, since time.Time) ([]AuditEvent, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+	defer file.Close()
+
+	var events []AuditEvent
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var event AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			continue
+		}
+		if event.Timestamp.Before(since) {
+			continue
+		}
+		events = append(events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read audit log: %w", err)
+	}
+	return events, nil
+}
+
+// Rotate rotates the audit log file. The new file starts with an entry
+// chained to the last of the old one.
 func (al *AuditLogger) Rotate() error {
 	al.mu.Lock()
 	defer al.mu.Unlock()
@@ -204,6 +378,16 @@ func (al *AuditLogger) Rotate() error {
 		return nil
 	}
 
+	lock, err := lockFile(al.filePath+".lock", true)
+	if err != nil {
+		return fmt.Errorf("lock audit log: %w", err)
+	}
+	defer unlockFile(lock)
+	head, err := lastAuditHash(al.filePath)
+	if err != nil {
+		return err
+	}
+
 	// Close current file
 	if err := al.file.Close(); err != nil {
 		return fmt.Errorf("close audit log: %w", err)
@@ -223,9 +407,98 @@ func (al *AuditLogger) Rotate() error {
 	}
 	al.file = file
 
+	line, _, err := chainAuditEvent(AuditEvent{
+		Timestamp: time.Now(),
+		EventType: "audit_rotated",
+		Method:    "audit",
+		Details:   map[string]interface{}{"previous_file": backupPath},
+		Success:   true,
+	}, head)
+	if err != nil {
+		return err
+	}
+	if _, err := al.file.Write(line); err != nil {
+		return fmt.Errorf("write to audit log: %w", err)
+	}
 	return nil
 }
 
+// Prune rewrites the audit log file without the events keep rejects,
+// returning how many were removed. Lines that don't parse are kept. The
+// remaining entries are chained anew, and an entry recording the pruning
+// and the chain's old end is added.
+func (al *AuditLogger) Prune(keep func(AuditEvent) bool) (int, error) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	if al.file == nil {
+		return 0, nil
+	}
+
+	lock, err := lockFile(al.filePath+".lock", true)
+	if err != nil {
+		return 0, fmt.Errorf("lock audit log: %w", err)
+	}
+	defer unlockFile(lock)
+
+	data, err := os.ReadFile(al.filePath)
+	if err != nil {
+		return 0, fmt.Errorf("read audit log: %w", err)
+	}
+
+	var kept bytes.Buffer
+	removed := 0
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		var event AuditEvent
+		if err := json.Unmarshal(line, &event); err == nil && !keep(event) {
+			removed++
+			continue
+		}
+		kept.Write(line)
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+
+	oldHead, err := lastAuditHash(al.filePath)
+	if err != nil {
+		return 0, err
+	}
+	rechained, head, err := rechainAuditLog(kept.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	note, _, err := chainAuditEvent(AuditEvent{
+		Timestamp: time.Now(),
+		EventType: "audit_pruned",
+		Method:    "audit",
+		Details:   map[string]interface{}{"removed": removed, "previous_head": oldHead},
+		Success:   true,
+	}, head)
+	if err != nil {
+		return 0, err
+	}
+
+	tmp := al.filePath + ".tmp"
+	if err := os.WriteFile(tmp, append(rechained, note...), 0600); err != nil {
+		return 0, fmt.Errorf("write audit log: %w", err)
+	}
+	if err := os.Rename(tmp, al.filePath); err != nil {
+		os.Remove(tmp)
+		return 0, fmt.Errorf("replace audit log: %w", err)
+	}
+
+	// Keep appending to the new file rather than the replaced one
+	file, err := os.OpenFile(al.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return removed, fmt.Errorf("open audit log: %w", err)
+	}
+	al.file.Close()
+	al.file = file
+
+	return removed, nil
+}
+
 // Close closes the audit logger
 func (al *AuditLogger) Close() error {
 	al.mu.Lock()
@@ -234,6 +507,10 @@ func (al *AuditLogger) Close() error {
 	var errors []error
 
 	if al.file != nil {
+		// Flush to disk so the last events survive an abrupt exit after shutdown
+		if err := al.file.Sync(); err != nil {
+			errors = append(errors, fmt.Errorf("sync file: %w", err))
+		}
 		if err := al.file.Close(); err != nil {
 			errors = append(errors, fmt.Errorf("close file: %w", err))
 		}
@@ -0,0 +1,309 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	logger, err := NewAuditLogger(path, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	logger.Log(AuditEvent{Timestamp: old, EventType: "key_added", User: "alice"})
+	logger.Log(AuditEvent{EventType: "key_removed", User: "alice"})
+	logger.Close()
+
+	// A truncated line must not hide the entries around it
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	f.WriteString("{\"timestamp\":\n")
+	f.Close()
+
+	events, err := ReadAuditLog(path, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("ReadAuditLog() error = %v", err)
+	}
+	if len(events) != 1 || events[0].EventType != "key_removed" {
+		t.Errorf("ReadAuditLog() = %+v, want only the recent event", events)
+	}
+
+	if events, err := ReadAuditLog(filepath.Join(t.TempDir(), "missing.log"), time.Time{}); err != nil || events != nil {
+		t.Errorf("ReadAuditLog(missing) = %v, %v", events, err)
+	}
+}
+
+func TestPruneAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	logger, err := NewAuditLogger(path, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	old := time.Now().Add(-48 * time.Hour)
+	logger.Log(AuditEvent{Timestamp: old, EventType: "ssh_session", User: "alice"})
+	logger.Log(AuditEvent{Timestamp: old, EventType: "key_added", User: "alice"})
+	logger.Log(AuditEvent{EventType: "ssh_session", User: "bob"})
+
+	cutoff := time.Now().Add(-24 * time.Hour)
+	removed, err := logger.Prune(func(e AuditEvent) bool {
+		return e.EventType != "ssh_session" || e.Timestamp.After(cutoff)
+	})
+	if err != nil || removed != 1 {
+		t.Fatalf("Prune() = %d, %v; want 1 removed", removed, err)
+	}
+
+	// Later events go to the rewritten file
+	logger.Log(AuditEvent{EventType: "key_removed", User: "alice"})
+	events, err := ReadAuditLog(path, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, e := range events {
+		types = append(types, e.EventType+":"+e.User)
+	}
+	if want := "key_added:alice ssh_session:bob audit_pruned: key_removed:alice"; strings.Join(types, " ") != want {
+		t.Errorf("events after prune = %v, want %s", types, want)
+	}
+	if v, err := VerifyAuditLog(path); err != nil || !v.Intact() {
+		t.Errorf("VerifyAuditLog() after prune = %+v, %v; want intact", v, err)
+	}
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	write := func(t *testing.T) (string, []string) {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		// Entries from before the log was chained
+		if err := os.WriteFile(path, []byte(`{"event_type":"key_added","user":"old","success":true}`+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		logger, err := NewAuditLogger(path, false, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, user := range []string{"alice", "bob", "carol"} {
+			logger.Log(AuditEvent{EventType: "key_added", User: user, Success: true})
+		}
+		logger.Close()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path, strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+	rewrite := func(t *testing.T, path string, lines []string) {
+		if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path, _ := write(t)
+	v, err := VerifyAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.Intact() || v.Entries != 4 || v.Chained != 3 || v.Unchained != 1 || v.Head == "" {
+		t.Errorf("VerifyAuditLog() = %+v, want 3 chained entries after 1 unchained", v)
+	}
+
+	tampered := map[string]func([]string) []string{
+		"modified": func(l []string) []string {
+			l[2] = strings.Replace(l[2], `"user":"bob"`, `"user":"eve"`, 1)
+			return l
+		},
+		"removed":   func(l []string) []string { return append(l[:2], l[3:]...) },
+		"reordered": func(l []string) []string { l[2], l[3] = l[3], l[2]; return l },
+	}
+	for name, tamper := range tampered {
+		t.Run(name, func(t *testing.T) {
+			path, lines := write(t)
+			rewrite(t, path, tamper(lines))
+			v, err := VerifyAuditLog(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v.Intact() {
+				t.Errorf("VerifyAuditLog() = %+v, want problems", v)
+			}
+		})
+	}
+
+	// Keyed entries only verify with the key
+	SetAuditKey([]byte("secret"))
+	defer SetAuditKey(nil)
+	path, _ = write(t)
+	if v, err := VerifyAuditLog(path); err != nil || !v.Intact() || !v.Keyed {
+		t.Errorf("VerifyAuditLog() with the key = %+v, %v", v, err)
+	}
+	SetAuditKey([]byte("guess"))
+	if v, _ := VerifyAuditLog(path); v.Intact() {
+		t.Error("VerifyAuditLog() with the wrong key found no problems")
+	}
+	SetAuditKey(nil)
+	if v, _ := VerifyAuditLog(path); v.Intact() {
+		t.Error("VerifyAuditLog() without the key found no problems")
+	}
+}
+
+func TestRecordConnectionEvents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	logger, err := NewAuditLogger(path, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publisher := NewEventPublisher(10)
+	sub := publisher.Subscribe("audit", nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		logger.RecordConnectionEvents(ctx, sub)
+		close(done)
+	}()
+
+	conn := NewConnection("bore-1", "bore", 22, "bore.pub", 4000)
+	conn.Metrics.Update(100, 200, 0)
+	publisher.Publish(NewEvent(EventDisconnected, conn.ID, conn, "stopped"))
+	publisher.Publish(NewEvent(EventMetricsUpdate, conn.ID, nil, "ignored"))
+	publisher.Publish(NewEvent(EventError, conn.ID, errors.New("timeout"), "failed"))
+
+	deadline := time.Now().Add(2 * time.Second)
+	var events []AuditEvent
+	for time.Now().Before(deadline) {
+		events, _ = ReadAuditLog(path, time.Time{})
+		if len(events) >= 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	logger.Close()
+
+	if len(events) != 2 {
+		t.Fatalf("recorded %d events, want 2", len(events))
+	}
+	if events[0].EventType != "connection_disconnected" || events[0].Method != "bore" || events[0].Details["bytes_received"] != float64(200) {
+		t.Errorf("disconnect recorded as %+v", events[0])
+	}
+	if events[1].EventType != "connection_error" || events[1].Success || events[1].Details["error"] != "timeout" {
+		t.Errorf("error recorded as %+v", events[1])
+	}
+}
+
+func TestAuditLoggerSubscribe(t *testing.T) {
+	logger, err := NewAuditLogger(filepath.Join(t.TempDir(), "audit.log"), false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	feed, unsubscribe := logger.Subscribe(1)
+	_ = logger.LogKeyOperation("key_added", "alice", true, nil)
+	_ = logger.LogKeyOperation("key_removed", "alice", true, nil) // no room, dropped
+
+	select {
+	case event := <-feed:
+		if event.EventType != "key_added" || event.User != "alice" {
+			t.Errorf("got %+v, want alice's key_added", event)
+		}
+	default:
+		t.Fatal("logged event not delivered")
+	}
+	select {
+	case event := <-feed:
+		t.Errorf("event %s delivered to a full feed", event.EventType)
+	default:
+	}
+
+	unsubscribe()
+	unsubscribe()
+	if _, ok := <-feed; ok {
+		t.Error("feed open after unsubscribing")
+	}
+	_ = logger.LogKeyOperation("key_added", "bob", true, nil)
+
+	if !(AuditEvent{EventType: "connection_failover"}).FromConnectionEvent() || (AuditEvent{EventType: "key_added"}).FromConnectionEvent() {
+		t.Error("FromConnectionEvent misclassifies events")
+	}
+}
+
+func TestQueryAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	logger, err := NewAuditLogger(path, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	logger.Log(AuditEvent{Timestamp: now.Add(-3 * time.Hour), EventType: "key_added", User: "alice", Success: true})
+	logger.Log(AuditEvent{Timestamp: now.Add(-2 * time.Hour), EventType: "identity_key_rotated", User: "deploy", Success: true,
+		Details: map[string]interface{}{"identity": "ci-runner"}})
+	logger.Log(AuditEvent{Timestamp: now.Add(-time.Hour), EventType: "identity_key_revoked", User: "deploy",
+		Details: map[string]interface{}{"error": "permission denied"}})
+	logger.Close()
+
+	tests := []struct {
+		name  string
+		query AuditQuery
+		want  []string
+	}{
+		{"everything", AuditQuery{}, []string{"key_added", "identity_key_rotated", "identity_key_revoked"}},
+		{"user", AuditQuery{User: "deploy"}, []string{"identity_key_rotated", "identity_key_revoked"}},
+		{"type pattern", AuditQuery{EventType: "identity_key_*"}, []string{"identity_key_rotated", "identity_key_revoked"}},
+		{"time range", AuditQuery{Since: now.Add(-150 * time.Minute), Until: now.Add(-90 * time.Minute)}, []string{"identity_key_rotated"}},
+		{"text in details", AuditQuery{Text: "CI-RUNNER"}, []string{"identity_key_rotated"}},
+		{"failed", AuditQuery{Failed: true}, []string{"identity_key_revoked"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := QueryAuditLog(path, tt.query)
+			if err != nil {
+				t.Fatalf("QueryAuditLog() error = %v", err)
+			}
+			var got []string
+			for _, e := range events {
+				got = append(got, e.EventType)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("QueryAuditLog() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := QueryAuditLog(path, AuditQuery{EventType: "[key"}); err == nil {
+		t.Error("expected error for a malformed pattern")
+	}
+	if _, err := QueryAuditLog(path, AuditQuery{Since: now, Until: now.Add(-time.Hour)}); err == nil {
+		t.Error("expected error for a range ending before it starts")
+	}
+}
+
+func TestWriteAuditCSV(t *testing.T) {
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	var b strings.Builder
+	err := WriteAuditCSV(&b, []AuditEvent{{
+		Timestamp: at,
+		EventType: "key_added",
+		Method:    "ssh-key",
+		User:      "alice",
+		Success:   true,
+		Details:   map[string]interface{}{"comment": "laptop, work"},
+	}})
+	if err != nil {
+		t.Fatalf("WriteAuditCSV() error = %v", err)
+	}
+	want := "timestamp,event_type,method,user,source_ip,success,details\n" +
+		`2026-03-01T12:00:00Z,key_added,ssh-key,alice,,true,"{""comment"":""laptop, work""}"` + "\n"
+	if b.String() != want {
+		t.Errorf("WriteAuditCSV() =\n%s\nwant\n%s", b.String(), want)
+	}
+}
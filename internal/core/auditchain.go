@@ -0,0 +1,247 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"sync"
+	"time"
+)
+
+// The audit log is a hash chain: each entry records the hash of the one
+// before it in prev_hash and ends with its own hash, taken over the entry's
+// bytes up to that field. Changing, removing or reordering an entry breaks
+// the chain from there on. With a key the hashes are HMACs, so the chain
+// can't be rebuilt after tampering without the key either.
+
+var (
+	auditKeyMu sync.RWMutex
+	auditKey   []byte
+)
+
+// SetAuditKey makes every audit logger of the process sign entries with an
+// HMAC under key; nil goes back to plain SHA-256 hashes
+func SetAuditKey(key []byte) {
+	auditKeyMu.Lock()
+	defer auditKeyMu.Unlock()
+	auditKey = append([]byte(nil), key...)
+	if len(key) == 0 {
+		auditKey = nil
+	}
+}
+
+// newAuditHash returns the hash entries are chained with
+func newAuditHash() hash.Hash {
+	auditKeyMu.RLock()
+	defer auditKeyMu.RUnlock()
+	if auditKey != nil {
+		return hmac.New(sha256.New, auditKey)
+	}
+	return sha256.New()
+}
+
+// chainAuditEvent encodes event as a log line chained to prev
+func chainAuditEvent(event AuditEvent, prev string) ([]byte, string, error) {
+	event.PrevHash = prev
+	event.Hash = ""
+	body, err := json.Marshal(event)
+	if err != nil {
+		return nil, "", fmt.Errorf("marshal audit event: %w", err)
+	}
+	sum := auditHashOf(body)
+
+	// Hash is the last field, so the line is the body with it added
+	line := make([]byte, 0, len(body)+len(sum)+12)
+	line = append(line, body[:len(body)-1]...)
+	line = append(line, `,"hash":"`...)
+	line = append(line, sum...)
+	line = append(line, "\"}\n"...)
+	return line, sum, nil
+}
+
+// auditHashOf hashes an entry's bytes without its hash field
+func auditHashOf(body []byte) string {
+	h := newAuditHash()
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashedBody returns the bytes a line's hash was taken over: the line with
+// its trailing hash field removed
+func hashedBody(line []byte, sum string) ([]byte, bool) {
+	suffix := []byte(`,"hash":"` + sum + `"}`)
+	if !bytes.HasSuffix(line, suffix) {
+		return nil, false
+	}
+	body := append([]byte(nil), line[:len(line)-len(suffix)]...)
+	return append(body, '}'), true
+}
+
+// lastAuditHash returns the hash of the last entry of the log at path, or
+// "" for an empty or unchained log. Only the end of the file is read.
+func lastAuditHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", fmt.Errorf("open audit log: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", fmt.Errorf("open audit log: %w", err)
+	}
+
+	const chunk, limit = 4096, 1 << 20
+	var tail []byte
+	for off := info.Size(); off > 0 && info.Size()-off < limit; {
+		n := min(int64(chunk), off)
+		off -= n
+		buf := make([]byte, n, n+int64(len(tail)))
+		if _, err := f.ReadAt(buf, off); err != nil {
+			return "", fmt.Errorf("read audit log: %w", err)
+		}
+		tail = append(buf, tail...)
+
+		// The first line may be cut short unless it starts the file
+		lines := bytes.Split(tail, []byte("\n"))
+		first := 1
+		if off == 0 {
+			first = 0
+		}
+		for i := len(lines) - 1; i >= first; i-- {
+			var entry struct {
+				Hash string `json:"hash"`
+			}
+			if json.Unmarshal(lines[i], &entry) == nil {
+				return entry.Hash, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// rechainAuditLog rewrites the chained entries of data to follow one
+// another, as after some were removed, and returns the new last hash.
+// Entries from before the log was chained are left as they are.
+func rechainAuditLog(data []byte) ([]byte, string, error) {
+	var out bytes.Buffer
+	prev, chained := "", false
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		var event AuditEvent
+		if err := json.Unmarshal(line, &event); err != nil || (event.Hash == "" && !chained) {
+			out.Write(line)
+			continue
+		}
+		if !chained {
+			prev, chained = event.PrevHash, true
+		}
+		rechained, sum, err := chainAuditEvent(event, prev)
+		if err != nil {
+			return nil, "", err
+		}
+		out.Write(rechained)
+		prev = sum
+	}
+	return out.Bytes(), prev, nil
+}
+
+// AuditProblem is an entry of the audit log that breaks the chain
+type AuditProblem struct {
+	Line      int       `json:"line"`
+	Timestamp time.Time `json:"timestamp,omitempty"`
+	EventType string    `json:"event_type,omitempty"`
+	Problem   string    `json:"problem"`
+}
+
+// AuditVerification is what checking an audit log's chain found
+type AuditVerification struct {
+	Entries   int            `json:"entries"`
+	Chained   int            `json:"chained"`
+	Unchained int            `json:"unchained"` // written before the log was chained
+	Keyed     bool           `json:"keyed"`     // checked as HMACs
+	Head      string         `json:"head"`      // hash of the last entry
+	Problems  []AuditProblem `json:"problems"`
+}
+
+// Intact reports whether the chain is unbroken
+func (v *AuditVerification) Intact() bool {
+	return len(v.Problems) == 0
+}
+
+// VerifyAuditLog checks the hash chain of the audit log at path. Entries
+// appended after the one whose hash was last noted down can't be told from
+// a log cut short, so the head is returned for comparing with later runs.
+func VerifyAuditLog(path string) (*AuditVerification, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+	defer file.Close()
+
+	auditKeyMu.RLock()
+	v := &AuditVerification{Keyed: auditKey != nil, Problems: []AuditProblem{}}
+	auditKeyMu.RUnlock()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	prev, chained, lineNo := "", false, 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		v.Entries++
+
+		problem := func(event AuditEvent, what string) {
+			v.Problems = append(v.Problems, AuditProblem{
+				Line: lineNo, Timestamp: event.Timestamp, EventType: event.EventType, Problem: what,
+			})
+		}
+
+		var event AuditEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			if chained {
+				problem(event, "does not parse")
+			} else {
+				v.Unchained++
+			}
+			continue
+		}
+		if event.Hash == "" {
+			if chained {
+				problem(event, "has no hash, yet entries before it do")
+			} else {
+				v.Unchained++
+			}
+			continue
+		}
+
+		body, ok := hashedBody(line, event.Hash)
+		switch {
+		case !ok:
+			problem(event, "has its hash out of place")
+		case auditHashOf(body) != event.Hash:
+			problem(event, "was modified after it was written")
+		case chained && event.PrevHash != prev:
+			problem(event, "does not follow the entry before it: entries were removed, added or reordered")
+		}
+		chained = true
+		prev = event.Hash
+		v.Chained++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read audit log: %w", err)
+	}
+	v.Head = prev
+	return v, nil
+}
@@ -0,0 +1,134 @@
+package core
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuditQuery selects audit events. Zero fields match everything.
+type AuditQuery struct {
+	User      string
+	EventType string // an event type, or a pattern such as identity_key_*
+	Since     time.Time
+	Until     time.Time
+	Text      string // found in any field or detail, ignoring case
+	Failed    bool   // only events that did not succeed
+}
+
+// Match reports whether event is selected by q
+func (q AuditQuery) Match(event AuditEvent) bool {
+	if q.User != "" && event.User != q.User {
+		return false
+	}
+	if q.EventType != "" {
+		if ok, _ := path.Match(q.EventType, event.EventType); !ok {
+			return false
+		}
+	}
+	if !q.Since.IsZero() && event.Timestamp.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && event.Timestamp.After(q.Until) {
+		return false
+	}
+	if q.Failed && event.Success {
+		return false
+	}
+	if q.Text != "" && !strings.Contains(strings.ToLower(auditEventText(event)), strings.ToLower(q.Text)) {
+		return false
+	}
+	return true
+}
+
+// Validate checks the query's event type pattern and time range
+func (q AuditQuery) Validate() error {
+	if _, err := path.Match(q.EventType, ""); err != nil {
+		return fmt.Errorf("invalid event type pattern %q", q.EventType)
+	}
+	if !q.Since.IsZero() && !q.Until.IsZero() && q.Until.Before(q.Since) {
+		return fmt.Errorf("the end of the time range is before its start")
+	}
+	return nil
+}
+
+// QueryAuditLog returns the events in the audit log at path selected by q,
+// oldest first
+func QueryAuditLog(path string, q AuditQuery) ([]AuditEvent, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	events, err := ReadAuditLog(path, q.Since)
+	if err != nil {
+		return nil, err
+	}
+	matched := []AuditEvent{}
+	for _, event := range events {
+		if q.Match(event) {
+			matched = append(matched, event)
+		}
+	}
+	return matched, nil
+}
+
+// auditEventText is everything searchable in an event, on one line
+func auditEventText(event AuditEvent) string {
+	return strings.Join([]string{
+		event.EventType, event.Method, event.User, event.SourceIP, FormatAuditDetails(event.Details),
+	}, " ")
+}
+
+// FormatAuditDetails lists an event's details as k=v, sorted by key, with
+// nested values as JSON
+func FormatAuditDetails(details map[string]interface{}) string {
+	pairs := make([]string, 0, len(details))
+	for k, v := range details {
+		switch v.(type) {
+		case map[string]interface{}, []interface{}:
+			data, _ := json.Marshal(v)
+			pairs = append(pairs, k+"="+string(data))
+		default:
+			pairs = append(pairs, fmt.Sprintf("%s=%v", k, v))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
+
+// WriteAuditCSV writes events as CSV with a header row, one event a row and
+// the details as a JSON object, for review in a spreadsheet
+func WriteAuditCSV(w io.Writer, events []AuditEvent) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"timestamp", "event_type", "method", "user", "source_ip", "success", "details"}); err != nil {
+		return err
+	}
+	for _, event := range events {
+		details := ""
+		if len(event.Details) > 0 {
+			data, err := json.Marshal(event.Details)
+			if err != nil {
+				return fmt.Errorf("encode details of %s event: %w", event.EventType, err)
+			}
+			details = string(data)
+		}
+		if err := cw.Write([]string{
+			event.Timestamp.UTC().Format(time.RFC3339),
+			event.EventType,
+			event.Method,
+			event.User,
+			event.SourceIP,
+			strconv.FormatBool(event.Success),
+			details,
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,227 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// ConnectionMode is how the manager uses several connections at once
+type ConnectionMode string
+
+const (
+	// ModeFailover sends sessions to the primary connection and keeps the
+	// others as backups
+	ModeFailover ConnectionMode = "failover"
+
+	// ModeLoadBalance keeps every healthy connection active and spreads new
+	// sessions across them
+	ModeLoadBalance ConnectionMode = "load-balance"
+)
+
+// BalanceStrategy is how load-balance mode picks a connection for a session
+type BalanceStrategy string
+
+const (
+	// BalanceRoundRobin takes the healthy connections in turn
+	BalanceRoundRobin BalanceStrategy = "round-robin"
+
+	// BalanceLatency picks connections in inverse proportion to their
+	// latency, so one twice as fast gets twice the sessions
+	BalanceLatency BalanceStrategy = "latency"
+)
+
+// ErrNoHealthyConnection is returned by Pick when no connection can take a
+// session
+var ErrNoHealthyConnection = errors.New("no healthy connection")
+
+// ParseConnectionMode checks a mode and strategy as written in the config;
+// empty ones are the defaults
+func ParseConnectionMode(mode, strategy string) (ConnectionMode, BalanceStrategy, error) {
+	m, s := ConnectionMode(mode), BalanceStrategy(strategy)
+	switch m {
+	case "":
+		m = ModeFailover
+	case ModeFailover, ModeLoadBalance:
+	default:
+		return "", "", fmt.Errorf("unknown connection mode %q: use failover or load-balance", mode)
+	}
+	switch s {
+	case "":
+		s = BalanceRoundRobin
+	case BalanceRoundRobin, BalanceLatency:
+	default:
+		return "", "", fmt.Errorf("unknown balance strategy %q: use round-robin or latency", strategy)
+	}
+	return m, s, nil
+}
+
+// balancer spreads sessions over connections in load-balance mode
+type balancer struct {
+	next  int              // position of the next round-robin pick
+	float func() float64   // random source of weighted picks, in [0, 1)
+	picks map[string]int64 // sessions handed to each connection, by ID
+}
+
+func newBalancer() balancer {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return balancer{float: rng.Float64, picks: make(map[string]int64)}
+}
+
+// SetMode switches between failover and load balancing. While load
+// balancing, a primary is still kept and replaced when it fails, but not
+// for latency or to recover a preferred one.
+func (m *DefaultConnectionManager) SetMode(mode ConnectionMode, strategy BalanceStrategy) error {
+	mode, strategy, err := ParseConnectionMode(string(mode), string(strategy))
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	changed := m.config.Mode != mode || m.config.Balance != strategy
+	m.config.Mode, m.config.Balance = mode, strategy
+	m.mu.Unlock()
+
+	if m.failoverManager != nil {
+		m.failoverManager.balancing.Store(mode == ModeLoadBalance)
+	}
+	if changed {
+		m.eventPublisher.Publish(NewEvent(EventStateChange, "", string(mode),
+			"Connection mode set to "+describeMode(mode, strategy)))
+	}
+	return nil
+}
+
+// Mode returns the connection mode and, for load balancing, the strategy
+func (m *DefaultConnectionManager) Mode() (ConnectionMode, BalanceStrategy) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	mode, strategy, _ := ParseConnectionMode(string(m.config.Mode), string(m.config.Balance))
+	return mode, strategy
+}
+
+// describeMode names a mode for messages, e.g. "load-balance (latency)"
+func describeMode(mode ConnectionMode, strategy BalanceStrategy) string {
+	if mode == ModeLoadBalance {
+		return fmt.Sprintf("%s (%s)", mode, strategy)
+	}
+	return string(mode)
+}
+
+// Pick returns the connection a new session should use: the primary in
+// failover mode, or the next healthy connection by the balance strategy
+// when load balancing
+func (m *DefaultConnectionManager) Pick() (*Connection, error) {
+	var healthy []ConnectionStatus
+	for _, s := range m.ConnectionStatuses() {
+		if s.State == StateConnected.String() && s.Healthy {
+			healthy = append(healthy, s)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil, ErrNoHealthyConnection
+	}
+
+	m.mu.Lock()
+	var id string
+	switch mode, strategy, _ := ParseConnectionMode(string(m.config.Mode), string(m.config.Balance)); {
+	case mode == ModeFailover:
+		id = pickPrimary(healthy)
+	case strategy == BalanceLatency:
+		id = m.balance.pickWeighted(healthy)
+	default:
+		id = healthy[m.balance.next%len(healthy)].ID
+		m.balance.next++
+	}
+	m.balance.picks[id]++
+	m.mu.Unlock()
+
+	return m.Status(id)
+}
+
+// pickPrimary returns the primary among healthy, or the one of highest
+// priority when the primary is not among them
+func pickPrimary(healthy []ConnectionStatus) string {
+	for _, s := range healthy {
+		if s.Primary {
+			return s.ID
+		}
+	}
+	best := healthy[0]
+	for _, s := range healthy[1:] {
+		if s.Priority < best.Priority {
+			best = s
+		}
+	}
+	return best.ID
+}
+
+// pickWeighted picks among healthy with a chance inverse to each one's
+// latency. Connections not measured yet weigh as much as the average of
+// those that were, or all the same when none was.
+func (b *balancer) pickWeighted(healthy []ConnectionStatus) string {
+	weights := latencyWeights(healthy)
+	var total float64
+	for _, w := range weights {
+		total += w
+	}
+	r := b.float() * total
+	for i, w := range weights {
+		if r < w {
+			return healthy[i].ID
+		}
+		r -= w
+	}
+	return healthy[len(healthy)-1].ID
+}
+
+// latencyWeights returns the weight of each connection in healthy
+func latencyWeights(healthy []ConnectionStatus) []float64 {
+	weights := make([]float64, len(healthy))
+	var known float64
+	measured := 0
+	for i, s := range healthy {
+		if s.Latency > 0 {
+			weights[i] = 1 / s.Latency.Seconds()
+			known += weights[i]
+			measured++
+		}
+	}
+	fill := 1.0
+	if measured > 0 {
+		fill = known / float64(measured)
+	}
+	for i := range weights {
+		if weights[i] == 0 {
+			weights[i] = fill
+		}
+	}
+	return weights
+}
+
+// SessionCounts returns how many sessions Pick has handed each connection,
+// by ID, busiest first
+func (m *DefaultConnectionManager) SessionCounts() []SessionCount {
+	m.mu.RLock()
+	counts := make([]SessionCount, 0, len(m.balance.picks))
+	for id, n := range m.balance.picks {
+		counts = append(counts, SessionCount{ConnID: id, Sessions: n})
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Sessions != counts[j].Sessions {
+			return counts[i].Sessions > counts[j].Sessions
+		}
+		return counts[i].ConnID < counts[j].ConnID
+	})
+	return counts
+}
+
+// SessionCount is how many sessions were handed a connection
+type SessionCount struct {
+	ConnID   string `json:"connection_id"`
+	Sessions int64  `json:"sessions"`
+}
@@ -0,0 +1,160 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func startBalanced(t *testing.T, methods ...string) (*DefaultConnectionManager, []*Connection) {
+	t.Helper()
+	manager := NewConnectionManager(nil)
+	t.Cleanup(func() { manager.Shutdown() })
+
+	var conns []*Connection
+	for _, method := range methods {
+		manager.RegisterProvider(NewMockProvider(method, 0.0, time.Millisecond))
+		conn, err := manager.Start(method, DefaultConfig())
+		if err != nil {
+			t.Fatalf("Failed to start connection: %v", err)
+		}
+		conns = append(conns, conn)
+	}
+	return manager, conns
+}
+
+func TestPickFailoverUsesPrimary(t *testing.T) {
+	manager, conns := startBalanced(t, "first", "second")
+	if err := manager.SetPrimary(conns[1].ID); err != nil {
+		t.Fatalf("SetPrimary() error = %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		got, err := manager.Pick()
+		if err != nil {
+			t.Fatalf("Pick() error = %v", err)
+		}
+		if got.ID != conns[1].ID {
+			t.Errorf("Pick() = %s, want the primary %s", got.Method, conns[1].Method)
+		}
+	}
+}
+
+func TestPickRoundRobin(t *testing.T) {
+	manager, conns := startBalanced(t, "first", "second", "third")
+	if err := manager.SetMode(ModeLoadBalance, BalanceRoundRobin); err != nil {
+		t.Fatalf("SetMode() error = %v", err)
+	}
+
+	for i := 0; i < 6; i++ {
+		got, err := manager.Pick()
+		if err != nil {
+			t.Fatalf("Pick() error = %v", err)
+		}
+		if want := conns[i%3]; got.ID != want.ID {
+			t.Errorf("pick %d = %s, want %s", i, got.Method, want.Method)
+		}
+	}
+
+	for _, c := range manager.SessionCounts() {
+		if c.Sessions != 2 {
+			t.Errorf("%s got %d sessions, want 2", c.ConnID, c.Sessions)
+		}
+	}
+
+	// A connection that goes down is left out
+	conns[1].SetState(StateFailed)
+	for i := 0; i < 4; i++ {
+		got, err := manager.Pick()
+		if err != nil {
+			t.Fatalf("Pick() error = %v", err)
+		}
+		if got.ID == conns[1].ID {
+			t.Fatal("Pick() chose a failed connection")
+		}
+	}
+}
+
+func TestPickWeightedByLatency(t *testing.T) {
+	manager, conns := startBalanced(t, "fast", "slow")
+	conns[0].Metrics.Update(0, 0, 10*time.Millisecond)
+	conns[1].Metrics.Update(0, 0, 30*time.Millisecond)
+	if err := manager.SetMode(ModeLoadBalance, BalanceLatency); err != nil {
+		t.Fatalf("SetMode() error = %v", err)
+	}
+
+	// Walk the random source evenly over [0, 1)
+	const picks = 400
+	n := 0
+	manager.balance.float = func() float64 {
+		f := float64(n) / picks
+		n++
+		return f
+	}
+
+	fast := 0
+	for i := 0; i < picks; i++ {
+		got, err := manager.Pick()
+		if err != nil {
+			t.Fatalf("Pick() error = %v", err)
+		}
+		if got.ID == conns[0].ID {
+			fast++
+		}
+	}
+	// Three times as fast gets three quarters of the sessions
+	if fast != 300 {
+		t.Errorf("fast connection got %d of %d sessions, want 300", fast, picks)
+	}
+}
+
+func TestLatencyWeightsFillUnmeasured(t *testing.T) {
+	weights := latencyWeights([]ConnectionStatus{
+		{Latency: 10 * time.Millisecond},
+		{Latency: 40 * time.Millisecond},
+		{},
+	})
+	if want := (100.0 + 25.0) / 2; weights[2] != want {
+		t.Errorf("unmeasured weight = %v, want %v", weights[2], want)
+	}
+
+	weights = latencyWeights([]ConnectionStatus{{}, {}})
+	if weights[0] != weights[1] || weights[0] <= 0 {
+		t.Errorf("expected equal positive weights without measurements, got %v", weights)
+	}
+}
+
+func TestPickWithoutHealthyConnection(t *testing.T) {
+	manager, conns := startBalanced(t, "only")
+	conns[0].SetState(StateFailed)
+
+	if _, err := manager.Pick(); err != ErrNoHealthyConnection {
+		t.Errorf("Pick() error = %v, want %v", err, ErrNoHealthyConnection)
+	}
+}
+
+func TestSetMode(t *testing.T) {
+	manager, _ := startBalanced(t)
+
+	if mode, strategy := manager.Mode(); mode != ModeFailover || strategy != BalanceRoundRobin {
+		t.Errorf("default Mode() = %s, %s", mode, strategy)
+	}
+	if err := manager.SetMode(ModeLoadBalance, BalanceLatency); err != nil {
+		t.Fatalf("SetMode() error = %v", err)
+	}
+	if mode, strategy := manager.Mode(); mode != ModeLoadBalance || strategy != BalanceLatency {
+		t.Errorf("Mode() = %s, %s after SetMode", mode, strategy)
+	}
+	if !manager.failoverManager.balancing.Load() {
+		t.Error("expected failover to know it is load balancing")
+	}
+
+	if err := manager.SetMode("active-active", ""); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+	if err := manager.SetMode(ModeLoadBalance, "random"); err == nil {
+		t.Error("expected error for unknown strategy")
+	}
+	if mode, _ := manager.Mode(); mode != ModeLoadBalance {
+		t.Errorf("an invalid mode changed the mode to %s", mode)
+	}
+}
@@ -0,0 +1,141 @@
+package core
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/providers"
+)
+
+// BenchmarkOptions controls how long each provider is measured
+type BenchmarkOptions struct {
+	Samples  int           // latency measurements per provider; 0 uses 5
+	Interval time.Duration // wait before each measurement; 0 uses a second
+}
+
+// BenchmarkResult is how one provider fared in a benchmark. Latencies are
+// taken once the tunnel is up, so the first moments of a new connection
+// don't count against it.
+type BenchmarkResult struct {
+	Provider         string        `json:"provider"`
+	ConnectTime      time.Duration `json:"connect_time"` // 0 when it was already connected
+	Latency          time.Duration `json:"latency"`      // median of the samples
+	MinLatency       time.Duration `json:"min_latency"`
+	MaxLatency       time.Duration `json:"max_latency"`
+	Samples          int           `json:"samples"` // measurements that succeeded
+	AlreadyConnected bool          `json:"already_connected,omitempty"`
+	Error            string        `json:"error,omitempty"`
+}
+
+// OK reports whether the provider connected and its latency was measured
+func (r BenchmarkResult) OK() bool {
+	return r.Error == "" && r.Samples > 0
+}
+
+// BenchmarkProvider brings p up, unless it already is, measures its
+// latency and takes it down again. The latency is the round trip the
+// provider's health check reports where it measures one, and the time to
+// open a TCP connection to the tunnel's endpoint otherwise.
+func BenchmarkProvider(ctx context.Context, p providers.Provider, opts BenchmarkOptions) BenchmarkResult {
+	if opts.Samples <= 0 {
+		opts.Samples = 5
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	res := BenchmarkResult{Provider: p.Name()}
+
+	if p.IsConnected() {
+		res.AlreadyConnected = true
+	} else {
+		start := time.Now()
+		if err := p.Connect(); err != nil {
+			res.Error = err.Error()
+			return res
+		}
+		res.ConnectTime = time.Since(start)
+		defer p.Disconnect()
+	}
+
+	conn := benchmarkConnection(p)
+	var collector DefaultMetricsCollector
+	var samples []time.Duration
+	var lastErr error
+	for i := 0; i < opts.Samples; i++ {
+		select {
+		case <-ctx.Done():
+			res.Error = ctx.Err().Error()
+			return res
+		case <-time.After(opts.Interval):
+		}
+
+		if health, err := p.HealthCheck(); err == nil && health != nil && health.Latency > 0 {
+			samples = append(samples, health.Latency)
+			continue
+		}
+		latency, err := collector.measureLatency(ctx, conn)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		samples = append(samples, latency)
+	}
+
+	if len(samples) == 0 {
+		if lastErr != nil {
+			res.Error = lastErr.Error()
+		} else {
+			res.Error = "no latency measured"
+		}
+		return res
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	res.Samples = len(samples)
+	res.Latency = samples[len(samples)/2]
+	res.MinLatency = samples[0]
+	res.MaxLatency = samples[len(samples)-1]
+	return res
+}
+
+// benchmarkConnection describes p's tunnel as a connection whose endpoint
+// a latency measurement can dial
+func benchmarkConnection(p providers.Provider) *Connection {
+	info, err := p.GetConnectionInfo()
+	if err != nil || info == nil {
+		return NewConnection(p.Name(), p.Name(), 0, "", 0)
+	}
+
+	host, port := info.RemoteIP, info.RemotePort
+	if info.TunnelURL != "" {
+		host, port = providers.SplitHostPort(info.TunnelURL)
+		if port == 0 {
+			switch {
+			case strings.HasPrefix(info.TunnelURL, "https://"):
+				port = 443
+			case strings.HasPrefix(info.TunnelURL, "http://"):
+				port = 80
+			}
+		}
+	}
+	if host != "" && port == 0 {
+		port = 22
+	}
+	return NewConnection(p.Name(), p.Name(), info.LocalPort, host, port)
+}
+
+// RankBenchmarks orders results best first: those measured by median
+// latency, then by connect time, and those that failed last
+func RankBenchmarks(results []BenchmarkResult) {
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.OK() != b.OK() {
+			return a.OK()
+		}
+		if a.Latency != b.Latency {
+			return a.Latency < b.Latency
+		}
+		return a.ConnectTime < b.ConnectTime
+	})
+}
@@ -0,0 +1,98 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/providers"
+)
+
+// benchProvider is a provider whose tunnel ends at a local listener
+type benchProvider struct {
+	providers.Provider
+	name       string
+	endpoint   string
+	connectErr error
+	connected  bool
+}
+
+func (p *benchProvider) Name() string      { return p.name }
+func (p *benchProvider) IsConnected() bool { return p.connected }
+func (p *benchProvider) Disconnect() error { p.connected = false; return nil }
+
+func (p *benchProvider) Connect() error {
+	if p.connectErr != nil {
+		return p.connectErr
+	}
+	p.connected = true
+	return nil
+}
+
+func (p *benchProvider) GetConnectionInfo() (*providers.ConnectionInfo, error) {
+	return &providers.ConnectionInfo{TunnelURL: "tcp://" + p.endpoint}, nil
+}
+
+func (p *benchProvider) HealthCheck() (*providers.HealthStatus, error) {
+	return &providers.HealthStatus{Healthy: true}, nil
+}
+
+func TestBenchmarkProvider(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	opts := BenchmarkOptions{Samples: 3, Interval: time.Millisecond}
+	up := &benchProvider{name: "bore", endpoint: ln.Addr().String()}
+	res := BenchmarkProvider(context.Background(), up, opts)
+	if !res.OK() || res.Samples != 3 || res.Latency <= 0 || res.MinLatency > res.Latency || res.MaxLatency < res.Latency {
+		t.Errorf("result = %+v", res)
+	}
+	if up.connected {
+		t.Error("provider left connected after the benchmark")
+	}
+
+	// One that was already up is measured but left up
+	up.connected = true
+	res = BenchmarkProvider(context.Background(), up, opts)
+	if !res.OK() || !res.AlreadyConnected || res.ConnectTime != 0 || !up.connected {
+		t.Errorf("already connected: %+v", res)
+	}
+
+	down := &benchProvider{name: "ngrok", connectErr: errors.New("no authtoken")}
+	failed := BenchmarkProvider(context.Background(), down, opts)
+	if failed.OK() || failed.Error != "no authtoken" {
+		t.Errorf("failed connect: %+v", failed)
+	}
+
+	results := []BenchmarkResult{
+		failed,
+		{Provider: "slow", Latency: 90 * time.Millisecond, Samples: 3},
+		{Provider: "fast", Latency: 20 * time.Millisecond, Samples: 3, ConnectTime: time.Second},
+		{Provider: "fast-connect", Latency: 20 * time.Millisecond, Samples: 3, ConnectTime: time.Millisecond},
+	}
+	RankBenchmarks(results)
+	var order []string
+	for _, r := range results {
+		order = append(order, r.Provider)
+	}
+	want := []string{"fast-connect", "fast", "slow", "ngrok"}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("ranking = %v, want %v", order, want)
+		}
+	}
+}
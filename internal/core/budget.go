@@ -0,0 +1,86 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrConnectionLimit is returned by Start when the connection budget is used up
+var ErrConnectionLimit = errors.New("connection limit reached")
+
+// ConnectionBudget reports how much of the configured connection limits is in
+// use. A zero maximum is unlimited.
+type ConnectionBudget struct {
+	Connections    int            `json:"connections"`
+	MaxConnections int            `json:"max_connections"`
+	PerProvider    map[string]int `json:"per_provider"`
+	MaxPerProvider int            `json:"max_per_provider"`
+}
+
+// Exhausted reports whether no further connection can be started
+func (b ConnectionBudget) Exhausted() bool {
+	return b.MaxConnections > 0 && b.Connections >= b.MaxConnections
+}
+
+// ProviderExhausted reports whether no further connection of provider can be
+// started, either because of its own limit or the total one
+func (b ConnectionBudget) ProviderExhausted(provider string) bool {
+	return b.Exhausted() || (b.MaxPerProvider > 0 && b.PerProvider[provider] >= b.MaxPerProvider)
+}
+
+// SetLimits changes the connection limits. Connections already running are
+// kept even if they exceed the new limits.
+func (m *DefaultConnectionManager) SetLimits(maxConnections, maxPerProvider int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.config.MaxConnections = maxConnections
+	m.config.MaxPerProvider = maxPerProvider
+}
+
+// Budget returns the connections in use against the configured limits
+func (m *DefaultConnectionManager) Budget() ConnectionBudget {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.budgetLocked()
+}
+
+// budgetLocked counts running connections and starts in progress; m.mu must
+// be held
+func (m *DefaultConnectionManager) budgetLocked() ConnectionBudget {
+	b := ConnectionBudget{
+		MaxConnections: m.config.MaxConnections,
+		MaxPerProvider: m.config.MaxPerProvider,
+		PerProvider:    make(map[string]int),
+	}
+	for _, conn := range m.connections {
+		b.Connections++
+		b.PerProvider[conn.Method]++
+	}
+	for method, n := range m.starting {
+		b.Connections += n
+		b.PerProvider[method] += n
+	}
+	return b
+}
+
+// reserve claims a slot for a connection of method, failing if the budget
+// is used up; m.mu must be held. The slot is given back by release once the
+// connection is registered or has failed.
+func (m *DefaultConnectionManager) reserve(method string) error {
+	b := m.budgetLocked()
+	if b.Exhausted() {
+		return fmt.Errorf("%w: %d of %d connections in use", ErrConnectionLimit, b.Connections, b.MaxConnections)
+	}
+	if b.ProviderExhausted(method) {
+		return fmt.Errorf("%w: %d of %d %s connections in use", ErrConnectionLimit, b.PerProvider[method], b.MaxPerProvider, method)
+	}
+	m.starting[method]++
+	return nil
+}
+
+// release gives back a slot claimed by reserve; m.mu must be held
+func (m *DefaultConnectionManager) release(method string) {
+	if m.starting[method]--; m.starting[method] <= 0 {
+		delete(m.starting, method)
+	}
+}
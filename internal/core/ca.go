@@ -0,0 +1,260 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrNoCA is returned when the certificate authority has no key yet
+var ErrNoCA = errors.New("no certificate authority; create one with `tunnel keys ca init`")
+
+// certClockSkew backdates certificates so a client whose clock runs a
+// little behind the server's can use them straight away
+const certClockSkew = 5 * time.Minute
+
+// The certificate extensions sshd knows, granted unless a restriction
+// takes them away
+const (
+	permitPTY             = "permit-pty"
+	permitPortForwarding  = "permit-port-forwarding"
+	permitX11Forwarding   = "permit-X11-forwarding"
+	permitAgentForwarding = "permit-agent-forwarding"
+	permitUserRC          = "permit-user-rc"
+)
+
+// CAManager signs users' public keys with a certificate authority key, an
+// alternative to listing every key in authorized_keys: sshd accepts any
+// key carrying a valid certificate from a CA in its TrustedUserCAKeys.
+type CAManager struct {
+	keyPath     string // private key; the public key is next to it in keyPath.pub
+	auditLogger *AuditLogger
+}
+
+// NewCAManager returns a manager for the CA key at keyPath, which need not
+// exist yet
+func NewCAManager(keyPath string, auditLogger *AuditLogger) *CAManager {
+	return &CAManager{keyPath: keyPath, auditLogger: auditLogger}
+}
+
+// KeyPath returns where the CA's private key is kept
+func (ca *CAManager) KeyPath() string {
+	return ca.keyPath
+}
+
+// Init generates the CA key. An existing key is never replaced: every
+// certificate it signed would stop working.
+func (ca *CAManager) Init(comment string) (*SSHPublicKey, error) {
+	if _, err := os.Stat(ca.keyPath); err == nil {
+		return nil, fmt.Errorf("certificate authority already exists at %s", ca.keyPath)
+	}
+	key, err := GenerateIdentityKey(ca.keyPath, comment)
+	if err != nil {
+		return nil, err
+	}
+	if ca.auditLogger != nil {
+		_ = ca.auditLogger.LogKeyOperation("ca_created", "", true, map[string]interface{}{
+			"fingerprint": key.Fingerprint,
+			"path":        ca.keyPath,
+		})
+	}
+	return key, nil
+}
+
+// PublicKey returns the CA's public key, the line sshd's TrustedUserCAKeys
+// file holds
+func (ca *CAManager) PublicKey() (*SSHPublicKey, error) {
+	data, err := os.ReadFile(ca.keyPath + ".pub")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoCA
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read CA public key: %w", err)
+	}
+	return ParseSSHPublicKey(strings.TrimSpace(string(data)))
+}
+
+// WriteTrustedKeys writes the CA's public key to path, the file named by
+// sshd's TrustedUserCAKeys
+func (ca *CAManager) WriteTrustedKeys(path string) error {
+	key, err := ca.PublicKey()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create %s: %w", filepath.Dir(path), err)
+	}
+	content := "# Managed by TUNNEL - user certificate authority\n" + key.PublicKey + "\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return fmt.Errorf("write trusted CA keys: %w", err)
+	}
+	return nil
+}
+
+// TrustedUserCAConfig renders the sshd_config drop-in trusting the CA
+// keys in trustedKeysPath
+func TrustedUserCAConfig(trustedKeysPath string) string {
+	var b strings.Builder
+	b.WriteString("# Managed by TUNNEL - user certificate authority. Run\n")
+	b.WriteString("# `tunnel keys ca trust` instead of changing this file.\n\n")
+	fmt.Fprintf(&b, "TrustedUserCAKeys %s\n", trustedKeysPath)
+	return b.String()
+}
+
+// CertRequest is what a certificate should say about the key it signs
+type CertRequest struct {
+	PublicKey       string   // authorized_keys line of the key to sign
+	KeyID           string   // shown in sshd's logs; defaults to the first principal
+	Principals      []string // users the certificate may log in as
+	ValidFor        time.Duration
+	Restrictions    KeyRestrictions
+	AgentForwarding bool
+}
+
+// SignedCert is a certificate issued by the CA
+type SignedCert struct {
+	Certificate string    `json:"certificate"` // the -cert.pub line
+	Serial      uint64    `json:"serial"`
+	KeyID       string    `json:"key_id"`
+	Principals  []string  `json:"principals"`
+	Fingerprint string    `json:"fingerprint"` // of the signed key
+	ValidAfter  time.Time `json:"valid_after"`
+	ValidBefore time.Time `json:"valid_before"`
+}
+
+// Sign issues a user certificate for req.PublicKey, valid from now (less a
+// few minutes of clock skew) for req.ValidFor. Restrictions become the
+// certificate's critical options and extensions; a certificate can only
+// limit the source to addresses and CIDR blocks.
+func (ca *CAManager) Sign(req CertRequest) (*SignedCert, error) {
+	if len(req.Principals) == 0 {
+		return nil, fmt.Errorf("a certificate needs at least one principal")
+	}
+	if req.ValidFor <= 0 {
+		return nil, fmt.Errorf("invalid validity: %s", req.ValidFor)
+	}
+	pub, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(req.PublicKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	if _, ok := pub.(*ssh.Certificate); ok {
+		return nil, fmt.Errorf("invalid public key: already a certificate")
+	}
+	perms, err := certPermissions(req.Restrictions, req.AgentForwarding)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(ca.keyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoCA
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read CA key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse CA key: %w", err)
+	}
+
+	var serial [8]byte
+	if _, err := rand.Read(serial[:]); err != nil {
+		return nil, fmt.Errorf("generate serial: %w", err)
+	}
+	keyID := req.KeyID
+	if keyID == "" {
+		keyID = req.Principals[0]
+		if comment != "" {
+			keyID += " (" + comment + ")"
+		}
+	}
+	now := time.Now()
+	validAfter := now.Add(-certClockSkew).Truncate(time.Second)
+	validBefore := now.Add(req.ValidFor).Truncate(time.Second)
+
+	cert := &ssh.Certificate{
+		Key:             pub,
+		Serial:          binary.BigEndian.Uint64(serial[:]),
+		CertType:        ssh.UserCert,
+		KeyId:           keyID,
+		ValidPrincipals: req.Principals,
+		ValidAfter:      uint64(validAfter.Unix()),
+		ValidBefore:     uint64(validBefore.Unix()),
+		Permissions:     perms,
+	}
+	if err := cert.SignCert(rand.Reader, signer); err != nil {
+		return nil, fmt.Errorf("sign certificate: %w", err)
+	}
+
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert)))
+	if comment != "" {
+		line += " " + comment
+	}
+	signed := &SignedCert{
+		Certificate: line,
+		Serial:      cert.Serial,
+		KeyID:       keyID,
+		Principals:  req.Principals,
+		Fingerprint: ssh.FingerprintSHA256(pub),
+		ValidAfter:  validAfter,
+		ValidBefore: validBefore,
+	}
+
+	if ca.auditLogger != nil {
+		_ = ca.auditLogger.LogKeyOperation("cert_signed", strings.Join(req.Principals, ","), true, map[string]interface{}{
+			"serial":       strconv.FormatUint(signed.Serial, 10),
+			"key_id":       signed.KeyID,
+			"fingerprint":  signed.Fingerprint,
+			"valid_before": signed.ValidBefore,
+		})
+	}
+	return signed, nil
+}
+
+// certPermissions turns key restrictions into certificate critical
+// options and extensions
+func certPermissions(r KeyRestrictions, agentForwarding bool) (ssh.Permissions, error) {
+	if err := r.Validate(); err != nil {
+		return ssh.Permissions{}, err
+	}
+	perms := ssh.Permissions{
+		CriticalOptions: map[string]string{},
+		Extensions:      map[string]string{permitUserRC: ""},
+	}
+	if r.Command != "" {
+		perms.CriticalOptions["force-command"] = r.Command
+	}
+	if len(r.From) > 0 {
+		for _, addr := range r.From {
+			if _, _, err := net.ParseCIDR(addr); err == nil {
+				continue
+			}
+			if net.ParseIP(addr) == nil {
+				return ssh.Permissions{}, fmt.Errorf("invalid from %q: certificates only take addresses and CIDR blocks", addr)
+			}
+		}
+		perms.CriticalOptions["source-address"] = strings.Join(r.From, ",")
+	}
+	if !r.NoPTY {
+		perms.Extensions[permitPTY] = ""
+	}
+	if !r.NoPortForwarding {
+		perms.Extensions[permitPortForwarding] = ""
+	}
+	if !r.NoX11Forwarding {
+		perms.Extensions[permitX11Forwarding] = ""
+	}
+	if agentForwarding {
+		perms.Extensions[permitAgentForwarding] = ""
+	}
+	return perms, nil
+}
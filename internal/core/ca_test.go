@@ -0,0 +1,88 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// TestCAManagerSign tests signing a key and sshd-style checking of the
+// certificate
+func TestCAManagerSign(t *testing.T) {
+	dir := t.TempDir()
+	ca := NewCAManager(filepath.Join(dir, "ca", "user_ca"), nil)
+
+	if _, err := ca.Sign(CertRequest{PublicKey: testED25519Key, Principals: []string{"alice"}, ValidFor: time.Hour}); err != ErrNoCA {
+		t.Fatalf("Sign without a CA = %v, want ErrNoCA", err)
+	}
+	caKey, err := ca.Init("tunnel-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ca.Init("tunnel-ca"); err == nil {
+		t.Error("Init replaced an existing CA")
+	}
+
+	signed, err := ca.Sign(CertRequest{
+		PublicKey:    testED25519Key,
+		Principals:   []string{"alice", "deploy"},
+		ValidFor:     8 * time.Hour,
+		Restrictions: KeyRestrictions{Command: "uptime", From: []string{"10.0.0.0/8"}, NoPTY: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(signed.Certificate))
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		t.Fatalf("signed key is a %T, want a certificate", pub)
+	}
+	if ssh.FingerprintSHA256(cert.SignatureKey) != caKey.Fingerprint {
+		t.Error("certificate not signed by the CA")
+	}
+	if cert.Permissions.CriticalOptions["force-command"] != "uptime" || cert.Permissions.CriticalOptions["source-address"] != "10.0.0.0/8" {
+		t.Errorf("critical options = %v", cert.Permissions.CriticalOptions)
+	}
+	if _, ok := cert.Permissions.Extensions[permitPTY]; ok {
+		t.Error("no-pty certificate permits a pty")
+	}
+	if _, ok := cert.Permissions.Extensions[permitAgentForwarding]; ok {
+		t.Error("certificate permits agent forwarding without being asked to")
+	}
+
+	checker := ssh.CertChecker{
+		SupportedCriticalOptions: []string{"force-command", "source-address"},
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			return ssh.FingerprintSHA256(auth) == caKey.Fingerprint
+		},
+	}
+	if err := checker.CheckCert("deploy", cert); err != nil {
+		t.Errorf("sshd would refuse deploy: %v", err)
+	}
+	if err := checker.CheckCert("root", cert); err == nil {
+		t.Error("certificate accepted for a principal it doesn't name")
+	}
+
+	// Certificates can't carry host name patterns
+	if _, err := ca.Sign(CertRequest{PublicKey: testED25519Key, Principals: []string{"alice"}, ValidFor: time.Hour,
+		Restrictions: KeyRestrictions{From: []string{"*.example.com"}}}); err == nil {
+		t.Error("expected an error for a host name pattern in from")
+	}
+
+	trusted := filepath.Join(dir, "trusted_user_ca_keys")
+	if err := ca.WriteTrustedKeys(trusted); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(trusted)
+	if !strings.Contains(string(data), caKey.PublicKey) {
+		t.Errorf("trusted keys file = %q, want the CA key", data)
+	}
+}
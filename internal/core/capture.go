@@ -0,0 +1,237 @@
+package core
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultCaptureLimit caps the size of a capture file
+const DefaultCaptureLimit = 16 << 20
+
+// pcap framing: the file header, and per packet a record header, an IPv4
+// header and a TCP header
+const (
+	pcapMagic        = 0xa1b2c3d4
+	pcapLinkTypeRaw  = 101 // packets start at the IP header
+	pcapSnapLen      = 65535
+	pcapRecordHeader = 16
+	ipv4HeaderLen    = 20
+	tcpHeaderLen     = 20
+	maxSegment       = pcapSnapLen - ipv4HeaderLen - tcpHeaderLen
+)
+
+// ErrCaptureRunning is returned when a relay is already capturing
+var ErrCaptureRunning = errors.New("a capture is already running")
+
+// CaptureStatus describes a capture in progress or finished
+type CaptureStatus struct {
+	Path      string    `json:"path"`
+	Started   time.Time `json:"started"`
+	Packets   int       `json:"packets"`
+	Bytes     int64     `json:"bytes"`     // size of the file so far
+	Truncated bool      `json:"truncated"` // the size limit was reached
+}
+
+// Capture writes the traffic a relay passes to a pcap file that Wireshark
+// or tcpdump can read. The relay sees the payload, not the packets that
+// carried it, so each read becomes one TCP segment between the tunnel
+// client and the local service; sequence numbers follow the payload, which
+// is enough to follow a stream. Recording stops once the file reaches its
+// size limit.
+type Capture struct {
+	mu        sync.Mutex
+	file      *os.File
+	w         *bufio.Writer
+	status    CaptureStatus
+	limit     int64
+	flows     map[string]*captureFlow
+	packetID  uint16
+	closed    bool
+	closedErr error
+}
+
+// captureFlow is the next sequence number of each side of a connection
+type captureFlow struct {
+	clientSeq, serverSeq uint32
+}
+
+// NewCapture creates the pcap file at path, capped at limit bytes; a limit
+// of 0 uses DefaultCaptureLimit
+func NewCapture(path string, limit int64) (*Capture, error) {
+	if limit <= 0 {
+		limit = DefaultCaptureLimit
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create capture: %w", err)
+	}
+
+	c := &Capture{
+		file:   file,
+		w:      bufio.NewWriter(file),
+		status: CaptureStatus{Path: path, Started: time.Now()},
+		limit:  limit,
+		flows:  make(map[string]*captureFlow),
+	}
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:], pcapMagic)
+	binary.LittleEndian.PutUint16(header[4:], 2) // version 2.4
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], pcapSnapLen)
+	binary.LittleEndian.PutUint32(header[20:], pcapLinkTypeRaw)
+	if _, err := c.w.Write(header); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write capture: %w", err)
+	}
+	c.status.Bytes = int64(len(header))
+	return c, nil
+}
+
+// Record adds data sent from the client to the server, or back when
+// fromClient is false
+func (c *Capture) Record(client, server *net.TCPAddr, fromClient bool, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || c.status.Truncated {
+		return
+	}
+
+	key := client.String() + "-" + server.String()
+	flow := c.flows[key]
+	if flow == nil {
+		flow = &captureFlow{clientSeq: 1, serverSeq: 1}
+		c.flows[key] = flow
+	}
+
+	now := time.Now()
+	for len(data) > 0 {
+		segment := data
+		if len(segment) > maxSegment {
+			segment = segment[:maxSegment]
+		}
+		data = data[len(segment):]
+
+		size := int64(pcapRecordHeader + ipv4HeaderLen + tcpHeaderLen + len(segment))
+		if c.status.Bytes+size > c.limit {
+			c.status.Truncated = true
+			return
+		}
+
+		var packet []byte
+		if fromClient {
+			packet = c.packet(client, server, flow.clientSeq, flow.serverSeq, segment)
+			flow.clientSeq += uint32(len(segment))
+		} else {
+			packet = c.packet(server, client, flow.serverSeq, flow.clientSeq, segment)
+			flow.serverSeq += uint32(len(segment))
+		}
+
+		record := make([]byte, pcapRecordHeader)
+		binary.LittleEndian.PutUint32(record[0:], uint32(now.Unix()))
+		binary.LittleEndian.PutUint32(record[4:], uint32(now.Nanosecond()/1000))
+		binary.LittleEndian.PutUint32(record[8:], uint32(len(packet)))
+		binary.LittleEndian.PutUint32(record[12:], uint32(len(packet)))
+		if _, err := c.w.Write(record); err != nil {
+			c.status.Truncated = true
+			return
+		}
+		if _, err := c.w.Write(packet); err != nil {
+			c.status.Truncated = true
+			return
+		}
+		c.status.Bytes += size
+		c.status.Packets++
+	}
+}
+
+// packet builds an IPv4 TCP segment carrying payload from src to dst
+func (c *Capture) packet(src, dst *net.TCPAddr, seq, ack uint32, payload []byte) []byte {
+	srcIP, dstIP := captureIP(src.IP), captureIP(dst.IP)
+	total := ipv4HeaderLen + tcpHeaderLen + len(payload)
+	p := make([]byte, total)
+
+	ip := p[:ipv4HeaderLen]
+	ip[0] = 0x45 // version 4, 5 words of header
+	binary.BigEndian.PutUint16(ip[2:], uint16(total))
+	c.packetID++
+	binary.BigEndian.PutUint16(ip[4:], c.packetID)
+	binary.BigEndian.PutUint16(ip[6:], 0x4000) // don't fragment
+	ip[8] = 64                                 // TTL
+	ip[9] = 6                                  // TCP
+	copy(ip[12:16], srcIP)
+	copy(ip[16:20], dstIP)
+	binary.BigEndian.PutUint16(ip[10:], checksum(ip, 0))
+
+	tcp := p[ipv4HeaderLen:]
+	binary.BigEndian.PutUint16(tcp[0:], uint16(src.Port))
+	binary.BigEndian.PutUint16(tcp[2:], uint16(dst.Port))
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	binary.BigEndian.PutUint32(tcp[8:], ack)
+	tcp[12] = tcpHeaderLen / 4 << 4
+	tcp[13] = 0x18 // PSH, ACK
+	binary.BigEndian.PutUint16(tcp[14:], 65535)
+	copy(tcp[tcpHeaderLen:], payload)
+
+	// The TCP checksum covers a pseudo header of the addresses, protocol
+	// and segment length
+	var pseudo uint32
+	for i := 0; i < 4; i += 2 {
+		pseudo += uint32(binary.BigEndian.Uint16(srcIP[i:])) + uint32(binary.BigEndian.Uint16(dstIP[i:]))
+	}
+	pseudo += 6 + uint32(len(tcp))
+	binary.BigEndian.PutUint16(tcp[16:], checksum(tcp, pseudo))
+	return p
+}
+
+// captureIP is ip as four bytes; addresses that are not IPv4 show as
+// loopback, which is where the relay runs
+func captureIP(ip net.IP) net.IP {
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+	return net.IPv4(127, 0, 0, 1).To4()
+}
+
+// checksum is the Internet checksum of b, starting from sum
+func checksum(b []byte, sum uint32) uint16 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+// Status returns what the capture has recorded so far
+func (c *Capture) Status() CaptureStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status
+}
+
+// Close flushes the capture to its file; it is safe to call more than once
+func (c *Capture) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return c.closedErr
+	}
+	c.closed = true
+	if err := c.w.Flush(); err != nil {
+		c.file.Close()
+		c.closedErr = fmt.Errorf("failed to write capture: %w", err)
+		return c.closedErr
+	}
+	c.closedErr = c.file.Close()
+	return c.closedErr
+}
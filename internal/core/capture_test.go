@@ -0,0 +1,80 @@
+package core
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCapture(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bore.pcap")
+	c, err := NewCapture(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50123}
+	server := &net.TCPAddr{IP: net.IPv6loopback, Port: 22}
+	c.Record(client, server, true, []byte("SSH-2.0-OpenSSH_9.6\r\n"))
+	c.Record(client, server, false, []byte("SSH-2.0-tunnel\r\n"))
+	c.Record(client, server, true, []byte("more"))
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if binary.LittleEndian.Uint32(data) != pcapMagic || binary.LittleEndian.Uint32(data[20:]) != pcapLinkTypeRaw {
+		t.Fatalf("bad pcap header % x", data[:24])
+	}
+	status := c.Status()
+	if status.Packets != 3 || status.Bytes != int64(len(data)) || status.Truncated {
+		t.Errorf("status = %+v, file has %d bytes", status, len(data))
+	}
+
+	// Walk the packets: valid IP headers, ports by direction, and sequence
+	// numbers following the payload
+	var seqs []uint32
+	for off := 24; off < len(data); {
+		n := int(binary.LittleEndian.Uint32(data[off+8:]))
+		packet := data[off+pcapRecordHeader : off+pcapRecordHeader+n]
+		off += pcapRecordHeader + n
+
+		if checksum(packet[:ipv4HeaderLen], 0) != 0 {
+			t.Errorf("bad IP checksum in packet %d", len(seqs))
+		}
+		tcp := packet[ipv4HeaderLen:]
+		src, dst := binary.BigEndian.Uint16(tcp), binary.BigEndian.Uint16(tcp[2:])
+		if (src != 50123 || dst != 22) && (src != 22 || dst != 50123) {
+			t.Errorf("packet %d ports %d → %d", len(seqs), src, dst)
+		}
+		seqs = append(seqs, binary.BigEndian.Uint32(tcp[4:]))
+	}
+	if len(seqs) != 3 || seqs[0] != 1 || seqs[1] != 1 || seqs[2] != 1+21 {
+		t.Errorf("sequence numbers %v", seqs)
+	}
+}
+
+func TestCaptureLimit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capped.pcap")
+	c, err := NewCapture(path, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	client := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50123}
+	server := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 22}
+	for i := 0; i < 10; i++ {
+		c.Record(client, server, true, make([]byte, 50))
+	}
+	status := c.Status()
+	if !status.Truncated || status.Bytes > 200 || status.Packets != 1 {
+		t.Errorf("status = %+v", status)
+	}
+	if _, err := NewCapture(path, 0); err == nil {
+		t.Error("an existing capture should not be overwritten")
+	}
+}
@@ -57,6 +57,16 @@ func (m *ConnectionMetrics) Update(sent, received int64, latency time.Duration)
 	m.LastActive = time.Now()
 }
 
+// AddTraffic counts bytes relayed through a connection, leaving the
+// measured latency as it is
+func (m *ConnectionMetrics) AddTraffic(sent, received int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.BytesSent += sent
+	m.BytesReceived += received
+	m.LastActive = time.Now()
+}
+
 // GetLatency safely retrieves latency
 func (m *ConnectionMetrics) GetLatency() time.Duration {
 	m.mu.RLock()
@@ -79,22 +89,36 @@ func (m *ConnectionMetrics) GetStats() (sent, received int64, latency time.Durat
 	return m.BytesSent, m.BytesReceived, m.Latency
 }
 
+// maxStateHistory bounds how many state transitions a connection remembers
+const maxStateHistory = 100
+
+// StateTransition records a connection entering a state
+type StateTransition struct {
+	State ConnectionState
+	At    time.Time
+}
+
 // Connection represents a single SSH tunnel connection
 type Connection struct {
-	mu         sync.RWMutex
-	ID         string
-	Method     string // Provider name (e.g., "cloudflare", "tailscale", "ngrok")
-	State      ConnectionState
-	LocalPort  int
-	RemoteHost string
-	RemotePort int
-	StartedAt  time.Time
-	PID        int // Process ID of the tunnel process
-	Metrics    *ConnectionMetrics
-	Priority   int           // For failover ordering (lower = higher priority)
-	IsPrimary  bool          // Is this the primary connection
-	Config     interface{}   // Provider-specific configuration
-	cancel     chan struct{} // For cancellation
+	mu          sync.RWMutex
+	ID          string
+	Method      string // Provider name (e.g., "cloudflare", "tailscale", "ngrok")
+	State       ConnectionState
+	LocalPort   int
+	RemoteHost  string
+	RemotePort  int
+	URL         string // Public address, for providers that publish one
+	StartedAt   time.Time
+	PID         int // Process ID of the tunnel process
+	Metrics     *ConnectionMetrics
+	Priority    int           // For failover ordering (lower = higher priority)
+	IsPrimary   bool          // Is this the primary connection
+	Config      interface{}   // Provider-specific configuration
+	OperationID string        // Connect operation that established the connection
+	cancel      chan struct{} // For cancellation
+
+	history []StateTransition // oldest first, at most maxStateHistory
+	uptime  time.Duration     // time spent connected in earlier sessions
 }
 
 // NewConnection creates a new connection instance
@@ -118,11 +142,88 @@ func (c *Connection) GetState() ConnectionState {
 	return c.State
 }
 
-// SetState safely updates the connection state
+// SetState safely updates the connection state, recording the transition in
+// the connection's history
 func (c *Connection) SetState(state ConnectionState) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	if state == c.State {
+		return
+	}
+
+	now := time.Now()
+	if c.State == StateConnected && !c.StartedAt.IsZero() {
+		c.uptime += now.Sub(c.StartedAt)
+	}
+	if state == StateConnected {
+		c.StartedAt = now
+	}
+
 	c.State = state
+	c.history = append(c.history, StateTransition{State: state, At: now})
+	if len(c.history) > maxStateHistory {
+		c.history = c.history[len(c.history)-maxStateHistory:]
+	}
+}
+
+// StateHistory returns the connection's recorded state transitions, oldest
+// first
+func (c *Connection) StateHistory() []StateTransition {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]StateTransition(nil), c.history...)
+}
+
+// TotalUptime returns the time spent connected across every reconnect,
+// whereas GetUptime only covers the current session
+func (c *Connection) TotalUptime() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	total := c.uptime
+	if c.State == StateConnected && !c.StartedAt.IsZero() {
+		total += time.Since(c.StartedAt)
+	}
+	return total
+}
+
+// inheritHistory prepends the history and accumulated uptime of prev, the
+// connection this one replaces after a restart
+func (c *Connection) inheritHistory(prev *Connection) {
+	prev.mu.RLock()
+	history := append([]StateTransition(nil), prev.history...)
+	uptime := prev.uptime
+	prev.mu.RUnlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.history = append(history, c.history...)
+	if len(c.history) > maxStateHistory {
+		c.history = c.history[len(c.history)-maxStateHistory:]
+	}
+	c.uptime += uptime
+}
+
+// SetEndpoint safely updates the addresses the connection is reachable at,
+// reporting whether any of them changed. Zero values leave the current value
+// in place, since providers often only learn part of the endpoint.
+func (c *Connection) SetEndpoint(localPort int, remoteHost string, remotePort int, url string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	changed := false
+	if localPort != 0 && localPort != c.LocalPort {
+		c.LocalPort, changed = localPort, true
+	}
+	if remoteHost != "" && remoteHost != c.RemoteHost {
+		c.RemoteHost, changed = remoteHost, true
+	}
+	if remotePort != 0 && remotePort != c.RemotePort {
+		c.RemotePort, changed = remotePort, true
+	}
+	if url != "" && url != c.URL {
+		c.URL, changed = url, true
+	}
+	return changed
 }
 
 // GetPriority safely retrieves the connection priority
@@ -181,21 +282,25 @@ func (c *Connection) Clone() *Connection {
 	sent, received, latency := c.Metrics.GetStats()
 
 	return &Connection{
-		ID:         c.ID,
-		Method:     c.Method,
-		State:      c.State,
-		LocalPort:  c.LocalPort,
-		RemoteHost: c.RemoteHost,
-		RemotePort: c.RemotePort,
-		StartedAt:  c.StartedAt,
-		PID:        c.PID,
-		Priority:   c.Priority,
-		IsPrimary:  c.IsPrimary,
+		ID:          c.ID,
+		Method:      c.Method,
+		State:       c.State,
+		LocalPort:   c.LocalPort,
+		RemoteHost:  c.RemoteHost,
+		RemotePort:  c.RemotePort,
+		URL:         c.URL,
+		StartedAt:   c.StartedAt,
+		PID:         c.PID,
+		Priority:    c.Priority,
+		IsPrimary:   c.IsPrimary,
+		OperationID: c.OperationID,
 		Metrics: &ConnectionMetrics{
 			BytesSent:     sent,
 			BytesReceived: received,
 			Latency:       latency,
 		},
+		history: append([]StateTransition(nil), c.history...),
+		uptime:  c.uptime,
 	}
 }
 
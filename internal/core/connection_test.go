@@ -91,6 +91,66 @@ func TestSetState(t *testing.T) {
 	}
 }
 
+func TestStateHistory(t *testing.T) {
+	conn := NewConnection("test-id", "mock", 8080, "localhost", 22)
+
+	conn.SetState(StateConnecting)
+	conn.SetState(StateConnected)
+	conn.SetState(StateConnected) // no transition
+	conn.SetState(StateFailed)
+
+	history := conn.StateHistory()
+	want := []ConnectionState{StateConnecting, StateConnected, StateFailed}
+	if len(history) != len(want) {
+		t.Fatalf("Expected %d transitions, got %d", len(want), len(history))
+	}
+	for i, state := range want {
+		if history[i].State != state {
+			t.Errorf("Transition %d: expected %s, got %s", i, state, history[i].State)
+		}
+		if i > 0 && history[i].At.Before(history[i-1].At) {
+			t.Errorf("Transition %d recorded out of order", i)
+		}
+	}
+
+	// The ring keeps only the most recent transitions
+	for i := 0; i < maxStateHistory; i++ {
+		conn.SetState(StateConnected)
+		conn.SetState(StateReconnecting)
+	}
+	history = conn.StateHistory()
+	if len(history) != maxStateHistory {
+		t.Errorf("Expected history capped at %d, got %d", maxStateHistory, len(history))
+	}
+	if last := history[len(history)-1].State; last != StateReconnecting {
+		t.Errorf("Expected newest transition Reconnecting, got %s", last)
+	}
+}
+
+func TestTotalUptime(t *testing.T) {
+	conn := NewConnection("test-id", "mock", 8080, "localhost", 22)
+
+	conn.SetState(StateConnected)
+	conn.StartedAt = time.Now().Add(-3 * time.Second)
+	conn.SetState(StateDisconnected)
+
+	if uptime := conn.GetUptime(); uptime != 0 {
+		t.Errorf("Expected session uptime 0 while disconnected, got %v", uptime)
+	}
+	total := conn.TotalUptime()
+	if total < 3*time.Second || total > 4*time.Second {
+		t.Errorf("Expected total uptime around 3 seconds, got %v", total)
+	}
+
+	// A new session adds to the earlier one
+	conn.SetState(StateConnected)
+	conn.StartedAt = time.Now().Add(-2 * time.Second)
+	total = conn.TotalUptime()
+	if total < 5*time.Second || total > 6*time.Second {
+		t.Errorf("Expected total uptime around 5 seconds, got %v", total)
+	}
+}
+
 func TestGetPriority(t *testing.T) {
 	conn := NewConnection("test-id", "mock", 8080, "localhost", 22)
 
@@ -156,6 +216,29 @@ func TestSetPrimaryConnection(t *testing.T) {
 	}
 }
 
+func TestSetEndpoint(t *testing.T) {
+	conn := NewConnection("test-id", "bore", 0, "", 0)
+
+	if !conn.SetEndpoint(22, "bore.pub", 4000, "bore.pub:4000") {
+		t.Error("Expected first SetEndpoint to report a change")
+	}
+	if conn.LocalPort != 22 || conn.RemoteHost != "bore.pub" || conn.RemotePort != 4000 || conn.URL != "bore.pub:4000" {
+		t.Errorf("Unexpected endpoint %d %s:%d %s", conn.LocalPort, conn.RemoteHost, conn.RemotePort, conn.URL)
+	}
+
+	if conn.SetEndpoint(22, "bore.pub", 4000, "bore.pub:4000") {
+		t.Error("Expected unchanged endpoint to report no change")
+	}
+
+	// Partial updates keep what is already known
+	if !conn.SetEndpoint(0, "", 4001, "") {
+		t.Error("Expected new remote port to report a change")
+	}
+	if conn.RemoteHost != "bore.pub" || conn.RemotePort != 4001 {
+		t.Errorf("Expected bore.pub:4001, got %s:%d", conn.RemoteHost, conn.RemotePort)
+	}
+}
+
 func TestGetUptime(t *testing.T) {
 	conn := NewConnection("test-id", "mock", 8080, "localhost", 22)
 
@@ -204,6 +287,7 @@ func TestClone(t *testing.T) {
 	original.PID = 12345
 	original.Priority = 5
 	original.IsPrimary = true
+	original.URL = "tcp://0.tcp.ngrok.io:12345"
 
 	// Set some metrics
 	original.Metrics.Update(1000, 2000, 100*time.Millisecond)
@@ -239,6 +323,10 @@ func TestClone(t *testing.T) {
 		t.Errorf("Expected RemotePort %d, got %d", original.RemotePort, clone.RemotePort)
 	}
 
+	if clone.URL != original.URL {
+		t.Errorf("Expected URL '%s', got '%s'", original.URL, clone.URL)
+	}
+
 	if clone.PID != original.PID {
 		t.Errorf("Expected PID %d, got %d", original.PID, clone.PID)
 	}
@@ -22,8 +22,14 @@ var (
 	ErrCredentialNotFound = errors.New("credential not found")
 	ErrInvalidCredential  = errors.New("invalid credential")
 	ErrStoreUnavailable   = errors.New("credential store unavailable")
+	ErrNoPassphrase       = errors.New("a passphrase is needed to encrypt credentials in a file")
+	ErrWrongPassphrase    = errors.New("wrong passphrase for the credential store")
 )
 
+// ProviderCredential is the key a provider's API key or auth token is
+// stored under, with the provider's name as the service
+const ProviderCredential = "api_key"
+
 // CredentialStore defines the interface for storing and retrieving credentials
 type CredentialStore interface {
 	Set(service, key string, value []byte) error
@@ -83,8 +89,12 @@ type FileStore struct {
 	passphrase string
 }
 
-// NewFileStore creates a new file-based credential store
+// NewFileStore creates a new file-based credential store, its contents
+// encrypted with AES-GCM under a key derived from passphrase
 func NewFileStore(baseDir, passphrase string) (*FileStore, error) {
+	if passphrase == "" {
+		return nil, ErrNoPassphrase
+	}
 	if err := os.MkdirAll(baseDir, 0700); err != nil {
 		return nil, fmt.Errorf("create credential directory: %w", err)
 	}
@@ -162,7 +172,7 @@ func (f *FileStore) decrypt(data []byte) ([]byte, error) {
 
 	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
 	if err != nil {
-		return nil, fmt.Errorf("decrypt: %w", err)
+		return nil, ErrWrongPassphrase
 	}
 
 	return plaintext, nil
@@ -418,3 +428,76 @@ func NewCredentialStore(storeType, serviceName, baseDir, passphrase string) (Cre
 		return nil, fmt.Errorf("unsupported credential store type: %s", storeType)
 	}
 }
+
+// ProviderToken returns the API key or auth token stored for provider, or
+// "" when none is
+func ProviderToken(store CredentialStore, provider string) (string, error) {
+	value, err := store.Get(provider, ProviderCredential)
+	if errors.Is(err, ErrCredentialNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+// MoveTo moves every credential f's passphrase decrypts into to, such as
+// credentials encrypted under an old passphrase into a store with a new
+// one, and returns the services moved. Files f can't decrypt are left
+// alone, so running it again moves nothing.
+func (f *FileStore) MoveTo(to CredentialStore) ([]string, error) {
+	entries, err := os.ReadDir(f.baseDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read credential directory: %w", err)
+	}
+
+	var moved []string
+	for _, entry := range entries {
+		service, ok := strings.CutSuffix(entry.Name(), ".cred")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		filePath := f.getFilePath(service)
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			return moved, fmt.Errorf("read credentials: %w", err)
+		}
+		decrypted, err := f.decrypt(data)
+		if errors.Is(err, ErrWrongPassphrase) {
+			continue
+		}
+		if err != nil {
+			return moved, fmt.Errorf("decrypt credentials: %w", err)
+		}
+		credentials := make(map[string][]byte)
+		if err := json.Unmarshal(decrypted, &credentials); err != nil {
+			return moved, fmt.Errorf("unmarshal credentials: %w", err)
+		}
+
+		if dst, ok := to.(*FileStore); ok && dst.getFilePath(service) == filePath {
+			// The same file: encrypt it again under the new passphrase
+			encrypted, err := dst.encrypt(decrypted)
+			if err != nil {
+				return moved, fmt.Errorf("encrypt credentials: %w", err)
+			}
+			if err := os.WriteFile(filePath, encrypted, 0600); err != nil {
+				return moved, fmt.Errorf("write credentials: %w", err)
+			}
+		} else {
+			for key, value := range credentials {
+				if err := to.Set(service, key, value); err != nil {
+					return moved, fmt.Errorf("move %s credentials: %w", service, err)
+				}
+			}
+			if err := os.Remove(filePath); err != nil {
+				return moved, fmt.Errorf("remove moved credentials: %w", err)
+			}
+		}
+		moved = append(moved, service)
+	}
+	return moved, nil
+}
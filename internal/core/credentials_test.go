@@ -1,6 +1,7 @@
 package core
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
@@ -153,3 +154,72 @@ func TestFileStoreEncryption(t *testing.T) {
 		t.Errorf("Expected %s, got %s", value, retrieved)
 	}
 }
+
+func TestProviderToken(t *testing.T) {
+	tmpDir := t.TempDir()
+	if _, err := NewFileStore(tmpDir, ""); !errors.Is(err, ErrNoPassphrase) {
+		t.Errorf("NewFileStore without a passphrase = %v, want ErrNoPassphrase", err)
+	}
+
+	store, err := NewFileStore(tmpDir, "right")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, err := ProviderToken(store, "ngrok"); token != "" || err != nil {
+		t.Errorf("ProviderToken with nothing stored = %q, %v", token, err)
+	}
+	if err := store.Set("ngrok", ProviderCredential, []byte("2abc_token")); err != nil {
+		t.Fatal(err)
+	}
+	if token, err := ProviderToken(store, "ngrok"); token != "2abc_token" || err != nil {
+		t.Errorf("ProviderToken = %q, %v, want the stored token", token, err)
+	}
+
+	wrong, _ := NewFileStore(tmpDir, "wrong")
+	if _, err := ProviderToken(wrong, "ngrok"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("ProviderToken with the wrong passphrase = %v, want ErrWrongPassphrase", err)
+	}
+}
+
+func TestFileStoreMoveTo(t *testing.T) {
+	tmpDir := t.TempDir()
+	old, _ := NewFileStore(tmpDir, "old")
+	if err := old.Set("ngrok", ProviderCredential, []byte("2abc_token")); err != nil {
+		t.Fatal(err)
+	}
+	current, _ := NewFileStore(tmpDir, "new")
+	if err := current.Set("bore", ProviderCredential, []byte("bore_secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	moved, err := old.MoveTo(current)
+	if err != nil {
+		t.Fatalf("MoveTo() error = %v", err)
+	}
+	if len(moved) != 1 || moved[0] != "ngrok" {
+		t.Errorf("moved %v, want only ngrok", moved)
+	}
+	for service, want := range map[string]string{"ngrok": "2abc_token", "bore": "bore_secret"} {
+		if token, err := ProviderToken(current, service); token != want || err != nil {
+			t.Errorf("ProviderToken(%s) = %q, %v, want %q", service, token, err, want)
+		}
+	}
+	if moved, err := old.MoveTo(current); len(moved) != 0 || err != nil {
+		t.Errorf("moving again = %v, %v, want nothing", moved, err)
+	}
+
+	// Into another store, the old file goes
+	other, _ := NewFileStore(t.TempDir(), "other")
+	if err := current.Set("ngrok", ProviderCredential, []byte("2abc_token")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := current.MoveTo(other); err != nil {
+		t.Fatal(err)
+	}
+	if token, _ := ProviderToken(other, "ngrok"); token != "2abc_token" {
+		t.Errorf("token not moved to the other store: %q", token)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "ngrok.cred")); !os.IsNotExist(err) {
+		t.Errorf("moved credentials left behind: %v", err)
+	}
+}
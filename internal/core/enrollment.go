@@ -0,0 +1,192 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/offline"
+)
+
+// ErrInvalidEnrollmentToken is returned for unknown, used or expired verification tokens
+var ErrInvalidEnrollmentToken = errors.New("invalid or expired enrollment token")
+
+// Mailer sends plain-text email
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// SMTPMailer sends email through an SMTP server
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// Send delivers a plain-text message via SMTP
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	if err := offline.Check("sending email"); err != nil {
+		return err
+	}
+	addr := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s",
+		m.From, to, subject, body)
+
+	if err := smtp.SendMail(addr, auth, m.From, []string{to}, []byte(msg)); err != nil {
+		return fmt.Errorf("send mail: %w", err)
+	}
+	return nil
+}
+
+// pendingEnrollment is a submitted key waiting for its email to be verified
+type pendingEnrollment struct {
+	email     string
+	publicKey string
+	ttl       string
+	expiresAt time.Time
+}
+
+// EnrollmentManager lets teammates submit their own public key. A key is
+// only queued for admin approval after the submitter follows the
+// verification link emailed to them.
+type EnrollmentManager struct {
+	mu             sync.Mutex
+	queue          *ApprovalQueue
+	mailer         Mailer
+	baseURL        string
+	allowedDomains []string
+	tokenTTL       time.Duration
+	pending        map[string]*pendingEnrollment
+}
+
+// NewEnrollmentManager creates an enrollment manager that queues verified
+// keys on queue. An empty allowedDomains accepts any email address.
+func NewEnrollmentManager(queue *ApprovalQueue, mailer Mailer, baseURL string, allowedDomains []string, tokenTTL time.Duration) *EnrollmentManager {
+	if tokenTTL <= 0 {
+		tokenTTL = 15 * time.Minute
+	}
+
+	return &EnrollmentManager{
+		queue:          queue,
+		mailer:         mailer,
+		baseURL:        strings.TrimRight(baseURL, "/"),
+		allowedDomains: allowedDomains,
+		tokenTTL:       tokenTTL,
+		pending:        make(map[string]*pendingEnrollment),
+	}
+}
+
+// Begin validates a submission and emails a verification link to the submitter
+func (m *EnrollmentManager) Begin(email, publicKey, ttl string) error {
+	email = strings.TrimSpace(strings.ToLower(email))
+	if err := m.checkEmail(email); err != nil {
+		return err
+	}
+
+	if _, err := m.queue.keyManager.ValidateKey(publicKey); err != nil {
+		return err
+	}
+	if err := m.queue.keyManager.ValidateKeyStrength(publicKey); err != nil {
+		return err
+	}
+	if ttl != "" {
+		if d, err := time.ParseDuration(ttl); err != nil || d <= 0 {
+			return fmt.Errorf("invalid ttl: %s", ttl)
+		}
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Errorf("generate token: %w", err)
+	}
+	token := hex.EncodeToString(b)
+
+	m.mu.Lock()
+	m.purgeExpired(time.Now())
+	m.pending[token] = &pendingEnrollment{
+		email:     email,
+		publicKey: strings.TrimSpace(publicKey),
+		ttl:       ttl,
+		expiresAt: time.Now().Add(m.tokenTTL),
+	}
+	m.mu.Unlock()
+
+	link := fmt.Sprintf("%s/api/enroll/verify?token=%s", m.baseURL, url.QueryEscape(token))
+	body := fmt.Sprintf("Someone submitted an SSH key for %s to TUNNEL.\n\n"+
+		"If this was you, confirm the submission within %s:\n\n  %s\n\n"+
+		"Your key will then wait for an administrator to approve it.\n"+
+		"If you did not request this, ignore this email.\n",
+		email, m.tokenTTL, link)
+
+	if err := m.mailer.Send(email, "Confirm your SSH key enrollment", body); err != nil {
+		m.mu.Lock()
+		delete(m.pending, token)
+		m.mu.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+// Verify consumes a verification token and queues the key for approval
+func (m *EnrollmentManager) Verify(token string) (*AccessRequest, error) {
+	m.mu.Lock()
+	enrollment, ok := m.pending[token]
+	delete(m.pending, token)
+	m.mu.Unlock()
+
+	if !ok || time.Now().After(enrollment.expiresAt) {
+		return nil, ErrInvalidEnrollmentToken
+	}
+
+	return m.queue.Submit(AccessRequest{
+		User:      enrollment.email,
+		PublicKey: enrollment.publicKey,
+		TTL:       enrollment.ttl,
+		Source:    "self-enroll",
+	})
+}
+
+func (m *EnrollmentManager) checkEmail(email string) error {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || at == len(email)-1 || strings.ContainsAny(email, " \r\n") {
+		return fmt.Errorf("invalid email address: %s", email)
+	}
+
+	if len(m.allowedDomains) == 0 {
+		return nil
+	}
+
+	domain := email[at+1:]
+	for _, allowed := range m.allowedDomains {
+		if strings.EqualFold(domain, allowed) {
+			return nil
+		}
+	}
+	return fmt.Errorf("email domain %s is not allowed to enroll", domain)
+}
+
+// purgeExpired drops tokens that can no longer be verified. Callers hold m.mu.
+func (m *EnrollmentManager) purgeExpired(now time.Time) {
+	for token, enrollment := range m.pending {
+		if now.After(enrollment.expiresAt) {
+			delete(m.pending, token)
+		}
+	}
+}
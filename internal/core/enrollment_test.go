@@ -0,0 +1,119 @@
+package core
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeMailer records the last message instead of sending it
+type fakeMailer struct {
+	to   string
+	body string
+	err  error
+}
+
+func (f *fakeMailer) Send(to, subject, body string) error {
+	f.to = to
+	f.body = body
+	return f.err
+}
+
+// tokenFromBody extracts the verification token from an enrollment email
+func tokenFromBody(t *testing.T, body string) string {
+	t.Helper()
+
+	for _, field := range strings.Fields(body) {
+		if strings.Contains(field, "/api/enroll/verify?") {
+			u, err := url.Parse(field)
+			if err != nil {
+				t.Fatalf("Failed to parse link: %v", err)
+			}
+			return u.Query().Get("token")
+		}
+	}
+	t.Fatalf("no verification link in body: %s", body)
+	return ""
+}
+
+// TestEnrollmentVerify tests that keys are only queued after verification
+func TestEnrollmentVerify(t *testing.T) {
+	q, _, _ := setupTestApprovalQueue(t)
+	mailer := &fakeMailer{}
+	em := NewEnrollmentManager(q, mailer, "https://tunnel.example.com/", []string{"example.com"}, time.Minute)
+
+	if err := em.Begin("Alice@Example.com", testED25519Key, "8h"); err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	if mailer.to != "alice@example.com" {
+		t.Errorf("mail sent to %q, want alice@example.com", mailer.to)
+	}
+
+	pending, _ := q.Pending()
+	if len(pending) != 0 {
+		t.Fatalf("request queued before verification: %+v", pending)
+	}
+
+	token := tokenFromBody(t, mailer.body)
+	req, err := em.Verify(token)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if req.User != "alice@example.com" || req.Source != "self-enroll" || req.TTL != "8h" {
+		t.Errorf("Verify() = %+v, want self-enroll request for alice", req)
+	}
+
+	pending, _ = q.Pending()
+	if len(pending) != 1 {
+		t.Errorf("Pending() = %+v, want one request", pending)
+	}
+
+	// Tokens are single use
+	if _, err := em.Verify(token); !errors.Is(err, ErrInvalidEnrollmentToken) {
+		t.Errorf("second Verify() error = %v, want ErrInvalidEnrollmentToken", err)
+	}
+}
+
+// TestEnrollmentBeginValidation tests rejected submissions
+func TestEnrollmentBeginValidation(t *testing.T) {
+	q, _, _ := setupTestApprovalQueue(t)
+	em := NewEnrollmentManager(q, &fakeMailer{}, "https://tunnel.example.com", []string{"example.com"}, time.Minute)
+
+	tests := []struct {
+		name  string
+		email string
+		key   string
+		ttl   string
+	}{
+		{"invalid email", "alice", testED25519Key, ""},
+		{"disallowed domain", "mallory@evil.test", testED25519Key, ""},
+		{"invalid key", "alice@example.com", invalidKey, ""},
+		{"invalid ttl", "alice@example.com", testED25519Key, "forever"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := em.Begin(tt.email, tt.key, tt.ttl); err == nil {
+				t.Error("Begin() expected error")
+			}
+		})
+	}
+}
+
+// TestEnrollmentExpiredToken tests that stale links are rejected
+func TestEnrollmentExpiredToken(t *testing.T) {
+	q, _, _ := setupTestApprovalQueue(t)
+	mailer := &fakeMailer{}
+	em := NewEnrollmentManager(q, mailer, "https://tunnel.example.com", nil, time.Millisecond)
+
+	if err := em.Begin("bob@example.org", testECDSAKey, ""); err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if _, err := em.Verify(tokenFromBody(t, mailer.body)); !errors.Is(err, ErrInvalidEnrollmentToken) {
+		t.Errorf("Verify() error = %v, want ErrInvalidEnrollmentToken", err)
+	}
+}
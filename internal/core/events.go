@@ -1,7 +1,11 @@
 package core
 
 import (
+	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,6 +21,7 @@ const (
 	EventError
 	EventStateChange
 	EventPrimaryChange
+	EventNetworkChange
 )
 
 // String returns the string representation of EventType
@@ -38,18 +43,52 @@ func (e EventType) String() string {
 		return "StateChange"
 	case EventPrimaryChange:
 		return "PrimaryChange"
+	case EventNetworkChange:
+		return "NetworkChange"
 	default:
 		return "Unknown"
 	}
 }
 
+// EventTypes lists every connection event type
+var EventTypes = []EventType{
+	EventConnected,
+	EventDisconnected,
+	EventReconnecting,
+	EventFailover,
+	EventMetricsUpdate,
+	EventError,
+	EventStateChange,
+	EventPrimaryChange,
+	EventNetworkChange,
+}
+
+// ParseEventType parses an event type name case-insensitively, ignoring
+// underscores and dashes, so "failover", "state_change" and "PrimaryChange"
+// are all accepted
+func ParseEventType(name string) (EventType, error) {
+	normalized := normalizeEventName(name)
+	for _, t := range EventTypes {
+		if normalizeEventName(t.String()) == normalized {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown event type: %s", name)
+}
+
+// normalizeEventName lowercases name and strips separators
+func normalizeEventName(name string) string {
+	return strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(name))
+}
+
 // ConnectionEvent represents an event related to a connection
 type ConnectionEvent struct {
-	Type      EventType
-	ConnID    string
-	Timestamp time.Time
-	Data      interface{}
-	Message   string
+	Type        EventType
+	ConnID      string
+	Timestamp   time.Time
+	Data        interface{}
+	Message     string
+	OperationID string // Operation the event belongs to, if any
 }
 
 // NewEvent creates a new connection event
@@ -68,6 +107,9 @@ type EventSubscriber struct {
 	ID      string
 	Channel chan *ConnectionEvent
 	Filter  func(*ConnectionEvent) bool // Optional filter function
+
+	dropped     atomic.Int64 // events skipped because Channel was full
+	lastDropped int64        // dropped when Stalled last looked
 }
 
 // EventPublisher manages event publishing and subscription
@@ -130,6 +172,49 @@ func (p *EventPublisher) Publish(event *ConnectionEvent) {
 		case sub.Channel <- event:
 		default:
 			// Channel full, skip this subscriber to avoid blocking
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// Stalled returns the subscribers that stopped draining their queue: it is
+// full and events have been dropped since the previous call
+func (p *EventPublisher) Stalled() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var stalled []string
+	for id, sub := range p.subscribers {
+		dropped := sub.dropped.Load()
+		if dropped > sub.lastDropped && len(sub.Channel) == cap(sub.Channel) {
+			stalled = append(stalled, id)
+		}
+		sub.lastDropped = dropped
+	}
+	sort.Strings(stalled)
+	return stalled
+}
+
+// Drain discards the events queued for subscriber id, so that it receives
+// current events again once it resumes, and returns how many were dropped
+func (p *EventPublisher) Drain(id string) int {
+	p.mu.RLock()
+	sub, exists := p.subscribers[id]
+	p.mu.RUnlock()
+	if !exists {
+		return 0
+	}
+
+	n := 0
+	for {
+		select {
+		case _, ok := <-sub.Channel:
+			if !ok {
+				return n
+			}
+			n++
+		default:
+			return n
 		}
 	}
 }
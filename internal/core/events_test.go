@@ -1,6 +1,8 @@
 package core
 
 import (
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 )
@@ -306,6 +308,32 @@ func TestPublishNonBlocking(t *testing.T) {
 	<-sub.Channel
 }
 
+func TestStalledAndDrain(t *testing.T) {
+	publisher := NewEventPublisher(2)
+	stuck := publisher.Subscribe("stuck", nil)
+	live := publisher.Subscribe("live", nil)
+
+	for i := 0; i < 3; i++ {
+		publisher.Publish(NewEvent(EventConnected, "conn-1", nil, "Event"))
+		<-live.Channel
+	}
+	if got := publisher.Stalled(); len(got) != 1 || got[0] != "stuck" {
+		t.Fatalf("Stalled() = %v, want [stuck]", got)
+	}
+	// Nothing dropped since the last look
+	if got := publisher.Stalled(); len(got) != 0 {
+		t.Errorf("Stalled() again = %v, want none", got)
+	}
+
+	if n := publisher.Drain("stuck"); n != 2 {
+		t.Errorf("Drain dropped %d events, want 2", n)
+	}
+	publisher.Publish(NewEvent(EventDisconnected, "conn-1", nil, "Latest"))
+	if event := <-stuck.Channel; event.Type != EventDisconnected {
+		t.Errorf("after draining, got %v; want the latest event", event.Type)
+	}
+}
+
 func TestClose(t *testing.T) {
 	publisher := NewEventPublisher(100)
 
@@ -387,6 +415,7 @@ func TestEventTypeString(t *testing.T) {
 		{EventError, "Error"},
 		{EventStateChange, "StateChange"},
 		{EventPrimaryChange, "PrimaryChange"},
+		{EventNetworkChange, "NetworkChange"},
 	}
 
 	for _, test := range tests {
@@ -628,3 +657,72 @@ func TestEventPublisherConcurrency(t *testing.T) {
 		t.Errorf("Expected 10 subscribers, got %d", count)
 	}
 }
+
+func TestParseEventType(t *testing.T) {
+	for name, want := range map[string]EventType{
+		"failover":       EventFailover,
+		"Error":          EventError,
+		"state_change":   EventStateChange,
+		"primary-change": EventPrimaryChange,
+		"MetricsUpdate":  EventMetricsUpdate,
+	} {
+		got, err := ParseEventType(name)
+		if err != nil || got != want {
+			t.Errorf("ParseEventType(%q) = %v, %v; want %v", name, got, err, want)
+		}
+	}
+
+	if _, err := ParseEventType("explosion"); err == nil {
+		t.Error("ParseEventType() expected error for unknown type")
+	}
+}
+
+func BenchmarkPublishFanOut(b *testing.B) {
+	for _, n := range []int{1, 10, 100} {
+		b.Run(fmt.Sprintf("subscribers=%d", n), func(b *testing.B) {
+			publisher := NewEventPublisher(1024)
+
+			var wg sync.WaitGroup
+			for i := 0; i < n; i++ {
+				sub := publisher.Subscribe(fmt.Sprintf("sub-%d", i), nil)
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for range sub.Channel {
+					}
+				}()
+			}
+
+			event := NewEvent(EventMetricsUpdate, "conn-1", nil, "metrics updated")
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				publisher.Publish(event)
+			}
+			b.StopTimer()
+
+			publisher.Close()
+			wg.Wait()
+		})
+	}
+}
+
+func BenchmarkPublishFiltered(b *testing.B) {
+	publisher := NewEventPublisher(1024)
+	defer publisher.Close()
+
+	// Subscribers that reject everything measure the cost of filtering alone
+	for i := 0; i < 100; i++ {
+		publisher.Subscribe(fmt.Sprintf("sub-%d", i), func(e *ConnectionEvent) bool {
+			return e.Type == EventFailover
+		})
+	}
+	event := NewEvent(EventMetricsUpdate, "conn-1", nil, "metrics updated")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		publisher.Publish(event)
+	}
+}
@@ -46,8 +46,7 @@ func (p *MockProvider) Connect(ctx context.Context, config *Config) (*Connection
 	connID := fmt.Sprintf("%s-%d", p.name, time.Now().UnixNano())
 
 	conn := NewConnection(connID, p.name, config.LocalPort, config.RemoteHost, config.RemotePort)
-	conn.State = StateConnected
-	conn.StartedAt = time.Now()
+	conn.SetState(StateConnected)
 	conn.PID = rand.Intn(10000) + 1000 // Simulate PID
 	conn.Config = config
 
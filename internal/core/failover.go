@@ -2,10 +2,14 @@ package core
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/jedarden/tunnel/internal/logging"
 )
 
 // FailoverConfig holds configuration for failover behavior
@@ -15,6 +19,7 @@ type FailoverConfig struct {
 	FailureThreshold    int           // Number of failures before triggering failover
 	RecoveryThreshold   int           // Number of successes before marking as recovered
 	MaxLatency          time.Duration // Maximum acceptable latency
+	LatencyChecks       int           // Checks in a row over MaxLatency before failing over to a faster backup; 0 disables
 	AutoRecover         bool          // Automatically switch back to higher priority on recovery
 }
 
@@ -26,10 +31,14 @@ func DefaultFailoverConfig() *FailoverConfig {
 		FailureThreshold:    3,
 		RecoveryThreshold:   5,
 		MaxLatency:          500 * time.Millisecond,
+		LatencyChecks:       3,
 		AutoRecover:         true,
 	}
 }
 
+// errNoHealthyBackup fails a failover that had nothing to switch to
+var errNoHealthyBackup = errors.New("no healthy backup available")
+
 // FailoverManager manages automatic failover between connections
 type FailoverManager struct {
 	mu               sync.RWMutex
@@ -44,6 +53,16 @@ type FailoverManager struct {
 	ctx              context.Context
 	cancel           context.CancelFunc
 	wg               sync.WaitGroup
+	lastTick         atomic.Int64 // unix nanoseconds the monitor loop last finished a round
+	balancing        atomic.Bool  // every healthy connection is in use, so only a failed primary is replaced
+
+	// recordOperation records a failover with the connection manager and
+	// returns its operation ID
+	recordOperation func(method, connID string, err error) string
+
+	// measureLatency measures the round trip of a connection; without it
+	// the latency the metrics collector last averaged is used
+	measureLatency func(ctx context.Context, conn *Connection) (time.Duration, error)
 }
 
 // HealthStatus tracks the health of a connection
@@ -54,6 +73,16 @@ type HealthStatus struct {
 	LastCheck            time.Time
 	LastError            error
 	IsHealthy            bool
+	Latency              time.Duration // round trip measured by the last check; 0 if it could not be
+	SlowChecks           int           // checks in a row over MaxLatency
+
+	// fastChecks counts checks in a row within MaxLatency, so a connection
+	// left for being slow is only taken back once it has stayed fast
+	fastChecks int
+
+	// markedUnhealthy is set once the failure threshold is crossed so that
+	// the return to health can be announced exactly once
+	markedUnhealthy bool
 }
 
 // NewFailoverManager creates a new failover manager
@@ -114,11 +143,44 @@ func (fm *FailoverManager) Start() {
 	// Recreate context for this run (in case of restart after Stop)
 	fm.ctx, fm.cancel = context.WithCancel(context.Background())
 	// Copy context to local var to avoid race with Stop() modifying fm.ctx
-	ctx := fm.ctx
+	ctx, ticker := fm.ctx, fm.ticker
 	fm.wg.Add(1)
+	fm.lastTick.Store(time.Now().UnixNano())
 	fm.mu.Unlock()
 
-	go fm.monitorLoop(ctx)
+	go fm.monitorLoop(ctx, ticker)
+}
+
+// Stalled reports a running monitor loop that has missed three rounds of
+// health checks, usually because a provider's health check hangs
+func (fm *FailoverManager) Stalled() error {
+	fm.mu.RLock()
+	running, interval := fm.running, fm.config.HealthCheckInterval
+	fm.mu.RUnlock()
+	if !running {
+		return nil
+	}
+	since := time.Since(time.Unix(0, fm.lastTick.Load()))
+	if since > 3*interval {
+		return fmt.Errorf("health loop has not completed a round for %s", since.Round(time.Second))
+	}
+	return nil
+}
+
+// Restart replaces the monitor loop without waiting for the current one,
+// which may be stuck; it exits once whatever it waits on returns
+func (fm *FailoverManager) Restart() {
+	fm.mu.Lock()
+	if !fm.running {
+		fm.mu.Unlock()
+		return
+	}
+	fm.ticker.Stop()
+	fm.cancel()
+	fm.running = false
+	fm.mu.Unlock()
+
+	fm.Start()
 }
 
 // Stop halts the failover monitoring
@@ -142,14 +204,17 @@ func (fm *FailoverManager) Stop() {
 }
 
 // monitorLoop continuously monitors connection health
-func (fm *FailoverManager) monitorLoop(ctx context.Context) {
+func (fm *FailoverManager) monitorLoop(ctx context.Context, ticker *time.Ticker) {
 	defer fm.wg.Done()
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-fm.ticker.C:
+		case <-ticker.C:
 			fm.performHealthChecks()
+			if ctx.Err() == nil {
+				fm.lastTick.Store(time.Now().UnixNano())
+			}
 		}
 	}
 }
@@ -183,6 +248,7 @@ func (fm *FailoverManager) performHealthChecks() {
 func (fm *FailoverManager) checkConnection(conn *Connection) {
 	fm.mu.RLock()
 	status, exists := fm.healthStatus[conn.ID]
+	ctx := fm.ctx
 	fm.mu.RUnlock()
 
 	if !exists {
@@ -191,6 +257,9 @@ func (fm *FailoverManager) checkConnection(conn *Connection) {
 
 	// Perform the health check
 	healthy := fm.isConnectionHealthy(conn)
+	if healthy {
+		fm.checkLatency(ctx, conn, status)
+	}
 
 	status.mu.Lock()
 	status.LastCheck = time.Now()
@@ -203,14 +272,26 @@ func (fm *FailoverManager) checkConnection(conn *Connection) {
 		if status.ConsecutiveSuccesses >= fm.config.RecoveryThreshold {
 			status.IsHealthy = true
 			status.LastError = nil
+
+			if status.markedUnhealthy {
+				status.markedUnhealthy = false
+				if fm.eventPublisher != nil {
+					event := NewEvent(EventStateChange, conn.ID, "recovered",
+						fmt.Sprintf("Connection %s recovered", conn.ID))
+					fm.eventPublisher.Publish(event)
+				}
+			}
 		}
 	} else {
 		status.ConsecutiveFailures++
 		status.ConsecutiveSuccesses = 0
+		logging.For("failover").Debug("health check failed",
+			"connection", conn.ID, "failures", status.ConsecutiveFailures)
 
 		// Mark as unhealthy if we've reached failure threshold
 		if status.ConsecutiveFailures >= fm.config.FailureThreshold {
 			status.IsHealthy = false
+			status.markedUnhealthy = true
 
 			// Publish error event
 			if fm.eventPublisher != nil {
@@ -226,28 +307,64 @@ func (fm *FailoverManager) checkConnection(conn *Connection) {
 
 // isConnectionHealthy checks if a connection is healthy
 func (fm *FailoverManager) isConnectionHealthy(conn *Connection) bool {
-	// Check connection state
+	// Check connection state; a slow connection is still healthy, and is
+	// left for a faster one by checkLatency instead
 	if conn.GetState() != StateConnected {
 		return false
 	}
 
-	// Check latency if metrics collector is available
-	if fm.metricsCollector != nil {
-		metrics, err := fm.metricsCollector.GetConnectionMetrics(conn.ID)
-		if err == nil {
-			latency := metrics.GetLatency()
-			if latency > fm.config.MaxLatency {
-				return false
-			}
-		}
-	}
-
 	// Additional health checks can be added here
 	// For example: checking if the process is still running, port is open, etc.
 
 	return true
 }
 
+// checkLatency measures the round trip of a healthy connection and counts
+// the checks in a row it has been over MaxLatency. A failed measurement
+// leaves the counts alone, since losing the connection is for the health
+// check to notice.
+func (fm *FailoverManager) checkLatency(ctx context.Context, conn *Connection, status *HealthStatus) {
+	if fm.config.LatencyChecks <= 0 || fm.config.MaxLatency <= 0 {
+		return
+	}
+
+	var latency time.Duration
+	if fm.measureLatency != nil {
+		measured, err := fm.measureLatency(ctx, conn)
+		if err != nil {
+			status.mu.Lock()
+			status.Latency = 0
+			status.mu.Unlock()
+			return
+		}
+		latency = measured
+	} else if fm.metricsCollector != nil {
+		if metrics, err := fm.metricsCollector.GetConnectionMetrics(conn.ID); err == nil {
+			latency = metrics.GetLatency()
+		}
+	}
+	if latency <= 0 {
+		return
+	}
+
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	status.Latency = latency
+	if latency > fm.config.MaxLatency {
+		status.SlowChecks++
+		status.fastChecks = 0
+		if status.SlowChecks == fm.config.LatencyChecks && fm.eventPublisher != nil {
+			event := NewEvent(EventError, conn.ID, nil,
+				fmt.Sprintf("Connection %s over %s latency for %d checks (%s)",
+					conn.ID, fm.config.MaxLatency, status.SlowChecks, latency.Round(time.Millisecond)))
+			fm.eventPublisher.Publish(event)
+		}
+		return
+	}
+	status.SlowChecks = 0
+	status.fastChecks++
+}
+
 // evaluateFailover determines if failover should be triggered
 func (fm *FailoverManager) evaluateFailover(currentPrimaryID string) {
 	fm.mu.Lock()
@@ -276,6 +393,17 @@ func (fm *FailoverManager) evaluateFailover(currentPrimaryID string) {
 		return
 	}
 
+	// Load balancing spreads sessions by itself; a slow primary is no reason
+	// to switch
+	if fm.balancing.Load() {
+		return
+	}
+
+	// If primary has been too slow for a while, move to a faster backup
+	if fm.tooSlow(primaryStatus) && fm.failoverForLatency(currentPrimaryID, primaryStatus) {
+		return
+	}
+
 	// If auto-recovery is enabled, check if a higher priority connection is available
 	if fm.config.AutoRecover {
 		fm.checkForBetterPrimary(currentPrimaryID)
@@ -289,9 +417,14 @@ func (fm *FailoverManager) triggerFailover(failedPrimaryID string) {
 
 	if backup == nil {
 		// No healthy backup available
+		opID := ""
+		if failed := fm.connections[failedPrimaryID]; failed != nil {
+			opID = fm.operation(failed.Method, failedPrimaryID, errNoHealthyBackup)
+		}
 		if fm.eventPublisher != nil {
 			event := NewEvent(EventError, failedPrimaryID, nil,
 				"Primary connection failed and no healthy backup available")
+			event.OperationID = opID
 			fm.eventPublisher.Publish(event)
 		}
 		return
@@ -305,6 +438,7 @@ func (fm *FailoverManager) triggerFailover(failedPrimaryID string) {
 
 	backup.SetPrimaryConnection(true)
 	fm.primaryConnID = backup.ID
+	opID := fm.operation(backup.Method, backup.ID, nil)
 
 	// Publish failover event
 	if fm.eventPublisher != nil {
@@ -314,6 +448,7 @@ func (fm *FailoverManager) triggerFailover(failedPrimaryID string) {
 				"new_primary": backup.ID,
 			},
 			fmt.Sprintf("Failed over from %s to %s", failedPrimaryID, backup.ID))
+		event.OperationID = opID
 		fm.eventPublisher.Publish(event)
 	}
 }
@@ -342,11 +477,12 @@ func (fm *FailoverManager) checkForBetterPrimary(currentPrimaryID string) {
 		healthy := status.IsHealthy
 		status.mu.RUnlock()
 
-		if healthy && conn.GetPriority() < currentPriority {
+		if healthy && conn.GetPriority() < currentPriority && fm.fastAgain(status) {
 			// Found a better connection, switch to it
 			currentPrimary.SetPrimaryConnection(false)
 			conn.SetPrimaryConnection(true)
 			fm.primaryConnID = conn.ID
+			opID := fm.operation(conn.Method, conn.ID, nil)
 
 			if fm.eventPublisher != nil {
 				event := NewEvent(EventPrimaryChange, conn.ID,
@@ -355,6 +491,7 @@ func (fm *FailoverManager) checkForBetterPrimary(currentPrimaryID string) {
 						"new_primary": conn.ID,
 					},
 					fmt.Sprintf("Recovered to higher priority connection: %s", conn.ID))
+				event.OperationID = opID
 				fm.eventPublisher.Publish(event)
 			}
 			return
@@ -362,6 +499,98 @@ func (fm *FailoverManager) checkForBetterPrimary(currentPrimaryID string) {
 	}
 }
 
+// tooSlow reports whether status has been over MaxLatency for
+// LatencyChecks checks in a row
+func (fm *FailoverManager) tooSlow(status *HealthStatus) bool {
+	if fm.config.LatencyChecks <= 0 {
+		return false
+	}
+	status.mu.RLock()
+	defer status.mu.RUnlock()
+	return status.SlowChecks >= fm.config.LatencyChecks
+}
+
+// fastAgain reports whether a connection may be made primary again as far
+// as latency goes: it is not being measured, or has stayed within
+// MaxLatency for RecoveryThreshold checks
+func (fm *FailoverManager) fastAgain(status *HealthStatus) bool {
+	if fm.config.LatencyChecks <= 0 {
+		return true
+	}
+	status.mu.RLock()
+	defer status.mu.RUnlock()
+	if status.Latency == 0 {
+		return true
+	}
+	return status.SlowChecks == 0 && status.fastChecks >= fm.config.RecoveryThreshold
+}
+
+// failoverForLatency switches from a slow primary to the healthy backup
+// of highest priority among those within MaxLatency and faster than the
+// primary, so that auto-recovery has no reason to switch again. It reports
+// whether it switched.
+func (fm *FailoverManager) failoverForLatency(slowPrimaryID string, primaryStatus *HealthStatus) bool {
+	primaryStatus.mu.RLock()
+	primaryLatency := primaryStatus.Latency
+	primaryStatus.mu.RUnlock()
+
+	var backup *Connection
+	var backupLatency time.Duration
+	for id, conn := range fm.connections {
+		if id == slowPrimaryID || conn.GetState() != StateConnected {
+			continue
+		}
+		status, exists := fm.healthStatus[id]
+		if !exists {
+			continue
+		}
+
+		status.mu.RLock()
+		healthy, latency, slow := status.IsHealthy, status.Latency, status.SlowChecks
+		status.mu.RUnlock()
+
+		if !healthy || latency == 0 || slow > 0 || latency >= primaryLatency {
+			continue
+		}
+		if backup == nil || conn.GetPriority() < backup.GetPriority() ||
+			(conn.GetPriority() == backup.GetPriority() && latency < backupLatency) {
+			backup, backupLatency = conn, latency
+		}
+	}
+	if backup == nil {
+		return false
+	}
+
+	if oldPrimary := fm.connections[slowPrimaryID]; oldPrimary != nil {
+		oldPrimary.SetPrimaryConnection(false)
+	}
+	backup.SetPrimaryConnection(true)
+	fm.primaryConnID = backup.ID
+	opID := fm.operation(backup.Method, backup.ID, nil)
+
+	if fm.eventPublisher != nil {
+		event := NewEvent(EventFailover, backup.ID,
+			map[string]string{
+				"old_primary": slowPrimaryID,
+				"new_primary": backup.ID,
+				"reason":      "latency",
+			},
+			fmt.Sprintf("Failed over from %s (%s) to faster %s (%s)", slowPrimaryID,
+				primaryLatency.Round(time.Millisecond), backup.ID, backupLatency.Round(time.Millisecond)))
+		event.OperationID = opID
+		fm.eventPublisher.Publish(event)
+	}
+	return true
+}
+
+// operation records a failover to connID and returns its operation ID
+func (fm *FailoverManager) operation(method, connID string, err error) string {
+	if fm.recordOperation == nil {
+		return NewCorrelationID()
+	}
+	return fm.recordOperation(method, connID, err)
+}
+
 // findBestBackup finds the best available backup connection
 func (fm *FailoverManager) findBestBackup(excludeID string) *Connection {
 	candidates := make([]*Connection, 0)
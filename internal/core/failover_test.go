@@ -1,6 +1,8 @@
 package core
 
 import (
+	"context"
+	"fmt"
 	"testing"
 	"time"
 )
@@ -582,3 +584,157 @@ func TestPerformHealthChecks(t *testing.T) {
 		t.Error("Expected LastCheck to be updated for conn2")
 	}
 }
+
+func BenchmarkPerformHealthChecks(b *testing.B) {
+	for _, n := range []int{1, 10, 100} {
+		b.Run(fmt.Sprintf("connections=%d", n), func(b *testing.B) {
+			publisher := NewEventPublisher(100)
+			defer publisher.Close()
+			collector := NewMetricsCollector()
+			fm := NewFailoverManager(DefaultFailoverConfig(), publisher, collector)
+
+			for i := 0; i < n; i++ {
+				conn := NewConnection(fmt.Sprintf("conn-%d", i), "mock", 8080+i, "localhost", 22)
+				conn.SetState(StateConnected)
+				conn.StartedAt = time.Now()
+				conn.Priority = i
+				collector.RegisterConnection(conn)
+				fm.RegisterConnection(conn)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				fm.performHealthChecks()
+			}
+		})
+	}
+}
+
+func TestFailoverStalledAndRestart(t *testing.T) {
+	config := DefaultFailoverConfig()
+	config.HealthCheckInterval = 10 * time.Millisecond
+	fm := NewFailoverManager(config, NewEventPublisher(10), NewMetricsCollector())
+	if err := fm.Stalled(); err != nil {
+		t.Errorf("stopped loop reported stalled: %v", err)
+	}
+
+	fm.Start()
+	defer fm.Stop()
+	time.Sleep(50 * time.Millisecond)
+	if err := fm.Stalled(); err != nil {
+		t.Fatalf("ticking loop reported stalled: %v", err)
+	}
+
+	// A round that never finishes, as when a health check hangs
+	fm.lastTick.Store(time.Now().Add(-time.Second).UnixNano())
+	if err := fm.Stalled(); err == nil {
+		t.Fatal("expected the loop to be reported stalled")
+	}
+	fm.Restart()
+	if err := fm.Stalled(); err != nil {
+		t.Errorf("after restart: %v", err)
+	}
+}
+
+func TestFailoverOnSustainedLatency(t *testing.T) {
+	config := DefaultFailoverConfig()
+	config.MaxLatency = 200 * time.Millisecond
+	config.LatencyChecks = 3
+	config.RecoveryThreshold = 2
+
+	fm := NewFailoverManager(config, NewEventPublisher(100), nil)
+
+	latencies := map[string]time.Duration{
+		"primary": 80 * time.Millisecond,
+		"backup":  120 * time.Millisecond,
+		"fastest": 40 * time.Millisecond,
+		"slow":    300 * time.Millisecond,
+	}
+	fm.measureLatency = func(ctx context.Context, conn *Connection) (time.Duration, error) {
+		return latencies[conn.ID], nil
+	}
+
+	var conns []*Connection
+	for i, id := range []string{"primary", "backup", "fastest", "slow"} {
+		conn := NewConnection(id, "mock", 8080+i, "localhost", 22)
+		conn.SetState(StateConnected)
+		conn.SetPriority(i)
+		fm.RegisterConnection(conn)
+		conns = append(conns, conn)
+	}
+	check := func() {
+		for _, conn := range conns {
+			fm.checkConnection(conn)
+		}
+		fm.evaluateFailover(fm.GetPrimary())
+	}
+	for i := 0; i < config.RecoveryThreshold; i++ {
+		check()
+	}
+	if fm.GetPrimary() != "primary" {
+		t.Fatalf("primary = %q, want primary", fm.GetPrimary())
+	}
+
+	// Slow for fewer checks than the threshold keeps the primary
+	latencies["primary"] = 500 * time.Millisecond
+	for i := 0; i < config.LatencyChecks-1; i++ {
+		check()
+	}
+	if fm.GetPrimary() != "primary" {
+		t.Fatalf("failed over after %d slow checks", config.LatencyChecks-1)
+	}
+
+	// The first backup in priority order within the limit beats the fastest
+	check()
+	if fm.GetPrimary() != "backup" {
+		t.Fatalf("primary = %q, want the first backup within the latency limit", fm.GetPrimary())
+	}
+	status, _ := fm.GetHealthStatus("primary")
+	if !status.IsHealthy || status.SlowChecks != config.LatencyChecks {
+		t.Errorf("slow primary status = healthy %v, %d slow checks", status.IsHealthy, status.SlowChecks)
+	}
+
+	// The old primary is taken back only once it has stayed fast
+	latencies["primary"] = 50 * time.Millisecond
+	check()
+	if fm.GetPrimary() != "backup" {
+		t.Error("recovered to the old primary after a single fast check")
+	}
+	check()
+	if fm.GetPrimary() != "primary" {
+		t.Errorf("primary = %q, want recovery to primary", fm.GetPrimary())
+	}
+}
+
+func TestLatencyFailoverNeedsFasterBackup(t *testing.T) {
+	config := DefaultFailoverConfig()
+	config.MaxLatency = 100 * time.Millisecond
+	config.LatencyChecks = 1
+
+	fm := NewFailoverManager(config, NewEventPublisher(100), nil)
+	fm.measureLatency = func(ctx context.Context, conn *Connection) (time.Duration, error) {
+		if conn.ID == "backup" {
+			return 0, fmt.Errorf("unreachable")
+		}
+		return 400 * time.Millisecond, nil
+	}
+
+	for i, id := range []string{"primary", "backup"} {
+		conn := NewConnection(id, "mock", 8080+i, "localhost", 22)
+		conn.SetState(StateConnected)
+		conn.SetPriority(i)
+		fm.RegisterConnection(conn)
+		fm.healthStatus[id].IsHealthy = true
+	}
+	_ = fm.SetPrimary("primary")
+
+	fm.checkConnection(fm.connections["primary"])
+	fm.checkConnection(fm.connections["backup"])
+	fm.evaluateFailover("primary")
+
+	// A backup whose latency is unknown is no better than a slow primary
+	if fm.GetPrimary() != "primary" {
+		t.Errorf("primary = %q, want primary kept", fm.GetPrimary())
+	}
+}
@@ -0,0 +1,41 @@
+//go:build !windows
+
+package core
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile opens path and takes an advisory lock on it, shared or exclusive,
+// blocking until the lock is available
+func lockFile(path string, exclusive bool) (*os.File, error) {
+	return lockFileMode(path, exclusive, 0600)
+}
+
+// lockFileMode is lockFile creating the lock file with perm, for locks
+// several users share
+func lockFileMode(path string, exclusive bool, perm os.FileMode) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, perm)
+	if err != nil {
+		return nil, err
+	}
+	// Past the umask; fails harmlessly on a lock file another user created
+	_ = f.Chmod(perm)
+
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	if err := syscall.Flock(int(f.Fd()), how); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// unlockFile releases a lock taken by lockFile
+func unlockFile(f *os.File) error {
+	defer f.Close()
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
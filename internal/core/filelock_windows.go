@@ -0,0 +1,21 @@
+//go:build windows
+
+package core
+
+import "os"
+
+// lockFile opens path without locking; on Windows only the in-process mutex
+// serializes access
+func lockFile(path string, exclusive bool) (*os.File, error) {
+	return lockFileMode(path, exclusive, 0600)
+}
+
+// lockFileMode is lockFile creating the file with perm
+func lockFileMode(path string, exclusive bool, perm os.FileMode) (*os.File, error) {
+	return os.OpenFile(path, os.O_CREATE|os.O_RDWR, perm)
+}
+
+// unlockFile closes a file opened by lockFile
+func unlockFile(f *os.File) error {
+	return f.Close()
+}
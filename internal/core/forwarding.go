@@ -0,0 +1,274 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Forward is a named forward of a local port through a provider to a
+// remote host and port
+type Forward struct {
+	Name       string `json:"name"`
+	Provider   string `json:"provider"`
+	LocalPort  int    `json:"local_port"`
+	RemoteHost string `json:"remote_host,omitempty"` // empty uses the provider's
+	RemotePort int    `json:"remote_port,omitempty"` // 0 lets the provider pick
+}
+
+// Validate checks that f names a provider and has usable ports
+func (f Forward) Validate() error {
+	switch {
+	case f.Name == "":
+		return fmt.Errorf("forward needs a name")
+	case f.Provider == "":
+		return fmt.Errorf("forward %s needs a provider", f.Name)
+	case f.LocalPort < 1 || f.LocalPort > 65535:
+		return fmt.Errorf("forward %s: invalid local port %d", f.Name, f.LocalPort)
+	case f.RemotePort < 0 || f.RemotePort > 65535:
+		return fmt.Errorf("forward %s: invalid remote port %d", f.Name, f.RemotePort)
+	}
+	return nil
+}
+
+// ForwardTunnel is the running tunnel carrying a forward
+type ForwardTunnel interface {
+	Address() string // where the forward is reachable, "" if not known
+	Running() bool
+	Err() error // why the tunnel ended, once it has
+	Stop() error
+}
+
+// ForwardOpener starts the tunnel for f. Each call opens a tunnel of its
+// own, so one provider can carry several forwards.
+type ForwardOpener func(f Forward) (ForwardTunnel, error)
+
+// Forward states
+const (
+	ForwardStopped = "stopped"
+	ForwardRunning = "running"
+	ForwardFailed  = "failed"
+)
+
+// ForwardStatus is a forward and the state of its tunnel
+type ForwardStatus struct {
+	Forward
+	State     string     `json:"state"`
+	Address   string     `json:"address,omitempty"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// forwardEntry is a defined forward and its tunnel, if started
+type forwardEntry struct {
+	forward   Forward
+	tunnel    ForwardTunnel
+	startedAt time.Time
+	err       error // why the last start failed
+}
+
+// ForwardingManager runs named forwards of local services through
+// providers, several per provider if need be
+type ForwardingManager struct {
+	mu       sync.Mutex
+	forwards map[string]*forwardEntry
+	open     ForwardOpener
+	events   *EventPublisher // nil publishes nothing
+}
+
+// NewForwardingManager creates a forwarding manager opening tunnels with
+// open and announcing them on events
+func NewForwardingManager(open ForwardOpener, events *EventPublisher) *ForwardingManager {
+	return &ForwardingManager{
+		forwards: make(map[string]*forwardEntry),
+		open:     open,
+		events:   events,
+	}
+}
+
+// Define adds f, or replaces the forward of the same name while it is
+// stopped
+func (m *ForwardingManager) Define(f Forward) error {
+	if err := f.Validate(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if existing, ok := m.forwards[f.Name]; ok {
+		if existing.running() {
+			return fmt.Errorf("forward %s is running; stop it first", f.Name)
+		}
+	}
+	m.forwards[f.Name] = &forwardEntry{forward: f}
+	return nil
+}
+
+// Remove stops the forward called name and forgets it
+func (m *ForwardingManager) Remove(name string) error {
+	if err := m.Stop(name); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.forwards, name)
+	return nil
+}
+
+// Start opens the tunnel of the forward called name. Starting a running
+// forward does nothing.
+func (m *ForwardingManager) Start(name string) error {
+	m.mu.Lock()
+	entry, ok := m.forwards[name]
+	if !ok {
+		m.mu.Unlock()
+		return fmt.Errorf("forward not found: %s", name)
+	}
+	if entry.running() {
+		m.mu.Unlock()
+		return nil
+	}
+	f := entry.forward
+	m.mu.Unlock()
+
+	tunnel, err := m.open(f)
+
+	m.mu.Lock()
+	entry.err = err
+	if err == nil {
+		entry.tunnel, entry.startedAt = tunnel, time.Now()
+	}
+	m.mu.Unlock()
+
+	if err != nil {
+		m.publish(NewEvent(EventError, forwardConnID(name), f, fmt.Sprintf("Forward %s failed to start: %v", name, err)))
+		return fmt.Errorf("failed to start forward %s: %w", name, err)
+	}
+	m.publish(NewEvent(EventConnected, forwardConnID(name), f,
+		fmt.Sprintf("Forward %s started: local port %d through %s", name, f.LocalPort, f.Provider)))
+	return nil
+}
+
+// Stop closes the tunnel of the forward called name
+func (m *ForwardingManager) Stop(name string) error {
+	m.mu.Lock()
+	entry, ok := m.forwards[name]
+	if !ok {
+		m.mu.Unlock()
+		return fmt.Errorf("forward not found: %s", name)
+	}
+	tunnel := entry.tunnel
+	entry.tunnel, entry.err = nil, nil
+	m.mu.Unlock()
+
+	if tunnel == nil {
+		return nil
+	}
+	if err := tunnel.Stop(); err != nil {
+		return fmt.Errorf("failed to stop forward %s: %w", name, err)
+	}
+	m.publish(NewEvent(EventDisconnected, forwardConnID(name), entry.forward, fmt.Sprintf("Forward %s stopped", name)))
+	return nil
+}
+
+// StartAll starts every forward not running, and returns the errors by
+// forward name
+func (m *ForwardingManager) StartAll() map[string]error {
+	errs := make(map[string]error)
+	for _, name := range m.names() {
+		if err := m.Start(name); err != nil {
+			errs[name] = err
+		}
+	}
+	return errs
+}
+
+// StopAll stops every forward
+func (m *ForwardingManager) StopAll() error {
+	var firstErr error
+	for _, name := range m.names() {
+		if err := m.Stop(name); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// List returns the forwards by provider, then name
+func (m *ForwardingManager) List() []ForwardStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]ForwardStatus, 0, len(m.forwards))
+	for _, entry := range m.forwards {
+		list = append(list, entry.status())
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Provider != list[j].Provider {
+			return list[i].Provider < list[j].Provider
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Get returns the forward called name
+func (m *ForwardingManager) Get(name string) (ForwardStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.forwards[name]
+	if !ok {
+		return ForwardStatus{}, fmt.Errorf("forward not found: %s", name)
+	}
+	return entry.status(), nil
+}
+
+func (m *ForwardingManager) names() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.forwards))
+	for name := range m.forwards {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *ForwardingManager) publish(event *ConnectionEvent) {
+	if m.events != nil {
+		m.events.Publish(event)
+	}
+}
+
+// forwardConnID identifies a forward in connection events
+func forwardConnID(name string) string {
+	return "forward:" + name
+}
+
+func (e *forwardEntry) running() bool {
+	return e.tunnel != nil && e.tunnel.Running()
+}
+
+func (e *forwardEntry) status() ForwardStatus {
+	s := ForwardStatus{Forward: e.forward, State: ForwardStopped}
+	switch {
+	case e.running():
+		s.State = ForwardRunning
+		s.Address = e.tunnel.Address()
+		startedAt := e.startedAt
+		s.StartedAt = &startedAt
+	case e.tunnel != nil:
+		// Started, but the tunnel has since ended by itself
+		s.State = ForwardFailed
+		if err := e.tunnel.Err(); err != nil {
+			s.Error = err.Error()
+		} else {
+			s.Error = "tunnel exited"
+		}
+	case e.err != nil:
+		s.State = ForwardFailed
+		s.Error = e.err.Error()
+	}
+	return s
+}
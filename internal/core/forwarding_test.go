@@ -0,0 +1,102 @@
+package core
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// fakeTunnel is a forward tunnel that runs until stopped or killed
+type fakeTunnel struct {
+	address string
+	stopped bool
+	err     error
+}
+
+func (t *fakeTunnel) Address() string { return t.address }
+func (t *fakeTunnel) Running() bool   { return !t.stopped && t.err == nil }
+func (t *fakeTunnel) Err() error      { return t.err }
+func (t *fakeTunnel) Stop() error {
+	t.stopped = true
+	return nil
+}
+
+func TestForwardingManager(t *testing.T) {
+	opened := make(map[string]*fakeTunnel)
+	open := func(f Forward) (ForwardTunnel, error) {
+		if f.LocalPort == 9999 {
+			return nil, errors.New("connection refused")
+		}
+		tunnel := &fakeTunnel{address: "bore.pub:4" + f.Name}
+		opened[f.Name] = tunnel
+		return tunnel, nil
+	}
+	events := NewEventPublisher(10)
+	sub := events.Subscribe("test", nil)
+	m := NewForwardingManager(open, events)
+
+	for _, f := range []Forward{
+		{Name: "web", Provider: "bore", LocalPort: 3000},
+		{Name: "api", Provider: "bore", LocalPort: 8080, RemotePort: 4080},
+		{Name: "db", Provider: "reverse-ssh", LocalPort: 9999, RemotePort: 5432},
+	} {
+		if err := m.Define(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Define(Forward{Name: "bad", Provider: "bore"}); err == nil {
+		t.Error("defined a forward without a local port")
+	}
+
+	errs := m.StartAll()
+	if len(errs) != 1 || errs["db"] == nil {
+		t.Fatalf("StartAll errors = %v, want only db", errs)
+	}
+
+	states := func() string {
+		var s []string
+		for _, st := range m.List() {
+			s = append(s, st.Name+"="+st.State)
+		}
+		return strings.Join(s, " ")
+	}
+	// Two forwards through one provider, ordered by provider then name
+	if got := states(); got != "api=running web=running db=failed" {
+		t.Errorf("states = %s", got)
+	}
+	if err := m.Define(Forward{Name: "web", Provider: "bore", LocalPort: 3001}); err == nil {
+		t.Error("redefined a running forward")
+	}
+
+	// A tunnel that ends by itself shows as failed, and can be started again
+	opened["web"].err = errors.New("exit status 1")
+	web, _ := m.Get("web")
+	if web.State != ForwardFailed || web.Error != "exit status 1" {
+		t.Errorf("web after its tunnel died = %+v", web)
+	}
+	if err := m.Start("web"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Remove("api"); err != nil {
+		t.Fatal(err)
+	}
+	if !opened["api"].stopped {
+		t.Error("removing a running forward didn't stop it")
+	}
+	if err := m.StopAll(); err != nil {
+		t.Fatal(err)
+	}
+	if got := states(); got != "web=stopped db=stopped" {
+		t.Errorf("states after StopAll = %s", got)
+	}
+
+	var kinds []string
+	for len(sub.Channel) > 0 {
+		event := <-sub.Channel
+		kinds = append(kinds, event.Type.String()+" "+event.ConnID)
+	}
+	if len(kinds) == 0 || kinds[0] != "Connected forward:api" {
+		t.Errorf("events = %v", kinds)
+	}
+}
@@ -0,0 +1,122 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ExportedKey is a key as tunnel keys export writes it in JSON. The
+// metadata fields are only filled in when metadata is exported.
+type ExportedKey struct {
+	Fingerprint string `json:"fingerprint"`
+	Type        string `json:"type"`
+	PublicKey   string `json:"public_key"` // the authorized_keys line, options included
+	Comment     string `json:"comment,omitempty"`
+
+	User         string           `json:"user,omitempty"`
+	Source       string           `json:"source,omitempty"`
+	Status       string           `json:"status,omitempty"`
+	AddedAt      *time.Time       `json:"added_at,omitempty"`
+	LastUsed     *time.Time       `json:"last_used,omitempty"`
+	ExpiresAt    *time.Time       `json:"expires_at,omitempty"`
+	Note         string           `json:"note,omitempty"`
+	Restrictions *KeyRestrictions `json:"restrictions,omitempty"`
+}
+
+// ExportKey converts key for export, with its metadata or without
+func ExportKey(key SSHPublicKey, metadata bool) ExportedKey {
+	exported := ExportedKey{
+		Fingerprint: key.Fingerprint,
+		Type:        key.Type,
+		PublicKey:   key.PublicKey,
+		Comment:     key.Comment,
+	}
+	if !metadata {
+		return exported
+	}
+	exported.User = key.User
+	exported.Source = key.Source
+	exported.Status = key.Status
+	exported.Note = key.Note
+	exported.ExpiresAt = key.ExpiresAt
+	if !key.AddedAt.IsZero() {
+		addedAt := key.AddedAt
+		exported.AddedAt = &addedAt
+	}
+	if !key.LastUsed.IsZero() {
+		lastUsed := key.LastUsed
+		exported.LastUsed = &lastUsed
+	}
+	if !key.Restrictions.IsZero() {
+		restrictions := key.Restrictions
+		exported.Restrictions = &restrictions
+	}
+	return exported
+}
+
+// WriteKeysJSON writes keys as a JSON document for other systems to read
+func WriteKeysJSON(w io.Writer, keys []SSHPublicKey, metadata bool) error {
+	exported := make([]ExportedKey, len(keys))
+	for i, key := range keys {
+		exported[i] = ExportKey(key, metadata)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(map[string]interface{}{
+		"exported_at": time.Now().UTC().Format(time.RFC3339),
+		"count":       len(exported),
+		"keys":        exported,
+	})
+}
+
+// WriteAuthorizedKeysFile writes keys as an authorized_keys file, each
+// line with its options. With metadata, a comment above each line says
+// whose key it is, where it came from and when it was added.
+func WriteAuthorizedKeysFile(w io.Writer, keys []SSHPublicKey, metadata bool) error {
+	if metadata {
+		if _, err := fmt.Fprintf(w, "# Exported from TUNNEL on %s\n", time.Now().UTC().Format(time.RFC3339)); err != nil {
+			return err
+		}
+	}
+	for _, key := range keys {
+		if metadata {
+			if _, err := fmt.Fprintf(w, "\n# %s\n", keyMetadataComment(key)); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintln(w, strings.TrimSpace(key.PublicKey)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keyMetadataComment describes key in key=value pairs for a comment line
+func keyMetadataComment(key SSHPublicKey) string {
+	pairs := []string{"fingerprint=" + key.Fingerprint}
+	add := func(name, value string) {
+		if value != "" {
+			pairs = append(pairs, name+"="+value)
+		}
+	}
+	add("user", key.User)
+	add("source", key.Source)
+	add("status", key.Status)
+	if !key.AddedAt.IsZero() {
+		add("added", key.AddedAt.UTC().Format(time.RFC3339))
+	}
+	if !key.LastUsed.IsZero() {
+		add("last_used", key.LastUsed.UTC().Format(time.RFC3339))
+	}
+	if key.ExpiresAt != nil {
+		add("expires", key.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+	if key.Note != "" {
+		// Quoted, and on one line, so the note can't end the comment
+		add("note", fmt.Sprintf("%q", key.Note))
+	}
+	return strings.Join(pairs, " ")
+}
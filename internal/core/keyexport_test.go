@@ -0,0 +1,63 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportKeys(t *testing.T) {
+	key, err := ParseSSHPublicKey(`no-pty ` + testED25519Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.User = "alice"
+	key.Source = KeySourceGitHub
+	key.Note = "laptop\nreplaced"
+	key.AddedAt = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	keys := []SSHPublicKey{*key}
+
+	var plain bytes.Buffer
+	if err := WriteAuthorizedKeysFile(&plain, keys, false); err != nil {
+		t.Fatal(err)
+	}
+	if plain.String() != `no-pty `+testED25519Key+"\n" {
+		t.Errorf("authorized_keys export = %q", plain.String())
+	}
+
+	var annotated bytes.Buffer
+	if err := WriteAuthorizedKeysFile(&annotated, keys, true); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(annotated.String()), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[2], "# fingerprint="+key.Fingerprint) {
+		t.Fatalf("authorized_keys export with metadata:\n%s", annotated.String())
+	}
+	for _, want := range []string{"user=alice", "source=github", "added=2026-01-02T03:04:05Z", `note="laptop\nreplaced"`} {
+		if !strings.Contains(lines[2], want) {
+			t.Errorf("metadata comment %q lacks %s", lines[2], want)
+		}
+	}
+
+	for _, metadata := range []bool{false, true} {
+		var buf bytes.Buffer
+		if err := WriteKeysJSON(&buf, keys, metadata); err != nil {
+			t.Fatal(err)
+		}
+		var doc struct {
+			Keys []ExportedKey `json:"keys"`
+		}
+		if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+			t.Fatal(err)
+		}
+		if len(doc.Keys) != 1 || doc.Keys[0].Fingerprint != key.Fingerprint {
+			t.Fatalf("JSON export = %s", buf.String())
+		}
+		got := doc.Keys[0]
+		if hasMetadata := got.User != "" && got.Restrictions != nil && got.AddedAt != nil; hasMetadata != metadata {
+			t.Errorf("JSON export with metadata %v = %+v", metadata, got)
+		}
+	}
+}
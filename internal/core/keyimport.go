@@ -0,0 +1,130 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Outcomes of a line of an imported authorized_keys file
+const (
+	KeyImportAdded     = "added"
+	KeyImportDuplicate = "duplicate"
+	KeyImportInvalid   = "invalid"
+)
+
+// KeyImportResult is what became of one key line of an imported file
+type KeyImportResult struct {
+	Line        int    `json:"line"`
+	Status      string `json:"status"` // added, duplicate or invalid
+	Type        string `json:"type,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Comment     string `json:"comment,omitempty"`
+	Reason      string `json:"reason,omitempty"` // why it was not added
+}
+
+// ImportAuthorizedKeys adds the keys of an authorized_keys file for
+// username. Lines that don't parse, weak keys and keys already authorized,
+// or repeated earlier in the file, are skipped; every key line gets a
+// result. The keys are added together, so an import is applied entirely or
+// not at all. With dryRun nothing is written.
+func (km *FileKeyManager) ImportAuthorizedKeys(username string, r io.Reader, dryRun bool) ([]KeyImportResult, error) {
+	lock := km.lockWritable
+	if dryRun {
+		lock = km.rlock
+	}
+	unlock, err := lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	keys, err := km.loadKeys()
+	if err != nil {
+		return nil, fmt.Errorf("read authorized_keys: %w", err)
+	}
+	seen := make(map[string]string, len(keys))
+	for _, key := range keys {
+		seen[key.Fingerprint] = "already authorized"
+	}
+
+	var results []KeyImportResult
+	var added []SSHPublicKey
+	reader := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		// Read whole lines: bufio.Scanner gives up on lines over 64KB
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("read line %d: %w", n, err)
+		}
+		if text := strings.TrimSpace(line); text != "" && !strings.HasPrefix(text, "#") {
+			result, key := km.importLine(n, text, seen)
+			results = append(results, result)
+			if key != nil {
+				key.User = username
+				key.Source = KeySourceFile
+				added = append(added, *key)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if dryRun || len(added) == 0 {
+		return results, nil
+	}
+	if err := km.saveKeys(append(keys, added...)); err != nil {
+		return nil, fmt.Errorf("write authorized_keys: %w", err)
+	}
+
+	if km.auditLogger != nil {
+		fingerprints := make([]string, len(added))
+		for i, key := range added {
+			fingerprints[i] = key.Fingerprint
+		}
+		_ = km.auditLogger.Log(AuditEvent{
+			Timestamp: time.Now(),
+			EventType: "keys_imported",
+			Method:    KeySourceFile,
+			User:      username,
+			Details: map[string]interface{}{
+				"count":        len(added),
+				"skipped":      len(results) - len(added),
+				"fingerprints": fingerprints,
+			},
+			Success: true,
+		})
+	}
+	return results, nil
+}
+
+// importLine validates line n of an imported file, returning the key to add
+// unless it is invalid, weak or in seen, which it is added to
+func (km *FileKeyManager) importLine(n int, line string, seen map[string]string) (KeyImportResult, *SSHPublicKey) {
+	result := KeyImportResult{Line: n, Status: KeyImportInvalid}
+	key, err := km.ValidateKey(line)
+	if err != nil {
+		result.Reason = err.Error()
+		return result, nil
+	}
+	result.Type = key.Type
+	result.Fingerprint = key.Fingerprint
+	result.Comment = key.Comment
+
+	if err := km.ValidateKeyStrength(line); err != nil {
+		result.Reason = err.Error()
+		return result, nil
+	}
+	if reason, ok := seen[key.Fingerprint]; ok {
+		result.Status = KeyImportDuplicate
+		result.Reason = reason
+		return result, nil
+	}
+	seen[key.Fingerprint] = fmt.Sprintf("same key as line %d", n)
+
+	result.Status = KeyImportAdded
+	return result, key
+}
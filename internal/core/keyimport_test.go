@@ -0,0 +1,73 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestImportAuthorizedKeys(t *testing.T) {
+	km, _, cleanup := setupTestKeyManager(t)
+	defer cleanup()
+
+	existing, _ := km.ValidateKey(testRSAKey)
+	if err := km.AddKey("alice", *existing); err != nil {
+		t.Fatal(err)
+	}
+
+	file := strings.Join([]string{
+		"# alice's old server",
+		testED25519Key,
+		"",
+		"ssh-ed25519 not-a-key",
+		testRSAKey,
+		`no-pty ` + testED25519Key,
+		testECDSAKey,
+	}, "\n")
+
+	want := []struct {
+		line   int
+		status string
+	}{
+		{2, KeyImportAdded},
+		{4, KeyImportInvalid},
+		{5, KeyImportDuplicate}, // already authorized
+		{6, KeyImportDuplicate}, // line 2 again, with options
+		{7, KeyImportAdded},
+	}
+	check := func(results []KeyImportResult) {
+		t.Helper()
+		if len(results) != len(want) {
+			t.Fatalf("got %d results, want %d: %+v", len(results), len(want), results)
+		}
+		for i, w := range want {
+			if results[i].Line != w.line || results[i].Status != w.status {
+				t.Errorf("result %d = line %d %s (%s), want line %d %s",
+					i, results[i].Line, results[i].Status, results[i].Reason, w.line, w.status)
+			}
+		}
+	}
+
+	results, err := km.ImportAuthorizedKeys("alice", strings.NewReader(file), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(results)
+	if keys, _ := km.ListKeys(""); len(keys) != 1 {
+		t.Fatalf("dry run left %d keys, want 1", len(keys))
+	}
+
+	results, err = km.ImportAuthorizedKeys("alice", strings.NewReader(file), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(results)
+	keys, _ := km.ListKeys("")
+	if len(keys) != 3 {
+		t.Fatalf("got %d keys after import, want 3", len(keys))
+	}
+	for _, key := range keys[1:] {
+		if key.User != "alice" || key.Source != KeySourceFile {
+			t.Errorf("imported key %s has user %q source %q", key.Fingerprint, key.User, key.Source)
+		}
+	}
+}
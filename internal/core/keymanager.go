@@ -4,17 +4,23 @@ import (
 	"bufio"
 	"crypto/sha256"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/jedarden/tunnel/internal/offline"
 	"golang.org/x/crypto/ssh"
 )
 
+// ErrKeyExists is returned when adding a key that is already authorized
+var ErrKeyExists = errors.New("key already exists")
+
 // SSHPublicKey represents an SSH public key with metadata
 type SSHPublicKey struct {
 	ID          string
@@ -26,6 +32,11 @@ type SSHPublicKey struct {
 	LastUsed    time.Time
 	ExpiresAt   *time.Time
 	Status      string // active, revoked, expired
+	User        string // owner, recorded only by key stores
+	Source      string // manual, github, gitlab or url
+	Note        string // free-form annotation, kept with the metadata
+
+	Restrictions KeyRestrictions // from the options of its authorized_keys line
 }
 
 // KeyManager handles SSH key operations
@@ -38,6 +49,7 @@ type KeyManager interface {
 	// Import
 	ImportFromGitHub(username string) ([]SSHPublicKey, error)
 	ImportFromGitLab(username string) ([]SSHPublicKey, error)
+	ImportFromKeyServer(server KeyServer, username string) ([]SSHPublicKey, error)
 	ImportFromURL(url string) (*SSHPublicKey, error)
 
 	// Validation
@@ -58,10 +70,18 @@ type KeyManager interface {
 	IsDuplicate(fingerprint string) (bool, string, error)
 }
 
-// FileKeyManager implements KeyManager using authorized_keys file
+// FileKeyManager implements KeyManager using authorized_keys file. Without
+// a key store, the metadata authorized_keys can't hold is kept in a sidecar
+// file next to it.
 type FileKeyManager struct {
 	authorizedKeysPath string
 	auditLogger        *AuditLogger
+	store              KeyStore // nil keeps keys only in authorized_keys
+	agentForwarding    *AgentForwardingPolicy
+
+	// mu serializes read-modify-write cycles within this process; the lock
+	// file next to authorized_keys does the same across processes
+	mu sync.RWMutex
 }
 
 // NewFileKeyManager creates a new file-based key manager
@@ -90,20 +110,234 @@ func NewFileKeyManager(authorizedKeysPath string, auditLogger *AuditLogger) (*Fi
 	}, nil
 }
 
+// NewFileKeyManagerWithStore creates a key manager that keeps keys and their
+// metadata in store and exports them to authorized_keys on every change. An
+// empty writable store is seeded from the existing authorized_keys file.
+func NewFileKeyManagerWithStore(authorizedKeysPath string, store KeyStore, auditLogger *AuditLogger) (*FileKeyManager, error) {
+	km, err := NewFileKeyManager(authorizedKeysPath, auditLogger)
+	if err != nil {
+		return nil, err
+	}
+	km.store = store
+
+	// A read-only store is the directory of record; SyncAuthorizedKeys
+	// exports it instead
+	if isReadOnly(store) {
+		return km, nil
+	}
+
+	stored, err := store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("load key store: %w", err)
+	}
+	if len(stored) == 0 {
+		existing, err := km.readAuthorizedKeys()
+		if err != nil {
+			return nil, fmt.Errorf("read authorized_keys: %w", err)
+		}
+		existing = km.withMetadata(existing)
+		if len(existing) > 0 {
+			if err := store.Save(existing); err != nil {
+				return nil, fmt.Errorf("seed key store: %w", err)
+			}
+		}
+	}
+
+	return km, nil
+}
+
+// SyncAuthorizedKeys rewrites authorized_keys from the key store, picking up
+// changes made directly in the store such as LDAP directory edits
+func (km *FileKeyManager) SyncAuthorizedKeys() (int, error) {
+	if km.store == nil {
+		return 0, nil
+	}
+
+	unlock, err := km.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	keys, err := km.store.Load()
+	if err != nil {
+		return 0, fmt.Errorf("load key store: %w", err)
+	}
+	if err := km.writeAuthorizedKeys(keys); err != nil {
+		return 0, fmt.Errorf("write authorized_keys: %w", err)
+	}
+	return len(keys), nil
+}
+
+// Close releases the key store, if any
+func (km *FileKeyManager) Close() error {
+	if km.store == nil {
+		return nil
+	}
+	return km.store.Close()
+}
+
+// RecordsOwners reports whether keys carry the user they were added for,
+// which authorized_keys alone cannot record: they do with a key store, or
+// when the metadata sidecar names an owner
+func (km *FileKeyManager) RecordsOwners() bool {
+	if km.store != nil {
+		return true
+	}
+	for _, m := range km.readMetadata() {
+		if m.User != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthorizedKeysFor returns the keys sshd should accept for user at now:
+// those recorded as the user's own that are active and unexpired. Where
+// owners aren't recorded there are none, as any key could otherwise log
+// in as any account.
+func (km *FileKeyManager) AuthorizedKeysFor(user string, now time.Time) ([]SSHPublicKey, error) {
+	if user == "" || !km.RecordsOwners() {
+		return nil, nil
+	}
+	keys, err := km.QueryKeys(KeyQuery{User: user})
+	if err != nil {
+		return nil, err
+	}
+
+	var usable []SSHPublicKey
+	for _, key := range keys {
+		if key.Status != "" && key.Status != "active" {
+			continue
+		}
+		if key.ExpiresAt != nil && !key.ExpiresAt.After(now) {
+			continue
+		}
+		usable = append(usable, key)
+	}
+	return usable, nil
+}
+
+// loadKeys returns the current keys from the store, or from authorized_keys
+// and the metadata sidecar
+func (km *FileKeyManager) loadKeys() ([]SSHPublicKey, error) {
+	if km.store != nil {
+		return km.store.Load()
+	}
+	keys, err := km.readAuthorizedKeys()
+	if err != nil {
+		return nil, err
+	}
+	return km.withMetadata(keys), nil
+}
+
+// saveKeys persists keys and exports them to authorized_keys
+func (km *FileKeyManager) saveKeys(keys []SSHPublicKey) error {
+	if km.store != nil {
+		if err := km.store.Save(keys); err != nil {
+			return err
+		}
+	}
+	if err := km.writeAuthorizedKeys(keys); err != nil {
+		return err
+	}
+	if km.store == nil {
+		if err := km.writeMetadata(keys); err != nil {
+			return fmt.Errorf("write key metadata: %w", err)
+		}
+	}
+	return nil
+}
+
+// QueryKeys returns the keys matching q, letting the store filter them when
+// it can
+func (km *FileKeyManager) QueryKeys(q KeyQuery) ([]SSHPublicKey, error) {
+	unlock, err := km.rlock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if querier, ok := km.store.(KeyQuerier); ok {
+		return querier.Query(q)
+	}
+
+	keys, err := km.loadKeys()
+	if err != nil {
+		return nil, fmt.Errorf("read authorized_keys: %w", err)
+	}
+	return filterKeys(keys, q), nil
+}
+
+// lockPath returns the path of the advisory lock file for authorized_keys
+func (km *FileKeyManager) lockPath() string {
+	return km.authorizedKeysPath + ".lock"
+}
+
+// lock takes exclusive ownership of authorized_keys for a read-modify-write
+// cycle. The returned function releases it.
+func (km *FileKeyManager) lock() (func(), error) {
+	km.mu.Lock()
+	f, err := lockFile(km.lockPath(), true)
+	if err != nil {
+		km.mu.Unlock()
+		return nil, fmt.Errorf("lock authorized_keys: %w", err)
+	}
+	return func() {
+		_ = unlockFile(f)
+		km.mu.Unlock()
+	}, nil
+}
+
+// lockWritable is lock for changes to the key set, failing fast when the
+// keys are managed in a read-only store
+func (km *FileKeyManager) lockWritable() (func(), error) {
+	if km.store != nil && isReadOnly(km.store) {
+		return nil, ErrReadOnlyKeyStore
+	}
+	return km.lock()
+}
+
+// rlock takes shared ownership of authorized_keys for reading
+func (km *FileKeyManager) rlock() (func(), error) {
+	km.mu.RLock()
+	f, err := lockFile(km.lockPath(), false)
+	if err != nil {
+		km.mu.RUnlock()
+		return nil, fmt.Errorf("lock authorized_keys: %w", err)
+	}
+	return func() {
+		_ = unlockFile(f)
+		km.mu.RUnlock()
+	}, nil
+}
+
 // ValidateKey parses and validates an SSH public key
 func (km *FileKeyManager) ValidateKey(keyStr string) (*SSHPublicKey, error) {
+	return ParseSSHPublicKey(keyStr)
+}
+
+// ParseSSHPublicKey parses a single authorized_keys line, including any
+// options, into an SSHPublicKey
+func ParseSSHPublicKey(keyStr string) (*SSHPublicKey, error) {
 	keyStr = strings.TrimSpace(keyStr)
 
+	// ParseAuthorizedKey skips to the first valid line, so a multi-line
+	// string would smuggle its other lines into authorized_keys
+	if strings.ContainsAny(keyStr, "\r\n") {
+		return nil, fmt.Errorf("invalid SSH key: must be a single line")
+	}
+
 	// Parse the SSH public key
-	publicKey, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(keyStr))
+	publicKey, comment, options, _, err := ssh.ParseAuthorizedKey([]byte(keyStr))
 	if err != nil {
 		return nil, fmt.Errorf("invalid SSH key: %w", err)
 	}
 
 	// Generate fingerprint
-	fingerprint := km.generateFingerprint(publicKey)
+	fingerprint := fingerprintSHA256(publicKey)
 
-	return &SSHPublicKey{
+	key := &SSHPublicKey{
 		ID:          fingerprint, // Use fingerprint as ID
 		Type:        publicKey.Type(),
 		PublicKey:   keyStr,
@@ -111,7 +345,49 @@ func (km *FileKeyManager) ValidateKey(keyStr string) (*SSHPublicKey, error) {
 		Comment:     comment,
 		AddedAt:     time.Now(),
 		Status:      "active",
-	}, nil
+	}
+	key.Restrictions, _ = parseKeyRestrictions(options)
+
+	// Pick up the expiry enforced by sshd, if any
+	for _, opt := range options {
+		value, ok := strings.CutPrefix(opt, expiryTimeOption+"=")
+		if !ok {
+			continue
+		}
+		expiresAt, err := time.ParseInLocation(expiryTimeFormat, strings.Trim(value, `"`), time.Local)
+		if err != nil {
+			continue
+		}
+		key.ExpiresAt = &expiresAt
+		if expiresAt.Before(time.Now()) {
+			key.Status = "expired"
+		}
+	}
+
+	return key, nil
+}
+
+const (
+	// expiryTimeOption is the authorized_keys option sshd uses to stop accepting a key
+	expiryTimeOption = "expiry-time"
+	// expiryTimeFormat is the local-time layout expected by expiry-time
+	expiryTimeFormat = "200601021504"
+)
+
+// WithExpiry returns a copy of key whose authorized_keys line carries an
+// expiry-time option, so sshd rejects it even if TUNNEL is not running
+func WithExpiry(key SSHPublicKey, expiresAt time.Time) SSHPublicKey {
+	expiresAt = expiresAt.Local().Truncate(time.Minute)
+	option := fmt.Sprintf(`%s="%s"`, expiryTimeOption, expiresAt.Format(expiryTimeFormat))
+	line, err := rewriteOptions(key.PublicKey, func(opt string) bool {
+		return strings.HasPrefix(strings.ToLower(opt), expiryTimeOption+"=")
+	}, []string{option})
+	if err != nil {
+		line = option + " " + strings.TrimSpace(key.PublicKey)
+	}
+	key.PublicKey = line
+	key.ExpiresAt = &expiresAt
+	return key
 }
 
 // GetFingerprint generates SHA256 fingerprint for an SSH key
@@ -125,6 +401,11 @@ func (km *FileKeyManager) GetFingerprint(keyStr string) (string, error) {
 }
 
 func (km *FileKeyManager) generateFingerprint(key ssh.PublicKey) string {
+	return fingerprintSHA256(key)
+}
+
+// fingerprintSHA256 returns the OpenSSH SHA256 fingerprint of key
+func fingerprintSHA256(key ssh.PublicKey) string {
 	hash := sha256.Sum256(key.Marshal())
 	return "SHA256:" + base64.RawStdEncoding.EncodeToString(hash[:])
 }
@@ -136,16 +417,29 @@ func (km *FileKeyManager) AddKey(username string, key SSHPublicKey) error {
 		return fmt.Errorf("invalid key: %w", err)
 	}
 
+	unlock, err := km.lockWritable()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	// Read existing keys
-	keys, err := km.readAuthorizedKeys()
+	keys, err := km.loadKeys()
 	if err != nil {
 		return fmt.Errorf("read authorized_keys: %w", err)
 	}
 
+	if key.User == "" {
+		key.User = username
+	}
+	if key.Source == "" {
+		key.Source = KeySourceManual
+	}
+
 	// Check for duplicates
 	for _, existing := range keys {
 		if existing.Fingerprint == key.Fingerprint {
-			return fmt.Errorf("key already exists")
+			return ErrKeyExists
 		}
 	}
 
@@ -153,7 +447,7 @@ func (km *FileKeyManager) AddKey(username string, key SSHPublicKey) error {
 	keys = append(keys, key)
 
 	// Write back to file
-	if err := km.writeAuthorizedKeys(keys); err != nil {
+	if err := km.saveKeys(keys); err != nil {
 		return fmt.Errorf("write authorized_keys: %w", err)
 	}
 
@@ -178,7 +472,13 @@ func (km *FileKeyManager) AddKey(username string, key SSHPublicKey) error {
 
 // RemoveKey removes an SSH public key
 func (km *FileKeyManager) RemoveKey(username string, keyID string) error {
-	keys, err := km.readAuthorizedKeys()
+	unlock, err := km.lockWritable()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	keys, err := km.loadKeys()
 	if err != nil {
 		return fmt.Errorf("read authorized_keys: %w", err)
 	}
@@ -199,7 +499,7 @@ func (km *FileKeyManager) RemoveKey(username string, keyID string) error {
 	}
 
 	// Write back to file
-	if err := km.writeAuthorizedKeys(filtered); err != nil {
+	if err := km.saveKeys(filtered); err != nil {
 		return fmt.Errorf("write authorized_keys: %w", err)
 	}
 
@@ -222,50 +522,58 @@ func (km *FileKeyManager) RemoveKey(username string, keyID string) error {
 
 // ListKeys returns all SSH public keys
 func (km *FileKeyManager) ListKeys(username string) ([]SSHPublicKey, error) {
-	return km.readAuthorizedKeys()
+	unlock, err := km.rlock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return km.loadKeys()
 }
 
 // ImportFromGitHub imports SSH keys from GitHub
 func (km *FileKeyManager) ImportFromGitHub(username string) ([]SSHPublicKey, error) {
-	url := fmt.Sprintf("https://github.com/%s.keys", username)
+	return km.importFromGitHub(username, nil)
+}
 
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, fmt.Errorf("fetch GitHub keys: %w", err)
-	}
-	defer resp.Body.Close()
+// ImportFromGitHubWithExpiry imports SSH keys from GitHub that stop being
+// accepted by sshd at expiresAt
+func (km *FileKeyManager) ImportFromGitHubWithExpiry(username string, expiresAt time.Time) ([]SSHPublicKey, error) {
+	return km.importFromGitHub(username, &expiresAt)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("GitHub API returned status %d", resp.StatusCode)
+func (km *FileKeyManager) importFromGitHub(username string, expiresAt *time.Time) ([]SSHPublicKey, error) {
+	return km.importFromKeyServer(GitHubKeyServer, username, expiresAt)
+}
+
+// ImportFromKeyServer imports the SSH keys username publishes on server
+func (km *FileKeyManager) ImportFromKeyServer(server KeyServer, username string) ([]SSHPublicKey, error) {
+	return km.importFromKeyServer(server, username, nil)
+}
+
+// importFromKeyServer adds the keys username publishes on server and
+// returns those it added. Keys that are already authorized are left as they
+// are, so an expiring import never shortens the life of a permanent key.
+func (km *FileKeyManager) importFromKeyServer(server KeyServer, username string, expiresAt *time.Time) ([]SSHPublicKey, error) {
+	fetched, err := km.fetchKeys(server, username)
+	if err != nil {
+		return nil, err
 	}
 
 	var keys []SSHPublicKey
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		keyStr := strings.TrimSpace(scanner.Text())
-		if keyStr == "" {
-			continue
+	for _, key := range fetched {
+		if expiresAt != nil {
+			key = WithExpiry(key, *expiresAt)
 		}
-
-		key, err := km.ValidateKey(keyStr)
-		if err != nil {
-			// Log but continue with other keys
-			fmt.Fprintf(os.Stderr, "Warning: invalid key from GitHub: %v\n", err)
+		// Add to authorized_keys
+		err := km.AddKey(username, key)
+		if errors.Is(err, ErrKeyExists) {
 			continue
 		}
-
-		// Add comment indicating source
-		key.Comment = fmt.Sprintf("github.com/%s", username)
-		keys = append(keys, *key)
-
-		// Add to authorized_keys
-		if err := km.AddKey(username, *key); err != nil {
-			return nil, fmt.Errorf("add key: %w", err)
+		if err != nil {
+			return keys, fmt.Errorf("add key: %w", err)
 		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("read GitHub response: %w", err)
+		keys = append(keys, key)
 	}
 
 	// Log audit event
@@ -273,11 +581,12 @@ func (km *FileKeyManager) ImportFromGitHub(username string) ([]SSHPublicKey, err
 		_ = km.auditLogger.Log(AuditEvent{
 			Timestamp: time.Now(),
 			EventType: "keys_imported",
-			Method:    "github",
+			Method:    server.Name,
 			User:      username,
 			Details: map[string]interface{}{
-				"source": url,
-				"count":  len(keys),
+				"source":     server.KeysURL(username),
+				"count":      len(keys),
+				"expires_at": expiresAt,
 			},
 			Success: true,
 		})
@@ -286,90 +595,90 @@ func (km *FileKeyManager) ImportFromGitHub(username string) ([]SSHPublicKey, err
 	return keys, nil
 }
 
-// ImportFromURL imports an SSH key from a URL
-func (km *FileKeyManager) ImportFromURL(url string) (*SSHPublicKey, error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, fmt.Errorf("fetch key from URL: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("URL returned status %d", resp.StatusCode)
+// fetchKeys reads the keys account publishes on server, commented and
+// marked with where they came from
+func (km *FileKeyManager) fetchKeys(server KeyServer, account string) ([]SSHPublicKey, error) {
+	if err := offline.Check(server.Name + " key import"); err != nil {
+		return nil, err
 	}
-
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("read response: %w", err)
+	if err := server.Validate(); err != nil {
+		return nil, err
 	}
 
-	key, err := km.ValidateKey(string(data))
+	req, err := server.keysRequest(account)
 	if err != nil {
-		return nil, fmt.Errorf("invalid key: %w", err)
+		return nil, fmt.Errorf("fetch %s keys: %w", server.Name, err)
 	}
-
-	return key, nil
-}
-
-// ImportFromGitLab imports SSH keys from GitLab
-func (km *FileKeyManager) ImportFromGitLab(username string) ([]SSHPublicKey, error) {
-	url := fmt.Sprintf("https://gitlab.com/%s.keys", username)
-
-	resp, err := http.Get(url)
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("fetch GitLab keys: %w", err)
+		return nil, fmt.Errorf("fetch %s keys: %w", server.Name, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("GitLab API returned status %d", resp.StatusCode)
+		return nil, fmt.Errorf("%s returned status %d", server.Name, resp.StatusCode)
 	}
 
 	var keys []SSHPublicKey
 	scanner := bufio.NewScanner(resp.Body)
 	for scanner.Scan() {
 		keyStr := strings.TrimSpace(scanner.Text())
-		if keyStr == "" {
+		if keyStr == "" || strings.HasPrefix(keyStr, "#") {
 			continue
 		}
 
 		key, err := km.ValidateKey(keyStr)
 		if err != nil {
 			// Log but continue with other keys
-			fmt.Fprintf(os.Stderr, "Warning: invalid key from GitLab: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Warning: invalid key from %s: %v\n", server.Name, err)
 			continue
 		}
 
 		// Add comment indicating source
-		key.Comment = fmt.Sprintf("gitlab.com/%s", username)
+		key.Comment = server.keyComment(account)
+		key.Source = server.source()
 		keys = append(keys, *key)
-
-		// Add to authorized_keys
-		if err := km.AddKey(username, *key); err != nil {
-			return nil, fmt.Errorf("add key: %w", err)
-		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("read GitLab response: %w", err)
+		return nil, fmt.Errorf("read %s response: %w", server.Name, err)
+	}
+	return keys, nil
+}
+
+// ImportFromURL imports an SSH key from a URL
+func (km *FileKeyManager) ImportFromURL(url string) (*SSHPublicKey, error) {
+	if err := offline.Check("URL key import"); err != nil {
+		return nil, err
 	}
 
-	// Log audit event
-	if km.auditLogger != nil {
-		_ = km.auditLogger.Log(AuditEvent{
-			Timestamp: time.Now(),
-			EventType: "keys_imported",
-			Method:    "gitlab",
-			User:      username,
-			Details: map[string]interface{}{
-				"source": url,
-				"count":  len(keys),
-			},
-			Success: true,
-		})
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("fetch key from URL: %w", err)
 	}
+	defer resp.Body.Close()
 
-	return keys, nil
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("URL returned status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+
+	key, err := km.ValidateKey(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %w", err)
+	}
+	key.Source = KeySourceURL
+
+	return key, nil
+}
+
+// ImportFromGitLab imports SSH keys from GitLab
+func (km *FileKeyManager) ImportFromGitLab(username string) ([]SSHPublicKey, error) {
+	return km.importFromKeyServer(GitLabKeyServer, username, nil)
 }
 
 // ValidateKeyStrength checks for weak keys (RSA < 2048 bits)
@@ -407,8 +716,14 @@ func (km *FileKeyManager) RotateKey(username, oldKeyID string, newKey SSHPublicK
 		return fmt.Errorf("invalid new key: %w", err)
 	}
 
+	unlock, err := km.lockWritable()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	// Read existing keys
-	keys, err := km.readAuthorizedKeys()
+	keys, err := km.loadKeys()
 	if err != nil {
 		return fmt.Errorf("read authorized_keys: %w", err)
 	}
@@ -433,7 +748,7 @@ func (km *FileKeyManager) RotateKey(username, oldKeyID string, newKey SSHPublicK
 	updatedKeys = append(updatedKeys, newKey)
 
 	// Write back to file
-	if err := km.writeAuthorizedKeys(updatedKeys); err != nil {
+	if err := km.saveKeys(updatedKeys); err != nil {
 		return fmt.Errorf("write authorized_keys: %w", err)
 	}
 
@@ -458,7 +773,13 @@ func (km *FileKeyManager) RotateKey(username, oldKeyID string, newKey SSHPublicK
 
 // CheckKeyExpiration returns all keys that have expired or are expiring soon (within 30 days)
 func (km *FileKeyManager) CheckKeyExpiration() ([]SSHPublicKey, error) {
-	keys, err := km.readAuthorizedKeys()
+	unlock, err := km.rlock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	keys, err := km.loadKeys()
 	if err != nil {
 		return nil, fmt.Errorf("read authorized_keys: %w", err)
 	}
@@ -500,7 +821,13 @@ func (km *FileKeyManager) BulkRevoke(username string, keyIDs []string) error {
 		return fmt.Errorf("no key IDs provided")
 	}
 
-	keys, err := km.readAuthorizedKeys()
+	unlock, err := km.lockWritable()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	keys, err := km.loadKeys()
 	if err != nil {
 		return fmt.Errorf("read authorized_keys: %w", err)
 	}
@@ -527,7 +854,7 @@ func (km *FileKeyManager) BulkRevoke(username string, keyIDs []string) error {
 	}
 
 	// Write back to file
-	if err := km.writeAuthorizedKeys(filtered); err != nil {
+	if err := km.saveKeys(filtered); err != nil {
 		return fmt.Errorf("write authorized_keys: %w", err)
 	}
 
@@ -562,8 +889,14 @@ func (km *FileKeyManager) BulkRotate(username string, newKeys []SSHPublicKey) er
 		}
 	}
 
+	unlock, err := km.lockWritable()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	// Read existing keys
-	existingKeys, err := km.readAuthorizedKeys()
+	existingKeys, err := km.loadKeys()
 	if err != nil {
 		return fmt.Errorf("read authorized_keys: %w", err)
 	}
@@ -571,7 +904,7 @@ func (km *FileKeyManager) BulkRotate(username string, newKeys []SSHPublicKey) er
 	oldCount := len(existingKeys)
 
 	// Replace all keys with new keys
-	if err := km.writeAuthorizedKeys(newKeys); err != nil {
+	if err := km.saveKeys(newKeys); err != nil {
 		return fmt.Errorf("write authorized_keys: %w", err)
 	}
 
@@ -595,7 +928,13 @@ func (km *FileKeyManager) BulkRotate(username string, newKeys []SSHPublicKey) er
 
 // IsDuplicate checks if fingerprint already exists, returns user if found
 func (km *FileKeyManager) IsDuplicate(fingerprint string) (bool, string, error) {
-	keys, err := km.readAuthorizedKeys()
+	unlock, err := km.rlock()
+	if err != nil {
+		return false, "", err
+	}
+	defer unlock()
+
+	keys, err := km.loadKeys()
 	if err != nil {
 		return false, "", fmt.Errorf("read authorized_keys: %w", err)
 	}
@@ -630,10 +969,11 @@ func (km *FileKeyManager) readAuthorizedKeys() ([]SSHPublicKey, error) {
 		return nil, err
 	}
 
+	// Split rather than scan: bufio.Scanner gives up on lines over 64KB,
+	// which would make one oversized entry hide every other key
 	var keys []SSHPublicKey
-	scanner := bufio.NewScanner(strings.NewReader(string(data)))
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
 		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
@@ -648,10 +988,6 @@ func (km *FileKeyManager) readAuthorizedKeys() ([]SSHPublicKey, error) {
 		keys = append(keys, *key)
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, err
-	}
-
 	return keys, nil
 }
 
@@ -663,8 +999,12 @@ func (km *FileKeyManager) writeAuthorizedKeys(keys []SSHPublicKey) error {
 	builder.WriteString(fmt.Sprintf("# Managed by TUNNEL - Last updated: %s\n\n", time.Now().Format(time.RFC3339)))
 
 	for _, key := range keys {
-		builder.WriteString(key.PublicKey)
-		if !strings.HasSuffix(key.PublicKey, "\n") {
+		line := key.PublicKey
+		if km.agentForwarding != nil {
+			line = withAgentForwarding(line, km.agentForwarding.Allows(key.User))
+		}
+		builder.WriteString(line)
+		if !strings.HasSuffix(line, "\n") {
 			builder.WriteString("\n")
 		}
 	}
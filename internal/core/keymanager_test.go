@@ -1,14 +1,18 @@
 package core
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/jedarden/tunnel/internal/offline"
 )
 
 // Test SSH keys - these are safe test keys generated for testing only
@@ -495,6 +499,99 @@ func TestImportFromGitHub(t *testing.T) {
 	})
 }
 
+// TestImportFromKeyServer tests importing from self-hosted key servers
+func TestImportFromKeyServer(t *testing.T) {
+	var gotPath, gotAuth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotAuth = r.Header.Get("Authorization") + r.Header.Get("PRIVATE-TOKEN")
+		fmt.Fprintf(w, "# published keys\n%s\n", testED25519Key)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	tests := []struct {
+		server  KeyServer
+		path    string
+		auth    string
+		comment string
+		source  string
+	}{
+		{KeyServer{Name: "work", Type: KeyServerGitLab, BaseURL: server.URL + "/", Token: "glpat"}, "/alice.keys", "glpat", host + "/alice", KeySourceGitLab},
+		{KeyServer{Name: "forge", Type: KeyServerGitea, BaseURL: server.URL, Token: "tea"}, "/alice.keys", "token tea", host + "/alice", KeySourceGitea},
+		{KeyServer{Name: "ghe", Type: KeyServerGitHub, BaseURL: server.URL}, "/alice.keys", "", host + "/alice", KeySourceGitHub},
+		{KeyServer{Name: "raw", Type: KeyServerURL, BaseURL: server.URL + "/keys/{user}", Token: "t"}, "/keys/alice", "Bearer t", host + "/keys/alice", KeySourceURL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.server.Name, func(t *testing.T) {
+			km, _, cleanup := setupTestKeyManager(t)
+			defer cleanup()
+
+			keys, err := km.ImportFromKeyServer(tt.server, "alice")
+			if err != nil {
+				t.Fatalf("ImportFromKeyServer() error = %v", err)
+			}
+			if gotPath != tt.path || gotAuth != tt.auth {
+				t.Errorf("request to %s with %q, want %s with %q", gotPath, gotAuth, tt.path, tt.auth)
+			}
+			if len(keys) != 1 || keys[0].Comment != tt.comment || keys[0].Source != tt.source {
+				t.Errorf("imported %+v, want one key from %s (%s)", keys, tt.comment, tt.source)
+			}
+		})
+	}
+
+	km, _, cleanup := setupTestKeyManager(t)
+	defer cleanup()
+	if _, err := km.ImportFromKeyServer(KeyServer{Name: "bad", Type: "bitbucket", BaseURL: server.URL}, "alice"); err == nil {
+		t.Error("ImportFromKeyServer() accepted an unknown server type")
+	}
+	if _, err := km.ImportFromKeyServer(KeyServer{Name: "bad", Type: KeyServerGitea, BaseURL: "ftp://forge"}, "alice"); err == nil {
+		t.Error("ImportFromKeyServer() accepted a non-http URL")
+	}
+}
+
+// TestImportSkipsAuthorizedKeys checks that an expiring import leaves keys
+// that were already authorized alone and returns only those it added
+func TestImportSkipsAuthorizedKeys(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s\n%s\n", testED25519Key, testRSAKey)
+	}))
+	defer server.Close()
+
+	km, _, cleanup := setupTestKeyManager(t)
+	defer cleanup()
+
+	permanent, err := km.ValidateKey(testED25519Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := km.AddKey("alice", *permanent); err != nil {
+		t.Fatal(err)
+	}
+	if err := km.AddKey("alice", *permanent); !errors.Is(err, ErrKeyExists) {
+		t.Errorf("adding a key twice: error = %v, want ErrKeyExists", err)
+	}
+
+	expiresAt := time.Now().Add(time.Hour)
+	added, err := km.importFromKeyServer(KeyServer{Name: "ghe", Type: KeyServerGitHub, BaseURL: server.URL}, "alice", &expiresAt)
+	if err != nil {
+		t.Fatalf("importFromKeyServer() error = %v", err)
+	}
+	if len(added) != 1 || added[0].Fingerprint == permanent.Fingerprint {
+		t.Fatalf("added %+v, want only the RSA key", added)
+	}
+
+	keys, err := km.ListKeys("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		if key.Fingerprint == permanent.Fingerprint && key.ExpiresAt != nil {
+			t.Errorf("the permanent key was given an expiry of %v", key.ExpiresAt)
+		}
+	}
+}
+
 // TestImportFromURL tests importing keys from URL
 func TestImportFromURL(t *testing.T) {
 	t.Run("Import from URL with mock server", func(t *testing.T) {
@@ -550,6 +647,19 @@ func TestImportFromURL(t *testing.T) {
 			t.Errorf("ImportFromURL() error = %v, want 404 error", err)
 		}
 	})
+
+	t.Run("Import from URL - offline mode", func(t *testing.T) {
+		km, _, cleanup := setupTestKeyManager(t)
+		defer cleanup()
+
+		offline.SetEnabled(true)
+		defer offline.SetEnabled(false)
+
+		_, err := km.ImportFromURL("http://127.0.0.1/keys")
+		if !errors.Is(err, offline.ErrOffline) {
+			t.Errorf("ImportFromURL() error = %v, want ErrOffline", err)
+		}
+	})
 }
 
 // TestFileKeyManagerCreation tests the NewFileKeyManager constructor
@@ -711,40 +821,70 @@ func TestReadAuthorizedKeysWithInvalidKeys(t *testing.T) {
 	}
 }
 
-// TestConcurrentKeyOperations tests concurrent access to the KeyManager
+// TestConcurrentKeyOperations tests that concurrent adds, lists and removes
+// never lose an update to interleaved read-modify-write cycles
 func TestConcurrentKeyOperations(t *testing.T) {
 	km, _, cleanup := setupTestKeyManager(t)
 	defer cleanup()
 
-	// This is a basic concurrency test
-	// In a production environment, you'd want more sophisticated testing
-	done := make(chan bool)
+	keys := make([]SSHPublicKey, 0, 3)
+	for _, k := range []string{testED25519Key, testRSAKey, testECDSAKey} {
+		key, err := km.ValidateKey(k)
+		if err != nil {
+			t.Fatalf("ValidateKey() error = %v", err)
+		}
+		keys = append(keys, *key)
+	}
 
-	// Goroutine 1: Add keys
-	go func() {
-		key, _ := km.ValidateKey(testED25519Key)
-		km.AddKey("user1", *key)
-		done <- true
-	}()
+	// Added and removed concurrently with the other adds
+	if err := km.AddKey("user0", keys[2]); err != nil {
+		t.Fatalf("AddKey() error = %v", err)
+	}
 
-	// Goroutine 2: List keys
+	var wg sync.WaitGroup
+	errs := make(chan error, 32)
+	for i, key := range keys[:2] {
+		wg.Add(1)
+		go func(user string, key SSHPublicKey) {
+			defer wg.Done()
+			errs <- km.AddKey(user, key)
+		}(fmt.Sprintf("user%d", i+1), key)
+	}
+	wg.Add(1)
 	go func() {
-		km.ListKeys("user2")
-		done <- true
+		defer wg.Done()
+		errs <- km.RemoveKey("user0", keys[2].Fingerprint)
 	}()
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			listed, err := km.ListKeys("reader")
+			if err == nil && len(listed) > 3 {
+				err = fmt.Errorf("ListKeys() saw %d keys mid-update", len(listed))
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
 
-	// Wait for both to complete
-	<-done
-	<-done
+	for err := range errs {
+		if err != nil {
+			t.Errorf("concurrent operation failed: %v", err)
+		}
+	}
 
-	// Basic verification - no panic is a success
-	keys, err := km.ListKeys("user1")
+	final, err := km.ListKeys("user1")
 	if err != nil {
-		t.Errorf("Concurrent operations caused error: %v", err)
+		t.Fatalf("ListKeys() error = %v", err)
 	}
-
-	if len(keys) != 1 {
-		t.Logf("Warning: Concurrent test may have race condition, got %d keys", len(keys))
+	got := make(map[string]bool)
+	for _, key := range final {
+		got[key.Fingerprint] = true
+	}
+	if len(final) != 2 || !got[keys[0].Fingerprint] || !got[keys[1].Fingerprint] {
+		t.Errorf("ListKeys() = %d keys %v, want both added keys and not the removed one", len(final), got)
 	}
 }
 
@@ -836,20 +976,16 @@ func TestCheckKeyExpiration(t *testing.T) {
 		key2, _ := km.ValidateKey(testRSAKey)
 		km.AddKey("testuser", *key2)
 
-		// Manually update the file to preserve expiration data
-		// (since AddKey/ListKeys don't preserve ExpiresAt in current implementation)
-		// For this test, we'll check the logic works
-
+		// ExpiresAt survives in the metadata sidecar
 		expiring, err := km.CheckKeyExpiration()
 		if err != nil {
 			t.Errorf("CheckKeyExpiration() error = %v", err)
 		}
 
-		// Note: Due to current implementation limitations where ExpiresAt is not persisted
-		// to authorized_keys file, this will return 0 keys. This is expected.
-		// A full implementation would need to store metadata separately.
-		if len(expiring) > 0 {
-			t.Logf("Found %d expiring keys (metadata persisted)", len(expiring))
+		if len(expiring) != 1 || expiring[0].Fingerprint != key1.Fingerprint {
+			t.Errorf("CheckKeyExpiration() returned %d keys, want the expired one", len(expiring))
+		} else if expiring[0].Status != "expired" {
+			t.Errorf("expired key has status %q", expiring[0].Status)
 		}
 
 		// Cleanup temp file
@@ -871,8 +1007,9 @@ func TestCheckKeyExpiration(t *testing.T) {
 			t.Errorf("CheckKeyExpiration() error = %v", err)
 		}
 
-		// Note: See comment above about metadata persistence
-		_ = expiring
+		if len(expiring) != 1 {
+			t.Errorf("CheckKeyExpiration() returned %d keys, want 1", len(expiring))
+		}
 	})
 
 	t.Run("No expiring keys", func(t *testing.T) {
@@ -1291,6 +1428,26 @@ func BenchmarkListKeys(b *testing.B) {
 	}
 }
 
+func BenchmarkIsDuplicate(b *testing.B) {
+	km, path, cleanup := setupTestKeyManager(&testing.T{})
+	defer cleanup()
+
+	// A realistic authorized_keys file with a hundred entries
+	var lines []string
+	for i := 0; i < 50; i++ {
+		lines = append(lines, testED25519Key, testRSAKey)
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		km.IsDuplicate("SHA256:missing")
+	}
+}
+
 // TestExpirationHandling tests keys with expiration dates
 func TestExpirationHandling(t *testing.T) {
 	km, _, cleanup := setupTestKeyManager(t)
@@ -1330,3 +1487,183 @@ func TestExpirationHandling(t *testing.T) {
 		// That test is in the stub above
 	})
 }
+
+// FuzzValidateKey feeds malformed and decorated key lines to the parser.
+// Anything it accepts must be a single line that parses back to the same key.
+func FuzzValidateKey(f *testing.F) {
+	for _, seed := range []string{
+		testED25519Key,
+		testRSAKey,
+		testECDSAKey,
+		invalidKey,
+		"",
+		"ssh-ed25519",
+		"ssh-ed25519 AAAA",
+		`expiry-time="202501011200" ` + testED25519Key,
+		`expiry-time="not-a-time" ` + testED25519Key,
+		`command="echo \"hi\"",no-pty,from="10.0.0.0/8" ` + testED25519Key,
+		`command="unterminated ` + testED25519Key,
+		testED25519Key + " comment with spaces\tand tabs",
+		"# " + testED25519Key,
+		"junk\n" + testED25519Key,
+		testED25519Key + "\r\nssh-rsa AAAA",
+	} {
+		f.Add(seed)
+	}
+
+	km := &FileKeyManager{}
+	f.Fuzz(func(t *testing.T, line string) {
+		key, err := km.ValidateKey(line)
+		if err != nil {
+			return
+		}
+
+		if strings.ContainsAny(key.PublicKey, "\r\n") {
+			t.Fatalf("accepted multi-line key %q", key.PublicKey)
+		}
+		if !strings.HasPrefix(key.Fingerprint, "SHA256:") {
+			t.Fatalf("unexpected fingerprint %q", key.Fingerprint)
+		}
+
+		again, err := km.ValidateKey(key.PublicKey)
+		if err != nil {
+			t.Fatalf("accepted key does not re-parse: %v", err)
+		}
+		if again.Fingerprint != key.Fingerprint {
+			t.Fatalf("fingerprint changed on re-parse: %s != %s", again.Fingerprint, key.Fingerprint)
+		}
+	})
+}
+
+// TestAgentForwardingPolicy checks that denied owners' keys get
+// no-agent-forwarding and allowed ones lose it
+func TestAgentForwardingPolicy(t *testing.T) {
+	km, authorizedKeysPath, cleanup := setupTestKeyManager(t)
+	defer cleanup()
+
+	km.SetAgentForwardingPolicy(&AgentForwardingPolicy{Allow: false, Users: map[string]bool{"alice": true}})
+	keys := []SSHPublicKey{
+		{PublicKey: `from="10.0.0.0/8",no-agent-forwarding ` + testED25519Key, User: "alice"},
+		{PublicKey: testRSAKey, User: "bob"},
+		{PublicKey: "restrict " + testECDSAKey, User: "bob"},
+	}
+	if err := km.writeAuthorizedKeys(keys); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(authorizedKeysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`from="10.0.0.0/8" ` + testED25519Key,
+		"no-agent-forwarding " + testRSAKey,
+		"restrict " + testECDSAKey,
+	} {
+		if !strings.Contains(string(content), want+"\n") {
+			t.Errorf("authorized_keys missing %q:\n%s", want, content)
+		}
+	}
+}
+
+func TestKeyRestrictions(t *testing.T) {
+	km, authorizedKeysPath, cleanup := setupTestKeyManager(t)
+	defer cleanup()
+
+	key, err := km.ValidateKey(testED25519Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiring := WithExpiry(*key, time.Now().Add(24*time.Hour))
+	restricted, err := WithRestrictions(expiring, KeyRestrictions{
+		From:    []string{"10.0.0.0/8", "!10.0.0.1"},
+		Command: `echo "backup only"`,
+		NoPTY:   true,
+	})
+	if err != nil {
+		t.Fatalf("WithRestrictions() error = %v", err)
+	}
+	if err := km.AddKey("alice", restricted); err != nil {
+		t.Fatalf("AddKey() error = %v", err)
+	}
+
+	content, err := os.ReadFile(authorizedKeysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `from="10.0.0.0/8,!10.0.0.1",command="echo \"backup only\"",no-pty `
+	if !strings.Contains(string(content), want) || !strings.Contains(string(content), expiryTimeOption+"=") {
+		t.Errorf("authorized_keys = %s\nwant options %s and the expiry", content, want)
+	}
+
+	keys, err := km.ListKeys("alice")
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("ListKeys() = %v, %v", keys, err)
+	}
+	got := keys[0].Restrictions
+	if got.Command != `echo "backup only"` || len(got.From) != 2 || got.From[1] != "!10.0.0.1" || !got.NoPTY || got.NoPortForwarding {
+		t.Errorf("restrictions read back = %+v", got)
+	}
+	if keys[0].ExpiresAt == nil {
+		t.Error("expiry lost when restricting the key")
+	}
+
+	// Restricting again replaces the restrictions
+	lifted, err := WithRestrictions(keys[0], KeyRestrictions{NoPortForwarding: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := restrictionsOf(lifted.PublicKey); r.NoPTY || len(r.From) != 0 || !r.NoPortForwarding {
+		t.Errorf("restrictions after replacing = %+v", r)
+	}
+
+	for _, bad := range []KeyRestrictions{
+		{From: []string{"10.0.0.0/33"}},
+		{From: []string{"host name"}},
+		{From: []string{""}},
+		{Command: "rm -rf /\nid"},
+	} {
+		if _, err := WithRestrictions(*key, bad); err == nil {
+			t.Errorf("WithRestrictions(%+v) accepted", bad)
+		}
+	}
+}
+
+// FuzzReadAuthorizedKeys checks that any authorized_keys content can be read,
+// and that writing the keys back preserves exactly the same set
+func FuzzReadAuthorizedKeys(f *testing.F) {
+	f.Add(testED25519Key + "\n" + testRSAKey + "\n")
+	f.Add("# comment\n\n" + testECDSAKey + "\r\n")
+	f.Add("garbage\n\x00\xff\n" + testED25519Key)
+	f.Add(`from="*.example.com",no-agent-forwarding ` + testRSAKey + "\n" + invalidKey)
+	f.Add(strings.Repeat("A", 70000) + "\n" + testED25519Key)
+
+	f.Fuzz(func(t *testing.T, content string) {
+		path := filepath.Join(t.TempDir(), "authorized_keys")
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		km := &FileKeyManager{authorizedKeysPath: path}
+
+		keys, err := km.readAuthorizedKeys()
+		if err != nil {
+			t.Fatalf("readAuthorizedKeys() error = %v", err)
+		}
+		if err := km.writeAuthorizedKeys(keys); err != nil {
+			t.Fatalf("writeAuthorizedKeys() error = %v", err)
+		}
+
+		reread, err := km.readAuthorizedKeys()
+		if err != nil {
+			t.Fatalf("readAuthorizedKeys() after write error = %v", err)
+		}
+		if len(reread) != len(keys) {
+			t.Fatalf("round trip changed key count: %d != %d", len(reread), len(keys))
+		}
+		for i := range keys {
+			if reread[i].Fingerprint != keys[i].Fingerprint {
+				t.Fatalf("key %d changed on round trip: %s != %s", i, reread[i].Fingerprint, keys[i].Fingerprint)
+			}
+		}
+	})
+}
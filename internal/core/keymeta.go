@@ -0,0 +1,280 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// keyMetadataVersion is the format of the metadata sidecar
+const keyMetadataVersion = 1
+
+// keyMetadata is what the sidecar keeps for one key: everything an
+// authorized_keys line can't say
+type keyMetadata struct {
+	ID        string     `json:"id,omitempty"`
+	AddedAt   time.Time  `json:"added_at"`
+	LastUsed  *time.Time `json:"last_used,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Status    string     `json:"status,omitempty"`
+	User      string     `json:"user,omitempty"`
+	Source    string     `json:"source,omitempty"`
+	Comment   string     `json:"comment,omitempty"` // replaces the comment of the authorized_keys line
+	Note      string     `json:"note,omitempty"`
+}
+
+// keyMetadataFile is the sidecar's contents, keyed by fingerprint
+type keyMetadataFile struct {
+	Version int                    `json:"version"`
+	Keys    map[string]keyMetadata `json:"keys"`
+}
+
+// KeyMetadataPath returns the sidecar that holds the metadata of the keys
+// in authorizedKeysPath when no key store is configured
+func KeyMetadataPath(authorizedKeysPath string) string {
+	return authorizedKeysPath + ".meta.json"
+}
+
+func (km *FileKeyManager) metadataPath() string {
+	return KeyMetadataPath(km.authorizedKeysPath)
+}
+
+// readMetadata loads the sidecar. A missing sidecar is empty; an unreadable
+// one is reported and ignored, since authorized_keys remains usable.
+func (km *FileKeyManager) readMetadata() map[string]keyMetadata {
+	data, err := os.ReadFile(km.metadataPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Warning: failed to read key metadata: %v\n", err)
+		}
+		return nil
+	}
+
+	var file keyMetadataFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring corrupt key metadata %s: %v\n", km.metadataPath(), err)
+		return nil
+	}
+	return file.Keys
+}
+
+// withMetadata fills in the metadata recorded for keys read from
+// authorized_keys. An expiry written into authorized_keys wins over the
+// recorded one, as it is what sshd enforces.
+func (km *FileKeyManager) withMetadata(keys []SSHPublicKey) []SSHPublicKey {
+	meta := km.readMetadata()
+	if len(meta) == 0 {
+		return keys
+	}
+
+	now := time.Now()
+	for i := range keys {
+		m, ok := meta[keys[i].Fingerprint]
+		if !ok {
+			continue
+		}
+		key := &keys[i]
+		if m.ID != "" {
+			key.ID = m.ID
+		}
+		if !m.AddedAt.IsZero() {
+			key.AddedAt = m.AddedAt
+		}
+		if m.LastUsed != nil {
+			key.LastUsed = *m.LastUsed
+		}
+		if key.ExpiresAt == nil && m.ExpiresAt != nil {
+			expiresAt := *m.ExpiresAt
+			key.ExpiresAt = &expiresAt
+		}
+		if m.Status != "" {
+			key.Status = m.Status
+		}
+		if key.ExpiresAt != nil && key.ExpiresAt.Before(now) && key.Status == "active" {
+			key.Status = "expired"
+		}
+		if m.Comment != "" {
+			key.Comment = m.Comment
+		}
+		key.User = m.User
+		key.Source = m.Source
+		key.Note = m.Note
+	}
+	return keys
+}
+
+// writeMetadata replaces the sidecar with the metadata of keys, dropping
+// entries for keys no longer in authorized_keys
+func (km *FileKeyManager) writeMetadata(keys []SSHPublicKey) error {
+	file := keyMetadataFile{
+		Version: keyMetadataVersion,
+		Keys:    make(map[string]keyMetadata, len(keys)),
+	}
+	for _, key := range keys {
+		m := keyMetadata{
+			AddedAt:   key.AddedAt,
+			ExpiresAt: key.ExpiresAt,
+			Status:    key.Status,
+			User:      key.User,
+			Source:    key.Source,
+			Note:      key.Note,
+		}
+		if key.Comment != lineComment(key.PublicKey) {
+			m.Comment = key.Comment
+		}
+		if key.ID != key.Fingerprint {
+			m.ID = key.ID
+		}
+		if !key.LastUsed.IsZero() {
+			lastUsed := key.LastUsed
+			m.LastUsed = &lastUsed
+		}
+		file.Keys[key.Fingerprint] = m
+	}
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write then rename, so a crash never leaves a truncated sidecar
+	tmp, err := os.CreateTemp(filepath.Dir(km.metadataPath()), ".key-metadata-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), km.metadataPath())
+}
+
+// RecordKeyUse notes that the key with fingerprint was used to log in at
+// at. Only the metadata is rewritten; authorized_keys is left alone.
+func (km *FileKeyManager) RecordKeyUse(fingerprint string, at time.Time) error {
+	if km.store != nil && isReadOnly(km.store) {
+		return nil
+	}
+
+	unlock, err := km.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	keys, err := km.loadKeys()
+	if err != nil {
+		return fmt.Errorf("read authorized_keys: %w", err)
+	}
+
+	changed := false
+	for i := range keys {
+		if keys[i].Fingerprint == fingerprint && at.After(keys[i].LastUsed) {
+			keys[i].LastUsed = at
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	if km.store != nil {
+		return km.store.Save(keys)
+	}
+	if err := km.writeMetadata(keys); err != nil {
+		return fmt.Errorf("write key metadata: %w", err)
+	}
+	return nil
+}
+
+// lineComment returns the comment of an authorized_keys line
+func lineComment(line string) string {
+	_, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		return ""
+	}
+	return comment
+}
+
+// KeyAnnotation is what AnnotateKey changes; nil fields are left alone
+type KeyAnnotation struct {
+	Comment *string // label shown for the key; empty restores its authorized_keys comment
+	Note    *string // free-form note, e.g. which machine the key is on
+}
+
+// AnnotateKey relabels the key with ID or fingerprint keyID and sets its
+// note. Both are kept with the metadata: the authorized_keys line, and the
+// key material in it, are left alone.
+func (km *FileKeyManager) AnnotateKey(username, keyID string, a KeyAnnotation) (*SSHPublicKey, error) {
+	unlock, err := km.lockWritable()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	keys, err := km.loadKeys()
+	if err != nil {
+		return nil, fmt.Errorf("read authorized_keys: %w", err)
+	}
+
+	index := -1
+	for i, key := range keys {
+		if key.ID == keyID || key.Fingerprint == keyID {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("key not found")
+	}
+
+	key := &keys[index]
+	if a.Comment != nil {
+		key.Comment = *a.Comment
+		if key.Comment == "" {
+			key.Comment = lineComment(key.PublicKey)
+		}
+	}
+	if a.Note != nil {
+		key.Note = *a.Note
+	}
+
+	if km.store != nil {
+		err = km.store.Save(keys)
+	} else {
+		err = km.writeMetadata(keys)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("write key metadata: %w", err)
+	}
+
+	if km.auditLogger != nil {
+		_ = km.auditLogger.Log(AuditEvent{
+			Timestamp: time.Now(),
+			EventType: "key_annotated",
+			Method:    "ssh-key",
+			User:      username,
+			Details: map[string]interface{}{
+				"key_id":  keyID,
+				"comment": key.Comment,
+				"note":    key.Note,
+			},
+			Success: true,
+		})
+	}
+
+	annotated := *key
+	return &annotated, nil
+}
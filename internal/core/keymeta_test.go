@@ -0,0 +1,223 @@
+package core
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyMetadataSurvivesReload(t *testing.T) {
+	km, path, cleanup := setupTestKeyManager(t)
+	defer cleanup()
+
+	key, err := km.ValidateKey(testED25519Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := time.Now().Add(-90 * 24 * time.Hour).Truncate(time.Second)
+	expires := time.Now().Add(7 * 24 * time.Hour).Truncate(time.Second)
+	key.AddedAt = added
+	key.ExpiresAt = &expires
+	key.Source = KeySourceGitHub
+	if err := km.AddKey("alice", *key); err != nil {
+		t.Fatal(err)
+	}
+	used := time.Now().Truncate(time.Second)
+	if err := km.RecordKeyUse(key.Fingerprint, used); err != nil {
+		t.Fatal(err)
+	}
+
+	// A fresh manager sees only what is on disk
+	reopened, err := NewFileKeyManager(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := reopened.ListKeys("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("got %d keys, want 1", len(keys))
+	}
+	got := keys[0]
+	if !got.AddedAt.Equal(added) {
+		t.Errorf("AddedAt = %v, want %v", got.AddedAt, added)
+	}
+	if got.ExpiresAt == nil || !got.ExpiresAt.Equal(expires) {
+		t.Errorf("ExpiresAt = %v, want %v", got.ExpiresAt, expires)
+	}
+	if !got.LastUsed.Equal(used) {
+		t.Errorf("LastUsed = %v, want %v", got.LastUsed, used)
+	}
+	if got.User != "alice" || got.Source != KeySourceGitHub {
+		t.Errorf("owner %q source %q, want alice and github", got.User, got.Source)
+	}
+
+	// An older login doesn't move LastUsed back
+	if err := km.RecordKeyUse(key.Fingerprint, used.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	keys, _ = km.ListKeys("")
+	if !keys[0].LastUsed.Equal(used) {
+		t.Errorf("LastUsed moved back to %v", keys[0].LastUsed)
+	}
+}
+
+func TestKeyMetadataFollowsAuthorizedKeys(t *testing.T) {
+	km, path, cleanup := setupTestKeyManager(t)
+	defer cleanup()
+
+	for _, k := range []string{testED25519Key, testRSAKey} {
+		key, _ := km.ValidateKey(k)
+		if err := km.AddKey("alice", *key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	removed, _ := km.ValidateKey(testRSAKey)
+	if err := km.RemoveKey("alice", removed.Fingerprint); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(km.metadataPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), removed.Fingerprint) {
+		t.Error("metadata of a removed key was kept")
+	}
+
+	// A key added to authorized_keys by hand is listed without metadata
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(testECDSAKey + "\n")
+	f.Close()
+
+	keys, err := km.ListKeys("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("got %d keys, want 2", len(keys))
+	}
+	if keys[0].User != "alice" || keys[1].User != "" {
+		t.Errorf("owners %q and %q, want alice and none", keys[0].User, keys[1].User)
+	}
+}
+
+func TestCorruptKeyMetadataIsIgnored(t *testing.T) {
+	km, _, cleanup := setupTestKeyManager(t)
+	defer cleanup()
+
+	key, _ := km.ValidateKey(testED25519Key)
+	if err := km.AddKey("alice", *key); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(km.metadataPath(), []byte("{not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := km.ListKeys("")
+	if err != nil {
+		t.Fatalf("ListKeys with corrupt metadata: %v", err)
+	}
+	if len(keys) != 1 {
+		t.Errorf("got %d keys, want 1", len(keys))
+	}
+}
+
+func TestAnnotateKey(t *testing.T) {
+	km, path, cleanup := setupTestKeyManager(t)
+	defer cleanup()
+
+	key, _ := km.ValidateKey(testED25519Key)
+	if err := km.AddKey("alice", *key); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	label, note := "alice-laptop", "Alice's work laptop, replaced 2024-05"
+	annotated, err := km.AnnotateKey("alice", key.Fingerprint, KeyAnnotation{Comment: &label, Note: &note})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if annotated.Comment != label || annotated.Note != note {
+		t.Errorf("annotated key has comment %q note %q", annotated.Comment, annotated.Note)
+	}
+	if after, _ := os.ReadFile(path); string(after) != string(before) {
+		t.Errorf("authorized_keys changed:\n%s", after)
+	}
+
+	reopened, err := NewFileKeyManager(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, _ := reopened.ListKeys("")
+	if len(keys) != 1 || keys[0].Comment != label || keys[0].Note != note {
+		t.Fatalf("reloaded keys = %+v", keys)
+	}
+
+	// Only the fields given change, and an empty comment restores the line's
+	empty := ""
+	if _, err := km.AnnotateKey("alice", key.Fingerprint, KeyAnnotation{Comment: &empty}); err != nil {
+		t.Fatal(err)
+	}
+	keys, _ = km.ListKeys("")
+	if keys[0].Comment != "test-ed25519@example.com" || keys[0].Note != note {
+		t.Errorf("comment %q note %q after restoring the comment", keys[0].Comment, keys[0].Note)
+	}
+
+	if _, err := km.AnnotateKey("alice", "SHA256:missing", KeyAnnotation{Note: &note}); err == nil {
+		t.Error("expected an error for an unknown key")
+	}
+}
+
+func TestAuthorizedKeysFor(t *testing.T) {
+	km, path, cleanup := setupTestKeyManager(t)
+	defer cleanup()
+
+	// Lines written to authorized_keys by hand have no owner
+	if err := os.WriteFile(path, []byte(testRSAKey+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if km.RecordsOwners() {
+		t.Error("RecordsOwners without a key store or sidecar owners")
+	}
+	if keys, err := km.AuthorizedKeysFor("root", time.Now()); err != nil || len(keys) != 0 {
+		t.Errorf("keys for root without recorded owners = %v, %v; want none", keys, err)
+	}
+
+	key, err := km.ValidateKey(testED25519Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := km.AddKey("alice", *key); err != nil {
+		t.Fatal(err)
+	}
+	if !km.RecordsOwners() {
+		t.Fatal("sidecar owners not counted")
+	}
+
+	keys, err := km.AuthorizedKeysFor("alice", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].Fingerprint != key.Fingerprint {
+		t.Errorf("keys for alice = %v, want her key only", keys)
+	}
+	for _, user := range []string{"root", ""} {
+		if keys, err := km.AuthorizedKeysFor(user, time.Now()); err != nil || len(keys) != 0 {
+			t.Errorf("keys for %q = %v, %v; want none", user, keys, err)
+		}
+	}
+
+	// A key without an expiry stays authorized
+	if keys, _ := km.AuthorizedKeysFor("alice", time.Now().Add(100*365*24*time.Hour)); len(keys) != 1 {
+		t.Errorf("key without expiry dropped: %v", keys)
+	}
+}
@@ -0,0 +1,189 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// KeyRestrictions are the authorized_keys options limiting what a key may
+// do once sshd accepts it. The key's expiry is its ExpiresAt, written as
+// expiry-time, and agent forwarding follows the agent forwarding policy.
+type KeyRestrictions struct {
+	Command          string   // forced command, run whatever the client asks for
+	From             []string // address patterns the key may connect from
+	NoPortForwarding bool
+	NoPTY            bool
+	NoX11Forwarding  bool
+}
+
+// The authorized_keys options making up KeyRestrictions
+const (
+	commandOption          = "command"
+	fromOption             = "from"
+	noPortForwardingOption = "no-port-forwarding"
+	noPTYOption            = "no-pty"
+	noX11ForwardingOption  = "no-x11-forwarding"
+)
+
+// IsZero reports whether r restricts nothing
+func (r KeyRestrictions) IsZero() bool {
+	return r.Command == "" && len(r.From) == 0 && !r.NoPortForwarding && !r.NoPTY && !r.NoX11Forwarding
+}
+
+// Validate checks that r can be written to authorized_keys
+func (r KeyRestrictions) Validate() error {
+	if strings.ContainsAny(r.Command, "\r\n") {
+		return fmt.Errorf("invalid command: must be a single line")
+	}
+	for _, pattern := range r.From {
+		if err := validateFromPattern(pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateFromPattern checks one from= pattern: an address or host name,
+// with * and ? wildcards, a CIDR block, either negated with !
+func validateFromPattern(pattern string) error {
+	p := strings.TrimPrefix(pattern, "!")
+	if p == "" {
+		return fmt.Errorf("invalid from pattern %q: empty", pattern)
+	}
+	if strings.Contains(p, "/") {
+		if _, _, err := net.ParseCIDR(p); err != nil {
+			return fmt.Errorf("invalid from pattern %q: %w", pattern, err)
+		}
+		return nil
+	}
+	for _, c := range p {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune(".-_:*?", c):
+		default:
+			return fmt.Errorf("invalid from pattern %q: unexpected %q", pattern, c)
+		}
+	}
+	return nil
+}
+
+// Options returns r as authorized_keys options
+func (r KeyRestrictions) Options() []string {
+	var opts []string
+	if len(r.From) > 0 {
+		opts = append(opts, fmt.Sprintf(`%s="%s"`, fromOption, strings.Join(r.From, ",")))
+	}
+	if r.Command != "" {
+		escaped := strings.ReplaceAll(r.Command, `"`, `\"`)
+		opts = append(opts, fmt.Sprintf(`%s="%s"`, commandOption, escaped))
+	}
+	if r.NoPortForwarding {
+		opts = append(opts, noPortForwardingOption)
+	}
+	if r.NoPTY {
+		opts = append(opts, noPTYOption)
+	}
+	if r.NoX11Forwarding {
+		opts = append(opts, noX11ForwardingOption)
+	}
+	return opts
+}
+
+// String lists r as its options, e.g. from="10.0.0.0/8",no-pty
+func (r KeyRestrictions) String() string {
+	return strings.Join(r.Options(), ",")
+}
+
+// parseKeyRestrictions picks the restrictions out of a key's options,
+// reporting which options it used
+func parseKeyRestrictions(options []string) (KeyRestrictions, []bool) {
+	var r KeyRestrictions
+	used := make([]bool, len(options))
+	for i, opt := range options {
+		name, value, _ := strings.Cut(opt, "=")
+		value = strings.ReplaceAll(strings.TrimSuffix(strings.TrimPrefix(value, `"`), `"`), `\"`, `"`)
+		switch strings.ToLower(name) {
+		case commandOption:
+			r.Command = value
+		case fromOption:
+			r.From = strings.Split(value, ",")
+		case noPortForwardingOption:
+			r.NoPortForwarding = true
+		case noPTYOption:
+			r.NoPTY = true
+		case noX11ForwardingOption:
+			r.NoX11Forwarding = true
+		default:
+			continue
+		}
+		used[i] = true
+	}
+	return r, used
+}
+
+// restrictionsOf returns the restrictions of an authorized_keys line
+func restrictionsOf(line string) KeyRestrictions {
+	_, _, options, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		return KeyRestrictions{}
+	}
+	r, _ := parseKeyRestrictions(options)
+	return r
+}
+
+// WithRestrictions returns a copy of key whose authorized_keys line carries
+// r in place of the restrictions it had. Its other options, such as its
+// expiry, are kept.
+func WithRestrictions(key SSHPublicKey, r KeyRestrictions) (SSHPublicKey, error) {
+	if err := r.Validate(); err != nil {
+		return key, err
+	}
+	line, err := rewriteOptions(key.PublicKey, func(opt string) bool {
+		_, used := parseKeyRestrictions([]string{opt})
+		return used[0]
+	}, r.Options())
+	if err != nil {
+		return key, err
+	}
+	key.PublicKey = line
+	key.Restrictions = r
+	return key, nil
+}
+
+// withoutOptions returns an authorized_keys line with all its options
+// removed, leaving the key and its comment
+func withoutOptions(line string) (string, error) {
+	return rewriteOptions(line, func(string) bool { return true }, nil)
+}
+
+// rewriteOptions returns an authorized_keys line with the options drop
+// picks replaced by add
+func rewriteOptions(line string, drop func(opt string) bool, add []string) (string, error) {
+	pub, comment, options, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		return "", fmt.Errorf("invalid SSH key: %w", err)
+	}
+
+	var kept []string
+	for _, opt := range options {
+		if !drop(opt) {
+			kept = append(kept, opt)
+		}
+	}
+	kept = append(kept, add...)
+
+	var b strings.Builder
+	if len(kept) > 0 {
+		b.WriteString(strings.Join(kept, ","))
+		b.WriteString(" ")
+	}
+	b.WriteString(strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub))))
+	if comment != "" {
+		b.WriteString(" ")
+		b.WriteString(comment)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,105 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Key server types, by the API their keys are published with
+const (
+	KeyServerGitHub = "github" // github.com and GitHub Enterprise
+	KeyServerGitLab = "gitlab" // gitlab.com and self-hosted GitLab
+	KeyServerGitea  = "gitea"  // Gitea, Forgejo and Codeberg
+	KeyServerURL    = "url"    // any URL serving authorized_keys lines
+)
+
+// KeySourceGitea marks keys imported from a Gitea server
+const KeySourceGitea = "gitea"
+
+// KeyServer is a place users publish their SSH public keys, imported with
+// `tunnel keys import --source`
+type KeyServer struct {
+	Name    string
+	Type    string // KeyServerGitHub, KeyServerGitLab, KeyServerGitea or KeyServerURL
+	BaseURL string // the server, e.g. https://gitlab.example.com; for KeyServerURL the keys' URL, {user} standing for the user
+	Token   string // API token for private instances; empty sends none
+}
+
+// The public key servers
+var (
+	GitHubKeyServer   = KeyServer{Name: "github", Type: KeyServerGitHub, BaseURL: "https://github.com"}
+	GitLabKeyServer   = KeyServer{Name: "gitlab", Type: KeyServerGitLab, BaseURL: "https://gitlab.com"}
+	CodebergKeyServer = KeyServer{Name: "codeberg", Type: KeyServerGitea, BaseURL: "https://codeberg.org"}
+)
+
+// BuiltinKeyServers are the key servers there without any configured
+var BuiltinKeyServers = []KeyServer{GitHubKeyServer, GitLabKeyServer, CodebergKeyServer}
+
+// Validate checks that s names a known type and an http(s) URL
+func (s KeyServer) Validate() error {
+	switch s.Type {
+	case KeyServerGitHub, KeyServerGitLab, KeyServerGitea, KeyServerURL:
+	default:
+		return fmt.Errorf("key server %s: unknown type %q (must be github, gitlab, gitea or url)", s.Name, s.Type)
+	}
+	u, err := url.Parse(strings.ReplaceAll(s.BaseURL, "{user}", "user"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("key server %s: url must be an http or https URL, got %q", s.Name, s.BaseURL)
+	}
+	return nil
+}
+
+// KeysURL returns where s serves the keys of username. All of GitHub,
+// GitLab and Gitea serve them at /<user>.keys.
+func (s KeyServer) KeysURL(username string) string {
+	if s.Type == KeyServerURL {
+		return strings.ReplaceAll(s.BaseURL, "{user}", url.PathEscape(username))
+	}
+	return strings.TrimSuffix(s.BaseURL, "/") + "/" + url.PathEscape(username) + ".keys"
+}
+
+// source returns the key source recorded for keys imported from s
+func (s KeyServer) source() string {
+	switch s.Type {
+	case KeyServerGitHub:
+		return KeySourceGitHub
+	case KeyServerGitLab:
+		return KeySourceGitLab
+	case KeyServerGitea:
+		return KeySourceGitea
+	}
+	return KeySourceURL
+}
+
+// keysRequest builds the request for the keys of username, carrying the
+// token the way s's API expects it
+func (s KeyServer) keysRequest(username string) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodGet, s.KeysURL(username), nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.Token == "" {
+		return req, nil
+	}
+	switch s.Type {
+	case KeyServerGitLab:
+		req.Header.Set("PRIVATE-TOKEN", s.Token)
+	case KeyServerGitea:
+		req.Header.Set("Authorization", "token "+s.Token)
+	default:
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+	return req, nil
+}
+
+// keyComment is the comment given keys imported from s, e.g.
+// codeberg.org/alice
+func (s KeyServer) keyComment(username string) string {
+	u, err := url.Parse(s.BaseURL)
+	if err != nil || s.Type == KeyServerURL {
+		return strings.TrimPrefix(strings.TrimPrefix(s.KeysURL(username), "https://"), "http://")
+	}
+	return u.Host + "/" + username
+}
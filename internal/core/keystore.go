@@ -0,0 +1,127 @@
+package core
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// Key sources record where a key came from
+const (
+	KeySourceManual = "manual"
+	KeySourceGitHub = "github"
+	KeySourceGitLab = "gitlab"
+	KeySourceURL    = "url"
+	KeySourceFile   = "file"
+
+	// KeySourceRotation marks keys TUNNEL generated for a managed identity
+	KeySourceRotation = "rotation"
+)
+
+// KeyStore is the source of truth for a key manager's keys when configured.
+// The manager still exports every change to authorized_keys, since that file
+// is what sshd reads.
+type KeyStore interface {
+	// Load returns every stored key in authorized_keys order
+	Load() ([]SSHPublicKey, error)
+	// Save replaces the stored keys in a single transaction
+	Save(keys []SSHPublicKey) error
+	// Close releases the store
+	Close() error
+}
+
+// ErrReadOnlyKeyStore is returned when changing keys held in a read-only store
+var ErrReadOnlyKeyStore = errors.New("key store is read-only")
+
+// readOnlyKeyStore is implemented by stores whose keys are managed elsewhere
+type readOnlyKeyStore interface {
+	ReadOnly() bool
+}
+
+// isReadOnly reports whether store refuses writes
+func isReadOnly(store KeyStore) bool {
+	ro, ok := store.(readOnlyKeyStore)
+	return ok && ro.ReadOnly()
+}
+
+// KeyQuerier is implemented by stores that can filter keys themselves rather
+// than having the manager load and filter every key
+type KeyQuerier interface {
+	Query(q KeyQuery) ([]SSHPublicKey, error)
+}
+
+// KeyQuery selects keys by owner, origin, status and age. Zero fields match
+// every key.
+type KeyQuery struct {
+	User        string
+	Source      string
+	Status      string
+	AddedBefore time.Time
+}
+
+// Matches reports whether key satisfies every set field of q
+func (q KeyQuery) Matches(key SSHPublicKey) bool {
+	if q.User != "" && key.User != q.User {
+		return false
+	}
+	if q.Source != "" && key.Source != q.Source {
+		return false
+	}
+	if q.Status != "" && key.Status != q.Status {
+		return false
+	}
+	if !q.AddedBefore.IsZero() && !key.AddedAt.Before(q.AddedBefore) {
+		return false
+	}
+	return true
+}
+
+// filterKeys returns the keys matching q
+func filterKeys(keys []SSHPublicKey, q KeyQuery) []SSHPublicKey {
+	var matched []SSHPublicKey
+	for _, key := range keys {
+		if q.Matches(key) {
+			matched = append(matched, key)
+		}
+	}
+	return matched
+}
+
+// KeyMatch is a key found by SearchKeys and the field that matched
+type KeyMatch struct {
+	Key   SSHPublicKey `json:"key"`
+	Field string       `json:"field"` // fingerprint, comment, user, source or note
+	Value string       `json:"value"` // the matching field's value
+}
+
+// SearchKeys returns the keys whose fingerprint starts with query, with or
+// without its SHA256: prefix, or whose comment, owner, source or note
+// contains it regardless of case
+func SearchKeys(keys []SSHPublicKey, query string) []KeyMatch {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil
+	}
+	prefix := strings.TrimPrefix(query, "SHA256:")
+	lower := strings.ToLower(query)
+
+	var matches []KeyMatch
+	for _, key := range keys {
+		if strings.HasPrefix(strings.TrimPrefix(key.Fingerprint, "SHA256:"), prefix) {
+			matches = append(matches, KeyMatch{Key: key, Field: "fingerprint", Value: key.Fingerprint})
+			continue
+		}
+		for _, field := range []struct{ name, value string }{
+			{"comment", key.Comment},
+			{"user", key.User},
+			{"source", key.Source},
+			{"note", key.Note},
+		} {
+			if field.value != "" && strings.Contains(strings.ToLower(field.value), lower) {
+				matches = append(matches, KeyMatch{Key: key, Field: field.name, Value: field.value})
+				break
+			}
+		}
+	}
+	return matches
+}
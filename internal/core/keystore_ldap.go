@@ -0,0 +1,229 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/jedarden/tunnel/pkg/config"
+)
+
+// KeySourceLDAP marks keys read from an LDAP directory
+const KeySourceLDAP = "ldap"
+
+// ldapSearchTimeout bounds a single directory search
+const ldapSearchTimeout = 15 * time.Second
+
+// LDAPKeyStore reads public keys from an LDAP directory, typically the
+// sshPublicKey attribute of the openssh-lpk schema. It is read-only: keys are
+// managed in the directory, and the key manager exports them to
+// authorized_keys. Searches run through the OpenLDAP ldapsearch client.
+type LDAPKeyStore struct {
+	cfg config.LDAPConfig
+
+	// search runs ldapsearch with args and returns its LDIF output
+	search func(ctx context.Context, args []string) ([]byte, error)
+}
+
+// NewLDAPKeyStore creates a key store for the directory described by cfg
+func NewLDAPKeyStore(cfg config.LDAPConfig) *LDAPKeyStore {
+	if cfg.Filter == "" {
+		cfg.Filter = "(objectClass=ldapPublicKey)"
+	}
+	if cfg.UserAttribute == "" {
+		cfg.UserAttribute = "uid"
+	}
+	if cfg.KeyAttribute == "" {
+		cfg.KeyAttribute = "sshPublicKey"
+	}
+	return &LDAPKeyStore{cfg: cfg, search: runLDAPSearch}
+}
+
+// ReadOnly reports that keys cannot be written back to the directory
+func (s *LDAPKeyStore) ReadOnly() bool {
+	return true
+}
+
+// Load returns every key found under the base DN
+func (s *LDAPKeyStore) Load() ([]SSHPublicKey, error) {
+	return s.Query(KeyQuery{})
+}
+
+// Query searches the directory, narrowing the LDAP filter by user when set
+func (s *LDAPKeyStore) Query(q KeyQuery) ([]SSHPublicKey, error) {
+	filter := s.cfg.Filter
+	if q.User != "" {
+		filter = fmt.Sprintf("(&%s(%s=%s))", filter, s.cfg.UserAttribute, escapeLDAPFilter(q.User))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ldapSearchTimeout)
+	defer cancel()
+
+	out, err := s.search(ctx, s.searchArgs(filter))
+	if err != nil {
+		return nil, fmt.Errorf("search LDAP: %w", err)
+	}
+
+	var keys []SSHPublicKey
+	for _, entry := range parseLDIF(out) {
+		user := entry.first(s.cfg.UserAttribute)
+		for _, line := range entry.values(s.cfg.KeyAttribute) {
+			key, err := ParseSSHPublicKey(line)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: invalid key for %s in LDAP: %v\n", entry.dn, err)
+				continue
+			}
+			key.User = user
+			key.Source = KeySourceLDAP
+			keys = append(keys, *key)
+		}
+	}
+
+	// The directory already matched the user, case-insensitively
+	q.User = ""
+	return filterKeys(keys, q), nil
+}
+
+// Save fails: directory keys are changed in LDAP, not through TUNNEL
+func (s *LDAPKeyStore) Save(keys []SSHPublicKey) error {
+	return ErrReadOnlyKeyStore
+}
+
+// Close is a no-op; each search opens its own connection
+func (s *LDAPKeyStore) Close() error {
+	return nil
+}
+
+// searchArgs builds the ldapsearch command line for filter
+func (s *LDAPKeyStore) searchArgs(filter string) []string {
+	args := []string{"-LLL", "-x", "-o", "ldif-wrap=no", "-H", s.cfg.URL, "-b", s.cfg.BaseDN}
+	if s.cfg.BindDN != "" {
+		args = append(args, "-D", s.cfg.BindDN)
+		if s.cfg.BindPasswordFile != "" {
+			args = append(args, "-y", s.cfg.BindPasswordFile)
+		}
+	}
+	return append(args, filter, s.cfg.UserAttribute, s.cfg.KeyAttribute)
+}
+
+// runLDAPSearch executes ldapsearch, returning stderr in the error on failure
+func runLDAPSearch(ctx context.Context, args []string) ([]byte, error) {
+	path, err := exec.LookPath("ldapsearch")
+	if err != nil {
+		return nil, errors.New("ldapsearch not found; install the OpenLDAP client tools")
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+// ldifEntry is one directory entry from an LDIF stream
+type ldifEntry struct {
+	dn    string
+	attrs map[string][]string // keyed by lower-cased attribute name
+}
+
+func (e ldifEntry) values(attr string) []string {
+	return e.attrs[strings.ToLower(attr)]
+}
+
+func (e ldifEntry) first(attr string) string {
+	if v := e.values(attr); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// parseLDIF parses ldapsearch output, unfolding continuation lines and
+// decoding base64 ("attr:: value") values
+func parseLDIF(data []byte) []ldifEntry {
+	var entries []ldifEntry
+	var current *ldifEntry
+
+	flush := func() {
+		if current != nil && current.dn != "" {
+			entries = append(entries, *current)
+		}
+		current = nil
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.HasPrefix(line, " ") && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	for _, line := range lines {
+		if line == "" {
+			flush()
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		if encoded, isBase64 := strings.CutPrefix(value, ":"); isBase64 {
+			decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+			if err != nil {
+				continue
+			}
+			value = string(decoded)
+		} else {
+			value = strings.TrimPrefix(value, " ")
+		}
+
+		if current == nil {
+			current = &ldifEntry{attrs: make(map[string][]string)}
+		}
+		if strings.EqualFold(name, "dn") {
+			current.dn = value
+			continue
+		}
+		key := strings.ToLower(name)
+		current.attrs[key] = append(current.attrs[key], value)
+	}
+	flush()
+
+	return entries
+}
+
+// escapeLDAPFilter escapes a value for use inside an LDAP search filter
+// (RFC 4515)
+func escapeLDAPFilter(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '\\', '*', '(', ')', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,179 @@
+package core
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // registers the pure-Go "sqlite" driver
+)
+
+// SQLiteDriver is the database/sql driver name the SQLite key store uses
+const SQLiteDriver = "sqlite"
+
+// sqlKeySchema creates the key table; position preserves authorized_keys order
+const sqlKeySchema = `
+CREATE TABLE IF NOT EXISTS ssh_keys (
+	position    INTEGER PRIMARY KEY,
+	fingerprint TEXT NOT NULL,
+	owner       TEXT NOT NULL DEFAULT '',
+	source      TEXT NOT NULL DEFAULT '',
+	type        TEXT NOT NULL,
+	public_key  TEXT NOT NULL,
+	comment     TEXT NOT NULL DEFAULT '',
+	note        TEXT NOT NULL DEFAULT '',
+	status      TEXT NOT NULL DEFAULT 'active',
+	added_at    INTEGER NOT NULL,
+	last_used   INTEGER,
+	expires_at  INTEGER
+);
+CREATE INDEX IF NOT EXISTS ssh_keys_fingerprint ON ssh_keys (fingerprint);
+CREATE INDEX IF NOT EXISTS ssh_keys_owner ON ssh_keys (owner);
+CREATE INDEX IF NOT EXISTS ssh_keys_added_at ON ssh_keys (added_at);
+`
+
+const sqlKeyColumns = `fingerprint, owner, source, type, public_key, comment, note, status, added_at, last_used, expires_at`
+
+// sqlKeyMigrations bring tables created by earlier versions up to
+// sqlKeySchema; each fails harmlessly once applied
+var sqlKeyMigrations = []string{
+	`ALTER TABLE ssh_keys ADD COLUMN note TEXT NOT NULL DEFAULT ''`,
+}
+
+// SQLKeyStore keeps keys and their metadata in a SQL database
+type SQLKeyStore struct {
+	db *sql.DB
+}
+
+// OpenSQLKeyStore opens the database and creates the key table if needed
+func OpenSQLKeyStore(driver, dsn string) (*SQLKeyStore, error) {
+	if !slices.Contains(sql.Drivers(), driver) {
+		return nil, fmt.Errorf("open key store: %s driver is not compiled into this build", driver)
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open key store: %w", err)
+	}
+	if _, err := db.Exec(sqlKeySchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create key store schema: %w", err)
+	}
+	for _, migration := range sqlKeyMigrations {
+		_, _ = db.Exec(migration)
+	}
+
+	return &SQLKeyStore{db: db}, nil
+}
+
+// Load returns every stored key in authorized_keys order
+func (s *SQLKeyStore) Load() ([]SSHPublicKey, error) {
+	return s.Query(KeyQuery{})
+}
+
+// Query returns the keys matching q
+func (s *SQLKeyStore) Query(q KeyQuery) ([]SSHPublicKey, error) {
+	var where []string
+	var args []interface{}
+	if q.User != "" {
+		where = append(where, "owner = ?")
+		args = append(args, q.User)
+	}
+	if q.Source != "" {
+		where = append(where, "source = ?")
+		args = append(args, q.Source)
+	}
+	if q.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, q.Status)
+	}
+	if !q.AddedBefore.IsZero() {
+		where = append(where, "added_at < ?")
+		args = append(args, q.AddedBefore.UnixNano())
+	}
+
+	query := "SELECT " + sqlKeyColumns + " FROM ssh_keys"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY position"
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []SSHPublicKey
+	for rows.Next() {
+		var key SSHPublicKey
+		var addedAt int64
+		var lastUsed, expiresAt sql.NullInt64
+		if err := rows.Scan(&key.Fingerprint, &key.User, &key.Source, &key.Type, &key.PublicKey,
+			&key.Comment, &key.Note, &key.Status, &addedAt, &lastUsed, &expiresAt); err != nil {
+			return nil, fmt.Errorf("scan key: %w", err)
+		}
+
+		key.ID = key.Fingerprint
+		key.Restrictions = restrictionsOf(key.PublicKey)
+		key.AddedAt = time.Unix(0, addedAt)
+		if lastUsed.Valid {
+			key.LastUsed = time.Unix(0, lastUsed.Int64)
+		}
+		if expiresAt.Valid {
+			t := time.Unix(0, expiresAt.Int64)
+			key.ExpiresAt = &t
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query keys: %w", err)
+	}
+	return keys, nil
+}
+
+// Save replaces every stored key in one transaction, so a multi-key change
+// is either fully applied or not at all
+func (s *SQLKeyStore) Save(keys []SSHPublicKey) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin key transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM ssh_keys"); err != nil {
+		return fmt.Errorf("clear keys: %w", err)
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO ssh_keys (position, " + sqlKeyColumns + ") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("prepare key insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for i, key := range keys {
+		var lastUsed, expiresAt sql.NullInt64
+		if !key.LastUsed.IsZero() {
+			lastUsed = sql.NullInt64{Int64: key.LastUsed.UnixNano(), Valid: true}
+		}
+		if key.ExpiresAt != nil {
+			expiresAt = sql.NullInt64{Int64: key.ExpiresAt.UnixNano(), Valid: true}
+		}
+		if _, err := stmt.Exec(i, key.Fingerprint, key.User, key.Source, key.Type, key.PublicKey,
+			key.Comment, key.Note, key.Status, key.AddedAt.UnixNano(), lastUsed, expiresAt); err != nil {
+			return fmt.Errorf("insert key %s: %w", key.Fingerprint, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit keys: %w", err)
+	}
+	return nil
+}
+
+// Close closes the database
+func (s *SQLKeyStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,236 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jedarden/tunnel/pkg/config"
+)
+
+// memKeyStore is an in-memory KeyStore
+type memKeyStore struct {
+	keys  []SSHPublicKey
+	saves int
+}
+
+func (m *memKeyStore) Load() ([]SSHPublicKey, error) {
+	return append([]SSHPublicKey(nil), m.keys...), nil
+}
+
+func (m *memKeyStore) Save(keys []SSHPublicKey) error {
+	m.keys = append([]SSHPublicKey(nil), keys...)
+	m.saves++
+	return nil
+}
+
+func (m *memKeyStore) Close() error { return nil }
+
+func TestKeyManagerWithStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "authorized_keys")
+	if err := os.WriteFile(path, []byte(testRSAKey+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	store := &memKeyStore{}
+	km, err := NewFileKeyManagerWithStore(path, store, nil)
+	if err != nil {
+		t.Fatalf("NewFileKeyManagerWithStore() error = %v", err)
+	}
+	if len(store.keys) != 1 {
+		t.Fatalf("expected store seeded from authorized_keys, got %d keys", len(store.keys))
+	}
+
+	// Metadata authorized_keys cannot hold survives in the store
+	old := time.Now().Add(-400 * 24 * time.Hour)
+	store.keys[0].AddedAt = old
+
+	key, _ := km.ValidateKey(testED25519Key)
+	key.Source = KeySourceGitHub
+	if err := km.AddKey("alice", *key); err != nil {
+		t.Fatalf("AddKey() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), testED25519Key) || !strings.Contains(string(data), testRSAKey) {
+		t.Errorf("expected both keys exported to authorized_keys, got:\n%s", data)
+	}
+
+	byUser, err := km.QueryKeys(KeyQuery{User: "alice"})
+	if err != nil {
+		t.Fatalf("QueryKeys() error = %v", err)
+	}
+	if len(byUser) != 1 || byUser[0].Source != KeySourceGitHub {
+		t.Errorf("QueryKeys(user=alice) = %+v, want the GitHub key", byUser)
+	}
+
+	stale, _ := km.QueryKeys(KeyQuery{AddedBefore: time.Now().Add(-365 * 24 * time.Hour)})
+	if len(stale) != 1 || !stale[0].AddedAt.Equal(old) {
+		t.Errorf("QueryKeys(older than 1y) = %+v, want the seeded key", stale)
+	}
+}
+
+func TestKeyQueryMatches(t *testing.T) {
+	now := time.Now()
+	key := SSHPublicKey{User: "alice", Source: KeySourceGitLab, Status: "active", AddedAt: now.Add(-time.Hour)}
+
+	tests := []struct {
+		name  string
+		query KeyQuery
+		want  bool
+	}{
+		{"empty", KeyQuery{}, true},
+		{"user", KeyQuery{User: "alice"}, true},
+		{"other user", KeyQuery{User: "bob"}, false},
+		{"source and status", KeyQuery{Source: KeySourceGitLab, Status: "active"}, true},
+		{"other source", KeyQuery{Source: KeySourceGitHub}, false},
+		{"added before now", KeyQuery{AddedBefore: now}, true},
+		{"added before two hours ago", KeyQuery{AddedBefore: now.Add(-2 * time.Hour)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.query.Matches(key); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchKeys(t *testing.T) {
+	keys := []SSHPublicKey{
+		{Fingerprint: "SHA256:abcDEF123", Comment: "alice@laptop", User: "alice", Source: KeySourceGitHub},
+		{Fingerprint: "SHA256:xyz789", Comment: "ci", User: "deploy", Source: KeySourceManual, Note: "Old Jenkins box"},
+	}
+
+	tests := []struct {
+		query string
+		want  []string // matching fields, in key order
+	}{
+		{"SHA256:abc", []string{"fingerprint"}},
+		{"xyz", []string{"fingerprint"}},
+		{"ABC", nil}, // fingerprints are case-sensitive
+		{"LAPTOP", []string{"comment"}},
+		{"deploy", []string{"user"}},
+		{"git", []string{"source"}},
+		{"jenkins", []string{"note"}},
+		{"e", []string{"comment", "user"}},
+		{"  ", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			var got []string
+			for _, m := range SearchKeys(keys, tt.query) {
+				got = append(got, m.Field)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("SearchKeys(%q) matched %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSQLKeyStore(t *testing.T) {
+	if !slices.Contains(sql.Drivers(), SQLiteDriver) {
+		t.Fatal("sqlite driver is not compiled in")
+	}
+	if _, err := OpenSQLKeyStore("nosuchdriver", ":memory:"); err == nil {
+		t.Fatal("expected an error for an unregistered driver")
+	}
+
+	store, err := OpenSQLKeyStore(SQLiteDriver, filepath.Join(t.TempDir(), "keys.db"))
+	if err != nil {
+		t.Fatalf("OpenSQLKeyStore() error = %v", err)
+	}
+	defer store.Close()
+
+	km := &FileKeyManager{}
+	a, _ := km.ValidateKey(testED25519Key)
+	a.User, a.Source = "alice", KeySourceGitHub
+	b, _ := km.ValidateKey(testRSAKey)
+	b.User, b.Source = "bob", KeySourceManual
+	expires := time.Now().Add(24 * time.Hour)
+	b.ExpiresAt = &expires
+
+	if err := store.Save([]SSHPublicKey{*a, *b}); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	keys, err := store.Load()
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("Load() = %d keys, %v", len(keys), err)
+	}
+	if keys[0].Fingerprint != a.Fingerprint || keys[1].ExpiresAt == nil {
+		t.Errorf("Load() did not preserve order and metadata: %+v", keys)
+	}
+
+	bob, err := store.Query(KeyQuery{User: "bob"})
+	if err != nil || len(bob) != 1 || bob[0].Fingerprint != b.Fingerprint {
+		t.Errorf("Query(user=bob) = %+v, %v", bob, err)
+	}
+}
+
+func TestLDAPKeyStore(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString([]byte(testRSAKey))
+	ldif := "dn: uid=alice,ou=people,dc=example,dc=com\n" +
+		"uid: alice\n" +
+		"sshPublicKey: " + testED25519Key[:40] + "\n " + testED25519Key[40:] + "\n" +
+		"sshPublicKey:: " + encoded + "\n" +
+		"\n" +
+		"dn: uid=bob,ou=people,dc=example,dc=com\n" +
+		"uid: bob\n" +
+		"sshPublicKey: not-a-key\n"
+
+	store := NewLDAPKeyStore(config.LDAPConfig{URL: "ldap://ldap.example.com", BaseDN: "dc=example,dc=com"})
+	var lastArgs []string
+	store.search = func(ctx context.Context, args []string) ([]byte, error) {
+		lastArgs = args
+		return []byte(ldif), nil
+	}
+
+	keys, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("Load() returned %d keys, want 2 (invalid key skipped)", len(keys))
+	}
+	for _, key := range keys {
+		if key.User != "alice" || key.Source != KeySourceLDAP {
+			t.Errorf("key %s has user %q source %q", key.Fingerprint, key.User, key.Source)
+		}
+	}
+
+	if _, err := store.Query(KeyQuery{User: "al*ce)"}); err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if filter := lastArgs[len(lastArgs)-3]; filter != `(&(objectClass=ldapPublicKey)(uid=al\2ace\29))` {
+		t.Errorf("Query() filter = %s", filter)
+	}
+
+	// The directory is the source of truth: no seeding, no writes
+	path := filepath.Join(t.TempDir(), "authorized_keys")
+	km, err := NewFileKeyManagerWithStore(path, store, nil)
+	if err != nil {
+		t.Fatalf("NewFileKeyManagerWithStore() error = %v", err)
+	}
+	if err := km.AddKey("carol", keys[0]); !errors.Is(err, ErrReadOnlyKeyStore) {
+		t.Errorf("AddKey() error = %v, want ErrReadOnlyKeyStore", err)
+	}
+	if n, err := km.SyncAuthorizedKeys(); err != nil || n != 2 {
+		t.Fatalf("SyncAuthorizedKeys() = %d, %v", n, err)
+	}
+	exported, err := km.readAuthorizedKeys()
+	if err != nil || len(exported) != 2 {
+		t.Errorf("authorized_keys holds %d keys after sync, want 2 (%v)", len(exported), err)
+	}
+}
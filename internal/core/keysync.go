@@ -0,0 +1,131 @@
+package core
+
+import (
+	"time"
+)
+
+// KeySyncMapping ties a local key owner to their account on a key server
+type KeySyncMapping struct {
+	User    string // owner of the keys here
+	Account string // name on the server
+	Server  KeyServer
+}
+
+// KeySyncResult is what syncing one mapping changed
+type KeySyncResult struct {
+	User    string   `json:"user"`
+	Source  string   `json:"source"`
+	Account string   `json:"account"`
+	Added   []string `json:"added,omitempty"`   // fingerprints
+	Removed []string `json:"removed,omitempty"` // fingerprints
+	Err     error    `json:"-"`
+}
+
+// SyncKeys brings each mapped user's keys in line with what they publish:
+// keys new upstream are added and keys from the same kind of server that
+// are no longer published are revoked. A user whose keys couldn't all be
+// fetched keeps every key. Changes are audited; with dryRun nothing is
+// changed and the results say what would be.
+func (km *FileKeyManager) SyncKeys(mappings []KeySyncMapping, dryRun bool) []KeySyncResult {
+	type group struct{ user, source string }
+	results := make([]KeySyncResult, len(mappings))
+	fetched := make([][]SSHPublicKey, len(mappings))
+	published := make(map[group]map[string]bool)
+	failed := make(map[group]bool)
+	for i, m := range mappings {
+		g := group{m.User, m.Server.source()}
+		results[i] = KeySyncResult{User: m.User, Source: m.Server.Name, Account: m.Account}
+		fetched[i], results[i].Err = km.fetchKeys(m.Server, m.Account)
+		if results[i].Err != nil {
+			failed[g] = true
+			continue
+		}
+		if published[g] == nil {
+			published[g] = make(map[string]bool)
+		}
+		for _, key := range fetched[i] {
+			published[g][key.Fingerprint] = true
+		}
+	}
+
+	existing, err := km.ListKeys("")
+	if err != nil {
+		for i := range results {
+			results[i].Err = err
+		}
+		return results
+	}
+	have := make(map[string]bool, len(existing))
+	for _, key := range existing {
+		have[key.Fingerprint] = true
+	}
+
+	pruned := make(map[group]bool)
+	for i, m := range mappings {
+		r := &results[i]
+		if r.Err != nil {
+			continue
+		}
+
+		// A key already here, whoever it belongs to, is left alone
+		for _, key := range fetched[i] {
+			if have[key.Fingerprint] {
+				continue
+			}
+			if !dryRun {
+				if err := km.AddKey(m.User, key); err != nil {
+					r.Err = err
+					break
+				}
+			}
+			have[key.Fingerprint] = true
+			r.Added = append(r.Added, key.Fingerprint)
+		}
+
+		// Each user's keys from a kind of server are pruned once, against
+		// the keys of all their accounts there
+		g := group{m.User, m.Server.source()}
+		if failed[g] || pruned[g] {
+			continue
+		}
+		pruned[g] = true
+		for _, key := range existing {
+			if key.User != m.User || key.Source != g.source || published[g][key.Fingerprint] {
+				continue
+			}
+			if !dryRun {
+				if err := km.RemoveKey(m.User, key.Fingerprint); err != nil {
+					r.Err = err
+					break
+				}
+			}
+			r.Removed = append(r.Removed, key.Fingerprint)
+		}
+	}
+
+	if km.auditLogger != nil && !dryRun {
+		for i, r := range results {
+			if len(r.Added) == 0 && len(r.Removed) == 0 && r.Err == nil {
+				continue
+			}
+			details := map[string]interface{}{
+				"source":  mappings[i].Server.KeysURL(r.Account),
+				"account": r.Account,
+				"added":   r.Added,
+				"removed": r.Removed,
+			}
+			if r.Err != nil {
+				details["error"] = r.Err.Error()
+			}
+			_ = km.auditLogger.Log(AuditEvent{
+				Timestamp: time.Now(),
+				EventType: "keys_synced",
+				Method:    r.Source,
+				User:      r.User,
+				Details:   details,
+				Success:   r.Err == nil,
+			})
+		}
+	}
+	return results
+}
@@ -0,0 +1,64 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestSyncKeys tests adding and pruning keys as they change upstream
+func TestSyncKeys(t *testing.T) {
+	km, _, cleanup := setupTestKeyManager(t)
+	defer cleanup()
+
+	published := testED25519Key
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		fmt.Fprintln(w, published)
+	}))
+	defer server.Close()
+	mappings := []KeySyncMapping{{User: "alice", Account: "alice-gh", Server: KeyServer{Name: "ghe", Type: KeyServerGitHub, BaseURL: server.URL}}}
+
+	// A key alice added herself is never pruned
+	manual, _ := km.ValidateKey(testECDSAKey)
+	if err := km.AddKey("alice", *manual); err != nil {
+		t.Fatal(err)
+	}
+
+	results := km.SyncKeys(mappings, false)
+	if results[0].Err != nil || len(results[0].Added) != 1 || len(results[0].Removed) != 0 {
+		t.Fatalf("first sync = %+v, want one key added", results[0])
+	}
+
+	// The key is swapped upstream; a dry run changes nothing
+	published = testRSAKey
+	results = km.SyncKeys(mappings, true)
+	if len(results[0].Added) != 1 || len(results[0].Removed) != 1 {
+		t.Fatalf("dry run = %+v, want one added and one removed", results[0])
+	}
+	if keys, _ := km.ListKeys(""); len(keys) != 2 {
+		t.Fatalf("dry run changed the keys: %d keys", len(keys))
+	}
+
+	km.SyncKeys(mappings, false)
+	keys, _ := km.ListKeys("")
+	got := make(map[string]bool)
+	for _, key := range keys {
+		got[key.Type] = true
+	}
+	if len(keys) != 2 || !got["ssh-rsa"] || !got["ecdsa-sha2-nistp256"] {
+		t.Errorf("after sync keys = %v, want the new upstream key and the manual one", got)
+	}
+
+	// A failed fetch prunes nothing
+	status = http.StatusInternalServerError
+	results = km.SyncKeys(mappings, false)
+	if results[0].Err == nil || len(results[0].Removed) != 0 {
+		t.Errorf("sync with the server down = %+v, want an error and nothing removed", results[0])
+	}
+	if keys, _ := km.ListKeys(""); len(keys) != 2 {
+		t.Errorf("server down: %d keys left, want 2", len(keys))
+	}
+}
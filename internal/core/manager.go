@@ -3,8 +3,11 @@ package core
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
+
+	"github.com/jedarden/tunnel/internal/state"
 )
 
 // ConnectionManager defines the interface for managing tunnel connections
@@ -33,11 +36,18 @@ type ConnectionManager interface {
 type DefaultConnectionManager struct {
 	mu               sync.RWMutex
 	connections      map[string]*Connection
+	startOrder       []string                      // Connection IDs, oldest first
+	starting         map[string]int                // Starts in progress per method, held against the budget
+	operations       []Operation                   // Recent operations, oldest first
+	operationCount   map[string]int                // Operations per kind and method
 	providers        map[string]ConnectionProvider // Provider implementations
 	eventPublisher   *EventPublisher
 	metricsCollector *DefaultMetricsCollector
 	failoverManager  *FailoverManager
+	balance          balancer
 	config           *ManagerConfig
+	state            *state.Store // nil keeps no state
+	shuttingDown     bool         // connections stopped from now on are restored on the next run
 	ctx              context.Context
 	cancel           context.CancelFunc
 }
@@ -49,6 +59,17 @@ type ManagerConfig struct {
 	FailoverConfig  *FailoverConfig
 	MetricsInterval time.Duration
 	EventBufferSize int
+	MaxConnections  int // Total connections allowed; 0 is unlimited
+	MaxPerProvider  int // Connections allowed per provider; 0 is unlimited
+
+	// Mode is how connections share sessions; empty is ModeFailover
+	Mode ConnectionMode
+	// Balance is how ModeLoadBalance picks connections; empty is BalanceRoundRobin
+	Balance BalanceStrategy
+
+	// StateFile records the active connections as they change, so Restore
+	// can bring them back after a restart; empty keeps no state
+	StateFile string
 }
 
 // DefaultManagerConfig returns a manager config with sensible defaults
@@ -77,6 +98,13 @@ type ConnectionProvider interface {
 	IsHealthy(conn *Connection) bool
 }
 
+// LatencyProber is implemented by connection providers that measure a
+// connection's round trip themselves, e.g. in their health check. For
+// other providers failover times a TCP dial to the connection's endpoint.
+type LatencyProber interface {
+	MeasureLatency(ctx context.Context, conn *Connection) (time.Duration, error)
+}
+
 // NewConnectionManager creates a new connection manager
 func NewConnectionManager(config *ManagerConfig) *DefaultConnectionManager {
 	if config == nil {
@@ -95,15 +123,27 @@ func NewConnectionManager(config *ManagerConfig) *DefaultConnectionManager {
 
 	manager := &DefaultConnectionManager{
 		connections:      make(map[string]*Connection),
+		starting:         make(map[string]int),
+		operationCount:   make(map[string]int),
 		providers:        make(map[string]ConnectionProvider),
 		eventPublisher:   publisher,
 		metricsCollector: collector,
 		failoverManager:  failover,
+		balance:          newBalancer(),
 		config:           config,
 		ctx:              ctx,
 		cancel:           cancel,
 	}
 
+	if failover != nil {
+		failover.recordOperation = manager.recordFailover
+		failover.measureLatency = manager.measureLatency
+		failover.balancing.Store(config.Mode == ModeLoadBalance)
+	}
+	if config.StateFile != "" {
+		manager.state = state.NewStore(config.StateFile)
+	}
+
 	// Start metrics collection
 	if config.EnableMetrics {
 		collector.Start(ctx, config.MetricsInterval)
@@ -124,25 +164,60 @@ func (m *DefaultConnectionManager) RegisterProvider(provider ConnectionProvider)
 	m.providers[provider.Name()] = provider
 }
 
+// UnregisterProvider removes a connection provider. It fails while the
+// provider has active connections, since those could no longer be stopped.
+func (m *DefaultConnectionManager) UnregisterProvider(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, conn := range m.connections {
+		if conn.Method == name {
+			return fmt.Errorf("provider %s has active connection %s", name, conn.ID)
+		}
+	}
+
+	delete(m.providers, name)
+	return nil
+}
+
 // Start establishes a new connection using the specified method
 func (m *DefaultConnectionManager) Start(method string, config *Config) (*Connection, error) {
 	m.mu.Lock()
 	provider, exists := m.providers[method]
-	m.mu.Unlock()
-
 	if !exists {
+		m.mu.Unlock()
 		return nil, fmt.Errorf("provider %s not registered", method)
 	}
+	if err := m.reserve(method); err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+	op := m.beginOperation(OpConnect, method, "")
+	m.mu.Unlock()
 
 	// Create connection using provider
 	conn, err := provider.Connect(m.ctx, config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to start connection: %w", err)
+		m.mu.Lock()
+		m.release(method)
+		m.endOperation(&op, err)
+		m.mu.Unlock()
+
+		opErr := &OperationError{Operation: op, Err: err}
+		event := NewEvent(EventError, "", err, opErr.Error())
+		event.OperationID = op.ID
+		m.eventPublisher.Publish(event)
+		return nil, fmt.Errorf("failed to start connection: %w", opErr)
 	}
+	conn.OperationID = op.ID
 
 	// Register with manager
 	m.mu.Lock()
+	m.release(method)
+	op.ConnID = conn.ID
+	m.endOperation(&op, nil)
 	m.connections[conn.ID] = conn
+	m.startOrder = append(m.startOrder, conn.ID)
 	m.mu.Unlock()
 
 	// Register with metrics collector
@@ -155,9 +230,21 @@ func (m *DefaultConnectionManager) Start(method string, config *Config) (*Connec
 		m.failoverManager.RegisterConnection(conn)
 	}
 
+	m.saveState(func(s *state.State) {
+		s.PutConnection(state.Connection{
+			ID:         conn.ID,
+			Method:     conn.Method,
+			LocalPort:  conn.LocalPort,
+			RemoteHost: conn.RemoteHost,
+			RemotePort: conn.RemotePort,
+			StartedAt:  conn.StartedAt,
+		})
+	})
+
 	// Publish connected event
 	event := NewEvent(EventConnected, conn.ID, conn,
 		fmt.Sprintf("Connection %s started using %s", conn.ID, method))
+	event.OperationID = op.ID
 	m.eventPublisher.Publish(event)
 
 	return conn, nil
@@ -173,16 +260,26 @@ func (m *DefaultConnectionManager) Stop(connID string) error {
 	}
 
 	provider, providerExists := m.providers[conn.Method]
-	m.mu.Unlock()
-
 	if !providerExists {
+		m.mu.Unlock()
 		return fmt.Errorf("provider %s not found", conn.Method)
 	}
+	op := m.beginOperation(OpDisconnect, conn.Method, connID)
+	m.mu.Unlock()
 
 	// Disconnect using provider
 	if err := provider.Disconnect(conn); err != nil {
-		return fmt.Errorf("failed to stop connection: %w", err)
+		m.mu.Lock()
+		m.endOperation(&op, err)
+		m.mu.Unlock()
+
+		opErr := &OperationError{Operation: op, Err: err}
+		event := NewEvent(EventError, connID, err, opErr.Error())
+		event.OperationID = op.ID
+		m.eventPublisher.Publish(event)
+		return fmt.Errorf("failed to stop connection: %w", opErr)
 	}
+	conn.SetState(StateDisconnected)
 
 	// Unregister from failover
 	if m.config.EnableFailover && m.failoverManager != nil {
@@ -197,11 +294,25 @@ func (m *DefaultConnectionManager) Stop(connID string) error {
 	// Remove from manager
 	m.mu.Lock()
 	delete(m.connections, connID)
+	for i, id := range m.startOrder {
+		if id == connID {
+			m.startOrder = append(m.startOrder[:i], m.startOrder[i+1:]...)
+			break
+		}
+	}
+	m.endOperation(&op, nil)
+	shuttingDown := m.shuttingDown
 	m.mu.Unlock()
 
+	// Connections stopped by a shutdown come back with Restore
+	if !shuttingDown {
+		m.saveState(func(s *state.State) { s.RemoveConnection(connID) })
+	}
+
 	// Publish disconnected event
-	event := NewEvent(EventDisconnected, connID, nil,
+	event := NewEvent(EventDisconnected, connID, conn,
 		fmt.Sprintf("Connection %s stopped", connID))
+	event.OperationID = op.ID
 	m.eventPublisher.Publish(event)
 
 	return nil
@@ -238,6 +349,8 @@ func (m *DefaultConnectionManager) Restart(connID string) error {
 	if err != nil {
 		return fmt.Errorf("failed to start connection during restart: %w", err)
 	}
+	// Keep the timeline and cumulative uptime across the reconnect
+	newConn.inheritHistory(conn)
 
 	// Publish reconnecting event
 	event := NewEvent(EventReconnecting, newConn.ID, newConn,
@@ -427,30 +540,118 @@ func (m *DefaultConnectionManager) EnableAutoFailover(enabled bool) {
 
 // Shutdown gracefully shuts down the connection manager
 func (m *DefaultConnectionManager) Shutdown() error {
-	// Stop failover
+	return m.ShutdownContext(context.Background())
+}
+
+// ShutdownContext shuts down the connection manager, giving up once ctx is done.
+// Connections are stopped one at a time, newest first, so anything started on
+// top of an earlier connection is torn down before the connection it relies on.
+func (m *DefaultConnectionManager) ShutdownContext(ctx context.Context) error {
+	// Cancel the manager context so in-flight connects give up
+	m.cancel()
+
+	m.mu.Lock()
+	m.shuttingDown = true
+	m.mu.Unlock()
+
+	// Stop failover before tearing down connections so nothing reconnects
 	if m.failoverManager != nil {
 		m.failoverManager.Stop()
 	}
 
-	// Stop metrics collection
+	// Stop metrics collection, waiting for any in-progress collection to finish
 	if m.metricsCollector != nil {
 		m.metricsCollector.Stop()
 	}
 
-	// Stop all connections
-	if err := m.StopAll(); err != nil {
-		return err
-	}
+	err := m.stopInReverseOrder(ctx)
 
 	// Close event publisher
 	m.eventPublisher.Close()
 
-	// Cancel context
-	m.cancel()
+	return err
+}
+
+// stopInReverseOrder stops connections in the reverse of the order they started
+func (m *DefaultConnectionManager) stopInReverseOrder(ctx context.Context) error {
+	m.mu.RLock()
+	connIDs := make([]string, len(m.startOrder))
+	copy(connIDs, m.startOrder)
+	m.mu.RUnlock()
+
+	var errors []error
+	for i := len(connIDs) - 1; i >= 0; i-- {
+		if ctx.Err() != nil {
+			return fmt.Errorf("shutdown interrupted with %d connection(s) still running: %w", i+1, ctx.Err())
+		}
+		if err := m.Stop(connIDs[i]); err != nil {
+			errors = append(errors, err)
+		}
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf("errors stopping connections: %v", errors)
+	}
 
 	return nil
 }
 
+// Restore starts the connections recorded in the state file again, as after
+// a restart or reboot. Each restored connection replaces its saved entry;
+// those that fail to start stay saved for the next attempt.
+func (m *DefaultConnectionManager) Restore() ([]*Connection, error) {
+	if m.state == nil {
+		return nil, fmt.Errorf("no state file configured")
+	}
+	saved, err := m.state.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	var restored []*Connection
+	var errors []error
+	for _, c := range saved.Connections {
+		config := DefaultConfig()
+		if c.LocalPort != 0 {
+			config.LocalPort = c.LocalPort
+		}
+		if c.RemoteHost != "" {
+			config.RemoteHost = c.RemoteHost
+		}
+		if c.RemotePort != 0 {
+			config.RemotePort = c.RemotePort
+		}
+
+		conn, err := m.Start(c.Method, config)
+		if err != nil {
+			errors = append(errors, fmt.Errorf("%s: %w", c.Method, err))
+			continue
+		}
+		if conn.ID != c.ID {
+			m.saveState(func(s *state.State) { s.RemoveConnection(c.ID) })
+		}
+		restored = append(restored, conn)
+	}
+
+	if len(errors) > 0 {
+		return restored, fmt.Errorf("errors restoring connections: %v", errors)
+	}
+	return restored, nil
+}
+
+// saveState applies fn to the state file, if there is one. Failing to save
+// doesn't fail the operation that changed the state; it is reported as an
+// error event instead.
+func (m *DefaultConnectionManager) saveState(fn func(*state.State)) {
+	if m.state == nil {
+		return
+	}
+	if err := m.state.Update(fn); err != nil {
+		m.eventPublisher.Publish(NewEvent(EventError, "", err,
+			fmt.Sprintf("Failed to save connection state: %v", err)))
+	}
+}
+
 // GetMetrics exports current metrics
 func (m *DefaultConnectionManager) GetMetrics() map[string]interface{} {
 	if m.metricsCollector == nil {
@@ -459,7 +660,108 @@ func (m *DefaultConnectionManager) GetMetrics() map[string]interface{} {
 	return m.metricsCollector.Export()
 }
 
+// GetAggregatedMetrics returns rolled-up metrics for a connection over a window
+func (m *DefaultConnectionManager) GetAggregatedMetrics(connID string, window AggregationWindow) (*AggregatedMetrics, error) {
+	if m.metricsCollector == nil {
+		return nil, fmt.Errorf("metrics collection is disabled")
+	}
+	return m.metricsCollector.GetAggregated(connID, window)
+}
+
+// ConnectionThroughput is the recent transfer rates of one connection
+type ConnectionThroughput struct {
+	ID     string            `json:"id"`
+	Method string            `json:"method"`
+	Points []ThroughputPoint `json:"points"`
+}
+
+// ThroughputSince returns the transfer rates of every connection from since
+// on, ordered by method
+func (m *DefaultConnectionManager) ThroughputSince(since time.Time) []ConnectionThroughput {
+	if m.metricsCollector == nil {
+		return nil
+	}
+	conns, _ := m.List()
+	sort.Slice(conns, func(i, j int) bool {
+		if conns[i].Method != conns[j].Method {
+			return conns[i].Method < conns[j].Method
+		}
+		return conns[i].ID < conns[j].ID
+	})
+
+	result := make([]ConnectionThroughput, 0, len(conns))
+	for _, conn := range conns {
+		result = append(result, ConnectionThroughput{
+			ID:     conn.ID,
+			Method: conn.Method,
+			Points: m.metricsCollector.Throughput(conn.ID, since),
+		})
+	}
+	return result
+}
+
+// HealthLoopStalled reports a failover health loop that stopped ticking
+func (m *DefaultConnectionManager) HealthLoopStalled() error {
+	if m.failoverManager == nil {
+		return nil
+	}
+	return m.failoverManager.Stalled()
+}
+
+// RestartHealthLoop replaces the failover health loop
+func (m *DefaultConnectionManager) RestartHealthLoop() {
+	if m.failoverManager != nil {
+		m.failoverManager.Restart()
+	}
+}
+
 // GetEventPublisher returns the event publisher for external subscription
 func (m *DefaultConnectionManager) GetEventPublisher() *EventPublisher {
 	return m.eventPublisher
 }
+
+// HandleNetworkChange records a network change and re-establishes the
+// connections that did not survive it. Health checks are re-run right away
+// rather than waiting for the next failover tick, and any connection whose
+// provider reports it unhealthy is restarted.
+func (m *DefaultConnectionManager) HandleNetworkChange(reason string) error {
+	m.eventPublisher.Publish(NewEvent(EventNetworkChange, "", reason,
+		fmt.Sprintf("Network changed: %s", reason)))
+
+	if m.failoverManager != nil {
+		m.failoverManager.performHealthChecks()
+	}
+
+	type check struct {
+		conn     *Connection
+		provider ConnectionProvider
+	}
+	m.mu.RLock()
+	checks := make([]check, 0, len(m.startOrder))
+	for _, id := range m.startOrder {
+		conn := m.connections[id]
+		if provider, ok := m.providers[conn.Method]; ok {
+			checks = append(checks, check{conn, provider})
+		}
+	}
+	m.mu.RUnlock()
+
+	var stale []string
+	for _, c := range checks {
+		if !c.provider.IsHealthy(c.conn) {
+			stale = append(stale, c.conn.ID)
+		}
+	}
+
+	var errors []error
+	for _, id := range stale {
+		if err := m.Restart(id); err != nil {
+			errors = append(errors, err)
+		}
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf("errors re-establishing connections: %v", errors)
+	}
+	return nil
+}
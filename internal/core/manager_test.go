@@ -1,8 +1,14 @@
 package core
 
 import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/jedarden/tunnel/internal/state"
 )
 
 func TestConnectionManagerCreation(t *testing.T) {
@@ -301,7 +307,23 @@ func TestRestartConnection(t *testing.T) {
 	// Should have a new connection
 	connections, _ := manager.List()
 	if len(connections) != 1 {
-		t.Errorf("Expected 1 connection after restart, got %d", len(connections))
+		t.Fatalf("Expected 1 connection after restart, got %d", len(connections))
+	}
+
+	// The replacement carries the original's history: connected,
+	// disconnected by the restart, then connected again
+	history := connections[0].StateHistory()
+	want := []ConnectionState{StateConnected, StateDisconnected, StateConnected}
+	if len(history) != len(want) {
+		t.Fatalf("Expected %d transitions after restart, got %d", len(want), len(history))
+	}
+	for i, state := range want {
+		if history[i].State != state {
+			t.Errorf("Transition %d: expected %s, got %s", i, state, history[i].State)
+		}
+	}
+	if connections[0].TotalUptime() < connections[0].GetUptime() {
+		t.Error("Expected total uptime to include the earlier session")
 	}
 }
 
@@ -626,3 +648,248 @@ func TestShutdownCleansUp(t *testing.T) {
 		t.Errorf("Expected 0 subscribers after shutdown, got %d", subCount)
 	}
 }
+
+// orderedProvider records the order in which its connections are disconnected
+type orderedProvider struct {
+	*MockProvider
+	mu      *sync.Mutex
+	stopped *[]string
+}
+
+func (p *orderedProvider) Disconnect(conn *Connection) error {
+	p.mu.Lock()
+	*p.stopped = append(*p.stopped, p.Name())
+	p.mu.Unlock()
+	return p.MockProvider.Disconnect(conn)
+}
+
+func TestShutdownStopsInReverseStartOrder(t *testing.T) {
+	manager := NewConnectionManager(nil)
+
+	var mu sync.Mutex
+	var stopped []string
+	for _, name := range []string{"vpn", "tunnel", "ssh"} {
+		manager.RegisterProvider(&orderedProvider{
+			MockProvider: NewMockProvider(name, 0.0, 10*time.Millisecond),
+			mu:           &mu,
+			stopped:      &stopped,
+		})
+	}
+
+	config := DefaultConfig()
+	for _, name := range []string{"vpn", "tunnel", "ssh"} {
+		if _, err := manager.Start(name, config); err != nil {
+			t.Fatalf("Failed to start %s: %v", name, err)
+		}
+	}
+
+	if err := manager.Shutdown(); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	want := []string{"ssh", "tunnel", "vpn"}
+	if len(stopped) != len(want) {
+		t.Fatalf("Expected %d disconnects, got %v", len(want), stopped)
+	}
+	for i := range want {
+		if stopped[i] != want[i] {
+			t.Errorf("Expected stop order %v, got %v", want, stopped)
+			break
+		}
+	}
+}
+
+func TestShutdownContextExpired(t *testing.T) {
+	manager := NewConnectionManager(nil)
+	manager.RegisterProvider(NewMockProvider("mock", 0.0, 10*time.Millisecond))
+
+	if _, err := manager.Start("mock", DefaultConfig()); err != nil {
+		t.Fatalf("Failed to start connection: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := manager.ShutdownContext(ctx); err == nil {
+		t.Error("Expected error when shutdown context is already done")
+	}
+
+	// Background loops and events are still torn down
+	select {
+	case <-manager.ctx.Done():
+	default:
+		t.Error("Expected manager context to be cancelled")
+	}
+}
+
+func TestUnregisterProvider(t *testing.T) {
+	manager := NewConnectionManager(nil)
+	defer manager.Shutdown()
+
+	manager.RegisterProvider(NewMockProvider("mock", 0.0, 10*time.Millisecond))
+	conn, err := manager.Start("mock", DefaultConfig())
+	if err != nil {
+		t.Fatalf("Failed to start connection: %v", err)
+	}
+
+	if err := manager.UnregisterProvider("mock"); err == nil {
+		t.Error("Expected error unregistering a provider with active connections")
+	}
+
+	if err := manager.Stop(conn.ID); err != nil {
+		t.Fatalf("Failed to stop connection: %v", err)
+	}
+	if err := manager.UnregisterProvider("mock"); err != nil {
+		t.Errorf("UnregisterProvider() error = %v", err)
+	}
+	if _, err := manager.Start("mock", DefaultConfig()); err == nil {
+		t.Error("Expected error starting an unregistered provider")
+	}
+}
+
+func TestHandleNetworkChange(t *testing.T) {
+	manager := NewConnectionManager(nil)
+	defer manager.Shutdown()
+
+	manager.RegisterProvider(NewMockProvider("healthy", 0.0, 10*time.Millisecond))
+	manager.RegisterProvider(NewMockProvider("dropped", 0.0, 10*time.Millisecond))
+
+	healthy, err := manager.Start("healthy", DefaultConfig())
+	if err != nil {
+		t.Fatalf("Failed to start connection: %v", err)
+	}
+	dropped, err := manager.Start("dropped", DefaultConfig())
+	if err != nil {
+		t.Fatalf("Failed to start connection: %v", err)
+	}
+	dropped.SetState(StateFailed)
+
+	sub := manager.GetEventPublisher().Subscribe("test", func(e *ConnectionEvent) bool {
+		return e.Type == EventNetworkChange
+	})
+
+	if err := manager.HandleNetworkChange("default route changed"); err != nil {
+		t.Fatalf("HandleNetworkChange() error = %v", err)
+	}
+
+	select {
+	case event := <-sub.Channel:
+		if event.Data != "default route changed" {
+			t.Errorf("unexpected event data: %v", event.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a network change event")
+	}
+
+	conns, _ := manager.List()
+	if len(conns) != 2 {
+		t.Fatalf("expected 2 connections after reconnect, got %d", len(conns))
+	}
+	if _, err := manager.Status(healthy.ID); err != nil {
+		t.Error("expected the healthy connection to be left alone")
+	}
+	if _, err := manager.Status(dropped.ID); err == nil {
+		t.Error("expected the dropped connection to be replaced")
+	}
+}
+
+func TestConnectionBudget(t *testing.T) {
+	config := DefaultManagerConfig()
+	config.MaxConnections = 3
+	config.MaxPerProvider = 2
+	manager := NewConnectionManager(config)
+	defer manager.Shutdown()
+
+	for _, name := range []string{"a", "b", "c"} {
+		manager.RegisterProvider(NewMockProvider(name, 0.0, time.Millisecond))
+	}
+
+	// Starts in flight count against the budget, so only two of three
+	// concurrent starts of one provider get through
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := manager.Start("a", DefaultConfig())
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	limited := 0
+	for err := range errs {
+		if errors.Is(err, ErrConnectionLimit) {
+			limited++
+		} else if err != nil {
+			t.Fatalf("Start(a) error = %v", err)
+		}
+	}
+	if limited != 1 {
+		t.Errorf("%d starts of a hit the limit, want 1", limited)
+	}
+
+	if _, err := manager.Start("b", DefaultConfig()); err != nil {
+		t.Fatalf("Start(b) error = %v", err)
+	}
+	if _, err := manager.Start("c", DefaultConfig()); !errors.Is(err, ErrConnectionLimit) {
+		t.Errorf("Start(c) error = %v, want ErrConnectionLimit", err)
+	}
+
+	budget := manager.Budget()
+	if budget.Connections != 3 || budget.PerProvider["a"] != 2 || !budget.Exhausted() {
+		t.Errorf("Budget() = %+v, want 3 connections, 2 of a, exhausted", budget)
+	}
+}
+
+func TestStateSurvivesShutdown(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	config := DefaultManagerConfig()
+	config.EnableFailover = false
+	config.StateFile = path
+
+	manager := NewConnectionManager(config)
+	manager.RegisterProvider(NewMockProvider("mock", 0.0, time.Millisecond))
+	kept, err := manager.Start("mock", DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopped, err := manager.Start("mock", DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Stop(stopped.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := state.NewStore(path).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Connections) != 1 || saved.Connections[0].ID != kept.ID {
+		t.Fatalf("saved %+v; want only %s, which was up at shutdown", saved.Connections, kept.ID)
+	}
+
+	// The next run brings it back under a new ID
+	manager = NewConnectionManager(config)
+	defer manager.Shutdown()
+	manager.RegisterProvider(NewMockProvider("mock", 0.0, time.Millisecond))
+	restored, err := manager.Restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != 1 || restored[0].Method != "mock" {
+		t.Fatalf("restored %+v", restored)
+	}
+	saved, err = state.NewStore(path).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Connections) != 1 || saved.Connections[0].ID != restored[0].ID {
+		t.Errorf("after restore, saved %+v; want only %s", saved.Connections, restored[0].ID)
+	}
+}
@@ -6,6 +6,9 @@ import (
 	"net"
 	"sync"
 	"time"
+
+	"github.com/jedarden/tunnel/internal/logging"
+	"github.com/jedarden/tunnel/internal/providers"
 )
 
 // MetricsCollector defines the interface for collecting connection metrics
@@ -24,30 +27,35 @@ type MetricsCollector interface {
 
 	// GetConnectionMetrics returns metrics for a specific connection
 	GetConnectionMetrics(connID string) (*ConnectionMetrics, error)
+
+	// GetAggregated returns rolled-up metrics for a connection over a window
+	GetAggregated(connID string, window AggregationWindow) (*AggregatedMetrics, error)
 }
 
 // DefaultMetricsCollector implements MetricsCollector
 type DefaultMetricsCollector struct {
-	mu              sync.RWMutex
-	connections     map[string]*Connection
-	latencyHistory  map[string][]time.Duration // Historical latency data for averaging
-	historySize     int                        // Number of historical samples to keep
-	ticker          *time.Ticker
-	running         bool
-	ctx             context.Context
-	cancel          context.CancelFunc
-	wg              sync.WaitGroup
+	mu          sync.RWMutex
+	connections map[string]*Connection
+	series      map[string]*connectionSeries // Bounded sample history and rollups per connection
+	historySize int                          // Number of recent samples used for the average latency
+	bufferSize  int                          // Number of raw samples kept per connection
+	ticker      *time.Ticker
+	running     bool
+	ctx         context.Context
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
 }
 
 // NewMetricsCollector creates a new metrics collector
 func NewMetricsCollector() *DefaultMetricsCollector {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &DefaultMetricsCollector{
-		connections:    make(map[string]*Connection),
-		latencyHistory: make(map[string][]time.Duration),
-		historySize:    10, // Keep last 10 samples for averaging
-		ctx:            ctx,
-		cancel:         cancel,
+		connections: make(map[string]*Connection),
+		series:      make(map[string]*connectionSeries),
+		historySize: 10,  // Average over the last 10 samples
+		bufferSize:  360, // One hour of raw samples at a 10s interval
+		ctx:         ctx,
+		cancel:      cancel,
 	}
 }
 
@@ -63,6 +71,7 @@ func (mc *DefaultMetricsCollector) UnregisterConnection(connID string) {
 	mc.mu.Lock()
 	defer mc.mu.Unlock()
 	delete(mc.connections, connID)
+	delete(mc.series, connID)
 }
 
 // Collect gathers metrics for a specific connection
@@ -75,20 +84,14 @@ func (mc *DefaultMetricsCollector) Collect(ctx context.Context, conn *Connection
 		latency = 0 // Use 0 to indicate measurement failure
 	}
 
-	// Store in history and calculate average
-	mc.mu.Lock()
-	history := mc.latencyHistory[conn.ID]
-	history = append(history, latency)
-
-	// Keep only the most recent samples
-	if len(history) > mc.historySize {
-		history = history[len(history)-mc.historySize:]
-	}
-	mc.latencyHistory[conn.ID] = history
-
-	// Calculate average latency
-	avgLatency := mc.calculateAverageLatency(history)
-	mc.mu.Unlock()
+	// Store the sample and calculate the average over recent history
+	sent, received, _ := conn.Metrics.GetStats()
+	avgLatency := mc.RecordSample(conn.ID, MetricSample{
+		Timestamp:     time.Now(),
+		Latency:       latency,
+		BytesSent:     sent,
+		BytesReceived: received,
+	})
 
 	// Update connection metrics
 	conn.Metrics.mu.Lock()
@@ -102,6 +105,94 @@ func (mc *DefaultMetricsCollector) Collect(ctx context.Context, conn *Connection
 	return nil
 }
 
+// RecordSample adds a sample to a connection's history and rollups and
+// returns the average latency over the most recent samples
+func (mc *DefaultMetricsCollector) RecordSample(connID string, sample MetricSample) time.Duration {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	s, exists := mc.series[connID]
+	if !exists {
+		s = newConnectionSeries(mc.bufferSize)
+		mc.series[connID] = s
+	}
+	s.add(sample)
+
+	recent := s.ring.last(mc.historySize)
+	history := make([]time.Duration, len(recent))
+	for i, r := range recent {
+		history[i] = r.Latency
+	}
+	return mc.calculateAverageLatency(history)
+}
+
+// GetAggregated returns rolled-up metrics for a connection over a window
+func (mc *DefaultMetricsCollector) GetAggregated(connID string, window AggregationWindow) (*AggregatedMetrics, error) {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+
+	s, exists := mc.series[connID]
+	if !exists {
+		return nil, fmt.Errorf("no metrics for connection %s", connID)
+	}
+
+	r, ok := s.rollups[window]
+	if !ok {
+		return nil, fmt.Errorf("unsupported aggregation window: %s", window)
+	}
+
+	result := r.aggregate(time.Now())
+	return &result, nil
+}
+
+// GetSamples returns up to n of the most recent raw samples for a connection, oldest first
+func (mc *DefaultMetricsCollector) GetSamples(connID string, n int) []MetricSample {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+
+	s, exists := mc.series[connID]
+	if !exists {
+		return nil
+	}
+	return s.ring.last(n)
+}
+
+// Throughput returns a connection's transfer rates between the samples
+// taken from since on, oldest first
+func (mc *DefaultMetricsCollector) Throughput(connID string, since time.Time) []ThroughputPoint {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+
+	s, exists := mc.series[connID]
+	if !exists {
+		return nil
+	}
+	samples := s.ring.last(mc.bufferSize)
+	// Keep the sample before since, where the first interval starts
+	first := 0
+	for i, sample := range samples {
+		if sample.Timestamp.Before(since) {
+			first = i
+		}
+	}
+	return throughput(samples[first:])
+}
+
+// measureLatency measures the round trip of conn for failover, through its
+// provider when that can measure it and by a TCP dial otherwise
+func (m *DefaultConnectionManager) measureLatency(ctx context.Context, conn *Connection) (time.Duration, error) {
+	m.mu.RLock()
+	provider := m.providers[conn.Method]
+	m.mu.RUnlock()
+
+	if prober, ok := provider.(LatencyProber); ok {
+		if latency, err := prober.MeasureLatency(ctx, conn); err == nil && latency > 0 {
+			return latency, nil
+		}
+	}
+	return m.metricsCollector.measureLatency(ctx, conn)
+}
+
 // measureLatency performs actual latency measurement using TCP connection test
 func (mc *DefaultMetricsCollector) measureLatency(ctx context.Context, conn *Connection) (time.Duration, error) {
 	// Determine the target address for latency measurement
@@ -141,8 +232,9 @@ func (mc *DefaultMetricsCollector) getLatencyTarget(conn *Connection) string {
 	}
 
 	// Fallback targets based on provider type
-	switch conn.Method {
-	case "cloudflare", "cloudflared":
+	method, _ := providers.CanonicalName(conn.Method)
+	switch method {
+	case "cloudflare":
 		// Cloudflare's DNS service for latency check
 		return "1.1.1.1:443"
 	case "tailscale":
@@ -240,7 +332,9 @@ func (mc *DefaultMetricsCollector) collectAll(ctx context.Context) {
 		wg.Add(1)
 		go func(c *Connection) {
 			defer wg.Done()
-			_ = mc.Collect(ctx, c)
+			if err := mc.Collect(ctx, c); err != nil {
+				logging.For("metrics").Debug("collect failed", "connection", c.ID, "error", err)
+			}
 		}(conn)
 	}
 	wg.Wait()
@@ -292,6 +386,24 @@ func (mc *DefaultMetricsCollector) Export() map[string]interface{} {
 			"priority":       conn.GetPriority(),
 		}
 
+		if s, exists := mc.series[conn.ID]; exists {
+			now := time.Now()
+			aggregates := make(map[string]interface{}, len(AggregationWindows))
+			for _, w := range AggregationWindows {
+				agg := s.rollups[w].aggregate(now)
+				aggregates[w.String()] = map[string]interface{}{
+					"samples":        agg.Samples,
+					"failures":       agg.Failures,
+					"latency_min_ms": agg.MinLatency.Milliseconds(),
+					"latency_avg_ms": agg.AvgLatency.Milliseconds(),
+					"latency_max_ms": agg.MaxLatency.Milliseconds(),
+					"bytes_sent":     agg.BytesSent,
+					"bytes_received": agg.BytesReceived,
+				}
+			}
+			connData["aggregates"] = aggregates
+		}
+
 		connections = append(connections, connData)
 	}
 
@@ -0,0 +1,192 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestSampleRing tests that the ring buffer keeps only the newest samples
+func TestSampleRing(t *testing.T) {
+	r := newSampleRing(3)
+	if got := r.last(5); len(got) != 0 {
+		t.Fatalf("last() on empty ring = %v, want empty", got)
+	}
+
+	for i := 1; i <= 5; i++ {
+		r.add(MetricSample{Latency: time.Duration(i)})
+	}
+
+	got := r.last(10)
+	if len(got) != 3 {
+		t.Fatalf("last() returned %d samples, want 3", len(got))
+	}
+	for i, want := range []time.Duration{3, 4, 5} {
+		if got[i].Latency != want {
+			t.Errorf("last()[%d] = %v, want %v", i, got[i].Latency, want)
+		}
+	}
+
+	got = r.last(2)
+	if len(got) != 2 || got[0].Latency != 4 || got[1].Latency != 5 {
+		t.Errorf("last(2) = %v, want [4 5]", got)
+	}
+}
+
+// TestRollupAggregate tests windowed aggregation and expiry of old buckets
+func TestRollupAggregate(t *testing.T) {
+	r := newRollup(Window1m)
+	now := time.Now()
+
+	r.add(MetricSample{Timestamp: now.Add(-30 * time.Second), Latency: 10 * time.Millisecond}, 100, 200)
+	r.add(MetricSample{Timestamp: now.Add(-10 * time.Second), Latency: 30 * time.Millisecond}, 50, 0)
+	r.add(MetricSample{Timestamp: now.Add(-5 * time.Second)}, 0, 0) // failed measurement
+
+	agg := r.aggregate(now)
+	if agg.Samples != 2 || agg.Failures != 1 {
+		t.Errorf("Samples/Failures = %d/%d, want 2/1", agg.Samples, agg.Failures)
+	}
+	if agg.MinLatency != 10*time.Millisecond || agg.MaxLatency != 30*time.Millisecond {
+		t.Errorf("Min/Max = %v/%v, want 10ms/30ms", agg.MinLatency, agg.MaxLatency)
+	}
+	if agg.AvgLatency != 20*time.Millisecond {
+		t.Errorf("AvgLatency = %v, want 20ms", agg.AvgLatency)
+	}
+	if agg.BytesSent != 150 || agg.BytesReceived != 200 {
+		t.Errorf("Bytes = %d/%d, want 150/200", agg.BytesSent, agg.BytesReceived)
+	}
+
+	// Two minutes later everything has aged out of the 1m window
+	if later := r.aggregate(now.Add(2 * time.Minute)); later.Samples != 0 || later.Failures != 0 {
+		t.Errorf("aggregate after window = %+v, want empty", later)
+	}
+}
+
+// TestMetricsCollectorAggregation tests the collector's bounded history and rollups
+func TestMetricsCollectorAggregation(t *testing.T) {
+	mc := NewMetricsCollector()
+	mc.bufferSize = 20
+
+	conn := &Connection{ID: "conn-1", Method: "test", Metrics: &ConnectionMetrics{}}
+	mc.RegisterConnection(conn)
+
+	now := time.Now()
+	var avg time.Duration
+	for i := 0; i < 100; i++ {
+		avg = mc.RecordSample(conn.ID, MetricSample{
+			Timestamp: now.Add(time.Duration(i-100) * 100 * time.Millisecond),
+			Latency:   time.Duration(i+1) * time.Millisecond,
+			BytesSent: int64(i * 10),
+		})
+	}
+
+	// Average covers only the last historySize samples (91..100ms)
+	if want := 95500 * time.Microsecond; avg != want {
+		t.Errorf("RecordSample() average = %v, want %v", avg, want)
+	}
+
+	if samples := mc.GetSamples(conn.ID, 1000); len(samples) != 20 {
+		t.Errorf("GetSamples() returned %d samples, want ring size 20", len(samples))
+	}
+
+	for _, w := range AggregationWindows {
+		agg, err := mc.GetAggregated(conn.ID, w)
+		if err != nil {
+			t.Fatalf("GetAggregated(%s) error = %v", w, err)
+		}
+		if agg.Samples != 100 {
+			t.Errorf("GetAggregated(%s).Samples = %d, want 100", w, agg.Samples)
+		}
+		if agg.BytesSent != 990 {
+			t.Errorf("GetAggregated(%s).BytesSent = %d, want 990", w, agg.BytesSent)
+		}
+	}
+
+	if _, err := mc.GetAggregated(conn.ID, AggregationWindow(time.Second)); err == nil {
+		t.Error("GetAggregated() expected error for unsupported window")
+	}
+
+	mc.UnregisterConnection(conn.ID)
+	if _, err := mc.GetAggregated(conn.ID, Window1m); err == nil {
+		t.Error("GetAggregated() expected error after unregister")
+	}
+}
+
+// TestMetricsCollectorThroughput tests rates derived from the byte counters
+func TestMetricsCollectorThroughput(t *testing.T) {
+	mc := NewMetricsCollector()
+	conn := &Connection{ID: "conn-1", Method: "test", Metrics: &ConnectionMetrics{}}
+	mc.RegisterConnection(conn)
+
+	now := time.Now()
+	for i, bytes := range []int64{0, 1000, 3000, 500} { // the last after a reconnect
+		mc.RecordSample(conn.ID, MetricSample{
+			Timestamp:     now.Add(time.Duration(i-3) * 10 * time.Second),
+			BytesSent:     bytes,
+			BytesReceived: 2 * bytes,
+		})
+	}
+
+	points := mc.Throughput(conn.ID, now.Add(-25*time.Second))
+	if len(points) != 3 {
+		t.Fatalf("Throughput() returned %d points, want the 3 from the sample before since", len(points))
+	}
+	for i, want := range []float64{100, 200, 50} {
+		if points[i].Sent != want || points[i].Received != 2*want {
+			t.Errorf("point %d = %v/%v, want %v/%v", i, points[i].Sent, points[i].Received, want, 2*want)
+		}
+	}
+	if !points[2].End.Equal(now) || !points[2].Start.Equal(now.Add(-10*time.Second)) {
+		t.Errorf("last point spans %v-%v", points[2].Start, points[2].End)
+	}
+
+	if got := mc.Throughput(conn.ID, now.Add(-5*time.Second)); len(got) != 1 {
+		t.Errorf("Throughput() since the last interval returned %d points, want 1", len(got))
+	}
+	if got := mc.Throughput("unknown", now); got != nil {
+		t.Errorf("Throughput() of an unknown connection = %v", got)
+	}
+}
+
+func BenchmarkRecordSample(b *testing.B) {
+	mc := NewMetricsCollector()
+	conn := &Connection{ID: "conn-1", Method: "test", Metrics: &ConnectionMetrics{}}
+	mc.RegisterConnection(conn)
+
+	now := time.Now()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mc.RecordSample(conn.ID, MetricSample{
+			Timestamp: now.Add(time.Duration(i) * time.Second),
+			Latency:   time.Duration(i%100) * time.Millisecond,
+			BytesSent: 1024,
+		})
+	}
+}
+
+func BenchmarkGetAggregated(b *testing.B) {
+	mc := NewMetricsCollector()
+	conn := &Connection{ID: "conn-1", Method: "test", Metrics: &ConnectionMetrics{}}
+	mc.RegisterConnection(conn)
+
+	// A day of samples at the default collection interval
+	now := time.Now()
+	for i := 0; i < 24*60*6; i++ {
+		mc.RecordSample(conn.ID, MetricSample{
+			Timestamp: now.Add(time.Duration(i-24*60*6) * 10 * time.Second),
+			Latency:   time.Duration(i%100) * time.Millisecond,
+		})
+	}
+
+	for _, w := range AggregationWindows {
+		b.Run(fmt.Sprintf("window=%s", w), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := mc.GetAggregated(conn.ID, w); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/offline"
+)
+
+// notifyTimeout bounds a single delivery to a Slack or webhook endpoint
+const notifyTimeout = 10 * time.Second
+
+// MultiNotifier delivers each notification to every notifier it holds. One
+// failing backend doesn't keep the others from being tried.
+type MultiNotifier []Notifier
+
+// Notify sends n to every notifier, joining their errors
+func (m MultiNotifier) Notify(n Notification) error {
+	var errs []error
+	for _, notifier := range m {
+		if err := notifier.Notify(n); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// EmailNotifier mails notifications to a fixed list of recipients
+type EmailNotifier struct {
+	Mailer Mailer
+	To     []string
+}
+
+// Notify mails n to each recipient
+func (e *EmailNotifier) Notify(n Notification) error {
+	subject := "[tunnel] " + n.Title
+	body := notificationText(n)
+	var errs []error
+	for _, to := range e.To {
+		if err := e.Mailer.Send(to, subject, body); err != nil {
+			errs = append(errs, fmt.Errorf("email %s: %w", to, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SlackNotifier posts notifications to a Slack incoming webhook
+type SlackNotifier struct {
+	WebhookURL string
+	Channel    string // overrides the webhook's default channel when set
+	Client     *http.Client
+}
+
+// Notify posts n as a Slack message
+func (s *SlackNotifier) Notify(n Notification) error {
+	payload := map[string]string{
+		"text": fmt.Sprintf("*%s*\n%s", n.Title, notificationText(n)),
+	}
+	if s.Channel != "" {
+		payload["channel"] = s.Channel
+	}
+	if err := postJSON(s.Client, s.WebhookURL, nil, payload); err != nil {
+		return fmt.Errorf("slack: %w", err)
+	}
+	return nil
+}
+
+// WebhookNotifier posts notifications as JSON to an HTTP endpoint
+type WebhookNotifier struct {
+	URL     string
+	Headers map[string]string // e.g. Authorization
+	Client  *http.Client
+}
+
+// webhookPayload is the JSON body a WebhookNotifier posts
+type webhookPayload struct {
+	Event   NotificationEvent `json:"event"`
+	Title   string            `json:"title"`
+	Message string            `json:"message"`
+	ConnID  string            `json:"connection_id,omitempty"`
+	Time    time.Time         `json:"time"`
+	Host    string            `json:"host,omitempty"`
+}
+
+// Notify posts n to the webhook
+func (w *WebhookNotifier) Notify(n Notification) error {
+	host, _ := os.Hostname()
+	payload := webhookPayload{
+		Event:   n.Event,
+		Title:   n.Title,
+		Message: n.Message,
+		ConnID:  n.ConnID,
+		Time:    n.Time,
+		Host:    host,
+	}
+	if err := postJSON(w.Client, w.URL, w.Headers, payload); err != nil {
+		return fmt.Errorf("webhook %s: %w", w.URL, err)
+	}
+	return nil
+}
+
+// notificationText is the body of a notification sent to a person, with
+// enough context to act on without opening tunnel
+func notificationText(n Notification) string {
+	var b strings.Builder
+	b.WriteString(n.Message)
+	if n.ConnID != "" {
+		fmt.Fprintf(&b, "\nConnection: %s", n.ConnID)
+	}
+	if host, err := os.Hostname(); err == nil {
+		fmt.Fprintf(&b, "\nHost: %s", host)
+	}
+	if !n.Time.IsZero() {
+		fmt.Fprintf(&b, "\nTime: %s", n.Time.Format(time.RFC3339))
+	}
+	return b.String()
+}
+
+// postJSON posts body as JSON to url, treating any non-2xx answer as an error
+func postJSON(client *http.Client, url string, headers map[string]string, body any) error {
+	if err := offline.Check("notification delivery"); err != nil {
+		return err
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	if client == nil {
+		client = &http.Client{Timeout: notifyTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
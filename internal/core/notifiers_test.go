@@ -0,0 +1,127 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/offline"
+)
+
+// outboxMailer records who was mailed, failing for one recipient
+type outboxMailer struct {
+	sent []string
+	fail string
+}
+
+func (m *outboxMailer) Send(to, subject, body string) error {
+	if to == m.fail {
+		return errors.New("mailbox unavailable")
+	}
+	m.sent = append(m.sent, to+": "+subject)
+	return nil
+}
+
+func TestEmailNotifier(t *testing.T) {
+	mailer := &outboxMailer{fail: "broken@example.com"}
+	notifier := &EmailNotifier{Mailer: mailer, To: []string{"ops@example.com", "broken@example.com", "sec@example.com"}}
+
+	err := notifier.Notify(Notification{Event: NotifyKey, Title: "SSH key revoked for alice", Message: "Key abc was revoked"})
+	if err == nil || !strings.Contains(err.Error(), "broken@example.com") {
+		t.Errorf("Notify error = %v, want the failing recipient reported", err)
+	}
+	if len(mailer.sent) != 2 || mailer.sent[0] != "ops@example.com: [tunnel] SSH key revoked for alice" {
+		t.Errorf("sent = %v, want the other recipients mailed", mailer.sent)
+	}
+}
+
+func TestSlackNotifier(t *testing.T) {
+	var got map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer server.Close()
+
+	notifier := &SlackNotifier{WebhookURL: server.URL, Channel: "#ops"}
+	if err := notifier.Notify(Notification{Title: "Tunnel conn-1 dropped", Message: "health check failed", ConnID: "conn-1"}); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	if got["channel"] != "#ops" || !strings.HasPrefix(got["text"], "*Tunnel conn-1 dropped*\nhealth check failed") {
+		t.Errorf("payload = %v", got)
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var got webhookPayload
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer server.Close()
+
+	notifier := &WebhookNotifier{URL: server.URL, Headers: map[string]string{"Authorization": "Bearer s3cret"}}
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := notifier.Notify(Notification{Event: NotifyFailover, Title: "Tunnel failed over", ConnID: "conn-2", Time: at}); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	if got.Event != NotifyFailover || got.ConnID != "conn-2" || !got.Time.Equal(at) {
+		t.Errorf("payload = %+v", got)
+	}
+	if auth != "Bearer s3cret" {
+		t.Errorf("Authorization = %q", auth)
+	}
+}
+
+func TestWebhookNotifierRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid token", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	err := (&WebhookNotifier{URL: server.URL}).Notify(Notification{Title: "x"})
+	if err == nil || !strings.Contains(err.Error(), "invalid token") {
+		t.Errorf("Notify error = %v, want the endpoint's answer", err)
+	}
+}
+
+func TestNotifiersOffline(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	offline.SetEnabled(true)
+	defer offline.SetEnabled(false)
+
+	for _, notifier := range []Notifier{
+		&SlackNotifier{WebhookURL: server.URL},
+		&WebhookNotifier{URL: server.URL},
+		&EmailNotifier{Mailer: &SMTPMailer{Host: "127.0.0.1", Port: 1}, To: []string{"ops@example.com"}},
+	} {
+		if err := notifier.Notify(Notification{Title: "x"}); !errors.Is(err, offline.ErrOffline) {
+			t.Errorf("%T: Notify error = %v, want ErrOffline", notifier, err)
+		}
+	}
+	if called {
+		t.Error("a webhook was posted in offline mode")
+	}
+}
+
+func TestMultiNotifier(t *testing.T) {
+	first, second := &recordingNotifier{}, &recordingNotifier{}
+	failing := &EmailNotifier{Mailer: &outboxMailer{fail: "x@example.com"}, To: []string{"x@example.com"}}
+
+	err := MultiNotifier{first, failing, second}.Notify(Notification{Title: "t"})
+	if err == nil {
+		t.Error("expected the failing notifier's error")
+	}
+	if len(first.sent) != 1 || len(second.sent) != 1 {
+		t.Error("a failing notifier kept the others from being notified")
+	}
+}
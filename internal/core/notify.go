@@ -0,0 +1,247 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// NotificationEvent identifies the kind of health change a notification reports
+type NotificationEvent string
+
+const (
+	NotifyDrop     NotificationEvent = "drop"
+	NotifyFailover NotificationEvent = "failover"
+	NotifyRecovery NotificationEvent = "recovery"
+	NotifyAlert    NotificationEvent = "alert"   // an alert rule fired
+	NotifyUpdate   NotificationEvent = "update"  // a new release of an installed provider binary
+	NotifyFailure  NotificationEvent = "failure" // a connection could not be established
+	NotifyKey      NotificationEvent = "key"     // an SSH key was added, rotated or revoked
+)
+
+// NotificationEvents lists every notification event type
+var NotificationEvents = []NotificationEvent{NotifyDrop, NotifyFailover, NotifyRecovery, NotifyAlert, NotifyUpdate, NotifyFailure, NotifyKey}
+
+// Notification is a single user-facing alert about a connection
+type Notification struct {
+	Event   NotificationEvent
+	Title   string
+	Message string
+	ConnID  string
+	Time    time.Time
+}
+
+// Notifier delivers notifications to the user
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+// QuietHours is a daily window during which notifications are suppressed.
+// End before Start means the window wraps past midnight.
+type QuietHours struct {
+	Start time.Duration // offset from local midnight
+	End   time.Duration
+}
+
+// ParseQuietHours parses "HH:MM" start and end times. Two empty strings yield
+// nil, meaning no quiet hours.
+func ParseQuietHours(start, end string) (*QuietHours, error) {
+	if start == "" && end == "" {
+		return nil, nil
+	}
+
+	s, err := parseClock(start)
+	if err != nil {
+		return nil, err
+	}
+	e, err := parseClock(end)
+	if err != nil {
+		return nil, err
+	}
+	return &QuietHours{Start: s, End: e}, nil
+}
+
+// parseClock converts "HH:MM" to an offset from midnight
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("parse quiet hours time %q: expected HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains reports whether t falls inside the quiet window
+func (q *QuietHours) Contains(t time.Time) bool {
+	if q == nil || q.Start == q.End {
+		return false
+	}
+
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	if q.Start < q.End {
+		return offset >= q.Start && offset < q.End
+	}
+	return offset >= q.Start || offset < q.End
+}
+
+// DesktopNotifier shows native desktop notifications, ringing the terminal
+// bell when no notification tool is available
+type DesktopNotifier struct {
+	AppName string
+}
+
+// NewDesktopNotifier creates a desktop notifier
+func NewDesktopNotifier() *DesktopNotifier {
+	return &DesktopNotifier{AppName: "tunnel"}
+}
+
+// Notify shows n using notify-send on Linux or osascript on macOS
+func (d *DesktopNotifier) Notify(n Notification) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "linux", "freebsd", "openbsd":
+		if path, err := exec.LookPath("notify-send"); err == nil {
+			urgency := "normal"
+			if n.Event == NotifyDrop {
+				urgency = "critical"
+			}
+			cmd = exec.Command(path, "--app-name", d.AppName, "--urgency", urgency, n.Title, n.Message)
+		}
+	case "darwin":
+		if path, err := exec.LookPath("osascript"); err == nil {
+			script := fmt.Sprintf("display notification %q with title %q", n.Message, n.Title)
+			cmd = exec.Command(path, "-e", script)
+		}
+	}
+
+	if cmd != nil {
+		if err := cmd.Run(); err == nil {
+			return nil
+		}
+	}
+
+	// Fall back to the terminal bell
+	_, err := fmt.Fprint(os.Stderr, "\a")
+	return err
+}
+
+// NotificationDispatcher turns connection events into notifications, tracking
+// which connections are down so that recoveries are only reported after a drop
+type NotificationDispatcher struct {
+	notifier   Notifier
+	enabled    map[NotificationEvent]bool
+	quietHours *QuietHours
+	now        func() time.Time
+
+	mu   sync.Mutex
+	down map[string]bool
+}
+
+// NewNotificationDispatcher creates a dispatcher. A nil enabled map turns on
+// every event type.
+func NewNotificationDispatcher(notifier Notifier, enabled map[NotificationEvent]bool, quiet *QuietHours) *NotificationDispatcher {
+	if enabled == nil {
+		enabled = make(map[NotificationEvent]bool)
+		for _, e := range NotificationEvents {
+			enabled[e] = true
+		}
+	}
+	return &NotificationDispatcher{
+		notifier:   notifier,
+		enabled:    enabled,
+		quietHours: quiet,
+		now:        time.Now,
+		down:       make(map[string]bool),
+	}
+}
+
+// Run dispatches events from sub until ctx is done or the channel closes
+func (d *NotificationDispatcher) Run(ctx context.Context, sub *EventSubscriber) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-sub.Channel:
+			if !ok {
+				return
+			}
+			d.Handle(event)
+		}
+	}
+}
+
+// Handle maps a single connection event to a notification and sends it
+func (d *NotificationDispatcher) Handle(event *ConnectionEvent) error {
+	n, ok := d.classify(event)
+	if !ok {
+		return nil
+	}
+	return d.Notify(n)
+}
+
+// Notify forwards n to the underlying notifier if its type is enabled and
+// quiet hours are not in effect, so other sources such as the alert engine
+// share the same settings
+func (d *NotificationDispatcher) Notify(n Notification) error {
+	if !d.enabled[n.Event] {
+		return nil
+	}
+	if d.quietHours.Contains(n.Time) {
+		return nil
+	}
+	return d.notifier.Notify(n)
+}
+
+// classify decides whether event is a drop, failover or recovery
+func (d *NotificationDispatcher) classify(event *ConnectionEvent) (Notification, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	n := Notification{ConnID: event.ConnID, Message: event.Message, Time: event.Timestamp}
+	if n.Time.IsZero() {
+		n.Time = d.now()
+	}
+
+	switch event.Type {
+	case EventError:
+		// Errors without a connection are attempts that never came up
+		if event.ConnID == "" {
+			n.Event = NotifyFailure
+			n.Title = "Tunnel failed to connect"
+			break
+		}
+		// The failover manager repeats its error on every failed check, so
+		// only the first one after a healthy period counts as a drop
+		if d.down[event.ConnID] {
+			return n, false
+		}
+		d.down[event.ConnID] = true
+		n.Event = NotifyDrop
+		n.Title = fmt.Sprintf("Tunnel %s dropped", event.ConnID)
+
+	case EventFailover:
+		n.Event = NotifyFailover
+		n.Title = "Tunnel failed over"
+
+	case EventConnected, EventStateChange:
+		if !d.down[event.ConnID] {
+			return n, false
+		}
+		delete(d.down, event.ConnID)
+		n.Event = NotifyRecovery
+		n.Title = fmt.Sprintf("Tunnel %s recovered", event.ConnID)
+
+	case EventDisconnected:
+		// A deliberate stop is not a drop; forget any outstanding failure
+		delete(d.down, event.ConnID)
+		return n, false
+
+	default:
+		return n, false
+	}
+
+	return n, true
+}
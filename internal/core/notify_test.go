@@ -0,0 +1,111 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+type recordingNotifier struct {
+	sent []Notification
+}
+
+func (r *recordingNotifier) Notify(n Notification) error {
+	r.sent = append(r.sent, n)
+	return nil
+}
+
+func TestQuietHoursContains(t *testing.T) {
+	at := func(clock string) time.Time {
+		tm, _ := time.Parse("15:04", clock)
+		return time.Date(2024, 1, 1, tm.Hour(), tm.Minute(), 0, 0, time.Local)
+	}
+
+	overnight, err := ParseQuietHours("22:00", "07:00")
+	if err != nil {
+		t.Fatalf("ParseQuietHours failed: %v", err)
+	}
+	daytime, _ := ParseQuietHours("09:00", "17:30")
+
+	tests := []struct {
+		quiet *QuietHours
+		clock string
+		want  bool
+	}{
+		{overnight, "23:15", true},
+		{overnight, "03:00", true},
+		{overnight, "07:00", false},
+		{overnight, "12:00", false},
+		{daytime, "09:00", true},
+		{daytime, "17:29", true},
+		{daytime, "17:30", false},
+		{nil, "03:00", false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.quiet.Contains(at(tt.clock)); got != tt.want {
+			t.Errorf("Contains(%s) = %v, want %v", tt.clock, got, tt.want)
+		}
+	}
+
+	if _, err := ParseQuietHours("25:00", "07:00"); err == nil {
+		t.Error("Expected error for invalid time")
+	}
+	if q, err := ParseQuietHours("", ""); err != nil || q != nil {
+		t.Errorf("Expected no quiet hours, got %v, %v", q, err)
+	}
+}
+
+func TestNotificationDispatcher(t *testing.T) {
+	notifier := &recordingNotifier{}
+	d := NewNotificationDispatcher(notifier, nil, nil)
+
+	events := []*ConnectionEvent{
+		NewEvent(EventError, "conn-1", nil, "marked unhealthy"),
+		NewEvent(EventError, "conn-1", nil, "marked unhealthy"), // repeat, ignored
+		NewEvent(EventFailover, "conn-2", nil, "Failed over from conn-1 to conn-2"),
+		NewEvent(EventStateChange, "conn-1", "recovered", "Connection conn-1 recovered"),
+		NewEvent(EventStateChange, "conn-1", "recovered", "Connection conn-1 recovered"), // not down
+		NewEvent(EventConnected, "conn-3", nil, "Connection conn-3 established"),
+		NewEvent(EventError, "", nil, "start wireguard: handshake timed out"),
+	}
+	for _, e := range events {
+		d.Handle(e)
+	}
+
+	want := []NotificationEvent{NotifyDrop, NotifyFailover, NotifyRecovery, NotifyFailure}
+	if len(notifier.sent) != len(want) {
+		t.Fatalf("Expected %d notifications, got %d: %+v", len(want), len(notifier.sent), notifier.sent)
+	}
+	for i, n := range notifier.sent {
+		if n.Event != want[i] {
+			t.Errorf("Notification %d: expected %s, got %s", i, want[i], n.Event)
+		}
+	}
+}
+
+func TestNotificationDispatcherFiltering(t *testing.T) {
+	notifier := &recordingNotifier{}
+	d := NewNotificationDispatcher(notifier, map[NotificationEvent]bool{NotifyDrop: true}, nil)
+
+	d.Handle(NewEvent(EventFailover, "conn-2", nil, "failover"))
+	d.Handle(NewEvent(EventError, "conn-1", nil, "drop"))
+	if len(notifier.sent) != 1 || notifier.sent[0].Event != NotifyDrop {
+		t.Fatalf("Expected only the drop notification, got %+v", notifier.sent)
+	}
+
+	// A deliberate stop clears the drop without announcing a recovery
+	d.Handle(NewEvent(EventDisconnected, "conn-1", nil, "stopped"))
+	d.Handle(NewEvent(EventConnected, "conn-1", nil, "connected"))
+	if len(notifier.sent) != 1 {
+		t.Errorf("Expected no recovery after a stop, got %+v", notifier.sent)
+	}
+
+	quiet := &QuietHours{Start: 0, End: 24*time.Hour - time.Minute}
+	d = NewNotificationDispatcher(notifier, nil, quiet)
+	event := NewEvent(EventError, "conn-9", nil, "drop")
+	event.Timestamp = time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)
+	d.Handle(event)
+	if len(notifier.sent) != 1 {
+		t.Errorf("Expected notification to be suppressed during quiet hours")
+	}
+}
@@ -0,0 +1,200 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/providers"
+)
+
+// maxOperations is how many operations the manager remembers
+const maxOperations = 200
+
+// Kinds of operation the manager records
+const (
+	OpConnect    = "connect"
+	OpDisconnect = "disconnect"
+	OpFailover   = "failover"
+)
+
+// Operation is one connect, disconnect or failover. Its ID is carried by
+// the events and audit entries the operation causes and tags the provider
+// log lines written while it ran, so a failure can be traced through all
+// of them.
+type Operation struct {
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	Method    string    `json:"method"`
+	ConnID    string    `json:"connection_id,omitempty"`
+	Number    int       `json:"number"` // counts the method's operations of this kind since the manager started
+	StartedAt time.Time `json:"started_at"`
+	EndedAt   time.Time `json:"ended_at,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Label names the operation as errors and log markers show it
+func (o Operation) Label() string {
+	if o.Kind == OpConnect {
+		return fmt.Sprintf("connect attempt #%d (%s)", o.Number, o.ID)
+	}
+	return fmt.Sprintf("%s #%d (%s)", o.Kind, o.Number, o.ID)
+}
+
+// OperationError is returned when an operation fails, so callers can
+// report its ID
+type OperationError struct {
+	Operation Operation
+	Err       error
+}
+
+func (e *OperationError) Error() string {
+	return fmt.Sprintf("%s failed: %v", e.Operation.Label(), e.Err)
+}
+
+func (e *OperationError) Unwrap() error {
+	return e.Err
+}
+
+// NewCorrelationID generates a short random ID that ties together the
+// events and log lines of one operation
+func NewCorrelationID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Operations returns the remembered operations on method, or on every
+// method when it is empty, oldest first
+func (m *DefaultConnectionManager) Operations(method string) []Operation {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var ops []Operation
+	for _, op := range m.operations {
+		if method == "" || op.Method == method {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+// Operation returns the remembered operation with the given ID
+func (m *DefaultConnectionManager) Operation(id string) (Operation, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, op := range m.operations {
+		if op.ID == id {
+			return op, true
+		}
+	}
+	return Operation{}, false
+}
+
+// LatestOperation returns the most recent operation of kind on a connection
+func (m *DefaultConnectionManager) LatestOperation(kind, connID string) (Operation, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for i := len(m.operations) - 1; i >= 0; i-- {
+		if op := m.operations[i]; op.Kind == kind && op.ConnID == connID {
+			return op, true
+		}
+	}
+	return Operation{}, false
+}
+
+// beginOperation records the start of an operation; m.mu must be held
+func (m *DefaultConnectionManager) beginOperation(kind, method, connID string) Operation {
+	key := kind + "/" + method
+	m.operationCount[key]++
+	op := Operation{
+		ID:        NewCorrelationID(),
+		Kind:      kind,
+		Method:    method,
+		ConnID:    connID,
+		Number:    m.operationCount[key],
+		StartedAt: time.Now(),
+	}
+	m.operations = append(m.operations, op)
+	if len(m.operations) > maxOperations {
+		m.operations = m.operations[len(m.operations)-maxOperations:]
+	}
+	return op
+}
+
+// endOperation records how an operation ended; m.mu must be held
+func (m *DefaultConnectionManager) endOperation(op *Operation, err error) {
+	op.EndedAt = time.Now()
+	if err != nil {
+		op.Error = err.Error()
+	}
+	for i := len(m.operations) - 1; i >= 0; i-- {
+		if m.operations[i].ID == op.ID {
+			m.operations[i] = *op
+			return
+		}
+	}
+}
+
+// recordFailover records a failover as an operation that ended as soon as
+// it began, and returns its ID
+func (m *DefaultConnectionManager) recordFailover(method, connID string, err error) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	op := m.beginOperation(OpFailover, method, connID)
+	m.endOperation(&op, err)
+	return op.ID
+}
+
+// TagLogEntries sets the OperationID of each provider log entry to the
+// operation on its provider that was the latest to start at or before the
+// entry
+func TagLogEntries(entries []providers.LogEntry, method string, ops []Operation) {
+	var own []Operation
+	for _, op := range ops {
+		if op.Method == method {
+			own = append(own, op)
+		}
+	}
+	sort.SliceStable(own, func(i, j int) bool { return own[i].StartedAt.Before(own[j].StartedAt) })
+
+	for i := range entries {
+		at := entries[i].Timestamp
+		n := sort.Search(len(own), func(j int) bool { return own[j].StartedAt.After(at) })
+		if n > 0 {
+			entries[i].OperationID = own[n-1].ID
+		}
+	}
+}
+
+// LogsAround returns the entries logged within window of the operation
+// with the given ID: from window before it started until window after it
+// ended. It returns nil if the operation is unknown.
+func LogsAround(entries []providers.LogEntry, ops []Operation, id string, window time.Duration) []providers.LogEntry {
+	for _, op := range ops {
+		if op.ID != id {
+			continue
+		}
+		end := op.EndedAt
+		if end.IsZero() {
+			end = time.Now()
+		}
+		from, until := op.StartedAt.Add(-window), end.Add(window)
+
+		around := []providers.LogEntry{}
+		for _, e := range entries {
+			if !e.Timestamp.Before(from) && !e.Timestamp.After(until) {
+				around = append(around, e)
+			}
+		}
+		return around
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/providers"
+)
+
+func TestManagerRecordsOperations(t *testing.T) {
+	manager := NewConnectionManager(DefaultManagerConfig())
+	defer manager.Shutdown()
+	manager.RegisterProvider(NewMockProvider("ok", 0.0, time.Millisecond))
+	manager.RegisterProvider(NewMockProvider("broken", 1.0, time.Millisecond))
+
+	conn, err := manager.Start("ok", DefaultConfig())
+	if err != nil {
+		t.Fatalf("Start(ok) error = %v", err)
+	}
+	if _, err := manager.Start("broken", DefaultConfig()); err == nil {
+		t.Fatal("Start(broken) succeeded, want error")
+	}
+	manager.Start("broken", DefaultConfig())
+
+	connects := manager.Operations("ok")
+	if len(connects) != 1 || connects[0].ID != conn.OperationID || connects[0].ConnID != conn.ID || connects[0].Error != "" || connects[0].EndedAt.IsZero() {
+		t.Errorf("Operations(ok) = %+v, want one successful connect %s", connects, conn.OperationID)
+	}
+	broken := manager.Operations("broken")
+	if len(broken) != 2 || broken[1].Number != 2 || broken[1].Error == "" {
+		t.Errorf("Operations(broken) = %+v, want two numbered failed attempts", broken)
+	}
+	if all := manager.Operations(""); len(all) != 3 {
+		t.Errorf("Operations() returned %d operations, want 3", len(all))
+	}
+
+	_, err = manager.Start("broken", DefaultConfig())
+	var opErr *OperationError
+	if !errors.As(err, &opErr) || opErr.Operation.Kind != OpConnect || opErr.Operation.Number != 3 {
+		t.Errorf("Start(broken) error = %v, want an OperationError for attempt #3", err)
+	}
+
+	if err := manager.Stop(conn.ID); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	op, ok := manager.LatestOperation(OpDisconnect, conn.ID)
+	if !ok || op.Method != "ok" || op.EndedAt.IsZero() {
+		t.Errorf("LatestOperation(disconnect) = %+v, %v", op, ok)
+	}
+	if got, ok := manager.Operation(op.ID); !ok || got != op {
+		t.Errorf("Operation(%s) = %+v, %v", op.ID, got, ok)
+	}
+}
+
+func TestTagLogEntries(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	ops := []Operation{
+		{ID: "b", Kind: OpDisconnect, Method: "ngrok", StartedAt: base.Add(time.Minute), EndedAt: base.Add(70 * time.Second)},
+		{ID: "a", Method: "ngrok", StartedAt: base, EndedAt: base.Add(5 * time.Second)},
+		{ID: "x", Method: "bore", StartedAt: base.Add(30 * time.Second)},
+	}
+	entries := []providers.LogEntry{
+		{Timestamp: base.Add(-time.Second), Message: "before"},
+		{Timestamp: base, Message: "first"},
+		{Timestamp: base.Add(40 * time.Second), Message: "still first"},
+		{Timestamp: base.Add(65 * time.Second), Message: "second"},
+	}
+
+	TagLogEntries(entries, "ngrok", ops)
+	for i, want := range []string{"", "a", "a", "b"} {
+		if entries[i].OperationID != want {
+			t.Errorf("%q tagged %q, want %q", entries[i].Message, entries[i].OperationID, want)
+		}
+	}
+
+	around := LogsAround(entries, ops, "a", 10*time.Second)
+	if len(around) != 2 || around[0].Message != "before" || around[1].Message != "first" {
+		t.Errorf("LogsAround(a) = %+v, want the two lines near it", around)
+	}
+	if LogsAround(entries, ops, "missing", time.Minute) != nil {
+		t.Error("LogsAround(missing) should return nil")
+	}
+}
+
+func TestFailoverRecordsOperation(t *testing.T) {
+	manager := NewConnectionManager(DefaultManagerConfig())
+	defer manager.Shutdown()
+	fm := manager.failoverManager
+	sub := manager.GetEventPublisher().Subscribe("test", func(e *ConnectionEvent) bool { return e.Type == EventFailover })
+
+	primary := NewConnection("primary", "mock", 8080, "localhost", 22)
+	backup := NewConnection("backup", "mock", 8081, "localhost", 22)
+	backup.SetState(StateConnected)
+	fm.RegisterConnection(primary)
+	fm.RegisterConnection(backup)
+	fm.healthStatus[backup.ID].IsHealthy = true
+	_ = fm.SetPrimary(primary.ID)
+
+	fm.evaluateFailover(primary.ID)
+
+	ops := manager.Operations("mock")
+	if len(ops) != 1 || ops[0].Kind != OpFailover || ops[0].ConnID != backup.ID {
+		t.Fatalf("Operations(mock) = %+v, want one failover to %s", ops, backup.ID)
+	}
+	select {
+	case event := <-sub.Channel:
+		if event.OperationID != ops[0].ID {
+			t.Errorf("failover event OperationID = %q, want %q", event.OperationID, ops[0].ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no failover event published")
+	}
+}
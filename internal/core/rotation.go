@@ -0,0 +1,346 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// RotationPolicy is a managed identity: a keypair TUNNEL generates at
+// KeyPath and installs for User, replacing it every Every. The key it
+// replaces stays valid for Overlap, so clients can pick up the new one
+// before the old one is revoked.
+type RotationPolicy struct {
+	Identity string
+	User     string
+	KeyPath  string
+	Every    time.Duration
+	Overlap  time.Duration
+}
+
+// previousKeyPath is where the replaced private key is kept during the
+// overlap
+func (p RotationPolicy) previousKeyPath() string {
+	return p.KeyPath + ".previous"
+}
+
+// RotationState is where the rotation of an identity stands
+type RotationState struct {
+	Identity     string    `json:"identity"`
+	User         string    `json:"user"`
+	Current      string    `json:"current"` // fingerprint of the key in use
+	CurrentSince time.Time `json:"current_since"`
+	Previous     string    `json:"previous,omitempty"` // fingerprint of the key being retired
+	RevokeAt     time.Time `json:"revoke_at,omitempty"`
+}
+
+// NextRotation returns when the identity's key is next replaced
+func (s RotationState) NextRotation(p RotationPolicy) time.Time {
+	return s.CurrentSince.Add(p.Every)
+}
+
+// Rotation steps
+const (
+	RotationGenerated = "generated" // the identity got its first key
+	RotationAdopted   = "adopted"   // a key already at the path was taken over
+	RotationRotated   = "rotated"   // a new key replaced the current one
+	RotationRevoked   = "revoked"   // the replaced key's overlap ended
+)
+
+// RotationStep is something a rotation run did, or failed to do
+type RotationStep struct {
+	Identity    string
+	User        string
+	Action      string
+	Fingerprint string    // the key the step installed or revoked
+	Replaced    string    // for RotationRotated, the key now being retired
+	RevokeAt    time.Time // for RotationRotated, when the retired key goes
+	Err         error
+}
+
+// rotationFileVersion is the rotation.json format written by this release
+const rotationFileVersion = 1
+
+// rotationFile is the on-disk form of a RotationStore
+type rotationFile struct {
+	Version    int             `json:"version"`
+	Identities []RotationState `json:"identities"`
+}
+
+// RotationStore persists where the rotation of each identity stands
+type RotationStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewRotationStore creates a rotation store backed by the given file
+func NewRotationStore(path string) *RotationStore {
+	return &RotationStore{path: path}
+}
+
+// List returns the state of every identity rotated so far
+func (s *RotationStore) List() ([]RotationState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load()
+}
+
+// Run brings every identity of policies up to date at now: identities
+// without a key get one, keys older than their policy's Every are replaced
+// and replaced keys whose overlap has ended are revoked. force rotates
+// the named identity whatever its age. A step that fails is retried by
+// the next run.
+func (s *RotationStore) Run(km KeyManager, policies []RotationPolicy, now time.Time, force string) ([]RotationStep, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	states, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	byIdentity := make(map[string]*RotationState, len(states))
+	for i := range states {
+		byIdentity[states[i].Identity] = &states[i]
+	}
+
+	var steps []RotationStep
+	var updated []RotationState
+	configured := make(map[string]bool, len(policies))
+	for _, p := range policies {
+		configured[p.Identity] = true
+		st, ok := byIdentity[p.Identity]
+		if !ok {
+			st = &RotationState{Identity: p.Identity, User: p.User}
+		}
+
+		// An overlap still running when the next rotation is due ends now,
+		// so at most two keys are valid at once
+		due := st.Current == "" || force == p.Identity || !now.Before(st.NextRotation(p))
+		if st.Previous != "" && due {
+			step := revokeRetired(km, p, st)
+			steps = append(steps, step)
+			if step.Err != nil {
+				updated = append(updated, *st)
+				continue
+			}
+		}
+		if due {
+			steps = append(steps, rotateIdentity(km, p, st, now))
+		}
+		if st.Previous != "" && !now.Before(st.RevokeAt) {
+			steps = append(steps, revokeRetired(km, p, st))
+		}
+		updated = append(updated, *st)
+	}
+	// Identities taken out of the config keep their keys until put back or
+	// revoked by hand
+	for _, st := range states {
+		if !configured[st.Identity] {
+			updated = append(updated, st)
+		}
+	}
+
+	if err := s.save(updated); err != nil {
+		return steps, err
+	}
+	return steps, nil
+}
+
+// rotateIdentity installs a new key for the identity of p, or adopts the
+// one already at its path when it has no state yet
+func rotateIdentity(km KeyManager, p RotationPolicy, st *RotationState, now time.Time) RotationStep {
+	step := RotationStep{Identity: p.Identity, User: p.User}
+
+	if st.Current == "" {
+		if key, modTime, err := readIdentityKey(p.KeyPath); err == nil {
+			step.Action, step.Fingerprint = RotationAdopted, key.Fingerprint
+			if err := installIdentityKey(km, p.User, *key); err != nil {
+				step.Err = err
+				return step
+			}
+			st.Current, st.CurrentSince = key.Fingerprint, modTime
+			return step
+		} else if !errors.Is(err, os.ErrNotExist) {
+			step.Action, step.Err = RotationAdopted, err
+			return step
+		}
+	}
+
+	step.Action = RotationGenerated
+	if st.Current != "" {
+		step.Action, step.Replaced = RotationRotated, st.Current
+		if err := renameKeyPair(p.KeyPath, p.previousKeyPath()); err != nil {
+			step.Err = err
+			return step
+		}
+	}
+
+	comment := fmt.Sprintf("%s@tunnel-%s", p.Identity, now.Format("20060102"))
+	key, err := GenerateIdentityKey(p.KeyPath, comment)
+	if err == nil {
+		step.Fingerprint = key.Fingerprint
+		err = installIdentityKey(km, p.User, *key)
+	}
+	if err != nil {
+		// Put the key in use back where clients expect it
+		if step.Replaced != "" {
+			_ = renameKeyPair(p.previousKeyPath(), p.KeyPath)
+		}
+		step.Err = err
+		return step
+	}
+
+	if step.Replaced != "" {
+		st.Previous, st.RevokeAt = st.Current, now.Add(p.Overlap)
+		step.RevokeAt = st.RevokeAt
+	}
+	st.Current, st.CurrentSince = key.Fingerprint, now
+	return step
+}
+
+// revokeRetired revokes the key the identity of p is retiring and deletes
+// its private key
+func revokeRetired(km KeyManager, p RotationPolicy, st *RotationState) RotationStep {
+	step := RotationStep{Identity: p.Identity, User: p.User, Action: RotationRevoked, Fingerprint: st.Previous}
+
+	keys, err := km.ListKeys(p.User)
+	if err != nil {
+		step.Err = fmt.Errorf("list keys: %w", err)
+		return step
+	}
+	for _, key := range keys {
+		if key.Fingerprint == st.Previous {
+			if err := km.RemoveKey(p.User, key.Fingerprint); err != nil {
+				step.Err = err
+				return step
+			}
+			break
+		}
+	}
+
+	for _, path := range []string{p.previousKeyPath(), p.previousKeyPath() + ".pub"} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			step.Err = fmt.Errorf("delete retired key: %w", err)
+			return step
+		}
+	}
+
+	st.Previous, st.RevokeAt = "", time.Time{}
+	return step
+}
+
+// installIdentityKey adds key for user unless it is already there
+func installIdentityKey(km KeyManager, user string, key SSHPublicKey) error {
+	keys, err := km.ListKeys(user)
+	if err != nil {
+		return fmt.Errorf("list keys: %w", err)
+	}
+	for _, existing := range keys {
+		if existing.Fingerprint == key.Fingerprint {
+			return nil
+		}
+	}
+	key.User, key.Source = user, KeySourceRotation
+	return km.AddKey(user, key)
+}
+
+// GenerateIdentityKey writes a new ed25519 keypair to path and path.pub,
+// in the formats ssh-keygen writes, and returns the public key
+func GenerateIdentityKey(path, comment string) (*SSHPublicKey, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, comment)
+	if err != nil {
+		return nil, fmt.Errorf("encode private key: %w", err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("encode public key: %w", err)
+	}
+	authorized := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub))) + " " + comment
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("create key directory: %w", err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		return nil, fmt.Errorf("write private key: %w", err)
+	}
+	if err := os.WriteFile(path+".pub", []byte(authorized+"\n"), 0644); err != nil {
+		return nil, fmt.Errorf("write public key: %w", err)
+	}
+
+	return ParseSSHPublicKey(authorized)
+}
+
+// readIdentityKey reads the public key next to the private key at path,
+// with when it was written
+func readIdentityKey(path string) (*SSHPublicKey, time.Time, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, time.Time{}, err
+	}
+	info, err := os.Stat(path + ".pub")
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	data, err := os.ReadFile(path + ".pub")
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	key, err := ParseSSHPublicKey(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("read %s.pub: %w", path, err)
+	}
+	return key, info.ModTime(), nil
+}
+
+// renameKeyPair moves the keypair at from, private and public key, to to
+func renameKeyPair(from, to string) error {
+	if err := os.Rename(from, to); err != nil {
+		return fmt.Errorf("move key: %w", err)
+	}
+	if err := os.Rename(from+".pub", to+".pub"); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("move key: %w", err)
+	}
+	return nil
+}
+
+func (s *RotationStore) load() ([]RotationState, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []RotationState{}, nil
+		}
+		return nil, fmt.Errorf("read rotation state: %w", err)
+	}
+
+	var file rotationFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse rotation state: %w", err)
+	}
+	return file.Identities, nil
+}
+
+func (s *RotationStore) save(states []RotationState) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("create rotation state directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(rotationFile{Version: rotationFileVersion, Identities: states}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode rotation state: %w", err)
+	}
+
+	return os.WriteFile(s.path, data, 0600)
+}
@@ -0,0 +1,177 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// installedKeys returns the fingerprints installed for user
+func installedKeys(t *testing.T, km KeyManager, user string) map[string]bool {
+	t.Helper()
+	keys, err := km.ListKeys(user)
+	if err != nil {
+		t.Fatalf("ListKeys() error = %v", err)
+	}
+	fps := make(map[string]bool)
+	for _, key := range keys {
+		fps[key.Fingerprint] = true
+	}
+	return fps
+}
+
+// TestRotationWithOverlap tests a key's life: generated, replaced, kept
+// through the overlap and revoked
+func TestRotationWithOverlap(t *testing.T) {
+	km, _, cleanup := setupTestKeyManager(t)
+	defer cleanup()
+
+	dir := t.TempDir()
+	store := NewRotationStore(filepath.Join(dir, "rotation.json"))
+	policy := RotationPolicy{
+		Identity: "deploy",
+		User:     "deploy",
+		KeyPath:  filepath.Join(dir, "keys", "deploy"),
+		Every:    30 * 24 * time.Hour,
+		Overlap:  7 * 24 * time.Hour,
+	}
+	policies := []RotationPolicy{policy}
+	start := time.Now()
+
+	steps, err := store.Run(km, policies, start, "")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(steps) != 1 || steps[0].Action != RotationGenerated || steps[0].Err != nil {
+		t.Fatalf("first Run() steps = %+v, want one generated", steps)
+	}
+	first := steps[0].Fingerprint
+	if !installedKeys(t, km, "deploy")[first] {
+		t.Fatal("generated key was not installed")
+	}
+
+	// The private key is usable by ssh clients
+	data, err := os.ReadFile(policy.KeyPath)
+	if err != nil {
+		t.Fatalf("read private key: %v", err)
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		t.Fatalf("ParsePrivateKey() error = %v", err)
+	}
+	if fingerprintSHA256(signer.PublicKey()) != first {
+		t.Error("private key does not match the installed public key")
+	}
+
+	// Nothing is due before the period ends
+	if steps, _ := store.Run(km, policies, start.Add(29*24*time.Hour), ""); len(steps) != 0 {
+		t.Errorf("Run() before due = %+v, want no steps", steps)
+	}
+
+	rotatedAt := start.Add(30 * 24 * time.Hour)
+	steps, err = store.Run(km, policies, rotatedAt, "")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(steps) != 1 || steps[0].Action != RotationRotated || steps[0].Replaced != first {
+		t.Fatalf("Run() when due = %+v, want first key rotated", steps)
+	}
+	second := steps[0].Fingerprint
+	if !steps[0].RevokeAt.Equal(rotatedAt.Add(policy.Overlap)) {
+		t.Errorf("RevokeAt = %v, want end of overlap", steps[0].RevokeAt)
+	}
+	keys := installedKeys(t, km, "deploy")
+	if !keys[first] || !keys[second] {
+		t.Error("expected both keys valid during the overlap")
+	}
+	if _, err := os.Stat(policy.KeyPath + ".previous"); err != nil {
+		t.Errorf("expected the replaced private key kept during the overlap: %v", err)
+	}
+
+	steps, err = store.Run(km, policies, rotatedAt.Add(policy.Overlap), "")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(steps) != 1 || steps[0].Action != RotationRevoked || steps[0].Fingerprint != first {
+		t.Fatalf("Run() after overlap = %+v, want first key revoked", steps)
+	}
+	keys = installedKeys(t, km, "deploy")
+	if keys[first] || !keys[second] {
+		t.Error("expected only the new key valid after the overlap")
+	}
+	if _, err := os.Stat(policy.KeyPath + ".previous"); !os.IsNotExist(err) {
+		t.Error("expected the replaced private key deleted after the overlap")
+	}
+
+	states, err := store.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(states) != 1 || states[0].Current != second || states[0].Previous != "" {
+		t.Errorf("List() = %+v", states)
+	}
+}
+
+// TestRotationForcedAndImmediate tests forcing a rotation without overlap
+func TestRotationForcedAndImmediate(t *testing.T) {
+	km, _, cleanup := setupTestKeyManager(t)
+	defer cleanup()
+
+	dir := t.TempDir()
+	store := NewRotationStore(filepath.Join(dir, "rotation.json"))
+	policies := []RotationPolicy{{
+		Identity: "ci",
+		User:     "ci",
+		KeyPath:  filepath.Join(dir, "ci"),
+		Every:    90 * 24 * time.Hour,
+	}}
+	now := time.Now()
+
+	steps, err := store.Run(km, policies, now, "")
+	if err != nil || len(steps) != 1 {
+		t.Fatalf("Run() = %+v, %v", steps, err)
+	}
+	first := steps[0].Fingerprint
+
+	steps, err = store.Run(km, policies, now.Add(time.Minute), "ci")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(steps) != 2 || steps[0].Action != RotationRotated || steps[1].Action != RotationRevoked {
+		t.Fatalf("forced Run() = %+v, want rotated then revoked", steps)
+	}
+	keys := installedKeys(t, km, "ci")
+	if keys[first] || !keys[steps[0].Fingerprint] {
+		t.Error("expected the old key revoked at once without an overlap")
+	}
+}
+
+// TestRotationAdoptsExistingKey tests taking over a keypair already at the
+// identity's path
+func TestRotationAdoptsExistingKey(t *testing.T) {
+	km, _, cleanup := setupTestKeyManager(t)
+	defer cleanup()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "backup")
+	existing, err := GenerateIdentityKey(path, "backup@host")
+	if err != nil {
+		t.Fatalf("GenerateIdentityKey() error = %v", err)
+	}
+
+	store := NewRotationStore(filepath.Join(dir, "rotation.json"))
+	policies := []RotationPolicy{{Identity: "backup", User: "backup", KeyPath: path, Every: time.Hour}}
+	steps, err := store.Run(km, policies, time.Now(), "")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(steps) != 1 || steps[0].Action != RotationAdopted || steps[0].Fingerprint != existing.Fingerprint {
+		t.Fatalf("Run() = %+v, want existing key adopted", steps)
+	}
+	if !installedKeys(t, km, "backup")[existing.Fingerprint] {
+		t.Error("adopted key was not installed")
+	}
+}
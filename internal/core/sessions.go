@@ -0,0 +1,139 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/system"
+)
+
+// DefaultSessionGrace is how long a session is given to exit after SIGTERM
+// before it is killed outright
+const DefaultSessionGrace = 5 * time.Second
+
+// SessionKill is the outcome of terminating one SSH session
+type SessionKill struct {
+	Session system.SSHSession `json:"session"`
+	PID     int               `json:"pid"`              // process that was signalled
+	Killed  bool              `json:"killed"`           // the process is gone
+	Forced  bool              `json:"forced,omitempty"` // it ignored SIGTERM and got SIGKILL
+	Error   string            `json:"error,omitempty"`
+}
+
+// SessionTracker finds the SSH sessions on this host and ends them
+type SessionTracker struct {
+	// Grace is how long to wait after SIGTERM before sending SIGKILL
+	Grace time.Duration
+
+	list   func() ([]system.SSHSession, error)
+	signal func(pid int, sig syscall.Signal) error
+}
+
+// NewSessionTracker creates a tracker for the sessions of this host's sshd
+func NewSessionTracker() *SessionTracker {
+	return &SessionTracker{
+		Grace:  DefaultSessionGrace,
+		list:   system.SSHSessions,
+		signal: signalProcess,
+	}
+}
+
+// UserSessions returns the active SSH sessions logged in as user or, where
+// sshd exposes it, authenticated with one of the keys in fingerprints
+func (t *SessionTracker) UserSessions(user string, fingerprints []string) ([]system.SSHSession, error) {
+	sessions, err := t.list()
+	if err != nil {
+		return nil, fmt.Errorf("list SSH sessions: %w", err)
+	}
+	keys := make(map[string]bool, len(fingerprints))
+	for _, fp := range fingerprints {
+		keys[fp] = true
+	}
+	var matched []system.SSHSession
+	for _, s := range sessions {
+		if s.User == user || (s.Fingerprint != "" && keys[s.Fingerprint]) {
+			matched = append(matched, s)
+		}
+	}
+	return matched, nil
+}
+
+// KillUserSessions terminates the SSH sessions UserSessions finds. Each
+// session's sshd process is sent SIGTERM, which drops the connection; any
+// still running after the grace period get SIGKILL. A failure to end one
+// session is recorded in its result and does not stop the others.
+func (t *SessionTracker) KillUserSessions(user string, fingerprints []string) ([]SessionKill, error) {
+	sessions, err := t.UserSessions(user, fingerprints)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]SessionKill, len(sessions))
+	var pending []int
+	for i, s := range sessions {
+		results[i] = SessionKill{Session: s, PID: sessionProcess(s)}
+		if err := t.signal(results[i].PID, syscall.SIGTERM); err != nil {
+			if errors.Is(err, os.ErrProcessDone) {
+				results[i].Killed = true
+			} else {
+				results[i].Error = fmt.Sprintf("terminate: %v", err)
+			}
+			continue
+		}
+		pending = append(pending, i)
+	}
+
+	// Sessions are given their grace period together rather than one by one
+	deadline := time.Now().Add(t.Grace)
+	for len(pending) > 0 {
+		var running []int
+		for _, i := range pending {
+			if t.alive(results[i].PID) {
+				running = append(running, i)
+			} else {
+				results[i].Killed = true
+			}
+		}
+		pending = running
+		if len(pending) == 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	for _, i := range pending {
+		results[i].Forced = true
+		if err := t.signal(results[i].PID, syscall.SIGKILL); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			results[i].Error = fmt.Sprintf("kill: %v", err)
+			continue
+		}
+		results[i].Killed = true
+	}
+	return results, nil
+}
+
+// alive reports whether pid is still running, probing it with signal 0
+func (t *SessionTracker) alive(pid int) bool {
+	return t.signal(pid, syscall.Signal(0)) == nil
+}
+
+// sessionProcess returns the process to signal to end s: the sshd process
+// serving its connection, or the session's own process when that is unknown
+func sessionProcess(s system.SSHSession) int {
+	if s.SSHDPID > 0 {
+		return s.SSHDPID
+	}
+	return s.PID
+}
+
+// signalProcess sends sig to pid
+func signalProcess(pid int, sig syscall.Signal) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Signal(sig)
+}
@@ -0,0 +1,98 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/jedarden/tunnel/internal/system"
+)
+
+// fakeProcesses stands in for the host's processes. Those in stubborn
+// ignore SIGTERM.
+type fakeProcesses struct {
+	running  map[int]bool
+	stubborn map[int]bool
+	signals  map[int][]syscall.Signal
+}
+
+func (f *fakeProcesses) signal(pid int, sig syscall.Signal) error {
+	if !f.running[pid] {
+		return os.ErrProcessDone
+	}
+	if pid == 666 {
+		return syscall.EPERM
+	}
+	if sig != 0 {
+		f.signals[pid] = append(f.signals[pid], sig)
+	}
+	if sig == syscall.SIGKILL || (sig == syscall.SIGTERM && !f.stubborn[pid]) {
+		delete(f.running, pid)
+	}
+	return nil
+}
+
+func TestKillUserSessions(t *testing.T) {
+	procs := &fakeProcesses{
+		running:  map[int]bool{100: true, 200: true, 666: true, 700: true, 900: true, 950: true},
+		stubborn: map[int]bool{200: true},
+		signals:  make(map[int][]syscall.Signal),
+	}
+	sessions := []system.SSHSession{
+		{ID: "a", PID: 101, SSHDPID: 100, User: "alice"},
+		{ID: "b", PID: 200, User: "alice"}, // no sshd parent found
+		{ID: "c", PID: 667, SSHDPID: 666, User: "alice"},
+		{ID: "d", PID: 701, SSHDPID: 700, User: "bob"},
+		{ID: "e", PID: 801, SSHDPID: 800, User: "alice"}, // already gone
+		{ID: "f", PID: 901, SSHDPID: 900, User: "deploy", Fingerprint: "SHA256:alice-laptop"},
+		{ID: "g", PID: 951, SSHDPID: 950, User: "deploy", Fingerprint: "SHA256:ci"},
+	}
+	tracker := &SessionTracker{
+		list:   func() ([]system.SSHSession, error) { return sessions, nil },
+		signal: procs.signal,
+	}
+
+	results, err := tracker.KillUserSessions("alice", []string{"SHA256:alice-laptop"})
+	if err != nil {
+		t.Fatalf("KillUserSessions failed: %v", err)
+	}
+	if len(results) != 5 {
+		t.Fatalf("results = %+v, want 5", results)
+	}
+
+	byID := make(map[string]SessionKill)
+	for _, r := range results {
+		byID[r.Session.ID] = r
+	}
+	if r := byID["a"]; r.PID != 100 || !r.Killed || r.Forced || r.Error != "" {
+		t.Errorf("session a = %+v, want sshd terminated", r)
+	}
+	if r := byID["b"]; r.PID != 200 || !r.Killed || !r.Forced {
+		t.Errorf("session b = %+v, want killed after ignoring SIGTERM", r)
+	}
+	if r := byID["c"]; r.Killed || r.Error == "" {
+		t.Errorf("session c = %+v, want an error", r)
+	}
+	if r := byID["e"]; !r.Killed || r.Error != "" {
+		t.Errorf("session e = %+v, want already ended", r)
+	}
+	if r := byID["f"]; !r.Killed {
+		t.Errorf("session f = %+v, want the session logged in with alice's key killed", r)
+	}
+	if !procs.running[700] || !procs.running[950] {
+		t.Error("another user's session was signalled")
+	}
+	if got := procs.signals[200]; len(got) != 2 || got[0] != syscall.SIGTERM || got[1] != syscall.SIGKILL {
+		t.Errorf("signals to 200 = %v, want SIGTERM then SIGKILL", got)
+	}
+}
+
+func TestKillUserSessionsListError(t *testing.T) {
+	tracker := &SessionTracker{
+		list: func() ([]system.SSHSession, error) { return nil, errors.New("no ps") },
+	}
+	if _, err := tracker.KillUserSessions("alice", nil); err == nil {
+		t.Error("expected an error when sessions can't be listed")
+	}
+}
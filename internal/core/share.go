@@ -0,0 +1,228 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/migrate"
+	"gopkg.in/yaml.v3"
+)
+
+// Share is a time-limited grant of SSH access to a single user
+type Share struct {
+	ID           string    `json:"id"`
+	User         string    `json:"user"`
+	Provider     string    `json:"provider,omitempty"`
+	Fingerprints []string  `json:"fingerprints"`
+	Instruction  string    `json:"instruction,omitempty"`
+	RevokeTunnel bool      `json:"revoke_tunnel,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// Expired reports whether the share's TTL has elapsed at the given time
+func (s *Share) Expired(now time.Time) bool {
+	return !now.Before(s.ExpiresAt)
+}
+
+// NewShareID generates a short random share identifier
+func NewShareID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// shareFileVersion is the shares.json format written by this release
+const shareFileVersion = 1
+
+// shareFile is the on-disk form of a ShareStore
+type shareFile struct {
+	Version int     `json:"version"`
+	Shares  []Share `json:"shares"`
+}
+
+// ShareMigrations upgrades shares.json files written by older releases
+var ShareMigrations = &migrate.Migrator{
+	Name:       "shares",
+	VersionKey: "version",
+	Format:     migrate.JSON,
+	Steps: []migrate.Step{
+		{
+			Version:     1,
+			Description: "Wrap the share list in a versioned object",
+			Apply: func(root *yaml.Node) error {
+				if root.Kind != yaml.SequenceNode {
+					return nil
+				}
+				list := *root
+				*root = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+				migrate.SetMapValue(root, "shares", &list)
+				return nil
+			},
+		},
+	},
+}
+
+// ShareStore persists active shares to a JSON file
+type ShareStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewShareStore creates a share store backed by the given file
+func NewShareStore(path string) *ShareStore {
+	return &ShareStore{path: path}
+}
+
+// List returns all recorded shares
+func (s *ShareStore) List() ([]Share, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load()
+}
+
+// Add records a new share
+func (s *ShareStore) Add(share Share) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	shares, err := s.load()
+	if err != nil {
+		return err
+	}
+	for _, existing := range shares {
+		if existing.ID == share.ID {
+			return fmt.Errorf("share %s already exists", share.ID)
+		}
+	}
+
+	return s.save(append(shares, share))
+}
+
+// Remove deletes a share by ID and returns it
+func (s *ShareStore) Remove(id string) (*Share, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	shares, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, share := range shares {
+		if share.ID == id {
+			remaining := append(shares[:i:i], shares[i+1:]...)
+			if err := s.save(remaining); err != nil {
+				return nil, err
+			}
+			return &share, nil
+		}
+	}
+
+	return nil, fmt.Errorf("share not found: %s", id)
+}
+
+// RevokeExpired removes the keys of every share whose TTL has elapsed and
+// drops those shares from the store. Shares whose keys could not be removed
+// are kept so the next sweep retries them.
+func (s *ShareStore) RevokeExpired(km KeyManager, now time.Time) ([]Share, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	shares, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	var revoked, remaining []Share
+	var firstErr error
+	for _, share := range shares {
+		if !share.Expired(now) {
+			remaining = append(remaining, share)
+			continue
+		}
+		if err := RevokeShareKeys(km, share); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("revoke share %s: %w", share.ID, err)
+			}
+			remaining = append(remaining, share)
+			continue
+		}
+		revoked = append(revoked, share)
+	}
+
+	if len(revoked) > 0 {
+		if err := s.save(remaining); err != nil {
+			return revoked, err
+		}
+	}
+
+	return revoked, firstErr
+}
+
+// RevokeShareKeys removes every key granted by a share. Keys that are
+// already gone are not treated as an error.
+func RevokeShareKeys(km KeyManager, share Share) error {
+	keys, err := km.ListKeys(share.User)
+	if err != nil {
+		return fmt.Errorf("list keys: %w", err)
+	}
+
+	present := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		present[key.Fingerprint] = true
+	}
+
+	var ids []string
+	for _, fp := range share.Fingerprints {
+		if present[fp] {
+			ids = append(ids, fp)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return km.BulkRevoke(share.User, ids)
+}
+
+func (s *ShareStore) load() ([]Share, error) {
+	if _, err := ShareMigrations.MigrateFile(s.path, false); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Share{}, nil
+		}
+		return nil, fmt.Errorf("read shares: %w", err)
+	}
+
+	var file shareFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse shares: %w", err)
+	}
+	return file.Shares, nil
+}
+
+func (s *ShareStore) save(shares []Share) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("create shares directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(shareFile{Version: shareFileVersion, Shares: shares}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode shares: %w", err)
+	}
+
+	return os.WriteFile(s.path, data, 0600)
+}
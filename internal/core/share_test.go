@@ -0,0 +1,168 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestWithExpiry tests that expiring keys round-trip through authorized_keys
+func TestWithExpiry(t *testing.T) {
+	km, _, cleanup := setupTestKeyManager(t)
+	defer cleanup()
+
+	key, err := km.ValidateKey(testED25519Key)
+	if err != nil {
+		t.Fatalf("ValidateKey() error = %v", err)
+	}
+
+	expiresAt := time.Now().Add(2 * time.Hour)
+	expiring := WithExpiry(*key, expiresAt)
+	if !strings.HasPrefix(expiring.PublicKey, `expiry-time="`) {
+		t.Errorf("WithExpiry() line = %q, want expiry-time option", expiring.PublicKey)
+	}
+
+	if err := km.AddKey("alice", expiring); err != nil {
+		t.Fatalf("AddKey() error = %v", err)
+	}
+
+	keys, err := km.ListKeys("alice")
+	if err != nil {
+		t.Fatalf("ListKeys() error = %v", err)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("ListKeys() returned %d keys, want 1", len(keys))
+	}
+	if keys[0].ExpiresAt == nil {
+		t.Fatal("ListKeys() ExpiresAt = nil, want parsed expiry-time")
+	}
+	if !keys[0].ExpiresAt.Equal(expiresAt.Truncate(time.Minute)) {
+		t.Errorf("ExpiresAt = %v, want %v", keys[0].ExpiresAt, expiresAt.Truncate(time.Minute))
+	}
+	if keys[0].Fingerprint != key.Fingerprint {
+		t.Errorf("Fingerprint = %v, want %v", keys[0].Fingerprint, key.Fingerprint)
+	}
+}
+
+// TestShareStore tests adding, listing and removing shares
+func TestShareStore(t *testing.T) {
+	store := NewShareStore(filepath.Join(t.TempDir(), "shares.json"))
+
+	share := Share{
+		ID:        NewShareID(),
+		User:      "alice",
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if err := store.Add(share); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if err := store.Add(share); err == nil {
+		t.Error("Add() expected error for duplicate share")
+	}
+
+	shares, err := store.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(shares) != 1 || shares[0].User != "alice" {
+		t.Fatalf("List() = %+v, want one share for alice", shares)
+	}
+
+	removed, err := store.Remove(share.ID)
+	if err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if removed.ID != share.ID {
+		t.Errorf("Remove() ID = %v, want %v", removed.ID, share.ID)
+	}
+	if _, err := store.Remove(share.ID); err == nil {
+		t.Error("Remove() expected error for missing share")
+	}
+}
+
+// TestShareStoreRevokeExpired tests that only expired shares lose their keys
+func TestShareStoreRevokeExpired(t *testing.T) {
+	km, _, cleanup := setupTestKeyManager(t)
+	defer cleanup()
+
+	store := NewShareStore(filepath.Join(t.TempDir(), "shares.json"))
+	now := time.Now()
+
+	for _, tc := range []struct {
+		id, user, keyStr string
+		expiresAt        time.Time
+	}{
+		{"expired", "alice", testED25519Key, now.Add(-time.Minute)},
+		{"active", "bob", testECDSAKey, now.Add(time.Hour)},
+	} {
+		key, err := km.ValidateKey(tc.keyStr)
+		if err != nil {
+			t.Fatalf("ValidateKey() error = %v", err)
+		}
+		if err := km.AddKey(tc.user, *key); err != nil {
+			t.Fatalf("AddKey() error = %v", err)
+		}
+		if err := store.Add(Share{
+			ID:           tc.id,
+			User:         tc.user,
+			Fingerprints: []string{key.Fingerprint},
+			CreatedAt:    now.Add(-time.Hour),
+			ExpiresAt:    tc.expiresAt,
+		}); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+
+	revoked, err := store.RevokeExpired(km, now)
+	if err != nil {
+		t.Fatalf("RevokeExpired() error = %v", err)
+	}
+	if len(revoked) != 1 || revoked[0].ID != "expired" {
+		t.Fatalf("RevokeExpired() = %+v, want only the expired share", revoked)
+	}
+
+	keys, _ := km.ListKeys("")
+	if len(keys) != 1 || keys[0].Type != "ecdsa-sha2-nistp256" {
+		t.Errorf("remaining keys = %+v, want only bob's key", keys)
+	}
+
+	shares, _ := store.List()
+	if len(shares) != 1 || shares[0].ID != "active" {
+		t.Errorf("remaining shares = %+v, want only the active share", shares)
+	}
+
+	// A second sweep is a no-op
+	revoked, err = store.RevokeExpired(km, now)
+	if err != nil || len(revoked) != 0 {
+		t.Errorf("second RevokeExpired() = %v, %v; want nothing", revoked, err)
+	}
+}
+
+// TestShareStoreMigratesLegacyFile tests that a pre-versioning shares.json,
+// a bare JSON list, is still readable and is upgraded in place
+func TestShareStoreMigratesLegacyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shares.json")
+	legacy := `[{"id":"ab12","user":"alice","fingerprints":["SHA256:x"],"created_at":"2026-01-01T00:00:00Z","expires_at":"2026-01-02T00:00:00Z"}]`
+	if err := os.WriteFile(path, []byte(legacy), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	shares, err := NewShareStore(path).List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(shares) != 1 || shares[0].ID != "ab12" || shares[0].ExpiresAt.IsZero() {
+		t.Fatalf("List() = %+v, want the legacy share", shares)
+	}
+
+	if _, err := os.Stat(path + ".v0.bak"); err != nil {
+		t.Errorf("expected legacy file backed up: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), `"version": 1`) {
+		t.Errorf("shares.json not upgraded:\n%s", data)
+	}
+}
@@ -0,0 +1,294 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Errors approving a staged action
+var (
+	ErrStagedNotFound = errors.New("no staged action with that ID")
+	ErrStagedExpired  = errors.New("the staged action expired before it was approved")
+	ErrSameOperator   = errors.New("a staged action must be approved by a different operator")
+)
+
+// StagedAction is an emergency or bulk action one operator has asked for
+// and a second must approve before it runs: the two-person rule
+type StagedAction struct {
+	ID        string            `json:"id"`
+	Action    string            `json:"action"` // e.g. emergency-revoke
+	User      string            `json:"user"`   // whose keys it acts on
+	Reason    string            `json:"reason,omitempty"`
+	Params    map[string]string `json:"params,omitempty"`
+	StagedBy  string            `json:"staged_by"`
+	StagedAt  time.Time         `json:"staged_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// Expired reports whether the time to approve the action has run out
+func (a *StagedAction) Expired(now time.Time) bool {
+	return !now.Before(a.ExpiresAt)
+}
+
+// stagedFileVersion is the staged.json format written by this release
+const stagedFileVersion = 1
+
+// stagedFile is the on-disk form of a StagedActionStore
+type stagedFile struct {
+	Version int            `json:"version"`
+	Actions []StagedAction `json:"actions"`
+}
+
+// StagedActionStore keeps staged actions in a JSON file until they are
+// approved, rejected or expire, auditing each step. Operators share the file
+// from processes of their own, so every change happens under a file lock.
+type StagedActionStore struct {
+	mu          sync.Mutex
+	path        string
+	auditLogger *AuditLogger
+}
+
+// NewStagedActionStore creates a store backed by the given file. auditLogger
+// may be nil.
+func NewStagedActionStore(path string, auditLogger *AuditLogger) *StagedActionStore {
+	return &StagedActionStore{path: path, auditLogger: auditLogger}
+}
+
+// Stage records action, staged by operator, to be approved within window
+func (s *StagedActionStore) Stage(action StagedAction, operator string, window time.Duration, now time.Time) (*StagedAction, error) {
+	if operator == "" {
+		return nil, fmt.Errorf("the operator staging an action must be known")
+	}
+	if window <= 0 {
+		return nil, fmt.Errorf("invalid approval window %s", window)
+	}
+
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	actions, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	actions = s.dropExpired(actions, now)
+
+	action.ID = NewShareID()
+	action.StagedBy = operator
+	action.StagedAt = now
+	action.ExpiresAt = now.Add(window)
+	if err := s.save(append(actions, action)); err != nil {
+		return nil, err
+	}
+	s.audit("action_staged", action, operator, nil)
+	return &action, nil
+}
+
+// Pending returns the actions waiting for approval, oldest first
+func (s *StagedActionStore) Pending(now time.Time) ([]StagedAction, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	actions, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	pending := s.dropExpired(actions, now)
+	if len(pending) != len(actions) {
+		if err := s.save(pending); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].StagedAt.Before(pending[j].StagedAt) })
+	return pending, nil
+}
+
+// Approve takes the action off the store for approver to run. The operator
+// who staged it can't approve it, and an expired action is dropped.
+func (s *StagedActionStore) Approve(id, approver string, now time.Time) (*StagedAction, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	action, remaining, err := s.take(id)
+	if err != nil {
+		return nil, err
+	}
+	if action.StagedBy == approver || approver == "" {
+		s.audit("action_approval_refused", *action, approver, ErrSameOperator)
+		return nil, ErrSameOperator
+	}
+	if err := s.save(remaining); err != nil {
+		return nil, err
+	}
+	if action.Expired(now) {
+		s.audit("action_expired", *action, approver, ErrStagedExpired)
+		return nil, ErrStagedExpired
+	}
+	s.audit("action_approved", *action, approver, nil)
+	return action, nil
+}
+
+// Reject drops a staged action without running it. Either operator may
+// reject it, including the one who staged it.
+func (s *StagedActionStore) Reject(id, operator string) (*StagedAction, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	action, remaining, err := s.take(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.save(remaining); err != nil {
+		return nil, err
+	}
+	s.audit("action_rejected", *action, operator, nil)
+	return action, nil
+}
+
+// RecordOutcome audits how an approved action went when it ran
+func (s *StagedActionStore) RecordOutcome(action StagedAction, approver string, err error) {
+	s.audit("action_executed", action, approver, err)
+}
+
+// take finds the action by ID and returns it with the others
+func (s *StagedActionStore) take(id string) (*StagedAction, []StagedAction, error) {
+	actions, err := s.load()
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, action := range actions {
+		if action.ID == id {
+			return &action, append(actions[:i:i], actions[i+1:]...), nil
+		}
+	}
+	return nil, nil, ErrStagedNotFound
+}
+
+// dropExpired returns the actions still open for approval, auditing the
+// ones that ran out of time
+func (s *StagedActionStore) dropExpired(actions []StagedAction, now time.Time) []StagedAction {
+	open := actions[:0:0]
+	for _, action := range actions {
+		if action.Expired(now) {
+			s.audit("action_expired", action, "", ErrStagedExpired)
+			continue
+		}
+		open = append(open, action)
+	}
+	return open
+}
+
+func (s *StagedActionStore) audit(eventType string, action StagedAction, operator string, err error) {
+	if s.auditLogger == nil {
+		return
+	}
+
+	details := map[string]interface{}{
+		"action_id":  action.ID,
+		"action":     action.Action,
+		"staged_by":  action.StagedBy,
+		"expires_at": action.ExpiresAt,
+	}
+	if action.Reason != "" {
+		details["reason"] = action.Reason
+	}
+	if len(action.Params) > 0 {
+		details["params"] = action.Params
+	}
+	if operator != "" {
+		details["operator"] = operator
+	}
+	if err != nil {
+		details["error"] = err.Error()
+	}
+
+	_ = s.auditLogger.Log(AuditEvent{
+		Timestamp: time.Now(),
+		EventType: eventType,
+		Method:    "two-person",
+		User:      action.User,
+		Details:   details,
+		Success:   err == nil,
+	})
+}
+
+// lock takes the store for a load-change-save cycle, against other
+// processes as well as this one, so two operators can't both approve the
+// same action. The returned function releases it.
+func (s *StagedActionStore) lock() (func(), error) {
+	s.mu.Lock()
+	if err := os.MkdirAll(filepath.Dir(s.path), 0770); err != nil {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("create staged actions directory: %w", err)
+	}
+	f, err := lockFileMode(s.path+".lock", true, 0660)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("lock staged actions: %w", err)
+	}
+	return func() {
+		_ = unlockFile(f)
+		s.mu.Unlock()
+	}, nil
+}
+
+func (s *StagedActionStore) load() ([]StagedAction, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []StagedAction{}, nil
+		}
+		return nil, fmt.Errorf("read staged actions: %w", err)
+	}
+
+	var file stagedFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse staged actions: %w", err)
+	}
+	return file.Actions, nil
+}
+
+// save writes the store for the operators sharing it, who are told apart
+// from others by the group owning its directory. It writes then renames, so
+// a reader never sees a partly written file.
+func (s *StagedActionStore) save(actions []StagedAction) error {
+	data, err := json.MarshalIndent(stagedFile{Version: stagedFileVersion, Actions: actions}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode staged actions: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".staged-*")
+	if err != nil {
+		return fmt.Errorf("write staged actions: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write staged actions: %w", err)
+	}
+	if err := tmp.Chmod(0660); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write staged actions: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write staged actions: %w", err)
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,168 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStagedActionTwoPersonRule(t *testing.T) {
+	dir := t.TempDir()
+	auditPath := filepath.Join(dir, "audit.log")
+	auditLogger, err := NewAuditLogger(auditPath, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLogger.Close()
+
+	store := NewStagedActionStore(filepath.Join(dir, "staged.json"), auditLogger)
+	now := time.Now()
+	staged, err := store.Stage(StagedAction{
+		Action: "emergency-revoke",
+		User:   "bob",
+		Reason: "laptop stolen",
+		Params: map[string]string{"kill_sessions": "true"},
+	}, "alice", 30*time.Minute, now)
+	if err != nil {
+		t.Fatalf("Stage() error = %v", err)
+	}
+
+	if _, err := store.Approve(staged.ID, "alice", now.Add(time.Minute)); !errors.Is(err, ErrSameOperator) {
+		t.Fatalf("Approve() by the stager error = %v, want ErrSameOperator", err)
+	}
+	if pending, _ := store.Pending(now.Add(time.Minute)); len(pending) != 1 {
+		t.Fatalf("Pending() = %+v, want the action still staged after a refused approval", pending)
+	}
+
+	approved, err := store.Approve(staged.ID, "carol", now.Add(2*time.Minute))
+	if err != nil {
+		t.Fatalf("Approve() error = %v", err)
+	}
+	if approved.User != "bob" || approved.Params["kill_sessions"] != "true" || approved.StagedBy != "alice" {
+		t.Errorf("approved action = %+v", approved)
+	}
+	if _, err := store.Approve(staged.ID, "carol", now.Add(2*time.Minute)); !errors.Is(err, ErrStagedNotFound) {
+		t.Errorf("second Approve() error = %v, want ErrStagedNotFound", err)
+	}
+	store.RecordOutcome(*approved, "carol", nil)
+
+	events, err := ReadAuditLog(auditPath, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, e := range events {
+		types = append(types, e.EventType)
+	}
+	want := []string{"action_staged", "action_approval_refused", "action_approved", "action_executed"}
+	if len(types) != len(want) {
+		t.Fatalf("audited %v, want %v", types, want)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Errorf("audited %v, want %v", types, want)
+			break
+		}
+	}
+}
+
+func TestStagedActionExpiresAndRejects(t *testing.T) {
+	store := NewStagedActionStore(filepath.Join(t.TempDir(), "staged.json"), nil)
+	now := time.Now()
+
+	late, err := store.Stage(StagedAction{Action: "fleet-revoke", User: "bob"}, "alice", 10*time.Minute, now)
+	if err != nil {
+		t.Fatalf("Stage() error = %v", err)
+	}
+	if _, err := store.Approve(late.ID, "carol", now.Add(10*time.Minute)); !errors.Is(err, ErrStagedExpired) {
+		t.Errorf("Approve() after the window error = %v, want ErrStagedExpired", err)
+	}
+
+	dropped, err := store.Stage(StagedAction{Action: "fleet-revoke", User: "dave"}, "alice", time.Hour, now)
+	if err != nil {
+		t.Fatalf("Stage() error = %v", err)
+	}
+	if _, err := store.Reject(dropped.ID, "alice"); err != nil {
+		t.Errorf("Reject() by the stager error = %v", err)
+	}
+	if pending, _ := store.Pending(now); len(pending) != 0 {
+		t.Errorf("Pending() = %+v, want nothing left", pending)
+	}
+
+	if _, err := store.Stage(StagedAction{Action: "fleet-revoke"}, "", time.Hour, now); err == nil {
+		t.Error("expected error staging without an operator")
+	}
+}
+
+func TestStagedActionSharedAcrossStores(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("only the in-process mutex serializes access on Windows")
+	}
+	// The race is narrow, so run it a few times
+	for round := 0; round < 20; round++ {
+		raceStagedStores(t, filepath.Join(t.TempDir(), "staged.json"))
+	}
+}
+
+// raceStagedStores has operators approve one action and stage others at the
+// same time, each through a store of its own as their commands would
+func raceStagedStores(t *testing.T, path string) {
+	t.Helper()
+	now := time.Now()
+
+	staged, err := NewStagedActionStore(path, nil).Stage(StagedAction{Action: "emergency-revoke", User: "bob"}, "alice", time.Hour, now)
+	if err != nil {
+		t.Fatalf("Stage() error = %v", err)
+	}
+
+	const approvers = 8
+	var wg sync.WaitGroup
+	var approved atomic.Int32
+	errs := make(chan error, approvers*3)
+	for i := 0; i < approvers; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			store := NewStagedActionStore(path, nil)
+			_, err := store.Approve(staged.ID, fmt.Sprintf("operator%d", i), now)
+			switch {
+			case err == nil:
+				approved.Add(1)
+			case !errors.Is(err, ErrStagedNotFound):
+				errs <- err
+			}
+		}(i)
+		// Meanwhile others stage and list actions
+		go func(i int) {
+			defer wg.Done()
+			store := NewStagedActionStore(path, nil)
+			if _, err := store.Stage(StagedAction{Action: "bulk-revoke", User: "carol"}, "dave", time.Hour, now); err != nil {
+				errs <- err
+			}
+			if _, err := store.Pending(now); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("concurrent store error = %v", err)
+	}
+	if n := approved.Load(); n != 1 {
+		t.Errorf("action approved %d times, want once", n)
+	}
+	pending, err := NewStagedActionStore(path, nil).Pending(now)
+	if err != nil {
+		t.Fatalf("Pending() error = %v", err)
+	}
+	if len(pending) != approvers {
+		t.Errorf("Pending() = %d actions, want the %d staged alongside", len(pending), approvers)
+	}
+}
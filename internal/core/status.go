@@ -0,0 +1,147 @@
+package core
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ConnectionStatus is a point-in-time view of a connection that combines its
+// state, metrics and failover health, suitable for serializing to dashboards.
+// In JSON, uptime, total_uptime and latency are in seconds.
+type ConnectionStatus struct {
+	ID                  string        `json:"id"`
+	Method              string        `json:"method"`
+	State               string        `json:"state"`
+	Primary             bool          `json:"primary"`
+	Priority            int           `json:"priority"`
+	StartedAt           time.Time     `json:"started_at,omitempty"`
+	Uptime              time.Duration `json:"uptime"`
+	TotalUptime         time.Duration `json:"total_uptime"`
+	History             []StateChange `json:"history,omitempty"`
+	Healthy             bool          `json:"healthy"`
+	ConsecutiveFailures int           `json:"consecutive_failures"`
+	LastCheck           time.Time     `json:"last_check,omitempty"`
+	Latency             time.Duration `json:"latency"`
+	BytesSent           int64         `json:"bytes_sent"`
+	BytesReceived       int64         `json:"bytes_received"`
+	FailureCount        int           `json:"failure_count"`
+	LastError           string        `json:"last_error,omitempty"`
+}
+
+// connectionStatusJSON is ConnectionStatus with its durations in seconds
+type connectionStatusJSON struct {
+	plainConnectionStatus
+	Uptime      float64 `json:"uptime"`
+	TotalUptime float64 `json:"total_uptime"`
+	Latency     float64 `json:"latency"`
+}
+
+type plainConnectionStatus ConnectionStatus
+
+// MarshalJSON writes the durations as seconds rather than nanoseconds
+func (s ConnectionStatus) MarshalJSON() ([]byte, error) {
+	return json.Marshal(connectionStatusJSON{
+		plainConnectionStatus: plainConnectionStatus(s),
+		Uptime:                s.Uptime.Seconds(),
+		TotalUptime:           s.TotalUptime.Seconds(),
+		Latency:               s.Latency.Seconds(),
+	})
+}
+
+// UnmarshalJSON reads the durations as seconds
+func (s *ConnectionStatus) UnmarshalJSON(data []byte) error {
+	var v connectionStatusJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*s = ConnectionStatus(v.plainConnectionStatus)
+	s.Uptime = seconds(v.Uptime)
+	s.TotalUptime = seconds(v.TotalUptime)
+	s.Latency = seconds(v.Latency)
+	return nil
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// StateChange is a state transition as reported in ConnectionStatus
+type StateChange struct {
+	State string    `json:"state"`
+	At    time.Time `json:"at"`
+}
+
+// ConnectionStatus returns the status of a single connection
+func (m *DefaultConnectionManager) ConnectionStatus(connID string) (*ConnectionStatus, error) {
+	conn, err := m.Status(connID)
+	if err != nil {
+		return nil, err
+	}
+	status := m.buildStatus(conn)
+	return &status, nil
+}
+
+// ConnectionStatuses returns the status of every connection, oldest first
+func (m *DefaultConnectionManager) ConnectionStatuses() []ConnectionStatus {
+	m.mu.RLock()
+	conns := make([]*Connection, 0, len(m.startOrder))
+	for _, id := range m.startOrder {
+		if conn, ok := m.connections[id]; ok {
+			conns = append(conns, conn)
+		}
+	}
+	m.mu.RUnlock()
+
+	statuses := make([]ConnectionStatus, 0, len(conns))
+	for _, conn := range conns {
+		statuses = append(statuses, m.buildStatus(conn))
+	}
+	return statuses
+}
+
+// buildStatus snapshots a connection and its failover health
+func (m *DefaultConnectionManager) buildStatus(conn *Connection) ConnectionStatus {
+	status := ConnectionStatus{
+		ID:          conn.ID,
+		Method:      conn.Method,
+		State:       conn.GetState().String(),
+		Primary:     conn.IsPrimaryConnection(),
+		Priority:    conn.GetPriority(),
+		StartedAt:   conn.StartedAt,
+		Uptime:      conn.GetUptime(),
+		TotalUptime: conn.TotalUptime(),
+		Healthy:     conn.GetState() == StateConnected,
+	}
+	for _, t := range conn.StateHistory() {
+		status.History = append(status.History, StateChange{State: t.State.String(), At: t.At})
+	}
+
+	if conn.Metrics != nil {
+		conn.Metrics.mu.RLock()
+		status.Latency = conn.Metrics.Latency
+		status.BytesSent = conn.Metrics.BytesSent
+		status.BytesReceived = conn.Metrics.BytesReceived
+		status.FailureCount = conn.Metrics.FailureCount
+		if conn.Metrics.LastError != nil {
+			status.LastError = conn.Metrics.LastError.Error()
+		}
+		conn.Metrics.mu.RUnlock()
+	}
+
+	if m.failoverManager != nil {
+		if health, err := m.failoverManager.GetHealthStatus(conn.ID); err == nil {
+			health.mu.RLock()
+			// Failover only marks a connection healthy after several good checks,
+			// so a connected one counts as healthy until a check fails
+			status.Healthy = health.IsHealthy || (status.Healthy && health.ConsecutiveFailures == 0)
+			status.ConsecutiveFailures = health.ConsecutiveFailures
+			status.LastCheck = health.LastCheck
+			if health.LastError != nil {
+				status.LastError = health.LastError.Error()
+			}
+			health.mu.RUnlock()
+		}
+	}
+
+	return status
+}
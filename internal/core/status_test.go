@@ -0,0 +1,82 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConnectionStatuses(t *testing.T) {
+	manager := NewConnectionManager(nil)
+	defer manager.Shutdown()
+
+	manager.RegisterProvider(NewMockProvider("first", 0.0, 10*time.Millisecond))
+	manager.RegisterProvider(NewMockProvider("second", 0.0, 10*time.Millisecond))
+
+	first, err := manager.Start("first", DefaultConfig())
+	if err != nil {
+		t.Fatalf("Failed to start connection: %v", err)
+	}
+	if _, err := manager.Start("second", DefaultConfig()); err != nil {
+		t.Fatalf("Failed to start connection: %v", err)
+	}
+
+	first.Metrics.Update(100, 200, 15*time.Millisecond)
+	first.Metrics.RecordFailure(errors.New("probe timed out"))
+	if err := manager.SetPrimary(first.ID); err != nil {
+		t.Fatalf("SetPrimary() error = %v", err)
+	}
+
+	statuses := manager.ConnectionStatuses()
+	if len(statuses) != 2 {
+		t.Fatalf("ConnectionStatuses() returned %d entries, want 2", len(statuses))
+	}
+	if statuses[0].Method != "first" || statuses[1].Method != "second" {
+		t.Errorf("expected statuses in start order, got %s, %s", statuses[0].Method, statuses[1].Method)
+	}
+
+	got := statuses[0]
+	if !got.Primary || got.State != "Connected" || !got.Healthy {
+		t.Errorf("unexpected status: %+v", got)
+	}
+	if got.BytesSent != 100 || got.BytesReceived != 200 || got.Latency != 15*time.Millisecond {
+		t.Errorf("unexpected metrics in status: %+v", got)
+	}
+	if got.FailureCount != 1 || got.LastError != "probe timed out" {
+		t.Errorf("unexpected failure info in status: %+v", got)
+	}
+
+	if _, err := manager.ConnectionStatus("missing"); err == nil {
+		t.Error("expected error for unknown connection")
+	}
+}
+
+func TestConnectionStatusJSON(t *testing.T) {
+	status := ConnectionStatus{
+		ID:          "bore-1",
+		Uptime:      90 * time.Second,
+		TotalUptime: 2 * time.Hour,
+		Latency:     1500 * time.Microsecond,
+	}
+	data, err := json.Marshal(status)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if raw["uptime"] != 90.0 || raw["total_uptime"] != 7200.0 || raw["latency"] != 0.0015 {
+		t.Errorf("durations in %s, want seconds", data)
+	}
+
+	var decoded ConnectionStatus
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if decoded.ID != "bore-1" || decoded.Uptime != status.Uptime || decoded.TotalUptime != status.TotalUptime || decoded.Latency != status.Latency {
+		t.Errorf("round trip = %+v, want %+v", decoded, status)
+	}
+}
@@ -0,0 +1,337 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// throttleBufferSize is how much a relay reads from one side at a time
+const throttleBufferSize = 32 * 1024
+
+// rateWindow is how many seconds the live transfer rates average over
+const rateWindow = 4
+
+// ThrottleLimits caps the bandwidth of a tunnel in kilobits a second. Upload
+// is what the local service sends out through the tunnel, download what
+// comes in. Zero is unlimited.
+type ThrottleLimits struct {
+	UploadKbps   int `json:"upload_kbps"`
+	DownloadKbps int `json:"download_kbps"`
+}
+
+// Unlimited reports whether neither direction is capped
+func (l ThrottleLimits) Unlimited() bool {
+	return l.UploadKbps <= 0 && l.DownloadKbps <= 0
+}
+
+// RateLimiter is a token bucket handing out bytes at a fixed rate, with a
+// burst of one second's worth
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes a second
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a limiter allowing kbps kilobits a second, or nil
+// when kbps is not positive. A nil limiter never waits.
+func NewRateLimiter(kbps int) *RateLimiter {
+	if kbps <= 0 {
+		return nil
+	}
+	rate := float64(kbps) * 1000 / 8
+	return &RateLimiter{rate: rate, tokens: rate, last: time.Now()}
+}
+
+// WaitN takes n bytes from the bucket, waiting until the rate allows them
+// or ctx is done. A large n runs the bucket into debt that later calls wait
+// out, so n need not fit in the burst.
+func (r *RateLimiter) WaitN(ctx context.Context, n int) error {
+	if r == nil || n <= 0 {
+		return nil
+	}
+
+	r.mu.Lock()
+	now := time.Now()
+	r.tokens += now.Sub(r.last).Seconds() * r.rate
+	if r.tokens > r.rate {
+		r.tokens = r.rate
+	}
+	r.last = now
+	r.tokens -= float64(n)
+	var wait time.Duration
+	if r.tokens < 0 {
+		wait = time.Duration(-r.tokens / r.rate * float64(time.Second))
+	}
+	r.mu.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rateMeter counts bytes per second over the last rateWindow seconds
+type rateMeter struct {
+	mu      sync.Mutex
+	seconds [rateWindow]int64
+	bytes   [rateWindow]int64
+}
+
+func (m *rateMeter) add(n int) {
+	now := time.Now().Unix()
+	i := now % rateWindow
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.seconds[i] != now {
+		m.seconds[i] = now
+		m.bytes[i] = 0
+	}
+	m.bytes[i] += int64(n)
+}
+
+// kbps is the average rate of the last rateWindow seconds, in kilobits a
+// second
+func (m *rateMeter) kbps() float64 {
+	now := time.Now().Unix()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var total int64
+	for i, sec := range m.seconds {
+		if now-sec < rateWindow {
+			total += m.bytes[i]
+		}
+	}
+	return float64(total) * 8 / 1000 / rateWindow
+}
+
+// ThrottleStatus is the limits and live rates of a throttled tunnel
+type ThrottleStatus struct {
+	Provider      string         `json:"provider"`
+	Target        string         `json:"target"`
+	Limits        ThrottleLimits `json:"limits"`
+	UploadKbps    float64        `json:"upload_kbps"`
+	DownloadKbps  float64        `json:"download_kbps"`
+	BytesSent     int64          `json:"bytes_sent"`
+	BytesReceived int64          `json:"bytes_received"`
+	Connections   int            `json:"connections"`
+	Capture       *CaptureStatus `json:"capture,omitempty"` // the capture running, if any
+}
+
+// Throttle relays the connections a tunnel makes to a local service,
+// holding each direction to its limit and capturing the traffic on demand.
+// Tunnel clients run as processes of their own, so the way to throttle
+// them is to point them at the relay instead of the service.
+type Throttle struct {
+	Name   string // provider the relay serves
+	Target string // address of the local service
+	Limits ThrottleLimits
+
+	// OnTransfer, if set, is called with the bytes relayed in each
+	// direction as they pass
+	OnTransfer func(sent, received int64)
+
+	ln          net.Listener
+	upload      *RateLimiter
+	download    *RateLimiter
+	uploadRate  rateMeter
+	downRate    rateMeter
+	sent        atomic.Int64
+	received    atomic.Int64
+	connections atomic.Int32
+	capture     atomic.Pointer[Capture]
+}
+
+// NewThrottle returns a relay to target for the tunnel of provider name
+func NewThrottle(name, target string, limits ThrottleLimits) *Throttle {
+	return &Throttle{
+		Name:     name,
+		Target:   target,
+		Limits:   limits,
+		upload:   NewRateLimiter(limits.UploadKbps),
+		download: NewRateLimiter(limits.DownloadKbps),
+	}
+}
+
+// Listen opens the relay's listener; use port 0 to let the system pick
+func (t *Throttle) Listen(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("throttle %s: %w", t.Name, err)
+	}
+	t.ln = ln
+	return nil
+}
+
+// Port is the port the relay listens on, or 0 before Listen
+func (t *Throttle) Port() int {
+	if t.ln == nil {
+		return 0
+	}
+	return t.ln.Addr().(*net.TCPAddr).Port
+}
+
+// Serve relays connections until ctx is done or the relay is closed
+func (t *Throttle) Serve(ctx context.Context) error {
+	if t.ln == nil {
+		return fmt.Errorf("throttle %s: not listening", t.Name)
+	}
+	go func() {
+		<-ctx.Done()
+		t.ln.Close()
+	}()
+
+	for {
+		conn, err := t.ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go t.handle(ctx, conn)
+	}
+}
+
+// Close stops accepting connections; those already relayed run until
+// either side ends them
+func (t *Throttle) Close() error {
+	if t.ln == nil {
+		return nil
+	}
+	if err := t.ln.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// Status returns the relay's limits and live rates
+func (t *Throttle) Status() ThrottleStatus {
+	status := ThrottleStatus{
+		Provider:      t.Name,
+		Target:        t.Target,
+		Limits:        t.Limits,
+		UploadKbps:    t.uploadRate.kbps(),
+		DownloadKbps:  t.downRate.kbps(),
+		BytesSent:     t.sent.Load(),
+		BytesReceived: t.received.Load(),
+		Connections:   int(t.connections.Load()),
+	}
+	if c := t.capture.Load(); c != nil {
+		capture := c.Status()
+		status.Capture = &capture
+	}
+	return status
+}
+
+// StartCapture records the traffic of every relayed connection to c
+// until StopCapture
+func (t *Throttle) StartCapture(c *Capture) error {
+	if !t.capture.CompareAndSwap(nil, c) {
+		return fmt.Errorf("throttle %s: %w", t.Name, ErrCaptureRunning)
+	}
+	return nil
+}
+
+// StopCapture ends the running capture and returns it for the caller to
+// close, or nil when none was running
+func (t *Throttle) StopCapture() *Capture {
+	return t.capture.Swap(nil)
+}
+
+// handle relays conn, which the tunnel client opened, to the target
+func (t *Throttle) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	var d net.Dialer
+	service, err := d.DialContext(ctx, "tcp", t.Target)
+	if err != nil {
+		return
+	}
+	defer service.Close()
+
+	t.connections.Add(1)
+	defer t.connections.Add(-1)
+
+	client, _ := conn.RemoteAddr().(*net.TCPAddr)
+	server, _ := service.RemoteAddr().(*net.TCPAddr)
+	record := func(fromClient bool, p []byte) {
+		if c := t.capture.Load(); c != nil && client != nil && server != nil {
+			c.Record(client, server, fromClient, p)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		t.copy(ctx, conn, service, t.upload, func(p []byte) {
+			t.uploadRate.add(len(p))
+			t.sent.Add(int64(len(p)))
+			t.transferred(int64(len(p)), 0)
+			record(false, p)
+		})
+	}()
+	go func() {
+		defer wg.Done()
+		t.copy(ctx, service, conn, t.download, func(p []byte) {
+			t.downRate.add(len(p))
+			t.received.Add(int64(len(p)))
+			t.transferred(0, int64(len(p)))
+			record(true, p)
+		})
+	}()
+	wg.Wait()
+}
+
+// copy relays src to dst at the rate limit allows, handing relayed what
+// passed, then half-closes dst
+func (t *Throttle) copy(ctx context.Context, dst, src net.Conn, limit *RateLimiter, relayed func(p []byte)) {
+	defer func() {
+		if tcp, ok := dst.(*net.TCPConn); ok {
+			tcp.CloseWrite()
+		} else {
+			dst.Close()
+		}
+	}()
+
+	buf := make([]byte, throttleBufferSize)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if limit.WaitN(ctx, n) != nil {
+				return
+			}
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return
+			}
+			relayed(buf[:n])
+		}
+		if err != nil {
+			if err != io.EOF {
+				dst.Close()
+			}
+			return
+		}
+	}
+}
+
+func (t *Throttle) transferred(sent, received int64) {
+	if t.OnTransfer != nil {
+		t.OnTransfer(sent, received)
+	}
+}
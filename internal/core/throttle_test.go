@@ -0,0 +1,116 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestThrottleLimitsDownload(t *testing.T) {
+	// The local service reads what the tunnel sends and answers once done
+	service, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer service.Close()
+	go func() {
+		conn, err := service.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		n, _ := io.Copy(io.Discard, conn)
+		conn.Write([]byte(strconv.FormatInt(n, 10)))
+	}()
+
+	// 160 kbps is 20 KB a second
+	th := NewThrottle("bore", service.Addr().String(), ThrottleLimits{DownloadKbps: 160})
+	var sent, received atomic.Int64
+	th.OnTransfer = func(s, r int64) {
+		sent.Add(s)
+		received.Add(r)
+	}
+	if err := th.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	capture, err := NewCapture(filepath.Join(t.TempDir(), "bore.pcap"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := th.StartCapture(capture); err != nil {
+		t.Fatal(err)
+	}
+	if err := th.StartCapture(capture); !errors.Is(err, ErrCaptureRunning) {
+		t.Errorf("second capture: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go th.Serve(ctx)
+
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(th.Port())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	payload := bytes.Repeat([]byte("x"), 50*1024)
+	if _, err := conn.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+	conn.(*net.TCPConn).CloseWrite()
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+
+	if string(reply) != strconv.Itoa(len(payload)) {
+		t.Fatalf("service got %s bytes, want %d", reply, len(payload))
+	}
+	// The first second's worth passes at once, the remaining 30 KB take 1.5s
+	if elapsed < time.Second {
+		t.Errorf("50 KB at 160 kbps took %s", elapsed)
+	}
+
+	status := th.Status()
+	if status.BytesReceived != int64(len(payload)) || status.BytesSent != int64(len(reply)) {
+		t.Errorf("status counted %d received, %d sent", status.BytesReceived, status.BytesSent)
+	}
+	if received.Load() != status.BytesReceived || sent.Load() != status.BytesSent {
+		t.Errorf("OnTransfer counted %d received, %d sent", received.Load(), sent.Load())
+	}
+	if status.DownloadKbps <= 0 || status.Limits.DownloadKbps != 160 {
+		t.Errorf("status = %+v", status)
+	}
+	if status.Capture == nil || status.Capture.Packets < 2 || th.StopCapture() != capture {
+		t.Errorf("capture = %+v", status.Capture)
+	}
+	if err := capture.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	if NewRateLimiter(0) != nil {
+		t.Fatal("a zero rate should not be limited")
+	}
+	var r *RateLimiter
+	if err := r.WaitN(context.Background(), 1<<20); err != nil {
+		t.Fatalf("nil limiter: %v", err)
+	}
+
+	r = NewRateLimiter(8) // one byte a millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := r.WaitN(ctx, 2000); err == nil {
+		t.Fatal("waiting for a second's debt should end with the context")
+	}
+}
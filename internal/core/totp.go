@@ -0,0 +1,311 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	totpPeriod      = 30 * time.Second
+	totpDigits      = 6
+	totpSkew        = 1 // steps accepted either side of now, for clock drift
+	backupCodeCount = 10
+)
+
+var (
+	// ErrTOTPNotEnrolled is returned when verifying a user without a secret
+	ErrTOTPNotEnrolled = errors.New("user is not enrolled for TOTP")
+
+	// ErrTOTPInvalid is returned for a wrong, expired or reused code
+	ErrTOTPInvalid = errors.New("invalid verification code")
+)
+
+// TOTPEnrollment is a user's TOTP secret and unused backup codes
+type TOTPEnrollment struct {
+	User        string    `json:"user"`
+	Secret      string    `json:"secret"`       // base32, as shown to authenticator apps
+	BackupCodes []string  `json:"backup_codes"` // SHA-256 of each unused code
+	EnrolledAt  time.Time `json:"enrolled_at"`
+	LastStep    int64     `json:"last_step"` // last time step used, so codes can't be replayed
+}
+
+// totpFile is the on-disk form of a TOTPStore
+type totpFile struct {
+	Version int              `json:"version"`
+	Users   []TOTPEnrollment `json:"users"`
+}
+
+// TOTPStore persists TOTP enrollments to a JSON file. The file holds
+// secrets, so it is only readable by its owner.
+type TOTPStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewTOTPStore creates a TOTP store backed by the given file
+func NewTOTPStore(path string) *TOTPStore {
+	return &TOTPStore{path: path}
+}
+
+// Enroll gives user a new secret and backup codes, replacing any earlier
+// enrollment. The backup codes are only returned here; the store keeps
+// their hashes.
+func (s *TOTPStore) Enroll(user string) (string, []string, error) {
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return "", nil, err
+	}
+	codes, hashes, err := newBackupCodes(backupCodeCount)
+	if err != nil {
+		return "", nil, err
+	}
+
+	err = s.update(func(users []TOTPEnrollment) ([]TOTPEnrollment, error) {
+		users = removeEnrollment(users, user)
+		return append(users, TOTPEnrollment{
+			User:        user,
+			Secret:      secret,
+			BackupCodes: hashes,
+			EnrolledAt:  time.Now(),
+		}), nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return secret, codes, nil
+}
+
+// Remove deletes user's enrollment
+func (s *TOTPStore) Remove(user string) error {
+	return s.update(func(users []TOTPEnrollment) ([]TOTPEnrollment, error) {
+		remaining := removeEnrollment(users, user)
+		if len(remaining) == len(users) {
+			return nil, ErrTOTPNotEnrolled
+		}
+		return remaining, nil
+	})
+}
+
+// List returns every enrollment
+func (s *TOTPStore) List() ([]TOTPEnrollment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load()
+}
+
+// Enrolled reports whether user has a TOTP secret
+func (s *TOTPStore) Enrolled(user string) (bool, error) {
+	users, err := s.List()
+	if err != nil {
+		return false, err
+	}
+	for _, u := range users {
+		if u.User == user {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Verify checks code against user's secret or, failing that, their unused
+// backup codes, which are used up. It returns "totp" or "backup_code" for
+// the factor that matched.
+func (s *TOTPStore) Verify(user, code string, now time.Time) (string, error) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	var method string
+	err := s.update(func(users []TOTPEnrollment) ([]TOTPEnrollment, error) {
+		for i := range users {
+			u := &users[i]
+			if u.User != user {
+				continue
+			}
+			if step, ok := verifyTOTP(u.Secret, code, now); ok && step > u.LastStep {
+				u.LastStep = step
+				method = "totp"
+				return users, nil
+			}
+			hash := hashBackupCode(code)
+			for j, h := range u.BackupCodes {
+				if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+					u.BackupCodes = append(u.BackupCodes[:j:j], u.BackupCodes[j+1:]...)
+					method = "backup_code"
+					return users, nil
+				}
+			}
+			return nil, ErrTOTPInvalid
+		}
+		return nil, ErrTOTPNotEnrolled
+	})
+	return method, err
+}
+
+// TOTPURI returns the otpauth URI authenticator apps enroll from, usually
+// shown as a QR code
+func TOTPURI(issuer, user, secret string) string {
+	label := url.PathEscape(issuer + ":" + user)
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(int(totpPeriod.Seconds())))
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// TOTPCode returns the code for secret at t, as an authenticator app shows it
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return totpCode(key, t.Unix()/int64(totpPeriod.Seconds())), nil
+}
+
+// update runs fn on the enrollments under the store's locks and saves what
+// it returns. Verification runs in short-lived processes, so the file lock
+// keeps concurrent logins from losing each other's updates.
+func (s *TOTPStore) update(fn func([]TOTPEnrollment) ([]TOTPEnrollment, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("create totp directory: %w", err)
+	}
+	lock, err := lockFile(s.path+".lock", true)
+	if err != nil {
+		return fmt.Errorf("lock totp store: %w", err)
+	}
+	defer unlockFile(lock)
+
+	users, err := s.load()
+	if err != nil {
+		return err
+	}
+	users, err = fn(users)
+	if err != nil {
+		return err
+	}
+	return s.save(users)
+}
+
+func (s *TOTPStore) load() ([]TOTPEnrollment, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []TOTPEnrollment{}, nil
+		}
+		return nil, fmt.Errorf("read totp store: %w", err)
+	}
+
+	var file totpFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse totp store: %w", err)
+	}
+	return file.Users, nil
+}
+
+func (s *TOTPStore) save(users []TOTPEnrollment) error {
+	data, err := json.MarshalIndent(totpFile{Version: 1, Users: users}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode totp store: %w", err)
+	}
+	return os.WriteFile(s.path, data, 0600)
+}
+
+func removeEnrollment(users []TOTPEnrollment, user string) []TOTPEnrollment {
+	remaining := users[:0:0]
+	for _, u := range users {
+		if u.User != user {
+			remaining = append(remaining, u)
+		}
+	}
+	return remaining
+}
+
+// verifyTOTP checks code against the steps around now and returns the
+// step that matched
+func verifyTOTP(secret, code string, now time.Time) (int64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return 0, false
+	}
+	current := now.Unix() / int64(totpPeriod.Seconds())
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpCode computes the RFC 6238 code for a time step
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+func newTOTPSecret() (string, error) {
+	key := make([]byte, 20)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("generate totp secret: %w", err)
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(key), nil
+}
+
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.TrimRight(strings.ReplaceAll(secret, " ", ""), "="))
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid totp secret: %w", err)
+	}
+	return key, nil
+}
+
+// newBackupCodes returns n single-use codes of the form xxxx-xxxx and their
+// hashes
+func newBackupCodes(n int) ([]string, []string, error) {
+	codes := make([]string, 0, n)
+	hashes := make([]string, 0, n)
+	for range n {
+		b := make([]byte, 4)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, fmt.Errorf("generate backup codes: %w", err)
+		}
+		h := hex.EncodeToString(b)
+		code := h[:4] + "-" + h[4:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashBackupCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashBackupCode hashes a backup code, ignoring the dash and case
+func hashBackupCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(code, "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
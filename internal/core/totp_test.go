@@ -0,0 +1,61 @@
+package core
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 test vectors for the SHA-1 seed, truncated to six digits
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	for _, tc := range []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{20000000000, "353130"},
+	} {
+		got, err := TOTPCode(secret, time.Unix(tc.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("TOTPCode(%d) = %s, want %s", tc.unix, got, tc.want)
+		}
+	}
+}
+
+func TestTOTPStoreVerify(t *testing.T) {
+	store := NewTOTPStore(filepath.Join(t.TempDir(), "totp.json"))
+	secret, backup, err := store.Enroll("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backup) != backupCodeCount {
+		t.Fatalf("got %d backup codes, want %d", len(backup), backupCodeCount)
+	}
+
+	now := time.Now()
+	code, _ := TOTPCode(secret, now)
+	if method, err := store.Verify("alice", code, now); err != nil || method != "totp" {
+		t.Fatalf("Verify(current code) = %q, %v", method, err)
+	}
+	if _, err := store.Verify("alice", code, now); !errors.Is(err, ErrTOTPInvalid) {
+		t.Errorf("Verify(replayed code) error = %v, want ErrTOTPInvalid", err)
+	}
+
+	if method, err := store.Verify("alice", backup[0], now); err != nil || method != "backup_code" {
+		t.Fatalf("Verify(backup code) = %q, %v", method, err)
+	}
+	if _, err := store.Verify("alice", backup[0], now); !errors.Is(err, ErrTOTPInvalid) {
+		t.Errorf("Verify(used backup code) error = %v, want ErrTOTPInvalid", err)
+	}
+
+	if _, err := store.Verify("bob", code, now); !errors.Is(err, ErrTOTPNotEnrolled) {
+		t.Errorf("Verify(unenrolled) error = %v, want ErrTOTPNotEnrolled", err)
+	}
+}
@@ -0,0 +1,278 @@
+// Package daemon is the control channel of a long-running tunnel process.
+// The daemon listens on a unix socket only its user can open; each client
+// connection carries one JSON request naming an operation and gets one
+// JSON response back.
+//
+// A system-wide daemon instead listens on a socket every user can open and
+// tells its callers apart by their peer credentials. Its Policy decides
+// what each one may see and do.
+package daemon
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotRunning is returned by a client when no daemon is listening
+	ErrNotRunning = errors.New("tunnel daemon is not running")
+
+	// ErrUnknownOp is returned for operations the daemon has no handler for
+	ErrUnknownOp = errors.New("unknown daemon operation")
+)
+
+// DefaultTimeout bounds a client call, long enough for a provider to come up
+const DefaultTimeout = 2 * time.Minute
+
+// request is what a client sends
+type request struct {
+	Op   string          `json:"op"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+// response is what the daemon answers
+type response struct {
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// HandlerFunc performs an operation. args holds the client's arguments as
+// JSON; the returned value is marshalled back to the client.
+type HandlerFunc func(ctx context.Context, args json.RawMessage) (any, error)
+
+// Server dispatches control requests to handlers
+type Server struct {
+	Logger *log.Logger
+
+	// Policy scopes each caller to what it may do. Nil trusts every
+	// caller, which suits a socket only the daemon's user can open.
+	Policy *Policy
+
+	mu       sync.RWMutex
+	handlers map[string]HandlerFunc
+	admin    map[string]bool
+	peerOf   func(net.Conn) (Peer, error)
+}
+
+// NewServer creates a server with no operations
+func NewServer() *Server {
+	return &Server{
+		handlers: make(map[string]HandlerFunc),
+		admin:    make(map[string]bool),
+		peerOf:   peerCredentials,
+	}
+}
+
+// Handle registers fn for op, replacing any earlier handler
+func (s *Server) Handle(op string, fn HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[op] = fn
+	delete(s.admin, op)
+}
+
+// HandleAdmin registers fn for an op only callers with ScopeAll may perform
+func (s *Server) HandleAdmin(op string, fn HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[op] = fn
+	s.admin[op] = true
+}
+
+// Listen opens the control socket at path. A socket left behind by a
+// daemon that is no longer running is replaced; a live one is not.
+func Listen(path string) (net.Listener, error) {
+	return listen(path, 0700, 0600)
+}
+
+// ListenShared opens a control socket every local user can connect to, for
+// a system-wide daemon whose Server has a Policy
+func ListenShared(path string) (net.Listener, error) {
+	return listen(path, 0755, 0666)
+}
+
+func listen(path string, dirMode, mode os.FileMode) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), dirMode); err != nil {
+		return nil, fmt.Errorf("create socket directory: %w", err)
+	}
+	if _, err := os.Stat(path); err == nil {
+		if NewClient(path).Running() {
+			return nil, fmt.Errorf("a daemon is already listening on %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("remove stale socket: %w", err)
+		}
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("listen on %s: %w", path, err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("set socket permissions: %w", err)
+	}
+	return ln, nil
+}
+
+// Serve answers requests on ln until ctx is done, then closes ln
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("accept control connection: %w", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serveConn(ctx, conn)
+		}()
+	}
+}
+
+// serveConn answers the single request on conn
+func (s *Server) serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	var req request
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&req); err != nil {
+		// Clients checking whether the daemon is up connect and hang up
+		if !errors.Is(err, io.EOF) {
+			s.logf("daemon: bad request: %v", err)
+		}
+		return
+	}
+
+	var resp response
+	result, err := s.dispatch(ctx, conn, req)
+	if err == nil {
+		resp.Result, err = json.Marshal(result)
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		s.logf("daemon: reply to %s: %v", req.Op, err)
+	}
+}
+
+func (s *Server) dispatch(ctx context.Context, conn net.Conn, req request) (any, error) {
+	s.mu.RLock()
+	fn, ok := s.handlers[req.Op]
+	admin := s.admin[req.Op]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownOp, req.Op)
+	}
+
+	if s.Policy != nil {
+		caller, err := s.authorize(conn, req.Op, admin)
+		if err != nil {
+			s.logf("daemon: %s refused: %v", req.Op, err)
+			return nil, err
+		}
+		ctx = withCaller(ctx, caller)
+	}
+	return fn(ctx, req.Args)
+}
+
+// authorize identifies the caller on conn and checks its scope allows op
+func (s *Server) authorize(conn net.Conn, op string, admin bool) (Caller, error) {
+	peer, err := s.peerOf(conn)
+	if err != nil {
+		return Caller{}, fmt.Errorf("%w: can't identify caller: %v", ErrForbidden, err)
+	}
+	caller := Caller{Peer: peer, Scope: s.Policy.ScopeFor(peer)}
+	switch {
+	case caller.Scope == ScopeNone:
+		return caller, fmt.Errorf("%w: user %s may not use this daemon", ErrForbidden, peer.User)
+	case admin && caller.Scope != ScopeAll:
+		return caller, fmt.Errorf("%w: %s needs access to all instances", ErrForbidden, op)
+	}
+	return caller, nil
+}
+
+func (s *Server) logf(format string, args ...any) {
+	if s.Logger != nil {
+		s.Logger.Printf(format, args...)
+	}
+}
+
+// Client calls a daemon over its control socket
+type Client struct {
+	path    string
+	Timeout time.Duration
+}
+
+// NewClient creates a client for the socket at path
+func NewClient(path string) *Client {
+	return &Client{path: path, Timeout: DefaultTimeout}
+}
+
+// Running reports whether a daemon is accepting connections
+func (c *Client) Running() bool {
+	conn, err := net.DialTimeout("unix", c.path, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// Call performs op with args, decoding the daemon's answer into result
+// when it is non-nil. Errors from the handler are returned as they were
+// reported by the daemon.
+func (c *Client) Call(op string, args, result any) error {
+	conn, err := net.DialTimeout("unix", c.path, time.Second)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNotRunning, err)
+	}
+	defer conn.Close()
+	if c.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(c.Timeout))
+	}
+
+	req := request{Op: op}
+	if args != nil {
+		if req.Args, err = json.Marshal(args); err != nil {
+			return fmt.Errorf("encode %s arguments: %w", op, err)
+		}
+	}
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return fmt.Errorf("send %s request: %w", op, err)
+	}
+
+	var resp response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return fmt.Errorf("read %s response: %w", op, err)
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	if result != nil && len(resp.Result) > 0 {
+		if err := json.Unmarshal(resp.Result, result); err != nil {
+			return fmt.Errorf("decode %s response: %w", op, err)
+		}
+	}
+	return nil
+}
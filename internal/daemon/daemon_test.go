@@ -0,0 +1,155 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// startServer serves s on a socket in a temporary directory
+func startServer(t *testing.T, s *Server) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "daemon.sock")
+	ln, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(ctx, ln) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+	})
+	return path
+}
+
+func TestCall(t *testing.T) {
+	s := NewServer()
+	s.Handle("echo", func(ctx context.Context, args json.RawMessage) (any, error) {
+		var name string
+		if err := json.Unmarshal(args, &name); err != nil {
+			return nil, err
+		}
+		return map[string]string{"hello": name}, nil
+	})
+	s.Handle("fail", func(ctx context.Context, args json.RawMessage) (any, error) {
+		return nil, errors.New("provider bore not found")
+	})
+	path := startServer(t, s)
+
+	c := NewClient(path)
+	if !c.Running() {
+		t.Fatal("expected the daemon to be running")
+	}
+
+	var got map[string]string
+	if err := c.Call("echo", "bore", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["hello"] != "bore" {
+		t.Errorf("got %v", got)
+	}
+
+	if err := c.Call("fail", nil, nil); err == nil || err.Error() != "provider bore not found" {
+		t.Errorf("handler error not passed through: %v", err)
+	}
+	if err := c.Call("reboot", nil, nil); err == nil || !strings.Contains(err.Error(), "unknown daemon operation") {
+		t.Errorf("unknown op: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("socket permissions %o, want 600", perm)
+	}
+}
+
+func TestClientWithoutDaemon(t *testing.T) {
+	c := NewClient(filepath.Join(t.TempDir(), "daemon.sock"))
+	if c.Running() {
+		t.Error("expected no daemon")
+	}
+	if err := c.Call("status", nil, nil); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("got %v, want ErrNotRunning", err)
+	}
+}
+
+func TestListenReplacesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "daemon.sock")
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := Listen(path)
+	if err != nil {
+		t.Fatalf("stale socket not replaced: %v", err)
+	}
+	defer ln.Close()
+
+	if _, err := Listen(path); err == nil {
+		t.Error("a live socket was replaced")
+	}
+}
+
+func TestPolicyScopesCallers(t *testing.T) {
+	peer := Peer{UID: 1001, User: "alice", Groups: []string{"dev"}}
+	s := NewServer()
+	s.Policy = &Policy{Groups: map[string]Scope{"contractors": ScopeNone}}
+	s.peerOf = func(net.Conn) (Peer, error) { return peer, nil }
+
+	s.Handle("whoami", func(ctx context.Context, args json.RawMessage) (any, error) {
+		return CallerFrom(ctx), nil
+	})
+	s.HandleAdmin("shutdown", func(ctx context.Context, args json.RawMessage) (any, error) {
+		return nil, nil
+	})
+	c := NewClient(startServer(t, s))
+
+	var caller Caller
+	if err := c.Call("whoami", nil, &caller); err != nil {
+		t.Fatal(err)
+	}
+	if caller.Peer.User != "alice" || caller.Scope != ScopeOwn {
+		t.Errorf("caller = %+v, want alice with her own instances", caller)
+	}
+	if err := c.Call("shutdown", nil, nil); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("shutdown by a user without all instances: %v", err)
+	}
+
+	peer = Peer{UID: 1002, User: "mallory", Groups: []string{"contractors"}}
+	if err := c.Call("whoami", nil, nil); err == nil || !strings.Contains(err.Error(), "may not use this daemon") {
+		t.Errorf("call by a user with no access: %v", err)
+	}
+
+	peer = Peer{UID: 0, User: "root"}
+	if err := c.Call("shutdown", nil, nil); err != nil {
+		t.Errorf("shutdown by root: %v", err)
+	}
+}
+
+func TestListenShared(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run", "daemon.sock")
+	ln, err := ListenShared(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0666 {
+		t.Errorf("socket permissions %o, want 666", perm)
+	}
+}
@@ -0,0 +1,129 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Health answers the liveness and readiness probes of load balancers and
+// uptime monitors over plain HTTP, on a listener of its own: /healthz says
+// the process is up, /readyz that enough tunnels are established to send
+// it traffic. Both answer 200 when the check passes and 503 when it doesn't.
+type Health struct {
+	// MinTunnels is how many tunnels must be established for the daemon to
+	// be ready; 0 makes it ready as soon as it serves
+	MinTunnels int
+
+	// Tunnels lists the tunnels established now
+	Tunnels func() []string
+
+	started  time.Time
+	stopping atomic.Bool
+}
+
+// HealthStatus is the body of a probe's answer
+type HealthStatus struct {
+	Status     string   `json:"status"` // ok, not ready or stopping
+	PID        int      `json:"pid"`
+	Uptime     string   `json:"uptime"`
+	Tunnels    []string `json:"tunnels,omitempty"`
+	MinTunnels int      `json:"min_tunnels"`
+	Reason     string   `json:"reason,omitempty"`
+}
+
+// NewHealth creates probes that need minTunnels of those tunnels lists
+func NewHealth(minTunnels int, tunnels func() []string) *Health {
+	return &Health{MinTunnels: minTunnels, Tunnels: tunnels, started: time.Now()}
+}
+
+// Handler serves /healthz and /readyz
+func (h *Health) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", h.probe(h.live))
+	mux.HandleFunc("/readyz", h.probe(h.ready))
+	return mux
+}
+
+// Serve answers probes on ln until ctx is done. From then on both probes
+// fail, so load balancers stop sending traffic before the tunnels close.
+func (h *Health) Serve(ctx context.Context, ln net.Listener) error {
+	srv := &http.Server{Handler: h.Handler(), ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		<-ctx.Done()
+		h.stopping.Store(true)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("serve health probes: %w", err)
+	}
+	return nil
+}
+
+// probe answers GET and HEAD requests with check's status
+func (h *Health) probe(check func() (HealthStatus, bool)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		status, ok := check()
+		code := http.StatusOK
+		if !ok {
+			code = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		if r.Method == http.MethodGet {
+			json.NewEncoder(w).Encode(status)
+		}
+	}
+}
+
+// live reports whether the process is up and not shutting down
+func (h *Health) live() (HealthStatus, bool) {
+	status := h.status()
+	if h.stopping.Load() {
+		status.Status = "stopping"
+		return status, false
+	}
+	return status, true
+}
+
+// ready reports whether at least MinTunnels tunnels are established
+func (h *Health) ready() (HealthStatus, bool) {
+	status, ok := h.live()
+	if !ok {
+		return status, false
+	}
+	if h.Tunnels != nil {
+		status.Tunnels = h.Tunnels()
+	}
+	if n := len(status.Tunnels); n < h.MinTunnels {
+		status.Status = "not ready"
+		status.Reason = fmt.Sprintf("%d of %d tunnels established", n, h.MinTunnels)
+		return status, false
+	}
+	return status, true
+}
+
+func (h *Health) status() HealthStatus {
+	return HealthStatus{
+		Status:     "ok",
+		PID:        os.Getpid(),
+		Uptime:     time.Since(h.started).Round(time.Second).String(),
+		MinTunnels: h.MinTunnels,
+	}
+}
@@ -0,0 +1,80 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func probe(t *testing.T, h http.Handler, method, path string) (int, HealthStatus) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+	var status HealthStatus
+	if method == http.MethodGet && rec.Code != http.StatusMethodNotAllowed {
+		if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return rec.Code, status
+}
+
+func TestHealthReadiness(t *testing.T) {
+	var tunnels []string
+	h := NewHealth(2, func() []string { return tunnels })
+	handler := h.Handler()
+
+	if code, status := probe(t, handler, http.MethodGet, "/healthz"); code != http.StatusOK || status.Status != "ok" {
+		t.Errorf("healthz = %d %+v, want 200 ok", code, status)
+	}
+
+	tunnels = []string{"bore"}
+	code, status := probe(t, handler, http.MethodGet, "/readyz")
+	if code != http.StatusServiceUnavailable || status.Status != "not ready" || status.Reason != "1 of 2 tunnels established" {
+		t.Errorf("readyz with one tunnel = %d %+v", code, status)
+	}
+
+	tunnels = []string{"bore", "ngrok"}
+	if code, status := probe(t, handler, http.MethodGet, "/readyz"); code != http.StatusOK || len(status.Tunnels) != 2 {
+		t.Errorf("readyz with two tunnels = %d %+v", code, status)
+	}
+	if code, _ := probe(t, handler, http.MethodHead, "/readyz"); code != http.StatusOK {
+		t.Errorf("HEAD readyz = %d", code)
+	}
+	if code, _ := probe(t, handler, http.MethodPost, "/readyz"); code != http.StatusMethodNotAllowed {
+		t.Errorf("POST readyz = %d", code)
+	}
+}
+
+func TestHealthStopping(t *testing.T) {
+	h := NewHealth(0, nil)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- h.Serve(ctx, ln) }()
+
+	resp, err := http.Get("http://" + ln.Addr().String() + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("readyz without a minimum = %d, want 200", resp.StatusCode)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Serve: %v", err)
+	}
+	for _, path := range []string{"/healthz", "/readyz"} {
+		if code, status := probe(t, h.Handler(), http.MethodGet, path); code != http.StatusServiceUnavailable || status.Status != "stopping" {
+			t.Errorf("%s while stopping = %d %+v", path, code, status)
+		}
+	}
+}
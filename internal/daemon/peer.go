@@ -0,0 +1,41 @@
+package daemon
+
+import (
+	"errors"
+	"os/user"
+	"strconv"
+)
+
+// errPeerUnsupported is returned where the OS can't say who is at the other
+// end of a unix socket
+var errPeerUnsupported = errors.New("peer credentials are not supported on this platform")
+
+// Peer identifies the process at the other end of a control connection
+type Peer struct {
+	PID    int      `json:"pid"`
+	UID    int      `json:"uid"`
+	GID    int      `json:"gid"`
+	User   string   `json:"user,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// lookupNames fills in the names of the peer's user and its groups. A uid
+// without an account keeps its number as its name.
+func (p *Peer) lookupNames() {
+	p.User = strconv.Itoa(p.UID)
+	u, err := user.LookupId(p.User)
+	if err != nil {
+		return
+	}
+	p.User = u.Username
+
+	gids, err := u.GroupIds()
+	if err != nil {
+		return
+	}
+	for _, gid := range gids {
+		if g, err := user.LookupGroupId(gid); err == nil {
+			p.Groups = append(p.Groups, g.Name)
+		}
+	}
+}
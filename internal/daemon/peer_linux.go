@@ -0,0 +1,41 @@
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// SupportsPeerCredentials reports whether the daemon can tell which user
+// each client runs as, which a multi-user daemon depends on
+func SupportsPeerCredentials() bool {
+	return true
+}
+
+// peerCredentials reads the client's pid, uid and gid from the kernel with
+// SO_PEERCRED; they can't be forged by the client
+func peerCredentials(conn net.Conn) (Peer, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return Peer{}, fmt.Errorf("peer credentials need a unix socket, not %T", conn)
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return Peer{}, err
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return Peer{}, err
+	}
+	if credErr != nil {
+		return Peer{}, fmt.Errorf("read peer credentials: %w", credErr)
+	}
+
+	peer := Peer{PID: int(cred.Pid), UID: int(cred.Uid), GID: int(cred.Gid)}
+	peer.lookupNames()
+	return peer, nil
+}
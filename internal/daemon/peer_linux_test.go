@@ -0,0 +1,36 @@
+package daemon
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPeerCredentials(t *testing.T) {
+	ln, err := Listen(filepath.Join(t.TempDir(), "daemon.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		if conn, err := net.Dial("unix", ln.Addr().String()); err == nil {
+			defer conn.Close()
+			conn.Read(make([]byte, 1))
+		}
+	}()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	peer, err := peerCredentials(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peer.UID != os.Getuid() || peer.PID != os.Getpid() || peer.User == "" {
+		t.Errorf("peer = %+v, want this process", peer)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package daemon
+
+import "net"
+
+// SupportsPeerCredentials reports whether the daemon can tell which user
+// each client runs as, which a multi-user daemon depends on
+func SupportsPeerCredentials() bool {
+	return false
+}
+
+func peerCredentials(conn net.Conn) (Peer, error) {
+	return Peer{}, errPeerUnsupported
+}
@@ -0,0 +1,91 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrForbidden is returned for requests the caller's scope doesn't allow
+var ErrForbidden = errors.New("permission denied")
+
+// Scope is how much of a multi-user daemon a user may see and control
+type Scope string
+
+const (
+	ScopeNone Scope = "none" // may not use the daemon
+	ScopeOwn  Scope = "own"  // the instances the user started
+	ScopeAll  Scope = "all"  // every instance, and the daemon itself
+)
+
+// rank orders scopes from least to most access
+var rank = map[Scope]int{ScopeNone: 0, ScopeOwn: 1, ScopeAll: 2}
+
+// ParseScope checks that s names a scope
+func ParseScope(s string) (Scope, error) {
+	if _, ok := rank[Scope(s)]; !ok {
+		return "", fmt.Errorf("invalid scope %q: expected none, own or all", s)
+	}
+	return Scope(s), nil
+}
+
+// Policy assigns scopes to the users of a multi-user daemon
+type Policy struct {
+	Default Scope            // for users matched by nothing else; empty is own
+	Users   map[string]Scope // by user name
+	Groups  map[string]Scope // by group name
+}
+
+// ScopeFor returns the scope of peer. root always has every instance; a
+// user's own entry wins over its groups, and among groups the widest
+// scope wins.
+func (p *Policy) ScopeFor(peer Peer) Scope {
+	if peer.UID == 0 {
+		return ScopeAll
+	}
+	if scope, ok := p.Users[peer.User]; ok {
+		return scope
+	}
+
+	best, matched := ScopeNone, false
+	for _, group := range peer.Groups {
+		if scope, ok := p.Groups[group]; ok && (!matched || rank[scope] > rank[best]) {
+			best, matched = scope, true
+		}
+	}
+	if matched {
+		return best
+	}
+	if p.Default == "" {
+		return ScopeOwn
+	}
+	return p.Default
+}
+
+// Caller is who made a request and what they may do
+type Caller struct {
+	Peer  Peer
+	Scope Scope
+}
+
+// CanSee reports whether the caller may see and control an instance
+// started by the user with ownerUID
+func (c Caller) CanSee(ownerUID int) bool {
+	return c.Scope == ScopeAll || c.Peer.UID == ownerUID
+}
+
+type callerKey struct{}
+
+// CallerFrom returns the caller of the request ctx belongs to. Without a
+// policy every caller may do anything, as on a daemon only its user can
+// reach.
+func CallerFrom(ctx context.Context) Caller {
+	if c, ok := ctx.Value(callerKey{}).(Caller); ok {
+		return c
+	}
+	return Caller{Scope: ScopeAll}
+}
+
+func withCaller(ctx context.Context, c Caller) context.Context {
+	return context.WithValue(ctx, callerKey{}, c)
+}
@@ -0,0 +1,42 @@
+package daemon
+
+import "testing"
+
+func TestScopeFor(t *testing.T) {
+	policy := &Policy{
+		Default: ScopeNone,
+		Users:   map[string]Scope{"alice": ScopeAll, "bob": ScopeOwn},
+		Groups:  map[string]Scope{"ops": ScopeAll, "dev": ScopeOwn, "interns": ScopeNone},
+	}
+
+	tests := []struct {
+		name string
+		peer Peer
+		want Scope
+	}{
+		{"root", Peer{UID: 0, User: "root"}, ScopeAll},
+		{"user entry", Peer{UID: 1000, User: "alice"}, ScopeAll},
+		{"user entry wins over groups", Peer{UID: 1001, User: "bob", Groups: []string{"ops"}}, ScopeOwn},
+		{"widest group", Peer{UID: 1002, User: "carol", Groups: []string{"interns", "dev"}}, ScopeOwn},
+		{"default", Peer{UID: 1003, User: "dave", Groups: []string{"users"}}, ScopeNone},
+	}
+	for _, tt := range tests {
+		if got := policy.ScopeFor(tt.peer); got != tt.want {
+			t.Errorf("%s: ScopeFor = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	if got := (&Policy{}).ScopeFor(Peer{UID: 1000, User: "alice"}); got != ScopeOwn {
+		t.Errorf("empty policy: ScopeFor = %s, want own", got)
+	}
+}
+
+func TestCallerCanSee(t *testing.T) {
+	own := Caller{Peer: Peer{UID: 1000}, Scope: ScopeOwn}
+	if !own.CanSee(1000) || own.CanSee(1001) {
+		t.Error("own scope should see only the caller's instances")
+	}
+	if all := (Caller{Peer: Peer{UID: 1000}, Scope: ScopeAll}); !all.CanSee(1001) {
+		t.Error("all scope should see every instance")
+	}
+}
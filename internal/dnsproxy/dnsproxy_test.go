@@ -0,0 +1,205 @@
+package dnsproxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jedarden/tunnel/internal/providers"
+)
+
+// startEchoResolver answers every UDP and TCP message with "re:" + message
+func startEchoResolver(t *testing.T) string {
+	t.Helper()
+
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen udp: %v", err)
+	}
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("listen tcp: %v", err)
+	}
+	t.Cleanup(func() {
+		udp.Close()
+		tcp.Close()
+	})
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := udp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			udp.WriteTo(append([]byte("re:"), buf[:n]...), addr)
+		}
+	}()
+	go func() {
+		for {
+			conn, err := tcp.Accept()
+			if err != nil {
+				return
+			}
+			msg, err := readTCPMessage(conn)
+			if err == nil {
+				writeTCPMessage(conn, append([]byte("re:"), msg...))
+			}
+			conn.Close()
+		}
+	}()
+
+	return udp.LocalAddr().String()
+}
+
+func TestForwarder(t *testing.T) {
+	upstream := startEchoResolver(t)
+
+	f := NewForwarder("127.0.0.1:0")
+	// An unreachable resolver first, to check the fallback to the next one
+	f.SetUpstreams([]string{"127.0.0.1:1", upstream})
+	if err := f.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer f.Close()
+
+	udp, err := net.Dial("udp", f.Addr())
+	if err != nil {
+		t.Fatalf("dial udp: %v", err)
+	}
+	defer udp.Close()
+	udp.Write([]byte("query"))
+	buf := make([]byte, 512)
+	n, err := udp.Read(buf)
+	if err != nil || string(buf[:n]) != "re:query" {
+		t.Fatalf("udp reply = %q, %v", buf[:n], err)
+	}
+
+	tcp, err := net.Dial("tcp", f.Addr())
+	if err != nil {
+		t.Fatalf("dial tcp: %v", err)
+	}
+	defer tcp.Close()
+	writeTCPMessage(tcp, []byte("query"))
+	reply, err := readTCPMessage(tcp)
+	if err != nil || string(reply) != "re:query" {
+		t.Fatalf("tcp reply = %q, %v", reply, err)
+	}
+}
+
+func TestResolvConfApplyRestore(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "stub-resolv.conf")
+	original := "nameserver 127.0.0.53\noptions edns0 trust-ad\nsearch corp.example.com\n"
+	if err := os.WriteFile(target, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// resolv.conf is commonly a symlink; it must come back as one
+	path := filepath.Join(dir, "resolv.conf")
+	if err := os.Symlink(target, path); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewResolvConf(path)
+	if err := r.Apply("127.0.0.1:53", "DNS over tailscale"); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if !r.Managed() {
+		t.Error("expected resolv.conf to be managed after Apply")
+	}
+
+	data, _ := os.ReadFile(path)
+	content := string(data)
+	for _, want := range []string{"nameserver 127.0.0.1\n", "search corp.example.com", "options edns0 trust-ad"} {
+		if !strings.Contains(content, want) {
+			t.Errorf("managed resolv.conf missing %q:\n%s", want, content)
+		}
+	}
+	if strings.Contains(content, "127.0.0.53") {
+		t.Errorf("managed resolv.conf kept the original nameserver:\n%s", content)
+	}
+
+	// Applying again must not overwrite the saved original
+	if err := r.Apply("127.0.0.1:53", "DNS over wireguard"); err != nil {
+		t.Fatalf("second Apply() error = %v", err)
+	}
+
+	if err := r.Restore(); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if link, err := os.Readlink(path); err != nil || link != target {
+		t.Errorf("expected symlink to %s after restore, got %q, %v", target, link, err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != original {
+		t.Errorf("restored content = %q, want %q", data, original)
+	}
+	if r.Managed() {
+		t.Error("expected resolv.conf not to be managed after Restore")
+	}
+}
+
+// dnsVPN is a VPN provider stub; only the methods the manager calls are implemented
+type dnsVPN struct {
+	providers.Provider
+	name      string
+	connected bool
+	servers   []string
+}
+
+func (p *dnsVPN) Name() string                 { return p.name }
+func (p *dnsVPN) Category() providers.Category { return providers.CategoryVPN }
+func (p *dnsVPN) IsConnected() bool            { return p.connected }
+func (p *dnsVPN) DNSServers() []string         { return p.servers }
+
+func TestManagerFollowsConnectedVPN(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "resolv.conf")
+	os.WriteFile(path, []byte("nameserver 192.168.1.1\n"), 0644)
+
+	tailscale := &dnsVPN{name: "tailscale", servers: []string{"100.100.100.100"}}
+	wireguard := &dnsVPN{name: "wireguard", servers: []string{"10.8.0.1"}}
+	list := func() []providers.Provider { return []providers.Provider{wireguard, tailscale} }
+
+	m := NewManager("127.0.0.1:0", NewResolvConf(path), list, log.New(io.Discard, "", 0))
+	defer m.Close(context.Background())
+
+	m.Sync()
+	if m.Active() != "" {
+		t.Fatalf("expected no forwarding without a connected VPN, got %s", m.Active())
+	}
+
+	wireguard.connected = true
+	tailscale.connected = true
+	m.Sync()
+	if m.Active() != "tailscale" {
+		t.Fatalf("expected the first VPN by name, got %q", m.Active())
+	}
+
+	// Stays on the active provider while it remains connected
+	m.Sync()
+	if m.Active() != "tailscale" {
+		t.Errorf("expected to stay on tailscale, got %q", m.Active())
+	}
+
+	tailscale.connected = false
+	m.Sync()
+	if m.Active() != "wireguard" {
+		t.Errorf("expected to switch to wireguard, got %q", m.Active())
+	}
+
+	wireguard.connected = false
+	m.Sync()
+	if m.Active() != "" {
+		t.Errorf("expected forwarding to stop, got %q", m.Active())
+	}
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, []byte("nameserver 192.168.1.1\n")) {
+		t.Errorf("expected original resolv.conf restored, got %q", data)
+	}
+}
@@ -0,0 +1,238 @@
+// Package dnsproxy runs a local DNS forwarder that sends queries to the
+// resolvers of an active VPN, and points the system resolver at it.
+package dnsproxy
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// queryTimeout bounds each attempt against an upstream resolver
+const queryTimeout = 3 * time.Second
+
+// maxMessageSize is the largest DNS message carried over UDP or TCP
+const maxMessageSize = 65535
+
+// Forwarder relays raw DNS messages between local clients and upstream
+// resolvers over UDP and TCP. Messages are not parsed; each query is tried
+// against the upstreams in order until one answers.
+type Forwarder struct {
+	listen string
+
+	mu        sync.RWMutex
+	upstreams []string
+
+	udp    net.PacketConn
+	tcp    net.Listener
+	wg     sync.WaitGroup
+	closed chan struct{}
+}
+
+// NewForwarder creates a forwarder that will listen on addr, e.g. "127.0.0.1:53"
+func NewForwarder(addr string) *Forwarder {
+	return &Forwarder{listen: addr}
+}
+
+// SetUpstreams replaces the resolvers queries are sent to. Addresses without
+// a port use 53.
+func (f *Forwarder) SetUpstreams(servers []string) {
+	upstreams := make([]string, 0, len(servers))
+	for _, s := range servers {
+		if _, _, err := net.SplitHostPort(s); err != nil {
+			s = net.JoinHostPort(s, "53")
+		}
+		upstreams = append(upstreams, s)
+	}
+
+	f.mu.Lock()
+	f.upstreams = upstreams
+	f.mu.Unlock()
+}
+
+// Upstreams returns the resolvers queries are currently sent to
+func (f *Forwarder) Upstreams() []string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return append([]string(nil), f.upstreams...)
+}
+
+// Start begins serving on the listen address
+func (f *Forwarder) Start() error {
+	udp, err := net.ListenPacket("udp", f.listen)
+	if err != nil {
+		return fmt.Errorf("listen udp %s: %w", f.listen, err)
+	}
+
+	// Bind TCP to the port UDP actually got, in case the listen port was 0
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	if err != nil {
+		udp.Close()
+		return fmt.Errorf("listen tcp %s: %w", f.listen, err)
+	}
+
+	f.udp = udp
+	f.tcp = tcp
+	f.closed = make(chan struct{})
+
+	f.wg.Add(2)
+	go f.serveUDP()
+	go f.serveTCP()
+	return nil
+}
+
+// Addr returns the address the forwarder is listening on
+func (f *Forwarder) Addr() string {
+	if f.udp == nil {
+		return f.listen
+	}
+	return f.udp.LocalAddr().String()
+}
+
+// Close stops the forwarder and waits for its listeners to exit
+func (f *Forwarder) Close() error {
+	if f.udp == nil {
+		return nil
+	}
+
+	close(f.closed)
+	err := errors.Join(f.udp.Close(), f.tcp.Close())
+	f.wg.Wait()
+	f.udp, f.tcp = nil, nil
+	return err
+}
+
+func (f *Forwarder) serveUDP() {
+	defer f.wg.Done()
+
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, client, err := f.udp.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-f.closed:
+				return
+			default:
+				continue
+			}
+		}
+
+		query := append([]byte(nil), buf[:n]...)
+		go func() {
+			if reply, err := f.exchange("udp", query); err == nil {
+				f.udp.WriteTo(reply, client)
+			}
+		}()
+	}
+}
+
+func (f *Forwarder) serveTCP() {
+	defer f.wg.Done()
+
+	for {
+		conn, err := f.tcp.Accept()
+		if err != nil {
+			select {
+			case <-f.closed:
+				return
+			default:
+				continue
+			}
+		}
+		go f.handleTCP(conn)
+	}
+}
+
+// handleTCP answers length-prefixed queries until the client hangs up
+func (f *Forwarder) handleTCP(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		query, err := readTCPMessage(conn)
+		if err != nil {
+			return
+		}
+
+		reply, err := f.exchange("tcp", query)
+		if err != nil {
+			return
+		}
+		if err := writeTCPMessage(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// exchange sends query to each upstream in turn and returns the first answer
+func (f *Forwarder) exchange(network string, query []byte) ([]byte, error) {
+	upstreams := f.Upstreams()
+	if len(upstreams) == 0 {
+		return nil, fmt.Errorf("no upstream resolvers")
+	}
+
+	var lastErr error
+	for _, upstream := range upstreams {
+		reply, err := exchangeWith(network, upstream, query)
+		if err == nil {
+			return reply, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func exchangeWith(network, upstream string, query []byte) ([]byte, error) {
+	conn, err := net.DialTimeout(network, upstream, queryTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(queryTimeout))
+
+	if network == "tcp" {
+		if err := writeTCPMessage(conn, query); err != nil {
+			return nil, err
+		}
+		return readTCPMessage(conn)
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, maxMessageSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// readTCPMessage reads one DNS message with its two-byte length prefix
+func readTCPMessage(r io.Reader) ([]byte, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, length)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// writeTCPMessage writes one DNS message with its two-byte length prefix
+func writeTCPMessage(w io.Writer, msg []byte) error {
+	if len(msg) > maxMessageSize {
+		return fmt.Errorf("dns message too large: %d bytes", len(msg))
+	}
+	buf := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	copy(buf[2:], msg)
+	_, err := w.Write(buf)
+	return err
+}
@@ -0,0 +1,156 @@
+package dnsproxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/providers"
+)
+
+// Manager runs the forwarder while a VPN provider with its own resolvers is
+// connected, whoever connected it, and restores resolv.conf once none is.
+type Manager struct {
+	listen    string
+	resolv    *ResolvConf // nil leaves resolv.conf alone
+	providers func() []providers.Provider
+	logger    *log.Logger
+
+	mu        sync.Mutex
+	forwarder *Forwarder
+	active    string // provider whose resolvers are in use
+}
+
+// NewManager creates a manager. list returns the providers to consider;
+// resolv may be nil to run the forwarder without touching resolv.conf.
+func NewManager(listen string, resolv *ResolvConf, list func() []providers.Provider, logger *log.Logger) *Manager {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return &Manager{
+		listen:    listen,
+		resolv:    resolv,
+		providers: list,
+		logger:    logger,
+	}
+}
+
+// Run re-checks the connected providers every interval until ctx is done,
+// then stops the forwarder and restores resolv.conf
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	m.Sync()
+	for {
+		select {
+		case <-ctx.Done():
+			m.Close(context.Background())
+			return
+		case <-ticker.C:
+			m.Sync()
+		}
+	}
+}
+
+// Active returns the provider whose resolvers are in use, or ""
+func (m *Manager) Active() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.active
+}
+
+// Sync points the forwarder at a connected VPN provider with resolvers, or
+// shuts it down if there is none. The provider in use is kept while it stays
+// connected; otherwise the first by name is chosen.
+func (m *Manager) Sync() {
+	candidates := make(map[string][]string)
+	for _, p := range m.providers() {
+		if p.Category() != providers.CategoryVPN {
+			continue
+		}
+		if s := providers.DNSServers(p); len(s) > 0 && p.IsConnected() {
+			candidates[p.Name()] = s
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name := m.active
+	if _, ok := candidates[name]; !ok {
+		names := make([]string, 0, len(candidates))
+		for n := range candidates {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		name = ""
+		if len(names) > 0 {
+			name = names[0]
+		}
+	}
+	servers := candidates[name]
+
+	if name == "" {
+		if err := m.deactivate(); err != nil {
+			m.logger.Printf("DNS over tunnel: %v", err)
+		}
+		return
+	}
+	if err := m.activate(name, servers); err != nil {
+		m.logger.Printf("DNS over tunnel: %v", err)
+	}
+}
+
+// activate starts the forwarder if needed and points it at servers
+func (m *Manager) activate(name string, servers []string) error {
+	if m.forwarder == nil {
+		f := NewForwarder(m.listen)
+		if err := f.Start(); err != nil {
+			return fmt.Errorf("start forwarder: %w", err)
+		}
+		m.forwarder = f
+	}
+	m.forwarder.SetUpstreams(servers)
+
+	if m.active == name {
+		return nil
+	}
+
+	if m.resolv != nil {
+		if err := m.resolv.Apply(m.forwarder.Addr(), fmt.Sprintf("DNS over %s", name)); err != nil {
+			return err
+		}
+	}
+	m.logger.Printf("DNS over tunnel: forwarding %s to %s resolvers %v", m.forwarder.Addr(), name, servers)
+	m.active = name
+	return nil
+}
+
+// deactivate restores resolv.conf and stops the forwarder. A resolv.conf left
+// managed by an earlier run that did not exit cleanly is restored too.
+func (m *Manager) deactivate() error {
+	var err error
+	if m.resolv != nil && m.resolv.Managed() {
+		err = m.resolv.Restore()
+	}
+	if m.forwarder != nil {
+		m.forwarder.Close()
+		m.forwarder = nil
+	}
+	if m.active != "" {
+		m.logger.Printf("DNS over tunnel: %s disconnected, restored system resolvers", m.active)
+		m.active = ""
+	}
+	return err
+}
+
+// Close stops forwarding and restores resolv.conf
+func (m *Manager) Close(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.deactivate()
+}
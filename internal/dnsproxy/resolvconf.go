@@ -0,0 +1,115 @@
+package dnsproxy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// DefaultResolvConf is the system resolver configuration on Unix systems
+const DefaultResolvConf = "/etc/resolv.conf"
+
+// backupSuffix names the saved copy of the original resolv.conf
+const backupSuffix = ".tunnel-backup"
+
+// ResolvConf points a resolv.conf at the forwarder and puts the original
+// back afterwards. The original is moved aside rather than copied, so a
+// symlink (as used by systemd-resolved) is restored as a symlink.
+type ResolvConf struct {
+	Path string
+}
+
+// NewResolvConf manages the resolv.conf at path
+func NewResolvConf(path string) *ResolvConf {
+	if path == "" {
+		path = DefaultResolvConf
+	}
+	return &ResolvConf{Path: path}
+}
+
+// BackupPath returns where the original resolv.conf is kept while managed
+func (r *ResolvConf) BackupPath() string {
+	return r.Path + backupSuffix
+}
+
+// Managed reports whether the original resolv.conf is currently saved aside,
+// including when a previous run exited without restoring it
+func (r *ResolvConf) Managed() bool {
+	_, err := os.Lstat(r.BackupPath())
+	return err == nil
+}
+
+// Apply replaces resolv.conf with one using nameserver, keeping the search
+// domains and options of the original. note is written as a comment.
+func (r *ResolvConf) Apply(nameserver, note string) error {
+	if !r.Managed() {
+		if err := os.Rename(r.Path, r.BackupPath()); err != nil {
+			return fmt.Errorf("back up %s: %w", r.Path, err)
+		}
+	}
+
+	// Read through the backup; a symlink target is still there to follow
+	original, err := os.ReadFile(r.BackupPath())
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("read %s: %w", r.BackupPath(), err)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# Generated by tunnel: %s\n", note)
+	fmt.Fprintf(&b, "# The original is saved as %s and restored on disconnect.\n", r.BackupPath())
+	fmt.Fprintf(&b, "nameserver %s\n", nameserverHost(nameserver))
+	for _, line := range carriedLines(original) {
+		b.WriteString(line + "\n")
+	}
+
+	tmp := r.Path + ".tunnel-tmp"
+	if err := os.WriteFile(tmp, b.Bytes(), 0644); err != nil {
+		return fmt.Errorf("write %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, r.Path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("replace %s: %w", r.Path, err)
+	}
+	return nil
+}
+
+// Restore puts the original resolv.conf back. It is a no-op when resolv.conf
+// is not managed.
+func (r *ResolvConf) Restore() error {
+	if !r.Managed() {
+		return nil
+	}
+	if err := os.Rename(r.BackupPath(), r.Path); err != nil {
+		return fmt.Errorf("restore %s: %w", r.Path, err)
+	}
+	return nil
+}
+
+// carriedLines returns the search, domain and options lines worth keeping
+func carriedLines(original []byte) []string {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(original))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "search", "domain", "options":
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// nameserverHost strips the port; resolv.conf always uses port 53
+func nameserverHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
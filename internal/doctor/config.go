@@ -0,0 +1,75 @@
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/jedarden/tunnel/pkg/config"
+)
+
+// Config checks that the config file at path exists, parses and is valid,
+// that other users can't change the commands it runs, and that its
+// directory is writable
+func Config(path string) Check {
+	return Check{Category: "config", Run: func(ctx context.Context) []Result {
+		const name = "Config file"
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			return []Result{warn(name, fmt.Sprintf("%s does not exist; using defaults", path), "Run 'tunnel config edit' to create it")}
+		}
+		if err != nil {
+			return []Result{fail(name, err.Error(), fmt.Sprintf("Check the permissions of %s", path))}
+		}
+
+		results := []Result{pass(name, fmt.Sprintf("Found at %s", path))}
+		if runtime.GOOS != "windows" && info.Mode().Perm()&0022 != 0 {
+			results = append(results, fail("Config permissions",
+				fmt.Sprintf("%s is writable by other users (mode %04o), who could change the commands tunnel runs", path, info.Mode().Perm()),
+				fmt.Sprintf("Run: chmod go-w %s", path)))
+		}
+
+		cfg, err := config.LoadUnmigrated(path)
+		switch {
+		case err != nil:
+			results = append(results, fail("Config validity", err.Error(), fmt.Sprintf("Fix %s, then run 'tunnel doctor' again", path)))
+		default:
+			if err := cfg.Validate(); err != nil {
+				results = append(results, fail("Config validity", err.Error(), "Run 'tunnel config edit' to correct it"))
+			} else {
+				results = append(results, pass("Config validity", "The configuration is valid"))
+			}
+		}
+
+		return append(results, writableDir(filepath.Dir(path)))
+	}}
+}
+
+// writableDir checks that files can be created in dir
+func writableDir(dir string) Result {
+	const name = "Config directory"
+	f, err := os.CreateTemp(dir, ".doctor-*")
+	if err != nil {
+		return fail(name, fmt.Sprintf("Cannot write to %s: %v", dir, err), fmt.Sprintf("Check write permissions for %s", dir))
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return pass(name, fmt.Sprintf("%s is writable", dir))
+}
+
+// System reports the platform tunnel runs on
+func System() Check {
+	return Check{Category: "system", Run: func(ctx context.Context) []Result {
+		const name = "System requirements"
+		message := fmt.Sprintf("%s/%s, Go %s", runtime.GOOS, runtime.GOARCH, runtime.Version())
+		if _, err := os.Stat("/.dockerenv"); err == nil {
+			message += " (running in container)"
+		}
+		if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+			return []Result{warn(name, message, "TUNNEL is primarily tested on Linux and macOS")}
+		}
+		return []Result{pass(name, message)}
+	}}
+}
@@ -0,0 +1,155 @@
+// Package doctor diagnoses a TUNNEL installation. A diagnosis runs a list
+// of checks concurrently, each bounded by a timeout, and collects what they
+// find into a report: one result per thing checked, with a suggested fix
+// for anything that isn't right.
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Status is the outcome of one result
+type Status string
+
+const (
+	StatusPass Status = "pass"
+	StatusWarn Status = "warn" // works, but may not as expected
+	StatusFail Status = "fail"
+	StatusSkip Status = "skip" // not applicable here, e.g. a provider that isn't installed or used
+)
+
+// Result is what a check found about one thing
+type Result struct {
+	Category string `json:"category"`
+	Name     string `json:"name"`
+	Status   Status `json:"status"`
+	Message  string `json:"message"`
+	Fix      string `json:"fix,omitempty"`
+}
+
+// Check inspects one area of the installation. Run must return when ctx is
+// done; results without a category get the check's.
+type Check struct {
+	Category string
+	Run      func(ctx context.Context) []Result
+}
+
+// Summary counts results by status
+type Summary struct {
+	Pass int `json:"pass"`
+	Warn int `json:"warn"`
+	Fail int `json:"fail"`
+	Skip int `json:"skip"`
+}
+
+// Report is the outcome of a diagnosis
+type Report struct {
+	Results  []Result      `json:"results"`
+	Summary  Summary       `json:"summary"`
+	Healthy  bool          `json:"healthy"` // no result failed
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration"`
+}
+
+// DefaultTimeout bounds each check
+const DefaultTimeout = 10 * time.Second
+
+// Run runs checks concurrently and reports their results in the order the
+// checks were given. A check still running after timeout is reported as a
+// warning and left to finish on its own.
+func Run(ctx context.Context, checks []Check, timeout time.Duration) *Report {
+	report := &Report{Results: []Result{}, Started: time.Now()}
+	found := make([][]Result, len(checks))
+	done := make(chan struct{}, len(checks))
+
+	for i, check := range checks {
+		go func(i int, check Check) {
+			found[i] = runCheck(ctx, check, timeout)
+			done <- struct{}{}
+		}(i, check)
+	}
+	for range checks {
+		<-done
+	}
+
+	for i, results := range found {
+		for _, r := range results {
+			if r.Category == "" {
+				r.Category = checks[i].Category
+			}
+			report.add(r)
+		}
+	}
+	report.Healthy = report.Summary.Fail == 0
+	report.Duration = time.Since(report.Started)
+	return report
+}
+
+// runCheck runs check, giving up on it after timeout
+func runCheck(ctx context.Context, check Check, timeout time.Duration) []Result {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	results := make(chan []Result, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				results <- []Result{fail(check.Category, fmt.Sprintf("check panicked: %v", p), "")}
+			}
+		}()
+		results <- check.Run(ctx)
+	}()
+
+	select {
+	case r := <-results:
+		return r
+	case <-ctx.Done():
+		return []Result{warn(check.Category, fmt.Sprintf("did not finish within %s", timeout), "")}
+	}
+}
+
+func (r *Report) add(result Result) {
+	r.Results = append(r.Results, result)
+	switch result.Status {
+	case StatusPass:
+		r.Summary.Pass++
+	case StatusWarn:
+		r.Summary.Warn++
+	case StatusFail:
+		r.Summary.Fail++
+	case StatusSkip:
+		r.Summary.Skip++
+	}
+}
+
+// Categories returns the categories of the report's results in the order
+// they first appear
+func (r *Report) Categories() []string {
+	var categories []string
+	seen := make(map[string]bool)
+	for _, result := range r.Results {
+		if !seen[result.Category] {
+			seen[result.Category] = true
+			categories = append(categories, result.Category)
+		}
+	}
+	return categories
+}
+
+func pass(name, message string) Result {
+	return Result{Name: name, Status: StatusPass, Message: message}
+}
+
+func warn(name, message, fix string) Result {
+	return Result{Name: name, Status: StatusWarn, Message: message, Fix: fix}
+}
+
+func fail(name, message, fix string) Result {
+	return Result{Name: name, Status: StatusFail, Message: message, Fix: fix}
+}
+
+func skip(name, message string) Result {
+	return Result{Name: name, Status: StatusSkip, Message: message}
+}
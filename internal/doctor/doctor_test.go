@@ -0,0 +1,333 @@
+package doctor
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/providers"
+)
+
+// fakeProvider is a provider whose installation and endpoints are set by
+// the test
+type fakeProvider struct {
+	*providers.BaseProvider
+	installed bool
+	endpoints []string
+	invalid   error
+}
+
+func newFake(name string, installed bool) *fakeProvider {
+	return &fakeProvider{BaseProvider: providers.NewBaseProvider(name, providers.CategoryTunnel), installed: installed}
+}
+
+func (f *fakeProvider) Install() error      { return nil }
+func (f *fakeProvider) Uninstall() error    { return nil }
+func (f *fakeProvider) IsInstalled() bool   { return f.installed }
+func (f *fakeProvider) Connect() error      { return nil }
+func (f *fakeProvider) Disconnect() error   { return nil }
+func (f *fakeProvider) IsConnected() bool   { return false }
+func (f *fakeProvider) Endpoints() []string { return f.endpoints }
+func (f *fakeProvider) GetConnectionInfo() (*providers.ConnectionInfo, error) {
+	return &providers.ConnectionInfo{}, nil
+}
+func (f *fakeProvider) HealthCheck() (*providers.HealthStatus, error) {
+	return &providers.HealthStatus{}, nil
+}
+func (f *fakeProvider) GetLogs(time.Time) ([]providers.LogEntry, error) { return nil, nil }
+func (f *fakeProvider) ValidateConfig(*providers.ProviderConfig) error {
+	return f.invalid
+}
+
+func find(t *testing.T, report *Report, name string) Result {
+	t.Helper()
+	for _, r := range report.Results {
+		if r.Name == name {
+			return r
+		}
+	}
+	t.Fatalf("no result named %q in %+v", name, report.Results)
+	return Result{}
+}
+
+func TestRunKeepsOrderAndTimesOut(t *testing.T) {
+	checks := []Check{
+		{Category: "slow", Run: func(ctx context.Context) []Result {
+			time.Sleep(20 * time.Millisecond)
+			return []Result{pass("first", "ok")}
+		}},
+		{Category: "stuck", Run: func(ctx context.Context) []Result {
+			select {} // ignores ctx
+		}},
+		{Category: "broken", Run: func(ctx context.Context) []Result {
+			panic("boom")
+		}},
+		{Category: "fast", Run: func(ctx context.Context) []Result {
+			return []Result{fail("last", "bad", "fix it"), {Category: "other", Name: "moved", Status: StatusSkip}}
+		}},
+	}
+
+	report := Run(context.Background(), checks, 100*time.Millisecond)
+	var names []string
+	for _, r := range report.Results {
+		names = append(names, r.Category+"/"+r.Name)
+	}
+	want := "slow/first stuck/stuck broken/broken fast/last other/moved"
+	if got := strings.Join(names, " "); got != want {
+		t.Errorf("results = %s, want %s", got, want)
+	}
+	if r := report.Results[1]; r.Status != StatusWarn || !strings.Contains(r.Message, "did not finish") {
+		t.Errorf("stuck check reported %+v", r)
+	}
+	if r := report.Results[2]; r.Status != StatusFail || !strings.Contains(r.Message, "boom") {
+		t.Errorf("panicking check reported %+v", r)
+	}
+	if report.Summary != (Summary{Pass: 1, Warn: 1, Fail: 2, Skip: 1}) || report.Healthy {
+		t.Errorf("summary %+v, healthy %v", report.Summary, report.Healthy)
+	}
+	if got := strings.Join(report.Categories(), " "); got != "slow stuck broken fast other" {
+		t.Errorf("categories = %s", got)
+	}
+}
+
+func TestBinaries(t *testing.T) {
+	old := detectVersion
+	defer func() { detectVersion = old }()
+	detectVersion = func(name string) string {
+		if name == "versioned" {
+			return "1.2.3"
+		}
+		return ""
+	}
+
+	ps := []Provider{
+		{Provider: newFake("versioned", true)},
+		{Provider: newFake("plain", true), Enabled: true},
+		{Provider: newFake("missing", false), Enabled: true},
+		{Provider: newFake("unused", false)},
+	}
+	report := Run(context.Background(), []Check{Binaries(ps)}, time.Second)
+
+	if r := find(t, report, "versioned"); r.Status != StatusPass || r.Message != "Installed, version 1.2.3" {
+		t.Errorf("versioned: %+v", r)
+	}
+	if r := find(t, report, "plain"); r.Status != StatusPass || r.Message != "Installed" {
+		t.Errorf("plain: %+v", r)
+	}
+	if r := find(t, report, "missing"); r.Status != StatusFail || r.Fix == "" {
+		t.Errorf("an enabled provider that isn't installed should fail: %+v", r)
+	}
+	if r := find(t, report, "unused"); r.Status != StatusSkip {
+		t.Errorf("an unused provider that isn't installed should be skipped: %+v", r)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	incomplete := newFake("incomplete", true)
+	incomplete.invalid = errors.New("relay server is required")
+	ps := []Provider{
+		{Provider: newFake("loggedin", true)},
+		{Provider: newFake("loggedout", true), Enabled: true},
+		{Provider: newFake("optional", true)},
+		{Provider: incomplete, Enabled: true},
+		{Provider: newFake("unknown", true)},
+		{Provider: newFake("absent", false), Enabled: true},
+	}
+	status := func(name string) string {
+		switch name {
+		case "loggedin":
+			return "authenticated"
+		case "loggedout", "optional":
+			return "not authenticated"
+		default:
+			return "unknown"
+		}
+	}
+	report := Run(context.Background(), []Check{Auth(ps, status)}, time.Second)
+
+	want := map[string]Status{
+		"loggedin":   StatusPass,
+		"loggedout":  StatusFail,
+		"optional":   StatusWarn,
+		"incomplete": StatusFail,
+	}
+	if len(report.Results) != len(want) {
+		t.Errorf("results %+v, want only %v", report.Results, want)
+	}
+	for name, status := range want {
+		if r := find(t, report, name); r.Status != status {
+			t.Errorf("%s: %+v, want %s", name, r, status)
+		}
+	}
+	if r := find(t, report, "loggedout"); r.Fix != "Run: tunnel auth login loggedout" {
+		t.Errorf("fix = %q", r.Fix)
+	}
+}
+
+type fakeResolver map[string]bool
+
+func (f fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if f[host] {
+		return []string{"192.0.2.1"}, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func TestDNS(t *testing.T) {
+	old := resolver
+	resolver = fakeResolver{"relay.example.com": true}
+	defer func() { resolver = old }()
+
+	good := newFake("good", true)
+	good.endpoints = []string{"relay.example.com"}
+	bad := newFake("bad", false)
+	bad.endpoints = []string{"relay.example.com", "gone.example.com"}
+	idle := newFake("idle", true)
+	idle.endpoints = []string{"gone.example.com"}
+	unused := newFake("unused", false)
+	unused.endpoints = []string{"gone.example.com"}
+
+	ps := []Provider{{Provider: good}, {Provider: bad, Enabled: true}, {Provider: idle}, {Provider: unused}}
+	report := Run(context.Background(), []Check{DNS(ps)}, time.Second)
+
+	if len(report.Results) != 3 {
+		t.Fatalf("results %+v; unused providers shouldn't be checked", report.Results)
+	}
+	if r := find(t, report, "good endpoints"); r.Status != StatusPass {
+		t.Errorf("good: %+v", r)
+	}
+	if r := find(t, report, "bad endpoints"); r.Status != StatusFail || r.Message != "Cannot resolve gone.example.com (no such host)" {
+		t.Errorf("bad: %+v", r)
+	}
+	if r := find(t, report, "idle endpoints"); r.Status != StatusWarn {
+		t.Errorf("a provider that isn't enabled should only warn: %+v", r)
+	}
+}
+
+func TestPorts(t *testing.T) {
+	held, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Close()
+	heldAddr := held.Addr().String()
+	heldPort := held.Addr().(*net.TCPAddr).Port
+
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	freeAddr := free.Addr().String()
+	freePort := free.Addr().(*net.TCPAddr).Port
+	free.Close()
+
+	listeners := []Listener{
+		{Owner: "web", Address: heldAddr, Fallback: true},
+		{Owner: "wake 1", Address: freeAddr},
+		{Owner: "wake 2", Address: freeAddr},
+		{Owner: "wake 3", Address: heldAddr},
+	}
+	exposed := []Exposed{{Provider: "ngrok", Port: heldPort}, {Provider: "bore", Port: freePort}}
+	report := Run(context.Background(), []Check{Ports(listeners, exposed, false)}, 5*time.Second)
+
+	want := map[string]Status{
+		"web":                            StatusWarn,
+		"wake 1":                         StatusPass,
+		"wake 2":                         StatusFail,
+		"wake 3":                         StatusFail,
+		"Port " + strconv.Itoa(heldPort): StatusPass,
+		"Port " + strconv.Itoa(freePort): StatusWarn,
+	}
+	for name, status := range want {
+		if r := find(t, report, name); r.Status != status {
+			t.Errorf("%s: %+v, want %s", name, r, status)
+		}
+	}
+	if r := find(t, report, "wake 2"); !strings.Contains(r.Message, "wake 1 and wake 2 both listen") {
+		t.Errorf("duplicate listener: %+v", r)
+	}
+
+	report = Run(context.Background(), []Check{Ports(listeners[3:], nil, true)}, 5*time.Second)
+	if r := find(t, report, "wake 3"); r.Status != StatusPass {
+		t.Errorf("a port held while the daemon runs should pass: %+v", r)
+	}
+}
+
+func TestAuthorizedKeys(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sshd's StrictModes checks are POSIX permissions")
+	}
+	dir := filepath.Join(t.TempDir(), ".ssh")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "authorized_keys")
+
+	report := Run(context.Background(), []Check{AuthorizedKeys(path)}, time.Second)
+	if r := report.Results[0]; r.Status != StatusWarn || !strings.Contains(r.Message, "does not exist") {
+		t.Errorf("missing file: %+v", r)
+	}
+
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	report = Run(context.Background(), []Check{AuthorizedKeys(path)}, time.Second)
+	if report.Summary.Fail != 0 || report.Results[0].Status != StatusPass {
+		t.Errorf("safe permissions: %+v", report.Results)
+	}
+
+	os.Chmod(path, 0664)
+	os.Chmod(dir, 0777)
+	report = Run(context.Background(), []Check{AuthorizedKeys(path)}, time.Second)
+	if report.Summary.Fail != 2 {
+		t.Fatalf("want the file and its directory to fail: %+v", report.Results)
+	}
+	if r := report.Results[1]; !strings.Contains(r.Message, dir) || r.Fix != "Run: chmod go-w "+dir {
+		t.Errorf("directory: %+v", r)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+
+	report := Run(context.Background(), []Check{Config(path)}, time.Second)
+	if r := report.Results[0]; r.Status != StatusWarn {
+		t.Errorf("missing config: %+v", r)
+	}
+
+	if err := os.WriteFile(path, []byte("wake:\n  - listen: 127.0.0.1:15432\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	report = Run(context.Background(), []Check{Config(path)}, time.Second)
+	if r := find(t, report, "Config validity"); r.Status != StatusFail {
+		t.Errorf("invalid config: %+v", r)
+	}
+
+	if err := os.WriteFile(path, []byte("methods: [\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	report = Run(context.Background(), []Check{Config(path)}, time.Second)
+	if r := find(t, report, "Config validity"); r.Status != StatusFail {
+		t.Errorf("unparsable config: %+v", r)
+	}
+
+	if runtime.GOOS != "windows" {
+		os.WriteFile(path, []byte("settings:\n  offline: false\n"), 0666)
+		os.Chmod(path, 0666)
+		report = Run(context.Background(), []Check{Config(path)}, time.Second)
+		if r := find(t, report, "Config permissions"); r.Status != StatusFail {
+			t.Errorf("world-writable config: %+v", r)
+		}
+		if r := find(t, report, "Config validity"); r.Status != StatusPass {
+			t.Errorf("valid config: %+v", r)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package doctor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/jedarden/tunnel/internal/offline"
+	"github.com/jedarden/tunnel/internal/providers"
+)
+
+// connectivityURL is fetched to tell whether the internet is reachable
+const connectivityURL = "https://www.cloudflare.com"
+
+// resolver looks up provider endpoints; replaced in tests
+var resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+} = net.DefaultResolver
+
+// Connectivity checks that the internet is reachable
+func Connectivity() Check {
+	return Check{Category: "network", Run: func(ctx context.Context) []Result {
+		const name = "Internet connectivity"
+		if offline.Enabled() {
+			return []Result{skip(name, "Offline mode is enabled")}
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, connectivityURL, nil)
+		if err != nil {
+			return []Result{fail(name, err.Error(), "")}
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return []Result{fail(name, fmt.Sprintf("Cannot reach the internet: %v", err), "Check your internet connection and firewall settings")}
+		}
+		resp.Body.Close()
+		return []Result{pass(name, "Internet is reachable")}
+	}}
+}
+
+// DNS checks that the endpoints of every enabled or installed provider
+// resolve
+func DNS(ps []Provider) Check {
+	return Check{Category: "network", Run: func(ctx context.Context) []Result {
+		if offline.Enabled() {
+			return []Result{skip("Provider endpoints", "Offline mode is enabled")}
+		}
+
+		var results []Result
+		resolved := make(map[string]error)
+		for _, p := range ps {
+			if !p.Enabled && !p.IsInstalled() {
+				continue
+			}
+			hosts := providers.Endpoints(p.Provider)
+			if len(hosts) == 0 {
+				continue
+			}
+
+			var failed []string
+			for _, host := range hosts {
+				err, ok := resolved[host]
+				if !ok {
+					_, err = resolver.LookupHost(ctx, host)
+					resolved[host] = err
+				}
+				if err != nil {
+					failed = append(failed, fmt.Sprintf("%s (%v)", host, lookupError(err)))
+				}
+			}
+
+			name := providers.DisplayName(p.Name()) + " endpoints"
+			switch {
+			case len(failed) == 0:
+				results = append(results, pass(name, "Resolved "+strings.Join(hosts, ", ")))
+			case p.Enabled:
+				results = append(results, fail(name, "Cannot resolve "+strings.Join(failed, ", "),
+					"Check your DNS settings, and that a firewall or captive portal isn't blocking the provider"))
+			default:
+				results = append(results, warn(name, "Cannot resolve "+strings.Join(failed, ", "),
+					"Check your DNS settings before enabling this provider"))
+			}
+		}
+		if len(results) == 0 {
+			results = append(results, skip("Provider endpoints", "No provider with known endpoints is installed or enabled"))
+		}
+		return results
+	}}
+}
+
+// lookupError shortens the errors of failed lookups
+func lookupError(err error) string {
+	var dnsErr *net.DNSError
+	switch {
+	case !errors.As(err, &dnsErr):
+		return err.Error()
+	case dnsErr.IsNotFound:
+		return "no such host"
+	case dnsErr.IsTimeout:
+		return "timed out"
+	default:
+		return dnsErr.Err
+	}
+}
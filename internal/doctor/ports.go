@@ -0,0 +1,121 @@
+package doctor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Listener is an address TUNNEL listens on
+type Listener struct {
+	Owner    string // what listens there, e.g. "wake listener 127.0.0.1:15432"
+	Network  string // tcp or udp; empty is tcp
+	Address  string // host:port
+	Fallback bool   // moves to the next free port if taken, as the web server does
+}
+
+// Exposed is a local port an enabled provider makes reachable
+type Exposed struct {
+	Provider string
+	Port     int
+}
+
+// dialTimeout bounds the probes of exposed ports
+const dialTimeout = 2 * time.Second
+
+// Ports checks that nothing else holds the addresses TUNNEL listens on and
+// that something serves the ports providers expose. While a tunnel daemon
+// runs, its own listeners are expected to be taken.
+func Ports(listeners []Listener, exposed []Exposed, daemonRunning bool) Check {
+	return Check{Category: "ports", Run: func(ctx context.Context) []Result {
+		var results []Result
+		owners := make(map[string]string)
+		for _, l := range listeners {
+			network := l.Network
+			if network == "" {
+				network = "tcp"
+			}
+			key := network + " " + l.Address
+			if other, ok := owners[key]; ok {
+				results = append(results, fail(l.Owner, fmt.Sprintf("%s and %s both listen on %s", other, l.Owner, l.Address),
+					"Give each listener its own address in the config"))
+				continue
+			}
+			owners[key] = l.Owner
+			results = append(results, checkListener(l, network, daemonRunning))
+		}
+
+		for _, e := range exposed {
+			name := fmt.Sprintf("Port %d", e.Port)
+			var d net.Dialer
+			dctx, cancel := context.WithTimeout(ctx, dialTimeout)
+			conn, err := d.DialContext(dctx, "tcp", net.JoinHostPort("localhost", strconv.Itoa(e.Port)))
+			cancel()
+			if err != nil {
+				results = append(results, warn(name, fmt.Sprintf("%s exposes port %d, but nothing is listening on it", e.Provider, e.Port),
+					fmt.Sprintf("Start the service %s should expose, or change its local port", e.Provider)))
+				continue
+			}
+			conn.Close()
+			results = append(results, pass(name, fmt.Sprintf("Listening, exposed by %s", e.Provider)))
+		}
+
+		if len(results) == 0 {
+			results = append(results, skip("Ports", "Nothing is configured to listen or be exposed"))
+		}
+		return results
+	}}
+}
+
+// checkListener tries to listen where l would
+func checkListener(l Listener, network string, daemonRunning bool) Result {
+	var err error
+	if network == "udp" {
+		var pc net.PacketConn
+		if pc, err = net.ListenPacket(network, l.Address); err == nil {
+			pc.Close()
+		}
+	} else {
+		var ln net.Listener
+		if ln, err = net.Listen(network, l.Address); err == nil {
+			ln.Close()
+		}
+	}
+
+	switch {
+	case err == nil:
+		return pass(l.Owner, fmt.Sprintf("%s is free", l.Address))
+	case !errors.Is(err, syscall.EADDRINUSE):
+		return warn(l.Owner, fmt.Sprintf("Cannot listen on %s: %v", l.Address, err),
+			"Ports below 1024 need root or CAP_NET_BIND_SERVICE")
+	case daemonRunning:
+		return pass(l.Owner, fmt.Sprintf("%s is in use, presumably by the running tunnel daemon", l.Address))
+	case l.Fallback:
+		return warn(l.Owner, fmt.Sprintf("%s is in use; the next free port will be used instead", l.Address),
+			"Stop whatever holds the port, or pick another with --port")
+	default:
+		return fail(l.Owner, fmt.Sprintf("%s is in use by another process", l.Address),
+			"Stop whatever holds the port, or change the address in the config")
+	}
+}
+
+// SSHServer checks that an SSH server answers on port
+func SSHServer(port int) Check {
+	return Check{Category: "ssh", Run: func(ctx context.Context) []Result {
+		const name = "SSH server"
+		var d net.Dialer
+		ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+		defer cancel()
+		conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort("localhost", strconv.Itoa(port)))
+		if err != nil {
+			return []Result{warn(name, fmt.Sprintf("SSH server not running on port %d", port),
+				"Install and start SSH server: sudo apt-get install openssh-server && sudo systemctl start ssh")}
+		}
+		conn.Close()
+		return []Result{pass(name, fmt.Sprintf("SSH server is running on port %d", port))}
+	}}
+}
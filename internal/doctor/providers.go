@@ -0,0 +1,100 @@
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jedarden/tunnel/internal/installer"
+	"github.com/jedarden/tunnel/internal/providers"
+)
+
+// Provider is a provider to diagnose
+type Provider struct {
+	providers.Provider
+	Enabled bool // the config uses it, so problems with it are failures
+}
+
+// detectVersion finds the installed version of a provider; replaced in tests
+var detectVersion = installer.DetectVersion
+
+// Binaries checks that the client of every enabled provider is installed
+// and reports the version of each installed one
+func Binaries(ps []Provider) Check {
+	return Check{Category: "providers", Run: func(ctx context.Context) []Result {
+		results := make([]Result, 0, len(ps))
+		for _, p := range ps {
+			name := providers.DisplayName(p.Name())
+			if !p.IsInstalled() {
+				if p.Enabled {
+					results = append(results, fail(name, "Enabled but not installed",
+						fmt.Sprintf("Install %s, or disable the %s method", name, p.Name())))
+				} else {
+					results = append(results, skip(name, "Not installed"))
+				}
+				continue
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			message := "Installed"
+			if version := detectVersion(p.Name()); version != "" {
+				message = fmt.Sprintf("Installed, version %s", version)
+			}
+			results = append(results, pass(name, message))
+		}
+		return results
+	}}
+}
+
+// Auth checks that every installed provider is logged in to its service.
+// status reports a provider's authentication as `tunnel auth status` does;
+// providers it knows nothing about are checked by validating their config
+// if enabled.
+func Auth(ps []Provider, status func(name string) string) Check {
+	return Check{Category: "auth", Run: func(ctx context.Context) []Result {
+		var results []Result
+		for _, p := range ps {
+			if !p.IsInstalled() || ctx.Err() != nil {
+				continue
+			}
+			name := providers.DisplayName(p.Name())
+			switch s := status(p.Name()); {
+			case s == "unknown" || s == "":
+				if !p.Enabled {
+					continue
+				}
+				config, err := p.GetConfig()
+				if err == nil {
+					err = p.ValidateConfig(config)
+				}
+				if err != nil {
+					results = append(results, fail(name, fmt.Sprintf("Incomplete configuration: %v", err),
+						fmt.Sprintf("Set the %s method's settings in the config", p.Name())))
+				} else {
+					results = append(results, pass(name, "Configured"))
+				}
+			case strings.HasPrefix(s, "not "):
+				fix := fmt.Sprintf("Run: tunnel auth login %s", p.Name())
+				if p.Enabled {
+					results = append(results, fail(name, capitalize(s), fix))
+				} else {
+					results = append(results, warn(name, capitalize(s), fix))
+				}
+			default:
+				results = append(results, pass(name, capitalize(s)))
+			}
+		}
+		if len(results) == 0 {
+			results = append(results, skip("Authentication", "No installed provider to check"))
+		}
+		return results
+	}}
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
@@ -0,0 +1,67 @@
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// AuthorizedKeys checks the authorized_keys file at path and the
+// directories above it the way sshd's StrictModes does: none of them may be
+// writable by other users, and they must belong to the user or root.
+// Otherwise sshd ignores the file and key logins fail.
+func AuthorizedKeys(path string) Check {
+	return Check{Category: "ssh", Run: func(ctx context.Context) []Result {
+		const name = "authorized_keys"
+		info, err := os.Stat(path)
+		switch {
+		case os.IsNotExist(err):
+			return []Result{warn(name, fmt.Sprintf("%s does not exist yet", path), "Add a key with: tunnel keys add <user>")}
+		case err != nil:
+			return []Result{fail(name, err.Error(), fmt.Sprintf("Check the permissions of %s", filepath.Dir(path)))}
+		case !info.Mode().IsRegular():
+			return []Result{fail(name, fmt.Sprintf("%s is not a regular file", path), "")}
+		case runtime.GOOS == "windows":
+			return []Result{pass(name, fmt.Sprintf("Found at %s", path))}
+		}
+
+		var results []Result
+		if problem := strictModes(path, info); problem != "" {
+			results = append(results, fail(name, problem, fmt.Sprintf("Run: chmod 600 %s", path)))
+		} else {
+			results = append(results, pass(name, fmt.Sprintf("%s has safe permissions (%04o)", path, info.Mode().Perm())))
+		}
+
+		// sshd also checks the directories up to the user's home
+		home, _ := os.UserHomeDir()
+		dir := filepath.Dir(path)
+		for i := 0; i < 2; i++ {
+			dirInfo, err := os.Stat(dir)
+			if err != nil {
+				break
+			}
+			if problem := strictModes(dir, dirInfo); problem != "" {
+				results = append(results, fail(name, problem, fmt.Sprintf("Run: chmod go-w %s", dir)))
+			}
+			if dir == home || filepath.Dir(dir) == dir {
+				break
+			}
+			dir = filepath.Dir(dir)
+		}
+		return results
+	}}
+}
+
+// strictModes explains why sshd's StrictModes would reject path, or
+// returns "" if it wouldn't
+func strictModes(path string, info os.FileInfo) string {
+	if info.Mode().Perm()&0022 != 0 {
+		return fmt.Sprintf("%s is writable by other users (mode %04o), so sshd ignores the keys", path, info.Mode().Perm())
+	}
+	if uid, ok := fileOwner(info); ok && uid != 0 && uid != os.Geteuid() {
+		return fmt.Sprintf("%s belongs to uid %d, not you or root, so sshd ignores the keys", path, uid)
+	}
+	return ""
+}
@@ -0,0 +1,172 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jedarden/tunnel/internal/providers"
+	"gopkg.in/yaml.v3"
+)
+
+// clientImages are the published images of tunnel clients, by binary.
+// Their entrypoint is the client, so services only give its arguments.
+var clientImages = map[string]string{
+	"cloudflared": "cloudflare/cloudflared:latest",
+	"ngrok":       "ngrok/ngrok:latest",
+	"bore":        "ekzhang/bore:latest",
+}
+
+type composeFile struct {
+	Services map[string]composeService `yaml:"services"`
+}
+
+type composeService struct {
+	Image       string            `yaml:"image"`
+	Entrypoint  []string          `yaml:"entrypoint,omitempty"`
+	Command     []string          `yaml:"command,omitempty"`
+	Environment map[string]string `yaml:"environment,omitempty"`
+	Volumes     []string          `yaml:"volumes,omitempty"`
+	NetworkMode string            `yaml:"network_mode"`
+	Restart     string            `yaml:"restart"`
+}
+
+// compose writes s as a docker-compose service. The service needs a
+// long-running command to supervise; commands run before it go through
+// the image's shell.
+func compose(def Definition, s steps, exp *Export) error {
+	if s.main == nil {
+		return fmt.Errorf("%s connects with `%s`, which exits once connected; a compose service needs a command that keeps running", def.Name, s.post[0].String())
+	}
+	for _, cmd := range s.post {
+		exp.note("skipped `%s`: a compose service only runs commands before its main one", cmd.String())
+	}
+	for _, cmd := range s.stop {
+		exp.note("skipped `%s`: compose stops a service by stopping its container", cmd.String())
+	}
+
+	exp.Name = unitName(def.Name)
+	exp.File = "docker-compose.yml"
+
+	client := filepath.Base(s.main.Path)
+	image := def.Image
+	if image == "" {
+		image = clientImages[client]
+	}
+	if image == "" {
+		return fmt.Errorf("no known image runs %s; give one that has it", client)
+	}
+
+	service := composeService{
+		Image:       image,
+		NetworkMode: "host",
+		Restart:     "on-failure",
+	}
+	if def.Restart {
+		service.Restart = "unless-stopped"
+	}
+	if len(def.Env) > 0 {
+		service.Environment = make(map[string]string, len(def.Env))
+		for k, v := range def.Env {
+			service.Environment[k] = strings.ReplaceAll(v, "$", "$$")
+		}
+	}
+
+	switch {
+	case len(s.pre) > 0:
+		script := make([]string, 0, len(s.pre)+1)
+		for _, cmd := range s.pre {
+			script = append(script, shellCommand(cmd, exp))
+		}
+		script = append(script, "exec "+shellCommand(*s.main, exp))
+		service.Entrypoint = []string{"/bin/sh", "-c", strings.Join(script, " && ")}
+		exp.note("the service runs its commands with /bin/sh, which %s must have", image)
+	case def.Image == "":
+		service.Command = composeWords(s.main.Args, exp)
+	default:
+		service.Entrypoint = composeWords([]string{s.main.Path}, exp)
+		service.Command = composeWords(s.main.Args, exp)
+	}
+	for _, cmd := range append(append([]providers.PlannedCommand{}, s.pre...), *s.main) {
+		for _, word := range cmd.Args {
+			if filepath.IsAbs(word) && !contains(service.Volumes, word+":"+word+":ro") {
+				service.Volumes = append(service.Volumes, word+":"+word+":ro")
+			}
+		}
+	}
+	if len(service.Volumes) > 0 {
+		exp.note("files the command reads from this host are mounted read-only at the same paths")
+	}
+	exp.note("the service uses the host's network, so it reaches the same ports the tunnel would on this machine")
+	if exp.SecretEnv != "" {
+		exp.note("set %s to the secret in the environment or an .env file next to the compose file", exp.SecretEnv)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Generated by tunnel export compose %s\n", def.Name)
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(composeFile{Services: map[string]composeService{exp.Name: service}}); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	exp.Content = buf.String()
+	return nil
+}
+
+// composeWords escapes what compose would interpolate in words and reads
+// the secret from exp.SecretEnv
+func composeWords(words []string, exp *Export) []string {
+	out := make([]string, len(words))
+	for i, word := range words {
+		out[i] = composeWord(word, exp)
+	}
+	return out
+}
+
+func composeWord(word string, exp *Export) string {
+	word = strings.ReplaceAll(word, "$", "$$")
+	if exp.SecretEnv != "" {
+		ref := fmt.Sprintf("${%s:?set %s}", exp.SecretEnv, exp.SecretEnv)
+		word = strings.ReplaceAll(word, providers.Redacted, ref)
+	}
+	return word
+}
+
+// shellCommand renders cmd for sh -c, quoting each word
+func shellCommand(cmd providers.PlannedCommand, exp *Export) string {
+	words := make([]string, 0, len(cmd.Args)+1)
+	for _, word := range append([]string{cmd.Path}, cmd.Args...) {
+		words = append(words, composeWord(shellQuote(word), exp))
+	}
+	return strings.Join(words, " ")
+}
+
+func shellQuote(word string) string {
+	if word != "" && !strings.ContainsAny(word, " \t\n\"'$\\|&;<>()*?`#~") {
+		return word
+	}
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,215 @@
+// Package exporter turns what a provider runs to connect into a standalone
+// definition for a machine where TUNNEL itself won't run: a systemd service
+// unit or a docker-compose service. The commands come from the provider's
+// connect plan, so command templates apply as they do for tunnel start.
+// Secrets, which plans redact, are read from an environment variable rather
+// than written into the definition.
+package exporter
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/jedarden/tunnel/internal/providers"
+)
+
+// Kind names a format a provider can be exported as
+type Kind string
+
+const (
+	KindSystemd Kind = "systemd"
+	KindCompose Kind = "compose"
+)
+
+// Kinds lists the formats providers can be exported as
+var Kinds = []Kind{KindSystemd, KindCompose}
+
+// ParseKind checks that s names a format providers can be exported as
+func ParseKind(s string) (Kind, error) {
+	for _, k := range Kinds {
+		if string(k) == s {
+			return k, nil
+		}
+	}
+	return "", fmt.Errorf("unknown export format %q: expected systemd or compose", s)
+}
+
+// Definition is a provider to export
+type Definition struct {
+	Name        string            // provider name
+	Description string            // what the tunnel is, for the unit's description
+	Connect     *providers.Plan   // the provider's connect plan
+	Disconnect  *providers.Plan   // its disconnect plan; may be nil
+	Env         map[string]string // environment of every command
+	Restart     bool              // restart the command whenever it exits, not only on failure
+	Image       string            // compose only: image with the client; defaults by client
+}
+
+// Export is a generated definition
+type Export struct {
+	Kind      Kind     `json:"kind"`
+	Name      string   `json:"name"` // unit or service name
+	File      string   `json:"file"` // file name the definition is usually kept in
+	Content   string   `json:"content"`
+	SecretEnv string   `json:"secret_env,omitempty"` // variable the provider's secret is read from
+	Notes     []string `json:"notes,omitempty"`
+}
+
+// note adds a remark about the export, once
+func (e *Export) note(format string, args ...interface{}) {
+	note := fmt.Sprintf(format, args...)
+	for _, n := range e.Notes {
+		if n == note {
+			return
+		}
+	}
+	e.Notes = append(e.Notes, note)
+}
+
+// lookPath resolves the binaries systemd units run; replaced in tests
+var lookPath = exec.LookPath
+
+// Generate exports def as kind
+func Generate(kind Kind, def Definition) (*Export, error) {
+	if def.Connect == nil {
+		return nil, fmt.Errorf("%s has no connect plan", def.Name)
+	}
+	exp := &Export{Kind: kind}
+	if usesSecret(def) {
+		exp.SecretEnv = SecretEnv(def.Name)
+	}
+
+	s, err := planSteps(def, exp)
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case KindSystemd:
+		err = systemd(def, s, exp)
+	case KindCompose:
+		err = compose(def, s, exp)
+	default:
+		err = fmt.Errorf("unknown export format %q", kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return exp, nil
+}
+
+// SecretEnv returns the environment variable an exported provider reads
+// its secret from: TUNNEL_CLOUDFLARE_SECRET for cloudflare
+func SecretEnv(name string) string {
+	return "TUNNEL_" + envName(name) + "_SECRET"
+}
+
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+// unitName returns a name for the unit or service exporting provider name
+func unitName(name string) string {
+	return "tunnel-" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '-'
+	}, name)
+}
+
+// usesSecret reports whether any command of def has a redacted secret
+func usesSecret(def Definition) bool {
+	plans := []*providers.Plan{def.Connect, def.Disconnect}
+	for _, plan := range plans {
+		if plan == nil {
+			continue
+		}
+		for _, cmd := range plan.Commands {
+			for _, word := range append([]string{cmd.Path}, cmd.Args...) {
+				if strings.Contains(word, providers.Redacted) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// steps are the commands an exported definition runs
+type steps struct {
+	pre  []providers.PlannedCommand // run to completion before main
+	main *providers.PlannedCommand  // the long-running command, if there is one
+	post []providers.PlannedCommand // run to completion after main starts, or the only commands if there is no main
+	stop []providers.PlannedCommand // run to take the tunnel down
+}
+
+// planSteps sorts the commands of def's plans into steps, dropping what
+// can't be exported and noting it on exp
+func planSteps(def Definition, exp *Export) (steps, error) {
+	var s steps
+	sudoDropped := false
+	prepare := func(cmd providers.PlannedCommand) (providers.PlannedCommand, bool) {
+		if cmd.Condition != "" {
+			exp.note("skipped `%s`, which only runs if %s", cmd.String(), cmd.Condition)
+			return cmd, false
+		}
+		if cmd.Path == "sudo" && len(cmd.Args) > 0 {
+			if !sudoDropped {
+				exp.note("sudo is dropped from commands; the exported definition runs as root unless you change it")
+				sudoDropped = true
+			}
+			cmd.Path, cmd.Args = cmd.Args[0], cmd.Args[1:]
+		}
+		return cmd, true
+	}
+
+	for _, planned := range def.Connect.Commands {
+		cmd, ok := prepare(planned)
+		if !ok {
+			continue
+		}
+		switch {
+		case cmd.Background && s.main != nil:
+			return s, fmt.Errorf("%s starts more than one long-running command", def.Name)
+		case cmd.Background:
+			if cmd.Sandboxed {
+				exp.note("sandbox.%s settings confine the command under tunnel only and are not exported", def.Name)
+			}
+			s.main = &cmd
+		case s.main == nil:
+			s.pre = append(s.pre, cmd)
+		default:
+			s.post = append(s.post, cmd)
+		}
+	}
+	if s.main == nil {
+		s.post, s.pre = append(s.pre, s.post...), nil
+	}
+	if s.main == nil && len(s.post) == 0 {
+		return s, fmt.Errorf("%s runs no commands that can be exported", def.Name)
+	}
+
+	if def.Disconnect != nil {
+		for _, planned := range def.Disconnect.Commands {
+			// pkill finds a process tunnel left running; the exported
+			// definition's own process manager stops what it started
+			if planned.Path == "pkill" {
+				continue
+			}
+			if cmd, ok := prepare(planned); ok {
+				s.stop = append(s.stop, cmd)
+			}
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,222 @@
+package exporter
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jedarden/tunnel/internal/providers"
+	"gopkg.in/yaml.v3"
+)
+
+func init() {
+	// Resolve only ssh, so tests don't depend on what is installed
+	lookPath = func(file string) (string, error) {
+		if file == "ssh" {
+			return "/usr/bin/ssh", nil
+		}
+		return "", errors.New("not found")
+	}
+}
+
+func ngrokPlans() (*providers.Plan, *providers.Plan) {
+	connect := &providers.Plan{Provider: "ngrok", Action: providers.ActionConnect}
+	connect.Run("ngrok", "config", "add-authtoken", providers.Redacted)
+	connect.Start("ngrok", "tcp", "22", "--log", "stdout")
+	disconnect := &providers.Plan{Provider: "ngrok", Action: providers.ActionDisconnect}
+	disconnect.Run("pkill", "-f", "ngrok tcp")
+	return connect, disconnect
+}
+
+func TestSystemdLongRunning(t *testing.T) {
+	connect, disconnect := ngrokPlans()
+	exp, err := Generate(KindSystemd, Definition{Name: "ngrok", Description: "tunnel ngrok", Connect: connect, Disconnect: disconnect})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exp.Name != "tunnel-ngrok" || exp.File != "tunnel-ngrok.service" {
+		t.Errorf("name %q, file %q", exp.Name, exp.File)
+	}
+	if exp.SecretEnv != "TUNNEL_NGROK_SECRET" {
+		t.Errorf("secret env = %q", exp.SecretEnv)
+	}
+	for _, want := range []string{
+		"Type=simple\n",
+		"EnvironmentFile=/etc/tunnel/ngrok.env\n",
+		"ExecStartPre=ngrok config add-authtoken ${TUNNEL_NGROK_SECRET}\n",
+		"ExecStart=ngrok tcp 22 --log stdout\n",
+		"Restart=on-failure\n",
+		"WantedBy=multi-user.target\n",
+	} {
+		if !strings.Contains(exp.Content, want) {
+			t.Errorf("unit lacks %q:\n%s", want, exp.Content)
+		}
+	}
+	if strings.Contains(exp.Content, "pkill") || strings.Contains(exp.Content, providers.Redacted) {
+		t.Errorf("unit should neither pkill nor hold a redacted secret:\n%s", exp.Content)
+	}
+}
+
+func TestSystemdOneshot(t *testing.T) {
+	connect := &providers.Plan{Provider: "tailscale", Action: providers.ActionConnect}
+	connect.Run("pgrep", "tailscaled")
+	connect.Run("sudo", "systemctl", "start", "tailscaled").Condition = "tailscaled is not running"
+	connect.Run("tailscale", "up", "--authkey="+providers.Redacted)
+	disconnect := &providers.Plan{Provider: "tailscale", Action: providers.ActionDisconnect}
+	disconnect.Run("sudo", "tailscale", "down")
+
+	exp, err := Generate(KindSystemd, Definition{Name: "tailscale", Connect: connect, Disconnect: disconnect})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Type=oneshot\nRemainAfterExit=yes\n",
+		"ExecStart=pgrep tailscaled\n",
+		"ExecStart=tailscale up --authkey=${TUNNEL_TAILSCALE_SECRET}\n",
+		"ExecStop=tailscale down\n",
+	} {
+		if !strings.Contains(exp.Content, want) {
+			t.Errorf("unit lacks %q:\n%s", want, exp.Content)
+		}
+	}
+	if strings.Contains(exp.Content, "Restart=") || strings.Contains(exp.Content, "systemctl") {
+		t.Errorf("unexpected line in oneshot unit:\n%s", exp.Content)
+	}
+	notes := strings.Join(exp.Notes, "\n")
+	for _, want := range []string{"only runs if tailscaled is not running", "sudo is dropped", "TUNNEL_TAILSCALE_SECRET"} {
+		if !strings.Contains(notes, want) {
+			t.Errorf("notes %q lack %q", notes, want)
+		}
+	}
+}
+
+func TestSystemdEscaping(t *testing.T) {
+	connect := &providers.Plan{Provider: "mytun", Action: providers.ActionConnect}
+	connect.Start("ssh", "-o", "ProxyCommand=nc %h %p", "--label", `say "$HOME"`, ";")
+
+	exp, err := Generate(KindSystemd, Definition{
+		Name:    "mytun",
+		Connect: connect,
+		Env:     map[string]string{"B": "50%", "A": "x y"},
+		Restart: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`Environment="A=x y"` + "\nEnvironment=B=50%%\n",
+		`ExecStart=/usr/bin/ssh -o "ProxyCommand=nc %%h %%p" --label "say \"$$HOME\"" ";"` + "\n",
+		"Restart=always\n",
+	} {
+		if !strings.Contains(exp.Content, want) {
+			t.Errorf("unit lacks %q:\n%s", want, exp.Content)
+		}
+	}
+	if exp.SecretEnv != "" || strings.Contains(exp.Content, "EnvironmentFile") {
+		t.Error("a plan without secrets shouldn't read an environment file")
+	}
+}
+
+func parseCompose(t *testing.T, exp *Export) composeService {
+	t.Helper()
+	var file composeFile
+	if err := yaml.Unmarshal([]byte(exp.Content), &file); err != nil {
+		t.Fatalf("invalid compose file: %v\n%s", err, exp.Content)
+	}
+	service, ok := file.Services[exp.Name]
+	if !ok || len(file.Services) != 1 {
+		t.Fatalf("services %v, want only %s", file.Services, exp.Name)
+	}
+	return service
+}
+
+func TestComposeKnownImage(t *testing.T) {
+	connect := &providers.Plan{Provider: "bore", Action: providers.ActionConnect}
+	connect.Start("bore", "local", "22", "--to", "bore.pub", "--secret", providers.Redacted)
+
+	exp, err := Generate(KindCompose, Definition{Name: "bore", Connect: connect})
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := parseCompose(t, exp)
+	if service.Image != "ekzhang/bore:latest" || len(service.Entrypoint) != 0 {
+		t.Errorf("image %q, entrypoint %q", service.Image, service.Entrypoint)
+	}
+	want := "local 22 --to bore.pub --secret ${TUNNEL_BORE_SECRET:?set TUNNEL_BORE_SECRET}"
+	if got := strings.Join(service.Command, " "); got != want {
+		t.Errorf("command = %q, want %q", got, want)
+	}
+	if service.NetworkMode != "host" || service.Restart != "on-failure" {
+		t.Errorf("network_mode %q, restart %q", service.NetworkMode, service.Restart)
+	}
+}
+
+func TestComposeSetupCommandsUseShell(t *testing.T) {
+	connect, disconnect := ngrokPlans()
+	exp, err := Generate(KindCompose, Definition{Name: "ngrok", Connect: connect, Disconnect: disconnect})
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := parseCompose(t, exp)
+	want := []string{"/bin/sh", "-c", "ngrok config add-authtoken '${TUNNEL_NGROK_SECRET:?set TUNNEL_NGROK_SECRET}' && exec ngrok tcp 22 --log stdout"}
+	if strings.Join(service.Entrypoint, "|") != strings.Join(want, "|") {
+		t.Errorf("entrypoint = %q, want %q", service.Entrypoint, want)
+	}
+}
+
+func TestComposeCustomImage(t *testing.T) {
+	connect := &providers.Plan{Provider: "reverse-ssh", Action: providers.ActionConnect}
+	connect.Start("ssh", "-R", "2222:localhost:22", "-i", "/home/me/.ssh/relay", "relay.example.com")
+
+	if _, err := Generate(KindCompose, Definition{Name: "reverse-ssh", Connect: connect}); err == nil {
+		t.Fatal("expected an error without an image for ssh")
+	}
+
+	exp, err := Generate(KindCompose, Definition{
+		Name:    "reverse-ssh",
+		Connect: connect,
+		Image:   "alpine/ssh",
+		Env:     map[string]string{"PASS": "a$b"},
+		Restart: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := parseCompose(t, exp)
+	if strings.Join(service.Entrypoint, " ") != "ssh" {
+		t.Errorf("entrypoint = %q", service.Entrypoint)
+	}
+	if len(service.Volumes) != 1 || service.Volumes[0] != "/home/me/.ssh/relay:/home/me/.ssh/relay:ro" {
+		t.Errorf("volumes = %q", service.Volumes)
+	}
+	if service.Environment["PASS"] != "a$$b" || service.Restart != "unless-stopped" {
+		t.Errorf("environment %v, restart %q", service.Environment, service.Restart)
+	}
+}
+
+func TestComposeNeedsLongRunningCommand(t *testing.T) {
+	connect := &providers.Plan{Provider: "wireguard", Action: providers.ActionConnect}
+	connect.Run("wg-quick", "up", "wg0")
+
+	_, err := Generate(KindCompose, Definition{Name: "wireguard", Connect: connect})
+	if err == nil || !strings.Contains(err.Error(), "wg-quick up wg0") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestNothingToExport(t *testing.T) {
+	connect := &providers.Plan{Provider: "plugin", Action: providers.ActionConnect}
+	if _, err := Generate(KindSystemd, Definition{Name: "plugin", Connect: connect}); err == nil {
+		t.Error("expected an error for a plan without commands")
+	}
+}
+
+func TestParseKind(t *testing.T) {
+	if k, err := ParseKind("compose"); err != nil || k != KindCompose {
+		t.Errorf("ParseKind(compose) = %q, %v", k, err)
+	}
+	if _, err := ParseKind("launchd"); err == nil {
+		t.Error("expected an error for launchd")
+	}
+}
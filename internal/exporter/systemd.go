@@ -0,0 +1,121 @@
+package exporter
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/jedarden/tunnel/internal/providers"
+)
+
+// secretDir holds the environment files exported units read secrets from
+const secretDir = "/etc/tunnel"
+
+// systemd writes s as a service unit. A long-running command is the
+// unit's main process; providers that only run commands to completion,
+// such as tailscale up, become a oneshot unit that stays active.
+func systemd(def Definition, s steps, exp *Export) error {
+	exp.Name = unitName(def.Name)
+	exp.File = exp.Name + ".service"
+
+	var b strings.Builder
+	line := func(format string, args ...interface{}) {
+		fmt.Fprintf(&b, format+"\n", args...)
+	}
+	execLines := func(key string, cmds ...providers.PlannedCommand) {
+		for _, cmd := range cmds {
+			line("%s=%s", key, systemdCommand(cmd, exp))
+		}
+	}
+
+	line("# Generated by tunnel export systemd %s", def.Name)
+	line("[Unit]")
+	description := def.Description
+	if description == "" {
+		description = def.Name
+	}
+	line("Description=%s", strings.ReplaceAll(description, "%", "%%"))
+	line("After=network-online.target")
+	line("Wants=network-online.target")
+	line("")
+	line("[Service]")
+	if s.main != nil {
+		line("Type=simple")
+	} else {
+		line("Type=oneshot")
+		line("RemainAfterExit=yes")
+	}
+	for _, key := range sortedKeys(def.Env) {
+		line("Environment=%s", systemdQuote(systemdEscape(key+"="+def.Env[key], false)))
+	}
+	if exp.SecretEnv != "" {
+		envFile := filepath.Join(secretDir, def.Name+".env")
+		line("EnvironmentFile=%s", envFile)
+		exp.note("put %s=<secret> in %s, readable only by root", exp.SecretEnv, envFile)
+	}
+
+	if s.main != nil {
+		execLines("ExecStartPre", s.pre...)
+		execLines("ExecStart", *s.main)
+		execLines("ExecStartPost", s.post...)
+		execLines("ExecStopPost", s.stop...)
+		if def.Restart {
+			line("Restart=always")
+		} else {
+			line("Restart=on-failure")
+		}
+		line("RestartSec=5")
+	} else {
+		execLines("ExecStart", s.post...)
+		execLines("ExecStop", s.stop...)
+	}
+	line("")
+	line("[Install]")
+	line("WantedBy=multi-user.target")
+
+	exp.Content = b.String()
+	return nil
+}
+
+// systemdCommand renders cmd for an Exec line, with an absolute path for
+// the binary where it is installed here and the secret read from
+// exp.SecretEnv
+func systemdCommand(cmd providers.PlannedCommand, exp *Export) string {
+	path := cmd.Path
+	if !filepath.IsAbs(path) {
+		if resolved, err := lookPath(path); err == nil {
+			path = resolved
+		} else {
+			exp.note("%s isn't installed here, so the unit leaves systemd to find it", path)
+		}
+	}
+
+	words := make([]string, 0, len(cmd.Args)+1)
+	for _, word := range append([]string{path}, cmd.Args...) {
+		word = systemdEscape(word, true)
+		if exp.SecretEnv != "" {
+			word = strings.ReplaceAll(word, providers.Redacted, "${"+exp.SecretEnv+"}")
+		}
+		words = append(words, systemdQuote(word))
+	}
+	return strings.Join(words, " ")
+}
+
+// systemdEscape escapes what systemd would expand in word: specifiers, and
+// in command lines, variables
+func systemdEscape(word string, command bool) string {
+	word = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%").Replace(word)
+	if command {
+		word = strings.ReplaceAll(word, "$", "$$")
+	}
+	return word
+}
+
+// systemdQuote double quotes an escaped word if it would otherwise split
+// or be read as a command separator
+func systemdQuote(word string) string {
+	if word == "" || word == ";" || strings.ContainsAny(word, " \t\"'") {
+		return `"` + word + `"`
+	}
+	return word
+}
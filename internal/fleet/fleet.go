@@ -0,0 +1,123 @@
+// Package fleet passes key revocations on to other TUNNEL nodes through
+// their REST API, so a key revoked on one node can be revoked everywhere.
+package fleet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/offline"
+)
+
+// DefaultTimeout bounds each node's revocation
+const DefaultTimeout = 30 * time.Second
+
+// RevokeRequest asks a node to revoke keys of a user. Without
+// fingerprints, every key of the user is revoked.
+type RevokeRequest struct {
+	User         string   `json:"user"`
+	Fingerprints []string `json:"fingerprints,omitempty"`
+	Reason       string   `json:"reason,omitempty"`
+}
+
+// RevokeResult is how a revocation went on one node
+type RevokeResult struct {
+	Node    string   `json:"node"`
+	Revoked []string `json:"revoked"`           // fingerprints revoked on the node
+	Missing []string `json:"missing,omitempty"` // asked for but not on the node
+	Error   string   `json:"error,omitempty"`
+}
+
+// OK reports whether the node did its part; keys it never had count as
+// revoked
+func (r RevokeResult) OK() bool {
+	return r.Error == ""
+}
+
+// Node is another TUNNEL node's API
+type Node struct {
+	Name  string // how results from this node are labelled
+	URL   string // the API's base URL, e.g. https://node-b:8080
+	Token string // bearer token, if the API needs one
+
+	HTTP *http.Client // nil uses a client with DefaultTimeout
+}
+
+// Revoke asks the node to revoke the keys of req
+func (n *Node) Revoke(ctx context.Context, req RevokeRequest) (RevokeResult, error) {
+	if err := offline.Check("fleet revocation"); err != nil {
+		return RevokeResult{}, fmt.Errorf("node %s: %w", n.Name, err)
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return RevokeResult{}, fmt.Errorf("node %s: %w", n.Name, err)
+	}
+	url := strings.TrimSuffix(n.URL, "/") + "/api/keys/revoke"
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return RevokeResult{}, fmt.Errorf("node %s: %w", n.Name, err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if n.Token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+
+	client := n.HTTP
+	if client == nil {
+		client = &http.Client{Timeout: DefaultTimeout}
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return RevokeResult{}, fmt.Errorf("node %s: %w", n.Name, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 256*1024))
+	if err != nil {
+		return RevokeResult{}, fmt.Errorf("node %s: %w", n.Name, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error != "" {
+			return RevokeResult{}, fmt.Errorf("node %s: %s (%d)", n.Name, apiErr.Error, resp.StatusCode)
+		}
+		return RevokeResult{}, fmt.Errorf("node %s: %s", n.Name, resp.Status)
+	}
+
+	var res RevokeResult
+	if err := json.Unmarshal(data, &res); err != nil {
+		return RevokeResult{}, fmt.Errorf("node %s: invalid response: %w", n.Name, err)
+	}
+	res.Node = n.Name
+	return res, nil
+}
+
+// Revoke asks every node at once to revoke the keys of req and returns
+// their results in the order of nodes. A node that can't be reached or
+// refuses has its error in its result.
+func Revoke(ctx context.Context, nodes []*Node, req RevokeRequest) []RevokeResult {
+	results := make([]RevokeResult, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func(i int, node *Node) {
+			defer wg.Done()
+			res, err := node.Revoke(ctx, req)
+			if err != nil {
+				res = RevokeResult{Node: node.Name, Error: err.Error()}
+			}
+			results[i] = res
+		}(i, node)
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,81 @@
+package fleet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jedarden/tunnel/internal/offline"
+)
+
+func TestRevoke(t *testing.T) {
+	var got RevokeRequest
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/keys/revoke" || r.Header.Get("Authorization") != "Bearer s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		json.NewEncoder(w).Encode(RevokeResult{
+			Node:    "its own hostname",
+			Revoked: got.Fingerprints[:1],
+			Missing: got.Fingerprints[1:],
+		})
+	}))
+	defer healthy.Close()
+
+	refusing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Forbidden"})
+	}))
+	defer refusing.Close()
+
+	nodes := []*Node{
+		{Name: "edge-1", URL: healthy.URL + "/", Token: "s3cret"},
+		{Name: "edge-2", URL: refusing.URL},
+		{Name: "edge-3", URL: "http://127.0.0.1:1"},
+	}
+	req := RevokeRequest{User: "bob", Fingerprints: []string{"SHA256:aaa", "SHA256:bbb"}, Reason: "laptop stolen"}
+	results := Revoke(context.Background(), nodes, req)
+
+	if len(results) != 3 {
+		t.Fatalf("Revoke() returned %d results, want one per node", len(results))
+	}
+	if got.User != "bob" || got.Reason != "laptop stolen" {
+		t.Errorf("node was sent %+v", got)
+	}
+	first := results[0]
+	if !first.OK() || first.Node != "edge-1" || len(first.Revoked) != 1 || len(first.Missing) != 1 {
+		t.Errorf("healthy node result = %+v", first)
+	}
+	if results[1].OK() || !strings.Contains(results[1].Error, "Forbidden (403)") || results[1].Node != "edge-2" {
+		t.Errorf("refusing node result = %+v", results[1])
+	}
+	if results[2].OK() || results[2].Node != "edge-3" {
+		t.Errorf("unreachable node result = %+v", results[2])
+	}
+}
+
+func TestRevokeOffline(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	offline.SetEnabled(true)
+	defer offline.SetEnabled(false)
+
+	_, err := (&Node{Name: "edge-1", URL: server.URL}).Revoke(context.Background(), RevokeRequest{User: "bob"})
+	if !errors.Is(err, offline.ErrOffline) {
+		t.Errorf("Revoke() error = %v, want ErrOffline", err)
+	}
+	if called {
+		t.Error("the node was contacted in offline mode")
+	}
+}
@@ -0,0 +1,69 @@
+package importer
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/jedarden/tunnel/internal/cmdtemplate"
+)
+
+// Autossh imports the autossh command lines in data, such as a shell
+// script, a crontab or the output of ps. Lines continued with a backslash
+// are joined, and anything before the autossh word is ignored.
+func Autossh(data []byte, source string) *Result {
+	result := newResult()
+	found := false
+
+	lines := strings.Split(string(data), "\n")
+	for i := 0; i < len(lines); i++ {
+		start := i + 1
+		line := strings.TrimRight(lines[i], "\r")
+		for strings.HasSuffix(line, "\\") && i+1 < len(lines) {
+			i++
+			line = strings.TrimSuffix(line, "\\") + " " + strings.TrimRight(lines[i], "\r")
+		}
+		if trimmed := strings.TrimSpace(line); trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		words, err := cmdtemplate.Split(line)
+		at := fmt.Sprintf("%s:%d", source, start)
+		if err != nil {
+			if strings.Contains(line, "autossh") {
+				result.skip(at, "can't split the command line: %v", err)
+			}
+			continue
+		}
+		argv := autosshCommand(words)
+		if argv == nil {
+			continue
+		}
+		found = true
+		result.addSSH(at, argv, true)
+	}
+
+	if !found {
+		result.skip(source, "no autossh command lines found")
+	}
+	return result
+}
+
+// autosshCommand returns the autossh invocation in words, stopping at a
+// shell operator such as & or ;
+func autosshCommand(words []string) []string {
+	for i, word := range words {
+		if filepath.Base(word) != "autossh" {
+			continue
+		}
+		argv := []string{"autossh"}
+		for _, arg := range words[i+1:] {
+			if arg == "&" || arg == "&&" || arg == ";" || arg == "|" || arg == "||" {
+				break
+			}
+			argv = append(argv, strings.TrimRight(arg, ";&"))
+		}
+		return argv
+	}
+	return nil
+}
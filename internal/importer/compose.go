@@ -0,0 +1,223 @@
+package importer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jedarden/tunnel/internal/cmdtemplate"
+	"gopkg.in/yaml.v3"
+)
+
+// composeFile is the part of a docker-compose file that is imported
+type composeFile struct {
+	Services map[string]composeService `yaml:"services"`
+}
+
+type composeService struct {
+	Image       string      `yaml:"image"`
+	Command     commandLine `yaml:"command"`
+	Entrypoint  commandLine `yaml:"entrypoint"`
+	Environment environment `yaml:"environment"`
+	Restart     string      `yaml:"restart"`
+	Volumes     []yaml.Node `yaml:"volumes"`
+}
+
+// commandLine is a command given as a string or as a list of words
+type commandLine []string
+
+func (c *commandLine) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		words, err := cmdtemplate.Split(node.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		*c = words
+		return nil
+	}
+	var words []string
+	if err := node.Decode(&words); err != nil {
+		return err
+	}
+	*c = words
+	return nil
+}
+
+// environment is a service's environment, given as a map or as a list of
+// KEY=value entries
+type environment map[string]string
+
+func (e *environment) UnmarshalYAML(node *yaml.Node) error {
+	env := make(map[string]string)
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			env[node.Content[i].Value] = node.Content[i+1].Value
+		}
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			key, value, _ := strings.Cut(item.Value, "=")
+			env[key] = value
+		}
+	default:
+		return fmt.Errorf("line %d: environment must be a map or a list", node.Line)
+	}
+	*e = env
+	return nil
+}
+
+// Compose imports the tunnel clients the services of a docker-compose file
+// run: cloudflared, ngrok, bore and autossh images, and services whose
+// command is an ssh or autossh command line. They run on the host rather
+// than in a container once imported.
+func Compose(data []byte, source string) (*Result, error) {
+	var file composeFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", source, err)
+	}
+
+	result := newResult()
+	if len(file.Services) == 0 {
+		result.skip(source, "no services")
+		return result, nil
+	}
+	for _, name := range sortedKeys(file.Services) {
+		result.composeService(source+":"+name, name, file.Services[name], file.Services)
+	}
+	return result, nil
+}
+
+// composeService adds the tunnel client service runs to r
+func (r *Result) composeService(source, name string, service composeService, all map[string]composeService) {
+	restart := service.Restart != "" && service.Restart != "no"
+	argv := append(append([]string{}, service.Entrypoint...), service.Command...)
+	image := imageName(service.Image)
+
+	var client string
+	switch {
+	case strings.HasSuffix(image, "cloudflared"):
+		client = "cloudflared"
+	case strings.HasSuffix(image, "ngrok"):
+		client = "ngrok"
+	case strings.HasSuffix(image, "bore"):
+		client = "bore"
+	case strings.HasSuffix(image, "autossh"):
+		if len(argv) == 0 {
+			r.composeAutossh(source, service, restart)
+			return
+		}
+	}
+
+	if len(argv) > 0 {
+		if base := argv[0]; base == "autossh" || base == "ssh" || strings.HasSuffix(base, "/autossh") || strings.HasSuffix(base, "/ssh") {
+			r.addSSH(source, argv, restart)
+			return
+		}
+	}
+	if client == "" {
+		what := service.Image
+		if what == "" {
+			what = "a build"
+		}
+		r.skip(source, "runs %s, which isn't a tunnel client TUNNEL knows", what)
+		return
+	}
+
+	// The image's entrypoint is the client; command holds its arguments
+	if len(argv) == 0 || !strings.HasSuffix(argv[0], client) {
+		argv = append([]string{client}, argv...)
+	}
+	env := map[string]string(service.Environment)
+	notes := []string{fmt.Sprintf("runs %s on this host instead of in the %s container", client, service.Image)}
+	for _, word := range argv {
+		if strings.Contains(word, "${") {
+			notes = append(notes, fmt.Sprintf("the command refers to a variable compose interpolated: %s", word))
+		}
+	}
+	for _, key := range sortedKeys(env) {
+		if strings.Contains(env[key], "${") {
+			notes = append(notes, fmt.Sprintf("%s refers to a variable compose interpolated; set its value in the script's env", key))
+		}
+	}
+	for _, other := range sortedKeys(all) {
+		if other != name && mentions(argv, other) {
+			notes = append(notes, fmt.Sprintf("the command points at the %s service, which only resolves inside the compose network", other))
+		}
+	}
+	if len(env) == 0 {
+		env = nil
+	}
+
+	sc, clientNotes, _ := clientScript(argv, env, restart)
+	r.addScript(source, name, sc, append(notes, clientNotes...))
+}
+
+// composeAutossh imports a jnovack/autossh style service, which is
+// configured entirely by environment variables
+func (r *Result) composeAutossh(source string, service composeService, restart bool) {
+	env := service.Environment
+	host := env["SSH_REMOTE_HOST"]
+	if host == "" {
+		r.skip(source, "the autossh service sets no SSH_REMOTE_HOST")
+		return
+	}
+	get := func(key, fallback string) string {
+		if v := env[key]; v != "" {
+			return v
+		}
+		return fallback
+	}
+
+	mode := get("SSH_MODE", "-R")
+	if mode != "-R" && mode != "-L" {
+		r.skip(source, "unknown SSH_MODE %s", mode)
+		return
+	}
+	argv := []string{
+		"ssh", "-N",
+		mode, fmt.Sprintf("%s:%s:%s", get("SSH_TUNNEL_PORT", "2222"), get("SSH_TARGET_HOST", "localhost"), get("SSH_TARGET_PORT", "22")),
+		"-p", get("SSH_REMOTE_PORT", "22"),
+	}
+	if key := mountedKey(service.Volumes); key != "" {
+		argv = append(argv, "-i", key)
+	}
+	if user := env["SSH_REMOTE_USER"]; user != "" {
+		host = user + "@" + host
+	}
+	argv = append(argv, host)
+	r.addSSH(source, argv, restart)
+}
+
+// mountedKey returns the host path of the private key an autossh service
+// mounts at /id_rsa
+func mountedKey(volumes []yaml.Node) string {
+	for _, volume := range volumes {
+		if volume.Kind != yaml.ScalarNode {
+			continue
+		}
+		parts := strings.Split(volume.Value, ":")
+		if len(parts) >= 2 && parts[1] == "/id_rsa" {
+			return parts[0]
+		}
+	}
+	return ""
+}
+
+// imageName returns the repository of an image reference, without its
+// tag or digest: cloudflare/cloudflared:latest is cloudflare/cloudflared
+func imageName(image string) string {
+	image, _, _ = strings.Cut(image, "@")
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return strings.ToLower(image)
+}
+
+// mentions reports whether any word of argv refers to host
+func mentions(argv []string, host string) bool {
+	for _, word := range argv {
+		if word == host || strings.Contains(word, "://"+host) || strings.HasPrefix(word, host+":") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,253 @@
+// Package importer converts tunnels defined for other tools (autossh
+// command lines, systemd units and docker-compose services) into TUNNEL
+// config. A definition the reverse-ssh provider can run becomes its method
+// settings; any other tunnel client becomes a script provider running the
+// same command, and whatever can't be mapped is reported with the reason.
+package importer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jedarden/tunnel/internal/providers"
+	"github.com/jedarden/tunnel/pkg/config"
+)
+
+// Kind names a tool whose tunnel definitions can be imported
+type Kind string
+
+const (
+	KindAutossh Kind = "autossh"
+	KindSystemd Kind = "systemd"
+	KindCompose Kind = "compose"
+)
+
+// Kinds lists the tools definitions can be imported from
+var Kinds = []Kind{KindAutossh, KindSystemd, KindCompose}
+
+// ParseKind checks that s names a tool definitions can be imported from
+func ParseKind(s string) (Kind, error) {
+	for _, k := range Kinds {
+		if string(k) == s {
+			return k, nil
+		}
+	}
+	return "", fmt.Errorf("unknown import source %q: expected autossh, systemd or compose", s)
+}
+
+// Entry types
+const (
+	TypeMethod = "method"
+	TypeScript = "script"
+)
+
+// Entry is a definition that was imported
+type Entry struct {
+	Source string   `json:"source"` // file and line, unit or service it came from
+	Type   string   `json:"type"`   // method or script
+	Name   string   `json:"name"`   // provider or script name in the config
+	Notes  []string `json:"notes,omitempty"`
+}
+
+// Skip is a definition that couldn't be imported
+type Skip struct {
+	Source string `json:"source"`
+	Reason string `json:"reason"`
+}
+
+// Result is the config converted from one or more definitions
+type Result struct {
+	Methods  map[string]config.MethodConfig
+	Scripts  map[string]config.ScriptConfig
+	Imported []Entry
+	Skipped  []Skip
+}
+
+func newResult() *Result {
+	return &Result{
+		Methods: make(map[string]config.MethodConfig),
+		Scripts: make(map[string]config.ScriptConfig),
+	}
+}
+
+// File imports the definitions of kind in path. A systemd path may be a
+// directory of units, and an autossh path of "-" reads standard input.
+func File(kind Kind, path string) (*Result, error) {
+	switch kind {
+	case KindAutossh:
+		data, err := readInput(path)
+		if err != nil {
+			return nil, err
+		}
+		return Autossh(data, sourceName(path)), nil
+
+	case KindSystemd:
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			return Systemd(data, filepath.Base(path)), nil
+		}
+		units, err := filepath.Glob(filepath.Join(path, "*.service"))
+		if err != nil {
+			return nil, err
+		}
+		result := newResult()
+		for _, unit := range units {
+			data, err := os.ReadFile(unit)
+			if err != nil {
+				return nil, err
+			}
+			result.systemdUnit(data, filepath.Base(unit))
+		}
+		return result, nil
+
+	case KindCompose:
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return Compose(data, filepath.Base(path))
+
+	default:
+		return nil, fmt.Errorf("unknown import source %q", kind)
+	}
+}
+
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+func sourceName(path string) string {
+	if path == "-" {
+		return "stdin"
+	}
+	return filepath.Base(path)
+}
+
+// addScript records sc under a free name derived from name
+func (r *Result) addScript(source, name string, sc config.ScriptConfig, notes []string) {
+	name = r.uniqueName(name)
+	r.Scripts[name] = sc
+	r.Imported = append(r.Imported, Entry{Source: source, Type: TypeScript, Name: name, Notes: notes})
+}
+
+// addMethod records settings for the built-in provider name. A provider
+// has one method, so it reports false if an earlier definition took it.
+func (r *Result) addMethod(source, name string, settings map[string]string, notes []string) bool {
+	if _, taken := r.Methods[name]; taken {
+		return false
+	}
+	r.Methods[name] = config.MethodConfig{Enabled: true, Settings: settings}
+	r.Imported = append(r.Imported, Entry{Source: source, Type: TypeMethod, Name: name, Notes: notes})
+	return true
+}
+
+func (r *Result) skip(source, format string, args ...interface{}) {
+	r.Skipped = append(r.Skipped, Skip{Source: source, Reason: fmt.Sprintf(format, args...)})
+}
+
+// uniqueName returns name, or name with a number appended, such that it
+// is neither a built-in provider nor a script already in r
+func (r *Result) uniqueName(name string) string {
+	name = sanitizeName(name)
+	if _, builtin := providers.LookupIdentity(name); builtin {
+		name = "imported-" + name
+	}
+	candidate := name
+	for i := 2; ; i++ {
+		if _, taken := r.Scripts[candidate]; !taken {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s-%d", name, i)
+	}
+}
+
+var nameSeparators = regexp.MustCompile(`[^a-z0-9]+`)
+
+// sanitizeName lowercases s and replaces everything but letters and
+// digits with dashes
+func sanitizeName(s string) string {
+	name := strings.Trim(nameSeparators.ReplaceAllString(strings.ToLower(s), "-"), "-")
+	if name == "" {
+		return "imported"
+	}
+	return name
+}
+
+var safeWord = regexp.MustCompile(`^[A-Za-z0-9@%+=:,./_-]+$`)
+
+// joinCommand turns argv back into a command template for a script
+// provider, quoting words a shell would split and escaping template
+// delimiters
+func joinCommand(argv []string) string {
+	words := make([]string, len(argv))
+	for i, word := range argv {
+		if !safeWord.MatchString(word) {
+			word = "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+		}
+		words[i] = strings.ReplaceAll(word, "{{", `{{"{{"}}`)
+	}
+	return strings.Join(words, " ")
+}
+
+// clientScript builds a script provider for a tunnel client command other
+// than ssh, reading its endpoint from the output where the client prints it
+func clientScript(argv []string, env map[string]string, restart bool) (config.ScriptConfig, []string, bool) {
+	sc := config.ScriptConfig{
+		Connect:  joinCommand(argv),
+		Category: string(providers.CategoryTunnel),
+		Env:      env,
+		Restart:  restart,
+	}
+	var notes []string
+	switch filepath.Base(argv[0]) {
+	case "cloudflared":
+		if contains(argv, "--url") {
+			sc.Endpoint = `https://[a-z0-9-]+\.trycloudflare\.com`
+		}
+	case "ngrok":
+		if contains(argv, "--log") {
+			sc.Endpoint = `url=(\S+)`
+		} else {
+			notes = append(notes, "add --log stdout to the connect command so the endpoint can be read")
+		}
+	case "bore":
+		sc.Endpoint = `listening at (\S+)`
+	default:
+		return sc, nil, false
+	}
+	return sc, notes, true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s || strings.HasPrefix(v, s+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,216 @@
+package importer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jedarden/tunnel/internal/cmdtemplate"
+)
+
+func TestAutosshReverseBecomesMethod(t *testing.T) {
+	data := []byte(`#!/bin/sh
+autossh -M 0 -f -N -R 2222:localhost:22 -p 2200 \
+  -o ServerAliveInterval=30 -i ~/.ssh/relay tunnel@jump.example.com
+autossh -M 20000 -N -L 5432:db.internal:5432 admin@bastion.example.com &
+`)
+	result := Autossh(data, "tunnels.sh")
+
+	if len(result.Imported) != 2 {
+		t.Fatalf("imported %d, want 2: %+v (skipped %+v)", len(result.Imported), result.Imported, result.Skipped)
+	}
+	method, ok := result.Methods["reverse-ssh"]
+	if !ok {
+		t.Fatalf("no reverse-ssh method in %+v", result.Methods)
+	}
+	want := map[string]string{
+		"relayServer":       "jump.example.com",
+		"relayPort":         "2200",
+		"relayUsername":     "tunnel",
+		"identityFile":      "~/.ssh/relay",
+		"remotePort":        "2222",
+		"localHost":         "localhost",
+		"localPort":         "22",
+		"keepaliveInterval": "30",
+	}
+	for key, value := range want {
+		if method.Settings[key] != value {
+			t.Errorf("settings[%s] = %q, want %q", key, method.Settings[key], value)
+		}
+	}
+	if result.Imported[0].Source != "tunnels.sh:2" {
+		t.Errorf("source = %q, want tunnels.sh:2", result.Imported[0].Source)
+	}
+
+	local := result.Imported[1]
+	if local.Type != TypeScript || local.Name != "ssh-bastion-example-com-5432" {
+		t.Fatalf("local forward imported as %s %s", local.Type, local.Name)
+	}
+	sc := result.Scripts[local.Name]
+	if sc.Connect != "ssh -N -L 5432:db.internal:5432 admin@bastion.example.com" {
+		t.Errorf("connect = %q", sc.Connect)
+	}
+	if !sc.Restart {
+		t.Error("autossh tunnels should restart")
+	}
+	if !strings.Contains(strings.Join(local.Notes, "\n"), "-M 20000") {
+		t.Errorf("notes %q don't mention the dropped monitor port", local.Notes)
+	}
+}
+
+func TestAutosshSecondReverseBecomesScript(t *testing.T) {
+	data := []byte("autossh -M 0 -N -R 2222:localhost:22 a.example.com\nautossh -M 0 -N -R 2223:localhost:22 b.example.com\n")
+	result := Autossh(data, "stdin")
+	if len(result.Methods) != 1 || len(result.Scripts) != 1 {
+		t.Fatalf("methods %d, scripts %d, want 1 each", len(result.Methods), len(result.Scripts))
+	}
+	if _, ok := result.Scripts["ssh-b-example-com-2223"]; !ok {
+		t.Errorf("scripts = %v", sortedKeys(result.Scripts))
+	}
+}
+
+func TestAutosshNothingFound(t *testing.T) {
+	result := Autossh([]byte("echo hello\n"), "x.sh")
+	if len(result.Imported) != 0 || len(result.Skipped) != 1 {
+		t.Errorf("imported %+v, skipped %+v", result.Imported, result.Skipped)
+	}
+}
+
+func TestSystemdCloudflared(t *testing.T) {
+	unit := []byte(`[Unit]
+Description=cloudflared quick tunnel
+
+[Service]
+User=cloudflared
+Environment="TUNNEL_LOGLEVEL=info" NO_AUTOUPDATE=true
+ExecStart=/usr/local/bin/cloudflared tunnel --url http://localhost:8080
+Restart=on-failure
+`)
+	result := Systemd(unit, "cloudflared.service")
+	if len(result.Imported) != 1 {
+		t.Fatalf("imported %+v, skipped %+v", result.Imported, result.Skipped)
+	}
+	entry := result.Imported[0]
+	if entry.Name != "imported-cloudflared" {
+		t.Errorf("name = %q, want imported-cloudflared, clear of the built-in provider", entry.Name)
+	}
+	sc := result.Scripts[entry.Name]
+	if sc.Connect != "/usr/local/bin/cloudflared tunnel --url http://localhost:8080" || !sc.Restart || sc.Endpoint == "" {
+		t.Errorf("script = %+v", sc)
+	}
+	if sc.Env["TUNNEL_LOGLEVEL"] != "info" || sc.Env["NO_AUTOUPDATE"] != "true" {
+		t.Errorf("env = %v", sc.Env)
+	}
+}
+
+func TestSystemdSkips(t *testing.T) {
+	tests := map[string]string{
+		"no exec":   "[Service]\nType=simple\n",
+		"specifier": "[Service]\nExecStart=/usr/bin/autossh -N -R 2222:localhost:22 %i\n",
+		"unknown":   "[Service]\nExecStart=/usr/sbin/nginx\n",
+	}
+	for name, unit := range tests {
+		t.Run(name, func(t *testing.T) {
+			result := Systemd([]byte(unit), name+".service")
+			if len(result.Imported) != 0 || len(result.Skipped) != 1 {
+				t.Errorf("imported %+v, skipped %+v", result.Imported, result.Skipped)
+			}
+		})
+	}
+}
+
+func TestSystemdDirectory(t *testing.T) {
+	dir := t.TempDir()
+	units := map[string]string{
+		"relay.service": "[Service]\nExecStart=/usr/bin/ssh -N -R 2222:localhost:22 relay.example.com\nRestart=always\n",
+		"web.service":   "[Service]\nExecStart=/usr/bin/python3 -m http.server\n",
+	}
+	for name, unit := range units {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(unit), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err := File(KindSystemd, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Imported) != 1 || result.Imported[0].Type != TypeMethod || len(result.Skipped) != 1 {
+		t.Errorf("imported %+v, skipped %+v", result.Imported, result.Skipped)
+	}
+}
+
+func TestCompose(t *testing.T) {
+	data := []byte(`services:
+  web:
+    image: nginx:1.27
+  tunnel:
+    image: cloudflare/cloudflared:latest
+    command: tunnel --no-autoupdate run --token ${CF_TOKEN}
+    restart: unless-stopped
+  ngrok:
+    image: ngrok/ngrok
+    command: ["http", "web:80"]
+    environment:
+      NGROK_AUTHTOKEN: abc123
+  relay:
+    image: jnovack/autossh
+    environment:
+      - SSH_REMOTE_USER=tunnel
+      - SSH_REMOTE_HOST=jump.example.com
+      - SSH_TUNNEL_PORT=2022
+    volumes:
+      - /home/me/.ssh/id_rsa:/id_rsa
+`)
+	result, err := Compose(data, "docker-compose.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Imported) != 3 || len(result.Skipped) != 1 {
+		t.Fatalf("imported %+v, skipped %+v", result.Imported, result.Skipped)
+	}
+
+	ngrok := result.Scripts["imported-ngrok"]
+	if ngrok.Connect != "ngrok http web:80" || ngrok.Env["NGROK_AUTHTOKEN"] != "abc123" {
+		t.Errorf("ngrok script = %+v", ngrok)
+	}
+	tunnel := result.Scripts["tunnel"]
+	if tunnel.Connect != "cloudflared tunnel --no-autoupdate run --token '${CF_TOKEN}'" || !tunnel.Restart {
+		t.Errorf("cloudflared script = %+v", tunnel)
+	}
+
+	relay := result.Methods["reverse-ssh"].Settings
+	if relay["relayServer"] != "jump.example.com" || relay["remotePort"] != "2022" || relay["identityFile"] != "/home/me/.ssh/id_rsa" {
+		t.Errorf("relay settings = %v", relay)
+	}
+
+	var notes []string
+	for _, entry := range result.Imported {
+		notes = append(notes, entry.Notes...)
+	}
+	joined := strings.Join(notes, "\n")
+	if !strings.Contains(joined, "web service") || !strings.Contains(joined, "${CF_TOKEN}") {
+		t.Errorf("notes = %s", joined)
+	}
+}
+
+func TestJoinCommand(t *testing.T) {
+	argv := []string{"ssh", "-o", "ProxyCommand=nc %h %p", "it's", "{{x}}"}
+	got := joinCommand(argv)
+	want := `ssh -o 'ProxyCommand=nc %h %p' 'it'\''s' '{{"{{"}}x}}'`
+	if got != want {
+		t.Errorf("joinCommand() = %s, want %s", got, want)
+	}
+
+	tmpl, err := cmdtemplate.Parse(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendered, err := tmpl.Render(cmdtemplate.Data{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(rendered, "|") != strings.Join(argv, "|") {
+		t.Errorf("rendered %q, want %q", rendered, argv)
+	}
+}
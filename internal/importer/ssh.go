@@ -0,0 +1,281 @@
+package importer
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jedarden/tunnel/internal/providers"
+	"github.com/jedarden/tunnel/pkg/config"
+)
+
+// sshArgFlags are the ssh options that take an argument
+const sshArgFlags = "BbcDEeFIiJLlmOoPpQRSWw"
+
+// sshCommand is an ssh or autossh invocation taken apart
+type sshCommand struct {
+	argv        []string // ssh command line without autossh's options or backgrounding
+	destination string
+	user        string
+	host        string
+	port        string
+	identity    string
+	jump        string
+	remote      []string          // -R forwards
+	local       []string          // -L forwards
+	dynamic     []string          // -D forwards
+	options     map[string]string // -o options, by lowercased name
+	flags       string            // option letters without arguments, such as N or C
+	command     []string          // remote command
+	dropped     []string          // options left out of argv, and why
+}
+
+// parseSSH takes apart argv, an ssh or autossh command line
+func parseSSH(argv []string) (*sshCommand, error) {
+	autossh := filepath.Base(argv[0]) == "autossh"
+	cmd := &sshCommand{argv: []string{"ssh"}, options: make(map[string]string)}
+
+	args := argv[1:]
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			i++
+			if i < len(args) {
+				cmd.destination = args[i]
+				cmd.command = args[i+1:]
+			}
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			cmd.destination = arg
+			cmd.command = args[i+1:]
+			break
+		}
+
+		cluster := arg[1:]
+		for j := 0; j < len(cluster); j++ {
+			c := cluster[j]
+			takesArg := strings.IndexByte(sshArgFlags, c) >= 0 || (autossh && c == 'M')
+			if !takesArg {
+				switch {
+				case c == 'f' && autossh:
+					cmd.dropped = append(cmd.dropped, "-f: TUNNEL supervises the command instead of backgrounding it")
+				case c == 'f':
+					cmd.dropped = append(cmd.dropped, "-f: TUNNEL supervises ssh instead of backgrounding it")
+				default:
+					cmd.flags += string(c)
+					cmd.argv = append(cmd.argv, "-"+string(c))
+				}
+				continue
+			}
+
+			value := cluster[j+1:]
+			if value == "" {
+				i++
+				if i >= len(args) {
+					return nil, fmt.Errorf("option -%c needs an argument", c)
+				}
+				value = args[i]
+			}
+			if autossh && c == 'M' {
+				cmd.dropped = append(cmd.dropped, "-M "+value+": TUNNEL restarts ssh when it exits, and ssh keepalives replace the monitor port")
+				break
+			}
+			cmd.argv = append(cmd.argv, "-"+string(c), value)
+			switch c {
+			case 'R':
+				cmd.remote = append(cmd.remote, value)
+			case 'L':
+				cmd.local = append(cmd.local, value)
+			case 'D':
+				cmd.dynamic = append(cmd.dynamic, value)
+			case 'p':
+				cmd.port = value
+			case 'l':
+				cmd.user = value
+			case 'i':
+				cmd.identity = value
+			case 'J':
+				cmd.jump = value
+			case 'o':
+				key, val, ok := strings.Cut(value, "=")
+				if !ok {
+					key, val, _ = strings.Cut(value, " ")
+				}
+				cmd.options[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(val)
+			}
+			break
+		}
+	}
+
+	if cmd.destination == "" {
+		return nil, fmt.Errorf("no destination host")
+	}
+	cmd.argv = append(cmd.argv, cmd.destination)
+	cmd.argv = append(cmd.argv, cmd.command...)
+	if err := cmd.parseDestination(); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// parseDestination splits [user@]host or ssh://[user@]host[:port]
+func (c *sshCommand) parseDestination() error {
+	dest := c.destination
+	if strings.HasPrefix(dest, "ssh://") {
+		u, err := url.Parse(dest)
+		if err != nil {
+			return fmt.Errorf("invalid destination %q: %w", dest, err)
+		}
+		c.host = u.Hostname()
+		if u.Port() != "" {
+			c.port = u.Port()
+		}
+		if u.User != nil {
+			c.user = u.User.Username()
+		}
+		return nil
+	}
+	if user, host, ok := strings.Cut(dest, "@"); ok {
+		c.user, dest = user, host
+	}
+	c.host = dest
+	return nil
+}
+
+// reverseOptions are the -o options the reverse-ssh provider sets itself
+// or has settings for
+var reverseOptions = map[string]string{
+	"serveraliveinterval":   "keepaliveInterval",
+	"serveralivecountmax":   "keepaliveCountMax",
+	"stricthostkeychecking": "strictHostKeyChecking",
+	"userknownhostsfile":    "knownHostsFile",
+	"identityfile":          "identityFile",
+	"port":                  "relayPort",
+	"user":                  "relayUsername",
+	"exitonforwardfailure":  "",
+	"batchmode":             "",
+	"identitiesonly":        "",
+}
+
+// reverseSettings returns the reverse-ssh method settings for c, or why
+// the provider can't run it as is
+func (c *sshCommand) reverseSettings() (map[string]string, string) {
+	switch {
+	case len(c.remote) != 1 || len(c.local) > 0 || len(c.dynamic) > 0:
+		return nil, "it doesn't forward exactly one remote port"
+	case len(c.command) > 0:
+		return nil, "it runs a remote command"
+	case c.jump != "":
+		return nil, "it goes through a jump host"
+	}
+	for _, flag := range c.flags {
+		if !strings.ContainsRune("NTnq", flag) {
+			return nil, fmt.Sprintf("it uses option -%c", flag)
+		}
+	}
+
+	parts := strings.Split(c.remote[0], ":")
+	if len(parts) != 3 {
+		return nil, fmt.Sprintf("forward %s sets a bind address or isn't port:host:port", c.remote[0])
+	}
+	for _, port := range []string{parts[0], parts[2]} {
+		if _, err := strconv.Atoi(port); err != nil {
+			return nil, fmt.Sprintf("forward %s isn't port:host:port", c.remote[0])
+		}
+	}
+
+	settings := map[string]string{
+		"relayServer": c.host,
+		"remotePort":  parts[0],
+		"localHost":   parts[1],
+		"localPort":   parts[2],
+	}
+	for option, value := range c.options {
+		key, ok := reverseOptions[option]
+		if !ok {
+			return nil, fmt.Sprintf("it sets ssh option %s", option)
+		}
+		if key != "" {
+			settings[key] = value
+		}
+	}
+	if v, ok := settings["strictHostKeyChecking"]; ok {
+		settings["strictHostKeyChecking"] = strings.ToLower(v)
+		switch settings["strictHostKeyChecking"] {
+		case "yes", "no", "accept-new":
+		default:
+			return nil, fmt.Sprintf("it sets StrictHostKeyChecking=%s", v)
+		}
+	}
+	if c.port != "" {
+		settings["relayPort"] = c.port
+	}
+	if c.user != "" {
+		settings["relayUsername"] = c.user
+	}
+	if c.identity != "" {
+		settings["identityFile"] = c.identity
+	}
+	for _, key := range []string{"relayPort", "keepaliveInterval", "keepaliveCountMax"} {
+		if v, ok := settings[key]; ok {
+			if _, err := strconv.Atoi(v); err != nil {
+				return nil, fmt.Sprintf("%s %q isn't a number", key, v)
+			}
+		}
+	}
+	return settings, ""
+}
+
+// script returns a script provider running c under TUNNEL's supervision
+func (c *sshCommand) script(restart bool) (config.ScriptConfig, []string) {
+	argv := c.argv
+	var notes []string
+	if len(c.command) == 0 && !strings.ContainsRune(c.flags, 'N') {
+		// Without a terminal, ssh waiting on a shell would exit at once
+		argv = append([]string{"ssh", "-N"}, argv[1:]...)
+		notes = append(notes, "added -N so ssh only forwards ports")
+	}
+	return config.ScriptConfig{
+		Connect:  joinCommand(argv),
+		Category: string(providers.CategorySSH),
+		Restart:  restart,
+	}, notes
+}
+
+// name suggests a script name for c from its host and first forward
+func (c *sshCommand) name() string {
+	name := "ssh-" + c.host
+	for _, forwards := range [][]string{c.remote, c.local, c.dynamic} {
+		if len(forwards) > 0 {
+			parts := strings.Split(forwards[0], ":")
+			return name + "-" + parts[0]
+		}
+	}
+	return name
+}
+
+// addSSH imports an ssh or autossh command line found at source: as the
+// reverse-ssh method if that provider can run it, and as a script
+// otherwise. restart says whether the original was restarted on exit.
+func (r *Result) addSSH(source string, argv []string, restart bool) {
+	cmd, err := parseSSH(argv)
+	if err != nil {
+		r.skip(source, "can't parse the ssh command line: %v", err)
+		return
+	}
+
+	settings, reason := cmd.reverseSettings()
+	if settings != nil && r.addMethod(source, "reverse-ssh", settings, cmd.dropped) {
+		return
+	}
+	if settings != nil {
+		reason = "the reverse-ssh method was taken by an earlier definition"
+	}
+
+	sc, notes := cmd.script(restart)
+	notes = append(append(cmd.dropped, notes...), "runs as a script because "+reason)
+	r.addScript(source, cmd.name(), sc, notes)
+}
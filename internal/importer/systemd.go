@@ -0,0 +1,141 @@
+package importer
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/jedarden/tunnel/internal/cmdtemplate"
+)
+
+// Systemd imports the tunnel client a systemd service unit runs
+func Systemd(data []byte, source string) *Result {
+	result := newResult()
+	result.systemdUnit(data, source)
+	return result
+}
+
+// systemdUnit adds the service in the unit file data, named source, to r
+func (r *Result) systemdUnit(data []byte, source string) {
+	unit := parseUnit(string(data))
+	service := unit["service"]
+	execStart := service["execstart"]
+	if len(execStart) == 0 || execStart[len(execStart)-1] == "" {
+		r.skip(source, "no ExecStart in the [Service] section")
+		return
+	}
+	if len(execStart) > 1 {
+		r.skip(source, "more than one ExecStart")
+		return
+	}
+
+	line := strings.TrimLeft(execStart[0], "-@:+!")
+	if strings.Contains(line, "%") {
+		r.skip(source, "ExecStart uses unit specifiers such as %%i, which only systemd can fill in")
+		return
+	}
+	argv, err := cmdtemplate.Split(line)
+	if err != nil || len(argv) == 0 {
+		r.skip(source, "can't split ExecStart: %v", err)
+		return
+	}
+
+	restart := false
+	if policy := last(service["restart"]); policy != "" && policy != "no" {
+		restart = true
+	}
+
+	var notes []string
+	if user := last(service["user"]); user != "" {
+		notes = append(notes, "ran as "+user+"; the script runs as whoever runs tunnel")
+	}
+	if len(service["environmentfile"]) > 0 {
+		notes = append(notes, "EnvironmentFile was not imported; copy its variables into the script's env")
+	}
+	env := make(map[string]string)
+	for _, assignments := range service["environment"] {
+		words, err := cmdtemplate.Split(assignments)
+		if err != nil {
+			notes = append(notes, "skipped Environment="+assignments)
+			continue
+		}
+		for _, word := range words {
+			if key, value, ok := strings.Cut(word, "="); ok {
+				env[key] = value
+			}
+		}
+	}
+
+	name := strings.TrimSuffix(source, ".service")
+	switch filepath.Base(argv[0]) {
+	case "autossh", "ssh":
+		if len(env) > 0 {
+			notes = append(notes, "Environment was not imported for ssh")
+		}
+		before := len(r.Imported)
+		r.addSSH(source, argv, restart)
+		if len(r.Imported) > before {
+			entry := &r.Imported[len(r.Imported)-1]
+			entry.Notes = append(entry.Notes, notes...)
+		}
+	default:
+		if len(env) == 0 {
+			env = nil
+		}
+		sc, clientNotes, ok := clientScript(argv, env, restart)
+		if !ok {
+			r.skip(source, "ExecStart runs %s, which isn't a tunnel client TUNNEL knows", filepath.Base(argv[0]))
+			return
+		}
+		r.addScript(source, name, sc, append(notes, clientNotes...))
+	}
+}
+
+// parseUnit reads a systemd unit file into its settings, by lowercased
+// section and key, with every value each key was given in order. An empty
+// assignment resets the list, as it does for systemd.
+func parseUnit(data string) map[string]map[string][]string {
+	unit := make(map[string]map[string][]string)
+	section := ""
+
+	lines := strings.Split(data, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		for strings.HasSuffix(line, "\\") && i+1 < len(lines) {
+			i++
+			line = strings.TrimSuffix(line, "\\") + " " + strings.TrimSpace(lines[i])
+		}
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+			continue
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			section = strings.ToLower(strings.Trim(line, "[]"))
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		if unit[section] == nil {
+			unit[section] = make(map[string][]string)
+		}
+		if value == "" {
+			unit[section][key] = []string{""}
+			continue
+		}
+		if values := unit[section][key]; len(values) == 1 && values[0] == "" {
+			unit[section][key] = nil
+		}
+		unit[section][key] = append(unit[section][key], value)
+	}
+	return unit
+}
+
+func last(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[len(values)-1]
+}
@@ -0,0 +1,315 @@
+package installer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/jedarden/tunnel/internal/offline"
+)
+
+func TestStore(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), "installed.json"))
+
+	if list, err := store.List(); err != nil || len(list) != 0 {
+		t.Fatalf("List() on missing file = %v, %v", list, err)
+	}
+
+	for _, name := range []string{"tailscale", "bore"} {
+		if err := store.Put(Record{Provider: name, Version: "1.0.0"}); err != nil {
+			t.Fatalf("Put(%s) error = %v", name, err)
+		}
+	}
+	list, err := store.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(list) != 2 || list[0].Provider != "bore" {
+		t.Errorf("List() = %+v, want bore and tailscale sorted", list)
+	}
+
+	if err := store.Remove("bore"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if _, ok, _ := store.Get("bore"); ok {
+		t.Error("bore still recorded after Remove()")
+	}
+	if r, ok, _ := store.Get("tailscale"); !ok || r.Version != "1.0.0" {
+		t.Errorf("Get(tailscale) = %+v, %v", r, ok)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"0.5.1", "v0.5.1", 0},
+		{"1.66.4", "1.8.0", 1},
+		{"2024.6.1", "2024.10.0", -1},
+		{"1.2", "1.2.0", 0},
+		{"bore-cli 0.5.0", "0.5.1", -1},
+	}
+	for _, tt := range tests {
+		if got := CompareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestGitHubReleases(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/ekzhang/bore/releases" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`[
+			{"tag_name": "v0.6.0-rc1", "prerelease": true},
+			{"tag_name": "v0.5.2", "body": "Fix reconnects\r\n", "html_url": "https://example.com/0.5.2", "published_at": "2024-08-01T00:00:00Z"},
+			{"tag_name": "v0.5.1", "draft": true}
+		]`))
+	}))
+	defer server.Close()
+
+	g := &GitHub{BaseURL: server.URL, Client: server.Client()}
+	releases, err := g.Releases(context.Background(), "ekzhang/bore")
+	if err != nil {
+		t.Fatalf("Releases() error = %v", err)
+	}
+	if len(releases) != 1 || releases[0].Version != "0.5.2" || releases[0].Notes != "Fix reconnects" {
+		t.Errorf("Releases() = %+v, want only 0.5.2", releases)
+	}
+
+	offline.SetEnabled(true)
+	defer offline.SetEnabled(false)
+	if _, err := g.Releases(context.Background(), "ekzhang/bore"); !errors.Is(err, offline.ErrOffline) {
+		t.Errorf("Releases() while offline error = %v, want ErrOffline", err)
+	}
+}
+
+type fakeReleases map[string][]Release
+
+func (f fakeReleases) Releases(ctx context.Context, repo string) ([]Release, error) {
+	return f[repo], nil
+}
+
+func TestCheck(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), "installed.json"))
+	store.Put(Record{Provider: "bore", Version: "0.5.0"})
+	store.Put(Record{Provider: "cloudflare", Version: "2024.6.1"})
+	store.Put(Record{Provider: "ngrok", Version: "3.0.0"}) // no release source
+
+	checker := &Checker{
+		Store: store,
+		Releases: fakeReleases{
+			"ekzhang/bore":           {{Version: "0.5.2"}, {Version: "0.5.1"}, {Version: "0.5.0"}},
+			"cloudflare/cloudflared": {{Version: "2024.8.0"}},
+		},
+		Pins: map[string]string{"cloudflare": "2024.6.1"},
+	}
+
+	updates, err := checker.Check(context.Background())
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if len(updates) != 2 {
+		t.Fatalf("Check() = %+v, want bore and cloudflare", updates)
+	}
+
+	bore := updates[0]
+	if bore.Latest != "0.5.2" || len(bore.Changelog) != 2 || !bore.Available() {
+		t.Errorf("bore update = %+v, want 0.5.1 and 0.5.2 in the changelog", bore)
+	}
+	if cf := updates[1]; cf.Available() || cf.Pinned != "2024.6.1" {
+		t.Errorf("cloudflare update = %+v, want held by its pin", cf)
+	}
+
+	if _, err := checker.Check(context.Background(), "tailscale"); err == nil {
+		t.Error("Check(tailscale) should fail for a provider tunnel did not install")
+	}
+}
+
+type failingReleases struct{}
+
+func (failingReleases) Releases(ctx context.Context, repo string) ([]Release, error) {
+	return nil, errors.New("rate limited")
+}
+
+func TestCheckUnknownVersion(t *testing.T) {
+	t.Setenv("PATH", t.TempDir()) // no bore binary to ask for its version
+	store := NewStore(filepath.Join(t.TempDir(), "installed.json"))
+	store.Put(Record{Provider: "bore"})
+	store.Put(Record{Provider: "tailscale", Version: "1.66.4"})
+
+	checker := &Checker{
+		Store: store,
+		Releases: fakeReleases{
+			"ekzhang/bore":        {{Version: "0.5.2"}, {Version: "0.5.1"}},
+			"tailscale/tailscale": {{Version: "1.66.4"}},
+		},
+	}
+	updates, err := checker.Check(context.Background(), "bore", "tailscale")
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if len(updates) != 2 {
+		t.Fatalf("Check() = %+v, want bore and tailscale", updates)
+	}
+	if bore := updates[0]; bore.Installed != "" || len(bore.Changelog) != 1 || bore.Changelog[0].Version != "0.5.2" {
+		t.Errorf("bore update = %+v, want only the latest release", bore)
+	}
+	if ts := updates[1]; ts.Available() || ts.Latest != "1.66.4" {
+		t.Errorf("tailscale update = %+v, want up to date", ts)
+	}
+
+	checker.Releases = failingReleases{}
+	if _, err := checker.Check(context.Background()); err == nil {
+		t.Error("Check() should fail when releases can't be listed")
+	}
+}
+
+// fakeProvider installs a script that reports version, or one that fails
+// when version is empty
+type fakeProvider struct {
+	path    string
+	version string
+}
+
+func (f *fakeProvider) Name() string     { return "fake" }
+func (f *fakeProvider) Uninstall() error { return os.Remove(f.path) }
+func (f *fakeProvider) Install() error {
+	if f.version == "" {
+		return os.WriteFile(f.path, []byte("#!/bin/sh\nexit 1\n"), 0755)
+	}
+	return os.WriteFile(f.path, []byte("#!/bin/sh\necho fake "+f.version+"\n"), 0755)
+}
+
+// versionCanary fails unless the installed binary reports a version, the
+// first thing the canary connection checks
+func versionCanary() error {
+	if DetectVersion("fake") == "" {
+		return errors.New("new binary does not report a version")
+	}
+	return nil
+}
+
+func TestUpgradeRollsBackFailedCanary(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as the provider binary")
+	}
+	bin := t.TempDir()
+	t.Setenv("PATH", bin)
+	Sources["fake"] = Source{Version: []string{"fake-bin", "--version"}}
+	defer delete(Sources, "fake")
+
+	p := &fakeProvider{path: filepath.Join(bin, "fake-bin"), version: "1.0.0"}
+	if err := p.Install(); err != nil {
+		t.Fatal(err)
+	}
+	store := NewStore(filepath.Join(t.TempDir(), "installed.json"))
+	if err := RecordInstall(store, "fake"); err != nil {
+		t.Fatal(err)
+	}
+
+	p.version = "2.0.0"
+	_, err := Upgrade(store, p, func() error { return errors.New("connection refused") })
+	if !errors.Is(err, ErrCanaryFailed) {
+		t.Fatalf("Upgrade() error = %v, want ErrCanaryFailed", err)
+	}
+	if v := DetectVersion("fake"); v != "1.0.0" {
+		t.Errorf("version after rollback = %q, want 1.0.0", v)
+	}
+	if r, _, _ := store.Get("fake"); r.Version != "1.0.0" {
+		t.Errorf("recorded version = %q, want 1.0.0", r.Version)
+	}
+
+	record, err := Upgrade(store, p, func() error { return nil })
+	if err != nil {
+		t.Fatalf("Upgrade() error = %v", err)
+	}
+	if record.Version != "2.0.0" || record.PreviousVersion != "1.0.0" {
+		t.Errorf("Upgrade() = %+v, want 2.0.0 replacing 1.0.0", record)
+	}
+}
+
+func TestUpgradeRestoresBrokenBinary(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as the provider binary")
+	}
+	bin := t.TempDir()
+	t.Setenv("PATH", bin)
+	Sources["fake"] = Source{Version: []string{"fake-bin", "--version"}}
+	defer delete(Sources, "fake")
+
+	p := &fakeProvider{path: filepath.Join(bin, "fake-bin"), version: "1.0.0"}
+	if err := p.Install(); err != nil {
+		t.Fatal(err)
+	}
+	old, err := os.ReadFile(p.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewStore(filepath.Join(t.TempDir(), "installed.json"))
+	if err := RecordInstall(store, "fake"); err != nil {
+		t.Fatal(err)
+	}
+
+	p.version = ""
+	_, err = Upgrade(store, p, versionCanary)
+	if !errors.Is(err, ErrCanaryFailed) {
+		t.Fatalf("Upgrade() error = %v, want ErrCanaryFailed", err)
+	}
+	restored, err := os.ReadFile(p.path)
+	if err != nil {
+		t.Fatalf("binary missing after rollback: %v", err)
+	}
+	if string(restored) != string(old) {
+		t.Errorf("binary after rollback = %q, want the previous %q", restored, old)
+	}
+	if info, err := os.Stat(p.path); err != nil || info.Mode().Perm()&0100 == 0 {
+		t.Errorf("restored binary is not executable: %v, %v", info, err)
+	}
+	if r, _, _ := store.Get("fake"); r.Version != "1.0.0" || r.PreviousVersion != "" {
+		t.Errorf("record after rollback = %+v, want 1.0.0 unchanged", r)
+	}
+}
+
+func TestUpgradeCanaryWithoutPreviousBinary(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as the provider binary")
+	}
+	bin := t.TempDir()
+	t.Setenv("PATH", bin)
+	Sources["fake"] = Source{Version: []string{"fake-bin", "--version"}}
+	defer delete(Sources, "fake")
+
+	// Recorded as installed, but the binary has since gone
+	store := NewStore(filepath.Join(t.TempDir(), "installed.json"))
+	if err := store.Put(Record{Provider: "fake", Version: "1.0.0"}); err != nil {
+		t.Fatal(err)
+	}
+	p := &reinstallOnly{&fakeProvider{path: filepath.Join(bin, "fake-bin")}}
+	_, err := Upgrade(store, p, versionCanary)
+	if !errors.Is(err, ErrCanaryFailed) || !strings.Contains(err.Error(), "no previous binary") {
+		t.Fatalf("Upgrade() error = %v, want ErrCanaryFailed with nothing to restore", err)
+	}
+	if r, _, _ := store.Get("fake"); r.Version != "1.0.0" {
+		t.Errorf("recorded version = %q, want 1.0.0 kept after a failed canary", r.Version)
+	}
+}
+
+// reinstallOnly tolerates a binary that is already gone
+type reinstallOnly struct{ *fakeProvider }
+
+func (r *reinstallOnly) Uninstall() error {
+	if err := r.fakeProvider.Uninstall(); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
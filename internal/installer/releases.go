@@ -0,0 +1,155 @@
+package installer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/offline"
+)
+
+// Source is where a provider's binary is released
+type Source struct {
+	Repo    string   // GitHub owner/name
+	Version []string // command printing the installed version
+}
+
+// Sources lists the providers whose installed binaries can be checked for
+// updates, by registry name
+var Sources = map[string]Source{
+	"bore":       {Repo: "ekzhang/bore", Version: []string{"bore", "--version"}},
+	"cloudflare": {Repo: "cloudflare/cloudflared", Version: []string{"cloudflared", "--version"}},
+	"tailscale":  {Repo: "tailscale/tailscale", Version: []string{"tailscale", "version"}},
+	"zerotier":   {Repo: "zerotier/ZeroTierOne", Version: []string{"zerotier-cli", "-v"}},
+}
+
+// Release is a published version of a provider binary
+type Release struct {
+	Version     string    `json:"version"`
+	Name        string    `json:"name,omitempty"`
+	Notes       string    `json:"notes,omitempty"`
+	URL         string    `json:"url,omitempty"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+// ReleaseSource lists the releases of a repository, newest first
+type ReleaseSource interface {
+	Releases(ctx context.Context, repo string) ([]Release, error)
+}
+
+// GitHub reads releases from the GitHub REST API
+type GitHub struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+// NewGitHub returns a release source for api.github.com
+func NewGitHub() *GitHub {
+	return &GitHub{
+		BaseURL: "https://api.github.com",
+		Client:  &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// Releases returns the published, non-prerelease releases of repo
+func (g *GitHub) Releases(ctx context.Context, repo string) ([]Release, error) {
+	if err := offline.Check("checking for provider updates"); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/repos/%s/releases?per_page=30", g.BaseURL, repo), nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := g.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch releases of %s: %w", repo, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch releases of %s: %s", repo, resp.Status)
+	}
+
+	var raw []struct {
+		TagName     string    `json:"tag_name"`
+		Name        string    `json:"name"`
+		Body        string    `json:"body"`
+		HTMLURL     string    `json:"html_url"`
+		Draft       bool      `json:"draft"`
+		Prerelease  bool      `json:"prerelease"`
+		PublishedAt time.Time `json:"published_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("parse releases of %s: %w", repo, err)
+	}
+
+	var releases []Release
+	for _, r := range raw {
+		if r.Draft || r.Prerelease {
+			continue
+		}
+		releases = append(releases, Release{
+			Version:     normalizeVersion(r.TagName),
+			Name:        r.Name,
+			Notes:       strings.TrimSpace(r.Body),
+			URL:         r.HTMLURL,
+			PublishedAt: r.PublishedAt,
+		})
+	}
+	return releases, nil
+}
+
+var versionPattern = regexp.MustCompile(`\d+(\.\d+)+`)
+
+// normalizeVersion extracts the dotted version from a tag or version output,
+// e.g. "v0.5.1" or "cloudflared version 2024.6.1 (built ...)"
+func normalizeVersion(s string) string {
+	if v := versionPattern.FindString(s); v != "" {
+		return v
+	}
+	return strings.TrimPrefix(s, "v")
+}
+
+// CompareVersions compares dotted versions numerically, returning -1, 0 or 1
+func CompareVersions(a, b string) int {
+	as := strings.Split(normalizeVersion(a), ".")
+	bs := strings.Split(normalizeVersion(b), ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
+
+// DetectVersion runs the provider's version command and returns the
+// installed version, or "" if it cannot be determined
+func DetectVersion(provider string) string {
+	source, ok := Sources[provider]
+	if !ok || len(source.Version) == 0 {
+		return ""
+	}
+	out, err := exec.Command(source.Version[0], source.Version[1:]...).Output()
+	if err != nil {
+		return ""
+	}
+	return versionPattern.FindString(string(out))
+}
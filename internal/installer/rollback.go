@@ -0,0 +1,88 @@
+package installer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// ErrCanaryFailed is returned by Upgrade when the new binary fails its canary
+var ErrCanaryFailed = errors.New("canary failed")
+
+// Canary verifies a freshly upgraded provider before the primary connection
+// uses it
+type Canary func() error
+
+// binaryPath locates the installed binary of provider
+func binaryPath(provider string) (string, bool) {
+	source, ok := Sources[provider]
+	if !ok || len(source.Version) == 0 {
+		return "", false
+	}
+	path, err := exec.LookPath(source.Version[0])
+	if err != nil {
+		return "", false
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	return path, true
+}
+
+// rollbackPath is where the binary replaced by the last upgrade of provider
+// is kept
+func (s *Store) rollbackPath(provider string) string {
+	return filepath.Join(filepath.Dir(s.path), "rollback", provider)
+}
+
+// restoreBinary puts the kept binary back where the provider's binary now
+// lives, or where it used to if the new one is gone
+func restoreBinary(provider, previousPath, backup string) error {
+	target := previousPath
+	if path, ok := binaryPath(provider); ok {
+		target = path
+	}
+	return copyBinary(backup, target)
+}
+
+// copyBinary copies src over dst through a temporary file in dst's
+// directory, so a binary that is running is replaced rather than rewritten
+func copyBinary(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return fmt.Errorf("replace %s: %w", dst, err)
+	}
+	return nil
+}
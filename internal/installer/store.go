@@ -0,0 +1,133 @@
+// Package installer keeps track of the provider binaries TUNNEL installed
+// and checks their upstream releases for updates.
+package installer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Record describes a provider binary installed by TUNNEL
+type Record struct {
+	Provider        string    `json:"provider"`
+	Version         string    `json:"version,omitempty"`
+	PreviousVersion string    `json:"previous_version,omitempty"` // replaced by the last upgrade
+	InstalledAt     time.Time `json:"installed_at"`
+	UpdatedAt       time.Time `json:"updated_at,omitempty"`
+}
+
+// Store persists install records in a JSON file. Binaries the user
+// installed some other way have no record and are left alone.
+type Store struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewStore returns a store backed by the file at path
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// List returns all records sorted by provider
+func (s *Store) List() ([]Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Record, 0, len(records))
+	for _, r := range records {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Provider < list[j].Provider })
+	return list, nil
+}
+
+// Get returns the record for provider
+func (s *Store) Get(provider string) (Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.load()
+	if err != nil {
+		return Record{}, false, err
+	}
+	r, ok := records[provider]
+	return r, ok, nil
+}
+
+// Put adds or replaces the record for r.Provider
+func (s *Store) Put(r Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.load()
+	if err != nil {
+		return err
+	}
+	records[r.Provider] = r
+	return s.save(records)
+}
+
+// Remove deletes the record for provider, if there is one
+func (s *Store) Remove(provider string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := records[provider]; !ok {
+		return nil
+	}
+	delete(records, provider)
+	return s.save(records)
+}
+
+func (s *Store) load() (map[string]Record, error) {
+	records := make(map[string]Record)
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read install records: %w", err)
+	}
+
+	var list []Record
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("parse install records: %w", err)
+	}
+	for _, r := range list {
+		records[r.Provider] = r
+	}
+	return records, nil
+}
+
+func (s *Store) save(records map[string]Record) error {
+	list := make([]Record, 0, len(records))
+	for _, r := range records {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Provider < list[j].Provider })
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode install records: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("create install records directory: %w", err)
+	}
+	if err := os.WriteFile(s.path, data, 0644); err != nil {
+		return fmt.Errorf("write install records: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Installable is a provider with an installer, from either provider registry
+type Installable interface {
+	Name() string
+	Install() error
+	Uninstall() error
+}
+
+// Update describes the releases available for one installed provider
+type Update struct {
+	Provider  string    `json:"provider"`
+	Installed string    `json:"installed"`
+	Latest    string    `json:"latest"`
+	Pinned    string    `json:"pinned,omitempty"` // version the provider is held at
+	Changelog []Release `json:"changelog"`        // releases newer than Installed, newest first
+}
+
+// Available reports whether the provider can be upgraded: a newer release
+// exists and the provider is not pinned
+func (u Update) Available() bool {
+	return u.Pinned == "" && len(u.Changelog) > 0
+}
+
+// Checker looks up new releases for the binaries in a store
+type Checker struct {
+	Store    *Store
+	Releases ReleaseSource
+	Pins     map[string]string // provider to pinned version
+}
+
+// Check returns an update entry for each recorded provider with a known
+// release source, or only for names if any are given
+func (c *Checker) Check(ctx context.Context, names ...string) ([]Update, error) {
+	records, err := c.Store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(names) > 0 {
+		recorded := make(map[string]Record)
+		for _, record := range records {
+			recorded[record.Provider] = record
+		}
+		records = nil
+		for _, name := range names {
+			record, ok := recorded[name]
+			if !ok {
+				return nil, fmt.Errorf("provider %s was not installed by tunnel", name)
+			}
+			records = append(records, record)
+		}
+	}
+
+	var updates []Update
+	for _, record := range records {
+		source, ok := Sources[record.Provider]
+		if !ok {
+			continue
+		}
+		installed := record.Version
+		if installed == "" {
+			installed = DetectVersion(record.Provider)
+		}
+
+		releases, err := c.Releases.Releases(ctx, source.Repo)
+		if err != nil {
+			return nil, err
+		}
+
+		u := Update{Provider: record.Provider, Installed: installed, Pinned: c.Pins[record.Provider]}
+		for _, release := range releases {
+			if u.Latest == "" || CompareVersions(release.Version, u.Latest) > 0 {
+				u.Latest = release.Version
+			}
+			if installed == "" || CompareVersions(release.Version, installed) > 0 {
+				u.Changelog = append(u.Changelog, release)
+			}
+		}
+		// With no known version every release is "newer"; only show the latest
+		if installed == "" && len(u.Changelog) > 1 {
+			u.Changelog = u.Changelog[:1]
+		}
+		updates = append(updates, u)
+	}
+
+	return updates, nil
+}
+
+// Upgrade reinstalls p through its installer, which fetches the latest
+// release, and records the new version. The binary being replaced is kept;
+// if canary is given and fails, that binary is put back and the error wraps
+// ErrCanaryFailed.
+func Upgrade(store *Store, p Installable, canary Canary) (Record, error) {
+	name := p.Name()
+	record, ok, err := store.Get(name)
+	if err != nil {
+		return Record{}, err
+	}
+	if !ok {
+		return Record{}, fmt.Errorf("provider %s was not installed by tunnel", name)
+	}
+
+	previous := record.Version
+	if previous == "" {
+		previous = DetectVersion(name)
+	}
+	previousPath, kept := binaryPath(name)
+	if kept {
+		if err := copyBinary(previousPath, store.rollbackPath(name)); err != nil {
+			return Record{}, fmt.Errorf("keep previous %s binary: %w", name, err)
+		}
+	}
+
+	if err := p.Uninstall(); err != nil {
+		return Record{}, fmt.Errorf("remove %s: %w", name, err)
+	}
+	if err := p.Install(); err != nil {
+		return Record{}, fmt.Errorf("install %s: %w", name, err)
+	}
+
+	if canary != nil {
+		if err := canary(); err != nil {
+			if !kept {
+				return Record{}, fmt.Errorf("%w: %v; no previous binary to restore", ErrCanaryFailed, err)
+			}
+			if rerr := restoreBinary(name, previousPath, store.rollbackPath(name)); rerr != nil {
+				return Record{}, fmt.Errorf("%w: %v; restoring the previous binary failed: %v", ErrCanaryFailed, err, rerr)
+			}
+			if previous == "" {
+				previous = "the previous binary"
+			}
+			return Record{}, fmt.Errorf("%w: %v; rolled back to %s", ErrCanaryFailed, err, previous)
+		}
+	}
+
+	record.PreviousVersion = previous
+	record.Version = DetectVersion(name)
+	record.UpdatedAt = time.Now()
+	if err := store.Put(record); err != nil {
+		return Record{}, err
+	}
+	return record, nil
+}
+
+// RecordInstall records that provider was just installed by tunnel
+func RecordInstall(store *Store, provider string) error {
+	now := time.Now()
+	return store.Put(Record{
+		Provider:    provider,
+		Version:     DetectVersion(provider),
+		InstalledAt: now,
+		UpdatedAt:   now,
+	})
+}
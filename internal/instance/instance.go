@@ -0,0 +1,160 @@
+// Package instance keeps a per-user lock file so only one TUI or daemon
+// manages providers at a time.
+package instance
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	// ErrRunning is returned when the lock is held by a live process
+	ErrRunning = errors.New("another tunnel instance is running")
+
+	// ErrStaleLock is returned when the lock was left behind by a process that
+	// is no longer running and takeover was not requested
+	ErrStaleLock = errors.New("stale instance lock")
+)
+
+// Info describes the process holding the lock
+type Info struct {
+	PID       int       `json:"pid"`
+	Port      int       `json:"port,omitempty"` // web server port, once known
+	Command   string    `json:"command"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// Lock is a held instance lock
+type Lock struct {
+	path string
+	info Info
+}
+
+// Acquire takes the lock at path for the current process. If the lock
+// belongs to a dead process it is replaced only when takeover is set.
+func Acquire(path, command string, takeover bool) (*Lock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("create lock directory: %w", err)
+	}
+
+	lock := &Lock{
+		path: path,
+		info: Info{
+			PID:       os.Getpid(),
+			Command:   command,
+			StartedAt: time.Now(),
+		},
+	}
+
+	// Two passes: the second follows removal of a stale lock
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			err = json.NewEncoder(f).Encode(lock.info)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, fmt.Errorf("write lock file: %w", err)
+			}
+			return lock, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("create lock file: %w", err)
+		}
+
+		holder, err := Read(path)
+		if err == nil && holder.PID != os.Getpid() && processAlive(holder.PID) {
+			return nil, fmt.Errorf("%w (pid %d)", ErrRunning, holder.PID)
+		}
+
+		if !takeover {
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s is unreadable", ErrStaleLock, path)
+			}
+			return nil, fmt.Errorf("%w: pid %d is no longer running", ErrStaleLock, holder.PID)
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("remove stale lock: %w", err)
+		}
+	}
+
+	return nil, fmt.Errorf("%w: lock was re-created during takeover", ErrRunning)
+}
+
+// Read returns the holder recorded in the lock file at path
+func Read(path string) (*Info, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read lock file: %w", err)
+	}
+
+	var info Info
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("parse lock file: %w", err)
+	}
+	if info.PID <= 0 {
+		return nil, fmt.Errorf("parse lock file: invalid pid %d", info.PID)
+	}
+	return &info, nil
+}
+
+// Find returns the live process holding the lock at path, or nil if the lock
+// is absent or stale
+func Find(path string) *Info {
+	holder, err := Read(path)
+	if err != nil || !processAlive(holder.PID) {
+		return nil
+	}
+	return holder
+}
+
+// Info returns what the lock records about this process
+func (l *Lock) Info() Info {
+	return l.info
+}
+
+// SetPort records the web server port so later invocations can attach to it
+func (l *Lock) SetPort(port int) error {
+	l.info.Port = port
+
+	data, err := json.Marshal(l.info)
+	if err != nil {
+		return fmt.Errorf("encode lock file: %w", err)
+	}
+
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("write lock file: %w", err)
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write lock file: %w", err)
+	}
+	return nil
+}
+
+// Release removes the lock file if it still belongs to this process
+func (l *Lock) Release() error {
+	holder, err := Read(l.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if holder.PID != l.info.PID {
+		// Someone took over; the file is theirs now
+		return nil
+	}
+
+	if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove lock file: %w", err)
+	}
+	return nil
+}
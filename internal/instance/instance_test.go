@@ -0,0 +1,101 @@
+package instance
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeLock(t *testing.T, path string, info Info) {
+	t.Helper()
+	data, err := json.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAcquireAndRelease(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "instance.lock")
+
+	lock, err := Acquire(path, "tui", false)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	if err := lock.SetPort(8081); err != nil {
+		t.Fatalf("SetPort() error = %v", err)
+	}
+	info, err := Read(path)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if info.PID != os.Getpid() || info.Port != 8081 || info.Command != "tui" {
+		t.Errorf("Read() = %+v", info)
+	}
+
+	if err := lock.Release(); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("lock file still exists after Release()")
+	}
+}
+
+func TestAcquireHeldByLiveProcess(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "instance.lock")
+	writeLock(t, path, Info{PID: os.Getppid(), Port: 8080})
+
+	for _, takeover := range []bool{false, true} {
+		if _, err := Acquire(path, "tui", takeover); !errors.Is(err, ErrRunning) {
+			t.Errorf("Acquire(takeover=%v) error = %v, want ErrRunning", takeover, err)
+		}
+	}
+}
+
+func TestAcquireStaleLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "instance.lock")
+	writeLock(t, path, Info{PID: 999999999})
+
+	if _, err := Acquire(path, "tui", false); !errors.Is(err, ErrStaleLock) {
+		t.Fatalf("Acquire() error = %v, want ErrStaleLock", err)
+	}
+
+	lock, err := Acquire(path, "tui", true)
+	if err != nil {
+		t.Fatalf("Acquire(takeover) error = %v", err)
+	}
+	if info, err := Read(path); err != nil || info.PID != os.Getpid() {
+		t.Errorf("Read() after takeover = %+v, %v", info, err)
+	}
+
+	// A lock taken over by someone else is left alone on release
+	writeLock(t, path, Info{PID: os.Getppid()})
+	if err := lock.Release(); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Release() removed a lock it no longer owns")
+	}
+}
+
+func TestFind(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "instance.lock")
+	if holder := Find(path); holder != nil {
+		t.Errorf("Find() with no lock = %+v, want nil", holder)
+	}
+
+	writeLock(t, path, Info{PID: 999999999})
+	if holder := Find(path); holder != nil {
+		t.Errorf("Find() with stale lock = %+v, want nil", holder)
+	}
+
+	writeLock(t, path, Info{PID: os.Getppid(), Port: 8080})
+	if holder := Find(path); holder == nil || holder.Port != 8080 {
+		t.Errorf("Find() with live lock = %+v", holder)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package instance
+
+import (
+	"os"
+)
+
+// processAlive reports whether a process with pid exists. On Windows
+// FindProcess opens a handle and fails for processes that have exited.
+func processAlive(pid int) bool {
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	proc.Release()
+	return true
+}
@@ -0,0 +1,286 @@
+// Package logging is TUNNEL's central logger. Setup installs it as the
+// default slog logger, which the standard log package then writes through
+// too, so packages log with slog (or logging.For for a component) and the
+// settings decide the level, the format and where the lines go: stderr, or
+// a file rotated by size. Entries are also fanned out to subscribers, such
+// as the TUI's live log.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/units"
+)
+
+// Defaults for file output
+const (
+	DefaultMaxSize  = 10 // megabytes before the file is rotated
+	DefaultMaxFiles = 3  // rotated files kept
+)
+
+// Options configures the logger
+type Options struct {
+	Level    string // debug, info, warn or error; empty is info
+	Format   string // text or json; empty is text
+	File     string // empty writes to stderr
+	MaxSize  int    // megabytes; 0 is DefaultMaxSize
+	MaxFiles int    // 0 is DefaultMaxFiles; -1 keeps none
+}
+
+var (
+	level slog.LevelVar
+	quiet atomic.Bool
+
+	mu   sync.Mutex
+	file *RotatingFile // the open log file, if any
+)
+
+// Setup installs the logger described by opts as the default, closing the
+// file of any previous Setup
+func Setup(opts Options) error {
+	lvl, err := ParseLevel(opts.Level)
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stderr
+	var opened *RotatingFile
+	if opts.File != "" {
+		maxSize, maxFiles := opts.MaxSize, opts.MaxFiles
+		if maxSize == 0 {
+			maxSize = DefaultMaxSize
+		}
+		switch {
+		case maxFiles == 0:
+			maxFiles = DefaultMaxFiles
+		case maxFiles < 0:
+			maxFiles = 0
+		}
+		if opened, err = OpenRotatingFile(opts.File, int64(maxSize)<<20, maxFiles); err != nil {
+			return err
+		}
+		out = opened
+	}
+
+	handlerOpts := &slog.HandlerOptions{Level: &level}
+	var h slog.Handler
+	switch opts.Format {
+	case "", "text":
+		h = slog.NewTextHandler(out, handlerOpts)
+	case "json":
+		h = slog.NewJSONHandler(out, handlerOpts)
+	default:
+		if opened != nil {
+			opened.Close()
+		}
+		return fmt.Errorf("unknown log format %q: use text or json", opts.Format)
+	}
+
+	level.Set(lvl)
+	slog.SetDefault(slog.New(&handler{out: h, toFile: opened != nil}))
+
+	mu.Lock()
+	previous := file
+	file = opened
+	mu.Unlock()
+	if previous != nil {
+		previous.Close()
+	}
+	return nil
+}
+
+// Close closes the log file, if one is open. Later entries go to
+// subscribers only.
+func Close() error {
+	mu.Lock()
+	defer mu.Unlock()
+	if file == nil {
+		return nil
+	}
+	return file.Close()
+}
+
+// SetLevel changes the level of the installed logger
+func SetLevel(s string) error {
+	lvl, err := ParseLevel(s)
+	if err != nil {
+		return err
+	}
+	level.Set(lvl)
+	return nil
+}
+
+// SetQuiet keeps entries off stderr while on, as when a full-screen UI
+// owns the terminal. Log files and subscribers still get them.
+func SetQuiet(on bool) {
+	quiet.Store(on)
+}
+
+// ParseLevel parses a level as written in the config
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q: use debug, info, warn or error", s)
+}
+
+// For returns the logger of a component, whose entries carry its name
+func For(component string) *slog.Logger {
+	return slog.Default().With("component", component)
+}
+
+// handler writes records to the configured output and hands them to
+// subscribers
+type handler struct {
+	out    slog.Handler
+	toFile bool        // out is a file, which quiet does not silence
+	attrs  []slog.Attr // added with With, for entries
+	group  string      // prefix of attribute keys, for entries
+}
+
+func (h *handler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= level.Level()
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	var err error
+	if h.toFile || !quiet.Load() {
+		err = h.out.Handle(ctx, r)
+	}
+	if hasSubscribers() {
+		publish(h.entry(r))
+	}
+	return err
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.out = h.out.WithAttrs(attrs)
+	c.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, a := range attrs {
+		if h.group != "" {
+			a.Key = h.group + a.Key
+		}
+		c.attrs = append(c.attrs, a)
+	}
+	return &c
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := *h
+	c.out = h.out.WithGroup(name)
+	c.group = h.group + name + "."
+	return &c
+}
+
+// entry turns a record into an Entry, pulling out the component
+func (h *handler) entry(r slog.Record) Entry {
+	e := Entry{Time: r.Time, Level: r.Level, Message: r.Message}
+	var rest []string
+	add := func(a slog.Attr) {
+		if a.Key == "component" && e.Component == "" {
+			e.Component = a.Value.String()
+			return
+		}
+		rest = append(rest, a.Key+"="+a.Value.String())
+	}
+	for _, a := range h.attrs {
+		add(a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		if h.group != "" {
+			a.Key = h.group + a.Key
+		}
+		add(a)
+		return true
+	})
+	e.Attrs = strings.Join(rest, " ")
+	return e
+}
+
+// Entry is a logged record as handed to subscribers
+type Entry struct {
+	Time      time.Time
+	Level     slog.Level
+	Component string
+	Message   string
+	Attrs     string // the other attributes, as key=value pairs
+}
+
+// String formats e as one line, e.g. "12:04:05 WARN throttle: limit hit rate=1MB"
+func (e Entry) String() string {
+	var b strings.Builder
+	b.WriteString(units.Clock(e.Time))
+	b.WriteString(" ")
+	b.WriteString(fmt.Sprintf("%-5s", e.Level.String()))
+	b.WriteString(" ")
+	if e.Component != "" {
+		b.WriteString(e.Component + ": ")
+	}
+	b.WriteString(e.Message)
+	if e.Attrs != "" {
+		b.WriteString(" " + e.Attrs)
+	}
+	return b.String()
+}
+
+var (
+	subMu       sync.Mutex
+	subscribers = make(map[chan Entry]struct{})
+	subCount    atomic.Int32
+)
+
+// Subscribe returns a feed of the entries logged from now on and a func
+// that ends it. Entries a slow subscriber has no room for are dropped
+// rather than holding up the logger.
+func Subscribe(buffer int) (<-chan Entry, func()) {
+	ch := make(chan Entry, buffer)
+	subMu.Lock()
+	subscribers[ch] = struct{}{}
+	subCount.Add(1)
+	subMu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			subMu.Lock()
+			delete(subscribers, ch)
+			subCount.Add(-1)
+			close(ch)
+			subMu.Unlock()
+		})
+	}
+}
+
+func hasSubscribers() bool {
+	return subCount.Load() > 0
+}
+
+func publish(e Entry) {
+	subMu.Lock()
+	defer subMu.Unlock()
+	for ch := range subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package logging
+
+import (
+	"encoding/json"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// restoreDefault puts back the default loggers a test replaced
+func restoreDefault(t *testing.T) {
+	t.Helper()
+	prev, flags, out := slog.Default(), log.Flags(), log.Writer()
+	t.Cleanup(func() {
+		Close()
+		slog.SetDefault(prev)
+		log.SetFlags(flags)
+		log.SetOutput(out)
+	})
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tunnel.log")
+	f, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile() error = %v", err)
+	}
+	defer f.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+
+	want := map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	}
+	for p, content := range want {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatalf("read %s: %v", filepath.Base(p), err)
+		}
+		if string(data) != content {
+			t.Errorf("%s = %q, want %q", filepath.Base(p), data, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("expected files beyond the number kept to be deleted")
+	}
+}
+
+func TestRotatingFileKeepsSizeAcrossOpens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tunnel.log")
+	if err := os.WriteFile(path, []byte("12345678"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := OpenRotatingFile(path, 10, 1)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile() error = %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Write([]byte("abc")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	if data, _ := os.ReadFile(path + ".1"); string(data) != "12345678" {
+		t.Errorf("rotated file = %q, want what was there before", data)
+	}
+}
+
+func TestSetupWritesJSONToFile(t *testing.T) {
+	restoreDefault(t)
+	path := filepath.Join(t.TempDir(), "tunnel.log")
+	if err := Setup(Options{Level: "warn", Format: "json", File: path}); err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+
+	For("failover").Info("below the level")
+	For("failover").Warn("switched primary", "from", "bore", "to", "ngrok")
+	log.Printf("from the log package")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("log has %d lines, want 1 at warn level:\n%s", len(lines), data)
+	}
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("line is not JSON: %v", err)
+	}
+	if record["component"] != "failover" || record["msg"] != "switched primary" || record["to"] != "ngrok" {
+		t.Errorf("unexpected record %v", record)
+	}
+
+	// The log package goes through the same logger
+	if err := SetLevel("info"); err != nil {
+		t.Fatalf("SetLevel() error = %v", err)
+	}
+	log.Printf("from the log package")
+	data, _ = os.ReadFile(path)
+	if !strings.Contains(string(data), "from the log package") {
+		t.Error("expected the log package to write through the logger")
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	restoreDefault(t)
+	if err := Setup(Options{File: filepath.Join(t.TempDir(), "tunnel.log")}); err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+
+	feed, cancel := Subscribe(4)
+	For("throttle").With("provider", "bore").Warn("limit reached", "rate", "1MB/s")
+
+	e := <-feed
+	if e.Component != "throttle" || e.Message != "limit reached" || e.Level != slog.LevelWarn {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	if e.Attrs != "provider=bore rate=1MB/s" {
+		t.Errorf("Attrs = %q", e.Attrs)
+	}
+	if s := e.String(); !strings.Contains(s, "WARN  throttle: limit reached provider=bore") {
+		t.Errorf("String() = %q", s)
+	}
+
+	cancel()
+	if _, ok := <-feed; ok {
+		t.Error("expected the feed closed after cancel")
+	}
+}
+
+func TestSetupRejectsUnknownSettings(t *testing.T) {
+	restoreDefault(t)
+	if err := Setup(Options{Level: "loud"}); err == nil {
+		t.Error("expected error for unknown level")
+	}
+	if err := Setup(Options{Format: "xml"}); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
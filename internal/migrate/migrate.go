@@ -0,0 +1,238 @@
+// Package migrate upgrades versioned config and state files through an
+// ordered list of migration steps. Documents are handled as YAML nodes so
+// comments and key order survive a migration; JSON files are read the same
+// way, since JSON is valid YAML.
+package migrate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Format is the encoding a migrated document is written back in
+type Format int
+
+const (
+	YAML Format = iota
+	JSON
+)
+
+// Step upgrades a document to Version from the version before it. Apply
+// edits root in place; a step that changes the root's kind, such as wrapping
+// a list in an object, overwrites *root.
+type Step struct {
+	Version     int
+	Description string
+	Apply       func(root *yaml.Node) error
+}
+
+// Migrator holds the migrations for one kind of file
+type Migrator struct {
+	Name       string // shown in reports, e.g. "config"
+	VersionKey string // top-level key holding the document version
+	Format     Format
+	Steps      []Step // ordered by Version, starting at 1
+}
+
+// Result describes a migration that was applied or, in a dry run, planned
+type Result struct {
+	Name    string
+	Path    string
+	From    int
+	To      int
+	Applied []Step
+	Before  []byte
+	After   []byte
+	Backup  string // copy of the original file, empty for dry runs
+}
+
+// Changed reports whether any step applied
+func (r *Result) Changed() bool {
+	return len(r.Applied) > 0
+}
+
+// Latest returns the version documents are migrated to
+func (m *Migrator) Latest() int {
+	if len(m.Steps) == 0 {
+		return 0
+	}
+	return m.Steps[len(m.Steps)-1].Version
+}
+
+// version returns the version recorded in root; documents that predate
+// versioning are version 0
+func (m *Migrator) version(root *yaml.Node) (int, error) {
+	if root == nil || root.Kind != yaml.MappingNode {
+		return 0, nil
+	}
+	v := MapValue(root, m.VersionKey)
+	if v == nil {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(v.Value)
+	if err != nil || version < 0 {
+		return 0, fmt.Errorf("invalid %s %q", m.VersionKey, v.Value)
+	}
+	return version, nil
+}
+
+// Migrate runs every step newer than the version recorded in data. It fails
+// for documents written by a newer release rather than misreading them.
+func (m *Migrator) Migrate(data []byte) (*Result, error) {
+	result := &Result{Name: m.Name, Before: data, After: data}
+
+	doc, err := decodeDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	root := documentRoot(doc)
+
+	from, err := m.version(root)
+	if err != nil {
+		return nil, err
+	}
+	result.From, result.To = from, from
+
+	if latest := m.Latest(); from > latest {
+		return nil, fmt.Errorf("%s version %d is newer than this release supports (%d); upgrade tunnel", m.Name, from, latest)
+	}
+
+	for _, step := range m.Steps {
+		if step.Version <= from {
+			continue
+		}
+		if err := step.Apply(root); err != nil {
+			return nil, fmt.Errorf("migrate %s to version %d: %w", m.Name, step.Version, err)
+		}
+		setVersion(root, m.VersionKey, step.Version)
+		result.Applied = append(result.Applied, step)
+		result.To = step.Version
+	}
+
+	if !result.Changed() {
+		return result, nil
+	}
+
+	result.After, err = m.encode(doc)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// MigrateFile migrates the file at path in place. The original is copied to
+// path.v<version>.bak first. A missing file needs no migration. With dryRun
+// the file is left untouched and the result shows what would change.
+func (m *Migrator) MigrateFile(path string, dryRun bool) (*Result, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &Result{Name: m.Name, Path: path}, nil
+		}
+		return nil, fmt.Errorf("read %s: %w", m.Name, err)
+	}
+
+	result, err := m.Migrate(data)
+	if err != nil {
+		return nil, err
+	}
+	result.Path = path
+	if !result.Changed() || dryRun {
+		return result, nil
+	}
+
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	backup := fmt.Sprintf("%s.v%d.bak", path, result.From)
+	if err := os.WriteFile(backup, data, mode); err != nil {
+		return nil, fmt.Errorf("back up %s: %w", m.Name, err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, result.After, mode); err != nil {
+		return nil, fmt.Errorf("write %s: %w", m.Name, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("write %s: %w", m.Name, err)
+	}
+	result.Backup = backup
+
+	return result, nil
+}
+
+// encode writes doc back in the migrator's format
+func (m *Migrator) encode(doc *yaml.Node) ([]byte, error) {
+	if m.Format == JSON {
+		var v interface{}
+		if err := doc.Decode(&v); err != nil {
+			return nil, fmt.Errorf("encode %s: %w", m.Name, err)
+		}
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("encode %s: %w", m.Name, err)
+		}
+		return data, nil
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, fmt.Errorf("encode %s: %w", m.Name, err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("encode %s: %w", m.Name, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeDocument parses data, returning an empty document for empty input
+func decodeDocument(data []byte) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse: %w", err)
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	return &doc, nil
+}
+
+func documentRoot(doc *yaml.Node) *yaml.Node {
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		return doc.Content[0]
+	}
+	return doc
+}
+
+// setVersion records version under key, adding the key at the top of the
+// document if it is missing
+func setVersion(root *yaml.Node, key string, version int) {
+	if root.Kind != yaml.MappingNode {
+		return
+	}
+	value := strconv.Itoa(version)
+	if v := MapValue(root, key); v != nil {
+		v.Value, v.Tag, v.Style = value, "!!int", 0
+		return
+	}
+	keyNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}
+	// Keep a file's header comment above the new key
+	if len(root.Content) > 0 {
+		keyNode.HeadComment, root.Content[0].HeadComment = root.Content[0].HeadComment, ""
+	}
+	root.Content = append([]*yaml.Node{
+		keyNode,
+		{Kind: yaml.ScalarNode, Tag: "!!int", Value: value},
+	}, root.Content...)
+}
@@ -0,0 +1,102 @@
+package migrate
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func testMigrator() *Migrator {
+	return &Migrator{
+		Name:       "test",
+		VersionKey: "schema",
+		Format:     YAML,
+		Steps: []Step{
+			{Version: 1, Description: "rename timeout", Apply: func(root *yaml.Node) error {
+				RenameKey(root, "timeout", "timeout_seconds")
+				return nil
+			}},
+			{Version: 2, Description: "drop legacy", Apply: func(root *yaml.Node) error {
+				DeleteKey(root, "legacy")
+				return nil
+			}},
+		},
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	m := testMigrator()
+
+	result, err := m.Migrate([]byte("# settings\ntimeout: 30 # seconds\nlegacy: true\n"))
+	if err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	if result.From != 0 || result.To != 2 || len(result.Applied) != 2 {
+		t.Errorf("Migrate() = from %d to %d with %d steps", result.From, result.To, len(result.Applied))
+	}
+	want := "# settings\nschema: 2\ntimeout_seconds: 30 # seconds\n"
+	if string(result.After) != want {
+		t.Errorf("Migrate() output:\n%s\nwant:\n%s", result.After, want)
+	}
+
+	// Only steps newer than the recorded version run
+	result, err = m.Migrate([]byte("schema: 1\ntimeout: 30\nlegacy: true\n"))
+	if err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	if len(result.Applied) != 1 || strings.Contains(string(result.After), "timeout_seconds") {
+		t.Errorf("Migrate() from version 1 reapplied step 1:\n%s", result.After)
+	}
+
+	result, err = m.Migrate([]byte("schema: 2\n"))
+	if err != nil || result.Changed() {
+		t.Errorf("Migrate() on a current document = %+v, %v", result, err)
+	}
+
+	if _, err := m.Migrate([]byte("schema: 3\n")); err == nil {
+		t.Error("Migrate() accepted a document from a newer release")
+	}
+	if _, err := m.Migrate([]byte("schema: two\n")); err == nil {
+		t.Error("Migrate() accepted a non-numeric version")
+	}
+}
+
+func TestMigrateFile(t *testing.T) {
+	m := testMigrator()
+	m.Format = JSON
+	path := filepath.Join(t.TempDir(), "state.json")
+	original := `{"timeout": 30}`
+	if err := os.WriteFile(path, []byte(original), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := m.MigrateFile(path, true)
+	if err != nil || !result.Changed() {
+		t.Fatalf("MigrateFile(dry run) = %+v, %v", result, err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != original {
+		t.Error("dry run modified the file")
+	}
+	if diff := Diff(result.Before, result.After); !strings.Contains(diff, `+  "timeout_seconds": 30`) {
+		t.Errorf("Diff() = %q", diff)
+	}
+
+	result, err = m.MigrateFile(path, false)
+	if err != nil {
+		t.Fatalf("MigrateFile() error = %v", err)
+	}
+	if backup, _ := os.ReadFile(result.Backup); string(backup) != original {
+		t.Errorf("backup = %q, want original", backup)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), `"schema": 2`) {
+		t.Errorf("migrated file = %s", data)
+	}
+
+	if result, err := m.MigrateFile(filepath.Join(t.TempDir(), "missing.json"), false); err != nil || result.Changed() {
+		t.Errorf("MigrateFile() on a missing file = %+v, %v", result, err)
+	}
+}
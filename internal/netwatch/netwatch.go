@@ -0,0 +1,184 @@
+// Package netwatch detects changes to the host's network attachment, such as
+// switching Wi-Fi networks or resuming from sleep, which silently break
+// long-lived tunnels.
+package netwatch
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultInterval is how often the network is polled when no change
+// notifications are available, and how often sleep is checked for
+const DefaultInterval = 5 * time.Second
+
+// settleDelay lets a burst of link and address updates finish before the
+// network is compared, so one Wi-Fi switch yields one change
+const settleDelay = time.Second
+
+// Change describes a detected network change
+type Change struct {
+	Reason string
+	Time   time.Time
+}
+
+// virtualPrefixes are interfaces created by tunnels and containers. They are
+// left out of the comparison so that bringing a tunnel up does not count as
+// the network changing underneath it.
+var virtualPrefixes = []string{
+	"lo", "tun", "tap", "wg", "utun", "tailscale", "zt", "docker", "veth", "br-", "virbr", "awdl", "llw",
+}
+
+// snapshot is the network state compared between checks
+type snapshot struct {
+	defaultRoute string
+	addrs        string
+}
+
+// Watch reports network changes on the returned channel until ctx is done.
+// On Linux, netlink route notifications trigger an immediate check; other
+// platforms poll every interval. A wall-clock jump larger than the interval
+// is reported as a resume from sleep.
+func Watch(ctx context.Context, interval time.Duration) <-chan Change {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	changes := make(chan Change, 1)
+	go func() {
+		defer close(changes)
+
+		// A nil channel simply never fires when notifications are unsupported
+		notify, _ := subscribe(ctx)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		last := current()
+		lastTick := time.Now().Round(0) // wall clock, which keeps running during sleep
+
+		emit := func(reason string) {
+			select {
+			case changes <- Change{Reason: reason, Time: time.Now()}:
+			default:
+				// A change is already waiting to be handled
+			}
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case <-notify:
+				select {
+				case <-time.After(settleDelay):
+				case <-ctx.Done():
+					return
+				}
+				drain(notify)
+
+				next := current()
+				if reason := diff(last, next); reason != "" {
+					emit(reason)
+				}
+				last = next
+
+			case <-ticker.C:
+				now := time.Now().Round(0)
+				slept := now.Sub(lastTick) > 2*interval+settleDelay
+				lastTick = now
+
+				next := current()
+				reason := diff(last, next)
+				last = next
+
+				switch {
+				case slept:
+					emit("resumed from sleep")
+				case reason != "":
+					emit(reason)
+				}
+			}
+		}
+	}()
+
+	return changes
+}
+
+// drain discards queued notifications
+func drain(notify <-chan struct{}) {
+	for {
+		select {
+		case <-notify:
+		default:
+			return
+		}
+	}
+}
+
+// diff describes how the network changed between two snapshots, or returns
+// "" if it did not
+func diff(before, after snapshot) string {
+	switch {
+	case before.defaultRoute != after.defaultRoute:
+		if after.defaultRoute == "" {
+			return "default route removed"
+		}
+		return "default route changed"
+	case before.addrs != after.addrs:
+		return "interface addresses changed"
+	default:
+		return ""
+	}
+}
+
+// current captures the present network state
+func current() snapshot {
+	return snapshot{
+		defaultRoute: defaultRoute(),
+		addrs:        physicalAddrs(),
+	}
+}
+
+// physicalAddrs returns the sorted addresses of up, non-virtual interfaces
+func physicalAddrs() string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+
+	var addrs []string
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagPointToPoint != 0 || isVirtual(iface.Name) {
+			continue
+		}
+		ifAddrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range ifAddrs {
+			// Link-local IPv6 addresses churn without connectivity changing
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			addrs = append(addrs, iface.Name+"="+addr.String())
+		}
+	}
+
+	sort.Strings(addrs)
+	return strings.Join(addrs, ",")
+}
+
+// isVirtual reports whether an interface name belongs to a tunnel or container
+func isVirtual(name string) bool {
+	for _, prefix := range virtualPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package netwatch
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// rtnetlink multicast groups (linux/rtnetlink.h), which syscall does not export
+const (
+	rtmgrpLink       = 0x1
+	rtmgrpIPv4IfAddr = 0x10
+	rtmgrpIPv4Route  = 0x40
+	rtmgrpIPv6IfAddr = 0x100
+	rtmgrpIPv6Route  = 0x400
+)
+
+// subscribe opens a netlink socket for link, address and route updates and
+// signals on the returned channel whenever one arrives
+func subscribe(ctx context.Context) (<-chan struct{}, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("open netlink socket: %w", err)
+	}
+
+	addr := &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: rtmgrpLink | rtmgrpIPv4IfAddr | rtmgrpIPv4Route | rtmgrpIPv6IfAddr | rtmgrpIPv6Route,
+	}
+	if err := syscall.Bind(fd, addr); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("bind netlink socket: %w", err)
+	}
+
+	notify := make(chan struct{}, 1)
+	go func() {
+		<-ctx.Done()
+		// Unblocks the pending Recvfrom
+		syscall.Close(fd)
+	}()
+	go func() {
+		buf := make([]byte, 8192)
+		for {
+			if _, _, err := syscall.Recvfrom(fd, buf, 0); err != nil {
+				if err == syscall.EINTR || err == syscall.ENOBUFS {
+					continue
+				}
+				return
+			}
+			select {
+			case notify <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return notify, nil
+}
+
+// defaultRoute returns the interface and gateway of the lowest-metric IPv4
+// default route in the main routing table
+func defaultRoute() string {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	best, bestMetric := "", -1
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 7 || fields[1] != "00000000" || isVirtual(fields[0]) {
+			continue
+		}
+		metric, err := strconv.Atoi(fields[6])
+		if err != nil {
+			continue
+		}
+		if bestMetric == -1 || metric < bestMetric {
+			best, bestMetric = fields[0]+" via "+fields[2], metric
+		}
+	}
+	return best
+}
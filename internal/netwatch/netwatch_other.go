@@ -0,0 +1,18 @@
+//go:build !linux
+
+package netwatch
+
+import (
+	"context"
+	"errors"
+)
+
+// subscribe is not supported off Linux; Watch falls back to polling
+func subscribe(ctx context.Context) (<-chan struct{}, error) {
+	return nil, errors.New("network change notifications not supported on this platform")
+}
+
+// defaultRoute is not tracked off Linux; address changes still are
+func defaultRoute() string {
+	return ""
+}
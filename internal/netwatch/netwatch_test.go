@@ -0,0 +1,39 @@
+package netwatch
+
+import "testing"
+
+func TestDiff(t *testing.T) {
+	base := snapshot{defaultRoute: "wlan0 via 0101A8C0", addrs: "wlan0=192.168.1.20/24"}
+
+	tests := []struct {
+		name  string
+		after snapshot
+		want  string
+	}{
+		{"unchanged", base, ""},
+		{"new gateway", snapshot{defaultRoute: "wlan0 via 01000A0A", addrs: base.addrs}, "default route changed"},
+		{"route gone", snapshot{addrs: base.addrs}, "default route removed"},
+		{"new address", snapshot{defaultRoute: base.defaultRoute, addrs: "wlan0=10.10.0.5/24"}, "interface addresses changed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diff(base, tt.after); got != tt.want {
+				t.Errorf("diff() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsVirtual(t *testing.T) {
+	for _, name := range []string{"tailscale0", "wg0", "utun3", "docker0", "lo"} {
+		if !isVirtual(name) {
+			t.Errorf("expected %s to be treated as virtual", name)
+		}
+	}
+	for _, name := range []string{"eth0", "wlan0", "en0", "enp3s0"} {
+		if isVirtual(name) {
+			t.Errorf("expected %s to be treated as physical", name)
+		}
+	}
+}
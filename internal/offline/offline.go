@@ -0,0 +1,32 @@
+// Package offline provides the global offline switch used to suppress
+// outbound network access on air-gapped machines.
+package offline
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ErrOffline is returned when an operation needs internet access while offline mode is enabled
+var ErrOffline = errors.New("offline mode enabled")
+
+var enabled atomic.Bool
+
+// SetEnabled turns offline mode on or off for the whole process
+func SetEnabled(on bool) {
+	enabled.Store(on)
+}
+
+// Enabled reports whether offline mode is active
+func Enabled() bool {
+	return enabled.Load()
+}
+
+// Check returns an error describing the blocked operation when offline mode is active
+func Check(operation string) error {
+	if !Enabled() {
+		return nil
+	}
+	return fmt.Errorf("%w: %s requires internet access", ErrOffline, operation)
+}
@@ -0,0 +1,28 @@
+package offline
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	SetEnabled(false)
+	defer SetEnabled(false)
+
+	if err := Check("fetch keys"); err != nil {
+		t.Errorf("expected no error while online, got %v", err)
+	}
+
+	SetEnabled(true)
+	if !Enabled() {
+		t.Fatal("expected offline mode to be enabled")
+	}
+
+	err := Check("fetch keys")
+	if err == nil {
+		t.Fatal("expected error while offline")
+	}
+	if !errors.Is(err, ErrOffline) {
+		t.Errorf("expected ErrOffline, got %v", err)
+	}
+}
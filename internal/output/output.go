@@ -0,0 +1,81 @@
+// Package output renders command results as aligned tables, CSV, or as
+// structured JSON or YAML, so list-style commands share one set of formats.
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Format selects how a command prints its result
+type Format string
+
+const (
+	FormatTable Format = "table"
+	FormatJSON  Format = "json"
+	FormatYAML  Format = "yaml"
+	FormatCSV   Format = "csv"
+)
+
+// Formats lists the supported output formats
+var Formats = []Format{FormatTable, FormatJSON, FormatYAML, FormatCSV}
+
+// ParseFormat validates a --output value. An empty value selects the table.
+func ParseFormat(s string) (Format, error) {
+	if s == "" {
+		return FormatTable, nil
+	}
+	for _, f := range Formats {
+		if Format(strings.ToLower(s)) == f {
+			return f, nil
+		}
+	}
+
+	names := make([]string, len(Formats))
+	for i, f := range Formats {
+		names[i] = string(f)
+	}
+	return "", fmt.Errorf("unsupported output format %q (expected one of: %s)", s, strings.Join(names, ", "))
+}
+
+// Structured reports whether the format serializes the full result rather
+// than the rows of a table
+func (f Format) Structured() bool {
+	return f == FormatJSON || f == FormatYAML
+}
+
+// Write prints data in a structured format. Table and CSV output are rendered
+// from a Table built by the caller, which knows which columns matter.
+func Write(w io.Writer, format Format, data interface{}) error {
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(data)
+
+	case FormatYAML:
+		// Round-trip through JSON so field names follow the json tags the
+		// API and `--output json` already use
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return fmt.Errorf("encode output: %w", err)
+		}
+		var generic interface{}
+		if err := json.Unmarshal(raw, &generic); err != nil {
+			return fmt.Errorf("encode output: %w", err)
+		}
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(generic); err != nil {
+			return fmt.Errorf("encode output: %w", err)
+		}
+		return encoder.Close()
+
+	default:
+		return fmt.Errorf("format %q is not a structured format", format)
+	}
+}
@@ -0,0 +1,95 @@
+package output
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Format
+		wantErr bool
+	}{
+		{"", FormatTable, false},
+		{"table", FormatTable, false},
+		{"JSON", FormatJSON, false},
+		{"yaml", FormatYAML, false},
+		{"csv", FormatCSV, false},
+		{"xml", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseFormat(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseFormat(%q) = %q, %v; want %q, err=%v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestTableRender(t *testing.T) {
+	table := NewTable("NAME", "STATUS", "URL")
+	table.SetMaxWidth(2, 10)
+	table.SetColor(1, func(v string) string { return "<" + v + ">" })
+	table.AddRow("cloudflare", "connected", "https://example.trycloudflare.com")
+	table.AddRow("bore", "", "")
+
+	var buf bytes.Buffer
+	if err := table.Render(&buf); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	want := strings.Join([]string{
+		"NAME        STATUS     URL",
+		"cloudflare  <connected>  https://e…",
+		"bore",
+		"",
+	}, "\n")
+	if buf.String() != want {
+		t.Errorf("Render() =\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	table.NoHeaders = true
+	buf.Reset()
+	if err := table.Render(&buf); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if strings.Contains(buf.String(), "NAME") {
+		t.Errorf("Render() with NoHeaders printed headers:\n%s", buf.String())
+	}
+}
+
+func TestWriteYAMLUsesJSONNames(t *testing.T) {
+	data := struct {
+		ProviderName string `json:"provider_name"`
+		Count        int    `json:"count"`
+	}{"ngrok", 2}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, FormatYAML, data); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if got := buf.String(); !strings.Contains(got, "provider_name: ngrok") || !strings.Contains(got, "count: 2") {
+		t.Errorf("Write(yaml) = %q", got)
+	}
+
+	if err := Write(&buf, FormatTable, data); err == nil {
+		t.Error("Write(table) expected error")
+	}
+}
+
+func TestTableWriteCSV(t *testing.T) {
+	table := NewTable("NAME", "COMMENT")
+	table.SetMaxWidth(1, 5)
+	table.SetColor(1, func(v string) string { return "<" + v + ">" })
+	table.AddRow("alice", "laptop, work")
+
+	var buf bytes.Buffer
+	if err := table.WriteCSV(&buf); err != nil {
+		t.Fatalf("WriteCSV() error = %v", err)
+	}
+	if want := "NAME,COMMENT\nalice,\"laptop, work\"\n"; buf.String() != want {
+		t.Errorf("WriteCSV() = %q, want %q", buf.String(), want)
+	}
+}
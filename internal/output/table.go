@@ -0,0 +1,153 @@
+package output
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Column describes one table column
+type Column struct {
+	Header string
+
+	// MaxWidth truncates longer values with an ellipsis. Zero means unlimited.
+	MaxWidth int
+
+	// Color styles a cell's text after widths are computed, so escape codes
+	// do not affect alignment. It receives the text after truncation.
+	Color func(value string) string
+}
+
+// Table accumulates rows and renders them with aligned columns
+type Table struct {
+	Columns   []Column
+	NoHeaders bool
+	Indent    string
+
+	rows [][]string
+}
+
+// NewTable creates a table with the given column headers
+func NewTable(headers ...string) *Table {
+	t := &Table{}
+	for _, h := range headers {
+		t.Columns = append(t.Columns, Column{Header: h})
+	}
+	return t
+}
+
+// SetMaxWidth limits the width of the column at index col
+func (t *Table) SetMaxWidth(col, width int) *Table {
+	t.Columns[col].MaxWidth = width
+	return t
+}
+
+// SetColor styles the cells of the column at index col
+func (t *Table) SetColor(col int, fn func(value string) string) *Table {
+	t.Columns[col].Color = fn
+	return t
+}
+
+// AddRow appends a row. Missing cells are left blank and extra cells dropped.
+func (t *Table) AddRow(cells ...string) {
+	row := make([]string, len(t.Columns))
+	copy(row, cells)
+	t.rows = append(t.rows, row)
+}
+
+// Len returns the number of rows
+func (t *Table) Len() int {
+	return len(t.rows)
+}
+
+// Render writes the table to w
+func (t *Table) Render(w io.Writer) error {
+	widths := make([]int, len(t.Columns))
+	if !t.NoHeaders {
+		for i, col := range t.Columns {
+			widths[i] = utf8.RuneCountInString(col.Header)
+		}
+	}
+	for _, row := range t.rows {
+		for i, cell := range row {
+			if n := utf8.RuneCountInString(t.truncate(i, cell)); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+
+	if !t.NoHeaders {
+		headers := make([]string, len(t.Columns))
+		for i, col := range t.Columns {
+			headers[i] = col.Header
+		}
+		if err := t.renderLine(w, widths, headers, false); err != nil {
+			return err
+		}
+	}
+
+	for _, row := range t.rows {
+		if err := t.renderLine(w, widths, row, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteCSV writes the table as CSV, without truncation or color
+func (t *Table) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+
+	if !t.NoHeaders {
+		headers := make([]string, len(t.Columns))
+		for i, col := range t.Columns {
+			headers[i] = col.Header
+		}
+		if err := writer.Write(headers); err != nil {
+			return fmt.Errorf("write csv: %w", err)
+		}
+	}
+
+	if err := writer.WriteAll(t.rows); err != nil {
+		return fmt.Errorf("write csv: %w", err)
+	}
+	return nil
+}
+
+// renderLine writes one padded line, trimming trailing padding
+func (t *Table) renderLine(w io.Writer, widths []int, cells []string, styled bool) error {
+	var b strings.Builder
+	b.WriteString(t.Indent)
+
+	last := len(cells) - 1
+	for i, cell := range cells {
+		text := t.truncate(i, cell)
+		padding := ""
+		if i < last {
+			padding = strings.Repeat(" ", widths[i]-utf8.RuneCountInString(text)+2)
+		}
+		if styled && t.Columns[i].Color != nil && text != "" {
+			text = t.Columns[i].Color(text)
+		}
+		b.WriteString(text)
+		b.WriteString(padding)
+	}
+
+	_, err := fmt.Fprintln(w, strings.TrimRight(b.String(), " "))
+	return err
+}
+
+// truncate shortens a cell to its column's maximum width
+func (t *Table) truncate(col int, value string) string {
+	max := t.Columns[col].MaxWidth
+	if max <= 0 || utf8.RuneCountInString(value) <= max {
+		return value
+	}
+	if max == 1 {
+		return "…"
+	}
+	runes := []rune(value)
+	return string(runes[:max-1]) + "…"
+}
@@ -0,0 +1,178 @@
+// Package probe checks from somewhere else that a tunnel's public endpoint
+// answers. A probe is asked over HTTP to connect to a host and port and
+// read the SSH banner there; another TUNNEL node answers such requests at
+// /api/probe, and any service speaking the same JSON can stand in for one.
+package probe
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/offline"
+)
+
+// DefaultTimeout bounds a check when the request sets none
+const DefaultTimeout = 10 * time.Second
+
+// maxTimeout bounds the timeout a request may ask for
+const maxTimeout = time.Minute
+
+// Request asks a probe to check the SSH server at Host:Port
+type Request struct {
+	Host    string `json:"host"`
+	Port    int    `json:"port"`
+	Timeout int    `json:"timeout,omitempty"` // seconds; 0 uses DefaultTimeout
+}
+
+// Validate checks that r names an endpoint
+func (r Request) Validate() error {
+	switch {
+	case r.Host == "":
+		return fmt.Errorf("host is required")
+	case r.Port < 1 || r.Port > 65535:
+		return fmt.Errorf("invalid port %d", r.Port)
+	case r.Timeout < 0:
+		return fmt.Errorf("invalid timeout %d", r.Timeout)
+	}
+	return nil
+}
+
+// Address is r's endpoint as host:port
+func (r Request) Address() string {
+	return net.JoinHostPort(r.Host, strconv.Itoa(r.Port))
+}
+
+func (r Request) timeout() time.Duration {
+	d := time.Duration(r.Timeout) * time.Second
+	if d <= 0 {
+		return DefaultTimeout
+	}
+	if d > maxTimeout {
+		return maxTimeout
+	}
+	return d
+}
+
+// Result is what a probe found
+type Result struct {
+	Probe     string `json:"probe,omitempty"` // who checked, e.g. the node's hostname
+	Address   string `json:"address"`
+	Reachable bool   `json:"reachable"`        // an SSH server answered
+	Banner    string `json:"banner,omitempty"` // its identification line
+	LatencyMS int64  `json:"latency_ms"`       // from dialing to the banner
+	Error     string `json:"error,omitempty"`  // why the check failed
+}
+
+// CheckSSH connects to the endpoint of req and reads its SSH banner
+func CheckSSH(ctx context.Context, req Request) Result {
+	res := Result{Address: req.Address()}
+	ctx, cancel := context.WithTimeout(ctx, req.timeout())
+	defer cancel()
+
+	start := time.Now()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", res.Address)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetReadDeadline(deadline)
+	}
+
+	// Servers may send other lines before the identification (RFC 4253)
+	r := bufio.NewReader(io.LimitReader(conn, 8192))
+	var first string
+	for {
+		line, err := r.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if strings.HasPrefix(line, "SSH-") {
+			res.Reachable = true
+			res.Banner = line
+			res.LatencyMS = time.Since(start).Milliseconds()
+			return res
+		}
+		if first == "" {
+			first = line
+		}
+		if err != nil {
+			if first != "" {
+				res.Error = fmt.Sprintf("not an SSH server: %q", first)
+			} else {
+				res.Error = fmt.Sprintf("no SSH banner: %v", err)
+			}
+			return res
+		}
+	}
+}
+
+// Client asks a remote probe to check an endpoint
+type Client struct {
+	Name  string // how results from this probe are labelled
+	URL   string // where the probe takes requests, e.g. https://node-b:8080/api/probe
+	Token string // bearer token, if the probe needs one
+
+	HTTP *http.Client // nil uses a client with a timeout above the check's
+}
+
+// Verify asks the probe to check req. An error means the probe itself could
+// not be asked; whether the endpoint answered is in the result.
+func (c *Client) Verify(ctx context.Context, req Request) (Result, error) {
+	if err := offline.Check("remote probe"); err != nil {
+		return Result{}, fmt.Errorf("probe %s: %w", c.Name, err)
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return Result{}, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return Result{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if c.Token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	client := c.HTTP
+	if client == nil {
+		client = &http.Client{Timeout: req.timeout() + 10*time.Second}
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return Result{}, fmt.Errorf("probe %s: %w", c.Name, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return Result{}, fmt.Errorf("probe %s: %w", c.Name, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error != "" {
+			return Result{}, fmt.Errorf("probe %s: %s (%d)", c.Name, apiErr.Error, resp.StatusCode)
+		}
+		return Result{}, fmt.Errorf("probe %s: %s", c.Name, resp.Status)
+	}
+
+	var res Result
+	if err := json.Unmarshal(data, &res); err != nil {
+		return Result{}, fmt.Errorf("probe %s: invalid response: %w", c.Name, err)
+	}
+	// Label results as configured rather than by the probe's hostname
+	res.Probe = c.Name
+	return res, nil
+}
@@ -0,0 +1,109 @@
+package probe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jedarden/tunnel/internal/offline"
+)
+
+// serve answers each connection to a local listener with greeting
+func serve(t *testing.T, greeting string) Request {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(greeting))
+			conn.Close()
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	return Request{Host: "127.0.0.1", Port: addr.Port, Timeout: 2}
+}
+
+func TestCheckSSH(t *testing.T) {
+	res := CheckSSH(context.Background(), serve(t, "welcome to the relay\r\nSSH-2.0-OpenSSH_9.6\r\n"))
+	if !res.Reachable || res.Banner != "SSH-2.0-OpenSSH_9.6" || res.Error != "" {
+		t.Errorf("ssh server: %+v", res)
+	}
+
+	res = CheckSSH(context.Background(), serve(t, "HTTP/1.1 400 Bad Request\r\n"))
+	if res.Reachable || !strings.Contains(res.Error, "HTTP/1.1") {
+		t.Errorf("http server: %+v", res)
+	}
+
+	closed := serve(t, "")
+	res = CheckSSH(context.Background(), closed)
+	if res.Reachable || !strings.Contains(res.Error, "no SSH banner") {
+		t.Errorf("silent server: %+v", res)
+	}
+
+	if err := (Request{Host: "bore.pub"}).Validate(); err == nil {
+		t.Error("a request without a port should not validate")
+	}
+}
+
+func TestClientVerify(t *testing.T) {
+	target := serve(t, "SSH-2.0-tunnel\r\n")
+	probe := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"Invalid API token"}`))
+			return
+		}
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(CheckSSH(r.Context(), req))
+	}))
+	defer probe.Close()
+
+	c := &Client{Name: "node-b", URL: probe.URL, Token: "s3cret"}
+	res, err := c.Verify(context.Background(), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Reachable || res.Probe != "node-b" || res.Banner != "SSH-2.0-tunnel" {
+		t.Errorf("result = %+v", res)
+	}
+
+	c.Token = "wrong"
+	if _, err := c.Verify(context.Background(), target); err == nil || !strings.Contains(err.Error(), "Invalid API token") {
+		t.Errorf("wrong token: %v", err)
+	}
+}
+
+func TestClientVerifyOffline(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	offline.SetEnabled(true)
+	defer offline.SetEnabled(false)
+
+	client := &Client{Name: "node-b", URL: server.URL}
+	if _, err := client.Verify(context.Background(), Request{Host: "example.com", Port: 22}); !errors.Is(err, offline.ErrOffline) {
+		t.Errorf("Verify() error = %v, want ErrOffline", err)
+	}
+	if called {
+		t.Error("the probe was asked in offline mode")
+	}
+}
@@ -58,6 +58,22 @@ func (b *BastionProvider) Connect() error {
 	return nil
 }
 
+// PlanConnect describes how Connect makes sure sshd is running
+func (b *BastionProvider) PlanConnect() (*providers.Plan, error) {
+	plan := providers.NewPlan(b, providers.ActionConnect)
+	plan.Run("pgrep", "sshd")
+	plan.Run("sudo", "systemctl", "start", "sshd").Condition = "sshd is not running"
+	plan.Port(22, "tcp", "local", "sshd, for jumping through this host")
+	return plan, nil
+}
+
+// PlanDisconnect notes that Disconnect leaves sshd running
+func (b *BastionProvider) PlanDisconnect() (*providers.Plan, error) {
+	plan := providers.NewPlan(b, providers.ActionDisconnect)
+	plan.Note("sshd is left running")
+	return plan, nil
+}
+
 // Disconnect stops bastion mode
 func (b *BastionProvider) Disconnect() error {
 	// In bastion mode, we typically don't stop SSH
@@ -84,6 +100,7 @@ func (b *BastionProvider) GetConnectionInfo() (*providers.ConnectionInfo, error)
 		info.Extra["type"] = "bastion-host"
 		info.Extra["mode"] = "jump-server"
 		info.Extra["port"] = 22
+		info.LocalPort = 22
 	}
 
 	return info, nil
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/jedarden/tunnel/internal/offline"
 	"github.com/jedarden/tunnel/internal/providers"
 )
 
@@ -23,6 +24,25 @@ func New() *BoreProvider {
 	}
 }
 
+// RequiresInternet reports that bore needs internet access to connect
+func (b *BoreProvider) RequiresInternet() bool {
+	return true
+}
+
+// ProcessPatterns matches bore client processes for resource monitoring
+func (b *BoreProvider) ProcessPatterns() []string {
+	return []string{"bore local"}
+}
+
+// Endpoints returns the bore server tunnels are opened on
+func (b *BoreProvider) Endpoints() []string {
+	host := "bore.pub"
+	if config, err := b.GetConfig(); err == nil && config.RemoteHost != "" {
+		host = config.RemoteHost
+	}
+	return []string{host}
+}
+
 // Install installs bore
 func (b *BoreProvider) Install() error {
 	if b.IsInstalled() {
@@ -86,6 +106,10 @@ func (b *BoreProvider) IsInstalled() bool {
 
 // Connect establishes a bore tunnel
 func (b *BoreProvider) Connect() error {
+	if err := offline.Check(b.Name()); err != nil {
+		return err
+	}
+
 	if !b.IsInstalled() {
 		return providers.ErrNotInstalled
 	}
@@ -95,29 +119,15 @@ func (b *BoreProvider) Connect() error {
 		return err
 	}
 
-	// Default to port 22 for SSH if not specified
-	localPort := config.LocalPort
-	if localPort == 0 {
-		localPort = 22
-	}
-
-	// Default to bore.pub as remote host
-	remoteHost := config.RemoteHost
-	if remoteHost == "" {
-		remoteHost = "bore.pub"
+	// Start bore in background
+	cmd, err := providers.Command(b.Name(), commandData(config, config.AuthToken))
+	if err != nil {
+		return fmt.Errorf("%w: %v", providers.ErrConnectionFailed, err)
 	}
-
-	// Build bore command
-	args := []string{"local", fmt.Sprintf("%d", localPort), "--to", remoteHost}
-
-	// Add remote port if specified
-	if config.RemotePort > 0 {
-		args = append(args, "--port", fmt.Sprintf("%d", config.RemotePort))
+	if err := providers.Sandbox(b.Name(), cmd); err != nil {
+		return fmt.Errorf("%w: %v", providers.ErrConnectionFailed, err)
 	}
 
-	// Start bore in background
-	cmd := exec.Command("bore", args...)
-
 	// Capture output to extract tunnel URL
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -147,6 +157,104 @@ func (b *BoreProvider) Connect() error {
 	return nil
 }
 
+// listeningRE matches bore's report of the remote address, e.g.
+// "listening at bore.pub:12345"
+var listeningRE = regexp.MustCompile(`listening at ([a-zA-Z0-9.-]+):(\d+)`)
+
+// StartForward runs another bore client exposing f.LocalPort on the
+// configured bore server, or f.RemoteHost
+func (b *BoreProvider) StartForward(f providers.Forward) (*providers.ForwardProcess, error) {
+	if err := offline.Check(b.Name()); err != nil {
+		return nil, err
+	}
+	if !b.IsInstalled() {
+		return nil, providers.ErrNotInstalled
+	}
+
+	config, err := b.GetConfig()
+	if err != nil {
+		config = &providers.ProviderConfig{Name: b.Name()}
+	}
+	forward := *config
+	forward.LocalPort, forward.RemotePort = f.LocalPort, f.RemotePort
+	if f.RemoteHost != "" {
+		forward.RemoteHost = f.RemoteHost
+	}
+
+	cmd, err := providers.Command(b.Name(), commandData(&forward, forward.AuthToken))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", providers.ErrConnectionFailed, err)
+	}
+	proc, err := providers.LaunchForward(b.Name(), cmd, "", 2*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if matches := listeningRE.FindStringSubmatch(proc.Output()); len(matches) > 2 {
+		proc.SetAddress(matches[1] + ":" + matches[2])
+	}
+	return proc, nil
+}
+
+// commandData describes the bore command line for config, with secret
+// standing in for the bore server's secret
+func commandData(config *providers.ProviderConfig, secret string) providers.CommandData {
+	// Default to port 22 for SSH if not specified
+	localPort := config.LocalPort
+	if localPort == 0 {
+		localPort = 22
+	}
+
+	// Default to bore.pub as remote host
+	remoteHost := config.RemoteHost
+	if remoteHost == "" {
+		remoteHost = "bore.pub"
+	}
+
+	args := []string{"local", fmt.Sprintf("%d", localPort), "--to", remoteHost}
+
+	// Add remote port if specified
+	if config.RemotePort > 0 {
+		args = append(args, "--port", fmt.Sprintf("%d", config.RemotePort))
+	}
+	return providers.CommandData{
+		Binary:     "bore",
+		Args:       args,
+		Port:       localPort,
+		RemoteHost: remoteHost,
+		RemotePort: config.RemotePort,
+		Token:      secret,
+		ConfigFile: config.ConfigFile,
+	}
+}
+
+// PlanConnect describes the bore client Connect would start
+func (b *BoreProvider) PlanConnect() (*providers.Plan, error) {
+	config, err := b.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	data := commandData(config, providers.Redact(config.AuthToken))
+	plan := providers.NewPlan(b, providers.ActionConnect)
+	if err := plan.Launch(data, true); err != nil {
+		return nil, err
+	}
+	plan.Port(data.Port, "tcp", "local", "exposed through bore")
+	if config.RemotePort > 0 {
+		plan.Port(config.RemotePort, "tcp", "remote", "requested on the bore server")
+	} else {
+		plan.Note("the bore server assigns the remote port")
+	}
+	return plan, nil
+}
+
+// PlanDisconnect describes how Disconnect stops the bore client
+func (b *BoreProvider) PlanDisconnect() (*providers.Plan, error) {
+	plan := providers.NewPlan(b, providers.ActionDisconnect)
+	plan.Run("pkill", "-f", "bore local")
+	return plan, nil
+}
+
 // Disconnect terminates the bore tunnel
 func (b *BoreProvider) Disconnect() error {
 	if !b.IsInstalled() {
@@ -187,16 +295,15 @@ func (b *BoreProvider) GetConnectionInfo() (*providers.ConnectionInfo, error) {
 
 	if b.tunnelURL != "" {
 		info.TunnelURL = b.tunnelURL
-
-		// Parse host and port
-		parts := strings.Split(b.tunnelURL, ":")
-		if len(parts) == 2 {
-			info.RemoteIP = parts[0]
-		}
+		info.RemoteIP, info.RemotePort = providers.SplitHostPort(b.tunnelURL)
 	}
 
 	config, err := b.GetConfig()
 	if err == nil {
+		info.LocalPort = config.LocalPort
+		if info.LocalPort == 0 {
+			info.LocalPort = 22
+		}
 		info.Extra["local_port"] = config.LocalPort
 		info.Extra["remote_host"] = config.RemoteHost
 	}
@@ -206,6 +313,10 @@ func (b *BoreProvider) GetConnectionInfo() (*providers.ConnectionInfo, error) {
 
 // HealthCheck performs a health check
 func (b *BoreProvider) HealthCheck() (*providers.HealthStatus, error) {
+	if offline.Enabled() {
+		return providers.OfflineHealthStatus(b.Name()), nil
+	}
+
 	if !b.IsInstalled() {
 		return &providers.HealthStatus{
 			Healthy:   false,
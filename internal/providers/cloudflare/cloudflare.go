@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/jedarden/tunnel/internal/offline"
 	"github.com/jedarden/tunnel/internal/providers"
 )
 
@@ -22,6 +23,22 @@ func New() *CloudflareProvider {
 	}
 }
 
+// RequiresInternet reports that Cloudflare Tunnel needs internet access to connect
+func (c *CloudflareProvider) RequiresInternet() bool {
+	return true
+}
+
+// ProcessPatterns matches cloudflared tunnel processes for resource monitoring
+func (c *CloudflareProvider) ProcessPatterns() []string {
+	return []string{"cloudflared tunnel run"}
+}
+
+// Endpoints returns the edge cloudflared registers the tunnel with and the
+// API it is managed through
+func (c *CloudflareProvider) Endpoints() []string {
+	return []string{"region1.v2.argotunnel.com", "region2.v2.argotunnel.com", "api.cloudflare.com"}
+}
+
 // Install installs cloudflared
 func (c *CloudflareProvider) Install() error {
 	if c.IsInstalled() {
@@ -78,6 +95,10 @@ func (c *CloudflareProvider) IsInstalled() bool {
 
 // Connect establishes a Cloudflare Tunnel connection
 func (c *CloudflareProvider) Connect() error {
+	if err := offline.Check(c.Name()); err != nil {
+		return err
+	}
+
 	if !c.IsInstalled() {
 		return providers.ErrNotInstalled
 	}
@@ -87,33 +108,81 @@ func (c *CloudflareProvider) Connect() error {
 		return err
 	}
 
+	data, err := commandData(config, config.AuthToken)
+	if err != nil {
+		return err
+	}
+
+	// Start tunnel as background process
+	cmd, err := providers.Command(c.Name(), data)
+	if err != nil {
+		return fmt.Errorf("%w: %v", providers.ErrConnectionFailed, err)
+	}
+	if err := providers.Sandbox(c.Name(), cmd); err != nil {
+		return fmt.Errorf("%w: %v", providers.ErrConnectionFailed, err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("%w: %v", providers.ErrConnectionFailed, err)
+	}
+
+	// Give it a moment to start
+	time.Sleep(2 * time.Second)
+
+	return nil
+}
+
+// commandData describes the cloudflared command line for config, with
+// token standing in for its auth token
+func commandData(config *providers.ProviderConfig, token string) (providers.CommandData, error) {
 	// Need either a token OR a tunnel name
 	if config.AuthToken == "" && config.TunnelName == "" {
-		return fmt.Errorf("tunnel token or tunnel name is required")
+		return providers.CommandData{}, fmt.Errorf("tunnel token or tunnel name is required")
 	}
 
-	// Start tunnel as background process
 	args := []string{"tunnel", "run"}
-
 	if config.AuthToken != "" {
 		// When using a token, the token contains all tunnel info
 		// Command: cloudflared tunnel run --token <token>
-		args = append(args, "--token", config.AuthToken)
+		args = append(args, "--token", token)
 	} else {
 		// When using tunnel name (requires prior cloudflared login)
 		// Command: cloudflared tunnel run <tunnel_name>
 		args = append(args, config.TunnelName)
 	}
+	return providers.CommandData{
+		Binary:     "cloudflared",
+		Args:       args,
+		Port:       config.LocalPort,
+		Token:      token,
+		ConfigFile: config.ConfigFile,
+		TunnelName: config.TunnelName,
+	}, nil
+}
 
-	cmd := exec.Command("cloudflared", args...)
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("%w: %v", providers.ErrConnectionFailed, err)
+// PlanConnect describes the cloudflared process Connect would start
+func (c *CloudflareProvider) PlanConnect() (*providers.Plan, error) {
+	config, err := c.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	data, err := commandData(config, providers.Redact(config.AuthToken))
+	if err != nil {
+		return nil, err
 	}
 
-	// Give it a moment to start
-	time.Sleep(2 * time.Second)
+	plan := providers.NewPlan(c, providers.ActionConnect)
+	if err := plan.Launch(data, true); err != nil {
+		return nil, err
+	}
+	plan.Note("cloudflared only connects outbound; what it exposes comes from the tunnel's ingress rules")
+	return plan, nil
+}
 
-	return nil
+// PlanDisconnect describes how Disconnect stops cloudflared
+func (c *CloudflareProvider) PlanDisconnect() (*providers.Plan, error) {
+	plan := providers.NewPlan(c, providers.ActionDisconnect)
+	plan.Run("pkill", "-f", "cloudflared tunnel run")
+	return plan, nil
 }
 
 // Disconnect terminates the Cloudflare Tunnel connection
@@ -162,6 +231,10 @@ func (c *CloudflareProvider) GetConnectionInfo() (*providers.ConnectionInfo, err
 
 // HealthCheck performs a health check
 func (c *CloudflareProvider) HealthCheck() (*providers.HealthStatus, error) {
+	if offline.Enabled() {
+		return providers.OfflineHealthStatus(c.Name()), nil
+	}
+
 	if !c.IsInstalled() {
 		return &providers.HealthStatus{
 			Healthy:   false,
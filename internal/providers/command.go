@@ -0,0 +1,100 @@
+package providers
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+
+	"github.com/jedarden/tunnel/internal/cmdtemplate"
+)
+
+// CommandData describes the command a provider launches, for rendering a
+// command template configured in its place
+type CommandData = cmdtemplate.Data
+
+var commandTemplates = struct {
+	sync.RWMutex
+	templates map[string]*cmdtemplate.Template
+}{templates: make(map[string]*cmdtemplate.Template)}
+
+// SetCommandTemplate replaces the command line provider launches from now
+// on with tmpl. A nil template restores the provider's own.
+func SetCommandTemplate(provider string, tmpl *cmdtemplate.Template) {
+	commandTemplates.Lock()
+	defer commandTemplates.Unlock()
+	if tmpl == nil {
+		delete(commandTemplates.templates, provider)
+		return
+	}
+	commandTemplates.templates[provider] = tmpl
+}
+
+func commandTemplate(provider string) *cmdtemplate.Template {
+	commandTemplates.RLock()
+	defer commandTemplates.RUnlock()
+	return commandTemplates.templates[provider]
+}
+
+// CommandLine returns the binary and arguments provider launches: data's
+// own, or those of the provider's command template rendered with data
+func CommandLine(provider string, data CommandData) (string, []string, error) {
+	tmpl := commandTemplate(provider)
+	if tmpl == nil {
+		return data.Binary, data.Args, nil
+	}
+	data.TokenPath = tokenPath(provider)
+	argv, err := tmpl.Render(data)
+	if err != nil {
+		return "", nil, fmt.Errorf("render %s command template: %w", provider, err)
+	}
+	return argv[0], argv[1:], nil
+}
+
+// Command builds the command provider launches with data, writing its
+// token to TokenPath first if its command template needs it there
+func Command(provider string, data CommandData) (*exec.Cmd, error) {
+	if tmpl := commandTemplate(provider); tmpl != nil {
+		return TemplateCommand(provider, tmpl, data)
+	}
+	return exec.Command(data.Binary, data.Args...), nil
+}
+
+// TemplateCommand builds the command tmpl renders for provider with data,
+// writing the token to TokenPath first if tmpl needs it there
+func TemplateCommand(provider string, tmpl *cmdtemplate.Template, data CommandData) (*exec.Cmd, error) {
+	data.TokenPath = tokenPath(provider)
+	argv, err := tmpl.Render(data)
+	if err != nil {
+		return nil, fmt.Errorf("render %s command template: %w", provider, err)
+	}
+	if tmpl.UsesTokenPath() {
+		if data.Token == "" {
+			return nil, fmt.Errorf("the %s command uses TokenPath, but %s has no token configured", provider, provider)
+		}
+		if err := writeToken(data.TokenPath, data.Token); err != nil {
+			return nil, err
+		}
+	}
+	return exec.Command(argv[0], argv[1:]...), nil
+}
+
+// tokenPath is where provider's token is written for its command template
+func tokenPath(provider string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "tunnel", provider+".token")
+}
+
+func writeToken(path, token string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("create token directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(token), 0600); err != nil {
+		return fmt.Errorf("write token file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package providers
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// forwardStopTimeout is how long a forward's client has to exit after an
+// interrupt before it is killed
+const forwardStopTimeout = 5 * time.Second
+
+// maxForwardOutput bounds the client output a ForwardProcess keeps
+const maxForwardOutput = 4096
+
+// Forward is a local port to expose through a provider
+type Forward struct {
+	LocalPort  int
+	RemoteHost string // empty uses the provider's configured host
+	RemotePort int    // 0 lets the provider pick
+}
+
+// ForwardProvider is implemented by providers that can expose any local
+// port besides the one their own connection carries. Every forward runs a
+// client of its own, so a provider can carry several at once.
+type ForwardProvider interface {
+	StartForward(f Forward) (*ForwardProcess, error)
+}
+
+// StartForward starts f through p, if p can forward arbitrary ports
+func StartForward(p Provider, f Forward) (*ForwardProcess, error) {
+	fp, ok := p.(ForwardProvider)
+	if !ok {
+		return nil, fmt.Errorf("%s can't forward other local ports", p.Name())
+	}
+	return fp.StartForward(f)
+}
+
+// CanForward reports whether p can forward arbitrary local ports
+func CanForward(p Provider) bool {
+	_, ok := p.(ForwardProvider)
+	return ok
+}
+
+// ForwardProcess is the client process carrying one forward
+type ForwardProcess struct {
+	cmd    *exec.Cmd
+	exited chan struct{}
+
+	mu      sync.Mutex
+	address string
+	output  []byte
+	err     error // how the client exited
+}
+
+// LaunchForward starts cmd, sandboxed like provider's own client, and
+// waits settle for it to fail early, as a client refused by its server
+// does. address is where the forward is reachable, if already known.
+func LaunchForward(provider string, cmd *exec.Cmd, address string, settle time.Duration) (*ForwardProcess, error) {
+	p := &ForwardProcess{cmd: cmd, exited: make(chan struct{}), address: address}
+	cmd.Stdout = p
+	cmd.Stderr = p
+	if err := Sandbox(provider, cmd); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrConnectionFailed, err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrConnectionFailed, err)
+	}
+	go p.wait()
+
+	select {
+	case <-p.exited:
+		return nil, fmt.Errorf("%w: %s exited: %s", ErrConnectionFailed, cmd.Args[0], p.lastLine())
+	case <-time.After(settle):
+	}
+	return p, nil
+}
+
+func (p *ForwardProcess) wait() {
+	err := p.cmd.Wait()
+	p.mu.Lock()
+	p.err = err
+	p.mu.Unlock()
+	close(p.exited)
+}
+
+// Write collects the client's output
+func (p *ForwardProcess) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.output = append(p.output, b...)
+	if len(p.output) > maxForwardOutput {
+		p.output = p.output[len(p.output)-maxForwardOutput:]
+	}
+	return len(b), nil
+}
+
+// Output returns what the client has printed, up to the last few KB
+func (p *ForwardProcess) Output() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return string(p.output)
+}
+
+func (p *ForwardProcess) lastLine() string {
+	lines := strings.Split(strings.TrimSpace(p.Output()), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// SetAddress records where the forward is reachable, once the client says
+func (p *ForwardProcess) SetAddress(address string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.address = address
+}
+
+// Address returns where the forward is reachable, or "" if not known
+func (p *ForwardProcess) Address() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.address
+}
+
+// PID returns the client's process ID
+func (p *ForwardProcess) PID() int {
+	return p.cmd.Process.Pid
+}
+
+// Running reports whether the client is still running
+func (p *ForwardProcess) Running() bool {
+	select {
+	case <-p.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+// Err returns why the client exited, once it has
+func (p *ForwardProcess) Err() error {
+	if p.Running() {
+		return nil
+	}
+	p.mu.Lock()
+	err := p.err
+	p.mu.Unlock()
+	if err != nil {
+		if line := p.lastLine(); line != "" {
+			return fmt.Errorf("%v: %s", err, line)
+		}
+	}
+	return err
+}
+
+// Stop interrupts the client, killing it if it doesn't exit in time
+func (p *ForwardProcess) Stop() error {
+	if !p.Running() {
+		return nil
+	}
+	if err := p.cmd.Process.Signal(os.Interrupt); err != nil {
+		_ = p.cmd.Process.Kill()
+	}
+	select {
+	case <-p.exited:
+	case <-time.After(forwardStopTimeout):
+		_ = p.cmd.Process.Kill()
+		<-p.exited
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+//go:build !windows
+
+package providers
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLaunchForward(t *testing.T) {
+	// A client refused by its server fails the launch with its last words
+	_, err := LaunchForward("test", exec.Command("sh", "-c", "echo connecting; echo 'port 4000 already in use' >&2; exit 1"), "", 2*time.Second)
+	if !errors.Is(err, ErrConnectionFailed) || !strings.Contains(err.Error(), "port 4000 already in use") {
+		t.Errorf("failed launch = %v", err)
+	}
+
+	proc, err := LaunchForward("test", exec.Command("sh", "-c", "echo 'listening at relay:4000'; exec sleep 30"), "relay:4000", 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proc.Running() || proc.Address() != "relay:4000" || !strings.Contains(proc.Output(), "listening at") {
+		t.Errorf("running forward: running %v, address %q, output %q", proc.Running(), proc.Address(), proc.Output())
+	}
+	if err := proc.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if proc.Running() || proc.Err() == nil {
+		t.Errorf("stopped forward: running %v, err %v", proc.Running(), proc.Err())
+	}
+}
@@ -0,0 +1,121 @@
+package providers
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Identity names a built-in provider. Name is the one identifier used by
+// the registry, config keys and the CLI; everything else maps onto it.
+type Identity struct {
+	Name        string   // registry, config and CLI identifier
+	DisplayName string   // human-readable name for UI and reports
+	Aliases     []string // short spellings accepted anywhere a name is
+	Deprecated  []string // names from earlier releases, accepted with a warning
+}
+
+// identities lists every built-in provider. Deprecated names stay for at
+// least one release after a rename so both spellings work while users update
+// scripts and config files.
+var identities = []Identity{
+	{Name: "tailscale", DisplayName: "Tailscale", Aliases: []string{"ts"}},
+	{Name: "wireguard", DisplayName: "WireGuard", Aliases: []string{"wg"}},
+	{Name: "zerotier", DisplayName: "ZeroTier", Aliases: []string{"zt"}},
+	{Name: "cloudflare", DisplayName: "Cloudflare Tunnel", Aliases: []string{"cf"}, Deprecated: []string{"cloudflared"}},
+	{Name: "ngrok", DisplayName: "ngrok"},
+	{Name: "bore", DisplayName: "bore"},
+	{Name: "vscode-tunnel", DisplayName: "VS Code Tunnel", Aliases: []string{"vscode"}, Deprecated: []string{"vscodetunnel"}},
+	{Name: "ssh-forward", DisplayName: "SSH Forward", Deprecated: []string{"sshforward"}},
+	{Name: "reverse-ssh", DisplayName: "Reverse SSH", Aliases: []string{"ssh-reverse"}, Deprecated: []string{"reversessh"}},
+	{Name: "bastion", DisplayName: "Bastion Host", Aliases: []string{"jump"}},
+}
+
+// Identities returns the identities of all built-in providers
+func Identities() []Identity {
+	return append([]Identity(nil), identities...)
+}
+
+// LookupIdentity finds a built-in provider by name, alias or deprecated
+// name, ignoring case
+func LookupIdentity(name string) (Identity, bool) {
+	name = strings.ToLower(name)
+	for _, id := range identities {
+		if id.Name == name || contains(id.Aliases, name) || contains(id.Deprecated, name) {
+			return id, true
+		}
+	}
+	return Identity{}, false
+}
+
+// DisplayName returns the human-readable name of a provider, or name itself
+// for providers without an identity, such as plugins
+func DisplayName(name string) string {
+	if id, ok := LookupIdentity(name); ok {
+		return id.DisplayName
+	}
+	return name
+}
+
+// CanonicalName returns the registry name for name and whether name is a
+// deprecated spelling. Aliases resolve silently; unknown names are returned
+// unchanged.
+func CanonicalName(name string) (string, bool) {
+	id, ok := LookupIdentity(name)
+	if !ok {
+		return name, false
+	}
+	return id.Name, contains(id.Deprecated, strings.ToLower(name))
+}
+
+// DeprecatedNames returns the deprecated names of a provider
+func DeprecatedNames(canonical string) []string {
+	if id, ok := LookupIdentity(canonical); ok {
+		return append([]string(nil), id.Deprecated...)
+	}
+	return nil
+}
+
+// CanonicalConfigKey translates a "providers.<name>..." config key that uses
+// a deprecated provider name, reporting whether it did
+func CanonicalConfigKey(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(strings.ToLower(key), "providers.")
+	if !ok {
+		return key, false
+	}
+	name, sub, _ := strings.Cut(rest, ".")
+	canonical, deprecated := CanonicalName(name)
+	if !deprecated {
+		return key, false
+	}
+	if sub == "" {
+		return "providers." + canonical, true
+	}
+	return "providers." + canonical + "." + sub, true
+}
+
+// deprecationOutput receives deprecation warnings
+var deprecationOutput io.Writer = os.Stderr
+
+var warned sync.Map
+
+// WarnDeprecated prints a warning, once per process, that old has been
+// replaced by replacement. kind describes what was renamed, e.g.
+// "provider name" or "config key".
+func WarnDeprecated(kind, old, replacement string) {
+	if _, seen := warned.LoadOrStore(kind+"\x00"+old, true); seen {
+		return
+	}
+	fmt.Fprintf(deprecationOutput, "Warning: %s %q is deprecated; use %q instead\n", kind, old, replacement)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -9,6 +9,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/jedarden/tunnel/internal/offline"
 	"github.com/jedarden/tunnel/internal/providers"
 )
 
@@ -26,6 +27,21 @@ func New() *NgrokProvider {
 	}
 }
 
+// RequiresInternet reports that ngrok needs internet access to connect
+func (n *NgrokProvider) RequiresInternet() bool {
+	return true
+}
+
+// ProcessPatterns matches ngrok agent processes for resource monitoring
+func (n *NgrokProvider) ProcessPatterns() []string {
+	return []string{"ngrok tcp"}
+}
+
+// Endpoints returns the ngrok agent's ingress
+func (n *NgrokProvider) Endpoints() []string {
+	return []string{"connect.ngrok-agent.com"}
+}
+
 // Install installs ngrok
 func (n *NgrokProvider) Install() error {
 	if n.IsInstalled() {
@@ -82,6 +98,10 @@ func (n *NgrokProvider) IsInstalled() bool {
 
 // Connect establishes an ngrok tunnel
 func (n *NgrokProvider) Connect() error {
+	if err := offline.Check(n.Name()); err != nil {
+		return err
+	}
+
 	if !n.IsInstalled() {
 		return providers.ErrNotInstalled
 	}
@@ -99,15 +119,14 @@ func (n *NgrokProvider) Connect() error {
 		}
 	}
 
-	// Default to port 22 for SSH if not specified
-	port := config.LocalPort
-	if port == 0 {
-		port = 22
-	}
-
 	// Start ngrok TCP tunnel in background
-	args := []string{"tcp", fmt.Sprintf("%d", port), "--log", "stdout"}
-	cmd := exec.Command("ngrok", args...)
+	cmd, err := providers.Command(n.Name(), commandData(config, config.AuthToken))
+	if err != nil {
+		return fmt.Errorf("%w: %v", providers.ErrConnectionFailed, err)
+	}
+	if err := providers.Sandbox(n.Name(), cmd); err != nil {
+		return fmt.Errorf("%w: %v", providers.ErrConnectionFailed, err)
+	}
 
 	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("%w: %v", providers.ErrConnectionFailed, err)
@@ -119,6 +138,52 @@ func (n *NgrokProvider) Connect() error {
 	return nil
 }
 
+// localPort is the port ngrok forwards, SSH unless configured otherwise
+func localPort(config *providers.ProviderConfig) int {
+	if config.LocalPort == 0 {
+		return 22
+	}
+	return config.LocalPort
+}
+
+// commandData describes the ngrok command line for config, with token
+// standing in for its auth token
+func commandData(config *providers.ProviderConfig, token string) providers.CommandData {
+	return providers.CommandData{
+		Binary:     "ngrok",
+		Args:       []string{"tcp", fmt.Sprintf("%d", localPort(config)), "--log", "stdout"},
+		Port:       localPort(config),
+		Token:      token,
+		ConfigFile: config.ConfigFile,
+	}
+}
+
+// PlanConnect describes the ngrok commands Connect would run
+func (n *NgrokProvider) PlanConnect() (*providers.Plan, error) {
+	config, err := n.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	plan := providers.NewPlan(n, providers.ActionConnect)
+	if config.AuthToken != "" {
+		plan.Run("ngrok", "config", "add-authtoken", providers.Redact(config.AuthToken))
+	}
+	if err := plan.Launch(commandData(config, providers.Redact(config.AuthToken)), true); err != nil {
+		return nil, err
+	}
+	plan.Port(localPort(config), "tcp", "local", "exposed through ngrok")
+	plan.Note("ngrok assigns the public address")
+	return plan, nil
+}
+
+// PlanDisconnect describes how Disconnect stops ngrok
+func (n *NgrokProvider) PlanDisconnect() (*providers.Plan, error) {
+	plan := providers.NewPlan(n, providers.ActionDisconnect)
+	plan.Run("pkill", "-f", "ngrok tcp")
+	return plan, nil
+}
+
 // Disconnect terminates the ngrok tunnel
 func (n *NgrokProvider) Disconnect() error {
 	if !n.IsInstalled() {
@@ -171,10 +236,7 @@ func (n *NgrokProvider) GetConnectionInfo() (*providers.ConnectionInfo, error) {
 		// Extract host and port from public URL
 		// e.g., tcp://0.tcp.ngrok.io:12345
 		if strings.HasPrefix(tunnel.PublicURL, "tcp://") {
-			parts := strings.Split(strings.TrimPrefix(tunnel.PublicURL, "tcp://"), ":")
-			if len(parts) == 2 {
-				info.RemoteIP = parts[0]
-			}
+			info.RemoteIP, info.RemotePort = providers.SplitHostPort(tunnel.PublicURL)
 		}
 	}
 
@@ -183,6 +245,10 @@ func (n *NgrokProvider) GetConnectionInfo() (*providers.ConnectionInfo, error) {
 
 // HealthCheck performs a health check
 func (n *NgrokProvider) HealthCheck() (*providers.HealthStatus, error) {
+	if offline.Enabled() {
+		return providers.OfflineHealthStatus(n.Name()), nil
+	}
+
 	if !n.IsInstalled() {
 		return &providers.HealthStatus{
 			Healthy:   false,
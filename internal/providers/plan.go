@@ -0,0 +1,184 @@
+package providers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Plan actions
+const (
+	ActionConnect    = "connect"
+	ActionDisconnect = "disconnect"
+)
+
+// Redacted replaces secrets in plans, which are meant to be shown
+const Redacted = "<redacted>"
+
+// Plan describes what a provider would do to connect or disconnect,
+// without doing any of it
+type Plan struct {
+	Provider string            `json:"provider"`
+	Action   string            `json:"action"`
+	Commands []PlannedCommand  `json:"commands"`
+	Ports    []PlannedPort     `json:"ports,omitempty"`
+	Config   map[string]string `json:"config,omitempty"`
+	Notes    []string          `json:"notes,omitempty"`
+}
+
+// PlannedCommand is a binary a plan would execute
+type PlannedCommand struct {
+	Path       string   `json:"path"`
+	Args       []string `json:"args"`
+	Background bool     `json:"background,omitempty"` // left running after connect returns
+	Sandboxed  bool     `json:"sandboxed,omitempty"`
+	Condition  string   `json:"condition,omitempty"` // only run when this holds
+}
+
+// String renders the command as it would be typed in a shell
+func (c PlannedCommand) String() string {
+	parts := make([]string, 0, len(c.Args)+1)
+	for _, s := range append([]string{c.Path}, c.Args...) {
+		if s == "" || strings.ContainsAny(s, " \t\"'$\\|&;<>()*?") {
+			s = strconv.Quote(s)
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, " ")
+}
+
+// PlannedPort is a port a plan would open or forward
+type PlannedPort struct {
+	Port        int    `json:"port"`
+	Protocol    string `json:"protocol"`
+	Side        string `json:"side"` // "local" or "remote"
+	Description string `json:"description"`
+}
+
+// Planner is implemented by providers that can describe the commands they
+// run, so connects and disconnects can be reviewed before they happen.
+// Plans must not have side effects, and secrets in them are redacted.
+type Planner interface {
+	PlanConnect() (*Plan, error)
+	PlanDisconnect() (*Plan, error)
+}
+
+// PlanFor returns the plan of p for action. Providers that are not
+// Planners get a plan that says their commands are unknown.
+func PlanFor(p Provider, action string) (*Plan, error) {
+	planner, ok := p.(Planner)
+	if !ok {
+		plan := NewPlan(p, action)
+		plan.Notes = append(plan.Notes, fmt.Sprintf("%s does not describe the commands it runs", p.Name()))
+		return plan, nil
+	}
+
+	switch action {
+	case ActionConnect:
+		return planner.PlanConnect()
+	case ActionDisconnect:
+		return planner.PlanDisconnect()
+	default:
+		return nil, fmt.Errorf("unknown plan action %q", action)
+	}
+}
+
+// NewPlan starts a plan for p with its configuration, secrets redacted
+func NewPlan(p Provider, action string) *Plan {
+	plan := &Plan{Provider: p.Name(), Action: action, Commands: []PlannedCommand{}}
+	if config, err := p.GetConfig(); err == nil {
+		plan.Config = configSummary(config)
+	}
+	return plan
+}
+
+// Run adds a command that runs to completion
+func (p *Plan) Run(path string, args ...string) *PlannedCommand {
+	p.Commands = append(p.Commands, PlannedCommand{Path: path, Args: args})
+	return &p.Commands[len(p.Commands)-1]
+}
+
+// Start adds a long-running command, confined by the provider's sandbox if
+// it has one
+func (p *Plan) Start(path string, args ...string) *PlannedCommand {
+	cmd := p.Run(path, args...)
+	cmd.Background = true
+	cmd.Sandboxed = Sandboxed(p.Provider)
+	return cmd
+}
+
+// Launch adds the command the provider launches with data, as its command
+// template renders it if it has one. Background commands are left running.
+func (p *Plan) Launch(data CommandData, background bool) error {
+	path, args, err := CommandLine(p.Provider, data)
+	if err != nil {
+		return err
+	}
+	if background {
+		p.Start(path, args...)
+	} else {
+		p.Run(path, args...)
+	}
+	if commandTemplate(p.Provider) != nil {
+		p.Note("the command line comes from commands.%s in config", p.Provider)
+	}
+	return nil
+}
+
+// Port adds a port the plan opens or forwards
+func (p *Plan) Port(port int, protocol, side, description string) {
+	p.Ports = append(p.Ports, PlannedPort{Port: port, Protocol: protocol, Side: side, Description: description})
+}
+
+// Note adds a remark about something the plan can't show as a command
+func (p *Plan) Note(format string, args ...interface{}) {
+	p.Notes = append(p.Notes, fmt.Sprintf(format, args...))
+}
+
+// Redact returns secret as it may appear in a plan
+func Redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return Redacted
+}
+
+// configSummary lists the set fields of config, secrets redacted
+func configSummary(config *ProviderConfig) map[string]string {
+	summary := make(map[string]string)
+	set := func(key, value string) {
+		if value != "" {
+			summary[key] = value
+		}
+	}
+	set("auth_token", Redact(config.AuthToken))
+	set("auth_key", Redact(config.AuthKey))
+	set("network_id", config.NetworkID)
+	set("tunnel_name", config.TunnelName)
+	set("remote_host", config.RemoteHost)
+	set("config_file", config.ConfigFile)
+	if config.RemotePort != 0 {
+		summary["remote_port"] = strconv.Itoa(config.RemotePort)
+	}
+	if config.LocalPort != 0 {
+		summary["local_port"] = strconv.Itoa(config.LocalPort)
+	}
+	for k, v := range config.Extra {
+		if isSecretKey(k) {
+			v = Redact(v)
+		}
+		set("extra."+k, v)
+	}
+	return summary
+}
+
+// isSecretKey guesses whether an extra config key holds a secret
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, word := range []string{"token", "secret", "password", "key"} {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,345 @@
+// Package plugin implements providers supplied by third parties as
+// executables named tunnel-provider-<name>. TUNNEL runs the executable
+// once per operation, writes a JSON request on its stdin and reads a JSON
+// response from its stdout, so a plugin can be written in any language
+// and keeps whatever state it needs itself. See docs/PLUGINS.md.
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/offline"
+	"github.com/jedarden/tunnel/internal/providers"
+)
+
+// Prefix starts the name of every plugin executable
+const Prefix = "tunnel-provider-"
+
+// ProtocolVersion is sent with every request; plugins should refuse
+// versions they don't know
+const ProtocolVersion = 1
+
+// Operations a plugin is asked to perform
+const (
+	OpDescribe   = "describe"
+	OpConnect    = "connect"
+	OpDisconnect = "disconnect"
+	OpStatus     = "status"
+	OpHealth     = "health"
+	OpLogs       = "logs"
+)
+
+const (
+	// describeTimeout bounds describe, which runs for every plugin each
+	// time tunnel starts
+	describeTimeout = 2 * time.Second
+
+	// connectTimeout bounds connect, which may wait for a tunnel to come up
+	connectTimeout = time.Minute
+
+	// callTimeout bounds every other operation
+	callTimeout = 15 * time.Second
+
+	// pipeDelay is how long a plugin's output is read after it exits, in
+	// case a tunnel process it left running still holds its stdout
+	pipeDelay = 2 * time.Second
+)
+
+// Request is what a plugin reads from stdin
+type Request struct {
+	Protocol int                       `json:"protocol"`
+	Op       string                    `json:"op"`
+	Config   *providers.ProviderConfig `json:"config,omitempty"`
+	Since    *time.Time                `json:"since,omitempty"` // logs only
+}
+
+// Response is what a plugin writes to stdout. Error fails the operation;
+// Unsupported says the plugin doesn't implement it.
+type Response struct {
+	Error       string `json:"error,omitempty"`
+	Unsupported bool   `json:"unsupported,omitempty"`
+
+	// describe
+	Name             string             `json:"name,omitempty"`
+	DisplayName      string             `json:"display_name,omitempty"`
+	Category         providers.Category `json:"category,omitempty"`
+	RequiresInternet bool               `json:"requires_internet,omitempty"`
+
+	// status
+	Connected bool `json:"connected,omitempty"`
+
+	// connect and status
+	Info *providers.ConnectionInfo `json:"info,omitempty"`
+
+	// health
+	Health *providers.HealthStatus `json:"health,omitempty"`
+
+	// logs
+	Logs []providers.LogEntry `json:"logs,omitempty"`
+}
+
+// errUnsupported is returned for operations the plugin doesn't implement
+var errUnsupported = errors.New("operation not supported by plugin")
+
+// PluginProvider implements the Provider interface by running a plugin
+type PluginProvider struct {
+	*providers.BaseProvider
+	path             string
+	displayName      string
+	requiresInternet bool
+}
+
+// Load asks the plugin at path to describe itself and returns a provider
+// for it called name, the part of the executable's name after Prefix
+func Load(name, path string) (*PluginProvider, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), describeTimeout)
+	defer cancel()
+	resp, err := call(ctx, name, path, Request{Protocol: ProtocolVersion, Op: OpDescribe})
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s: describe: %w", name, err)
+	}
+	if resp.Name != "" && resp.Name != name {
+		return nil, fmt.Errorf("plugin %s: describes itself as %s; rename it %s%s", name, resp.Name, Prefix, resp.Name)
+	}
+
+	category := resp.Category
+	switch category {
+	case "":
+		category = providers.CategoryTunnel
+	case providers.CategoryVPN, providers.CategoryTunnel, providers.CategoryDirect, providers.CategorySSH:
+	default:
+		return nil, fmt.Errorf("plugin %s: unknown category %q", name, category)
+	}
+
+	return &PluginProvider{
+		BaseProvider:     providers.NewBaseProvider(name, category),
+		path:             path,
+		displayName:      resp.DisplayName,
+		requiresInternet: resp.RequiresInternet,
+	}, nil
+}
+
+// Path returns the plugin's executable
+func (p *PluginProvider) Path() string {
+	return p.path
+}
+
+// DisplayName returns the name the plugin gave itself for reports, or its
+// provider name if it gave none
+func (p *PluginProvider) DisplayName() string {
+	if p.displayName != "" {
+		return p.displayName
+	}
+	return p.Name()
+}
+
+// RequiresInternet reports what the plugin said when described
+func (p *PluginProvider) RequiresInternet() bool {
+	return p.requiresInternet
+}
+
+// Install reports that plugins are installed by putting them on PATH
+func (p *PluginProvider) Install() error {
+	if p.IsInstalled() {
+		return providers.ErrAlreadyInstalled
+	}
+	return fmt.Errorf("%w: put %s%s back on your PATH", providers.ErrInstallFailed, Prefix, p.Name())
+}
+
+// Uninstall reports that plugins are removed by deleting them
+func (p *PluginProvider) Uninstall() error {
+	return fmt.Errorf("plugin %s was not installed by tunnel; remove %s yourself", p.Name(), p.path)
+}
+
+// IsInstalled checks that the plugin executable is still there
+func (p *PluginProvider) IsInstalled() bool {
+	_, err := exec.LookPath(p.path)
+	return err == nil
+}
+
+// Connect asks the plugin to bring its tunnel up
+func (p *PluginProvider) Connect() error {
+	if p.requiresInternet {
+		if err := offline.Check(p.Name()); err != nil {
+			return err
+		}
+	}
+	_, err := p.request(OpConnect, connectTimeout, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %v", providers.ErrConnectionFailed, err)
+	}
+	return nil
+}
+
+// Disconnect asks the plugin to take its tunnel down
+func (p *PluginProvider) Disconnect() error {
+	if _, err := p.request(OpDisconnect, callTimeout, nil); err != nil {
+		return fmt.Errorf("%w: %v", providers.ErrCommandFailed, err)
+	}
+	return nil
+}
+
+// IsConnected asks the plugin whether its tunnel is up
+func (p *PluginProvider) IsConnected() bool {
+	resp, err := p.request(OpStatus, callTimeout, nil)
+	return err == nil && resp.Connected
+}
+
+// GetConnectionInfo asks the plugin for the state of its tunnel
+func (p *PluginProvider) GetConnectionInfo() (*providers.ConnectionInfo, error) {
+	resp, err := p.request(OpStatus, callTimeout, nil)
+	if err != nil {
+		return nil, err
+	}
+	info := resp.Info
+	if info == nil {
+		info = &providers.ConnectionInfo{}
+	}
+	if info.Status == "" {
+		info.Status = "disconnected"
+		if resp.Connected {
+			info.Status = "connected"
+		}
+	}
+	return info, nil
+}
+
+// HealthCheck asks the plugin for its health, falling back to its status
+// for plugins without a health check
+func (p *PluginProvider) HealthCheck() (*providers.HealthStatus, error) {
+	if p.requiresInternet && offline.Enabled() {
+		return providers.OfflineHealthStatus(p.Name()), nil
+	}
+
+	start := time.Now()
+	resp, err := p.request(OpHealth, callTimeout, nil)
+	switch {
+	case errors.Is(err, errUnsupported):
+		connected := p.IsConnected()
+		status := &providers.HealthStatus{
+			Healthy:   connected,
+			Status:    "disconnected",
+			Message:   fmt.Sprintf("%s is not connected", p.DisplayName()),
+			LastCheck: time.Now(),
+		}
+		if connected {
+			status.Status = "connected"
+			status.Message = fmt.Sprintf("%s is connected", p.DisplayName())
+		}
+		return status, nil
+
+	case err != nil:
+		return &providers.HealthStatus{
+			Healthy:   false,
+			Status:    "error",
+			Message:   err.Error(),
+			LastCheck: time.Now(),
+		}, nil
+
+	case resp.Health == nil:
+		return nil, fmt.Errorf("%w: plugin %s sent no health", providers.ErrInvalidResponse, p.Name())
+	}
+
+	health := resp.Health
+	if health.LastCheck.IsZero() {
+		health.LastCheck = time.Now()
+	}
+	if health.Latency == 0 {
+		health.Latency = time.Since(start)
+	}
+	if health.Status == "" {
+		health.Status = "unhealthy"
+		if health.Healthy {
+			health.Status = "healthy"
+		}
+	}
+	return health, nil
+}
+
+// GetLogs asks the plugin for its logs since the specified time
+func (p *PluginProvider) GetLogs(since time.Time) ([]providers.LogEntry, error) {
+	resp, err := p.request(OpLogs, callTimeout, func(req *Request) { req.Since = &since })
+	if errors.Is(err, errUnsupported) {
+		return []providers.LogEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range resp.Logs {
+		if resp.Logs[i].Source == "" {
+			resp.Logs[i].Source = p.Name()
+		}
+	}
+	return resp.Logs, nil
+}
+
+// request runs op with the provider's config, letting edit add to the
+// request
+func (p *PluginProvider) request(op string, timeout time.Duration, edit func(*Request)) (*Response, error) {
+	req := Request{Protocol: ProtocolVersion, Op: op}
+	if config, err := p.GetConfig(); err == nil {
+		req.Config = config
+	}
+	if edit != nil {
+		edit(&req)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return call(ctx, p.Name(), p.path, req)
+}
+
+// call runs the plugin at path once with req and decodes its response
+func call(ctx context.Context, name, path string, req Request) (*Response, error) {
+	input, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Stdin = bytes.NewReader(append(input, '\n'))
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = pipeDelay
+	if err := providers.Sandbox(name, cmd); err != nil {
+		return nil, err
+	}
+	runErr := cmd.Run()
+
+	var resp Response
+	if err := json.Unmarshal(bytes.TrimSpace(stdout.Bytes()), &resp); err != nil {
+		if runErr != nil {
+			return nil, fmt.Errorf("%v%s", runErr, stderrSuffix(stderr.String()))
+		}
+		return nil, fmt.Errorf("%w: %s sent %q", providers.ErrInvalidResponse, path, firstLine(stdout.String()))
+	}
+	switch {
+	case resp.Unsupported:
+		return nil, errUnsupported
+	case resp.Error != "":
+		return nil, errors.New(resp.Error)
+	case runErr != nil:
+		return nil, fmt.Errorf("%v%s", runErr, stderrSuffix(stderr.String()))
+	}
+	return &resp, nil
+}
+
+func stderrSuffix(stderr string) string {
+	if line := firstLine(strings.TrimSpace(stderr)); line != "" {
+		return ": " + line
+	}
+	return ""
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
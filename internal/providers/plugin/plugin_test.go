@@ -0,0 +1,130 @@
+package plugin
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/providers"
+)
+
+// demoPlugin keeps its tunnel's state in a file next to itself
+const demoPlugin = `#!/bin/sh
+state="$(dirname "$0")/state"
+req=$(cat)
+case "$req" in
+*'"op":"describe"'*) echo '{"display_name":"Demo","category":"ssh"}' ;;
+*'"op":"connect"'*)
+	case "$req" in *'"tunnel_name":"fail"'*) echo '{"error":"relay refused"}'; exit 1 ;; esac
+	touch "$state"; echo '{}' ;;
+*'"op":"disconnect"'*) rm -f "$state"; echo '{}' ;;
+*'"op":"status"'*)
+	if [ -f "$state" ]; then
+		echo '{"connected":true,"info":{"tunnel_url":"demo://tunnel"}}'
+	else
+		echo '{}'
+	fi ;;
+*'"op":"logs"'*) echo '{"logs":[{"timestamp":"2024-01-01T00:00:00Z","level":"Info","message":"hello"}]}' ;;
+*) echo '{"unsupported":true}' ;;
+esac
+`
+
+func writePlugin(t *testing.T, name, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), Prefix+name)
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	p, err := Load("demo", writePlugin(t, "demo", demoPlugin))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if p.Name() != "demo" || p.DisplayName() != "Demo" || p.Category() != providers.CategorySSH {
+		t.Errorf("loaded %s %q %s", p.Name(), p.DisplayName(), p.Category())
+	}
+	if !p.IsInstalled() {
+		t.Error("IsInstalled() = false")
+	}
+}
+
+func TestLoadRefusesMismatchedName(t *testing.T) {
+	path := writePlugin(t, "demo", "#!/bin/sh\necho '{\"name\":\"other\"}'\n")
+	if _, err := Load("demo", path); err == nil || !strings.Contains(err.Error(), "other") {
+		t.Errorf("Load() error = %v, want a name mismatch", err)
+	}
+}
+
+func TestLoadRefusesGarbage(t *testing.T) {
+	path := writePlugin(t, "demo", "#!/bin/sh\necho not json\n")
+	if _, err := Load("demo", path); !errors.Is(err, providers.ErrInvalidResponse) {
+		t.Errorf("Load() error = %v, want ErrInvalidResponse", err)
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	p, err := Load("demo", writePlugin(t, "demo", demoPlugin))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.IsConnected() {
+		t.Fatal("connected before Connect()")
+	}
+	if err := p.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	if !p.IsConnected() {
+		t.Fatal("not connected after Connect()")
+	}
+	info, err := p.GetConnectionInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Status != "connected" || info.TunnelURL != "demo://tunnel" {
+		t.Errorf("info = %+v", info)
+	}
+
+	// The plugin has no health check, so its status stands in for one
+	health, err := p.HealthCheck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !health.Healthy || health.Status != "connected" {
+		t.Errorf("health = %+v", health)
+	}
+
+	logs, err := p.GetLogs(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 || logs[0].Message != "hello" || logs[0].Source != "demo" {
+		t.Errorf("logs = %+v", logs)
+	}
+
+	if err := p.Disconnect(); err != nil {
+		t.Fatalf("Disconnect() error = %v", err)
+	}
+	if p.IsConnected() {
+		t.Error("connected after Disconnect()")
+	}
+}
+
+func TestConnectError(t *testing.T) {
+	p, err := Load("demo", writePlugin(t, "demo", demoPlugin))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Configure(&providers.ProviderConfig{Name: "demo", TunnelName: "fail"})
+
+	err = p.Connect()
+	if !errors.Is(err, providers.ErrConnectionFailed) || !strings.Contains(err.Error(), "relay refused") {
+		t.Errorf("Connect() error = %v, want the plugin's error", err)
+	}
+}
@@ -1,6 +1,11 @@
 package providers
 
 import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -60,12 +65,32 @@ type ConnectionInfo struct {
 	ConnectedAt   time.Time              `json:"connected_at,omitempty"`
 	LocalIP       string                 `json:"local_ip,omitempty"`
 	RemoteIP      string                 `json:"remote_ip,omitempty"`
+	LocalPort     int                    `json:"local_port,omitempty"`
+	RemotePort    int                    `json:"remote_port,omitempty"`
 	TunnelURL     string                 `json:"tunnel_url,omitempty"`
 	InterfaceName string                 `json:"interface_name,omitempty"`
 	Peers         []string               `json:"peers,omitempty"`
 	Extra         map[string]interface{} `json:"extra,omitempty"`
 }
 
+// SplitHostPort splits an endpoint such as "bore.pub:4000" or
+// "tcp://0.tcp.ngrok.io:12345" into host and port. The port is 0 when the
+// endpoint has none.
+func SplitHostPort(endpoint string) (string, int) {
+	if _, rest, ok := strings.Cut(endpoint, "://"); ok {
+		endpoint, _, _ = strings.Cut(rest, "/")
+	}
+	host, portStr, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return endpoint, 0
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return host, 0
+	}
+	return host, port
+}
+
 // HealthStatus represents the health of the provider
 type HealthStatus struct {
 	Healthy       bool                   `json:"healthy"`
@@ -80,10 +105,11 @@ type HealthStatus struct {
 
 // LogEntry represents a single log entry
 type LogEntry struct {
-	Timestamp time.Time `json:"timestamp"`
-	Level     string    `json:"level"`
-	Message   string    `json:"message"`
-	Source    string    `json:"source,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+	Level       string    `json:"level"`
+	Message     string    `json:"message"`
+	Source      string    `json:"source,omitempty"`
+	OperationID string    `json:"operation_id,omitempty"` // operation running when it was logged
 }
 
 // BaseProvider provides common functionality for all providers
@@ -139,3 +165,97 @@ func (b *BaseProvider) ValidateConfig(config *ProviderConfig) error {
 	}
 	return nil
 }
+
+// InternetProvider is implemented by providers whose connections depend on
+// reaching a third-party service over the internet
+type InternetProvider interface {
+	RequiresInternet() bool
+}
+
+// RequiresInternet reports whether a provider needs internet access to connect
+func RequiresInternet(p Provider) bool {
+	ip, ok := p.(InternetProvider)
+	return ok && ip.RequiresInternet()
+}
+
+// OfflineHealthStatus returns the status reported by internet-dependent
+// providers while offline mode is enabled
+func OfflineHealthStatus(name string) *HealthStatus {
+	return &HealthStatus{
+		Healthy:   false,
+		Status:    "offline",
+		Message:   fmt.Sprintf("offline mode: %s requires internet access", name),
+		LastCheck: time.Now(),
+	}
+}
+
+// ProcessProvider is implemented by providers that run local processes whose
+// CPU and memory usage can be monitored
+type ProcessProvider interface {
+	// ProcessPatterns returns pgrep -f patterns matching the provider's processes
+	ProcessPatterns() []string
+}
+
+// ProcessPatterns returns the process patterns for a provider, or nil if it
+// has no processes of its own
+func ProcessPatterns(p Provider) []string {
+	if pp, ok := p.(ProcessProvider); ok {
+		return pp.ProcessPatterns()
+	}
+	return nil
+}
+
+// EndpointProvider is implemented by providers that connect to known
+// hosts, such as a relay or a vendor's control plane
+type EndpointProvider interface {
+	// Endpoints returns the host names the provider's client connects to
+	Endpoints() []string
+}
+
+// Endpoints returns the hosts a provider connects to, or nil if they
+// aren't known
+func Endpoints(p Provider) []string {
+	if ep, ok := p.(EndpointProvider); ok {
+		return ep.Endpoints()
+	}
+	return nil
+}
+
+// DNSProvider is implemented by VPN providers whose network has its own
+// resolvers, such as Tailscale MagicDNS or a WireGuard peer's DNS server
+type DNSProvider interface {
+	// DNSServers returns resolver IP addresses reachable through the VPN
+	DNSServers() []string
+}
+
+// DNSServers returns the resolvers a provider supplies, or nil if it has none
+func DNSServers(p Provider) []string {
+	if dp, ok := p.(DNSProvider); ok {
+		return dp.DNSServers()
+	}
+	return nil
+}
+
+// disconnectRank orders categories for teardown: SSH and direct links may run
+// over a tunnel, and tunnels may run over a VPN
+var disconnectRank = map[Category]int{
+	CategorySSH:    0,
+	CategoryDirect: 0,
+	CategoryTunnel: 1,
+	CategoryVPN:    2,
+}
+
+// DisconnectOrder takes providers in the order they were connected and returns
+// the order to disconnect them in: SSH and direct first, then tunnels, then
+// VPNs, with the most recently connected first within each category
+func DisconnectOrder(connected []Provider) []Provider {
+	ordered := make([]Provider, 0, len(connected))
+	for i := len(connected) - 1; i >= 0; i-- {
+		ordered = append(ordered, connected[i])
+	}
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return disconnectRank[ordered[i].Category()] < disconnectRank[ordered[j].Category()]
+	})
+	return ordered
+}
@@ -3,7 +3,12 @@ package providers_test
 import (
 	"testing"
 
+	"github.com/jedarden/tunnel/internal/cmdtemplate"
 	"github.com/jedarden/tunnel/internal/providers"
+	"github.com/jedarden/tunnel/internal/providers/bore"
+	"github.com/jedarden/tunnel/internal/providers/cloudflare"
+	"github.com/jedarden/tunnel/internal/providers/reversessh"
+	"github.com/jedarden/tunnel/internal/providers/wireguard"
 )
 
 func TestBaseProvider(t *testing.T) {
@@ -76,3 +81,174 @@ func TestValidateConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestRequiresInternet(t *testing.T) {
+	if !providers.RequiresInternet(cloudflare.New()) {
+		t.Error("expected cloudflare to require internet")
+	}
+	if providers.RequiresInternet(wireguard.New()) {
+		t.Error("expected wireguard not to require internet")
+	}
+
+	status := providers.OfflineHealthStatus("cloudflare")
+	if status.Healthy || status.Status != "offline" {
+		t.Errorf("unexpected offline status: %+v", status)
+	}
+}
+
+func TestProcessPatterns(t *testing.T) {
+	if patterns := providers.ProcessPatterns(cloudflare.New()); len(patterns) == 0 {
+		t.Error("expected cloudflare to report process patterns")
+	}
+	if patterns := providers.ProcessPatterns(wireguard.New()); patterns != nil {
+		t.Errorf("expected no process patterns for wireguard, got %v", patterns)
+	}
+}
+
+func TestDisconnectOrder(t *testing.T) {
+	connected := []providers.Provider{wireguard.New(), cloudflare.New(), reversessh.New(), bore.New()}
+
+	var got []string
+	for _, p := range providers.DisconnectOrder(connected) {
+		got = append(got, p.Name())
+	}
+
+	want := []string{"reverse-ssh", "bore", "cloudflare", "wireguard"}
+	if len(got) != len(want) {
+		t.Fatalf("DisconnectOrder() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("DisconnectOrder() = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSplitHostPort(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		host     string
+		port     int
+	}{
+		{"bore.pub:4000", "bore.pub", 4000},
+		{"tcp://0.tcp.ngrok.io:12345", "0.tcp.ngrok.io", 12345},
+		{"https://example.ngrok.app/path", "example.ngrok.app", 0},
+		{"[2001:db8::1]:51820", "2001:db8::1", 51820},
+		{"10.0.0.1", "10.0.0.1", 0},
+	}
+
+	for _, tt := range tests {
+		host, port := providers.SplitHostPort(tt.endpoint)
+		if host != tt.host || port != tt.port {
+			t.Errorf("SplitHostPort(%q) = %q, %d, want %q, %d", tt.endpoint, host, port, tt.host, tt.port)
+		}
+	}
+}
+
+func TestCanonicalNames(t *testing.T) {
+	tests := []struct {
+		name           string
+		wantName       string
+		wantDeprecated bool
+	}{
+		{"cloudflare", "cloudflare", false},
+		{"cloudflared", "cloudflare", true},
+		{"Cloudflared", "cloudflare", true},
+		{"sshforward", "ssh-forward", true},
+		{"ts", "tailscale", false},
+		{"wg", "wireguard", false},
+		{"unknown", "unknown", false},
+	}
+	for _, tt := range tests {
+		got, deprecated := providers.CanonicalName(tt.name)
+		if got != tt.wantName || deprecated != tt.wantDeprecated {
+			t.Errorf("CanonicalName(%q) = %q, %v; want %q, %v", tt.name, got, deprecated, tt.wantName, tt.wantDeprecated)
+		}
+	}
+
+	keys := map[string]string{
+		"providers.cloudflared.binary_path": "providers.cloudflare.binary_path",
+		"providers.cloudflared":             "providers.cloudflare",
+		"providers.ngrok.enabled":           "providers.ngrok.enabled",
+		"ssh.port":                          "ssh.port",
+	}
+	for key, want := range keys {
+		if got, _ := providers.CanonicalConfigKey(key); got != want {
+			t.Errorf("CanonicalConfigKey(%q) = %q, want %q", key, got, want)
+		}
+	}
+
+	if aliases := providers.DeprecatedNames("cloudflare"); len(aliases) != 1 || aliases[0] != "cloudflared" {
+		t.Errorf("DeprecatedNames(cloudflare) = %v", aliases)
+	}
+}
+
+func TestIdentities(t *testing.T) {
+	seen := make(map[string]string)
+	for _, id := range providers.Identities() {
+		if id.DisplayName == "" {
+			t.Errorf("provider %s has no display name", id.Name)
+		}
+		// Every spelling must resolve to exactly one provider
+		for _, name := range append(append([]string{id.Name}, id.Aliases...), id.Deprecated...) {
+			if other, dup := seen[name]; dup {
+				t.Errorf("%q names both %s and %s", name, other, id.Name)
+			}
+			seen[name] = id.Name
+		}
+	}
+
+	if got := providers.DisplayName("cf"); got != "Cloudflare Tunnel" {
+		t.Errorf("DisplayName(cf) = %q", got)
+	}
+	if got := providers.DisplayName("my-plugin"); got != "my-plugin" {
+		t.Errorf("DisplayName(my-plugin) = %q, want the name itself", got)
+	}
+}
+
+func TestPlans(t *testing.T) {
+	b := bore.New()
+	_ = b.Configure(&providers.ProviderConfig{Name: "bore", LocalPort: 8080, RemoteHost: "relay.example.com", RemotePort: 4000})
+	plan, err := providers.PlanFor(b, providers.ActionConnect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Commands) != 1 || plan.Commands[0].String() != "bore local 8080 --to relay.example.com --port 4000" || !plan.Commands[0].Background {
+		t.Errorf("bore connect commands = %+v", plan.Commands)
+	}
+	if len(plan.Ports) != 2 || plan.Config["remote_host"] != "relay.example.com" {
+		t.Errorf("bore connect plan = %+v", plan)
+	}
+
+	tmpl, err := cmdtemplate.Parse("{{.Binary}} {{quote .Args}} --secret-file {{.TokenPath}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	providers.SetCommandTemplate("bore", tmpl)
+	defer providers.SetCommandTemplate("bore", nil)
+	plan, err = providers.PlanFor(b, providers.ActionConnect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args := plan.Commands[0].Args; len(args) != 8 || args[6] != "--secret-file" {
+		t.Errorf("templated bore command = %s", plan.Commands[0])
+	}
+
+	cf := cloudflare.New()
+	_ = cf.Configure(&providers.ProviderConfig{Name: "cloudflare", AuthToken: "s3cret"})
+	plan, err = providers.PlanFor(cf, providers.ActionConnect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := plan.Commands[0].String(); got != `cloudflared tunnel run --token "<redacted>"` {
+		t.Errorf("cloudflare connect command = %s", got)
+	}
+	if plan.Config["auth_token"] != "<redacted>" {
+		t.Errorf("cloudflare plan config leaks the token: %v", plan.Config)
+	}
+
+	plan, err = providers.PlanFor(cf, providers.ActionDisconnect)
+	if err != nil || len(plan.Commands) != 1 || plan.Commands[0].Path != "pkill" {
+		t.Errorf("cloudflare disconnect plan = %+v, %v", plan, err)
+	}
+}
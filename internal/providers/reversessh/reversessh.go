@@ -1,17 +1,50 @@
+// Package reversessh implements reverse SSH tunnels: `ssh -R` to a relay
+// or jump host the user runs, which then forwards a port back to this
+// machine's sshd. It needs nothing but an SSH account on the relay.
 package reversessh
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
+	"net"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/jedarden/tunnel/internal/providers"
 )
 
+const (
+	// settleTime is how long Connect watches ssh for an early exit, which
+	// is how a refused login or a remote port already in use shows up
+	settleTime = 3 * time.Second
+
+	// stopTimeout is how long ssh has to exit after an interrupt before it
+	// is killed
+	stopTimeout = 5 * time.Second
+
+	// probeTimeout bounds the health check's dial to the relay
+	probeTimeout = 5 * time.Second
+
+	// maxLogLines is how many lines of ssh output are kept for GetLogs
+	maxLogLines = 200
+)
+
 // ReverseSSHProvider implements the Provider interface for reverse SSH tunnels
 type ReverseSSHProvider struct {
 	*providers.BaseProvider
-	cmd *exec.Cmd
+
+	mu          sync.Mutex
+	cmd         *exec.Cmd     // running ssh client, nil while stopped
+	exited      chan struct{} // closed when cmd exits
+	relay       relaySettings // what cmd was started with
+	connectedAt time.Time
+	logs        []providers.LogEntry
 }
 
 // New creates a new Reverse SSH provider
@@ -21,6 +54,24 @@ func New() *ReverseSSHProvider {
 	}
 }
 
+// ProcessPatterns matches reverse SSH client processes for resource monitoring
+func (r *ReverseSSHProvider) ProcessPatterns() []string {
+	return []string{"ssh -R"}
+}
+
+// Endpoints returns the relay server, once one is configured
+func (r *ReverseSSHProvider) Endpoints() []string {
+	config, err := r.GetConfig()
+	if err != nil {
+		return nil
+	}
+	relay, err := relayFromConfig(config)
+	if err != nil {
+		return nil
+	}
+	return []string{relay.server}
+}
+
 // Install checks SSH client availability
 func (r *ReverseSSHProvider) Install() error {
 	if r.IsInstalled() {
@@ -36,12 +87,12 @@ func (r *ReverseSSHProvider) Uninstall() error {
 
 // IsInstalled checks if SSH client is installed
 func (r *ReverseSSHProvider) IsInstalled() bool {
-	cmd := exec.Command("which", "ssh")
-	err := cmd.Run()
+	_, err := exec.LookPath("ssh")
 	return err == nil
 }
 
-// Connect establishes a reverse SSH tunnel
+// Connect starts ssh with the reverse forward and waits for it to survive
+// the login and the remote port binding
 func (r *ReverseSSHProvider) Connect() error {
 	if !r.IsInstalled() {
 		return providers.ErrNotInstalled
@@ -51,72 +102,383 @@ func (r *ReverseSSHProvider) Connect() error {
 	if err != nil {
 		return err
 	}
+	relay, err := relayFromConfig(config)
+	if err != nil {
+		return err
+	}
 
-	// Get relay server details from config
-	relayServer := ""
-	relayPort := "22"
-	relayUser := ""
-	remotePort := "2222"
+	r.mu.Lock()
+	if r.cmd != nil {
+		r.mu.Unlock()
+		return providers.ErrAlreadyConnected
+	}
+	cmd, err := providers.Command(r.Name(), relay.commandData())
+	if err != nil {
+		r.mu.Unlock()
+		return fmt.Errorf("%w: %v", providers.ErrConnectionFailed, err)
+	}
+	cmd.Stdout = &lineWriter{provider: r}
+	cmd.Stderr = &lineWriter{provider: r}
+	if err := providers.Sandbox(r.Name(), cmd); err != nil {
+		r.mu.Unlock()
+		return fmt.Errorf("%w: %v", providers.ErrConnectionFailed, err)
+	}
+	if err := cmd.Start(); err != nil {
+		r.mu.Unlock()
+		return fmt.Errorf("%w: %v", providers.ErrConnectionFailed, err)
+	}
+
+	r.cmd = cmd
+	r.exited = make(chan struct{})
+	r.relay = relay
+	r.connectedAt = time.Time{}
+	exited := r.exited
+	r.log("Info", fmt.Sprintf("started ssh to %s (pid %d)", relay.address(), cmd.Process.Pid), "tunnel")
+	go r.wait(cmd, exited)
+	r.mu.Unlock()
 
-	if config.Extra != nil {
-		if s, ok := config.Extra["relayServer"]; ok {
-			relayServer = s
+	select {
+	case <-exited:
+		return fmt.Errorf("%w: ssh exited: %s", providers.ErrConnectionFailed, r.lastLine())
+	case <-time.After(settleTime):
+	}
+
+	r.mu.Lock()
+	r.connectedAt = time.Now()
+	r.mu.Unlock()
+	return nil
+}
+
+// StartForward runs another ssh client to the configured relay, forwarding
+// f.RemotePort there to f.LocalPort here. The relay has to be configured,
+// and a forward needs a remote port of its own.
+func (r *ReverseSSHProvider) StartForward(f providers.Forward) (*providers.ForwardProcess, error) {
+	if !r.IsInstalled() {
+		return nil, providers.ErrNotInstalled
+	}
+	if f.RemotePort == 0 {
+		return nil, fmt.Errorf("a reverse SSH forward needs a remote port")
+	}
+
+	config, err := r.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	forward := *config
+	forward.LocalPort, forward.RemotePort = f.LocalPort, f.RemotePort
+	if f.RemoteHost != "" {
+		forward.RemoteHost = f.RemoteHost
+	}
+	// The Extra keys would win over the forward's own
+	forward.Extra = make(map[string]string, len(config.Extra))
+	for k, v := range config.Extra {
+		switch k {
+		case "localPort", "remotePort":
+		case "relayServer":
+			if f.RemoteHost == "" {
+				forward.Extra[k] = v
+			}
+		default:
+			forward.Extra[k] = v
 		}
-		if p, ok := config.Extra["relayPort"]; ok {
-			relayPort = p
+	}
+	relay, err := relayFromConfig(&forward)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd, err := providers.Command(r.Name(), relay.commandData())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", providers.ErrConnectionFailed, err)
+	}
+	return providers.LaunchForward(r.Name(), cmd, net.JoinHostPort(relay.server, relay.remotePort), settleTime)
+}
+
+// wait records the exit of cmd. ssh exits by itself when the relay stops
+// answering keepalives, so this is also how a dead tunnel is noticed.
+func (r *ReverseSSHProvider) wait(cmd *exec.Cmd, exited chan struct{}) {
+	err := cmd.Wait()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	close(exited)
+	if r.cmd != cmd {
+		return
+	}
+	r.cmd = nil
+	r.connectedAt = time.Time{}
+	if err != nil {
+		r.log("Error", fmt.Sprintf("ssh exited: %v", err), "tunnel")
+	} else {
+		r.log("Warning", "ssh exited", "tunnel")
+	}
+}
+
+// relaySettings is where the reverse tunnel is opened and what it forwards
+type relaySettings struct {
+	server            string
+	port              string
+	user              string
+	identityFile      string
+	remotePort        string
+	localHost         string
+	localPort         string
+	keepaliveInterval int // seconds between keepalives
+	keepaliveCountMax int // unanswered keepalives before ssh gives up
+	hostKeyChecking   string
+	knownHostsFile    string
+}
+
+// relayFromConfig reads the relay server details from config. The Extra
+// keys win over the generic remote and local fields.
+func relayFromConfig(config *providers.ProviderConfig) (relaySettings, error) {
+	relay := relaySettings{
+		server:            config.RemoteHost,
+		port:              "22",
+		remotePort:        "2222",
+		localHost:         "localhost",
+		localPort:         "22",
+		keepaliveInterval: 15,
+		keepaliveCountMax: 3,
+		hostKeyChecking:   "accept-new",
+	}
+	if config.RemotePort != 0 {
+		relay.remotePort = strconv.Itoa(config.RemotePort)
+	}
+	if config.LocalPort != 0 {
+		relay.localPort = strconv.Itoa(config.LocalPort)
+	}
+
+	extra := config.Extra
+	for key, field := range map[string]*string{
+		"relayServer":           &relay.server,
+		"relayPort":             &relay.port,
+		"relayUsername":         &relay.user,
+		"identityFile":          &relay.identityFile,
+		"remotePort":            &relay.remotePort,
+		"localHost":             &relay.localHost,
+		"localPort":             &relay.localPort,
+		"strictHostKeyChecking": &relay.hostKeyChecking,
+		"knownHostsFile":        &relay.knownHostsFile,
+	} {
+		if v, ok := extra[key]; ok && v != "" {
+			*field = v
+		}
+	}
+	for key, field := range map[string]*int{
+		"keepaliveInterval": &relay.keepaliveInterval,
+		"keepaliveCountMax": &relay.keepaliveCountMax,
+	} {
+		v, ok := extra[key]
+		if !ok || v == "" {
+			continue
 		}
-		if u, ok := config.Extra["relayUsername"]; ok {
-			relayUser = u
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return relay, fmt.Errorf("%s must be a positive number, not %q", key, v)
 		}
-		if rp, ok := config.Extra["remotePort"]; ok {
-			remotePort = rp
+		*field = n
+	}
+
+	if relay.server == "" {
+		return relay, fmt.Errorf("relay server is required")
+	}
+	for key, port := range map[string]string{
+		"relayPort":  relay.port,
+		"remotePort": relay.remotePort,
+		"localPort":  relay.localPort,
+	} {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return relay, fmt.Errorf("invalid %s %q", key, port)
 		}
 	}
+	switch relay.hostKeyChecking {
+	case "yes", "accept-new", "no":
+	default:
+		return relay, fmt.Errorf("strictHostKeyChecking must be yes, accept-new or no, not %q", relay.hostKeyChecking)
+	}
+	relay.identityFile = expandHome(relay.identityFile)
+	relay.knownHostsFile = expandHome(relay.knownHostsFile)
+	return relay, nil
+}
 
-	if relayServer == "" {
-		return fmt.Errorf("relay server is required")
+// expandHome replaces a leading ~/ in path with the home directory
+func expandHome(path string) string {
+	rest, ok := strings.CutPrefix(path, "~/")
+	if !ok {
+		return path
 	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, rest)
+}
 
-	// Build SSH command for reverse tunnel
-	// ssh -R remotePort:localhost:22 user@relay -p port -N
-	target := relayServer
-	if relayUser != "" {
-		target = relayUser + "@" + relayServer
+// address is the relay's host:port
+func (relay relaySettings) address() string {
+	return net.JoinHostPort(relay.server, relay.port)
+}
+
+// forward is the -R argument: remotePort:localHost:localPort
+func (relay relaySettings) forward() string {
+	return fmt.Sprintf("%s:%s:%s", relay.remotePort, relay.localHost, relay.localPort)
+}
+
+// keepaliveWindow is how long the relay can go silent before ssh exits
+func (relay relaySettings) keepaliveWindow() time.Duration {
+	return time.Duration(relay.keepaliveInterval*relay.keepaliveCountMax) * time.Second
+}
+
+// commandData describes the SSH command for the reverse tunnel:
+// ssh -R remotePort:localhost:22 -N -p port user@relay
+//
+// ExitOnForwardFailure makes ssh exit when the relay refuses the remote
+// port, and the ServerAlive options make it exit when the relay stops
+// answering, so a running ssh is a working tunnel.
+func (relay relaySettings) commandData() providers.CommandData {
+	target := relay.server
+	if relay.user != "" {
+		target = relay.user + "@" + relay.server
 	}
 
 	args := []string{
-		"-R", fmt.Sprintf("%s:localhost:22", remotePort),
-		target,
-		"-p", relayPort,
+		"-R", relay.forward(),
 		"-N",
-		"-o", "ServerAliveInterval=60",
-		"-o", "StrictHostKeyChecking=no",
+		"-p", relay.port,
+		"-o", "ExitOnForwardFailure=yes",
+		"-o", fmt.Sprintf("ServerAliveInterval=%d", relay.keepaliveInterval),
+		"-o", fmt.Sprintf("ServerAliveCountMax=%d", relay.keepaliveCountMax),
+		"-o", "BatchMode=yes",
+		"-o", "StrictHostKeyChecking=" + relay.hostKeyChecking,
+	}
+	if relay.knownHostsFile != "" {
+		args = append(args, "-o", "UserKnownHostsFile="+relay.knownHostsFile)
+	}
+	if relay.identityFile != "" {
+		args = append(args, "-i", relay.identityFile, "-o", "IdentitiesOnly=yes")
 	}
+	args = append(args, target)
 
-	r.cmd = exec.Command("ssh", args...)
-	if err := r.cmd.Start(); err != nil {
-		return fmt.Errorf("%w: %v", providers.ErrConnectionFailed, err)
+	port, _ := strconv.Atoi(relay.localPort)
+	remotePort, _ := strconv.Atoi(relay.remotePort)
+	return providers.CommandData{
+		Binary:     "ssh",
+		Args:       args,
+		Port:       port,
+		RemoteHost: relay.server,
+		RemotePort: remotePort,
 	}
+}
 
-	return nil
+// PlanConnect describes the ssh client Connect would start
+func (r *ReverseSSHProvider) PlanConnect() (*providers.Plan, error) {
+	config, err := r.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	relay, err := relayFromConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := providers.NewPlan(r, providers.ActionConnect)
+	data := relay.commandData()
+	if err := plan.Launch(data, true); err != nil {
+		return nil, err
+	}
+	plan.Port(data.Port, "tcp", "local", "forwarded from the relay")
+	plan.Port(data.RemotePort, "tcp", "remote", "listening on "+relay.server)
+	switch relay.hostKeyChecking {
+	case "no":
+		plan.Note("the relay's host key is not checked")
+	case "accept-new":
+		plan.Note("the relay's host key is trusted on first use")
+	}
+	plan.Note("ssh exits if the relay misses %d keepalives sent every %ds", relay.keepaliveCountMax, relay.keepaliveInterval)
+	return plan, nil
 }
 
-// Disconnect terminates the reverse SSH tunnel
+// PlanDisconnect describes how Disconnect stops the ssh client
+func (r *ReverseSSHProvider) PlanDisconnect() (*providers.Plan, error) {
+	plan := providers.NewPlan(r, providers.ActionDisconnect)
+	r.mu.Lock()
+	cmd := r.cmd
+	r.mu.Unlock()
+	if cmd != nil {
+		plan.Run("kill", "-INT", strconv.Itoa(cmd.Process.Pid))
+		plan.Note("ssh is killed if it has not exited after %s", stopTimeout)
+	} else {
+		plan.Run("pkill", "-f", r.pattern())
+	}
+	return plan, nil
+}
+
+// Disconnect stops the ssh client this provider started, or one started
+// by an earlier run of tunnel for the same relay port
 func (r *ReverseSSHProvider) Disconnect() error {
-	if r.cmd != nil && r.cmd.Process != nil {
-		return r.cmd.Process.Kill()
+	r.mu.Lock()
+	cmd, exited := r.cmd, r.exited
+	r.mu.Unlock()
+
+	if cmd == nil {
+		_ = exec.Command("pkill", "-f", r.pattern()).Run()
+		return nil
+	}
+
+	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+		_ = cmd.Process.Kill()
+	}
+	select {
+	case <-exited:
+	case <-time.After(stopTimeout):
+		_ = cmd.Process.Kill()
+		<-exited
 	}
-	// Fallback: kill any reverse SSH tunnels
-	cmd := exec.Command("pkill", "-f", "ssh -R")
-	_ = cmd.Run()
 	return nil
 }
 
+// pattern matches the ssh client for the configured forward in pgrep -f,
+// or any reverse forward when the provider isn't configured
+func (r *ReverseSSHProvider) pattern() string {
+	config, err := r.GetConfig()
+	if err != nil {
+		return "ssh -R"
+	}
+	relay, err := relayFromConfig(config)
+	if err != nil {
+		return "ssh -R"
+	}
+	return "ssh -R " + relay.forward()
+}
+
 // IsConnected checks if reverse SSH tunnel is active
 func (r *ReverseSSHProvider) IsConnected() bool {
-	cmd := exec.Command("pgrep", "-f", "ssh -R")
-	err := cmd.Run()
-	return err == nil
+	r.mu.Lock()
+	running := r.cmd != nil
+	r.mu.Unlock()
+	if running {
+		return true
+	}
+	return exec.Command("pgrep", "-f", r.pattern()).Run() == nil
+}
+
+// current returns the settings of the running tunnel, or of the config
+// when the tunnel was started by another run of tunnel
+func (r *ReverseSSHProvider) current() (relaySettings, *exec.Cmd, time.Time, error) {
+	r.mu.Lock()
+	cmd, relay, connectedAt := r.cmd, r.relay, r.connectedAt
+	r.mu.Unlock()
+	if cmd != nil {
+		return relay, cmd, connectedAt, nil
+	}
+
+	config, err := r.GetConfig()
+	if err != nil {
+		return relaySettings{}, nil, time.Time{}, err
+	}
+	relay, err = relayFromConfig(config)
+	return relay, nil, time.Time{}, err
 }
 
 // GetConnectionInfo retrieves current connection information
@@ -125,16 +487,35 @@ func (r *ReverseSSHProvider) GetConnectionInfo() (*providers.ConnectionInfo, err
 		Status: "disconnected",
 		Extra:  make(map[string]interface{}),
 	}
-
-	if r.IsConnected() {
-		info.Status = "connected"
-		info.Extra["type"] = "reverse-ssh-tunnel"
+	if !r.IsConnected() {
+		return info, nil
 	}
 
+	info.Status = "connected"
+	info.Extra["type"] = "reverse-ssh-tunnel"
+	relay, cmd, connectedAt, err := r.current()
+	if err != nil {
+		return info, nil
+	}
+	info.ConnectedAt = connectedAt
+	info.RemoteIP = relay.server
+	info.RemotePort, _ = strconv.Atoi(relay.remotePort)
+	info.LocalPort, _ = strconv.Atoi(relay.localPort)
+	info.TunnelURL = "ssh://" + net.JoinHostPort(relay.server, relay.remotePort)
+	info.Extra["relay"] = relay.address()
+	if relay.user != "" {
+		info.Extra["relay_user"] = relay.user
+	}
+	if cmd != nil {
+		info.Extra["pid"] = cmd.Process.Pid
+	}
 	return info, nil
 }
 
-// HealthCheck performs a health check
+// HealthCheck reports on the tunnel's ssh client and the relay behind it.
+// A running client has had its keepalives answered within the keepalive
+// window; the relay is also probed for its SSH banner to measure latency
+// and to catch an outage before the keepalives give up.
 func (r *ReverseSSHProvider) HealthCheck() (*providers.HealthStatus, error) {
 	if !r.IsInstalled() {
 		return &providers.HealthStatus{
@@ -144,25 +525,165 @@ func (r *ReverseSSHProvider) HealthCheck() (*providers.HealthStatus, error) {
 			LastCheck: time.Now(),
 		}, nil
 	}
+	if !r.IsConnected() {
+		message := "Reverse SSH tunnel is not active"
+		if line := r.lastLine(); line != "no output" {
+			message += ": " + line
+		}
+		return &providers.HealthStatus{
+			Healthy:   false,
+			Status:    "disconnected",
+			Message:   message,
+			LastCheck: time.Now(),
+		}, nil
+	}
 
-	connected := r.IsConnected()
-	status := "ready"
-	message := "SSH client is available for reverse tunneling"
+	relay, _, connectedAt, err := r.current()
+	if err != nil {
+		return &providers.HealthStatus{
+			Healthy:   true,
+			Status:    "connected",
+			Message:   "Reverse SSH tunnel is active",
+			LastCheck: time.Now(),
+		}, nil
+	}
 
-	if connected {
-		status = "connected"
-		message = "Reverse SSH tunnel is active"
+	metrics := map[string]interface{}{
+		"relay":              relay.address(),
+		"keepalive_interval": relay.keepaliveInterval,
+		"keepalive_count":    relay.keepaliveCountMax,
+	}
+	if !connectedAt.IsZero() {
+		metrics["uptime_seconds"] = int(time.Since(connectedAt).Seconds())
 	}
 
+	latency, err := probeRelay(relay.address(), probeTimeout)
+	if err != nil {
+		return &providers.HealthStatus{
+			Healthy: false,
+			Status:  "degraded",
+			Message: fmt.Sprintf("relay %s is not answering (%v); ssh gives up on it after %s",
+				relay.address(), err, relay.keepaliveWindow()),
+			LastCheck: time.Now(),
+			Metrics:   metrics,
+		}, nil
+	}
 	return &providers.HealthStatus{
 		Healthy:   true,
-		Status:    status,
-		Message:   message,
+		Status:    "connected",
+		Message:   fmt.Sprintf("Reverse SSH tunnel is active: %s:%s forwards to %s:%s", relay.server, relay.remotePort, relay.localHost, relay.localPort),
 		LastCheck: time.Now(),
+		Latency:   latency,
+		Metrics:   metrics,
 	}, nil
 }
 
-// GetLogs retrieves logs
+// probeRelay dials the relay's SSH port and reads its banner, returning
+// how long that took
+func probeRelay(address string, timeout time.Duration) (time.Duration, error) {
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+
+	banner, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return 0, fmt.Errorf("read banner: %w", err)
+	}
+	if !strings.HasPrefix(banner, "SSH-") {
+		return 0, fmt.Errorf("not an SSH server: %q", strings.TrimSpace(banner))
+	}
+	return time.Since(start), nil
+}
+
+// GetLogs returns ssh's output since the specified time
 func (r *ReverseSSHProvider) GetLogs(since time.Time) ([]providers.LogEntry, error) {
-	return []providers.LogEntry{}, nil
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	logs := []providers.LogEntry{}
+	for _, entry := range r.logs {
+		if !entry.Timestamp.Before(since) {
+			logs = append(logs, entry)
+		}
+	}
+	return logs, nil
+}
+
+// ValidateConfig checks that the relay is configured
+func (r *ReverseSSHProvider) ValidateConfig(config *providers.ProviderConfig) error {
+	if err := r.BaseProvider.ValidateConfig(config); err != nil {
+		return err
+	}
+	if _, err := relayFromConfig(config); err != nil {
+		return fmt.Errorf("%w: %v", providers.ErrInvalidConfig, err)
+	}
+	return nil
+}
+
+// lastLine returns the last line ssh wrote, to explain why it exited
+func (r *ReverseSSHProvider) lastLine() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := len(r.logs) - 1; i >= 0; i-- {
+		if r.logs[i].Source == "ssh" {
+			return r.logs[i].Message
+		}
+	}
+	return "no output"
+}
+
+// log appends a log entry, dropping the oldest past maxLogLines; r.mu must
+// be held
+func (r *ReverseSSHProvider) log(level, message, source string) {
+	r.logs = append(r.logs, providers.LogEntry{
+		Timestamp: time.Now(),
+		Level:     level,
+		Message:   message,
+		Source:    source,
+	})
+	if len(r.logs) > maxLogLines {
+		r.logs = r.logs[len(r.logs)-maxLogLines:]
+	}
+}
+
+// logLevel guesses the level of a line of ssh output
+func logLevel(line string) string {
+	lower := strings.ToLower(line)
+	switch {
+	case strings.Contains(lower, "error") || strings.Contains(lower, "denied") || strings.Contains(lower, "failed"):
+		return "Error"
+	case strings.Contains(lower, "warning"):
+		return "Warning"
+	default:
+		return "Info"
+	}
+}
+
+// lineWriter splits ssh's output into log lines
+type lineWriter struct {
+	provider *ReverseSSHProvider
+	partial  []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimRight(string(w.partial[:i]), "\r")
+		w.partial = w.partial[i+1:]
+		if line == "" {
+			continue
+		}
+		w.provider.mu.Lock()
+		w.provider.log(logLevel(line), line, "ssh")
+		w.provider.mu.Unlock()
+	}
+	return len(p), nil
 }
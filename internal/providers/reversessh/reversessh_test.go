@@ -0,0 +1,164 @@
+package reversessh
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/providers"
+)
+
+func TestRelayFromConfigDefaults(t *testing.T) {
+	relay, err := relayFromConfig(&providers.ProviderConfig{
+		Name:  "reverse-ssh",
+		Extra: map[string]string{"relayServer": "jump.example.com"},
+	})
+	if err != nil {
+		t.Fatalf("relayFromConfig() error = %v", err)
+	}
+	if relay.port != "22" || relay.remotePort != "2222" || relay.localPort != "22" {
+		t.Errorf("ports = %s/%s/%s, want 22/2222/22", relay.port, relay.remotePort, relay.localPort)
+	}
+	if relay.keepaliveWindow() != 45*time.Second {
+		t.Errorf("keepaliveWindow() = %s, want 45s", relay.keepaliveWindow())
+	}
+	if relay.hostKeyChecking != "accept-new" {
+		t.Errorf("hostKeyChecking = %q, want accept-new", relay.hostKeyChecking)
+	}
+}
+
+func TestRelayFromConfigFields(t *testing.T) {
+	relay, err := relayFromConfig(&providers.ProviderConfig{
+		Name:       "reverse-ssh",
+		RemoteHost: "relay.example.com",
+		RemotePort: 2022,
+		LocalPort:  2200,
+		Extra:      map[string]string{"relayPort": "2222", "keepaliveInterval": "5"},
+	})
+	if err != nil {
+		t.Fatalf("relayFromConfig() error = %v", err)
+	}
+	if relay.address() != "relay.example.com:2222" {
+		t.Errorf("address() = %q", relay.address())
+	}
+	if relay.forward() != "2022:localhost:2200" {
+		t.Errorf("forward() = %q", relay.forward())
+	}
+	if relay.keepaliveInterval != 5 {
+		t.Errorf("keepaliveInterval = %d, want 5", relay.keepaliveInterval)
+	}
+}
+
+func TestRelayFromConfigInvalid(t *testing.T) {
+	tests := map[string]map[string]string{
+		"no server":     {},
+		"bad port":      {"relayServer": "jump", "relayPort": "ssh"},
+		"port range":    {"relayServer": "jump", "remotePort": "70000"},
+		"bad keepalive": {"relayServer": "jump", "keepaliveInterval": "0"},
+		"bad host keys": {"relayServer": "jump", "strictHostKeyChecking": "maybe"},
+	}
+	for name, extra := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := relayFromConfig(&providers.ProviderConfig{Name: "reverse-ssh", Extra: extra}); err == nil {
+				t.Error("relayFromConfig() succeeded")
+			}
+		})
+	}
+}
+
+func TestCommandData(t *testing.T) {
+	relay, err := relayFromConfig(&providers.ProviderConfig{
+		Name: "reverse-ssh",
+		Extra: map[string]string{
+			"relayServer":   "jump.example.com",
+			"relayUsername": "tunnel",
+			"identityFile":  "/keys/id_ed25519",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := relay.commandData()
+	args := strings.Join(data.Args, " ")
+
+	if !strings.HasPrefix(args, "-R 2222:localhost:22 ") {
+		t.Errorf("args %q must start with the forward so %q matches", args, New().ProcessPatterns()[0])
+	}
+	for _, want := range []string{
+		"ExitOnForwardFailure=yes",
+		"ServerAliveInterval=15",
+		"ServerAliveCountMax=3",
+		"BatchMode=yes",
+		"-i /keys/id_ed25519",
+	} {
+		if !strings.Contains(args, want) {
+			t.Errorf("args %q missing %q", args, want)
+		}
+	}
+	if last := data.Args[len(data.Args)-1]; last != "tunnel@jump.example.com" {
+		t.Errorf("target = %q, want tunnel@jump.example.com", last)
+	}
+	if data.Port != 22 || data.RemotePort != 2222 {
+		t.Errorf("ports = %d/%d, want 22/2222", data.Port, data.RemotePort)
+	}
+}
+
+func TestProbeRelay(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+			conn.Close()
+		}
+	}()
+
+	if _, err := probeRelay(ln.Addr().String(), time.Second); err != nil {
+		t.Errorf("probeRelay() error = %v", err)
+	}
+
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := closed.Addr().String()
+	closed.Close()
+	if _, err := probeRelay(addr, time.Second); err == nil {
+		t.Error("probeRelay() of a closed port succeeded")
+	}
+}
+
+func TestConnectReportsEarlyExit(t *testing.T) {
+	dir := t.TempDir()
+	script := "#!/bin/sh\necho 'Warning: remote port forwarding failed for listen port 2222' >&2\nexit 255\n"
+	if err := os.WriteFile(filepath.Join(dir, "ssh"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+
+	p := New()
+	p.Configure(&providers.ProviderConfig{Name: "reverse-ssh", Extra: map[string]string{"relayServer": "jump"}})
+	err := p.Connect()
+	if !errors.Is(err, providers.ErrConnectionFailed) {
+		t.Fatalf("Connect() error = %v, want ErrConnectionFailed", err)
+	}
+	if !strings.Contains(err.Error(), "remote port forwarding failed") {
+		t.Errorf("Connect() error = %v, want ssh's output", err)
+	}
+	if p.IsConnected() {
+		t.Error("IsConnected() after ssh exited")
+	}
+
+	logs, _ := p.GetLogs(time.Time{})
+	if len(logs) == 0 {
+		t.Error("GetLogs() returned nothing")
+	}
+}
@@ -0,0 +1,85 @@
+package providers
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/jedarden/tunnel/internal/logging"
+	"github.com/jedarden/tunnel/internal/sandbox"
+)
+
+var sandboxes = struct {
+	sync.RWMutex
+	policies map[string]sandbox.Policy
+}{policies: make(map[string]sandbox.Policy)}
+
+// SetSandbox confines the processes provider starts from now on. A zero
+// policy removes the confinement.
+func SetSandbox(provider string, policy sandbox.Policy) {
+	sandboxes.Lock()
+	defer sandboxes.Unlock()
+	if policy.IsZero() {
+		delete(sandboxes.policies, provider)
+		return
+	}
+	sandboxes.policies[provider] = policy
+}
+
+// Sandbox applies the policy configured for provider to cmd before it is
+// started. Providers call it for the long-running processes they spawn.
+func Sandbox(provider string, cmd *exec.Cmd) error {
+	sandboxes.RLock()
+	policy, ok := sandboxes.policies[provider]
+	sandboxes.RUnlock()
+	if !ok {
+		return nil
+	}
+	if policy.Name == "" {
+		policy.Name = provider
+	}
+
+	warnings, err := sandbox.Apply(cmd, policy)
+	if err != nil {
+		return fmt.Errorf("sandbox %s: %w", provider, err)
+	}
+	for _, w := range warnings {
+		logging.For("sandbox").Warn(w, "provider", provider)
+	}
+	return nil
+}
+
+// Sandboxed reports whether provider's processes are confined
+func Sandboxed(provider string) bool {
+	sandboxes.RLock()
+	defer sandboxes.RUnlock()
+	_, ok := sandboxes.policies[provider]
+	return ok
+}
+
+// LimitViolations describes how often the resource limits configured for
+// provider held its processes back
+func LimitViolations(provider string) []string {
+	sandboxes.RLock()
+	policy, ok := sandboxes.policies[provider]
+	sandboxes.RUnlock()
+	if !ok || policy.Limits.IsZero() {
+		return nil
+	}
+	return sandbox.Violations(provider)
+}
+
+// CheckHealth runs the health check of p and reports it as degraded while
+// its processes run into their resource limits
+func CheckHealth(p Provider) (*HealthStatus, error) {
+	health, err := p.HealthCheck()
+	if err != nil || health == nil || !health.Healthy {
+		return health, err
+	}
+	if violations := LimitViolations(p.Name()); len(violations) > 0 {
+		health.Status = "degraded"
+		health.Message = "resource limits: " + strings.Join(violations, "; ")
+	}
+	return health, nil
+}
@@ -0,0 +1,19 @@
+//go:build windows
+
+package script
+
+import "os/exec"
+
+// newProcessGroup does nothing on Windows, where the connect command is
+// stopped on its own
+func newProcessGroup(cmd *exec.Cmd) {}
+
+// interrupt kills cmd, as Windows has no interrupt to send it
+func interrupt(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+// kill kills cmd
+func kill(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
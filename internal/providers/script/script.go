@@ -0,0 +1,617 @@
+// Package script implements providers whose connect, disconnect and health
+// check are commands the user supplies in config. TUNNEL supervises the
+// connect command and reads the public endpoint from its output, so any
+// tunnel client with a command line can be used like a built-in provider.
+package script
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/cmdtemplate"
+	"github.com/jedarden/tunnel/internal/providers"
+)
+
+const (
+	// defaultStartTimeout is how long Connect waits for the endpoint
+	defaultStartTimeout = 30 * time.Second
+
+	// settleTime is how long Connect watches a command without a declared
+	// endpoint for an early exit
+	settleTime = time.Second
+
+	// stopTimeout is how long the connect command has to exit after an
+	// interrupt before it is killed
+	stopTimeout = 10 * time.Second
+
+	// healthTimeout bounds the health command
+	healthTimeout = 15 * time.Second
+
+	// maxRestartDelay caps the backoff between restarts
+	maxRestartDelay = time.Minute
+
+	// maxLogLines is how many output lines are kept for GetLogs
+	maxLogLines = 500
+)
+
+// Spec is what a script provider runs. Commands are templates rendered
+// with the provider's config; Connect is required.
+type Spec struct {
+	Connect      *cmdtemplate.Template
+	Disconnect   *cmdtemplate.Template
+	Health       *cmdtemplate.Template
+	Endpoint     *regexp.Regexp // first group, or the whole match, is the endpoint
+	EndpointJSON string         // dotted field of a JSON output line holding the endpoint
+	Category     providers.Category
+	Env          map[string]string
+	Restart      bool
+	StartTimeout time.Duration
+}
+
+// declaresEndpoint reports whether the spec says how to find the endpoint
+func (s Spec) declaresEndpoint() bool {
+	return s.Endpoint != nil || s.EndpointJSON != ""
+}
+
+// ScriptProvider implements the Provider interface for user-supplied commands
+type ScriptProvider struct {
+	*providers.BaseProvider
+	spec Spec
+
+	mu          sync.Mutex
+	cmd         *exec.Cmd     // running connect command, nil while stopped
+	exited      chan struct{} // closed when cmd exits
+	found       chan struct{} // closed when the endpoint is first seen; nil once it has been
+	gen         int           // bumped by each Connect and Disconnect to retire old supervisors
+	endpoint    string
+	connectedAt time.Time
+	restarts    int
+	logs        []providers.LogEntry
+}
+
+// New creates a script provider called name
+func New(name string, spec Spec) *ScriptProvider {
+	category := spec.Category
+	if category == "" {
+		category = providers.CategoryTunnel
+	}
+	if spec.StartTimeout <= 0 {
+		spec.StartTimeout = defaultStartTimeout
+	}
+	return &ScriptProvider{
+		BaseProvider: providers.NewBaseProvider(name, category),
+		spec:         spec,
+	}
+}
+
+// ProcessPatterns matches the running connect command for resource monitoring
+func (s *ScriptProvider) ProcessPatterns() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cmd == nil {
+		return nil
+	}
+	return []string{strings.Join(s.cmd.Args, " ")}
+}
+
+// Install reports that script providers are installed by the user
+func (s *ScriptProvider) Install() error {
+	if s.IsInstalled() {
+		return providers.ErrAlreadyInstalled
+	}
+	return fmt.Errorf("%w: script %s runs commands you install yourself", providers.ErrInstallFailed, s.Name())
+}
+
+// Uninstall reports that script providers are uninstalled by the user
+func (s *ScriptProvider) Uninstall() error {
+	return fmt.Errorf("script %s runs commands TUNNEL did not install; remove them yourself", s.Name())
+}
+
+// IsInstalled checks that the binary of each command can be found
+func (s *ScriptProvider) IsInstalled() bool {
+	data := s.commandData()
+	for _, tmpl := range []*cmdtemplate.Template{s.spec.Connect, s.spec.Disconnect, s.spec.Health} {
+		if tmpl == nil {
+			continue
+		}
+		argv, err := tmpl.Render(data)
+		if err != nil {
+			return false
+		}
+		if _, err := exec.LookPath(argv[0]); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// commandData describes the provider's config to its command templates
+func (s *ScriptProvider) commandData() providers.CommandData {
+	config, err := s.GetConfig()
+	if err != nil {
+		return providers.CommandData{}
+	}
+	token := config.AuthToken
+	if token == "" {
+		token = config.AuthKey
+	}
+	return providers.CommandData{
+		Port:       config.LocalPort,
+		RemoteHost: config.RemoteHost,
+		RemotePort: config.RemotePort,
+		Token:      token,
+		ConfigFile: config.ConfigFile,
+		TunnelName: config.TunnelName,
+		NetworkID:  config.NetworkID,
+	}
+}
+
+// command builds tmpl's command with the script's environment
+func (s *ScriptProvider) command(tmpl *cmdtemplate.Template) (*exec.Cmd, error) {
+	cmd, err := providers.TemplateCommand(s.Name(), tmpl, s.commandData())
+	if err != nil {
+		return nil, err
+	}
+	if len(s.spec.Env) > 0 {
+		cmd.Env = os.Environ()
+		for k, v := range s.spec.Env {
+			cmd.Env = append(cmd.Env, k+"="+v)
+		}
+	}
+	return cmd, nil
+}
+
+// Connect starts the connect command and waits for it to report the
+// endpoint, or, if no endpoint is declared, to survive its first second
+func (s *ScriptProvider) Connect() error {
+	s.mu.Lock()
+	if s.cmd != nil {
+		s.mu.Unlock()
+		return providers.ErrAlreadyConnected
+	}
+	s.gen++
+	s.endpoint = ""
+	s.connectedAt = time.Time{}
+	s.restarts = 0
+	s.found = make(chan struct{})
+	found := s.found
+	if err := s.start(); err != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %v", providers.ErrConnectionFailed, err)
+	}
+	exited := s.exited
+	s.mu.Unlock()
+
+	if !s.spec.declaresEndpoint() {
+		found = nil
+	}
+	wait := s.spec.StartTimeout
+	if found == nil {
+		wait = settleTime
+	}
+
+	select {
+	case <-found:
+	case <-exited:
+		return fmt.Errorf("%w: connect command exited: %s", providers.ErrConnectionFailed, s.lastLine())
+	case <-time.After(wait):
+		if found != nil {
+			s.stop()
+			return fmt.Errorf("%w: no endpoint in the connect command's output after %s", providers.ErrConnectionFailed, wait)
+		}
+	}
+
+	s.mu.Lock()
+	s.connectedAt = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+// start launches the connect command and its supervisor; s.mu must be held
+func (s *ScriptProvider) start() error {
+	cmd, err := s.command(s.spec.Connect)
+	if err != nil {
+		return err
+	}
+	cmd.Stdout = &lineWriter{provider: s, source: "stdout"}
+	cmd.Stderr = &lineWriter{provider: s, source: "stderr"}
+	// Children that outlive the command must not hold Wait up forever
+	cmd.WaitDelay = stopTimeout
+	newProcessGroup(cmd)
+	if err := providers.Sandbox(s.Name(), cmd); err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	s.cmd = cmd
+	s.exited = make(chan struct{})
+	s.log("Info", fmt.Sprintf("started connect command (pid %d)", cmd.Process.Pid), "tunnel")
+	go s.supervise(cmd, s.exited, s.gen, time.Now())
+	return nil
+}
+
+// supervise waits for cmd to exit and restarts it if the spec asks for
+// that and it was neither stopped nor still connecting
+func (s *ScriptProvider) supervise(cmd *exec.Cmd, exited chan struct{}, gen int, startedAt time.Time) {
+	err := cmd.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	close(exited)
+	if s.cmd == cmd {
+		s.cmd = nil
+	}
+	if gen != s.gen {
+		return
+	}
+
+	if err != nil {
+		s.log("Error", fmt.Sprintf("connect command exited: %v", err), "tunnel")
+	} else {
+		s.log("Warning", "connect command exited", "tunnel")
+	}
+	if !s.spec.Restart || s.connectedAt.IsZero() {
+		s.endpoint = ""
+		s.connectedAt = time.Time{}
+		return
+	}
+
+	// A command that ran for a while before exiting starts over at the
+	// shortest delay
+	if time.Since(startedAt) > maxRestartDelay {
+		s.restarts = 0
+	}
+	delay := time.Second << s.restarts
+	if delay > maxRestartDelay || delay <= 0 {
+		delay = maxRestartDelay
+	} else {
+		s.restarts++
+	}
+	s.log("Info", fmt.Sprintf("restarting connect command in %s", delay), "tunnel")
+
+	s.mu.Unlock()
+	time.Sleep(delay)
+	s.mu.Lock()
+
+	if gen != s.gen || s.cmd != nil {
+		return
+	}
+	if err := s.start(); err != nil {
+		s.log("Error", fmt.Sprintf("failed to restart connect command: %v", err), "tunnel")
+		s.endpoint = ""
+		s.connectedAt = time.Time{}
+	}
+}
+
+// stop interrupts the connect command, killing it if it does not exit in
+// time, and retires its supervisor
+func (s *ScriptProvider) stop() {
+	s.mu.Lock()
+	s.gen++
+	cmd, exited := s.cmd, s.exited
+	s.mu.Unlock()
+	if cmd == nil {
+		return
+	}
+
+	if err := interrupt(cmd); err != nil {
+		_ = kill(cmd)
+	}
+	select {
+	case <-exited:
+	case <-time.After(stopTimeout):
+		_ = kill(cmd)
+		<-exited
+	}
+}
+
+// PlanConnect describes the connect command Connect would supervise
+func (s *ScriptProvider) PlanConnect() (*providers.Plan, error) {
+	data := s.commandData()
+	data.Token = providers.Redact(data.Token)
+	argv, err := s.spec.Connect.Render(data)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := providers.NewPlan(s, providers.ActionConnect)
+	plan.Start(argv[0], argv[1:]...)
+	if data.Port != 0 {
+		plan.Port(data.Port, "tcp", "local", "exposed by the connect command")
+	}
+	switch {
+	case s.spec.Endpoint != nil:
+		plan.Note("the endpoint is read from output matching %s", s.spec.Endpoint)
+	case s.spec.EndpointJSON != "":
+		plan.Note("the endpoint is read from the %s field of JSON output", s.spec.EndpointJSON)
+	}
+	if s.spec.Restart {
+		plan.Note("the connect command is restarted if it exits")
+	}
+	return plan, nil
+}
+
+// PlanDisconnect describes how Disconnect stops the script
+func (s *ScriptProvider) PlanDisconnect() (*providers.Plan, error) {
+	plan := providers.NewPlan(s, providers.ActionDisconnect)
+	plan.Note("the connect command is interrupted, and killed after %s", stopTimeout)
+	if s.spec.Disconnect != nil {
+		data := s.commandData()
+		data.Token = providers.Redact(data.Token)
+		argv, err := s.spec.Disconnect.Render(data)
+		if err != nil {
+			return nil, err
+		}
+		plan.Run(argv[0], argv[1:]...)
+	}
+	return plan, nil
+}
+
+// Disconnect stops the connect command and runs the disconnect command
+func (s *ScriptProvider) Disconnect() error {
+	s.stop()
+
+	s.mu.Lock()
+	s.endpoint = ""
+	s.connectedAt = time.Time{}
+	s.mu.Unlock()
+
+	if s.spec.Disconnect == nil {
+		return nil
+	}
+	cmd, err := s.command(s.spec.Disconnect)
+	if err != nil {
+		return err
+	}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: disconnect command: %v: %s", providers.ErrCommandFailed, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// IsConnected reports whether the connect command is running
+func (s *ScriptProvider) IsConnected() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cmd != nil
+}
+
+// GetConnectionInfo retrieves current connection information
+func (s *ScriptProvider) GetConnectionInfo() (*providers.ConnectionInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info := &providers.ConnectionInfo{
+		Status: "disconnected",
+		Extra:  make(map[string]interface{}),
+	}
+	if s.cmd == nil {
+		return info, nil
+	}
+
+	info.Status = "connected"
+	info.ConnectedAt = s.connectedAt
+	if s.endpoint != "" {
+		info.TunnelURL = s.endpoint
+		info.RemoteIP, info.RemotePort = providers.SplitHostPort(s.endpoint)
+	}
+	if config, err := s.GetConfig(); err == nil {
+		info.LocalPort = config.LocalPort
+	}
+	info.Extra["pid"] = s.cmd.Process.Pid
+	info.Extra["restarts"] = s.restarts
+	return info, nil
+}
+
+// HealthCheck runs the health command, or checks that the connect command
+// is running if there is none
+func (s *ScriptProvider) HealthCheck() (*providers.HealthStatus, error) {
+	if !s.IsConnected() {
+		return &providers.HealthStatus{
+			Healthy:   false,
+			Status:    "disconnected",
+			Message:   fmt.Sprintf("%s is not running", s.Name()),
+			LastCheck: time.Now(),
+		}, nil
+	}
+	if s.spec.Health == nil {
+		message := fmt.Sprintf("%s is running", s.Name())
+		if endpoint := s.Endpoint(); endpoint != "" {
+			message = fmt.Sprintf("%s is running at %s", s.Name(), endpoint)
+		}
+		return &providers.HealthStatus{
+			Healthy:   true,
+			Status:    "connected",
+			Message:   message,
+			LastCheck: time.Now(),
+		}, nil
+	}
+
+	cmd, err := s.command(s.spec.Health)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), healthTimeout)
+	defer cancel()
+	health := exec.CommandContext(ctx, cmd.Path, cmd.Args[1:]...)
+	health.Env = cmd.Env
+
+	start := time.Now()
+	output, err := health.CombinedOutput()
+	status := &providers.HealthStatus{
+		Healthy:   err == nil,
+		Status:    "connected",
+		Message:   "health command passed",
+		Latency:   time.Since(start),
+		LastCheck: time.Now(),
+	}
+	if err != nil {
+		status.Status = "unhealthy"
+		status.Message = fmt.Sprintf("health command failed: %v", err)
+	}
+	if out := strings.TrimSpace(string(output)); out != "" {
+		status.Message += ": " + firstLine(out)
+	}
+	return status, nil
+}
+
+// GetLogs returns the connect command's output since the specified time
+func (s *ScriptProvider) GetLogs(since time.Time) ([]providers.LogEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	logs := []providers.LogEntry{}
+	for _, entry := range s.logs {
+		if !entry.Timestamp.Before(since) {
+			logs = append(logs, entry)
+		}
+	}
+	return logs, nil
+}
+
+// Endpoint returns the public endpoint last read from the connect command
+func (s *ScriptProvider) Endpoint() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.endpoint
+}
+
+// lastLine returns the last line the connect command wrote, to explain
+// why it failed
+func (s *ScriptProvider) lastLine() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.logs) - 1; i >= 0; i-- {
+		if s.logs[i].Source != "tunnel" {
+			return s.logs[i].Message
+		}
+	}
+	return "no output"
+}
+
+// output records a line of the connect command's output and looks for the
+// endpoint in it
+func (s *ScriptProvider) output(line, source string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.log(logLevel(line), line, source)
+	endpoint := s.parseEndpoint(line)
+	if endpoint == "" {
+		return
+	}
+	s.endpoint = endpoint
+	if s.found != nil {
+		close(s.found)
+		s.found = nil
+	}
+}
+
+// log appends a log entry, dropping the oldest past maxLogLines; s.mu must
+// be held
+func (s *ScriptProvider) log(level, message, source string) {
+	s.logs = append(s.logs, providers.LogEntry{
+		Timestamp: time.Now(),
+		Level:     level,
+		Message:   message,
+		Source:    source,
+	})
+	if len(s.logs) > maxLogLines {
+		s.logs = s.logs[len(s.logs)-maxLogLines:]
+	}
+}
+
+// parseEndpoint returns the endpoint declared in line, if any
+func (s *ScriptProvider) parseEndpoint(line string) string {
+	if s.spec.Endpoint != nil {
+		m := s.spec.Endpoint.FindStringSubmatch(line)
+		switch {
+		case m == nil:
+			return ""
+		case len(m) > 1:
+			return m[1]
+		default:
+			return m[0]
+		}
+	}
+	if s.spec.EndpointJSON != "" {
+		return jsonField(line, s.spec.EndpointJSON)
+	}
+	return ""
+}
+
+// jsonField returns the string at a dotted path such as "tunnel.url" in a
+// line holding a JSON object, or "" if there is none
+func jsonField(line, path string) string {
+	var value interface{}
+	if err := json.Unmarshal([]byte(line), &value); err != nil {
+		return ""
+	}
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		value = object[key]
+	}
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return fmt.Sprint(v)
+	default:
+		return ""
+	}
+}
+
+// logLevel guesses the level of an output line
+func logLevel(line string) string {
+	lower := strings.ToLower(line)
+	switch {
+	case strings.Contains(lower, "error") || strings.Contains(lower, "failed"):
+		return "Error"
+	case strings.Contains(lower, "warn"):
+		return "Warning"
+	default:
+		return "Info"
+	}
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+// lineWriter splits what a command writes into lines for its provider
+type lineWriter struct {
+	provider *ScriptProvider
+	source   string
+	partial  []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimRight(string(w.partial[:i]), "\r")
+		w.partial = w.partial[i+1:]
+		if line != "" {
+			w.provider.output(line, w.source)
+		}
+	}
+	return len(p), nil
+}
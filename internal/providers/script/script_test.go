@@ -0,0 +1,176 @@
+package script
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/cmdtemplate"
+	"github.com/jedarden/tunnel/internal/providers"
+)
+
+func mustParse(t *testing.T, text string) *cmdtemplate.Template {
+	t.Helper()
+	tmpl, err := cmdtemplate.Parse(text)
+	if err != nil {
+		t.Fatalf("parse %q: %v", text, err)
+	}
+	return tmpl
+}
+
+func TestConnectReadsEndpoint(t *testing.T) {
+	p := New("demo", Spec{
+		Connect:  mustParse(t, `sh -c 'echo starting; echo "listening at demo.example:{{.Port}}"; sleep 30'`),
+		Endpoint: regexp.MustCompile(`listening at (\S+)`),
+	})
+	if err := p.Configure(&providers.ProviderConfig{Name: "demo", LocalPort: 4000}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer p.Disconnect()
+
+	if !p.IsConnected() {
+		t.Fatal("expected the script to be connected")
+	}
+	info, err := p.GetConnectionInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.TunnelURL != "demo.example:4000" || info.RemotePort != 4000 {
+		t.Errorf("got endpoint %q port %d, want demo.example:4000", info.TunnelURL, info.RemotePort)
+	}
+	if err := p.Connect(); !errors.Is(err, providers.ErrAlreadyConnected) {
+		t.Errorf("second Connect returned %v, want ErrAlreadyConnected", err)
+	}
+
+	logs, _ := p.GetLogs(time.Time{})
+	var sawOutput bool
+	for _, entry := range logs {
+		if entry.Message == "starting" && entry.Source == "stdout" {
+			sawOutput = true
+		}
+	}
+	if !sawOutput {
+		t.Errorf("output missing from logs: %+v", logs)
+	}
+
+	if err := p.Disconnect(); err != nil {
+		t.Fatalf("Disconnect: %v", err)
+	}
+	if p.IsConnected() {
+		t.Error("expected the script to be stopped")
+	}
+}
+
+func TestConnectReadsJSONEndpoint(t *testing.T) {
+	p := New("demo", Spec{
+		Connect:      mustParse(t, `sh -c 'echo "{\"tunnel\":{\"url\":\"https://demo.example\"}}"; sleep 30'`),
+		EndpointJSON: "tunnel.url",
+	})
+	if err := p.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer p.Disconnect()
+
+	if got := p.Endpoint(); got != "https://demo.example" {
+		t.Errorf("got endpoint %q", got)
+	}
+}
+
+func TestConnectFailsWhenCommandExits(t *testing.T) {
+	p := New("demo", Spec{
+		Connect:  mustParse(t, `sh -c 'echo "error: no route"; exit 3'`),
+		Endpoint: regexp.MustCompile(`listening at (\S+)`),
+	})
+
+	err := p.Connect()
+	if !errors.Is(err, providers.ErrConnectionFailed) {
+		t.Fatalf("got %v, want ErrConnectionFailed", err)
+	}
+	if !strings.Contains(err.Error(), "error: no route") {
+		t.Errorf("error does not explain the exit: %v", err)
+	}
+	if p.IsConnected() {
+		t.Error("expected the script not to be connected")
+	}
+}
+
+func TestRestartsAfterExit(t *testing.T) {
+	p := New("demo", Spec{
+		Connect:  mustParse(t, `sh -c 'echo "listening at demo.example:1"; sleep 0.5'`),
+		Endpoint: regexp.MustCompile(`listening at (\S+)`),
+		Restart:  true,
+	})
+	if err := p.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer p.Disconnect()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		info, _ := p.GetConnectionInfo()
+		if restarts, _ := info.Extra["restarts"].(int); restarts > 0 {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Error("connect command was not restarted")
+}
+
+func TestHealthCommand(t *testing.T) {
+	for _, tt := range []struct {
+		health  string
+		healthy bool
+	}{
+		{"true", true},
+		{`sh -c 'echo endpoint unreachable; exit 1'`, false},
+	} {
+		p := New("demo", Spec{
+			Connect: mustParse(t, "sleep 30"),
+			Health:  mustParse(t, tt.health),
+		})
+		if err := p.Connect(); err != nil {
+			t.Fatalf("Connect: %v", err)
+		}
+
+		status, err := p.HealthCheck()
+		_ = p.Disconnect()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Healthy != tt.healthy {
+			t.Errorf("%s: healthy = %v, want %v (%s)", tt.health, status.Healthy, tt.healthy, status.Message)
+		}
+	}
+}
+
+func TestPlanRedactsToken(t *testing.T) {
+	p := New("demo", Spec{
+		Connect:    mustParse(t, "demo-client --token {{.Token}} --port {{.Port}}"),
+		Disconnect: mustParse(t, "demo-client --down"),
+	})
+	if err := p.Configure(&providers.ProviderConfig{Name: "demo", AuthToken: "s3cret", LocalPort: 8080}); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := p.PlanConnect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := plan.Commands[0].String(); strings.Contains(got, "s3cret") || !strings.Contains(got, "--port 8080") {
+		t.Errorf("unexpected connect command: %s", got)
+	}
+
+	plan, err = p.PlanDisconnect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Commands) != 1 || plan.Commands[0].String() != "demo-client --down" {
+		t.Errorf("unexpected disconnect commands: %+v", plan.Commands)
+	}
+}
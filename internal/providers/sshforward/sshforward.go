@@ -59,6 +59,23 @@ func (s *SSHForwardProvider) Connect() error {
 	return nil
 }
 
+// PlanConnect describes how Connect makes sure sshd is running
+func (s *SSHForwardProvider) PlanConnect() (*providers.Plan, error) {
+	plan := providers.NewPlan(s, providers.ActionConnect)
+	plan.Run("pgrep", "sshd")
+	plan.Run("sudo", "systemctl", "start", "sshd").Condition = "sshd is not running"
+	plan.Port(22, "tcp", "local", "sshd, reached through your port forward")
+	return plan, nil
+}
+
+// PlanDisconnect describes how Disconnect stops sshd
+func (s *SSHForwardProvider) PlanDisconnect() (*providers.Plan, error) {
+	plan := providers.NewPlan(s, providers.ActionDisconnect)
+	plan.Run("sudo", "systemctl", "stop", "sshd")
+	plan.Note("this stops sshd for every user, not just tunnel connections")
+	return plan, nil
+}
+
 // Disconnect stops SSH server
 func (s *SSHForwardProvider) Disconnect() error {
 	cmd := exec.Command("sudo", "systemctl", "stop", "sshd")
@@ -83,6 +100,7 @@ func (s *SSHForwardProvider) GetConnectionInfo() (*providers.ConnectionInfo, err
 		info.Status = "connected"
 		info.Extra["type"] = "ssh-server"
 		info.Extra["port"] = 22
+		info.LocalPort = 22
 	}
 
 	return info, nil
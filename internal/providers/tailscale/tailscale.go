@@ -3,11 +3,13 @@ package tailscale
 import (
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"os/exec"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/jedarden/tunnel/internal/offline"
 	"github.com/jedarden/tunnel/internal/providers"
 )
 
@@ -23,6 +25,40 @@ func New() *TailscaleProvider {
 	}
 }
 
+// RequiresInternet reports that Tailscale needs internet access to connect
+func (t *TailscaleProvider) RequiresInternet() bool {
+	return true
+}
+
+// ProcessPatterns matches the tailscaled daemon for resource monitoring
+func (t *TailscaleProvider) ProcessPatterns() []string {
+	return []string{"tailscaled"}
+}
+
+// magicDNSAddr is the fixed address of Tailscale's MagicDNS resolver
+const magicDNSAddr = "100.100.100.100"
+
+// DNSServers returns the MagicDNS resolver, reachable only over the tailnet
+func (t *TailscaleProvider) DNSServers() []string {
+	return []string{magicDNSAddr}
+}
+
+// defaultControlURL is the coordination server used unless control_url
+// points at another, such as Headscale
+const defaultControlURL = "https://controlplane.tailscale.com"
+
+// Endpoints returns the coordination server
+func (t *TailscaleProvider) Endpoints() []string {
+	controlURL := defaultControlURL
+	if config, err := t.GetConfig(); err == nil && config.Extra["control_url"] != "" {
+		controlURL = config.Extra["control_url"]
+	}
+	if u, err := url.Parse(controlURL); err == nil && u.Hostname() != "" {
+		return []string{u.Hostname()}
+	}
+	return nil
+}
+
 // Install installs Tailscale
 func (t *TailscaleProvider) Install() error {
 	if t.IsInstalled() {
@@ -80,6 +116,10 @@ func (t *TailscaleProvider) IsInstalled() bool {
 
 // Connect establishes a Tailscale connection
 func (t *TailscaleProvider) Connect() error {
+	if err := offline.Check(t.Name()); err != nil {
+		return err
+	}
+
 	if !t.IsInstalled() {
 		return providers.ErrNotInstalled
 	}
@@ -89,26 +129,59 @@ func (t *TailscaleProvider) Connect() error {
 		return err
 	}
 
+	cmd, err := providers.Command(t.Name(), upData(config, config.AuthKey))
+	if err != nil {
+		return fmt.Errorf("%w: %v", providers.ErrConnectionFailed, err)
+	}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", providers.ErrConnectionFailed, string(output))
+	}
+
+	return nil
+}
+
+// upData describes the tailscale up command line for config, with authKey
+// standing in for its auth key
+func upData(config *providers.ProviderConfig, authKey string) providers.CommandData {
 	args := []string{"up"}
 
 	// Add auth key if provided
-	if config.AuthKey != "" {
-		args = append(args, "--authkey", config.AuthKey)
+	if authKey != "" {
+		args = append(args, "--authkey", authKey)
 	}
 
-	// Enable SSH
-	args = append(args, "--ssh")
-
-	// Accept routes
-	args = append(args, "--accept-routes")
+	// Enable SSH and accept routes
+	return providers.CommandData{
+		Binary:     "tailscale",
+		Args:       append(args, "--ssh", "--accept-routes"),
+		Port:       22,
+		Token:      authKey,
+		ConfigFile: config.ConfigFile,
+	}
+}
 
-	cmd := exec.Command("tailscale", args...)
-	output, err := cmd.CombinedOutput()
+// PlanConnect describes the tailscale command Connect would run
+func (t *TailscaleProvider) PlanConnect() (*providers.Plan, error) {
+	config, err := t.GetConfig()
 	if err != nil {
-		return fmt.Errorf("%w: %s", providers.ErrConnectionFailed, string(output))
+		return nil, err
 	}
 
-	return nil
+	plan := providers.NewPlan(t, providers.ActionConnect)
+	if err := plan.Launch(upData(config, providers.Redact(config.AuthKey)), false); err != nil {
+		return nil, err
+	}
+	plan.Port(22, "tcp", "local", "Tailscale SSH on the tailnet address")
+	plan.Note("routes advertised by other nodes are accepted")
+	return plan, nil
+}
+
+// PlanDisconnect describes the tailscale command Disconnect would run
+func (t *TailscaleProvider) PlanDisconnect() (*providers.Plan, error) {
+	plan := providers.NewPlan(t, providers.ActionDisconnect)
+	plan.Run("tailscale", "down")
+	return plan, nil
 }
 
 // Disconnect terminates the Tailscale connection
@@ -176,6 +249,10 @@ func (t *TailscaleProvider) GetConnectionInfo() (*providers.ConnectionInfo, erro
 
 // HealthCheck performs a health check
 func (t *TailscaleProvider) HealthCheck() (*providers.HealthStatus, error) {
+	if offline.Enabled() {
+		return providers.OfflineHealthStatus(t.Name()), nil
+	}
+
 	if !t.IsInstalled() {
 		return &providers.HealthStatus{
 			Healthy:   false,
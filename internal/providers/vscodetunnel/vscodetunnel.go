@@ -6,6 +6,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/jedarden/tunnel/internal/offline"
 	"github.com/jedarden/tunnel/internal/providers"
 )
 
@@ -21,6 +22,21 @@ func New() *VSCodeTunnelProvider {
 	}
 }
 
+// RequiresInternet reports that VS Code tunnels need internet access to connect
+func (v *VSCodeTunnelProvider) RequiresInternet() bool {
+	return true
+}
+
+// ProcessPatterns matches the VS Code tunnel process for resource monitoring
+func (v *VSCodeTunnelProvider) ProcessPatterns() []string {
+	return []string{"code tunnel"}
+}
+
+// Endpoints returns the dev tunnels service the VS Code CLI relays through
+func (v *VSCodeTunnelProvider) Endpoints() []string {
+	return []string{"global.rel.tunnels.api.visualstudio.com"}
+}
+
 // Install installs the VS Code CLI (code tunnel)
 func (v *VSCodeTunnelProvider) Install() error {
 	if v.IsInstalled() {
@@ -48,6 +64,10 @@ func (v *VSCodeTunnelProvider) IsInstalled() bool {
 
 // Connect starts a VS Code tunnel
 func (v *VSCodeTunnelProvider) Connect() error {
+	if err := offline.Check(v.Name()); err != nil {
+		return err
+	}
+
 	if !v.IsInstalled() {
 		return providers.ErrNotInstalled
 	}
@@ -57,16 +77,13 @@ func (v *VSCodeTunnelProvider) Connect() error {
 		return err
 	}
 
-	args := []string{"tunnel"}
-
-	// Add machine name if provided
-	if config.Extra != nil {
-		if name, ok := config.Extra["machineName"]; ok && name != "" {
-			args = append(args, "--name", name)
-		}
+	cmd, err := providers.Command(v.Name(), commandData(config))
+	if err != nil {
+		return fmt.Errorf("%w: %v", providers.ErrConnectionFailed, err)
+	}
+	if err := providers.Sandbox(v.Name(), cmd); err != nil {
+		return fmt.Errorf("%w: %v", providers.ErrConnectionFailed, err)
 	}
-
-	cmd := exec.Command("code", args...)
 	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("%w: %v", providers.ErrConnectionFailed, err)
 	}
@@ -77,6 +94,45 @@ func (v *VSCodeTunnelProvider) Connect() error {
 	return nil
 }
 
+// commandData describes the code tunnel command line for config
+func commandData(config *providers.ProviderConfig) providers.CommandData {
+	args := []string{"tunnel"}
+
+	// Add machine name if provided
+	if name := config.Extra["machineName"]; name != "" {
+		args = append(args, "--name", name)
+	}
+	return providers.CommandData{
+		Binary:     "code",
+		Args:       args,
+		Port:       config.LocalPort,
+		TunnelName: config.Extra["machineName"],
+		ConfigFile: config.ConfigFile,
+	}
+}
+
+// PlanConnect describes the code tunnel process Connect would start
+func (v *VSCodeTunnelProvider) PlanConnect() (*providers.Plan, error) {
+	config, err := v.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	plan := providers.NewPlan(v, providers.ActionConnect)
+	if err := plan.Launch(commandData(config), true); err != nil {
+		return nil, err
+	}
+	plan.Note("the first run asks for a GitHub or Microsoft login")
+	return plan, nil
+}
+
+// PlanDisconnect describes how Disconnect stops the tunnel
+func (v *VSCodeTunnelProvider) PlanDisconnect() (*providers.Plan, error) {
+	plan := providers.NewPlan(v, providers.ActionDisconnect)
+	plan.Run("pkill", "-f", "code tunnel")
+	return plan, nil
+}
+
 // Disconnect stops the VS Code tunnel
 func (v *VSCodeTunnelProvider) Disconnect() error {
 	cmd := exec.Command("pkill", "-f", "code tunnel")
@@ -108,6 +164,10 @@ func (v *VSCodeTunnelProvider) GetConnectionInfo() (*providers.ConnectionInfo, e
 
 // HealthCheck performs a health check
 func (v *VSCodeTunnelProvider) HealthCheck() (*providers.HealthStatus, error) {
+	if offline.Enabled() {
+		return providers.OfflineHealthStatus(v.Name()), nil
+	}
+
 	if !v.IsInstalled() {
 		return &providers.HealthStatus{
 			Healthy:   false,
@@ -1,9 +1,13 @@
 package wireguard
 
 import (
+	"bufio"
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -26,6 +30,49 @@ func New() *WireGuardProvider {
 	}
 }
 
+// DNSServers returns the resolver addresses from the DNS line of the
+// interface's wg-quick config. Search domains on that line are skipped.
+func (w *WireGuardProvider) DNSServers() []string {
+	path := filepath.Join("/etc/wireguard", w.interfaceName+".conf")
+	if config, err := w.GetConfig(); err == nil && config.ConfigFile != "" {
+		path = config.ConfigFile
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	return parseDNS(f)
+}
+
+// parseDNS extracts resolver IPs from DNS = lines in the [Interface] section
+func parseDNS(r io.Reader) []string {
+	var servers []string
+	inInterface := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			inInterface = strings.EqualFold(line, "[Interface]")
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !inInterface || !ok || !strings.EqualFold(strings.TrimSpace(key), "DNS") {
+			continue
+		}
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+			if net.ParseIP(field) != nil {
+				servers = append(servers, field)
+			}
+		}
+	}
+	return servers
+}
+
 // Install installs WireGuard
 func (w *WireGuardProvider) Install() error {
 	if w.IsInstalled() {
@@ -59,19 +106,13 @@ func (w *WireGuardProvider) Connect() error {
 	if err != nil {
 		return err
 	}
-
-	// Use config file if specified, otherwise use default interface
-	iface := w.interfaceName
-	if config.ConfigFile != "" {
-		// Extract interface name from config file path
-		// e.g., /etc/wireguard/wg0.conf -> wg0
-		parts := strings.Split(config.ConfigFile, "/")
-		filename := parts[len(parts)-1]
-		iface = strings.TrimSuffix(filename, ".conf")
-	}
+	iface := w.configInterface(config)
 
 	// Bring up the interface using wg-quick
-	cmd := exec.Command("wg-quick", "up", iface)
+	cmd, err := providers.Command(w.Name(), upData(config, iface))
+	if err != nil {
+		return fmt.Errorf("%w: %v", providers.ErrConnectionFailed, err)
+	}
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("%w: %s", providers.ErrConnectionFailed, string(output))
@@ -81,6 +122,51 @@ func (w *WireGuardProvider) Connect() error {
 	return nil
 }
 
+// configInterface returns the interface config brings up: named by the
+// config file if specified, otherwise the default interface
+func (w *WireGuardProvider) configInterface(config *providers.ProviderConfig) string {
+	if config.ConfigFile == "" {
+		return w.interfaceName
+	}
+	// e.g., /etc/wireguard/wg0.conf -> wg0
+	parts := strings.Split(config.ConfigFile, "/")
+	filename := parts[len(parts)-1]
+	return strings.TrimSuffix(filename, ".conf")
+}
+
+// upData describes the wg-quick command that brings up iface
+func upData(config *providers.ProviderConfig, iface string) providers.CommandData {
+	return providers.CommandData{
+		Binary:     "wg-quick",
+		Args:       []string{"up", iface},
+		Port:       config.LocalPort,
+		ConfigFile: config.ConfigFile,
+	}
+}
+
+// PlanConnect describes the wg-quick command Connect would run
+func (w *WireGuardProvider) PlanConnect() (*providers.Plan, error) {
+	config, err := w.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	iface := w.configInterface(config)
+	plan := providers.NewPlan(w, providers.ActionConnect)
+	if err := plan.Launch(upData(config, iface), false); err != nil {
+		return nil, err
+	}
+	plan.Note("wg-quick applies the addresses, routes and listen port in %s's configuration", iface)
+	return plan, nil
+}
+
+// PlanDisconnect describes the wg-quick command Disconnect would run
+func (w *WireGuardProvider) PlanDisconnect() (*providers.Plan, error) {
+	plan := providers.NewPlan(w, providers.ActionDisconnect)
+	plan.Run("wg-quick", "down", w.interfaceName)
+	return plan, nil
+}
+
 // Disconnect terminates the WireGuard connection
 func (w *WireGuardProvider) Disconnect() error {
 	if !w.IsInstalled() {
@@ -142,7 +228,7 @@ func (w *WireGuardProvider) GetConnectionInfo() (*providers.ConnectionInfo, erro
 			peers = append(peers, strings.TrimSpace(peer))
 		} else if strings.HasPrefix(line, "endpoint:") {
 			endpoint := strings.TrimPrefix(line, "endpoint:")
-			info.RemoteIP = strings.TrimSpace(endpoint)
+			info.RemoteIP, info.RemotePort = providers.SplitHostPort(strings.TrimSpace(endpoint))
 		}
 	}
 
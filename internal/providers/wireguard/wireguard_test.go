@@ -0,0 +1,29 @@
+package wireguard
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDNS(t *testing.T) {
+	conf := `[Interface]
+PrivateKey = aGVsbG8=
+Address = 10.8.0.2/24
+DNS = 10.8.0.1, fd00::1, corp.example.com
+
+[Peer]
+PublicKey = d29ybGQ=
+# DNS = 9.9.9.9 in a peer section is not a resolver setting
+DNS = 9.9.9.9
+`
+	got := parseDNS(strings.NewReader(conf))
+	want := []string{"10.8.0.1", "fd00::1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseDNS() = %v, want %v", got, want)
+	}
+
+	if got := parseDNS(strings.NewReader("[Interface]\nAddress = 10.8.0.2/24\n")); got != nil {
+		t.Errorf("parseDNS() without DNS = %v, want nil", got)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/jedarden/tunnel/internal/offline"
 	"github.com/jedarden/tunnel/internal/providers"
 )
 
@@ -22,6 +23,21 @@ func New() *ZeroTierProvider {
 	}
 }
 
+// RequiresInternet reports that ZeroTier needs internet access to connect
+func (z *ZeroTierProvider) RequiresInternet() bool {
+	return true
+}
+
+// ProcessPatterns matches the zerotier-one service for resource monitoring
+func (z *ZeroTierProvider) ProcessPatterns() []string {
+	return []string{"zerotier-one"}
+}
+
+// Endpoints returns ZeroTier Central, which authorizes network members
+func (z *ZeroTierProvider) Endpoints() []string {
+	return []string{"my.zerotier.com"}
+}
+
 // Install installs ZeroTier
 func (z *ZeroTierProvider) Install() error {
 	if z.IsInstalled() {
@@ -78,6 +94,10 @@ func (z *ZeroTierProvider) IsInstalled() bool {
 
 // Connect joins a ZeroTier network
 func (z *ZeroTierProvider) Connect() error {
+	if err := offline.Check(z.Name()); err != nil {
+		return err
+	}
+
 	if !z.IsInstalled() {
 		return providers.ErrNotInstalled
 	}
@@ -92,7 +112,10 @@ func (z *ZeroTierProvider) Connect() error {
 	}
 
 	// Join the network
-	cmd := exec.Command("zerotier-cli", "join", config.NetworkID)
+	cmd, err := providers.Command(z.Name(), joinData(config))
+	if err != nil {
+		return fmt.Errorf("%w: %v", providers.ErrConnectionFailed, err)
+	}
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("%w: %s", providers.ErrConnectionFailed, string(output))
@@ -104,6 +127,54 @@ func (z *ZeroTierProvider) Connect() error {
 	return nil
 }
 
+// joinData describes the zerotier-cli command that joins config's network
+func joinData(config *providers.ProviderConfig) providers.CommandData {
+	return providers.CommandData{
+		Binary:     "zerotier-cli",
+		Args:       []string{"join", config.NetworkID},
+		NetworkID:  config.NetworkID,
+		ConfigFile: config.ConfigFile,
+	}
+}
+
+// PlanConnect describes the zerotier-cli command Connect would run
+func (z *ZeroTierProvider) PlanConnect() (*providers.Plan, error) {
+	config, err := z.networkConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	plan := providers.NewPlan(z, providers.ActionConnect)
+	if err := plan.Launch(joinData(config), false); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// PlanDisconnect describes the zerotier-cli command Disconnect would run
+func (z *ZeroTierProvider) PlanDisconnect() (*providers.Plan, error) {
+	config, err := z.networkConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	plan := providers.NewPlan(z, providers.ActionDisconnect)
+	plan.Run("zerotier-cli", "leave", config.NetworkID)
+	return plan, nil
+}
+
+// networkConfig returns the configuration, which must name a network
+func (z *ZeroTierProvider) networkConfig() (*providers.ProviderConfig, error) {
+	config, err := z.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config.NetworkID == "" {
+		return nil, fmt.Errorf("network_id is required for ZeroTier")
+	}
+	return config, nil
+}
+
 // Disconnect leaves the ZeroTier network
 func (z *ZeroTierProvider) Disconnect() error {
 	if !z.IsInstalled() {
@@ -191,6 +262,10 @@ func (z *ZeroTierProvider) GetConnectionInfo() (*providers.ConnectionInfo, error
 
 // HealthCheck performs a health check
 func (z *ZeroTierProvider) HealthCheck() (*providers.HealthStatus, error) {
+	if offline.Enabled() {
+		return providers.OfflineHealthStatus(z.Name()), nil
+	}
+
 	if !z.IsInstalled() {
 		return &providers.HealthStatus{
 			Healthy:   false,
@@ -1,12 +1,17 @@
 package registry
 
 import (
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/jedarden/tunnel/internal/logging"
 	"github.com/jedarden/tunnel/internal/providers"
+	"github.com/jedarden/tunnel/internal/state"
+	"github.com/jedarden/tunnel/internal/suggest"
 )
 
 // instanceCounter is used to generate unique instance IDs
@@ -121,11 +126,21 @@ func (pi *ProviderInstance) GetConnectionInfo() (*providers.ConnectionInfo, erro
 	return pi.Provider.GetConnectionInfo()
 }
 
+// ErrInstanceLimit is returned when creating or connecting an instance
+// would exceed the configured limits
+var ErrInstanceLimit = errors.New("instance limit reached")
+
 // InstanceManager manages multiple instances of providers
 type InstanceManager struct {
 	mu        sync.RWMutex
 	instances map[string]*ProviderInstance // keyed by instance ID
 	registry  *Registry
+
+	maxConnected   int // connected instances allowed in total; 0 is unlimited
+	maxPerProvider int // instances allowed per provider; 0 is unlimited
+	connecting     int // connects in progress, held against maxConnected
+
+	state *state.Store // records the instances as they change; nil keeps no state
 }
 
 // NewInstanceManager creates a new instance manager
@@ -153,9 +168,22 @@ func (im *InstanceManager) CreateInstance(providerName, displayName string, conf
 	instance := NewProviderInstance(provider, displayName, config)
 
 	im.mu.Lock()
+	if im.maxPerProvider > 0 {
+		count := 0
+		for _, existing := range im.instances {
+			if existing.ProviderName == instance.ProviderName {
+				count++
+			}
+		}
+		if count >= im.maxPerProvider {
+			im.mu.Unlock()
+			return nil, fmt.Errorf("%w: %s already has %d of %d instances", ErrInstanceLimit, instance.ProviderName, count, im.maxPerProvider)
+		}
+	}
 	im.instances[instance.ID] = instance
 	im.mu.Unlock()
 
+	im.saveState()
 	return instance, nil
 }
 
@@ -166,7 +194,11 @@ func (im *InstanceManager) GetInstance(instanceID string) (*ProviderInstance, er
 
 	instance, exists := im.instances[instanceID]
 	if !exists {
-		return nil, fmt.Errorf("instance not found: %s", instanceID)
+		ids := make([]string, 0, len(im.instances))
+		for id := range im.instances {
+			ids = append(ids, id)
+		}
+		return nil, fmt.Errorf("instance not found: %s%s", instanceID, suggest.Hint(suggest.Closest(instanceID, ids)))
 	}
 
 	return instance, nil
@@ -215,6 +247,15 @@ func (im *InstanceManager) GetConnectedInstances() []*ProviderInstance {
 	return connected
 }
 
+// SetLimits caps the number of connected instances and the instances of
+// each provider; 0 leaves either unlimited. Existing instances are kept.
+func (im *InstanceManager) SetLimits(maxConnected, maxPerProvider int) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	im.maxConnected = maxConnected
+	im.maxPerProvider = maxPerProvider
+}
+
 // ConnectInstance connects a specific instance
 func (im *InstanceManager) ConnectInstance(instanceID string) error {
 	instance, err := im.GetInstance(instanceID)
@@ -222,6 +263,37 @@ func (im *InstanceManager) ConnectInstance(instanceID string) error {
 		return err
 	}
 
+	defer im.saveState()
+	return im.connect(instance)
+}
+
+// connect connects instance if the connection limit allows another one
+func (im *InstanceManager) connect(instance *ProviderInstance) error {
+	if instance.IsConnected() {
+		return instance.Connect()
+	}
+
+	im.mu.Lock()
+	if im.maxConnected > 0 {
+		connected := im.connecting
+		for _, other := range im.instances {
+			if other.IsConnected() {
+				connected++
+			}
+		}
+		if connected >= im.maxConnected {
+			im.mu.Unlock()
+			return fmt.Errorf("%w: %d of %d instances connected", ErrInstanceLimit, connected, im.maxConnected)
+		}
+	}
+	im.connecting++
+	im.mu.Unlock()
+
+	defer func() {
+		im.mu.Lock()
+		im.connecting--
+		im.mu.Unlock()
+	}()
 	return instance.Connect()
 }
 
@@ -232,6 +304,7 @@ func (im *InstanceManager) DisconnectInstance(instanceID string) error {
 		return err
 	}
 
+	defer im.saveState()
 	return instance.Disconnect()
 }
 
@@ -255,6 +328,7 @@ func (im *InstanceManager) DeleteInstance(instanceID string) error {
 	im.mu.Lock()
 	delete(im.instances, instanceID)
 	im.mu.Unlock()
+	im.saveState()
 
 	return nil
 }
@@ -276,7 +350,7 @@ func (im *InstanceManager) ConnectAll() map[string]error {
 		wg.Add(1)
 		go func(inst *ProviderInstance) {
 			defer wg.Done()
-			if err := inst.Connect(); err != nil {
+			if err := im.connect(inst); err != nil {
 				errorsMu.Lock()
 				errors[inst.ID] = err
 				errorsMu.Unlock()
@@ -285,6 +359,7 @@ func (im *InstanceManager) ConnectAll() map[string]error {
 	}
 
 	wg.Wait()
+	im.saveState()
 	return errors
 }
 
@@ -316,6 +391,7 @@ func (im *InstanceManager) DisconnectAll() map[string]error {
 	}
 
 	wg.Wait()
+	im.saveState()
 	return errors
 }
 
@@ -341,6 +417,130 @@ func (im *InstanceManager) ConnectMultiple(instanceIDs []string) map[string]erro
 	return errors
 }
 
+// SetStateStore records the instances in store whenever they are created,
+// connected, disconnected or deleted, so Restore can recreate them after a
+// restart. A process shutting down should detach the store with nil before
+// disconnecting, so its instances come back connected.
+func (im *InstanceManager) SetStateStore(store *state.Store) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	im.state = store
+}
+
+// Saved returns the instances as they are recorded in the state file
+func (im *InstanceManager) Saved() []state.Instance {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	saved := make([]state.Instance, 0, len(im.instances))
+	for _, instance := range im.instances {
+		saved = append(saved, state.Instance{
+			ID:          instance.ID,
+			Provider:    instance.ProviderName,
+			DisplayName: instance.DisplayName,
+			Config:      instance.Config,
+			Connected:   instance.IsConnected(),
+		})
+	}
+	sort.Slice(saved, func(i, j int) bool { return saved[i].ID < saved[j].ID })
+	return saved
+}
+
+// Restore recreates saved instances under their old IDs and connects those
+// that were connected. Instances that already exist are left alone. The
+// errors are keyed by instance ID.
+func (im *InstanceManager) Restore(saved []state.Instance) map[string]error {
+	errors := make(map[string]error)
+	var connect []*ProviderInstance
+	for _, s := range saved {
+		instance, err := im.recreate(s)
+		if err != nil {
+			errors[s.ID] = err
+			continue
+		}
+		if instance != nil && s.Connected {
+			connect = append(connect, instance)
+		}
+	}
+
+	var wg sync.WaitGroup
+	var errorsMu sync.Mutex
+	for _, instance := range connect {
+		wg.Add(1)
+		go func(inst *ProviderInstance) {
+			defer wg.Done()
+			if err := im.connect(inst); err != nil {
+				errorsMu.Lock()
+				errors[inst.ID] = err
+				errorsMu.Unlock()
+			}
+		}(instance)
+	}
+	wg.Wait()
+
+	im.saveState()
+	return errors
+}
+
+// Load recreates saved instances under their old IDs without connecting
+// any, for a process managing instances another one may have connected.
+// Those saved as connected whose provider is still up count as connected.
+// Instances that already exist are left alone; the errors are keyed by
+// instance ID.
+func (im *InstanceManager) Load(saved []state.Instance) map[string]error {
+	errors := make(map[string]error)
+	for _, s := range saved {
+		instance, err := im.recreate(s)
+		if err != nil {
+			errors[s.ID] = err
+			continue
+		}
+		if instance != nil && s.Connected && instance.Provider.IsConnected() {
+			instance.mu.Lock()
+			instance.Status = "connected"
+			instance.mu.Unlock()
+		}
+	}
+	return errors
+}
+
+// recreate adds the saved instance s, disconnected. It returns nil when an
+// instance with its ID exists already.
+func (im *InstanceManager) recreate(s state.Instance) (*ProviderInstance, error) {
+	provider, err := im.registry.GetProvider(s.Provider)
+	if err != nil {
+		return nil, fmt.Errorf("provider not found: %w", err)
+	}
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	if _, exists := im.instances[s.ID]; exists {
+		return nil, nil
+	}
+	instance := NewProviderInstance(provider, s.DisplayName, s.Config)
+	instance.ID = s.ID
+	if s.DisplayName == "" {
+		instance.DisplayName = s.ID
+	}
+	im.instances[s.ID] = instance
+	return instance, nil
+}
+
+// saveState records the instances in the state store, if there is one
+func (im *InstanceManager) saveState() {
+	im.mu.RLock()
+	store := im.state
+	im.mu.RUnlock()
+	if store == nil {
+		return
+	}
+
+	saved := im.Saved()
+	if err := store.Update(func(s *state.State) { s.Instances = saved }); err != nil {
+		logging.For("instances").Error("failed to save state", "error", err)
+	}
+}
+
 // InstanceCount returns the total number of instances
 func (im *InstanceManager) InstanceCount() int {
 	im.mu.RLock()
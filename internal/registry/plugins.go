@@ -0,0 +1,116 @@
+package registry
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jedarden/tunnel/internal/providers"
+	"github.com/jedarden/tunnel/internal/providers/plugin"
+)
+
+// FindPlugins returns the plugin executables in the directories of
+// pathList, a PATH-style list, by provider name. As with a shell, the
+// first directory holding a name wins. Empty and relative entries, which
+// a shell resolves against the working directory, are skipped so a plugin
+// dropped into whatever directory tunnel runs from is never started.
+func FindPlugins(pathList string) map[string]string {
+	found := make(map[string]string)
+	for _, dir := range filepath.SplitList(pathList) {
+		if !filepath.IsAbs(dir) {
+			continue
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			name, ok := pluginName(entry.Name())
+			if !ok || found[name] != "" {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			if isExecutable(path) {
+				found[name] = path
+			}
+		}
+	}
+	return found
+}
+
+// pluginName returns the provider name of a plugin executable's file name
+func pluginName(file string) (string, bool) {
+	name, ok := strings.CutPrefix(file, plugin.Prefix)
+	if !ok {
+		return "", false
+	}
+	if runtime.GOOS == "windows" {
+		name = strings.TrimSuffix(strings.ToLower(name), ".exe")
+	}
+	return name, name != ""
+}
+
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	return runtime.GOOS == "windows" || info.Mode().Perm()&0111 != 0
+}
+
+// LoadPlugins registers a provider for every plugin on PATH and returns
+// the plugins that couldn't be loaded. A plugin can't take the name of a
+// built-in provider, but replaces a plugin loaded earlier. Plugins are
+// asked to describe themselves in parallel, so one slow plugin doesn't
+// hold up the rest.
+func (r *Registry) LoadPlugins() []error {
+	var errs []error
+	found := FindPlugins(os.Getenv("PATH"))
+	names := make([]string, 0, len(found))
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	loadable := names[:0]
+	for _, name := range names {
+		path := found[name]
+		if _, builtin := providers.LookupIdentity(name); builtin {
+			errs = append(errs, fmt.Errorf("plugin %s (%s): the name is taken by a built-in provider", name, path))
+			continue
+		}
+		r.mu.RLock()
+		existing, registered := r.providers[name]
+		r.mu.RUnlock()
+		if _, isPlugin := existing.(*plugin.PluginProvider); registered && !isPlugin {
+			errs = append(errs, fmt.Errorf("plugin %s (%s): the name is taken by another provider", name, path))
+			continue
+		}
+		loadable = append(loadable, name)
+	}
+
+	loaded := make([]*plugin.PluginProvider, len(loadable))
+	loadErrs := make([]error, len(loadable))
+	var wg sync.WaitGroup
+	for i, name := range loadable {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			loaded[i], loadErrs[i] = plugin.Load(name, found[name])
+		}()
+	}
+	wg.Wait()
+
+	for i := range loadable {
+		if loadErrs[i] != nil {
+			errs = append(errs, loadErrs[i])
+			continue
+		}
+		r.Register(loaded[i])
+	}
+	return errs
+}
@@ -0,0 +1,103 @@
+package registry_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/providers"
+	"github.com/jedarden/tunnel/internal/registry"
+)
+
+const describePlugin = "#!/bin/sh\nread -r request\necho '{\"category\":\"tunnel\"}'\n"
+
+func installPlugin(t *testing.T, dir, name string, mode os.FileMode) string {
+	t.Helper()
+	path := filepath.Join(dir, "tunnel-provider-"+name)
+	if err := os.WriteFile(path, []byte(describePlugin), mode); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFindPlugins(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	want := installPlugin(t, first, "demo", 0755)
+	installPlugin(t, second, "demo", 0755)
+	installPlugin(t, second, "notexec", 0644)
+	other := installPlugin(t, second, "other", 0755)
+
+	found := registry.FindPlugins(strings.Join([]string{first, second}, string(os.PathListSeparator)))
+	if found["demo"] != want {
+		t.Errorf("demo = %q, want the first on the path, %q", found["demo"], want)
+	}
+	if found["other"] != other {
+		t.Errorf("other = %q, want %q", found["other"], other)
+	}
+	if _, ok := found["notexec"]; ok {
+		t.Error("found a plugin that isn't executable")
+	}
+}
+
+func TestFindPluginsSkipsRelativeDirs(t *testing.T) {
+	dir := t.TempDir()
+	installPlugin(t, dir, "planted", 0755)
+	t.Chdir(dir)
+
+	for _, pathList := range []string{"", ".", string(os.PathListSeparator) + "/nonexistent", "bin"} {
+		if found := registry.FindPlugins(pathList); len(found) != 0 {
+			t.Errorf("FindPlugins(%q) = %v, want nothing from the working directory", pathList, found)
+		}
+	}
+}
+
+func TestLoadPluginsInParallel(t *testing.T) {
+	dir := t.TempDir()
+	slow := "#!/bin/sh\nread -r request\n/bin/sleep 1\necho '{\"category\":\"tunnel\"}'\n"
+	for _, name := range []string{"one", "two", "three"} {
+		if err := os.WriteFile(filepath.Join(dir, "tunnel-provider-"+name), []byte(slow), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir)
+
+	r := registry.NewRegistry()
+	start := time.Now()
+	if errs := r.LoadPlugins(); len(errs) != 0 {
+		t.Fatalf("LoadPlugins() errors = %v", errs)
+	}
+	if elapsed := time.Since(start); elapsed > 2500*time.Millisecond {
+		t.Errorf("loading three 1s plugins took %v; they were described one at a time", elapsed)
+	}
+	for _, name := range []string{"one", "two", "three"} {
+		if _, err := r.GetProvider(name); err != nil {
+			t.Errorf("plugin %s not registered: %v", name, err)
+		}
+	}
+}
+
+func TestLoadPlugins(t *testing.T) {
+	dir := t.TempDir()
+	installPlugin(t, dir, "demo", 0755)
+	installPlugin(t, dir, "bore", 0755)
+	t.Setenv("PATH", dir)
+
+	r := registry.NewRegistry()
+	errs := r.LoadPlugins()
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "built-in") {
+		t.Errorf("LoadPlugins() errors = %v, want one for bore", errs)
+	}
+
+	provider, err := r.GetProvider("demo")
+	if err != nil {
+		t.Fatalf("plugin not registered: %v", err)
+	}
+	if provider.Category() != providers.CategoryTunnel {
+		t.Errorf("category = %s", provider.Category())
+	}
+	if bore, _ := r.GetProvider("bore"); bore == nil || bore == provider {
+		t.Error("the bore plugin replaced the built-in provider")
+	}
+}
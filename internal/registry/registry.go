@@ -9,24 +9,47 @@ import (
 	"github.com/jedarden/tunnel/internal/providers/bore"
 	"github.com/jedarden/tunnel/internal/providers/cloudflare"
 	"github.com/jedarden/tunnel/internal/providers/ngrok"
+	"github.com/jedarden/tunnel/internal/providers/plugin"
 	"github.com/jedarden/tunnel/internal/providers/reversessh"
 	"github.com/jedarden/tunnel/internal/providers/sshforward"
 	"github.com/jedarden/tunnel/internal/providers/tailscale"
 	"github.com/jedarden/tunnel/internal/providers/vscodetunnel"
 	"github.com/jedarden/tunnel/internal/providers/wireguard"
 	"github.com/jedarden/tunnel/internal/providers/zerotier"
+	"github.com/jedarden/tunnel/internal/suggest"
 )
 
+// ChangeType identifies what happened to a provider in the registry
+type ChangeType string
+
+const (
+	ChangeRegistered   ChangeType = "registered"
+	ChangeUnregistered ChangeType = "unregistered"
+)
+
+// Change is delivered to watchers when a provider is added or removed
+type Change struct {
+	Type     ChangeType         `json:"type"`
+	Name     string             `json:"name"`
+	Category providers.Category `json:"category"`
+}
+
+// changeBufferSize is how many changes a slow watcher may fall behind by
+// before further changes are dropped for it
+const changeBufferSize = 16
+
 // Registry manages all available providers
 type Registry struct {
 	mu        sync.RWMutex
 	providers map[string]providers.Provider
+	watchers  map[chan Change]struct{}
 }
 
 // NewRegistry creates a new provider registry
 func NewRegistry() *Registry {
 	r := &Registry{
 		providers: make(map[string]providers.Provider),
+		watchers:  make(map[chan Change]struct{}),
 	}
 	r.registerDefaultProviders()
 	return r
@@ -51,28 +74,86 @@ func (r *Registry) registerDefaultProviders() {
 	r.Register(bastion.New())
 }
 
-// Register adds a provider to the registry
+// Register adds a provider to the registry, replacing any provider with the
+// same name. It is safe to call while the registry is in use.
 func (r *Registry) Register(provider providers.Provider) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.providers[provider.Name()] = provider
+	r.notify(Change{Type: ChangeRegistered, Name: provider.Name(), Category: provider.Category()})
 }
 
-// Unregister removes a provider from the registry
-func (r *Registry) Unregister(name string) {
+// Unregister removes a provider from the registry. A connected provider must
+// be disconnected first so its tunnel is not orphaned.
+func (r *Registry) Unregister(name string) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+
+	provider, exists := r.providers[name]
+	if !exists {
+		return fmt.Errorf("%w: %s", providers.ErrProviderNotFound, name)
+	}
+	if provider.IsConnected() {
+		return fmt.Errorf("%w: disconnect %s before unregistering it", providers.ErrAlreadyConnected, name)
+	}
+
 	delete(r.providers, name)
+	r.notify(Change{Type: ChangeUnregistered, Name: name, Category: provider.Category()})
+	return nil
+}
+
+// Watch returns a channel of registry changes and a function that stops
+// watching and closes the channel. Changes are dropped for a watcher that
+// falls too far behind, so watchers should re-list providers on each change
+// rather than track individual events.
+func (r *Registry) Watch() (<-chan Change, func()) {
+	ch := make(chan Change, changeBufferSize)
+
+	r.mu.Lock()
+	r.watchers[ch] = struct{}{}
+	r.mu.Unlock()
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			r.mu.Lock()
+			delete(r.watchers, ch)
+			r.mu.Unlock()
+			close(ch)
+		})
+	}
+	return ch, stop
 }
 
-// GetProvider retrieves a provider by name
+// notify delivers a change to every watcher without blocking. Callers must hold r.mu.
+func (r *Registry) notify(change Change) {
+	for ch := range r.watchers {
+		select {
+		case ch <- change:
+		default:
+		}
+	}
+}
+
+// GetProvider retrieves a provider by name or alias. Deprecated names are
+// accepted with a warning, and unknown names suggest the closest matches.
 func (r *Registry) GetProvider(name string) (providers.Provider, error) {
+	canonical, deprecated := providers.CanonicalName(name)
+	if deprecated {
+		providers.WarnDeprecated("provider name", name, canonical)
+	}
+	name = canonical
+
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	provider, exists := r.providers[name]
 	if !exists {
-		return nil, fmt.Errorf("%w: %s", providers.ErrProviderNotFound, name)
+		names := make([]string, 0, len(r.providers))
+		for registered := range r.providers {
+			names = append(names, registered)
+		}
+		return nil, fmt.Errorf("%w: %s%s", providers.ErrProviderNotFound, name, suggest.Hint(suggest.Closest(name, names)))
 	}
 
 	return provider, nil
@@ -138,10 +219,12 @@ func (r *Registry) GetConnectedProviders() []providers.Provider {
 
 // ProviderInfo contains summary information about a provider
 type ProviderInfo struct {
-	Name      string             `json:"name"`
-	Category  providers.Category `json:"category"`
-	Installed bool               `json:"installed"`
-	Connected bool               `json:"connected"`
+	Name        string             `json:"name"`
+	DisplayName string             `json:"display_name"`
+	Aliases     []string           `json:"aliases,omitempty"`
+	Category    providers.Category `json:"category"`
+	Installed   bool               `json:"installed"`
+	Connected   bool               `json:"connected"`
 }
 
 // GetProviderInfo returns summary information for all providers
@@ -151,11 +234,18 @@ func (r *Registry) GetProviderInfo() []ProviderInfo {
 
 	info := make([]ProviderInfo, 0, len(r.providers))
 	for _, provider := range r.providers {
+		id, _ := providers.LookupIdentity(provider.Name())
+		displayName := providers.DisplayName(provider.Name())
+		if p, ok := provider.(*plugin.PluginProvider); ok {
+			displayName = p.DisplayName()
+		}
 		info = append(info, ProviderInfo{
-			Name:      provider.Name(),
-			Category:  provider.Category(),
-			Installed: provider.IsInstalled(),
-			Connected: provider.IsConnected(),
+			Name:        provider.Name(),
+			DisplayName: displayName,
+			Aliases:     id.Aliases,
+			Category:    provider.Category(),
+			Installed:   provider.IsInstalled(),
+			Connected:   provider.IsConnected(),
 		})
 	}
 
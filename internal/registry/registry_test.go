@@ -1,10 +1,16 @@
 package registry_test
 
 import (
+	"errors"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/jedarden/tunnel/internal/providers"
+	"github.com/jedarden/tunnel/internal/providers/bore"
 	"github.com/jedarden/tunnel/internal/registry"
+	"github.com/jedarden/tunnel/internal/state"
 )
 
 func TestNewRegistry(t *testing.T) {
@@ -46,11 +52,29 @@ func TestGetProvider(t *testing.T) {
 		t.Errorf("expected provider name 'tailscale', got '%s'", provider.Name())
 	}
 
+	// Deprecated names resolve to the current provider
+	provider, err = r.GetProvider("cloudflared")
+	if err != nil || provider.Name() != "cloudflare" {
+		t.Errorf("GetProvider(cloudflared) = %v, %v; want the cloudflare provider", provider, err)
+	}
+
+	// Aliases resolve silently
+	provider, err = r.GetProvider("ts")
+	if err != nil || provider.Name() != "tailscale" {
+		t.Errorf("GetProvider(ts) = %v, %v; want the tailscale provider", provider, err)
+	}
+
 	// Test getting a non-existent provider
 	_, err = r.GetProvider("nonexistent")
 	if err == nil {
 		t.Error("expected error when getting non-existent provider")
 	}
+
+	// Typos suggest the intended provider
+	_, err = r.GetProvider("cloudfare")
+	if !errors.Is(err, providers.ErrProviderNotFound) || !strings.Contains(err.Error(), "did you mean cloudflare?") {
+		t.Errorf("GetProvider(cloudfare) error = %v, want a suggestion", err)
+	}
 }
 
 func TestListByCategory(t *testing.T) {
@@ -129,3 +153,112 @@ func TestGlobalFunctions(t *testing.T) {
 		t.Errorf("expected provider name 'tailscale', got '%s'", provider.Name())
 	}
 }
+
+func TestWatchRegistrationChanges(t *testing.T) {
+	r := registry.NewRegistry()
+
+	changes, stop := r.Watch()
+	defer stop()
+
+	if err := r.Unregister("bore"); err != nil {
+		t.Fatalf("Unregister() error = %v", err)
+	}
+	if _, err := r.GetProvider("bore"); err == nil {
+		t.Error("expected bore to be gone after Unregister()")
+	}
+
+	r.Register(bore.New())
+	if _, err := r.GetProvider("bore"); err != nil {
+		t.Errorf("expected bore after Register(): %v", err)
+	}
+
+	for _, want := range []registry.ChangeType{registry.ChangeUnregistered, registry.ChangeRegistered} {
+		select {
+		case change := <-changes:
+			if change.Type != want || change.Name != "bore" || change.Category != providers.CategoryTunnel {
+				t.Errorf("change = %+v, want %s bore", change, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %s change", want)
+		}
+	}
+
+	if err := r.Unregister("nonexistent"); !errors.Is(err, providers.ErrProviderNotFound) {
+		t.Errorf("Unregister(nonexistent) error = %v, want ErrProviderNotFound", err)
+	}
+
+	stop()
+	if _, ok := <-changes; ok {
+		t.Error("expected channel to be closed after stop")
+	}
+	stop() // stopping twice is harmless
+}
+
+func TestRestoreInstances(t *testing.T) {
+	store := state.NewStore(filepath.Join(t.TempDir(), "state.json"))
+	im := registry.NewInstanceManager(registry.NewRegistry())
+	im.SetStateStore(store)
+
+	errs := im.Restore([]state.Instance{
+		{ID: "bore-staging", Provider: "bore", DisplayName: "staging", Config: &providers.ProviderConfig{LocalPort: 3000}},
+		{ID: "gone-1", Provider: "no-such-provider"},
+	})
+	if len(errs) != 1 || errs["gone-1"] == nil {
+		t.Errorf("errors = %v, want one for gone-1", errs)
+	}
+
+	instance, err := im.GetInstance("bore-staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if instance.DisplayName != "staging" || instance.Config.LocalPort != 3000 || instance.GetStatus() != "disconnected" {
+		t.Errorf("restored %+v", instance)
+	}
+
+	saved, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Instances) != 1 || saved.Instances[0].ID != "bore-staging" || saved.Instances[0].Connected {
+		t.Errorf("saved instances %+v", saved.Instances)
+	}
+}
+
+func TestLoadInstances(t *testing.T) {
+	store := state.NewStore(filepath.Join(t.TempDir(), "state.json"))
+	im := registry.NewInstanceManager(registry.NewRegistry())
+	im.SetStateStore(store)
+
+	saved := []state.Instance{
+		{ID: "bore-staging", Provider: "bore", DisplayName: "staging", Connected: true},
+		{ID: "bore-2", Provider: "bore"},
+		{ID: "gone-1", Provider: "no-such-provider"},
+	}
+	errs := im.Load(saved)
+	if len(errs) != 1 || errs["gone-1"] == nil {
+		t.Errorf("errors = %v, want one for gone-1", errs)
+	}
+	if im.InstanceCount() != 2 {
+		t.Fatalf("loaded %d instances, want 2", im.InstanceCount())
+	}
+
+	// Nothing is connected, and bore isn't running here
+	instance, err := im.GetInstance("bore-staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if instance.GetStatus() != "disconnected" {
+		t.Errorf("status = %q, want disconnected", instance.GetStatus())
+	}
+	if other, _ := im.GetInstance("bore-2"); other == nil || other.DisplayName != "bore-2" {
+		t.Errorf("instance without a display name = %+v, want its ID as the name", other)
+	}
+
+	// Loading records nothing, and leaves existing instances alone
+	if st, err := store.Load(); err != nil || len(st.Instances) != 0 {
+		t.Errorf("saved instances %+v, %v; want none", st.Instances, err)
+	}
+	if errs := im.Load(saved[:1]); len(errs) != 0 || im.InstanceCount() != 2 {
+		t.Errorf("reloading: errors %v, %d instances", errs, im.InstanceCount())
+	}
+}
@@ -0,0 +1,168 @@
+package report
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/units"
+)
+
+// Format is the document format a report is rendered in
+type Format string
+
+const (
+	Markdown Format = "markdown"
+	HTML     Format = "html"
+)
+
+// ParseFormat parses a report format name
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "markdown", "md", "":
+		return Markdown, nil
+	case "html":
+		return HTML, nil
+	default:
+		return "", fmt.Errorf("unknown report format %q (use markdown or html)", s)
+	}
+}
+
+// Render writes the report in format
+func (r *Report) Render(w io.Writer, format Format) error {
+	var err error
+	switch format {
+	case Markdown:
+		err = markdownTemplate.Execute(w, r)
+	case HTML:
+		err = htmlTemplate.Execute(w, r)
+	default:
+		return fmt.Errorf("unknown report format %q", format)
+	}
+	if err != nil {
+		return fmt.Errorf("render report: %w", err)
+	}
+	return nil
+}
+
+var funcs = map[string]interface{}{
+	"date":          func(t time.Time) string { return t.Local().Format("2006-01-02 15:04") },
+	"duration":      units.Duration,
+	"durationExact": units.DurationExact,
+	"bytes":         units.Bytes,
+	"bytesExact":    units.BytesExact,
+	"bytesDetail":   func(n int64) string { return units.Detail(units.Bytes(n), units.BytesExact(n)) },
+	"percent":       func(p float64) string { return units.Decimal(p, 2) + "%" },
+	"resolved": func(a AlertSummary) string {
+		if a.ResolvedAt.IsZero() {
+			return "still active"
+		}
+		return a.ResolvedAt.Local().Format("2006-01-02 15:04")
+	},
+}
+
+var markdownTemplate = texttemplate.Must(texttemplate.New("markdown").Funcs(funcs).Parse(`# Tunnel report
+
+{{date .Since}} to {{date .Until}} (generated {{date .GeneratedAt}})
+
+## Connections
+{{if .Connections}}
+| Provider | Availability | Uptime | Sessions | Reconnects | Errors | Sent | Received |
+|---|---|---|---|---|---|---|---|
+{{- range .Connections}}
+| {{.Method}} | {{percent .Availability}} | {{duration .Uptime}} | {{.Sessions}} | {{.Reconnects}} | {{.Errors}} | {{bytes .BytesSent}} | {{bytes .BytesReceived}} |
+{{- end}}
+{{else}}
+No connections were recorded.
+{{end}}
+## Failovers
+{{if .Failovers}}
+| Time | From | To |
+|---|---|---|
+{{- range .Failovers}}
+| {{date .At}} | {{.From}} | {{.To}} |
+{{- end}}
+{{else}}
+No failovers.
+{{end}}
+## Bandwidth
+
+- Sent: {{bytesDetail .Bandwidth.BytesSent}}
+- Received: {{bytesDetail .Bandwidth.BytesReceived}}
+
+## Key changes
+{{if .KeyChanges}}
+| Time | Action | User | Detail | Result |
+|---|---|---|---|---|
+{{- range .KeyChanges}}
+| {{date .At}} | {{.Action}} | {{.User}} | {{.Detail}} | {{if .Success}}ok{{else}}failed{{end}} |
+{{- end}}
+{{else}}
+No key changes.
+{{end}}
+## Alerts
+{{if .Alerts}}
+| Fired | Resolved | Rule | Subject | Message |
+|---|---|---|---|---|
+{{- range .Alerts}}
+| {{date .FiredAt}} | {{resolved .}} | {{.Rule}} | {{.Subject}} | {{.Message}} |
+{{- end}}
+{{else}}
+No alerts fired.
+{{end}}`))
+
+var htmlTemplate = htmltemplate.Must(htmltemplate.New("html").Funcs(funcs).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Tunnel report {{date .Since}} to {{date .Until}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2rem auto; max-width: 60rem; color: #1f2937; }
+h1 { color: #7D56F4; }
+h2 { border-bottom: 1px solid #e5e7eb; padding-bottom: .25rem; margin-top: 2rem; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: .4rem .6rem; border-bottom: 1px solid #e5e7eb; }
+th { background: #f9fafb; }
+.muted { color: #6b7280; }
+.failed { color: #ef4444; }
+</style>
+</head>
+<body>
+<h1>Tunnel report</h1>
+<p class="muted">{{date .Since}} to {{date .Until}} (generated {{date .GeneratedAt}})</p>
+
+<h2>Connections</h2>
+{{if .Connections}}<table>
+<tr><th>Provider</th><th>Availability</th><th>Uptime</th><th>Sessions</th><th>Reconnects</th><th>Errors</th><th>Sent</th><th>Received</th></tr>
+{{range .Connections}}<tr><td>{{.Method}}</td><td>{{percent .Availability}}</td><td title="{{durationExact .Uptime}}">{{duration .Uptime}}</td><td>{{.Sessions}}</td><td>{{.Reconnects}}</td><td>{{.Errors}}</td><td title="{{bytesExact .BytesSent}}">{{bytes .BytesSent}}</td><td title="{{bytesExact .BytesReceived}}">{{bytes .BytesReceived}}</td></tr>
+{{end}}</table>{{else}}<p class="muted">No connections were recorded.</p>{{end}}
+
+<h2>Failovers</h2>
+{{if .Failovers}}<table>
+<tr><th>Time</th><th>From</th><th>To</th></tr>
+{{range .Failovers}}<tr><td>{{date .At}}</td><td>{{.From}}</td><td>{{.To}}</td></tr>
+{{end}}</table>{{else}}<p class="muted">No failovers.</p>{{end}}
+
+<h2>Bandwidth</h2>
+<table>
+<tr><th>Sent</th><td title="{{bytesExact .Bandwidth.BytesSent}}">{{bytes .Bandwidth.BytesSent}}</td></tr>
+<tr><th>Received</th><td title="{{bytesExact .Bandwidth.BytesReceived}}">{{bytes .Bandwidth.BytesReceived}}</td></tr>
+</table>
+
+<h2>Key changes</h2>
+{{if .KeyChanges}}<table>
+<tr><th>Time</th><th>Action</th><th>User</th><th>Detail</th><th>Result</th></tr>
+{{range .KeyChanges}}<tr><td>{{date .At}}</td><td>{{.Action}}</td><td>{{.User}}</td><td>{{.Detail}}</td><td>{{if .Success}}ok{{else}}<span class="failed">failed</span>{{end}}</td></tr>
+{{end}}</table>{{else}}<p class="muted">No key changes.</p>{{end}}
+
+<h2>Alerts</h2>
+{{if .Alerts}}<table>
+<tr><th>Fired</th><th>Resolved</th><th>Rule</th><th>Subject</th><th>Message</th></tr>
+{{range .Alerts}}<tr><td>{{date .FiredAt}}</td><td>{{resolved .}}</td><td>{{.Rule}}</td><td>{{.Subject}}</td><td>{{.Message}}</td></tr>
+{{end}}</table>{{else}}<p class="muted">No alerts fired.</p>{{end}}
+</body>
+</html>
+`))
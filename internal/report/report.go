@@ -0,0 +1,286 @@
+// Package report summarizes a period of tunnel activity - connection uptime,
+// failovers, bandwidth, key changes and alerts - for operational reviews.
+// Reports are built from the audit log, plus the live connection statuses of
+// a running instance for sessions that have not ended yet.
+package report
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/core"
+)
+
+// Report is the activity of one period
+type Report struct {
+	Since       time.Time           `json:"since"`
+	Until       time.Time           `json:"until"`
+	GeneratedAt time.Time           `json:"generated_at"`
+	Connections []ConnectionSummary `json:"connections"`
+	Failovers   []Failover          `json:"failovers"`
+	Bandwidth   Bandwidth           `json:"bandwidth"`
+	KeyChanges  []KeyChange         `json:"key_changes"`
+	Alerts      []AlertSummary      `json:"alerts"`
+}
+
+// ConnectionSummary is the activity of one provider over the period
+type ConnectionSummary struct {
+	Method        string        `json:"method"`
+	Sessions      int           `json:"sessions"`
+	Reconnects    int           `json:"reconnects"`
+	Errors        int           `json:"errors"`
+	Uptime        time.Duration `json:"uptime"`
+	Availability  float64       `json:"availability"` // percent of the period
+	BytesSent     int64         `json:"bytes_sent"`
+	BytesReceived int64         `json:"bytes_received"`
+}
+
+// Failover is a switch of the primary connection
+type Failover struct {
+	At   time.Time `json:"at"`
+	From string    `json:"from"`
+	To   string    `json:"to"`
+}
+
+// Bandwidth totals traffic across all connections
+type Bandwidth struct {
+	BytesSent     int64 `json:"bytes_sent"`
+	BytesReceived int64 `json:"bytes_received"`
+}
+
+// KeyChange is an SSH key being added, removed, rotated or imported
+type KeyChange struct {
+	At      time.Time `json:"at"`
+	Action  string    `json:"action"`
+	User    string    `json:"user"`
+	Detail  string    `json:"detail,omitempty"`
+	Success bool      `json:"success"`
+}
+
+// AlertSummary is an alert that was active during the period
+type AlertSummary struct {
+	Rule       string    `json:"rule"`
+	Subject    string    `json:"subject"`
+	Message    string    `json:"message"`
+	FiredAt    time.Time `json:"fired_at"`
+	ResolvedAt time.Time `json:"resolved_at,omitempty"`
+}
+
+// keyEventTypes are the audit event types recorded for key changes
+var keyEventTypes = map[string]bool{
+	"key_added":         true,
+	"key_removed":       true,
+	"key_rotated":       true,
+	"keys_imported":     true,
+	"keys_bulk_revoked": true,
+	"keys_bulk_rotated": true,
+	"emergency_revoke":  true,
+	"fleet_revoke":      true,
+
+	"identity_key_generated": true,
+	"identity_key_adopted":   true,
+	"identity_key_rotated":   true,
+	"identity_key_revoked":   true,
+}
+
+// session is a connection that has connected but not yet disconnected
+type session struct {
+	method string
+	start  time.Time
+}
+
+// Build summarizes the audit events between since and until. events should
+// include what happened before since, so sessions that were already up when
+// the period began are counted. live holds a running instance's connection
+// statuses; sessions still open in the log count up to until only if live
+// shows them connected, since otherwise their end is unknown.
+func Build(events []core.AuditEvent, live []core.ConnectionStatus, since, until time.Time) *Report {
+	r := &Report{
+		Since:       since,
+		Until:       until,
+		GeneratedAt: time.Now(),
+		Connections: []ConnectionSummary{},
+		Failovers:   []Failover{},
+		KeyChanges:  []KeyChange{},
+		Alerts:      []AlertSummary{},
+	}
+
+	summaries := make(map[string]*ConnectionSummary)
+	summary := func(method string) *ConnectionSummary {
+		if method == "" {
+			method = "unknown"
+		}
+		s, ok := summaries[method]
+		if !ok {
+			s = &ConnectionSummary{Method: method}
+			summaries[method] = s
+		}
+		return s
+	}
+
+	open := make(map[string]session)
+	methods := make(map[string]string) // connection ID to method
+	firing := make(map[string]int)     // alert key to index in r.Alerts
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Timestamp.Before(events[j].Timestamp) })
+	for _, event := range events {
+		if event.Timestamp.After(until) {
+			break
+		}
+		inPeriod := !event.Timestamp.Before(since)
+		connID := detail(event, "connection_id")
+		if event.Method != "" && connID != "" {
+			methods[connID] = event.Method
+		}
+
+		switch {
+		case event.EventType == "connection_connected":
+			open[connID] = session{method: event.Method, start: event.Timestamp}
+			if inPeriod {
+				summary(event.Method).Sessions++
+			}
+
+		case event.EventType == "connection_disconnected":
+			s, ok := open[connID]
+			if !ok {
+				continue
+			}
+			delete(open, connID)
+			sum := summary(s.method)
+			sum.Uptime += overlap(s.start, event.Timestamp, since, until)
+			if inPeriod {
+				sum.BytesSent += number(event.Details["bytes_sent"])
+				sum.BytesReceived += number(event.Details["bytes_received"])
+			}
+
+		case !inPeriod:
+			continue
+
+		case event.EventType == "connection_reconnected":
+			summary(methods[connID]).Reconnects++
+
+		case event.EventType == "connection_error":
+			summary(methods[connID]).Errors++
+
+		case event.EventType == "connection_failover":
+			r.Failovers = append(r.Failovers, Failover{
+				At:   event.Timestamp,
+				From: detail(event, "old_primary"),
+				To:   detail(event, "new_primary"),
+			})
+
+		case keyEventTypes[event.EventType]:
+			r.KeyChanges = append(r.KeyChanges, KeyChange{
+				At:      event.Timestamp,
+				Action:  strings.ReplaceAll(event.EventType, "_", " "),
+				User:    event.User,
+				Detail:  keyDetail(event),
+				Success: event.Success,
+			})
+
+		case event.EventType == "alert_fired":
+			firing[alertKey(event)] = len(r.Alerts)
+			r.Alerts = append(r.Alerts, AlertSummary{
+				Rule:    detail(event, "rule"),
+				Subject: detail(event, "subject"),
+				Message: detail(event, "message"),
+				FiredAt: event.Timestamp,
+			})
+
+		case event.EventType == "alert_resolved":
+			if i, ok := firing[alertKey(event)]; ok {
+				r.Alerts[i].ResolvedAt = event.Timestamp
+				delete(firing, alertKey(event))
+			}
+		}
+	}
+
+	// Sessions still up on the running instance run to the end of the period
+	// and contribute their traffic so far
+	connected := make(map[string]core.ConnectionStatus)
+	for _, status := range live {
+		if status.State == core.StateConnected.String() {
+			connected[status.ID] = status
+		}
+	}
+	for connID, s := range open {
+		status, ok := connected[connID]
+		if !ok {
+			continue
+		}
+		sum := summary(s.method)
+		sum.Uptime += overlap(s.start, until, since, until)
+		sum.BytesSent += status.BytesSent
+		sum.BytesReceived += status.BytesReceived
+	}
+
+	period := until.Sub(since)
+	for _, s := range summaries {
+		if period > 0 {
+			s.Availability = 100 * float64(s.Uptime) / float64(period)
+		}
+		r.Bandwidth.BytesSent += s.BytesSent
+		r.Bandwidth.BytesReceived += s.BytesReceived
+		r.Connections = append(r.Connections, *s)
+	}
+	sort.Slice(r.Connections, func(i, j int) bool { return r.Connections[i].Method < r.Connections[j].Method })
+
+	return r
+}
+
+// overlap returns how much of [start, end] falls within [since, until]
+func overlap(start, end, since, until time.Time) time.Duration {
+	if start.Before(since) {
+		start = since
+	}
+	if end.After(until) {
+		end = until
+	}
+	if !end.After(start) {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+// alertKey identifies the alert an alert audit event is about
+func alertKey(event core.AuditEvent) string {
+	return detail(event, "rule") + "/" + detail(event, "subject")
+}
+
+// detail returns a string detail of an audit event
+func detail(event core.AuditEvent, key string) string {
+	if v, ok := event.Details[key]; ok && v != nil {
+		return fmt.Sprint(v)
+	}
+	return ""
+}
+
+// number reads a numeric detail, which JSON decoding leaves as float64
+func number(v interface{}) int64 {
+	switch n := v.(type) {
+	case float64:
+		return int64(n)
+	case int64:
+		return n
+	case int:
+		return int64(n)
+	}
+	return 0
+}
+
+// keyDetail picks the most useful detail recorded for a key change
+func keyDetail(event core.AuditEvent) string {
+	for _, key := range []string{"fingerprint", "new_fingerprint", "key_id", "source", "reason"} {
+		if v := detail(event, key); v != "" {
+			return v
+		}
+	}
+	for _, key := range []string{"count", "revoked_count", "keys_revoked", "new_count"} {
+		if v := detail(event, key); v != "" {
+			return v + " keys"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,102 @@
+package report
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/core"
+)
+
+func TestBuild(t *testing.T) {
+	until := time.Date(2024, 6, 8, 0, 0, 0, 0, time.UTC)
+	since := until.Add(-48 * time.Hour)
+	at := func(h int) time.Time { return since.Add(time.Duration(h) * time.Hour) }
+	conn := func(id string) map[string]interface{} { return map[string]interface{}{"connection_id": id} }
+
+	events := []core.AuditEvent{
+		// Already up when the period starts; only the part inside counts
+		{Timestamp: since.Add(-5 * time.Hour), EventType: "connection_connected", Method: "tailscale", Details: conn("ts-1")},
+		{Timestamp: at(2), EventType: "connection_disconnected", Method: "tailscale",
+			Details: map[string]interface{}{"connection_id": "ts-1", "bytes_sent": float64(1000), "bytes_received": float64(3000)}},
+		{Timestamp: at(3), EventType: "connection_connected", Method: "tailscale", Details: conn("ts-2")},
+		{Timestamp: at(4), EventType: "connection_error", Details: conn("ts-2")},
+		{Timestamp: at(5), EventType: "connection_failover",
+			Details: map[string]interface{}{"old_primary": "ts-2", "new_primary": "bore-1"}},
+		{Timestamp: at(6), EventType: "key_added", User: "alice", Success: true,
+			Details: map[string]interface{}{"fingerprint": "SHA256:abc"}},
+		{Timestamp: at(7), EventType: "alert_fired", Details: map[string]interface{}{"rule": "down", "subject": "ts-2", "message": "unhealthy"}},
+		{Timestamp: at(8), EventType: "alert_resolved", Details: map[string]interface{}{"rule": "down", "subject": "ts-2"}},
+		// Outside the period
+		{Timestamp: since.Add(-time.Hour), EventType: "key_removed", User: "bob"},
+	}
+	live := []core.ConnectionStatus{
+		{ID: "ts-2", Method: "tailscale", State: core.StateConnected.String(), BytesSent: 500, BytesReceived: 500},
+	}
+
+	r := Build(events, live, since, until)
+
+	if len(r.Connections) != 1 {
+		t.Fatalf("Build() connections = %+v, want only tailscale", r.Connections)
+	}
+	ts := r.Connections[0]
+	if ts.Method != "tailscale" || ts.Sessions != 1 || ts.Errors != 1 {
+		t.Errorf("tailscale summary = %+v", ts)
+	}
+	if want := 2*time.Hour + 45*time.Hour; ts.Uptime != want {
+		t.Errorf("uptime = %v, want %v", ts.Uptime, want)
+	}
+	if ts.BytesSent != 1500 || ts.BytesReceived != 3500 {
+		t.Errorf("bytes = %d/%d, want 1500/3500", ts.BytesSent, ts.BytesReceived)
+	}
+	if r.Bandwidth.BytesSent != 1500 {
+		t.Errorf("bandwidth sent = %d, want 1500", r.Bandwidth.BytesSent)
+	}
+
+	if len(r.Failovers) != 1 || r.Failovers[0].From != "ts-2" || r.Failovers[0].To != "bore-1" {
+		t.Errorf("failovers = %+v", r.Failovers)
+	}
+	if len(r.KeyChanges) != 1 || r.KeyChanges[0].Detail != "SHA256:abc" {
+		t.Errorf("key changes = %+v", r.KeyChanges)
+	}
+	if len(r.Alerts) != 1 || !r.Alerts[0].ResolvedAt.Equal(at(8)) {
+		t.Errorf("alerts = %+v", r.Alerts)
+	}
+}
+
+func TestRender(t *testing.T) {
+	r := Build([]core.AuditEvent{
+		{Timestamp: time.Now().Add(-time.Hour), EventType: "key_added", User: "<alice>", Success: true},
+	}, nil, time.Now().Add(-24*time.Hour), time.Now())
+
+	var md bytes.Buffer
+	if err := r.Render(&md, Markdown); err != nil {
+		t.Fatalf("Render(markdown) error = %v", err)
+	}
+	for _, want := range []string{"# Tunnel report", "No connections were recorded.", "| key added | <alice> |"} {
+		if !strings.Contains(md.String(), want) {
+			t.Errorf("markdown report missing %q:\n%s", want, md.String())
+		}
+	}
+
+	var html bytes.Buffer
+	if err := r.Render(&html, HTML); err != nil {
+		t.Fatalf("Render(html) error = %v", err)
+	}
+	if !strings.Contains(html.String(), "&lt;alice&gt;") {
+		t.Error("HTML report does not escape audit details")
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	if f, err := ParseFormat("md"); err != nil || f != Markdown {
+		t.Errorf("ParseFormat(md) = %q, %v", f, err)
+	}
+	if f, err := ParseFormat("HTML"); err != nil || f != HTML {
+		t.Errorf("ParseFormat(HTML) = %q, %v", f, err)
+	}
+	if _, err := ParseFormat("pdf"); err == nil {
+		t.Error("ParseFormat(pdf) should fail")
+	}
+}
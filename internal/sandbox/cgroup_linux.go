@@ -0,0 +1,138 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// cgroupRoot is where the unified (v2) hierarchy is mounted
+var cgroupRoot = "/sys/fs/cgroup"
+
+// cpuPeriod is the cpu.max period in microseconds
+const cpuPeriod = 100000
+
+// cgroups keeps each provider's cgroup directory open for the life of the
+// process, since new processes are placed in it by descriptor
+var cgroups = struct {
+	sync.Mutex
+	dirs map[string]*os.File
+}{dirs: make(map[string]*os.File)}
+
+// cgroupDir is the cgroup tunnel places the processes of provider in
+func cgroupDir(provider string) string {
+	return filepath.Join(cgroupRoot, "tunnel", provider)
+}
+
+// joinCgroup sets l on the provider's cgroup and makes cmd start in it
+func joinCgroup(cmd *exec.Cmd, provider string, l Limits) error {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return errors.New("cgroup v2 is not mounted")
+	}
+
+	cgroups.Lock()
+	defer cgroups.Unlock()
+
+	dir := cgroupDir(provider)
+	if _, open := cgroups.dirs[provider]; !open {
+		// Start from fresh counters unless processes of an earlier run
+		// remain; rmdir fails on a cgroup that is still populated
+		os.Remove(dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("create cgroup: %w", err)
+	}
+	// Controllers must be enabled on every level above the leaf
+	for _, parent := range []string{cgroupRoot, filepath.Dir(dir)} {
+		if err := writeCgroup(parent, "cgroup.subtree_control", "+cpu +memory"); err != nil {
+			return err
+		}
+	}
+
+	memory, cpu := "max", fmt.Sprintf("max %d", cpuPeriod)
+	if l.MemoryMB > 0 {
+		memory = strconv.FormatUint(l.MemoryMB*1024*1024, 10)
+	}
+	if l.CPUPercent > 0 {
+		cpu = fmt.Sprintf("%d %d", int(l.CPUPercent/100*cpuPeriod), cpuPeriod)
+	}
+	if err := writeCgroup(dir, "memory.max", memory); err != nil {
+		return err
+	}
+	if err := writeCgroup(dir, "cpu.max", cpu); err != nil {
+		return err
+	}
+
+	f, ok := cgroups.dirs[provider]
+	if !ok {
+		var err error
+		if f, err = os.Open(dir); err != nil {
+			return fmt.Errorf("open cgroup: %w", err)
+		}
+		cgroups.dirs[provider] = f
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(f.Fd())
+	return nil
+}
+
+func writeCgroup(dir, file, value string) error {
+	if err := os.WriteFile(filepath.Join(dir, file), []byte(value), 0644); err != nil {
+		return fmt.Errorf("set cgroup %s: %w", file, err)
+	}
+	return nil
+}
+
+// Violations describes how often the processes of provider were held back
+// by the limits of their cgroup
+func Violations(provider string) []string {
+	dir := cgroupDir(provider)
+	memory := readCgroupStats(filepath.Join(dir, "memory.events"))
+	cpu := readCgroupStats(filepath.Join(dir, "cpu.stat"))
+
+	var violations []string
+	if n := memory["max"]; n > 0 {
+		violations = append(violations, fmt.Sprintf("memory limit reached %d times", n))
+	}
+	if n := memory["oom_kill"]; n > 0 {
+		violations = append(violations, fmt.Sprintf("%d processes killed for exceeding the memory limit", n))
+	}
+	if n := cpu["nr_throttled"]; n > 0 {
+		violations = append(violations, fmt.Sprintf("CPU throttled in %d periods", n))
+	}
+	return violations
+}
+
+// readCgroupStats parses a flat-keyed cgroup file such as memory.events
+func readCgroupStats(path string) map[string]uint64 {
+	stats := make(map[string]uint64)
+	f, err := os.Open(path)
+	if err != nil {
+		return stats
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			continue
+		}
+		if n, err := strconv.ParseUint(value, 10, 64); err == nil {
+			stats[key] = n
+		}
+	}
+	return stats
+}
@@ -0,0 +1,63 @@
+package sandbox
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLimitsUseCgroup(t *testing.T) {
+	cgroupRoot = t.TempDir()
+	defer func() { cgroupRoot = "/sys/fs/cgroup" }()
+	os.WriteFile(filepath.Join(cgroupRoot, "cgroup.controllers"), []byte("cpu memory\n"), 0644)
+
+	cmd := exec.Command(os.Args[0])
+	policy := Policy{Name: "bore", Limits: Limits{MemoryMB: 64, CPUPercent: 50}}
+	if _, err := Apply(cmd, policy); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if !cmd.SysProcAttr.UseCgroupFD || cmd.Path != os.Args[0] {
+		t.Errorf("Apply() should start %s in its cgroup unwrapped, got %v", os.Args[0], cmd.Args)
+	}
+
+	dir := cgroupDir("bore")
+	for file, want := range map[string]string{"memory.max": "67108864", "cpu.max": "50000 100000"} {
+		if got, _ := os.ReadFile(filepath.Join(dir, file)); string(got) != want {
+			t.Errorf("%s = %q, want %q", file, got, want)
+		}
+	}
+
+	os.WriteFile(filepath.Join(dir, "memory.events"), []byte("low 0\nhigh 0\nmax 3\noom 0\noom_kill 1\n"), 0644)
+	if v := Violations("bore"); len(v) != 2 {
+		t.Errorf("Violations() = %v, want memory limit and OOM kill", v)
+	}
+}
+
+func TestLimitsFallBackToRlimits(t *testing.T) {
+	cgroupRoot = t.TempDir() // no cgroup v2 here
+	defer func() { cgroupRoot = "/sys/fs/cgroup" }()
+
+	cmd := exec.Command(os.Args[0], "-test.run=none")
+	policy := Policy{Name: "bore", Limits: Limits{MemoryMB: 64, CPUPercent: 50, OpenFiles: 256}}
+	warnings, err := Apply(cmd, policy)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "CPU") {
+		t.Errorf("Apply() warnings = %v, want the CPU limit skipped", warnings)
+	}
+	if cmd.Path != "/bin/sh" || !strings.Contains(cmd.Args[2], "ulimit -n 256") || !strings.Contains(cmd.Args[2], "ulimit -v 65536") {
+		t.Fatalf("Args = %v, want a ulimit wrapper", cmd.Args)
+	}
+	if !slices.Equal(cmd.Args[3:], []string{os.Args[0], "-test.run=none"}) {
+		t.Errorf("Args = %v, want the provider command after the script", cmd.Args)
+	}
+
+	policy.Required = true
+	if _, err := Apply(exec.Command(os.Args[0]), policy); err == nil {
+		t.Error("Apply() with Required should fail without cgroups for the CPU limit")
+	}
+}
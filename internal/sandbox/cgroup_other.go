@@ -0,0 +1,20 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// joinCgroup is only supported on Linux; elsewhere limits fall back to
+// rlimits
+func joinCgroup(cmd *exec.Cmd, provider string, l Limits) error {
+	return errors.New("cgroups are only available on Linux")
+}
+
+// Violations is empty where limits are plain rlimits, which are not
+// accounted; usage over a limit shows up in the process stats instead
+func Violations(provider string) []string {
+	return nil
+}
@@ -0,0 +1,55 @@
+package sandbox
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Limits caps the resources a provider's processes may use. Zero leaves a
+// resource unlimited.
+type Limits struct {
+	MemoryMB   uint64
+	CPUPercent float64 // of one core, so 200 allows two full cores
+	OpenFiles  uint64
+}
+
+// IsZero reports whether no limit is set
+func (l Limits) IsZero() bool {
+	return l.MemoryMB == 0 && l.CPUPercent == 0 && l.OpenFiles == 0
+}
+
+// applyLimits puts cmd in the provider's cgroup where cgroup v2 is
+// available and returns the ulimit commands for what the cgroup cannot
+// cover. Without cgroups memory falls back to an address space rlimit and
+// the CPU limit is reported as skipped.
+func applyLimits(cmd *exec.Cmd, name string, l Limits, skip func(string, ...interface{}) error) ([]string, error) {
+	var ulimits []string
+	if l.OpenFiles > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -n %d", l.OpenFiles))
+	}
+	if l.MemoryMB == 0 && l.CPUPercent == 0 {
+		return ulimits, nil
+	}
+
+	err := joinCgroup(cmd, name, l)
+	if err == nil {
+		return ulimits, nil
+	}
+	if l.MemoryMB > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -v %d", l.MemoryMB*1024))
+	}
+	if l.CPUPercent > 0 {
+		if err := skip("CPU limit not applied: %v", err); err != nil {
+			return nil, err
+		}
+	}
+	return ulimits, nil
+}
+
+// ulimitWrapper returns argv run through a shell that sets ulimits first;
+// rlimits survive the exec into the provider
+func ulimitWrapper(ulimits, argv []string) []string {
+	script := strings.Join(append(ulimits, `exec "$0" "$@"`), " && ")
+	return append([]string{"/bin/sh", "-c", script}, argv...)
+}
@@ -0,0 +1,186 @@
+// Package sandbox confines the processes providers start, so a compromised
+// tunnel binary cannot reach more of the system than it needs.
+//
+// Confinement is layered from what the host offers: the process can run as
+// a dedicated unprivileged user, under an AppArmor profile (via aa-exec),
+// inside a bubblewrap mount namespace that makes the filesystem read-only,
+// hides sensitive paths and loads a seccomp filter, and with its memory, CPU
+// and open files capped by a cgroup or rlimits.
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// ErrUnavailable is returned by Apply when a required confinement is not
+// supported on this host
+var ErrUnavailable = errors.New("sandbox unavailable")
+
+// Policy describes how to confine a provider's processes. The zero Policy
+// leaves them alone.
+type Policy struct {
+	Name     string   // provider the policy is for; names its cgroup
+	User     string   // run as this account; switching users requires root
+	AppArmor string   // AppArmor profile applied with aa-exec
+	Seccomp  string   // compiled seccomp BPF program loaded by bubblewrap
+	ReadOnly bool     // mount the whole filesystem read-only
+	Writable []string // paths left writable when ReadOnly is set
+	Hide     []string // paths replaced by an empty tmpfs, such as ~/.ssh
+	Limits   Limits
+	Required bool // fail rather than skip confinement the host lacks
+}
+
+// IsZero reports whether the policy confines nothing
+func (p Policy) IsZero() bool {
+	return p.User == "" && p.AppArmor == "" && !p.needsNamespace() && p.Limits.IsZero()
+}
+
+// needsNamespace reports whether the policy needs bubblewrap
+func (p Policy) needsNamespace() bool {
+	return p.Seccomp != "" || p.ReadOnly || len(p.Writable) > 0 || len(p.Hide) > 0
+}
+
+// Validate checks the policy without touching the host
+func (p Policy) Validate() error {
+	for _, path := range append(append([]string{}, p.Writable...), p.Hide...) {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("sandbox path %s is not absolute", path)
+		}
+	}
+	if len(p.Writable) > 0 && !p.ReadOnly {
+		return fmt.Errorf("sandbox writable paths require read_only")
+	}
+	if p.Seccomp != "" && !filepath.IsAbs(p.Seccomp) {
+		return fmt.Errorf("sandbox seccomp filter %s is not absolute", p.Seccomp)
+	}
+	if p.Limits.CPUPercent < 0 {
+		return fmt.Errorf("invalid sandbox CPU limit: %v", p.Limits.CPUPercent)
+	}
+	if !p.Limits.IsZero() && p.Name == "" {
+		return fmt.Errorf("sandbox resource limits require a name")
+	}
+	return nil
+}
+
+// Apply rewrites cmd, which must not have been started, so it runs under p.
+// Confinement the host cannot provide is skipped and described in the
+// returned warnings, unless p.Required is set, in which case Apply fails
+// with ErrUnavailable.
+func Apply(cmd *exec.Cmd, p Policy) ([]string, error) {
+	if p.IsZero() {
+		return nil, nil
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	if cmd.Err != nil {
+		return nil, cmd.Err
+	}
+
+	var warnings []string
+	skip := func(format string, args ...interface{}) error {
+		msg := fmt.Sprintf(format, args...)
+		if p.Required {
+			return fmt.Errorf("%w: %s", ErrUnavailable, msg)
+		}
+		warnings = append(warnings, msg)
+		return nil
+	}
+
+	// Innermost first: aa-exec switches profile on exec of the provider
+	argv := append([]string{cmd.Path}, cmd.Args[1:]...)
+	if p.AppArmor != "" {
+		if aaExec, ok := appArmor(); ok {
+			argv = append([]string{aaExec, "-p", p.AppArmor, "--"}, argv...)
+		} else if err := skip("AppArmor is not enabled, profile %s not applied", p.AppArmor); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.needsNamespace() {
+		if bwrap, err := exec.LookPath("bwrap"); err == nil {
+			args, err := namespaceArgs(cmd, p)
+			if err != nil {
+				return nil, err
+			}
+			argv = append(append([]string{bwrap}, args...), argv...)
+		} else if err := skip("bubblewrap is not installed, filesystem and seccomp restrictions not applied"); err != nil {
+			return nil, err
+		}
+	}
+
+	if !p.Limits.IsZero() {
+		if runtime.GOOS == "windows" {
+			if err := skip("resource limits are not supported on Windows"); err != nil {
+				return nil, err
+			}
+		} else {
+			ulimits, err := applyLimits(cmd, p.Name, p.Limits, skip)
+			if err != nil {
+				return nil, err
+			}
+			if len(ulimits) > 0 {
+				argv = ulimitWrapper(ulimits, argv)
+			}
+		}
+	}
+
+	if p.User != "" {
+		if err := setUser(cmd, p.User); err != nil {
+			return nil, err
+		}
+	}
+
+	cmd.Path = argv[0]
+	cmd.Args = argv
+	return warnings, nil
+}
+
+// namespaceArgs builds the bubblewrap arguments for p, passing the seccomp
+// filter to it as an extra file
+func namespaceArgs(cmd *exec.Cmd, p Policy) ([]string, error) {
+	args := []string{"--die-with-parent", "--unshare-pid"}
+	if p.ReadOnly {
+		args = append(args, "--ro-bind", "/", "/")
+	} else {
+		args = append(args, "--bind", "/", "/")
+	}
+	args = append(args, "--dev", "/dev", "--proc", "/proc")
+	for _, path := range p.Writable {
+		args = append(args, "--bind-try", path, path)
+	}
+	for _, path := range p.Hide {
+		args = append(args, "--tmpfs", path)
+	}
+
+	if p.Seccomp != "" {
+		filter, err := os.Open(p.Seccomp)
+		if err != nil {
+			return nil, fmt.Errorf("open seccomp filter: %w", err)
+		}
+		// Descriptors 0-2 are stdio, ExtraFiles follow from 3
+		cmd.ExtraFiles = append(cmd.ExtraFiles, filter)
+		args = append(args, "--seccomp", strconv.Itoa(2+len(cmd.ExtraFiles)))
+	}
+	return append(args, "--"), nil
+}
+
+// appArmor returns aa-exec if AppArmor is enabled in the running kernel
+func appArmor() (string, bool) {
+	enabled, err := os.ReadFile("/sys/module/apparmor/parameters/enabled")
+	if err != nil || strings.TrimSpace(string(enabled)) != "Y" {
+		return "", false
+	}
+	path, err := exec.LookPath("aa-exec")
+	if err != nil {
+		return "", false
+	}
+	return path, true
+}
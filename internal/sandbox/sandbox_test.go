@@ -0,0 +1,80 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+func TestApplySkipsUnavailable(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+
+	cmd := exec.Command(os.Args[0], "-test.run=none")
+	warnings, err := Apply(cmd, Policy{ReadOnly: true, Hide: []string{"/root/.ssh"}})
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if len(warnings) != 1 || cmd.Args[0] != os.Args[0] {
+		t.Errorf("Apply() = %v, args %v; want a warning and the command unchanged", warnings, cmd.Args)
+	}
+
+	cmd = exec.Command(os.Args[0])
+	if _, err := Apply(cmd, Policy{ReadOnly: true, Required: true}); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Apply() with Required error = %v, want ErrUnavailable", err)
+	}
+}
+
+func TestApplyWrapsWithBubblewrap(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("bubblewrap is Linux only")
+	}
+	bin := t.TempDir()
+	bwrap := filepath.Join(bin, "bwrap")
+	if err := os.WriteFile(bwrap, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+
+	cmd := exec.Command(os.Args[0], "local", "22")
+	policy := Policy{ReadOnly: true, Writable: []string{"/tmp"}, Hide: []string{"/root/.ssh"}}
+	if _, err := Apply(cmd, policy); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	if cmd.Path != bwrap {
+		t.Errorf("Path = %s, want %s", cmd.Path, bwrap)
+	}
+	sep := slices.Index(cmd.Args, "--")
+	if sep < 0 || !slices.Equal(cmd.Args[sep+1:], []string{os.Args[0], "local", "22"}) {
+		t.Fatalf("Args = %v, want the provider command after --", cmd.Args)
+	}
+	for _, want := range [][]string{{"--ro-bind", "/", "/"}, {"--bind-try", "/tmp", "/tmp"}, {"--tmpfs", "/root/.ssh"}} {
+		i := slices.Index(cmd.Args, want[0])
+		if i < 0 || !slices.Equal(cmd.Args[i:i+len(want)], want) {
+			t.Errorf("Args = %v, want %v", cmd.Args, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  Policy
+		wantErr bool
+	}{
+		{"zero", Policy{}, false},
+		{"read only", Policy{ReadOnly: true, Writable: []string{"/var/lib/bore"}}, false},
+		{"relative hide", Policy{Hide: []string{".ssh"}}, true},
+		{"writable without read only", Policy{Writable: []string{"/tmp"}}, true},
+		{"relative seccomp", Policy{Seccomp: "bore.bpf"}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.policy.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Validate() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
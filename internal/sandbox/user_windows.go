@@ -0,0 +1,14 @@
+//go:build windows
+
+package sandbox
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// setUser is not supported on Windows, where providers run as the user
+// running tunnel
+func setUser(cmd *exec.Cmd, name string) error {
+	return fmt.Errorf("%w: running providers as %s is not supported on Windows", ErrUnavailable, name)
+}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package state
+
+import "os"
+
+// lockFile opens path without locking; on Windows only the in-process mutex
+// serializes access
+func lockFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+}
+
+// unlockFile closes a file opened by lockFile
+func unlockFile(f *os.File) error {
+	return f.Close()
+}
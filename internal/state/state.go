@@ -0,0 +1,190 @@
+// Package state records which tunnels are up, so they can be brought back
+// after a restart or reboot. The connection manager, the CLI and the
+// instance manager each keep their entries in one file as they change;
+// tunnel start --restore reads it back.
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/providers"
+)
+
+// fileVersion is the state.json format written by this release
+const fileVersion = 1
+
+// State is the contents of the state file
+type State struct {
+	Version     int          `json:"version"`
+	SavedAt     time.Time    `json:"saved_at"`
+	Connections []Connection `json:"connections"`
+	Instances   []Instance   `json:"instances,omitempty"`
+}
+
+// Connection is a tunnel that was up when the state was saved
+type Connection struct {
+	ID         string    `json:"id"` // connection ID, or the method for tunnels the CLI started
+	Method     string    `json:"method"`
+	LocalPort  int       `json:"local_port,omitempty"`
+	RemoteHost string    `json:"remote_host,omitempty"`
+	RemotePort int       `json:"remote_port,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+}
+
+// Instance is one of several configured instances of a provider. Its
+// config is kept whether or not it is connected, since that is what it
+// takes to recreate it; Connected says whether to connect it again.
+type Instance struct {
+	ID          string                    `json:"id"`
+	Provider    string                    `json:"provider"`
+	DisplayName string                    `json:"display_name,omitempty"`
+	Config      *providers.ProviderConfig `json:"config,omitempty"`
+	Connected   bool                      `json:"connected"`
+}
+
+// Methods returns the methods of the saved connections, each once, in the
+// order they were started
+func (s *State) Methods() []string {
+	seen := make(map[string]bool)
+	var methods []string
+	for _, c := range s.Connections {
+		if !seen[c.Method] {
+			seen[c.Method] = true
+			methods = append(methods, c.Method)
+		}
+	}
+	return methods
+}
+
+// PutConnection records c, replacing a connection with the same ID
+func (s *State) PutConnection(c Connection) {
+	for i := range s.Connections {
+		if s.Connections[i].ID == c.ID {
+			s.Connections[i] = c
+			return
+		}
+	}
+	s.Connections = append(s.Connections, c)
+}
+
+// RemoveConnection drops the connection with id
+func (s *State) RemoveConnection(id string) {
+	s.removeConnections(func(c Connection) bool { return c.ID == id })
+}
+
+// RemoveMethod drops every connection made with method
+func (s *State) RemoveMethod(method string) {
+	s.removeConnections(func(c Connection) bool { return c.Method == method })
+}
+
+func (s *State) removeConnections(match func(Connection) bool) {
+	kept := s.Connections[:0]
+	for _, c := range s.Connections {
+		if !match(c) {
+			kept = append(kept, c)
+		}
+	}
+	s.Connections = kept
+}
+
+// Store reads and writes the state file at a path. Updates hold a lock on
+// the file, so the daemon and CLI processes can share it.
+type Store struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewStore creates a store for the state file at path
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// DefaultPath returns ~/.config/tunnel/state.json
+func DefaultPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("get home directory: %w", err)
+	}
+	return filepath.Join(home, ".config", "tunnel", "state.json"), nil
+}
+
+// Path returns the file the store reads and writes
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Load reads the saved state. A missing file is an empty state.
+func (s *Store) Load() (*State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load()
+}
+
+// Update applies fn to the saved state and writes the result back
+func (s *Store) Update(fn func(*State)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("create state directory: %w", err)
+	}
+	lock, err := lockFile(s.path + ".lock")
+	if err != nil {
+		return fmt.Errorf("lock state: %w", err)
+	}
+	defer unlockFile(lock)
+
+	st, err := s.load()
+	if err != nil {
+		return err
+	}
+	fn(st)
+	return s.save(st)
+}
+
+func (s *Store) load() (*State, error) {
+	st := &State{Version: fileVersion}
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read state: %w", err)
+	}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("parse state %s: %w", s.path, err)
+	}
+	if st.Version > fileVersion {
+		return nil, fmt.Errorf("state %s has version %d, newer than this release supports (%d)", s.path, st.Version, fileVersion)
+	}
+	return st, nil
+}
+
+// save replaces the state file in one step. The file holds the configs of
+// provider instances, tokens included, so only the user may read it.
+func (s *Store) save(st *State) error {
+	st.Version = fileVersion
+	st.SavedAt = time.Now().UTC()
+	if st.Connections == nil {
+		st.Connections = []Connection{}
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode state: %w", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("write state: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write state: %w", err)
+	}
+	return nil
+}
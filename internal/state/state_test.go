@@ -0,0 +1,57 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tunnel", "state.json")
+	store := NewStore(path)
+
+	if st, err := store.Load(); err != nil || len(st.Connections) != 0 {
+		t.Fatalf("missing file: %+v, %v; want an empty state", st, err)
+	}
+
+	err := store.Update(func(s *State) {
+		s.PutConnection(Connection{ID: "bore", Method: "bore"})
+		s.PutConnection(Connection{ID: "conn-1", Method: "ngrok", LocalPort: 3000})
+		s.PutConnection(Connection{ID: "conn-2", Method: "ngrok", LocalPort: 4000})
+		s.PutConnection(Connection{ID: "bore", Method: "bore", LocalPort: 22})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(st.Methods(), " "); got != "bore ngrok" {
+		t.Errorf("methods = %s", got)
+	}
+	if st.Connections[0].LocalPort != 22 || st.Version != fileVersion || st.SavedAt.IsZero() {
+		t.Errorf("state %+v", st)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("state file mode %v, %v; want 0600", info.Mode(), err)
+	}
+
+	store.Update(func(s *State) { s.RemoveMethod("ngrok") })
+	st, _ = store.Load()
+	if len(st.Connections) != 1 || st.Connections[0].ID != "bore" {
+		t.Errorf("after removing ngrok: %+v", st.Connections)
+	}
+}
+
+func TestLoadNewerVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte(`{"version": 99, "connections": []}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewStore(path).Load(); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("Load = %v, want an error about the newer version", err)
+	}
+}
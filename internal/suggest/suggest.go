@@ -0,0 +1,90 @@
+// Package suggest finds likely intended values for mistyped names, for
+// "did you mean" hints in error messages.
+package suggest
+
+import (
+	"sort"
+	"strings"
+)
+
+// maxSuggestions bounds how many candidates a hint lists
+const maxSuggestions = 3
+
+// Closest returns the candidates within typing distance of input, nearest
+// first. A candidate matches when its edit distance is at most a third of
+// its length (minimum 1), or when input is a prefix of it.
+func Closest(input string, candidates []string) []string {
+	input = strings.ToLower(input)
+	if input == "" {
+		return nil
+	}
+
+	type match struct {
+		name     string
+		distance int
+	}
+	var matches []match
+	seen := make(map[string]bool)
+	for _, c := range candidates {
+		if seen[c] {
+			continue
+		}
+		seen[c] = true
+
+		lower := strings.ToLower(c)
+		d := Distance(input, lower)
+		limit := max(len(lower)/3, 1)
+		if d <= limit || (len(input) >= 2 && strings.HasPrefix(lower, input)) {
+			matches = append(matches, match{c, d})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].distance != matches[j].distance {
+			return matches[i].distance < matches[j].distance
+		}
+		return matches[i].name < matches[j].name
+	})
+
+	var names []string
+	for i := 0; i < len(matches) && i < maxSuggestions; i++ {
+		names = append(names, matches[i].name)
+	}
+	return names
+}
+
+// Hint formats suggestions for appending to an error message, returning ""
+// when there are none
+func Hint(suggestions []string) string {
+	switch len(suggestions) {
+	case 0:
+		return ""
+	case 1:
+		return " (did you mean " + suggestions[0] + "?)"
+	default:
+		return " (did you mean one of: " + strings.Join(suggestions, ", ") + "?)"
+	}
+}
+
+// Distance returns the Levenshtein edit distance between a and b
+func Distance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,58 @@
+package suggest
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"cloudfare", "cloudflare", 1},
+		{"kitten", "sitting", 3},
+		{"tailscale", "tailscale", 0},
+		{"", "bore", 4},
+	}
+	for _, tt := range tests {
+		if got := Distance(tt.a, tt.b); got != tt.want {
+			t.Errorf("Distance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestClosest(t *testing.T) {
+	candidates := []string{"cloudflare", "tailscale", "wireguard", "bore", "ngrok", "reverse-ssh", "ssh-forward"}
+
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"cloudfare", []string{"cloudflare"}},
+		{"TAILSCALE", []string{"tailscale"}},
+		{"wiregaurd", []string{"wireguard"}},
+		{"ssh", []string{"ssh-forward"}},
+		{"bor", []string{"bore"}},
+		{"xyz", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := Closest(tt.input, candidates); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Closest(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestHint(t *testing.T) {
+	if got := Hint(nil); got != "" {
+		t.Errorf("Hint(nil) = %q", got)
+	}
+	if got := Hint([]string{"bore"}); got != " (did you mean bore?)" {
+		t.Errorf("Hint(one) = %q", got)
+	}
+	if got := Hint([]string{"a", "b"}); got != " (did you mean one of: a, b?)" {
+		t.Errorf("Hint(two) = %q", got)
+	}
+}
@@ -0,0 +1,75 @@
+package system
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// procRoot is where process information is read from
+var procRoot = "/proc"
+
+// AgentSession is an SSH login that forwarded the client's agent
+type AgentSession struct {
+	PID      int    // a process of the session
+	User     string // login user
+	SourceIP string // client address
+	Socket   string // agent socket sshd created for the session
+}
+
+// AgentForwardedSessions lists SSH sessions with a forwarded agent, found
+// through the SSH_AUTH_SOCK sshd puts in the environment of the session's
+// processes. Only processes whose environment is readable are seen, which
+// is all of them when running as root. Without /proc it returns nothing.
+func AgentForwardedSessions() ([]AgentSession, error) {
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	bySocket := make(map[string]AgentSession)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		environ, err := os.ReadFile(filepath.Join(procRoot, entry.Name(), "environ"))
+		if err != nil {
+			continue
+		}
+		session, ok := parseAgentEnviron(environ)
+		if !ok {
+			continue
+		}
+		// Keep the session's first process, its login shell
+		if seen, ok := bySocket[session.Socket]; ok && seen.PID < pid {
+			continue
+		}
+		session.PID = pid
+		bySocket[session.Socket] = session
+	}
+
+	sessions := make([]AgentSession, 0, len(bySocket))
+	for _, s := range bySocket {
+		sessions = append(sessions, s)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].PID < sessions[j].PID })
+	return sessions, nil
+}
+
+// parseAgentEnviron reads a NUL-separated environment and reports the
+// session if it belongs to an SSH login with a forwarded agent. Agents
+// started locally also set SSH_AUTH_SOCK but lack SSH_CONNECTION.
+func parseAgentEnviron(environ []byte) (AgentSession, bool) {
+	env := parseEnviron(environ)
+	s := AgentSession{Socket: env["SSH_AUTH_SOCK"], User: loginUser(env)}
+	if s.Socket == "" || env["SSH_CONNECTION"] == "" {
+		return AgentSession{}, false
+	}
+	s.SourceIP, _ = parseSSHConnection(env["SSH_CONNECTION"])
+	return s, true
+}
@@ -0,0 +1,44 @@
+package system
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAgentForwardedSessions(t *testing.T) {
+	root := t.TempDir()
+	writeEnviron := func(pid string, vars ...string) {
+		dir := filepath.Join(root, pid)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "environ"), []byte(strings.Join(vars, "\x00")+"\x00"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	forwarded := []string{"USER=alice", "SSH_CONNECTION=203.0.113.7 50022 10.0.0.2 22", "SSH_AUTH_SOCK=/tmp/ssh-abc/agent.100"}
+	writeEnviron("101", forwarded...)
+	writeEnviron("140", forwarded...) // a child of the same session
+	writeEnviron("200", "USER=bob", "SSH_CONNECTION=198.51.100.1 4000 10.0.0.2 22")
+	writeEnviron("300", "USER=carol", "SSH_AUTH_SOCK=/run/user/1000/keyring/ssh")
+	writeEnviron("self", forwarded...)
+
+	old := procRoot
+	procRoot = root
+	defer func() { procRoot = old }()
+
+	sessions, err := AgentForwardedSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("sessions = %+v, want only alice's", sessions)
+	}
+	want := AgentSession{PID: 101, User: "alice", SourceIP: "203.0.113.7", Socket: "/tmp/ssh-abc/agent.100"}
+	if sessions[0] != want {
+		t.Errorf("session = %+v, want %+v", sessions[0], want)
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"strconv"
 	"time"
+
+	"github.com/jedarden/tunnel/internal/offline"
 )
 
 // NetworkInfo contains network-related information
@@ -37,6 +40,10 @@ func GetLocalIPs() ([]string, error) {
 
 // GetPublicIP attempts to get the public IP address
 func GetPublicIP() (string, error) {
+	if err := offline.Check("public IP lookup"); err != nil {
+		return "", err
+	}
+
 	client := &http.Client{
 		Timeout: 5 * time.Second,
 	}
@@ -146,7 +153,7 @@ func GetAvailablePort(startPort int) (int, error) {
 
 // TestConnectivity tests connectivity to a host and port
 func TestConnectivity(host string, port int, timeout time.Duration) error {
-	address := fmt.Sprintf("%s:%d", host, port)
+	address := net.JoinHostPort(host, strconv.Itoa(port))
 	conn, err := net.DialTimeout("tcp", address, timeout)
 	if err != nil {
 		return fmt.Errorf("failed to connect to %s: %w", address, err)
@@ -157,6 +164,10 @@ func TestConnectivity(host string, port int, timeout time.Duration) error {
 
 // TestHTTPConnectivity tests HTTP/HTTPS connectivity to a URL
 func TestHTTPConnectivity(url string, timeout time.Duration) error {
+	if err := offline.Check("HTTP connectivity test"); err != nil {
+		return err
+	}
+
 	client := &http.Client{
 		Timeout: timeout,
 	}
@@ -0,0 +1,8 @@
+package system
+
+import "os"
+
+// fileOwner is unknown on Windows, which has no uids
+func fileOwner(info os.FileInfo) (uint32, bool) {
+	return 0, false
+}
@@ -0,0 +1,163 @@
+package system
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// ProcessUsage describes the CPU and memory consumption of a single process
+type ProcessUsage struct {
+	PID         int     `json:"pid"`
+	Command     string  `json:"command"`
+	CPUPercent  float64 `json:"cpu_percent"`
+	MemoryBytes uint64  `json:"memory_bytes"`
+	OpenFiles   int     `json:"open_files,omitempty"` // Linux only
+}
+
+// ResourceUsage aggregates the processes that belong to one provider
+type ResourceUsage struct {
+	Processes   []ProcessUsage `json:"processes"`
+	CPUPercent  float64        `json:"cpu_percent"`
+	MemoryBytes uint64         `json:"memory_bytes"`
+	OpenFiles   int            `json:"open_files,omitempty"` // of the busiest process
+
+	// Violations describes enforced limits the processes ran into
+	Violations []string `json:"violations,omitempty"`
+}
+
+// ResourceLimits are thresholds above which a provider is considered to misbehave.
+// Zero disables a limit.
+type ResourceLimits struct {
+	MaxCPUPercent float64
+	MaxMemoryMB   uint64
+	MaxOpenFiles  uint64 // per process
+}
+
+// FindProcesses returns the PIDs of processes whose command line matches pattern
+func FindProcesses(pattern string) ([]int, error) {
+	output, err := exec.Command("pgrep", "-f", pattern).Output()
+	if err != nil {
+		// pgrep exits 1 when nothing matches
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("pgrep %q: %w", pattern, err)
+	}
+
+	var pids []int
+	for _, field := range strings.Fields(string(output)) {
+		pid, err := strconv.Atoi(field)
+		if err != nil {
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+// GetProcessUsage reads CPU and resident memory for a process using ps
+func GetProcessUsage(pid int) (*ProcessUsage, error) {
+	output, err := exec.Command("ps", "-o", "pcpu=,rss=,comm=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		return nil, fmt.Errorf("process %d not found: %w", pid, err)
+	}
+
+	usage, err := parsePSLine(strings.TrimSpace(string(output)))
+	if err != nil {
+		return nil, err
+	}
+	usage.PID = pid
+	usage.OpenFiles = countOpenFiles(pid)
+	return usage, nil
+}
+
+// GetResourceUsage sums the usage of all processes matching any of patterns
+func GetResourceUsage(patterns []string) (*ResourceUsage, error) {
+	usage := &ResourceUsage{Processes: []ProcessUsage{}}
+	seen := make(map[int]bool)
+
+	for _, pattern := range patterns {
+		pids, err := FindProcesses(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pid := range pids {
+			if seen[pid] {
+				continue
+			}
+			seen[pid] = true
+
+			proc, err := GetProcessUsage(pid)
+			if err != nil {
+				// Process exited between pgrep and ps
+				continue
+			}
+			usage.Processes = append(usage.Processes, *proc)
+			usage.CPUPercent += proc.CPUPercent
+			usage.MemoryBytes += proc.MemoryBytes
+			usage.OpenFiles = max(usage.OpenFiles, proc.OpenFiles)
+		}
+	}
+
+	return usage, nil
+}
+
+// CheckLimits returns a warning for every limit the usage exceeds
+func (u *ResourceUsage) CheckLimits(limits ResourceLimits) []string {
+	var warnings []string
+
+	if limits.MaxCPUPercent > 0 && u.CPUPercent > limits.MaxCPUPercent {
+		warnings = append(warnings, fmt.Sprintf("CPU usage %.1f%% exceeds limit of %.1f%%", u.CPUPercent, limits.MaxCPUPercent))
+	}
+
+	if limits.MaxMemoryMB > 0 {
+		usedMB := u.MemoryBytes / (1024 * 1024)
+		if usedMB > limits.MaxMemoryMB {
+			warnings = append(warnings, fmt.Sprintf("memory usage %d MB exceeds limit of %d MB", usedMB, limits.MaxMemoryMB))
+		}
+	}
+
+	if limits.MaxOpenFiles > 0 && uint64(u.OpenFiles) >= limits.MaxOpenFiles {
+		warnings = append(warnings, fmt.Sprintf("%d open files reaches limit of %d", u.OpenFiles, limits.MaxOpenFiles))
+	}
+
+	return warnings
+}
+
+// countOpenFiles returns the number of descriptors pid holds open, or 0
+// where /proc is not available
+func countOpenFiles(pid int) int {
+	entries, err := os.ReadDir(fmt.Sprintf("/proc/%d/fd", pid))
+	if err != nil {
+		return 0
+	}
+	return len(entries)
+}
+
+// parsePSLine parses "<pcpu> <rss-kb> <comm>" as printed by ps
+func parsePSLine(line string) (*ProcessUsage, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("unexpected ps output: %q", line)
+	}
+
+	cpu, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return nil, fmt.Errorf("parse cpu: %w", err)
+	}
+
+	rssKB, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("parse rss: %w", err)
+	}
+
+	return &ProcessUsage{
+		Command:     strings.Join(fields[2:], " "),
+		CPUPercent:  cpu,
+		MemoryBytes: rssKB * 1024,
+	}, nil
+}
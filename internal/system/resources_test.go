@@ -0,0 +1,49 @@
+package system
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParsePSLine(t *testing.T) {
+	usage, err := parsePSLine(" 12.5 20480 cloudflared")
+	if err != nil {
+		t.Fatalf("parsePSLine() error = %v", err)
+	}
+	if usage.CPUPercent != 12.5 || usage.MemoryBytes != 20480*1024 || usage.Command != "cloudflared" {
+		t.Errorf("parsePSLine() = %+v", usage)
+	}
+
+	for _, line := range []string{"", "1.0 2048", "abc 2048 ngrok", "1.0 xyz ngrok"} {
+		if _, err := parsePSLine(line); err == nil {
+			t.Errorf("parsePSLine(%q) expected error", line)
+		}
+	}
+}
+
+func TestCheckLimits(t *testing.T) {
+	usage := &ResourceUsage{CPUPercent: 80, MemoryBytes: 600 * 1024 * 1024, OpenFiles: 64}
+
+	if warnings := usage.CheckLimits(ResourceLimits{}); len(warnings) != 0 {
+		t.Errorf("CheckLimits() with no limits = %v, want none", warnings)
+	}
+	if warnings := usage.CheckLimits(ResourceLimits{MaxCPUPercent: 90, MaxMemoryMB: 1024}); len(warnings) != 0 {
+		t.Errorf("CheckLimits() under limits = %v, want none", warnings)
+	}
+	if warnings := usage.CheckLimits(ResourceLimits{MaxCPUPercent: 50, MaxMemoryMB: 512}); len(warnings) != 2 {
+		t.Errorf("CheckLimits() over limits = %v, want 2 warnings", warnings)
+	}
+	if warnings := usage.CheckLimits(ResourceLimits{MaxOpenFiles: 64}); len(warnings) != 1 {
+		t.Errorf("CheckLimits() at open files limit = %v, want 1 warning", warnings)
+	}
+}
+
+func TestGetProcessUsageSelf(t *testing.T) {
+	usage, err := GetProcessUsage(os.Getpid())
+	if err != nil {
+		t.Skipf("ps not available: %v", err)
+	}
+	if usage.PID != os.Getpid() || usage.MemoryBytes == 0 {
+		t.Errorf("GetProcessUsage(self) = %+v", usage)
+	}
+}
@@ -0,0 +1,291 @@
+package system
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// clockTicks is USER_HZ, the unit of process start times in /proc; it is
+// 100 on every Linux architecture Go supports
+const clockTicks = 100
+
+// SSHSession is a login to the SSH server, seen through the processes sshd
+// started for it
+type SSHSession struct {
+	ID            string    `json:"id"` // SSH_CONNECTION, unique while the connection lasts
+	PID           int       `json:"pid"`
+	SSHDPID       int       `json:"sshd_pid,omitempty"` // sshd process serving the connection
+	User          string    `json:"user"`
+	SourceIP      string    `json:"source_ip"`
+	SourcePort    int       `json:"source_port"`
+	Fingerprint   string    `json:"fingerprint,omitempty"` // needs ExposeAuthInfo in sshd_config
+	TTY           string    `json:"tty,omitempty"`
+	Command       string    `json:"command,omitempty"` // exec requests only
+	StartedAt     time.Time `json:"started_at"`
+	BytesSent     int64     `json:"bytes_sent"`     // to the client
+	BytesReceived int64     `json:"bytes_received"` // from the client
+}
+
+// SSHSessions lists the SSH logins on this host. Sessions are found through
+// the SSH_CONNECTION sshd puts in the environment of their processes, so
+// only sessions whose processes are readable are seen - all of them when
+// running as root. Traffic comes from ss and is zero where it is missing.
+// Without /proc it returns nothing.
+func SSHSessions() ([]SSHSession, error) {
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	byID := make(map[string]SSHSession)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		environ, err := os.ReadFile(filepath.Join(procRoot, entry.Name(), "environ"))
+		if err != nil {
+			continue
+		}
+		env := parseEnviron(environ)
+		id := env["SSH_CONNECTION"]
+		if id == "" {
+			continue
+		}
+		// The lowest PID is the process sshd started, the login shell or
+		// the command of an exec request
+		if seen, ok := byID[id]; ok && seen.PID < pid {
+			continue
+		}
+
+		s := SSHSession{ID: id, PID: pid, User: loginUser(env), TTY: env["SSH_TTY"]}
+		s.SourceIP, s.SourcePort = parseSSHConnection(id)
+		if s.TTY == "" {
+			if cmdline, err := os.ReadFile(filepath.Join(procRoot, entry.Name(), "cmdline")); err == nil {
+				s.Command = sessionCommand(cmdline)
+			}
+		}
+		if path := env["SSH_USER_AUTH"]; path != "" {
+			s.Fingerprint = authInfoFingerprint(path)
+		}
+		s.StartedAt = processStartTime(pid)
+		s.SSHDPID = parentSSHD(pid)
+		byID[id] = s
+	}
+	if len(byID) == 0 {
+		return nil, nil
+	}
+
+	traffic := socketTraffic()
+	sessions := make([]SSHSession, 0, len(byID))
+	for _, s := range byID {
+		if t, ok := traffic[net.JoinHostPort(s.SourceIP, strconv.Itoa(s.SourcePort))]; ok {
+			s.BytesSent, s.BytesReceived = t[0], t[1]
+		}
+		sessions = append(sessions, s)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].PID < sessions[j].PID })
+	return sessions, nil
+}
+
+// parseEnviron splits a NUL-separated process environment into variables
+func parseEnviron(environ []byte) map[string]string {
+	env := make(map[string]string)
+	for _, kv := range bytes.Split(environ, []byte{0}) {
+		if key, value, ok := strings.Cut(string(kv), "="); ok {
+			env[key] = value
+		}
+	}
+	return env
+}
+
+// loginUser returns the user an SSH session logged in as
+func loginUser(env map[string]string) string {
+	if user := env["USER"]; user != "" {
+		return user
+	}
+	return env["LOGNAME"]
+}
+
+// parseSSHConnection reads the client address from SSH_CONNECTION, which is
+// "client-ip client-port server-ip server-port"
+func parseSSHConnection(conn string) (string, int) {
+	fields := strings.Fields(conn)
+	if len(fields) < 2 {
+		return "", 0
+	}
+	port, _ := strconv.Atoi(fields[1])
+	return fields[0], port
+}
+
+// sessionCommand returns the command of an exec request from the cmdline of
+// the process sshd started, unwrapping the user's shell
+func sessionCommand(cmdline []byte) string {
+	args := strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00")
+	if len(args) >= 3 && args[1] == "-c" {
+		return args[2]
+	}
+	return strings.Join(args, " ")
+}
+
+// authInfoFingerprint returns the fingerprint of the public key a session
+// authenticated with, from the file sshd names in SSH_USER_AUTH when
+// ExposeAuthInfo is on. Its lines look like "publickey ssh-ed25519 AAAA...".
+func authInfoFingerprint(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		method, key, ok := strings.Cut(line, " ")
+		if !ok || method != "publickey" {
+			continue
+		}
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key))
+		if err != nil {
+			continue
+		}
+		hash := sha256.Sum256(pub.Marshal())
+		return "SHA256:" + base64.RawStdEncoding.EncodeToString(hash[:])
+	}
+	return ""
+}
+
+// processStartTime returns when pid started, or the zero time if unknown
+func processStartTime(pid int) time.Time {
+	stat, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return time.Time{}
+	}
+	// The command name may contain spaces; fields resume after its ")"
+	end := bytes.LastIndexByte(stat, ')')
+	if end < 0 {
+		return time.Time{}
+	}
+	fields := strings.Fields(string(stat[end+1:]))
+	// starttime is field 22 of stat, the 20th after the command name
+	if len(fields) < 20 {
+		return time.Time{}
+	}
+	ticks, err := strconv.ParseInt(fields[19], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	boot := bootTime()
+	if boot.IsZero() {
+		return time.Time{}
+	}
+	return boot.Add(time.Duration(ticks) * time.Second / clockTicks)
+}
+
+// parentSSHD returns the sshd process pid was started by, or 0 when its
+// parent is something else. Ending that process closes the connection.
+func parentSSHD(pid int) int {
+	_, ppid := processStat(pid)
+	if ppid <= 1 {
+		return 0
+	}
+	// OpenSSH 9.8 moved the per-connection server into sshd-session
+	if comm, _ := processStat(ppid); comm == "sshd" || comm == "sshd-session" {
+		return ppid
+	}
+	return 0
+}
+
+// processStat returns the command name and parent of pid from its stat
+func processStat(pid int) (string, int) {
+	stat, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return "", 0
+	}
+	start := bytes.IndexByte(stat, '(')
+	end := bytes.LastIndexByte(stat, ')')
+	if start < 0 || end < start {
+		return "", 0
+	}
+	fields := strings.Fields(string(stat[end+1:]))
+	if len(fields) < 2 {
+		return "", 0
+	}
+	ppid, _ := strconv.Atoi(fields[1])
+	return string(stat[start+1 : end]), ppid
+}
+
+// bootTime reads the system boot time from /proc/stat
+func bootTime() time.Time {
+	f, err := os.Open(filepath.Join(procRoot, "stat"))
+	if err != nil {
+		return time.Time{}
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "btime "); ok {
+			if secs, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64); err == nil {
+				return time.Unix(secs, 0)
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// socketTraffic returns the bytes sent and received on each established TCP
+// socket, keyed by peer address, using ss
+func socketTraffic() map[string][2]int64 {
+	out, err := exec.Command("ss", "-tinH", "state", "established").Output()
+	if err != nil {
+		return nil
+	}
+	return parseSocketTraffic(string(out))
+}
+
+// parseSocketTraffic reads `ss -tinH state established` output, where each
+// socket is a "recv-q send-q local peer" line followed by an indented line
+// of TCP info
+func parseSocketTraffic(out string) map[string][2]int64 {
+	traffic := make(map[string][2]int64)
+	var peer string
+	for _, line := range strings.Split(out, "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if line[0] != ' ' && line[0] != '\t' {
+			peer = ""
+			if len(fields) >= 4 {
+				peer = fields[3]
+			}
+			continue
+		}
+		if peer == "" {
+			continue
+		}
+		var t [2]int64
+		for _, field := range fields {
+			if v, ok := strings.CutPrefix(field, "bytes_sent:"); ok {
+				t[0], _ = strconv.ParseInt(v, 10, 64)
+			} else if v, ok := strings.CutPrefix(field, "bytes_received:"); ok {
+				t[1], _ = strconv.ParseInt(v, 10, 64)
+			}
+		}
+		traffic[peer] = t
+		peer = ""
+	}
+	return traffic
+}
@@ -0,0 +1,91 @@
+package system
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSSHSessions(t *testing.T) {
+	root := t.TempDir()
+	write := func(path, content string) {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	environ := func(vars ...string) string { return strings.Join(vars, "\x00") + "\x00" }
+
+	authInfo := filepath.Join(root, "auth-info")
+	write("auth-info", "publickey ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOWBrmkd1aRC5ZjxlCmRW7bIQiMYme7azKGHkhhY1lHq\n")
+	write("stat", "cpu  1 2 3\nbtime 1700000000\n")
+
+	// An exec request: bash -c runs the command, which has a child
+	exec := environ("USER=deploy", "SSH_CONNECTION=203.0.113.7 50022 10.0.0.2 22", "SSH_USER_AUTH="+authInfo)
+	write("500/environ", exec)
+	write("500/cmdline", "bash\x00-c\x00rsync --server -vlogDtpre.iLsfxC . /srv\x00")
+	write("500/stat", "500 (bash) S 499 500 500 0 -1 4194560 0 0 0 0 0 0 0 0 20 0 1 0 12000 0 0")
+	write("499/stat", "499 (sshd-session) S 1 499 499 0 -1 4194560 0 0 0 0 0 0 0 0 20 0 1 0 11990 0 0")
+	write("501/environ", exec)
+	write("501/cmdline", "rsync\x00--server\x00")
+
+	// An interactive login
+	write("600/environ", environ("LOGNAME=alice", "SSH_CONNECTION=198.51.100.1 4000 10.0.0.2 22", "SSH_TTY=/dev/pts/1"))
+	write("600/cmdline", "-bash\x00")
+
+	// A local process
+	write("700/environ", environ("USER=root", "HOME=/root"))
+
+	old := procRoot
+	procRoot = root
+	defer func() { procRoot = old }()
+
+	sessions, err := SSHSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("sessions = %+v, want 2", sessions)
+	}
+
+	s := sessions[0]
+	if s.PID != 500 || s.User != "deploy" || s.SourceIP != "203.0.113.7" || s.SourcePort != 50022 {
+		t.Errorf("exec session = %+v", s)
+	}
+	if s.Command != "rsync --server -vlogDtpre.iLsfxC . /srv" {
+		t.Errorf("Command = %q", s.Command)
+	}
+	if !strings.HasPrefix(s.Fingerprint, "SHA256:") {
+		t.Errorf("Fingerprint = %q", s.Fingerprint)
+	}
+	if want := time.Unix(1700000000+120, 0); !s.StartedAt.Equal(want) {
+		t.Errorf("StartedAt = %v, want %v", s.StartedAt, want)
+	}
+	if s.SSHDPID != 499 {
+		t.Errorf("SSHDPID = %d, want 499", s.SSHDPID)
+	}
+
+	if s := sessions[1]; s.User != "alice" || s.TTY != "/dev/pts/1" || s.Command != "" || s.SSHDPID != 0 {
+		t.Errorf("interactive session = %+v", s)
+	}
+}
+
+func TestParseSocketTraffic(t *testing.T) {
+	out := "0      0      10.0.0.2:22 203.0.113.7:50022\n" +
+		"\t cubic wscale:7,7 rto:204 bytes_sent:4096 bytes_acked:4096 bytes_received:512 segs_out:10\n" +
+		"0      0      [2001:db8::2]:22 [2001:db8::7]:41000\n" +
+		"\t cubic bytes_sent:10 bytes_received:20\n"
+
+	traffic := parseSocketTraffic(out)
+	if got := traffic["203.0.113.7:50022"]; got != [2]int64{4096, 512} {
+		t.Errorf("IPv4 traffic = %v", got)
+	}
+	if got := traffic["[2001:db8::7]:41000"]; got != [2]int64{10, 20} {
+		t.Errorf("IPv6 traffic = %v", got)
+	}
+}
@@ -0,0 +1,201 @@
+package system
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// SFTPDropInPath is where the sshd configuration for the SFTP policy is
+// written; sshd_config includes sshd_config.d/*.conf on current distributions
+var SFTPDropInPath = "/etc/ssh/sshd_config.d/tunnel-sftp.conf"
+
+// sftpDisabled is the subsystem command that refuses every SFTP session
+const sftpDisabled = "/bin/false"
+
+// SFTPPolicy is the SFTP access sshd should enforce
+type SFTPPolicy struct {
+	Enabled       bool
+	ReadOnly      bool              // every user may only read
+	Chroot        map[string]string // user to directory; these users get SFTP only, jailed to it
+	ReadOnlyUsers []string          // users limited to read-only SFTP, with no shell
+}
+
+// SSHDConfig renders the policy as an sshd_config drop-in
+func (p SFTPPolicy) SSHDConfig() string {
+	var b strings.Builder
+	b.WriteString("# Managed by TUNNEL - SFTP policy. Edit ssh.sftp in the TUNNEL config\n")
+	b.WriteString("# and run `tunnel sftp apply` instead of changing this file.\n\n")
+
+	if !p.Enabled {
+		fmt.Fprintf(&b, "Subsystem sftp %s\n", sftpDisabled)
+		return b.String()
+	}
+
+	fmt.Fprintf(&b, "Subsystem sftp %s\n", p.sftpCommand(false))
+
+	// Match blocks run to the end of the file, so they come last
+	for _, user := range p.restrictedUsers() {
+		fmt.Fprintf(&b, "\nMatch User %s\n", user)
+		if dir := p.Chroot[user]; dir != "" {
+			fmt.Fprintf(&b, "    ChrootDirectory %s\n", dir)
+		}
+		fmt.Fprintf(&b, "    ForceCommand %s\n", p.sftpCommand(slices.Contains(p.ReadOnlyUsers, user)))
+		b.WriteString("    AllowTcpForwarding no\n")
+		b.WriteString("    X11Forwarding no\n")
+	}
+	return b.String()
+}
+
+// sftpCommand returns the internal-sftp invocation, read-only when asked
+// or when the whole policy is
+func (p SFTPPolicy) sftpCommand(readOnly bool) string {
+	if readOnly || p.ReadOnly {
+		return "internal-sftp -R"
+	}
+	return "internal-sftp"
+}
+
+// restrictedUsers returns the users with a Match block, sorted
+func (p SFTPPolicy) restrictedUsers() []string {
+	seen := make(map[string]bool)
+	for user := range p.Chroot {
+		seen[user] = true
+	}
+	for _, user := range p.ReadOnlyUsers {
+		seen[user] = true
+	}
+	users := make([]string, 0, len(seen))
+	for user := range seen {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	return users
+}
+
+// Verify compares the policy with sshd's effective configuration and
+// returns what does not match
+func (p SFTPPolicy) Verify() ([]string, error) {
+	global, err := EffectiveSSHDConfig("")
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	subsystem := sftpSubsystem(global)
+	switch {
+	case !p.Enabled && subsystem != "" && subsystem != sftpDisabled:
+		problems = append(problems, fmt.Sprintf("SFTP is disabled but sshd serves it with %s", subsystem))
+	case p.Enabled && subsystem == "":
+		problems = append(problems, "SFTP is enabled but sshd has no sftp subsystem")
+	case p.Enabled && p.ReadOnly && subsystem != p.sftpCommand(true):
+		problems = append(problems, fmt.Sprintf("SFTP should be read-only but sshd runs %s", subsystem))
+	}
+	if !p.Enabled {
+		return problems, nil
+	}
+
+	for _, user := range p.restrictedUsers() {
+		effective, err := EffectiveSSHDConfig(user)
+		if err != nil {
+			return nil, err
+		}
+		want := p.sftpCommand(slices.Contains(p.ReadOnlyUsers, user))
+		if got := first(effective["forcecommand"]); got != want {
+			problems = append(problems, fmt.Sprintf("%s should be limited to %s but sshd forces %q", user, want, got))
+		}
+		dir := p.Chroot[user]
+		if dir == "" {
+			continue
+		}
+		expanded := strings.ReplaceAll(dir, "%u", user)
+		if got := first(effective["chrootdirectory"]); got != dir && got != expanded {
+			problems = append(problems, fmt.Sprintf("%s should be chrooted to %s but sshd uses %q", user, dir, got))
+			continue
+		}
+		if err := CheckChrootDir(expanded); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", user, err))
+		}
+	}
+	return problems, nil
+}
+
+// CheckChrootDir checks that sshd will accept dir as a ChrootDirectory:
+// it and every directory above it must be owned by root and writable by
+// nobody else
+func CheckChrootDir(dir string) error {
+	if strings.Contains(dir, "%") {
+		return nil // other sshd tokens are only known at login
+	}
+	for path := filepath.Clean(dir); ; path = filepath.Dir(path) {
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("chroot directory: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("chroot directory %s is not a directory", path)
+		}
+		if info.Mode().Perm()&0022 != 0 {
+			return fmt.Errorf("chroot directory %s is writable by group or others", path)
+		}
+		if uid, ok := fileOwner(info); ok && uid != 0 {
+			return fmt.Errorf("chroot directory %s is not owned by root", path)
+		}
+		if path == filepath.Dir(path) {
+			return nil
+		}
+	}
+}
+
+// EffectiveSSHDConfig returns sshd's effective configuration, as printed by
+// sshd -T, for a login by user, or without Match blocks applied when user
+// is empty. Keys are lower case; reading it usually needs root.
+func EffectiveSSHDConfig(user string) (map[string][]string, error) {
+	args := []string{"-T"}
+	if user != "" {
+		args = append(args, "-C", fmt.Sprintf("user=%s,host=localhost,addr=127.0.0.1", user))
+	}
+	out, err := exec.Command("sshd", args...).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("read sshd configuration: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return parseSSHDConfig(string(out)), nil
+}
+
+// parseSSHDConfig reads sshd -T output, one "keyword value" per line;
+// repeated keywords such as subsystem keep every value
+func parseSSHDConfig(out string) map[string][]string {
+	config := make(map[string][]string)
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		if key == "" {
+			continue
+		}
+		key = strings.ToLower(key)
+		config[key] = append(config[key], value)
+	}
+	return config
+}
+
+// sftpSubsystem returns the command of the sftp subsystem, or "" if none
+func sftpSubsystem(config map[string][]string) string {
+	for _, value := range config["subsystem"] {
+		if name, command, ok := strings.Cut(value, " "); ok && name == "sftp" {
+			return strings.TrimSpace(command)
+		}
+	}
+	return ""
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
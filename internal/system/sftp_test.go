@@ -0,0 +1,65 @@
+package system
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSFTPPolicySSHDConfig(t *testing.T) {
+	disabled := SFTPPolicy{}.SSHDConfig()
+	if !strings.Contains(disabled, "Subsystem sftp /bin/false\n") || strings.Contains(disabled, "Match") {
+		t.Errorf("disabled policy =\n%s", disabled)
+	}
+
+	policy := SFTPPolicy{
+		Enabled:       true,
+		Chroot:        map[string]string{"backup": "/srv/sftp/%u"},
+		ReadOnlyUsers: []string{"auditor"},
+	}
+	got := policy.SSHDConfig()
+	want := `Subsystem sftp internal-sftp
+
+Match User auditor
+    ForceCommand internal-sftp -R
+    AllowTcpForwarding no
+    X11Forwarding no
+
+Match User backup
+    ChrootDirectory /srv/sftp/%u
+    ForceCommand internal-sftp
+    AllowTcpForwarding no
+    X11Forwarding no
+`
+	if !strings.HasSuffix(got, want) {
+		t.Errorf("SSHDConfig() =\n%s\nwant it to end with\n%s", got, want)
+	}
+
+	policy.ReadOnly = true
+	if got := policy.SSHDConfig(); strings.Count(got, "internal-sftp -R") != 3 {
+		t.Errorf("read-only policy =\n%s", got)
+	}
+}
+
+func TestSFTPSubsystem(t *testing.T) {
+	config := parseSSHDConfig("port 22\nSubsystem x11 /usr/bin/foo\nsubsystem sftp internal-sftp -R\n")
+	if got := sftpSubsystem(config); got != "internal-sftp -R" {
+		t.Errorf("sftpSubsystem() = %q", got)
+	}
+	if got := sftpSubsystem(parseSSHDConfig("port 22\n")); got != "" {
+		t.Errorf("sftpSubsystem() without sftp = %q", got)
+	}
+}
+
+func TestCheckChrootDir(t *testing.T) {
+	if err := CheckChrootDir("/srv/sftp/%h"); err != nil {
+		t.Errorf("CheckChrootDir() with a login-time token = %v", err)
+	}
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckChrootDir(dir); err == nil {
+		t.Error("CheckChrootDir() accepted a world-writable directory")
+	}
+}
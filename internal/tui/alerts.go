@@ -0,0 +1,184 @@
+package tui
+
+import (
+	"fmt"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/units"
+)
+
+// alertPollInterval is how often the alerts panel refreshes from the engine
+const alertPollInterval = 2 * time.Second
+
+// maxRecentShown limits how many resolved alerts the panel lists
+const maxRecentShown = 3
+
+// AlertEngineMsg hands the running alert engine to the TUI once it exists
+type AlertEngineMsg struct {
+	Engine *core.AlertEngine
+}
+
+// AlertsMsg carries the current active and recently resolved alerts
+type AlertsMsg struct {
+	Active []core.Alert
+	Recent []core.Alert
+}
+
+// alertActionMsg reports the outcome of an acknowledge or silence keypress
+type alertActionMsg struct {
+	alert  core.Alert
+	action string
+	err    error
+}
+
+// SetAlertEngine enables the alerts panel
+func (a *App) SetAlertEngine(e *core.AlertEngine) {
+	a.alerts = e
+}
+
+// pollAlerts reads the engine's alerts after the poll interval
+func (a *App) pollAlerts() tea.Cmd {
+	e := a.alerts
+	return tea.Tick(alertPollInterval, func(time.Time) tea.Msg {
+		return AlertsMsg{Active: e.Active(), Recent: e.Recent()}
+	})
+}
+
+// handleAlerts stores polled alerts, keeping the selection in range
+func (a *App) handleAlerts(msg AlertsMsg) {
+	a.activeAlerts = msg.Active
+	a.recentAlerts = msg.Recent
+	a.reselectAlert()
+	if a.alertCursor >= len(a.activeAlerts) {
+		a.alertCursor = len(a.activeAlerts) - 1
+	}
+	if a.alertCursor < 0 {
+		a.alertCursor = 0
+	}
+}
+
+// moveAlertCursor moves the active alert selection by delta
+func (a *App) moveAlertCursor(delta int) {
+	n := len(a.activeAlerts)
+	if n == 0 {
+		return
+	}
+	a.alertCursor = (a.alertCursor + delta + n) % n
+	a.restoreAlert = "" // the user has chosen for themselves
+}
+
+// selectedAlert returns the highlighted active alert
+func (a *App) selectedAlert() (core.Alert, bool) {
+	if a.alerts == nil || a.alertCursor >= len(a.activeAlerts) {
+		return core.Alert{}, false
+	}
+	return a.activeAlerts[a.alertCursor], true
+}
+
+// acknowledgeAlert acknowledges the highlighted alert
+func (a *App) acknowledgeAlert() tea.Cmd {
+	alert, ok := a.selectedAlert()
+	if !ok {
+		return nil
+	}
+
+	e := a.alerts
+	return func() tea.Msg {
+		return alertActionMsg{alert: alert, action: "Acknowledged", err: e.Acknowledge(alert.Key())}
+	}
+}
+
+// silenceAlert silences the highlighted alert for d
+func (a *App) silenceAlert(d time.Duration) tea.Cmd {
+	alert, ok := a.selectedAlert()
+	if !ok {
+		return nil
+	}
+
+	e := a.alerts
+	return func() tea.Msg {
+		return alertActionMsg{
+			alert:  alert,
+			action: fmt.Sprintf("Silenced for %s", units.Duration(d)),
+			err:    e.Silence(alert.Key(), d),
+		}
+	}
+}
+
+// handleAlertAction records the result and refreshes the panel immediately
+func (a *App) handleAlertAction(msg alertActionMsg) {
+	if msg.err != nil {
+		a.alertNotice = ErrorStyle.Render(fmt.Sprintf("%s %s: %v", IconCross, msg.alert.Rule, msg.err))
+		return
+	}
+	a.alertNotice = HelpDescStyle.Render(fmt.Sprintf("%s %s", msg.action, msg.alert.Key()))
+	a.handleAlerts(AlertsMsg{Active: a.alerts.Active(), Recent: a.alerts.Recent()})
+}
+
+// renderAlertsPanel lists active alerts with the selection highlighted,
+// followed by the most recently resolved ones
+func (a *App) renderAlertsPanel(l layout) string {
+	if a.alerts == nil || (len(a.activeAlerts) == 0 && len(a.recentAlerts) == 0) {
+		return ""
+	}
+
+	now := time.Now()
+	lines := []string{StatusStoppedStyle.Render(fmt.Sprintf("%s Alerts (%d active)", IconCross, len(a.activeAlerts)))}
+
+	for i, alert := range a.activeAlerts {
+		marker := "  "
+		if i == a.alertCursor {
+			marker = HelpKeyStyle.Render("> ")
+		}
+
+		var flags string
+		if alert.Acknowledged() {
+			flags += HelpDescStyle.Render("  ack")
+		}
+		if alert.Silenced(now) {
+			flags += HelpDescStyle.Render(fmt.Sprintf("  silenced until %s", units.Time(alert.SilencedUntil)))
+		}
+
+		style := StatusStoppedStyle
+		if alert.Acknowledged() {
+			style = StatusReadyStyle
+		}
+		lines = append(lines, marker+style.Render(alert.Rule)+"  "+alert.Message+flags)
+	}
+
+	recent := a.recentAlerts
+	if len(recent) > maxRecentShown {
+		recent = recent[len(recent)-maxRecentShown:]
+	}
+	if len(recent) > 0 {
+		lines = append(lines, "", HelpDescStyle.Render("Recently resolved"))
+		for i := len(recent) - 1; i >= 0; i-- {
+			r := recent[i]
+			lines = append(lines, HelpDescStyle.Render(fmt.Sprintf("  %s  %s  %s", units.Time(r.ResolvedAt), r.Rule, r.Subject)))
+		}
+	}
+
+	if len(a.activeAlerts) > 0 {
+		lines = append(lines, "",
+			HelpKeyStyle.Render("a")+HelpDescStyle.Render(" acknowledge")+
+				HelpSeparatorStyle.Render("  •  ")+
+				HelpKeyStyle.Render("s")+HelpDescStyle.Render(" silence 1h")+
+				HelpSeparatorStyle.Render("  •  ")+
+				HelpKeyStyle.Render("S")+HelpDescStyle.Render(" silence 1d")+
+				HelpSeparatorStyle.Render("  •  ")+
+				HelpKeyStyle.Render("↑/↓")+HelpDescStyle.Render(" select"))
+	}
+
+	box := BoxStyle.
+		BorderForeground(ColorDanger).
+		Width(l.panelWidth).
+		Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+
+	if a.alertNotice != "" {
+		box = lipgloss.JoinVertical(lipgloss.Center, box, a.alertNotice)
+	}
+	return box
+}
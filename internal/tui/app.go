@@ -5,9 +5,13 @@ import (
 	"os/exec"
 	"runtime"
 	"strings"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/jedarden/tunnel/internal/changelog"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/logging"
 	"github.com/jedarden/tunnel/pkg/version"
 )
 
@@ -19,6 +23,7 @@ const (
 	ServerRunning
 	ServerError
 	ServerStopped
+	ServerAttached // another instance owns the web server
 )
 
 // App is the minimal TUI application model
@@ -26,6 +31,10 @@ type App struct {
 	width  int
 	height int
 
+	// Panel geometry, recomputed once resizes settle
+	layout    layout
+	resizeSeq int
+
 	// Web server state
 	serverStatus  WebServerStatus
 	serverPort    int
@@ -33,6 +42,101 @@ type App struct {
 	serverError   error
 	connections   int
 	browserOpened bool
+
+	// Access approval state
+	approvals      *core.ApprovalQueue
+	pending        []core.AccessRequest
+	approvalNotice string
+
+	// Alerts panel state
+	alerts       *core.AlertEngine
+	activeAlerts []core.Alert
+	recentAlerts []core.Alert
+	alertCursor  int
+	alertNotice  string
+
+	// Connection budget, polled from the connection manager
+	budgetSource func() core.ConnectionBudget
+	budget       core.ConnectionBudget
+
+	// Forwards of local services, polled from the forwarding manager
+	forwardSource func() []core.ForwardStatus
+	forwards      []core.ForwardStatus
+
+	// Bandwidth limited tunnels, polled from their relays
+	throttleSource func() []core.ThrottleStatus
+	throttles      []core.ThrottleStatus
+	toggleCapture  func() (string, error)
+	captureNotice  string
+
+	// Connection mode, switched with m
+	modeSource func() string
+	cycleMode  func() (string, error)
+	modeNotice string
+
+	// Profile picker state
+	profileSource func() []Profile
+	applyProfile  func(name string) error
+	profiles      []Profile
+	profileCursor int
+	picking       bool
+	profileNotice string
+
+	// Dashboard widgets in the order shown; nil shows DefaultWidgets
+	widgetOrder []string
+
+	// Keys expired or expiring soon, polled for the key expirations widget
+	keyExpirySource func() ([]core.SSHPublicKey, error)
+	expiringKeys    []core.SSHPublicKey
+	keyExpiryErr    error
+
+	// What's new screen: releases not seen yet, shown until dismissed,
+	// and the newest release, remembered as seen once they are
+	unseenReleases []changelog.Release
+	newestRelease  string
+	changelogSeen  string
+	whatsNewScroll int
+
+	// Transfer rates for the bandwidth graph, polled from the connection
+	// manager; w cycles the window graphed and b the connection
+	throughputSource func(since time.Time) []core.ConnectionThroughput
+	throughput       []core.ConnectionThroughput
+	graphWindow      core.AggregationWindow
+	graphConn        string // connection graphed, "" for all of them
+	graphStyle       string
+
+	// Keyboard macros: the saved ones, and the recording in progress
+	macros         Macros
+	saveMacros     func(Macros) error
+	armingMacro    bool   // R was pressed; the next key picks the slot
+	recordingMacro string // slot being recorded
+	recordedKeys   []string
+	replaying      bool
+	macroNotice    string
+
+	// Split layout state: the pane with focus, what the side pane shows,
+	// and the live log of connection events and log entries
+	focus     pane
+	sideView  sideView
+	eventFeed <-chan *core.ConnectionEvent
+	logFeed   <-chan logging.Entry
+	logLines  []string
+	logScroll int // lines scrolled back from the newest
+
+	// Events screen, opened with e: connection events and audit events as
+	// they happen, filtered by type and provider
+	showingEvents       bool
+	eventLog            *core.EventLogger
+	connProviders       map[string]string // provider of each connection seen
+	auditFeed           <-chan core.AuditEvent
+	auditEvents         []core.AuditEvent
+	eventTypeFilter     string
+	eventProviderFilter string
+	eventScroll         int // rows scrolled back from the newest
+
+	// restoreAlert is the key of the alert to select once it is polled,
+	// from the state saved by the last run
+	restoreAlert string
 }
 
 // ServerStatusMsg updates the server status
@@ -52,37 +156,54 @@ func NewApp(port int) *App {
 		serverStatus: ServerStarting,
 		serverPort:   port,
 		serverURL:    fmt.Sprintf("http://localhost:%d", port),
+		graphWindow:  core.Window5m,
+		graphStyle:   GraphBraille,
+
+		eventLog:      core.NewEventLogger(maxEvents),
+		connProviders: make(map[string]string),
 	}
 }
 
 // Init initializes the application
 func (a *App) Init() tea.Cmd {
-	return nil
+	var cmds []tea.Cmd
+	if a.approvals != nil {
+		cmds = append(cmds, a.pollApprovals())
+	}
+	if a.alerts != nil {
+		cmds = append(cmds, a.pollAlerts())
+	}
+	if a.forwardSource != nil {
+		a.forwards = a.forwardSource()
+		cmds = append(cmds, a.pollForwards())
+	}
+	if a.throttleSource != nil {
+		a.throttles = a.throttleSource()
+		cmds = append(cmds, a.pollThrottles())
+	}
+	if a.keyExpirySource != nil {
+		cmds = append(cmds, a.readKeyExpirations(0))
+	}
+	return tea.Batch(cmds...)
 }
 
 // Update handles messages and updates the model
 func (a *App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
-		switch msg.String() {
-		case "ctrl+c", "q":
-			return a, tea.Quit
-
-		case "o":
-			// Open browser
-			if a.serverStatus == ServerRunning {
-				a.openBrowser()
-			}
-			return a, nil
-
-		case "r":
-			// Refresh - could trigger a status update
-			return a, nil
+		if cmd, ok := a.handleMacroKey(msg.String()); ok {
+			return a, cmd
 		}
+		return a, a.handleKey(msg.String())
 
 	case tea.WindowSizeMsg:
-		a.width = msg.Width
-		a.height = msg.Height
+		return a, a.handleResize(msg)
+
+	case resizeSettledMsg:
+		return a, a.relayout(msg)
+
+	case layoutMsg:
+		a.applyLayout(msg)
 		return a, nil
 
 	case ServerStatusMsg:
@@ -97,27 +218,279 @@ func (a *App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		a.serverError = msg.Error
 		a.connections = msg.Connections
 		return a, nil
+
+	case ApprovalsMsg:
+		if msg.Error == nil {
+			a.pending = msg.Requests
+		}
+		return a, a.pollApprovals()
+
+	case approvalDecisionMsg:
+		a.handleApprovalDecision(msg)
+		return a, nil
+
+	case AlertEngineMsg:
+		// Only start polling the first time an engine arrives
+		first := a.alerts == nil
+		a.alerts = msg.Engine
+		if first && a.alerts != nil {
+			return a, a.pollAlerts()
+		}
+		return a, nil
+
+	case AlertsMsg:
+		a.handleAlerts(msg)
+		return a, a.pollAlerts()
+
+	case ConnectionBudgetMsg:
+		first := a.budgetSource == nil
+		a.budgetSource = msg.Budget
+		if first && a.budgetSource != nil {
+			a.budget = a.budgetSource()
+			return a, a.pollBudget()
+		}
+		return a, nil
+
+	case budgetMsg:
+		a.budget = msg.budget
+		return a, a.pollBudget()
+
+	case forwardsMsg:
+		a.forwards = msg.forwards
+		return a, a.pollForwards()
+
+	case throttlesMsg:
+		a.throttles = msg.throttles
+		return a, a.pollThrottles()
+
+	case captureToggledMsg:
+		a.handleCaptureToggled(msg)
+		return a, nil
+
+	case profileAppliedMsg:
+		a.handleProfileApplied(msg)
+		return a, nil
+
+	case modeChangedMsg:
+		a.handleModeChanged(msg)
+		return a, nil
+
+	case ThroughputMsg:
+		first := a.throughputSource == nil
+		a.throughputSource = msg.Throughput
+		if first && a.throughputSource != nil {
+			return a, a.readThroughput(0)
+		}
+		return a, nil
+
+	case throughputMsg:
+		a.throughput = msg.conns
+		return a, a.readThroughput(throughputPollInterval)
+
+	case keyExpiryMsg:
+		a.handleKeyExpiry(msg)
+		return a, a.readKeyExpirations(keyExpiryPollInterval)
+
+	case macroSavedMsg:
+		a.handleMacroSaved(msg)
+		return a, nil
+
+	case alertActionMsg:
+		a.handleAlertAction(msg)
+		return a, nil
+
+	case EventFeedMsg:
+		first := a.eventFeed == nil
+		a.eventFeed = msg.Events
+		if first && a.eventFeed != nil {
+			return a, a.waitForEvent()
+		}
+		return a, nil
+
+	case eventMsg:
+		a.handleEvent(msg.event)
+		return a, a.waitForEvent()
+
+	case LogFeedMsg:
+		first := a.logFeed == nil
+		a.logFeed = msg.Entries
+		if first && a.logFeed != nil {
+			return a, a.waitForLog()
+		}
+		return a, nil
+
+	case logMsg:
+		a.appendLogLine(msg.entry.String())
+		return a, a.waitForLog()
+
+	case AuditFeedMsg:
+		first := a.auditFeed == nil
+		a.auditFeed = msg.Events
+		if first && a.auditFeed != nil {
+			return a, a.waitForAudit()
+		}
+		return a, nil
+
+	case auditMsg:
+		a.handleAudit(msg.event)
+		return a, a.waitForAudit()
 	}
 
 	return a, nil
 }
 
+// handleKey acts on a key press, typed or replayed from a macro
+func (a *App) handleKey(key string) tea.Cmd {
+	if a.showingWhatsNew() {
+		if cmd, ok := a.handleWhatsNewKey(key); ok {
+			return cmd
+		}
+	}
+	if a.showingEvents {
+		if cmd, ok := a.handleEventsKey(key); ok {
+			return cmd
+		}
+	}
+	if a.picking {
+		if cmd, ok := a.handlePickerKey(key); ok {
+			return cmd
+		}
+	}
+	switch key {
+	case "ctrl+c", "q":
+		return tea.Quit
+
+	case "o":
+		// Open browser
+		if a.serverStatus == ServerRunning || a.serverStatus == ServerAttached {
+			a.openBrowser()
+		}
+		return nil
+
+	case "r":
+		// Refresh - could trigger a status update
+		return nil
+
+	case "y":
+		return a.decideApproval(true)
+
+	case "n":
+		return a.decideApproval(false)
+
+	case "a":
+		return a.acknowledgeAlert()
+
+	case "s":
+		return a.silenceAlert(time.Hour)
+
+	case "S":
+		return a.silenceAlert(24 * time.Hour)
+
+	case "p":
+		a.togglePicker()
+		return nil
+
+	case "c":
+		return a.toggleCaptureCmd()
+
+	case "m":
+		return a.cycleModeCmd()
+
+	case "w":
+		a.cycleGraphWindow()
+		return nil
+
+	case "b":
+		a.cycleGraphConnection()
+		return nil
+
+	case "ctrl+w":
+		a.switchFocus()
+		return nil
+
+	case "e":
+		a.showingEvents = a.eventsAvailable()
+		return nil
+
+	case "d":
+		if a.currentLayout().split {
+			a.toggleSideView()
+		}
+		return nil
+
+	case "up", "k":
+		if a.sideFocused() && a.sideView == sideLogs {
+			a.scrollLog(1)
+			return nil
+		}
+		a.moveAlertCursor(-1)
+		return nil
+
+	case "down", "j":
+		if a.sideFocused() && a.sideView == sideLogs {
+			a.scrollLog(-1)
+			return nil
+		}
+		a.moveAlertCursor(1)
+		return nil
+	}
+	return nil
+}
+
 // View renders the application UI
 func (a *App) View() string {
+	if a.tooSmall() {
+		return a.renderTooSmall()
+	}
+	if a.showingWhatsNew() {
+		return a.renderWhatsNew()
+	}
+	if a.showingEvents {
+		return a.renderEvents()
+	}
+
+	l := a.currentLayout()
 	var b strings.Builder
 
 	// Header
 	header := a.renderHeader()
 	b.WriteString(header)
-	b.WriteString("\n\n")
+	b.WriteString(l.gap)
+
+	// Profile picker, or the outcome of the last switch
+	if picker := a.renderProfilePicker(l); picker != "" {
+		b.WriteString(picker)
+		b.WriteString(l.gap)
+	}
+
+	// Pending access requests wait on a key press, so they come before
+	// whatever widgets are picked
+	if prompt := a.renderApprovalPrompt(l); prompt != "" {
+		b.WriteString(prompt)
+		b.WriteString(l.gap)
+	}
+
+	// Dashboard widgets, in the configured order
+	for _, name := range a.dashboardWidgets() {
+		if panel := widgets[name].Render(a, l); panel != "" {
+			b.WriteString(panel)
+			b.WriteString(l.gap)
+		}
+	}
 
-	// Server status box
-	statusBox := a.renderStatusBox()
-	b.WriteString(statusBox)
-	b.WriteString("\n\n")
+	// Macro being recorded, or the last one run
+	if status := a.renderMacroStatus(); status != "" {
+		b.WriteString(status)
+		b.WriteString(l.gap)
+	}
 
 	// Footer with controls
-	footer := a.renderFooter()
+	footer := a.renderFooter(l)
+
+	// Wide terminals get the side pane next to everything above
+	if l.split {
+		return a.renderSplit(l, strings.TrimSuffix(b.String(), l.gap), footer)
+	}
 	b.WriteString(footer)
 
 	// Center content vertically
@@ -146,7 +519,7 @@ func (a *App) renderHeader() string {
 }
 
 // renderStatusBox renders the server status
-func (a *App) renderStatusBox() string {
+func (a *App) renderStatusBox(l layout) string {
 	var statusLine, urlLine, connectionsLine string
 
 	switch a.serverStatus {
@@ -157,7 +530,16 @@ func (a *App) renderStatusBox() string {
 		statusLine = StatusConnectedStyle.Render(IconConnected + " Web server running")
 		urlLine = "\n\n" + InfoStyle.Render("Open in browser:") + "\n" +
 			TitleStyle.Render(a.serverURL)
-		connectionsLine = "\n\n" + HelpDescStyle.Render(fmt.Sprintf("Active connections: %d", a.connections))
+		connectionsLine = "\n\n" + a.renderConnections()
+		if mode := a.renderMode(); mode != "" {
+			connectionsLine += "\n" + mode
+		}
+
+	case ServerAttached:
+		statusLine = StatusConnectedStyle.Render(IconConnected + " Attached to running instance")
+		urlLine = "\n\n" + InfoStyle.Render("Open in browser:") + "\n" +
+			TitleStyle.Render(a.serverURL)
+		connectionsLine = "\n\n" + HelpDescStyle.Render("Quitting leaves the running instance up")
 
 	case ServerError:
 		statusLine = StatusStoppedStyle.Render(IconCross + " Server error")
@@ -172,24 +554,52 @@ func (a *App) renderStatusBox() string {
 	content := statusLine + urlLine + connectionsLine
 
 	// Create a centered box
-	boxWidth := 50
-	if a.width < 60 {
-		boxWidth = a.width - 4
-	}
-
 	return BoxStyle.
-		Width(boxWidth).
+		Width(l.statusWidth).
 		Align(lipgloss.Center).
 		Render(content)
 }
 
 // renderFooter renders the control hints
-func (a *App) renderFooter() string {
+func (a *App) renderFooter(l layout) string {
 	var hints []string
 
-	if a.serverStatus == ServerRunning {
+	if a.serverStatus == ServerRunning || a.serverStatus == ServerAttached {
 		hints = append(hints, HelpKeyStyle.Render("o")+HelpDescStyle.Render(" open browser"))
 	}
+	if a.profileSource != nil {
+		hints = append(hints, HelpKeyStyle.Render("p")+HelpDescStyle.Render(" profiles"))
+	}
+	if a.toggleCapture != nil && len(a.throttles) > 0 {
+		hints = append(hints, HelpKeyStyle.Render("c")+HelpDescStyle.Render(" capture"))
+	}
+	if a.cycleMode != nil {
+		hints = append(hints, HelpKeyStyle.Render("m")+HelpDescStyle.Render(" mode"))
+	}
+	if a.graphShown() {
+		hints = append(hints, HelpKeyStyle.Render("w")+HelpDescStyle.Render(" graph window"))
+		if len(a.throughput) > 1 {
+			hints = append(hints, HelpKeyStyle.Render("b")+HelpDescStyle.Render(" graph connection"))
+		}
+	}
+	if a.saveMacros != nil {
+		if slots := a.macroSlots(); len(slots) > 0 {
+			hints = append(hints, HelpKeyStyle.Render(strings.Join(slots, ","))+HelpDescStyle.Render(" run macro"))
+		}
+		hints = append(hints, HelpKeyStyle.Render("R")+HelpDescStyle.Render(" record macro"))
+	}
+	if a.eventsAvailable() {
+		hints = append(hints, HelpKeyStyle.Render("e")+HelpDescStyle.Render(" events"))
+	}
+	if l.split {
+		other := "detail"
+		if a.sideView == sideDetail {
+			other = "live log"
+		}
+		hints = append(hints,
+			HelpKeyStyle.Render("ctrl+w")+HelpDescStyle.Render(" switch pane"),
+			HelpKeyStyle.Render("d")+HelpDescStyle.Render(" "+other))
+	}
 	hints = append(hints, HelpKeyStyle.Render("q")+HelpDescStyle.Render(" quit"))
 
 	return lipgloss.JoinHorizontal(
@@ -0,0 +1,129 @@
+package tui
+
+import (
+	"fmt"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/units"
+)
+
+// approvalPollInterval is how often the request folder is rescanned
+const approvalPollInterval = 2 * time.Second
+
+// ApprovalsMsg carries the current set of pending access requests
+type ApprovalsMsg struct {
+	Requests []core.AccessRequest
+	Error    error
+}
+
+// approvalDecisionMsg reports the outcome of an approve/deny keypress
+type approvalDecisionMsg struct {
+	request  core.AccessRequest
+	approved bool
+	err      error
+}
+
+// SetApprovalQueue enables the access approval prompt
+func (a *App) SetApprovalQueue(q *core.ApprovalQueue) {
+	a.approvals = q
+}
+
+// pollApprovals rescans the queue after the poll interval
+func (a *App) pollApprovals() tea.Cmd {
+	q := a.approvals
+	return tea.Tick(approvalPollInterval, func(time.Time) tea.Msg {
+		requests, err := q.Pending()
+		return ApprovalsMsg{Requests: requests, Error: err}
+	})
+}
+
+// decideApproval approves or denies the request at the head of the queue
+func (a *App) decideApproval(approve bool) tea.Cmd {
+	if a.approvals == nil || len(a.pending) == 0 {
+		return nil
+	}
+
+	q := a.approvals
+	req := a.pending[0]
+	return func() tea.Msg {
+		var err error
+		if approve {
+			_, err = q.Approve(req.ID)
+		} else {
+			err = q.Deny(req.ID)
+		}
+		return approvalDecisionMsg{request: req, approved: approve, err: err}
+	}
+}
+
+// handleApprovalDecision records the result and drops the request locally
+func (a *App) handleApprovalDecision(msg approvalDecisionMsg) {
+	if msg.err != nil {
+		a.approvalNotice = ErrorStyle.Render(fmt.Sprintf("%s %s: %v", IconCross, msg.request.User, msg.err))
+		return
+	}
+
+	for i, req := range a.pending {
+		if req.ID == msg.request.ID {
+			a.pending = append(a.pending[:i], a.pending[i+1:]...)
+			break
+		}
+	}
+
+	if msg.approved {
+		a.approvalNotice = StatusConnectedStyle.Render(fmt.Sprintf("%s Approved access for %s", IconConnected, msg.request.User))
+	} else {
+		a.approvalNotice = StatusStoppedStyle.Render(fmt.Sprintf("%s Denied access for %s", IconStopped, msg.request.User))
+	}
+}
+
+// renderApprovalPrompt renders the request at the head of the queue
+func (a *App) renderApprovalPrompt(l layout) string {
+	if len(a.pending) == 0 {
+		if a.approvalNotice == "" {
+			return ""
+		}
+		return a.approvalNotice
+	}
+
+	req := a.pending[0]
+	duration := "permanent"
+	if d := req.Duration(); d > 0 {
+		duration = units.Duration(d)
+	}
+
+	title := StatusReadyStyle.Render(IconReady + " Access request")
+	if len(a.pending) > 1 {
+		title += HelpDescStyle.Render(fmt.Sprintf("  (1 of %d)", len(a.pending)))
+	}
+
+	row := func(label, value string) string {
+		return HelpDescStyle.Render(fmt.Sprintf("%-12s", label)) + value
+	}
+
+	content := lipgloss.JoinVertical(lipgloss.Left,
+		title,
+		"",
+		row("User", req.User),
+		row("Fingerprint", req.Fingerprint),
+		row("Duration", duration),
+		row("Source", req.Source),
+		"",
+		HelpKeyStyle.Render("y")+HelpDescStyle.Render(" approve")+
+			HelpSeparatorStyle.Render("  •  ")+
+			HelpKeyStyle.Render("n")+HelpDescStyle.Render(" deny"),
+	)
+
+	box := BoxStyle.
+		BorderForeground(ColorWarning).
+		Width(l.panelWidth).
+		Render(content)
+
+	if a.approvalNotice != "" {
+		box = lipgloss.JoinVertical(lipgloss.Center, box, a.approvalNotice)
+	}
+	return box
+}
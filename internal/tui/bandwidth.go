@@ -0,0 +1,116 @@
+package tui
+
+import (
+	"fmt"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/units"
+)
+
+// throttlePollInterval is how often the bandwidth panel refreshes; the
+// rates themselves average over a few seconds
+const throttlePollInterval = time.Second
+
+// throttlesMsg carries the polled bandwidth relays
+type throttlesMsg struct {
+	throttles []core.ThrottleStatus
+}
+
+// SetThrottles enables the bandwidth panel, showing the limits and live
+// rates of what source returns
+func (a *App) SetThrottles(source func() []core.ThrottleStatus) {
+	a.throttleSource = source
+}
+
+// pollThrottles reads the relays after the poll interval
+func (a *App) pollThrottles() tea.Cmd {
+	source := a.throttleSource
+	return tea.Tick(throttlePollInterval, func(time.Time) tea.Msg {
+		return throttlesMsg{throttles: source()}
+	})
+}
+
+// captureToggledMsg reports the outcome of toggling a capture
+type captureToggledMsg struct {
+	notice string
+	err    error
+}
+
+// SetCapture enables toggling a capture of the relayed tunnels with c;
+// toggle starts or stops it and describes what it did
+func (a *App) SetCapture(toggle func() (string, error)) {
+	a.toggleCapture = toggle
+}
+
+// toggleCaptureCmd starts or stops the capture off the UI goroutine, as
+// closing a capture writes out its file
+func (a *App) toggleCaptureCmd() tea.Cmd {
+	if a.toggleCapture == nil || len(a.throttles) == 0 {
+		return nil
+	}
+	toggle := a.toggleCapture
+	return func() tea.Msg {
+		notice, err := toggle()
+		return captureToggledMsg{notice: notice, err: err}
+	}
+}
+
+func (a *App) handleCaptureToggled(msg captureToggledMsg) {
+	if msg.err != nil {
+		a.captureNotice = ErrorStyle.Render(IconCross + " " + msg.err.Error())
+		return
+	}
+	a.captureNotice = InfoStyle.Render(msg.notice)
+}
+
+// renderBandwidthPanel shows each relayed tunnel's upload and download
+// rate against its limits and any capture running, or nothing when no
+// tunnel is relayed
+func (a *App) renderBandwidthPanel(l layout) string {
+	if a.throttleSource == nil || len(a.throttles) == 0 {
+		return ""
+	}
+
+	lines := []string{InfoStyle.Render("Bandwidth")}
+	for _, th := range a.throttles {
+		name := HelpDescStyle.Render(IconStopped + " " + th.Provider)
+		if th.Connections > 0 {
+			name = StatusConnectedStyle.Render(IconConnected + " " + th.Provider)
+		}
+		line := fmt.Sprintf("%s  ↑ %s  ↓ %s", name,
+			rateAgainstLimit(th.UploadKbps, th.Limits.UploadKbps),
+			rateAgainstLimit(th.DownloadKbps, th.Limits.DownloadKbps))
+		line += HelpDescStyle.Render(fmt.Sprintf("  %s out, %s in", units.Bytes(th.BytesSent), units.Bytes(th.BytesReceived)))
+		if c := th.Capture; c != nil {
+			rec := fmt.Sprintf("  ● capturing, %s", units.Bytes(c.Bytes))
+			if c.Truncated {
+				rec += " (size limit reached)"
+			}
+			line += StatusStoppedStyle.Render(rec)
+		}
+		lines = append(lines, line)
+	}
+	if a.captureNotice != "" {
+		lines = append(lines, a.captureNotice)
+	}
+
+	return BoxStyle.
+		Width(l.panelWidth).
+		Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
+
+// rateAgainstLimit formats a rate and its limit in kbps, warning once the
+// rate nears the limit
+func rateAgainstLimit(rate float64, limit int) string {
+	if limit <= 0 {
+		return fmt.Sprintf("%.0f kbps", rate)
+	}
+	s := fmt.Sprintf("%.0f/%d kbps", rate, limit)
+	if rate >= 0.9*float64(limit) {
+		return StatusReadyStyle.Render(s)
+	}
+	return s
+}
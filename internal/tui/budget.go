@@ -0,0 +1,49 @@
+package tui
+
+import (
+	"fmt"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/jedarden/tunnel/internal/core"
+)
+
+// budgetPollInterval is how often the connection count refreshes
+const budgetPollInterval = 2 * time.Second
+
+// ConnectionBudgetMsg hands the TUI the source of the connection budget once
+// the connection manager exists
+type ConnectionBudgetMsg struct {
+	Budget func() core.ConnectionBudget
+}
+
+// budgetMsg carries a polled connection budget
+type budgetMsg struct {
+	budget core.ConnectionBudget
+}
+
+// pollBudget reads the connection budget after the poll interval
+func (a *App) pollBudget() tea.Cmd {
+	source := a.budgetSource
+	return tea.Tick(budgetPollInterval, func(time.Time) tea.Msg {
+		return budgetMsg{budget: source()}
+	})
+}
+
+// renderConnections renders the connection count against the budget and
+// a warning once the budget is used up
+func (a *App) renderConnections() string {
+	if a.budgetSource == nil {
+		return HelpDescStyle.Render(fmt.Sprintf("Active connections: %d", a.connections))
+	}
+
+	b := a.budget
+	if b.MaxConnections == 0 {
+		return HelpDescStyle.Render(fmt.Sprintf("Active connections: %d", b.Connections))
+	}
+	line := HelpDescStyle.Render(fmt.Sprintf("Active connections: %d/%d", b.Connections, b.MaxConnections))
+	if b.Exhausted() {
+		line += "\n" + StatusReadyStyle.Render(IconCross+" Connection limit reached")
+	}
+	return line
+}
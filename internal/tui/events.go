@@ -0,0 +1,242 @@
+package tui
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/units"
+)
+
+// maxEvents bounds the connection and audit events kept for the events
+// screen, each
+const maxEvents = 1000
+
+// AuditFeedMsg hands the TUI a subscription to the audit log, whose events
+// join connection events on the events screen
+type AuditFeedMsg struct {
+	Events <-chan core.AuditEvent
+}
+
+// auditMsg carries one audit event from the feed
+type auditMsg struct {
+	event core.AuditEvent
+}
+
+// waitForAudit delivers the next audit event; a closed feed ends the wait
+func (a *App) waitForAudit() tea.Cmd {
+	feed := a.auditFeed
+	return func() tea.Msg {
+		event, ok := <-feed
+		if !ok {
+			return nil
+		}
+		return auditMsg{event: event}
+	}
+}
+
+// recordEvent keeps a connection event for the events screen, learning
+// the provider of its connection when the event says
+func (a *App) recordEvent(event *core.ConnectionEvent) {
+	if conn, ok := event.Data.(*core.Connection); ok && event.ConnID != "" {
+		a.connProviders[event.ConnID] = conn.Method
+	}
+	a.eventLog.Log(event)
+	a.followEvents()
+}
+
+// handleAudit keeps an audit event for the events screen. Connection
+// events the audit log records are there already.
+func (a *App) handleAudit(event core.AuditEvent) {
+	if event.FromConnectionEvent() {
+		return
+	}
+	a.auditEvents = append(a.auditEvents, event)
+	if len(a.auditEvents) > maxEvents {
+		a.auditEvents = a.auditEvents[len(a.auditEvents)-maxEvents:]
+	}
+	a.followEvents()
+}
+
+// followEvents keeps a scrolled back events screen where it is as new
+// events arrive
+func (a *App) followEvents() {
+	if a.eventScroll > 0 {
+		a.eventScroll++
+	}
+}
+
+// eventRow is a connection or audit event as the events screen lists it
+type eventRow struct {
+	at       time.Time
+	kind     string // event type, e.g. Failover or key_added
+	provider string
+	subject  string // connection ID, or the user of an audit event
+	message  string
+	failed   bool
+}
+
+// eventRows merges the kept connection and audit events, oldest first
+func (a *App) eventRows() []eventRow {
+	var rows []eventRow
+	for _, e := range a.eventLog.GetRecent(0) {
+		rows = append(rows, eventRow{
+			at:       e.Timestamp,
+			kind:     e.Type.String(),
+			provider: a.connProviders[e.ConnID],
+			subject:  e.ConnID,
+			message:  e.Message,
+			failed:   e.Type == core.EventError,
+		})
+	}
+	for _, e := range a.auditEvents {
+		rows = append(rows, eventRow{
+			at:       e.Timestamp,
+			kind:     e.EventType,
+			provider: e.Method,
+			subject:  e.User,
+			message:  auditDetails(e.Details),
+			failed:   !e.Success,
+		})
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].at.Before(rows[j].at) })
+	return rows
+}
+
+// auditDetails renders the details of an audit event as sorted key=value
+// pairs
+func auditDetails(details map[string]interface{}) string {
+	keys := make([]string, 0, len(details))
+	for k := range details {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = fmt.Sprintf("%s=%v", k, details[k])
+	}
+	return strings.Join(pairs, " ")
+}
+
+// filteredEventRows returns the rows matching the type and provider filters
+func (a *App) filteredEventRows() []eventRow {
+	var rows []eventRow
+	for _, row := range a.eventRows() {
+		if a.eventTypeFilter != "" && row.kind != a.eventTypeFilter {
+			continue
+		}
+		if a.eventProviderFilter != "" && row.provider != a.eventProviderFilter {
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// nextFilter returns the value after current among the values field takes
+// in rows, sorted; after the last comes "", no filter
+func nextFilter(rows []eventRow, field func(eventRow) string, current string) string {
+	var values []string
+	for _, row := range rows {
+		if v := field(row); v != "" && !slices.Contains(values, v) {
+			values = append(values, v)
+		}
+	}
+	sort.Strings(values)
+	i := slices.Index(values, current)
+	if i+1 >= len(values) {
+		return ""
+	}
+	return values[i+1]
+}
+
+// eventsAvailable reports whether there are feeds for the events screen
+func (a *App) eventsAvailable() bool {
+	return a.eventFeed != nil || a.auditFeed != nil
+}
+
+// handleEventsKey handles a key while the events screen is up. Only
+// quitting gets past it.
+func (a *App) handleEventsKey(key string) (tea.Cmd, bool) {
+	switch key {
+	case "ctrl+c", "q":
+		return nil, false
+	case "esc", "e":
+		a.showingEvents = false
+	case "t":
+		a.eventTypeFilter = nextFilter(a.eventRows(), func(r eventRow) string { return r.kind }, a.eventTypeFilter)
+		a.eventScroll = 0
+	case "p":
+		a.eventProviderFilter = nextFilter(a.eventRows(), func(r eventRow) string { return r.provider }, a.eventProviderFilter)
+		a.eventScroll = 0
+	case "up", "k":
+		a.eventScroll++
+	case "down", "j":
+		a.eventScroll = max(a.eventScroll-1, 0)
+	}
+	return nil, true
+}
+
+// renderEvents draws the events matching the filters, newest at the
+// bottom, scrolled to fit the terminal
+func (a *App) renderEvents() string {
+	l := a.currentLayout()
+	header := a.renderHeader()
+	footer := strings.Join([]string{
+		HelpKeyStyle.Render("t") + HelpDescStyle.Render(" type"),
+		HelpKeyStyle.Render("p") + HelpDescStyle.Render(" provider"),
+		HelpKeyStyle.Render("↑/↓") + HelpDescStyle.Render(" scroll"),
+		HelpKeyStyle.Render("esc") + HelpDescStyle.Render(" back"),
+		HelpKeyStyle.Render("q") + HelpDescStyle.Render(" quit"),
+	}, HelpSeparatorStyle.Render("  •  "))
+
+	width := max(l.width-4, 20)
+	filter := func(value string) string {
+		if value == "" {
+			return "all"
+		}
+		return value
+	}
+	title := InfoStyle.Render("Events") + HelpDescStyle.Render(fmt.Sprintf("  type: %s  provider: %s",
+		filter(a.eventTypeFilter), filter(a.eventProviderFilter)))
+
+	rows := a.filteredEventRows()
+	// The box's border and padding take four lines, its title two
+	room := max(a.height-lipgloss.Height(header+l.gap+l.gap+footer)-6, 1)
+	a.eventScroll = min(a.eventScroll, max(len(rows)-room, 0))
+	end := len(rows) - a.eventScroll
+	shown := rows[max(end-room, 0):end]
+
+	clip := lipgloss.NewStyle().MaxWidth(width - 4)
+	lines := []string{title, ""}
+	if len(rows) == 0 {
+		lines = append(lines, HelpDescStyle.Render("Waiting for events..."))
+	}
+	for _, row := range shown {
+		line := fmt.Sprintf("%s  %-22s %-12s %-16s %s",
+			units.Clock(row.at), row.kind, orDash(row.provider), orDash(row.subject), row.message)
+		style := lipgloss.NewStyle()
+		if row.failed {
+			style = ErrorStyle
+		}
+		lines = append(lines, style.Render(clip.Render(line)))
+	}
+
+	box := BoxStyle.
+		Width(width).
+		Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+	return lipgloss.Place(a.width, a.height, lipgloss.Center, lipgloss.Top,
+		header+l.gap+box+l.gap+footer)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
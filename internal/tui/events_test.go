@@ -0,0 +1,68 @@
+package tui
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/jedarden/tunnel/internal/core"
+)
+
+func TestEventsScreen(t *testing.T) {
+	a := NewApp(8080)
+	a.Update(tea.WindowSizeMsg{Width: 120, Height: 40})
+	press(a, "e")
+	if a.showingEvents {
+		t.Fatal("events screen opened without any feed")
+	}
+
+	events := make(chan *core.ConnectionEvent, 4)
+	audits := make(chan core.AuditEvent, 2)
+	a.Update(EventFeedMsg{Events: events})
+	a.Update(AuditFeedMsg{Events: audits})
+
+	conn := core.NewConnection("cf-1", "cloudflare", 22, "", 0)
+	a.Update(eventMsg{event: core.NewEvent(core.EventConnected, "cf-1", conn, "tunnel up")})
+	a.Update(eventMsg{event: core.NewEvent(core.EventConnected, "bore-1", core.NewConnection("bore-1", "bore", 22, "", 0), "")})
+	a.Update(eventMsg{event: core.NewEvent(core.EventFailover, "cf-1", map[string]string{}, "Failed over from bore-1 to cf-1")})
+	a.Update(auditMsg{event: core.AuditEvent{Timestamp: time.Now(), EventType: "key_added", Method: "ssh-key", User: "alice", Success: true}})
+	a.Update(auditMsg{event: core.AuditEvent{Timestamp: time.Now(), EventType: "connection_failover"}})
+
+	press(a, "e")
+	view := a.View()
+	for _, want := range []string{"Events", "Failed over from bore-1 to cf-1", "key_added", "alice"} {
+		if !strings.Contains(view, want) {
+			t.Errorf("events screen lacks %q:\n%s", want, view)
+		}
+	}
+	if strings.Contains(view, "connection_failover") {
+		t.Error("audit record of a connection event shown twice")
+	}
+
+	// Failover events carry no connection, but cf-1's provider is known
+	press(a, "t", "t") // Connected, then Failover
+	if a.eventTypeFilter != "Failover" {
+		t.Fatalf("type filter = %q, want Failover", a.eventTypeFilter)
+	}
+	rows := a.filteredEventRows()
+	if len(rows) != 1 || rows[0].provider != "cloudflare" {
+		t.Errorf("failover rows = %+v", rows)
+	}
+
+	press(a, "t", "t")
+	if a.eventTypeFilter != "" {
+		t.Errorf("type filter = %q after cycling through, want none", a.eventTypeFilter)
+	}
+	press(a, "p") // bore
+	if rows := a.filteredEventRows(); len(rows) != 1 || rows[0].subject != "bore-1" {
+		t.Errorf("bore rows = %+v", rows)
+	}
+
+	// Keys for the dashboard don't reach it while the screen is up
+	press(a, "m")
+	a.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	if a.showingEvents {
+		t.Error("esc did not close the events screen")
+	}
+}
@@ -0,0 +1,76 @@
+package tui
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/jedarden/tunnel/internal/core"
+)
+
+// forwardPollInterval is how often the forwards panel refreshes
+const forwardPollInterval = 2 * time.Second
+
+// forwardsMsg carries the polled forwards
+type forwardsMsg struct {
+	forwards []core.ForwardStatus
+}
+
+// SetForwards enables the forwards panel, listing what source returns
+func (a *App) SetForwards(source func() []core.ForwardStatus) {
+	a.forwardSource = source
+}
+
+// pollForwards reads the forwards after the poll interval
+func (a *App) pollForwards() tea.Cmd {
+	source := a.forwardSource
+	return tea.Tick(forwardPollInterval, func(time.Time) tea.Msg {
+		return forwardsMsg{forwards: source()}
+	})
+}
+
+// renderForwardsPanel lists the defined forwards and their state, or
+// nothing when there are none
+func (a *App) renderForwardsPanel(l layout) string {
+	if a.forwardSource == nil || len(a.forwards) == 0 {
+		return ""
+	}
+
+	running := 0
+	for _, f := range a.forwards {
+		if f.State == core.ForwardRunning {
+			running++
+		}
+	}
+	lines := []string{InfoStyle.Render(fmt.Sprintf("Forwards (%d of %d running)", running, len(a.forwards)))}
+
+	for _, f := range a.forwards {
+		route := fmt.Sprintf(":%d → %s", f.LocalPort, f.Provider)
+		if f.RemoteHost != "" || f.RemotePort != 0 {
+			route += " → " + f.RemoteHost
+			if f.RemotePort != 0 {
+				route += ":" + strconv.Itoa(f.RemotePort)
+			}
+		}
+
+		var line string
+		switch f.State {
+		case core.ForwardRunning:
+			line = StatusConnectedStyle.Render(IconConnected+" "+f.Name) + "  " + route
+			if f.Address != "" {
+				line += HelpDescStyle.Render("  " + f.Address)
+			}
+		case core.ForwardFailed:
+			line = StatusStoppedStyle.Render(IconCross+" "+f.Name) + "  " + route + ErrorStyle.Render("  "+f.Error)
+		default:
+			line = HelpDescStyle.Render(IconStopped + " " + f.Name + "  " + route)
+		}
+		lines = append(lines, line)
+	}
+
+	return BoxStyle.
+		Width(l.panelWidth).
+		Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
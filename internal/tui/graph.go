@@ -0,0 +1,285 @@
+package tui
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/units"
+)
+
+// throughputPollInterval is how often the bandwidth graph refreshes; the
+// samples behind it are taken every metrics interval
+const throughputPollInterval = 2 * time.Second
+
+// graphRows is how many lines each direction's graph takes
+const graphRows = 3
+
+// Graph styles of the bandwidth graph
+const (
+	GraphBraille = "braille" // two columns and four rows of dots a character
+	GraphBlock   = "block"   // one column of eighth blocks a character
+)
+
+// ThroughputMsg hands the TUI the source of the connections' transfer
+// rates once the connection manager exists
+type ThroughputMsg struct {
+	Throughput func(since time.Time) []core.ConnectionThroughput
+}
+
+// throughputMsg carries the polled transfer rates
+type throughputMsg struct {
+	conns []core.ConnectionThroughput
+}
+
+// SetGraphStyle picks how the bandwidth graph is drawn: GraphBraille, the
+// default, or GraphBlock for terminals without braille glyphs
+func (a *App) SetGraphStyle(style string) error {
+	switch style {
+	case "":
+		style = GraphBraille
+	case GraphBraille, GraphBlock:
+	default:
+		return fmt.Errorf("unknown graph style %q (available: %s, %s)", style, GraphBraille, GraphBlock)
+	}
+	a.graphStyle = style
+	return nil
+}
+
+// readThroughput reads the rates of the longest window, now or after the
+// poll interval, so switching windows needn't wait for a poll
+func (a *App) readThroughput(wait time.Duration) tea.Cmd {
+	source := a.throughputSource
+	longest := time.Duration(core.AggregationWindows[len(core.AggregationWindows)-1])
+	read := func(now time.Time) tea.Msg {
+		return throughputMsg{conns: source(now.Add(-longest))}
+	}
+	if wait == 0 {
+		return func() tea.Msg { return read(time.Now()) }
+	}
+	return tea.Tick(wait, read)
+}
+
+// graphShown reports whether the bandwidth graph is on the dashboard and
+// has connections to draw
+func (a *App) graphShown() bool {
+	if a.throughputSource == nil || len(a.throughput) == 0 {
+		return false
+	}
+	for _, name := range a.dashboardWidgets() {
+		if name == "bandwidth_graph" {
+			return true
+		}
+	}
+	return false
+}
+
+// cycleGraphWindow switches the graph to the next rollup window
+func (a *App) cycleGraphWindow() {
+	if !a.graphShown() {
+		return
+	}
+	for i, w := range core.AggregationWindows {
+		if w == a.graphWindow {
+			a.graphWindow = core.AggregationWindows[(i+1)%len(core.AggregationWindows)]
+			return
+		}
+	}
+	a.graphWindow = core.AggregationWindows[0]
+}
+
+// cycleGraphConnection switches the graph from all connections to each
+// one in turn and back
+func (a *App) cycleGraphConnection() {
+	if !a.graphShown() {
+		return
+	}
+	next := a.throughput[0].ID
+	for i, c := range a.throughput {
+		if c.ID != a.graphConn {
+			continue
+		}
+		next = ""
+		if i+1 < len(a.throughput) {
+			next = a.throughput[i+1].ID
+		}
+	}
+	a.graphConn = next
+}
+
+// graphedConnections returns the connections the graph sums and its title
+// for them; a graphed connection that went away falls back to all of them
+func (a *App) graphedConnections() ([]core.ConnectionThroughput, string) {
+	for _, c := range a.throughput {
+		if c.ID == a.graphConn {
+			return []core.ConnectionThroughput{c}, fmt.Sprintf("%s (%s)", c.Method, c.ID)
+		}
+	}
+	return a.throughput, "all connections"
+}
+
+// renderBandwidthGraph draws the download and upload rates over the graph
+// window, or nothing without connections
+func (a *App) renderBandwidthGraph(l layout) string {
+	if !a.graphShown() {
+		return ""
+	}
+
+	conns, title := a.graphedConnections()
+	window := time.Duration(a.graphWindow)
+	lines := []string{InfoStyle.Render("Throughput") +
+		HelpDescStyle.Render(fmt.Sprintf("  %s, last %s", title, units.Duration(window)))}
+
+	// The time axis ends at the newest sample so the right edge isn't a
+	// drop to zero between samples
+	var end time.Time
+	for _, c := range conns {
+		if n := len(c.Points); n > 0 && c.Points[n-1].End.After(end) {
+			end = c.Points[n-1].End
+		}
+	}
+	if end.IsZero() {
+		lines = append(lines, HelpDescStyle.Render("Waiting for samples…"))
+		return BoxStyle.
+			Width(l.panelWidth).
+			Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+	}
+
+	width := max(l.panelWidth-4, 10)
+	columns := width
+	if a.graphStyle != GraphBlock {
+		columns *= 2
+	}
+	for _, dir := range []struct {
+		label string
+		style lipgloss.Style
+		rate  func(core.ThroughputPoint) float64
+	}{
+		{"↓", StatusConnectedStyle, func(p core.ThroughputPoint) float64 { return p.Received }},
+		{"↑", InfoStyle, func(p core.ThroughputPoint) float64 { return p.Sent }},
+	} {
+		values := make([]float64, columns)
+		current := 0.0
+		for _, c := range conns {
+			resample(c.Points, dir.rate, end.Add(-window), window/time.Duration(columns), values)
+			if n := len(c.Points); n > 0 {
+				current += dir.rate(c.Points[n-1])
+			}
+		}
+		// A column over several samples shows their mean, which can be
+		// under the newest rate
+		peak := current
+		for _, v := range values {
+			peak = math.Max(peak, v)
+		}
+		lines = append(lines, dir.style.Render(dir.label+" "+formatRate(current))+
+			HelpDescStyle.Render("  peak "+formatRate(peak)))
+
+		graph := brailleGraph
+		if a.graphStyle == GraphBlock {
+			graph = blockGraph
+		}
+		for _, line := range graph(values, math.Max(peak, 1), graphRows) {
+			lines = append(lines, dir.style.Render(line))
+		}
+	}
+
+	return BoxStyle.
+		Width(l.panelWidth).
+		Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
+
+// formatRate formats bytes a second
+func formatRate(bytesPerSec float64) string {
+	return units.Bytes(int64(bytesPerSec)) + "/s"
+}
+
+// resample adds to values the rates of points averaged over columns step
+// long from start, so a column covering several samples shows their mean
+func resample(points []core.ThroughputPoint, rate func(core.ThroughputPoint) float64, start time.Time, step time.Duration, values []float64) {
+	if step <= 0 {
+		return
+	}
+	for _, p := range points {
+		first := max(int(p.Start.Sub(start)/step), 0)
+		last := min(int(p.End.Sub(start)/step), len(values)-1)
+		for i := first; i <= last; i++ {
+			from := start.Add(time.Duration(i) * step)
+			to := from.Add(step)
+			if p.Start.After(from) {
+				from = p.Start
+			}
+			if p.End.Before(to) {
+				to = p.End
+			}
+			if overlap := to.Sub(from); overlap > 0 {
+				values[i] += rate(p) * float64(overlap) / float64(step)
+			}
+		}
+	}
+}
+
+// levels scales values to whole levels out of n, top reaching n. Any
+// traffic at all gets at least one level so it isn't lost to rounding.
+func levels(values []float64, top float64, n int) []int {
+	heights := make([]int, len(values))
+	for i, v := range values {
+		h := int(math.Round(v / top * float64(n)))
+		if v > 0 && h == 0 {
+			h = 1
+		}
+		heights[i] = min(max(h, 0), n)
+	}
+	return heights
+}
+
+// brailleDots are the bits of a braille cell's dots by column, then by
+// row from the top
+var brailleDots = [2][4]rune{{0x01, 0x02, 0x04, 0x40}, {0x08, 0x10, 0x20, 0x80}}
+
+// brailleGraph draws values as an area graph rows lines high, two values
+// to a character and four dots to a line
+func brailleGraph(values []float64, top float64, rows int) []string {
+	heights := levels(values, top, rows*4)
+	lines := make([]string, rows)
+	for r := range lines {
+		below := (rows - 1 - r) * 4 // dot rows under this line
+		var b strings.Builder
+		for i := 0; i < len(heights); i += 2 {
+			cell := rune(0x2800)
+			for c := 0; c < 2 && i+c < len(heights); c++ {
+				for d := 0; d < 4; d++ {
+					if heights[i+c] > below+3-d {
+						cell |= brailleDots[c][d]
+					}
+				}
+			}
+			b.WriteRune(cell)
+		}
+		lines[r] = b.String()
+	}
+	return lines
+}
+
+// blocks are the eighth blocks from empty to full
+var blocks = []rune(" ▁▂▃▄▅▆▇█")
+
+// blockGraph draws values as a bar graph rows lines high, one value to a
+// character and eight levels to a line
+func blockGraph(values []float64, top float64, rows int) []string {
+	heights := levels(values, top, rows*8)
+	lines := make([]string, rows)
+	for r := range lines {
+		below := (rows - 1 - r) * 8
+		var b strings.Builder
+		for _, h := range heights {
+			b.WriteRune(blocks[min(max(h-below, 0), 8)])
+		}
+		lines[r] = b.String()
+	}
+	return lines
+}
@@ -0,0 +1,92 @@
+package tui
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/core"
+)
+
+func TestBrailleGraph(t *testing.T) {
+	got := brailleGraph([]float64{0, 4, 8, 2}, 8, 2)
+	want := []string{"\u2800\u2847", "\u28b8\u28e7"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("brailleGraph() = %q, want %q", got, want)
+	}
+}
+
+func TestBlockGraph(t *testing.T) {
+	got := blockGraph([]float64{0, 0.1, 12, 16, 32}, 16, 2)
+	want := []string{"  ▄██", " ▁███"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("blockGraph() = %q, want %q", got, want)
+	}
+}
+
+func TestResample(t *testing.T) {
+	start := time.Now()
+	points := []core.ThroughputPoint{
+		{Start: start, End: start.Add(10 * time.Second), Sent: 100},
+		{Start: start.Add(10 * time.Second), End: start.Add(20 * time.Second), Sent: 300},
+	}
+	values := make([]float64, 4)
+	resample(points, func(p core.ThroughputPoint) float64 { return p.Sent }, start, 5*time.Second, values)
+	resample(points[:1], func(p core.ThroughputPoint) float64 { return p.Sent }, start, 5*time.Second, values)
+	for i, want := range []float64{200, 200, 300, 300} {
+		if values[i] != want {
+			t.Errorf("values = %v, want connections summed per column", values)
+			break
+		}
+	}
+
+	// A column over two samples averages them
+	values = make([]float64, 1)
+	resample(points, func(p core.ThroughputPoint) float64 { return p.Sent }, start, 20*time.Second, values)
+	if values[0] != 200 {
+		t.Errorf("values = %v, want [200]", values)
+	}
+}
+
+func TestBandwidthGraphWidget(t *testing.T) {
+	a := NewApp(8080)
+	a.SetWidgets([]string{"bandwidth_graph"})
+	if strings.Contains(a.View(), "Throughput") {
+		t.Fatal("graph drawn without a throughput source")
+	}
+
+	now := time.Now()
+	point := func(sent float64) []core.ThroughputPoint {
+		return []core.ThroughputPoint{{Start: now.Add(-10 * time.Second), End: now, Sent: sent, Received: 2 * sent}}
+	}
+	conns := []core.ConnectionThroughput{
+		{ID: "bore-1", Method: "bore", Points: point(2048)},
+		{ID: "ngrok-1", Method: "ngrok", Points: point(1024)},
+	}
+	_, cmd := a.Update(ThroughputMsg{Throughput: func(time.Time) []core.ConnectionThroughput { return conns }})
+	a.Update(cmd())
+
+	view := a.View()
+	if !strings.Contains(view, "all connections, last 5m") || !strings.Contains(view, "↑ 3.0 KiB/s") {
+		t.Errorf("want the summed rates over 5m:\n%s", view)
+	}
+
+	press(a, "w", "b")
+	view = a.View()
+	if !strings.Contains(view, "bore (bore-1), last 1h") || !strings.Contains(view, "↑ 2.0 KiB/s") {
+		t.Errorf("want bore-1 alone over 1h:\n%s", view)
+	}
+	press(a, "b", "b")
+	if !strings.Contains(a.View(), "all connections") {
+		t.Error("b should come back round to all connections")
+	}
+
+	if err := a.SetGraphStyle("sparkline"); err == nil {
+		t.Error("SetGraphStyle() accepted an unknown style")
+	}
+	a.SetGraphStyle(GraphBlock)
+	press(a, "w") // 1m, where the sample spans whole columns
+	if !strings.Contains(a.View(), "█") {
+		t.Errorf("want block characters:\n%s", a.View())
+	}
+}
@@ -0,0 +1,201 @@
+package tui
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Macros are recorded key sequences by slot, "1" to "9". R then a digit
+// records into a slot, R again stops, and the digit alone replays it.
+type Macros map[string][]string
+
+// maxMacroKeys bounds a recording left running by mistake
+const maxMacroKeys = 100
+
+// macroSavedMsg reports the outcome of saving the macros
+type macroSavedMsg struct {
+	slot string
+	keys int
+	err  error
+}
+
+// MacrosPath returns the macros file, next to the TUI state file
+func MacrosPath() (string, error) {
+	state, err := StatePath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(state), "macros.json"), nil
+}
+
+// LoadMacros reads the macros saved at path. A missing file has none.
+func LoadMacros(path string) (Macros, error) {
+	m := Macros{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return m, fmt.Errorf("read TUI macros: %w", err)
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return Macros{}, fmt.Errorf("parse TUI macros %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// SaveMacros writes m to path, replacing the previous macros in one step
+func SaveMacros(path string, m Macros) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("create state directory: %w", err)
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("write TUI macros: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write TUI macros: %w", err)
+	}
+	return nil
+}
+
+// SetMacros enables macros, starting from m and keeping recordings with
+// save
+func (a *App) SetMacros(m Macros, save func(Macros) error) {
+	if m == nil {
+		m = Macros{}
+	}
+	a.macros = m
+	a.saveMacros = save
+}
+
+// isMacroSlot reports whether key names a macro slot
+func isMacroSlot(key string) bool {
+	return len(key) == 1 && key[0] >= '1' && key[0] <= '9'
+}
+
+// handleMacroKey handles the keys that record and replay macros,
+// reporting whether key was one of them. Other keys are added to the
+// recording, if there is one.
+func (a *App) handleMacroKey(key string) (tea.Cmd, bool) {
+	if a.saveMacros == nil || a.replaying {
+		return nil, false
+	}
+
+	// R was pressed; this key picks the slot
+	if a.armingMacro {
+		a.armingMacro = false
+		if isMacroSlot(key) {
+			a.recordingMacro = key
+			a.recordedKeys = nil
+			a.macroNotice = ""
+		} else {
+			a.macroNotice = HelpDescStyle.Render("Not recording: pick a slot from 1 to 9 after R")
+		}
+		return nil, true
+	}
+
+	switch {
+	case key == "R" && a.recordingMacro != "":
+		return a.stopRecording(), true
+	case key == "R":
+		a.armingMacro = true
+		return nil, true
+	case isMacroSlot(key):
+		return a.replayMacro(key), true
+	}
+
+	if a.recordingMacro != "" && len(a.recordedKeys) < maxMacroKeys {
+		a.recordedKeys = append(a.recordedKeys, key)
+	}
+	return nil, false
+}
+
+// stopRecording keeps the recorded keys in their slot. Stopping without
+// recording anything clears the slot.
+func (a *App) stopRecording() tea.Cmd {
+	slot, keys := a.recordingMacro, a.recordedKeys
+	a.recordingMacro, a.recordedKeys = "", nil
+
+	if len(keys) == 0 {
+		delete(a.macros, slot)
+	} else {
+		a.macros[slot] = keys
+	}
+	saved := make(Macros, len(a.macros))
+	for s, k := range a.macros {
+		saved[s] = k
+	}
+	save := a.saveMacros
+	return func() tea.Msg {
+		return macroSavedMsg{slot: slot, keys: len(keys), err: save(saved)}
+	}
+}
+
+// replayMacro presses the keys of the macro in slot, in order. A replay
+// during a recording is recorded as the keys it pressed.
+func (a *App) replayMacro(slot string) tea.Cmd {
+	keys, ok := a.macros[slot]
+	if !ok {
+		a.macroNotice = HelpDescStyle.Render(fmt.Sprintf("No macro %s; record one with R %s", slot, slot))
+		return nil
+	}
+
+	a.replaying = true
+	defer func() { a.replaying = false }()
+	var cmds []tea.Cmd
+	for _, key := range keys {
+		if a.recordingMacro != "" && len(a.recordedKeys) < maxMacroKeys {
+			a.recordedKeys = append(a.recordedKeys, key)
+		}
+		cmds = append(cmds, a.handleKey(key))
+	}
+	a.macroNotice = HelpDescStyle.Render(fmt.Sprintf("Ran macro %s: %s", slot, strings.Join(keys, " ")))
+	return tea.Sequence(cmds...)
+}
+
+// macroSlots returns the slots holding a macro, in order
+func (a *App) macroSlots() []string {
+	slots := make([]string, 0, len(a.macros))
+	for slot := range a.macros {
+		slots = append(slots, slot)
+	}
+	sort.Strings(slots)
+	return slots
+}
+
+// handleMacroSaved records the result of saving a recording
+func (a *App) handleMacroSaved(msg macroSavedMsg) {
+	switch {
+	case msg.err != nil:
+		a.macroNotice = ErrorStyle.Render(fmt.Sprintf("%s Macro %s not saved: %v", IconCross, msg.slot, msg.err))
+	case msg.keys == 0:
+		a.macroNotice = HelpDescStyle.Render(fmt.Sprintf("Macro %s cleared", msg.slot))
+	default:
+		a.macroNotice = StatusConnectedStyle.Render(fmt.Sprintf("%s Macro %s saved: %d key(s)", IconConnected, msg.slot, msg.keys))
+	}
+}
+
+// renderMacroStatus renders the recording in progress, or the outcome of
+// the last macro action
+func (a *App) renderMacroStatus() string {
+	switch {
+	case a.armingMacro:
+		return InfoStyle.Render("Record macro: press a slot from 1 to 9")
+	case a.recordingMacro != "":
+		return ErrorStyle.Render(fmt.Sprintf("● Recording macro %s (%d key(s)) - R to stop", a.recordingMacro, len(a.recordedKeys)))
+	}
+	return a.macroNotice
+}
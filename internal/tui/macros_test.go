@@ -0,0 +1,75 @@
+package tui
+
+import (
+	"path/filepath"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func press(a *App, keys ...string) {
+	for _, key := range keys {
+		msg := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(key)}
+		if key == "ctrl+w" {
+			msg = tea.KeyMsg{Type: tea.KeyCtrlW}
+		}
+		a.Update(msg)
+	}
+}
+
+// splitApp returns an app laid out with the side pane
+func splitApp() *App {
+	a := NewApp(8080)
+	a.Update(tea.WindowSizeMsg{Width: SplitWidth, Height: 40})
+	a.Update(a.relayout(resizeSettledMsg{seq: a.resizeSeq})())
+	return a
+}
+
+func TestRecordAndReplayMacro(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "macros.json")
+	var saved Macros
+	a := splitApp()
+	a.SetMacros(nil, func(m Macros) error {
+		saved = m
+		return SaveMacros(path, m)
+	})
+
+	press(a, "R", "2", "ctrl+w", "d")
+	if a.focus != paneSide {
+		t.Fatalf("keys recorded should still act: focus %v", a.focus)
+	}
+	_, cmd := a.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("R")})
+	if cmd == nil {
+		t.Fatal("stopping the recording should save it")
+	}
+	a.Update(cmd())
+	if got := saved["2"]; len(got) != 2 || got[0] != "ctrl+w" || got[1] != "d" {
+		t.Fatalf("saved %v, want [ctrl+w d] in slot 2", saved)
+	}
+
+	// Replaying switches the focus back
+	press(a, "2")
+	if a.focus != paneMonitor {
+		t.Errorf("replay left focus on %v", a.focus)
+	}
+
+	// The macro is there for the next session
+	macros, err := LoadMacros(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := splitApp()
+	b.SetMacros(macros, func(Macros) error { return nil })
+	press(b, "2")
+	if b.focus != paneSide {
+		t.Errorf("loaded macro left focus on %v", b.focus)
+	}
+}
+
+func TestMacroKeysNeedMacros(t *testing.T) {
+	a := NewApp(8080)
+	press(a, "R", "1")
+	if a.armingMacro || a.recordingMacro != "" {
+		t.Error("R recorded a macro without macros set up")
+	}
+}
@@ -0,0 +1,53 @@
+package tui
+
+import (
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// modeChangedMsg reports the outcome of switching connection modes
+type modeChangedMsg struct {
+	mode string
+	err  error
+}
+
+// SetConnectionMode shows the connection mode current describes on the
+// dashboard and lets the m key switch to the next one with cycle, which
+// returns the mode it switched to
+func (a *App) SetConnectionMode(current func() string, cycle func() (string, error)) {
+	a.modeSource = current
+	a.cycleMode = cycle
+}
+
+// cycleModeCmd switches modes off the UI goroutine, as it saves the config
+func (a *App) cycleModeCmd() tea.Cmd {
+	if a.cycleMode == nil {
+		return nil
+	}
+	cycle := a.cycleMode
+	return func() tea.Msg {
+		mode, err := cycle()
+		return modeChangedMsg{mode: mode, err: err}
+	}
+}
+
+// handleModeChanged records the result of switching modes
+func (a *App) handleModeChanged(msg modeChangedMsg) {
+	if msg.err != nil {
+		a.modeNotice = ErrorStyle.Render(IconCross + " " + msg.err.Error())
+		return
+	}
+	a.modeNotice = ""
+}
+
+// renderMode renders the connection mode line of the status box, with
+// the error of the last switch if it failed
+func (a *App) renderMode() string {
+	if a.modeSource == nil {
+		return ""
+	}
+	line := HelpDescStyle.Render("Mode: " + a.modeSource())
+	if a.modeNotice != "" {
+		line += "\n" + a.modeNotice
+	}
+	return line
+}
@@ -0,0 +1,140 @@
+package tui
+
+import (
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Profile is a named setup offered by the profile picker
+type Profile struct {
+	Name        string
+	Description string
+	Active      bool // the profile last applied
+}
+
+// profileAppliedMsg reports the outcome of picking a profile
+type profileAppliedMsg struct {
+	name string
+	err  error
+}
+
+// SetProfiles enables the profile picker, listing what list returns and
+// switching setups with apply
+func (a *App) SetProfiles(list func() []Profile, apply func(name string) error) {
+	a.profileSource = list
+	a.applyProfile = apply
+}
+
+// togglePicker opens the profile picker on the active profile, or closes it
+func (a *App) togglePicker() {
+	if a.profileSource == nil {
+		return
+	}
+	if a.picking {
+		a.picking = false
+		return
+	}
+
+	a.picking = true
+	a.profiles = a.profileSource()
+	a.profileCursor = 0
+	for i, p := range a.profiles {
+		if p.Active {
+			a.profileCursor = i
+		}
+	}
+}
+
+// movePickerCursor moves the picker selection by delta, staying in range
+func (a *App) movePickerCursor(delta int) {
+	a.profileCursor += delta
+	if a.profileCursor >= len(a.profiles) {
+		a.profileCursor = len(a.profiles) - 1
+	}
+	if a.profileCursor < 0 {
+		a.profileCursor = 0
+	}
+}
+
+// pickProfile applies the selected profile and closes the picker
+func (a *App) pickProfile() tea.Cmd {
+	if len(a.profiles) == 0 {
+		a.picking = false
+		return nil
+	}
+
+	name := a.profiles[a.profileCursor].Name
+	apply := a.applyProfile
+	a.picking = false
+	return func() tea.Msg {
+		return profileAppliedMsg{name: name, err: apply(name)}
+	}
+}
+
+// handlePickerKey handles a key while the picker is open, reporting
+// whether it was used
+func (a *App) handlePickerKey(key string) (tea.Cmd, bool) {
+	switch key {
+	case "up", "k":
+		a.movePickerCursor(-1)
+	case "down", "j":
+		a.movePickerCursor(1)
+	case "enter":
+		return a.pickProfile(), true
+	case "esc", "p":
+		a.picking = false
+	default:
+		return nil, false
+	}
+	return nil, true
+}
+
+// handleProfileApplied records the result of switching profiles
+func (a *App) handleProfileApplied(msg profileAppliedMsg) {
+	if msg.err != nil {
+		a.profileNotice = ErrorStyle.Render(fmt.Sprintf("%s %s: %v", IconCross, msg.name, msg.err))
+		return
+	}
+	a.profileNotice = StatusConnectedStyle.Render(fmt.Sprintf("%s Switched to profile %s", IconConnected, msg.name))
+}
+
+// renderProfilePicker renders the open picker, or the outcome of the last
+// pick
+func (a *App) renderProfilePicker(l layout) string {
+	if !a.picking {
+		return a.profileNotice
+	}
+
+	lines := []string{InfoStyle.Render("Switch profile"), ""}
+	if len(a.profiles) == 0 {
+		lines = append(lines, HelpDescStyle.Render("No profiles; save one with tunnel profile create"))
+	}
+	for i, p := range a.profiles {
+		marker := "  "
+		if i == a.profileCursor {
+			marker = "> "
+		}
+		name := p.Name
+		if p.Active {
+			name = StatusConnectedStyle.Render(IconConnected + " " + name)
+		} else {
+			name = IconStopped + " " + name
+		}
+		line := marker + name
+		if p.Description != "" {
+			line += HelpDescStyle.Render("  " + p.Description)
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, "",
+		HelpKeyStyle.Render("enter")+HelpDescStyle.Render(" apply")+
+			HelpSeparatorStyle.Render("  •  ")+
+			HelpKeyStyle.Render("esc")+HelpDescStyle.Render(" cancel"))
+
+	return BoxStyle.
+		BorderForeground(ColorPrimary).
+		Width(l.panelWidth).
+		Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
@@ -0,0 +1,174 @@
+package tui
+
+import (
+	"fmt"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+const (
+	// MinWidth and MinHeight are the smallest terminal the panels fit in;
+	// below them the guard screen is shown instead
+	MinWidth  = 40
+	MinHeight = 16
+
+	// resizeDebounce is how long the size must hold still before the
+	// panels are laid out again
+	resizeDebounce = 80 * time.Millisecond
+
+	// compactHeight is the height below which panels are separated by a
+	// single line rather than a blank one
+	compactHeight = 24
+
+	// SplitWidth is the width from which the monitor and the side pane are
+	// shown side by side
+	SplitWidth = 160
+)
+
+// layout holds the panel geometry for one terminal size
+type layout struct {
+	width       int
+	height      int
+	statusWidth int    // width of the server status box
+	panelWidth  int    // width of the approval and alert panels
+	gap         string // separator between panels
+
+	// Split layout only
+	split      bool
+	leftWidth  int // columns given to the monitor
+	paneWidth  int // width of the side pane box, inside its border
+	paneHeight int // height of the side pane box, inside its border
+}
+
+// computeLayout works out the panel geometry for a width x height terminal
+func computeLayout(width, height int) layout {
+	l := layout{
+		width:       width,
+		height:      height,
+		statusWidth: 50,
+		panelWidth:  70,
+		gap:         "\n\n",
+	}
+	if width < 60 {
+		l.statusWidth = width - 4
+	}
+	if width < 80 {
+		l.panelWidth = width - 4
+	}
+	if height < compactHeight {
+		l.gap = "\n"
+	}
+	if width >= SplitWidth {
+		l.split = true
+		l.leftWidth = width / 2
+		l.paneWidth = width - l.leftWidth - 4
+		l.paneHeight = height - 4 // footer and the blank line above it
+	}
+	return l
+}
+
+// fit shrinks a layout computed for an earlier size so it never overflows
+// the terminal while the layout for the new size is still being computed
+func (l layout) fit(width, height int) layout {
+	if l.split && width < l.width {
+		l.split = false
+	}
+	l.paneHeight = min(l.paneHeight, height-4)
+	l.statusWidth = min(l.statusWidth, width-4)
+	l.panelWidth = min(l.panelWidth, width-4)
+	return l
+}
+
+// resizeSettledMsg fires once the terminal size has held still for
+// resizeDebounce
+type resizeSettledMsg struct {
+	seq int
+}
+
+// layoutMsg carries a layout computed off the update loop
+type layoutMsg struct {
+	seq    int
+	layout layout
+}
+
+// handleResize records the new size and schedules a re-layout once the
+// resizes stop coming, so a drag-resize doesn't lay the panels out for
+// every intermediate size
+func (a *App) handleResize(msg tea.WindowSizeMsg) tea.Cmd {
+	a.width = msg.Width
+	a.height = msg.Height
+	a.resizeSeq++
+
+	// Lay out the first size straight away so the first frame is right
+	if a.layout.width == 0 {
+		a.layout = computeLayout(msg.Width, msg.Height)
+		return nil
+	}
+
+	seq := a.resizeSeq
+	return tea.Tick(resizeDebounce, func(time.Time) tea.Msg {
+		return resizeSettledMsg{seq: seq}
+	})
+}
+
+// relayout computes the layout for the current size in the background.
+// Stale settles, from sizes the terminal has since left, are dropped.
+func (a *App) relayout(msg resizeSettledMsg) tea.Cmd {
+	if msg.seq != a.resizeSeq {
+		return nil
+	}
+	width, height := a.width, a.height
+	return func() tea.Msg {
+		return layoutMsg{seq: msg.seq, layout: computeLayout(width, height)}
+	}
+}
+
+// applyLayout switches to a computed layout unless the terminal has been
+// resized again since it was requested
+func (a *App) applyLayout(msg layoutMsg) {
+	if msg.seq == a.resizeSeq {
+		a.layout = msg.layout
+	}
+}
+
+// currentLayout returns the layout to render with. During a resize that is
+// the last settled layout, narrowed to fit the terminal as it is now.
+func (a *App) currentLayout() layout {
+	if a.layout.width == 0 {
+		return computeLayout(a.width, a.height)
+	}
+	if a.layout.width == a.width && a.layout.height == a.height {
+		return a.layout
+	}
+	return a.layout.fit(a.width, a.height)
+}
+
+// tooSmall reports whether the terminal is below the minimum size
+func (a *App) tooSmall() bool {
+	return a.width < MinWidth || a.height < MinHeight
+}
+
+// renderTooSmall renders the guard screen shown while the terminal is
+// below the minimum size
+func (a *App) renderTooSmall() string {
+	widthStyle, heightStyle := StatusConnectedStyle, StatusConnectedStyle
+	if a.width < MinWidth {
+		widthStyle = StatusStoppedStyle
+	}
+	if a.height < MinHeight {
+		heightStyle = StatusStoppedStyle
+	}
+
+	content := lipgloss.JoinVertical(lipgloss.Center,
+		StatusReadyStyle.Render("Terminal too small"),
+		"",
+		HelpDescStyle.Render("current ")+
+			widthStyle.Render(fmt.Sprint(a.width))+HelpDescStyle.Render(" x ")+
+			heightStyle.Render(fmt.Sprint(a.height)),
+		HelpDescStyle.Render(fmt.Sprintf("needed  %d x %d", MinWidth, MinHeight)),
+	)
+
+	return lipgloss.Place(a.width, a.height, lipgloss.Center, lipgloss.Center, content)
+}
@@ -0,0 +1,50 @@
+package tui
+
+import (
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestResizeDebounce(t *testing.T) {
+	a := NewApp(8080)
+	a.Update(tea.WindowSizeMsg{Width: 100, Height: 30})
+	if a.layout.width != 100 {
+		t.Fatalf("first size not laid out immediately: %+v", a.layout)
+	}
+
+	// A drag-resize: only the last size should be laid out
+	a.Update(tea.WindowSizeMsg{Width: 90, Height: 30})
+	stale := resizeSettledMsg{seq: a.resizeSeq}
+	a.Update(tea.WindowSizeMsg{Width: 70, Height: 30})
+
+	if cmd := a.relayout(stale); cmd != nil {
+		t.Error("a settle from an earlier size scheduled a layout")
+	}
+	if l := a.currentLayout(); l.panelWidth > 66 {
+		t.Errorf("stale layout overflows the terminal: panel width %d", l.panelWidth)
+	}
+
+	cmd := a.relayout(resizeSettledMsg{seq: a.resizeSeq})
+	if cmd == nil {
+		t.Fatal("the latest settle scheduled no layout")
+	}
+	a.Update(cmd())
+	if a.layout.width != 70 || a.layout.panelWidth != 66 {
+		t.Errorf("layout not applied: %+v", a.layout)
+	}
+}
+
+func TestTooSmallGuard(t *testing.T) {
+	a := NewApp(8080)
+	a.Update(tea.WindowSizeMsg{Width: MinWidth - 1, Height: MinHeight})
+	if view := a.View(); !strings.Contains(view, "Terminal too small") {
+		t.Errorf("guard screen not shown:\n%s", view)
+	}
+
+	a.Update(tea.WindowSizeMsg{Width: MinWidth, Height: MinHeight})
+	if view := a.View(); strings.Contains(view, "Terminal too small") {
+		t.Errorf("guard screen shown at the minimum size:\n%s", view)
+	}
+}
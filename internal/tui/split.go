@@ -0,0 +1,217 @@
+package tui
+
+import (
+	"fmt"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/logging"
+	"github.com/jedarden/tunnel/internal/units"
+)
+
+// maxLogLines bounds the live log kept for the side pane
+const maxLogLines = 500
+
+// pane identifies which side of the split layout has focus
+type pane int
+
+const (
+	paneMonitor pane = iota
+	paneSide
+)
+
+// sideView is what the side pane shows
+type sideView int
+
+const (
+	sideLogs   sideView = iota // live connection events and log entries
+	sideDetail                 // the selected alert
+)
+
+// EventFeedMsg hands the TUI a subscription to connection events, shown as
+// the live log in the split layout
+type EventFeedMsg struct {
+	Events <-chan *core.ConnectionEvent
+}
+
+// eventMsg carries one connection event from the feed
+type eventMsg struct {
+	event *core.ConnectionEvent
+}
+
+// waitForEvent delivers the next event from the feed; a closed feed ends
+// the wait for good
+func (a *App) waitForEvent() tea.Cmd {
+	feed := a.eventFeed
+	return func() tea.Msg {
+		event, ok := <-feed
+		if !ok {
+			return nil
+		}
+		return eventMsg{event: event}
+	}
+}
+
+// LogFeedMsg hands the TUI a subscription to the log, whose entries join
+// connection events in the live log
+type LogFeedMsg struct {
+	Entries <-chan logging.Entry
+}
+
+// logMsg carries one log entry from the feed
+type logMsg struct {
+	entry logging.Entry
+}
+
+// waitForLog delivers the next log entry; a closed feed ends the wait
+func (a *App) waitForLog() tea.Cmd {
+	feed := a.logFeed
+	return func() tea.Msg {
+		entry, ok := <-feed
+		if !ok {
+			return nil
+		}
+		return logMsg{entry: entry}
+	}
+}
+
+// handleEvent appends an event to the live log and keeps it for the
+// events screen
+func (a *App) handleEvent(event *core.ConnectionEvent) {
+	line := fmt.Sprintf("%s  %-22s %s",
+		units.Clock(event.Timestamp), event.Type, event.ConnID)
+	if event.Message != "" {
+		line += "  " + event.Message
+	}
+	a.appendLogLine(line)
+	a.recordEvent(event)
+}
+
+// appendLogLine adds a line to the live log. A log scrolled back by the
+// user stays where it is rather than following new lines.
+func (a *App) appendLogLine(line string) {
+	a.logLines = append(a.logLines, line)
+	if len(a.logLines) > maxLogLines {
+		a.logLines = a.logLines[len(a.logLines)-maxLogLines:]
+	}
+	if a.logScroll > 0 {
+		a.logScroll = min(a.logScroll+1, len(a.logLines)-1)
+	}
+}
+
+// switchFocus moves focus to the other pane of the split layout
+func (a *App) switchFocus() {
+	if !a.currentLayout().split {
+		return
+	}
+	if a.focus == paneMonitor {
+		a.focus = paneSide
+	} else {
+		a.focus = paneMonitor
+	}
+}
+
+// toggleSideView switches the side pane between the live log and the
+// detail of the selected alert
+func (a *App) toggleSideView() {
+	if a.sideView == sideLogs {
+		a.sideView = sideDetail
+	} else {
+		a.sideView = sideLogs
+	}
+}
+
+// scrollLog moves the live log view back (positive) or forward in time
+func (a *App) scrollLog(delta int) {
+	a.logScroll = max(0, min(a.logScroll+delta, len(a.logLines)-1))
+}
+
+// sideFocused reports whether keys go to the side pane
+func (a *App) sideFocused() bool {
+	return a.focus == paneSide && a.currentLayout().split
+}
+
+// renderSplit lays the monitor out on the left and the side pane on the
+// right, with the footer across the bottom
+func (a *App) renderSplit(l layout, monitor, footer string) string {
+	left := lipgloss.Place(l.leftWidth, l.height-2, lipgloss.Center, lipgloss.Center, monitor)
+	body := lipgloss.JoinHorizontal(lipgloss.Top, left, a.renderSidePane(l))
+	return lipgloss.JoinVertical(lipgloss.Center, body, "", footer)
+}
+
+// renderSidePane renders the live log or the selected alert in a box that
+// is highlighted while it has focus
+func (a *App) renderSidePane(l layout) string {
+	rows := max(l.paneHeight-2, 1) // inside the padding
+	width := max(l.paneWidth-4, 1)
+
+	var title string
+	var lines []string
+	if a.sideView == sideDetail {
+		title = "Detail"
+		lines = a.alertDetail()
+	} else {
+		title = "Live log"
+		if a.logScroll > 0 {
+			title += HelpDescStyle.Render(fmt.Sprintf("  (%d lines back)", a.logScroll))
+		}
+		lines = a.visibleLog(rows - 2)
+	}
+
+	clip := lipgloss.NewStyle().MaxWidth(width)
+	for i, line := range lines {
+		lines[i] = clip.Render(line)
+	}
+	content := lipgloss.JoinVertical(lipgloss.Left,
+		append([]string{TitleStyle.Render(title), ""}, lines...)...)
+
+	border := ColorBorder
+	if a.focus == paneSide {
+		border = ColorPrimary
+	}
+	return BoxStyle.
+		BorderForeground(border).
+		Width(l.paneWidth).
+		Height(l.paneHeight).
+		MaxHeight(l.paneHeight + 2).
+		Render(content)
+}
+
+// visibleLog returns the n log lines that fit the pane at the current
+// scroll position, oldest first
+func (a *App) visibleLog(n int) []string {
+	if len(a.logLines) == 0 {
+		return []string{HelpDescStyle.Render("Waiting for connection events...")}
+	}
+	end := len(a.logLines) - a.logScroll
+	start := max(end-n, 0)
+	return append([]string(nil), a.logLines[start:end]...)
+}
+
+// alertDetail describes the selected alert for the side pane
+func (a *App) alertDetail() []string {
+	if len(a.activeAlerts) == 0 {
+		return []string{HelpDescStyle.Render("No alert selected")}
+	}
+	alert := a.activeAlerts[a.alertCursor]
+
+	row := func(label, value string) string {
+		return HelpDescStyle.Render(fmt.Sprintf("%-14s", label)) + value
+	}
+	lines := []string{
+		row("Rule", alert.Rule),
+		row("Subject", alert.Subject),
+		row("Message", alert.Message),
+		row("Since", units.TimeExact(alert.Since)),
+		row("Fired", units.TimeExact(alert.FiredAt)),
+	}
+	if alert.Acknowledged() {
+		lines = append(lines, row("Acknowledged", units.TimeExact(alert.AcknowledgedAt)))
+	}
+	if alert.Silenced(time.Now()) {
+		lines = append(lines, row("Silenced until", units.TimeExact(alert.SilencedUntil)))
+	}
+	return lines
+}
@@ -0,0 +1,69 @@
+package tui
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/logging"
+)
+
+func TestSplitLayout(t *testing.T) {
+	a := NewApp(8080)
+	a.Update(tea.WindowSizeMsg{Width: SplitWidth - 1, Height: 40})
+	if strings.Contains(a.View(), "Live log") {
+		t.Error("side pane shown below the split width")
+	}
+
+	a.Update(tea.WindowSizeMsg{Width: SplitWidth, Height: 40})
+	a.Update(a.relayout(resizeSettledMsg{seq: a.resizeSeq})())
+
+	a.handleEvent(core.NewEvent(core.EventConnected, "bore-1", nil, "tunnel up"))
+	view := a.View()
+	if !strings.Contains(view, "Live log") || !strings.Contains(view, "bore-1") {
+		t.Fatalf("live log missing from the split layout:\n%s", view)
+	}
+	if h, w := lipgloss.Height(view), lipgloss.Width(view); h > 40 || w > SplitWidth {
+		t.Errorf("split layout is %dx%d, larger than the terminal", w, h)
+	}
+
+	// Focus moves to the side pane, where up scrolls the log back
+	a.Update(tea.KeyMsg{Type: tea.KeyCtrlW})
+	if !a.sideFocused() {
+		t.Fatal("ctrl+w did not focus the side pane")
+	}
+	a.handleEvent(core.NewEvent(core.EventDisconnected, "bore-1", nil, ""))
+	a.Update(tea.KeyMsg{Type: tea.KeyUp})
+	if a.logScroll != 1 {
+		t.Errorf("log scrolled to %d, want 1", a.logScroll)
+	}
+
+	a.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("d")})
+	if view := a.View(); !strings.Contains(view, "No alert selected") {
+		t.Errorf("detail view not shown:\n%s", view)
+	}
+}
+
+func TestLiveLogShowsLogEntries(t *testing.T) {
+	a := NewApp(8080)
+	feed := make(chan logging.Entry, 1)
+	_, cmd := a.Update(LogFeedMsg{Entries: feed})
+	if cmd == nil {
+		t.Fatal("expected the app to wait on the log feed")
+	}
+
+	feed <- logging.Entry{
+		Time:      time.Now(),
+		Level:     slog.LevelWarn,
+		Component: "throttle",
+		Message:   "relay stopped",
+	}
+	a.Update(cmd())
+	if len(a.logLines) != 1 || !strings.Contains(a.logLines[0], "throttle: relay stopped") {
+		t.Errorf("log lines = %q", a.logLines)
+	}
+}
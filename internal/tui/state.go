@@ -0,0 +1,131 @@
+package tui
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// State is what the TUI remembers across restarts, so reopening it returns
+// to where it was left
+type State struct {
+	Focus         string   `json:"focus,omitempty"`          // pane with focus: monitor or side
+	SideView      string   `json:"side_view,omitempty"`      // side pane contents: logs or detail
+	SelectedAlert string   `json:"selected_alert,omitempty"` // key of the selected alert
+	Log           []string `json:"log,omitempty"`            // tail of the live log
+	LogScroll     int      `json:"log_scroll,omitempty"`     // lines scrolled back in the live log
+	ChangelogSeen string   `json:"changelog_seen,omitempty"` // newest release on the what's new screen dismissed
+}
+
+var (
+	paneNames = map[pane]string{paneMonitor: "monitor", paneSide: "side"}
+	viewNames = map[sideView]string{sideLogs: "logs", sideDetail: "detail"}
+)
+
+// StatePath returns the TUI state file under the XDG state directory,
+// $XDG_STATE_HOME or ~/.local/state
+func StatePath() (string, error) {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("get home directory: %w", err)
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "tunnel", "tui.json"), nil
+}
+
+// LoadState reads the state saved at path. A missing file is an empty state.
+func LoadState(path string) (State, error) {
+	var s State
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return s, fmt.Errorf("read TUI state: %w", err)
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return State{}, fmt.Errorf("parse TUI state %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// SaveState writes s to path, replacing the previous state in one step
+func SaveState(path string, s State) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("create state directory: %w", err)
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("write TUI state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write TUI state: %w", err)
+	}
+	return nil
+}
+
+// State returns the TUI's current state for saving
+func (a *App) State() State {
+	s := State{
+		Focus:     paneNames[a.focus],
+		SideView:  viewNames[a.sideView],
+		Log:       a.logLines,
+		LogScroll: a.logScroll,
+
+		ChangelogSeen: a.changelogSeen,
+	}
+	if a.alertCursor < len(a.activeAlerts) {
+		s.SelectedAlert = a.activeAlerts[a.alertCursor].Key()
+	}
+	if s.SelectedAlert == "" {
+		s.SelectedAlert = a.restoreAlert // never shown, so still selected
+	}
+	return s
+}
+
+// RestoreState returns the TUI to a saved state. The selected alert is
+// picked again once the alerts it is among have been polled.
+func (a *App) RestoreState(s State) {
+	for p, name := range paneNames {
+		if name == s.Focus {
+			a.focus = p
+		}
+	}
+	for v, name := range viewNames {
+		if name == s.SideView {
+			a.sideView = v
+		}
+	}
+	a.logLines = s.Log
+	if len(a.logLines) > maxLogLines {
+		a.logLines = a.logLines[len(a.logLines)-maxLogLines:]
+	}
+	a.logScroll = max(0, min(s.LogScroll, len(a.logLines)-1))
+	a.restoreAlert = s.SelectedAlert
+	a.changelogSeen = s.ChangelogSeen
+}
+
+// reselectAlert moves the cursor to the alert selected when the state was
+// saved, the first time it is among the active alerts
+func (a *App) reselectAlert() {
+	if a.restoreAlert == "" {
+		return
+	}
+	for i, alert := range a.activeAlerts {
+		if alert.Key() == a.restoreAlert {
+			a.alertCursor = i
+			a.restoreAlert = ""
+			return
+		}
+	}
+}
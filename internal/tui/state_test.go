@@ -0,0 +1,72 @@
+package tui
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/core"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	alerts := []core.Alert{
+		{Rule: "connection_down", Subject: "bore-1", FiredAt: time.Now()},
+		{Rule: "key_expiring", Subject: "SHA256:abc", FiredAt: time.Now()},
+	}
+
+	a := NewApp(8080)
+	a.focus = paneSide
+	a.sideView = sideDetail
+	a.handleAlerts(AlertsMsg{Active: alerts})
+	a.moveAlertCursor(1)
+	for i := 0; i < 5; i++ {
+		a.handleEvent(core.NewEvent(core.EventConnected, "bore-1", nil, ""))
+	}
+	a.scrollLog(2)
+
+	path := filepath.Join(t.TempDir(), "tunnel", "tui.json")
+	if err := SaveState(path, a.State()); err != nil {
+		t.Fatal(err)
+	}
+	state, err := LoadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewApp(8080)
+	b.RestoreState(state)
+	if b.focus != paneSide || b.sideView != sideDetail {
+		t.Errorf("view not restored: focus %v side %v", b.focus, b.sideView)
+	}
+	if len(b.logLines) != 5 || b.logScroll != 2 {
+		t.Errorf("log not restored: %d lines, scrolled %d", len(b.logLines), b.logScroll)
+	}
+
+	// The selection comes back once the alerts are polled, wherever the
+	// alert now sits
+	b.handleAlerts(AlertsMsg{Active: []core.Alert{alerts[1], alerts[0]}})
+	if b.alertCursor != 0 {
+		t.Errorf("cursor on alert %d, want 0", b.alertCursor)
+	}
+}
+
+func TestLoadMissingState(t *testing.T) {
+	state, err := LoadState(filepath.Join(t.TempDir(), "tui.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Focus != "" || len(state.Log) != 0 {
+		t.Errorf("got %+v, want an empty state", state)
+	}
+}
+
+func TestStatePathFollowsXDG(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", "/var/state")
+	path, err := StatePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != filepath.Join("/var/state", "tunnel", "tui.json") {
+		t.Errorf("got %s", path)
+	}
+}
@@ -0,0 +1,105 @@
+package tui
+
+import (
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/jedarden/tunnel/internal/changelog"
+)
+
+// SetChangelog shows the releases newer than the last one dismissed on a
+// what's new screen ahead of the dashboard. Call it after RestoreState,
+// which brings back the release last dismissed.
+func (a *App) SetChangelog(releases []changelog.Release) {
+	a.unseenReleases = changelog.Since(releases, a.changelogSeen)
+	if len(releases) > 0 {
+		a.newestRelease = releases[0].Version
+	}
+	a.whatsNewScroll = 0
+}
+
+// showingWhatsNew reports whether the what's new screen is up
+func (a *App) showingWhatsNew() bool {
+	return len(a.unseenReleases) > 0
+}
+
+// dismissWhatsNew closes the what's new screen for good: the saved state
+// remembers the newest release as seen
+func (a *App) dismissWhatsNew() {
+	a.unseenReleases = nil
+	a.changelogSeen = a.newestRelease
+}
+
+// handleWhatsNewKey handles a key while the what's new screen is up. Only
+// quitting gets past it, so keys meant for the dashboard can't act unseen.
+func (a *App) handleWhatsNewKey(key string) (tea.Cmd, bool) {
+	switch key {
+	case "ctrl+c", "q":
+		return nil, false
+	case "enter", "esc":
+		a.dismissWhatsNew()
+	case "up", "k":
+		a.whatsNewScroll = max(a.whatsNewScroll-1, 0)
+	case "down", "j":
+		a.whatsNewScroll++
+	}
+	return nil, true
+}
+
+// whatsNewLines lays out the unseen releases, breaking changes and
+// migrations standing out from the rest
+func (a *App) whatsNewLines(width int) []string {
+	var lines []string
+	wrap := lipgloss.NewStyle().Width(width)
+	for i, r := range a.unseenReleases {
+		if i > 0 {
+			lines = append(lines, "")
+		}
+		heading := TitleStyle.Render(r.Version)
+		if r.Date != "" {
+			heading += HelpDescStyle.Render("  " + r.Date)
+		}
+		lines = append(lines, heading)
+
+		for _, s := range r.Sections {
+			style := InfoStyle
+			switch s.Title {
+			case changelog.Breaking:
+				style = ErrorStyle
+			case changelog.Migrations:
+				style = StatusReadyStyle
+			}
+			lines = append(lines, "", style.Render(s.Title))
+			for _, item := range s.Items {
+				lines = append(lines, strings.Split(wrap.Render("• "+item), "\n")...)
+			}
+		}
+	}
+	return lines
+}
+
+// renderWhatsNew draws the unseen releases, scrolled to fit the terminal
+func (a *App) renderWhatsNew() string {
+	l := a.currentLayout()
+	header := a.renderHeader()
+	footer := strings.Join([]string{
+		HelpKeyStyle.Render("enter") + HelpDescStyle.Render(" dismiss"),
+		HelpKeyStyle.Render("↑/↓") + HelpDescStyle.Render(" scroll"),
+		HelpKeyStyle.Render("q") + HelpDescStyle.Render(" quit"),
+	}, HelpSeparatorStyle.Render("  •  "))
+
+	// The box's border and padding take four lines, its title two
+	lines := a.whatsNewLines(l.panelWidth - 4)
+	room := max(a.height-lipgloss.Height(header+l.gap+l.gap+footer)-6, 1)
+	a.whatsNewScroll = min(a.whatsNewScroll, max(len(lines)-room, 0))
+	shown := lines[a.whatsNewScroll:min(a.whatsNewScroll+room, len(lines))]
+
+	title := InfoStyle.Render("What's new since you last looked")
+	box := BoxStyle.
+		Width(l.panelWidth).
+		Render(lipgloss.JoinVertical(lipgloss.Left, append([]string{title, ""}, shown...)...))
+
+	return lipgloss.Place(a.width, a.height, lipgloss.Center, lipgloss.Top,
+		header+l.gap+box+l.gap+footer)
+}
@@ -0,0 +1,47 @@
+package tui
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jedarden/tunnel/internal/changelog"
+)
+
+func TestWhatsNewScreen(t *testing.T) {
+	releases := []changelog.Release{
+		{Version: "1.2.0", Sections: []changelog.Section{{Title: changelog.Breaking, Items: []string{"Audit log chained"}}}},
+		{Version: "1.1.0", Sections: []changelog.Section{{Title: "Added", Items: []string{"Macros"}}}},
+		{Version: "1.0.0"},
+	}
+
+	a := NewApp(8080)
+	a.RestoreState(State{ChangelogSeen: "1.0.0"})
+	a.SetChangelog(releases)
+	view := a.View()
+	if !strings.Contains(view, "Audit log chained") || !strings.Contains(view, "Macros") {
+		t.Fatalf("want both unseen releases:\n%s", view)
+	}
+
+	// Dashboard keys don't act behind the screen
+	a.SetProfiles(func() []Profile { return []Profile{{Name: "home"}} }, func(string) error { return nil })
+	press(a, "p")
+	if a.picking {
+		t.Error("key reached the dashboard behind the what's new screen")
+	}
+
+	press(a, "enter")
+	if strings.Contains(a.View(), "What's new") {
+		t.Error("screen still shown after dismissing")
+	}
+	if seen := a.State().ChangelogSeen; seen != "1.2.0" {
+		t.Errorf("State().ChangelogSeen = %q, want 1.2.0", seen)
+	}
+
+	// Not shown again next time
+	b := NewApp(8080)
+	b.RestoreState(a.State())
+	b.SetChangelog(releases)
+	if b.showingWhatsNew() {
+		t.Error("dismissed releases shown again")
+	}
+}
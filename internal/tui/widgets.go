@@ -0,0 +1,176 @@
+package tui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/units"
+)
+
+// Widget is a panel of the dashboard
+type Widget interface {
+	// Render draws the widget for the app's current state, or returns ""
+	// when it has nothing to show
+	Render(a *App, l layout) string
+}
+
+// widgetFunc lets a render method of App serve as a Widget
+type widgetFunc func(a *App, l layout) string
+
+func (f widgetFunc) Render(a *App, l layout) string {
+	return f(a, l)
+}
+
+// widgets are the dashboard's widgets by the name the dashboard.widgets
+// config picks them with. A new widget only needs adding here.
+var widgets = map[string]Widget{
+	"connections":     widgetFunc((*App).renderStatusBox),
+	"alerts":          widgetFunc((*App).renderAlertsPanel),
+	"forwards":        widgetFunc((*App).renderForwardsPanel),
+	"bandwidth":       widgetFunc((*App).renderBandwidthPanel),
+	"bandwidth_graph": widgetFunc((*App).renderBandwidthGraph),
+	"key_expirations": widgetFunc((*App).renderKeyExpirations),
+	"recent_events":   widgetFunc((*App).renderRecentEvents),
+}
+
+// DefaultWidgets is the dashboard when the config doesn't pick widgets
+var DefaultWidgets = []string{"connections", "alerts", "forwards", "bandwidth", "bandwidth_graph"}
+
+// WidgetNames returns the names of all widgets, sorted
+func WidgetNames() []string {
+	names := make([]string, 0, len(widgets))
+	for name := range widgets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetWidgets picks the dashboard's widgets and their order; none picks
+// DefaultWidgets. On an unknown name the dashboard is left as it was.
+func (a *App) SetWidgets(names []string) error {
+	if len(names) == 0 {
+		names = DefaultWidgets
+	}
+	for _, name := range names {
+		if _, ok := widgets[name]; !ok {
+			return fmt.Errorf("unknown dashboard widget %q (available: %s)", name, strings.Join(WidgetNames(), ", "))
+		}
+	}
+	a.widgetOrder = append([]string(nil), names...)
+	return nil
+}
+
+// dashboardWidgets returns the names of the widgets to draw, in order
+func (a *App) dashboardWidgets() []string {
+	if a.widgetOrder == nil {
+		return DefaultWidgets
+	}
+	return a.widgetOrder
+}
+
+// keyExpiryPollInterval is how often the key expirations widget refreshes
+const keyExpiryPollInterval = time.Minute
+
+// keyExpiryMsg carries the polled expiring keys
+type keyExpiryMsg struct {
+	keys []core.SSHPublicKey
+	err  error
+}
+
+// SetKeyExpirations gives the key expirations widget its source: the keys
+// expired or about to expire
+func (a *App) SetKeyExpirations(source func() ([]core.SSHPublicKey, error)) {
+	a.keyExpirySource = source
+}
+
+// readKeyExpirations reads the expiring keys, now or after the poll
+// interval
+func (a *App) readKeyExpirations(wait time.Duration) tea.Cmd {
+	source := a.keyExpirySource
+	read := func(time.Time) tea.Msg {
+		keys, err := source()
+		return keyExpiryMsg{keys: keys, err: err}
+	}
+	if wait == 0 {
+		return func() tea.Msg { return read(time.Now()) }
+	}
+	return tea.Tick(wait, read)
+}
+
+func (a *App) handleKeyExpiry(msg keyExpiryMsg) {
+	a.keyExpiryErr = msg.err
+	if msg.err != nil {
+		return
+	}
+	a.expiringKeys = msg.keys
+	sort.Slice(a.expiringKeys, func(i, j int) bool {
+		return a.expiringKeys[i].ExpiresAt.Before(*a.expiringKeys[j].ExpiresAt)
+	})
+}
+
+// renderKeyExpirations lists the authorized keys that expired or expire
+// soon, soonest first, with their notes
+func (a *App) renderKeyExpirations(l layout) string {
+	if a.keyExpirySource == nil {
+		return ""
+	}
+
+	lines := []string{InfoStyle.Render("Key expirations")}
+	switch {
+	case a.keyExpiryErr != nil:
+		lines = append(lines, ErrorStyle.Render(IconCross+" "+a.keyExpiryErr.Error()))
+	case len(a.expiringKeys) == 0:
+		lines = append(lines, HelpDescStyle.Render("No keys expire in the next 30 days"))
+	}
+
+	now := time.Now()
+	for _, key := range a.expiringKeys {
+		name := key.Comment
+		if name == "" {
+			name = key.Fingerprint
+		}
+		note := ""
+		if key.Note != "" {
+			note = " - " + key.Note
+		}
+		if left := key.ExpiresAt.Sub(now); left > 0 {
+			lines = append(lines, StatusReadyStyle.Render(IconReady+" "+name)+
+				HelpDescStyle.Render("  expires in "+units.Duration(left)+note))
+		} else {
+			lines = append(lines, StatusStoppedStyle.Render(IconCross+" "+name)+
+				HelpDescStyle.Render("  expired "+units.Duration(-left)+" ago"+note))
+		}
+	}
+
+	return BoxStyle.
+		Width(l.panelWidth).
+		Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
+
+// recentEventLines is how many lines the recent events widget shows
+const recentEventLines = 5
+
+// renderRecentEvents shows the newest lines of the live log, or nothing
+// before there are any
+func (a *App) renderRecentEvents(l layout) string {
+	if len(a.logLines) == 0 {
+		return ""
+	}
+
+	recent := a.logLines[max(0, len(a.logLines)-recentEventLines):]
+	lines := []string{InfoStyle.Render("Recent events")}
+	clip := HelpDescStyle.MaxWidth(l.panelWidth - 4)
+	for _, line := range recent {
+		lines = append(lines, clip.Render(line))
+	}
+
+	return BoxStyle.
+		Width(l.panelWidth).
+		Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
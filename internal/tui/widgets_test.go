@@ -0,0 +1,60 @@
+package tui
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/core"
+)
+
+func TestDashboardWidgetOrder(t *testing.T) {
+	a := NewApp(8080)
+	if err := a.SetWidgets([]string{"connections", "graph"}); err == nil {
+		t.Error("SetWidgets() accepted an unknown widget")
+	}
+
+	if err := a.SetWidgets([]string{"recent_events", "connections"}); err != nil {
+		t.Fatalf("SetWidgets() error = %v", err)
+	}
+	a.handleEvent(core.NewEvent(core.EventConnected, "bore-1", nil, ""))
+	view := a.View()
+	events, status := strings.Index(view, "Recent events"), strings.Index(view, "Starting web server")
+	if events < 0 || status < 0 || events > status {
+		t.Errorf("want recent events above the status box:\n%s", view)
+	}
+
+	// Widgets left out aren't drawn
+	a.SetWidgets([]string{"recent_events"})
+	if strings.Contains(a.View(), "Starting web server") {
+		t.Error("status box drawn without the connections widget")
+	}
+}
+
+func TestKeyExpirationsWidget(t *testing.T) {
+	a := NewApp(8080)
+	a.SetWidgets([]string{"key_expirations"})
+	soon, past := time.Now().Add(72*time.Hour), time.Now().Add(-time.Hour)
+	a.SetKeyExpirations(func() ([]core.SSHPublicKey, error) {
+		return []core.SSHPublicKey{
+			{Comment: "bob@laptop", ExpiresAt: &soon},
+			{Comment: "ci-deploy", Note: "old CI runner", ExpiresAt: &past},
+		}, nil
+	})
+	a.Update(a.readKeyExpirations(0)())
+
+	view := a.View()
+	expired, expiring := strings.Index(view, "ci-deploy"), strings.Index(view, "bob@laptop")
+	if expired < 0 || expiring < 0 || expired > expiring {
+		t.Errorf("want the expired key first:\n%s", view)
+	}
+	if !strings.Contains(view, "old CI runner") {
+		t.Errorf("key note not shown:\n%s", view)
+	}
+
+	a.Update(keyExpiryMsg{err: errors.New("permission denied")})
+	if !strings.Contains(a.View(), "permission denied") {
+		t.Error("read error not shown")
+	}
+}
@@ -0,0 +1,182 @@
+package units
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimeStyle selects how points in time are shown
+type TimeStyle string
+
+const (
+	ISO        TimeStyle = "iso"      // 2026-10-18 14:05
+	RFC3339    TimeStyle = "rfc3339"  // 2026-10-18T14:05:09+02:00
+	Relative   TimeStyle = "relative" // 2m ago
+	LocaleTime TimeStyle = "locale"   // 18.10.2026 14:05 in de-DE
+)
+
+// relativeLimit is how far from now relative times go before falling back
+// to the date
+const relativeLimit = 30 * 24 * time.Hour
+
+// now is the clock relative times are measured against
+var now = time.Now
+
+// timeLayout is how a locale writes dates and times of day, as
+// time.Format layouts
+type timeLayout struct {
+	date  string
+	clock string // to the second
+}
+
+// timeLayouts lists date and time layouts by language, or language and
+// region, looked up like the number separators
+var timeLayouts = map[string]timeLayout{
+	"c":     {"2006-01-02", "15:04:05"},
+	"en":    {"01/02/2006", "3:04:05 PM"},
+	"en-gb": {"02/01/2006", "15:04:05"},
+	"en-ie": {"02/01/2006", "15:04:05"},
+	"en-au": {"02/01/2006", "3:04:05 pm"},
+	"en-nz": {"02/01/2006", "3:04:05 pm"},
+	"en-in": {"02/01/2006", "3:04:05 pm"},
+	"en-ca": {"2006-01-02", "3:04:05 p.m."},
+	"de":    {"02.01.2006", "15:04:05"},
+	"da":    {"02.01.2006", "15.04.05"},
+	"nb":    {"02.01.2006", "15:04:05"},
+	"fi":    {"2.1.2006", "15.04.05"},
+	"ru":    {"02.01.2006", "15:04:05"},
+	"uk":    {"02.01.2006", "15:04:05"},
+	"pl":    {"02.01.2006", "15:04:05"},
+	"cs":    {"2. 1. 2006", "15:04:05"},
+	"tr":    {"02.01.2006", "15:04:05"},
+	"fr":    {"02/01/2006", "15:04:05"},
+	"es":    {"02/01/2006", "15:04:05"},
+	"it":    {"02/01/2006", "15:04:05"},
+	"pt":    {"02/01/2006", "15:04:05"},
+	"nl":    {"02-01-2006", "15:04:05"},
+	"sv":    {"2006-01-02", "15:04:05"},
+	"ja":    {"2006/01/02", "15:04:05"},
+	"zh":    {"2006/01/02", "15:04:05"},
+	"ko":    {"2006. 01. 02.", "15:04:05"},
+}
+
+func lookupTimeLayout(locale string) timeLayout {
+	tag := strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+	if tag == "posix" {
+		tag = "c"
+	}
+	if l, ok := timeLayouts[tag]; ok {
+		return l
+	}
+	lang, _, _ := strings.Cut(tag, "-")
+	if l, ok := timeLayouts[lang]; ok {
+		return l
+	}
+	return timeLayouts["en"]
+}
+
+// loadZone looks up a time zone by name; empty and "local" are the
+// machine's own. An unknown name gives the machine's zone and an error.
+func loadZone(name string) (*time.Location, error) {
+	switch strings.ToLower(name) {
+	case "", "local":
+		return time.Local, nil
+	case "utc":
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.Local, err
+	}
+	return loc, nil
+}
+
+// Zone returns the time zone times are shown in
+func Zone() *time.Location {
+	return get().zone
+}
+
+// Time renders t to the minute in the configured time style and zone, e.g.
+// 2026-10-18 14:05, 18.10.2026 14:05 or 2m ago
+func Time(t time.Time) string {
+	return formatTime(t, false)
+}
+
+// TimeExact renders t like Time but to the second
+func TimeExact(t time.Time) string {
+	return formatTime(t, true)
+}
+
+// Clock renders the time of day of t for lines that are printed once as
+// they happen, such as log and event lines. The relative style shows the
+// time of day too, since "2m ago" would be wrong a minute later.
+func Clock(t time.Time) string {
+	s := get()
+	t = t.In(s.zone)
+	switch s.times {
+	case RFC3339:
+		return t.Format(time.RFC3339)
+	case LocaleTime:
+		return t.Format(s.layout.clock)
+	}
+	return t.Format(time.TimeOnly)
+}
+
+func formatTime(t time.Time, seconds bool) string {
+	s := get()
+	t = t.In(s.zone)
+	switch s.times {
+	case RFC3339:
+		return t.Format(time.RFC3339)
+	case Relative:
+		if rel, ok := relative(t, s); ok {
+			return rel
+		}
+		return t.Format(time.DateOnly)
+	case LocaleTime:
+		clock := s.layout.clock
+		if !seconds {
+			clock = strings.Replace(clock, ":05", "", 1)
+			clock = strings.Replace(clock, ".05", "", 1)
+		}
+		return t.Format(s.layout.date + " " + clock)
+	}
+	if seconds {
+		return t.Format(time.DateTime)
+	}
+	return t.Format("2006-01-02 15:04")
+}
+
+// relative renders t as its largest unit of distance from now, e.g. 2m ago
+// or in 3h; times further than relativeLimit away are left to the caller
+func relative(t time.Time, s settings) (string, bool) {
+	d := now().Sub(t)
+	future := d < 0
+	if future {
+		d = -d
+	}
+	if d > relativeLimit {
+		return "", false
+	}
+	if d < time.Second {
+		return "just now", true
+	}
+
+	var amount string
+	for _, u := range durationUnits {
+		if d >= u.size {
+			n := int64(d / u.size)
+			if s.durations == Long {
+				amount = plural(n, u.long)
+			} else {
+				amount = fmt.Sprintf("%d%s", n, u.short)
+			}
+			break
+		}
+	}
+	if future {
+		return "in " + amount, true
+	}
+	return amount + " ago", true
+}
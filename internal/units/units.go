@@ -0,0 +1,376 @@
+// Package units formats byte counts, durations, numbers and times for
+// people to read, in the unit style, locale and time zone the user
+// configured, so the TUI, CLI and reports show the same value the same way. The Exact variants keep
+// full precision for detail views and tooltips; structured output (JSON,
+// YAML) should carry raw values instead.
+package units
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Style selects the units byte counts are shown in
+type Style string
+
+const (
+	IEC Style = "iec" // KiB, MiB, ...: powers of 1024
+	SI  Style = "si"  // kB, MB, ...: powers of 1000
+)
+
+// DurationStyle selects how durations are spelled
+type DurationStyle string
+
+const (
+	Short DurationStyle = "short" // 2d 3h
+	Long  DurationStyle = "long"  // 2 days 3 hours
+)
+
+// Options configures formatting. Zero fields take their defaults: IEC
+// units, short durations, the locale of the environment and ISO-style
+// times in the machine's time zone.
+type Options struct {
+	Units     Style
+	Durations DurationStyle
+	Locale    string // e.g. "de-DE" or "fr"; empty reads LC_ALL, LC_NUMERIC and LANG
+	Times     TimeStyle
+	Timezone  string // e.g. "UTC" or "Europe/Berlin"; empty or "local" is the machine's
+}
+
+// separators are the digit group and decimal separators of a locale
+type separators struct {
+	group   string
+	decimal string
+}
+
+// locales lists separators by language, or language and region where the
+// region differs from the language's default. Spaces are no-break spaces so
+// numbers don't wrap.
+var locales = map[string]separators{
+	"c":     {"", "."},
+	"en":    {",", "."},
+	"ja":    {",", "."},
+	"ko":    {",", "."},
+	"zh":    {",", "."},
+	"de":    {".", ","},
+	"es":    {".", ","},
+	"it":    {".", ","},
+	"nl":    {".", ","},
+	"pt":    {".", ","},
+	"da":    {".", ","},
+	"tr":    {".", ","},
+	"fr":    {"\u202f", ","},
+	"ru":    {"\u00a0", ","},
+	"pl":    {"\u00a0", ","},
+	"cs":    {"\u00a0", ","},
+	"sv":    {"\u00a0", ","},
+	"nb":    {"\u00a0", ","},
+	"fi":    {"\u00a0", ","},
+	"uk":    {"\u00a0", ","},
+	"de-ch": {"’", "."},
+	"fr-ch": {"\u202f", "."},
+	"pt-br": {".", ","},
+	"es-mx": {",", "."},
+}
+
+type settings struct {
+	units     Style
+	durations DurationStyle
+	locale    string
+	sep       separators
+	times     TimeStyle
+	zone      *time.Location
+	layout    timeLayout
+}
+
+var (
+	mu      sync.RWMutex
+	current = resolve(Options{})
+)
+
+// Configure sets how values are formatted from now on
+func Configure(opts Options) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	s := resolve(opts)
+	mu.Lock()
+	current = s
+	mu.Unlock()
+	return nil
+}
+
+// Validate checks the unit, duration and time styles and the time zone
+func (o Options) Validate() error {
+	switch o.Units {
+	case "", IEC, SI:
+	default:
+		return fmt.Errorf("unknown unit style %q (valid: iec, si)", o.Units)
+	}
+	switch o.Durations {
+	case "", Short, Long:
+	default:
+		return fmt.Errorf("unknown duration style %q (valid: short, long)", o.Durations)
+	}
+	switch o.Times {
+	case "", ISO, RFC3339, Relative, LocaleTime:
+	default:
+		return fmt.Errorf("unknown time format %q (valid: iso, rfc3339, relative, locale)", o.Times)
+	}
+	if _, err := loadZone(o.Timezone); err != nil {
+		return fmt.Errorf("unknown time zone %q: %w", o.Timezone, err)
+	}
+	return nil
+}
+
+// Locale returns the locale numbers are formatted for
+func Locale() string {
+	return get().locale
+}
+
+func get() settings {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// resolve fills in the defaults of opts
+func resolve(opts Options) settings {
+	s := settings{units: opts.Units, durations: opts.Durations, locale: opts.Locale}
+	if s.units == "" {
+		s.units = IEC
+	}
+	if s.durations == "" {
+		s.durations = Short
+	}
+	if s.locale == "" {
+		s.locale = envLocale()
+	}
+	s.sep = lookupSeparators(s.locale)
+	s.layout = lookupTimeLayout(s.locale)
+	s.times = opts.Times
+	if s.times == "" {
+		s.times = ISO
+	}
+	s.zone, _ = loadZone(opts.Timezone)
+	return s
+}
+
+// envLocale reads the numeric locale from the environment, turning
+// "de_DE.UTF-8" into "de-DE"
+func envLocale() string {
+	for _, name := range []string{"LC_ALL", "LC_NUMERIC", "LANG"} {
+		if v := os.Getenv(name); v != "" {
+			v, _, _ = strings.Cut(v, ".")
+			v, _, _ = strings.Cut(v, "@")
+			return strings.ReplaceAll(v, "_", "-")
+		}
+	}
+	return "en"
+}
+
+func lookupSeparators(locale string) separators {
+	tag := strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+	if tag == "posix" {
+		tag = "c"
+	}
+	if sep, ok := locales[tag]; ok {
+		return sep
+	}
+	lang, _, _ := strings.Cut(tag, "-")
+	if sep, ok := locales[lang]; ok {
+		return sep
+	}
+	return locales["en"]
+}
+
+// Number renders n with the locale's digit grouping, e.g. 1,234,567
+func Number(n int64) string {
+	return group(strconv.FormatInt(n, 10), get().sep)
+}
+
+// Decimal renders f with prec decimal places in the locale's notation
+func Decimal(f float64, prec int) string {
+	return decimal(f, prec, get().sep)
+}
+
+// Percent renders p, already scaled to 0-100, with one decimal place
+func Percent(p float64) string {
+	return Decimal(p, 1) + "%"
+}
+
+func decimal(f float64, prec int, sep separators) string {
+	s := strconv.FormatFloat(f, 'f', prec, 64)
+	whole, frac, hasFrac := strings.Cut(s, ".")
+	whole = group(whole, sep)
+	if !hasFrac {
+		return whole
+	}
+	return whole + sep.decimal + frac
+}
+
+// group inserts the group separator every three digits of an integer
+func group(digits string, sep separators) string {
+	sign := ""
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = "-", digits[1:]
+	}
+	if sep.group == "" || len(digits) <= 3 {
+		return sign + digits
+	}
+	var b strings.Builder
+	lead := len(digits) % 3
+	if lead > 0 {
+		b.WriteString(digits[:lead])
+	}
+	for i := lead; i < len(digits); i += 3 {
+		if b.Len() > 0 {
+			b.WriteString(sep.group)
+		}
+		b.WriteString(digits[i : i+3])
+	}
+	return sign + b.String()
+}
+
+// Bytes renders n in the configured unit style with one decimal place,
+// e.g. 1.5 GiB or 1.6 GB
+func Bytes(n int64) string {
+	s := get()
+	base, names := int64(1024), []string{"KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+	if s.units == SI {
+		base, names = 1000, []string{"kB", "MB", "GB", "TB", "PB", "EB"}
+	}
+
+	abs := n
+	if abs < 0 {
+		abs = -abs
+	}
+	if abs < base {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := base, 0
+	for m := abs / base; m >= base && exp < len(names)-1; m /= base {
+		div *= base
+		exp++
+	}
+	return decimal(float64(n)/float64(div), 1, s.sep) + " " + names[exp]
+}
+
+// BytesExact renders n in full, e.g. 1,610,612,736 bytes
+func BytesExact(n int64) string {
+	if n == 1 {
+		return "1 byte"
+	}
+	return Number(n) + " bytes"
+}
+
+// durationUnits are the units durations are broken into, largest first
+var durationUnits = []struct {
+	size        time.Duration
+	short, long string
+}{
+	{24 * time.Hour, "d", "day"},
+	{time.Hour, "h", "hour"},
+	{time.Minute, "m", "minute"},
+	{time.Second, "s", "second"},
+}
+
+// Duration renders d in its two largest non-zero units, e.g. 2d 3h or
+// 4m 5s, in the configured duration style. Durations under a second are
+// shown in milliseconds.
+func Duration(d time.Duration) string {
+	s := get()
+	sign := ""
+	if d < 0 {
+		sign, d = "-", -d
+	}
+	if d == 0 {
+		if s.durations == Long {
+			return "0 seconds"
+		}
+		return "0s"
+	}
+	if d < time.Second {
+		ms := d.Round(time.Millisecond) / time.Millisecond
+		if s.durations == Long {
+			return sign + plural(int64(ms), "millisecond")
+		}
+		return fmt.Sprintf("%s%dms", sign, ms)
+	}
+
+	// Round to the smaller of the two units shown
+	for i, u := range durationUnits {
+		if d >= u.size {
+			if i+1 < len(durationUnits) {
+				d = d.Round(durationUnits[i+1].size)
+			}
+			break
+		}
+	}
+
+	var parts []string
+	for _, u := range durationUnits {
+		if len(parts) == 2 {
+			break
+		}
+		n := int64(d / u.size)
+		d -= time.Duration(n) * u.size
+		if n == 0 {
+			if len(parts) > 0 {
+				break
+			}
+			continue
+		}
+		if s.durations == Long {
+			parts = append(parts, plural(n, u.long))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d%s", n, u.short))
+		}
+	}
+	return sign + strings.Join(parts, " ")
+}
+
+// DurationExact renders d in every unit down to the millisecond, e.g.
+// 2d 3h 4m 5.123s
+func DurationExact(d time.Duration) string {
+	sign := ""
+	if d < 0 {
+		sign, d = "-", -d
+	}
+	d = d.Round(time.Millisecond)
+
+	var parts []string
+	for _, u := range durationUnits[:3] {
+		if n := d / u.size; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d%s", n, u.short))
+			d -= n * u.size
+		}
+	}
+	if d > 0 || len(parts) == 0 {
+		seconds := decimal(d.Seconds(), 3, get().sep)
+		seconds = strings.TrimRight(strings.TrimRight(seconds, "0"), get().sep.decimal)
+		parts = append(parts, seconds+"s")
+	}
+	return sign + strings.Join(parts, " ")
+}
+
+// Detail joins a rounded value and its exact form for detail views, e.g.
+// "1.5 GiB (1,610,612,736 bytes)", leaving out the exact form when it adds
+// nothing
+func Detail(rounded, exact string) string {
+	if rounded == exact {
+		return rounded
+	}
+	return rounded + " (" + exact + ")"
+}
+
+func plural(n int64, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return group(strconv.FormatInt(n, 10), get().sep) + " " + unit + "s"
+}
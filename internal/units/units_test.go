@@ -0,0 +1,155 @@
+package units
+
+import (
+	"testing"
+	"time"
+)
+
+// configure sets opts for the rest of the test
+func configure(t *testing.T, opts Options) {
+	t.Helper()
+	if err := Configure(opts); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = Configure(Options{}) })
+}
+
+func TestBytes(t *testing.T) {
+	tests := []struct {
+		opts Options
+		n    int64
+		want string
+	}{
+		{Options{Locale: "en"}, 512, "512 B"},
+		{Options{Locale: "en"}, 1536, "1.5 KiB"},
+		{Options{Locale: "en"}, 1610612736, "1.5 GiB"},
+		{Options{Locale: "en", Units: SI}, 1536, "1.5 kB"},
+		{Options{Locale: "en", Units: SI}, 1610612736, "1.6 GB"},
+		{Options{Locale: "de-DE"}, 1536, "1,5 KiB"},
+	}
+	for _, tt := range tests {
+		configure(t, tt.opts)
+		if got := Bytes(tt.n); got != tt.want {
+			t.Errorf("Bytes(%d) with %+v = %q, want %q", tt.n, tt.opts, got, tt.want)
+		}
+	}
+}
+
+func TestNumber(t *testing.T) {
+	tests := []struct {
+		locale string
+		want   string
+	}{
+		{"en-US", "1,610,612,736"},
+		{"de_DE.UTF-8", "1.610.612.736"},
+		{"fr", "1 610 612 736"},
+		{"de-CH", "1’610’612’736"},
+		{"C", "1610612736"},
+		{"xx", "1,610,612,736"},
+	}
+	for _, tt := range tests {
+		configure(t, Options{Locale: tt.locale})
+		if got := Number(1610612736); got != tt.want {
+			t.Errorf("Number in %s = %q, want %q", tt.locale, got, tt.want)
+		}
+	}
+
+	configure(t, Options{Locale: "en"})
+	if got := Number(-1234); got != "-1,234" {
+		t.Errorf("Number(-1234) = %q", got)
+	}
+	if got := BytesExact(1610612736); got != "1,610,612,736 bytes" {
+		t.Errorf("BytesExact = %q", got)
+	}
+}
+
+func TestDuration(t *testing.T) {
+	d := 2*24*time.Hour + 3*time.Hour + 4*time.Minute + 5*time.Second + 123*time.Millisecond
+
+	configure(t, Options{Locale: "en"})
+	for in, want := range map[time.Duration]string{
+		0:                             "0s",
+		250 * time.Millisecond:        "250ms",
+		45 * time.Second:              "45s",
+		4*time.Minute + 5*time.Second: "4m 5s",
+		3*time.Hour + 59*time.Second:  "3h 1m",
+		24 * time.Hour:                "1d",
+		d:                             "2d 3h",
+		23*time.Hour + 59*time.Minute + 40*time.Second: "1d",
+	} {
+		if got := Duration(in); got != want {
+			t.Errorf("Duration(%s) = %q, want %q", in, got, want)
+		}
+	}
+	if got := DurationExact(d); got != "2d 3h 4m 5.123s" {
+		t.Errorf("DurationExact = %q", got)
+	}
+	if got := Detail(Duration(d), DurationExact(d)); got != "2d 3h (2d 3h 4m 5.123s)" {
+		t.Errorf("Detail = %q", got)
+	}
+
+	configure(t, Options{Locale: "en", Durations: Long})
+	if got := Duration(d); got != "2 days 3 hours" {
+		t.Errorf("long Duration = %q", got)
+	}
+	if got := Duration(time.Hour); got != "1 hour" {
+		t.Errorf("long Duration(1h) = %q", got)
+	}
+}
+
+func TestConfigureRejectsUnknownStyles(t *testing.T) {
+	if err := Configure(Options{Units: "binary"}); err == nil {
+		t.Error("expected an error for an unknown unit style")
+	}
+	if err := Configure(Options{Durations: "clock"}); err == nil {
+		t.Error("expected an error for an unknown duration style")
+	}
+	if err := Configure(Options{Times: "epoch"}); err == nil {
+		t.Error("expected an error for an unknown time format")
+	}
+	if err := Configure(Options{Timezone: "Nowhere/Special"}); err == nil {
+		t.Error("expected an error for an unknown time zone")
+	}
+}
+
+func TestTime(t *testing.T) {
+	at := time.Date(2026, 10, 18, 12, 5, 9, 0, time.UTC)
+	now = func() time.Time { return at.Add(2*time.Minute + 30*time.Second) }
+	t.Cleanup(func() { now = time.Now })
+
+	tests := []struct {
+		opts        Options
+		time, exact string
+		clock       string
+	}{
+		{Options{Timezone: "UTC"}, "2026-10-18 12:05", "2026-10-18 12:05:09", "12:05:09"},
+		{Options{Timezone: "Europe/Berlin"}, "2026-10-18 14:05", "2026-10-18 14:05:09", "14:05:09"},
+		{Options{Timezone: "UTC", Times: RFC3339}, "2026-10-18T12:05:09Z", "2026-10-18T12:05:09Z", "2026-10-18T12:05:09Z"},
+		{Options{Timezone: "UTC", Times: Relative}, "2m ago", "2m ago", "12:05:09"},
+		{Options{Timezone: "UTC", Times: Relative, Durations: Long}, "2 minutes ago", "2 minutes ago", "12:05:09"},
+		{Options{Timezone: "UTC", Times: LocaleTime, Locale: "de-DE"}, "18.10.2026 12:05", "18.10.2026 12:05:09", "12:05:09"},
+		{Options{Timezone: "UTC", Times: LocaleTime, Locale: "en-US"}, "10/18/2026 12:05 PM", "10/18/2026 12:05:09 PM", "12:05:09 PM"},
+		{Options{Timezone: "UTC", Times: LocaleTime, Locale: "fi"}, "18.10.2026 12.05", "18.10.2026 12.05.09", "12.05.09"},
+	}
+	for _, tt := range tests {
+		configure(t, tt.opts)
+		if got := Time(at); got != tt.time {
+			t.Errorf("Time with %+v = %q, want %q", tt.opts, got, tt.time)
+		}
+		if got := TimeExact(at); got != tt.exact {
+			t.Errorf("TimeExact with %+v = %q, want %q", tt.opts, got, tt.exact)
+		}
+		if got := Clock(at); got != tt.clock {
+			t.Errorf("Clock with %+v = %q, want %q", tt.opts, got, tt.clock)
+		}
+	}
+
+	// Relative times in the future, and too far off to be worth counting
+	configure(t, Options{Timezone: "UTC", Times: Relative})
+	if got := Time(now().Add(3 * time.Hour)); got != "in 3h" {
+		t.Errorf("Time 3h ahead = %q, want in 3h", got)
+	}
+	if got := Time(at.AddDate(0, -3, 0)); got != "2026-07-18" {
+		t.Errorf("Time 3 months back = %q, want the date", got)
+	}
+}
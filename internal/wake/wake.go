@@ -0,0 +1,226 @@
+// Package wake starts tunnels on demand. A listener holds a local port
+// while its tunnel is down; the first connection to it brings the tunnel up
+// and is then handed through to the address the tunnel makes reachable.
+// Tunnels the listener started are stopped again once idle.
+package wake
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultStartTimeout bounds how long a connection waits for its tunnel
+const DefaultStartTimeout = 30 * time.Second
+
+// Tunnel is the part of a provider a listener drives
+type Tunnel interface {
+	Name() string
+	Connect() error
+	Disconnect() error
+	IsConnected() bool
+}
+
+// Listener accepts connections on Addr and forwards them to Target,
+// starting Tunnel first if it is down
+type Listener struct {
+	Addr         string
+	Target       string
+	Tunnel       Tunnel
+	IdleTimeout  time.Duration // stop a tunnel the listener started after this long unused; 0 never stops it
+	StartTimeout time.Duration // wait this long for Target once the tunnel is starting
+	Logger       *log.Logger
+
+	startMu sync.Mutex // serializes tunnel starts
+
+	mu      sync.Mutex
+	ln      net.Listener
+	active  int         // connections being forwarded
+	started bool        // the listener started the tunnel, so it may stop it
+	idle    *time.Timer // pending idle stop
+}
+
+// Listen binds the listener's address
+func (l *Listener) Listen() error {
+	ln, err := net.Listen("tcp", l.Addr)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", l.Addr, err)
+	}
+	l.mu.Lock()
+	l.ln = ln
+	l.mu.Unlock()
+	return nil
+}
+
+// Serve forwards connections until ctx is done or the listener is closed
+func (l *Listener) Serve(ctx context.Context) error {
+	l.mu.Lock()
+	ln := l.ln
+	l.mu.Unlock()
+	if ln == nil {
+		return errors.New("wake listener is not listening")
+	}
+
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("accept on %s: %w", l.Addr, err)
+		}
+		go l.handle(ctx, conn)
+	}
+}
+
+// Close stops accepting connections and cancels a pending idle stop. A
+// tunnel the listener started is left to the caller's shutdown.
+func (l *Listener) Close(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.idle != nil {
+		l.idle.Stop()
+	}
+	if l.ln == nil {
+		return nil
+	}
+	return l.ln.Close()
+}
+
+// handle brings the tunnel up if needed and forwards conn to the target
+func (l *Listener) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	l.acquire()
+	defer l.release()
+
+	timeout := l.StartTimeout
+	if timeout <= 0 {
+		timeout = DefaultStartTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := l.ensureStarted(); err != nil {
+		l.logf("wake %s: start %s: %v", l.Addr, l.Tunnel.Name(), err)
+		return
+	}
+	upstream, err := dialUntil(ctx, l.Target)
+	if err != nil {
+		l.logf("wake %s: %s did not become reachable through %s: %v", l.Addr, l.Target, l.Tunnel.Name(), err)
+		return
+	}
+	defer upstream.Close()
+
+	pipe(conn, upstream)
+}
+
+// ensureStarted connects the tunnel unless it is already up
+func (l *Listener) ensureStarted() error {
+	l.startMu.Lock()
+	defer l.startMu.Unlock()
+
+	if l.Tunnel.IsConnected() {
+		return nil
+	}
+	if err := l.Tunnel.Connect(); err != nil {
+		return err
+	}
+	l.logf("wake %s: started %s on demand", l.Addr, l.Tunnel.Name())
+
+	l.mu.Lock()
+	l.started = true
+	l.mu.Unlock()
+	return nil
+}
+
+func (l *Listener) acquire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active++
+	if l.idle != nil {
+		l.idle.Stop()
+		l.idle = nil
+	}
+}
+
+// release schedules an idle stop once the last connection is done
+func (l *Listener) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	if l.active == 0 && l.started && l.IdleTimeout > 0 {
+		l.idle = time.AfterFunc(l.IdleTimeout, l.stopIfIdle)
+	}
+}
+
+// stopIfIdle stops the tunnel if no connection arrived since the timer was set
+func (l *Listener) stopIfIdle() {
+	l.startMu.Lock()
+	defer l.startMu.Unlock()
+
+	l.mu.Lock()
+	if l.active > 0 || !l.started {
+		l.mu.Unlock()
+		return
+	}
+	l.started = false
+	l.idle = nil
+	l.mu.Unlock()
+
+	if err := l.Tunnel.Disconnect(); err != nil {
+		l.logf("wake %s: stop idle %s: %v", l.Addr, l.Tunnel.Name(), err)
+		return
+	}
+	l.logf("wake %s: stopped %s after %s idle", l.Addr, l.Tunnel.Name(), l.IdleTimeout)
+}
+
+func (l *Listener) logf(format string, args ...interface{}) {
+	if l.Logger != nil {
+		l.Logger.Printf(format, args...)
+	}
+}
+
+// dialUntil retries target until it accepts a connection or ctx is done;
+// tunnels take a moment to route after Connect returns
+func dialUntil(ctx context.Context, target string) (net.Conn, error) {
+	var d net.Dialer
+	for {
+		conn, err := d.DialContext(ctx, "tcp", target)
+		if err == nil {
+			return conn, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
+
+// pipe copies between a and b until both directions are done
+func pipe(a, b net.Conn) {
+	var wg sync.WaitGroup
+	copyHalf := func(dst, src net.Conn) {
+		defer wg.Done()
+		io.Copy(dst, src)
+		if tcp, ok := dst.(*net.TCPConn); ok {
+			tcp.CloseWrite()
+		} else {
+			dst.Close()
+		}
+	}
+	wg.Add(2)
+	go copyHalf(a, b)
+	go copyHalf(b, a)
+	wg.Wait()
+}
@@ -0,0 +1,151 @@
+package wake
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// echoTunnel makes an echo server reachable only while connected
+type echoTunnel struct {
+	addr string
+
+	mu          sync.Mutex
+	ln          net.Listener
+	connects    int
+	disconnects int
+}
+
+func (e *echoTunnel) Name() string { return "echo" }
+
+func (e *echoTunnel) Connect() error {
+	ln, err := net.Listen("tcp", e.addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.ln = ln
+	e.connects++
+	return nil
+}
+
+func (e *echoTunnel) Disconnect() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.disconnects++
+	err := e.ln.Close()
+	e.ln = nil
+	return err
+}
+
+func (e *echoTunnel) IsConnected() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.ln != nil
+}
+
+func (e *echoTunnel) counts() (int, int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.connects, e.disconnects
+}
+
+// freeAddr returns a local address nothing listens on
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func TestListenerStartsTunnelOnDemand(t *testing.T) {
+	tunnel := &echoTunnel{addr: freeAddr(t)}
+	l := &Listener{
+		Addr:         "127.0.0.1:0",
+		Target:       tunnel.addr,
+		Tunnel:       tunnel,
+		IdleTimeout:  50 * time.Millisecond,
+		StartTimeout: 2 * time.Second,
+	}
+	if err := l.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go l.Serve(ctx)
+
+	if tunnel.IsConnected() {
+		t.Fatal("tunnel started before first use")
+	}
+
+	conn, err := net.Dial("tcp", l.ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte("ping\n")); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || reply != "ping\n" {
+		t.Fatalf("reply = %q, %v; want the first connection handed through", reply, err)
+	}
+	conn.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for tunnel.IsConnected() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if connects, disconnects := tunnel.counts(); connects != 1 || disconnects != 1 {
+		t.Errorf("connects = %d, disconnects = %d; want the tunnel started once and stopped when idle", connects, disconnects)
+	}
+}
+
+func TestListenerLeavesForeignTunnelRunning(t *testing.T) {
+	tunnel := &echoTunnel{addr: freeAddr(t)}
+	if err := tunnel.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer tunnel.Disconnect()
+
+	l := &Listener{Addr: "127.0.0.1:0", Target: tunnel.addr, Tunnel: tunnel, IdleTimeout: time.Millisecond}
+	if err := l.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go l.Serve(ctx)
+
+	conn, err := net.Dial("tcp", l.ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("x"))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	conn.Read(make([]byte, 1))
+	conn.Close()
+
+	time.Sleep(50 * time.Millisecond)
+	if _, disconnects := tunnel.counts(); disconnects != 0 || !tunnel.IsConnected() {
+		t.Error("listener stopped a tunnel it did not start")
+	}
+}
@@ -0,0 +1,114 @@
+package watchdog
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/providers"
+)
+
+// Providers watches that the providers list returns keep answering. One
+// whose IsConnected doesn't return within timeout has a supervisor that
+// stopped responding, typically stuck holding its lock. Restarting it
+// disconnects and connects it again.
+func Providers(list func() []providers.Provider, timeout time.Duration) Component {
+	p := &prober{list: list, timeout: timeout, pending: make(map[string]<-chan struct{})}
+	return Component{Name: "provider supervisor", Check: p.check, Restart: p.restart}
+}
+
+// prober calls providers in the background so that a stuck one can't
+// block the watchdog
+type prober struct {
+	list    func() []providers.Provider
+	timeout time.Duration
+
+	mu      sync.Mutex
+	pending map[string]<-chan struct{} // probes still waiting for an answer, by provider
+	stuck   []providers.Provider       // found by the last check
+}
+
+func (p *prober) check() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	type probe struct {
+		provider providers.Provider
+		done     <-chan struct{}
+	}
+	var probes []probe
+	for _, provider := range p.list() {
+		done, waiting := p.pending[provider.Name()]
+		if !waiting {
+			provider := provider
+			done = inBackground(func() { provider.IsConnected() })
+			p.pending[provider.Name()] = done
+		}
+		probes = append(probes, probe{provider, done})
+	}
+
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+	expired := false
+	p.stuck = nil
+	var names []string
+	for _, pr := range probes {
+		if !expired {
+			select {
+			case <-pr.done:
+				delete(p.pending, pr.provider.Name())
+				continue
+			case <-timer.C:
+				expired = true
+			}
+		}
+		select {
+		case <-pr.done:
+			delete(p.pending, pr.provider.Name())
+		default:
+			p.stuck = append(p.stuck, pr.provider)
+			names = append(names, pr.provider.Name())
+		}
+	}
+
+	if len(names) > 0 {
+		return fmt.Errorf("%s not responding after %s", strings.Join(names, ", "), p.timeout)
+	}
+	return nil
+}
+
+func (p *prober) restart() error {
+	p.mu.Lock()
+	stuck := p.stuck
+	p.mu.Unlock()
+
+	var failed []string
+	for _, provider := range stuck {
+		provider := provider
+		done := inBackground(func() {
+			provider.Disconnect()
+			provider.Connect()
+		})
+		select {
+		case <-done:
+		case <-time.After(p.timeout):
+			failed = append(failed, provider.Name())
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%s did not respond to a restart", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// inBackground runs fn in a goroutine and returns a channel closed when it
+// returns
+func inBackground(fn func()) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+	return done
+}
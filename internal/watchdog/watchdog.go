@@ -0,0 +1,206 @@
+// Package watchdog keeps a long-running process healthy. It checks its
+// subsystems on an interval, restarts one that is stuck, and when restarts
+// don't help it escalates, normally to a controlled restart of the whole
+// process.
+package watchdog
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Defaults for a Watchdog's settings left at zero
+const (
+	DefaultInterval    = 30 * time.Second
+	DefaultMaxRestarts = 3
+	DefaultWindow      = 10 * time.Minute
+)
+
+// maxInterventions bounds the interventions a watchdog remembers
+const maxInterventions = 100
+
+// Component is a subsystem the watchdog checks
+type Component struct {
+	Name string
+
+	// Check returns why the component is stuck, or nil while it works. It
+	// must not block on the component it checks.
+	Check func() error
+
+	// Restart brings the component back. A component without one can only
+	// be escalated.
+	Restart func() error
+}
+
+// Intervention is something the watchdog did about a stuck component
+type Intervention struct {
+	At        time.Time `json:"at"`
+	Component string    `json:"component"`
+	Problem   string    `json:"problem"`
+	Action    string    `json:"action"` // restarted, restart failed or escalated
+	Error     string    `json:"error,omitempty"`
+}
+
+// Watchdog checks components and intervenes when they are stuck
+type Watchdog struct {
+	Interval    time.Duration // between rounds of checks
+	MaxRestarts int           // restarts of one component within Window before escalating
+	Window      time.Duration
+	Logger      *log.Logger
+
+	// Escalate is called once, when restarting a component didn't help.
+	// It should return promptly; the watchdog stops checking afterwards.
+	Escalate func(reason string)
+
+	mu            sync.Mutex
+	components    []Component
+	restarts      map[string][]time.Time
+	interventions []Intervention
+	escalated     bool
+	now           func() time.Time
+}
+
+// New creates a watchdog with the default settings that calls escalate
+// when restarts don't help
+func New(escalate func(reason string)) *Watchdog {
+	return &Watchdog{
+		Interval:    DefaultInterval,
+		MaxRestarts: DefaultMaxRestarts,
+		Window:      DefaultWindow,
+		Escalate:    escalate,
+		restarts:    make(map[string][]time.Time),
+		now:         time.Now,
+	}
+}
+
+// Watch adds c to the components checked
+func (w *Watchdog) Watch(c Component) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.components = append(w.components, c)
+}
+
+// Run checks the components every Interval until ctx is done
+func (w *Watchdog) Run(ctx context.Context) {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.CheckNow()
+		}
+	}
+}
+
+// CheckNow checks every component once and returns the interventions made
+func (w *Watchdog) CheckNow() []Intervention {
+	w.mu.Lock()
+	components := append([]Component(nil), w.components...)
+	w.mu.Unlock()
+
+	var made []Intervention
+	for _, c := range components {
+		w.mu.Lock()
+		escalated := w.escalated
+		w.mu.Unlock()
+		if escalated {
+			break
+		}
+
+		problem := c.Check()
+		if problem == nil {
+			continue
+		}
+		made = append(made, w.intervene(c, problem))
+	}
+	return made
+}
+
+// intervene restarts c, or escalates once c has been restarted too often
+func (w *Watchdog) intervene(c Component, problem error) Intervention {
+	w.mu.Lock()
+	now := w.now()
+	recent := w.recentRestarts(c.Name, now)
+	maxRestarts := w.MaxRestarts
+	if maxRestarts <= 0 {
+		maxRestarts = DefaultMaxRestarts
+	}
+
+	in := Intervention{At: now, Component: c.Name, Problem: problem.Error()}
+	if c.Restart == nil || len(recent) >= maxRestarts {
+		w.escalated = true
+		in.Action = "escalated"
+		w.record(in)
+		w.mu.Unlock()
+
+		reason := fmt.Sprintf("%s: %s", c.Name, problem)
+		if c.Restart != nil {
+			reason = fmt.Sprintf("%s, still stuck after %d restarts", reason, len(recent))
+		}
+		if w.Escalate != nil {
+			w.Escalate(reason)
+		}
+		return in
+	}
+	w.restarts[c.Name] = append(recent, now)
+	w.mu.Unlock()
+
+	in.Action = "restarted"
+	if err := c.Restart(); err != nil {
+		in.Action = "restart failed"
+		in.Error = err.Error()
+	}
+	w.mu.Lock()
+	w.record(in)
+	w.mu.Unlock()
+	return in
+}
+
+// recentRestarts returns the restarts of name within the window; w.mu
+// must be held
+func (w *Watchdog) recentRestarts(name string, now time.Time) []time.Time {
+	window := w.Window
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	var recent []time.Time
+	for _, at := range w.restarts[name] {
+		if now.Sub(at) < window {
+			recent = append(recent, at)
+		}
+	}
+	return recent
+}
+
+// record keeps and logs in; w.mu must be held
+func (w *Watchdog) record(in Intervention) {
+	w.interventions = append(w.interventions, in)
+	if len(w.interventions) > maxInterventions {
+		w.interventions = w.interventions[len(w.interventions)-maxInterventions:]
+	}
+	if w.Logger == nil {
+		return
+	}
+	if in.Error != "" {
+		w.Logger.Printf("watchdog: %s: %s; %s: %s", in.Component, in.Problem, in.Action, in.Error)
+	} else {
+		w.Logger.Printf("watchdog: %s: %s; %s", in.Component, in.Problem, in.Action)
+	}
+}
+
+// Interventions returns the interventions made, oldest first
+func (w *Watchdog) Interventions() []Intervention {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]Intervention(nil), w.interventions...)
+}
@@ -0,0 +1,139 @@
+package watchdog
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jedarden/tunnel/internal/providers"
+)
+
+func TestRestartThenEscalate(t *testing.T) {
+	var escalated string
+	w := New(func(reason string) { escalated = reason })
+	w.MaxRestarts = 2
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	w.now = func() time.Time { return now }
+
+	stuck := true
+	restarts := 0
+	w.Watch(Component{
+		Name: "health loop",
+		Check: func() error {
+			if stuck {
+				return errors.New("no tick for 1m")
+			}
+			return nil
+		},
+		Restart: func() error {
+			restarts++
+			if restarts == 2 {
+				return errors.New("still wedged")
+			}
+			return nil
+		},
+	})
+	w.Watch(Component{Name: "fine", Check: func() error { return nil }})
+
+	actions := func(ins []Intervention) string {
+		var s []string
+		for _, in := range ins {
+			s = append(s, in.Action)
+		}
+		return strings.Join(s, ",")
+	}
+
+	if got := actions(w.CheckNow()); got != "restarted" {
+		t.Fatalf("first round: %s", got)
+	}
+	now = now.Add(time.Minute)
+	if got := actions(w.CheckNow()); got != "restart failed" {
+		t.Fatalf("second round: %s", got)
+	}
+
+	// Restarts age out of the window
+	stuck = false
+	w.CheckNow()
+	now = now.Add(DefaultWindow)
+	stuck = true
+	if got := actions(w.CheckNow()); got != "restarted" || escalated != "" {
+		t.Fatalf("after the window: %s, escalated %q", got, escalated)
+	}
+	now = now.Add(time.Minute)
+	w.CheckNow()
+	now = now.Add(time.Minute)
+	if got := actions(w.CheckNow()); got != "escalated" {
+		t.Fatalf("third restart within the window: %s", got)
+	}
+	if !strings.Contains(escalated, "health loop: no tick for 1m, still stuck after 2 restarts") {
+		t.Errorf("escalated with %q", escalated)
+	}
+
+	// Nothing more happens once escalated
+	if got := w.CheckNow(); len(got) != 0 {
+		t.Errorf("checked after escalating: %+v", got)
+	}
+	if got := len(w.Interventions()); got != 5 {
+		t.Errorf("%d interventions recorded, want 5", got)
+	}
+}
+
+// hangingProvider blocks IsConnected until released
+type hangingProvider struct {
+	*providers.BaseProvider
+	release   chan struct{}
+	reconnect atomic.Int32
+}
+
+func (h *hangingProvider) Install() error   { return nil }
+func (h *hangingProvider) Uninstall() error { return nil }
+func (h *hangingProvider) IsInstalled() bool {
+	return true
+}
+func (h *hangingProvider) Connect() error {
+	h.reconnect.Add(1)
+	return nil
+}
+func (h *hangingProvider) Disconnect() error { return nil }
+func (h *hangingProvider) IsConnected() bool {
+	<-h.release
+	return true
+}
+func (h *hangingProvider) GetConnectionInfo() (*providers.ConnectionInfo, error) {
+	return &providers.ConnectionInfo{}, nil
+}
+func (h *hangingProvider) HealthCheck() (*providers.HealthStatus, error) {
+	return &providers.HealthStatus{}, nil
+}
+func (h *hangingProvider) GetLogs(time.Time) ([]providers.LogEntry, error) { return nil, nil }
+func (h *hangingProvider) ValidateConfig(*providers.ProviderConfig) error  { return nil }
+
+func TestProviders(t *testing.T) {
+	hung := &hangingProvider{BaseProvider: providers.NewBaseProvider("hung", providers.CategoryTunnel), release: make(chan struct{})}
+	ok := &hangingProvider{BaseProvider: providers.NewBaseProvider("ok", providers.CategoryTunnel), release: make(chan struct{})}
+	close(ok.release)
+
+	c := Providers(func() []providers.Provider { return []providers.Provider{hung, ok} }, 20*time.Millisecond)
+	err := c.Check()
+	if err == nil || err.Error() != "hung not responding after 20ms" {
+		t.Fatalf("Check = %v", err)
+	}
+	if err := c.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	if hung.reconnect.Load() != 1 || ok.reconnect.Load() != 0 {
+		t.Errorf("reconnected hung %d times and ok %d times; want only hung, once", hung.reconnect.Load(), ok.reconnect.Load())
+	}
+
+	// The probe still waiting is reused rather than piling up, and once it
+	// answers the provider is fine again
+	if err := c.Check(); err == nil {
+		t.Error("a provider still hanging was reported fine")
+	}
+	close(hung.release)
+	if err := c.Check(); err != nil {
+		t.Errorf("after answering: %v", err)
+	}
+}
@@ -1,10 +1,19 @@
 package api
 
 import (
+	"errors"
 	"fmt"
+	"html"
+	"os"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/fleet"
+	"github.com/jedarden/tunnel/internal/installer"
+	"github.com/jedarden/tunnel/internal/probe"
+	"github.com/jedarden/tunnel/internal/providers"
+	"github.com/jedarden/tunnel/internal/system"
 	"github.com/jedarden/tunnel/pkg/tunnel"
 )
 
@@ -16,10 +25,11 @@ func (s *Server) listProviders(c *fiber.Ctx) error {
 	result := make([]map[string]interface{}, 0, len(providerList))
 	for _, p := range providerList {
 		result = append(result, map[string]interface{}{
-			"name":      p.Name(),
-			"category":  p.Category(),
-			"installed": p.IsInstalled(),
-			"connected": p.IsConnected(),
+			"name":         p.Name(),
+			"display_name": providers.DisplayName(p.Name()),
+			"category":     p.Category(),
+			"installed":    p.IsInstalled(),
+			"connected":    p.IsConnected(),
 		})
 	}
 
@@ -38,10 +48,11 @@ func (s *Server) getProvider(c *fiber.Ctx) error {
 	}
 
 	return c.JSON(fiber.Map{
-		"name":      provider.Name(),
-		"category":  provider.Category(),
-		"installed": provider.IsInstalled(),
-		"connected": provider.IsConnected(),
+		"name":         provider.Name(),
+		"display_name": providers.DisplayName(provider.Name()),
+		"category":     provider.Category(),
+		"installed":    provider.IsInstalled(),
+		"connected":    provider.IsConnected(),
 	})
 }
 
@@ -68,6 +79,67 @@ func (s *Server) getProviderStatus(c *fiber.Ctx) error {
 	})
 }
 
+// getProviderLogs returns a provider's log lines tagged with the operation
+// each was written during, and the operations themselves. ?op= narrows the
+// lines to those around one operation, widened by ?window=.
+func (s *Server) getProviderLogs(c *fiber.Ctx) error {
+	name := c.Params("name")
+
+	provider, err := s.registry.GetProvider(name)
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("Provider %s not found", name))
+	}
+
+	since := time.Hour
+	if v := c.Query("since"); v != "" {
+		if since, err = time.ParseDuration(v); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Invalid since: %v", err))
+		}
+	}
+	window := 30 * time.Second
+	if v := c.Query("window"); v != "" {
+		if window, err = time.ParseDuration(v); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Invalid window: %v", err))
+		}
+	}
+
+	entries, err := provider.GetLogs(time.Now().Add(-since))
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, fmt.Sprintf("Failed to read logs: %v", err))
+	}
+	ops := s.manager.Operations(provider.Name())
+	core.TagLogEntries(entries, provider.Name(), ops)
+
+	if id := c.Query("op"); id != "" {
+		entries = core.LogsAround(entries, ops, id, window)
+		if entries == nil {
+			return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("Operation %s not found", id))
+		}
+	}
+	if entries == nil {
+		entries = []providers.LogEntry{}
+	}
+	if ops == nil {
+		ops = []core.Operation{}
+	}
+
+	return c.JSON(fiber.Map{
+		"entries":    entries,
+		"operations": ops,
+	})
+}
+
+// getOperation returns a connect, disconnect or failover by its ID
+func (s *Server) getOperation(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	op, ok := s.manager.Operation(id)
+	if !ok {
+		return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("Operation %s not found", id))
+	}
+	return c.JSON(op)
+}
+
 func (s *Server) installProvider(c *fiber.Ctx) error {
 	name := c.Params("name")
 
@@ -79,6 +151,11 @@ func (s *Server) installProvider(c *fiber.Ctx) error {
 	if err := provider.Install(); err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, fmt.Sprintf("Failed to install provider: %v", err))
 	}
+	if s.installs != nil {
+		if err := installer.RecordInstall(s.installs, provider.Name()); err != nil {
+			s.logger.Printf("Failed to record install of %s: %v", name, err)
+		}
+	}
 
 	return c.JSON(fiber.Map{
 		"message": fmt.Sprintf("Provider %s installed successfully", name),
@@ -96,6 +173,11 @@ func (s *Server) uninstallProvider(c *fiber.Ctx) error {
 	if err := provider.Uninstall(); err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, fmt.Sprintf("Failed to uninstall provider: %v", err))
 	}
+	if s.installs != nil {
+		if err := s.installs.Remove(provider.Name()); err != nil {
+			s.logger.Printf("Failed to remove install record of %s: %v", name, err)
+		}
+	}
 
 	return c.JSON(fiber.Map{
 		"message": fmt.Sprintf("Provider %s uninstalled successfully", name),
@@ -110,6 +192,10 @@ func (s *Server) connectProvider(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("Provider %s not found", name))
 	}
 
+	if err := s.checkOwner(c, name, provider.IsConnected()); err != nil {
+		return err
+	}
+
 	// Parse config from request body
 	var config tunnel.ProviderConfig
 	if err := c.BodyParser(&config); err != nil {
@@ -120,10 +206,15 @@ func (s *Server) connectProvider(c *fiber.Ctx) error {
 	if err := provider.Configure(&config); err != nil {
 		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Configuration error: %v", err))
 	}
+	if s.config.BeforeConnect != nil {
+		s.config.BeforeConnect(provider)
+	}
 
 	if err := provider.Connect(); err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, fmt.Sprintf("Failed to connect: %v", err))
 	}
+	s.trackConnected(name)
+	s.setOwner(c, name)
 
 	return c.JSON(fiber.Map{
 		"message": fmt.Sprintf("Provider %s connected successfully", name),
@@ -138,15 +229,49 @@ func (s *Server) disconnectProvider(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("Provider %s not found", name))
 	}
 
+	if err := s.checkOwner(c, name, provider.IsConnected()); err != nil {
+		return err
+	}
+
 	if err := provider.Disconnect(); err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, fmt.Sprintf("Failed to disconnect: %v", err))
 	}
+	s.untrackConnected(name)
 
 	return c.JSON(fiber.Map{
 		"message": fmt.Sprintf("Provider %s disconnected successfully", name),
 	})
 }
 
+func (s *Server) getProviderResources(c *fiber.Ctx) error {
+	name := c.Params("name")
+
+	provider, err := s.registry.GetProvider(name)
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("Provider %s not found", name))
+	}
+
+	patterns := providers.ProcessPatterns(provider)
+	if len(patterns) == 0 {
+		return c.JSON(fiber.Map{
+			"name":      name,
+			"monitored": false,
+		})
+	}
+
+	usage, err := system.GetResourceUsage(patterns)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, fmt.Sprintf("Failed to read resource usage: %v", err))
+	}
+	usage.Violations = providers.LimitViolations(provider.Name())
+
+	return c.JSON(fiber.Map{
+		"name":      name,
+		"monitored": true,
+		"resources": usage,
+	})
+}
+
 func (s *Server) providerHealthCheck(c *fiber.Ctx) error {
 	name := c.Params("name")
 
@@ -155,7 +280,7 @@ func (s *Server) providerHealthCheck(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("Provider %s not found", name))
 	}
 
-	health, err := provider.HealthCheck()
+	health, err := providers.CheckHealth(provider)
 	if err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, fmt.Sprintf("Health check failed: %v", err))
 	}
@@ -175,7 +300,7 @@ func (s *Server) providerHealthCheckWithConfig(c *fiber.Ctx) error {
 	var config tunnel.ProviderConfig
 	if err := c.BodyParser(&config); err != nil {
 		// If no config provided, just do a basic health check
-		health, err := provider.HealthCheck()
+		health, err := providers.CheckHealth(provider)
 		if err != nil {
 			return fiber.NewError(fiber.StatusInternalServerError, fmt.Sprintf("Health check failed: %v", err))
 		}
@@ -192,7 +317,7 @@ func (s *Server) providerHealthCheckWithConfig(c *fiber.Ctx) error {
 	}
 
 	// Perform health check with the configuration
-	health, err := provider.HealthCheck()
+	health, err := providers.CheckHealth(provider)
 	if err != nil {
 		return c.JSON(fiber.Map{
 			"healthy": false,
@@ -220,6 +345,39 @@ func (s *Server) listConnections(c *fiber.Ctx) error {
 	return c.JSON(fiber.Map{
 		"connections": result,
 		"count":       len(result),
+		"budget":      s.manager.Budget(),
+	})
+}
+
+func (s *Server) getConnectionStatuses(c *fiber.Ctx) error {
+	statuses := s.manager.ConnectionStatuses()
+	mode, strategy := s.manager.Mode()
+
+	return c.JSON(fiber.Map{
+		"connections": statuses,
+		"count":       len(statuses),
+		"mode":        mode,
+		"balance":     strategy,
+		"sessions":    s.manager.SessionCounts(),
+	})
+}
+
+// pickConnection tells a client which connection to open its next session
+// over, by the connection mode
+func (s *Server) pickConnection(c *fiber.Ctx) error {
+	conn, err := s.manager.Pick()
+	if errors.Is(err, tunnel.ErrNoHealthyConnection) {
+		return fiber.NewError(fiber.StatusServiceUnavailable, "No healthy connection")
+	}
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
+	mode, strategy := s.manager.Mode()
+	return c.JSON(fiber.Map{
+		"connection": connectionToMap(conn),
+		"mode":       mode,
+		"balance":    strategy,
 	})
 }
 
@@ -249,6 +407,9 @@ func (s *Server) createConnection(c *fiber.Ctx) error {
 	}
 
 	conn, err := s.manager.Start(req.Method, config)
+	if errors.Is(err, tunnel.ErrConnectionLimit) {
+		return fiber.NewError(fiber.StatusConflict, err.Error())
+	}
 	if err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, fmt.Sprintf("Failed to create connection: %v", err))
 	}
@@ -273,9 +434,11 @@ func (s *Server) deleteConnection(c *fiber.Ctx) error {
 	if err := s.manager.Stop(id); err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, fmt.Sprintf("Failed to delete connection: %v", err))
 	}
+	op, _ := s.manager.LatestOperation(tunnel.OpDisconnect, id)
 
 	return c.JSON(fiber.Map{
-		"message": fmt.Sprintf("Connection %s deleted successfully", id),
+		"message":      fmt.Sprintf("Connection %s deleted successfully", id),
+		"operation_id": op.ID,
 	})
 }
 
@@ -301,6 +464,23 @@ func (s *Server) getConnectionMetrics(c *fiber.Ctx) error {
 
 	sent, received, latency := conn.Metrics.GetStats()
 
+	aggregates := fiber.Map{}
+	for _, window := range []tunnel.AggregationWindow{tunnel.Window1m, tunnel.Window5m, tunnel.Window1h} {
+		agg, err := s.manager.GetAggregatedMetrics(id, window)
+		if err != nil {
+			continue
+		}
+		aggregates[window.String()] = fiber.Map{
+			"samples":        agg.Samples,
+			"failures":       agg.Failures,
+			"latency_min":    agg.MinLatency.String(),
+			"latency_avg":    agg.AvgLatency.String(),
+			"latency_max":    agg.MaxLatency.String(),
+			"bytes_sent":     agg.BytesSent,
+			"bytes_received": agg.BytesReceived,
+		}
+	}
+
 	return c.JSON(fiber.Map{
 		"connection_id":  id,
 		"bytes_sent":     sent,
@@ -308,6 +488,7 @@ func (s *Server) getConnectionMetrics(c *fiber.Ctx) error {
 		"latency":        latency.String(),
 		"uptime":         conn.GetUptime().String(),
 		"state":          conn.GetState().String(),
+		"aggregates":     aggregates,
 	})
 }
 
@@ -402,22 +583,301 @@ func (s *Server) getSystemStatus(c *fiber.Ctx) error {
 	})
 }
 
+// Access request handlers
+
+func (s *Server) listAccessRequests(c *fiber.Ctx) error {
+	if s.approvals == nil {
+		return fiber.NewError(fiber.StatusServiceUnavailable, "Access requests are not enabled")
+	}
+
+	requests, err := s.approvals.Pending()
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, fmt.Sprintf("Failed to list access requests: %v", err))
+	}
+
+	return c.JSON(fiber.Map{
+		"requests": requests,
+		"count":    len(requests),
+	})
+}
+
+func (s *Server) createAccessRequest(c *fiber.Ctx) error {
+	if s.approvals == nil {
+		return fiber.NewError(fiber.StatusServiceUnavailable, "Access requests are not enabled")
+	}
+
+	var req struct {
+		User      string `json:"user"`
+		PublicKey string `json:"public_key"`
+		TTL       string `json:"ttl"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	queued, err := s.approvals.Submit(core.AccessRequest{
+		User:      req.User,
+		PublicKey: req.PublicKey,
+		TTL:       req.TTL,
+		Source:    "api",
+	})
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Invalid access request: %v", err))
+	}
+
+	// The key is only installed once an operator approves it
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"status":  "pending",
+		"request": queued,
+	})
+}
+
+// Key handlers
+
+func (s *Server) listKeys(c *fiber.Ctx) error {
+	if s.keys == nil {
+		return fiber.NewError(fiber.StatusServiceUnavailable, "Key management is not enabled")
+	}
+
+	user, err := keyUser(c, c.Query("user"))
+	if err != nil {
+		return err
+	}
+	keys, err := s.keys.ListKeys(user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, fmt.Sprintf("Failed to list keys: %v", err))
+	}
+	keys = ownedBy(keys, user)
+
+	return c.JSON(fiber.Map{
+		"keys":  keys,
+		"count": len(keys),
+	})
+}
+
+func (s *Server) addKey(c *fiber.Ctx) error {
+	if s.keys == nil {
+		return fiber.NewError(fiber.StatusServiceUnavailable, "Key management is not enabled")
+	}
+
+	var req struct {
+		User      string `json:"user"`
+		PublicKey string `json:"public_key"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+	}
+	if req.User == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "user is required")
+	}
+
+	key, err := s.keys.ValidateKey(req.PublicKey)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Invalid SSH key: %v", err))
+	}
+	if err := s.keys.AddKey(req.User, *key); err != nil {
+		return fiber.NewError(fiber.StatusConflict, fmt.Sprintf("Failed to add key: %v", err))
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"status":      "added",
+		"user":        req.User,
+		"type":        key.Type,
+		"fingerprint": key.Fingerprint,
+	})
+}
+
+func (s *Server) removeKey(c *fiber.Ctx) error {
+	if s.keys == nil {
+		return fiber.NewError(fiber.StatusServiceUnavailable, "Key management is not enabled")
+	}
+
+	user, id := c.Params("user"), c.Params("id")
+	if err := s.keys.RemoveKey(user, id); err != nil {
+		return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("Failed to remove key: %v", err))
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "removed",
+		"user":   user,
+		"key_id": id,
+	})
+}
+
+// revokeKeys revokes keys of a user by fingerprint, or all of them, as
+// another node passing on a revocation asks
+func (s *Server) revokeKeys(c *fiber.Ctx) error {
+	if s.keys == nil {
+		return fiber.NewError(fiber.StatusServiceUnavailable, "Key management is not enabled")
+	}
+
+	var req fleet.RevokeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+	}
+	if req.User == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "user is required")
+	}
+
+	keys, err := s.keys.ListKeys(req.User)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, fmt.Sprintf("Failed to list keys: %v", err))
+	}
+	keys = ownedBy(keys, req.User)
+	held := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		held[key.Fingerprint] = true
+	}
+	targets := req.Fingerprints
+	if len(targets) == 0 {
+		for _, key := range keys {
+			targets = append(targets, key.Fingerprint)
+		}
+	}
+
+	hostname, _ := os.Hostname()
+	result := fleet.RevokeResult{Node: hostname, Revoked: []string{}}
+	for _, fp := range targets {
+		if !held[fp] {
+			result.Missing = append(result.Missing, fp)
+			continue
+		}
+		if err := s.keys.RemoveKey(req.User, fp); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError,
+				fmt.Sprintf("Failed to revoke %s after revoking %d key(s): %v", fp, len(result.Revoked), err))
+		}
+		result.Revoked = append(result.Revoked, fp)
+	}
+	return c.JSON(result)
+}
+
+// ownedBy keeps the keys recorded as user's own, or all of them when user
+// is empty. The file-backed manager lists every key whatever the user.
+func ownedBy(keys []core.SSHPublicKey, user string) []core.SSHPublicKey {
+	owned := []core.SSHPublicKey{}
+	for _, key := range keys {
+		if user == "" || key.User == user {
+			owned = append(owned, key)
+		}
+	}
+	return owned
+}
+
+// Self-enrollment handlers
+
+func (s *Server) beginEnrollment(c *fiber.Ctx) error {
+	if s.enrollment == nil {
+		return fiber.NewError(fiber.StatusNotFound, "Self-enrollment is not enabled")
+	}
+
+	var req struct {
+		Email     string `json:"email"`
+		PublicKey string `json:"public_key"`
+		TTL       string `json:"ttl"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	if err := s.enrollment.Begin(req.Email, req.PublicKey, req.TTL); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Enrollment failed: %v", err))
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"status":  "verification_sent",
+		"message": "Check your email for a verification link",
+	})
+}
+
+// showEnrollmentVerification renders a confirm button rather than verifying
+// on GET, so mail scanners that prefetch links can't complete enrollment
+func (s *Server) showEnrollmentVerification(c *fiber.Ctx) error {
+	if s.enrollment == nil {
+		return fiber.NewError(fiber.StatusNotFound, "Self-enrollment is not enabled")
+	}
+
+	token := html.EscapeString(c.Query("token"))
+	c.Type("html")
+	return c.SendString(`<!DOCTYPE html>
+<html><head><title>Confirm SSH key enrollment</title></head>
+<body>
+<h1>Confirm SSH key enrollment</h1>
+<form method="post" action="/api/enroll/verify">
+<input type="hidden" name="token" value="` + token + `">
+<button type="submit">Confirm</button>
+</form>
+</body></html>`)
+}
+
+func (s *Server) verifyEnrollment(c *fiber.Ctx) error {
+	if s.enrollment == nil {
+		return fiber.NewError(fiber.StatusNotFound, "Self-enrollment is not enabled")
+	}
+
+	var req struct {
+		Token string `json:"token" form:"token"`
+	}
+	if err := c.BodyParser(&req); err != nil || req.Token == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Missing verification token")
+	}
+
+	queued, err := s.enrollment.Verify(req.Token)
+	if err != nil {
+		if errors.Is(err, core.ErrInvalidEnrollmentToken) {
+			return fiber.NewError(fiber.StatusGone, err.Error())
+		}
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Enrollment failed: %v", err))
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"status":      "pending",
+		"message":     "Email verified; your key is waiting for administrator approval",
+		"fingerprint": queued.Fingerprint,
+	})
+}
+
+// Probe handlers
+
+// answerProbe checks from here that an SSH server answers at the endpoint
+// another node asks about
+func (s *Server) answerProbe(c *fiber.Ctx) error {
+	if !s.config.AnswerProbes {
+		return fiber.NewError(fiber.StatusServiceUnavailable, "Probes are not enabled on this node")
+	}
+
+	var req probe.Request
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+	}
+	if err := req.Validate(); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Invalid probe request: %v", err))
+	}
+
+	res := probe.CheckSSH(c.UserContext(), req)
+	res.Probe, _ = os.Hostname()
+	return c.JSON(res)
+}
+
 // Helper functions
 
 func connectionToMap(conn *tunnel.Connection) map[string]interface{} {
 	sent, received, latency := conn.Metrics.GetStats()
 
 	return map[string]interface{}{
-		"id":          conn.ID,
-		"method":      conn.Method,
-		"state":       conn.GetState().String(),
-		"local_port":  conn.LocalPort,
-		"remote_host": conn.RemoteHost,
-		"remote_port": conn.RemotePort,
-		"started_at":  conn.StartedAt,
-		"uptime":      conn.GetUptime().String(),
-		"is_primary":  conn.IsPrimaryConnection(),
-		"priority":    conn.GetPriority(),
+		"id":           conn.ID,
+		"method":       conn.Method,
+		"state":        conn.GetState().String(),
+		"local_port":   conn.LocalPort,
+		"remote_host":  conn.RemoteHost,
+		"remote_port":  conn.RemotePort,
+		"url":          conn.URL,
+		"started_at":   conn.StartedAt,
+		"uptime":       conn.GetUptime().String(),
+		"total_uptime": conn.TotalUptime().String(),
+		"history":      stateHistory(conn),
+		"is_primary":   conn.IsPrimaryConnection(),
+		"priority":     conn.GetPriority(),
+		"operation_id": conn.OperationID,
 		"metrics": map[string]interface{}{
 			"bytes_sent":     sent,
 			"bytes_received": received,
@@ -425,3 +885,15 @@ func connectionToMap(conn *tunnel.Connection) map[string]interface{} {
 		},
 	}
 }
+
+// stateHistory lists a connection's state transitions, oldest first
+func stateHistory(conn *tunnel.Connection) []map[string]interface{} {
+	history := []map[string]interface{}{}
+	for _, t := range conn.StateHistory() {
+		history = append(history, map[string]interface{}{
+			"state": t.State.String(),
+			"at":    t.At,
+		})
+	}
+	return history
+}
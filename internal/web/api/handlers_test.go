@@ -0,0 +1,263 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/fleet"
+	"github.com/jedarden/tunnel/internal/providers"
+	"github.com/jedarden/tunnel/internal/web/middleware"
+	"github.com/jedarden/tunnel/pkg/tunnel"
+	"golang.org/x/crypto/ssh"
+)
+
+func newKey(t *testing.T) string {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub)))
+}
+
+// newKeyApp serves the API with alice and bob holding a key each
+func newKeyApp(t *testing.T) (*fiber.App, map[string]string) {
+	t.Helper()
+	km, err := core.NewFileKeyManager(filepath.Join(t.TempDir(), "authorized_keys"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprints := make(map[string]string)
+	for _, user := range []string{"alice", "bob"} {
+		key, err := km.ValidateKey(newKey(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := km.AddKey(user, *key); err != nil {
+			t.Fatal(err)
+		}
+		fingerprints[user] = key.Fingerprint
+	}
+
+	return newProxiedApp(&ServerConfig{Keys: km}), fingerprints
+}
+
+// newProxiedApp serves the API behind proxy auth; members of the ops group
+// get the all scope and everyone else own
+func newProxiedApp(config *ServerConfig) *fiber.App {
+	app := fiber.New()
+	app.Use("/api", middleware.ProxyAuth(middleware.ProxyAuthConfig{
+		Secret: "proxy-secret",
+		Scope: func(user string, groups []string) string {
+			for _, g := range groups {
+				if g == "ops" {
+					return middleware.ScopeAll
+				}
+			}
+			return middleware.ScopeOwn
+		},
+	}))
+	SetupRoutes(app, NewServer(config))
+	return app
+}
+
+// asUser sends a request the proxy authenticated as user and returns the
+// status and body of the response
+func asUser(t *testing.T, app *fiber.App, method, path, body, user, groups string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Forwarded-User", user)
+	req.Header.Set("X-Proxy-Secret", "proxy-secret")
+	if groups != "" {
+		req.Header.Set("X-Forwarded-Groups", groups)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+func TestKeyRoutesOwner(t *testing.T) {
+	app, fingerprints := newKeyApp(t)
+	do := func(method, path, body, user, groups string) (int, string) {
+		t.Helper()
+		return asUser(t, app, method, path, body, user, groups)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		user   string
+		groups string
+		status int
+	}{
+		{"read another user's keys", "GET", "/api/keys?user=bob", "", "alice", "", fiber.StatusForbidden},
+		{"remove another user's key", "DELETE", "/api/keys/bob/" + url.PathEscape(fingerprints["bob"]), "", "alice", "", fiber.StatusForbidden},
+		{"revoke another user's keys", "POST", "/api/keys/revoke", `{"user":"bob"}`, "alice", "", fiber.StatusForbidden},
+		{"read own keys", "GET", "/api/keys?user=alice", "", "alice", "", fiber.StatusOK},
+		{"all scope reads anyone's keys", "GET", "/api/keys?user=bob", "", "carol", "ops", fiber.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, body := do(tt.method, tt.path, tt.body, tt.user, tt.groups); status != tt.status {
+				t.Errorf("%s %s as %s = %d %s, want %d", tt.method, tt.path, tt.user, status, body, tt.status)
+			}
+		})
+	}
+
+	// Without a user query, alice sees only her own key
+	status, body := do("GET", "/api/keys", "", "alice", "")
+	var listed struct {
+		Keys []core.SSHPublicKey `json:"keys"`
+	}
+	if status != fiber.StatusOK || json.Unmarshal([]byte(body), &listed) != nil {
+		t.Fatalf("GET /api/keys as alice = %d %s", status, body)
+	}
+	if len(listed.Keys) != 1 || listed.Keys[0].Fingerprint != fingerprints["alice"] {
+		t.Errorf("GET /api/keys as alice = %+v, want only her key", listed.Keys)
+	}
+
+	// Bob's key survived alice's attempts
+	if status, body := do("GET", "/api/keys?user=bob", "", "bob", ""); status != fiber.StatusOK || !strings.Contains(body, fingerprints["bob"]) {
+		t.Errorf("GET /api/keys as bob = %d %s, want his key still there", status, body)
+	}
+}
+
+// connectable is a provider that only remembers whether it is connected
+type connectable struct {
+	*providers.BaseProvider
+	connected bool
+}
+
+func (p *connectable) Install() error    { return nil }
+func (p *connectable) Uninstall() error  { return nil }
+func (p *connectable) IsInstalled() bool { return true }
+func (p *connectable) Connect() error    { p.connected = true; return nil }
+func (p *connectable) Disconnect() error { p.connected = false; return nil }
+func (p *connectable) IsConnected() bool { return p.connected }
+func (p *connectable) GetConnectionInfo() (*providers.ConnectionInfo, error) {
+	return &providers.ConnectionInfo{}, nil
+}
+func (p *connectable) HealthCheck() (*providers.HealthStatus, error) {
+	return &providers.HealthStatus{Healthy: true}, nil
+}
+func (p *connectable) GetLogs(time.Time) ([]providers.LogEntry, error) { return nil, nil }
+
+func TestProviderRoutesOwner(t *testing.T) {
+	reg := tunnel.NewRegistry()
+	reg.Register(&connectable{BaseProvider: providers.NewBaseProvider("bore", providers.CategoryTunnel)})
+	app := newProxiedApp(&ServerConfig{Registry: reg})
+
+	steps := []struct {
+		name   string
+		path   string
+		user   string
+		groups string
+		status int
+	}{
+		{"alice connects", "/api/providers/bore/connect", "alice", "", fiber.StatusOK},
+		{"bob reconnects alice's provider", "/api/providers/bore/connect", "bob", "", fiber.StatusForbidden},
+		{"bob disconnects alice's provider", "/api/providers/bore/disconnect", "bob", "", fiber.StatusForbidden},
+		{"all scope disconnects it", "/api/providers/bore/disconnect", "carol", "ops", fiber.StatusOK},
+		{"bob connects it once it is down", "/api/providers/bore/connect", "bob", "", fiber.StatusOK},
+		{"alice disconnects bob's provider", "/api/providers/bore/disconnect", "alice", "", fiber.StatusForbidden},
+		{"bob disconnects his own", "/api/providers/bore/disconnect", "bob", "", fiber.StatusOK},
+	}
+	for _, step := range steps {
+		if status, body := asUser(t, app, "POST", step.path, `{"name":"bore"}`, step.user, step.groups); status != step.status {
+			t.Fatalf("%s: POST %s as %s = %d %s, want %d", step.name, step.path, step.user, status, body, step.status)
+		}
+	}
+}
+
+func TestKeyRoutesNeedAuth(t *testing.T) {
+	km, err := core.NewFileKeyManager(filepath.Join(t.TempDir(), "authorized_keys"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	add := `{"user":"alice","public_key":"` + newKey(t) + `"}`
+	call := func(app *fiber.App, method, path, body, token string) int {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	// As the TUI and a per-user daemon serve it: neither a token nor a proxy
+	open := fiber.New()
+	SetupRoutes(open, NewServer(&ServerConfig{Keys: km}))
+	tests := []struct {
+		name, method, path, body, token string
+	}{
+		{"add without auth", "POST", "/api/keys", add, ""},
+		{"add with an unchecked token", "POST", "/api/keys", add, "made-up"},
+		{"remove without auth", "DELETE", "/api/keys/alice/SHA256:x", "", ""},
+		{"revoke without auth", "POST", "/api/keys/revoke", `{"user":"alice"}`, ""},
+	}
+	for _, tt := range tests {
+		if status := call(open, tt.method, tt.path, tt.body, tt.token); status != fiber.StatusUnauthorized {
+			t.Errorf("%s: %s %s = %d, want 401", tt.name, tt.method, tt.path, status)
+		}
+	}
+	if keys, _ := km.ListKeys(""); len(keys) != 0 {
+		t.Fatalf("keys added without auth: %+v", keys)
+	}
+
+	tokened := fiber.New()
+	tokened.Use("/api", middleware.RequireToken("s3cret"))
+	SetupRoutes(tokened, NewServer(&ServerConfig{Keys: km}))
+	if status := call(tokened, "POST", "/api/keys", add, "s3cret"); status != fiber.StatusCreated {
+		t.Errorf("POST /api/keys with the token = %d, want 201", status)
+	}
+}
+
+func TestRevokeKeysOnlyTheUsers(t *testing.T) {
+	app, fingerprints := newKeyApp(t)
+
+	status, body := asUser(t, app, "POST", "/api/keys/revoke", `{"user":"alice"}`, "carol", "ops")
+	var result fleet.RevokeResult
+	if status != fiber.StatusOK || json.Unmarshal([]byte(body), &result) != nil {
+		t.Fatalf("POST /api/keys/revoke = %d %s", status, body)
+	}
+	if len(result.Revoked) != 1 || result.Revoked[0] != fingerprints["alice"] {
+		t.Errorf("revoked %v, want only alice's %s", result.Revoked, fingerprints["alice"])
+	}
+
+	status, body = asUser(t, app, "GET", "/api/keys", "", "carol", "ops")
+	if status != fiber.StatusOK || strings.Contains(body, fingerprints["alice"]) || !strings.Contains(body, fingerprints["bob"]) {
+		t.Errorf("keys after revoking alice's = %d %s, want only bob's", status, body)
+	}
+
+	// Naming another user's key revokes nothing
+	status, body = asUser(t, app, "POST", "/api/keys/revoke", `{"user":"alice","fingerprints":["`+fingerprints["bob"]+`"]}`, "carol", "ops")
+	if status != fiber.StatusOK || json.Unmarshal([]byte(body), &result) != nil || len(result.Revoked) != 0 {
+		t.Errorf("revoking bob's key as alice's = %d %s, want it missing", status, body)
+	}
+}
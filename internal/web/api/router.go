@@ -2,6 +2,7 @@ package api
 
 import (
 	"github.com/gofiber/fiber/v2"
+	"github.com/jedarden/tunnel/internal/web/middleware"
 )
 
 // SetupRoutes configures all API routes
@@ -9,13 +10,22 @@ func SetupRoutes(app *fiber.App, server *Server) {
 	// API group
 	api := app.Group("/api")
 
+	// Routes changing more than a user's own providers need the all scope
+	// when a reverse proxy authenticated the request
+	admin := middleware.RequireScope()
+	// Routes changing authorized_keys are refused outright on a server with
+	// neither a token nor a proxy, as anyone reaching it could add a key
+	authed := middleware.RequireAuth()
+
 	// Provider routes
 	providers := api.Group("/providers")
 	providers.Get("/", server.listProviders)
 	providers.Get("/:name", server.getProvider)
 	providers.Get("/:name/status", server.getProviderStatus)
-	providers.Post("/:name/install", server.installProvider)
-	providers.Post("/:name/uninstall", server.uninstallProvider)
+	providers.Get("/:name/resources", server.getProviderResources)
+	providers.Get("/:name/logs", server.getProviderLogs)
+	providers.Post("/:name/install", admin, server.installProvider)
+	providers.Post("/:name/uninstall", admin, server.uninstallProvider)
 	providers.Post("/:name/connect", server.connectProvider)
 	providers.Post("/:name/disconnect", server.disconnectProvider)
 	providers.Get("/:name/health", server.providerHealthCheck)
@@ -24,24 +34,50 @@ func SetupRoutes(app *fiber.App, server *Server) {
 	// Connection routes
 	connections := api.Group("/connections")
 	connections.Get("/", server.listConnections)
-	connections.Post("/", server.createConnection)
+	connections.Post("/", admin, server.createConnection)
+	connections.Get("/status", server.getConnectionStatuses)
+	connections.Get("/pick", server.pickConnection)
 	connections.Get("/:id", server.getConnection)
-	connections.Delete("/:id", server.deleteConnection)
-	connections.Post("/:id/restart", server.restartConnection)
+	connections.Delete("/:id", admin, server.deleteConnection)
+	connections.Post("/:id/restart", admin, server.restartConnection)
 	connections.Get("/:id/metrics", server.getConnectionMetrics)
 
 	// Failover routes
 	failover := api.Group("/failover")
 	failover.Get("/primary", server.getPrimaryConnection)
-	failover.Post("/primary/:id", server.setPrimaryConnection)
-	failover.Post("/enable", server.enableAutoFailover)
-	failover.Post("/disable", server.disableAutoFailover)
+	failover.Post("/primary/:id", admin, server.setPrimaryConnection)
+	failover.Post("/enable", admin, server.enableAutoFailover)
+	failover.Post("/disable", admin, server.disableAutoFailover)
+
+	// Operation routes (connects, disconnects and failovers by ID)
+	api.Get("/operations/:id", server.getOperation)
 
 	// Metrics routes
 	metrics := api.Group("/metrics")
 	metrics.Get("/", server.getGlobalMetrics)
 	metrics.Get("/export", server.exportMetrics)
 
+	// Key routes
+	keys := api.Group("/keys")
+	keys.Get("/", server.listKeys)
+	keys.Post("/", authed, admin, server.addKey)
+	keys.Delete("/:user/:id", authed, admin, server.removeKey)
+	keys.Post("/revoke", authed, admin, server.revokeKeys)
+
+	// Access request routes (approved or denied in the TUI)
+	requests := api.Group("/access-requests")
+	requests.Get("/", server.listAccessRequests)
+	requests.Post("/", server.createAccessRequest)
+
+	// Self-enrollment routes (verified by email before queuing for approval)
+	enroll := api.Group("/enroll")
+	enroll.Post("/", server.beginEnrollment)
+	enroll.Get("/verify", server.showEnrollmentVerification)
+	enroll.Post("/verify", server.verifyEnrollment)
+
+	// Probe route: other nodes verify their tunnels from here
+	api.Post("/probe", admin, server.answerProbe)
+
 	// WebSocket route
 	api.Get("/ws", server.handleWebSocket)
 
@@ -1,25 +1,52 @@
 package api
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"sync"
 
+	"github.com/gofiber/fiber/v2"
+	"github.com/jedarden/tunnel/internal/core"
+	"github.com/jedarden/tunnel/internal/installer"
+	"github.com/jedarden/tunnel/internal/providers"
+	"github.com/jedarden/tunnel/internal/web/middleware"
 	"github.com/jedarden/tunnel/pkg/tunnel"
 )
 
 // Server holds the API server state and dependencies
 type Server struct {
-	manager  *tunnel.Manager
-	registry *tunnel.Registry
-	logger   *log.Logger
-	config   *ServerConfig
+	manager    *tunnel.Manager
+	registry   *tunnel.Registry
+	approvals  *core.ApprovalQueue
+	enrollment *core.EnrollmentManager
+	installs   *installer.Store
+	keys       core.KeyManager
+	logger     *log.Logger
+	config     *ServerConfig
+
+	mu        sync.Mutex
+	connected []string          // providers connected through the API, oldest first
+	owners    map[string]string // proxy user who connected each provider, by name
+	stopWatch func()            // stops forwarding registry changes to WebSocket clients
 }
 
 // ServerConfig holds configuration for the API server
 type ServerConfig struct {
-	Manager  *tunnel.Manager
-	Registry *tunnel.Registry
-	Logger   *log.Logger
-	DevMode  bool
+	Manager    *tunnel.Manager
+	Registry   *tunnel.Registry
+	Approvals  *core.ApprovalQueue     // optional; enables access request routes
+	Enrollment *core.EnrollmentManager // optional; enables key self-enrollment
+	Installs   *installer.Store        // optional; records binaries installed through the API
+	Keys       core.KeyManager         // optional; enables key routes
+	Logger     *log.Logger
+	DevMode    bool
+
+	AnswerProbes bool // other nodes may ask this one to check their endpoints
+
+	// BeforeConnect, if set, is called with a provider just before the API
+	// connects it, after the request's configuration is applied
+	BeforeConnect func(providers.Provider)
 }
 
 // NewServer creates a new API server instance
@@ -28,12 +55,30 @@ func NewServer(config *ServerConfig) *Server {
 		config.Logger = log.Default()
 	}
 
-	return &Server{
-		manager:  config.Manager,
-		registry: config.Registry,
-		logger:   config.Logger,
-		config:   config,
+	s := &Server{
+		manager:    config.Manager,
+		registry:   config.Registry,
+		approvals:  config.Approvals,
+		enrollment: config.Enrollment,
+		installs:   config.Installs,
+		keys:       config.Keys,
+		logger:     config.Logger,
+		config:     config,
+		owners:     make(map[string]string),
+	}
+
+	// Let the provider browser refresh when providers come and go at runtime
+	if s.registry != nil {
+		changes, stop := s.registry.Watch()
+		s.stopWatch = stop
+		go func() {
+			for change := range changes {
+				BroadcastEvent("provider_"+string(change.Type), change)
+			}
+		}()
 	}
+
+	return s
 }
 
 // GetManager returns the connection manager
@@ -58,8 +103,111 @@ func (s *Server) IsDevMode() bool {
 
 // Close performs cleanup when the server is shutting down
 func (s *Server) Close() error {
+	return s.Shutdown(context.Background())
+}
+
+// Shutdown stops the manager's connections and disconnects every provider
+// connected through the API, tunnels before the VPNs they may depend on
+func (s *Server) Shutdown(ctx context.Context) error {
+	var errors []error
+
+	if s.stopWatch != nil {
+		s.stopWatch()
+	}
+
 	if s.manager != nil {
-		return s.manager.Shutdown()
+		if err := s.manager.ShutdownContext(ctx); err != nil {
+			errors = append(errors, err)
+		}
+	}
+
+	s.mu.Lock()
+	connected := make([]providers.Provider, 0, len(s.connected))
+	for _, name := range s.connected {
+		if provider, err := s.registry.GetProvider(name); err == nil {
+			connected = append(connected, provider)
+		}
+	}
+	s.connected = nil
+	s.mu.Unlock()
+
+	for _, provider := range providers.DisconnectOrder(connected) {
+		if ctx.Err() != nil {
+			errors = append(errors, fmt.Errorf("disconnect %s: %w", provider.Name(), ctx.Err()))
+			continue
+		}
+		if err := provider.Disconnect(); err != nil {
+			errors = append(errors, fmt.Errorf("disconnect %s: %w", provider.Name(), err))
+		}
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf("shutdown api server: %v", errors)
+	}
+	return nil
+}
+
+// trackConnected remembers that a provider was connected through the API
+func (s *Server) trackConnected(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected = append(removeName(s.connected, name), name)
+}
+
+// untrackConnected forgets a provider disconnected through the API
+func (s *Server) untrackConnected(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected = removeName(s.connected, name)
+	delete(s.owners, name)
+}
+
+// setOwner records that the proxy identity of c connected name
+func (s *Server) setOwner(c *fiber.Ctx, name string) {
+	id := middleware.IdentityFrom(c)
+	if id == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.owners[name] = id.User
+}
+
+// checkOwner refuses a proxy identity with the own scope control of a
+// connected provider someone else started. As on the daemon, a provider
+// connected other than by a proxy identity belongs to no such user.
+func (s *Server) checkOwner(c *fiber.Ctx, name string, connected bool) error {
+	id := middleware.IdentityFrom(c)
+	if id == nil || id.Scope == middleware.ScopeAll || !connected {
+		return nil
+	}
+	s.mu.Lock()
+	owner := s.owners[name]
+	s.mu.Unlock()
+	if owner != id.User {
+		return fiber.NewError(fiber.StatusForbidden, fmt.Sprintf("%s is running for another user", name))
 	}
 	return nil
 }
+
+// keyUser returns whose keys the request may see: user, or for a proxy
+// identity with the own scope its own keys, refusing anyone else's
+func keyUser(c *fiber.Ctx, user string) (string, error) {
+	id := middleware.IdentityFrom(c)
+	if id == nil || id.Scope == middleware.ScopeAll {
+		return user, nil
+	}
+	if user != "" && user != id.User {
+		return "", fiber.NewError(fiber.StatusForbidden, fmt.Sprintf("keys of %s belong to another user", user))
+	}
+	return id.User, nil
+}
+
+func removeName(names []string, name string) []string {
+	for i, n := range names {
+		if n == name {
+			return append(names[:i], names[i+1:]...)
+		}
+	}
+	return names
+}
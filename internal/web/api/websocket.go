@@ -2,6 +2,7 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"sync"
 	"time"
 
@@ -99,12 +100,13 @@ func (s *Server) websocketHandler(conn *websocket.Conn) {
 		conn.Close()
 	}()
 
-	// Subscribe to connection events
+	// Subscribe to connection events; each client needs its own subscriber ID
+	subscriberID := fmt.Sprintf("ws-client-%p", client)
 	eventPub := s.manager.GetEventPublisher()
-	subscriber := eventPub.Subscribe("ws-client", func(event *tunnel.ConnectionEvent) bool {
+	subscriber := eventPub.Subscribe(subscriberID, func(event *tunnel.ConnectionEvent) bool {
 		return true // Subscribe to all events
 	})
-	defer eventPub.Unsubscribe("ws-client")
+	defer eventPub.Unsubscribe(subscriberID)
 
 	// Start goroutine to send messages to client
 	go func() {
@@ -133,12 +135,13 @@ func (s *Server) websocketHandler(conn *websocket.Conn) {
 				}
 
 				msg := &WebSocketMessage{
-					Type:    string(event.Type),
-					Time:    event.Timestamp,
+					Type: event.Type.String(),
+					Time: event.Timestamp,
 					Payload: map[string]interface{}{
-						"conn_id": event.ConnID,
-						"message": event.Message,
-						"data":    event.Data,
+						"conn_id":      event.ConnID,
+						"message":      event.Message,
+						"data":         event.Data,
+						"operation_id": event.OperationID,
 					},
 				}
 
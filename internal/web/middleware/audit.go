@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxParamLength caps the length of a parameter value in an audit record
+const maxParamLength = 200
+
+// AuditRecord is a mutating API call as the audit trail keeps it
+type AuditRecord struct {
+	Caller   string
+	SourceIP string
+	Method   string            // HTTP method
+	Endpoint string            // route, e.g. /api/providers/:name/connect
+	Path     string            // the path as requested
+	Params   map[string]string // route, query and top-level JSON body parameters
+	Status   int
+	Error    string
+	Duration time.Duration
+}
+
+// Audit hands record every call that may change something once it has
+// been handled, with who made it, what it was and how it ended. Values of
+// parameters that look like credentials are redacted.
+func Audit(record func(AuditRecord)) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !IsMutation(c) {
+			return c.Next()
+		}
+
+		start := time.Now()
+		err := c.Next()
+
+		// By now the route is the one that handled the call. Fiber reuses
+		// the request's buffers, so the record holds copies.
+		r := AuditRecord{
+			Caller:   strings.Clone(Caller(c)),
+			SourceIP: strings.Clone(c.IP()),
+			Method:   strings.Clone(c.Method()),
+			Endpoint: c.Route().Path,
+			Path:     strings.Clone(c.Path()),
+			Params:   auditParams(c),
+			Status:   c.Response().StatusCode(),
+			Duration: time.Since(start),
+		}
+		if err != nil {
+			r.Status = fiber.StatusInternalServerError
+			var fe *fiber.Error
+			if errors.As(err, &fe) {
+				r.Status = fe.Code
+			}
+			r.Error = err.Error()
+		}
+		record(r)
+		return err
+	}
+}
+
+// auditParams collects the parameters of a call
+func auditParams(c *fiber.Ctx) map[string]string {
+	params := make(map[string]string)
+	for k, v := range c.AllParams() {
+		params[k] = strings.Clone(v)
+	}
+	c.Context().QueryArgs().VisitAll(func(k, v []byte) {
+		params[string(k)] = string(v)
+	})
+
+	var body map[string]json.RawMessage
+	if strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEApplicationJSON) && json.Unmarshal(c.Body(), &body) == nil {
+		for k, raw := range body {
+			var s string
+			if json.Unmarshal(raw, &s) != nil {
+				s = string(raw)
+			}
+			params[k] = s
+		}
+	}
+
+	for k, v := range params {
+		switch {
+		case sensitiveParam(k):
+			params[k] = "[redacted]"
+		case len(v) > maxParamLength:
+			params[k] = fmt.Sprintf("%s... (%d bytes)", v[:maxParamLength], len(v))
+		}
+	}
+	if len(params) == 0 {
+		return nil
+	}
+	return params
+}
+
+// sensitiveParam reports whether a parameter name suggests a credential
+func sensitiveParam(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range []string{"token", "secret", "password", "passphrase", "private", "auth"} {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
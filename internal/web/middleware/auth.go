@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type verifiedTokenKey struct{}
+
+// RequireToken requires requests to carry token as a bearer token, or as a
+// token query parameter where headers can't be set, such as WebSocket
+// connections from a browser. Requests from this host are no exception, as
+// a reverse proxy here connects from loopback too. A request ProxyAuth
+// already accepted needs no token. An empty token refuses every request.
+func RequireToken(token string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if IdentityFrom(c) != nil {
+			return c.Next()
+		}
+
+		given, ok := givenToken(c)
+		if !ok {
+			return fiber.NewError(fiber.StatusUnauthorized, "Authorization must be a bearer token")
+		}
+		if given == "" || token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			c.Set(fiber.HeaderWWWAuthenticate, `Bearer realm="tunnel"`)
+			return fiber.NewError(fiber.StatusUnauthorized, "A valid API token is required")
+		}
+		c.Locals(verifiedTokenKey{}, given)
+		return c.Next()
+	}
+}
+
+// verifiedToken returns the token RequireToken verified on the request
+func verifiedToken(c *fiber.Ctx) (string, bool) {
+	token, ok := c.Locals(verifiedTokenKey{}).(string)
+	return token, ok
+}
+
+// RequireAuth refuses requests that neither RequireToken nor ProxyAuth
+// authenticated, for routes too dangerous to serve on a server without
+// either, such as those changing authorized_keys
+func RequireAuth() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if _, ok := verifiedToken(c); ok || IdentityFrom(c) != nil {
+			return c.Next()
+		}
+		return fiber.NewError(fiber.StatusUnauthorized, "This route needs an API token or an authenticating proxy")
+	}
+}
+
+// givenToken returns the token a request carries, or false when its
+// Authorization header isn't a bearer token
+func givenToken(c *fiber.Ctx) (string, bool) {
+	auth := c.Get(fiber.HeaderAuthorization)
+	if auth == "" {
+		return c.Query("token"), true
+	}
+	scheme, value, _ := strings.Cut(auth, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(value), true
+}
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestRequireTokenFrom(t *testing.T) {
+	app := fiber.New(fiber.Config{
+		// Pretend requests come from the address in the header
+		ProxyHeader: fiber.HeaderXForwardedFor,
+	})
+	app.Use(RequireToken("s3cret"))
+	app.Get("/api/status", func(c *fiber.Ctx) error { return c.SendString("ok") })
+
+	tests := []struct {
+		name   string
+		from   string
+		auth   string
+		query  string
+		status int
+	}{
+		{"remote without token", "203.0.113.7", "", "", fiber.StatusUnauthorized},
+		{"remote with wrong token", "203.0.113.7", "Bearer nope", "", fiber.StatusUnauthorized},
+		{"remote with basic auth", "203.0.113.7", "Basic czNjcmV0", "", fiber.StatusUnauthorized},
+		{"remote with bearer token", "203.0.113.7", "Bearer s3cret", "", fiber.StatusOK},
+		{"remote with query token", "203.0.113.7", "", "?token=s3cret", fiber.StatusOK},
+		{"loopback without token", "127.0.0.1", "", "", fiber.StatusUnauthorized},
+		{"ipv6 loopback without token", "::1", "", "", fiber.StatusUnauthorized},
+		{"loopback with bearer token", "127.0.0.1", "Bearer s3cret", "", fiber.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/status"+tt.query, nil)
+			req.Header.Set(fiber.HeaderXForwardedFor, tt.from)
+			if tt.auth != "" {
+				req.Header.Set(fiber.HeaderAuthorization, tt.auth)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Errorf("got status %d, want %d", resp.StatusCode, tt.status)
+			}
+		})
+	}
+}
+
+func TestRequireToken(t *testing.T) {
+	for _, token := range []string{"s3cret", ""} {
+		app := fiber.New(fiber.Config{ProxyHeader: fiber.HeaderXForwardedFor})
+		app.Use(RequireToken(token))
+		app.Get("/api/status", func(c *fiber.Ctx) error { return c.SendString("ok") })
+
+		req := httptest.NewRequest("GET", "/api/status", nil)
+		req.Header.Set(fiber.HeaderXForwardedFor, "127.0.0.1")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != fiber.StatusUnauthorized {
+			t.Errorf("token %q: loopback without token got %d, want 401", token, resp.StatusCode)
+		}
+
+		req = httptest.NewRequest("GET", "/api/status", nil)
+		req.Header.Set(fiber.HeaderXForwardedFor, "127.0.0.1")
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer ")
+		if resp, err = app.Test(req); err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != fiber.StatusUnauthorized {
+			t.Errorf("token %q: empty bearer got %d, want 401", token, resp.StatusCode)
+		}
+	}
+}
+
+func TestRequireAuth(t *testing.T) {
+	call := func(app *fiber.App, headers map[string]string) int {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/api/keys", nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	// A server with no auth of its own, like the TUI's
+	open := fiber.New()
+	open.Post("/api/keys", RequireAuth(), func(c *fiber.Ctx) error { return c.SendString("added") })
+	if status := call(open, nil); status != fiber.StatusUnauthorized {
+		t.Errorf("no auth configured got %d, want 401", status)
+	}
+	if status := call(open, map[string]string{"Authorization": "Bearer anything"}); status != fiber.StatusUnauthorized {
+		t.Errorf("unchecked token got %d, want 401", status)
+	}
+
+	guarded := fiber.New()
+	guarded.Use(ProxyAuth(ProxyAuthConfig{Secret: "proxy-secret"}))
+	guarded.Use(RequireToken("s3cret"))
+	guarded.Post("/api/keys", RequireAuth(), func(c *fiber.Ctx) error { return c.SendString("added") })
+	if status := call(guarded, map[string]string{"Authorization": "Bearer s3cret"}); status != fiber.StatusOK {
+		t.Errorf("verified token got %d, want 200", status)
+	}
+	if status := call(guarded, map[string]string{"X-Forwarded-User": "alice", "X-Proxy-Secret": "proxy-secret"}); status != fiber.StatusOK {
+		t.Errorf("proxy identity got %d, want 200", status)
+	}
+}
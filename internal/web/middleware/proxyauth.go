@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Default headers a reverse proxy such as oauth2-proxy or Authelia sets
+const (
+	DefaultUserHeader   = "X-Forwarded-User"
+	DefaultGroupsHeader = "X-Forwarded-Groups"
+	DefaultSecretHeader = "X-Proxy-Secret"
+)
+
+// Scopes an identity may have, as in the daemon's RBAC
+const (
+	ScopeNone = "none"
+	ScopeOwn  = "own"
+	ScopeAll  = "all"
+)
+
+// Identity is who a trusted reverse proxy authenticated a request as
+type Identity struct {
+	User   string   `json:"user"`
+	Groups []string `json:"groups,omitempty"`
+	Scope  string   `json:"scope"`
+}
+
+// ProxyAuthConfig says which headers carry the identity and how the proxy
+// proves it set them: by sending Secret, or by presenting a client
+// certificate the TLS listener verified. At least one must be configured.
+type ProxyAuthConfig struct {
+	UserHeader   string // default X-Forwarded-User
+	GroupsHeader string // comma-separated; default X-Forwarded-Groups
+	SecretHeader string // default X-Proxy-Secret
+	Secret       string
+	ClientCert   bool     // trust requests whose connection presented a verified client certificate
+	ProxyNames   []string // if set, the certificate's common name must be one of these
+
+	// Scope maps the identity into the RBAC layer. Nil gives everyone own.
+	Scope func(user string, groups []string) string
+}
+
+type identityKey struct{}
+
+// IdentityFrom returns the identity the proxy vouched for, or nil when the
+// request wasn't authenticated by one
+func IdentityFrom(c *fiber.Ctx) *Identity {
+	id, _ := c.Locals(identityKey{}).(*Identity)
+	return id
+}
+
+// ProxyAuth accepts the identity a trusted reverse proxy puts in the user
+// header, so the API can sit behind a proxy doing the login. Requests
+// without the header pass on to the token check; requests with it that
+// didn't come from the proxy, and identities whose scope is none, are
+// refused.
+func ProxyAuth(cfg ProxyAuthConfig) fiber.Handler {
+	userHeader := headerOr(cfg.UserHeader, DefaultUserHeader)
+	groupsHeader := headerOr(cfg.GroupsHeader, DefaultGroupsHeader)
+	secretHeader := headerOr(cfg.SecretHeader, DefaultSecretHeader)
+
+	return func(c *fiber.Ctx) error {
+		user := strings.TrimSpace(c.Get(userHeader))
+		if user == "" {
+			return c.Next()
+		}
+		if !cfg.fromProxy(c, secretHeader) {
+			return fiber.NewError(fiber.StatusUnauthorized, userHeader+" is only accepted from the authenticating proxy")
+		}
+
+		id := &Identity{User: user, Groups: splitGroups(c.Get(groupsHeader)), Scope: ScopeOwn}
+		if cfg.Scope != nil {
+			id.Scope = cfg.Scope(id.User, id.Groups)
+		}
+		if id.Scope == ScopeNone {
+			return fiber.NewError(fiber.StatusForbidden, "permission denied for "+user)
+		}
+		c.Locals(identityKey{}, id)
+		return c.Next()
+	}
+}
+
+// fromProxy reports whether the request proves it came from the proxy
+func (cfg ProxyAuthConfig) fromProxy(c *fiber.Ctx, secretHeader string) bool {
+	if cfg.Secret != "" {
+		given := c.Get(secretHeader)
+		if given != "" && subtle.ConstantTimeCompare([]byte(given), []byte(cfg.Secret)) == 1 {
+			return true
+		}
+	}
+	if !cfg.ClientCert {
+		return false
+	}
+	state := c.Context().TLSConnectionState()
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return false
+	}
+	if len(cfg.ProxyNames) == 0 {
+		return true
+	}
+	name := state.VerifiedChains[0][0].Subject.CommonName
+	for _, allowed := range cfg.ProxyNames {
+		if name == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireScope refuses requests from proxy identities narrower than all.
+// Requests authenticated by the API token, or trusted as local, already
+// have every permission.
+func RequireScope() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if id := IdentityFrom(c); id != nil && id.Scope != ScopeAll {
+			return fiber.NewError(fiber.StatusForbidden, "permission denied for "+id.User)
+		}
+		return c.Next()
+	}
+}
+
+func headerOr(header, def string) string {
+	if header == "" {
+		return def
+	}
+	return header
+}
+
+func splitGroups(value string) []string {
+	var groups []string
+	for _, g := range strings.Split(value, ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			groups = append(groups, g)
+		}
+	}
+	return groups
+}
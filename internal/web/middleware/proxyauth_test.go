@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestProxyAuth(t *testing.T) {
+	app := fiber.New(fiber.Config{ProxyHeader: fiber.HeaderXForwardedFor})
+	app.Use(ProxyAuth(ProxyAuthConfig{
+		Secret: "proxy-secret",
+		Scope: func(user string, groups []string) string {
+			for _, g := range groups {
+				if g == "ops" {
+					return ScopeAll
+				}
+			}
+			if user == "mallory" {
+				return ScopeNone
+			}
+			return ScopeOwn
+		},
+	}))
+	app.Use(RequireToken("s3cret"))
+	app.Get("/api/status", func(c *fiber.Ctx) error {
+		if id := IdentityFrom(c); id != nil {
+			return c.SendString(id.User + ":" + id.Scope)
+		}
+		return c.SendString("token")
+	})
+	app.Post("/api/keys", RequireScope(), func(c *fiber.Ctx) error { return c.SendString("added") })
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		status  int
+		body    string
+	}{
+		{"token without identity", "GET", map[string]string{"Authorization": "Bearer s3cret"}, fiber.StatusOK, "token"},
+		{"nothing", "GET", nil, fiber.StatusUnauthorized, ""},
+		{"identity from the proxy", "GET", map[string]string{"X-Forwarded-User": "alice", "X-Proxy-Secret": "proxy-secret"}, fiber.StatusOK, "alice:own"},
+		{"group widens scope", "GET", map[string]string{"X-Forwarded-User": "bob", "X-Forwarded-Groups": "dev, ops", "X-Proxy-Secret": "proxy-secret"}, fiber.StatusOK, "bob:all"},
+		{"spoofed identity", "GET", map[string]string{"X-Forwarded-User": "bob", "Authorization": "Bearer s3cret"}, fiber.StatusUnauthorized, ""},
+		{"wrong secret", "GET", map[string]string{"X-Forwarded-User": "bob", "X-Proxy-Secret": "guess"}, fiber.StatusUnauthorized, ""},
+		{"scope none", "GET", map[string]string{"X-Forwarded-User": "mallory", "X-Proxy-Secret": "proxy-secret"}, fiber.StatusForbidden, ""},
+		{"own on an admin route", "POST", map[string]string{"X-Forwarded-User": "alice", "X-Proxy-Secret": "proxy-secret"}, fiber.StatusForbidden, ""},
+		{"all on an admin route", "POST", map[string]string{"X-Forwarded-User": "bob", "X-Forwarded-Groups": "ops", "X-Proxy-Secret": "proxy-secret"}, fiber.StatusOK, "added"},
+		{"token on an admin route", "POST", map[string]string{"Authorization": "Bearer s3cret"}, fiber.StatusOK, "added"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := "/api/status"
+			if tt.method == "POST" {
+				path = "/api/keys"
+			}
+			req := httptest.NewRequest(tt.method, path, nil)
+			req.Header.Set(fiber.HeaderXForwardedFor, "203.0.113.7")
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Fatalf("got status %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.body != "" {
+				buf := make([]byte, 64)
+				n, _ := resp.Body.Read(buf)
+				if got := string(buf[:n]); got != tt.body {
+					t.Errorf("got %q, want %q", got, tt.body)
+				}
+			}
+		})
+	}
+}
+
+func TestProxyAuthWithoutTrust(t *testing.T) {
+	// Neither a secret nor client certificates: no identity is ever trusted
+	app := fiber.New()
+	app.Use(ProxyAuth(ProxyAuthConfig{}))
+	app.Get("/", func(c *fiber.Ctx) error { return c.SendString("ok") })
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Forwarded-User", "alice")
+	req.Header.Set("X-Proxy-Secret", "")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusUnauthorized {
+		t.Errorf("got status %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestProxyAuthFromLoopback(t *testing.T) {
+	// A reverse proxy on this host connects from loopback, so loopback
+	// can't stand in for a token once proxied
+	app := fiber.New(fiber.Config{ProxyHeader: fiber.HeaderXForwardedFor})
+	app.Use(ProxyAuth(ProxyAuthConfig{Secret: "proxy-secret"}))
+	app.Use(RequireToken("s3cret"))
+	app.Get("/api/status", func(c *fiber.Ctx) error { return c.SendString("ok") })
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+	}{
+		{"loopback without token", nil, fiber.StatusUnauthorized},
+		{"loopback with token", map[string]string{"Authorization": "Bearer s3cret"}, fiber.StatusOK},
+		{"identity from the proxy", map[string]string{"X-Forwarded-User": "alice", "X-Proxy-Secret": "proxy-secret"}, fiber.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/status", nil)
+			req.Header.Set(fiber.HeaderXForwardedFor, "127.0.0.1")
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Errorf("got status %d, want %d", resp.StatusCode, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+)
+
+// Caller names who made a request, for rate limits and the audit trail:
+// the user a proxy vouched for, "token:" and the fingerprint of the API
+// token RequireToken verified, or "ip:" and the client address. A token
+// nobody checked counts for nothing, or a client could get a fresh budget
+// by sending a new one with each request.
+func Caller(c *fiber.Ctx) string {
+	if id := IdentityFrom(c); id != nil {
+		return id.User
+	}
+	if token, ok := verifiedToken(c); ok {
+		return "token:" + TokenFingerprint(token)
+	}
+	return "ip:" + c.IP()
+}
+
+// TokenFingerprint identifies an API token in logs without revealing it
+func TokenFingerprint(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:6])
+}
+
+// IsMutation reports whether a request may change something
+func IsMutation(c *fiber.Ctx) bool {
+	switch c.Method() {
+	case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+		return false
+	}
+	return true
+}
+
+// RateLimit allows each caller max requests a minute, counting only the
+// requests for which only returns true, or all requests when only is nil.
+// Callers over the limit get 429 Too Many Requests with a Retry-After
+// header, so runaway automation backs off instead of starving the daemon.
+func RateLimit(max int, only func(*fiber.Ctx) bool) fiber.Handler {
+	return limiter.New(limiter.Config{
+		Max:          max,
+		Expiration:   time.Minute,
+		KeyGenerator: Caller,
+		Next: func(c *fiber.Ctx) bool {
+			return only != nil && !only(c)
+		},
+		LimitReached: func(c *fiber.Ctx) error {
+			return fiber.NewError(fiber.StatusTooManyRequests, "Too many requests from "+Caller(c)+"; retry after "+c.GetRespHeader(fiber.HeaderRetryAfter)+"s")
+		},
+	})
+}
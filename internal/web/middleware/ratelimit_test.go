@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestRateLimitAndAudit(t *testing.T) {
+	app := fiber.New(fiber.Config{ProxyHeader: fiber.HeaderXForwardedFor})
+	// Two API tokens, each verified, as two daemons sharing the audit trail
+	app.Use(func(c *fiber.Ctx) error {
+		if c.Get(fiber.HeaderAuthorization) == "Bearer deploy" {
+			return RequireToken("deploy")(c)
+		}
+		return RequireToken("ci")(c)
+	})
+	var records []AuditRecord
+	app.Use(Audit(func(r AuditRecord) { records = append(records, r) }))
+	app.Use(RateLimit(2, IsMutation))
+	app.Get("/api/status", func(c *fiber.Ctx) error { return c.SendString("ok") })
+	app.Post("/api/providers/:name/connect", func(c *fiber.Ctx) error { return c.SendString("connected") })
+	app.Delete("/api/keys/:user/:id", func(c *fiber.Ctx) error {
+		return fiber.NewError(fiber.StatusNotFound, "key not found")
+	})
+
+	call := func(method, path, token, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(fiber.HeaderXForwardedFor, "203.0.113.7")
+		if token != "" {
+			req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+		}
+		if body != "" {
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	// Each token has a budget of its own, and reads don't use it up
+	for i := 0; i < 5; i++ {
+		if status := call("GET", "/api/status", "ci", ""); status != fiber.StatusOK {
+			t.Fatalf("read %d got status %d", i, status)
+		}
+	}
+	call("POST", "/api/providers/bore/connect?wait=true", "ci", `{"port": 22, "auth_key": "tskey-123"}`)
+	call("DELETE", "/api/keys/alice/42", "ci", "")
+	if status := call("POST", "/api/providers/bore/connect", "ci", ""); status != fiber.StatusTooManyRequests {
+		t.Errorf("third mutation got status %d, want 429", status)
+	}
+	if status := call("POST", "/api/providers/bore/connect", "deploy", ""); status != fiber.StatusOK {
+		t.Errorf("another token got status %d, want 200", status)
+	}
+
+	if len(records) != 4 {
+		t.Fatalf("got %d audit records, want 4: %+v", len(records), records)
+	}
+	connect := records[0]
+	if connect.Caller != "token:"+TokenFingerprint("ci") || connect.Endpoint != "/api/providers/:name/connect" || connect.Status != fiber.StatusOK {
+		t.Errorf("connect record = %+v", connect)
+	}
+	want := map[string]string{"name": "bore", "wait": "true", "port": "22", "auth_key": "[redacted]"}
+	for k, v := range want {
+		if connect.Params[k] != v {
+			t.Errorf("param %s = %q, want %q", k, connect.Params[k], v)
+		}
+	}
+	if del := records[1]; del.Status != fiber.StatusNotFound || del.Error != "key not found" || del.Params["user"] != "alice" {
+		t.Errorf("delete record = %+v", del)
+	}
+	if records[2].Status != fiber.StatusTooManyRequests || records[3].Caller == connect.Caller {
+		t.Errorf("limited record = %+v, next = %+v", records[2], records[3])
+	}
+}
+
+func TestRateLimitUncheckedTokens(t *testing.T) {
+	app := fiber.New(fiber.Config{ProxyHeader: fiber.HeaderXForwardedFor})
+	app.Use(RateLimit(2, nil))
+	app.Get("/api/status", func(c *fiber.Ctx) error { return c.SendString(Caller(c)) })
+
+	// Without RequireToken a made-up token per request earns no new budget
+	for i, want := range []int{fiber.StatusOK, fiber.StatusOK, fiber.StatusTooManyRequests} {
+		req := httptest.NewRequest("GET", "/api/status", nil)
+		req.Header.Set(fiber.HeaderXForwardedFor, "203.0.113.7")
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer made-up-"+strconv.Itoa(i))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != want {
+			t.Fatalf("request %d got status %d, want %d", i, resp.StatusCode, want)
+		}
+		if want == fiber.StatusOK && string(body) != "ip:203.0.113.7" {
+			t.Errorf("request %d counted against %q, want the client address", i, body)
+		}
+	}
+}
@@ -2,43 +2,153 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"github.com/jedarden/tunnel/internal/cmdtemplate"
+	"github.com/jedarden/tunnel/internal/providers"
+	"github.com/jedarden/tunnel/internal/units"
 	"gopkg.in/yaml.v3"
 )
 
 // Config represents the main configuration structure
 type Config struct {
-	Version     string                  `yaml:"version"`
-	Settings    Settings                `yaml:"settings"`
-	Credentials CredentialConfig        `yaml:"credentials"`
-	Methods     map[string]MethodConfig `yaml:"methods"`
-	SSH         SSHConfig               `yaml:"ssh"`
-	Monitoring  MonitoringConfig        `yaml:"monitoring"`
+	Version       string                  `yaml:"version"`
+	ConfigVersion int                     `yaml:"config_version"` // schema version, see migrations.go
+	Settings      Settings                `yaml:"settings"`
+	Credentials   CredentialConfig        `yaml:"credentials"`
+	Methods       map[string]MethodConfig `yaml:"methods"`
+	SSH           SSHConfig               `yaml:"ssh"`
+	Monitoring    MonitoringConfig        `yaml:"monitoring"`
+	Enrollment    EnrollmentConfig        `yaml:"enrollment"`
+	Notifications NotificationConfig      `yaml:"notifications"`
+	Alerts        []AlertRuleConfig       `yaml:"alerts"`
+	DNS           DNSConfig               `yaml:"dns"`
+	Updates       UpdatesConfig           `yaml:"updates"`
+
+	// Providers controls the traffic of the providers listed, by name
+	Providers map[string]TrafficConfig `yaml:"providers,omitempty"`
+
+	// Sandbox confines the processes of the providers listed, by name
+	Sandbox map[string]SandboxConfig `yaml:"sandbox,omitempty"`
+
+	// Wake lists local ports that start a tunnel when first connected to
+	Wake []WakeConfig `yaml:"wake,omitempty"`
+
+	// Forwards exposes local services through providers, by name
+	Forwards []ForwardConfig `yaml:"forwards,omitempty"`
+
+	// Profiles are named setups to switch between, by name
+	Profiles map[string]ProfileConfig `yaml:"profiles,omitempty"`
+
+	// Commands replaces the command line of the providers listed, by name,
+	// with a template; see internal/cmdtemplate
+	Commands map[string]string `yaml:"commands,omitempty"`
+
+	// Scripts defines providers run from user-supplied commands, by name
+	Scripts map[string]ScriptConfig `yaml:"scripts,omitempty"`
+
+	// RBAC scopes what each OS user may do through a system-wide daemon
+	RBAC RBACConfig `yaml:"rbac,omitempty"`
+
+	// Health serves the daemon's HTTP liveness and readiness probes
+	Health HealthConfig `yaml:"health,omitempty"`
+
+	// Watchdog restarts a daemon's stuck subsystems
+	Watchdog WatchdogConfig `yaml:"watchdog,omitempty"`
+
+	// API controls who the REST API trusts besides holders of its token
+	API APIConfig `yaml:"api,omitempty"`
+
+	// Verify lists the probes that check tunnels from outside
+	Verify VerifyConfig `yaml:"verify,omitempty"`
+
+	// Failover tunes when the primary tunnel is given up for a backup
+	Failover FailoverConfig `yaml:"failover,omitempty"`
+
+	// Rotation replaces the keypairs of managed identities on a schedule
+	Rotation RotationConfig `yaml:"rotation,omitempty"`
+
+	// Fleet lists the other TUNNEL nodes key revocations are passed on to
+	Fleet FleetConfig `yaml:"fleet,omitempty"`
+
+	// TwoPerson makes emergency and bulk actions wait for a second operator
+	TwoPerson TwoPersonConfig `yaml:"two_person,omitempty"`
+
+	// Dashboard picks the panels of the TUI's dashboard
+	Dashboard DashboardConfig `yaml:"dashboard,omitempty"`
+
+	// KeySources are servers keys are imported from besides github,
+	// gitlab and codeberg
+	KeySources []KeySourceConfig `yaml:"key_sources,omitempty"`
+
+	// KeySync keeps users' keys in line with what they publish upstream
+	KeySync KeySyncConfig `yaml:"key_sync,omitempty"`
 
 	mu       sync.RWMutex
 	filePath string
+	saved    *yaml.Node // as last loaded or saved; Save writes only what changed since
+	layers   map[Source]*layer
 	watcher  *fsnotify.Watcher
 	onChange []func(*Config)
 }
 
 // Settings contains general application settings
 type Settings struct {
-	DefaultMethod string `yaml:"default_method"`
-	AutoReconnect bool   `yaml:"auto_reconnect"`
-	LogLevel      string `yaml:"log_level"`
-	Theme         string `yaml:"theme"`
+	DefaultMethod   string `yaml:"default_method"`
+	AutoReconnect   bool   `yaml:"auto_reconnect"`
+	LogLevel        string `yaml:"log_level"`
+	LogFormat       string `yaml:"log_format,omitempty"`    // text or json
+	LogFile         string `yaml:"log_file,omitempty"`      // empty logs to stderr
+	LogMaxSize      int    `yaml:"log_max_size,omitempty"`  // megabytes before log_file is rotated; default 10
+	LogMaxFiles     int    `yaml:"log_max_files,omitempty"` // rotated log files kept; default 3, -1 none
+	Theme           string `yaml:"theme"`
+	Offline         bool   `yaml:"offline"`          // Suppress all outbound internet access
+	ShutdownTimeout int    `yaml:"shutdown_timeout"` // Seconds to wait for a graceful shutdown before forcing exit
+
+	// How sizes, durations, numbers and times are shown; see internal/units
+	Units         string `yaml:"units,omitempty"`          // iec (KiB) or si (kB)
+	DurationStyle string `yaml:"duration_style,omitempty"` // short (2d 3h) or long (2 days 3 hours)
+	Locale        string `yaml:"locale,omitempty"`         // number separators and date order, e.g. de-DE; default from LANG
+	TimeFormat    string `yaml:"time_format,omitempty"`    // iso, rfc3339, relative (2m ago) or locale
+	Timezone      string `yaml:"timezone,omitempty"`       // e.g. UTC or Europe/Berlin; default the machine's
+
+	// Connection budget; 0 is unlimited
+	MaxConnections          int `yaml:"max_connections"`            // Concurrent tunnels in total
+	MaxInstancesPerProvider int `yaml:"max_instances_per_provider"` // Concurrent tunnels of one provider
+
+	// How connections share SSH sessions: failover (one primary) or
+	// load-balance (all healthy ones), which picks by round-robin or latency
+	ConnectionMode string `yaml:"connection_mode,omitempty"`
+	Balance        string `yaml:"balance,omitempty"`
+
+	Profile string `yaml:"profile,omitempty"` // the profile last applied
+}
+
+// UnitOptions returns the formatting settings as options for internal/units
+func (s Settings) UnitOptions() units.Options {
+	return units.Options{
+		Units:     units.Style(s.Units),
+		Durations: units.DurationStyle(s.DurationStyle),
+		Locale:    s.Locale,
+		Times:     units.TimeStyle(s.TimeFormat),
+		Timezone:  s.Timezone,
+	}
 }
 
 // CredentialConfig contains credential store configuration
 type CredentialConfig struct {
-	Store      string `yaml:"store"`      // keyring, file, env
-	BaseDir    string `yaml:"base_dir"`   // For file store
-	Passphrase string `yaml:"passphrase"` // For file store encryption
+	Store          string `yaml:"store"`                     // keyring, file, env
+	BaseDir        string `yaml:"base_dir"`                  // For file store
+	Passphrase     string `yaml:"passphrase"`                // For file store encryption
+	PassphraseFile string `yaml:"passphrase_file,omitempty"` // file holding the passphrase instead
 }
 
 // MethodConfig contains configuration for each authentication method
@@ -52,15 +162,81 @@ type MethodConfig struct {
 
 // SSHConfig contains SSH-specific configuration
 type SSHConfig struct {
-	Port                 int      `yaml:"port"`
-	HostKeyPath          string   `yaml:"host_key_path"`
-	AuthorizedKeys       string   `yaml:"authorized_keys"`
-	AllowedUsers         []string `yaml:"allowed_users"`
-	MaxSessions          int      `yaml:"max_sessions"`
-	IdleTimeout          int      `yaml:"idle_timeout"` // seconds
-	KeepAlive            int      `yaml:"keep_alive"`   // seconds
-	AllowTCPForwarding   bool     `yaml:"allow_tcp_forwarding"`
-	AllowAgentForwarding bool     `yaml:"allow_agent_forwarding"`
+	Port                 int        `yaml:"port"`
+	HostKeyPath          string     `yaml:"host_key_path"`
+	AuthorizedKeys       string     `yaml:"authorized_keys"`
+	KeyStore             string     `yaml:"key_store"`    // file, sqlite or ldap
+	KeyDatabase          string     `yaml:"key_database"` // sqlite database path
+	LDAP                 LDAPConfig `yaml:"ldap"`
+	AllowedUsers         []string   `yaml:"allowed_users"`
+	MaxSessions          int        `yaml:"max_sessions"`
+	IdleTimeout          int        `yaml:"idle_timeout"` // seconds
+	KeepAlive            int        `yaml:"keep_alive"`   // seconds
+	AllowTCPForwarding   bool       `yaml:"allow_tcp_forwarding"`
+	AllowAgentForwarding bool       `yaml:"allow_agent_forwarding"`
+	// AgentForwardingUsers overrides AllowAgentForwarding per key owner
+	AgentForwardingUsers map[string]bool  `yaml:"agent_forwarding_users,omitempty"`
+	SessionLog           SessionLogConfig `yaml:"session_log"`
+	SFTP                 SFTPConfig       `yaml:"sftp"`
+	TOTP                 TOTPConfig       `yaml:"totp"`
+	CA                   SSHCAConfig      `yaml:"ca,omitempty"`
+}
+
+// SSHCAConfig is the user certificate authority `tunnel keys sign` signs
+// keys with, and where sshd is told to trust it
+type SSHCAConfig struct {
+	KeyPath     string `yaml:"key_path,omitempty"`     // CA private key; default ~/.config/tunnel/ca/user_ca
+	TrustedKeys string `yaml:"trusted_keys,omitempty"` // file sshd's TrustedUserCAKeys names
+	Validity    int    `yaml:"validity,omitempty"`     // hours certificates are valid; 0 uses DefaultCertValidity
+}
+
+// DefaultCertValidity is how many hours certificates are valid when the
+// validity is unset
+const DefaultCertValidity = 24
+
+// Hours returns how long certificates are valid
+func (c SSHCAConfig) Hours() int {
+	if c.Validity == 0 {
+		return DefaultCertValidity
+	}
+	return c.Validity
+}
+
+// TOTPConfig controls the TOTP second factor asked for after publickey
+// authentication, through PAM keyboard-interactive
+type TOTPConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	Issuer  string   `yaml:"issuer"` // name shown in authenticator apps
+	Store   string   `yaml:"store"`  // enrollments; holds secrets
+	Bypass  []string `yaml:"bypass,omitempty"`
+}
+
+// SFTPConfig is the SFTP access sshd enforces through the drop-in written
+// by `tunnel sftp apply`
+type SFTPConfig struct {
+	Enabled       bool              `yaml:"enabled"`
+	ReadOnly      bool              `yaml:"read_only"`
+	Chroot        map[string]string `yaml:"chroot,omitempty"`          // user to directory; these users get SFTP only
+	ReadOnlyUsers []string          `yaml:"read_only_users,omitempty"` // SFTP-only users who may not write
+}
+
+// SessionLogConfig controls the record of SSH sessions kept in the audit log
+type SessionLogConfig struct {
+	Enabled       bool `yaml:"enabled"`
+	Commands      bool `yaml:"commands"`       // also record the commands of exec requests
+	RetentionDays int  `yaml:"retention_days"` // drop session records older than this; 0 keeps them
+}
+
+// LDAPConfig configures the read-only LDAP key store
+type LDAPConfig struct {
+	URL              string `yaml:"url"`
+	BaseDN           string `yaml:"base_dn"`
+	BindDN           string `yaml:"bind_dn"`            // empty binds anonymously
+	BindPasswordFile string `yaml:"bind_password_file"` // file holding the bind password
+	Filter           string `yaml:"filter"`
+	UserAttribute    string `yaml:"user_attribute"`
+	KeyAttribute     string `yaml:"key_attribute"`
+	SyncInterval     int    `yaml:"sync_interval"` // seconds between authorized_keys exports
 }
 
 // MonitoringConfig contains monitoring and audit configuration
@@ -71,14 +247,439 @@ type MonitoringConfig struct {
 	SyslogServer   string `yaml:"syslog_server"`
 	MetricsEnabled bool   `yaml:"metrics_enabled"`
 	MetricsPort    int    `yaml:"metrics_port"`
+
+	// AuditKeyFile holds a secret the audit log's entries are chained with
+	// as HMACs, so the chain can't be rebuilt after tampering without it
+	AuditKeyFile string `yaml:"audit_key_file,omitempty"`
+
+	// ProviderLimits are optional per-provider resource thresholds that
+	// trigger warnings, or cap the provider's processes when enforced
+	ProviderLimits map[string]ProviderLimit `yaml:"provider_limits,omitempty"`
+}
+
+// ProviderLimit contains resource thresholds for a provider's processes; zero disables a limit
+type ProviderLimit struct {
+	MaxCPUPercent float64 `yaml:"max_cpu_percent"`
+	MaxMemoryMB   uint64  `yaml:"max_memory_mb"`
+	MaxOpenFiles  uint64  `yaml:"max_open_files"` // per process
+	Enforce       bool    `yaml:"enforce"`        // apply through cgroup v2 on Linux, rlimits elsewhere
+}
+
+// TrafficConfig caps the traffic of a provider's tunnel in kilobits a
+// second. MaxKbps applies to both directions unless MaxUploadKbps or
+// MaxDownloadKbps overrides it; zero is unlimited. Limited tunnels are
+// relayed through the running instance, and Capture relays the tunnel even
+// without limits so its traffic can be captured for debugging.
+type TrafficConfig struct {
+	MaxKbps         int  `yaml:"max_kbps,omitempty"`
+	MaxUploadKbps   int  `yaml:"max_upload_kbps,omitempty"`   // local service to the tunnel
+	MaxDownloadKbps int  `yaml:"max_download_kbps,omitempty"` // tunnel to the local service
+	Capture         bool `yaml:"capture,omitempty"`
+}
+
+// Limits returns the effective upload and download limits
+func (b TrafficConfig) Limits() (upload, download int) {
+	upload, download = b.MaxKbps, b.MaxKbps
+	if b.MaxUploadKbps != 0 {
+		upload = b.MaxUploadKbps
+	}
+	if b.MaxDownloadKbps != 0 {
+		download = b.MaxDownloadKbps
+	}
+	return upload, download
+}
+
+// SandboxConfig restricts what a provider's processes can reach; unset
+// fields leave that part of the system alone
+type SandboxConfig struct {
+	User     string   `yaml:"user"`     // unprivileged account to run as
+	AppArmor string   `yaml:"apparmor"` // AppArmor profile name
+	Seccomp  string   `yaml:"seccomp"`  // compiled seccomp BPF filter file
+	ReadOnly bool     `yaml:"read_only"`
+	Writable []string `yaml:"writable"` // paths left writable when read_only
+	Hide     []string `yaml:"hide"`     // paths hidden from the provider
+	Required bool     `yaml:"required"` // refuse to connect if confinement is unavailable
+}
+
+// ScriptConfig is a provider whose connect, disconnect and health check
+// are commands the user supplies. Each command is a template like those in
+// the commands section, without {{.Binary}} or {{.Args}}.
+type ScriptConfig struct {
+	Connect      string            `yaml:"connect"`       // long-running command TUNNEL supervises
+	Disconnect   string            `yaml:"disconnect"`    // run after the connect command is stopped
+	Health       string            `yaml:"health"`        // exits 0 while the tunnel works
+	Endpoint     string            `yaml:"endpoint"`      // regexp matching the public endpoint in the connect command's output
+	EndpointJSON string            `yaml:"endpoint_json"` // dotted field holding the endpoint in JSON output lines
+	Category     string            `yaml:"category"`      // vpn, tunnel, direct or ssh; default tunnel
+	Env          map[string]string `yaml:"env"`
+	Restart      bool              `yaml:"restart"`       // restart the connect command if it exits
+	StartTimeout int               `yaml:"start_timeout"` // seconds to wait for the endpoint; 0 uses the default of 30
+}
+
+// EnrollmentConfig contains configuration for the key self-enrollment endpoint
+type EnrollmentConfig struct {
+	Enabled        bool       `yaml:"enabled"`
+	BaseURL        string     `yaml:"base_url"`        // Public URL used in verification links
+	AllowedDomains []string   `yaml:"allowed_domains"` // Email domains allowed to enroll; empty allows any
+	TokenTTL       int        `yaml:"token_ttl"`       // seconds
+	SMTP           SMTPConfig `yaml:"smtp"`
+}
+
+// SMTPConfig contains the mail server used to send verification links
+type SMTPConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`
+}
+
+// NotificationConfig controls where notifications about connection health
+// and key changes are sent
+type NotificationConfig struct {
+	Desktop    bool                        `yaml:"desktop"`
+	Email      EmailNotificationConfig     `yaml:"email,omitempty"`
+	Slack      SlackNotificationConfig     `yaml:"slack,omitempty"`
+	Webhooks   []WebhookNotificationConfig `yaml:"webhooks,omitempty"`
+	Events     map[string]bool             `yaml:"events"` // drop, failover, recovery, alert, update, failure, key; missing entries are enabled
+	QuietHours QuietHoursConfig            `yaml:"quiet_hours"`
+}
+
+// EmailNotificationConfig mails notifications through an SMTP server. It is
+// off while To is empty.
+type EmailNotificationConfig struct {
+	SMTPConfig `yaml:",inline"`
+	To         []string `yaml:"to,omitempty"`
+}
+
+// SlackNotificationConfig posts notifications to a Slack incoming webhook
+type SlackNotificationConfig struct {
+	WebhookURL string `yaml:"webhook_url,omitempty"`
+	Channel    string `yaml:"channel,omitempty"` // overrides the webhook's channel
+}
+
+// WebhookNotificationConfig posts notifications as JSON to an HTTP endpoint
+type WebhookNotificationConfig struct {
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+}
+
+// QuietHoursConfig is a daily window, in local "HH:MM" time, during which
+// notifications are suppressed. The window may wrap past midnight.
+type QuietHoursConfig struct {
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+}
+
+// RBACConfig assigns each OS user of a system-wide daemon a scope: none,
+// own (the instances they started) or all (every instance, and stopping the
+// daemon). root always has all.
+type RBACConfig struct {
+	Default string            `yaml:"default,omitempty"` // for users matched by nothing else; default own
+	Users   map[string]string `yaml:"users,omitempty"`   // scope by user name
+	Groups  map[string]string `yaml:"groups,omitempty"`  // scope by group name; the widest applies
+}
+
+// HealthConfig is where a daemon answers /healthz and /readyz, and what
+// it takes to be ready
+type HealthConfig struct {
+	Listen     string `yaml:"listen,omitempty"`      // e.g. 127.0.0.1:8081; empty serves no probes
+	MinTunnels int    `yaml:"min_tunnels,omitempty"` // established tunnels /readyz needs; 0 is ready once up
+}
+
+// WatchdogConfig tunes how a daemon watches its own subsystems. When
+// restarting a stuck one doesn't help, the daemon restarts itself and
+// restores its tunnels.
+type WatchdogConfig struct {
+	Disabled    bool `yaml:"disabled,omitempty"`
+	Interval    int  `yaml:"interval,omitempty"`     // seconds between checks; 0 uses the default of 30
+	MaxRestarts int  `yaml:"max_restarts,omitempty"` // restarts of one subsystem within 10 minutes before the daemon restarts; 0 uses the default of 3
+}
+
+// APIConfig secures the REST API
+type APIConfig struct {
+	// TLSCert and TLSKey serve the API over HTTPS; proxy_auth.client_ca
+	// needs them
+	TLSCert string `yaml:"tls_cert,omitempty"`
+	TLSKey  string `yaml:"tls_key,omitempty"`
+
+	ProxyAuth ProxyAuthConfig    `yaml:"proxy_auth,omitempty"`
+	RateLimit APIRateLimitConfig `yaml:"rate_limit,omitempty"`
+}
+
+// Default API rate limits, per caller and minute
+const (
+	DefaultAPIRequestsPerMinute    = 600
+	DefaultAPIMutationsPerMinute   = 60
+	DefaultAPIEnrollmentsPerMinute = 5
+)
+
+// APIRateLimitConfig caps the calls each caller - an API token, a proxied
+// user or a client address - may make a minute; 0 uses the default
+type APIRateLimitConfig struct {
+	Requests    int  `yaml:"requests,omitempty"`    // any call; default 600
+	Mutations   int  `yaml:"mutations,omitempty"`   // calls that may change something; default 60
+	Enrollments int  `yaml:"enrollments,omitempty"` // enrollment requests and verification attempts; default 5
+	Disabled    bool `yaml:"disabled,omitempty"`
+}
+
+// Limits returns the effective limits on requests and mutations
+func (r APIRateLimitConfig) Limits() (requests, mutations int) {
+	requests, mutations = r.Requests, r.Mutations
+	if requests == 0 {
+		requests = DefaultAPIRequestsPerMinute
+	}
+	if mutations == 0 {
+		mutations = DefaultAPIMutationsPerMinute
+	}
+	return requests, mutations
+}
+
+// EnrollmentLimit returns the effective limit on self-enrollment requests,
+// kept low since each sends an email and each verification guesses a token
+func (r APIRateLimitConfig) EnrollmentLimit() int {
+	if r.Enrollments == 0 {
+		return DefaultAPIEnrollmentsPerMinute
+	}
+	return r.Enrollments
+}
+
+// VerifyConfig sets up end-to-end checks of tunnel endpoints. Probes are
+// asked to connect to an endpoint from where they run; AnswerProbes lets
+// this node be such a probe for others, at /api/probe.
+type VerifyConfig struct {
+	Probes       []ProbeConfig `yaml:"probes,omitempty"`
+	AnswerProbes bool          `yaml:"answer_probes,omitempty"`
+	Timeout      int           `yaml:"timeout,omitempty"` // seconds per check; default 10
+}
+
+// FailoverConfig tunes latency-based failover: a primary tunnel slower than
+// MaxLatency for LatencyChecks health checks in a row is given up for a
+// faster healthy backup
+type FailoverConfig struct {
+	MaxLatency    int `yaml:"max_latency,omitempty"`    // milliseconds; 0 uses the default of 500
+	LatencyChecks int `yaml:"latency_checks,omitempty"` // 0 uses the default of 3; -1 never fails over for latency
+}
+
+// RotationConfig lists the identities whose keypairs TUNNEL generates and
+// replaces on a schedule
+type RotationConfig struct {
+	Identities []IdentityRotation `yaml:"identities,omitempty"`
+}
+
+// IdentityRotation is a keypair TUNNEL holds for a user: every Every days
+// a new one is generated at KeyPath and installed for User, and the old
+// one stays valid for Overlap more days before it is revoked
+type IdentityRotation struct {
+	Name    string `yaml:"name"`
+	User    string `yaml:"user"`
+	KeyPath string `yaml:"key_path"`          // private key; the public key is next to it with .pub
+	Every   int    `yaml:"every,omitempty"`   // days; 0 uses DefaultRotationDays
+	Overlap int    `yaml:"overlap,omitempty"` // days; 0 revokes the old key at once
+}
+
+// FleetConfig lists other TUNNEL nodes, reached through their REST API.
+// Keys revoked here can be revoked on every node too; with
+// PropagateRevocations that is the default.
+type FleetConfig struct {
+	Nodes                []FleetNode `yaml:"nodes,omitempty"`
+	PropagateRevocations bool        `yaml:"propagate_revocations,omitempty"`
+}
+
+// FleetNode is another node's API
+type FleetNode struct {
+	Name      string `yaml:"name"`
+	URL       string `yaml:"url"`                  // the API's base URL, e.g. https://node-b:8080
+	TokenFile string `yaml:"token_file,omitempty"` // holds the node's API token
+}
+
+// TwoPersonConfig turns on the two-person rule: emergency-revoke and fleet
+// revoke are staged by one operator and run only once another approves
+// them within Window
+type TwoPersonConfig struct {
+	Enabled bool   `yaml:"enabled,omitempty"`
+	Window  int    `yaml:"window,omitempty"` // minutes; 0 uses DefaultApprovalWindow
+	Store   string `yaml:"store,omitempty"`  // staged actions, shared by the operators; empty uses DefaultStagedStore
+}
+
+// DashboardConfig lists the TUI's dashboard widgets in the order they are
+// shown, e.g. connections, alerts, bandwidth, bandwidth_graph,
+// key_expirations, recent_events and forwards. Empty shows the default set.
+type DashboardConfig struct {
+	Widgets []string `yaml:"widgets,omitempty"`
+
+	// GraphStyle draws the bandwidth graph in braille dots, the default,
+	// or in block characters
+	GraphStyle string `yaml:"graph_style,omitempty"`
+}
+
+// KeySourceConfig is a server `tunnel keys import --source` fetches a user's
+// published SSH keys from. A source named like a built-in one replaces it.
+type KeySourceConfig struct {
+	Name      string `yaml:"name"`
+	Type      string `yaml:"type"`                 // github, gitlab, gitea or url
+	URL       string `yaml:"url"`                  // the server's base URL; for url, the keys' URL with {user}
+	TokenFile string `yaml:"token_file,omitempty"` // holds an API token, for private instances
+}
+
+// KeySyncConfig maps users to their accounts on key sources. While tunnel
+// runs, and on `tunnel keys sync`, keys they publish are added and keys
+// they removed upstream are revoked.
+type KeySyncConfig struct {
+	Interval int           `yaml:"interval,omitempty"` // minutes between syncs; 0 uses DefaultKeySyncInterval
+	Users    []KeySyncUser `yaml:"users,omitempty"`
+}
+
+// KeySyncUser is a user whose keys follow an account on a key source
+type KeySyncUser struct {
+	User    string `yaml:"user"`              // owner of the keys here
+	Source  string `yaml:"source,omitempty"`  // github, gitlab, codeberg or a key_sources name; default github
+	Account string `yaml:"account,omitempty"` // name on the source; default the user
+}
+
+// DefaultKeySyncInterval is how many minutes pass between key syncs when
+// the interval is unset
+const DefaultKeySyncInterval = 60
+
+// Minutes returns how long to wait between key syncs
+func (k KeySyncConfig) Minutes() int {
+	if k.Interval == 0 {
+		return DefaultKeySyncInterval
+	}
+	return k.Interval
+}
+
+// DefaultApprovalWindow is how many minutes a staged action waits for
+// approval when the window is unset
+const DefaultApprovalWindow = 30
+
+// Minutes returns how long a staged action waits for approval
+func (t TwoPersonConfig) Minutes() int {
+	if t.Window == 0 {
+		return DefaultApprovalWindow
+	}
+	return t.Window
+}
+
+// DefaultStagedStore is where staged actions are kept when the store is
+// unset: a system path, so every operator sees the same actions
+const DefaultStagedStore = "/var/lib/tunnel/staged.json"
+
+// StorePath returns the file staged actions are kept in
+func (t TwoPersonConfig) StorePath() string {
+	if t.Store == "" {
+		return DefaultStagedStore
+	}
+	return t.Store
+}
+
+// DefaultRotationDays is how often identities are rotated when their
+// every is unset
+const DefaultRotationDays = 90
+
+// Days returns how many days each keypair of the identity is used for
+func (r IdentityRotation) Days() int {
+	if r.Every == 0 {
+		return DefaultRotationDays
+	}
+	return r.Every
+}
+
+// ProbeConfig is a probe service or another TUNNEL node's /api/probe
+type ProbeConfig struct {
+	Name      string `yaml:"name"`
+	URL       string `yaml:"url"`
+	TokenFile string `yaml:"token_file,omitempty"` // holds the bearer token the probe wants
+}
+
+// ProxyAuthConfig trusts the identity an authenticating reverse proxy, such
+// as oauth2-proxy or Authelia, passes in a header. The proxy proves it set
+// the header with a shared secret, a client certificate signed by ClientCA,
+// or both. The identity's scope comes from the rbac section.
+type ProxyAuthConfig struct {
+	UserHeader   string   `yaml:"user_header,omitempty"`   // default X-Forwarded-User
+	GroupsHeader string   `yaml:"groups_header,omitempty"` // default X-Forwarded-Groups
+	SecretHeader string   `yaml:"secret_header,omitempty"` // default X-Proxy-Secret
+	SecretFile   string   `yaml:"secret_file,omitempty"`   // holds the shared secret
+	ClientCA     string   `yaml:"client_ca,omitempty"`     // PEM CA the proxy's client certificate must chain to
+	ProxyNames   []string `yaml:"proxy_names,omitempty"`   // common names the certificate may have; empty accepts any
+}
+
+// Enabled reports whether proxy authentication is configured
+func (p ProxyAuthConfig) Enabled() bool {
+	return p.SecretFile != "" || p.ClientCA != ""
+}
+
+// AlertRuleConfig defines an alert rule evaluated by the running instance
+type AlertRuleConfig struct {
+	Name       string  `yaml:"name"`
+	Condition  string  `yaml:"condition"`  // unhealthy, latency, key_age
+	Target     string  `yaml:"target"`     // Connection ID or key fingerprint; empty matches all
+	Threshold  string  `yaml:"threshold"`  // Latency ("300ms") or key age ("1y", "90d")
+	Percentile float64 `yaml:"percentile"` // Latency percentile, default 95
+	For        string  `yaml:"for"`        // How long the condition must hold, e.g. "5m"
+}
+
+// DNSConfig controls the DNS-over-tunnel forwarder, which sends queries to
+// the resolvers of a connected VPN such as Tailscale MagicDNS
+type DNSConfig struct {
+	Enabled          bool   `yaml:"enabled"`
+	Listen           string `yaml:"listen"`             // Forwarder address; resolv.conf needs port 53
+	ManageResolvConf bool   `yaml:"manage_resolv_conf"` // Point resolv.conf at the forwarder while active
+	ResolvConf       string `yaml:"resolv_conf"`
+}
+
+// WakeConfig starts Provider when something connects to Listen and hands
+// the connection to Target, an address reachable through the tunnel
+type WakeConfig struct {
+	Listen       string `yaml:"listen"`        // local address, e.g. 127.0.0.1:15432
+	Provider     string `yaml:"provider"`      // tunnel to start
+	Target       string `yaml:"target"`        // address connections are forwarded to once it is up
+	IdleTimeout  int    `yaml:"idle_timeout"`  // seconds unused before the tunnel is stopped again; 0 keeps it up
+	StartTimeout int    `yaml:"start_timeout"` // seconds to wait for the target; 0 uses the default of 30
+}
+
+// ForwardConfig exposes LocalPort through Provider. Each forward runs a
+// client of its own, so a provider can carry several.
+type ForwardConfig struct {
+	Name       string `yaml:"name"`
+	Provider   string `yaml:"provider"`
+	LocalPort  int    `yaml:"local_port"`
+	RemoteHost string `yaml:"remote_host,omitempty"` // empty uses the provider's
+	RemotePort int    `yaml:"remote_port,omitempty"` // 0 lets the provider pick
+	Autostart  bool   `yaml:"autostart,omitempty"`   // started by the daemon and the TUI
+}
+
+// UpdatesConfig controls update checks for provider binaries installed by TUNNEL
+type UpdatesConfig struct {
+	Check    bool              `yaml:"check"`    // Check for new releases while an instance runs
+	Interval int               `yaml:"interval"` // seconds between checks
+	Pin      map[string]string `yaml:"pin"`      // Provider to the version it is held at
+}
+
+// EventEnabled reports whether notifications are on for the given event type
+func (n NotificationConfig) EventEnabled(event string) bool {
+	enabled, ok := n.Events[event]
+	return !ok || enabled
 }
 
 var (
 	defaultConfigPath = filepath.Join(os.Getenv("HOME"), ".config", "tunnel", "config.yaml")
 )
 
-// Load loads configuration from the specified path
+// Load loads configuration from the specified path, first migrating it to
+// CurrentConfigVersion
 func Load(path string) (*Config, error) {
+	return load(path, true)
+}
+
+// LoadUnmigrated loads configuration without migrating it, for commands that
+// report on or preview migrations
+func LoadUnmigrated(path string) (*Config, error) {
+	return load(path, false)
+}
+
+func load(path string, migrate bool) (*Config, error) {
 	if path == "" {
 		path = defaultConfigPath
 	}
@@ -96,22 +697,77 @@ func Load(path string) (*Config, error) {
 		}
 	}
 
+	if migrate {
+		if _, err := MigrateFile(path, false); err != nil {
+			return nil, err
+		}
+	}
+
 	// Read config file
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("read config file: %w", err)
 	}
 
-	// Parse YAML
+	layers, err := loadLayers(path, data)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := build(layers)
+	if err != nil {
+		return nil, err
+	}
+	cfg.filePath = path
+	cfg.layers = layers
+	if cfg.saved, err = cfg.snapshot(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// loadLayers reads the default, file and environment layers for the config
+// file at path holding data
+func loadLayers(path string, data []byte) (map[Source]*layer, error) {
+	if len(data) > maxConfigSize {
+		return nil, fmt.Errorf("parse config: file exceeds %d bytes", maxConfigSize)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+	file := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	if doc.Kind == yaml.DocumentNode && doc.Content[0].Kind == yaml.MappingNode {
+		file = doc.Content[0]
+	}
+
+	defaults, err := defaultLayer()
+	if err != nil {
+		return nil, err
+	}
+	return map[Source]*layer{
+		SourceDefault: defaults,
+		SourceFile:    {root: file, details: map[string]string{"": path}},
+		SourceEnv:     envLayer(),
+	}, nil
+}
+
+// maxConfigSize bounds the config files Parse accepts; real configs are a
+// few kilobytes
+const maxConfigSize = 1 << 20
+
+// Parse decodes and validates YAML configuration data
+func Parse(data []byte) (*Config, error) {
+	if len(data) > maxConfigSize {
+		return nil, fmt.Errorf("parse config: file exceeds %d bytes", maxConfigSize)
+	}
+
 	var cfg Config
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
 		return nil, fmt.Errorf("parse config: %w", err)
 	}
 
-	cfg.filePath = path
-
-	// Validate configuration
-	if err := cfg.Validate(); err != nil {
+	if err := validateConfig(&cfg); err != nil {
 		return nil, fmt.Errorf("validate config: %w", err)
 	}
 
@@ -125,6 +781,10 @@ func validateConfig(c *Config) error {
 		return fmt.Errorf("version is required")
 	}
 
+	if c.ConfigVersion > CurrentConfigVersion {
+		return fmt.Errorf("config_version %d is newer than this release supports (%d)", c.ConfigVersion, CurrentConfigVersion)
+	}
+
 	// Validate log level
 	validLogLevels := map[string]bool{
 		"debug": true, "info": true, "warn": true, "error": true,
@@ -132,6 +792,38 @@ func validateConfig(c *Config) error {
 	if !validLogLevels[c.Settings.LogLevel] {
 		return fmt.Errorf("invalid log level: %s", c.Settings.LogLevel)
 	}
+	if f := c.Settings.LogFormat; f != "" && f != "text" && f != "json" {
+		return fmt.Errorf("invalid log format: %s", f)
+	}
+	if c.Settings.LogMaxSize < 0 {
+		return fmt.Errorf("invalid log max size: %d", c.Settings.LogMaxSize)
+	}
+	if c.Settings.LogMaxFiles < -1 {
+		return fmt.Errorf("invalid log max files: %d", c.Settings.LogMaxFiles)
+	}
+
+	if err := c.Settings.UnitOptions().Validate(); err != nil {
+		return fmt.Errorf("invalid settings: %w", err)
+	}
+	if c.Settings.ShutdownTimeout < 0 {
+		return fmt.Errorf("invalid shutdown timeout: %d", c.Settings.ShutdownTimeout)
+	}
+	if c.Settings.MaxConnections < 0 {
+		return fmt.Errorf("invalid max connections: %d", c.Settings.MaxConnections)
+	}
+	if c.Settings.MaxInstancesPerProvider < 0 {
+		return fmt.Errorf("invalid max instances per provider: %d", c.Settings.MaxInstancesPerProvider)
+	}
+	switch c.Settings.ConnectionMode {
+	case "", "failover", "load-balance":
+	default:
+		return fmt.Errorf("invalid connection mode: %s", c.Settings.ConnectionMode)
+	}
+	switch c.Settings.Balance {
+	case "", "round-robin", "latency":
+	default:
+		return fmt.Errorf("invalid balance strategy: %s", c.Settings.Balance)
+	}
 
 	// Validate default method exists
 	if c.Settings.DefaultMethod != "" {
@@ -153,6 +845,40 @@ func validateConfig(c *Config) error {
 		return fmt.Errorf("invalid SSH port: %d", c.SSH.Port)
 	}
 
+	if c.SSH.SessionLog.RetentionDays < 0 {
+		return fmt.Errorf("invalid ssh session log retention: %d days", c.SSH.SessionLog.RetentionDays)
+	}
+
+	for user, dir := range c.SSH.SFTP.Chroot {
+		if !filepath.IsAbs(dir) {
+			return fmt.Errorf("invalid sftp chroot for %s: %s must be an absolute path", user, dir)
+		}
+	}
+	if c.SSH.CA.Validity < 0 {
+		return fmt.Errorf("invalid ssh ca validity: %d hours", c.SSH.CA.Validity)
+	}
+	if p := c.SSH.CA.TrustedKeys; p != "" && !filepath.IsAbs(p) {
+		return fmt.Errorf("invalid ssh ca trusted_keys: %s must be an absolute path", p)
+	}
+
+	// Validate key store backend
+	switch c.SSH.KeyStore {
+	case "", "file":
+	case "sqlite":
+		if c.SSH.KeyDatabase == "" {
+			return fmt.Errorf("ssh key_database is required for the sqlite key store")
+		}
+	case "ldap":
+		if c.SSH.LDAP.URL == "" || c.SSH.LDAP.BaseDN == "" {
+			return fmt.Errorf("ssh ldap url and base_dn are required for the ldap key store")
+		}
+		if c.SSH.LDAP.Filter != "" && !strings.HasPrefix(c.SSH.LDAP.Filter, "(") {
+			return fmt.Errorf("invalid ssh ldap filter: %s", c.SSH.LDAP.Filter)
+		}
+	default:
+		return fmt.Errorf("invalid ssh key store: %s", c.SSH.KeyStore)
+	}
+
 	// Validate monitoring metrics port if enabled
 	if c.Monitoring.MetricsEnabled {
 		if c.Monitoring.MetricsPort < 1 || c.Monitoring.MetricsPort > 65535 {
@@ -160,9 +886,358 @@ func validateConfig(c *Config) error {
 		}
 	}
 
+	// Validate provider resource limits
+	for name, limit := range c.Monitoring.ProviderLimits {
+		if limit.MaxCPUPercent < 0 {
+			return fmt.Errorf("invalid max_cpu_percent for provider %s: %v", name, limit.MaxCPUPercent)
+		}
+	}
+
+	// Validate provider bandwidth limits
+	for name, b := range c.Providers {
+		if b.MaxKbps < 0 || b.MaxUploadKbps < 0 || b.MaxDownloadKbps < 0 {
+			return fmt.Errorf("invalid bandwidth limit for provider %s: limits must not be negative", name)
+		}
+	}
+
+	// Validate provider sandboxes
+	for name, sb := range c.Sandbox {
+		for _, path := range append(append([]string{}, sb.Writable...), sb.Hide...) {
+			if !filepath.IsAbs(path) && !strings.HasPrefix(path, "~/") {
+				return fmt.Errorf("invalid sandbox path for provider %s: %s is not absolute", name, path)
+			}
+		}
+		if len(sb.Writable) > 0 && !sb.ReadOnly {
+			return fmt.Errorf("sandbox writable paths for provider %s require read_only", name)
+		}
+	}
+
+	// Validate wake-on-demand listeners
+	for i, w := range c.Wake {
+		if w.Provider == "" {
+			return fmt.Errorf("wake listener %d: provider is required", i+1)
+		}
+		for _, addr := range []string{w.Listen, w.Target} {
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				return fmt.Errorf("wake listener %d: invalid address %q", i+1, addr)
+			}
+		}
+		if w.IdleTimeout < 0 || w.StartTimeout < 0 {
+			return fmt.Errorf("wake listener %d: timeouts must not be negative", i+1)
+		}
+	}
+
+	forwards := make(map[string]bool)
+	for i, f := range c.Forwards {
+		switch {
+		case f.Name == "":
+			return fmt.Errorf("forward %d: name is required", i+1)
+		case forwards[f.Name]:
+			return fmt.Errorf("forward %s is defined twice", f.Name)
+		case f.Provider == "":
+			return fmt.Errorf("forward %s: provider is required", f.Name)
+		case f.LocalPort < 1 || f.LocalPort > 65535:
+			return fmt.Errorf("forward %s: invalid local_port %d", f.Name, f.LocalPort)
+		case f.RemotePort < 0 || f.RemotePort > 65535:
+			return fmt.Errorf("forward %s: invalid remote_port %d", f.Name, f.RemotePort)
+		}
+		forwards[f.Name] = true
+	}
+
+	// Validate profiles
+	for name, p := range c.Profiles {
+		if err := validateProfile(name, p, c.Methods); err != nil {
+			return err
+		}
+	}
+
+	// Validate health probes
+	if c.Health.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.Health.Listen); err != nil {
+			return fmt.Errorf("invalid health listen address %q", c.Health.Listen)
+		}
+	}
+	if c.Health.MinTunnels < 0 {
+		return fmt.Errorf("invalid health min_tunnels: %d", c.Health.MinTunnels)
+	}
+
+	if c.Watchdog.Interval < 0 || c.Watchdog.MaxRestarts < 0 {
+		return fmt.Errorf("invalid watchdog: interval and max_restarts must not be negative")
+	}
+
+	if (c.API.TLSCert == "") != (c.API.TLSKey == "") {
+		return fmt.Errorf("invalid api: tls_cert and tls_key must be set together")
+	}
+	if c.API.ProxyAuth.ClientCA != "" && c.API.TLSCert == "" {
+		return fmt.Errorf("invalid api: proxy_auth.client_ca needs tls_cert and tls_key")
+	}
+	if len(c.API.ProxyAuth.ProxyNames) > 0 && c.API.ProxyAuth.ClientCA == "" {
+		return fmt.Errorf("invalid api: proxy_auth.proxy_names needs client_ca")
+	}
+	if c.API.RateLimit.Requests < 0 || c.API.RateLimit.Mutations < 0 || c.API.RateLimit.Enrollments < 0 {
+		return fmt.Errorf("invalid api: rate_limit must not be negative")
+	}
+
+	// Validate verification probes
+	probes := make(map[string]bool)
+	for i, p := range c.Verify.Probes {
+		switch {
+		case p.Name == "":
+			return fmt.Errorf("verify probe %d: name is required", i+1)
+		case probes[p.Name]:
+			return fmt.Errorf("verify probe %s is defined twice", p.Name)
+		case !strings.HasPrefix(p.URL, "https://") && !strings.HasPrefix(p.URL, "http://"):
+			return fmt.Errorf("verify probe %s: url must be http or https, not %q", p.Name, p.URL)
+		}
+		probes[p.Name] = true
+	}
+	if c.Verify.Timeout < 0 {
+		return fmt.Errorf("invalid verify timeout: %d", c.Verify.Timeout)
+	}
+
+	if c.Failover.MaxLatency < 0 {
+		return fmt.Errorf("invalid failover max_latency: %d", c.Failover.MaxLatency)
+	}
+	if c.Failover.LatencyChecks < -1 {
+		return fmt.Errorf("invalid failover latency_checks: %d", c.Failover.LatencyChecks)
+	}
+
+	// Validate key rotation
+	identities := make(map[string]bool)
+	for i, id := range c.Rotation.Identities {
+		switch {
+		case id.Name == "":
+			return fmt.Errorf("rotation identity %d: name is required", i+1)
+		case identities[id.Name]:
+			return fmt.Errorf("rotation identity %s is defined twice", id.Name)
+		case id.User == "":
+			return fmt.Errorf("rotation identity %s: user is required", id.Name)
+		case id.KeyPath == "":
+			return fmt.Errorf("rotation identity %s: key_path is required", id.Name)
+		case id.Every < 0:
+			return fmt.Errorf("rotation identity %s: invalid every: %d", id.Name, id.Every)
+		case id.Overlap < 0:
+			return fmt.Errorf("rotation identity %s: invalid overlap: %d", id.Name, id.Overlap)
+		}
+		if id.Overlap >= id.Days() {
+			return fmt.Errorf("rotation identity %s: overlap must be shorter than every", id.Name)
+		}
+		identities[id.Name] = true
+	}
+
+	if c.TwoPerson.Window < 0 {
+		return fmt.Errorf("invalid two_person window: %d", c.TwoPerson.Window)
+	}
+
+	// Unknown widget names are reported by the TUI, which defines them
+	widgets := make(map[string]bool)
+	for _, w := range c.Dashboard.Widgets {
+		if widgets[w] {
+			return fmt.Errorf("dashboard widget %s is listed twice", w)
+		}
+		widgets[w] = true
+	}
+	switch c.Dashboard.GraphStyle {
+	case "", "braille", "block":
+	default:
+		return fmt.Errorf("invalid dashboard graph style: %s (must be braille or block)", c.Dashboard.GraphStyle)
+	}
+
+	// Validate fleet nodes
+	nodes := make(map[string]bool)
+	for i, n := range c.Fleet.Nodes {
+		switch {
+		case n.Name == "":
+			return fmt.Errorf("fleet node %d: name is required", i+1)
+		case nodes[n.Name]:
+			return fmt.Errorf("fleet node %s is defined twice", n.Name)
+		case !strings.HasPrefix(n.URL, "https://") && !strings.HasPrefix(n.URL, "http://"):
+			return fmt.Errorf("fleet node %s: url must be http or https, not %q", n.Name, n.URL)
+		}
+		nodes[n.Name] = true
+	}
+
+	// Validate key sources
+	sources := make(map[string]bool)
+	for i, s := range c.KeySources {
+		switch {
+		case s.Name == "":
+			return fmt.Errorf("key source %d: name is required", i+1)
+		case sources[s.Name]:
+			return fmt.Errorf("key source %s is defined twice", s.Name)
+		case s.Type != "github" && s.Type != "gitlab" && s.Type != "gitea" && s.Type != "url":
+			return fmt.Errorf("key source %s: invalid type %q (must be github, gitlab, gitea or url)", s.Name, s.Type)
+		case !strings.HasPrefix(s.URL, "https://") && !strings.HasPrefix(s.URL, "http://"):
+			return fmt.Errorf("key source %s: url must be http or https, not %q", s.Name, s.URL)
+		}
+		sources[s.Name] = true
+	}
+
+	// Validate key sync; sources are resolved when syncing, as the
+	// built-in ones aren't known here
+	if c.KeySync.Interval < 0 {
+		return fmt.Errorf("invalid key_sync interval: %d", c.KeySync.Interval)
+	}
+	for i, u := range c.KeySync.Users {
+		if u.User == "" {
+			return fmt.Errorf("key_sync user %d: user is required", i+1)
+		}
+	}
+
+	// Validate provider command templates
+	for name, command := range c.Commands {
+		if err := cmdtemplate.Validate(command); err != nil {
+			return fmt.Errorf("invalid command for provider %s: %w", name, err)
+		}
+	}
+
+	// Validate script providers
+	for name, sc := range c.Scripts {
+		if err := validateScript(name, sc); err != nil {
+			return err
+		}
+	}
+
+	// Validate enrollment only when it is switched on
+	if c.Enrollment.Enabled {
+		if c.Enrollment.BaseURL == "" {
+			return fmt.Errorf("enrollment base_url is required")
+		}
+		if c.Enrollment.SMTP.Host == "" || c.Enrollment.SMTP.From == "" {
+			return fmt.Errorf("enrollment smtp host and from are required")
+		}
+		if c.Enrollment.SMTP.Port < 1 || c.Enrollment.SMTP.Port > 65535 {
+			return fmt.Errorf("invalid enrollment smtp port: %d", c.Enrollment.SMTP.Port)
+		}
+	}
+
+	// Validate notification channels that are switched on
+	if email := c.Notifications.Email; len(email.To) > 0 {
+		if email.Host == "" || email.From == "" {
+			return fmt.Errorf("notifications email host and from are required")
+		}
+		if email.Port < 1 || email.Port > 65535 {
+			return fmt.Errorf("invalid notifications email port: %d", email.Port)
+		}
+	}
+	if u := c.Notifications.Slack.WebhookURL; u != "" && !isHTTPURL(u) {
+		return fmt.Errorf("invalid notifications slack webhook_url: %s", u)
+	}
+	for _, hook := range c.Notifications.Webhooks {
+		if !isHTTPURL(hook.URL) {
+			return fmt.Errorf("invalid notifications webhook url: %q", hook.URL)
+		}
+	}
+
+	// Validate quiet hours; both ends must be set together
+	qh := c.Notifications.QuietHours
+	if (qh.Start == "") != (qh.End == "") {
+		return fmt.Errorf("notifications quiet_hours requires both start and end")
+	}
+	for _, v := range []string{qh.Start, qh.End} {
+		if v == "" {
+			continue
+		}
+		if _, err := time.Parse("15:04", v); err != nil {
+			return fmt.Errorf("invalid notifications quiet_hours time %q: expected HH:MM", v)
+		}
+	}
+
+	// Validate the DNS forwarder address only when it is switched on
+	if c.DNS.Enabled {
+		if _, port, err := net.SplitHostPort(c.DNS.Listen); err != nil {
+			return fmt.Errorf("invalid dns listen address: %s", c.DNS.Listen)
+		} else if c.DNS.ManageResolvConf && port != "53" {
+			return fmt.Errorf("dns listen address must use port 53 when manage_resolv_conf is enabled")
+		}
+	}
+
+	// Validate update checks
+	if c.Updates.Check && c.Updates.Interval < 0 {
+		return fmt.Errorf("invalid updates interval: %d", c.Updates.Interval)
+	}
+	for name, version := range c.Updates.Pin {
+		if version == "" {
+			return fmt.Errorf("updates pin for provider %s requires a version", name)
+		}
+	}
+
+	// Validate alert rules; thresholds are parsed when the rules are loaded
+	validConditions := map[string]bool{"unhealthy": true, "latency": true, "key_age": true}
+	ruleNames := make(map[string]bool)
+	for _, rule := range c.Alerts {
+		if rule.Name == "" {
+			return fmt.Errorf("alert rule name is required")
+		}
+		if ruleNames[rule.Name] {
+			return fmt.Errorf("duplicate alert rule: %s", rule.Name)
+		}
+		ruleNames[rule.Name] = true
+		if !validConditions[rule.Condition] {
+			return fmt.Errorf("invalid condition for alert rule %s: %s", rule.Name, rule.Condition)
+		}
+	}
+
+	// Validate RBAC scopes
+	validScopes := map[string]bool{"none": true, "own": true, "all": true}
+	if d := c.RBAC.Default; d != "" && !validScopes[d] {
+		return fmt.Errorf("invalid rbac default scope: %s", d)
+	}
+	for name, scope := range c.RBAC.Users {
+		if !validScopes[scope] {
+			return fmt.Errorf("invalid rbac scope for user %s: %s", name, scope)
+		}
+	}
+	for name, scope := range c.RBAC.Groups {
+		if !validScopes[scope] {
+			return fmt.Errorf("invalid rbac scope for group %s: %s", name, scope)
+		}
+	}
+
+	return nil
+}
+
+// validateScript checks a script provider's commands and endpoint pattern
+func validateScript(name string, sc ScriptConfig) error {
+	if _, builtin := providers.LookupIdentity(name); builtin {
+		return fmt.Errorf("script %s: the name is taken by a built-in provider", name)
+	}
+	if sc.Connect == "" {
+		return fmt.Errorf("script %s: connect command is required", name)
+	}
+	for field, command := range map[string]string{"connect": sc.Connect, "disconnect": sc.Disconnect, "health": sc.Health} {
+		if command == "" {
+			continue
+		}
+		if err := cmdtemplate.Validate(command); err != nil {
+			return fmt.Errorf("script %s: invalid %s command: %w", name, field, err)
+		}
+	}
+	if sc.Endpoint != "" && sc.EndpointJSON != "" {
+		return fmt.Errorf("script %s: set endpoint or endpoint_json, not both", name)
+	}
+	if sc.Endpoint != "" {
+		if _, err := regexp.Compile(sc.Endpoint); err != nil {
+			return fmt.Errorf("script %s: invalid endpoint pattern: %w", name, err)
+		}
+	}
+	switch providers.Category(sc.Category) {
+	case "", providers.CategoryVPN, providers.CategoryTunnel, providers.CategoryDirect, providers.CategorySSH:
+	default:
+		return fmt.Errorf("script %s: invalid category: %s", name, sc.Category)
+	}
+	if sc.StartTimeout < 0 {
+		return fmt.Errorf("script %s: start_timeout must not be negative", name)
+	}
 	return nil
 }
 
+// isHTTPURL reports whether s is an absolute http or https URL
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
 // Validate validates the configuration
 func (c *Config) Validate() error {
 	c.mu.RLock()
@@ -170,20 +1245,33 @@ func (c *Config) Validate() error {
 	return validateConfig(c)
 }
 
-// Save saves the current configuration to file
+// Path returns the file the configuration was loaded from
+func (c *Config) Path() string {
+	return c.filePath
+}
+
+// Save writes the settings changed since the configuration was loaded into
+// the file, merging them with whatever else is there now. Changes another
+// process saved in the meantime survive unless the same key was changed here.
 func (c *Config) Save() error {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	data, err := yaml.Marshal(c)
+	current, err := c.snapshot()
 	if err != nil {
-		return fmt.Errorf("marshal config: %w", err)
+		return err
 	}
-
-	if err := os.WriteFile(c.filePath, data, 0644); err != nil {
-		return fmt.Errorf("write config file: %w", err)
+	err = Update(c.filePath, func(root *yaml.Node) error {
+		mergeChanges(root, c.saved, current)
+		if file := c.layers[SourceFile]; file != nil {
+			file.root = cloneNode(root)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
-
+	c.saved = current
 	return nil
 }
 
@@ -196,7 +1284,8 @@ func (c *Config) Watch() error {
 
 	c.watcher = watcher
 
-	if err := watcher.Add(c.filePath); err != nil {
+	// Watch the directory: saves replace the file rather than rewrite it
+	if err := watcher.Add(filepath.Dir(c.filePath)); err != nil {
 		return fmt.Errorf("watch config file: %w", err)
 	}
 
@@ -217,7 +1306,10 @@ func (c *Config) watchLoop() {
 				return
 			}
 
-			if event.Op&fsnotify.Write == fsnotify.Write {
+			if filepath.Clean(event.Name) != filepath.Clean(c.filePath) {
+				continue
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
 				// Debounce rapid changes
 				debounce.Reset(100 * time.Millisecond)
 			}
@@ -245,24 +1337,24 @@ func (c *Config) Reload() error {
 		return fmt.Errorf("read config file: %w", err)
 	}
 
-	var newCfg Config
-	if err := yaml.Unmarshal(data, &newCfg); err != nil {
-		return fmt.Errorf("parse config: %w", err)
+	c.mu.Lock()
+	layers, err := loadLayers(c.filePath, data)
+	if err != nil {
+		c.mu.Unlock()
+		return err
 	}
-
-	// Validate without locking (newCfg is a local variable)
-	if err := validateConfig(&newCfg); err != nil {
-		return fmt.Errorf("validate config: %w", err)
+	// Overrides from flags and at runtime outlive reloads of the file
+	for _, source := range []Source{SourceFlag, SourceRuntime} {
+		layers[source] = c.layers[source]
 	}
-
-	c.mu.Lock()
-	// Update config fields individually to preserve mutex
-	c.Version = newCfg.Version
-	c.Settings = newCfg.Settings
-	c.Credentials = newCfg.Credentials
-	c.Methods = newCfg.Methods
-	c.SSH = newCfg.SSH
-	c.Monitoring = newCfg.Monitoring
+	newCfg, err := build(layers)
+	if err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	c.copyFrom(newCfg)
+	c.layers = layers
+	c.saved, _ = newCfg.snapshot()
 	// filePath, watcher, onChange, and mu are preserved automatically
 
 	// Save onChange callbacks before unlock
@@ -278,6 +1370,38 @@ func (c *Config) Reload() error {
 	return nil
 }
 
+// copyFrom replaces the settings of c with those of other, keeping the
+// file, watcher and callbacks of c
+func (c *Config) copyFrom(other *Config) {
+	c.Version = other.Version
+	c.ConfigVersion = other.ConfigVersion
+	c.Settings = other.Settings
+	c.Credentials = other.Credentials
+	c.Methods = other.Methods
+	c.SSH = other.SSH
+	c.Monitoring = other.Monitoring
+	c.Enrollment = other.Enrollment
+	c.Notifications = other.Notifications
+	c.Alerts = other.Alerts
+	c.DNS = other.DNS
+	c.Updates = other.Updates
+	c.Commands = other.Commands
+	c.Scripts = other.Scripts
+	c.RBAC = other.RBAC
+	c.API = other.API
+	c.Forwards = other.Forwards
+	c.Profiles = other.Profiles
+	c.Providers = other.Providers
+	c.Verify = other.Verify
+	c.Failover = other.Failover
+	c.Rotation = other.Rotation
+	c.Fleet = other.Fleet
+	c.TwoPerson = other.TwoPerson
+	c.Dashboard = other.Dashboard
+	c.KeySources = other.KeySources
+	c.KeySync = other.KeySync
+}
+
 // OnChange registers a callback to be called when configuration changes
 func (c *Config) OnChange(callback func(*Config)) {
 	c.mu.Lock()
@@ -307,36 +1431,7 @@ func (c *Config) GetEnabledMethods() []string {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	type methodPriority struct {
-		name     string
-		priority int
-	}
-
-	var methods []methodPriority
-	for name, config := range c.Methods {
-		if config.Enabled {
-			methods = append(methods, methodPriority{
-				name:     name,
-				priority: config.Priority,
-			})
-		}
-	}
-
-	// Sort by priority (higher first)
-	for i := 0; i < len(methods); i++ {
-		for j := i + 1; j < len(methods); j++ {
-			if methods[j].priority > methods[i].priority {
-				methods[i], methods[j] = methods[j], methods[i]
-			}
-		}
-	}
-
-	result := make([]string, len(methods))
-	for i, m := range methods {
-		result[i] = m.name
-	}
-
-	return result
+	return enabledMethods(c.Methods)
 }
 
 // createDefaultConfig creates a default configuration file
@@ -3,8 +3,12 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 func TestLoadConfig(t *testing.T) {
@@ -84,6 +88,352 @@ func TestConfigValidation(t *testing.T) {
 			},
 			expectErr: true,
 		},
+		{
+			name: "enrollment enabled without smtp",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.Enrollment.Enabled = true
+				cfg.Enrollment.BaseURL = "https://tunnel.example.com"
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "quiet hours missing end",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.Notifications.QuietHours.Start = "22:00"
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "invalid quiet hours time",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.Notifications.QuietHours = QuietHoursConfig{Start: "22:00", End: "7am"}
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "notification email without server",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.Notifications.Email.To = []string{"ops@example.com"}
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "notification webhook without scheme",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.Notifications.Webhooks = []WebhookNotificationConfig{{URL: "hooks.example.com/tunnel"}}
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "invalid rbac scope",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.RBAC.Groups = map[string]string{"wheel": "admin"}
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "health probes without a port",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.Health.Listen = "127.0.0.1"
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "forward defined twice",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.Forwards = []ForwardConfig{
+					{Name: "web", Provider: "bore", LocalPort: 3000},
+					{Name: "web", Provider: "bore", LocalPort: 3001},
+				}
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "profile with an unknown method",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.Profiles = map[string]ProfileConfig{"work": {Failover: []string{"ssh-key", "carrier-pigeon"}}}
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "verify probe without a url scheme",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.Verify.Probes = []ProbeConfig{{Name: "node-b", URL: "node-b.example.com/api/probe"}}
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "negative bandwidth limit",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.Providers = map[string]TrafficConfig{"ngrok": {MaxKbps: 512, MaxUploadKbps: -1}}
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "proxy client CA without TLS",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.API.ProxyAuth.ClientCA = "/etc/tunnel/proxy-ca.pem"
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "negative API rate limit",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.API.RateLimit.Mutations = -5
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "negative enrollment rate limit",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.API.RateLimit.Enrollments = -1
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "negative failover latency",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.Failover.MaxLatency = -100
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "unknown log format",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.Settings.LogFormat = "logfmt"
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "unknown connection mode",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.Settings.ConnectionMode = "active-active"
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "unknown balance strategy",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.Settings.ConnectionMode = "load-balance"
+				cfg.Settings.Balance = "random"
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "rotation overlap as long as the period",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.Rotation.Identities = []IdentityRotation{
+					{Name: "deploy", User: "deploy", KeyPath: "~/.ssh/deploy", Every: 30, Overlap: 30},
+				}
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "fleet node without a scheme",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.Fleet.Nodes = []FleetNode{{Name: "edge-1", URL: "edge-1:8080"}}
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "key source of unknown type",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.KeySources = []KeySourceConfig{{Name: "work", Type: "bitbucket", URL: "https://bitbucket.org"}}
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "key sync user without a name",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.KeySync.Users = []KeySyncUser{{Source: "gitlab", Account: "alice"}}
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "negative two-person window",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.TwoPerson = TwoPersonConfig{Enabled: true, Window: -5}
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "dashboard widget listed twice",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.Dashboard.Widgets = []string{"alerts", "connections", "alerts"}
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "unknown dashboard graph style",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.Dashboard.GraphStyle = "sparkline"
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "negative watchdog interval",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.Watchdog.Interval = -1
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "unknown key store",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.SSH.KeyStore = "postgres"
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "sqlite key store without database",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.SSH.KeyStore = "sqlite"
+				cfg.SSH.KeyDatabase = ""
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "command template with unknown placeholder",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.Commands = map[string]string{"bore": "bore local {{.LocalPort}}"}
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "valid command template",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.Commands = map[string]string{"bore": "{{.Binary}} {{quote .Args}} --secret-file {{.TokenPath}}"}
+				return cfg
+			}(),
+			expectErr: false,
+		},
+		{
+			name: "unknown unit style",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.Settings.Units = "binary"
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "negative certificate validity",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.SSH.CA.Validity = -1
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "unknown time zone",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.Settings.TimeFormat = "relative"
+				cfg.Settings.Timezone = "Mars/Olympus_Mons"
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "script named after a built-in provider",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.Scripts = map[string]ScriptConfig{"bore": {Connect: "bore local 22"}}
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "script with invalid endpoint pattern",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.Scripts = map[string]ScriptConfig{"frp": {Connect: "frpc -c frpc.toml", Endpoint: "remote (\\S+"}}
+				return cfg
+			}(),
+			expectErr: true,
+		},
+		{
+			name: "valid script",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.Scripts = map[string]ScriptConfig{"frp": {
+					Connect:  "frpc tcp --local-port {{.Port}}",
+					Health:   "frpc status",
+					Endpoint: `start proxy success.*remote (\S+)`,
+				}}
+				return cfg
+			}(),
+			expectErr: false,
+		},
+		{
+			name: "config version from a newer release",
+			config: func() *Config {
+				cfg := GetDefaultConfig()
+				cfg.ConfigVersion = CurrentConfigVersion + 1
+				return cfg
+			}(),
+			expectErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -120,6 +470,146 @@ func TestConfigSaveLoad(t *testing.T) {
 	}
 }
 
+func TestSetValue(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	defaults, err := yaml.Marshal(GetDefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	initial := "# tunnel settings\n" + strings.Replace(string(defaults), "log_level: info", "log_level: info # chatty", 1) +
+		"providers:\n    bore:\n        server: bore.pub\n"
+	if err := os.WriteFile(configPath, []byte(initial), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetValue(configPath, "settings.auto_reconnect", "true"); err != nil {
+		t.Fatalf("SetValue failed: %v", err)
+	}
+	if err := SetValue(configPath, "dns.listen", "127.0.0.1:5353"); err != nil {
+		t.Fatalf("SetValue failed: %v", err)
+	}
+	if err := SetValue(configPath, "settings.log_level.level", "debug"); err == nil {
+		t.Error("SetValue should refuse to replace a setting with a section")
+	}
+
+	data, _ := os.ReadFile(configPath)
+	for _, want := range []string{"# tunnel settings", "# chatty", "server: bore.pub", "auto_reconnect: true", "listen: 127.0.0.1:5353"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("config missing %q:\n%s", want, data)
+		}
+	}
+
+	cfg, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if !cfg.Settings.AutoReconnect {
+		t.Error("auto_reconnect was not stored as a boolean")
+	}
+}
+
+func TestSaveMergesConcurrentChanges(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	first, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	// Another process changes a different setting and adds an unknown key
+	if err := SetValue(configPath, "settings.theme", "dark"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetValue(configPath, "providers.bore.server", "bore.example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	first.Settings.LogLevel = "debug"
+	first.Updates.Pin = map[string]string{"bore": "0.5.0"}
+	if err := first.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	loaded, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if loaded.Settings.LogLevel != "debug" || loaded.Updates.Pin["bore"] != "0.5.0" {
+		t.Errorf("Save lost its own changes: %+v %+v", loaded.Settings, loaded.Updates)
+	}
+	if loaded.Settings.Theme != "dark" {
+		t.Errorf("Save overwrote theme set by another writer: %q", loaded.Settings.Theme)
+	}
+	data, _ := os.ReadFile(configPath)
+	if !strings.Contains(string(data), "bore.example.com") {
+		t.Error("Save dropped a key it does not know")
+	}
+
+	// Removing a map entry removes it from the file
+	delete(loaded.Updates.Pin, "bore")
+	if err := loaded.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if again, _ := Load(configPath); again.Updates.Pin["bore"] != "" {
+		t.Error("unpinned entry is still in the file")
+	}
+}
+
+func TestExplain(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	if _, err := Load(configPath); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if err := SetValue(configPath, "settings.log_level", "debug"); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(EnvVar("ssh.port"), "2200")
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.SSH.Port != 2200 {
+		t.Errorf("ssh port = %d, want the environment's 2200", cfg.SSH.Port)
+	}
+
+	origin, err := cfg.Explain("ssh.port")
+	if err != nil {
+		t.Fatalf("Explain failed: %v", err)
+	}
+	if origin.Source != SourceEnv || origin.Detail != "TUNNEL_SSH_PORT" || len(origin.Shadowed) != 2 {
+		t.Errorf("Explain(ssh.port) = %+v, want env over file and default", origin)
+	}
+
+	origin, _ = cfg.Explain("settings.log_level")
+	if origin.Source != SourceFile || origin.Detail != configPath || origin.Value != "debug" {
+		t.Errorf("Explain(settings.log_level) = %+v, want debug from the file", origin)
+	}
+
+	if err := cfg.Override("settings.offline", "true", SourceFlag, "--offline"); err != nil {
+		t.Fatalf("Override failed: %v", err)
+	}
+	if !cfg.Settings.Offline {
+		t.Error("Override did not change the effective value")
+	}
+	if origin, _ := cfg.Explain("settings.offline"); origin.Source != SourceFlag {
+		t.Errorf("Explain(settings.offline) = %+v, want the flag", origin)
+	}
+
+	// Overrides stay out of the file
+	cfg.Settings.Theme = "dark"
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	data, _ := os.ReadFile(configPath)
+	if strings.Contains(string(data), "offline: true") || strings.Contains(string(data), "port: 2200") {
+		t.Errorf("Save wrote overrides to the file:\n%s", data)
+	}
+
+	if _, err := cfg.Explain("settings.nope"); err == nil {
+		t.Error("Explain should reject unknown keys")
+	}
+}
+
 func TestGetEnabledMethods(t *testing.T) {
 	cfg := GetDefaultConfig()
 
@@ -248,3 +738,142 @@ func TestMigrateConfig(t *testing.T) {
 		t.Errorf("Expected SSH port 2222, got %d", cfg.SSH.Port)
 	}
 }
+
+func TestLoadMigratesConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	legacy := "# header\nversion: \"1.0.0\"\nsettings:\n  log_level: debug # verbose\ncredentials:\n  store: file\nssh:\n  port: 2222\n"
+	if err := os.WriteFile(path, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.ConfigVersion != CurrentConfigVersion {
+		t.Errorf("ConfigVersion = %d, want %d", cfg.ConfigVersion, CurrentConfigVersion)
+	}
+
+	backup, err := os.ReadFile(path + ".v0.bak")
+	if err != nil || string(backup) != legacy {
+		t.Errorf("expected original kept in .v0.bak, got %q (%v)", backup, err)
+	}
+	migrated, _ := os.ReadFile(path)
+	if !strings.HasPrefix(string(migrated), "# header\n") || !strings.Contains(string(migrated), "# verbose") {
+		t.Errorf("migration dropped comments:\n%s", migrated)
+	}
+
+	// Already current: nothing to do
+	result, err := MigrateFile(path, false)
+	if err != nil || result.Changed() {
+		t.Errorf("MigrateFile() on a current config = %+v, %v", result, err)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	keys := Keys()
+	for _, want := range []string{"ssh.port", "ssh.ldap.base_dn", "settings.log_level", "methods", "alerts"} {
+		if !slices.Contains(keys, want) {
+			t.Errorf("Keys() is missing %s", want)
+		}
+	}
+
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"ssh.port", true},
+		{"ssh", true},
+		{"SSH.Port", true},
+		{"methods.ssh-key.enabled", true},
+		{"ssh.prot", false},
+		{"ssh.port.extra", false},
+		{"nonexistent", false},
+	}
+	for _, tt := range tests {
+		if got := IsKey(tt.key); got != tt.want {
+			t.Errorf("IsKey(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestApplyProfile(t *testing.T) {
+	cfg := GetDefaultConfig()
+	cfg.Profiles = map[string]ProfileConfig{
+		"demo": {
+			DefaultMethod: "tailscale",
+			Failover:      []string{"tailscale", "wireguard"},
+			Settings:      map[string]map[string]string{"wireguard": {"endpoint": "vpn.example.com:51820"}},
+			Keys:          &ProfileKeyPolicy{AllowedUsers: []string{"guest"}},
+		},
+		"broken": {DefaultMethod: "password", Failover: []string{"ssh-key"}},
+	}
+	home := cfg.CurrentProfile()
+
+	if err := cfg.ApplyProfile("broken"); err == nil {
+		t.Error("applied a profile that disables its own default method")
+	}
+	delete(cfg.Profiles, "broken")
+	if err := cfg.ApplyProfile("demo"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(cfg.GetEnabledMethods(), ","); got != "tailscale,wireguard" {
+		t.Errorf("enabled methods = %s, want tailscale,wireguard", got)
+	}
+	wg := cfg.Methods["wireguard"].Settings
+	if wg["endpoint"] != "vpn.example.com:51820" || wg["allowed_ips"] != "0.0.0.0/0" {
+		t.Errorf("wireguard settings = %v, want the endpoint merged in", wg)
+	}
+	if cfg.Settings.DefaultMethod != "tailscale" || cfg.Settings.Profile != "demo" ||
+		len(cfg.SSH.AllowedUsers) != 1 || cfg.SSH.AllowTCPForwarding {
+		t.Errorf("after demo: default %s, profile %s, ssh %+v", cfg.Settings.DefaultMethod, cfg.Settings.Profile, cfg.SSH)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("config invalid after applying a profile: %v", err)
+	}
+
+	// The setup captured before switching brings everything back
+	cfg.Profiles["home"] = home
+	if err := cfg.ApplyProfile("home"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(cfg.GetEnabledMethods(), ","); got != strings.Join(home.Failover, ",") {
+		t.Errorf("enabled methods = %s, want %v", got, home.Failover)
+	}
+	if cfg.Settings.DefaultMethod != home.DefaultMethod || cfg.SSH.AllowTCPForwarding != home.Keys.AllowTCPForwarding {
+		t.Errorf("after home: default %s, ssh %+v", cfg.Settings.DefaultMethod, cfg.SSH)
+	}
+}
+
+// FuzzParse checks that arbitrary YAML never panics the loader and that any
+// config it accepts survives a save/load round trip
+func FuzzParse(f *testing.F) {
+	defaults, err := yaml.Marshal(GetDefaultConfig())
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(defaults)
+	f.Add([]byte(""))
+	f.Add([]byte("version: 1.0.0\nsettings: [1, 2]\n"))
+	f.Add([]byte("version: &v 1.0.0\nssh:\n  port: *v\n"))
+	f.Add([]byte("methods:\n  ? [a, b]\n  : {enabled: true}\n"))
+	f.Add([]byte("a: &a [*a]\n"))
+	f.Add([]byte("alerts:\n  - name: x\n    condition: latency\n  - name: x\n"))
+	f.Add([]byte("version: 1.0.0\nsettings:\n  shutdown_timeout: 99999999999999999999\n"))
+	f.Add([]byte(strings.Repeat("- ", 1000) + "x\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		cfg, err := Parse(data)
+		if err != nil {
+			return
+		}
+
+		out, err := yaml.Marshal(cfg)
+		if err != nil {
+			t.Fatalf("accepted config does not marshal: %v", err)
+		}
+		if _, err := Parse(out); err != nil {
+			t.Fatalf("accepted config does not reload: %v\n%s", err, out)
+		}
+	})
+}
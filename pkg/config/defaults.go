@@ -11,13 +11,18 @@ func GetDefaultConfig() *Config {
 	configDir := filepath.Join(homeDir, ".config", "tunnel")
 
 	return &Config{
-		Version: "1.0.0",
+		Version:       "1.0.0",
+		ConfigVersion: CurrentConfigVersion,
 
 		Settings: Settings{
-			DefaultMethod: "ssh-key",
-			AutoReconnect: true,
-			LogLevel:      "info",
-			Theme:         "default",
+			DefaultMethod:   "ssh-key",
+			AutoReconnect:   true,
+			LogLevel:        "info",
+			Theme:           "default",
+			ShutdownTimeout: 10,
+
+			MaxConnections:          16,
+			MaxInstancesPerProvider: 4,
 		},
 
 		Credentials: CredentialConfig{
@@ -93,15 +98,34 @@ func GetDefaultConfig() *Config {
 		},
 
 		SSH: SSHConfig{
-			Port:                 2222,
-			HostKeyPath:          filepath.Join(configDir, "ssh_host_key"),
-			AuthorizedKeys:       filepath.Join(homeDir, ".ssh", "authorized_keys"),
+			Port:           2222,
+			HostKeyPath:    filepath.Join(configDir, "ssh_host_key"),
+			AuthorizedKeys: filepath.Join(homeDir, ".ssh", "authorized_keys"),
+			KeyStore:       "file",
+			KeyDatabase:    filepath.Join(configDir, "keys.db"),
+			LDAP: LDAPConfig{
+				Filter:        "(objectClass=ldapPublicKey)",
+				UserAttribute: "uid",
+				KeyAttribute:  "sshPublicKey",
+				SyncInterval:  300, // 5 minutes
+			},
 			AllowedUsers:         []string{},
 			MaxSessions:          10,
 			IdleTimeout:          300, // 5 minutes
 			KeepAlive:            60,  // 1 minute
 			AllowTCPForwarding:   true,
 			AllowAgentForwarding: true,
+			SessionLog: SessionLogConfig{
+				Enabled:       true,
+				RetentionDays: 90,
+			},
+			SFTP: SFTPConfig{
+				Enabled: true,
+			},
+			TOTP: TOTPConfig{
+				Issuer: "TUNNEL",
+				Store:  filepath.Join(configDir, "totp.json"),
+			},
 		},
 
 		Monitoring: MonitoringConfig{
@@ -112,6 +136,42 @@ func GetDefaultConfig() *Config {
 			MetricsEnabled: false,
 			MetricsPort:    9090,
 		},
+
+		Enrollment: EnrollmentConfig{
+			Enabled:        false,
+			BaseURL:        "",
+			AllowedDomains: []string{},
+			TokenTTL:       900, // 15 minutes
+			SMTP: SMTPConfig{
+				Port: 587,
+			},
+		},
+
+		Notifications: NotificationConfig{
+			Desktop: true,
+			Events: map[string]bool{
+				"drop":     true,
+				"failover": true,
+				"recovery": true,
+				"alert":    true,
+				"update":   true,
+				"failure":  true,
+				"key":      true,
+			},
+		},
+
+		DNS: DNSConfig{
+			Enabled:          false,
+			Listen:           "127.0.0.1:53",
+			ManageResolvConf: true,
+			ResolvConf:       "/etc/resolv.conf",
+		},
+
+		Updates: UpdatesConfig{
+			Check:    true,
+			Interval: 86400, // daily
+			Pin:      map[string]string{},
+		},
 	}
 }
 
@@ -132,6 +192,10 @@ func MigrateConfig(cfg *Config, fromVersion, toVersion string) error {
 		cfg.Settings.Theme = "default"
 	}
 
+	if cfg.Settings.ShutdownTimeout == 0 {
+		cfg.Settings.ShutdownTimeout = 10
+	}
+
 	if cfg.SSH.Port == 0 {
 		cfg.SSH.Port = 2222
 	}
@@ -148,6 +212,10 @@ func MigrateConfig(cfg *Config, fromVersion, toVersion string) error {
 		cfg.SSH.KeepAlive = 60
 	}
 
+	if cfg.SSH.KeyStore == "" {
+		cfg.SSH.KeyStore = "file"
+	}
+
 	if cfg.Credentials.Store == "" {
 		cfg.Credentials.Store = "keyring"
 	}
@@ -156,8 +224,49 @@ func MigrateConfig(cfg *Config, fromVersion, toVersion string) error {
 		cfg.Monitoring.MetricsPort = 9090
 	}
 
-	// Ensure all default methods are present
+	if cfg.Enrollment.TokenTTL == 0 {
+		cfg.Enrollment.TokenTTL = 900
+	}
+
+	if cfg.Enrollment.SMTP.Port == 0 {
+		cfg.Enrollment.SMTP.Port = 587
+	}
+
+	if cfg.DNS.Listen == "" {
+		cfg.DNS.Listen = "127.0.0.1:53"
+	}
+
+	if cfg.DNS.ResolvConf == "" {
+		cfg.DNS.ResolvConf = "/etc/resolv.conf"
+	}
+
+	if cfg.Updates.Interval == 0 {
+		cfg.Updates.Interval = 86400
+	}
+
 	defaults := GetDefaultConfig()
+
+	if cfg.SSH.KeyDatabase == "" {
+		cfg.SSH.KeyDatabase = defaults.SSH.KeyDatabase
+	}
+
+	if cfg.SSH.LDAP.Filter == "" {
+		cfg.SSH.LDAP.Filter = defaults.SSH.LDAP.Filter
+	}
+
+	if cfg.SSH.LDAP.UserAttribute == "" {
+		cfg.SSH.LDAP.UserAttribute = defaults.SSH.LDAP.UserAttribute
+	}
+
+	if cfg.SSH.LDAP.KeyAttribute == "" {
+		cfg.SSH.LDAP.KeyAttribute = defaults.SSH.LDAP.KeyAttribute
+	}
+
+	if cfg.SSH.LDAP.SyncInterval == 0 {
+		cfg.SSH.LDAP.SyncInterval = defaults.SSH.LDAP.SyncInterval
+	}
+
+	// Ensure all default methods are present
 	for name, method := range defaults.Methods {
 		if _, ok := cfg.Methods[name]; !ok {
 			if cfg.Methods == nil {
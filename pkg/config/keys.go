@@ -0,0 +1,78 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Keys returns the dotted path of every setting in the config file, such as
+// "ssh.port". Map-valued sections like methods are listed by their own key,
+// since their entries are user-defined.
+func Keys() []string {
+	var keys []string
+	collectKeys(reflect.TypeOf(Config{}), "", &keys)
+	sort.Strings(keys)
+	return keys
+}
+
+func collectKeys(t reflect.Type, prefix string, keys *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := yamlName(field)
+		if name == "" {
+			continue
+		}
+		key := prefix + name
+
+		if field.Type.Kind() == reflect.Struct && field.Type.PkgPath() == t.PkgPath() {
+			collectKeys(field.Type, key+".", keys)
+			continue
+		}
+		*keys = append(*keys, key)
+	}
+}
+
+// IsKey reports whether key names a setting, a section, or an entry under a
+// map-valued section (e.g. methods.ssh-key.enabled)
+func IsKey(key string) bool {
+	t := reflect.TypeOf(Config{})
+	parts := strings.Split(strings.ToLower(key), ".")
+	for i, part := range parts {
+		field, ok := fieldByYAMLName(t, part)
+		if !ok {
+			return false
+		}
+		switch field.Type.Kind() {
+		case reflect.Struct:
+			t = field.Type
+		case reflect.Map:
+			return true
+		default:
+			return i == len(parts)-1
+		}
+	}
+	return true
+}
+
+func fieldByYAMLName(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		if yamlName(t.Field(i)) == name {
+			return t.Field(i), true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// yamlName returns the key a field is stored under, or "" for fields that
+// are not part of the file
+func yamlName(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "-" || name == "" {
+		return ""
+	}
+	return name
+}
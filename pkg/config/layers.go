@@ -0,0 +1,224 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jedarden/tunnel/internal/migrate"
+	"gopkg.in/yaml.v3"
+)
+
+// Source is a configuration layer. Each layer overrides the ones before it
+// in Sources: built-in defaults, the config file, TUNNEL_* environment
+// variables, command-line flags, and changes made while running.
+type Source string
+
+const (
+	SourceDefault Source = "default"
+	SourceFile    Source = "file"
+	SourceEnv     Source = "env"
+	SourceFlag    Source = "flag"
+	SourceRuntime Source = "runtime"
+)
+
+// Sources lists the layers from lowest to highest precedence
+var Sources = []Source{SourceDefault, SourceFile, SourceEnv, SourceFlag, SourceRuntime}
+
+// layer holds the settings one source provides
+type layer struct {
+	root    *yaml.Node
+	details map[string]string // key to file path, variable or flag name
+}
+
+// detail describes where key, or the section holding it, was set
+func (l *layer) detail(key string) string {
+	for k := key; ; {
+		if d, ok := l.details[k]; ok {
+			return d
+		}
+		i := strings.LastIndex(k, ".")
+		if i < 0 {
+			return l.details[""] // set for the whole layer
+		}
+		k = k[:i]
+	}
+}
+
+// Origin is where the effective value of a key came from
+type Origin struct {
+	Key      string      `json:"key" yaml:"key"`
+	Value    interface{} `json:"value" yaml:"value"`
+	Source   Source      `json:"source" yaml:"source"`
+	Detail   string      `json:"detail,omitempty" yaml:"detail,omitempty"`     // file path, variable or flag name
+	Shadowed []Origin    `json:"shadowed,omitempty" yaml:"shadowed,omitempty"` // lower layers that also set the key
+}
+
+// EnvVar returns the environment variable that overrides key, e.g.
+// TUNNEL_SETTINGS_LOG_LEVEL for settings.log_level
+func EnvVar(key string) string {
+	return "TUNNEL_" + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+}
+
+// envLayer collects the settings overridden by environment variables
+func envLayer() *layer {
+	l := &layer{root: &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}, details: map[string]string{}}
+	for _, key := range Keys() {
+		value, ok := os.LookupEnv(EnvVar(key))
+		if !ok {
+			continue
+		}
+		if err := setPath(l.root, key, parseValue(value)); err == nil {
+			l.details[key] = EnvVar(key)
+		}
+	}
+	return l
+}
+
+// defaultLayer holds the built-in defaults
+func defaultLayer() (*layer, error) {
+	root, err := GetDefaultConfig().snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &layer{root: root}, nil
+}
+
+// build merges the layers in precedence order and decodes the result
+func build(layers map[Source]*layer) (*Config, error) {
+	merged := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, source := range Sources {
+		if l := layers[source]; l != nil {
+			overlay(merged, l.root)
+		}
+	}
+
+	data, err := yaml.Marshal(merged)
+	if err != nil {
+		return nil, fmt.Errorf("marshal config: %w", err)
+	}
+	return Parse(data)
+}
+
+// overlay copies the keys of src into dst, merging nested sections
+func overlay(dst, src *yaml.Node) {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i].Value, src.Content[i+1]
+		if target := migrate.MapValue(dst, key); target != nil && target.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode {
+			overlay(target, value)
+			continue
+		}
+		migrate.SetMapValue(dst, key, cloneNode(value))
+	}
+}
+
+// cloneNode deep-copies n
+func cloneNode(n *yaml.Node) *yaml.Node {
+	c := *n
+	c.Content = make([]*yaml.Node, len(n.Content))
+	for i, child := range n.Content {
+		c.Content[i] = cloneNode(child)
+	}
+	return &c
+}
+
+// Override sets key for this process only, as a layer above the config
+// file; Save does not write it. value is read as YAML. detail names where
+// the value came from, such as the flag.
+func (c *Config) Override(key, value string, source Source, detail string) error {
+	if source == SourceDefault || source == SourceFile {
+		return fmt.Errorf("override %s: %s is not an override layer", key, source)
+	}
+	key = strings.ToLower(key)
+	if !IsKey(key) {
+		return fmt.Errorf("override %s: unknown config key", key)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.ensureLayers(); err != nil {
+		return err
+	}
+	l := c.layers[source]
+	if l == nil {
+		l = &layer{root: &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}, details: map[string]string{}}
+		c.layers[source] = l
+	}
+	node := parseValue(value)
+	if err := setPath(l.root, key, node); err != nil {
+		return err
+	}
+	l.details[key] = detail
+
+	newCfg, err := build(c.layers)
+	if err != nil {
+		return fmt.Errorf("override %s: %w", key, err)
+	}
+	c.copyFrom(newCfg)
+
+	// Keep the override out of what Save considers changed
+	if c.saved != nil {
+		current, err := c.snapshot()
+		if err != nil {
+			return err
+		}
+		if v := lookupPath(current, key); v != nil {
+			return setPath(c.saved, key, v)
+		}
+	}
+	return nil
+}
+
+// ensureLayers gives configs that were not loaded from a file, such as
+// GetDefaultConfig, a default layer holding their current values
+func (c *Config) ensureLayers() error {
+	if c.layers != nil {
+		return nil
+	}
+	root, err := c.snapshot()
+	if err != nil {
+		return err
+	}
+	c.layers = map[Source]*layer{SourceDefault: {root: root}}
+	return nil
+}
+
+// Explain reports where the effective value of key came from and which
+// lower layers it overrides
+func (c *Config) Explain(key string) (Origin, error) {
+	key = strings.ToLower(key)
+	if !IsKey(key) {
+		return Origin{}, fmt.Errorf("unknown config key: %s", key)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.ensureLayers(); err != nil {
+		return Origin{}, err
+	}
+
+	var found []Origin
+	for i := len(Sources) - 1; i >= 0; i-- {
+		l := c.layers[Sources[i]]
+		if l == nil {
+			continue
+		}
+		node := lookupPath(l.root, key)
+		if node == nil {
+			continue
+		}
+		var value interface{}
+		if err := node.Decode(&value); err != nil {
+			return Origin{}, fmt.Errorf("decode %s: %w", key, err)
+		}
+		found = append(found, Origin{Key: key, Value: value, Source: Sources[i], Detail: l.detail(key)})
+	}
+	if len(found) == 0 {
+		return Origin{}, fmt.Errorf("config key %s is not set", key)
+	}
+
+	origin := found[0]
+	origin.Shadowed = found[1:]
+	return origin, nil
+}
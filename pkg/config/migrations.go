@@ -0,0 +1,39 @@
+package config
+
+import (
+	"github.com/jedarden/tunnel/internal/migrate"
+	"gopkg.in/yaml.v3"
+)
+
+// CurrentConfigVersion is the config_version written by this release
+const CurrentConfigVersion = 1
+
+// Migrations upgrades config files written by older releases. Append a step
+// whenever a change would make an older file load differently: renamed or
+// removed keys, changed units, or new required settings.
+var Migrations = &migrate.Migrator{
+	Name:       "config",
+	VersionKey: "config_version",
+	Format:     migrate.YAML,
+	Steps: []migrate.Step{
+		{
+			Version:     1,
+			Description: "Record config_version in configs that predate it",
+			Apply:       func(root *yaml.Node) error { return nil },
+		},
+	},
+}
+
+// DefaultPath returns the config file used when no path is given
+func DefaultPath() string {
+	return defaultConfigPath
+}
+
+// MigrateFile upgrades the config file at path to CurrentConfigVersion,
+// keeping a copy of the original. With dryRun nothing is written.
+func MigrateFile(path string, dryRun bool) (*migrate.Result, error) {
+	if path == "" {
+		path = defaultConfigPath
+	}
+	return Migrations.MigrateFile(path, dryRun)
+}
@@ -0,0 +1,191 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jedarden/tunnel/internal/migrate"
+	"gopkg.in/yaml.v3"
+)
+
+// Update applies fn to the top-level mapping of the config file at path and
+// writes the result back. The file is locked for the whole read-modify-write
+// cycle, so the CLI, the TUI and a running instance can write concurrently.
+// Keys fn leaves alone are kept as they are on disk, including keys this
+// release does not know about, and so are comments.
+func Update(path string, fn func(root *yaml.Node) error) error {
+	if path == "" {
+		path = defaultConfigPath
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create config directory: %w", err)
+	}
+
+	lock, err := lockFile(path + ".lock")
+	if err != nil {
+		return fmt.Errorf("lock config: %w", err)
+	}
+	defer unlockFile(lock)
+
+	mode := os.FileMode(0644)
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if info, err := os.Stat(path); err == nil {
+			mode = info.Mode().Perm()
+		}
+	case !os.IsNotExist(err):
+		return fmt.Errorf("read config file: %w", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("parse config: %w", err)
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("parse config: top level is not a mapping")
+	}
+
+	if err := fn(root); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return fmt.Errorf("marshal config: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return fmt.Errorf("marshal config: %w", err)
+	}
+	if _, err := Parse(buf.Bytes()); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), mode); err != nil {
+		return fmt.Errorf("write config file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write config file: %w", err)
+	}
+	return nil
+}
+
+// SetValue sets a dotted key such as "settings.log_level" in the config file
+// at path, creating sections as needed. value is read as YAML, so "true" and
+// "30" are stored as a boolean and a number.
+func SetValue(path, key, value string) error {
+	node := parseValue(value)
+	return Update(path, func(root *yaml.Node) error {
+		return setPath(root, key, node)
+	})
+}
+
+// parseValue reads a value given on the command line or in the environment
+// as YAML, falling back to a plain string
+func parseValue(value string) *yaml.Node {
+	var parsed yaml.Node
+	if err := yaml.Unmarshal([]byte(value), &parsed); err != nil || parsed.Kind == 0 {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+	}
+	return parsed.Content[0]
+}
+
+// setPath stores value under a dotted key in mapping root, creating
+// sections as needed
+func setPath(root *yaml.Node, key string, value *yaml.Node) error {
+	parts := strings.Split(key, ".")
+	m := root
+	for i, part := range parts[:len(parts)-1] {
+		next := migrate.MapValue(m, part)
+		if next == nil {
+			next = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			migrate.SetMapValue(m, part, next)
+		} else if next.Kind != yaml.MappingNode {
+			return fmt.Errorf("config key %s is not a section", strings.Join(parts[:i+1], "."))
+		}
+		m = next
+	}
+	migrate.SetMapValue(m, parts[len(parts)-1], value)
+	return nil
+}
+
+// lookupPath returns the value under a dotted key in mapping root
+func lookupPath(root *yaml.Node, key string) *yaml.Node {
+	node := root
+	for _, part := range strings.Split(key, ".") {
+		if node = migrate.MapValue(node, part); node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+// snapshot encodes c as a YAML mapping
+func (c *Config) snapshot() (*yaml.Node, error) {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("marshal config: %w", err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("marshal config: %w", err)
+	}
+	return doc.Content[0], nil
+}
+
+// mergeChanges writes into disk the keys whose value in current differs from
+// base, and removes the keys current dropped. With no base every key of
+// current is written.
+func mergeChanges(disk, base, current *yaml.Node) {
+	for i := 0; i+1 < len(current.Content); i += 2 {
+		key, value := current.Content[i].Value, current.Content[i+1]
+		was := migrate.MapValue(base, key)
+		if was != nil && sameNode(was, value) {
+			continue
+		}
+
+		target := migrate.MapValue(disk, key)
+		if value.Kind == yaml.MappingNode && target != nil && target.Kind == yaml.MappingNode &&
+			(was == nil || was.Kind == yaml.MappingNode) {
+			mergeChanges(target, was, value)
+			if len(target.Content) > 0 {
+				target.Style &^= yaml.FlowStyle // {} grew entries
+			}
+			continue
+		}
+		migrate.SetMapValue(disk, key, value)
+	}
+
+	if base == nil {
+		return
+	}
+	for i := 0; i+1 < len(base.Content); i += 2 {
+		if key := base.Content[i].Value; migrate.MapValue(current, key) == nil {
+			migrate.DeleteKey(disk, key)
+		}
+	}
+}
+
+// sameNode reports whether two nodes hold the same data
+func sameNode(a, b *yaml.Node) bool {
+	if a.Kind != b.Kind || a.Value != b.Value || len(a.Content) != len(b.Content) {
+		return false
+	}
+	for i := range a.Content {
+		if !sameNode(a.Content[i], b.Content[i]) {
+			return false
+		}
+	}
+	return true
+}
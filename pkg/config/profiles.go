@@ -0,0 +1,184 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ProfileConfig is a named setup - which method to use, the failover order,
+// method settings such as ports, and the SSH key policy - applied in one go
+// with tunnel profile apply
+type ProfileConfig struct {
+	Description   string                       `yaml:"description,omitempty"`
+	DefaultMethod string                       `yaml:"default_method,omitempty"`
+	Failover      []string                     `yaml:"failover,omitempty"` // methods enabled, first tried first; the rest are disabled
+	Settings      map[string]map[string]string `yaml:"settings,omitempty"` // merged into methods.<name>.settings
+	Keys          *ProfileKeyPolicy            `yaml:"keys,omitempty"`     // nil leaves the key policy alone
+}
+
+// ProfileKeyPolicy is the part of the SSH settings a profile switches
+type ProfileKeyPolicy struct {
+	AllowedUsers         []string `yaml:"allowed_users,omitempty"`
+	AllowTCPForwarding   bool     `yaml:"allow_tcp_forwarding"`
+	AllowAgentForwarding bool     `yaml:"allow_agent_forwarding"`
+}
+
+// ProfileNames returns the names of the defined profiles, sorted
+func (c *Config) ProfileNames() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CurrentProfile captures the current setup as a profile
+func (c *Config) CurrentProfile() ProfileConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	p := ProfileConfig{
+		DefaultMethod: c.Settings.DefaultMethod,
+		Settings:      make(map[string]map[string]string),
+		Keys: &ProfileKeyPolicy{
+			AllowedUsers:         append([]string(nil), c.SSH.AllowedUsers...),
+			AllowTCPForwarding:   c.SSH.AllowTCPForwarding,
+			AllowAgentForwarding: c.SSH.AllowAgentForwarding,
+		},
+	}
+	for _, name := range enabledMethods(c.Methods) {
+		p.Failover = append(p.Failover, name)
+		if settings := c.Methods[name].Settings; len(settings) > 0 {
+			p.Settings[name] = copySettings(settings)
+		}
+	}
+	if len(p.Settings) == 0 {
+		p.Settings = nil
+	}
+	return p
+}
+
+// ApplyProfile switches to the profile called name: it sets the default
+// method, enables the failover methods in order and disables the others,
+// merges in the method settings and replaces the key policy. The config
+// is left unchanged if the profile doesn't fit it.
+func (c *Config) ApplyProfile(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p, ok := c.Profiles[name]
+	if !ok {
+		return fmt.Errorf("profile not found: %s", name)
+	}
+	if err := validateProfile(name, p, c.Methods); err != nil {
+		return err
+	}
+
+	methods := make(map[string]MethodConfig, len(c.Methods))
+	for method, mc := range c.Methods {
+		mc.Settings = copySettings(mc.Settings)
+		if len(p.Failover) > 0 {
+			mc.Enabled = false
+		}
+		methods[method] = mc
+	}
+	for i, method := range p.Failover {
+		mc := methods[method]
+		mc.Enabled = true
+		mc.Priority = (len(p.Failover) - i) * 10 // highest is tried first
+		methods[method] = mc
+	}
+	for method, settings := range p.Settings {
+		mc := methods[method]
+		if mc.Settings == nil {
+			mc.Settings = make(map[string]string)
+		}
+		for k, v := range settings {
+			mc.Settings[k] = v
+		}
+		methods[method] = mc
+	}
+
+	c.Methods = methods
+	if p.DefaultMethod != "" {
+		c.Settings.DefaultMethod = p.DefaultMethod
+	}
+	if p.Keys != nil {
+		c.SSH.AllowedUsers = append([]string(nil), p.Keys.AllowedUsers...)
+		c.SSH.AllowTCPForwarding = p.Keys.AllowTCPForwarding
+		c.SSH.AllowAgentForwarding = p.Keys.AllowAgentForwarding
+	}
+	c.Settings.Profile = name
+	return nil
+}
+
+// validateProfile checks that a profile only names configured methods
+func validateProfile(name string, p ProfileConfig, methods map[string]MethodConfig) error {
+	if name == "" {
+		return fmt.Errorf("profile name is required")
+	}
+	check := func(method string) error {
+		if _, ok := methods[method]; !ok {
+			return fmt.Errorf("profile %s: method %s not found in methods", name, method)
+		}
+		return nil
+	}
+
+	if p.DefaultMethod != "" {
+		if err := check(p.DefaultMethod); err != nil {
+			return err
+		}
+	}
+	seen := make(map[string]bool)
+	for _, method := range p.Failover {
+		if err := check(method); err != nil {
+			return err
+		}
+		if seen[method] {
+			return fmt.Errorf("profile %s: method %s is listed twice in failover", name, method)
+		}
+		seen[method] = true
+	}
+	if p.DefaultMethod != "" && len(p.Failover) > 0 && !seen[p.DefaultMethod] {
+		return fmt.Errorf("profile %s: default method %s would be disabled; add it to failover", name, p.DefaultMethod)
+	}
+	for method := range p.Settings {
+		if err := check(method); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enabledMethods returns the enabled methods, highest priority first
+func enabledMethods(methods map[string]MethodConfig) []string {
+	names := make([]string, 0, len(methods))
+	for name, mc := range methods {
+		if mc.Enabled {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		pi, pj := methods[names[i]].Priority, methods[names[j]].Priority
+		if pi != pj {
+			return pi > pj
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+func copySettings(settings map[string]string) map[string]string {
+	if settings == nil {
+		return nil
+	}
+	out := make(map[string]string, len(settings))
+	for k, v := range settings {
+		out[k] = v
+	}
+	return out
+}
@@ -3,6 +3,7 @@ package tunnel
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/jedarden/tunnel/internal/core"
@@ -21,8 +22,26 @@ type (
 	EventType         = core.EventType
 	EventPublisher    = core.EventPublisher
 	EventSubscriber   = core.EventSubscriber
+	AggregationWindow = core.AggregationWindow
+	AggregatedMetrics = core.AggregatedMetrics
+	ConnectionStatus  = core.ConnectionStatus
+	ConnectionBudget  = core.ConnectionBudget
+	Operation         = core.Operation
+	OperationError    = core.OperationError
+	LatencyProber     = core.LatencyProber
+	ConnectionMode    = core.ConnectionMode
+	BalanceStrategy   = core.BalanceStrategy
+	SessionCount      = core.SessionCount
 )
 
+// ErrConnectionLimit is returned when starting a connection would exceed
+// the configured limits
+var ErrConnectionLimit = core.ErrConnectionLimit
+
+// ErrNoHealthyConnection is returned by Pick when no connection can take a
+// session
+var ErrNoHealthyConnection = core.ErrNoHealthyConnection
+
 // Re-export provider types
 type (
 	Provider         = providers.Provider
@@ -34,8 +53,9 @@ type (
 
 // Re-export registry types
 type (
-	Registry     = registry.Registry
-	ProviderInfo = registry.ProviderInfo
+	Registry       = registry.Registry
+	ProviderInfo   = registry.ProviderInfo
+	RegistryChange = registry.Change
 )
 
 // Connection states
@@ -57,6 +77,29 @@ const (
 	EventError         = core.EventError
 	EventStateChange   = core.EventStateChange
 	EventPrimaryChange = core.EventPrimaryChange
+	EventNetworkChange = core.EventNetworkChange
+)
+
+// Operation kinds
+const (
+	OpConnect    = core.OpConnect
+	OpDisconnect = core.OpDisconnect
+	OpFailover   = core.OpFailover
+)
+
+// Connection modes and load-balancing strategies
+const (
+	ModeFailover      = core.ModeFailover
+	ModeLoadBalance   = core.ModeLoadBalance
+	BalanceRoundRobin = core.BalanceRoundRobin
+	BalanceLatency    = core.BalanceLatency
+)
+
+// Metrics aggregation windows
+const (
+	Window1m = core.Window1m
+	Window5m = core.Window5m
+	Window1h = core.Window1h
 )
 
 // Provider categories
@@ -73,6 +116,10 @@ type ManagerConfig struct {
 	FailoverConfig  *core.FailoverConfig
 	MetricsInterval time.Duration
 	EventBufferSize int
+	MaxConnections  int             // 0 is unlimited
+	MaxPerProvider  int             // 0 is unlimited
+	Mode            ConnectionMode  // empty is ModeFailover
+	Balance         BalanceStrategy // empty is BalanceRoundRobin
 }
 
 // DefaultManagerConfig returns a manager config with sensible defaults
@@ -104,6 +151,10 @@ func NewManager(config *ManagerConfig) *Manager {
 			FailoverConfig:  config.FailoverConfig,
 			MetricsInterval: config.MetricsInterval,
 			EventBufferSize: config.EventBufferSize,
+			MaxConnections:  config.MaxConnections,
+			MaxPerProvider:  config.MaxPerProvider,
+			Mode:            config.Mode,
+			Balance:         config.Balance,
 		}
 	}
 
@@ -160,3 +211,12 @@ func (a *providerAdapter) Disconnect(conn *core.Connection) error {
 func (a *providerAdapter) IsHealthy(conn *core.Connection) bool {
 	return a.provider.IsHealthy(conn)
 }
+
+// MeasureLatency passes failover's latency checks on to providers that
+// implement LatencyProber
+func (a *providerAdapter) MeasureLatency(ctx context.Context, conn *core.Connection) (time.Duration, error) {
+	if prober, ok := a.provider.(LatencyProber); ok {
+		return prober.MeasureLatency(ctx, conn)
+	}
+	return 0, fmt.Errorf("%s does not measure latency", a.provider.Name())
+}